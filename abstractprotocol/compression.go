@@ -0,0 +1,160 @@
+package abstractprotocol
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+// A proposal carries two serialized policies, the terms and conditions and the producer policy, which grow with the
+// user input and the number of workloads in the policy. When both parties support it, the policies are sent gzip
+// compressed and base64 encoded, and the encoding of the proposal says so. The protocols choose the version from
+// which they compress proposals.
+const PROPOSAL_ENCODING_GZIP = "gzip"
+
+// The largest serialized proposal that is sent, before it is encrypted for the other party. The exchange rejects
+// messages that are much larger without saying why, so a larger proposal is refused here with an error that names
+// the part of the policy that made it so large.
+const MAX_PROPOSAL_SIZE = 256 * 1024
+
+// Returns a copy of the proposal with its policies compressed.
+func (bp *BaseProposal) Compress() (*BaseProposal, error) {
+	if bp.Encoding != "" {
+		return bp, nil
+	}
+
+	compressed := *bp
+	compressed.Encoding = PROPOSAL_ENCODING_GZIP
+	if tc, err := compressString(bp.TsandCs); err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to compress TsAndCs, error: %v", err))
+	} else if pp, err := compressString(bp.Producerpolicy); err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to compress producer policy, error: %v", err))
+	} else {
+		compressed.TsandCs = tc
+		compressed.Producerpolicy = pp
+	}
+	return &compressed, nil
+}
+
+// Restore the policies of a proposal that was received compressed. A proposal that is not compressed is unchanged.
+func (bp *BaseProposal) Decompress() error {
+	switch bp.Encoding {
+	case "":
+		return nil
+	case PROPOSAL_ENCODING_GZIP:
+		if tc, err := decompressString(bp.TsandCs); err != nil {
+			return errors.New(fmt.Sprintf("unable to decompress TsAndCs, error: %v", err))
+		} else if pp, err := decompressString(bp.Producerpolicy); err != nil {
+			return errors.New(fmt.Sprintf("unable to decompress producer policy, error: %v", err))
+		} else {
+			bp.TsandCs = tc
+			bp.Producerpolicy = pp
+			bp.Encoding = ""
+		}
+		return nil
+	default:
+		return errors.New(fmt.Sprintf("proposal encoding %v is not supported", bp.Encoding))
+	}
+}
+
+func compressString(s string) (string, error) {
+	buf := new(bytes.Buffer)
+	zw := gzip.NewWriter(buf)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		return "", err
+	} else if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func decompressString(s string) (string, error) {
+	if compressed, err := base64.StdEncoding.DecodeString(s); err != nil {
+		return "", err
+	} else if zr, err := gzip.NewReader(bytes.NewReader(compressed)); err != nil {
+		return "", err
+	} else if b, err := ioutil.ReadAll(zr); err != nil {
+		return "", err
+	} else {
+		return string(b), nil
+	}
+}
+
+// Returns the proposal as it is sent: compressed when the protocol compresses proposals of its version, and no larger
+// than MAX_PROPOSAL_SIZE.
+func proposalMessage(p ProtocolHandler, proposal Proposal) (Proposal, error) {
+	msg := proposal
+	if bp, ok := proposal.(*BaseProposal); ok && p.CompressesProposals(proposal.Version()) {
+		if compressed, err := bp.Compress(); err != nil {
+			return nil, err
+		} else {
+			msg = compressed
+		}
+	}
+
+	if pay, err := json.Marshal(msg); err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to serialize proposal %v, error: %v", msg, err))
+	} else if len(pay) > MAX_PROPOSAL_SIZE {
+		return nil, oversizedProposalError(proposal, len(pay))
+	}
+	return msg, nil
+}
+
+// Explains which section of which policy made the proposal too large, so that the author of the policy knows what to
+// cut down.
+func oversizedProposalError(proposal Proposal, size int) error {
+	section, sectionSize := largestSection("", json.RawMessage(proposal.TsAndCs()), 3)
+	policyName := "terms and conditions"
+	if ppSection, ppSize := largestSection("", json.RawMessage(proposal.ProducerPolicy()), 3); ppSize > sectionSize {
+		section, sectionSize, policyName = ppSection, ppSize, "producer policy"
+	}
+	return errors.New(fmt.Sprintf("proposal %v is %v bytes, more than the limit of %v bytes, the largest section is %v of the %v with %v bytes", proposal.AgreementId(), size, MAX_PROPOSAL_SIZE, section, policyName, sectionSize))
+}
+
+// Returns the path and size of the largest section of a JSON document, looking at most depth levels down. Objects and
+// arrays are descended into, the largest member is followed at each level.
+func largestSection(name string, raw json.RawMessage, depth int) (string, int) {
+	if depth == 0 {
+		return name, len(raw)
+	}
+
+	children := make(map[string]json.RawMessage)
+	var obj map[string]json.RawMessage
+	var arr []json.RawMessage
+	if err := json.Unmarshal(raw, &obj); err == nil {
+		for key, value := range obj {
+			if name == "" {
+				children[key] = value
+			} else {
+				children[name+"."+key] = value
+			}
+		}
+	} else if err := json.Unmarshal(raw, &arr); err == nil {
+		for i, value := range arr {
+			children[fmt.Sprintf("%v[%v]", name, i)] = value
+		}
+	}
+
+	// Sort the names so that the same section is named for the same policy when two sections are the same size.
+	names := make([]string, 0, len(children))
+	for childName := range children {
+		names = append(names, childName)
+	}
+	sort.Strings(names)
+
+	largest := ""
+	for _, childName := range names {
+		if largest == "" || len(children[childName]) > len(children[largest]) {
+			largest = childName
+		}
+	}
+	if largest == "" {
+		return name, len(raw)
+	}
+	return largestSection(largest, children[largest], depth-1)
+}
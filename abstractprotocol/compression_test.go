@@ -0,0 +1,65 @@
+// +build unit
+
+package abstractprotocol
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// Only CompressesProposals is called when a proposal is prepared for sending.
+type compressingHandler struct {
+	ProtocolHandler
+	compressionVersion int
+}
+
+func (h *compressingHandler) CompressesProposals(version int) bool {
+	return h.compressionVersion != 0 && version >= h.compressionVersion
+}
+
+func Test_ProposalCompression(t *testing.T) {
+
+	tsandcs := `{"header":{"name":"mypolicy","version":"2.0"},"workloads":[{"deployment":"` + strings.Repeat("a", 1000) + `","workloadUrl":"myservice"}]}`
+	prodPol := `{"header":{"name":"mynode","version":"2.0"}}`
+	prop := NewProposal("Basic", 3, tsandcs, prodPol, "ag1", "myorg/agbot1")
+
+	// Proposals are compressed from the protocol's compression version, and are decompressed when they are received.
+	if msg, err := proposalMessage(&compressingHandler{compressionVersion: 4}, prop); err != nil {
+		t.Errorf("unexpected error preparing proposal: %v", err)
+	} else if msg.(*BaseProposal).Encoding != "" || msg.TsAndCs() != tsandcs {
+		t.Errorf("proposal of an earlier protocol version should not be compressed: %v", msg)
+	}
+
+	msg, err := proposalMessage(&compressingHandler{compressionVersion: 3}, prop)
+	if err != nil {
+		t.Errorf("unexpected error preparing proposal: %v", err)
+		return
+	} else if msg.(*BaseProposal).Encoding != PROPOSAL_ENCODING_GZIP || len(msg.TsAndCs()) >= len(tsandcs) {
+		t.Errorf("proposal should be compressed: %v", msg)
+	} else if prop.Encoding != "" || prop.TsAndCs() != tsandcs {
+		t.Errorf("compressing a proposal should not change it: %v", prop)
+	}
+
+	if pay, err := json.Marshal(msg); err != nil {
+		t.Errorf("unable to marshal compressed proposal: %v", err)
+	} else if received, err := ValidateProposal(string(pay)); err != nil {
+		t.Errorf("unexpected error validating compressed proposal: %v", err)
+	} else if received.TsAndCs() != tsandcs || received.ProducerPolicy() != prodPol {
+		t.Errorf("received proposal should have the original policies, has %v and %v", received.TsAndCs(), received.ProducerPolicy())
+	} else if demarshalled, err := DemarshalProposal(string(pay)); err != nil || demarshalled.TsAndCs() != tsandcs {
+		t.Errorf("demarshalled proposal should have the original policies, error %v", err)
+	}
+
+	if _, err := ValidateProposal(`{"type":"proposal","protocol":"Basic","version":3,"agreementId":"ag1","tsandcs":"x","producerPolicy":"y","consumerId":"c","encoding":"zip"}`); err == nil {
+		t.Errorf("proposal with an unknown encoding should not be valid")
+	}
+
+	// A proposal that is too large names its largest section.
+	huge := `{"header":{"name":"mypolicy"},"workloads":[{"workloadUrl":"myservice","deployment":"` + strings.Repeat("ab", MAX_PROPOSAL_SIZE) + `"}]}`
+	if _, err := proposalMessage(&compressingHandler{}, NewProposal("Basic", 2, huge, prodPol, "ag2", "myorg/agbot1")); err == nil {
+		t.Errorf("oversized proposal should not be sent")
+	} else if !strings.Contains(err.Error(), "workloads[0].deployment of the terms and conditions") {
+		t.Errorf("error should name the oversized section: %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package abstractprotocol
 
 import (
 	"fmt"
+	"time"
 )
 
 // =======================================================================================================
@@ -15,8 +16,14 @@ type Proposal interface {
 	TsAndCs() string
 	ProducerPolicy() string
 	ConsumerId() string
+	Expiry() uint64
+	IsExpired() bool
 }
 
+// A proposal expires when the consumer stops waiting for the reply. The producer allows for this much difference
+// between its clock and the consumer's clock before it decides that a proposal has expired.
+const PROPOSAL_EXPIRY_TOLERANCE_S = 60
+
 // A concrete Proposal object that implements all the functions of a Proposal interface. This represents the base protocol object for a proposal. Other
 // agreement protocols might wish to embed and then extend this object.
 type BaseProposal struct {
@@ -24,6 +31,8 @@ type BaseProposal struct {
 	TsandCs        string `json:"tsandcs"` // This is a JSON serialized policy file, merged between consumer and producer. It has 1 workload array element.
 	Producerpolicy string `json:"producerPolicy"`
 	Consumerid     string `json:"consumerId"`
+	ExpiryTime     uint64 `json:"expiry,omitempty"`   // The time (in seconds since the epoch) after which the consumer no longer waits for a reply, 0 if it waits forever.
+	Encoding       string `json:"encoding,omitempty"` // How the policies are encoded, empty when they are plain JSON.
 }
 
 func NewProposal(name string, version int, tsandcs string, pPol string, agId string, cId string) *BaseProposal {
@@ -45,12 +54,12 @@ func (bp *BaseProposal) IsValid() bool {
 }
 
 func (bp *BaseProposal) String() string {
-	return bp.BaseProtocolMessage.String() + fmt.Sprintf(", ConsumerId: %v", bp.Consumerid)
+	return bp.BaseProtocolMessage.String() + fmt.Sprintf(", ConsumerId: %v, Expiry: %v", bp.Consumerid, bp.ExpiryTime)
 }
 
 func (bp *BaseProposal) ShortString() string {
 	res := ""
-	res += bp.BaseProtocolMessage.String() + fmt.Sprintf(", ConsumerId: %v", bp.Consumerid)
+	res += bp.BaseProtocolMessage.String() + fmt.Sprintf(", ConsumerId: %v, Expiry: %v", bp.Consumerid, bp.ExpiryTime)
 	res += fmt.Sprintf(", TsAndCs: %v", bp.TsandCs[:40])
 	res += fmt.Sprintf(", Producer Policy: %v", bp.Producerpolicy[:40])
 	return res
@@ -67,3 +76,12 @@ func (bp *BaseProposal) ProducerPolicy() string {
 func (bp *BaseProposal) ConsumerId() string {
 	return bp.Consumerid
 }
+
+func (bp *BaseProposal) Expiry() uint64 {
+	return bp.ExpiryTime
+}
+
+// A proposal from a consumer that doesn't set an expiry never expires.
+func (bp *BaseProposal) IsExpired() bool {
+	return bp.ExpiryTime != 0 && bp.ExpiryTime+PROPOSAL_EXPIRY_TOLERANCE_S < uint64(time.Now().Unix())
+}
@@ -2,6 +2,7 @@ package abstractprotocol
 
 import (
 	"fmt"
+	"github.com/open-horizon/anax/policy"
 )
 
 // =======================================================================================================
@@ -16,14 +17,69 @@ type ProposalReply interface {
 	DeviceId() string
 	AcceptProposal()
 	DoNotAcceptProposal()
+	RejectReason() string
+	SetRejectReason(reason string)
+	DecisionExplanation() *DecisionExplanation
+	SetDecisionExplanation(explanation *DecisionExplanation)
+}
+
+// The reasons a producer can give when it rejects a proposal. Most rejections dont carry a reason.
+const REJECT_NODE_AGREEMENT_LIMIT = "NodeAgreementLimit"     // the node is already running as many agreements as it allows
+const REJECT_PROPOSAL_EXPIRED = "ProposalExpired"            // the proposal arrived after the consumer stopped waiting for the reply
+const REJECT_NODE_LOW_DISK = "NodeLowDisk"                   // the node is short of disk space, it takes no new agreements until space is freed
+const REJECT_NODE_MISSING_DEVICES = "NodeMissingDevices"     // the node does not have the host devices that the workload needs
+const REJECT_NODE_WORKLOAD_ROLLBACK = "NodeWorkloadRollback" // the node rolled back from the workload version in the proposal because it kept failing
+
+// The steps of deciding on a proposal, used to explain which step failed when a proposal is declined.
+const EXPLAIN_INVALID_POLICY = "invalidPolicy"                    // a policy in the proposal could not be read
+const EXPLAIN_NO_POLICY = "noMatchingPolicy"                      // the producer policy in the proposal doesnt match the node's policies
+const EXPLAIN_PRODUCER_POLICY = "producerPolicyMismatch"          // the node's policies are not compatible with the producer policy in the proposal
+const EXPLAIN_MAX_AGREEMENTS = "maxAgreements"                    // the node's policies have reached their maximum number of agreements
+const EXPLAIN_COMPUTED_PROPERTIES = "computedProperties"          // a computed property of the node could not be evaluated
+const EXPLAIN_TERMS_AND_CONDITIONS = "termsAndConditionsMismatch" // the terms and conditions are not compatible with the node's policy
+const EXPLAIN_INTERNAL_ERROR = "internalError"                    // the node failed to record the agreement
+
+// A structured explanation of why a producer declined a proposal. Constraint is the part of the policies that did
+// not match, one of the policy.COMPAT_ constants, and Property, SpecRef and VersionRange say what exactly did not
+// match, when the producer knows.
+type DecisionExplanation struct {
+	Stage        string `json:"stage"`
+	Constraint   string `json:"constraint,omitempty"`
+	Property     string `json:"property,omitempty"`
+	SpecRef      string `json:"specRef,omitempty"`
+	VersionRange string `json:"versionRange,omitempty"`
+	Detail       string `json:"detail,omitempty"`
+}
+
+func (d DecisionExplanation) String() string {
+	return fmt.Sprintf("Stage: %v, Constraint: %v, Property: %v, SpecRef: %v, VersionRange: %v, Detail: %v", d.Stage, d.Constraint, d.Property, d.SpecRef, d.VersionRange, d.Detail)
+}
+
+// Create the explanation for a decision that failed at the stage because of the error.
+func NewDecisionExplanation(stage string, cause error) *DecisionExplanation {
+	de := &DecisionExplanation{
+		Stage: stage,
+	}
+	if cause != nil {
+		de.Detail = cause.Error()
+	}
+	if ce, ok := cause.(*policy.CompatibilityError); ok {
+		de.Constraint = ce.Constraint
+		de.Property = ce.Property
+		de.SpecRef = ce.SpecRef
+		de.VersionRange = ce.VersionRange
+	}
+	return de
 }
 
 // A concrete ProposalReply object that implements all the functions of a ProposalReply interface. This represents the base protocol
 // object for a proposal reply. Other agreement protocols might wish to embed and then extend this object.
 type BaseProposalReply struct {
 	*BaseProtocolMessage
-	Decision bool   `json:"decision"`
-	Deviceid string `json:"deviceId"`
+	Decision    bool                 `json:"decision"`
+	Deviceid    string               `json:"deviceId"`
+	Reason      string               `json:"reason,omitempty"`      // why the proposal was not accepted, when the producer says
+	Explanation *DecisionExplanation `json:"explanation,omitempty"` // which part of the decision failed, when the producer says
 }
 
 func (bp *BaseProposalReply) IsValid() bool {
@@ -31,11 +87,11 @@ func (bp *BaseProposalReply) IsValid() bool {
 }
 
 func (bp *BaseProposalReply) String() string {
-	return bp.BaseProtocolMessage.String() + fmt.Sprintf(", Decision: %v, DeviceId: %v", bp.Decision, bp.Deviceid)
+	return bp.BaseProtocolMessage.String() + fmt.Sprintf(", Decision: %v, DeviceId: %v, Reason: %v, Explanation: %v", bp.Decision, bp.Deviceid, bp.Reason, bp.Explanation)
 }
 
 func (bp *BaseProposalReply) ShortString() string {
-	return bp.BaseProtocolMessage.ShortString() + fmt.Sprintf(", Decision: %v, DeviceId: %v", bp.Decision, bp.Deviceid)
+	return bp.BaseProtocolMessage.ShortString() + fmt.Sprintf(", Decision: %v, DeviceId: %v, Reason: %v", bp.Decision, bp.Deviceid, bp.Reason)
 }
 
 func (bp *BaseProposalReply) ProposalAccepted() bool {
@@ -54,6 +110,23 @@ func (bp *BaseProposalReply) DoNotAcceptProposal() {
 	bp.Decision = false
 }
 
+func (bp *BaseProposalReply) RejectReason() string {
+	return bp.Reason
+}
+
+func (bp *BaseProposalReply) SetRejectReason(reason string) {
+	bp.Decision = false
+	bp.Reason = reason
+}
+
+func (bp *BaseProposalReply) DecisionExplanation() *DecisionExplanation {
+	return bp.Explanation
+}
+
+func (bp *BaseProposalReply) SetDecisionExplanation(explanation *DecisionExplanation) {
+	bp.Explanation = explanation
+}
+
 func NewProposalReply(name string, version int, id string, deviceId string) *BaseProposalReply {
 	return &BaseProposalReply{
 		BaseProtocolMessage: &BaseProtocolMessage{
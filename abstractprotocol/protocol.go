@@ -5,9 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"github.com/golang/glog"
+	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/metering"
 	"github.com/open-horizon/anax/policy"
 	"net/http"
+	"time"
 )
 
 // Protocol message types
@@ -89,6 +91,7 @@ type ProtocolHandler interface {
 	Version() int
 	PolicyManager() *policy.PolicyManager
 	HTTPClient() *http.Client
+	CompressesProposals(version int) bool
 
 	// Protocol methods that the handler has to implement
 	InitiateAgreement(agreementId string,
@@ -100,6 +103,7 @@ type ProtocolHandler interface {
 		workload *policy.Workload,
 		defaultPW string,
 		defaultNoData uint64,
+		proposalTimeoutS uint64,
 		sendMessage func(msgTarget interface{}, pay []byte) error) (Proposal, error)
 
 	DemarshalProposal(proposal string) (Proposal, error)
@@ -164,10 +168,12 @@ type ProtocolHandler interface {
 }
 
 type BaseProtocolHandler struct {
-	name       string
-	version    int
-	httpClient *http.Client
-	pm         *policy.PolicyManager
+	name               string
+	version            int
+	httpClient         *http.Client
+	pm                 *policy.PolicyManager
+	compressionVersion int // proposals of this protocol version and later are compressed, 0 if they never are
+	sealingVersion     int // messages of this protocol version and later are sealed, 0 if they never are
 }
 
 func (bp *BaseProtocolHandler) Name() string {
@@ -186,6 +192,16 @@ func (bp *BaseProtocolHandler) HTTPClient() *http.Client {
 	return bp.httpClient
 }
 
+// Returns true when proposals of the protocol version are sent compressed.
+func (bp *BaseProtocolHandler) CompressesProposals(version int) bool {
+	return bp.compressionVersion != 0 && version >= bp.compressionVersion
+}
+
+// Compress the proposals of the protocol version and later versions.
+func (bp *BaseProtocolHandler) CompressProposalsFrom(version int) {
+	bp.compressionVersion = version
+}
+
 func NewBaseProtocolHandler(n string, v int, h *http.Client, p *policy.PolicyManager) *BaseProtocolHandler {
 	return &BaseProtocolHandler{
 		name:       n,
@@ -206,7 +222,8 @@ func CreateProposal(p ProtocolHandler,
 	myId string,
 	workload *policy.Workload,
 	defaultPW string,
-	defaultNoData uint64) (*BaseProposal, error) {
+	defaultNoData uint64,
+	proposalTimeoutS uint64) (*BaseProposal, error) {
 
 	if TCPolicy, err := policy.Create_Terms_And_Conditions(producerPolicy, consumerPolicy, workload, agreementId, defaultPW, defaultNoData, version); err != nil {
 		return nil, errors.New(fmt.Sprintf("Protocol %v initiation received error trying to merge policy %v and %v, error: %v", p.Name(), producerPolicy, consumerPolicy, err))
@@ -218,7 +235,11 @@ func CreateProposal(p ProtocolHandler,
 		} else if pBytes, err := json.Marshal(producerPolicy); err != nil {
 			return nil, errors.New(fmt.Sprintf("Protocol %v error marshalling producer policy %v, error: %v", p.Name(), *producerPolicy, err))
 		} else {
-			return NewProposal(p.Name(), version, string(tcBytes), string(pBytes), agreementId, myId), nil
+			newProposal := NewProposal(p.Name(), version, string(tcBytes), string(pBytes), agreementId, myId)
+			if proposalTimeoutS != 0 {
+				newProposal.ExpiryTime = uint64(time.Now().Unix()) + proposalTimeoutS
+			}
+			return newProposal, nil
 		}
 	}
 }
@@ -231,13 +252,19 @@ func SendProposal(p ProtocolHandler,
 	messageTarget interface{},
 	sendMessage func(msgTarget interface{}, pay []byte) error) error {
 
+	// Compress the proposal when the device supports it, and make sure it is small enough to send.
+	msg, err := proposalMessage(p, newProposal)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Protocol %v unable to send proposal, error: %v", p.Name(), err))
+	}
+
 	// Tell the policy manager that we're going to attempt an agreement
 	if err := p.PolicyManager().AttemptingAgreement([]policy.Policy{*consumerPolicy}, newProposal.AgreementId(), org); err != nil {
 		glog.Errorf(AAPlogString(p.Name(), fmt.Sprintf("error saving agreement count: %v", err)))
 	}
 
 	// Send a message to the device to initiate the agreement protocol.
-	if err := SendProtocolMessage(messageTarget, newProposal, sendMessage); err != nil {
+	if err := SendProtocolMessage(messageTarget, msg, sendMessage); err != nil {
 		// Tell the policy manager that we're not attempting an agreement
 		if perr := p.PolicyManager().CancelAgreement([]policy.Policy{*consumerPolicy}, newProposal.AgreementId(), org); perr != nil {
 			glog.Errorf(AAPlogString(p.Name(), fmt.Sprintf("error saving agreement count: %v", perr)))
@@ -261,13 +288,18 @@ func DecideOnProposal(p ProtocolHandler,
 	replyErr := error(nil)
 	reply := NewProposalReply(p.Name(), proposal.Version(), proposal.AgreementId(), myId)
 
+	// When the proposal is declined, the stage that failed and the error that caused it are explained to the consumer.
+	stage, cause := "", error(nil)
+
 	var termsAndConditions, producerPolicy *policy.Policy
 
 	// Marshal the policies in the proposal into in memory policy objects
 	if tcPolicy, err := policy.DemarshalPolicy(proposal.TsAndCs()); err != nil {
 		replyErr = errors.New(fmt.Sprintf("Protocol %v decide on proposal received error demarshalling TsAndCs, %v", p.Name(), err))
+		stage, cause = EXPLAIN_INVALID_POLICY, err
 	} else if pPolicy, err := policy.DemarshalPolicy(proposal.ProducerPolicy()); err != nil {
 		replyErr = errors.New(fmt.Sprintf("Protocol %v decide on proposal received error demarshalling Producer Policy, %v", p.Name(), err))
+		stage, cause = EXPLAIN_INVALID_POLICY, err
 	} else {
 		termsAndConditions = tcPolicy
 		producerPolicy = pPolicy
@@ -279,8 +311,10 @@ func DecideOnProposal(p ProtocolHandler,
 	policies, err := p.PolicyManager().GetPolicyList(myOrg, producerPolicy)
 	if err != nil {
 		replyErr = errors.New(fmt.Sprintf("Protocol %v decide on proposal received error getting policy list: %v", p.Name(), err))
+		stage, cause = EXPLAIN_NO_POLICY, err
 	} else if err := p.PolicyManager().AttemptingAgreement(policies, proposal.AgreementId(), myOrg); err != nil {
 		replyErr = errors.New(fmt.Sprintf("Protocol %v decide on proposal received error saving agreement count: %v", p.Name(), err))
+		stage, cause = EXPLAIN_INTERNAL_ERROR, err
 	}
 
 	// The consumer will send 2 policies, one is the merged policy that represents the
@@ -292,29 +326,44 @@ func DecideOnProposal(p ProtocolHandler,
 
 		if mergedPolicy, err := p.PolicyManager().MergeAllProducers(&policies, producerPolicy); err != nil {
 			replyErr = errors.New(fmt.Sprintf("Protocol %v unable to merge producer policies, error: %v", p.Name(), err))
+			stage, cause = EXPLAIN_PRODUCER_POLICY, err
 
 			// Now that we successfully merged our policies, make sure that the input producer policy is compatible with
 			// the result of our merge
 		} else if _, err := policy.Are_Compatible_Producers(mergedPolicy, producerPolicy, uint64(producerPolicy.DataVerify.Interval)); err != nil {
 			replyErr = errors.New(fmt.Sprintf("Protocol %v error verifying merged policy %v and %v, error: %v", p.Name(), mergedPolicy, producerPolicy, err))
+			stage, cause = EXPLAIN_PRODUCER_POLICY, err
 
 			// And make sure we havent exceeded the maxAgreements in any of our policies.
 		} else if maxedOut, err := p.PolicyManager().ReachedMaxAgreements(policies, myOrg); maxedOut {
 			replyErr = errors.New(fmt.Sprintf("Protocol %v max agreements reached: %v", p.Name(), p.PolicyManager().AgreementCountString()))
+			stage = EXPLAIN_MAX_AGREEMENTS
 		} else if err != nil {
 			replyErr = errors.New(fmt.Sprintf("Protocol %v decide on proposal received error getting number of agreements, rejecting proposal: %v", p.Name(), err))
+			stage, cause = EXPLAIN_INTERNAL_ERROR, err
 
 			// Now check to make sure that the merged policy is acceptable. The policy is not acceptable if the terms and conditions are not
 			// compatible with the producer's policy.
-		} else if err := policy.Are_Compatible(producerPolicy, termsAndConditions); err != nil {
+			// Computed properties of the node are evaluated now, so that the terms and conditions are checked against the
+			// current values.
+		} else if evaluatedPolicy, err := producerPolicy.EvaluateComputedProperties(); err != nil {
+			replyErr = errors.New(fmt.Sprintf("Protocol %v decide on proposal received error evaluating computed properties, rejecting proposal: %v", p.Name(), err))
+			stage, cause = EXPLAIN_COMPUTED_PROPERTIES, err
+		} else if err := policy.Are_Compatible(evaluatedPolicy, termsAndConditions); err != nil {
 			replyErr = errors.New(fmt.Sprintf("Protocol %v decide on proposal received error, T and C policy is not compatible, rejecting proposal: %v", p.Name(), err))
+			stage, cause = EXPLAIN_TERMS_AND_CONDITIONS, err
 		} else if err := p.PolicyManager().FinalAgreement(policies, proposal.AgreementId(), myOrg); err != nil {
 			replyErr = errors.New(fmt.Sprintf("Protocol %v decide on proposal received error, unable to record agreement state in PM: %v", p.Name(), err))
+			stage, cause = EXPLAIN_INTERNAL_ERROR, err
 		} else {
 			reply.AcceptProposal()
 		}
 
 	}
+
+	if replyErr != nil {
+		reply.SetDecisionExplanation(NewDecisionExplanation(stage, cause))
+	}
 	return reply, replyErr
 
 }
@@ -402,6 +451,23 @@ func NotifyMeterReading(p ProtocolHandler,
 }
 
 // Send a message containing the proposal.
+// Reject a proposal without deciding on it, telling the consumer why it was rejected.
+func RejectProposal(p ProtocolHandler,
+	proposal Proposal,
+	myId string,
+	reason string,
+	messageTarget interface{},
+	sendMessage func(mt interface{}, pay []byte) error) error {
+
+	reply := NewProposalReply(p.Name(), proposal.Version(), proposal.AgreementId(), myId)
+	reply.SetRejectReason(reason)
+
+	if err := SendProtocolMessage(messageTarget, reply, sendMessage); err != nil {
+		return errors.New(fmt.Sprintf("Protocol %v error trying to send proposal rejection, error: %v", p.Name(), err))
+	}
+	return nil
+}
+
 func SendProtocolMessage(messageTarget interface{},
 	msg interface{},
 	sendMessage func(mt interface{}, pay []byte) error) error {
@@ -445,6 +511,8 @@ func ValidateProposal(proposal string) (Proposal, error) {
 
 	if err := json.Unmarshal([]byte(proposal), prop); err != nil {
 		return nil, errors.New(fmt.Sprintf("Error deserializing proposal: %s, error: %v", proposal, err))
+	} else if err := prop.Decompress(); err != nil {
+		return nil, errors.New(fmt.Sprintf("Error decoding proposal: %s, error: %v", proposal, err))
 	} else if !prop.IsValid() {
 		return nil, errors.New(fmt.Sprintf("Message is not a Proposal."))
 	} else {
@@ -550,6 +618,8 @@ func DemarshalProposal(proposal string) (Proposal, error) {
 
 	if err := json.Unmarshal([]byte(proposal), &prop); err != nil {
 		return nil, errors.New(fmt.Sprintf("Error deserializing proposal: %s, error: %v", proposal, err))
+	} else if err := prop.Decompress(); err != nil {
+		return nil, errors.New(fmt.Sprintf("Error decoding proposal: %s, error: %v", proposal, err))
 	} else {
 		return prop, nil
 	}
@@ -557,5 +627,8 @@ func DemarshalProposal(proposal string) (Proposal, error) {
 }
 
 var AAPlogString = func(p string, v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("AbstractProtocol", cutil.LogFields{cutil.LOG_PROTOCOL: p}, v)
+	}
 	return fmt.Sprintf("AbstractProtocol (%v): %v", p, v)
 }
@@ -0,0 +1,215 @@
+package abstractprotocol
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// The reason an agreement is terminated is sent as a number to the other party of the agreement, and some protocols
+// also record it in a blockchain. Nodes and agbots at different levels decode each other's codes, so a code never
+// changes its meaning once it is used. The producer (node) and the consumer (agbot) each have a range of codes.
+// The reasons in the shared registry have the same code in every protocol, a protocol adds its own reasons to
+// them with the codes that are not used by the shared reasons.
+
+const REASON_PRODUCER = "producer"
+const REASON_CONSUMER = "consumer"
+
+const PRODUCER_REASON_MIN = 100
+const PRODUCER_REASON_MAX = 199
+const CONSUMER_REASON_MIN = 200
+const CONSUMER_REASON_MAX = 299
+
+// The code used for a reason that the protocol does not have, and the description of a code that it does not know.
+const UNKNOWN_REASON_CODE = 999
+const UNKNOWN_REASON_DESCRIPTION = "unknown reason code, device might be downlevel"
+
+// The shared producer reason codes. 100 and 102 are not shared.
+const CANCEL_POLICY_CHANGED = 101
+const CANCEL_CONTAINER_FAILURE = 103
+const CANCEL_NOT_EXECUTED_TIMEOUT = 104
+const CANCEL_USER_REQUESTED = 105
+const CANCEL_AGBOT_REQUESTED = 106 // x6a
+const CANCEL_NO_REPLY_ACK = 107
+const CANCEL_MICROSERVICE_FAILURE = 108
+const CANCEL_WL_IMAGE_LOAD_FAILURE = 109
+const CANCEL_MS_IMAGE_LOAD_FAILURE = 110
+const CANCEL_MS_UPGRADE_REQUIRED = 111
+const CANCEL_IMAGE_DATA_ERROR = 112 // x70
+const CANCEL_IMAGE_FETCH_FAILURE = 113
+const CANCEL_IMAGE_FETCH_AUTH_FAILURE = 114
+const CANCEL_IMAGE_SIG_VERIF_FAILURE = 115
+const CANCEL_NODE_SHUTDOWN = 116 // x74
+const CANCEL_MS_IMAGE_FETCH_FAILURE = 117
+const CANCEL_MS_DOWNGRADE_REQUIRED = 118
+const CANCEL_NODE_DECOMMISSIONED = 119
+const CANCEL_WL_ROLLBACK = 120
+const CANCEL_USER_INPUT_CHANGED = 121
+
+// The shared consumer reason codes. 200 and the codes from 208 are not shared.
+const AB_CANCEL_NO_REPLY = 201
+const AB_CANCEL_NEGATIVE_REPLY = 202
+const AB_CANCEL_NO_DATA_RECEIVED = 203
+const AB_CANCEL_POLICY_CHANGED = 204
+const AB_CANCEL_DISCOVERED = 205 // xcd
+const AB_USER_REQUESTED = 206
+const AB_CANCEL_FORCED_UPGRADE = 207
+
+type TerminationReason struct {
+	Code        uint   `json:"code"`
+	Party       string `json:"party"`       // the party that terminates the agreement for this reason, producer or consumer
+	Name        string `json:"name"`        // the name the party uses to ask for the code, unique within the party
+	Description string `json:"description"` // the description that is recorded with a terminated agreement
+	Shared      bool   `json:"shared"`      // true when every protocol has the reason
+}
+
+func (t TerminationReason) String() string {
+	return fmt.Sprintf("Code: %v, Party: %v, Name: %v, Description: %v, Shared: %v", t.Code, t.Party, t.Name, t.Description, t.Shared)
+}
+
+var sharedReasons = []TerminationReason{
+	{Code: CANCEL_POLICY_CHANGED, Party: REASON_PRODUCER, Name: "PolicyChanged", Description: "producer policy changed"},
+	{Code: CANCEL_CONTAINER_FAILURE, Party: REASON_PRODUCER, Name: "ContainerFailure", Description: "workload terminated"},
+	{Code: CANCEL_NOT_EXECUTED_TIMEOUT, Party: REASON_PRODUCER, Name: "NotExecuted", Description: "workload start timeout"},
+	{Code: CANCEL_USER_REQUESTED, Party: REASON_PRODUCER, Name: "UserRequested", Description: "user requested"},
+	{Code: CANCEL_AGBOT_REQUESTED, Party: REASON_PRODUCER, Name: "ConsumerCancelled", Description: "agbot requested"},
+	{Code: CANCEL_NO_REPLY_ACK, Party: REASON_PRODUCER, Name: "NoReplyAck", Description: "agreement protocol incomplete, no reply ack received"},
+	{Code: CANCEL_MICROSERVICE_FAILURE, Party: REASON_PRODUCER, Name: "MicroserviceFailure", Description: "microservice failed"},
+	{Code: CANCEL_WL_IMAGE_LOAD_FAILURE, Party: REASON_PRODUCER, Name: "WorkloadImageLoadFailure", Description: "workload image loading failed"},
+	{Code: CANCEL_MS_IMAGE_LOAD_FAILURE, Party: REASON_PRODUCER, Name: "MicroserviceImageLoadFailure", Description: "microservice image loading failed"},
+	{Code: CANCEL_MS_UPGRADE_REQUIRED, Party: REASON_PRODUCER, Name: "MicroserviceUpgradeRequired", Description: "required by microservice upgrade process"},
+	{Code: CANCEL_IMAGE_DATA_ERROR, Party: REASON_PRODUCER, Name: "ImageDataError", Description: "image data error"},
+	{Code: CANCEL_IMAGE_FETCH_FAILURE, Party: REASON_PRODUCER, Name: "ImageFetchFailure", Description: "image fetching failed"},
+	{Code: CANCEL_IMAGE_FETCH_AUTH_FAILURE, Party: REASON_PRODUCER, Name: "ImageFetchAuthorizationFailure", Description: "authorization failed for image fetching"},
+	{Code: CANCEL_IMAGE_SIG_VERIF_FAILURE, Party: REASON_PRODUCER, Name: "ImageSignatureVerificationFailure", Description: "image signature verification failed"},
+	{Code: CANCEL_NODE_SHUTDOWN, Party: REASON_PRODUCER, Name: "NodeShutdown", Description: "node was unconfigured"},
+	{Code: CANCEL_MS_IMAGE_FETCH_FAILURE, Party: REASON_PRODUCER, Name: "MicroserviceImageFetchFailure", Description: "microservice image fetching failed"},
+	{Code: CANCEL_MS_DOWNGRADE_REQUIRED, Party: REASON_PRODUCER, Name: "MicroserviceDowngradeRequired", Description: "microservice failed, need downgrading to lower version"},
+	{Code: CANCEL_NODE_DECOMMISSIONED, Party: REASON_PRODUCER, Name: "NodeDecommissioned", Description: "node was decommissioned"},
+	{Code: CANCEL_WL_ROLLBACK, Party: REASON_PRODUCER, Name: "WorkloadRollback", Description: "workload upgrade kept failing, rolled back to the previous version"},
+	{Code: CANCEL_USER_INPUT_CHANGED, Party: REASON_PRODUCER, Name: "UserInputChanged", Description: "user input of the workload changed"},
+	{Code: AB_CANCEL_NO_REPLY, Party: REASON_CONSUMER, Name: "NoReply", Description: "agreement bot never received reply to proposal"},
+	{Code: AB_CANCEL_NEGATIVE_REPLY, Party: REASON_CONSUMER, Name: "NegativeReply", Description: "agreement bot received negative reply"},
+	{Code: AB_CANCEL_NO_DATA_RECEIVED, Party: REASON_CONSUMER, Name: "NoData", Description: "agreement bot did not detect data"},
+	{Code: AB_CANCEL_POLICY_CHANGED, Party: REASON_CONSUMER, Name: "PolicyChanged", Description: "agreement bot policy changed"},
+	{Code: AB_CANCEL_DISCOVERED, Party: REASON_CONSUMER, Name: "CancelDiscovered", Description: "agreement bot discovered cancellation from producer"},
+	{Code: AB_USER_REQUESTED, Party: REASON_CONSUMER, Name: "UserRequested", Description: "agreement bot user requested"},
+	{Code: AB_CANCEL_FORCED_UPGRADE, Party: REASON_CONSUMER, Name: "ForceUpgrade", Description: "agreement bot user requested workload upgrade"},
+}
+
+type terminationReasons []TerminationReason
+
+func (t terminationReasons) Len() int           { return len(t) }
+func (t terminationReasons) Less(i, j int) bool { return t[i].Code < t[j].Code }
+func (t terminationReasons) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+
+// The termination reasons of an agreement protocol, the shared reasons and the reasons of the protocol.
+type ReasonRegistry struct {
+	protocol string
+	reasons  terminationReasons
+	byCode   map[uint]TerminationReason
+	byName   map[string]TerminationReason
+}
+
+func reasonKey(party string, name string) string {
+	return party + "/" + name
+}
+
+// Returns an error when a reason does not fit in its party's range.
+func checkReasonRange(r TerminationReason) error {
+	if r.Name == "" || r.Description == "" {
+		return errors.New(fmt.Sprintf("termination reason %v must have a name and a description", r.Code))
+	} else if r.Party == REASON_PRODUCER && (r.Code < PRODUCER_REASON_MIN || r.Code > PRODUCER_REASON_MAX) {
+		return errors.New(fmt.Sprintf("producer termination reason %v must have a code from %v to %v", r.Name, PRODUCER_REASON_MIN, PRODUCER_REASON_MAX))
+	} else if r.Party == REASON_CONSUMER && (r.Code < CONSUMER_REASON_MIN || r.Code > CONSUMER_REASON_MAX) {
+		return errors.New(fmt.Sprintf("consumer termination reason %v must have a code from %v to %v", r.Name, CONSUMER_REASON_MIN, CONSUMER_REASON_MAX))
+	} else if r.Party != REASON_PRODUCER && r.Party != REASON_CONSUMER {
+		return errors.New(fmt.Sprintf("termination reason %v has unknown party %v", r.Name, r.Party))
+	}
+	return nil
+}
+
+// Create the registry of a protocol from the shared reasons and the reasons of the protocol. A protocol reason can
+// not reuse a code or a name of its party that is already in the registry.
+func NewReasonRegistry(protocol string, extensions []TerminationReason) (*ReasonRegistry, error) {
+	r := &ReasonRegistry{
+		protocol: protocol,
+		reasons:  make(terminationReasons, 0, len(sharedReasons)+len(extensions)),
+		byCode:   make(map[uint]TerminationReason),
+		byName:   make(map[string]TerminationReason),
+	}
+
+	add := func(reason TerminationReason) error {
+		if err := checkReasonRange(reason); err != nil {
+			return err
+		} else if other, ok := r.byCode[reason.Code]; ok {
+			return errors.New(fmt.Sprintf("termination reason %v of protocol %v uses code %v of reason %v", reason.Name, protocol, reason.Code, other.Name))
+		} else if _, ok := r.byName[reasonKey(reason.Party, reason.Name)]; ok {
+			return errors.New(fmt.Sprintf("protocol %v has more than 1 %v termination reason named %v", protocol, reason.Party, reason.Name))
+		}
+		r.reasons = append(r.reasons, reason)
+		r.byCode[reason.Code] = reason
+		r.byName[reasonKey(reason.Party, reason.Name)] = reason
+		return nil
+	}
+
+	for _, reason := range sharedReasons {
+		reason.Shared = true
+		if err := add(reason); err != nil {
+			return nil, err
+		}
+	}
+	for _, reason := range extensions {
+		reason.Shared = false
+		if err := add(reason); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Sort(r.reasons)
+	return r, nil
+}
+
+// The registries of the protocols are static, an error is a programming error.
+func MustNewReasonRegistry(protocol string, extensions []TerminationReason) *ReasonRegistry {
+	if r, err := NewReasonRegistry(protocol, extensions); err != nil {
+		panic(err)
+	} else {
+		return r
+	}
+}
+
+func (r *ReasonRegistry) Protocol() string {
+	return r.protocol
+}
+
+// Returns the code of a party's reason, or UNKNOWN_REASON_CODE when the protocol does not have the reason.
+func (r *ReasonRegistry) Code(party string, name string) uint {
+	if reason, ok := r.byName[reasonKey(party, name)]; ok {
+		return reason.Code
+	}
+	return UNKNOWN_REASON_CODE
+}
+
+// Returns the description of a reason code, the code may come from the other party.
+func (r *ReasonRegistry) Description(code uint64) string {
+	if reason, ok := r.byCode[uint(code)]; ok {
+		return reason.Description
+	}
+	return UNKNOWN_REASON_DESCRIPTION
+}
+
+// Returns the name of a reason code, or the empty string when the protocol does not have the code.
+func (r *ReasonRegistry) Name(code uint64) string {
+	if reason, ok := r.byCode[uint(code)]; ok {
+		return reason.Name
+	}
+	return ""
+}
+
+// Returns the reasons ordered by code.
+func (r *ReasonRegistry) Reasons() []TerminationReason {
+	reasons := make([]TerminationReason, len(r.reasons))
+	copy(reasons, r.reasons)
+	return reasons
+}
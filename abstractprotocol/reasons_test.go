@@ -0,0 +1,73 @@
+// +build unit
+
+package abstractprotocol
+
+import (
+	"testing"
+)
+
+func Test_ReasonRegistry(t *testing.T) {
+
+	r, err := NewReasonRegistry("test", []TerminationReason{
+		{Code: 100, Party: REASON_PRODUCER, Name: "NotFinalized", Description: "not finalized"},
+		{Code: 250, Party: REASON_CONSUMER, Name: "NotFinalized", Description: "agbot not finalized"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// The same name can be used by each party.
+	if code := r.Code(REASON_PRODUCER, "NotFinalized"); code != 100 {
+		t.Errorf("expected 100, got %v", code)
+	} else if code := r.Code(REASON_CONSUMER, "NotFinalized"); code != 250 {
+		t.Errorf("expected 250, got %v", code)
+	} else if code := r.Code(REASON_CONSUMER, "PolicyChanged"); code != AB_CANCEL_POLICY_CHANGED {
+		t.Errorf("shared reasons should be in the registry, got %v", code)
+	} else if code := r.Code(REASON_CONSUMER, "NoSuchReason"); code != UNKNOWN_REASON_CODE {
+		t.Errorf("expected unknown code, got %v", code)
+	}
+
+	if desc := r.Description(CANCEL_NODE_SHUTDOWN); desc != "node was unconfigured" {
+		t.Errorf("unexpected description %v", desc)
+	} else if desc := r.Description(299); desc != UNKNOWN_REASON_DESCRIPTION {
+		t.Errorf("unexpected description %v", desc)
+	}
+	if name := r.Name(CANCEL_NODE_SHUTDOWN); name != "NodeShutdown" {
+		t.Errorf("unexpected name %v", name)
+	} else if name := r.Name(299); name != "" {
+		t.Errorf("unexpected name %v", name)
+	}
+
+	reasons := r.Reasons()
+	if len(reasons) != len(sharedReasons)+2 {
+		t.Errorf("expected %v reasons, got %v", len(sharedReasons)+2, len(reasons))
+	}
+	for i, reason := range reasons {
+		if i > 0 && reasons[i-1].Code >= reason.Code {
+			t.Errorf("reasons should be ordered by code, got %v after %v", reason.Code, reasons[i-1].Code)
+		}
+		if reason.Shared != (reason.Code != 100 && reason.Code != 250) {
+			t.Errorf("reason %v has the wrong shared flag", reason)
+		}
+	}
+}
+
+func Test_ReasonRegistry_invalid(t *testing.T) {
+
+	tests := []struct {
+		reason TerminationReason
+		msg    string
+	}{
+		{TerminationReason{Code: CANCEL_POLICY_CHANGED, Party: REASON_PRODUCER, Name: "Other", Description: "other"}, "a shared code can not be reused"},
+		{TerminationReason{Code: 150, Party: REASON_PRODUCER, Name: "PolicyChanged", Description: "other"}, "a shared name can not be reused"},
+		{TerminationReason{Code: 250, Party: REASON_PRODUCER, Name: "Other", Description: "other"}, "a producer code must be in the producer range"},
+		{TerminationReason{Code: 150, Party: REASON_CONSUMER, Name: "Other", Description: "other"}, "a consumer code must be in the consumer range"},
+		{TerminationReason{Code: 150, Party: "agbot", Name: "Other", Description: "other"}, "the party must be known"},
+		{TerminationReason{Code: 150, Party: REASON_PRODUCER, Description: "other"}, "a reason must have a name"},
+	}
+	for _, test := range tests {
+		if _, err := NewReasonRegistry("test", []TerminationReason{test.reason}); err == nil {
+			t.Errorf("expected an error, %v", test.msg)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package abstractprotocol
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// The messages of an agreement wait in the exchange mailbox of the receiver until it reads them. From the version
+// chosen by a protocol, they are sealed: the encrypted message is padded so that its size does not tell which message
+// it is, and it names the receiver key that it was encrypted to, so that the receiver can rotate its keys. The other
+// party has to support the version to read them. Since the proposal and its reply are the messages that carry the
+// version negotiated for the agreement, they decide whether the messages sent to the other party are sealed.
+
+// The versions from which the protocols seal their messages, keyed by protocol name.
+var sealingVersions = make(map[string]int)
+var sealingLock sync.Mutex
+
+// Returns true when messages of the protocol version are sealed.
+func (bp *BaseProtocolHandler) SealsMessages(version int) bool {
+	return bp.sealingVersion != 0 && version >= bp.sealingVersion
+}
+
+// Seal the messages of the protocol version and later versions.
+func (bp *BaseProtocolHandler) SealMessagesFrom(version int) {
+	bp.sealingVersion = version
+	sealingLock.Lock()
+	defer sealingLock.Unlock()
+	sealingVersions[bp.name] = version
+}
+
+// Looks at a serialized protocol message before it is sent. When it carries the version negotiated for an agreement,
+// negotiated is true and sealed tells whether the version seals messages.
+func NegotiatesSealing(pay []byte) (negotiated bool, sealed bool) {
+	msg := new(BaseProtocolMessage)
+	if err := json.Unmarshal(pay, msg); err != nil {
+		return false, false
+	} else if msg.Type() != MsgTypeProposal && msg.Type() != MsgTypeReply {
+		return false, false
+	}
+
+	sealingLock.Lock()
+	defer sealingLock.Unlock()
+	version, ok := sealingVersions[msg.Protocol()]
+	return true, ok && version != 0 && msg.Version() >= version
+}
@@ -0,0 +1,44 @@
+// +build unit
+
+package abstractprotocol
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func Test_NegotiatesSealing(t *testing.T) {
+
+	bph := NewBaseProtocolHandler("SealingTest", 4, nil, nil)
+	bph.SealMessagesFrom(4)
+
+	if !bph.SealsMessages(4) || bph.SealsMessages(3) {
+		t.Errorf("messages should only be sealed from version 4")
+	} else if NewBaseProtocolHandler("Other", 4, nil, nil).SealsMessages(4) {
+		t.Errorf("messages should not be sealed by default")
+	}
+
+	pay := func(msgType string, protocol string, version int) []byte {
+		b, _ := json.Marshal(&BaseProtocolMessage{MsgType: msgType, AProtocol: protocol, AVersion: version, AgreeId: "ag1"})
+		return b
+	}
+
+	tests := []struct {
+		pay        []byte
+		negotiated bool
+		sealed     bool
+	}{
+		{pay(MsgTypeProposal, "SealingTest", 4), true, true},
+		{pay(MsgTypeReply, "SealingTest", 5), true, true},
+		{pay(MsgTypeProposal, "SealingTest", 3), true, false},
+		{pay(MsgTypeProposal, "Unsealed", 4), true, false},
+		{pay(MsgTypeCancel, "SealingTest", 4), false, false},
+		{[]byte("not json"), false, false},
+	}
+
+	for _, test := range tests {
+		if negotiated, sealed := NegotiatesSealing(test.pay); negotiated != test.negotiated || sealed != test.sealed {
+			t.Errorf("%s should be negotiated %v sealed %v, got %v %v", test.pay, test.negotiated, test.sealed, negotiated, sealed)
+		}
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"github.com/boltdb/bolt"
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/abstractprotocol"
+	"github.com/open-horizon/anax/anaxerrors"
 	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/exchange"
 	"github.com/open-horizon/anax/persistence"
@@ -18,7 +20,6 @@ import (
 	"net/http"
 	"reflect"
 	"strconv"
-	"strings"
 	"time"
 )
 
@@ -36,6 +37,7 @@ type AgreementWorker struct {
 	containerSyncUpSucessful bool
 	producerPH               map[string]producer.ProducerProtocolHandler
 	lastExchVerCheck         int64
+	heartbeatInterval        int // The seconds until the next heartbeat, when the heartbeat backs off
 }
 
 func NewAgreementWorker(name string, cfg *config.HorizonConfig, db *bolt.DB, pm *policy.PolicyManager) *AgreementWorker {
@@ -137,6 +139,13 @@ func (w *AgreementWorker) NewEvent(incoming events.Message) {
 			w.Commands <- worker.NewBeginShutdownCommand()
 		}
 
+	case *events.ExchangeOutageMessage:
+		msg, _ := incoming.(*events.ExchangeOutageMessage)
+		switch msg.Event().Id {
+		case events.EXCHANGE_RESTORED:
+			w.Commands <- NewExchangeRestoredCommand(msg)
+		}
+
 	case *events.NodeShutdownCompleteMessage:
 		msg, _ := incoming.(*events.NodeShutdownCompleteMessage)
 		switch msg.Event().Id {
@@ -256,6 +265,10 @@ func (w *AgreementWorker) CommandHandler(command worker.Command) bool {
 		} else if p, err := w.producerPH[msgProtocol].AgreementProtocolHandler("", "", "").ValidateProposal(protocolMsg); err != nil {
 			glog.V(5).Infof(logString(fmt.Sprintf("Proposal handler ignoring non-proposal message: %s due to %v", cmd.Msg.ShortProtocolMessage(), err)))
 			deleteMessage = false
+		} else if w.Config.Edge.LowMemory() && msgProtocol != policy.BasicProtocol {
+			glog.Infof(logString(fmt.Sprintf("ignoring %v proposal %v, the low memory profile does not run blockchain clients, deleting it.", msgProtocol, p.AgreementId())))
+		} else if w.producerPH[msgProtocol].ReliableMessageReceived(protocolMsg, exchangeMsg) {
+			glog.V(3).Infof(logString(fmt.Sprintf("ignoring proposal %v, it was already received", p.AgreementId())))
 		} else {
 			deleteMessage = w.producerPH[msgProtocol].HandleProposalMessage(p, protocolMsg, exchangeMsg)
 		}
@@ -291,8 +304,18 @@ func (w *AgreementWorker) CommandHandler(command worker.Command) bool {
 			// Setting the node's key into its exchange object enables an agbot to send proposal messages to it. Until this is
 			// set, the node will not receive any proposals.
 			w.patchNodeKey()
+
+			// The node's properties are signed with the key that was just set, so that an agbot can verify them.
+			if w.Config.Edge.NodeAttestation {
+				if err := w.attestNodeProperties(); err != nil {
+					glog.Errorf(logString(fmt.Sprintf("unable to attest to the node properties, error: %v", err)))
+				}
+			}
 		}
 
+	case *ExchangeRestoredCommand:
+		w.reconcileAfterOutage()
+
 	default:
 		// Unexpected commands are not handled.
 		return false
@@ -332,10 +355,12 @@ func (w *AgreementWorker) handleDeviceRegistered(cmd *DeviceRegisteredCommand) {
 
 }
 
-// Heartbeat to the exchange. This function is called by the heartbeat subworker.
+// Heartbeat to the exchange. This function is called by the heartbeat subworker. The heartbeat is also what
+// decides when the node enters and leaves degraded mode, because it is the one exchange call that keeps being
+// made while the node is degraded.
 func (w *AgreementWorker) heartBeat() int {
 
-	if w.Config.Edge.ExchangeVersionCheckIntervalM > 0 {
+	if w.Config.Edge.ExchangeVersionCheckIntervalM > 0 && !exchange.ExchangeDegraded() {
 		// get the exchange version check interval and change to seconds
 		check_interval := w.Config.Edge.ExchangeVersionCheckIntervalM * 60
 
@@ -356,11 +381,66 @@ func (w *AgreementWorker) heartBeat() int {
 	err := exchange.Heartbeat(w.GetHTTPFactory().NewHTTPClient(nil), targetURL, w.GetExchangeId(), w.GetExchangeToken())
 
 	// If the heartbeat fails because the node entry is gone then initiate a full node quiesce
-	if err != nil && strings.Contains(err.Error(), "status: 401") {
+	if err != nil && anaxerrors.Is(err, anaxerrors.EXCHANGE_AUTH) {
 		w.Messages() <- events.NewNodeShutdownMessage(events.START_UNCONFIGURE, false, false)
 	}
 
-	return 0
+	if state, changed := exchange.UpdateOutageState(); changed && state.Degraded {
+		glog.Warningf(logString(fmt.Sprintf("exchange has been unreachable since %v, entering degraded mode. Running workloads are kept, operations that need the exchange are suspended. Last error: %v", time.Unix(state.UnreachableSince, 0), state.LastError)))
+		w.Messages() <- events.NewExchangeOutageMessage(events.EXCHANGE_DEGRADED, state.UnreachableSince)
+	} else if changed {
+		glog.Infof(logString(fmt.Sprintf("exchange is reachable again, leaving degraded mode.")))
+		w.Messages() <- events.NewExchangeOutageMessage(events.EXCHANGE_RESTORED, 0)
+	}
+
+	w.heartbeatInterval = nextHeartbeatInterval(w.heartbeatInterval, w.Config.Edge.ExchangeHeartbeat, w.Config.Edge.ExchangeHeartbeatMaxS, err == nil)
+	return w.heartbeatInterval
+}
+
+// Returns the seconds until the next heartbeat. While the heartbeats succeed, the interval doubles from the configured
+// interval up to the max, so that a healthy node on a metered link heartbeats less often. A failed heartbeat goes back
+// to the configured interval, so that the node is seen by the exchange again soon after its link recovers. Zero
+// leaves the interval of the subworker as it is.
+func nextHeartbeatInterval(current int, base int, max int, ok bool) int {
+	if max <= base {
+		return 0
+	} else if !ok || current < base {
+		return base
+	} else if current*2 > max {
+		return max
+	}
+	return current * 2
+}
+
+// Bring the exchange up to date with the agreements that ended while the node was in degraded mode. Those agreements
+// could not be deleted from the exchange, and their presence in the exchange prevents agbots from making new
+// agreements with this node.
+func (w *AgreementWorker) reconcileAfterOutage() {
+
+	glog.V(3).Infof(logString("reconciling agreements with the exchange after an outage."))
+
+	exchangeDeviceAgreements, err := w.getAllAgreements()
+	if err != nil {
+		glog.Errorf(logString(fmt.Sprintf("unable to reconcile agreements, error getting device agreement list from exchange: %v", err)))
+		return
+	}
+
+	notTerminatedFilter := func() persistence.EAFilter {
+		return func(a persistence.EstablishedAgreement) bool { return a.AgreementTerminatedTime == 0 }
+	}
+
+	for exchangeAg, _ := range exchangeDeviceAgreements {
+		if agreements, err := persistence.FindEstablishedAgreementsAllProtocols(w.db, policy.AllAgreementProtocols(), []persistence.EAFilter{persistence.IdEAFilter(exchangeAg), persistence.UnarchivedEAFilter(), notTerminatedFilter()}); err != nil {
+			glog.Errorf(logString(fmt.Sprintf("error searching for agreement %v from exchange agreements. %v", exchangeAg, err)))
+		} else if len(agreements) == 0 {
+			glog.V(3).Infof(logString(fmt.Sprintf("agreement %v ended while the exchange was unreachable, deleting it from the exchange.", exchangeAg)))
+			if err := deleteProducerAgreement(w.GetHTTPFactory().NewHTTPClient(nil), w.GetExchangeURL(), w.GetExchangeId(), w.GetExchangeToken(), exchangeAg); err != nil {
+				glog.Errorf(logString(fmt.Sprintf("error deleting agreement %v in exchange: %v", exchangeAg, err)))
+			}
+		}
+	}
+
+	glog.V(3).Infof(logString("reconciled agreements with the exchange."))
 }
 
 // This function is only called when anax device side initializes. The agbot has it's own initialization checking.
@@ -774,6 +854,9 @@ func deleteProducerAgreement(httpClient *http.Client, url string, deviceId strin
 		if err, tpErr := exchange.InvokeExchange(httpClient, "DELETE", targetURL, deviceId, token, nil, &resp); err != nil {
 			glog.Errorf(logString(fmt.Sprintf(err.Error())))
 			return err
+		} else if tpErr != nil && exchange.ExchangeDegraded() {
+			// The agreement is deleted from the exchange when the node leaves degraded mode.
+			return tpErr
 		} else if tpErr != nil {
 			glog.Warningf(tpErr.Error())
 			time.Sleep(10 * time.Second)
@@ -789,7 +872,7 @@ func deleteProducerAgreement(httpClient *http.Client, url string, deviceId strin
 func (w *AgreementWorker) deleteMessage(msg *exchange.DeviceMessage) error {
 	var resp interface{}
 	resp = new(exchange.PostDeviceResponse)
-	targetURL := w.GetExchangeURL() + "orgs/" + exchange.GetOrg(w.GetExchangeId()) + "/nodes/" + exchange.GetId(w.GetExchangeId()) + "/msgs/" + strconv.Itoa(msg.MsgId)
+	targetURL := exchange.EdgeMessageTransport(w.Config).NodeMailboxURL(w.GetExchangeId()) + "/" + strconv.Itoa(msg.MsgId)
 	for {
 		if err, tpErr := exchange.InvokeExchange(w.GetHTTPFactory().NewHTTPClient(nil), "DELETE", targetURL, w.GetExchangeId(), w.GetExchangeToken(), nil, &resp); err != nil {
 			glog.Errorf(err.Error())
@@ -808,7 +891,7 @@ func (w *AgreementWorker) deleteMessage(msg *exchange.DeviceMessage) error {
 func (w *AgreementWorker) messageInExchange(msgId int) (bool, error) {
 	var resp interface{}
 	resp = new(exchange.GetDeviceMessageResponse)
-	targetURL := w.GetExchangeURL() + "orgs/" + exchange.GetOrg(w.GetExchangeId()) + "/nodes/" + exchange.GetId(w.GetExchangeId()) + "/msgs"
+	targetURL := exchange.EdgeMessageTransport(w.Config).NodeMailboxURL(w.GetExchangeId())
 	for {
 		if err, tpErr := exchange.InvokeExchange(w.GetHTTPFactory().NewHTTPClient(nil), "GET", targetURL, w.GetExchangeId(), w.GetExchangeToken(), nil, &resp); err != nil {
 			glog.Errorf(err.Error())
@@ -830,5 +913,8 @@ func (w *AgreementWorker) messageInExchange(msgId int) (bool, error) {
 }
 
 var logString = func(v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("AgreementWorker", nil, v)
+	}
 	return fmt.Sprintf("AgreementWorker %v", v)
 }
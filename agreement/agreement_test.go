@@ -0,0 +1,43 @@
+// +build unit
+
+package agreement
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_nextHeartbeatInterval(t *testing.T) {
+
+	// No max, the subworker keeps its interval.
+	if next := nextHeartbeatInterval(0, 60, 0, true); next != 0 {
+		t.Errorf("expected 0, got %v", next)
+	}
+
+	// Backs off while healthy, up to the max.
+	current := 0
+	for _, expected := range []int{60, 120, 240, 300, 300} {
+		if current = nextHeartbeatInterval(current, 60, 300, true); current != expected {
+			t.Errorf("expected %v, got %v", expected, current)
+		}
+	}
+
+	// Goes back to the configured interval when a heartbeat fails.
+	if next := nextHeartbeatInterval(current, 60, 300, false); next != 60 {
+		t.Errorf("expected 60, got %v", next)
+	}
+}
+
+func Test_memoryMB(t *testing.T) {
+
+	meminfo := "MemTotal:        8048508 kB\nMemFree:          180224 kB\n"
+	if mb, err := memoryMB(strings.NewReader(meminfo)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if mb != 7859 {
+		t.Errorf("expected 7859, got %v", mb)
+	}
+
+	if _, err := memoryMB(strings.NewReader("MemFree:          180224 kB\n")); err == nil {
+		t.Errorf("expected an error without MemTotal")
+	}
+}
@@ -0,0 +1,89 @@
+package agreement
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/containermessage"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/policy"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The node attests to the properties it measures itself by signing them with its messaging key, so that business
+// policies that require attestation can trust them. The properties are measured and signed again every time the
+// node's configuration is completed.
+
+const ATTESTED_ARCH = "arch"
+const ATTESTED_MEMORY = "memoryMB"
+const ATTESTED_GPU = "gpu"
+
+// The device that exists when the host has an NVIDIA GPU and its driver.
+const GPU_DEVICE = "/dev/nvidiactl"
+
+// Returns the MB of memory in the output of /proc/meminfo.
+func memoryMB(meminfo io.Reader) (int, error) {
+	scanner := bufio.NewScanner(meminfo)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			if kb, err := strconv.Atoi(fields[1]); err != nil {
+				return 0, errors.New(fmt.Sprintf("unable to parse MemTotal %v, error: %v", fields[1], err))
+			} else {
+				return kb / 1024, nil
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, errors.New(fmt.Sprintf("MemTotal not found"))
+}
+
+// Measure the properties the node attests to.
+func measuredProperties() (policy.PropertyList, error) {
+	props := policy.PropertyList{
+		*policy.Property_Factory(ATTESTED_ARCH, cutil.ArchString()),
+		*policy.Property_Factory(ATTESTED_GPU, containermessage.HostDeviceExists(GPU_DEVICE)),
+	}
+
+	if f, err := os.Open("/proc/meminfo"); err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to read the memory of the node, error: %v", err))
+	} else {
+		defer f.Close()
+		if mb, err := memoryMB(f); err != nil {
+			return nil, errors.New(fmt.Sprintf("unable to read the memory of the node, error: %v", err))
+		} else {
+			props = append(props, *policy.Property_Factory(ATTESTED_MEMORY, mb))
+		}
+	}
+	return props, nil
+}
+
+// Sign the measured properties and publish them in the node's exchange resource.
+func (w *AgreementWorker) attestNodeProperties() error {
+
+	props, err := measuredProperties()
+	if err != nil {
+		return err
+	}
+
+	_, privateKey, err := exchange.GetKeys("")
+	if err != nil {
+		return errors.New(fmt.Sprintf("unable to get the messaging key of the node, error: %v", err))
+	}
+
+	attestation, err := exchange.NewNodeAttestation(props, uint64(time.Now().Unix()), privateKey)
+	if err != nil {
+		return err
+	}
+
+	glog.V(3).Infof(logString(fmt.Sprintf("attesting to node properties %v", props)))
+	return exchange.PutNodeAttestation(w.GetHTTPFactory().NewHTTPClient(nil), w.GetExchangeId(), w.GetExchangeURL(), w.GetExchangeId(), w.GetExchangeToken(), attestation)
+}
@@ -51,3 +51,18 @@ func NewEdgeConfigCompleteCommand(msg *events.EdgeConfigCompleteMessage) *EdgeCo
 		Msg: msg,
 	}
 }
+
+// ==============================================================================================================
+type ExchangeRestoredCommand struct {
+	Msg *events.ExchangeOutageMessage
+}
+
+func (e ExchangeRestoredCommand) ShortString() string {
+	return fmt.Sprintf("%v", e)
+}
+
+func NewExchangeRestoredCommand(msg *events.ExchangeOutageMessage) *ExchangeRestoredCommand {
+	return &ExchangeRestoredCommand{
+		Msg: msg,
+	}
+}
@@ -0,0 +1,50 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/open-horizon/anax/policy"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func Test_AgreementTimeouts(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "agbot-timeouts")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := bolt.Open(path.Join(dir, "agbot.db"), 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("unable to open db: %v", err)
+	}
+	defer db.Close()
+
+	// The timeouts of the policy are kept with the agreement, and survive the updates of the agreement.
+	timeouts := policy.AgreementTimeouts_Factory(900, 0)
+	if err := AgreementAttempt(db, "ag1", "myorg", "myorg/node1", "policy", "", "", "", policy.BasicProtocol, "myorg/cellular", policy.NodeHealth{}, timeouts); err != nil {
+		t.Fatalf("unable to create agreement: %v", err)
+	} else if err := AgreementAttempt(db, "ag2", "myorg", "myorg/node2", "policy", "", "", "", policy.BasicProtocol, "myorg/pat", policy.NodeHealth{}, nil); err != nil {
+		t.Fatalf("unable to create agreement: %v", err)
+	} else if _, err := AgreementUpdate(db, "ag1", "proposal", "policy", policy.DataVerification{}, 0, "hash", "sig", policy.BasicProtocol, 2); err != nil {
+		t.Fatalf("unable to update agreement: %v", err)
+	}
+
+	if ag, err := FindSingleAgreementByAgreementId(db, "ag1", policy.BasicProtocol, []AFilter{}); err != nil || ag == nil {
+		t.Errorf("unable to find agreement ag1, error %v", err)
+	} else if ag.NoReplyTimeout(60) != 900 || ag.NotFinalizedTimeout(360) != 360 {
+		t.Errorf("agreement ag1 should wait 900 seconds for a reply and 360 to be finalized, has %v", ag)
+	}
+
+	if ag, err := FindSingleAgreementByAgreementId(db, "ag2", policy.BasicProtocol, []AFilter{}); err != nil || ag == nil {
+		t.Errorf("unable to find agreement ag2, error %v", err)
+	} else if ag.NoReplyTimeout(60) != 60 || ag.NotFinalizedTimeout(360) != 360 {
+		t.Errorf("agreement ag2 should use the configured timeouts, has %v", ag)
+	}
+}
@@ -0,0 +1,94 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/open-horizon/anax/policy"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func Test_AgreementTimestamps(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "agbot-timestamps")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := bolt.Open(path.Join(dir, "agbot.db"), 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("unable to open db: %v", err)
+	}
+	defer db.Close()
+
+	// The same steps are recorded for each protocol.
+	for _, protocol := range []string{policy.BasicProtocol, policy.CitizenScientist} {
+		if err := AgreementAttempt(db, "ag1", "myorg", "myorg/node1", "policy", "", "", "", protocol, "", policy.NodeHealth{}, nil); err != nil {
+			t.Fatalf("unable to create agreement: %v", err)
+		}
+		if ag, err := FindSingleAgreementByAgreementId(db, "ag1", protocol, []AFilter{}); err != nil || ag == nil {
+			t.Fatalf("unable to find agreement, error %v", err)
+		} else if ag.Timestamps != (AgreementTimestamps{}) {
+			t.Errorf("%v: no step has happened yet, got %v", protocol, ag.Timestamps)
+		}
+
+		if _, err := AgreementUpdate(db, "ag1", "proposal", "policy", policy.DataVerification{}, 0, "hash", "sig", protocol, 1); err != nil {
+			t.Fatalf("unable to update agreement: %v", err)
+		} else if _, err := AgreementMade(db, "ag1", "address", "sig", protocol, nil, "", "", ""); err != nil {
+			t.Fatalf("unable to update agreement: %v", err)
+		} else if _, err := AgreementFinalized(db, "ag1", protocol); err != nil {
+			t.Fatalf("unable to update agreement: %v", err)
+		}
+
+		ag, err := FindSingleAgreementByAgreementId(db, "ag1", protocol, []AFilter{})
+		if err != nil || ag == nil {
+			t.Fatalf("unable to find agreement, error %v", err)
+		}
+		ts := ag.Timestamps
+		if ts.ProposalSent == 0 || ts.ReplyReceived < ts.ProposalSent || ts.Finalized < ts.ReplyReceived || ts.Terminated != 0 {
+			t.Errorf("%v: unexpected timestamps %v", protocol, ts)
+		}
+
+		// The first termination time is kept.
+		if _, err := AgreementTimedout(db, "ag1", protocol); err != nil {
+			t.Fatalf("unable to update agreement: %v", err)
+		}
+		ag, _ = FindSingleAgreementByAgreementId(db, "ag1", protocol, []AFilter{})
+		terminated := ag.Timestamps.Terminated
+		if terminated == 0 {
+			t.Errorf("%v: termination time should be set, got %v", protocol, ag.Timestamps)
+		}
+		time.Sleep(1100 * time.Millisecond)
+		if ag, err := ArchiveAgreement(db, "ag1", protocol, 1, "cancelled"); err != nil {
+			t.Fatalf("unable to archive agreement: %v", err)
+		} else if ag.Timestamps.Terminated != terminated {
+			t.Errorf("%v: termination time should not change, was %v, got %v", protocol, terminated, ag.Timestamps.Terminated)
+		}
+
+		if err := DeleteAgreement(db, "ag1", protocol); err != nil {
+			t.Fatalf("unable to delete agreement: %v", err)
+		}
+	}
+}
+
+func Test_normalizeTimestamps(t *testing.T) {
+
+	// An agreement that was recorded before the timestamps were.
+	a := Agreement{AgreementCreationTime: 100, AgreementFinalizedTime: 120, AgreementTimedout: 200}
+	a.normalizeTimestamps()
+	if a.Timestamps != (AgreementTimestamps{ProposalSent: 100, Finalized: 120, Terminated: 200}) {
+		t.Errorf("unexpected timestamps %v", a.Timestamps)
+	}
+
+	// Recorded timestamps are not replaced.
+	a = Agreement{AgreementCreationTime: 100, Timestamps: AgreementTimestamps{ProposalSent: 90, ReplyReceived: 95}}
+	a.normalizeTimestamps()
+	if a.Timestamps != (AgreementTimestamps{ProposalSent: 90, ReplyReceived: 95}) {
+		t.Errorf("unexpected timestamps %v", a.Timestamps)
+	}
+}
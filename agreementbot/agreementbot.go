@@ -2,22 +2,29 @@ package agreementbot
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/boltdb/bolt"
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/abstractprotocol"
+	"github.com/open-horizon/anax/anaxerrors"
 	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/faults"
+	"github.com/open-horizon/anax/metering"
 	"github.com/open-horizon/anax/policy"
 	"github.com/open-horizon/anax/version"
 	"github.com/open-horizon/anax/worker"
 	"net/http"
 	"os"
+	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -26,8 +33,15 @@ const HEARTBEAT = "AgbotHeartBeat"
 const GOVERN_AGREEMENTS = "AgBotGovernAgreements"
 const GOVERN_ARCHIVED_AGREEMENTS = "AgBotGovernArchivedAgreements"
 const GOVERN_BC_NEEDS = "AgBotGovernBlockchain"
+const GOVERN_BC_HEALTH = "AgBotGovernBlockchainHealth"
 const POLICY_WATCHER = "AgBotPolicyWatcher"
 const GENERATE_POLICY = "AgBotPolicyGenerator"
+const GOVERN_BULK_CANCEL = "AgBotGovernBulkCancel"
+const GOVERN_UPGRADE_ROLLOUT = "AgBotGovernUpgradeRollout"
+const MESSAGE_LONG_POLL = "AgBotMessageLongPoll"
+const MESSAGE_PUSH = "AgBotMessagePush"
+const GOVERN_PARTITIONS = "AgBotGovernPartitions"
+const GOVERN_MESSAGE_KEYS = "AgBotGovernMessageKeys"
 
 // Agreement governance timing state. Used in the GovernAgreements subworker.
 type DVState struct {
@@ -37,16 +51,31 @@ type DVState struct {
 
 // must be safely-constructed!!
 type AgreementBotWorker struct {
-	worker.BaseWorker // embedded field
-	db                *bolt.DB
-	httpClient        *http.Client // a shared HTTP client instance for this worker
-	pm                *policy.PolicyManager
-	consumerPH        map[string]ConsumerProtocolHandler
-	ready             bool
-	PatternManager    *PatternManager
-	NHManager         *NodeHealthManager
-	GovTiming         DVState
-	lastExchVerCheck  int64
+	worker.BaseWorker  // embedded field
+	db                 *bolt.DB
+	httpClient         *http.Client // a shared HTTP client instance for this worker
+	pm                 *policy.PolicyManager
+	consumerPH         map[string]ConsumerProtocolHandler
+	consumerPHLock     sync.RWMutex // Guards additions to consumerPH, which the API reads from its own goroutines
+	ready              bool
+	PatternManager     *PatternManager
+	BusinessPolManager *BusinessPolicyManager
+	NHManager          *NodeHealthManager
+	GovTiming          DVState
+	lastExchVerCheck   int64
+	draining           bool                    // No new agreement work is started once the agbot begins to shut down
+	drained            chan bool               // Closed when the in-flight agreement work is finished during a shutdown
+	meteringMQTT       *metering.MQTTPublisher // Publishes metering notifications when a broker is configured, otherwise nil
+	maintenance        bool                    // The maintenance mode seen by the most recent agreement governance pass
+	mailbox            *exchange.MailboxPoller // Decides whether the agbot's mailbox is long polled or polled by the NoWorkHandler
+	mailboxClient      *http.Client            // The HTTP client used to fetch messages, with a timeout long enough for a long poll
+	pushClient         *http.Client            // The HTTP client that holds the push channel of the mailbox open, without a timeout
+	partitions         *PartitionManager       // Decides which nodes this agbot makes agreements with when it has HA peers, otherwise nil
+	dataPushes         *DataPushes             // The data receipts pushed to the API for the agreements with webhook data verification
+	placementHooks     *PlacementHooks         // The external schedulers that review the nodes found by a search, nil when none are configured
+	quotas             *QuotaManager           // Enforces the quotas of the orgs served by the agbot
+	governance         *GovernanceMetrics      // The duration of the agreement governance passes and the work done by each shard
+	nodeSearches       *NodeSearchCache        // The nodes found by recent searches of the exchange, nil when search results are not cached
 }
 
 func NewAgreementBotWorker(name string, cfg *config.HorizonConfig, db *bolt.DB) *AgreementBotWorker {
@@ -54,16 +83,35 @@ func NewAgreementBotWorker(name string, cfg *config.HorizonConfig, db *bolt.DB)
 	// An agbot is never service based, it supports both all the time, until we get rid of support for workloads.
 	ec := worker.NewExchangeContext(cfg.AgreementBot.ExchangeId, cfg.AgreementBot.ExchangeToken, cfg.AgreementBot.ExchangeURL, false, cfg.Collaborators.HTTPClientFactory)
 
+	mailbox := exchange.NewMailboxPoller(cfg.AgreementBot.ExchangeMessageWaitS, int(cfg.AgreementBot.NewContractIntervalS))
+	var pushClient *http.Client
+	if cfg.AgreementBot.ExchangeMessagePush {
+		mailbox.EnablePush()
+		noTimeout := uint(0)
+		pushClient = cfg.Collaborators.HTTPClientFactory.NewHTTPClient(&noTimeout)
+	}
+
 	worker := &AgreementBotWorker{
-		BaseWorker:       worker.NewBaseWorker(name, cfg, ec),
-		db:               db,
-		httpClient:       cfg.Collaborators.HTTPClientFactory.NewHTTPClient(nil),
-		consumerPH:       make(map[string]ConsumerProtocolHandler),
-		ready:            false,
-		PatternManager:   NewPatternManager(),
-		NHManager:        NewNodeHealthManager(),
-		GovTiming:        DVState{},
-		lastExchVerCheck: 0,
+		BaseWorker:         worker.NewBaseWorker(name, cfg, ec),
+		db:                 db,
+		httpClient:         cfg.Collaborators.HTTPClientFactory.NewHTTPClient(nil),
+		consumerPH:         make(map[string]ConsumerProtocolHandler),
+		ready:              false,
+		PatternManager:     NewPatternManager(),
+		BusinessPolManager: NewBusinessPolicyManager(),
+		NHManager:          NewNodeHealthManager(),
+		GovTiming:          DVState{},
+		lastExchVerCheck:   0,
+		draining:           false,
+		drained:            make(chan bool),
+		mailbox:            mailbox,
+		mailboxClient:      mailbox.NewHTTPClient(cfg.Collaborators.HTTPClientFactory.NewHTTPClient, cfg.Edge.DefaultHTTPClientTimeoutS),
+		pushClient:         pushClient,
+		dataPushes:         NewDataPushes(),
+		placementHooks:     NewPlacementHooks(cfg.AgreementBot.ExchangeId, cfg.AgreementBot.PlacementHooks, cfg.Collaborators.HTTPClientFactory),
+		quotas:             NewQuotaManager(db, cfg.AgreementBot.OrgQuotas, NewWebhookNotifier(name, cfg.AgreementBot.Webhooks, cfg.Collaborators.HTTPClientFactory.NewHTTPClient(nil))),
+		governance:         NewGovernanceMetrics(cfg.AgreementBot.GovernanceShards, cfg.AgreementBot.GovernanceShardBatch, cfg.AgreementBot.ProcessGovernanceIntervalS),
+		nodeSearches:       NewNodeSearchCache(cfg.AgreementBot.NodeSearchCacheS),
 	}
 
 	glog.Info("Starting AgreementBot worker")
@@ -77,7 +125,7 @@ func (w *AgreementBotWorker) Messages() chan events.Message {
 
 func (w *AgreementBotWorker) NewEvent(incoming events.Message) {
 
-	if w.Config.AgreementBot == (config.AGConfig{}) {
+	if reflect.DeepEqual(w.Config.AgreementBot, config.AGConfig{}) {
 		return
 	}
 
@@ -121,31 +169,15 @@ func (w *AgreementBotWorker) NewEvent(incoming events.Message) {
 			msg, _ := incoming.(*events.ABApiAgreementCancelationMessage)
 			switch msg.Event().Id {
 			case events.AGREEMENT_ENDED:
-				agCmd := NewAgreementTimeoutCommand(msg.AgreementId, msg.AgreementProtocol, w.consumerPH[msg.AgreementProtocol].GetTerminationCode(TERM_REASON_USER_REQUESTED))
+				reason := msg.Reason
+				if reason == "" {
+					reason = TERM_REASON_USER_REQUESTED
+				}
+				agCmd := NewAgreementTimeoutCommand(msg.AgreementId, msg.AgreementProtocol, w.consumerPH[msg.AgreementProtocol].GetTerminationCode(reason))
 				w.Commands <- agCmd
 			}
 		}
 
-	case *events.PolicyChangedMessage:
-		if w.ready {
-			msg, _ := incoming.(*events.PolicyChangedMessage)
-			switch msg.Event().Id {
-			case events.CHANGED_POLICY:
-				pcCmd := NewPolicyChangedCommand(*msg)
-				w.Commands <- pcCmd
-			}
-		}
-
-	case *events.PolicyDeletedMessage:
-		if w.ready {
-			msg, _ := incoming.(*events.PolicyDeletedMessage)
-			switch msg.Event().Id {
-			case events.DELETED_POLICY:
-				pdCmd := NewPolicyDeletedCommand(*msg)
-				w.Commands <- pdCmd
-			}
-		}
-
 	case *events.ABApiWorkloadUpgradeMessage:
 		if w.ready {
 			msg, _ := incoming.(*events.ABApiWorkloadUpgradeMessage)
@@ -156,6 +188,13 @@ func (w *AgreementBotWorker) NewEvent(incoming events.Message) {
 			}
 		}
 
+	case *events.AgbotShutdownMessage:
+		msg, _ := incoming.(*events.AgbotShutdownMessage)
+		switch msg.Event().Id {
+		case events.AGBOT_SHUTDOWN:
+			w.Commands <- NewAgbotShutdownCommand(msg)
+		}
+
 	case *events.NodeShutdownCompleteMessage:
 		msg, _ := incoming.(*events.NodeShutdownCompleteMessage)
 		switch msg.Event().Id {
@@ -176,7 +215,7 @@ func (w *AgreementBotWorker) Initialize() bool {
 	glog.Info("AgreementBot worker initializing")
 
 	// If there is no Agbot config, we will terminate
-	if w.Config.AgreementBot == (config.AGConfig{}) {
+	if reflect.DeepEqual(w.Config.AgreementBot, config.AGConfig{}) {
 		glog.Warningf("AgreementBotWorker terminating, no AgreementBot config.")
 		return false
 	} else if w.db == nil {
@@ -190,29 +229,60 @@ func (w *AgreementBotWorker) Initialize() bool {
 		return false
 	}
 
-	// Make sure the policy directory is in place
+	// Metering notifications are also published to an MQTT broker, if one is configured.
+	if w.Config.AgreementBot.MeteringMQTT.Broker != "" {
+		if pub, err := metering.NewMQTTPublisher(w.Config.AgreementBot.MeteringMQTT, w.GetExchangeId()); err != nil {
+			glog.Errorf(logString(fmt.Sprintf("unable to configure metering MQTT publisher, error: %v", err)))
+			return false
+		} else {
+			w.meteringMQTT = pub
+			glog.V(3).Infof(logString(fmt.Sprintf("publishing metering notifications to %v", pub)))
+		}
+	}
+
+	// Make sure the policy directory is in place. When pattern based policies are kept in memory, the policy directory
+	// is only needed for hand written policy files, so the agbot can run without it on a read-only filesystem.
+	usePolicyFiles := true
 	if err := os.MkdirAll(w.BaseWorker.Manager.Config.AgreementBot.PolicyPath, 0644); err != nil {
-		glog.Errorf("AgreementBotWorker cannot create agreement bot policy file path %v, terminating.", w.BaseWorker.Manager.Config.AgreementBot.PolicyPath)
-		return false
+		if !w.Config.AgreementBot.InMemoryPatternPolicies {
+			glog.Errorf("AgreementBotWorker cannot create agreement bot policy file path %v, terminating.", w.BaseWorker.Manager.Config.AgreementBot.PolicyPath)
+			return false
+		}
+		glog.Warningf("AgreementBotWorker cannot create agreement bot policy file path %v, only pattern based policies will be used.", w.BaseWorker.Manager.Config.AgreementBot.PolicyPath)
+		usePolicyFiles = false
 	}
 
 	// To strart clean, remove all left over pattern based policy files.
 	// This is only called once at the agbot start up time
-	if err := policy.DeleteAllPolicyFiles(w.BaseWorker.Manager.Config.AgreementBot.PolicyPath, true); err != nil {
-		glog.Errorf("AgreementBotWorker cannot clean up pattern based policy files under %v. %v", w.BaseWorker.Manager.Config.AgreementBot.PolicyPath, err)
-		return false
+	if usePolicyFiles {
+		if err := policy.DeleteAllPolicyFiles(w.BaseWorker.Manager.Config.AgreementBot.PolicyPath, true); err != nil {
+			glog.Errorf("AgreementBotWorker cannot clean up pattern based policy files under %v. %v", w.BaseWorker.Manager.Config.AgreementBot.PolicyPath, err)
+			return false
+		}
+	}
+
+	// Pattern based policies can be kept in memory so that they never have to be written to the filesystem. Policy
+	// changes are then reported directly to the agbot instead of being discovered by the policy file watcher.
+	var memoryStore *MemoryPolicyStore
+	if w.Config.AgreementBot.InMemoryPatternPolicies {
+		memoryStore = NewMemoryPolicyStore(w.Config.ArchSynonyms, w.validatePolicy, w.changedPolicy, w.deletedPolicy)
+		w.PatternManager.PolicyStore = memoryStore
+		w.BusinessPolManager.PolicyStore = memoryStore
 	}
 
 	// Give the policy manager a chance to read in all the policies. The agbot worker will not proceed past this point
 	// until it has some policies to work with.
 	for {
 
-		// Query the exchange for patterns that this agbot is supposed to serve and generate a policy for each one.
-		if agbotNotConfigured := w.GeneratePolicyFromPatterns(); agbotNotConfigured == -1 {
+		// Query the exchange for patterns and business policies that this agbot is supposed to serve and generate
+		// policies for each one.
+		if agbotNotConfigured := w.GeneratePolicyFromServedResources(); agbotNotConfigured == -1 {
 			return false
 		}
 
-		if policyManager, err := policy.Initialize(w.BaseWorker.Manager.Config.AgreementBot.PolicyPath, w.Config.ArchSynonyms, w.workloadOrServiceResolver, true, false); err != nil {
+		if policyManager, err := w.initPolicyManager(usePolicyFiles); err != nil {
+			glog.Errorf("AgreementBotWorker unable to initialize policy manager, error: %v", err)
+		} else if err := addMemoryPolicies(policyManager, memoryStore); err != nil {
 			glog.Errorf("AgreementBotWorker unable to initialize policy manager, error: %v", err)
 		} else if policyManager.NumberPolicies() != 0 {
 			w.pm = policyManager
@@ -237,7 +307,7 @@ func (w *AgreementBotWorker) Initialize() bool {
 		if policy.SupportedAgreementProtocol(protocolName) {
 			cph := CreateConsumerPH(protocolName, w.BaseWorker.Manager.Config, w.db, w.pm, w.BaseWorker.Manager.Messages)
 			cph.Initialize()
-			w.consumerPH[protocolName] = cph
+			w.addConsumerPH(protocolName, cph)
 		} else {
 			glog.Errorf("AgreementBotWorker ignoring agreement protocol %v, not supported.", protocolName)
 		}
@@ -252,8 +322,22 @@ func (w *AgreementBotWorker) Initialize() bool {
 		return false
 	}
 
+	// Repair what a crash left half done, before new agreements are made.
+	if _, err := w.checkConsistency(); err != nil {
+		glog.Errorf("AgreementBotWorker unable to check the consistency of the agreements, error: %v", err)
+	}
+
 	// The agbot worker is now ready to handle incoming messages
 	w.ready = true
+	w.maintenance = InMaintenanceMode(w.db)
+
+	// When other agbots serve the same patterns, the nodes are partitioned between them. The leases are read once
+	// before the first search, so that the partitions of peers that are already down are searched right away.
+	if len(w.Config.AgreementBot.HAPeers) != 0 {
+		w.partitions = NewPartitionManager(w.GetExchangeId(), w.Config.AgreementBot.HAPeers, w.Config.AgreementBot.HAPartitions, w.Config.AgreementBot.HALeaseS)
+		glog.Infof(AWlogString(fmt.Sprintf("partitioning the nodes with HA peers: %v", w.partitions)))
+		w.GovernPartitions()
+	}
 
 	// Start the go thread that heartbeats to the exchange
 	w.DispatchSubworker(HEARTBEAT, w.heartBeat, w.BaseWorker.Manager.Config.AgreementBot.ExchangeHeartbeat)
@@ -262,12 +346,34 @@ func (w *AgreementBotWorker) Initialize() bool {
 	w.DispatchSubworker(GOVERN_AGREEMENTS, w.GovernAgreements, int(w.BaseWorker.Manager.Config.AgreementBot.ProcessGovernanceIntervalS))
 	w.DispatchSubworker(GOVERN_ARCHIVED_AGREEMENTS, w.GovernArchivedAgreements, 1800)
 	w.DispatchSubworker(GOVERN_BC_NEEDS, w.GovernBlockchainNeeds, 60)
+	w.DispatchSubworker(GOVERN_BC_HEALTH, w.GovernBlockchainHealth, w.BaseWorker.Manager.Config.AgreementBot.BCHealthCheckIntervalS)
+	w.DispatchSubworker(GOVERN_BULK_CANCEL, w.GovernBulkCancel, 5)
+	w.DispatchSubworker(GOVERN_UPGRADE_ROLLOUT, w.GovernUpgradeRollouts, 5)
+	if w.Config.AgreementBot.ExchangeMessageWaitS > 0 || w.Config.AgreementBot.ExchangeMessagePush {
+		w.DispatchSubworker(MESSAGE_LONG_POLL, w.longPollMessages, 1)
+	}
+	if w.Config.AgreementBot.ExchangeMessagePush {
+		w.DispatchSubworker(MESSAGE_PUSH, w.pushMessages, 1)
+	}
+	if w.Config.AgreementBot.MessageKeyRotationDays > 0 {
+		w.DispatchSubworker(GOVERN_MESSAGE_KEYS, w.GovernMessageKeys, 3600)
+	}
+	if w.partitions.Enabled() {
+		// The leases are read several times per lease period, so that a peer that went down is noticed soon after its lease expires.
+		interval := w.Config.AgreementBot.HALeaseS / 4
+		if interval < 10 {
+			interval = 10
+		}
+		w.DispatchSubworker(GOVERN_PARTITIONS, w.GovernPartitions, interval)
+	}
 	if w.Config.AgreementBot.CheckUpdatedPolicyS != 0 {
 		// Use custom subworker APIs for the policy watcher because it is stateful and already does its own time management.
-		ch := w.AddSubworker(POLICY_WATCHER)
-		go w.policyWatcher(POLICY_WATCHER, ch)
+		if usePolicyFiles {
+			ch := w.AddSubworker(POLICY_WATCHER)
+			go w.policyWatcher(POLICY_WATCHER, ch)
+		}
 
-		w.DispatchSubworker(GENERATE_POLICY, w.GeneratePolicyFromPatterns, int(w.Config.AgreementBot.CheckUpdatedPolicyS))
+		w.DispatchSubworker(GENERATE_POLICY, w.GeneratePolicyFromServedResources, int(w.Config.AgreementBot.CheckUpdatedPolicyS))
 	}
 
 	return true
@@ -302,6 +408,7 @@ func (w *AgreementBotWorker) CommandHandler(command worker.Command) bool {
 			glog.V(5).Infof("AgreementBotWorker about to update policy in PM.")
 			// Update the policy in the policy manager.
 			w.pm.UpdatePolicy(cmd.Msg.Org(), pol)
+			w.nodeSearches.InvalidatePolicy(cmd.Msg.Org(), pol.Header.Name)
 			glog.V(5).Infof("AgreementBotWorker updated policy in PM.")
 
 			for _, agp := range pol.AgreementProtocols {
@@ -310,7 +417,7 @@ func (w *AgreementBotWorker) CommandHandler(command worker.Command) bool {
 					glog.V(3).Infof("AgreementBotWorker creating worker pool for new agreement protocol %v", agp.Name)
 					cph := CreateConsumerPH(agp.Name, w.BaseWorker.Manager.Config, w.db, w.pm, w.BaseWorker.Manager.Messages)
 					cph.Initialize()
-					w.consumerPH[agp.Name] = cph
+					w.addConsumerPH(agp.Name, cph)
 				}
 			}
 
@@ -335,6 +442,7 @@ func (w *AgreementBotWorker) CommandHandler(command worker.Command) bool {
 			glog.V(5).Infof("AgreementBotWorker about to delete policy from PM.")
 			// Update the policy in the policy manager.
 			w.pm.DeletePolicy(cmd.Msg.Org(), pol)
+			w.nodeSearches.InvalidatePolicy(cmd.Msg.Org(), pol.Header.Name)
 			glog.V(5).Infof("AgreementBotWorker deleted policy from PM.")
 
 			// Queue the command to the correct protocol worker pool(s) for further processing. The deleted policy
@@ -388,6 +496,10 @@ func (w *AgreementBotWorker) CommandHandler(command worker.Command) bool {
 			cph.SetBlockchainClientNotAvailable(&cmd.Msg)
 		}
 
+	case *AgbotShutdownCommand:
+		cmd, _ := command.(*AgbotShutdownCommand)
+		w.drain(cmd.Msg.Reason())
+
 	default:
 		return false
 	}
@@ -398,48 +510,38 @@ func (w *AgreementBotWorker) CommandHandler(command worker.Command) bool {
 
 func (w *AgreementBotWorker) NoWorkHandler() {
 
+	// Once the agbot is draining, it doesnt pick up any new work.
+	if w.draining {
+		return
+	}
+
 	glog.V(4).Infof("AgreementBotWorker queueing deferred commands")
 	for _, cph := range w.consumerPH {
 		cph.HandleDeferredCommands()
 	}
 	glog.V(4).Infof("AgreementBotWorker done queueing deferred commands")
 
-	glog.V(5).Infof(fmt.Sprintf("AgreementBotWorker retrieving messages from the exchange"))
+	for _, cph := range w.consumerPH {
+		cph.ResendMessages()
+	}
 
-	if msgs, err := w.getMessages(); err != nil {
-		glog.Errorf(fmt.Sprintf("AgreementBotWorker unable to retrieve exchange messages, error: %v", err))
-	} else {
-		// Loop through all the returned messages and process them
-		for _, msg := range msgs {
-
-			glog.V(3).Infof(fmt.Sprintf("AgreementBotWorker reading message %v from the exchange", msg.MsgId))
-			// First get my own keys
-			_, myPrivKey, _ := exchange.GetKeys(w.Config.AgreementBot.MessageKeyPath)
-
-			// Deconstruct and decrypt the message. Then process it.
-			if protocolMessage, receivedPubKey, err := exchange.DeconstructExchangeMessage(msg.Message, myPrivKey); err != nil {
-				glog.Errorf(fmt.Sprintf("AgreementBotWorker unable to deconstruct exchange message %v, error %v", msg, err))
-			} else if serializedPubKey, err := exchange.MarshalPublicKey(receivedPubKey); err != nil {
-				glog.Errorf(fmt.Sprintf("AgreementBotWorker unable to marshal the key from the encrypted message %v, error %v", receivedPubKey, err))
-			} else if bytes.Compare(msg.DevicePubKey, serializedPubKey) != 0 {
-				glog.Errorf(fmt.Sprintf("AgreementBotWorker sender public key from exchange %x is not the same as the sender public key in the encrypted message %x", msg.DevicePubKey, serializedPubKey))
-			} else if msgProtocol, err := abstractprotocol.ExtractProtocol(string(protocolMessage)); err != nil {
-				glog.Errorf(fmt.Sprintf("AgreementBotWorker unable to extract agreement protocol name from message %v", protocolMessage))
-			} else if _, ok := w.consumerPH[msgProtocol]; !ok {
-				glog.Infof(fmt.Sprintf("AgreementBotWorker unable to direct exchange message %v to a protocol handler, deleting it.", protocolMessage))
-				DeleteMessage(msg.MsgId, w.GetExchangeId(), w.GetExchangeToken(), w.GetExchangeURL(), w.httpClient)
-			} else {
-				cmd := NewNewProtocolMessageCommand(protocolMessage, msg.MsgId, msg.DeviceId, msg.DevicePubKey)
-				if !w.consumerPH[msgProtocol].AcceptCommand(cmd) {
-					glog.Infof(fmt.Sprintf("AgreementBotWorker protocol handler for %v not accepting exchange messages, deleting msg.", msgProtocol))
-					DeleteMessage(msg.MsgId, w.GetExchangeId(), w.GetExchangeToken(), w.GetExchangeURL(), w.httpClient)
-				} else if err := w.consumerPH[msgProtocol].DispatchProtocolMessage(cmd, w.consumerPH[msgProtocol]); err != nil {
-					DeleteMessage(msg.MsgId, w.GetExchangeId(), w.GetExchangeToken(), w.GetExchangeURL(), w.httpClient)
-				}
-			}
+	// While the mailbox is long polled or pushed, the messages are retrieved by the long poll subworker.
+	if !w.mailbox.LongPolling() && !w.mailbox.Pushing() {
+		glog.V(5).Infof(fmt.Sprintf("AgreementBotWorker retrieving messages from the exchange"))
+
+		if msgs, err := w.getMessages(w.CommandContext()); err != nil {
+			glog.Errorf(fmt.Sprintf("AgreementBotWorker unable to retrieve exchange messages, error: %v", err))
+		} else {
+			w.processMessages(msgs)
 		}
+		glog.V(5).Infof(fmt.Sprintf("AgreementBotWorker done processing messages"))
+	}
+
+	// No new agreements are made while the agbot is in maintenance mode.
+	if InMaintenanceMode(w.db) {
+		glog.V(4).Infof("AgreementBotWorker in maintenance mode, not polling exchange.")
+		return
 	}
-	glog.V(5).Infof(fmt.Sprintf("AgreementBotWorker done processing messages"))
 
 	glog.V(4).Infof("AgreementBotWorker Polling Exchange.")
 	w.findAndMakeAgreements()
@@ -447,6 +549,109 @@ func (w *AgreementBotWorker) NoWorkHandler() {
 
 }
 
+// Returns a channel that is closed when the agbot has finished draining its agreement work during a shutdown.
+func (w *AgreementBotWorker) Drained() chan bool {
+	return w.drained
+}
+
+func (w *AgreementBotWorker) addConsumerPH(protocol string, cph ConsumerProtocolHandler) {
+	w.consumerPHLock.Lock()
+	defer w.consumerPHLock.Unlock()
+	w.consumerPH[protocol] = cph
+}
+
+// Returns the state of the blockchain clients known to the protocol handlers that use a blockchain.
+func (w *AgreementBotWorker) BlockchainStates() []BlockchainReadiness {
+	w.consumerPHLock.RLock()
+	defer w.consumerPHLock.RUnlock()
+
+	states := make([]BlockchainReadiness, 0)
+	for _, cph := range w.consumerPH {
+		if csph, ok := cph.(*CSProtocolHandler); ok {
+			states = append(states, csph.BlockchainStates()...)
+		}
+	}
+	return states
+}
+
+// Returns the duration of the agreement governance passes.
+func (w *AgreementBotWorker) GovernanceStats() GovernanceStats {
+	return w.governance.Stats()
+}
+
+// Stop making new agreements, wait for the agreement workers to finish the work they are already doing and then
+// save the deferred work so that it can be retried after the agbot restarts. The agreements that are in flight
+// are already in the database, so the next agbot instance will continue to govern them.
+func (w *AgreementBotWorker) drain(reason string) {
+
+	if w.draining {
+		return
+	}
+	w.draining = true
+	glog.Infof(fmt.Sprintf("AgreementBotWorker draining agreement work, reason: %v", reason))
+
+	timeout := time.Now().Add(time.Duration(w.Config.AgreementBot.ShutdownDrainTimeoutS) * time.Second)
+	for {
+		active := 0
+		for _, cph := range w.consumerPH {
+			active += cph.ActiveWork()
+		}
+		if active == 0 {
+			break
+		} else if time.Now().After(timeout) {
+			// Stop the exchange calls of the work that is left, so that the agreement workers don't hold up the shutdown.
+			glog.Warningf(fmt.Sprintf("AgreementBotWorker timed out waiting for %v agreement work items to finish", active))
+			exchange.CancelExchangeCalls()
+			break
+		}
+		glog.V(3).Infof(fmt.Sprintf("AgreementBotWorker waiting for %v agreement work items to finish", active))
+		time.Sleep(1 * time.Second)
+	}
+
+	for _, cph := range w.consumerPH {
+		if err := cph.PersistDeferredCommands(); err != nil {
+			glog.Errorf(fmt.Sprintf("AgreementBotWorker %v", err))
+		}
+	}
+
+	glog.Infof(fmt.Sprintf("AgreementBotWorker drained"))
+	close(w.drained)
+}
+
+// Returns the devices found by a search that proposals could be made to, before the placement hooks review them.
+func (w *AgreementBotWorker) placementCandidates(devices []exchange.SearchResultDevice, consumerPolicy *policy.Policy) []exchange.SearchResultDevice {
+	candidates := make([]exchange.SearchResultDevice, 0, len(devices))
+	for _, dev := range devices {
+
+		glog.V(3).Infof("AgreementBotWorker picked up %v", dev.ShortString())
+		glog.V(5).Infof("AgreementBotWorker picked up %v", dev)
+
+		// Leave the node to the HA peer that holds the lease of its partition.
+		if !w.partitions.Owns(dev.Id) {
+			glog.V(5).Infof("AgreementBotWorker skipping device id %v, its partition %v belongs to HA peer %v", dev.Id, w.partitions.Partition(dev.Id), w.partitions.Owner(w.partitions.Partition(dev.Id)))
+			continue
+		}
+
+		// Check for agreements already in progress with this device
+		if found, err := w.alreadyMakingAgreementWith(&dev, consumerPolicy); err != nil {
+			glog.Errorf("AgreementBotWorker received error trying to find pending agreements: %v", err)
+			continue
+		} else if found {
+			glog.V(5).Infof("AgreementBotWorker skipping device id %v, agreement attempt already in progress with %v", dev.Id, consumerPolicy.Header.Name)
+			continue
+		}
+
+		// If the device is not ready to make agreements yet, then skip it.
+		if len(dev.PublicKey) == 0 || string(dev.PublicKey) == "" {
+			glog.V(5).Infof("AgreementBotWorker skipping device id %v, node is not ready to exchange messages", dev.Id)
+			continue
+		}
+
+		candidates = append(candidates, dev)
+	}
+	return candidates
+}
+
 // Search the exchange and make agreements with any device that is eligible based on the policies we have and
 // agreement protocols that we support.
 func (w *AgreementBotWorker) findAndMakeAgreements() {
@@ -454,6 +659,12 @@ func (w *AgreementBotWorker) findAndMakeAgreements() {
 	// Get a list of all the orgs we are serving
 	allOrgs := w.pm.GetAllPolicyOrgs()
 
+	// Count the agreements of each org against its quota.
+	w.quotas.BeginSearch(policy.AllAgreementProtocols())
+
+	// Forget the cached searches that nodes changed under.
+	w.checkNodeChanges()
+
 	for _, org := range allOrgs {
 		// Get a copy of all policies in the policy manager so that we can safely iterate the list
 		policies := w.pm.GetAllAvailablePolicies(org)
@@ -461,27 +672,11 @@ func (w *AgreementBotWorker) findAndMakeAgreements() {
 
 			if devices, err := w.searchExchange(&consumerPolicy, org); err != nil {
 				glog.Errorf("AgreementBotWorker received error searching for %v, error: %v", &consumerPolicy, err)
+			} else if candidates, err := w.placementHooks.Review(org, &consumerPolicy, w.placementCandidates(*devices, &consumerPolicy)); err != nil {
+				glog.Errorf("AgreementBotWorker not making proposals for %v, error: %v", consumerPolicy.Header.Name, err)
 			} else {
 
-				for _, dev := range *devices {
-
-					glog.V(3).Infof("AgreementBotWorker picked up %v", dev.ShortString())
-					glog.V(5).Infof("AgreementBotWorker picked up %v", dev)
-
-					// Check for agreements already in progress with this device
-					if found, err := w.alreadyMakingAgreementWith(&dev, &consumerPolicy); err != nil {
-						glog.Errorf("AgreementBotWorker received error trying to find pending agreements: %v", err)
-						continue
-					} else if found {
-						glog.V(5).Infof("AgreementBotWorker skipping device id %v, agreement attempt already in progress with %v", dev.Id, consumerPolicy.Header.Name)
-						continue
-					}
-
-					// If the device is not ready to make agreements yet, then skip it.
-					if len(dev.PublicKey) == 0 || string(dev.PublicKey) == "" {
-						glog.V(5).Infof("AgreementBotWorker skipping device id %v, node is not ready to exchange messages", dev.Id)
-						continue
-					}
+				for _, dev := range candidates {
 
 					// The only reason for no microservices in the device search result is because the search was pattern based.
 					// In this case there will not be any policies from the producer side to work with. The agbot assumes that
@@ -525,6 +720,9 @@ func (w *AgreementBotWorker) findAndMakeAgreements() {
 
 					if _, ok := w.consumerPH[protocol]; !ok {
 						glog.Errorf("AgreementBotWorker unable to find protocol handler for %v.", protocol)
+					} else if bcType != "" && IsBlockchainBlacklisted(w.db, bcOrg, bcType, bcName) {
+						glog.V(5).Infof("AgreementBotWorker skipping device id %v, requires blacklisted blockchain %v %v %v.", dev.Id, bcType, bcName, bcOrg)
+						continue
 					} else if bcType != "" && !w.consumerPH[protocol].IsBlockchainWritable(bcType, bcName, bcOrg) {
 						// Get that blockchain running if it isn't up.
 						glog.V(5).Infof("AgreementBotWorker skipping device id %v, requires blockchain %v %v %v that isnt ready yet.", dev.Id, bcType, bcName, bcOrg)
@@ -532,6 +730,9 @@ func (w *AgreementBotWorker) findAndMakeAgreements() {
 						continue
 					} else if !w.consumerPH[protocol].AcceptCommand(cmd) {
 						glog.Errorf("AgreementBotWorker protocol handler for %v not accepting new agreement commands.", protocol)
+					} else if !w.quotas.Allow(org) {
+						glog.V(5).Infof("AgreementBotWorker skipping device id %v, org %v is over its quota.", dev.Id, org)
+						continue
 					} else {
 						w.consumerPH[protocol].HandleMakeAgreement(cmd, w.consumerPH[protocol])
 						glog.V(5).Infof("AgreementBoWorker queued agreement attempt for policy %v and protocol %v", consumerPolicy.Header.Name, protocol)
@@ -615,13 +816,44 @@ func (w *AgreementBotWorker) policyWatcher(name string, quit chan bool) {
 
 }
 
+// Create the policy manager, reading in the policy files if there is a policy directory.
+func (w *AgreementBotWorker) initPolicyManager(usePolicyFiles bool) (*policy.PolicyManager, error) {
+	if !usePolicyFiles {
+		return policy.PolicyManager_Factory(true, false), nil
+	}
+	return policy.Initialize(w.BaseWorker.Manager.Config.AgreementBot.PolicyPath, w.Config.ArchSynonyms, w.workloadOrServiceResolver, true, false)
+}
+
+// Add the pattern based policies held in memory to the policy manager. The policy file watcher only finds the
+// policies that are in the filesystem.
+func addMemoryPolicies(pm *policy.PolicyManager, store *MemoryPolicyStore) error {
+	if store == nil {
+		return nil
+	}
+	for org, policies := range store.GetAllPolicies() {
+		for _, pol := range policies {
+			p := pol
+			if err := pm.AddPolicy(org, &p); err != nil {
+				return errors.New(fmt.Sprintf("unable to add policy %v for org %v, error: %v", p.Header.Name, org, err))
+			}
+		}
+	}
+	return nil
+}
+
+func (w *AgreementBotWorker) validatePolicy(pol *policy.Policy) error {
+	return pol.Is_Self_Consistent(nil, w.workloadOrServiceResolver)
+}
+
 // Functions called by the policy watcher
+// Policy changes are queued straight onto this worker's commands instead of being sent as events, because the
+// events are delivered to every worker, and the agbots of federated exchanges each have their own policies.
 func (w *AgreementBotWorker) changedPolicy(org string, fileName string, pol *policy.Policy) {
 	glog.V(3).Infof(fmt.Sprintf("AgreementBotWorker detected changed policy file %v containing %v", fileName, pol))
 	if policyString, err := policy.MarshalPolicy(pol); err != nil {
 		glog.Errorf(fmt.Sprintf("AgreementBotWorker error trying to marshal policy %v error: %v", pol, err))
-	} else {
-		w.Messages() <- events.NewPolicyChangedMessage(events.CHANGED_POLICY, fileName, pol.Header.Name, org, policyString)
+	} else if w.ready {
+		w.Commands <- NewPolicyChangedCommand(*events.NewPolicyChangedMessage(events.CHANGED_POLICY, fileName, pol.Header.Name, org, policyString))
 	}
 }
 
@@ -629,8 +861,8 @@ func (w *AgreementBotWorker) deletedPolicy(org string, fileName string, pol *pol
 	glog.V(3).Infof(fmt.Sprintf("AgreementBotWorker detected deleted policy file %v containing %v", fileName, pol))
 	if policyString, err := policy.MarshalPolicy(pol); err != nil {
 		glog.Errorf(fmt.Sprintf("AgreementBotWorker error trying to marshal policy %v error: %v", pol, err))
-	} else {
-		w.Messages() <- events.NewPolicyDeletedMessage(events.DELETED_POLICY, fileName, pol.Header.Name, org, policyString)
+	} else if w.ready {
+		w.Commands <- NewPolicyDeletedCommand(*events.NewPolicyDeletedMessage(events.DELETED_POLICY, fileName, pol.Header.Name, org, policyString))
 	}
 }
 
@@ -638,12 +870,97 @@ func (w *AgreementBotWorker) errorPolicy(org string, fileName string, err error)
 	glog.Errorf(fmt.Sprintf("AgreementBotWorker tried to read policy file %v/%v, encountered error: %v", org, fileName, err))
 }
 
-func (w *AgreementBotWorker) getMessages() ([]exchange.AgbotMessage, error) {
+// Process the messages retrieved from the agbot's mailbox, by handing each one to the protocol handler it is for.
+func (w *AgreementBotWorker) processMessages(msgs []exchange.AgbotMessage) {
+	// Loop through all the returned messages and process them
+	for _, msg := range msgs {
+
+		glog.V(3).Infof(fmt.Sprintf("AgreementBotWorker reading message %v from the exchange", msg.MsgId))
+
+		// Test builds can simulate a message that is lost in the mailbox.
+		if faults.Inject(faults.MAILBOX_DROP) {
+			DeleteMessage(msg.MsgId, w.GetExchangeId(), w.GetExchangeToken(), exchange.AgbotMessageTransport(w.Config), w.httpClient)
+			continue
+		}
+
+		// First get my own keys
+		_, myPrivKey, _ := exchange.GetKeys(w.Config.AgreementBot.MessageKeyPath)
+
+		// Deconstruct and decrypt the message. Then process it.
+		if protocolMessage, receivedPubKey, err := exchange.DeconstructExchangeMessage(msg.Message, myPrivKey); err != nil {
+			glog.Errorf(fmt.Sprintf("AgreementBotWorker unable to deconstruct exchange message %v, error %v", msg, err))
+		} else if serializedPubKey, err := exchange.MarshalPublicKey(receivedPubKey); err != nil {
+			glog.Errorf(fmt.Sprintf("AgreementBotWorker unable to marshal the key from the encrypted message %v, error %v", receivedPubKey, err))
+		} else if bytes.Compare(msg.DevicePubKey, serializedPubKey) != 0 {
+			glog.Errorf(fmt.Sprintf("AgreementBotWorker sender public key from exchange %x is not the same as the sender public key in the encrypted message %x", msg.DevicePubKey, serializedPubKey))
+		} else if msgProtocol, err := abstractprotocol.ExtractProtocol(string(protocolMessage)); err != nil {
+			glog.Errorf(fmt.Sprintf("AgreementBotWorker unable to extract agreement protocol name from message %v", protocolMessage))
+		} else if _, ok := w.consumerPH[msgProtocol]; !ok {
+			glog.Infof(fmt.Sprintf("AgreementBotWorker unable to direct exchange message %v to a protocol handler, deleting it.", protocolMessage))
+			DeleteMessage(msg.MsgId, w.GetExchangeId(), w.GetExchangeToken(), exchange.AgbotMessageTransport(w.Config), w.httpClient)
+		} else {
+			// A node that sends sealed messages can read them.
+			if exchange.IsSealedExchangeMessage(msg.Message) {
+				exchange.SetMessageSealing(msg.DeviceId, true)
+			}
+			cmd := NewNewProtocolMessageCommand(protocolMessage, msg.MsgId, msg.DeviceId, msg.DevicePubKey)
+			if !w.consumerPH[msgProtocol].AcceptCommand(cmd) {
+				glog.Infof(fmt.Sprintf("AgreementBotWorker protocol handler for %v not accepting exchange messages, deleting msg.", msgProtocol))
+				DeleteMessage(msg.MsgId, w.GetExchangeId(), w.GetExchangeToken(), exchange.AgbotMessageTransport(w.Config), w.httpClient)
+			} else if err := w.consumerPH[msgProtocol].DispatchProtocolMessage(cmd, w.consumerPH[msgProtocol]); err != nil {
+				DeleteMessage(msg.MsgId, w.GetExchangeId(), w.GetExchangeToken(), exchange.AgbotMessageTransport(w.Config), w.httpClient)
+			}
+		}
+	}
+}
+
+// Long poll the agbot's mailbox so that messages are processed as soon as they arrive, instead of when the
+// NoWorkHandler runs next. While the push channel of the mailbox is connected, the mailbox is fetched when the
+// exchange notifies a message instead. While the exchange supports neither, this subworker leaves the messages
+// to the NoWorkHandler and checks back once a minute.
+func (w *AgreementBotWorker) longPollMessages() int {
+
+	if w.draining {
+		return 60
+	} else if w.mailbox.Pushing() {
+		w.mailbox.WaitForPush()
+	} else if !w.mailbox.LongPolling() {
+		return 60
+	}
+
+	if msgs, err := w.getMessages(w.Context()); err != nil {
+		glog.Errorf(fmt.Sprintf("AgreementBotWorker unable to long poll exchange messages, error: %v", err))
+		return 10
+	} else {
+		w.processMessages(msgs)
+	}
+	return 1
+}
+
+// Hold the push channel of the agbot's mailbox open, and open it again when it closes.
+func (w *AgreementBotWorker) pushMessages() int {
+	if w.draining || !w.mailbox.PushDue() {
+		return exchange.MAILBOX_PUSH_RECONNECT_S
+	}
+
+	mailboxURL := exchange.AgbotMessageTransport(w.Config).AgbotMailboxURL(w.GetExchangeId())
+	if err := w.mailbox.Subscribe(w.Context(), w.pushClient, mailboxURL, w.GetExchangeId(), w.GetExchangeToken()); err != nil {
+		glog.V(3).Infof(fmt.Sprintf("AgreementBotWorker mailbox push channel closed, error: %v", err))
+	}
+	return exchange.MAILBOX_PUSH_RECONNECT_S
+}
+
+func (w *AgreementBotWorker) getMessages(ctx context.Context) ([]exchange.AgbotMessage, error) {
 	var resp interface{}
 	resp = new(exchange.GetAgbotMessageResponse)
-	targetURL := w.GetExchangeURL() + "orgs/" + exchange.GetOrg(w.GetExchangeId()) + "/agbots/" + exchange.GetId(w.GetExchangeId()) + "/msgs"
+	mailboxURL := exchange.AgbotMessageTransport(w.Config).AgbotMailboxURL(w.GetExchangeId())
 	for {
-		if err, tpErr := exchange.InvokeExchange(w.httpClient, "GET", targetURL, w.GetExchangeId(), w.GetExchangeToken(), nil, &resp); err != nil {
+		targetURL, waitS := w.mailbox.FetchURL(mailboxURL)
+		started := time.Now()
+		if err, tpErr := exchange.InvokeExchangeWithContext(ctx, w.mailboxClient, "GET", targetURL, w.GetExchangeId(), w.GetExchangeToken(), nil, &resp); err != nil {
+			if w.mailbox.Fetched(waitS, started, 0, err) {
+				continue
+			}
 			glog.Errorf(err.Error())
 			return nil, err
 		} else if tpErr != nil {
@@ -651,8 +968,9 @@ func (w *AgreementBotWorker) getMessages() ([]exchange.AgbotMessage, error) {
 			time.Sleep(10 * time.Second)
 			continue
 		} else {
-			glog.V(3).Infof(fmt.Sprintf("AgreementBotWorker retrieved %v messages", len(resp.(*exchange.GetAgbotMessageResponse).Messages)))
 			msgs := resp.(*exchange.GetAgbotMessageResponse).Messages
+			w.mailbox.Fetched(waitS, started, len(msgs), nil)
+			glog.V(3).Infof(fmt.Sprintf("AgreementBotWorker retrieved %v messages", len(msgs)))
 			return msgs, nil
 		}
 	}
@@ -682,16 +1000,19 @@ func RetrieveAllProperties(version string, arch string, pol *policy.Policy) (*po
 func DeleteConsumerAgreement(httpClient *http.Client, url string, agbotId string, token string, agreementId string) error {
 
 	logString := func(v interface{}) string {
+		if cutil.StructuredLogging() {
+			return cutil.LogString("AgreementBot Governance", cutil.LogFields{cutil.LOG_AGREEMENT_ID: agreementId}, v)
+		}
 		return fmt.Sprintf("AgreementBot Governance: %v", v)
 	}
 
-	glog.V(5).Infof(logString(fmt.Sprintf("deleting agreement %v in exchange", agreementId)))
+	cutil.TraceV(5, agreementId, "").Infof(logString(fmt.Sprintf("deleting agreement %v in exchange", agreementId)))
 
 	var resp interface{}
 	resp = new(exchange.PostDeviceResponse)
 	targetURL := url + "orgs/" + exchange.GetOrg(agbotId) + "/agbots/" + exchange.GetId(agbotId) + "/agreements/" + agreementId
 	for {
-		if err, tpErr := exchange.InvokeExchange(httpClient, "DELETE", targetURL, agbotId, token, nil, &resp); err != nil && !strings.Contains(err.Error(), "not found") {
+		if err, tpErr := exchange.InvokeExchange(httpClient, "DELETE", targetURL, agbotId, token, nil, &resp); err != nil && !anaxerrors.Is(err, anaxerrors.EXCHANGE_NOT_FOUND) {
 			glog.Errorf(logString(fmt.Sprintf(err.Error())))
 			return err
 		} else if tpErr != nil {
@@ -706,10 +1027,10 @@ func DeleteConsumerAgreement(httpClient *http.Client, url string, agbotId string
 
 }
 
-func DeleteMessage(msgId int, agbotId, agbotToken, exchangeURL string, httpClient *http.Client) error {
+func DeleteMessage(msgId int, agbotId, agbotToken string, transport *exchange.MessageTransport, httpClient *http.Client) error {
 	var resp interface{}
 	resp = new(exchange.PostDeviceResponse)
-	targetURL := exchangeURL + "orgs/" + exchange.GetOrg(agbotId) + "/agbots/" + exchange.GetId(agbotId) + "/msgs/" + strconv.Itoa(msgId)
+	targetURL := transport.AgbotMailboxURL(agbotId) + "/" + strconv.Itoa(msgId)
 	for {
 		if err, tpErr := exchange.InvokeExchange(httpClient, "DELETE", targetURL, agbotId, agbotToken, nil, &resp); err != nil {
 			glog.Errorf(err.Error())
@@ -719,7 +1040,7 @@ func DeleteMessage(msgId int, agbotId, agbotToken, exchangeURL string, httpClien
 			time.Sleep(10 * time.Second)
 			continue
 		} else {
-			glog.V(3).Infof("Deleted exchange message %v", msgId)
+			glog.V(3).Infof("Deleted %v message %v", transport.Name, msgId)
 			return nil
 		}
 	}
@@ -734,11 +1055,63 @@ func DeleteMessage(msgId int, agbotId, agbotToken, exchangeURL string, httpClien
 // microservices. If the agbot is working with a policy file that was generated from a pattern, then it will do searches
 // by pattern. If the agbot is working with a manually created policy file, then it will do searches by list of
 // microservices.
+//
+// The devices found by a recent search of the policy are used instead of searching again, when they are cached.
 func (w *AgreementBotWorker) searchExchange(pol *policy.Policy, searchOrg string) (*[]exchange.SearchResultDevice, error) {
 
-	// If it is a pattern based policy, search by worload URL and pattern.
-	if pol.PatternId != "" {
+	if devices, ok := w.nodeSearches.Get(searchOrg, pol.Header.Name); ok {
+		glog.V(5).Infof("AgreementBotWorker using %v cached devices for policy %v.", len(devices), pol.Header.Name)
+		return &devices, nil
+	}
 
+	devices, err := w.searchExchangeNodes(pol, searchOrg)
+	if err == nil {
+		// The nodes of a business policy are in the node orgs it is served for, the other searches are in one org.
+		orgs := []string{searchOrg}
+		if pol.BusinessPolId != "" {
+			orgs = w.BusinessPolManager.GetServedNodeOrgs(exchange.GetOrg(pol.BusinessPolId), exchange.GetId(pol.BusinessPolId))
+		}
+		w.nodeSearches.Put(searchOrg, pol.Header.Name, orgs, *devices)
+	}
+	return devices, err
+}
+
+func (w *AgreementBotWorker) searchExchangeNodes(pol *policy.Policy, searchOrg string) (*[]exchange.SearchResultDevice, error) {
+
+	// If it is a business policy based policy, search for the nodes without a pattern in the node orgs that the business
+	// policy is served for. Their node policies are matched with the business policy once the agreement attempt is on
+	// an agreement worker.
+	if pol.BusinessPolId != "" {
+
+		ser := exchange.CreateSearchBusinessPolRequest()
+		ser.SecondsStale = w.Config.AgreementBot.ActiveDeviceTimeoutS
+		ser.NodeOrgIds = w.BusinessPolManager.GetServedNodeOrgs(exchange.GetOrg(pol.BusinessPolId), exchange.GetId(pol.BusinessPolId))
+
+		var resp interface{}
+		resp = new(exchange.SearchExchBusinessPolResponse)
+		targetURL := w.GetExchangeURL() + "orgs/" + exchange.GetOrg(pol.BusinessPolId) + "/business/policies/" + exchange.GetId(pol.BusinessPolId) + "/search"
+		for {
+			if err, tpErr := exchange.InvokeExchangeWithContext(w.CommandContext(), w.httpClient, "POST", targetURL, w.GetExchangeId(), w.GetExchangeToken(), ser, &resp); err != nil {
+				if !anaxerrors.Is(err, anaxerrors.EXCHANGE_NOT_FOUND) {
+					return nil, err
+				} else {
+					empty := make([]exchange.SearchResultDevice, 0, 0)
+					return &empty, nil
+				}
+			} else if tpErr != nil {
+				glog.Warningf(tpErr.Error())
+				time.Sleep(10 * time.Second)
+				continue
+			} else {
+				glog.V(3).Infof("AgreementBotWorker found %v devices in exchange for business policy %v.", len(resp.(*exchange.SearchExchBusinessPolResponse).Devices), pol.BusinessPolId)
+				dev := resp.(*exchange.SearchExchBusinessPolResponse).Devices
+				return &dev, nil
+			}
+		}
+
+	} else if pol.PatternId != "" {
+
+		// If it is a pattern based policy, search by worload URL and pattern.
 		// Setup the search request body
 		ser := exchange.CreateSearchPatternRequest()
 		ser.SecondsStale = w.Config.AgreementBot.ActiveDeviceTimeoutS
@@ -753,8 +1126,8 @@ func (w *AgreementBotWorker) searchExchange(pol *policy.Policy, searchOrg string
 		resp = new(exchange.SearchExchangePatternResponse)
 		targetURL := w.GetExchangeURL() + "orgs/" + searchOrg + "/patterns/" + exchange.GetId(pol.PatternId) + "/search"
 		for {
-			if err, tpErr := exchange.InvokeExchange(w.httpClient, "POST", targetURL, w.GetExchangeId(), w.GetExchangeToken(), ser, &resp); err != nil {
-				if !strings.Contains(err.Error(), "status: 404") {
+			if err, tpErr := exchange.InvokeExchangeWithContext(w.CommandContext(), w.httpClient, "POST", targetURL, w.GetExchangeId(), w.GetExchangeToken(), ser, &resp); err != nil {
+				if !anaxerrors.Is(err, anaxerrors.EXCHANGE_NOT_FOUND) {
 					return nil, err
 				} else {
 					empty := make([]exchange.SearchResultDevice, 0, 0)
@@ -819,8 +1192,8 @@ func (w *AgreementBotWorker) searchExchange(pol *policy.Policy, searchOrg string
 		resp = new(exchange.SearchExchangeMSResponse)
 		targetURL := w.GetExchangeURL() + "orgs/" + searchOrg + "/search/nodes"
 		for {
-			if err, tpErr := exchange.InvokeExchange(w.httpClient, "POST", targetURL, w.GetExchangeId(), w.GetExchangeToken(), ser, &resp); err != nil {
-				if !strings.Contains(err.Error(), "status: 404") {
+			if err, tpErr := exchange.InvokeExchangeWithContext(w.CommandContext(), w.httpClient, "POST", targetURL, w.GetExchangeId(), w.GetExchangeToken(), ser, &resp); err != nil {
+				if !anaxerrors.Is(err, anaxerrors.EXCHANGE_NOT_FOUND) {
 					return nil, err
 				} else {
 					empty := make([]exchange.SearchResultDevice, 0, 0)
@@ -839,6 +1212,47 @@ func (w *AgreementBotWorker) searchExchange(pol *policy.Policy, searchOrg string
 	}
 }
 
+// Drop the cached searches of the orgs in which a node changed since the last check, according to the exchange change
+// feed. The first check of an org only finds its position in the feed, the searches cached before then are dropped.
+// When the exchange does not have a change feed, the cached searches are kept until they expire.
+func (w *AgreementBotWorker) checkNodeChanges() {
+
+	for _, org := range w.nodeSearches.NodeOrgs() {
+
+		since := w.nodeSearches.GetChangeId(org)
+		maxRecords := 0
+		if since == 0 {
+			maxRecords = 1
+		}
+
+		changes, err := exchange.GetOrgChanges(w.Config.Collaborators.HTTPClientFactory, org, exchange.CHANGE_RESOURCE_NODE, since, maxRecords, w.GetExchangeURL(), w.GetExchangeId(), w.GetExchangeToken())
+		if err != nil {
+			glog.Warningf(AWlogString(fmt.Sprintf("unable to get node changes for org %v, searching its nodes again, error %v", org, err)))
+			w.nodeSearches.InvalidateNodeOrg(org)
+			continue
+		} else if changes == nil {
+			continue
+		}
+
+		changed := since == 0 || changes.Exhausted
+		for _, change := range changes.Changes {
+			if change.Resource == exchange.CHANGE_RESOURCE_NODE {
+				changed = true
+			}
+		}
+		if changed {
+			glog.V(5).Infof(AWlogString(fmt.Sprintf("nodes changed in org %v since %v, searching its nodes again", org, since)))
+			w.nodeSearches.InvalidateNodeOrg(org)
+		}
+
+		if since == 0 || changes.Exhausted {
+			w.nodeSearches.SetChangeId(org, changes.MostRecentChangeId)
+		} else {
+			w.nodeSearches.SetChangeId(org, changes.LastChangeId())
+		}
+	}
+}
+
 func (w *AgreementBotWorker) makeNewMSSearchElement(specRef string, org string, version string, arch string, pol *policy.Policy) (*exchange.Microservice, error) {
 	newMS := new(exchange.Microservice)
 	newMS.Url = specRef
@@ -861,6 +1275,10 @@ func (w *AgreementBotWorker) makeNewMSSearchElement(specRef string, org string,
 func (w *AgreementBotWorker) syncOnInit() error {
 	glog.V(3).Infof(AWlogString("beginning sync up."))
 
+	// Agreements are not cancelled because their policy changed while the agbot is in maintenance mode. They are
+	// checked again when maintenance mode ends.
+	maintenance := InMaintenanceMode(w.db)
+
 	// Search all agreement protocol buckets
 	for _, agp := range policy.AllAgreementProtocols() {
 
@@ -889,7 +1307,9 @@ func (w *AgreementBotWorker) syncOnInit() error {
 				if ag.AgreementCreationTime != 0 {
 					if pol, err := policy.DemarshalPolicy(ag.Policy); err != nil {
 						glog.Errorf(AWlogString(fmt.Sprintf("unable to demarshal policy for agreement %v, error %v", ag.CurrentAgreementId, err)))
-					} else if existingPol := w.pm.GetPolicy(ag.Org, pol.Header.Name); existingPol == nil {
+					} else if existingPol := w.pm.GetPolicy(ag.Org, pol.Header.Name); existingPol == nil && maintenance {
+						glog.Warningf(AWlogString(fmt.Sprintf("agreement %v has a policy %v that doesn't exist anymore, keeping it during maintenance mode", ag.CurrentAgreementId, pol.Header.Name)))
+					} else if existingPol == nil {
 						glog.Errorf(AWlogString(fmt.Sprintf("agreement %v has a policy %v that doesn't exist anymore", ag.CurrentAgreementId, pol.Header.Name)))
 						// Update state in exchange
 						if err := DeleteConsumerAgreement(w.Config.Collaborators.HTTPClientFactory.NewHTTPClient(nil), w.GetExchangeURL(), w.GetExchangeId(), w.GetExchangeToken(), ag.CurrentAgreementId); err != nil {
@@ -904,7 +1324,7 @@ func (w *AgreementBotWorker) syncOnInit() error {
 							glog.Errorf(AWlogString(fmt.Sprintf("error marking agreement %v terminated: %v", ag.CurrentAgreementId, err)))
 						}
 						w.consumerPH[agp].HandleAgreementTimeout(NewAgreementTimeoutCommand(ag.CurrentAgreementId, ag.AgreementProtocol, w.consumerPH[agp].GetTerminationCode(TERM_REASON_POLICY_CHANGED)), w.consumerPH[agp])
-					} else if err := w.pm.MatchesMine(ag.Org, pol); err != nil {
+					} else if err := w.pm.MatchesMine(ag.Org, pol); err != nil && !maintenance {
 						glog.Warningf(AWlogString(fmt.Sprintf("agreement %v has a policy %v that has changed: %v", ag.CurrentAgreementId, pol.Header.Name, err)))
 
 						// Remove any workload usage records (non-HA) or mark for pending upgrade (HA). There might not be a workload usage record
@@ -982,6 +1402,10 @@ func (w *AgreementBotWorker) syncOnInit() error {
 			for org, typeMap := range neededBCInstances {
 				for typeName, instMap := range typeMap {
 					for instName, _ := range instMap {
+						if IsBlockchainBlacklisted(w.db, org, typeName, instName) {
+							glog.Warningf(AWlogString(fmt.Sprintf("not starting a client for blacklisted blockchain %v/%v/%v", org, typeName, instName)))
+							continue
+						}
 						w.Messages() <- events.NewNewBCContainerMessage(events.NEW_BC_CLIENT, typeName, instName, org, w.GetExchangeURL(), w.GetExchangeId(), w.GetExchangeToken())
 					}
 				}
@@ -1086,6 +1510,26 @@ func (w *AgreementBotWorker) registerPublicKey() error {
 	}
 }
 
+// Rotate the messaging keys once they are older than the configured number of days, and publish the new public key.
+// The nodes encrypt their messages to the key that the exchange has for the agbot, so the messages that are already
+// in the mailbox are read with the previous key.
+func (w *AgreementBotWorker) GovernMessageKeys() int {
+
+	keyPath := w.Config.AgreementBot.MessageKeyPath
+	if created, err := exchange.KeysCreated(keyPath); err != nil {
+		glog.Errorf(AWlogString(fmt.Sprintf("unable to get the age of the messaging keys, error: %v", err)))
+	} else if time.Since(created) < time.Duration(w.Config.AgreementBot.MessageKeyRotationDays)*24*time.Hour {
+		return 0
+	} else if _, _, err := exchange.RotateKeys(keyPath); err != nil {
+		glog.Errorf(AWlogString(fmt.Sprintf("unable to rotate the messaging keys, error: %v", err)))
+	} else if err := w.registerPublicKey(); err != nil {
+		glog.Errorf(AWlogString(fmt.Sprintf("unable to publish the rotated messaging key, error: %v", err)))
+	} else {
+		glog.Infof(AWlogString(fmt.Sprintf("rotated the messaging keys")))
+	}
+	return 0
+}
+
 func (w *AgreementBotWorker) workloadOrServiceResolver(wURL string, wOrg string, wVersion string, wArch string) (*policy.APISpecList, error) {
 
 	asl, _, err := exchange.GetHTTPWorkloadOrServiceResolverHandler(w)(wURL, wOrg, wVersion, wArch)
@@ -1095,17 +1539,24 @@ func (w *AgreementBotWorker) workloadOrServiceResolver(wURL string, wOrg string,
 	return asl, err
 }
 
-// Generate policy files based on pattern metadata in the exchange. The return value from this function is
-// the length of time the caller should wait before calling again. If -1 is returned, there was an error.
-func (w *AgreementBotWorker) GeneratePolicyFromPatterns() int {
+// Generate policy files based on the pattern and business policy metadata in the exchange. The return value from
+// this function is the length of time the caller should wait before calling again. If -1 is returned, there was an error.
+func (w *AgreementBotWorker) GeneratePolicyFromServedResources() int {
 
 	glog.V(5).Infof(AWlogString(fmt.Sprintf("scanning patterns for updates")))
 	if err := w.internalGeneratePolicyFromPatterns(); err != nil {
 		glog.Errorf(AWlogString(fmt.Sprintf("unable to process patterns, error %v", err)))
 		return -1
 	}
-
 	glog.V(5).Infof(AWlogString(fmt.Sprintf("pattern manager initialized: %v", w.PatternManager.ShortString())))
+
+	glog.V(5).Infof(AWlogString(fmt.Sprintf("scanning business policies for updates")))
+	if err := w.internalGeneratePolicyFromBusinessPols(); err != nil {
+		glog.Errorf(AWlogString(fmt.Sprintf("unable to process business policies, error %v", err)))
+		return -1
+	}
+	glog.V(5).Infof(AWlogString(fmt.Sprintf("business policy manager initialized: %v", w.BusinessPolManager.ShortString())))
+
 	return 0
 }
 
@@ -1122,6 +1573,9 @@ func (w *AgreementBotWorker) internalGeneratePolicyFromPatterns() error {
 		return errors.New(fmt.Sprintf("unable to retrieve agbot pattern metadata, error %v", err))
 	}
 
+	// Leave out the patterns of the orgs that are over their served patterns quota.
+	pats = w.quotas.LimitServedPatterns(pats)
+
 	// Consume the configured org/pattern pairs into the PatternManager
 	if err := w.PatternManager.SetCurrentPatterns(pats, w.Config.AgreementBot.PolicyPath); err != nil {
 		return errors.New(fmt.Sprintf("unable to process agbot served patterns metadata %v, error %v", pats, err))
@@ -1138,11 +1592,19 @@ func (w *AgreementBotWorker) internalGeneratePolicyFromPatterns() error {
 			// org does not exist is returned as an error
 			glog.V(5).Infof(AWlogString(fmt.Sprintf("unable to get organization %v: %v", org, err)))
 			exchangePatternMetadata = make(map[string]exchange.Pattern)
-		} else {
-			// Query exchange for all patterns in the org
-			if exchangePatternMetadata, err = exchange.GetPatterns(w.Config.Collaborators.HTTPClientFactory, org, "", w.GetExchangeURL(), w.GetExchangeId(), w.GetExchangeToken()); err != nil {
-				return errors.New(fmt.Sprintf("unable to get patterns for org %v, error %v", org, err))
+		} else if !w.PatternManager.NeedsFullSync(org, w.Config.AgreementBot.PatternFullResyncS) {
+			// Only the patterns that changed since the last time through need to be read.
+			if handled, err := w.updateChangedPatterns(org); err != nil {
+				return err
+			} else if handled {
+				continue
 			}
+			exchangePatternMetadata, err = w.getAllPatterns(org)
+			if err != nil {
+				return err
+			}
+		} else if exchangePatternMetadata, err = w.getAllPatterns(org); err != nil {
+			return err
 		}
 
 		// Check for pattern metadata changes and update policy files accordingly
@@ -1155,6 +1617,128 @@ func (w *AgreementBotWorker) internalGeneratePolicyFromPatterns() error {
 
 }
 
+// Read all the patterns in the org. The position in the org's change feed is captured first so that a change that
+// happens while the patterns are being read is picked up next time.
+func (w *AgreementBotWorker) getAllPatterns(org string) (map[string]exchange.Pattern, error) {
+
+	changeId := uint64(0)
+	if changes, err := exchange.GetOrgChanges(w.Config.Collaborators.HTTPClientFactory, org, exchange.CHANGE_RESOURCE_PATTERN, 0, 1, w.GetExchangeURL(), w.GetExchangeId(), w.GetExchangeToken()); err != nil {
+		glog.Warningf(AWlogString(fmt.Sprintf("unable to get pattern changes for org %v, error %v", org, err)))
+	} else if changes != nil {
+		changeId = changes.MostRecentChangeId
+	}
+
+	pats, err := exchange.GetPatterns(w.Config.Collaborators.HTTPClientFactory, org, "", w.GetExchangeURL(), w.GetExchangeId(), w.GetExchangeToken())
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to get patterns for org %v, error %v", org, err))
+	}
+
+	w.PatternManager.SetChangeId(org, changeId, true)
+	return pats, nil
+}
+
+// Use the exchange change feed to update only the patterns that changed in the org. Returns false when the change
+// feed cannot be used, in which case the caller has to read all the patterns in the org.
+func (w *AgreementBotWorker) updateChangedPatterns(org string) (bool, error) {
+
+	since := w.PatternManager.GetChangeId(org)
+	changes, err := exchange.GetOrgChanges(w.Config.Collaborators.HTTPClientFactory, org, exchange.CHANGE_RESOURCE_PATTERN, since, 0, w.GetExchangeURL(), w.GetExchangeId(), w.GetExchangeToken())
+	if err != nil {
+		glog.Warningf(AWlogString(fmt.Sprintf("unable to get pattern changes for org %v, falling back to a full resync, error %v", org, err)))
+		return false, nil
+	} else if changes == nil || changes.Exhausted {
+		glog.V(3).Infof(AWlogString(fmt.Sprintf("pattern changes for org %v are not available since %v, falling back to a full resync", org, since)))
+		return false, nil
+	}
+
+	// Read the current definition of each pattern that changed. A pattern that has been deleted in the meantime is
+	// simply not returned.
+	changedPatterns := make(map[string]exchange.Pattern)
+	for _, change := range changes.Changes {
+		if change.Resource != exchange.CHANGE_RESOURCE_PATTERN || change.Operation == exchange.CHANGE_OPERATION_DELETED {
+			continue
+		} else if _, ok := changedPatterns[change.Id]; ok {
+			continue
+		} else if pats, err := exchange.GetPatterns(w.Config.Collaborators.HTTPClientFactory, org, exchange.GetId(change.Id), w.GetExchangeURL(), w.GetExchangeId(), w.GetExchangeToken()); err != nil {
+			return false, errors.New(fmt.Sprintf("unable to get pattern %v, error %v", change.Id, err))
+		} else {
+			for patternId, pattern := range pats {
+				changedPatterns[patternId] = pattern
+			}
+		}
+	}
+
+	if err := w.PatternManager.UpdateChangedPatternPolicies(org, changes.Changes, changedPatterns, w.Config.AgreementBot.PolicyPath); err != nil {
+		return false, errors.New(fmt.Sprintf("unable to update changed policies for org %v, error %v", org, err))
+	}
+
+	w.PatternManager.SetChangeId(org, changes.LastChangeId(), false)
+	glog.V(5).Infof(AWlogString(fmt.Sprintf("applied %v pattern changes for org %v", len(changes.Changes), org)))
+	return true, nil
+}
+
+// Generate policy files based on the business policy metadata in the exchange. This works the same way as the pattern
+// based generation, except that business policies are always read in full.
+func (w *AgreementBotWorker) internalGeneratePolicyFromBusinessPols() error {
+
+	// Get the business policies this agbot is configured to serve.
+	pols, err := w.getAgbotBusinessPols()
+	if err != nil {
+		return errors.New(fmt.Sprintf("unable to retrieve agbot business policy metadata, error %v", err))
+	}
+
+	if err := w.BusinessPolManager.SetCurrentBusinessPolicies(pols, w.Config.AgreementBot.PolicyPath); err != nil {
+		return errors.New(fmt.Sprintf("unable to process agbot served business policies metadata %v, error %v", pols, err))
+	}
+
+	for org, _ := range w.BusinessPolManager.OrgPolicies {
+
+		var exchangePolMetadata map[string]exchange.BusinessPolicy
+		var err error
+
+		// check if the org exists on the exchange or not
+		if _, err = exchange.GetOrganization(w.Config.Collaborators.HTTPClientFactory, org, w.GetExchangeURL(), w.GetExchangeId(), w.GetExchangeToken()); err != nil {
+			glog.V(5).Infof(AWlogString(fmt.Sprintf("unable to get organization %v: %v", org, err)))
+			exchangePolMetadata = make(map[string]exchange.BusinessPolicy)
+		} else if exchangePolMetadata, err = exchange.GetBusinessPolicies(w.Config.Collaborators.HTTPClientFactory, org, "", w.GetExchangeURL(), w.GetExchangeId(), w.GetExchangeToken()); err != nil {
+			return errors.New(fmt.Sprintf("unable to get business policies for org %v, error %v", org, err))
+		}
+
+		// Check for business policy metadata changes and update policy files accordingly
+		if err := w.BusinessPolManager.UpdatePolicies(org, exchangePolMetadata, w.Config.AgreementBot.PolicyPath); err != nil {
+			return errors.New(fmt.Sprintf("unable to update business policies for org %v, error %v", org, err))
+		}
+	}
+
+	return nil
+}
+
+func (w *AgreementBotWorker) getAgbotBusinessPols() (map[string]exchange.ServedBusinessPolicy, error) {
+
+	var resp interface{}
+	resp = new(exchange.GetAgbotsBusinessPolsResponse)
+	targetURL := w.GetExchangeURL() + "orgs/" + exchange.GetOrg(w.GetExchangeId()) + "/agbots/" + exchange.GetId(w.GetExchangeId()) + "/businesspols"
+	for {
+		if err, tpErr := exchange.InvokeExchange(w.httpClient, "GET", targetURL, w.GetExchangeId(), w.GetExchangeToken(), nil, &resp); err != nil {
+			// An exchange that does not know about business policies yet is the same as no served business policies.
+			if anaxerrors.Is(err, anaxerrors.EXCHANGE_NOT_FOUND) {
+				return nil, nil
+			}
+			glog.Errorf(AWlogString(err.Error()))
+			return nil, err
+		} else if tpErr != nil {
+			glog.Warningf(AWlogString(tpErr.Error()))
+			time.Sleep(10 * time.Second)
+			continue
+		} else {
+			pols := resp.(*exchange.GetAgbotsBusinessPolsResponse).BusinessPols
+			glog.V(5).Infof(AWlogString(fmt.Sprintf("retrieved agbot business policies from exchange %v", pols)))
+			return pols, nil
+		}
+	}
+
+}
+
 func (w *AgreementBotWorker) getAgbotPatterns() (map[string]exchange.ServedPattern, error) {
 
 	var resp interface{}
@@ -1203,9 +1787,48 @@ func (w *AgreementBotWorker) heartBeat() int {
 	return 0
 }
 
+// Read the heartbeats of the HA peers from the exchange and renew the partition leases. This function is called by
+// the partition subworker.
+func (w *AgreementBotWorker) GovernPartitions() int {
+
+	lastHeartbeats := make(map[string]int64)
+	for _, peer := range w.partitions.Peers() {
+		if lastHB, err := w.getAgbotHeartbeat(peer); err != nil {
+			glog.Warningf(AWlogString(fmt.Sprintf("unable to read the heartbeat of HA peer %v, its lease is unchanged, error: %v", peer, err)))
+		} else {
+			lastHeartbeats[peer] = lastHB
+		}
+	}
+
+	if gained, lost := w.partitions.UpdateLeases(lastHeartbeats, time.Now().Unix()); len(gained) != 0 || len(lost) != 0 {
+		glog.Infof(AWlogString(fmt.Sprintf("gained node partitions %v and lost node partitions %v, %v", gained, lost, w.partitions)))
+	}
+	return 0
+}
+
+// Returns the time of the agbot's last heartbeat to the exchange in seconds, zero when the agbot is not in the exchange.
+func (w *AgreementBotWorker) getAgbotHeartbeat(agbotId string) (int64, error) {
+
+	var resp interface{}
+	resp = new(exchange.GetAgbotsResponse)
+	targetURL := w.GetExchangeURL() + "orgs/" + exchange.GetOrg(agbotId) + "/agbots/" + exchange.GetId(agbotId)
+	if err, tpErr := exchange.InvokeExchange(w.httpClient, "GET", targetURL, w.GetExchangeId(), w.GetExchangeToken(), nil, &resp); err != nil {
+		return 0, err
+	} else if tpErr != nil {
+		return 0, tpErr
+	} else if agbot, ok := resp.(*exchange.GetAgbotsResponse).Agbots[agbotId]; !ok || agbot.LastHeartbeat == "" {
+		return 0, nil
+	} else {
+		return cutil.TimeInSeconds(agbot.LastHeartbeat), nil
+	}
+}
+
 // ==========================================================================================================
 // Utility functions
 
 var AWlogString = func(v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("AgreementBotWorker", nil, v)
+	}
 	return fmt.Sprintf("AgreementBotWorker %v", v)
 }
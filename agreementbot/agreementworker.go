@@ -13,6 +13,7 @@ import (
 	"github.com/open-horizon/anax/policy"
 	"math/rand"
 	"net/http"
+	"time"
 )
 
 // These structs are the event bodies that flow from the processor to the agreement workers
@@ -142,14 +143,14 @@ func (b *BaseAgreementWorker) InitiateNewAgreement(cph ConsumerProtocolHandler,
 		glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error generating agreement id %v", aerr)))
 		return
 	}
-	glog.V(5).Infof(BAWlogstring(workerId, fmt.Sprintf("using AgreementId %v", agreementIdString)))
+	cutil.TraceV(5, agreementIdString, wi.Device.Id).Infof(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("using AgreementId %v", agreementIdString)))
 
 	bcType, bcName, bcOrg := (&wi.ProducerPolicy).RequiresKnownBC(cph.Name())
 
 	// Use the blockchain name to choose the handler
 	protocolHandler := cph.AgreementProtocolHandler(bcType, bcName, bcOrg)
 	if protocolHandler == nil {
-		glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("agreement protocol handler is not ready yet for %v %v", bcType, bcName)))
+		glog.Errorf(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("agreement protocol handler is not ready yet for %v %v", bcType, bcName)))
 		return
 	}
 
@@ -162,15 +163,60 @@ func (b *BaseAgreementWorker) InitiateNewAgreement(cph ConsumerProtocolHandler,
 	// workload in the current consumer policy. If that's the case, query the exchange to get all the device
 	// policies so we can merge them.
 	var exchangeDev *exchange.Device
-	if wi.ConsumerPolicy.PatternId != "" {
-		if theDev, err := GetDevice(b.config.Collaborators.HTTPClientFactory.NewHTTPClient(nil), wi.Device.Id, b.config.AgreementBot.ExchangeURL, cph.GetExchangeId(), cph.GetExchangeToken()); err != nil {
-			glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error getting device %v policies, error: %v", wi.Device.Id, err)))
+	if wi.ConsumerPolicy.PatternId != "" || wi.ConsumerPolicy.BusinessPolId != "" {
+		if theDev, err := GetDevice(exchange.ShutdownContext(), b.config.Collaborators.HTTPClientFactory.NewHTTPClient(nil), wi.Device.Id, b.config.AgreementBot.ExchangeURL, cph.GetExchangeId(), cph.GetExchangeToken()); err != nil {
+			glog.Errorf(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("error getting device %v policies, error: %v", wi.Device.Id, err)))
 			return
 		} else {
 			exchangeDev = theDev
 		}
 	}
 
+	// A node without a pattern is matched with the business policy through its node policy. The business policy can
+	// require the node's measured properties to be signed by the node, so that whoever can change the node policy
+	// cannot claim hardware the node does not have.
+	var nodePolicy *exchange.NodePolicy
+	var attestation *exchange.NodeAttestation
+	if wi.ConsumerPolicy.BusinessPolId != "" {
+		if theNodePolicy, err := exchange.GetNodePolicy(exchange.ShutdownContext(), b.config.Collaborators.HTTPClientFactory.NewHTTPClient(nil), wi.Device.Id, b.config.AgreementBot.ExchangeURL, cph.GetExchangeId(), cph.GetExchangeToken()); err != nil {
+			glog.Errorf(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("error getting device %v node policy, error: %v", wi.Device.Id, err)))
+			return
+		} else {
+			nodePolicy = theNodePolicy
+		}
+
+		if wi.ConsumerPolicy.RequireAttestation {
+			if theAttestation, err := exchange.GetNodeAttestation(exchange.ShutdownContext(), b.config.Collaborators.HTTPClientFactory.NewHTTPClient(nil), wi.Device.Id, b.config.AgreementBot.ExchangeURL, cph.GetExchangeId(), cph.GetExchangeToken()); err != nil {
+				glog.Errorf(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("error getting device %v attestation, error: %v", wi.Device.Id, err)))
+				return
+			} else if err := theAttestation.Verify(exchangeDev.PublicKey); err != nil {
+				glog.V(3).Infof(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("device %v does not match business policy %v, it requires attested properties: %v", wi.Device.Id, wi.ConsumerPolicy.BusinessPolId, err)))
+				return
+			} else {
+				attestation = theAttestation
+			}
+		}
+
+		addNodePolicy(&wi.ProducerPolicy, nodePolicy)
+		applyNodeAttestation(&wi.ProducerPolicy, attestation)
+	}
+
+	// A pattern or business policy can deploy its service to nodes of any hardware architecture. The workloads are then
+	// resolved for the arch of this node, in a copy of the workloads so that the policy is left for the other nodes.
+	if wi.ConsumerPolicy.Workloads.HasAnyArch() {
+		arch := ""
+		if exchangeDev != nil {
+			arch = nodeArch(exchangeDev)
+		}
+		if arch == "" {
+			glog.Errorf(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("unable to find the hardware architecture of device %v for the workloads of %v", wi.Device.Id, wi.ConsumerPolicy.Header.Name)))
+			return
+		} else if canonical := b.config.ArchSynonyms.GetCanonicalArch(arch); canonical != "" {
+			arch = canonical
+		}
+		wi.ConsumerPolicy.Workloads = wi.ConsumerPolicy.Workloads.ForArch(arch)
+	}
+
 	// There could be more than 1 workload version in the consumer policy, and each version might NOT require the exact same
 	// services/microservices (and versions), so we first need to choose a workload. Choosing a workload is based on the priority of
 	// each workload and whether or not this workload has been tried before. Also, iterate the loop more than once if we choose
@@ -181,7 +227,7 @@ func (b *BaseAgreementWorker) InitiateNewAgreement(cph ConsumerProtocolHandler,
 	for !foundWorkload {
 
 		if wlUsage, err := FindSingleWorkloadUsageByDeviceAndPolicyName(b.db, wi.Device.Id, wi.ConsumerPolicy.Header.Name); err != nil {
-			glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error searching for persistent workload usage records for device %v with policy %v, error: %v", wi.Device.Id, wi.ConsumerPolicy.Header.Name, err)))
+			glog.Errorf(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("error searching for persistent workload usage records for device %v with policy %v, error: %v", wi.Device.Id, wi.ConsumerPolicy.Header.Name, err)))
 			return
 		} else if wlUsage == nil {
 			workload = wi.ConsumerPolicy.NextHighestPriorityWorkload(0, 0, 0)
@@ -193,11 +239,11 @@ func (b *BaseAgreementWorker) InitiateNewAgreement(cph ConsumerProtocolHandler,
 
 		// If we chose the same workload 2 times in a row through this loop, then we need to exit out of here
 		if lastWorkload == workload {
-			glog.Warningf(BAWlogstring(workerId, fmt.Sprintf("unable to find supported workload for %v within %v", wi.Device.Id, wi.ConsumerPolicy.Workloads)))
+			glog.Warningf(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("unable to find supported workload for %v within %v", wi.Device.Id, wi.ConsumerPolicy.Workloads)))
 
 			// If we created a workload usage record during this process, get rid of it.
 			if err := DeleteWorkloadUsage(b.db, wi.Device.Id, wi.ConsumerPolicy.Header.Name); err != nil {
-				glog.Warningf(BAWlogstring(workerId, fmt.Sprintf("unable to delete workload usage record for %v with %v because %v", wi.Device.Id, wi.ConsumerPolicy.Header.Name, err)))
+				glog.Warningf(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("unable to delete workload usage record for %v with %v because %v", wi.Device.Id, wi.ConsumerPolicy.Header.Name, err)))
 			}
 			return
 		}
@@ -208,71 +254,70 @@ func (b *BaseAgreementWorker) InitiateNewAgreement(cph ConsumerProtocolHandler,
 		// version API specs (services), then we will try the next workload.
 
 		if asl, workloadDetails, err := exchange.GetHTTPWorkloadOrServiceResolverHandler(cph)(workload.WorkloadURL, workload.Org, workload.Version, workload.Arch); err != nil {
-			glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error searching for workload details %v, error: %v", workload, err)))
+			glog.Errorf(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("error searching for workload details %v, error: %v", workload, err)))
 			return
 		} else {
 
 			// Canonicalize the arch field in the API spec list, and then merge node policies together if they aren't already merged.
-			var mergedProducer *policy.Policy
 			for ix, apiSpec := range *asl {
 				if apiSpec.Arch != "" && b.config.ArchSynonyms.GetCanonicalArch(apiSpec.Arch) != "" {
 					(*asl)[ix].Arch = b.config.ArchSynonyms.GetCanonicalArch(apiSpec.Arch)
 				}
+			}
 
-				if wi.ConsumerPolicy.PatternId != "" {
-
-					services := exchangeDev.RegisteredServices
-					if !workloadDetails.IsServiceBased() {
-						services = exchangeDev.RegisteredMicroservices
-					}
-
-					// Run through all the services on the node that are required by this workload and merge those policies.
-					for _, devMS := range services {
-						// Find the device's service definition based on the services needed by the workload.
-						if devMS.Url == apiSpec.SpecRef {
-							if pol, err := policy.DemarshalPolicy(devMS.Policy); err != nil {
-								glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error demarshalling device %v policy, error: %v", wi.Device.Id, err)))
-								return
-							} else if mergedProducer == nil {
-								mergedProducer = pol
-							} else if newPolicy, err := policy.Are_Compatible_Producers(mergedProducer, pol, b.config.AgreementBot.NoDataIntervalS); err != nil {
-								glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error merging policies %v and %v, error: %v", mergedProducer, pol, err)))
-								return
-							} else {
-								mergedProducer = newPolicy
-							}
-							break
-						}
-					}
+			var mergedProducer *policy.Policy
+			if exchangeDev != nil {
+				if merged, err := mergeServicePolicies(exchangeDev, asl, workloadDetails.IsServiceBased(), b.config.AgreementBot.NoDataIntervalS); err != nil {
+					glog.Errorf(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("error merging device %v policies, error: %v", wi.Device.Id, err)))
+					return
+				} else {
+					mergedProducer = merged
 				}
 			}
 
 			// Update the producer policy with a real merged policy based on the services required by the workload
-			if wi.ConsumerPolicy.PatternId != "" && mergedProducer != nil {
+			if exchangeDev != nil && mergedProducer != nil {
+				addNodePolicy(mergedProducer, nodePolicy)
+				applyNodeAttestation(mergedProducer, attestation)
 				wi.ProducerPolicy = *mergedProducer
 			}
 
+			// The node is left alone when it doesnt match the business policy.
+			if wi.ConsumerPolicy.BusinessPolId != "" {
+				if err := matchBusinessPolicy(&wi.ProducerPolicy, &wi.ConsumerPolicy); err != nil {
+					glog.V(3).Infof(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("device %v does not match business policy %v: %v", wi.Device.Id, wi.ConsumerPolicy.BusinessPolId, err)))
+
+					// If we created a workload usage record for an earlier workload, get rid of it.
+					if lastWorkload != nil {
+						if err := DeleteWorkloadUsage(b.db, wi.Device.Id, wi.ConsumerPolicy.Header.Name); err != nil {
+							glog.Warningf(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("unable to delete workload usage record for %v with %v because %v", wi.Device.Id, wi.ConsumerPolicy.Header.Name, err)))
+						}
+					}
+					return
+				}
+			}
+
 			// If the device doesnt support the workload requirements, then remember that we rejected a higher priority workload because of
 			// device requirements not being met. This will cause agreement cancellation to try the highest priority workload again
 			// even if retries have been disabled.
 			if err := wi.ProducerPolicy.APISpecs.Supports(*asl); err != nil {
-				glog.Warningf(BAWlogstring(workerId, fmt.Sprintf("skipping workload %v because device %v cant support it: %v", workload, wi.Device.Id, err)))
+				glog.Warningf(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("skipping workload %v because device %v cant support it: %v", workload, wi.Device.Id, err)))
 
 				if !workload.HasEmptyPriority() {
 					// If this is not the first time through the loop, update the workload usage record, otherwise create it.
 					if lastWorkload != nil {
 						if _, err := UpdatePriority(b.db, wi.Device.Id, wi.ConsumerPolicy.Header.Name, workload.Priority.PriorityValue, workload.Priority.RetryDurationS, workload.Priority.VerifiedDurationS, agreementIdString); err != nil {
-							glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error updating priority in persistent workload usage records for device %v with policy %v, error: %v", wi.Device.Id, wi.ConsumerPolicy.Header.Name, err)))
+							glog.Errorf(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("error updating priority in persistent workload usage records for device %v with policy %v, error: %v", wi.Device.Id, wi.ConsumerPolicy.Header.Name, err)))
 							return
 						}
 					} else if err := NewWorkloadUsage(b.db, wi.Device.Id, wi.ProducerPolicy.HAGroup.Partners, "", wi.ConsumerPolicy.Header.Name, workload.Priority.PriorityValue, workload.Priority.RetryDurationS, workload.Priority.VerifiedDurationS, true, agreementIdString); err != nil {
-						glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error creating persistent workload usage records for device %v with policy %v, error: %v", wi.Device.Id, wi.ConsumerPolicy.Header.Name, err)))
+						glog.Errorf(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("error creating persistent workload usage records for device %v with policy %v, error: %v", wi.Device.Id, wi.ConsumerPolicy.Header.Name, err)))
 						return
 					}
 
 					// Artificially bump up the retry count so that the loop will choose the next workload
 					if _, err := UpdateRetryCount(b.db, wi.Device.Id, wi.ConsumerPolicy.Header.Name, workload.Priority.Retries+1, agreementIdString); err != nil {
-						glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error updating retry count persistent workload usage records for device %v with policy %v, error: %v", wi.Device.Id, wi.ConsumerPolicy.Header.Name, err)))
+						glog.Errorf(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("error updating retry count persistent workload usage records for device %v with policy %v, error: %v", wi.Device.Id, wi.ConsumerPolicy.Header.Name, err)))
 						return
 					}
 				}
@@ -286,24 +331,14 @@ func (b *BaseAgreementWorker) InitiateNewAgreement(cph ConsumerProtocolHandler,
 
 				// Save the deployment and implementation package details into the consumer policy so that the node knows how to run
 				// the workload/service in the policy.
-				workload.Deployment = workloadDetails.GetDeployment()
-				workload.DeploymentSignature = workloadDetails.GetDeploymentSignature()
-				workload.ImageStore = workloadDetails.GetImageStore()
-				if workloadDetails.GetTorrent() != "" {
-					torr := new(policy.Torrent)
-					if err := json.Unmarshal([]byte(workloadDetails.GetTorrent()), torr); err != nil {
-						glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("Unable to demarshal torrent info from %v, error: %v", workloadDetails, err)))
-						return
-					}
-					workload.Torrent = *torr
-				} else {
-					// Since the torrent field is empty, we can convert the Package implementation to a Torrent object. The conversion
-					// might result in an empty object which would be normal when the ImageStore field does not contain metadata
-					// pointing to an image server.
-					workload.Torrent = workload.ImageStore.ConvertToTorrent()
+				if legacyTorrent, err := addWorkloadDetails(workload, workloadDetails); err != nil {
+					glog.Errorf(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), err.Error()))
+					return
+				} else if legacyTorrent {
+					glog.Warningf(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("workload %v has a deprecated torrent field, treating it as empty", workload)))
 				}
 
-				glog.V(5).Infof(BAWlogstring(workerId, fmt.Sprintf("workload %v is supported by device %v", workload, wi.Device.Id)))
+				cutil.TraceV(5, agreementIdString, wi.Device.Id).Infof(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("workload %v is supported by device %v", workload, wi.Device.Id)))
 			}
 
 		}
@@ -314,34 +349,46 @@ func (b *BaseAgreementWorker) InitiateNewAgreement(cph ConsumerProtocolHandler,
 	// Call the exchange to make sure that all partners are registered in the exchange. We can do this check now that we know
 	// exactly what the merged producer policy looks like.
 	if err := b.incompleteHAGroup(cph, &wi.ProducerPolicy); err != nil {
-		glog.Warningf(BAWlogstring(workerId, fmt.Sprintf("received error checking HA group %v completeness for device %v, error: %v", wi.ProducerPolicy.HAGroup, wi.Device.Id, err)))
+		glog.Warningf(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("received error checking HA group %v completeness for device %v, error: %v", wi.ProducerPolicy.HAGroup, wi.Device.Id, err)))
+		return
+	}
+
+	// Check the workloads already running on the device against the placement constraints of the consumer policy.
+	if err := b.unsatisfiedPlacement(cph, wi.Device.Id, &wi.ConsumerPolicy); err != nil {
+		cutil.TraceV(3, agreementIdString, wi.Device.Id).Infof(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("skipping device %v, placement %v not satisfied: %v", wi.Device.Id, wi.ConsumerPolicy.Placement, err)))
+		return
+	}
+
+	// Only make the agreement while it is within the time windows of the consumer policy, in the node's local time.
+	if windows := wi.ConsumerPolicy.TimeWindows.ForNode(wi.ProducerPolicy.Properties); !windows.Allows(time.Now(), nil) {
+		cutil.TraceV(3, agreementIdString, wi.Device.Id).Infof(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("skipping device %v, outside of time windows %v", wi.Device.Id, windows)))
 		return
 	}
 
 	// If this device is advertising a property that we are supposed to ignore, then skip it.
 	if ignore, err := b.ignoreDevice(&wi.ProducerPolicy); err != nil {
-		glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("received error checking for ignored device %v, error: %v", wi.Device.Id, err)))
+		glog.Errorf(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("received error checking for ignored device %v, error: %v", wi.Device.Id, err)))
 		return
 	} else if ignore {
-		glog.V(3).Infof(BAWlogstring(workerId, fmt.Sprintf("skipping device %v, advertises ignored property", wi.Device.Id)))
+		cutil.TraceV(3, agreementIdString, wi.Device.Id).Infof(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("skipping device %v, advertises ignored property", wi.Device.Id)))
 		return
 	}
 
 	// Create pending agreement in database
-	if err := AgreementAttempt(b.db, agreementIdString, wi.Org, wi.Device.Id, wi.ConsumerPolicy.Header.Name, bcType, bcName, bcOrg, cph.Name(), wi.ConsumerPolicy.PatternId, wi.ConsumerPolicy.NodeH); err != nil {
-		glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error persisting agreement attempt: %v", err)))
+	if err := AgreementAttempt(b.db, agreementIdString, wi.Org, wi.Device.Id, wi.ConsumerPolicy.Header.Name, bcType, bcName, bcOrg, cph.Name(), wi.ConsumerPolicy.PatternId, wi.ConsumerPolicy.NodeH, wi.ConsumerPolicy.AgreementTimeouts); err != nil {
+		glog.Errorf(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("error persisting agreement attempt: %v", err)))
 
 		// Create message target for protocol message
 	} else if mt, err := exchange.CreateMessageTarget(wi.Device.Id, nil, wi.Device.PublicKey, wi.Device.MsgEndPoint); err != nil {
-		glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error creating message target: %v", err)))
+		glog.Errorf(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("error creating message target: %v", err)))
 
 		// Initiate the protocol
-	} else if proposal, err := protocolHandler.InitiateAgreement(agreementIdString, &wi.ProducerPolicy, &wi.ConsumerPolicy, wi.Org, cph.GetExchangeId(), mt, workload, b.config.AgreementBot.DefaultWorkloadPW, b.config.AgreementBot.NoDataIntervalS, cph.GetSendMessage()); err != nil {
-		glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error initiating agreement: %v", err)))
+	} else if proposal, err := protocolHandler.InitiateAgreement(agreementIdString, &wi.ProducerPolicy, &wi.ConsumerPolicy, wi.Org, cph.GetExchangeId(), mt, workload, b.config.AgreementBot.DefaultWorkloadPW, b.config.AgreementBot.NoDataIntervalS, wi.ConsumerPolicy.AgreementTimeouts.NoReply(b.config.AgreementBot.ProtocolTimeoutS), cph.GetSendMessage()); err != nil {
+		glog.Errorf(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("error initiating agreement: %v", err)))
 
 		// Remove pending agreement from database
 		if err := DeleteAgreement(b.db, agreementIdString, cph.Name()); err != nil {
-			glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error deleting pending agreement: %v, error %v", agreementIdString, err)))
+			glog.Errorf(BAWlogstringA(workerId, agreementIdString, wi.Device.Id, cph.Name(), fmt.Sprintf("error deleting pending agreement: %v, error %v", agreementIdString, err)))
 		}
 
 		// TODO: Publish error on the message bus
@@ -349,6 +396,8 @@ func (b *BaseAgreementWorker) InitiateNewAgreement(cph ConsumerProtocolHandler,
 		// Update the agreement in the DB with the proposal and policy
 	} else if err := cph.PersistAgreement(wi, proposal, workerId); err != nil {
 		glog.Errorf(err.Error())
+	} else {
+		noteNegotiation(NegotiationProposed(b.db, wi.Device.Id, agreementIdString, cph.Name(), wi.ConsumerPolicy.Header.Name, wi.ConsumerPolicy.PatternId, b.config.AgreementBot.NegotiationHistoryLength), wi.Device.Id, agreementIdString, NEGOTIATION_PROPOSED)
 	}
 
 }
@@ -377,29 +426,29 @@ func (b *BaseAgreementWorker) HandleAgreementReply(cph ConsumerProtocolHandler,
 
 		// Find the saved agreement in the database
 		if agreement, err := FindSingleAgreementByAgreementId(b.db, reply.AgreementId(), cph.Name(), []AFilter{UnarchivedAFilter()}); err != nil {
-			glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error querying pending agreement %v, error: %v", reply.AgreementId(), err)))
+			glog.Errorf(BAWlogstringA(workerId, reply.AgreementId(), wi.SenderId, cph.Name(), fmt.Sprintf("error querying pending agreement %v, error: %v", reply.AgreementId(), err)))
 		} else if agreement == nil {
-			glog.V(5).Infof(BAWlogstring(workerId, fmt.Sprintf("discarding reply, agreement id %v not in our database", reply.AgreementId())))
+			cutil.TraceV(5, reply.AgreementId(), wi.SenderId).Infof(BAWlogstringA(workerId, reply.AgreementId(), wi.SenderId, cph.Name(), fmt.Sprintf("discarding reply, agreement id %v not in our database", reply.AgreementId())))
 		} else if cph.AlreadyReceivedReply(agreement) {
-			glog.V(5).Infof(BAWlogstring(workerId, fmt.Sprintf("discarding reply, agreement id %v already received a reply", agreement.CurrentAgreementId)))
+			cutil.TraceV(5, reply.AgreementId(), wi.SenderId).Infof(BAWlogstringA(workerId, reply.AgreementId(), wi.SenderId, cph.Name(), fmt.Sprintf("discarding reply, agreement id %v already received a reply", agreement.CurrentAgreementId)))
 			// this will cause us to not send a reply ack, which is what we want in this case
 			sendReply = false
 
 			// Now we need to write the info to the exchange and the database
 		} else if proposal, err := protocolHandler.DemarshalProposal(agreement.Proposal); err != nil {
-			glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error validating proposal from pending agreement %v, error: %v", reply.AgreementId(), err)))
+			glog.Errorf(BAWlogstringA(workerId, reply.AgreementId(), wi.SenderId, cph.Name(), fmt.Sprintf("error validating proposal from pending agreement %v, error: %v", reply.AgreementId(), err)))
 		} else if pol, err := policy.DemarshalPolicy(proposal.TsAndCs()); err != nil {
-			glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error demarshalling tsandcs policy from pending agreement %v, error: %v", reply.AgreementId(), err)))
+			glog.Errorf(BAWlogstringA(workerId, reply.AgreementId(), wi.SenderId, cph.Name(), fmt.Sprintf("error demarshalling tsandcs policy from pending agreement %v, error: %v", reply.AgreementId(), err)))
 
 		} else if err := cph.PersistReply(reply, pol, workerId); err != nil {
 			glog.Errorf(err.Error())
 
 		} else if err := cph.RecordConsumerAgreementState(reply.AgreementId(), pol, agreement.Org, "Producer agreed", b.workerID); err != nil {
-			glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error setting agreement state for %v", reply.AgreementId())))
+			glog.Errorf(BAWlogstringA(workerId, reply.AgreementId(), wi.SenderId, cph.Name(), fmt.Sprintf("error setting agreement state for %v", reply.AgreementId())))
 
 			// We need to send a reply ack and write the info to the blockchain
 		} else if consumerPolicy, err := policy.DemarshalPolicy(agreement.Policy); err != nil {
-			glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("unable to demarshal policy for agreement %v, error %v", reply.AgreementId(), err)))
+			glog.Errorf(BAWlogstringA(workerId, reply.AgreementId(), wi.SenderId, cph.Name(), fmt.Sprintf("unable to demarshal policy for agreement %v, error %v", reply.AgreementId(), err)))
 		} else {
 			// Done handling the response successfully
 			ackReplyAsValid = true
@@ -408,7 +457,7 @@ func (b *BaseAgreementWorker) HandleAgreementReply(cph ConsumerProtocolHandler,
 			// workload usage record and workload rollback retry counting is enabled, then check to see if the workload priority
 			// has changed. If so, update the record and reset the retry count and time. Othwerwise just update the retry count.
 			if wlUsage, err := FindSingleWorkloadUsageByDeviceAndPolicyName(b.db, wi.SenderId, consumerPolicy.Header.Name); err != nil {
-				glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error searching for persistent workload usage records for device %v with policy %v, error: %v", wi.SenderId, consumerPolicy.Header.Name, err)))
+				glog.Errorf(BAWlogstringA(workerId, reply.AgreementId(), wi.SenderId, cph.Name(), fmt.Sprintf("error searching for persistent workload usage records for device %v with policy %v, error: %v", wi.SenderId, consumerPolicy.Header.Name, err)))
 			} else if wlUsage == nil {
 				// There is no workload usage record. Make sure that the current workload chosen is the highest priority workload.
 				// There could have been a change in the system such that the chosen workload is no longer the right choice. If this
@@ -420,41 +469,41 @@ func (b *BaseAgreementWorker) HandleAgreementReply(cph ConsumerProtocolHandler,
 					ackReplyAsValid = false
 				} else if !pol.Workloads[0].HasEmptyPriority() {
 					if err := NewWorkloadUsage(b.db, wi.SenderId, pol.HAGroup.Partners, agreement.Policy, consumerPolicy.Header.Name, pol.Workloads[0].Priority.PriorityValue, pol.Workloads[0].Priority.RetryDurationS, pol.Workloads[0].Priority.VerifiedDurationS, false, reply.AgreementId()); err != nil {
-						glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error creating persistent workload usage records for device %v with policy %v, error: %v", wi.SenderId, consumerPolicy.Header.Name, err)))
+						glog.Errorf(BAWlogstringA(workerId, reply.AgreementId(), wi.SenderId, cph.Name(), fmt.Sprintf("error creating persistent workload usage records for device %v with policy %v, error: %v", wi.SenderId, consumerPolicy.Header.Name, err)))
 					}
 				}
 			} else {
 				if wlUsage.Policy == "" {
 					if _, err := UpdatePolicy(b.db, wi.SenderId, consumerPolicy.Header.Name, agreement.Policy); err != nil {
-						glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error updating policy in workload usage prioroty for device %v with policy %v, error: %v", wi.SenderId, consumerPolicy.Header.Name, err)))
+						glog.Errorf(BAWlogstringA(workerId, reply.AgreementId(), wi.SenderId, cph.Name(), fmt.Sprintf("error updating policy in workload usage prioroty for device %v with policy %v, error: %v", wi.SenderId, consumerPolicy.Header.Name, err)))
 					}
 				}
 
 				if !wlUsage.DisableRetry {
 					if pol.Workloads[0].Priority.PriorityValue != wlUsage.Priority {
 						if _, err := UpdatePriority(b.db, wi.SenderId, consumerPolicy.Header.Name, pol.Workloads[0].Priority.PriorityValue, pol.Workloads[0].Priority.RetryDurationS, pol.Workloads[0].Priority.VerifiedDurationS, reply.AgreementId()); err != nil {
-							glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error updating workload usage prioroty for device %v with policy %v, error: %v", wi.SenderId, consumerPolicy.Header.Name, err)))
+							glog.Errorf(BAWlogstringA(workerId, reply.AgreementId(), wi.SenderId, cph.Name(), fmt.Sprintf("error updating workload usage prioroty for device %v with policy %v, error: %v", wi.SenderId, consumerPolicy.Header.Name, err)))
 						}
 					} else if _, err := UpdateRetryCount(b.db, wi.SenderId, consumerPolicy.Header.Name, wlUsage.RetryCount+1, reply.AgreementId()); err != nil {
-						glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error updating workload usage retry count for device %v with policy %v, error: %v", wi.SenderId, consumerPolicy.Header.Name, err)))
+						glog.Errorf(BAWlogstringA(workerId, reply.AgreementId(), wi.SenderId, cph.Name(), fmt.Sprintf("error updating workload usage retry count for device %v with policy %v, error: %v", wi.SenderId, consumerPolicy.Header.Name, err)))
 					}
 				} else if _, err := UpdateWUAgreementId(b.db, wi.SenderId, consumerPolicy.Header.Name, reply.AgreementId()); err != nil {
-					glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error updating agreement id %v in workload usage for %v for policy %v, error: %v", reply.AgreementId(), wi.SenderId, consumerPolicy.Header.Name, err)))
+					glog.Errorf(BAWlogstringA(workerId, reply.AgreementId(), wi.SenderId, cph.Name(), fmt.Sprintf("error updating agreement id %v in workload usage for %v for policy %v, error: %v", reply.AgreementId(), wi.SenderId, consumerPolicy.Header.Name, err)))
 				}
 			}
 
 			// Send the reply Ack if it's still valid.
 			if ackReplyAsValid {
 				if mt, err := exchange.CreateMessageTarget(wi.SenderId, nil, wi.SenderPubKey, wi.From); err != nil {
-					glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error creating message target: %v", err)))
+					glog.Errorf(BAWlogstringA(workerId, reply.AgreementId(), wi.SenderId, cph.Name(), fmt.Sprintf("error creating message target: %v", err)))
 				} else if err := protocolHandler.Confirm(ackReplyAsValid, reply.AgreementId(), mt, cph.GetSendMessage()); err != nil {
-					glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error trying to send reply ack for %v to %v, error: %v", reply.AgreementId(), mt, err)))
+					glog.Errorf(BAWlogstringA(workerId, reply.AgreementId(), wi.SenderId, cph.Name(), fmt.Sprintf("error trying to send reply ack for %v to %v, error: %v", reply.AgreementId(), mt, err)))
 				}
 
 				// Delete the original reply message
 				if wi.MessageId != 0 {
 					if err := cph.DeleteMessage(wi.MessageId); err != nil {
-						glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error deleting message %v from exchange for agbot %v", wi.MessageId, cph.GetExchangeId())))
+						glog.Errorf(BAWlogstringA(workerId, reply.AgreementId(), wi.SenderId, cph.Name(), fmt.Sprintf("error deleting message %v from exchange for agbot %v", wi.MessageId, cph.GetExchangeId())))
 					}
 				}
 
@@ -463,7 +512,7 @@ func (b *BaseAgreementWorker) HandleAgreementReply(cph ConsumerProtocolHandler,
 				lock.Unlock()
 
 				if err := cph.PostReply(reply.AgreementId(), proposal, reply, consumerPolicy, agreement.Org, workerId); err != nil {
-					glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error trying to record agreement in blockchain, %v", err)))
+					glog.Errorf(BAWlogstringA(workerId, reply.AgreementId(), wi.SenderId, cph.Name(), fmt.Sprintf("error trying to record agreement in blockchain, %v", err)))
 					b.CancelAgreementWithLock(cph, reply.AgreementId(), cph.GetTerminationCode(TERM_REASON_CANCEL_BC_WRITE_FAILED), workerId)
 					ackReplyAsValid = false
 				}
@@ -474,16 +523,39 @@ func (b *BaseAgreementWorker) HandleAgreementReply(cph ConsumerProtocolHandler,
 		// Always send an ack for a reply with a positive decision in it
 		if !ackReplyAsValid && sendReply {
 			if mt, err := exchange.CreateMessageTarget(wi.SenderId, nil, wi.SenderPubKey, wi.From); err != nil {
-				glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error creating message target: %v", err)))
+				glog.Errorf(BAWlogstringA(workerId, reply.AgreementId(), wi.SenderId, cph.Name(), fmt.Sprintf("error creating message target: %v", err)))
 			} else if err := protocolHandler.Confirm(ackReplyAsValid, reply.AgreementId(), mt, cph.GetSendMessage()); err != nil {
-				glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error trying to send reply ack for %v to %v, error: %v", reply.AgreementId(), wi.From, err)))
+				glog.Errorf(BAWlogstringA(workerId, reply.AgreementId(), wi.SenderId, cph.Name(), fmt.Sprintf("error trying to send reply ack for %v to %v, error: %v", reply.AgreementId(), wi.From, err)))
 			}
 		}
 
 	} else {
-		glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("received rejection from producer %v", reply)))
+		glog.Errorf(BAWlogstringA(workerId, reply.AgreementId(), wi.SenderId, cph.Name(), fmt.Sprintf("received rejection from producer %v", reply)))
+
+		// Keep the node's explanation of the rejection with the agreement, so that it can be seen after the agreement
+		// is archived.
+		if explanation := reply.DecisionExplanation(); explanation != nil {
+			if _, err := AgreementDecisionExplanation(b.db, reply.AgreementId(), cph.Name(), *explanation); err != nil {
+				glog.Errorf(BAWlogstringA(workerId, reply.AgreementId(), wi.SenderId, cph.Name(), fmt.Sprintf("error saving decision explanation %v, error: %v", explanation, err)))
+			}
+		}
 
-		b.CancelAgreement(cph, reply.AgreementId(), cph.GetTerminationCode(TERM_REASON_NEGATIVE_REPLY), workerId)
+		// A node that is at its agreement limit says so, record that rather than a plain rejection. A node that is short
+		// of disk space can't take more agreements either. A node that got the proposal after it expired is treated as
+		// if it never replied. A node that does not have the devices the workload needs says which reason it is. A node
+		// that rolled back from the workload version is offered the next lower priority workload from now on.
+		reason := TERM_REASON_NEGATIVE_REPLY
+		if reply.RejectReason() == abstractprotocol.REJECT_NODE_AGREEMENT_LIMIT || reply.RejectReason() == abstractprotocol.REJECT_NODE_LOW_DISK {
+			reason = TERM_REASON_NODE_AGREEMENT_LIMIT
+		} else if reply.RejectReason() == abstractprotocol.REJECT_PROPOSAL_EXPIRED {
+			reason = TERM_REASON_NO_REPLY
+		} else if reply.RejectReason() == abstractprotocol.REJECT_NODE_MISSING_DEVICES {
+			reason = TERM_REASON_NODE_MISSING_DEVICES
+		} else if reply.RejectReason() == abstractprotocol.REJECT_NODE_WORKLOAD_ROLLBACK {
+			reason = TERM_REASON_NODE_WORKLOAD_ROLLBACK
+			b.skipRolledBackWorkload(cph, reply.AgreementId(), wi.SenderId, workerId)
+		}
+		b.CancelAgreement(cph, reply.AgreementId(), cph.GetTerminationCode(reason), workerId)
 	}
 
 	// Get rid of the lock
@@ -494,7 +566,7 @@ func (b *BaseAgreementWorker) HandleAgreementReply(cph ConsumerProtocolHandler,
 	// Get rid of the exchange message if there is one
 	if wi.MessageId != 0 && !deletedMessage {
 		if err := cph.DeleteMessage(wi.MessageId); err != nil {
-			glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error deleting message %v from exchange for agbot %v", wi.MessageId, cph.GetExchangeId())))
+			glog.Errorf(BAWlogstringA(workerId, reply.AgreementId(), wi.SenderId, cph.Name(), fmt.Sprintf("error deleting message %v from exchange for agbot %v", wi.MessageId, cph.GetExchangeId())))
 		}
 	}
 
@@ -502,6 +574,48 @@ func (b *BaseAgreementWorker) HandleAgreementReply(cph ConsumerProtocolHandler,
 
 }
 
+// The node rolled back from the workload version in the proposal because the version kept failing on the node. Use up
+// the retries of the workload's priority, the way a workload that the node can't support is skipped, so that the
+// next proposal to the node offers the next lower priority workload. That is the version the node rolled back to when
+// the policy lists the versions of the workload in the order they were released.
+func (b *BaseAgreementWorker) skipRolledBackWorkload(cph ConsumerProtocolHandler, agreementId string, deviceId string, workerId string) {
+
+	protocolHandler := cph.AgreementProtocolHandler("", "", "")
+
+	if ag, err := FindSingleAgreementByAgreementId(b.db, agreementId, cph.Name(), []AFilter{UnarchivedAFilter()}); err != nil {
+		glog.Errorf(BAWlogstringA(workerId, agreementId, deviceId, cph.Name(), fmt.Sprintf("error querying agreement %v, error: %v", agreementId, err)))
+	} else if ag == nil {
+		glog.V(3).Infof(BAWlogstringA(workerId, agreementId, deviceId, cph.Name(), fmt.Sprintf("agreement %v not in our database, no workload to skip", agreementId)))
+	} else if proposal, err := protocolHandler.DemarshalProposal(ag.Proposal); err != nil {
+		glog.Errorf(BAWlogstringA(workerId, agreementId, deviceId, cph.Name(), fmt.Sprintf("error demarshalling proposal of agreement %v, error: %v", agreementId, err)))
+	} else if pol, err := policy.DemarshalPolicy(proposal.TsAndCs()); err != nil {
+		glog.Errorf(BAWlogstringA(workerId, agreementId, deviceId, cph.Name(), fmt.Sprintf("error demarshalling tsandcs policy of agreement %v, error: %v", agreementId, err)))
+	} else if len(pol.Workloads) == 0 || pol.Workloads[0].HasEmptyPriority() {
+		glog.Warningf(BAWlogstringA(workerId, agreementId, deviceId, cph.Name(), fmt.Sprintf("device %v rolled back from the workload of policy %v, which has no lower priority workload to offer", deviceId, ag.PolicyName)))
+	} else {
+		workload := pol.Workloads[0]
+		if wlUsage, err := FindSingleWorkloadUsageByDeviceAndPolicyName(b.db, deviceId, ag.PolicyName); err != nil {
+			glog.Errorf(BAWlogstringA(workerId, agreementId, deviceId, cph.Name(), fmt.Sprintf("error searching for persistent workload usage records for device %v with policy %v, error: %v", deviceId, ag.PolicyName, err)))
+			return
+		} else if wlUsage == nil {
+			if err := NewWorkloadUsage(b.db, deviceId, pol.HAGroup.Partners, ag.Policy, ag.PolicyName, workload.Priority.PriorityValue, workload.Priority.RetryDurationS, workload.Priority.VerifiedDurationS, false, agreementId); err != nil {
+				glog.Errorf(BAWlogstringA(workerId, agreementId, deviceId, cph.Name(), fmt.Sprintf("error creating persistent workload usage records for device %v with policy %v, error: %v", deviceId, ag.PolicyName, err)))
+				return
+			}
+		} else if wlUsage.Priority != workload.Priority.PriorityValue {
+			if _, err := UpdatePriority(b.db, deviceId, ag.PolicyName, workload.Priority.PriorityValue, workload.Priority.RetryDurationS, workload.Priority.VerifiedDurationS, agreementId); err != nil {
+				glog.Errorf(BAWlogstringA(workerId, agreementId, deviceId, cph.Name(), fmt.Sprintf("error updating priority in persistent workload usage records for device %v with policy %v, error: %v", deviceId, ag.PolicyName, err)))
+				return
+			}
+		}
+
+		glog.V(3).Infof(BAWlogstringA(workerId, agreementId, deviceId, cph.Name(), fmt.Sprintf("device %v rolled back from workload %v, skipping priority %v", deviceId, workload.ShortString(), workload.Priority.PriorityValue)))
+		if _, err := UpdateRetryCount(b.db, deviceId, ag.PolicyName, workload.Priority.Retries+1, agreementId); err != nil {
+			glog.Errorf(BAWlogstringA(workerId, agreementId, deviceId, cph.Name(), fmt.Sprintf("error updating retry count persistent workload usage records for device %v with policy %v, error: %v", deviceId, ag.PolicyName, err)))
+		}
+	}
+}
+
 func (b *BaseAgreementWorker) HandleDataReceivedAck(cph ConsumerProtocolHandler, wi *HandleDataReceivedAck, workerId string) {
 
 	protocolHandler := cph.AgreementProtocolHandler("", "", "") // Use the generic protocol handler
@@ -595,36 +709,36 @@ func (b *BaseAgreementWorker) CancelAgreementWithLock(cph ConsumerProtocolHandle
 func (b *BaseAgreementWorker) CancelAgreement(cph ConsumerProtocolHandler, agreementId string, reason uint, workerId string) {
 
 	// Start timing out the agreement
-	glog.V(3).Infof(BAWlogstring(workerId, fmt.Sprintf("terminating agreement %v.", agreementId)))
+	cutil.TraceV(3, agreementId, "").Infof(BAWlogstringA(workerId, agreementId, "", cph.Name(), fmt.Sprintf("terminating agreement %v.", agreementId)))
 
 	// Update the database
 	if _, err := AgreementTimedout(b.db, agreementId, cph.Name()); err != nil {
-		glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error marking agreement %v terminated: %v", agreementId, err)))
+		glog.Errorf(BAWlogstringA(workerId, agreementId, "", cph.Name(), fmt.Sprintf("error marking agreement %v terminated: %v", agreementId, err)))
 	}
 
 	// Update state in exchange
 	if err := DeleteConsumerAgreement(b.config.Collaborators.HTTPClientFactory.NewHTTPClient(nil), b.config.AgreementBot.ExchangeURL, cph.GetExchangeId(), cph.GetExchangeToken(), agreementId); err != nil {
-		glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error deleting agreement %v in exchange: %v", agreementId, err)))
+		glog.Errorf(BAWlogstringA(workerId, agreementId, "", cph.Name(), fmt.Sprintf("error deleting agreement %v in exchange: %v", agreementId, err)))
 	}
 
 	// Find the agreement record
 	if ag, err := FindSingleAgreementByAgreementId(b.db, agreementId, cph.Name(), []AFilter{UnarchivedAFilter()}); err != nil {
-		glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error querying agreement %v from database, error: %v", agreementId, err)))
+		glog.Errorf(BAWlogstringA(workerId, agreementId, "", cph.Name(), fmt.Sprintf("error querying agreement %v from database, error: %v", agreementId, err)))
 	} else if ag == nil {
-		glog.V(3).Infof(BAWlogstring(workerId, fmt.Sprintf("nothing to terminate for agreement %v, no database record.", agreementId)))
+		cutil.TraceV(3, agreementId, "").Infof(BAWlogstringA(workerId, agreementId, "", cph.Name(), fmt.Sprintf("nothing to terminate for agreement %v, no database record.", agreementId)))
 	} else {
 
 		// Update the workload usage record to clear the agreement. There might not be a workload usage record if there is no workload priority
 		// specified in the workload section of the policy.
 		if wlUsage, err := UpdateWUAgreementId(b.db, ag.DeviceId, ag.PolicyName, ""); err != nil {
-			glog.Warningf(BAWlogstring(workerId, fmt.Sprintf("warning updating agreement id in workload usage for %v for policy %v, error: %v", ag.DeviceId, ag.PolicyName, err)))
+			glog.Warningf(BAWlogstringA(workerId, agreementId, "", cph.Name(), fmt.Sprintf("warning updating agreement id in workload usage for %v for policy %v, error: %v", ag.DeviceId, ag.PolicyName, err)))
 
 		} else if wlUsage != nil && wlUsage.ReqsNotMet {
 			// If the workload usage record indicates that it is not at the highest priority workload because the device cant meet the
 			// requirements of the higher priority workload, then when an agreement gets cancelled, we will remove the record so that the
 			// agbot always tries the next agreement starting with the highest priority workload again.
 			if err := DeleteWorkloadUsage(b.db, ag.DeviceId, ag.PolicyName); err != nil {
-				glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error deleting workload usage record for device %v and policyName %v, error: %v", ag.DeviceId, ag.PolicyName, err)))
+				glog.Errorf(BAWlogstringA(workerId, agreementId, "", cph.Name(), fmt.Sprintf("error deleting workload usage record for device %v and policyName %v, error: %v", ag.DeviceId, ag.PolicyName, err)))
 			}
 		}
 
@@ -638,7 +752,7 @@ func (b *BaseAgreementWorker) CancelAgreement(cph ConsumerProtocolHandler, agree
 
 		if ag.AgreementProtocolVersion < 2 || (ag.BlockchainType != "" && !cph.IsBlockchainWritable(ag.BlockchainType, ag.BlockchainName, ag.BlockchainOrg)) {
 			// create deferred termination command
-			glog.V(3).Infof(BAWlogstring(workerId, fmt.Sprintf("deferring blockchain cancel for %v", agreementId)))
+			cutil.TraceV(3, agreementId, "").Infof(BAWlogstringA(workerId, agreementId, "", cph.Name(), fmt.Sprintf("deferring blockchain cancel for %v", agreementId)))
 			cph.DeferCommand(AsyncCancelAgreement{
 				workType:    ASYNC_CANCEL,
 				AgreementId: agreementId,
@@ -648,8 +762,17 @@ func (b *BaseAgreementWorker) CancelAgreement(cph ConsumerProtocolHandler, agree
 		}
 
 		// Archive the record
-		if _, err := ArchiveAgreement(b.db, ag.CurrentAgreementId, cph.Name(), reason, cph.GetTerminationReason(reason)); err != nil {
-			glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error archiving terminated agreement: %v, error: %v", ag.CurrentAgreementId, err)))
+		if archivedAg, err := ArchiveAgreement(b.db, ag.CurrentAgreementId, cph.Name(), reason, cph.GetTerminationReason(reason)); err != nil {
+			glog.Errorf(BAWlogstringA(workerId, agreementId, "", cph.Name(), fmt.Sprintf("error archiving terminated agreement: %v, error: %v", ag.CurrentAgreementId, err)))
+		} else {
+			cph.Webhooks().Notify(NewWebhookEvent(WEBHOOK_AGREEMENT_TERMINATED, archivedAg, reason, archivedAg.TerminatedDescription))
+
+			stage := ""
+			if archivedAg.DecisionExplanation != nil {
+				stage = archivedAg.DecisionExplanation.Stage
+			}
+			outcome := negotiationOutcome(cph, reason)
+			noteNegotiation(NegotiationEnded(b.db, archivedAg.DeviceId, archivedAg.CurrentAgreementId, outcome, reason, archivedAg.TerminatedDescription, stage), archivedAg.DeviceId, archivedAg.CurrentAgreementId, outcome)
 		}
 
 	}
@@ -657,20 +780,20 @@ func (b *BaseAgreementWorker) CancelAgreement(cph ConsumerProtocolHandler, agree
 
 func (b *BaseAgreementWorker) ExternalCancel(cph ConsumerProtocolHandler, agreementId string, reason uint, workerId string) {
 
-	glog.V(3).Infof(BAWlogstring(workerId, fmt.Sprintf("starting deferred cancel for %v", agreementId)))
+	cutil.TraceV(3, agreementId, "").Infof(BAWlogstringA(workerId, agreementId, "", cph.Name(), fmt.Sprintf("starting deferred cancel for %v", agreementId)))
 
 	// Find the agreement record
 	if ag, err := FindSingleAgreementByAgreementId(b.db, agreementId, cph.Name(), []AFilter{}); err != nil {
-		glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error querying agreement %v from database, error: %v", agreementId, err)))
+		glog.Errorf(BAWlogstringA(workerId, agreementId, "", cph.Name(), fmt.Sprintf("error querying agreement %v from database, error: %v", agreementId, err)))
 	} else if ag == nil {
-		glog.V(3).Infof(BAWlogstring(workerId, fmt.Sprintf("nothing to terminate for agreement %v, no database record.", agreementId)))
+		cutil.TraceV(3, agreementId, "").Infof(BAWlogstringA(workerId, agreementId, "", cph.Name(), fmt.Sprintf("nothing to terminate for agreement %v, no database record.", agreementId)))
 	} else {
 		bcType, bcName, bcOrg := cph.GetKnownBlockchain(ag)
 		if cph.IsBlockchainWritable(bcType, bcName, bcOrg) {
 			b.DoAsyncCancel(cph, ag, reason, workerId)
 
 		} else {
-			glog.V(3).Infof(BAWlogstring(workerId, fmt.Sprintf("deferring blockchain cancel for %v", agreementId)))
+			cutil.TraceV(3, agreementId, "").Infof(BAWlogstringA(workerId, agreementId, "", cph.Name(), fmt.Sprintf("deferring blockchain cancel for %v", agreementId)))
 			cph.DeferCommand(AsyncCancelAgreement{
 				workType:    ASYNC_CANCEL,
 				AgreementId: agreementId,
@@ -683,16 +806,28 @@ func (b *BaseAgreementWorker) ExternalCancel(cph ConsumerProtocolHandler, agreem
 
 func (b *BaseAgreementWorker) DoAsyncCancel(cph ConsumerProtocolHandler, ag *Agreement, reason uint, workerId string) {
 
-	glog.V(3).Infof(BAWlogstring(workerId, fmt.Sprintf("starting async cancel for %v", ag.CurrentAgreementId)))
+	cutil.TraceV(3, ag.CurrentAgreementId, ag.DeviceId).Infof(BAWlogstringA(workerId, ag.CurrentAgreementId, ag.DeviceId, cph.Name(), fmt.Sprintf("starting async cancel for %v", ag.CurrentAgreementId)))
 	// This routine does not need to be a subworker because it will terminate on its own.
 	go cph.TerminateAgreement(ag, reason, workerId)
 
 }
 
 var BAWlogstring = func(workerID string, v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("Base Agreement Worker", cutil.LogFields{cutil.LOG_WORKER_ID: workerID}, v)
+	}
 	return fmt.Sprintf("Base Agreement Worker (%v): %v", workerID, v)
 }
 
+// Log lines about a specific agreement carry the agreement, device and protocol as fields when structured logging is
+// on. The text format is the same as BAWlogstring.
+var BAWlogstringA = func(workerID string, agreementId string, deviceId string, protocol string, v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("Base Agreement Worker", cutil.LogFields{cutil.LOG_WORKER_ID: workerID, cutil.LOG_AGREEMENT_ID: agreementId, cutil.LOG_DEVICE_ID: deviceId, cutil.LOG_PROTOCOL: protocol}, v)
+	}
+	return BAWlogstring(workerID, v)
+}
+
 // This function checks the Exchange for every declared HA partner to verify that the partner is registered in the
 // exchange. As long as all partners are registered, agreements can be made. The partners dont have to be up and heart
 // beating, they just have to be registered. If not all partners are registered then no agreements will be attempted
@@ -707,7 +842,7 @@ func (b *BaseAgreementWorker) incompleteHAGroup(cph ConsumerProtocolHandler, pro
 		// Make sure all partners are in the exchange
 		for _, partnerId := range producerPolicy.HAGroup.Partners {
 
-			if _, err := GetDevice(b.config.Collaborators.HTTPClientFactory.NewHTTPClient(nil), partnerId, b.config.AgreementBot.ExchangeURL, cph.GetExchangeId(), cph.GetExchangeToken()); err != nil {
+			if _, err := GetDevice(exchange.ShutdownContext(), b.config.Collaborators.HTTPClientFactory.NewHTTPClient(nil), partnerId, b.config.AgreementBot.ExchangeURL, cph.GetExchangeId(), cph.GetExchangeToken()); err != nil {
 				return errors.New(fmt.Sprintf("could not obtain device %v from the exchange: %v", partnerId, err))
 			}
 		}
@@ -716,6 +851,92 @@ func (b *BaseAgreementWorker) incompleteHAGroup(cph ConsumerProtocolHandler, pro
 	}
 }
 
+// This function checks the placement constraints of a consumer policy against the agreements the device has in the
+// exchange, which tell us which workloads are already running on the device. The agreements in the exchange dont record
+// the org of the workload, so running workloads are identified by URL only.
+func (b *BaseAgreementWorker) unsatisfiedPlacement(cph ConsumerProtocolHandler, deviceId string, consumerPolicy *policy.Policy) error {
+
+	// If there are no placement constraints, there is nothing to check.
+	if consumerPolicy.Placement.IsEmpty() {
+		return nil
+	}
+
+	agreements, err := GetDeviceAgreements(exchange.ShutdownContext(), b.config.Collaborators.HTTPClientFactory.NewHTTPClient(nil), deviceId, b.config.AgreementBot.ExchangeURL, cph.GetExchangeId(), cph.GetExchangeToken())
+	if err != nil {
+		return errors.New(fmt.Sprintf("could not obtain device %v agreements from the exchange: %v", deviceId, err))
+	}
+
+	return consumerPolicy.Placement.Is_Satisfied_By(runningWorkloads(agreements))
+}
+
+// The workloads running on a device, from its agreements in the exchange.
+func runningWorkloads(agreements map[string]exchange.DeviceAgreement) []policy.PlacementWorkload {
+	running := make([]policy.PlacementWorkload, 0, len(agreements))
+	for _, ag := range agreements {
+		if ag.AgreementService.URL != "" {
+			running = append(running, policy.PlacementWorkload{URL: ag.AgreementService.URL})
+		} else if ag.Workload.URL != "" {
+			running = append(running, policy.PlacementWorkload{URL: ag.Workload.URL})
+		}
+	}
+	return running
+}
+
+// Merge the policies of the services on the device that the workload requires into 1 producer policy. Returns nil
+// when the device has not registered any of them.
+func mergeServicePolicies(dev *exchange.Device, asl *policy.APISpecList, serviceBased bool, noDataIntervalS uint64) (*policy.Policy, error) {
+
+	services := dev.RegisteredServices
+	if !serviceBased {
+		services = dev.RegisteredMicroservices
+	}
+
+	var merged *policy.Policy
+	for _, apiSpec := range *asl {
+		// Find the device's service definition based on the services needed by the workload.
+		for _, devMS := range services {
+			if devMS.Url == apiSpec.SpecRef {
+				if pol, err := policy.DemarshalPolicy(devMS.Policy); err != nil {
+					return nil, errors.New(fmt.Sprintf("error demarshalling policy of %v, error: %v", devMS.Url, err))
+				} else if merged == nil {
+					merged = pol
+				} else if newPolicy, err := policy.Are_Compatible_Producers(merged, pol, noDataIntervalS); err != nil {
+					return nil, err
+				} else {
+					merged = newPolicy
+				}
+				break
+			}
+		}
+	}
+	return merged, nil
+}
+
+// Save the deployment and implementation package details of the workload definition into the workload. Returns true
+// when the definition has a legacy torrent field, which is ignored.
+func addWorkloadDetails(workload *policy.Workload, details exchange.ExchangeDefinition) (bool, error) {
+	workload.Deployment = details.GetDeployment()
+	workload.DeploymentSignature = details.GetDeploymentSignature()
+	workload.ImageStore = details.GetImageStore()
+	workload.ImageFetch = details.GetImageFetch()
+	if exchange.IsLegacyTorrentField(details.GetTorrent()) {
+		workload.Torrent = workload.ImageStore.ConvertToTorrent()
+		return true, nil
+	} else if details.GetTorrent() != "" {
+		torr := new(policy.Torrent)
+		if err := json.Unmarshal([]byte(details.GetTorrent()), torr); err != nil {
+			return false, errors.New(fmt.Sprintf("Unable to demarshal torrent info from %v, error: %v", details, err))
+		}
+		workload.Torrent = *torr
+	} else {
+		// Since the torrent field is empty, we can convert the Package implementation to a Torrent object. The conversion
+		// might result in an empty object which would be normal when the ImageStore field does not contain metadata
+		// pointing to an image server.
+		workload.Torrent = workload.ImageStore.ConvertToTorrent()
+	}
+	return false, nil
+}
+
 // Legacy function. Ignore devices that export specificly known configured properties.
 func (b *BaseAgreementWorker) ignoreDevice(pol *policy.Policy) (bool, error) {
 
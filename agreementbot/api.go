@@ -1,6 +1,7 @@
 package agreementbot
 
 import (
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"github.com/boltdb/bolt"
@@ -8,6 +9,7 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/open-horizon/anax/apicommon"
 	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/exchange"
 	"github.com/open-horizon/anax/policy"
@@ -15,7 +17,10 @@ import (
 	"io/ioutil"
 	"net/http"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 type API struct {
@@ -26,9 +31,10 @@ type API struct {
 	bcState        map[string]map[string]apicommon.BlockchainState
 	bcStateLock    sync.Mutex
 	EC             *worker.BaseExchangeContext
+	agbot          *AgreementBotWorker // The source of the blockchain readiness reported by the API
 }
 
-func NewAPIListener(name string, config *config.HorizonConfig, db *bolt.DB) *API {
+func NewAPIListener(name string, config *config.HorizonConfig, db *bolt.DB, agbot *AgreementBotWorker) *API {
 	messages := make(chan events.Message)
 
 	listener := &API{
@@ -37,9 +43,11 @@ func NewAPIListener(name string, config *config.HorizonConfig, db *bolt.DB) *API
 			Messages: messages,
 		},
 
-		name: name,
-		db:   db,
-		EC:   worker.NewExchangeContext(config.AgreementBot.ExchangeId, config.AgreementBot.ExchangeToken, config.AgreementBot.ExchangeURL, false, config.Collaborators.HTTPClientFactory),
+		name:    name,
+		db:      db,
+		bcState: make(map[string]map[string]apicommon.BlockchainState),
+		EC:      worker.NewExchangeContext(config.AgreementBot.ExchangeId, config.AgreementBot.ExchangeToken, config.AgreementBot.ExchangeURL, false, config.Collaborators.HTTPClientFactory),
+		agbot:   agbot,
 	}
 
 	listener.listen(config.AgreementBot.APIListen)
@@ -157,16 +165,42 @@ func (a *API) listen(apiListen string) {
 
 		router.HandleFunc("/agreement", a.agreement).Methods("GET", "OPTIONS")
 		router.HandleFunc("/agreement/{id}", a.agreement).Methods("GET", "DELETE", "OPTIONS")
+		router.HandleFunc("/agreement/{id}/data", a.agreementdata).Methods("POST", "OPTIONS")
 		router.HandleFunc("/policy", a.policy).Methods("GET", "OPTIONS")
 		router.HandleFunc("/policy/{org}", a.policy).Methods("GET", "OPTIONS")
 		router.HandleFunc("/policy/{org}/{name}", a.policy).Methods("GET", "OPTIONS")
 		router.HandleFunc("/policy/{name}/upgrade", a.policy).Methods("POST", "OPTIONS")
 		router.HandleFunc("/workloadusage", a.workloadusage).Methods("GET", "OPTIONS")
+		router.HandleFunc("/usage", a.usage).Methods("GET", "OPTIONS")
+		router.HandleFunc("/metering", a.metering).Methods("GET", "OPTIONS")
 		router.HandleFunc("/status", a.status).Methods("GET", "OPTIONS")
 		router.HandleFunc("/status/workers", a.workerstatus).Methods("GET", "OPTIONS")
+		router.HandleFunc("/status/exchange", a.exchangestatus).Methods("GET", "OPTIONS")
+		router.HandleFunc("/status/blockchains", a.blockchainstatus).Methods("GET", "OPTIONS")
+		router.HandleFunc("/status/governance", a.governancestatus).Methods("GET", "OPTIONS")
+		router.HandleFunc("/blockchain/blacklist", a.blockchainblacklist).Methods("GET", "DELETE", "OPTIONS")
+		router.HandleFunc("/blockchain/blacklist/{org}/{type}/{name}", a.blockchainblacklist).Methods("GET", "DELETE", "OPTIONS")
+		router.HandleFunc("/negotiation", a.negotiation).Methods("GET", "DELETE", "OPTIONS")
+		router.HandleFunc("/negotiation/{org}/{id}", a.negotiation).Methods("GET", "DELETE", "OPTIONS")
 		router.HandleFunc("/node", a.node).Methods("GET", "OPTIONS")
-
-		http.ListenAndServe(apiListen, nocache(router))
+		router.HandleFunc("/shutdown", a.shutdown).Methods("POST", "OPTIONS")
+		router.HandleFunc("/maintenance", a.maintenance).Methods("GET", "PUT", "OPTIONS")
+		router.HandleFunc("/consistency", a.consistency).Methods("GET", "OPTIONS")
+		router.HandleFunc("/quota", a.quota).Methods("GET", "OPTIONS")
+		router.HandleFunc("/quota/{org}", a.quota).Methods("GET", "PUT", "DELETE", "OPTIONS")
+		router.HandleFunc("/diagnostic", a.diagnostic).Methods("GET", "OPTIONS")
+		router.HandleFunc("/simulate", a.simulate).Methods("GET", "OPTIONS")
+		router.HandleFunc("/bulkcancel", a.bulkcancel).Methods("GET", "OPTIONS")
+		router.HandleFunc("/bulkcancel/{name}", a.bulkcancel).Methods("GET", "POST", "DELETE", "OPTIONS")
+		router.HandleFunc("/bulkcancel/{name}/{action}", a.bulkcancelaction).Methods("POST", "OPTIONS")
+		router.HandleFunc("/upgrade/rollout", a.upgraderollout).Methods("GET", "OPTIONS")
+		router.HandleFunc("/upgrade/rollout/{name}", a.upgraderollout).Methods("GET", "POST", "DELETE", "OPTIONS")
+		router.HandleFunc("/upgrade/rollout/{name}/{action}", a.upgraderolloutaction).Methods("POST", "OPTIONS")
+		router.HandleFunc("/logtrace", a.logtrace).Methods("GET", "POST", "DELETE", "OPTIONS")
+
+		if err := apicommon.ListenAndServe("AgreementBot API", apiListen, nocache(router), a.Config.AgreementBot.APITLS); err != nil {
+			glog.Errorf(APIlogString(fmt.Sprintf("API server on %v stopped, error: %v", apiListen, err)))
+		}
 	}()
 }
 
@@ -197,8 +231,20 @@ func (a *API) agreement(w http.ResponseWriter, r *http.Request) {
 			wrap[agreementsKey][archivedKey] = []Agreement{}
 			wrap[agreementsKey][activeKey] = []Agreement{}
 
+			// The agreements can be narrowed down by org, pattern and device.
+			filters := []AFilter{}
+			if org := r.URL.Query().Get("org"); org != "" {
+				filters = append(filters, OrgAFilter(org))
+			}
+			if pattern := r.URL.Query().Get("pattern"); pattern != "" {
+				filters = append(filters, PatternAFilter(pattern))
+			}
+			if device := r.URL.Query().Get("device"); device != "" {
+				filters = append(filters, DeviceAFilter(device))
+			}
+
 			for _, agp := range policy.AllAgreementProtocols() {
-				if ags, err := FindAgreements(a.db, []AFilter{}, agp); err != nil {
+				if ags, err := FindAgreements(a.db, filters, agp); err != nil {
 					glog.Error(APIlogString(fmt.Sprintf("error finding all agreements, error: %v", err)))
 					http.Error(w, "Internal server error", http.StatusInternalServerError)
 					return
@@ -234,6 +280,13 @@ func (a *API) agreement(w http.ResponseWriter, r *http.Request) {
 		}
 		glog.V(3).Infof(APIlogString(fmt.Sprintf("handling DELETE of agreement: %v", r)))
 
+		// The user can choose the reason the agreement is terminated with, the default is that the user requested it.
+		reason := r.URL.Query().Get("reason")
+		if reason != "" && !IsUserTerminationReason(reason) {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "reason", Error: fmt.Sprintf("termination reason %v is not supported, use one of %v", reason, userTerminationReasons)})
+			return
+		}
+
 		if ag, err := FindSingleAgreementByAgreementIdAllProtocols(a.db, id, policy.AllAgreementProtocols(), []AFilter{UnarchivedAFilter()}); err != nil {
 			glog.Error(APIlogString(fmt.Sprintf("error finding agreement %v, error: %v", id, err)))
 			w.WriteHeader(http.StatusInternalServerError)
@@ -245,7 +298,7 @@ func (a *API) agreement(w http.ResponseWriter, r *http.Request) {
 				if _, err := AgreementTimedout(a.db, ag.CurrentAgreementId, ag.AgreementProtocol); err != nil {
 					glog.Errorf(APIlogString(fmt.Sprintf("error marking agreement %v terminated: %v", ag.CurrentAgreementId, err)))
 				}
-				a.Messages() <- events.NewABApiAgreementCancelationMessage(events.AGREEMENT_ENDED, ag.AgreementProtocol, ag.CurrentAgreementId)
+				a.Messages() <- events.NewABApiAgreementCancelationMessage(events.AGREEMENT_ENDED, ag.AgreementProtocol, ag.CurrentAgreementId, reason)
 			} else {
 				glog.V(3).Infof(APIlogString(fmt.Sprintf("agreement %v not deleted, already timed out at %v", id, ag.AgreementTimedout)))
 			}
@@ -260,6 +313,42 @@ func (a *API) agreement(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// The data ingest systems of the agreements with webhook data verification tell the agbot that they received data.
+// When the agreement's data verification has a user, the push must be authenticated with its user and password.
+func (a *API) agreementdata(w http.ResponseWriter, r *http.Request) {
+
+	switch r.Method {
+	case "POST":
+		pathVars := mux.Vars(r)
+		id := pathVars["id"]
+
+		glog.V(5).Infof(APIlogString(fmt.Sprintf("handling data push for agreement %v", id)))
+
+		if ag, err := FindSingleAgreementByAgreementIdAllProtocols(a.db, id, policy.AllAgreementProtocols(), []AFilter{UnarchivedAFilter()}); err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error finding agreement %v, error: %v", id, err)))
+			w.WriteHeader(http.StatusInternalServerError)
+		} else if ag == nil || ag.AgreementTimedout != 0 {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "id", Error: "agreement id not found"})
+		} else if ag.DisableDataVerificationChecks || dataVerificationType(ag) != policy.DATA_VERIFICATION_WEBHOOK {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "id", Error: fmt.Sprintf("agreement does not use %v data verification", policy.DATA_VERIFICATION_WEBHOOK)})
+		} else if user, pw, _ := r.BasicAuth(); ag.DataVerificationUser != "" && (user != ag.DataVerificationUser || pw != ag.DataVerificationPW) {
+			glog.Warningf(APIlogString(fmt.Sprintf("rejected data push for agreement %v, wrong credentials for user %v", id, user)))
+			w.WriteHeader(http.StatusUnauthorized)
+		} else if a.agbot == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			a.agbot.dataPushes.Record(id, uint64(time.Now().Unix()))
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "POST, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
 func (a *API) policy(w http.ResponseWriter, r *http.Request) {
 
 	workloadOrServiceResolver := func(wURL string, wOrg string, wVersion string, wArch string) (*policy.APISpecList, error) {
@@ -432,6 +521,147 @@ func (a *API) workloadusage(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// The resource usage reported by the nodes for their active agreements, narrowed down by org, pattern and device
+// like the agreements.
+func (a *API) usage(w http.ResponseWriter, r *http.Request) {
+
+	switch r.Method {
+	case "GET":
+		filters := []AFilter{UnarchivedAFilter()}
+		if org := r.URL.Query().Get("org"); org != "" {
+			filters = append(filters, OrgAFilter(org))
+		}
+		if pattern := r.URL.Query().Get("pattern"); pattern != "" {
+			filters = append(filters, PatternAFilter(pattern))
+		}
+		if device := r.URL.Query().Get("device"); device != "" {
+			filters = append(filters, DeviceAFilter(device))
+		}
+
+		ags := []Agreement{}
+		for _, agp := range policy.AllAgreementProtocols() {
+			if agps, err := FindAgreements(a.db, filters, agp); err != nil {
+				glog.Error(APIlogString(fmt.Sprintf("error finding agreements, error: %v", err)))
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			} else {
+				ags = append(ags, agps...)
+			}
+		}
+
+		// write output
+		writeResponse(w, SummarizeResourceUsage(ags), http.StatusOK)
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// The metering notifications sent by the agbot, for reconciliation with a billing system. The records sent between
+// since and until (in seconds since 1970) are returned, narrowed down by org and pattern, as JSON or as CSV with
+// format=csv. With aggregate=true, the tokens granted are added up by org and pattern, and by windows of window
+// seconds when window is set.
+func (a *API) metering(w http.ResponseWriter, r *http.Request) {
+
+	switch r.Method {
+	case "GET":
+		query := r.URL.Query()
+		var since, until, window uint64
+		var err error
+		aggregate := false
+
+		if since, err = queryUint(query.Get("since")); err != nil {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "since", Error: "since must be the number of seconds since 1970"})
+			return
+		} else if until, err = queryUint(query.Get("until")); err != nil {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "until", Error: "until must be the number of seconds since 1970"})
+			return
+		} else if window, err = queryUint(query.Get("window")); err != nil {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "window", Error: "window must be a number of seconds"})
+			return
+		} else if agg := query.Get("aggregate"); agg != "" {
+			if aggregate, err = strconv.ParseBool(agg); err != nil {
+				writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "aggregate", Error: "aggregate must be true or false"})
+				return
+			}
+		}
+
+		format := query.Get("format")
+		if format != "" && format != "json" && format != "csv" {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "format", Error: "format must be json or csv"})
+			return
+		} else if window != 0 && !aggregate {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "window", Error: "window can only be used with aggregate=true"})
+			return
+		}
+
+		filters := []MRFilter{}
+		if org := query.Get("org"); org != "" {
+			filters = append(filters, OrgMRFilter(org))
+		}
+		if pattern := query.Get("pattern"); pattern != "" {
+			filters = append(filters, PatternMRFilter(pattern))
+		}
+
+		records, err := FindMeteringRecords(a.db, since, until, filters)
+		if err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error finding metering records, error: %v", err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if aggregate {
+			aggregates := AggregateMeteringRecords(records, window)
+			if format == "csv" {
+				rows := make([][]string, 0, len(aggregates))
+				for _, m := range aggregates {
+					rows = append(rows, m.CSVRow())
+				}
+				writeCSVResponse(w, MeteringAggregateCSVHeader, rows)
+			} else {
+				writeResponse(w, aggregates, http.StatusOK)
+			}
+		} else if format == "csv" {
+			rows := make([][]string, 0, len(records))
+			for _, m := range records {
+				rows = append(rows, m.CSVRow())
+			}
+			writeCSVResponse(w, MeteringRecordCSVHeader, rows)
+		} else {
+			writeResponse(w, records, http.StatusOK)
+		}
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Returns the value of an unsigned integer query parameter, zero when it is not set.
+func queryUint(value string) (uint64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+func writeCSVResponse(w http.ResponseWriter, header []string, rows [][]string) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(w)
+	writer.Write(header)
+	writer.WriteAll(rows)
+	if err := writer.Error(); err != nil {
+		glog.Error(APIlogString(fmt.Sprintf("error writing CSV response, error: %v", err)))
+	}
+}
+
 func (a *API) status(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
@@ -465,6 +695,495 @@ func (a *API) status(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Start a graceful shutdown of the agbot. The agbot stops making new agreements, finishes its in-flight
+// agreement work and then the process exits.
+func (a *API) shutdown(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		glog.V(3).Infof(APIlogString(fmt.Sprintf("Handling shutdown request")))
+		a.Messages() <- events.NewAgbotShutdownMessage(events.AGBOT_SHUTDOWN, "shutdown requested through the API")
+		w.WriteHeader(http.StatusAccepted)
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "POST, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Get or set the maintenance mode of the agbot. While the agbot is in maintenance mode, it doesn't make new agreements
+// and it doesn't cancel agreements because their policy changed.
+func (a *API) maintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		if mode, err := FindMaintenanceMode(a.db); err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error finding maintenance mode, error: %v", err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		} else {
+			writeResponse(w, *mode, http.StatusOK)
+		}
+
+	case "PUT":
+		var input MaintenanceMode
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &input); err != nil {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "body", Error: fmt.Sprintf("user submitted data couldn't be deserialized to struct: %v. Error: %v", string(body), err)})
+		} else if mode, err := SetMaintenanceMode(a.db, input.Enabled, input.Reason); err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error setting maintenance mode, error: %v", err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		} else {
+			glog.Infof(APIlogString(fmt.Sprintf("maintenance mode set to %v", mode)))
+			writeResponse(w, *mode, http.StatusOK)
+		}
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, PUT, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// The report of the consistency check the agbot made when it started.
+func (a *API) consistency(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		if report, err := FindConsistencyReport(a.db); err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error finding consistency report, error: %v", err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		} else if report == nil {
+			writeResponse(w, ConsistencyReport{Findings: []ConsistencyFinding{}}, http.StatusOK)
+		} else {
+			writeResponse(w, *report, http.StatusOK)
+		}
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// The quota of an org, and its usage when the agbot is running.
+func (a *API) orgQuotaStatus(org string, replaced map[string]OrgQuota) OrgQuotaStatus {
+	status := OrgQuotaStatus{Org: org}
+	status.Quota, status.Source = lookupOrgQuota(org, replaced, a.Config.AgreementBot.OrgQuotas)
+	if a.agbot != nil && org != QUOTA_DEFAULT_ORG {
+		status.Usage = a.agbot.quotas.Usage(org)
+	}
+	return status
+}
+
+// Get the quotas of the orgs served by the agbot, or replace or reset the quota of an org at runtime. The quotas
+// of all the orgs that have a quota or that the agbot is serving are returned, ordered by org.
+func (a *API) quota(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+	org := pathVars["org"]
+
+	switch r.Method {
+	case "GET":
+		replaced, err := FindOrgQuotas(a.db)
+		if err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error finding org quotas, error: %v", err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		} else if org != "" {
+			writeResponse(w, a.orgQuotaStatus(org, replaced), http.StatusOK)
+			return
+		}
+
+		orgs := make(map[string]bool)
+		for o, _ := range a.Config.AgreementBot.OrgQuotas {
+			orgs[o] = true
+		}
+		for o, _ := range replaced {
+			orgs[o] = true
+		}
+		if a.agbot != nil {
+			for _, o := range a.agbot.quotas.Orgs() {
+				orgs[o] = true
+			}
+		}
+		names := make([]string, 0, len(orgs))
+		for o, _ := range orgs {
+			names = append(names, o)
+		}
+		sort.Strings(names)
+
+		quotas := make([]OrgQuotaStatus, 0, len(names))
+		for _, o := range names {
+			quotas = append(quotas, a.orgQuotaStatus(o, replaced))
+		}
+		writeResponse(w, quotas, http.StatusOK)
+
+	case "PUT":
+		var input OrgQuota
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &input); err != nil {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "body", Error: fmt.Sprintf("user submitted data couldn't be deserialized to struct: %v. Error: %v", string(body), err)})
+		} else if err := input.Validate(); err != nil {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "body", Error: err.Error()})
+		} else if err := SetOrgQuota(a.db, org, input); err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error setting quota of org %v, error: %v", org, err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		} else {
+			glog.Infof(APIlogString(fmt.Sprintf("quota of org %v set to %v", org, input)))
+			writeResponse(w, a.orgQuotaStatus(org, map[string]OrgQuota{org: input}), http.StatusOK)
+		}
+
+	case "DELETE":
+		if err := DeleteOrgQuota(a.db, org); err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error deleting quota of org %v, error: %v", org, err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		} else if replaced, err := FindOrgQuotas(a.db); err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error finding org quotas, error: %v", err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		} else {
+			glog.Infof(APIlogString(fmt.Sprintf("quota of org %v reset", org)))
+			writeResponse(w, a.orgQuotaStatus(org, replaced), http.StatusOK)
+		}
+
+	case "OPTIONS":
+		if org == "" {
+			w.Header().Set("Allow", "GET, OPTIONS")
+		} else {
+			w.Header().Set("Allow", "GET, PUT, DELETE, OPTIONS")
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Run the agbot self-diagnostic and return the findings.
+func (a *API) bulkcancel(w http.ResponseWriter, r *http.Request) {
+
+	pathVars := mux.Vars(r)
+	name := pathVars["name"]
+
+	switch r.Method {
+	case "GET":
+		if name == "" {
+			if jobs, err := FindBulkCancelJobs(a.db); err != nil {
+				glog.Error(APIlogString(fmt.Sprintf("error finding bulk cancel jobs, error: %v", err)))
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			} else {
+				writeResponse(w, jobs, http.StatusOK)
+			}
+		} else if job, err := FindBulkCancelJob(a.db, name); err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error finding bulk cancel job %v, error: %v", name, err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		} else if job == nil {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "name", Error: "bulk cancel job not found"})
+		} else {
+			writeResponse(w, *job, http.StatusOK)
+		}
+
+	case "POST":
+		// Planning a job is a dry run, the matching agreements are recorded but not cancelled.
+		glog.V(3).Infof(APIlogString(fmt.Sprintf("handling POST of bulk cancel job %v", name)))
+
+		var plan BulkCancelPlan
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &plan); err != nil {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "body", Error: fmt.Sprintf("user submitted data couldn't be deserialized to struct: %v. Error: %v", string(body), err)})
+		} else if err := plan.Filter.IsValid(); err != nil {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "filter", Error: err.Error()})
+		} else if existing, err := FindBulkCancelJob(a.db, name); err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error finding bulk cancel job %v, error: %v", name, err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		} else if existing != nil {
+			writeInputErr(w, http.StatusConflict, &APIUserInputError{Input: "name", Error: "bulk cancel job already exists"})
+		} else if job, err := PlanBulkCancelJob(a.db, name, plan.Filter, plan.BatchSize, plan.BatchIntervalS); err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error planning bulk cancel job %v, error: %v", name, err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		} else {
+			glog.V(3).Infof(APIlogString(fmt.Sprintf("planned bulk cancel job %v", job)))
+			writeResponse(w, *job, http.StatusCreated)
+		}
+
+	case "DELETE":
+		glog.V(3).Infof(APIlogString(fmt.Sprintf("handling DELETE of bulk cancel job %v", name)))
+
+		if job, err := FindBulkCancelJob(a.db, name); err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error finding bulk cancel job %v, error: %v", name, err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		} else if job == nil {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "name", Error: "bulk cancel job not found"})
+		} else if job.State == BULK_CANCEL_RUNNING {
+			writeInputErr(w, http.StatusConflict, &APIUserInputError{Input: "name", Error: "bulk cancel job is running, abort it before deleting it"})
+		} else if err := DeleteBulkCancelJob(a.db, name); err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error deleting bulk cancel job %v, error: %v", name, err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, POST, DELETE, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *API) bulkcancelaction(w http.ResponseWriter, r *http.Request) {
+
+	pathVars := mux.Vars(r)
+	name := pathVars["name"]
+	action := pathVars["action"]
+
+	switch r.Method {
+	case "POST":
+		glog.V(3).Infof(APIlogString(fmt.Sprintf("handling %v of bulk cancel job %v", action, name)))
+
+		var change func(*bolt.DB, string) (*BulkCancelJob, error)
+		if action == "start" {
+			change = StartBulkCancelJob
+		} else if action == "abort" {
+			change = AbortBulkCancelJob
+		} else {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "action", Error: fmt.Sprintf("action %v is not supported, must be start or abort", action)})
+			return
+		}
+
+		if job, err := FindBulkCancelJob(a.db, name); err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error finding bulk cancel job %v, error: %v", name, err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		} else if job == nil {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "name", Error: "bulk cancel job not found"})
+		} else if job, err := change(a.db, name); err != nil {
+			writeInputErr(w, http.StatusConflict, &APIUserInputError{Input: "name", Error: err.Error()})
+		} else {
+			writeResponse(w, *job, http.StatusOK)
+		}
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "POST, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *API) upgraderollout(w http.ResponseWriter, r *http.Request) {
+
+	pathVars := mux.Vars(r)
+	name := pathVars["name"]
+
+	switch r.Method {
+	case "GET":
+		if name == "" {
+			if rollouts, err := FindUpgradeRollouts(a.db); err != nil {
+				glog.Error(APIlogString(fmt.Sprintf("error finding upgrade rollouts, error: %v", err)))
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			} else {
+				writeResponse(w, rollouts, http.StatusOK)
+			}
+		} else if rollout, err := FindUpgradeRollout(a.db, name); err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error finding upgrade rollout %v, error: %v", name, err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		} else if rollout == nil {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "name", Error: "upgrade rollout not found"})
+		} else {
+			writeResponse(w, *rollout, http.StatusOK)
+		}
+
+	case "POST":
+		// Unlike a bulk cancel job, a rollout starts right away. The first batch is upgraded by the next governance pass.
+		glog.V(3).Infof(APIlogString(fmt.Sprintf("handling POST of upgrade rollout %v", name)))
+
+		var req UpgradeRolloutRequest
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "body", Error: fmt.Sprintf("user submitted data couldn't be deserialized to struct: %v. Error: %v", string(body), err)})
+		} else if err := req.IsValid(); err != nil {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "body", Error: err.Error()})
+		} else if existing, err := FindUpgradeRollout(a.db, name); err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error finding upgrade rollout %v, error: %v", name, err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		} else if existing != nil {
+			writeInputErr(w, http.StatusConflict, &APIUserInputError{Input: "name", Error: "upgrade rollout already exists"})
+		} else if rollout, err := StartUpgradeRollout(a.db, name, req); err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error starting upgrade rollout %v, error: %v", name, err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		} else {
+			glog.V(3).Infof(APIlogString(fmt.Sprintf("started upgrade rollout %v", rollout)))
+			writeResponse(w, *rollout, http.StatusCreated)
+		}
+
+	case "DELETE":
+		glog.V(3).Infof(APIlogString(fmt.Sprintf("handling DELETE of upgrade rollout %v", name)))
+
+		if rollout, err := FindUpgradeRollout(a.db, name); err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error finding upgrade rollout %v, error: %v", name, err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		} else if rollout == nil {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "name", Error: "upgrade rollout not found"})
+		} else if rollout.State == ROLLOUT_RUNNING {
+			writeInputErr(w, http.StatusConflict, &APIUserInputError{Input: "name", Error: "upgrade rollout is running, abort it before deleting it"})
+		} else if err := DeleteUpgradeRollout(a.db, name); err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error deleting upgrade rollout %v, error: %v", name, err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, POST, DELETE, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *API) upgraderolloutaction(w http.ResponseWriter, r *http.Request) {
+
+	pathVars := mux.Vars(r)
+	name := pathVars["name"]
+	action := pathVars["action"]
+
+	switch r.Method {
+	case "POST":
+		glog.V(3).Infof(APIlogString(fmt.Sprintf("handling %v of upgrade rollout %v", action, name)))
+
+		var change func(*bolt.DB, string) (*UpgradeRollout, error)
+		if action == "pause" {
+			change = PauseUpgradeRollout
+		} else if action == "resume" {
+			change = ResumeUpgradeRollout
+		} else if action == "abort" {
+			change = AbortUpgradeRollout
+		} else {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "action", Error: fmt.Sprintf("action %v is not supported, must be pause, resume or abort", action)})
+			return
+		}
+
+		if rollout, err := FindUpgradeRollout(a.db, name); err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error finding upgrade rollout %v, error: %v", name, err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		} else if rollout == nil {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "name", Error: "upgrade rollout not found"})
+		} else if rollout, err := change(a.db, name); err != nil {
+			writeInputErr(w, http.StatusConflict, &APIUserInputError{Input: "name", Error: err.Error()})
+		} else {
+			writeResponse(w, *rollout, http.StatusOK)
+		}
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "POST, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// The body of a POST to /logtrace. Zero level or duration selects the default.
+type LogTraceRequest struct {
+	AgreementId string `json:"agreement_id,omitempty"`
+	DeviceId    string `json:"device_id,omitempty"`
+	Level       int    `json:"level,omitempty"`
+	DurationS   int    `json:"duration_s,omitempty"`
+}
+
+// Raise the log verbosity for a single agreement or device, without raising it for the rest of the agbot.
+func (a *API) logtrace(w http.ResponseWriter, r *http.Request) {
+
+	switch r.Method {
+	case "GET":
+		writeResponse(w, cutil.GetLogTraces(), http.StatusOK)
+
+	case "POST":
+		var req LogTraceRequest
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "body", Error: fmt.Sprintf("user submitted data couldn't be deserialized to struct: %v. Error: %v", string(body), err)})
+		} else if trace, err := cutil.AddLogTrace(req.AgreementId, req.DeviceId, req.Level, req.DurationS); err != nil {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "body", Error: err.Error()})
+		} else {
+			glog.Infof(APIlogString(fmt.Sprintf("started log trace %v", trace)))
+			writeResponse(w, *trace, http.StatusCreated)
+		}
+
+	case "DELETE":
+		agreementId := r.URL.Query().Get("agreement_id")
+		deviceId := r.URL.Query().Get("device_id")
+		if (agreementId == "") == (deviceId == "") {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "agreement_id", Error: "either agreement_id or device_id must be specified"})
+		} else if !cutil.RemoveLogTrace(agreementId, deviceId) {
+			writeInputErr(w, http.StatusNotFound, &APIUserInputError{Input: "agreement_id", Error: fmt.Sprintf("no log trace for agreement %v device %v", agreementId, deviceId)})
+		} else {
+			glog.Infof(APIlogString(fmt.Sprintf("stopped log trace for agreement %v device %v", agreementId, deviceId)))
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, POST, DELETE, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *API) diagnostic(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		glog.V(3).Infof(APIlogString(fmt.Sprintf("Handling diagnostic request")))
+
+		// Collect the endpoints of the running blockchain clients.
+		endpoints := make(BlockchainEndpoints)
+		a.bcStateLock.Lock()
+		for bcType, instances := range a.bcState {
+			endpoints[bcType] = make(map[string]string)
+			for name, bc := range instances {
+				endpoints[bcType][name] = fmt.Sprintf("%v:%v", bc.GetService(), bc.GetServicePort())
+			}
+		}
+		a.bcStateLock.Unlock()
+
+		diag := NewDiagnostics(a.GetHTTPFactory().NewHTTPClient(nil), a.GetExchangeURL(), a.GetExchangeId(), a.GetExchangeToken(),
+			a.Config.AgreementBot.PolicyPath, a.Config.AgreementBot.InMemoryPatternPolicies, a.db, endpoints)
+		diag.transport = exchange.AgbotMessageTransport(a.Config)
+		writeResponse(w, diag.Run(), http.StatusOK)
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Simulate the agreements the agbot would propose to a node for a pattern, without proposing them.
+func (a *API) simulate(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		nodeId := r.URL.Query().Get("node")
+		patternId := r.URL.Query().Get("pattern")
+		glog.V(3).Infof(APIlogString(fmt.Sprintf("Handling simulate request for node %v pattern %v", nodeId, patternId)))
+
+		if nodeId == "" || len(strings.Split(nodeId, "/")) != 2 {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "node", Error: "node must be specified as org/id"})
+		} else if patternId == "" || len(strings.Split(patternId, "/")) != 2 {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "pattern", Error: "pattern must be specified as org/name"})
+		} else if a.agbot == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else if sims, err := a.agbot.SimulateAgreements(nodeId, patternId); err != nil {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "node", Error: err.Error()})
+		} else {
+			writeResponse(w, sims, http.StatusOK)
+		}
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
 func (a *API) workerstatus(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
@@ -478,6 +1197,158 @@ func (a *API) workerstatus(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Get the metadata of the most recent exchange calls.
+func (a *API) exchangestatus(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeResponse(w, apicommon.NewExchangeStatus(), http.StatusOK)
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Report whether each blockchain client the agbot is using is ready and writable.
+func (a *API) blockchainstatus(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		states := []BlockchainReadiness{}
+		if a.agbot != nil {
+			states = a.agbot.BlockchainStates()
+		}
+		writeResponse(w, states, http.StatusOK)
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Get the duration of the agreement governance passes and the work done by each governance shard in the last one.
+func (a *API) governancestatus(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		stats := GovernanceStats{LastPassShards: []GovernanceShardStats{}}
+		if a.agbot != nil {
+			stats = a.agbot.GovernanceStats()
+		}
+		writeResponse(w, stats, http.StatusOK)
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Get or clear the failures of the blockchains that failed to initialize or to record agreements. The blacklisted
+// blockchains are the ones that reached the failure limit, no client containers are started for them until they are
+// cleared.
+func (a *API) blockchainblacklist(w http.ResponseWriter, r *http.Request) {
+
+	pathVars := mux.Vars(r)
+	org := pathVars["org"]
+	typeName := pathVars["type"]
+	name := pathVars["name"]
+
+	switch r.Method {
+	case "GET":
+		if name == "" {
+			if all, err := FindBlockchainFailures(a.db); err != nil {
+				glog.Error(APIlogString(fmt.Sprintf("error finding blockchain failures, error: %v", err)))
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			} else {
+				writeResponse(w, all, http.StatusOK)
+			}
+		} else if f, err := FindBlockchainFailure(a.db, org, typeName, name); err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error finding failures of blockchain %v/%v/%v, error: %v", org, typeName, name, err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		} else if f == nil {
+			writeInputErr(w, http.StatusNotFound, &APIUserInputError{Input: "name", Error: "blockchain has no failures"})
+		} else {
+			writeResponse(w, *f, http.StatusOK)
+		}
+
+	case "DELETE":
+		var err error
+		cleared := "all blockchains"
+		if name == "" {
+			err = ClearAllBlockchainFailures(a.db)
+		} else {
+			cleared = fmt.Sprintf("blockchain %v/%v/%v", org, typeName, name)
+			err = ClearBlockchainFailures(a.db, org, typeName, name)
+		}
+		if err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error clearing the blacklist of %v, error: %v", cleared, err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		} else {
+			glog.Infof(APIlogString(fmt.Sprintf("cleared the failures and blacklist of %v", cleared)))
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, DELETE, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Get or clear the negotiation history of the nodes, the outcome of the most recent proposals made to each node.
+func (a *API) negotiation(w http.ResponseWriter, r *http.Request) {
+
+	pathVars := mux.Vars(r)
+	deviceId := ""
+	if id := pathVars["id"]; id != "" {
+		deviceId = fmt.Sprintf("%v/%v", pathVars["org"], id)
+	}
+
+	switch r.Method {
+	case "GET":
+		if deviceId == "" {
+			if all, err := FindNegotiationHistories(a.db); err != nil {
+				glog.Error(APIlogString(fmt.Sprintf("error finding negotiation histories, error: %v", err)))
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			} else {
+				writeResponse(w, all, http.StatusOK)
+			}
+		} else if h, err := FindNegotiationHistory(a.db, deviceId); err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error finding negotiation history of node %v, error: %v", deviceId, err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		} else if h == nil {
+			writeInputErr(w, http.StatusNotFound, &APIUserInputError{Input: "id", Error: "no proposals have been made to the node"})
+		} else {
+			writeResponse(w, *h, http.StatusOK)
+		}
+
+	case "DELETE":
+		var err error
+		cleared := "all nodes"
+		if deviceId == "" {
+			err = ClearAllNegotiationHistories(a.db)
+		} else {
+			cleared = fmt.Sprintf("node %v", deviceId)
+			err = ClearNegotiationHistory(a.db, deviceId)
+		}
+		if err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error clearing the negotiation history of %v, error: %v", cleared, err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		} else {
+			glog.Infof(APIlogString(fmt.Sprintf("cleared the negotiation history of %v", cleared)))
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, DELETE, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
 func (a *API) node(w http.ResponseWriter, r *http.Request) {
 
 	resource := "node"
@@ -587,6 +1458,9 @@ func (s WorkloadUsagesByDeviceId) Less(i, j int) bool {
 
 // Log string prefix api
 var APIlogString = func(v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("AgreementBotWorker API", nil, v)
+	}
 	return fmt.Sprintf("AgreementBotWorker API %v", v)
 }
 
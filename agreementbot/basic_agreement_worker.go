@@ -6,6 +6,7 @@ import (
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/basicprotocol"
 	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/exchange"
 	"github.com/open-horizon/anax/policy"
 	"github.com/open-horizon/anax/worker"
@@ -66,6 +67,33 @@ func (b BAgreementVerification) String() string {
 		b.workType, b.Verify, b.From, b.SenderId, pkey, b.MessageId)
 }
 
+const RESOURCE_USAGE = "RESOURCE_USAGE"
+
+type BResourceUsageReport struct {
+	workType     string
+	Usage        basicprotocol.BResourceUsage
+	SenderId     string // exchange Id of sender
+	SenderPubKey []byte
+	MessageId    int
+}
+
+func (b BResourceUsageReport) Type() string {
+	return b.workType
+}
+
+func (b BResourceUsageReport) String() string {
+	pkey := "not set"
+	if len(b.SenderPubKey) != 0 {
+		pkey = "set"
+	}
+	return fmt.Sprintf("WorkType: %v, "+
+		"Usage: %v, "+
+		"SenderId: %v, "+
+		"SenderPubKey: %v, "+
+		"MessageId: %v",
+		b.workType, b.Usage, b.SenderId, pkey, b.MessageId)
+}
+
 // This function receives an event to "make a new agreement" from the Process function, and then synchronously calls a function
 // to actually work through the agreement protocol.
 func (a *BasicAgreementWorker) start(work chan AgreementWork, random *rand.Rand) {
@@ -75,6 +103,7 @@ func (a *BasicAgreementWorker) start(work chan AgreementWork, random *rand.Rand)
 		glog.V(5).Infof(bwlogstring(a.workerID, fmt.Sprintf("blocking for work")))
 		workItem := <-work // block waiting for work
 		glog.V(2).Infof(bwlogstring(a.workerID, fmt.Sprintf("received work: %v", workItem)))
+		a.protocolHandler.workStarted()
 
 		if workItem.Type() == INITIATE {
 			wi := workItem.(InitiateAgreement)
@@ -87,11 +116,16 @@ func (a *BasicAgreementWorker) start(work chan AgreementWork, random *rand.Rand)
 				if ag, err := AgreementFinalized(a.db, wi.Reply.AgreementId(), a.protocolHandler.Name()); err != nil {
 					glog.Errorf(bwlogstring(a.workerID, fmt.Sprintf("error persisting agreement %v finalized: %v", wi.Reply.AgreementId(), err)))
 
+				} else {
+					a.protocolHandler.webhooks.Notify(NewWebhookEvent(WEBHOOK_AGREEMENT_FINALIZED, ag, 0, ""))
+					noteNegotiation(NegotiationFinalized(a.db, ag.DeviceId, ag.CurrentAgreementId), ag.DeviceId, ag.CurrentAgreementId, NEGOTIATION_FINALIZED)
+
 					// Update state in exchange
-				} else if pol, err := policy.DemarshalPolicy(ag.Policy); err != nil {
-					glog.Errorf(bwlogstring(a.workerID, fmt.Sprintf("error demarshalling policy from agreement %v, error: %v", wi.Reply.AgreementId(), err)))
-				} else if err := a.protocolHandler.RecordConsumerAgreementState(wi.Reply.AgreementId(), pol, ag.Org, "Finalized Agreement", a.workerID); err != nil {
-					glog.Errorf(bwlogstring(a.workerID, fmt.Sprintf("error setting agreement %v finalized state in exchange: %v", wi.Reply.AgreementId(), err)))
+					if pol, err := policy.DemarshalPolicy(ag.Policy); err != nil {
+						glog.Errorf(bwlogstring(a.workerID, fmt.Sprintf("error demarshalling policy from agreement %v, error: %v", wi.Reply.AgreementId(), err)))
+					} else if err := a.protocolHandler.RecordConsumerAgreementState(wi.Reply.AgreementId(), pol, ag.Org, "Finalized Agreement", a.workerID); err != nil {
+						glog.Errorf(bwlogstring(a.workerID, fmt.Sprintf("error setting agreement %v finalized state in exchange: %v", wi.Reply.AgreementId(), err)))
+					}
 				}
 			}
 
@@ -142,10 +176,32 @@ func (a *BasicAgreementWorker) start(work chan AgreementWork, random *rand.Rand)
 				}
 			}
 
+		} else if workItem.Type() == RESOURCE_USAGE {
+			wi := workItem.(BResourceUsageReport)
+
+			// Only the node in the agreement is allowed to report the resource usage of the agreement.
+			if agreement, err := FindSingleAgreementByAgreementId(a.db, wi.Usage.AgreementId(), a.protocolHandler.Name(), []AFilter{UnarchivedAFilter()}); err != nil {
+				glog.Errorf(bwlogstring(a.workerID, fmt.Sprintf("error querying agreement %v, error: %v", wi.Usage.AgreementId(), err)))
+			} else if agreement == nil {
+				glog.Warningf(bwlogstring(a.workerID, fmt.Sprintf("ignoring resource usage for agreement %v, the agreement does not exist", wi.Usage.AgreementId())))
+			} else if agreement.DeviceId != wi.SenderId {
+				glog.Warningf(bwlogstring(a.workerID, fmt.Sprintf("ignoring resource usage for agreement %v from %v, the agreement is with %v", wi.Usage.AgreementId(), wi.SenderId, agreement.DeviceId)))
+			} else if _, err := AgreementResourceUsage(a.db, wi.Usage.AgreementId(), a.protocolHandler.Name(), wi.Usage.Usage); err != nil {
+				glog.Errorf(bwlogstring(a.workerID, fmt.Sprintf("error persisting resource usage for agreement %v, error: %v", wi.Usage.AgreementId(), err)))
+			}
+
+			// Get rid of the resource usage message.
+			if wi.MessageId != 0 {
+				if err := a.protocolHandler.DeleteMessage(wi.MessageId); err != nil {
+					glog.Errorf(bwlogstring(a.workerID, fmt.Sprintf("error deleting message %v from exchange", wi.MessageId)))
+				}
+			}
+
 		} else {
 			glog.Errorf(bwlogstring(a.workerID, fmt.Sprintf("received unknown work request: %v", workItem)))
 		}
 
+		a.protocolHandler.workDone()
 		glog.V(5).Infof(bwlogstring(a.workerID, fmt.Sprintf("handled work: %v", workItem)))
 		runtime.Gosched()
 
@@ -153,5 +209,8 @@ func (a *BasicAgreementWorker) start(work chan AgreementWork, random *rand.Rand)
 }
 
 var bwlogstring = func(workerID string, v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("BasicAgreementWorker", cutil.LogFields{cutil.LOG_WORKER_ID: workerID}, v)
+	}
 	return fmt.Sprintf("BasicAgreementWorker (%v): %v", workerID, v)
 }
@@ -8,6 +8,7 @@ import (
 	"github.com/open-horizon/anax/abstractprotocol"
 	"github.com/open-horizon/anax/basicprotocol"
 	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/exchange"
 	"github.com/open-horizon/anax/metering"
@@ -20,7 +21,8 @@ import (
 type BasicProtocolHandler struct {
 	*BaseConsumerProtocolHandler
 	agreementPH *basicprotocol.ProtocolHandler
-	Work        chan AgreementWork // outgoing commands for the workers
+	messenger   *basicprotocol.ReliableMessenger // keeps the V2 protocol messages until the node acknowledges them
+	Work        chan AgreementWork               // outgoing commands for the workers
 }
 
 func NewBasicProtocolHandler(name string, cfg *config.HorizonConfig, db *bolt.DB, pm *policy.PolicyManager, messages chan events.Message) *BasicProtocolHandler {
@@ -38,6 +40,7 @@ func NewBasicProtocolHandler(name string, cfg *config.HorizonConfig, db *bolt.DB
 				messages:         messages,
 			},
 			agreementPH: basicprotocol.NewProtocolHandler(cfg.Collaborators.HTTPClientFactory.NewHTTPClient(nil), pm),
+			messenger:   basicprotocol.NewReliableMessenger(db),
 			Work:        make(chan AgreementWork),
 		}
 	} else {
@@ -62,6 +65,18 @@ func (c *BasicProtocolHandler) Initialize() {
 	// Setup a lock to protect concurrent agreement processing
 	agreementLockMgr := NewAgreementLockManager()
 
+	// Proposals might be batched before they are sent to the exchange.
+	c.initProposalBatcher()
+
+	// New agreements might be limited per org and pattern.
+	c.initRateLimiter()
+
+	// Agreement events might be sent to webhooks.
+	c.initWebhooks()
+
+	// Pick up any work that was deferred when the agbot last shut down.
+	c.restoreDeferredCommands()
+
 	// Set up agreement worker pool based on the current technical config.
 	for ix := 0; ix < c.config.AgreementBot.AgreementWorkers; ix++ {
 		agw := NewBasicAgreementWorker(c, c.config, c.db, c.pm, agreementLockMgr)
@@ -79,6 +94,87 @@ func (c *BasicProtocolHandler) WorkQueue() chan AgreementWork {
 	return c.Work
 }
 
+// The messages of V2 agreements are sent reliably, the other messages are sent once.
+func (c *BasicProtocolHandler) GetSendMessage() func(mt interface{}, pay []byte) error {
+	return c.sendMessage
+}
+
+func (c *BasicProtocolHandler) sendMessage(mt interface{}, pay []byte) error {
+
+	send := c.BaseConsumerProtocolHandler.GetSendMessage()
+
+	if header := basicprotocol.ReliableMessageHeader(pay); header == nil || !c.isReliable(header) {
+		return send(mt, pay)
+	} else if messageTarget, ok := mt.(*exchange.ExchangeMessageTarget); !ok {
+		return send(mt, pay)
+	} else {
+		return c.messenger.Send(header.AgreementId(), messageTarget.ReceiverExchangeId, pay, func(p []byte) error { return send(mt, p) })
+	}
+}
+
+// Returns true if the message belongs to an agreement that uses the V2 protocol.
+func (c *BasicProtocolHandler) isReliable(msg *abstractprotocol.BaseProtocolMessage) bool {
+	if basicprotocol.CarriesAgreementVersion(msg) {
+		return msg.Version() >= 2
+	} else if ag, err := FindSingleAgreementByAgreementId(c.db, msg.AgreementId(), c.Name(), []AFilter{}); err != nil {
+		glog.Errorf(BsCPHlogString(fmt.Sprintf("error finding agreement %v in the db, error %v", msg.AgreementId(), err)))
+		return false
+	} else {
+		return ag != nil && ag.AgreementProtocolVersion >= 2
+	}
+}
+
+// Send the messages that the nodes have not acknowledged again.
+func (c *BasicProtocolHandler) ResendMessages() {
+	c.messenger.Resend(func(receiverId string, pay []byte) error {
+		if whisperTo, pubkeyTo, err := c.GetDeviceMessageEndpoint(receiverId, "Resend"); err != nil {
+			return err
+		} else if mt, err := exchange.CreateMessageTarget(receiverId, nil, pubkeyTo, whisperTo); err != nil {
+			return err
+		} else {
+			return c.BaseConsumerProtocolHandler.GetSendMessage()(mt, pay)
+		}
+	})
+}
+
+// The messages of V2 agreements are acknowledged before they are processed. A message that arrives again, because
+// the node did not get the acknowledgement, is not processed again.
+func (c *BasicProtocolHandler) DispatchProtocolMessage(cmd *NewProtocolMessageCommand, cph ConsumerProtocolHandler) error {
+
+	if ack, err := c.agreementPH.ValidateMessageAck(string(cmd.Message)); err == nil {
+		glog.V(5).Infof(BsCPHlogString(fmt.Sprintf("received message ack %v", ack)))
+		if err := c.messenger.Acknowledged(ack.MsgId); err != nil {
+			glog.Errorf(BsCPHlogString(fmt.Sprintf("unable to record message ack %v, error %v", ack, err)))
+		}
+		if err := c.DeleteMessage(cmd.MessageId); err != nil {
+			glog.Errorf(BsCPHlogString(fmt.Sprintf("error deleting exchange message %v, error %v", cmd.MessageId, err)))
+		}
+		return nil
+	}
+
+	if header := basicprotocol.ReliableMessageHeader(cmd.Message); header != nil {
+		if msgId := basicprotocol.GetMessageId(string(cmd.Message)); msgId != "" {
+			if mt, err := exchange.CreateMessageTarget(cmd.From, nil, cmd.PubKey, ""); err != nil {
+				glog.Errorf(BsCPHlogString(fmt.Sprintf("error creating message target: %v", err)))
+			} else if err := c.agreementPH.SendMessageAck(header.AgreementId(), msgId, mt, c.BaseConsumerProtocolHandler.GetSendMessage()); err != nil {
+				glog.Errorf(BsCPHlogString(err.Error()))
+			}
+
+			if duplicate, err := c.messenger.Received(msgId, cmd.MessageId); err != nil {
+				glog.Errorf(BsCPHlogString(fmt.Sprintf("unable to record received message %v, error %v", msgId, err)))
+			} else if duplicate {
+				cutil.TraceV(3, header.AgreementId(), cmd.From).Infof(BsCPHlogString(fmt.Sprintf("ignoring message %v for agreement %v, it was already received", msgId, header.AgreementId())))
+				if err := c.DeleteMessage(cmd.MessageId); err != nil {
+					glog.Errorf(BsCPHlogString(fmt.Sprintf("error deleting exchange message %v, error %v", cmd.MessageId, err)))
+				}
+				return nil
+			}
+		}
+	}
+
+	return c.BaseConsumerProtocolHandler.DispatchProtocolMessage(cmd, cph)
+}
+
 func (c *BasicProtocolHandler) AcceptCommand(cmd worker.Command) bool {
 
 	switch cmd.(type) {
@@ -130,34 +226,11 @@ func (c *BasicProtocolHandler) TerminateAgreement(ag *Agreement, reason uint, wo
 }
 
 func (c *BasicProtocolHandler) GetTerminationCode(reason string) uint {
-	switch reason {
-	case TERM_REASON_POLICY_CHANGED:
-		return basicprotocol.AB_CANCEL_POLICY_CHANGED
-	// case TERM_REASON_NOT_FINALIZED_TIMEOUT:
-	//     return basicprotocol.AB_CANCEL_NOT_FINALIZED_TIMEOUT
-	case TERM_REASON_NO_DATA_RECEIVED:
-		return basicprotocol.AB_CANCEL_NO_DATA_RECEIVED
-	case TERM_REASON_NO_REPLY:
-		return basicprotocol.AB_CANCEL_NO_REPLY
-	case TERM_REASON_USER_REQUESTED:
-		return basicprotocol.AB_USER_REQUESTED
-	case TERM_REASON_DEVICE_REQUESTED:
+	if reason == TERM_REASON_DEVICE_REQUESTED {
+		// The device asked for the cancel, so it is recorded with the device's reason code.
 		return basicprotocol.CANCEL_USER_REQUESTED
-	case TERM_REASON_NEGATIVE_REPLY:
-		return basicprotocol.AB_CANCEL_NEGATIVE_REPLY
-	case TERM_REASON_CANCEL_DISCOVERED:
-		return basicprotocol.AB_CANCEL_DISCOVERED
-	case TERM_REASON_CANCEL_FORCED_UPGRADE:
-		return basicprotocol.AB_CANCEL_FORCED_UPGRADE
-	// case TERM_REASON_CANCEL_BC_WRITE_FAILED:
-	//     return basicprotocol.AB_CANCEL_BC_WRITE_FAILED
-	case TERM_REASON_NODE_HEARTBEAT:
-		return basicprotocol.AB_CANCEL_NODE_HEARTBEAT
-	case TERM_REASON_AG_MISSING:
-		return basicprotocol.AB_CANCEL_AG_MISSING
-	default:
-		return 999
 	}
+	return basicprotocol.Reasons.Code(abstractprotocol.REASON_CONSUMER, reason)
 }
 
 func (c *BasicProtocolHandler) GetTerminationReason(code uint) string {
@@ -185,7 +258,7 @@ func (b *BasicProtocolHandler) PostReply(agreementId string, proposal abstractpr
 	if err := b.agreementPH.RecordAgreement(proposal, reply, "", "", consumerPolicy, org); err != nil {
 		return err
 	} else {
-		glog.V(3).Infof(BCPHlogstring2(workerId, fmt.Sprintf("recorded agreement %v", agreementId)))
+		cutil.TraceV(3, agreementId, "").Infof(BCPHlogstring2(workerId, fmt.Sprintf("recorded agreement %v", agreementId)))
 	}
 
 	return nil
@@ -206,6 +279,17 @@ func (b *BasicProtocolHandler) HandleExtensionMessage(cmd *NewProtocolMessageCom
 		b.WorkQueue() <- agreementWork
 		glog.V(5).Infof(BsCPHlogString(fmt.Sprintf("queued agreement verify message")))
 
+	} else if usage, perr := b.agreementPH.ValidateResourceUsage(string(cmd.Message)); perr == nil {
+		usageWork := BResourceUsageReport{
+			workType:     RESOURCE_USAGE,
+			Usage:        *usage,
+			SenderId:     cmd.From,
+			SenderPubKey: cmd.PubKey,
+			MessageId:    cmd.MessageId,
+		}
+		b.WorkQueue() <- usageWork
+		glog.V(5).Infof(BsCPHlogString(fmt.Sprintf("queued resource usage message")))
+
 	} else {
 		glog.V(5).Infof(BsCPHlogString(fmt.Sprintf("ignoring  message: %v because it is an unknown type", string(cmd.Message))))
 		return errors.New(BsCPHlogString(fmt.Sprintf("unknown protocol msg %s", cmd.Message)))
@@ -217,5 +301,8 @@ func (b *BasicProtocolHandler) HandleExtensionMessage(cmd *NewProtocolMessageCom
 // Utility functions
 
 var BsCPHlogString = func(v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("AgreementBot Basic Protocol Handler", nil, v)
+	}
 	return fmt.Sprintf("AgreementBot Basic Protocol Handler %v", v)
 }
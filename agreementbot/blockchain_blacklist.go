@@ -0,0 +1,193 @@
+package agreementbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"strings"
+	"time"
+)
+
+// A blockchain instance whose client repeatedly fails to initialize, or that repeatedly fails to record agreements,
+// usually has bad metadata in the exchange. Without a limit, the agbot keeps asking for a new client container for it,
+// and the containers are started and torn down over and over. The consecutive failures of each blockchain instance
+// are counted in the database, so the count survives an agbot restart. After BCBlacklistFailureLimit failures the
+// instance is blacklisted: the agbot stops asking for a client container for it until the blacklist entry is cleared
+// through the API. A success resets the count of an instance that is not blacklisted yet.
+const BC_BLACKLIST = "blockchain_blacklist"
+
+type BlockchainFailures struct {
+	Org              string `json:"org"`
+	Type             string `json:"type"`
+	Name             string `json:"name"`
+	Failures         int    `json:"failures"`           // the number of consecutive failures
+	LastError        string `json:"last_error"`         // the error of the most recent failure
+	FirstFailureTime uint64 `json:"first_failure_time"` // the time of the first of the consecutive failures
+	LastFailureTime  uint64 `json:"last_failure_time"`
+	Blacklisted      bool   `json:"blacklisted"`
+	BlacklistedTime  uint64 `json:"blacklisted_time"`
+}
+
+func (b BlockchainFailures) String() string {
+	return fmt.Sprintf("Org: %v, Type: %v, Name: %v, Failures: %v, LastError: %v, FirstFailureTime: %v, LastFailureTime: %v, Blacklisted: %v, BlacklistedTime: %v",
+		b.Org, b.Type, b.Name, b.Failures, b.LastError, b.FirstFailureTime, b.LastFailureTime, b.Blacklisted, b.BlacklistedTime)
+}
+
+func bcFailuresKey(org string, typeName string, name string) []byte {
+	return []byte(strings.Join([]string{org, typeName, name}, "/"))
+}
+
+// Returns the failures of all the blockchain instances that have failed since they last succeeded, including the
+// blacklisted ones.
+func FindBlockchainFailures(db *bolt.DB) ([]BlockchainFailures, error) {
+	all := make([]BlockchainFailures, 0, 5)
+
+	readErr := db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(BC_BLACKLIST)); b != nil {
+			return b.ForEach(func(k, v []byte) error {
+				var f BlockchainFailures
+				if err := json.Unmarshal(v, &f); err != nil {
+					glog.Errorf(AWlogString(fmt.Sprintf("unable to deserialize blockchain failures %v: %v", string(v), err)))
+				} else {
+					all = append(all, f)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+
+	if readErr != nil {
+		return nil, readErr
+	}
+	return all, nil
+}
+
+// Returns the failures of a blockchain instance, nil when it has not failed since it last succeeded.
+func FindBlockchainFailure(db *bolt.DB, org string, typeName string, name string) (*BlockchainFailures, error) {
+	var f *BlockchainFailures
+
+	readErr := db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(BC_BLACKLIST)); b == nil {
+			return nil
+		} else if v := b.Get(bcFailuresKey(org, typeName, name)); v == nil {
+			return nil
+		} else {
+			f = new(BlockchainFailures)
+			if err := json.Unmarshal(v, f); err != nil {
+				return fmt.Errorf("Unable to deserialize blockchain failures: %v", err)
+			}
+		}
+		return nil
+	})
+
+	if readErr != nil {
+		return nil, readErr
+	}
+	return f, nil
+}
+
+// Count a failure of a blockchain instance, and blacklist the instance when this is its limit-th consecutive failure.
+// Returns the updated failures.
+func RecordBlockchainFailure(db *bolt.DB, org string, typeName string, name string, failure error, limit int) (*BlockchainFailures, error) {
+	var f BlockchainFailures
+
+	writeErr := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(BC_BLACKLIST))
+		if err != nil {
+			return err
+		}
+
+		now := uint64(time.Now().Unix())
+		key := bcFailuresKey(org, typeName, name)
+		if v := b.Get(key); v == nil {
+			f = BlockchainFailures{Org: org, Type: typeName, Name: name, FirstFailureTime: now}
+		} else if err := json.Unmarshal(v, &f); err != nil {
+			return fmt.Errorf("Unable to deserialize blockchain failures: %v", err)
+		}
+
+		f.Failures += 1
+		f.LastFailureTime = now
+		if failure != nil {
+			f.LastError = failure.Error()
+		}
+		if !f.Blacklisted && limit > 0 && f.Failures >= limit {
+			f.Blacklisted = true
+			f.BlacklistedTime = now
+		}
+
+		if serial, err := json.Marshal(f); err != nil {
+			return fmt.Errorf("Unable to serialize blockchain failures %v: %v", f, err)
+		} else {
+			return b.Put(key, serial)
+		}
+	})
+
+	if writeErr != nil {
+		return nil, writeErr
+	}
+	return &f, nil
+}
+
+// Forget the failures of a blockchain instance after it succeeded. A blacklisted instance stays blacklisted, it is
+// only cleared through the API.
+func ResetBlockchainFailures(db *bolt.DB, org string, typeName string, name string) error {
+	if f, err := FindBlockchainFailure(db, org, typeName, name); err != nil {
+		return err
+	} else if f == nil || f.Blacklisted {
+		return nil
+	}
+	return ClearBlockchainFailures(db, org, typeName, name)
+}
+
+// Remove the failures of a blockchain instance, including its blacklist entry.
+func ClearBlockchainFailures(db *bolt.DB, org string, typeName string, name string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(BC_BLACKLIST)); b == nil {
+			return nil
+		} else {
+			return b.Delete(bcFailuresKey(org, typeName, name))
+		}
+	})
+}
+
+// Remove the failures of all the blockchain instances, which clears the whole blacklist.
+func ClearAllBlockchainFailures(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(BC_BLACKLIST)); b == nil {
+			return nil
+		} else {
+			return tx.DeleteBucket([]byte(BC_BLACKLIST))
+		}
+	})
+}
+
+// Returns true when the blockchain instance is blacklisted. If the blacklist can't be read, the instance is treated
+// as not blacklisted.
+func IsBlockchainBlacklisted(db *bolt.DB, org string, typeName string, name string) bool {
+	if f, err := FindBlockchainFailure(db, org, typeName, name); err != nil {
+		glog.Errorf(AWlogString(fmt.Sprintf("unable to read blockchain blacklist for %v/%v/%v, error: %v", org, typeName, name, err)))
+		return false
+	} else {
+		return f != nil && f.Blacklisted
+	}
+}
+
+// Count a failure of a blockchain instance and log when the failure gets the instance blacklisted.
+func noteBlockchainFailure(db *bolt.DB, org string, typeName string, name string, failure error, limit int) {
+	if f, err := RecordBlockchainFailure(db, org, typeName, name, failure, limit); err != nil {
+		glog.Errorf(AWlogString(fmt.Sprintf("unable to record failure of blockchain %v/%v/%v, error: %v", org, typeName, name, err)))
+	} else if f.Blacklisted && f.Failures == limit {
+		glog.Errorf(AWlogString(fmt.Sprintf("blockchain %v/%v/%v failed %v times in a row and is blacklisted, no more client containers will be started for it until it is cleared from the blacklist. Last error: %v", org, typeName, name, f.Failures, f.LastError)))
+	} else {
+		glog.V(3).Infof(AWlogString(fmt.Sprintf("blockchain %v/%v/%v failed %v times in a row", org, typeName, name, f.Failures)))
+	}
+}
+
+// Forget the failures of a blockchain instance that succeeded.
+func noteBlockchainSuccess(db *bolt.DB, org string, typeName string, name string) {
+	if err := ResetBlockchainFailures(db, org, typeName, name); err != nil {
+		glog.Errorf(AWlogString(fmt.Sprintf("unable to reset failures of blockchain %v/%v/%v, error: %v", org, typeName, name, err)))
+	}
+}
@@ -0,0 +1,87 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"errors"
+	"github.com/boltdb/bolt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func Test_BlockchainBlacklist(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "agbot-bcblacklist")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := bolt.Open(path.Join(dir, "agbot.db"), 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("unable to open db: %v", err)
+	}
+	defer db.Close()
+
+	if all, err := FindBlockchainFailures(db); err != nil {
+		t.Fatalf("unable to find blockchain failures: %v", err)
+	} else if len(all) != 0 || IsBlockchainBlacklisted(db, "myorg", "ethereum", "bc1") {
+		t.Errorf("a new agbot should not have blockchain failures, has %v", all)
+	}
+
+	// Failures below the limit are counted, a success forgets them.
+	if f, err := RecordBlockchainFailure(db, "myorg", "ethereum", "bc1", errors.New("no genesis block"), 3); err != nil {
+		t.Fatalf("unable to record failure: %v", err)
+	} else if f.Failures != 1 || f.Blacklisted || f.LastError != "no genesis block" || f.FirstFailureTime == 0 {
+		t.Errorf("wrong failures after the first failure %v", f)
+	}
+	if err := ResetBlockchainFailures(db, "myorg", "ethereum", "bc1"); err != nil {
+		t.Fatalf("unable to reset failures: %v", err)
+	} else if f, err := FindBlockchainFailure(db, "myorg", "ethereum", "bc1"); err != nil || f != nil {
+		t.Errorf("failures should be forgotten after a success, are %v, error %v", f, err)
+	}
+
+	// Reaching the limit blacklists the blockchain, and a success doesnt clear the blacklist.
+	for i := 0; i < 3; i++ {
+		if _, err := RecordBlockchainFailure(db, "myorg", "ethereum", "bc1", errors.New("write failed"), 3); err != nil {
+			t.Fatalf("unable to record failure: %v", err)
+		}
+	}
+	RecordBlockchainFailure(db, "myorg", "ethereum", "bc2", errors.New("write failed"), 3)
+	if !IsBlockchainBlacklisted(db, "myorg", "ethereum", "bc1") {
+		t.Errorf("bc1 should be blacklisted")
+	} else if IsBlockchainBlacklisted(db, "myorg", "ethereum", "bc2") || IsBlockchainBlacklisted(db, "otherorg", "ethereum", "bc1") {
+		t.Errorf("only bc1 in myorg should be blacklisted")
+	} else if err := ResetBlockchainFailures(db, "myorg", "ethereum", "bc1"); err != nil {
+		t.Fatalf("unable to reset failures: %v", err)
+	} else if !IsBlockchainBlacklisted(db, "myorg", "ethereum", "bc1") {
+		t.Errorf("bc1 should still be blacklisted after a success")
+	} else if all, err := FindBlockchainFailures(db); err != nil || len(all) != 2 {
+		t.Errorf("expected failures for 2 blockchains, got %v, error %v", all, err)
+	}
+
+	// The blacklist survives a restart.
+	db.Close()
+	db, err = bolt.Open(path.Join(dir, "agbot.db"), 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("unable to reopen db: %v", err)
+	}
+	defer db.Close()
+	if f, err := FindBlockchainFailure(db, "myorg", "ethereum", "bc1"); err != nil || f == nil || !f.Blacklisted || f.Failures != 3 || f.BlacklistedTime == 0 {
+		t.Errorf("bc1 should still be blacklisted after a restart, is %v, error %v", f, err)
+	}
+
+	// Clearing removes the entries.
+	if err := ClearBlockchainFailures(db, "myorg", "ethereum", "bc1"); err != nil {
+		t.Fatalf("unable to clear bc1: %v", err)
+	} else if IsBlockchainBlacklisted(db, "myorg", "ethereum", "bc1") {
+		t.Errorf("bc1 should not be blacklisted after it was cleared")
+	} else if err := ClearAllBlockchainFailures(db); err != nil {
+		t.Fatalf("unable to clear the blacklist: %v", err)
+	} else if all, err := FindBlockchainFailures(db); err != nil || len(all) != 0 {
+		t.Errorf("expected no failures after clearing the blacklist, got %v, error %v", all, err)
+	}
+}
@@ -0,0 +1,119 @@
+package agreementbot
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/ethblockchain"
+	"github.com/open-horizon/anax/events"
+)
+
+// The blockchain clients that the CS protocol handler uses are health checked periodically by calling their RPC
+// endpoint. A client that fails a check is marked not writable, so that agreements are not written to it, and it is
+// marked writable again as soon as a check succeeds. Every BCHealthFailureLimit consecutive failures, the client's
+// container is relaunched, unless the blockchain is blacklisted. The BC_CLIENT_UNHEALTHY and BC_CLIENT_HEALTHY events
+// tell the rest of anax when a client fails and recovers.
+
+// A blockchain client to health check, copied out of the blockchain state so that the check can be made without
+// holding the state lock.
+type bcHealthTarget struct {
+	org      string
+	typeName string
+	name     string
+	url      string
+}
+
+// Call the eth client's RPC endpoint to check that the client is still answering.
+func probeBlockchainClient(httpClientFactory *config.HTTPClientFactory, url string) error {
+	if conn := ethblockchain.RPC_Connection_Factory("", 0, url); conn == nil {
+		return errors.New(fmt.Sprintf("unable to create RPC connection to %v", url))
+	} else if client := ethblockchain.RPC_Client_Factory(httpClientFactory, conn); client == nil {
+		return errors.New(fmt.Sprintf("unable to create RPC client for %v", url))
+	} else if _, err := client.Get_block_number(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Health check all the blockchain clients that are ready.
+func (c *CSProtocolHandler) CheckBlockchainHealth() {
+
+	c.bcStateLock.Lock()
+	targets := make([]bcHealthTarget, 0, 5)
+	for org, typeMap := range c.bcState {
+		for typeName, nameMap := range typeMap {
+			for name, bc := range nameMap {
+				if bc.ready && bc.service != "" {
+					targets = append(targets, bcHealthTarget{org: org, typeName: typeName, name: name, url: fmt.Sprintf("http://%v:%v", bc.service, bc.servicePort)})
+				}
+			}
+		}
+	}
+	c.bcStateLock.Unlock()
+
+	for _, target := range targets {
+		err := probeBlockchainClient(c.config.Collaborators.HTTPClientFactory, target.url)
+		for _, msg := range c.recordBlockchainHealth(target, err) {
+			c.messages <- msg
+		}
+	}
+}
+
+// Update the state of a blockchain client with the result of a health check. Returns the events that should be
+// sent because of the check.
+func (c *CSProtocolHandler) recordBlockchainHealth(target bcHealthTarget, checkErr error) []events.Message {
+
+	c.bcStateLock.Lock()
+	defer c.bcStateLock.Unlock()
+
+	msgs := make([]events.Message, 0, 2)
+
+	// The client might have stopped while it was being checked.
+	nameMap := c.getBCNameMap(target.org, target.typeName)
+	bc, ok := nameMap[target.name]
+	if !ok {
+		return msgs
+	}
+
+	if checkErr == nil {
+		if bc.failures != 0 {
+			glog.V(3).Infof(CPHlogString(fmt.Sprintf("blockchain client %v/%v is healthy again after %v failed health checks", target.org, target.name, bc.failures)))
+			bc.failures = 0
+			bc.writable = true
+			msgs = append(msgs, events.NewBlockchainClientHealthMessage(events.BC_CLIENT_HEALTHY, target.typeName, target.name, target.org, 0, ""))
+		}
+		return msgs
+	}
+
+	bc.failures += 1
+	bc.writable = false
+	glog.Warningf(CPHlogString(fmt.Sprintf("blockchain client %v/%v failed health check %v at %v, error: %v", target.org, target.name, bc.failures, target.url, checkErr)))
+	msgs = append(msgs, events.NewBlockchainClientHealthMessage(events.BC_CLIENT_UNHEALTHY, target.typeName, target.name, target.org, bc.failures, checkErr.Error()))
+
+	if IsBlockchainBlacklisted(c.db, target.org, target.typeName, target.name) {
+		glog.V(3).Infof(CPHlogString(fmt.Sprintf("not relaunching blacklisted blockchain client %v/%v", target.org, target.name)))
+	} else if limit := c.config.AgreementBot.BCHealthFailureLimit; limit > 0 && bc.failures%limit == 0 {
+		glog.V(3).Infof(CPHlogString(fmt.Sprintf("relaunching blockchain client %v/%v after %v failed health checks", target.org, target.name, bc.failures)))
+		msgs = append(msgs, events.NewNewBCContainerMessage(events.RESTART_BC_CLIENT, target.typeName, target.name, target.org, c.config.AgreementBot.ExchangeURL, c.agbotId, c.token))
+	}
+	return msgs
+}
+
+// Health check the blockchain clients of all the protocol handlers that use a blockchain.
+func (w *AgreementBotWorker) GovernBlockchainHealth() int {
+
+	w.consumerPHLock.RLock()
+	csphs := make([]*CSProtocolHandler, 0, 1)
+	for _, cph := range w.consumerPH {
+		if csph, ok := cph.(*CSProtocolHandler); ok {
+			csphs = append(csphs, csph)
+		}
+	}
+	w.consumerPHLock.RUnlock()
+
+	for _, csph := range csphs {
+		csph.CheckBlockchainHealth()
+	}
+	return 0
+}
@@ -0,0 +1,129 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/policy"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_CheckBlockchainHealth(t *testing.T) {
+
+	healthy := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"jsonrpc":"2.0","id":"1","result":"0x1b4"}`)
+	}))
+	defer server.Close()
+
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	dir, err := ioutil.TempDir("", "agbot-bchealth")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := bolt.Open(path.Join(dir, "agbot.db"), 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("unable to open db: %v", err)
+	}
+	defer db.Close()
+
+	cfg := &config.HorizonConfig{
+		AgreementBot: config.AGConfig{BCHealthFailureLimit: 2},
+		Collaborators: config.Collaborators{
+			HTTPClientFactory: &config.HTTPClientFactory{
+				NewHTTPClient: func(timeoutS *uint) *http.Client { return &http.Client{} },
+			},
+		},
+	}
+
+	messages := make(chan events.Message, 10)
+	c := createEmptyPH()
+	c.config = cfg
+	c.db = db
+	c.messages = messages
+	c.bcState = make(map[string]map[string]map[string]*BlockchainState)
+	c.bcStateLock = sync.Mutex{}
+	c.getBCNameMap("myorg", policy.Ethereum_bc)["bc1"] = &BlockchainState{ready: true, writable: true, service: host, servicePort: port}
+
+	// A healthy client doesnt change state or send events.
+	c.CheckBlockchainHealth()
+	if !c.IsBlockchainWritable(policy.Ethereum_bc, "bc1", "myorg") {
+		t.Errorf("healthy blockchain should be writable")
+	} else if len(messages) != 0 {
+		t.Errorf("healthy blockchain should not send events, sent %v", len(messages))
+	}
+
+	// The first failure marks the client not writable.
+	healthy = false
+	c.CheckBlockchainHealth()
+	if c.IsBlockchainWritable(policy.Ethereum_bc, "bc1", "myorg") {
+		t.Errorf("unhealthy blockchain should not be writable")
+	} else if !c.IsBlockchainReady(policy.Ethereum_bc, "bc1", "myorg") {
+		t.Errorf("unhealthy blockchain should still be ready")
+	} else if len(messages) != 1 {
+		t.Errorf("expected 1 event, got %v", len(messages))
+	} else if msg, ok := (<-messages).(*events.BlockchainClientHealthMessage); !ok || msg.Event().Id != events.BC_CLIENT_UNHEALTHY || msg.Failures != 1 {
+		t.Errorf("expected an unhealthy event with 1 failure, got %v", msg)
+	}
+
+	// Reaching the failure limit relaunches the container.
+	c.CheckBlockchainHealth()
+	if len(messages) != 2 {
+		t.Errorf("expected 2 events, got %v", len(messages))
+	} else if msg, ok := (<-messages).(*events.BlockchainClientHealthMessage); !ok || msg.Failures != 2 {
+		t.Errorf("expected an unhealthy event with 2 failures, got %v", msg)
+	} else if msg, ok := (<-messages).(*events.NewBCContainerMessage); !ok || msg.Event().Id != events.RESTART_BC_CLIENT || msg.Instance() != "bc1" || msg.Org() != "myorg" {
+		t.Errorf("expected a restart event for bc1, got %v", msg)
+	}
+
+	// A successful check makes the client writable again.
+	healthy = true
+	c.CheckBlockchainHealth()
+	if !c.IsBlockchainWritable(policy.Ethereum_bc, "bc1", "myorg") {
+		t.Errorf("recovered blockchain should be writable")
+	} else if len(messages) != 1 {
+		t.Errorf("expected 1 event, got %v", len(messages))
+	} else if msg, ok := (<-messages).(*events.BlockchainClientHealthMessage); !ok || msg.Event().Id != events.BC_CLIENT_HEALTHY {
+		t.Errorf("expected a healthy event, got %v", msg)
+	} else if states := c.BlockchainStates(); len(states) != 1 || states[0].Failures != 0 {
+		t.Errorf("expected the failures to be reset, got %v", states)
+	}
+
+	// A client that is not ready isnt checked.
+	c.getBCNameMap("myorg", policy.Ethereum_bc)["bc2"] = &BlockchainState{ready: false, service: host, servicePort: port}
+	healthy = false
+	c.CheckBlockchainHealth()
+	if len(messages) != 1 {
+		t.Errorf("expected only bc1 to be checked, got %v events", len(messages))
+	}
+	<-messages
+
+	// A blacklisted client is not relaunched when it reaches the failure limit.
+	if _, err := RecordBlockchainFailure(db, "myorg", policy.Ethereum_bc, "bc1", nil, 1); err != nil {
+		t.Fatalf("unable to blacklist bc1: %v", err)
+	}
+	c.CheckBlockchainHealth()
+	if len(messages) != 1 {
+		t.Errorf("expected only an unhealthy event for blacklisted bc1, got %v events", len(messages))
+	} else if msg, ok := (<-messages).(*events.BlockchainClientHealthMessage); !ok || msg.Failures != 2 {
+		t.Errorf("expected an unhealthy event with 2 failures, got %v", msg)
+	}
+}
@@ -0,0 +1,426 @@
+package agreementbot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/abstractprotocol"
+	"github.com/open-horizon/anax/policy"
+	"time"
+)
+
+// A bulk cancel job cancels all the agreements that match a filter, for example to withdraw a bad workload version
+// from the whole fleet. A job is always planned first, which is a dry run that records the matching agreements
+// without cancelling any of them. Once the plan has been reviewed, the job is started and the agbot cancels the
+// planned agreements in batches, pausing between batches so that the exchange and the nodes are not overwhelmed.
+// The job record is updated after every batch, so a job that is interrupted by an agbot restart resumes where it
+// left off.
+const BULK_CANCEL = "bulk_cancel"
+
+// The states of a bulk cancel job.
+const (
+	BULK_CANCEL_PLANNED   = "planned"
+	BULK_CANCEL_RUNNING   = "running"
+	BULK_CANCEL_COMPLETED = "completed"
+	BULK_CANCEL_ABORTED   = "aborted"
+)
+
+const DEFAULT_BULK_CANCEL_BATCH_SIZE = 10
+const DEFAULT_BULK_CANCEL_BATCH_INTERVAL_S = 30
+
+// An agreement matches the filter when it matches every criterion that is specified.
+type BulkCancelFilter struct {
+	Org             string   `json:"org"`                        // required
+	Pattern         string   `json:"pattern,omitempty"`          // the pattern the agreement was made for
+	WorkloadURL     string   `json:"workload_url,omitempty"`     // the workload or service running under the agreement
+	WorkloadVersion string   `json:"workload_version,omitempty"` // a single version or a version range, e.g. [1.0.0,1.2.0)
+	Nodes           []string `json:"nodes,omitempty"`            // org qualified node ids
+}
+
+func (f BulkCancelFilter) String() string {
+	return fmt.Sprintf("Org: %v, Pattern: %v, WorkloadURL: %v, WorkloadVersion: %v, Nodes: %v", f.Org, f.Pattern, f.WorkloadURL, f.WorkloadVersion, f.Nodes)
+}
+
+// A filter has to be narrower than a whole org, so that a typo can't cancel every agreement the agbot has.
+func (f BulkCancelFilter) IsValid() error {
+	if f.Org == "" {
+		return errors.New("the org must be specified")
+	} else if f.Pattern == "" && f.WorkloadURL == "" && len(f.Nodes) == 0 {
+		return errors.New("at least one of pattern, workload url or nodes must be specified")
+	} else if f.WorkloadVersion != "" && f.WorkloadURL == "" {
+		return errors.New("a workload version can only be specified with a workload url")
+	} else if f.WorkloadVersion != "" && !policy.IsVersionString(f.WorkloadVersion) && !policy.IsVersionExpression(f.WorkloadVersion) {
+		return errors.New(fmt.Sprintf("workload version %v is not a version or a version range", f.WorkloadVersion))
+	}
+	return nil
+}
+
+// Returns the workload url and version the agreement was made for, from the terms and conditions of its proposal.
+// An agreement that doesn't have a proposal yet has no workload.
+func agreementWorkload(ag *Agreement) (string, string, error) {
+	if ag.Proposal == "" {
+		return "", "", nil
+	} else if proposal, err := abstractprotocol.DemarshalProposal(ag.Proposal); err != nil {
+		return "", "", err
+	} else if tcPolicy, err := policy.DemarshalPolicy(proposal.TsAndCs()); err != nil {
+		return "", "", err
+	} else if len(tcPolicy.Workloads) == 0 {
+		return "", "", nil
+	} else {
+		return tcPolicy.Workloads[0].WorkloadURL, tcPolicy.Workloads[0].Version, nil
+	}
+}
+
+func (f BulkCancelFilter) Matches(ag *Agreement) (bool, error) {
+	if ag.Org != f.Org || (f.Pattern != "" && ag.Pattern != f.Pattern) {
+		return false, nil
+	}
+
+	if len(f.Nodes) != 0 {
+		found := false
+		for _, node := range f.Nodes {
+			if node == ag.DeviceId {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false, nil
+		}
+	}
+
+	if f.WorkloadURL == "" {
+		return true, nil
+	}
+	wURL, wVersion, err := agreementWorkload(ag)
+	if err != nil {
+		return false, err
+	} else if wURL != f.WorkloadURL {
+		return false, nil
+	} else if f.WorkloadVersion == "" {
+		return true, nil
+	} else if policy.IsVersionString(f.WorkloadVersion) {
+		c, err := policy.CompareVersions(wVersion, f.WorkloadVersion)
+		return c == 0, err
+	} else if vExp, err := policy.Version_Expression_Factory(f.WorkloadVersion); err != nil {
+		return false, err
+	} else {
+		return vExp.Is_within_range(wVersion)
+	}
+}
+
+// The body of the API request that plans a bulk cancel job.
+type BulkCancelPlan struct {
+	Filter         BulkCancelFilter `json:"filter"`
+	BatchSize      int              `json:"batch_size,omitempty"`       // defaults to DEFAULT_BULK_CANCEL_BATCH_SIZE
+	BatchIntervalS int              `json:"batch_interval_s,omitempty"` // defaults to DEFAULT_BULK_CANCEL_BATCH_INTERVAL_S
+}
+
+type BulkCancelAgreement struct {
+	AgreementId   string `json:"agreement_id"`
+	Protocol      string `json:"protocol"`
+	DeviceId      string `json:"device_id"`
+	CancelledTime uint64 `json:"cancelled_time"`    // when the agreement was cancelled by the job
+	Skipped       string `json:"skipped,omitempty"` // why the agreement was not cancelled by the job
+}
+
+func (a BulkCancelAgreement) done() bool {
+	return a.CancelledTime != 0 || a.Skipped != ""
+}
+
+type BulkCancelJob struct {
+	Name           string                `json:"name"`
+	Filter         BulkCancelFilter      `json:"filter"`
+	BatchSize      int                   `json:"batch_size"`       // the number of agreements cancelled in each batch
+	BatchIntervalS int                   `json:"batch_interval_s"` // the number of seconds between batches
+	State          string                `json:"state"`
+	PlannedTime    uint64                `json:"planned_time"`
+	StartedTime    uint64                `json:"started_time"`
+	LastBatchTime  uint64                `json:"last_batch_time"`
+	FinishedTime   uint64                `json:"finished_time"`
+	Agreements     []BulkCancelAgreement `json:"agreements"` // the agreements that matched the filter when the job was planned
+}
+
+func (j BulkCancelJob) String() string {
+	return fmt.Sprintf("Name: %v, "+
+		"Filter: %v, "+
+		"BatchSize: %v, "+
+		"BatchIntervalS: %v, "+
+		"State: %v, "+
+		"PlannedTime: %v, "+
+		"StartedTime: %v, "+
+		"LastBatchTime: %v, "+
+		"FinishedTime: %v, "+
+		"Agreements: %v",
+		j.Name, j.Filter, j.BatchSize, j.BatchIntervalS, j.State, j.PlannedTime, j.StartedTime, j.LastBatchTime, j.FinishedTime, len(j.Agreements))
+}
+
+// Returns the agreements that the job has not cancelled or skipped yet.
+func (j BulkCancelJob) Remaining() []BulkCancelAgreement {
+	remaining := make([]BulkCancelAgreement, 0)
+	for _, a := range j.Agreements {
+		if !a.done() {
+			remaining = append(remaining, a)
+		}
+	}
+	return remaining
+}
+
+// Plan a bulk cancel job by finding all the active agreements that match the filter. None of the agreements are
+// cancelled until the job is started.
+func PlanBulkCancelJob(db *bolt.DB, name string, filter BulkCancelFilter, batchSize int, batchIntervalS int) (*BulkCancelJob, error) {
+
+	if name == "" {
+		return nil, errors.New("the job name must be specified")
+	} else if err := filter.IsValid(); err != nil {
+		return nil, err
+	} else if existing, err := FindBulkCancelJob(db, name); err != nil {
+		return nil, err
+	} else if existing != nil {
+		return nil, errors.New(fmt.Sprintf("bulk cancel job %v already exists", name))
+	}
+
+	if batchSize <= 0 {
+		batchSize = DEFAULT_BULK_CANCEL_BATCH_SIZE
+	}
+	if batchIntervalS <= 0 {
+		batchIntervalS = DEFAULT_BULK_CANCEL_BATCH_INTERVAL_S
+	}
+
+	job := &BulkCancelJob{
+		Name:           name,
+		Filter:         filter,
+		BatchSize:      batchSize,
+		BatchIntervalS: batchIntervalS,
+		State:          BULK_CANCEL_PLANNED,
+		PlannedTime:    uint64(time.Now().Unix()),
+		Agreements:     make([]BulkCancelAgreement, 0),
+	}
+
+	for _, agp := range policy.AllAgreementProtocols() {
+		ags, err := FindAgreements(db, []AFilter{UnarchivedAFilter()}, agp)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("unable to find %v agreements, error: %v", agp, err))
+		}
+		for _, ag := range ags {
+			if ag.AgreementTimedout != 0 {
+				continue
+			} else if match, err := filter.Matches(&ag); err != nil {
+				glog.Warningf(APIlogString(fmt.Sprintf("unable to match agreement %v against bulk cancel filter %v, error: %v", ag.CurrentAgreementId, filter, err)))
+			} else if match {
+				job.Agreements = append(job.Agreements, BulkCancelAgreement{AgreementId: ag.CurrentAgreementId, Protocol: agp, DeviceId: ag.DeviceId})
+			}
+		}
+	}
+
+	return job, persistBulkCancelJob(db, job)
+}
+
+func persistBulkCancelJob(db *bolt.DB, job *BulkCancelJob) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if b, err := tx.CreateBucketIfNotExists([]byte(BULK_CANCEL)); err != nil {
+			return err
+		} else if serial, err := json.Marshal(job); err != nil {
+			return fmt.Errorf("Unable to serialize bulk cancel job %v: %v", job.Name, err)
+		} else {
+			return b.Put([]byte(job.Name), serial)
+		}
+	})
+}
+
+func FindBulkCancelJob(db *bolt.DB, name string) (*BulkCancelJob, error) {
+	var job *BulkCancelJob
+
+	readErr := db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(BULK_CANCEL)); b != nil {
+			if v := b.Get([]byte(name)); v != nil {
+				job = new(BulkCancelJob)
+				if err := json.Unmarshal(v, job); err != nil {
+					return fmt.Errorf("Unable to deserialize bulk cancel job %v: %v", name, err)
+				}
+			}
+		}
+		return nil
+	})
+
+	if readErr != nil {
+		return nil, readErr
+	}
+	return job, nil
+}
+
+func FindBulkCancelJobs(db *bolt.DB) ([]BulkCancelJob, error) {
+	jobs := make([]BulkCancelJob, 0)
+
+	readErr := db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(BULK_CANCEL)); b != nil {
+			b.ForEach(func(k, v []byte) error {
+				var job BulkCancelJob
+				if err := json.Unmarshal(v, &job); err != nil {
+					glog.Errorf("Unable to deserialize bulk cancel job %v: %v", string(k), err)
+				} else {
+					jobs = append(jobs, job)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+
+	if readErr != nil {
+		return nil, readErr
+	}
+	return jobs, nil
+}
+
+// Change a job in a single transaction. The fn can return an error to leave the job unchanged.
+func UpdateBulkCancelJob(db *bolt.DB, name string, fn func(*BulkCancelJob) error) (*BulkCancelJob, error) {
+	var job *BulkCancelJob
+
+	updateErr := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BULK_CANCEL))
+		if b == nil {
+			return errors.New(fmt.Sprintf("bulk cancel job %v not found", name))
+		}
+		v := b.Get([]byte(name))
+		if v == nil {
+			return errors.New(fmt.Sprintf("bulk cancel job %v not found", name))
+		}
+		job = new(BulkCancelJob)
+		if err := json.Unmarshal(v, job); err != nil {
+			return fmt.Errorf("Unable to deserialize bulk cancel job %v: %v", name, err)
+		} else if err := fn(job); err != nil {
+			return err
+		} else if serial, err := json.Marshal(job); err != nil {
+			return fmt.Errorf("Unable to serialize bulk cancel job %v: %v", name, err)
+		} else {
+			return b.Put([]byte(name), serial)
+		}
+	})
+
+	if updateErr != nil {
+		return nil, updateErr
+	}
+	return job, nil
+}
+
+// Start cancelling the agreements of a planned job.
+func StartBulkCancelJob(db *bolt.DB, name string) (*BulkCancelJob, error) {
+	return UpdateBulkCancelJob(db, name, func(job *BulkCancelJob) error {
+		if job.State != BULK_CANCEL_PLANNED {
+			return errors.New(fmt.Sprintf("bulk cancel job %v is %v, only a planned job can be started", name, job.State))
+		}
+		job.State = BULK_CANCEL_RUNNING
+		job.StartedTime = uint64(time.Now().Unix())
+		return nil
+	})
+}
+
+// Stop a job before all of its agreements are cancelled. The agreements that were already cancelled stay cancelled.
+func AbortBulkCancelJob(db *bolt.DB, name string) (*BulkCancelJob, error) {
+	return UpdateBulkCancelJob(db, name, func(job *BulkCancelJob) error {
+		if job.State != BULK_CANCEL_PLANNED && job.State != BULK_CANCEL_RUNNING {
+			return errors.New(fmt.Sprintf("bulk cancel job %v is already %v", name, job.State))
+		}
+		job.State = BULK_CANCEL_ABORTED
+		job.FinishedTime = uint64(time.Now().Unix())
+		return nil
+	})
+}
+
+// Remove the record of a job that is not running.
+func DeleteBulkCancelJob(db *bolt.DB, name string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BULK_CANCEL))
+		if b == nil {
+			return nil
+		} else if v := b.Get([]byte(name)); v == nil {
+			return nil
+		} else {
+			var job BulkCancelJob
+			if err := json.Unmarshal(v, &job); err == nil && job.State == BULK_CANCEL_RUNNING {
+				return errors.New(fmt.Sprintf("bulk cancel job %v is running, abort it before deleting it", name))
+			}
+			return b.Delete([]byte(name))
+		}
+	})
+}
+
+// The subworker that runs the bulk cancel jobs. Each running job cancels its next batch of agreements once the
+// batch interval has passed since its previous batch.
+func (w *AgreementBotWorker) GovernBulkCancel() int {
+
+	// Once the agbot is draining, it doesnt pick up any new work. The running jobs resume after the restart.
+	if w.draining {
+		return 0
+	}
+
+	jobs, err := FindBulkCancelJobs(w.db)
+	if err != nil {
+		glog.Errorf(AWlogString(fmt.Sprintf("unable to find bulk cancel jobs, error: %v", err)))
+		return 0
+	}
+
+	now := uint64(time.Now().Unix())
+	for _, job := range jobs {
+		if job.State == BULK_CANCEL_RUNNING && job.LastBatchTime+uint64(job.BatchIntervalS) <= now {
+			w.cancelBulkBatch(job)
+		}
+	}
+	return 0
+}
+
+// Cancel the next batch of agreements of a job. Each agreement is checked again before it is cancelled, because it
+// might have been terminated, or changed so that it no longer matches the filter, since the job was planned.
+func (w *AgreementBotWorker) cancelBulkBatch(job BulkCancelJob) {
+
+	batch := job.Remaining()
+	if len(batch) > job.BatchSize {
+		batch = batch[:job.BatchSize]
+	}
+
+	for ix, item := range batch {
+		cph, ok := w.consumerPH[item.Protocol]
+		if !ok {
+			batch[ix].Skipped = fmt.Sprintf("agreement protocol %v is not running", item.Protocol)
+		} else if ag, err := FindSingleAgreementByAgreementId(w.db, item.AgreementId, item.Protocol, []AFilter{UnarchivedAFilter()}); err != nil {
+			// Leave it for the next batch
+			glog.Errorf(AWlogString(fmt.Sprintf("bulk cancel job %v unable to find agreement %v, error: %v", job.Name, item.AgreementId, err)))
+		} else if ag == nil || ag.AgreementTimedout != 0 {
+			batch[ix].Skipped = "the agreement was already terminated"
+		} else if match, err := job.Filter.Matches(ag); err != nil || !match {
+			batch[ix].Skipped = "the agreement no longer matches the filter"
+		} else {
+			glog.V(3).Infof(AWlogString(fmt.Sprintf("bulk cancel job %v cancelling agreement %v with %v", job.Name, item.AgreementId, item.DeviceId)))
+			if _, err := AgreementTimedout(w.db, item.AgreementId, item.Protocol); err != nil {
+				glog.Errorf(AWlogString(fmt.Sprintf("error marking agreement %v terminated: %v", item.AgreementId, err)))
+			}
+			cph.HandleAgreementTimeout(NewAgreementTimeoutCommand(item.AgreementId, item.Protocol, cph.GetTerminationCode(TERM_REASON_USER_REQUESTED)), cph)
+			batch[ix].CancelledTime = uint64(time.Now().Unix())
+		}
+	}
+
+	// Record the results of the batch. The job might have been aborted while the batch was running, in which case it
+	// stays aborted.
+	if updated, err := UpdateBulkCancelJob(w.db, job.Name, func(j *BulkCancelJob) error {
+		for _, item := range batch {
+			for ix := range j.Agreements {
+				if j.Agreements[ix].AgreementId == item.AgreementId && j.Agreements[ix].Protocol == item.Protocol {
+					j.Agreements[ix] = item
+				}
+			}
+		}
+		j.LastBatchTime = uint64(time.Now().Unix())
+		if j.State == BULK_CANCEL_RUNNING && len(j.Remaining()) == 0 {
+			j.State = BULK_CANCEL_COMPLETED
+			j.FinishedTime = j.LastBatchTime
+		}
+		return nil
+	}); err != nil {
+		glog.Errorf(AWlogString(fmt.Sprintf("unable to record batch of bulk cancel job %v, error: %v", job.Name, err)))
+	} else if updated.State == BULK_CANCEL_COMPLETED {
+		glog.V(3).Infof(AWlogString(fmt.Sprintf("bulk cancel job %v completed", job.Name)))
+	}
+}
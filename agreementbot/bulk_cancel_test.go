@@ -0,0 +1,132 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"encoding/json"
+	"github.com/boltdb/bolt"
+	"github.com/open-horizon/anax/abstractprotocol"
+	"github.com/open-horizon/anax/policy"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// Create an agreement with a proposal for the given workload version.
+func bulkCancelAgreement(t *testing.T, id string, deviceId string, pattern string, wlVersion string) *Agreement {
+	pol := policy.Policy{Workloads: policy.WorkloadList{policy.Workload{WorkloadURL: "http://mydomain.com/wl", Version: wlVersion}}}
+	if polBytes, err := json.Marshal(pol); err != nil {
+		t.Fatalf("unable to marshal policy: %v", err)
+	} else if propBytes, err := json.Marshal(abstractprotocol.NewProposal("proposal", 1, string(polBytes), "", id, "ag1")); err != nil {
+		t.Fatalf("unable to marshal proposal: %v", err)
+	} else {
+		return &Agreement{CurrentAgreementId: id, Org: "myorg", DeviceId: deviceId, Pattern: pattern, Proposal: string(propBytes)}
+	}
+	return nil
+}
+
+func Test_BulkCancelFilter(t *testing.T) {
+
+	for _, f := range []BulkCancelFilter{
+		BulkCancelFilter{Pattern: "myorg/pat"},
+		BulkCancelFilter{Org: "myorg"},
+		BulkCancelFilter{Org: "myorg", WorkloadVersion: "1.0.0"},
+		BulkCancelFilter{Org: "myorg", WorkloadURL: "http://mydomain.com/wl", WorkloadVersion: "not a version"},
+	} {
+		if err := f.IsValid(); err == nil {
+			t.Errorf("filter %v should not be valid", f)
+		}
+	}
+
+	ag := bulkCancelAgreement(t, "ag1", "myorg/node1", "myorg/pat", "1.1.0")
+
+	for f, expected := range map[*BulkCancelFilter]bool{
+		&BulkCancelFilter{Org: "myorg", Pattern: "myorg/pat"}:                                                          true,
+		&BulkCancelFilter{Org: "otherorg", Pattern: "myorg/pat"}:                                                       false,
+		&BulkCancelFilter{Org: "myorg", Nodes: []string{"myorg/node2", "myorg/node1"}}:                                  true,
+		&BulkCancelFilter{Org: "myorg", Nodes: []string{"myorg/node2"}}:                                                 false,
+		&BulkCancelFilter{Org: "myorg", WorkloadURL: "http://mydomain.com/wl"}:                                          true,
+		&BulkCancelFilter{Org: "myorg", WorkloadURL: "http://mydomain.com/other"}:                                       false,
+		&BulkCancelFilter{Org: "myorg", WorkloadURL: "http://mydomain.com/wl", WorkloadVersion: "1.1.0"}:                true,
+		&BulkCancelFilter{Org: "myorg", WorkloadURL: "http://mydomain.com/wl", WorkloadVersion: "1.0.0"}:                false,
+		&BulkCancelFilter{Org: "myorg", WorkloadURL: "http://mydomain.com/wl", WorkloadVersion: "[1.0.0,1.2.0)"}:        true,
+		&BulkCancelFilter{Org: "myorg", WorkloadURL: "http://mydomain.com/wl", WorkloadVersion: "[1.2.0,INFINITY)"}:     false,
+		&BulkCancelFilter{Org: "myorg", Pattern: "myorg/pat", WorkloadURL: "http://mydomain.com/wl", Nodes: []string{}}: true,
+	} {
+		if err := f.IsValid(); err != nil {
+			t.Errorf("filter %v should be valid, error: %v", f, err)
+		} else if match, err := f.Matches(ag); err != nil {
+			t.Errorf("filter %v returned error: %v", f, err)
+		} else if match != expected {
+			t.Errorf("filter %v match should be %v", f, expected)
+		}
+	}
+
+	// An agreement that hasnt been proposed yet has no workload.
+	ag.Proposal = ""
+	if match, err := (BulkCancelFilter{Org: "myorg", WorkloadURL: "http://mydomain.com/wl"}).Matches(ag); err != nil || match {
+		t.Errorf("agreement without a proposal should not match a workload, error: %v", err)
+	}
+}
+
+func Test_BulkCancelJob_persistence(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "agbot-bulkcancel")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := bolt.Open(path.Join(dir, "agbot.db"), 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("unable to open db: %v", err)
+	}
+	defer db.Close()
+
+	for _, ag := range []struct{ id, pattern string }{{"ag1", "myorg/pat"}, {"ag2", "myorg/pat"}, {"ag3", "myorg/other"}, {"ag4", "myorg/pat"}} {
+		if err := AgreementAttempt(db, ag.id, "myorg", "myorg/"+ag.id, "policy", "", "", "", policy.BasicProtocol, ag.pattern, policy.NodeHealth{}, nil); err != nil {
+			t.Fatalf("unable to create agreement %v: %v", ag.id, err)
+		}
+	}
+	if _, err := AgreementTimedout(db, "ag4", policy.BasicProtocol); err != nil {
+		t.Fatalf("unable to time out agreement: %v", err)
+	}
+
+	// The plan only records the active agreements that match, the terminated agreement is left out.
+	job, err := PlanBulkCancelJob(db, "job1", BulkCancelFilter{Org: "myorg", Pattern: "myorg/pat"}, 0, 0)
+	if err != nil {
+		t.Fatalf("unable to plan job: %v", err)
+	} else if job.State != BULK_CANCEL_PLANNED || job.BatchSize != DEFAULT_BULK_CANCEL_BATCH_SIZE || job.BatchIntervalS != DEFAULT_BULK_CANCEL_BATCH_INTERVAL_S {
+		t.Errorf("wrong planned job %v", job)
+	} else if len(job.Remaining()) != 2 {
+		t.Errorf("job should have 2 agreements, has %v", job.Agreements)
+	}
+
+	if _, err := PlanBulkCancelJob(db, "job1", BulkCancelFilter{Org: "myorg", Pattern: "myorg/other"}, 0, 0); err == nil {
+		t.Errorf("planning a job with the same name should fail")
+	} else if _, err := AbortBulkCancelJob(db, "nojob"); err == nil {
+		t.Errorf("aborting a job that doesnt exist should fail")
+	}
+
+	if job, err := StartBulkCancelJob(db, "job1"); err != nil {
+		t.Errorf("unable to start job: %v", err)
+	} else if job.State != BULK_CANCEL_RUNNING || job.StartedTime == 0 {
+		t.Errorf("wrong started job %v", job)
+	} else if _, err := StartBulkCancelJob(db, "job1"); err == nil {
+		t.Errorf("starting a running job should fail")
+	} else if err := DeleteBulkCancelJob(db, "job1"); err == nil {
+		t.Errorf("deleting a running job should fail")
+	}
+
+	if job, err := AbortBulkCancelJob(db, "job1"); err != nil {
+		t.Errorf("unable to abort job: %v", err)
+	} else if job.State != BULK_CANCEL_ABORTED || job.FinishedTime == 0 {
+		t.Errorf("wrong aborted job %v", job)
+	} else if err := DeleteBulkCancelJob(db, "job1"); err != nil {
+		t.Errorf("unable to delete job: %v", err)
+	} else if jobs, err := FindBulkCancelJobs(db); err != nil || len(jobs) != 0 {
+		t.Errorf("there should be no jobs, found %v, error: %v", jobs, err)
+	}
+}
@@ -0,0 +1,339 @@
+package agreementbot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/exchange"
+	"time"
+)
+
+// The BusinessPolicyManager does for the business policies served by the agbot what the PatternManager does for the
+// served patterns. It keeps the metadata of each served business policy, generates a policy from each one, and
+// regenerates or deletes that policy when the business policy changes or is no longer served.
+
+type BusinessPolicyEntry struct {
+	Policy          *exchange.BusinessPolicy `json:"policy,omitempty"`          // the metadata for this business policy from the exchange
+	Updated         uint64                   `json:"updatedTime,omitempty"`     // the time when this entry was updated
+	Hash            []byte                   `json:"hash,omitempty"`            // a hash of the current entry to compare for matadata changes in the exchange
+	PolicyFileNames []string                 `json:"policyFileNames,omitempty"` // the list of policy names generated for this business policy
+}
+
+func (p *BusinessPolicyEntry) String() string {
+	return fmt.Sprintf("Business Policy Entry: "+
+		"Updated: %v "+
+		"Hash: %x "+
+		"Files: %v "+
+		"Policy: %v",
+		p.Updated, p.Hash, p.PolicyFileNames, p.Policy)
+}
+
+func (p *BusinessPolicyEntry) ShortString() string {
+	return fmt.Sprintf("Files: %v", p.PolicyFileNames)
+}
+
+func NewBusinessPolicyEntry(pol *exchange.BusinessPolicy) (*BusinessPolicyEntry, error) {
+	pe := new(BusinessPolicyEntry)
+	pe.Policy = pol
+	pe.Updated = uint64(time.Now().Unix())
+	if hash, err := hashServedResource(pol); err != nil {
+		return nil, err
+	} else {
+		pe.Hash = hash
+	}
+	pe.PolicyFileNames = make([]string, 0, 1)
+	return pe, nil
+}
+
+func (pe *BusinessPolicyEntry) AddPolicyFileName(fileName string) {
+	pe.PolicyFileNames = append(pe.PolicyFileNames, fileName)
+}
+
+func (pe *BusinessPolicyEntry) DeleteAllPolicyFiles(store PatternPolicyStore, org string) error {
+
+	for _, fileName := range pe.PolicyFileNames {
+		if err := store.DeletePolicy(fileName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete the policies generated from a previous version of the business policy that are not generated any more. A
+// policy that is still generated was replaced in place when it was added, so it is never seen as deleted.
+func (pe *BusinessPolicyEntry) DeleteReplacedPolicyFiles(store PatternPolicyStore, oldFileNames []string) error {
+
+	current := make(map[string]bool)
+	for _, fileName := range pe.PolicyFileNames {
+		current[fileName] = true
+	}
+	for _, fileName := range oldFileNames {
+		if current[fileName] {
+			continue
+		} else if err := store.DeletePolicy(fileName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (pe *BusinessPolicyEntry) UpdateEntry(pol *exchange.BusinessPolicy, newHash []byte) {
+	pe.Policy = pol
+	pe.Hash = newHash
+	pe.Updated = uint64(time.Now().Unix())
+	pe.PolicyFileNames = make([]string, 0, 1)
+}
+
+type BusinessPolicyManager struct {
+	OrgPolicies map[string]map[string]*BusinessPolicyEntry
+	NodeOrgs    map[string][]string // the orgs of the nodes that each served business policy is deployed to, keyed by org/name
+	PolicyStore PatternPolicyStore  // where the policies generated from the business policies are kept
+}
+
+func (p *BusinessPolicyManager) String() string {
+	res := "Business Policy Manager: "
+	for org, orgMap := range p.OrgPolicies {
+		res += fmt.Sprintf("Org: %v ", org)
+		for pol, pe := range orgMap {
+			res += fmt.Sprintf("Business Policy: %v %v ", pol, pe)
+		}
+	}
+	return res
+}
+
+func (p *BusinessPolicyManager) ShortString() string {
+	res := "Business Policy Manager: "
+	for org, orgMap := range p.OrgPolicies {
+		res += fmt.Sprintf("Org: %v ", org)
+		for pol, pe := range orgMap {
+			s := ""
+			if pe != nil {
+				s = pe.ShortString()
+			}
+			res += fmt.Sprintf("Business Policy: %v %v ", pol, s)
+		}
+	}
+	return res
+}
+
+func NewBusinessPolicyManager() *BusinessPolicyManager {
+	bpm := &BusinessPolicyManager{
+		OrgPolicies: make(map[string]map[string]*BusinessPolicyEntry),
+		NodeOrgs:    make(map[string][]string),
+		PolicyStore: &FilePolicyStore{},
+	}
+	return bpm
+}
+
+func (bpm *BusinessPolicyManager) hasOrg(org string) bool {
+	if _, ok := bpm.OrgPolicies[org]; ok {
+		return true
+	}
+	return false
+}
+
+func (bpm *BusinessPolicyManager) hasBusinessPolicy(org string, pol string) bool {
+	if bpm.hasOrg(org) {
+		if _, ok := bpm.OrgPolicies[org][pol]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Given the list of business policies that this agbot is supposed to be serving, convert it to a map of maps (keyed
+// by org and business policy name) to hold the business policy metadata. Business policies that are no longer served
+// have their generated policies deleted.
+func (bpm *BusinessPolicyManager) SetCurrentBusinessPolicies(servedPols map[string]exchange.ServedBusinessPolicy, policyPath string) error {
+
+	// Exit early if nothing to do
+	if len(bpm.OrgPolicies) == 0 && len(servedPols) == 0 {
+		return nil
+	}
+
+	// Create a new map of maps
+	newMap := make(map[string]map[string]*BusinessPolicyEntry)
+	newNodeOrgs := make(map[string][]string)
+
+	// For each business policy that this agbot is supposed to be serving, copy the map entries from the existing
+	// map or create new ones as necessary. The entry is nil for business policies that are newly served, it is
+	// created once the business policy metadata has been read from the exchange.
+	for _, served := range servedPols {
+
+		if _, ok := newMap[served.BusinessPolOrg]; !ok {
+			newMap[served.BusinessPolOrg] = make(map[string]*BusinessPolicyEntry)
+		}
+
+		// A business policy can be served for the nodes of more than one org.
+		polId := fmt.Sprintf("%v/%v", served.BusinessPolOrg, served.BusinessPol)
+		nodeOrg := served.NodeOrg
+		if nodeOrg == "" {
+			nodeOrg = served.BusinessPolOrg
+		}
+		newNodeOrgs[polId] = append(newNodeOrgs[polId], nodeOrg)
+
+		if bpm.hasBusinessPolicy(served.BusinessPolOrg, served.BusinessPol) {
+			newMap[served.BusinessPolOrg][served.BusinessPol] = bpm.OrgPolicies[served.BusinessPolOrg][served.BusinessPol]
+		} else {
+			newMap[served.BusinessPolOrg][served.BusinessPol] = nil
+		}
+	}
+
+	// Get rid of the orgs and business policies that are no longer served, along with their policies.
+	for org, orgMap := range bpm.OrgPolicies {
+		if _, ok := newMap[org]; !ok {
+			glog.V(5).Infof("Deleting the org %v from the business policy manager and all its policy files because it is no longer hosted by the agbot.", org)
+			if err := bpm.deleteOrg(policyPath, org); err != nil {
+				return err
+			}
+		} else {
+			for pol, _ := range orgMap {
+				if _, ok := newMap[org][pol]; !ok {
+					glog.V(5).Infof("Deleting business policy %v and its policy files from the org %v from the business policy manager because the business policy is no longer hosted by the agbot.", pol, org)
+					if err := bpm.deleteBusinessPolicy(policyPath, org, pol); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+
+	// The new map of business policies is current so save it as the BusinessPolicyManager's new state.
+	bpm.OrgPolicies = newMap
+	bpm.NodeOrgs = newNodeOrgs
+
+	return nil
+}
+
+// Returns the orgs of the nodes that the business policy is deployed to.
+func (bpm *BusinessPolicyManager) GetServedNodeOrgs(org string, pol string) []string {
+	return bpm.NodeOrgs[fmt.Sprintf("%v/%v", org, pol)]
+}
+
+// For an org that the agbot is serving, take the set of business policies defined within the org and save them into
+// the BusinessPolicyManager. When new or updated business policies are discovered, generate a policy for each one so
+// that the agbot can start serving the service.
+func (bpm *BusinessPolicyManager) UpdatePolicies(org string, definedPols map[string]exchange.BusinessPolicy, policyPath string) error {
+
+	// Exit early on error
+	if !bpm.hasOrg(org) {
+		return errors.New(fmt.Sprintf("org %v not found in business policy manager", org))
+	}
+
+	// If there is no business policy in the org, delete the org from the bpm and all of the policies in the org.
+	if definedPols == nil || len(definedPols) == 0 {
+		glog.V(5).Infof("Deleting the org %v from the business policy manager and all its policy files because it does not contain a business policy.", org)
+		return bpm.deleteOrg(policyPath, org)
+	}
+
+	// Delete the business policies that no longer exist on the exchange but are still served by the agbot.
+	for pol, _ := range bpm.OrgPolicies[org] {
+		found := false
+		for polId, _ := range definedPols {
+			if exchange.GetId(polId) == pol {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			glog.V(5).Infof("Deleting business policy %v and its policy files from the org %v from the business policy manager because the business policy no longer exists.", pol, org)
+			if err := bpm.deleteBusinessPolicy(policyPath, org, pol); err != nil {
+				return err
+			}
+		}
+	}
+
+	for polId, pol := range definedPols {
+		if err := bpm.updateBusinessPolicy(org, polId, pol, policyPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Create or update the BusinessPolicyEntry and the generated policy of a single business policy.
+func (bpm *BusinessPolicyManager) updateBusinessPolicy(org string, polId string, pol exchange.BusinessPolicy, policyPath string) error {
+
+	// The agbot is not configured to serve this business policy, ignore it.
+	if !bpm.hasBusinessPolicy(org, exchange.GetId(polId)) {
+		return nil
+	}
+
+	if pe := bpm.OrgPolicies[org][exchange.GetId(polId)]; pe == nil {
+		if newPE, err := NewBusinessPolicyEntry(&pol); err != nil {
+			return errors.New(fmt.Sprintf("unable to create business policy entry for %v, error %v", pol, err))
+		} else {
+			bpm.OrgPolicies[org][exchange.GetId(polId)] = newPE
+			glog.V(5).Infof("Creating the policy file for business policy %v.", polId)
+			if err := createBusinessPolicyFiles(bpm.PolicyStore, newPE, polId, &pol, policyPath, org); err != nil {
+				return errors.New(fmt.Sprintf("unable to create policy files for %v, error %v", pol, err))
+			}
+		}
+	} else {
+		// The entry was already there, so recreate the policy only if the business policy has changed.
+		newHash, err := hashServedResource(&pol)
+		if err != nil {
+			return errors.New(fmt.Sprintf("unable to hash business policy %v for %v, error %v", pol, org, err))
+		}
+		// The new policy is written over the old one before anything is deleted, so that the agbot does not see the
+		// policy disappear and cancel its agreements while the business policy is updated.
+		if !bytes.Equal(pe.Hash, newHash) {
+			glog.V(5).Infof("Replacing the policy files for org %v because the old business policy %v does not match the new business policy %v", org, pe.Policy, pol)
+			oldFileNames := pe.PolicyFileNames
+			pe.UpdateEntry(&pol, newHash)
+			if err := createBusinessPolicyFiles(bpm.PolicyStore, pe, polId, &pol, policyPath, org); err != nil {
+				return errors.New(fmt.Sprintf("unable to create policy files for %v, error %v", pol, err))
+			} else if err := pe.DeleteReplacedPolicyFiles(bpm.PolicyStore, oldFileNames); err != nil {
+				return errors.New(fmt.Sprintf("unable to delete policy files for %v, error %v", org, err))
+			}
+		}
+	}
+
+	return nil
+}
+
+// Create the policy file for the input business policy.
+func createBusinessPolicyFiles(store PatternPolicyStore, pe *BusinessPolicyEntry, polId string, pol *exchange.BusinessPolicy, policyPath string, org string) error {
+	if generated, err := exchange.ConvertBusinessPolicyToPolicy(polId, pol); err != nil {
+		return errors.New(fmt.Sprintf("error converting business policy to policy, error %v", err))
+	} else if fileName, err := store.AddPolicy(policyPath, org, generated); err != nil {
+		return errors.New(fmt.Sprintf("error creating policy file, error %v", err))
+	} else {
+		pe.AddPolicyFileName(fileName)
+	}
+	return nil
+}
+
+// When an org is no longer served, remove it from the BusinessPolicyManager and delete all the policies generated
+// from its business policies.
+func (bpm *BusinessPolicyManager) deleteOrg(policyPath string, org string) error {
+
+	if err := bpm.PolicyStore.DeleteOrgBusinessPolicyPolicies(policyPath, org); err != nil {
+		glog.Errorf("Error deleting business policy files for org %v. %v", org, err)
+	}
+
+	if bpm.hasOrg(org) {
+		delete(bpm.OrgPolicies, org)
+	}
+
+	return nil
+}
+
+// When a business policy is removed, remove it from the BusinessPolicyManager and delete its generated policy.
+func (bpm *BusinessPolicyManager) deleteBusinessPolicy(policyPath string, org string, pol string) error {
+
+	if err := bpm.PolicyStore.DeleteBusinessPolicyPolicies(policyPath, org, pol); err != nil {
+		glog.Errorf("Error deleting policy files for business policy %v/%v. %v", org, pol, err)
+	}
+
+	if bpm.hasOrg(org) {
+		if _, ok := bpm.OrgPolicies[org][pol]; ok {
+			delete(bpm.OrgPolicies[org], pol)
+		}
+	}
+
+	return nil
+}
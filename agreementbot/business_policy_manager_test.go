@@ -0,0 +1,162 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"bytes"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/policy"
+	"testing"
+)
+
+// Serve a business policy alongside a pattern in the same org, then change and remove the business policy.
+func Test_business_policy_manager_setpolicies(t *testing.T) {
+
+	policyPath := "/tmp/servedbusinespoltest/"
+	myorg1 := "myorg1"
+	bp1 := "bp1"
+
+	if err := cleanTestDir(policyPath); err != nil {
+		t.Errorf(err.Error())
+	}
+
+	// A pattern in the same org, its policy files must survive the business policy changes.
+	np := NewPatternManager()
+	servedPatterns := map[string]exchange.ServedPattern{"myorg1_pattern1": {Org: myorg1, Pattern: "pattern1"}}
+	if err := np.SetCurrentPatterns(servedPatterns, policyPath); err != nil {
+		t.Errorf("Error %v consuming served patterns %v", err, servedPatterns)
+	} else if err := np.UpdatePatternPolicies(myorg1, map[string]exchange.Pattern{"myorg1/pattern1": getTestPattern()}, policyPath); err != nil {
+		t.Errorf("Error: error updating pattern policies, %v", err)
+	}
+	patternFiles := np.OrgPatterns[myorg1]["pattern1"].PolicyFileNames
+
+	servedPols := map[string]exchange.ServedBusinessPolicy{
+		"myorg1_bp1": {BusinessPolOrg: myorg1, BusinessPol: bp1, NodeOrg: myorg1},
+	}
+	bp := getTestBusinessPolicy()
+	definedPols := map[string]exchange.BusinessPolicy{"myorg1/bp1": bp, "myorg1/notserved": bp}
+
+	bpm := NewBusinessPolicyManager()
+	if err := bpm.SetCurrentBusinessPolicies(servedPols, policyPath); err != nil {
+		t.Errorf("Error %v consuming served business policies %v", err, servedPols)
+	} else if pe, ok := bpm.OrgPolicies[myorg1][bp1]; !ok || pe != nil {
+		t.Errorf("Error: newly served business policy should have an empty entry, have %v", bpm)
+	} else if nodeOrgs := bpm.GetServedNodeOrgs(myorg1, bp1); len(nodeOrgs) != 1 || nodeOrgs[0] != myorg1 {
+		t.Errorf("Error: business policy %v should be served for the nodes of %v, is served for %v", bp1, myorg1, nodeOrgs)
+	} else if err := bpm.UpdatePolicies(myorg1, definedPols, policyPath); err != nil {
+		t.Errorf("Error: error updating business policies, %v", err)
+	} else if bpm.hasBusinessPolicy(myorg1, "notserved") {
+		t.Errorf("Error: business policy notserved should have been ignored, have %v", bpm)
+	} else if pe := bpm.OrgPolicies[myorg1][bp1]; pe == nil || len(pe.PolicyFileNames) != 1 {
+		t.Errorf("Error: business policy %v should have 1 policy file, have %v", bp1, pe)
+	} else if err := getPatternEntryFiles(pe.PolicyFileNames); err != nil {
+		t.Errorf("Error getting business policy files for %v %v, %v", myorg1, bp1, err)
+	} else if pol, err := policy.ReadPolicyFile(pe.PolicyFileNames[0], config.NewArchSynonyms()); err != nil {
+		t.Errorf("Error reading generated policy, %v", err)
+	} else if pol.BusinessPolId != "myorg1/bp1" || pol.PatternId != "" || !pol.IsServiceBased() || len(pol.Workloads) != 2 {
+		t.Errorf("Error: wrong policy generated from business policy, %v", pol)
+	} else if pol.Header.Name != "bp_bp1_bluehorizon.network-services-netspeed_myorg1_amd64" {
+		t.Errorf("Error: wrong policy name %v", pol.Header.Name)
+	}
+
+	// Changing the business policy regenerates its policy file.
+	oldHash := bpm.OrgPolicies[myorg1][bp1].Hash
+	bp.Label = "changed label"
+	definedPols["myorg1/bp1"] = bp
+	if err := bpm.UpdatePolicies(myorg1, definedPols, policyPath); err != nil {
+		t.Errorf("Error: error updating business policies, %v", err)
+	} else if pe := bpm.OrgPolicies[myorg1][bp1]; bytes.Equal(pe.Hash, oldHash) || pe.Policy.Label != "changed label" {
+		t.Errorf("Error: business policy %v should have been updated, have %v", bp1, pe)
+	} else if err := getPatternEntryFiles(pe.PolicyFileNames); err != nil {
+		t.Errorf("Error getting business policy files for %v %v, %v", myorg1, bp1, err)
+	}
+
+	// No longer serving the business policy removes its policy file, but not the pattern's.
+	if err := bpm.SetCurrentBusinessPolicies(map[string]exchange.ServedBusinessPolicy{}, policyPath); err != nil {
+		t.Errorf("Error %v consuming served business policies", err)
+	} else if len(bpm.OrgPolicies) != 0 {
+		t.Errorf("Error: should have 0 orgs in the BusinessPolicyManager, have %v", bpm)
+	} else if files, err := getPolicyFiles(policyPath + myorg1); err != nil {
+		t.Errorf("Error getting policy files from %v, %v", policyPath, err)
+	} else if len(files) != len(patternFiles) {
+		t.Errorf("Error: only the pattern policy files should be left, have %v", files)
+	} else if err := getPatternEntryFiles(patternFiles); err != nil {
+		t.Errorf("Error getting pattern entry files, %v", err)
+	}
+}
+
+// Changing a business policy replaces its policy, the agbot is never told that the policy was deleted.
+func Test_business_policy_manager_replace(t *testing.T) {
+
+	myorg1 := "myorg1"
+	bp1 := "bp1"
+
+	changed := make([]string, 0, 10)
+	deleted := make([]string, 0, 10)
+
+	bpm := NewBusinessPolicyManager()
+	bpm.PolicyStore = NewMemoryPolicyStore(config.ArchSynonyms{}, nil,
+		func(org string, name string, pol *policy.Policy) { changed = append(changed, name) },
+		func(org string, name string, pol *policy.Policy) { deleted = append(deleted, name) })
+
+	servedPols := map[string]exchange.ServedBusinessPolicy{
+		"myorg1_bp1": {BusinessPolOrg: myorg1, BusinessPol: bp1, NodeOrg: myorg1},
+	}
+	bp := getTestBusinessPolicy()
+
+	if err := bpm.SetCurrentBusinessPolicies(servedPols, "/unused"); err != nil {
+		t.Errorf("Error %v consuming served business policies %v", err, servedPols)
+	} else if err := bpm.UpdatePolicies(myorg1, map[string]exchange.BusinessPolicy{"myorg1/bp1": bp}, "/unused"); err != nil {
+		t.Errorf("Error: error updating business policies, %v", err)
+	}
+
+	bp.Label = "changed label"
+	if err := bpm.UpdatePolicies(myorg1, map[string]exchange.BusinessPolicy{"myorg1/bp1": bp}, "/unused"); err != nil {
+		t.Errorf("Error: error updating business policies, %v", err)
+	} else if len(changed) != 2 || len(deleted) != 0 {
+		t.Errorf("Error: the policy should have been changed twice and never deleted, changed %v, deleted %v", changed, deleted)
+	} else if pe := bpm.OrgPolicies[myorg1][bp1]; len(pe.PolicyFileNames) != 1 || pe.PolicyFileNames[0] != changed[0] {
+		t.Errorf("Error: business policy %v should still have its policy, have %v", bp1, pe)
+	}
+}
+
+func Test_ConvertBusinessPolicyToPolicy_errors(t *testing.T) {
+
+	bp := getTestBusinessPolicy()
+	bp.Service.Arch = ""
+	if _, err := exchange.ConvertBusinessPolicyToPolicy("myorg1/bp1", &bp); err == nil {
+		t.Errorf("Error: business policy without a service arch should not convert")
+	}
+
+	bp = getTestBusinessPolicy()
+	bp.Service.ServiceVersions = nil
+	if _, err := exchange.ConvertBusinessPolicyToPolicy("myorg1/bp1", &bp); err == nil {
+		t.Errorf("Error: business policy without service versions should not convert")
+	}
+
+	bp = getTestBusinessPolicy()
+	bp.Constraints = policy.RequiredProperty{"bad": []interface{}{}}
+	if _, err := exchange.ConvertBusinessPolicyToPolicy("myorg1/bp1", &bp); err == nil {
+		t.Errorf("Error: business policy with invalid constraints should not convert")
+	}
+}
+
+func getTestBusinessPolicy() exchange.BusinessPolicy {
+	return exchange.BusinessPolicy{
+		Owner:       "myorg1/user",
+		Label:       "business policy",
+		Description: "deploys netspeed",
+		Service: exchange.BusinessService{
+			Name: "https://bluehorizon.network/services/netspeed",
+			Org:  "myorg1",
+			Arch: "amd64",
+			ServiceVersions: []exchange.WorkloadChoice{
+				{Version: "1.0.0", Priority: exchange.WorkloadPriority{PriorityValue: 1}},
+				{Version: "1.1.0", Priority: exchange.WorkloadPriority{PriorityValue: 2}},
+			},
+		},
+		Properties:  policy.PropertyList{{Name: "purpose", Value: "network-testing"}},
+		Constraints: policy.RequiredProperty{"and": []interface{}{map[string]interface{}{"name": "location", "value": "lab"}}},
+	}
+}
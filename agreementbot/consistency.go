@@ -0,0 +1,261 @@
+package agreementbot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/policy"
+	"sort"
+	"time"
+)
+
+// When the agbot starts, it cross-checks the agreements in its database with its agreement resources in the exchange
+// and with what the agreement protocol knows about the blockchain, and repairs what a crash can leave half done. An
+// agreement that was terminated but not archived is terminated again, which deletes it from the exchange, cancels it
+// on the blockchain (deferred until the blockchain is writable) and archives it. An agreement whose reply was accepted
+// but that was not finalized is finalized again, in the database and in the exchange, unless its protocol finalizes
+// agreements when they are seen on a blockchain; those are left to the blockchain events and the not finalized
+// timeout. A finalized agreement that the exchange doesn't have as finalized is recorded as finalized again. An
+// agreement resource in the exchange that has no agreement in the database is an orphan and is deleted, the node
+// cancels its side when the agbot does not confirm the agreement. The report of the last check is saved in the
+// database.
+const CONSISTENCY = "consistency"
+const CONSISTENCY_REPORT = "report"
+
+const (
+	CONSISTENCY_NOT_ARCHIVED  = "terminated but not archived"
+	CONSISTENCY_NOT_FINALIZED = "reply accepted but not finalized"
+	CONSISTENCY_STATE_MISSING = "finalized but not in the exchange"
+	CONSISTENCY_ORPHAN        = "in the exchange but not in the database"
+)
+
+const (
+	CONSISTENCY_REPAIR_TERMINATE = "terminate and archive"
+	CONSISTENCY_REPAIR_FINALIZE  = "finalize"
+	CONSISTENCY_REPAIR_RECORD    = "record finalized state"
+	CONSISTENCY_REPAIR_DELETE    = "delete from the exchange"
+)
+
+const EXCHANGE_STATE_FINALIZED = "Finalized Agreement"
+
+type ConsistencyFinding struct {
+	AgreementId string `json:"agreement_id"`
+	Protocol    string `json:"protocol,omitempty"`
+	DeviceId    string `json:"device_id,omitempty"`
+	Problem     string `json:"problem"`
+	Repair      string `json:"repair"`
+	Error       string `json:"error,omitempty"` // why the repair failed, empty when it worked
+}
+
+func (f ConsistencyFinding) String() string {
+	return fmt.Sprintf("AgreementId: %v, Protocol: %v, DeviceId: %v, Problem: %v, Repair: %v, Error: %v", f.AgreementId, f.Protocol, f.DeviceId, f.Problem, f.Repair, f.Error)
+}
+
+type ConsistencyReport struct {
+	StartTime          uint64               `json:"start_time"`
+	EndTime            uint64               `json:"end_time"`
+	Agreements         int                  `json:"agreements"`          // the unarchived agreements in the database
+	ExchangeAgreements int                  `json:"exchange_agreements"` // the agreement resources of the agbot in the exchange
+	Findings           []ConsistencyFinding `json:"findings"`
+}
+
+func (r ConsistencyReport) String() string {
+	return fmt.Sprintf("StartTime: %v, EndTime: %v, Agreements: %v, ExchangeAgreements: %v, Findings: %v", r.StartTime, r.EndTime, r.Agreements, r.ExchangeAgreements, r.Findings)
+}
+
+// Compare the unarchived agreements in the database with the agreement resources in the exchange. The agreements
+// whose reply was accepted are the ones for which receivedReply is true, finalizedOnBlockchain is true for the
+// agreements that their protocol finalizes when they are seen on a blockchain. The findings are sorted by agreement id.
+func findInconsistencies(agreements []Agreement, exAgreements map[string]exchange.AgbotAgreement, receivedReply func(*Agreement) bool, finalizedOnBlockchain func(*Agreement) bool) []ConsistencyFinding {
+
+	findings := make([]ConsistencyFinding, 0)
+	known := make(map[string]bool)
+
+	for ix, ag := range agreements {
+		known[ag.CurrentAgreementId] = true
+		finding := ConsistencyFinding{AgreementId: ag.CurrentAgreementId, Protocol: ag.AgreementProtocol, DeviceId: ag.DeviceId}
+
+		if ag.AgreementTimedout != 0 {
+			finding.Problem, finding.Repair = CONSISTENCY_NOT_ARCHIVED, CONSISTENCY_REPAIR_TERMINATE
+		} else if ag.AgreementCreationTime == 0 {
+			continue
+		} else if ag.AgreementFinalizedTime == 0 {
+			if !receivedReply(&agreements[ix]) || finalizedOnBlockchain(&agreements[ix]) {
+				continue
+			}
+			finding.Problem, finding.Repair = CONSISTENCY_NOT_FINALIZED, CONSISTENCY_REPAIR_FINALIZE
+		} else if exAg, ok := exAgreements[ag.CurrentAgreementId]; !ok || exAg.State != EXCHANGE_STATE_FINALIZED {
+			finding.Problem, finding.Repair = CONSISTENCY_STATE_MISSING, CONSISTENCY_REPAIR_RECORD
+		} else {
+			continue
+		}
+		findings = append(findings, finding)
+	}
+
+	for agreementId, _ := range exAgreements {
+		if !known[agreementId] {
+			findings = append(findings, ConsistencyFinding{AgreementId: agreementId, Problem: CONSISTENCY_ORPHAN, Repair: CONSISTENCY_REPAIR_DELETE})
+		}
+	}
+
+	sort.Sort(ConsistencyFindingsById(findings))
+	return findings
+}
+
+type ConsistencyFindingsById []ConsistencyFinding
+
+func (s ConsistencyFindingsById) Len() int {
+	return len(s)
+}
+
+func (s ConsistencyFindingsById) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s ConsistencyFindingsById) Less(i, j int) bool {
+	return s[i].AgreementId < s[j].AgreementId
+}
+
+// Check the agreements of the agbot and repair what is inconsistent. This is called once at startup, after the sync
+// up and before the agbot makes new agreements.
+func (w *AgreementBotWorker) checkConsistency() (*ConsistencyReport, error) {
+
+	glog.V(3).Infof(AWlogString("checking the consistency of the agreements."))
+	report := &ConsistencyReport{StartTime: uint64(time.Now().Unix())}
+
+	var resp interface{}
+	resp = new(exchange.AllAgbotAgreementsResponse)
+	targetURL := w.GetExchangeURL() + "orgs/" + exchange.GetOrg(w.GetExchangeId()) + "/agbots/" + exchange.GetId(w.GetExchangeId()) + "/agreements"
+	if err, tpErr := exchange.InvokeExchange(w.httpClient, "GET", targetURL, w.GetExchangeId(), w.GetExchangeToken(), nil, &resp); err != nil || tpErr != nil {
+		return nil, errors.New(AWlogString(fmt.Sprintf("unable to get the agreements of the agbot from the exchange, error %v, transport error %v", err, tpErr)))
+	}
+	exAgreements := resp.(*exchange.AllAgbotAgreementsResponse).Agreements
+	report.ExchangeAgreements = len(exAgreements)
+
+	agreements := make([]Agreement, 0)
+	for _, agp := range policy.AllAgreementProtocols() {
+		if ags, err := FindAgreements(w.db, []AFilter{UnarchivedAFilter()}, agp); err != nil {
+			return nil, errors.New(AWlogString(fmt.Sprintf("error searching database: %v", err)))
+		} else {
+			agreements = append(agreements, ags...)
+		}
+	}
+	report.Agreements = len(agreements)
+
+	byId := make(map[string]*Agreement)
+	for ix, ag := range agreements {
+		byId[ag.CurrentAgreementId] = &agreements[ix]
+	}
+
+	// An agreement of a protocol the agbot is not running can't be repaired, it isn't in the findings.
+	known := make([]Agreement, 0, len(agreements))
+	for _, ag := range agreements {
+		if _, ok := w.consumerPH[ag.AgreementProtocol]; ok {
+			known = append(known, ag)
+		} else {
+			glog.Warningf(AWlogString(fmt.Sprintf("not checking agreement %v, agreement protocol %v is not configured", ag.CurrentAgreementId, ag.AgreementProtocol)))
+			delete(exAgreements, ag.CurrentAgreementId)
+		}
+	}
+
+	receivedReply := func(ag *Agreement) bool {
+		return w.consumerPH[ag.AgreementProtocol].AlreadyReceivedReply(ag)
+	}
+	finalizedOnBlockchain := func(ag *Agreement) bool {
+		bcType, _, _ := w.consumerPH[ag.AgreementProtocol].GetKnownBlockchain(ag)
+		return bcType != ""
+	}
+
+	report.Findings = findInconsistencies(known, exAgreements, receivedReply, finalizedOnBlockchain)
+	for ix, finding := range report.Findings {
+		glog.Warningf(AWlogString(fmt.Sprintf("agreement %v is %v, repairing: %v", finding.AgreementId, finding.Problem, finding.Repair)))
+		if err := w.repairInconsistency(&finding, byId[finding.AgreementId]); err != nil {
+			glog.Errorf(AWlogString(fmt.Sprintf("unable to repair agreement %v, error: %v", finding.AgreementId, err)))
+			report.Findings[ix].Error = err.Error()
+		}
+	}
+
+	report.EndTime = uint64(time.Now().Unix())
+	if err := SaveConsistencyReport(w.db, report); err != nil {
+		glog.Errorf(AWlogString(fmt.Sprintf("unable to save consistency report, error: %v", err)))
+	}
+	glog.V(3).Infof(AWlogString(fmt.Sprintf("consistency check completed with %v findings.", len(report.Findings))))
+	return report, nil
+}
+
+func (w *AgreementBotWorker) repairInconsistency(finding *ConsistencyFinding, ag *Agreement) error {
+
+	switch finding.Repair {
+	case CONSISTENCY_REPAIR_TERMINATE:
+		cph := w.consumerPH[ag.AgreementProtocol]
+		reason := ag.TerminatedReason
+		if reason == 0 {
+			reason = cph.GetTerminationCode(TERM_REASON_AG_MISSING)
+		}
+		cph.HandleAgreementTimeout(NewAgreementTimeoutCommand(ag.CurrentAgreementId, ag.AgreementProtocol, reason), cph)
+
+	case CONSISTENCY_REPAIR_FINALIZE:
+		cph := w.consumerPH[ag.AgreementProtocol]
+		if finalizedAg, err := AgreementFinalized(w.db, ag.CurrentAgreementId, ag.AgreementProtocol); err != nil {
+			return err
+		} else {
+			cph.Webhooks().Notify(NewWebhookEvent(WEBHOOK_AGREEMENT_FINALIZED, finalizedAg, 0, ""))
+			noteNegotiation(NegotiationFinalized(w.db, finalizedAg.DeviceId, finalizedAg.CurrentAgreementId), finalizedAg.DeviceId, finalizedAg.CurrentAgreementId, NEGOTIATION_FINALIZED)
+		}
+		return w.recordFinalizedState(ag)
+
+	case CONSISTENCY_REPAIR_RECORD:
+		return w.recordFinalizedState(ag)
+
+	case CONSISTENCY_REPAIR_DELETE:
+		return DeleteConsumerAgreement(w.Config.Collaborators.HTTPClientFactory.NewHTTPClient(nil), w.GetExchangeURL(), w.GetExchangeId(), w.GetExchangeToken(), finding.AgreementId)
+	}
+	return nil
+}
+
+func (w *AgreementBotWorker) recordFinalizedState(ag *Agreement) error {
+	if pol, err := policy.DemarshalPolicy(ag.Policy); err != nil {
+		return errors.New(fmt.Sprintf("unable to demarshal policy of agreement %v, error: %v", ag.CurrentAgreementId, err))
+	} else {
+		return w.recordConsumerAgreementState(ag.CurrentAgreementId, pol, ag.Org, EXCHANGE_STATE_FINALIZED)
+	}
+}
+
+// Returns the report of the last consistency check, nil if there wasn't one.
+func FindConsistencyReport(db *bolt.DB) (*ConsistencyReport, error) {
+	var report *ConsistencyReport
+
+	readErr := db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(CONSISTENCY)); b == nil {
+			return nil
+		} else if v := b.Get([]byte(CONSISTENCY_REPORT)); v == nil {
+			return nil
+		} else {
+			report = new(ConsistencyReport)
+			if err := json.Unmarshal(v, report); err != nil {
+				return fmt.Errorf("Unable to deserialize consistency report: %v", err)
+			}
+		}
+		return nil
+	})
+
+	if readErr != nil {
+		return nil, readErr
+	}
+	return report, nil
+}
+
+func SaveConsistencyReport(db *bolt.DB, report *ConsistencyReport) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if b, err := tx.CreateBucketIfNotExists([]byte(CONSISTENCY)); err != nil {
+			return err
+		} else if serial, err := json.Marshal(report); err != nil {
+			return fmt.Errorf("Unable to serialize consistency report %v: %v", report, err)
+		} else {
+			return b.Put([]byte(CONSISTENCY_REPORT), serial)
+		}
+	})
+}
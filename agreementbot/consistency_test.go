@@ -0,0 +1,93 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/open-horizon/anax/exchange"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func Test_findInconsistencies(t *testing.T) {
+
+	agreements := []Agreement{
+		{CurrentAgreementId: "a1", DeviceId: "myorg/d1", AgreementProtocol: "Basic", AgreementCreationTime: 10, AgreementTimedout: 20},
+		{CurrentAgreementId: "a2", DeviceId: "myorg/d2", AgreementProtocol: "Basic", AgreementCreationTime: 10, CounterPartyAddress: "myorg/d2"},
+		{CurrentAgreementId: "a3", DeviceId: "myorg/d3", AgreementProtocol: "Basic", AgreementCreationTime: 10},
+		{CurrentAgreementId: "a4", DeviceId: "myorg/d4", AgreementProtocol: "Citizen Scientist", AgreementCreationTime: 10, CounterPartyAddress: "0x1", BlockchainType: "ethereum"},
+		{CurrentAgreementId: "a5", DeviceId: "myorg/d5", AgreementProtocol: "Basic", AgreementCreationTime: 10, AgreementFinalizedTime: 15, CounterPartyAddress: "myorg/d5"},
+		{CurrentAgreementId: "a6", DeviceId: "myorg/d6", AgreementProtocol: "Basic", AgreementCreationTime: 10, AgreementFinalizedTime: 15, CounterPartyAddress: "myorg/d6"},
+		{CurrentAgreementId: "a7", DeviceId: "myorg/d7", AgreementProtocol: "Basic", AgreementInceptionTime: 5},
+	}
+	exAgreements := map[string]exchange.AgbotAgreement{
+		"a1": {State: "Finalized Agreement"},
+		"a5": {State: "Producer Agreed"},
+		"a6": {State: "Finalized Agreement"},
+		"a9": {State: "Finalized Agreement"},
+	}
+	receivedReply := func(ag *Agreement) bool { return ag.CounterPartyAddress != "" }
+	finalizedOnBlockchain := func(ag *Agreement) bool { return ag.BlockchainType != "" }
+
+	findings := findInconsistencies(agreements, exAgreements, receivedReply, finalizedOnBlockchain)
+
+	expected := []struct {
+		id     string
+		repair string
+	}{
+		{"a1", CONSISTENCY_REPAIR_TERMINATE},
+		{"a2", CONSISTENCY_REPAIR_FINALIZE},
+		{"a5", CONSISTENCY_REPAIR_RECORD},
+		{"a9", CONSISTENCY_REPAIR_DELETE},
+	}
+	if len(findings) != len(expected) {
+		t.Fatalf("expected %v findings, got %v", len(expected), findings)
+	}
+	for ix, e := range expected {
+		if findings[ix].AgreementId != e.id || findings[ix].Repair != e.repair {
+			t.Errorf("expected finding %v to be %v %v, got %v", ix, e.id, e.repair, findings[ix])
+		}
+	}
+	if findings[3].Problem != CONSISTENCY_ORPHAN || findings[3].DeviceId != "" {
+		t.Errorf("wrong orphan finding %v", findings[3])
+	}
+
+	// Nothing to repair when the database and the exchange agree.
+	if findings := findInconsistencies(agreements[5:6], map[string]exchange.AgbotAgreement{"a6": {State: "Finalized Agreement"}}, receivedReply, finalizedOnBlockchain); len(findings) != 0 {
+		t.Errorf("expected no findings, got %v", findings)
+	}
+}
+
+func Test_ConsistencyReport(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "agbot-consistency")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := bolt.Open(path.Join(dir, "agbot.db"), 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("unable to open db: %v", err)
+	}
+	defer db.Close()
+
+	if report, err := FindConsistencyReport(db); err != nil {
+		t.Fatalf("unable to find consistency report: %v", err)
+	} else if report != nil {
+		t.Errorf("a new agbot should not have a consistency report, got %v", report)
+	}
+
+	report := &ConsistencyReport{StartTime: 100, EndTime: 101, Agreements: 2, ExchangeAgreements: 3,
+		Findings: []ConsistencyFinding{{AgreementId: "a9", Problem: CONSISTENCY_ORPHAN, Repair: CONSISTENCY_REPAIR_DELETE, Error: "exchange down"}}}
+	if err := SaveConsistencyReport(db, report); err != nil {
+		t.Fatalf("unable to save consistency report: %v", err)
+	} else if saved, err := FindConsistencyReport(db); err != nil {
+		t.Fatalf("unable to find consistency report: %v", err)
+	} else if saved == nil || saved.EndTime != 101 || len(saved.Findings) != 1 || saved.Findings[0].Error != "exchange down" {
+		t.Errorf("wrong saved consistency report %v", saved)
+	}
+}
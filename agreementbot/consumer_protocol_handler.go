@@ -8,12 +8,15 @@ import (
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/abstractprotocol"
 	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/exchange"
 	"github.com/open-horizon/anax/metering"
 	"github.com/open-horizon/anax/policy"
 	"github.com/open-horizon/anax/worker"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -56,6 +59,9 @@ type ConsumerProtocolHandler interface {
 	CanCancelNow(agreement *Agreement) bool
 	DeferCommand(cmd AgreementWork)
 	HandleDeferredCommands()
+	PersistDeferredCommands() error
+	ResendMessages()
+	ActiveWork() int
 	PostReply(agreementId string, proposal abstractprotocol.Proposal, reply abstractprotocol.ProposalReply, consumerPolicy *policy.Policy, org string, workerId string) error
 	UpdateProducer(ag *Agreement)
 	HandleExtensionMessage(cmd *NewProtocolMessageCommand) error
@@ -67,6 +73,7 @@ type ConsumerProtocolHandler interface {
 	GetExchangeURL() string
 	GetServiceBased() bool
 	GetHTTPFactory() *config.HTTPClientFactory
+	Webhooks() *WebhookNotifier
 }
 
 type BaseConsumerProtocolHandler struct {
@@ -78,7 +85,12 @@ type BaseConsumerProtocolHandler struct {
 	agbotId          string
 	token            string
 	deferredCommands []AgreementWork // The agreement related work that has to be deferred and retried
+	deferredLock     sync.Mutex      // Agreement workers defer work concurrently
 	messages         chan events.Message
+	proposalBatcher  *ProposalBatcher      // Coalesces outgoing proposals, nil when batching is not configured
+	webhooks         *WebhookNotifier      // Sends agreement events to external systems, nil when no webhooks are configured
+	rateLimiter      *AgreementRateLimiter // Caps how fast new agreements are started per org and pattern, nil when no limit is configured
+	activeWork       int32                 // The number of work items currently being handled by the agreement workers
 }
 
 func (b *BaseConsumerProtocolHandler) GetSendMessage() func(mt interface{}, pay []byte) error {
@@ -109,6 +121,10 @@ func (b *BaseConsumerProtocolHandler) GetHTTPFactory() *config.HTTPClientFactory
 	return b.config.Collaborators.HTTPClientFactory
 }
 
+func (b *BaseConsumerProtocolHandler) Webhooks() *WebhookNotifier {
+	return b.webhooks
+}
+
 func (w *BaseConsumerProtocolHandler) sendMessage(mt interface{}, pay []byte) error {
 	// The mt parameter is an abstract message target object that is passed to this routine
 	// by the agreement protocol. It's an interface{} type so that we can avoid the protocol knowing
@@ -123,7 +139,7 @@ func (w *BaseConsumerProtocolHandler) sendMessage(mt interface{}, pay []byte) er
 	}
 
 	// Grab the exchange ID of the message receiver
-	glog.V(3).Infof(BCPHlogstring(w.Name(), fmt.Sprintf("sending exchange message to: %v, message %v", messageTarget.ReceiverExchangeId, string(pay))))
+	cutil.TraceV(3, "", messageTarget.ReceiverExchangeId).Infof(BCPHlogstring(w.Name(), fmt.Sprintf("sending exchange message to: %v, message %v", messageTarget.ReceiverExchangeId, string(pay))))
 
 	// Get my own keys
 	myPubKey, myPrivKey, _ := exchange.GetKeys(w.config.AgreementBot.MessageKeyPath)
@@ -137,32 +153,94 @@ func (w *BaseConsumerProtocolHandler) sendMessage(mt interface{}, pay []byte) er
 		}
 	}
 
-	// Create an encrypted message
-	if encryptedMsg, err := exchange.ConstructExchangeMessage(pay, myPubKey, myPrivKey, messageTarget.ReceiverPublicKeyObj); err != nil {
+	// The proposal and its reply tell whether the other party reads sealed messages.
+	if negotiated, sealed := abstractprotocol.NegotiatesSealing(pay); negotiated {
+		exchange.SetMessageSealing(messageTarget.ReceiverExchangeId, sealed)
+	}
+
+	// Create an encrypted message, sealed when the receiver reads sealed messages
+	if encryptedMsg, err := exchange.ConstructMessage(pay, exchange.MessageSealing(messageTarget.ReceiverExchangeId), myPubKey, myPrivKey, messageTarget.ReceiverPublicKeyObj); err != nil {
 		return errors.New(fmt.Sprintf("Unable to construct encrypted message, error %v for message %s", err, pay))
 		// Marshal it into a byte array
 	} else if msgBody, err := json.Marshal(encryptedMsg); err != nil {
 		return errors.New(fmt.Sprintf("Unable to marshal exchange message, error %v for message %v", err, encryptedMsg))
 		// Send it to the device's message queue
+	} else if w.proposalBatcher.Enabled() && isProposal(pay) {
+		return w.proposalBatcher.Send(messageTarget.ReceiverExchangeId, msgBody)
 	} else {
+		transport := exchange.AgbotMessageTransport(w.config)
 		pm := exchange.CreatePostMessage(msgBody, w.config.AgreementBot.ExchangeMessageTTL)
-		var resp interface{}
-		resp = new(exchange.PostDeviceResponse)
-		targetURL := w.config.AgreementBot.ExchangeURL + "orgs/" + exchange.GetOrg(messageTarget.ReceiverExchangeId) + "/nodes/" + exchange.GetId(messageTarget.ReceiverExchangeId) + "/msgs"
-		for {
-			if err, tpErr := exchange.InvokeExchange(w.httpClient, "POST", targetURL, w.agbotId, w.token, pm, &resp); err != nil {
-				return err
-			} else if tpErr != nil {
-				glog.Warningf(tpErr.Error())
-				time.Sleep(10 * time.Second)
-				continue
-			} else {
-				glog.V(5).Infof(BCPHlogstring(w.Name(), fmt.Sprintf("sent message for %v to exchange.", messageTarget.ReceiverExchangeId)))
-				return nil
-			}
+		if err := transport.Post(w.httpClient, transport.NodeMailboxURL(messageTarget.ReceiverExchangeId), w.agbotId, w.token, pm); err != nil {
+			return err
 		}
+		cutil.TraceV(5, "", messageTarget.ReceiverExchangeId).Infof(BCPHlogstring(w.Name(), fmt.Sprintf("sent message for %v to %v.", messageTarget.ReceiverExchangeId, transport.Name)))
+		return nil
+	}
+
+}
+
+// Proposals are the only messages worth batching. They are sent in large numbers when the agbot finds new nodes,
+// whereas the other protocol messages are sent in response to something the node did.
+func isProposal(pay []byte) bool {
+	msg := new(abstractprotocol.BaseProtocolMessage)
+	if err := json.Unmarshal(pay, msg); err != nil {
+		return false
+	}
+	return msg.Type() == abstractprotocol.MsgTypeProposal
+}
+
+// Set up the proposal batcher if the agbot is configured to batch proposals. This has to be called before
+// the agreement workers are started.
+func (b *BaseConsumerProtocolHandler) initProposalBatcher() {
+	if b.config.AgreementBot.ProposalBatchSize > 1 && exchange.AgbotMessageTransport(b.config).IsRelay() {
+		glog.Warningf(BCPHlogstring(b.Name(), fmt.Sprintf("not batching proposals, they are sent through the message relay %v", b.config.AgreementBot.MessageRelayURL)))
+	} else if b.config.AgreementBot.ProposalBatchSize > 1 {
+		b.proposalBatcher = NewProposalBatcher(b.Name(), b.config.AgreementBot.ProposalBatchSize, b.config.AgreementBot.ProposalBatchWaitMS, b.postMessageBatch)
+		glog.V(3).Infof(BCPHlogstring(b.Name(), fmt.Sprintf("batching proposals: %v", b.proposalBatcher)))
+	}
+}
+
+// Set up the limits on how fast new agreements are started, if the agbot has any. This has to be called before
+// the agreement workers are started.
+func (b *BaseConsumerProtocolHandler) initRateLimiter() {
+	if b.config.AgreementBot.NewAgreementsPerOrgPerMin > 0 || b.config.AgreementBot.NewAgreementsPerPatternPerMin > 0 {
+		b.rateLimiter = NewAgreementRateLimiter(b.config.AgreementBot.NewAgreementsPerOrgPerMin, b.config.AgreementBot.NewAgreementsPerPatternPerMin)
+		glog.V(3).Infof(BCPHlogstring(b.Name(), fmt.Sprintf("limiting new agreements: %v", b.rateLimiter)))
 	}
+}
+
+// Set up the webhooks that are told about agreement events, if the agbot has any. This has to be called before
+// the agreement workers are started.
+func (b *BaseConsumerProtocolHandler) initWebhooks() {
+	b.webhooks = NewWebhookNotifier(b.Name(), b.config.AgreementBot.Webhooks, b.config.Collaborators.HTTPClientFactory.NewHTTPClient(nil))
+}
+
+// Post a batch of encrypted messages to the exchange in a single call. The batch is signed with the agbot's
+// message key so that the exchange can verify the sender before it queues the messages for each node.
+func (b *BaseConsumerProtocolHandler) postMessageBatch(entries []exchange.BatchMessageEntry) error {
+
+	_, myPrivKey, _ := exchange.GetKeys(b.config.AgreementBot.MessageKeyPath)
 
+	pbm, err := exchange.CreatePostBatchMessage(entries, b.config.AgreementBot.ExchangeMessageTTL, myPrivKey)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Unable to construct message batch, error %v", err))
+	}
+
+	var resp interface{}
+	resp = new(exchange.PostBatchMessageResponse)
+	targetURL := b.config.AgreementBot.ExchangeURL + "orgs/" + exchange.GetOrg(b.agbotId) + "/agbots/" + exchange.GetId(b.agbotId) + "/nodemsgs"
+	for {
+		if err, tpErr := exchange.InvokeExchange(b.httpClient, "POST", targetURL, b.agbotId, b.token, pbm, &resp); err != nil {
+			return err
+		} else if tpErr != nil {
+			glog.Warningf(tpErr.Error())
+			time.Sleep(10 * time.Second)
+			continue
+		} else {
+			glog.V(5).Infof(BCPHlogstring(b.Name(), fmt.Sprintf("sent batch of %v messages to exchange.", len(entries))))
+			return nil
+		}
+	}
 }
 
 func (b *BaseConsumerProtocolHandler) DispatchProtocolMessage(cmd *NewProtocolMessageCommand, cph ConsumerProtocolHandler) error {
@@ -235,6 +313,12 @@ func (b *BaseConsumerProtocolHandler) HandlePolicyChanged(cmd *PolicyChangedComm
 
 	glog.V(5).Infof(BCPHlogstring(b.Name(), "received policy changed command."))
 
+	// The agreements are checked against the changed policy when maintenance mode ends.
+	if InMaintenanceMode(b.db) {
+		glog.V(3).Infof(BCPHlogstring(b.Name(), "in maintenance mode, not cancelling agreements for the changed policy."))
+		return
+	}
+
 	if eventPol, err := policy.DemarshalPolicy(cmd.Msg.PolicyString()); err != nil {
 		glog.Errorf(BCPHlogstring(b.Name(), fmt.Sprintf("error demarshalling change policy event %v, error: %v", cmd.Msg.PolicyString(), err)))
 	} else {
@@ -251,7 +335,7 @@ func (b *BaseConsumerProtocolHandler) HandlePolicyChanged(cmd *PolicyChangedComm
 
 				} else if eventPol.Header.Name != pol.Header.Name {
 					// This agreement is using a policy different from the one that changed.
-					glog.V(5).Infof(BCPHlogstring(b.Name(), fmt.Sprintf("policy change handler skipping agreement %v because it is using a policy that did not change.", ag.CurrentAgreementId)))
+					cutil.TraceV(5, ag.CurrentAgreementId, ag.DeviceId).Infof(BCPHlogstring(b.Name(), fmt.Sprintf("policy change handler skipping agreement %v because it is using a policy that did not change.", ag.CurrentAgreementId)))
 					continue
 				} else if err := b.pm.MatchesMine(cmd.Msg.Org(), pol); err != nil {
 					glog.Warningf(BCPHlogstring(b.Name(), fmt.Sprintf("agreement %v has a policy %v that has changed: %v", ag.CurrentAgreementId, pol.Header.Name, err)))
@@ -296,7 +380,7 @@ func (b *BaseConsumerProtocolHandler) HandlePolicyChanged(cmd *PolicyChangedComm
 						cph.WorkQueue() <- agreementWork
 					}
 				} else {
-					glog.V(5).Infof(BCPHlogstring(b.Name(), fmt.Sprintf("for agreement %v, no policy content differences detected", ag.CurrentAgreementId)))
+					cutil.TraceV(5, ag.CurrentAgreementId, ag.DeviceId).Infof(BCPHlogstring(b.Name(), fmt.Sprintf("for agreement %v, no policy content differences detected", ag.CurrentAgreementId)))
 				}
 
 			}
@@ -309,6 +393,12 @@ func (b *BaseConsumerProtocolHandler) HandlePolicyChanged(cmd *PolicyChangedComm
 func (b *BaseConsumerProtocolHandler) HandlePolicyDeleted(cmd *PolicyDeletedCommand, cph ConsumerProtocolHandler) {
 	glog.V(5).Infof(BCPHlogstring(b.Name(), "received policy deleted command."))
 
+	// The agreements are checked against the deleted policy when maintenance mode ends.
+	if InMaintenanceMode(b.db) {
+		glog.V(3).Infof(BCPHlogstring(b.Name(), "in maintenance mode, not cancelling agreements for the deleted policy."))
+		return
+	}
+
 	InProgress := func() AFilter {
 		return func(e Agreement) bool { return e.AgreementCreationTime != 0 && e.AgreementTimedout == 0 }
 	}
@@ -359,6 +449,13 @@ func (b *BaseConsumerProtocolHandler) HandleWorkloadUpgrade(cmd *WorkloadUpgrade
 
 func (b *BaseConsumerProtocolHandler) HandleMakeAgreement(cmd *MakeAgreementCommand, cph ConsumerProtocolHandler) {
 	glog.V(5).Infof(BCPHlogstring(b.Name(), fmt.Sprintf("received make agreement command.")))
+
+	// The node will be found again by a later search, when the org and pattern are under their limits.
+	if !b.rateLimiter.Allow(cmd.Org, cmd.ConsumerPolicy.PatternId) {
+		glog.V(3).Infof(BCPHlogstring(b.Name(), fmt.Sprintf("not starting agreement with %v, org %v or pattern %v has reached its new agreement rate limit.", cmd.Device.Id, cmd.Org, cmd.ConsumerPolicy.PatternId)))
+		return
+	}
+
 	agreementWork := InitiateAgreement{
 		workType:       INITIATE,
 		ProducerPolicy: cmd.ProducerPolicy,
@@ -390,8 +487,11 @@ func (b *BaseConsumerProtocolHandler) PersistBaseAgreement(wi *InitiateAgreement
 
 func (b *BaseConsumerProtocolHandler) PersistReply(reply abstractprotocol.ProposalReply, pol *policy.Policy, workerID string) error {
 
-	if _, err := AgreementMade(b.db, reply.AgreementId(), reply.DeviceId(), "", b.Name(), pol.HAGroup.Partners, "", "", ""); err != nil {
+	if ag, err := AgreementMade(b.db, reply.AgreementId(), reply.DeviceId(), "", b.Name(), pol.HAGroup.Partners, "", "", ""); err != nil {
 		return errors.New(BCPHlogstring2(workerID, fmt.Sprintf("error updating agreement %v with reply info in DB, error: %v", reply.AgreementId(), err)))
+	} else {
+		b.webhooks.Notify(NewWebhookEvent(WEBHOOK_AGREEMENT_REACHED, ag, 0, ""))
+		noteNegotiation(NegotiationAccepted(b.db, ag.DeviceId, ag.CurrentAgreementId), ag.DeviceId, ag.CurrentAgreementId, NEGOTIATION_ACCEPTED)
 	}
 	return nil
 
@@ -401,7 +501,7 @@ func (b *BaseConsumerProtocolHandler) RecordConsumerAgreementState(agreementId s
 
 	workload := pol.Workloads[0].WorkloadURL
 
-	glog.V(5).Infof(BCPHlogstring2(workerID, fmt.Sprintf("setting agreement %v for workload %v/%v state to %v", agreementId, org, workload, state)))
+	cutil.TraceV(5, agreementId, "").Infof(BCPHlogstring2(workerID, fmt.Sprintf("setting agreement %v for workload %v/%v state to %v", agreementId, org, workload, state)))
 
 	as := new(exchange.PutAgbotAgreementState)
 
@@ -430,16 +530,21 @@ func (b *BaseConsumerProtocolHandler) RecordConsumerAgreementState(agreementId s
 			time.Sleep(10 * time.Second)
 			continue
 		} else {
-			glog.V(5).Infof(BCPHlogstring2(workerID, fmt.Sprintf("set agreement %v to state %v", agreementId, state)))
+			cutil.TraceV(5, agreementId, "").Infof(BCPHlogstring2(workerID, fmt.Sprintf("set agreement %v to state %v", agreementId, state)))
 			return nil
 		}
 	}
 
 }
 
+// Protocols without reliable messaging have no unacknowledged messages to send again.
+func (b *BaseConsumerProtocolHandler) ResendMessages() {
+	return
+}
+
 func (b *BaseConsumerProtocolHandler) DeleteMessage(msgId int) error {
 
-	return DeleteMessage(msgId, b.agbotId, b.token, b.config.AgreementBot.ExchangeURL, b.httpClient)
+	return DeleteMessage(msgId, b.agbotId, b.token, exchange.AgbotMessageTransport(b.config), b.httpClient)
 
 }
 
@@ -450,7 +555,7 @@ func (b *BaseConsumerProtocolHandler) TerminateAgreement(ag *Agreement, reason u
 		bcType, bcName, bcOrg := cph.GetKnownBlockchain(ag)
 		if aph := cph.AgreementProtocolHandler(bcType, bcName, bcOrg); aph == nil {
 			glog.Warningf(BCPHlogstring2(workerId, fmt.Sprintf("for %v agreement protocol handler not ready", ag.CurrentAgreementId)))
-		} else if err := aph.TerminateAgreement([]policy.Policy{*pol}, ag.CounterPartyAddress, ag.CurrentAgreementId, ag.Org, reason, mt, b.GetSendMessage()); err != nil {
+		} else if err := aph.TerminateAgreement([]policy.Policy{*pol}, ag.CounterPartyAddress, ag.CurrentAgreementId, ag.Org, reason, mt, cph.GetSendMessage()); err != nil {
 			glog.Errorf(BCPHlogstring2(workerId, fmt.Sprintf("error terminating agreement %v on the blockchain: %v", ag.CurrentAgreementId, err)))
 		}
 	}
@@ -458,12 +563,12 @@ func (b *BaseConsumerProtocolHandler) TerminateAgreement(ag *Agreement, reason u
 
 func (b *BaseConsumerProtocolHandler) GetDeviceMessageEndpoint(deviceId string, workerId string) (string, []byte, error) {
 
-	glog.V(5).Infof(BCPHlogstring2(workerId, fmt.Sprintf("retrieving device %v msg endpoint from exchange", deviceId)))
+	cutil.TraceV(5, "", deviceId).Infof(BCPHlogstring2(workerId, fmt.Sprintf("retrieving device %v msg endpoint from exchange", deviceId)))
 
 	if dev, err := b.getDevice(deviceId, workerId); err != nil {
 		return "", nil, err
 	} else {
-		glog.V(5).Infof(BCPHlogstring2(workerId, fmt.Sprintf("retrieved device %v msg endpoint from exchange %v", deviceId, dev.MsgEndPoint)))
+		cutil.TraceV(5, "", deviceId).Infof(BCPHlogstring2(workerId, fmt.Sprintf("retrieved device %v msg endpoint from exchange %v", deviceId, dev.MsgEndPoint)))
 		return dev.MsgEndPoint, dev.PublicKey, nil
 	}
 
@@ -471,7 +576,7 @@ func (b *BaseConsumerProtocolHandler) GetDeviceMessageEndpoint(deviceId string,
 
 func (b *BaseConsumerProtocolHandler) getDevice(deviceId string, workerId string) (*exchange.Device, error) {
 
-	glog.V(5).Infof(BCPHlogstring2(workerId, fmt.Sprintf("retrieving device %v from exchange", deviceId)))
+	cutil.TraceV(5, "", deviceId).Infof(BCPHlogstring2(workerId, fmt.Sprintf("retrieving device %v from exchange", deviceId)))
 
 	var resp interface{}
 	resp = new(exchange.GetDevicesResponse)
@@ -489,7 +594,7 @@ func (b *BaseConsumerProtocolHandler) getDevice(deviceId string, workerId string
 			if dev, there := devs[deviceId]; !there {
 				return nil, errors.New(fmt.Sprintf("device %v not in GET response %v as expected", deviceId, devs))
 			} else {
-				glog.V(5).Infof(BCPHlogstring2(workerId, fmt.Sprintf("retrieved device %v from exchange %v", deviceId, dev)))
+				cutil.TraceV(5, "", deviceId).Infof(BCPHlogstring2(workerId, fmt.Sprintf("retrieved device %v from exchange %v", deviceId, dev)))
 				return &dev, nil
 			}
 		}
@@ -497,15 +602,58 @@ func (b *BaseConsumerProtocolHandler) getDevice(deviceId string, workerId string
 }
 
 func (b *BaseConsumerProtocolHandler) DeferCommand(cmd AgreementWork) {
+	b.deferredLock.Lock()
+	defer b.deferredLock.Unlock()
 	b.deferredCommands = append(b.deferredCommands, cmd)
 }
 
 func (b *BaseConsumerProtocolHandler) GetDeferredCommands() []AgreementWork {
+	b.deferredLock.Lock()
+	defer b.deferredLock.Unlock()
 	res := b.deferredCommands
 	b.deferredCommands = make([]AgreementWork, 0, 10)
 	return res
 }
 
+// Save the deferred commands in the database so that they are not lost when the agbot shuts down. They are
+// picked up again when the protocol handler is initialized.
+func (b *BaseConsumerProtocolHandler) PersistDeferredCommands() error {
+	cmds := b.GetDeferredCommands()
+	if err := SaveDeferredWork(b.db, b.Name(), cmds); err != nil {
+		return errors.New(BCPHlogstring(b.Name(), fmt.Sprintf("unable to persist %v deferred commands, error: %v", len(cmds), err)))
+	}
+	glog.V(3).Infof(BCPHlogstring(b.Name(), fmt.Sprintf("persisted %v deferred commands", len(cmds))))
+	return nil
+}
+
+// Reload the deferred commands saved by a previous shutdown. This has to be called before the agreement workers
+// are started.
+func (b *BaseConsumerProtocolHandler) restoreDeferredCommands() {
+	if cmds, err := RemoveDeferredWork(b.db, b.Name()); err != nil {
+		glog.Errorf(BCPHlogstring(b.Name(), fmt.Sprintf("unable to restore deferred commands, error: %v", err)))
+	} else {
+		for _, cmd := range cmds {
+			b.DeferCommand(cmd)
+		}
+		if len(cmds) != 0 {
+			glog.V(3).Infof(BCPHlogstring(b.Name(), fmt.Sprintf("restored %v deferred commands", len(cmds))))
+		}
+	}
+}
+
+// The agreement workers bracket each work item with these calls so that a shutdown can wait for in-flight work.
+func (b *BaseConsumerProtocolHandler) workStarted() {
+	atomic.AddInt32(&b.activeWork, 1)
+}
+
+func (b *BaseConsumerProtocolHandler) workDone() {
+	atomic.AddInt32(&b.activeWork, -1)
+}
+
+func (b *BaseConsumerProtocolHandler) ActiveWork() int {
+	return int(atomic.LoadInt32(&b.activeWork))
+}
+
 func (b *BaseConsumerProtocolHandler) UpdateProducer(ag *Agreement) {
 	return
 }
@@ -539,6 +687,7 @@ func (c *BaseConsumerProtocolHandler) CanSendMeterRecord(ag *Agreement) bool {
 
 // The list of termination reasons that should be supported by all agreement protocols. The caller can pass these into
 // the GetTerminationCode API to get a protocol specific reason code for that termination reason.
+// They are the names of the consumer reasons in the reason registry of each protocol, see abstractprotocol.ReasonRegistry.
 const TERM_REASON_POLICY_CHANGED = "PolicyChanged"
 const TERM_REASON_NOT_FINALIZED_TIMEOUT = "NotFinalized"
 const TERM_REASON_NO_DATA_RECEIVED = "NoData"
@@ -551,11 +700,45 @@ const TERM_REASON_CANCEL_FORCED_UPGRADE = "ForceUpgrade"
 const TERM_REASON_CANCEL_BC_WRITE_FAILED = "WriteFailed"
 const TERM_REASON_NODE_HEARTBEAT = "NodeHeartbeat"
 const TERM_REASON_AG_MISSING = "AgreementMissing"
+const TERM_REASON_NODE_AGREEMENT_LIMIT = "NodeAgreementLimit"
+const TERM_REASON_OUTSIDE_TIME_WINDOW = "OutsideTimeWindow"
+const TERM_REASON_NODE_MISSING_DEVICES = "NodeMissingDevices"
+const TERM_REASON_NODE_WORKLOAD_ROLLBACK = "NodeWorkloadRollback"
+
+// The termination reasons that a user can choose when cancelling an agreement through the API. Every agreement
+// protocol has a reason code for each of these.
+var userTerminationReasons = []string{
+	TERM_REASON_POLICY_CHANGED,
+	TERM_REASON_NO_DATA_RECEIVED,
+	TERM_REASON_NO_REPLY,
+	TERM_REASON_USER_REQUESTED,
+	TERM_REASON_NEGATIVE_REPLY,
+	TERM_REASON_CANCEL_DISCOVERED,
+	TERM_REASON_CANCEL_FORCED_UPGRADE,
+	TERM_REASON_NODE_HEARTBEAT,
+	TERM_REASON_AG_MISSING,
+	TERM_REASON_NODE_AGREEMENT_LIMIT,
+}
+
+func IsUserTerminationReason(reason string) bool {
+	for _, r := range userTerminationReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
 
 var BCPHlogstring = func(p string, v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("Base Consumer Protocol Handler", cutil.LogFields{cutil.LOG_PROTOCOL: p}, v)
+	}
 	return fmt.Sprintf("Base Consumer Protocol Handler (%v) %v", p, v)
 }
 
 var BCPHlogstring2 = func(workerID string, v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("Base Consumer Protocol Handler", cutil.LogFields{cutil.LOG_WORKER_ID: workerID}, v)
+	}
 	return fmt.Sprintf("Base Consumer Protocol Handler (%v): %v", workerID, v)
 }
@@ -6,6 +6,7 @@ import (
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/citizenscientist"
 	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/exchange"
 	"github.com/open-horizon/anax/policy"
 	"github.com/open-horizon/anax/worker"
@@ -128,6 +129,7 @@ func (a *CSAgreementWorker) start(work chan AgreementWork, random *rand.Rand) {
 		glog.V(5).Infof(logstring(a.workerID, fmt.Sprintf("blocking for work")))
 		workItem := <-work // block waiting for work
 		glog.V(2).Infof(logstring(a.workerID, fmt.Sprintf("received work: %v", workItem)))
+		a.protocolHandler.workStarted()
 
 		if workItem.Type() == INITIATE {
 			wi := workItem.(InitiateAgreement)
@@ -156,7 +158,7 @@ func (a *CSAgreementWorker) start(work chan AgreementWork, random *rand.Rand) {
 			if ag, err := FindSingleAgreementByAgreementId(a.protocolHandler.db, wi.AgreementId, a.protocolHandler.Name(), []AFilter{}); err != nil {
 				glog.Errorf(logstring(a.workerID, fmt.Sprintf("error querying agreement %v from database, error: %v", wi.AgreementId, err)))
 			} else if ag == nil {
-				glog.V(3).Infof(logstring(a.workerID, fmt.Sprintf("nothing to do for agreement %v, no database record.", wi.AgreementId)))
+				cutil.TraceV(3, wi.AgreementId, "").Infof(logstring(a.workerID, fmt.Sprintf("nothing to do for agreement %v, no database record.", wi.AgreementId)))
 			} else if ag.Archived || ag.AgreementTimedout != 0 {
 				// The agreement could be cancelled BEFORE it is written to the blockchain. If we find a BC recorded event for an archived
 				// or timed out agreement then we know this occurred. Cancel the agreement again so that the device will see the cancel.
@@ -164,8 +166,11 @@ func (a *CSAgreementWorker) start(work chan AgreementWork, random *rand.Rand) {
 				go a.DoAsyncCancel(a.protocolHandler, ag, ag.TerminatedReason, a.workerID)
 			} else {
 				// Update state in the database
-				if _, err := AgreementFinalized(a.protocolHandler.db, wi.AgreementId, a.protocolHandler.Name()); err != nil {
+				if finalizedAg, err := AgreementFinalized(a.protocolHandler.db, wi.AgreementId, a.protocolHandler.Name()); err != nil {
 					glog.Errorf(logstring(a.workerID, fmt.Sprintf("error persisting agreement %v finalized: %v", wi.AgreementId, err)))
+				} else {
+					a.protocolHandler.webhooks.Notify(NewWebhookEvent(WEBHOOK_AGREEMENT_FINALIZED, finalizedAg, 0, ""))
+					noteNegotiation(NegotiationFinalized(a.protocolHandler.db, finalizedAg.DeviceId, finalizedAg.CurrentAgreementId), finalizedAg.DeviceId, finalizedAg.CurrentAgreementId, NEGOTIATION_FINALIZED)
 				}
 
 				// Update state in exchange
@@ -213,6 +218,7 @@ func (a *CSAgreementWorker) start(work chan AgreementWork, random *rand.Rand) {
 			glog.Errorf(logstring(a.workerID, fmt.Sprintf("received unknown work request: %v", workItem)))
 		}
 
+		a.protocolHandler.workDone()
 		glog.V(5).Infof(logstring(a.workerID, fmt.Sprintf("handled work: %v", workItem)))
 		runtime.Gosched()
 	}
@@ -227,9 +233,9 @@ func (a *CSAgreementWorker) ExternalWrite(cph ConsumerProtocolHandler, agreement
 	if ag, err := FindSingleAgreementByAgreementId(a.db, agreementId, cph.Name(), []AFilter{UnarchivedAFilter()}); err != nil {
 		glog.Errorf(logstring(workerID, fmt.Sprintf("error querying agreement %v, error: %v", agreementId, err)))
 	} else if ag == nil {
-		glog.V(3).Infof(logstring(workerID, fmt.Sprintf("agreement %v no longer active, cancelling deferred write.", agreementId)))
+		cutil.TraceV(3, agreementId, "").Infof(logstring(workerID, fmt.Sprintf("agreement %v no longer active, cancelling deferred write.", agreementId)))
 	} else if ag.AgreementTimedout != 0 {
-		glog.V(3).Infof(logstring(workerID, fmt.Sprintf("agreement %v terminating, cancelling deferred write.", agreementId)))
+		cutil.TraceV(3, agreementId, "").Infof(logstring(workerID, fmt.Sprintf("agreement %v terminating, cancelling deferred write.", agreementId)))
 	} else if cph.IsBlockchainWritable(ag.BlockchainType, ag.BlockchainName, ag.BlockchainOrg) && ag.CounterPartyAddress != "" {
 
 		// Recording the agreement on the blockchain could take a long time.
@@ -238,7 +244,7 @@ func (a *CSAgreementWorker) ExternalWrite(cph ConsumerProtocolHandler, agreement
 
 	} else {
 		// create deferred write command
-		glog.V(5).Infof(logstring(workerID, fmt.Sprintf("agreement %v deferring blockchain write.", agreementId)))
+		cutil.TraceV(5, agreementId, "").Infof(logstring(workerID, fmt.Sprintf("agreement %v deferring blockchain write.", agreementId)))
 		cph.DeferCommand(AsyncWriteAgreement{
 			workType:    ASYNC_WRITE,
 			AgreementId: ag.CurrentAgreementId,
@@ -254,9 +260,11 @@ func (a *CSAgreementWorker) DoAsyncWrite(cph ConsumerProtocolHandler, ag *Agreem
 		glog.Errorf(logstring(workerID, fmt.Sprintf("error demarshalling tsandcs policy from pending agreement %v, error: %v", ag.CurrentAgreementId, err)))
 	} else if err := cph.AgreementProtocolHandler(ag.BlockchainType, ag.BlockchainName, ag.BlockchainOrg).RecordAgreement(proposal, nil, ag.CounterPartyAddress, ag.ProposalSig, pol, ag.Org); err != nil {
 		glog.Errorf(logstring(workerID, fmt.Sprintf("error trying to record agreement in blockchain, %v", err)))
+		noteBlockchainFailure(a.db, ag.BlockchainOrg, ag.BlockchainType, ag.BlockchainName, err, a.config.AgreementBot.BCBlacklistFailureLimit)
 		a.CancelAgreementWithLock(cph, ag.CurrentAgreementId, cph.GetTerminationCode(TERM_REASON_CANCEL_BC_WRITE_FAILED), workerID)
 	} else {
-		glog.V(3).Infof(logstring(workerID, fmt.Sprintf("recorded agreement %v", ag.CurrentAgreementId)))
+		cutil.TraceV(3, ag.CurrentAgreementId, ag.DeviceId).Infof(logstring(workerID, fmt.Sprintf("recorded agreement %v", ag.CurrentAgreementId)))
+		noteBlockchainSuccess(a.db, ag.BlockchainOrg, ag.BlockchainType, ag.BlockchainName)
 	}
 }
 
@@ -271,11 +279,11 @@ func (a *CSAgreementWorker) SendBCUpdate(ph ConsumerProtocolHandler, agreementId
 	} else if ag, err := FindSingleAgreementByAgreementId(a.db, agreementId, cph.Name(), []AFilter{UnarchivedAFilter()}); err != nil {
 		glog.Errorf(logstring(workerID, fmt.Sprintf("error querying agreement %v, error: %v", agreementId, err)))
 	} else if ag == nil {
-		glog.V(3).Infof(logstring(workerID, fmt.Sprintf("agreement %v no longer active, cancelling deferred update.", agreementId)))
+		cutil.TraceV(3, agreementId, "").Infof(logstring(workerID, fmt.Sprintf("agreement %v no longer active, cancelling deferred update.", agreementId)))
 	} else if ag.AgreementTimedout != 0 {
-		glog.V(3).Infof(logstring(workerID, fmt.Sprintf("agreement %v terminating, cancelling deferred update.", agreementId)))
+		cutil.TraceV(3, agreementId, "").Infof(logstring(workerID, fmt.Sprintf("agreement %v terminating, cancelling deferred update.", agreementId)))
 	} else if ag.BCUpdateAckTime != 0 {
-		glog.V(3).Infof(logstring(workerID, fmt.Sprintf("agreement %v received update ack, cancelling deferred update.", agreementId)))
+		cutil.TraceV(3, agreementId, "").Infof(logstring(workerID, fmt.Sprintf("agreement %v received update ack, cancelling deferred update.", agreementId)))
 	} else if cph.IsBlockchainReady(ag.BlockchainType, ag.BlockchainName, ag.BlockchainOrg) && ag.BCUpdateAckTime == 0 {
 		cph.UpdateProducer(ag)
 		// create deferred update command as a mechanism to retry the update if messaging fails to deliver the message.
@@ -286,7 +294,7 @@ func (a *CSAgreementWorker) SendBCUpdate(ph ConsumerProtocolHandler, agreementId
 		})
 	} else {
 		// create deferred update command to wait until blockchain comes up
-		glog.V(5).Infof(logstring(workerID, fmt.Sprintf("agreement %v deferring blockchain update.", agreementId)))
+		cutil.TraceV(5, agreementId, "").Infof(logstring(workerID, fmt.Sprintf("agreement %v deferring blockchain update.", agreementId)))
 		cph.DeferCommand(AsyncUpdateAgreement{
 			workType:    ASYNC_UPDATE,
 			AgreementId: ag.CurrentAgreementId,
@@ -311,9 +319,9 @@ func (a *CSAgreementWorker) HandleProducerUpdate(cph *CSProtocolHandler, wi *CSP
 	if ag, err := FindSingleAgreementByAgreementId(a.db, wi.Update.AgreementId(), cph.Name(), []AFilter{UnarchivedAFilter()}); err != nil {
 		glog.Errorf(logstring(workerID, fmt.Sprintf("error querying agreement %v, error: %v", wi.Update.AgreementId(), err)))
 	} else if ag == nil {
-		glog.V(3).Infof(logstring(workerID, fmt.Sprintf("agreement %v no longer active.", wi.Update.AgreementId())))
+		cutil.TraceV(3, wi.Update.AgreementId(), "").Infof(logstring(workerID, fmt.Sprintf("agreement %v no longer active.", wi.Update.AgreementId())))
 	} else if ag.AgreementTimedout != 0 {
-		glog.V(3).Infof(logstring(workerID, fmt.Sprintf("agreement %v terminating.", wi.Update.AgreementId())))
+		cutil.TraceV(3, wi.Update.AgreementId(), "").Infof(logstring(workerID, fmt.Sprintf("agreement %v terminating.", wi.Update.AgreementId())))
 	} else if _, err := AgreementBlockchainUpdate(a.db, wi.Update.AgreementId(), "", "", wi.Update.Address, wi.Update.Signature, cph.Name()); err != nil {
 		glog.Errorf(logstring(workerID, fmt.Sprintf("error hardening producer sig and address for agreement %v, error: %v", wi.Update.AgreementId(), err)))
 	} else if mt, err := exchange.CreateMessageTarget(wi.SenderId, nil, wi.SenderPubKey, ""); err != nil {
@@ -345,9 +353,9 @@ func (a *CSAgreementWorker) HandleConsumerUpdateAck(cph *CSProtocolHandler, wi *
 	if ag, err := FindSingleAgreementByAgreementId(a.db, wi.Update.AgreementId(), cph.Name(), []AFilter{UnarchivedAFilter()}); err != nil {
 		glog.Errorf(logstring(workerID, fmt.Sprintf("error querying agreement %v, error: %v", wi.Update.AgreementId(), err)))
 	} else if ag == nil {
-		glog.V(3).Infof(logstring(workerID, fmt.Sprintf("agreement %v no longer active.", wi.Update.AgreementId())))
+		cutil.TraceV(3, wi.Update.AgreementId(), "").Infof(logstring(workerID, fmt.Sprintf("agreement %v no longer active.", wi.Update.AgreementId())))
 	} else if ag.AgreementTimedout != 0 {
-		glog.V(3).Infof(logstring(workerID, fmt.Sprintf("agreement %v terminating.", wi.Update.AgreementId())))
+		cutil.TraceV(3, wi.Update.AgreementId(), "").Infof(logstring(workerID, fmt.Sprintf("agreement %v terminating.", wi.Update.AgreementId())))
 	} else if _, err := AgreementBlockchainUpdateAck(a.db, wi.Update.AgreementId(), cph.Name()); err != nil {
 		glog.Errorf(logstring(workerID, fmt.Sprintf("error hardening consumer update ack for agreement %v, error: %v", wi.Update.AgreementId(), err)))
 	}
@@ -362,5 +370,8 @@ func (a *CSAgreementWorker) HandleConsumerUpdateAck(cph *CSProtocolHandler, wi *
 }
 
 var logstring = func(workerID string, v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("CSAgreementWorker", cutil.LogFields{cutil.LOG_WORKER_ID: workerID}, v)
+	}
 	return fmt.Sprintf("CSAgreementWorker (%v): %v", workerID, v)
 }
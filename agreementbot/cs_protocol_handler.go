@@ -8,13 +8,14 @@ import (
 	"github.com/open-horizon/anax/abstractprotocol"
 	"github.com/open-horizon/anax/citizenscientist"
 	"github.com/open-horizon/anax/config"
-	"github.com/open-horizon/anax/ethblockchain"
+	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/exchange"
 	"github.com/open-horizon/anax/metering"
 	"github.com/open-horizon/anax/policy"
 	"github.com/open-horizon/anax/worker"
 	"math/rand"
+	"sort"
 	"sync"
 	"time"
 )
@@ -26,6 +27,7 @@ type BlockchainState struct {
 	servicePort string                            // the port of the network endpoint for the container
 	colonusDir  string                            // the anax side filesystem location for this BC instance
 	agreementPH *citizenscientist.ProtocolHandler // CS Protocolhandler for this blockchain client
+	failures    int                               // the number of consecutive failed health checks of the client's RPC endpoint
 }
 
 type CSProtocolHandler struct {
@@ -77,6 +79,18 @@ func (c *CSProtocolHandler) Initialize() {
 	// Setup a lock to protect concurrent agreement processing
 	agreementLockMgr := NewAgreementLockManager()
 
+	// Set up proposal batching before any worker can send a proposal.
+	c.initProposalBatcher()
+
+	// New agreements might be limited per org and pattern.
+	c.initRateLimiter()
+
+	// Agreement events might be sent to webhooks.
+	c.initWebhooks()
+
+	// Pick up any work that was deferred when the agbot last shut down.
+	c.restoreDeferredCommands()
+
 	// Set up agreement worker pool based on the current technical config.
 	for ix := 0; ix < c.config.AgreementBot.AgreementWorkers; ix++ {
 		agw := NewCSAgreementWorker(c, c.config, c.db, c.pm, agreementLockMgr)
@@ -116,7 +130,7 @@ func (c *CSProtocolHandler) AcceptCommand(cmd worker.Command) bool {
 		return true
 	case *BlockchainEventCommand:
 		bcc := cmd.(*BlockchainEventCommand)
-		if c.IsBlockchainReady(policy.Ethereum_bc, bcc.Msg.Name(), bcc.Msg.Org()) {
+		if c.IsBlockchainReady(bcc.Msg.BlockchainType(), bcc.Msg.Name(), bcc.Msg.Org()) {
 			return true
 		} else {
 			return false
@@ -164,8 +178,11 @@ func (c *CSProtocolHandler) PersistReply(r abstractprotocol.ProposalReply, pol *
 
 	if reply, ok := r.(*citizenscientist.CSProposalReply); !ok {
 		return errors.New(CPHlogStringW(workerID, fmt.Sprintf("unable to cast reply %v to %v Proposal Reply, is %T", r, c.Name(), r)))
-	} else if _, err := AgreementMade(c.db, reply.AgreementId(), reply.Address, reply.Signature, c.Name(), pol.HAGroup.Partners, reply.BlockchainType, reply.BlockchainName, reply.BlockchainOrg); err != nil {
+	} else if ag, err := AgreementMade(c.db, reply.AgreementId(), reply.Address, reply.Signature, c.Name(), pol.HAGroup.Partners, reply.BlockchainType, reply.BlockchainName, reply.BlockchainOrg); err != nil {
 		return errors.New(CPHlogStringW(workerID, fmt.Sprintf("error updating agreement %v with reply info DB, error: %v", reply.AgreementId(), err)))
+	} else {
+		c.webhooks.Notify(NewWebhookEvent(WEBHOOK_AGREEMENT_REACHED, ag, 0, ""))
+		noteNegotiation(NegotiationAccepted(c.db, ag.DeviceId, ag.CurrentAgreementId), ag.DeviceId, ag.CurrentAgreementId, NEGOTIATION_ACCEPTED)
 	}
 	return nil
 }
@@ -176,7 +193,7 @@ func (c *CSProtocolHandler) HandleBlockchainEvent(cmd *BlockchainEventCommand) {
 	// Unmarshal the raw event
 	if csaph, ok := c.AgreementProtocolHandler("", "", "").(*citizenscientist.ProtocolHandler); !ok {
 		glog.Errorf(CPHlogString(fmt.Sprintf("unable to cast agreement protocol handler %T to CS specific handler to process BC event %v", c.AgreementProtocolHandler("", "", ""), cmd.Msg.RawEvent())))
-	} else if rawEvent, err := csaph.DemarshalEvent(cmd.Msg.RawEvent()); err != nil {
+	} else if rawEvent, err := csaph.DemarshalEvent(cmd.Msg.BlockchainType(), cmd.Msg.RawEvent()); err != nil {
 		glog.Errorf(CPHlogString(fmt.Sprintf("unable to demarshal raw event %v, error: %v", cmd.Msg.RawEvent(), err)))
 	} else if !csaph.AgreementCreated(rawEvent) && !csaph.ProducerTermination(rawEvent) && !csaph.ConsumerTermination(rawEvent) {
 		glog.V(5).Infof(CPHlogString(fmt.Sprintf("ignoring the blockchain event because it is not agreement creation or termination event.")))
@@ -209,8 +226,15 @@ func (c *CSProtocolHandler) HandleBlockchainEvent(cmd *BlockchainEventCommand) {
 func (c *CSProtocolHandler) CreateMeteringNotification(mp policy.Meter, ag *Agreement) (*metering.MeteringNotification, error) {
 
 	// This function ASSUMEs that the BC client is already initialized
-	myAddress, _ := ethblockchain.AccountId(c.getColonusDir(ag))
-	return metering.NewMeteringNotification(mp, ag.AgreementCreationTime, uint64(ag.DataVerificationCheckRate), ag.DataVerificationMissedCount, ag.CurrentAgreementId, ag.ProposalHash, ag.ConsumerProposalSig, myAddress, ag.ProposalSig, "ethereum")
+	bcType, bcName, bcOrg := c.GetKnownBlockchain(ag)
+	myAddress := ""
+	if csph, ok := c.AgreementProtocolHandler(bcType, bcName, bcOrg).(*citizenscientist.ProtocolHandler); ok {
+		myAddress = csph.MyAddress
+	}
+	if bcType == "" {
+		bcType = policy.RequiresBlockchainType(c.Name())
+	}
+	return metering.NewMeteringNotification(mp, ag.AgreementCreationTime, uint64(ag.DataVerificationCheckRate), ag.DataVerificationMissedCount, ag.CurrentAgreementId, ag.ProposalHash, ag.ConsumerProposalSig, myAddress, ag.ProposalSig, bcType)
 }
 
 func (c *CSProtocolHandler) TerminateAgreement(ag *Agreement, reason uint, workerId string) {
@@ -228,32 +252,7 @@ func (c *CSProtocolHandler) TerminateAgreement(ag *Agreement, reason uint, worke
 }
 
 func (c *CSProtocolHandler) GetTerminationCode(reason string) uint {
-	switch reason {
-	case TERM_REASON_POLICY_CHANGED:
-		return citizenscientist.AB_CANCEL_POLICY_CHANGED
-	case TERM_REASON_NOT_FINALIZED_TIMEOUT:
-		return citizenscientist.AB_CANCEL_NOT_FINALIZED_TIMEOUT
-	case TERM_REASON_NO_DATA_RECEIVED:
-		return citizenscientist.AB_CANCEL_NO_DATA_RECEIVED
-	case TERM_REASON_NO_REPLY:
-		return citizenscientist.AB_CANCEL_NO_REPLY
-	case TERM_REASON_USER_REQUESTED:
-		return citizenscientist.AB_USER_REQUESTED
-	case TERM_REASON_NEGATIVE_REPLY:
-		return citizenscientist.AB_CANCEL_NEGATIVE_REPLY
-	case TERM_REASON_CANCEL_DISCOVERED:
-		return citizenscientist.AB_CANCEL_DISCOVERED
-	case TERM_REASON_CANCEL_FORCED_UPGRADE:
-		return citizenscientist.AB_CANCEL_FORCED_UPGRADE
-	case TERM_REASON_CANCEL_BC_WRITE_FAILED:
-		return citizenscientist.AB_CANCEL_BC_WRITE_FAILED
-	case TERM_REASON_NODE_HEARTBEAT:
-		return citizenscientist.AB_CANCEL_NODE_HEARTBEAT
-	case TERM_REASON_AG_MISSING:
-		return citizenscientist.AB_CANCEL_AG_MISSING
-	default:
-		return 999
-	}
+	return citizenscientist.Reasons.Code(abstractprotocol.REASON_CONSUMER, reason)
 }
 
 func (c *CSProtocolHandler) GetTerminationReason(code uint) string {
@@ -295,11 +294,15 @@ func (c *CSProtocolHandler) SetBlockchainWritable(ev *events.AccountFundedMessag
 		nameMap[ev.BlockchainInstance()].servicePort = ev.ServicePort()
 		nameMap[ev.BlockchainInstance()].colonusDir = ev.ColonusDir()
 		nameMap[ev.BlockchainInstance()].agreementPH = citizenscientist.NewProtocolHandler(c.httpClient, c.pm)
+		nameMap[ev.BlockchainInstance()].failures = 0
 	}
 
 	glog.V(3).Infof(CPHlogString(fmt.Sprintf("initializing agreement protocol handler for %v", ev)))
 	if err := nameMap[ev.BlockchainInstance()].agreementPH.InitBlockchain(ev); err != nil {
 		glog.Errorf(CPHlogString(fmt.Sprintf("failed initializing CS agreement protocol blockchain handler for %v, error: %v", ev, err)))
+		noteBlockchainFailure(c.db, ev.BlockchainOrg(), ev.BlockchainType(), ev.BlockchainInstance(), err, c.config.AgreementBot.BCBlacklistFailureLimit)
+	} else {
+		noteBlockchainSuccess(c.db, ev.BlockchainOrg(), ev.BlockchainType(), ev.BlockchainInstance())
 	}
 
 	glog.V(3).Infof(CPHlogString(fmt.Sprintf("agreement protocol handler can write to the blockchain now: %v", *nameMap[ev.BlockchainInstance()])))
@@ -414,26 +417,6 @@ func (c *CSProtocolHandler) CanCancelNow(ag *Agreement) bool {
 
 }
 
-func (c *CSProtocolHandler) getColonusDir(ag *Agreement) string {
-	if ag == nil {
-		return ""
-	}
-
-	bcType, bcName, bcOrg := c.GetKnownBlockchain(ag)
-
-	c.bcStateLock.Lock()
-	defer c.bcStateLock.Unlock()
-
-	nameMap := c.getBCNameMap(bcOrg, bcType)
-	namedBC, ok := nameMap[bcName]
-	if !ok || (ok && !namedBC.ready) {
-		return ""
-	}
-
-	return nameMap[bcName].colonusDir
-
-}
-
 func (c *CSProtocolHandler) getBCNameMap(org string, typeName string) map[string]*BlockchainState {
 	orgMap, ok := c.bcState[org]
 	if !ok {
@@ -449,6 +432,59 @@ func (c *CSProtocolHandler) getBCNameMap(org string, typeName string) map[string
 	return nameMap
 }
 
+// The state of a blockchain client as reported by the agbot API.
+type BlockchainReadiness struct {
+	Org      string `json:"org"`
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Ready    bool   `json:"ready"`
+	Writable bool   `json:"writable"`
+	Service  string `json:"service,omitempty"`  // the network endpoint of the client container
+	Failures int    `json:"failures,omitempty"` // the number of consecutive failed health checks of the client
+}
+
+// Returns a copy of the state of the blockchain clients this protocol handler knows about, sorted by org, type and name.
+func (c *CSProtocolHandler) BlockchainStates() []BlockchainReadiness {
+
+	c.bcStateLock.Lock()
+	defer c.bcStateLock.Unlock()
+
+	states := make([]BlockchainReadiness, 0)
+	for org, typeMap := range c.bcState {
+		for typeName, nameMap := range typeMap {
+			for name, bc := range nameMap {
+				state := BlockchainReadiness{Org: org, Type: typeName, Name: name, Ready: bc.ready, Writable: bc.writable, Failures: bc.failures}
+				if bc.service != "" {
+					state.Service = fmt.Sprintf("%v:%v", bc.service, bc.servicePort)
+				}
+				states = append(states, state)
+			}
+		}
+	}
+
+	sort.Sort(BlockchainReadinessByName(states))
+	return states
+}
+
+type BlockchainReadinessByName []BlockchainReadiness
+
+func (s BlockchainReadinessByName) Len() int {
+	return len(s)
+}
+
+func (s BlockchainReadinessByName) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s BlockchainReadinessByName) Less(i, j int) bool {
+	if s[i].Org != s[j].Org {
+		return s[i].Org < s[j].Org
+	} else if s[i].Type != s[j].Type {
+		return s[i].Type < s[j].Type
+	}
+	return s[i].Name < s[j].Name
+}
+
 func (c *CSProtocolHandler) HandleDeferredCommands() {
 	cmds := c.BaseConsumerProtocolHandler.GetDeferredCommands()
 	for _, aw := range cmds {
@@ -490,6 +526,8 @@ func (c *CSProtocolHandler) PostReply(agreementId string, proposal abstractproto
 				Protocol:    c.Name(),
 			})
 
+		} else if IsBlockchainBlacklisted(c.db, agreement.BlockchainOrg, agreement.BlockchainType, agreement.BlockchainName) {
+			glog.Warningf(CPHlogStringW(workerId, fmt.Sprintf("agreement %v needs blacklisted blockchain %v/%v/%v, not starting a client for it", agreementId, agreement.BlockchainOrg, agreement.BlockchainType, agreement.BlockchainName)))
 		} else {
 			c.messages <- events.NewNewBCContainerMessage(events.NEW_BC_CLIENT, agreement.BlockchainType, agreement.BlockchainName, agreement.BlockchainOrg, c.config.AgreementBot.ExchangeURL, c.agbotId, c.token)
 		}
@@ -551,9 +589,15 @@ func (c *CSProtocolHandler) CanSendMeterRecord(ag *Agreement) bool {
 // Utility functions
 
 var CPHlogString = func(v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("AgreementBot CS Protocol Handler", nil, v)
+	}
 	return fmt.Sprintf("AgreementBot CS Protocol Handler %v", v)
 }
 
 var CPHlogStringW = func(workerId string, v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("AgreementBot CS Protocol Handler", cutil.LogFields{cutil.LOG_WORKER_ID: workerId}, v)
+	}
 	return fmt.Sprintf("AgreementBot CS Protocol Handler (%v) %v", workerId, v)
 }
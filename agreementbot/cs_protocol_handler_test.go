@@ -49,7 +49,7 @@ func createEmptyPH() *CSProtocolHandler {
 }
 
 func createAgreement(proposal string, pol string, agpVersion int, bcType string, bcName string, bcOrg string) (*Agreement, error) {
-	if ag, err := agreement("testagid", "testorg", "deviceid", "testpolicy", bcType, bcName, bcOrg, "Citizen Scientist", "apattern", policy.NodeHealth{}); err != nil {
+	if ag, err := agreement("testagid", "testorg", "deviceid", "testpolicy", bcType, bcName, bcOrg, "Citizen Scientist", "apattern", policy.NodeHealth{}, nil); err != nil {
 		return nil, err
 	} else {
 		prop := new(citizenscientist.CSProposal)
@@ -67,3 +67,48 @@ func createAgreement(proposal string, pol string, agpVersion int, bcType string,
 		}
 	}
 }
+
+func Test_BlockchainStates(t *testing.T) {
+
+	ph := createEmptyPH()
+	ph.bcState = make(map[string]map[string]map[string]*BlockchainState)
+	ph.getBCNameMap("org2", policy.Ethereum_bc)["bc1"] = &BlockchainState{ready: true}
+	ph.getBCNameMap("org1", policy.Ethereum_bc)["bc2"] = &BlockchainState{ready: true, writable: true, service: "geth", servicePort: "8545"}
+	ph.getBCNameMap("org1", policy.Ethereum_bc)["bc1"] = &BlockchainState{}
+
+	states := ph.BlockchainStates()
+	expected := []BlockchainReadiness{
+		{Org: "org1", Type: policy.Ethereum_bc, Name: "bc1"},
+		{Org: "org1", Type: policy.Ethereum_bc, Name: "bc2", Ready: true, Writable: true, Service: "geth:8545"},
+		{Org: "org2", Type: policy.Ethereum_bc, Name: "bc1", Ready: true},
+	}
+	if len(states) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, states)
+	}
+	for i := range expected {
+		if states[i] != expected[i] {
+			t.Errorf("state %v should be %v, is %v", i, expected[i], states[i])
+		}
+	}
+}
+
+func Test_IsUserTerminationReason(t *testing.T) {
+
+	// Every reason a user can choose must have a reason code in each protocol.
+	bph := &BasicProtocolHandler{}
+	for _, reason := range userTerminationReasons {
+		if !IsUserTerminationReason(reason) {
+			t.Errorf("%v should be a user termination reason", reason)
+		} else if code := bph.GetTerminationCode(reason); code == 999 {
+			t.Errorf("%v has no basic protocol reason code", reason)
+		} else if code := createEmptyPH().GetTerminationCode(reason); code == 999 {
+			t.Errorf("%v has no citizen scientist reason code", reason)
+		}
+	}
+
+	for _, reason := range []string{"", "bogus", TERM_REASON_CANCEL_BC_WRITE_FAILED} {
+		if IsUserTerminationReason(reason) {
+			t.Errorf("%v should not be a user termination reason", reason)
+		}
+	}
+}
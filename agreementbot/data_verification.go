@@ -0,0 +1,155 @@
+package agreementbot
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/policy"
+	"net/http"
+	"sync"
+)
+
+// A DataVerifier finds out whether the node in an agreement is sending data to the consumer's data ingest system. The
+// data verification section of the agreement's policy chooses the verifier with its type. A verifier is used for one
+// governance pass, so that it can read what it needs once for all the agreements it verifies in the pass.
+type DataVerifier interface {
+	// Returns true when data is being received for the agreement.
+	DataReceived(ag *Agreement) (bool, error)
+}
+
+// Polls the agreement's data verification URL, or the agbot's ActiveAgreementsURL, for the list of agreements that
+// are sending data. This is how data has always been verified.
+type httpDataVerifier struct {
+	config  *config.HorizonConfig
+	devices map[string][]string // the agreements that are sending data, by verification URL
+}
+
+func (v *httpDataVerifier) DataReceived(ag *Agreement) (bool, error) {
+	if activeAgreements, err := GetActiveAgreements(v.devices, *ag, v.config); err != nil {
+		return false, err
+	} else {
+		return ActiveAgreementsContains(activeAgreements, *ag, v.config.AgreementBot.DVPrefix), nil
+	}
+}
+
+// Reads the time that the data ingest system last received data for the agreement from the agbot's agreement in the
+// exchange. The data ingest system sets it with the exchange's agbot dataheartbeat resource. Data is being received
+// when it was last received after the agbot last verified the data of the agreement.
+type exchangeDataVerifier struct {
+	httpClient *http.Client
+	url        string
+	id         string
+	token      string
+	agreements map[string]exchange.AgbotAgreement // read from the exchange the first time an agreement is verified
+}
+
+func (v *exchangeDataVerifier) DataReceived(ag *Agreement) (bool, error) {
+	if v.agreements == nil {
+		var resp interface{}
+		resp = new(exchange.AllAgbotAgreementsResponse)
+		targetURL := v.url + "orgs/" + exchange.GetOrg(v.id) + "/agbots/" + exchange.GetId(v.id) + "/agreements"
+		if err, tpErr := exchange.InvokeExchange(v.httpClient, "GET", targetURL, v.id, v.token, nil, &resp); err != nil {
+			return false, err
+		} else if tpErr != nil {
+			return false, tpErr
+		} else if v.agreements = resp.(*exchange.AllAgbotAgreementsResponse).Agreements; v.agreements == nil {
+			v.agreements = make(map[string]exchange.AgbotAgreement)
+		}
+		glog.V(5).Infof(logString(fmt.Sprintf("read %v agreements from the exchange for data verification", len(v.agreements))))
+	}
+
+	if exAg, ok := v.agreements[ag.CurrentAgreementId]; !ok || exAg.DataLastReceived == "" {
+		return false, nil
+	} else {
+		return uint64(cutil.TimeInSeconds(exAg.DataLastReceived)) > ag.DataVerifiedTime, nil
+	}
+}
+
+// The data ingest system tells the agbot API when it receives data for an agreement. Data is being received when the
+// last push came after the agbot last verified the data of the agreement.
+type webhookDataVerifier struct {
+	pushes *DataPushes
+}
+
+func (v *webhookDataVerifier) DataReceived(ag *Agreement) (bool, error) {
+	return v.pushes.LastPush(ag.CurrentAgreementId) > ag.DataVerifiedTime, nil
+}
+
+// The verifiers for one governance pass. When a verifier fails, the agreements that use it are not verified again
+// until the next pass, like the whole data verification loop used to stop when the verification URL failed.
+type DataVerifiers struct {
+	verifiers map[string]DataVerifier
+	failed    map[string]bool
+}
+
+func (w *AgreementBotWorker) newDataVerifiers() *DataVerifiers {
+	return &DataVerifiers{
+		verifiers: map[string]DataVerifier{
+			policy.DATA_VERIFICATION_HTTP:     &httpDataVerifier{config: w.BaseWorker.Manager.Config, devices: make(map[string][]string)},
+			policy.DATA_VERIFICATION_EXCHANGE: &exchangeDataVerifier{httpClient: w.httpClient, url: w.GetExchangeURL(), id: w.GetExchangeId(), token: w.GetExchangeToken()},
+			policy.DATA_VERIFICATION_WEBHOOK:  &webhookDataVerifier{pushes: w.dataPushes},
+		},
+		failed: make(map[string]bool),
+	}
+}
+
+// The type of data verification of the agreement. Agreements made before there were types use http.
+func dataVerificationType(ag *Agreement) string {
+	return policy.DataVerification{Type: ag.DataVerificationType}.VerificationType()
+}
+
+// Returns true when the verifier of the agreement failed earlier in this pass.
+func (dvs *DataVerifiers) Failed(ag *Agreement) bool {
+	return dvs.failed[dataVerificationType(ag)]
+}
+
+func (dvs *DataVerifiers) DataReceived(ag *Agreement) (bool, error) {
+	dvType := dataVerificationType(ag)
+	if v, ok := dvs.verifiers[dvType]; !ok {
+		return false, errors.New(fmt.Sprintf("data verification type %v is not supported", dvType))
+	} else if received, err := v.DataReceived(ag); err != nil {
+		dvs.failed[dvType] = true
+		return false, errors.New(fmt.Sprintf("unable to verify data with %v data verification, error: %v", dvType, err))
+	} else {
+		return received, nil
+	}
+}
+
+// The times that the data ingest systems last told the agbot API that they received data for the agreements that use
+// webhook data verification. They are only kept in memory, after a restart the agbot waits for the next push.
+type DataPushes struct {
+	pushes map[string]uint64 // the time of the last push, by agreement id
+	lock   sync.Mutex
+}
+
+func NewDataPushes() *DataPushes {
+	return &DataPushes{
+		pushes: make(map[string]uint64),
+	}
+}
+
+func (dp *DataPushes) Record(agreementId string, now uint64) {
+	dp.lock.Lock()
+	defer dp.lock.Unlock()
+	dp.pushes[agreementId] = now
+}
+
+func (dp *DataPushes) LastPush(agreementId string) uint64 {
+	dp.lock.Lock()
+	defer dp.lock.Unlock()
+	return dp.pushes[agreementId]
+}
+
+// Forget the pushes of the agreements that are no longer being governed.
+func (dp *DataPushes) Retain(agreementIds map[string]bool) {
+	dp.lock.Lock()
+	defer dp.lock.Unlock()
+	for id := range dp.pushes {
+		if !agreementIds[id] {
+			delete(dp.pushes, id)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"errors"
+	"fmt"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/policy"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type failingDataVerifier struct {
+	calls int
+}
+
+func (v *failingDataVerifier) DataReceived(ag *Agreement) (bool, error) {
+	v.calls += 1
+	return false, errors.New("ingest system is down")
+}
+
+func Test_DataVerifiers(t *testing.T) {
+
+	verifiedTime := uint64(time.Now().Unix()) - 60
+	lastReceived := time.Unix(int64(verifiedTime)+30, 0).UTC().Format(cutil.ExchangeTimeFormat)
+
+	reads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/orgs/myorg/agbots/agbot1/agreements" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		reads += 1
+		fmt.Fprintf(w, `{"agreements":{"ag1":{"state":"Finalized Agreement","lastUpdated":"","dataLastReceived":"%v"},"ag2":{"state":"Finalized Agreement","lastUpdated":""}}}`, lastReceived)
+	}))
+	defer server.Close()
+
+	pushes := NewDataPushes()
+	failing := &failingDataVerifier{}
+	dvs := &DataVerifiers{
+		verifiers: map[string]DataVerifier{
+			policy.DATA_VERIFICATION_EXCHANGE: &exchangeDataVerifier{httpClient: &http.Client{}, url: server.URL + "/", id: "myorg/agbot1", token: "token"},
+			policy.DATA_VERIFICATION_WEBHOOK:  &webhookDataVerifier{pushes: pushes},
+			policy.DATA_VERIFICATION_HTTP:     failing,
+		},
+		failed: make(map[string]bool),
+	}
+
+	// The exchange verifier reads the agreements once, and only the agreements with data received after the last
+	// verification are verified.
+	exAgs := []Agreement{
+		{CurrentAgreementId: "ag1", DataVerificationType: policy.DATA_VERIFICATION_EXCHANGE, DataVerifiedTime: verifiedTime},
+		{CurrentAgreementId: "ag2", DataVerificationType: policy.DATA_VERIFICATION_EXCHANGE, DataVerifiedTime: verifiedTime},
+		{CurrentAgreementId: "ag3", DataVerificationType: policy.DATA_VERIFICATION_EXCHANGE, DataVerifiedTime: verifiedTime},
+		{CurrentAgreementId: "ag1", DataVerificationType: policy.DATA_VERIFICATION_EXCHANGE, DataVerifiedTime: verifiedTime + 40},
+	}
+	for i, expected := range []bool{true, false, false, false} {
+		if received, err := dvs.DataReceived(&exAgs[i]); err != nil {
+			t.Errorf("unexpected error verifying %v: %v", exAgs[i].CurrentAgreementId, err)
+		} else if received != expected {
+			t.Errorf("data received for %v should be %v with verified time %v", exAgs[i].CurrentAgreementId, expected, exAgs[i].DataVerifiedTime)
+		}
+	}
+	if reads != 1 {
+		t.Errorf("the agreements should be read from the exchange once per pass, were read %v times", reads)
+	}
+
+	// The webhook verifier needs a push after the last verification.
+	webAg := Agreement{CurrentAgreementId: "ag4", DataVerificationType: policy.DATA_VERIFICATION_WEBHOOK, DataVerifiedTime: verifiedTime}
+	if received, err := dvs.DataReceived(&webAg); err != nil || received {
+		t.Errorf("data should not be received for %v without a push, received %v, error %v", webAg.CurrentAgreementId, received, err)
+	}
+	pushes.Record("ag4", verifiedTime+10)
+	if received, err := dvs.DataReceived(&webAg); err != nil || !received {
+		t.Errorf("data should be received for %v after a push, received %v, error %v", webAg.CurrentAgreementId, received, err)
+	}
+	pushes.Retain(map[string]bool{"ag5": true})
+	if pushes.LastPush("ag4") != 0 {
+		t.Errorf("the pushes of agreements that are not governed should be forgotten")
+	}
+
+	// Agreements without a type use http. When a verifier fails, its agreements are skipped for the rest of the pass,
+	// and the other verifiers keep working.
+	httpAg := Agreement{CurrentAgreementId: "ag6"}
+	if dvs.Failed(&httpAg) {
+		t.Errorf("http data verification should not have failed yet")
+	} else if _, err := dvs.DataReceived(&httpAg); err == nil {
+		t.Errorf("failing verifier should return an error")
+	} else if !dvs.Failed(&httpAg) || failing.calls != 1 {
+		t.Errorf("http data verification should have failed once, failed %v times", failing.calls)
+	} else if dvs.Failed(&webAg) || dvs.Failed(&exAgs[0]) {
+		t.Errorf("only http data verification should have failed")
+	}
+}
@@ -0,0 +1,117 @@
+package agreementbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+)
+
+const DEFERRED_WORK = "deferred_work"
+
+// The deferred agreement work of a protocol handler is held in memory and retried periodically. When the agbot
+// shuts down, the deferred work is saved in the database so that it can be picked up again after a restart.
+type PersistedAgreementWork struct {
+	WorkType    string `json:"work_type"`
+	AgreementId string `json:"agreement_id"`
+	Protocol    string `json:"protocol"`
+	Reason      uint   `json:"reason"`
+}
+
+func (p PersistedAgreementWork) String() string {
+	return fmt.Sprintf("WorkType: %v, AgreementId: %v, Protocol: %v, Reason: %v", p.WorkType, p.AgreementId, p.Protocol, p.Reason)
+}
+
+// Convert a unit of deferred work into its persistent form. Only the async work types can be deferred, anything
+// else is rejected.
+func NewPersistedAgreementWork(aw AgreementWork) (*PersistedAgreementWork, error) {
+	switch aw.(type) {
+	case AsyncCancelAgreement:
+		w := aw.(AsyncCancelAgreement)
+		return &PersistedAgreementWork{WorkType: w.Type(), AgreementId: w.AgreementId, Protocol: w.Protocol, Reason: w.Reason}, nil
+	case AsyncWriteAgreement:
+		w := aw.(AsyncWriteAgreement)
+		return &PersistedAgreementWork{WorkType: w.Type(), AgreementId: w.AgreementId, Protocol: w.Protocol}, nil
+	case AsyncUpdateAgreement:
+		w := aw.(AsyncUpdateAgreement)
+		return &PersistedAgreementWork{WorkType: w.Type(), AgreementId: w.AgreementId, Protocol: w.Protocol}, nil
+	default:
+		return nil, fmt.Errorf("agreement work %T cannot be persisted", aw)
+	}
+}
+
+// Convert the persistent form back into agreement work that can be queued to an agreement worker.
+func (p PersistedAgreementWork) AgreementWork() (AgreementWork, error) {
+	switch p.WorkType {
+	case ASYNC_CANCEL:
+		return AsyncCancelAgreement{workType: ASYNC_CANCEL, AgreementId: p.AgreementId, Protocol: p.Protocol, Reason: p.Reason}, nil
+	case ASYNC_WRITE:
+		return AsyncWriteAgreement{workType: ASYNC_WRITE, AgreementId: p.AgreementId, Protocol: p.Protocol}, nil
+	case ASYNC_UPDATE:
+		return AsyncUpdateAgreement{workType: ASYNC_UPDATE, AgreementId: p.AgreementId, Protocol: p.Protocol}, nil
+	default:
+		return nil, fmt.Errorf("unknown persisted agreement work type %v", p.WorkType)
+	}
+}
+
+// Save the deferred work for a protocol, replacing whatever was saved for that protocol before.
+func SaveDeferredWork(db *bolt.DB, protocol string, work []AgreementWork) error {
+
+	records := make([]PersistedAgreementWork, 0, len(work))
+	for _, aw := range work {
+		if p, err := NewPersistedAgreementWork(aw); err != nil {
+			glog.Errorf(fmt.Sprintf("AgreementBot dropping deferred work %v, error: %v", aw, err))
+		} else {
+			records = append(records, *p)
+		}
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		if b, err := tx.CreateBucketIfNotExists([]byte(DEFERRED_WORK)); err != nil {
+			return err
+		} else if len(records) == 0 {
+			return b.Delete([]byte(protocol))
+		} else if serial, err := json.Marshal(records); err != nil {
+			return fmt.Errorf("Unable to serialize deferred work for %v: %v", protocol, err)
+		} else {
+			return b.Put([]byte(protocol), serial)
+		}
+	})
+}
+
+// Retrieve and remove the deferred work saved for a protocol.
+func RemoveDeferredWork(db *bolt.DB, protocol string) ([]AgreementWork, error) {
+
+	work := make([]AgreementWork, 0, 10)
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DEFERRED_WORK))
+		if b == nil {
+			return nil
+		}
+
+		serial := b.Get([]byte(protocol))
+		if serial == nil {
+			return nil
+		}
+
+		var records []PersistedAgreementWork
+		if err := json.Unmarshal(serial, &records); err != nil {
+			glog.Errorf(fmt.Sprintf("AgreementBot unable to demarshal deferred work for %v: %v, error: %v", protocol, string(serial), err))
+		} else {
+			for _, p := range records {
+				if aw, err := p.AgreementWork(); err != nil {
+					glog.Errorf(fmt.Sprintf("AgreementBot dropping persisted deferred work %v, error: %v", p, err))
+				} else {
+					work = append(work, aw)
+				}
+			}
+		}
+		return b.Delete([]byte(protocol))
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return work, nil
+}
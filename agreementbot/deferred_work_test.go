@@ -0,0 +1,56 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"github.com/boltdb/bolt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_DeferredWork_save_and_remove(t *testing.T) {
+
+	dbFile, err := ioutil.TempFile("", "agreementbot_deferred_test.db")
+	if err != nil {
+		t.Fatalf("unable to create temp db file: %v", err)
+	}
+	defer os.Remove(dbFile.Name())
+
+	db, err := bolt.Open(dbFile.Name(), 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("unable to open db: %v", err)
+	}
+	defer db.Close()
+
+	work := []AgreementWork{
+		AsyncCancelAgreement{workType: ASYNC_CANCEL, AgreementId: "a1", Protocol: "Citizen Scientist", Reason: 201},
+		AsyncWriteAgreement{workType: ASYNC_WRITE, AgreementId: "a2", Protocol: "Citizen Scientist"},
+		AsyncUpdateAgreement{workType: ASYNC_UPDATE, AgreementId: "a3", Protocol: "Citizen Scientist"},
+		CancelAgreement{workType: CANCEL, AgreementId: "a4"},
+	}
+
+	if err := SaveDeferredWork(db, "Citizen Scientist", work); err != nil {
+		t.Errorf("error saving deferred work: %v", err)
+	} else if restored, err := RemoveDeferredWork(db, "Citizen Scientist"); err != nil {
+		t.Errorf("error restoring deferred work: %v", err)
+	} else if len(restored) != 3 {
+		t.Errorf("expected 3 restored work items, got %v", restored)
+	} else if restored[0] != work[0] || restored[1] != work[1] || restored[2] != work[2] {
+		t.Errorf("restored work %v does not match saved work %v", restored, work)
+	}
+
+	if restored, err := RemoveDeferredWork(db, "Citizen Scientist"); err != nil {
+		t.Errorf("error restoring deferred work: %v", err)
+	} else if len(restored) != 0 {
+		t.Errorf("deferred work should be removed once restored, got %v", restored)
+	}
+
+	if restored, err := RemoveDeferredWork(db, "Basic"); err != nil {
+		t.Errorf("error restoring deferred work: %v", err)
+	} else if len(restored) != 0 {
+		t.Errorf("expected no deferred work, got %v", restored)
+	}
+
+}
@@ -0,0 +1,335 @@
+package agreementbot
+
+import (
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/anaxerrors"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/policy"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// The agbot self-diagnostic runs a set of checks against the agbot's own environment and reports each problem it
+// finds along with what the operator can do about it. None of the checks change any state, so the diagnostic can be
+// run at any time, including while the agbot is making agreements.
+
+const (
+	DIAG_OK      = "ok"
+	DIAG_WARNING = "warning"
+	DIAG_ERROR   = "error"
+)
+
+const (
+	DIAG_CHECK_EXCHANGE   = "exchange"
+	DIAG_CHECK_PATTERNS   = "served patterns"
+	DIAG_CHECK_POLICYPATH = "policy directory"
+	DIAG_CHECK_DATABASE   = "database"
+	DIAG_CHECK_BLOCKCHAIN = "blockchain"
+	DIAG_CHECK_MAILBOX    = "mailbox"
+)
+
+// The number of messages waiting in the agbot's mailbox above which the agbot is considered to be falling behind.
+const DIAG_MAILBOX_BACKLOG = 100
+
+// How long to wait when connecting to a blockchain client.
+const DIAG_CONNECT_TIMEOUT_S = 5
+
+type DiagnosticFinding struct {
+	Check   string `json:"check"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Action  string `json:"action,omitempty"` // what the operator should do about the finding
+}
+
+func (f DiagnosticFinding) String() string {
+	return fmt.Sprintf("Check: %v, Status: %v, Message: %v, Action: %v", f.Check, f.Status, f.Message, f.Action)
+}
+
+func NewDiagnosticFinding(check string, status string, message string, action string) DiagnosticFinding {
+	return DiagnosticFinding{
+		Check:   check,
+		Status:  status,
+		Message: message,
+		Action:  action,
+	}
+}
+
+// The network endpoint of a running blockchain client, keyed by blockchain type and then by instance name.
+type BlockchainEndpoints map[string]map[string]string
+
+// Everything the diagnostic needs to know about the agbot.
+type Diagnostics struct {
+	httpClient       *http.Client
+	exchangeURL      string
+	exchangeId       string
+	exchangeToken    string
+	policyPath       string
+	inMemoryPolicies bool
+	db               *bolt.DB
+	blockchains      BlockchainEndpoints
+	transport        *exchange.MessageTransport // where the agbot's mailbox is, the exchange unless a message relay is configured
+}
+
+func NewDiagnostics(httpClient *http.Client, exchangeURL string, exchangeId string, exchangeToken string, policyPath string, inMemoryPolicies bool, db *bolt.DB, blockchains BlockchainEndpoints) *Diagnostics {
+	return &Diagnostics{
+		httpClient:       httpClient,
+		exchangeURL:      exchangeURL,
+		exchangeId:       exchangeId,
+		exchangeToken:    exchangeToken,
+		policyPath:       policyPath,
+		inMemoryPolicies: inMemoryPolicies,
+		db:               db,
+		blockchains:      blockchains,
+		transport:        exchange.NewMessageTransport(exchangeURL, ""),
+	}
+}
+
+// Run all the checks. The checks that need the exchange are skipped when the exchange cannot be used.
+func (d *Diagnostics) Run() []DiagnosticFinding {
+
+	findings := make([]DiagnosticFinding, 0, 10)
+
+	exchangeFinding := d.checkExchange()
+	findings = append(findings, exchangeFinding)
+
+	requiredBCs := make(policy.BlockchainList, 0, 5)
+	if exchangeFinding.Status == DIAG_ERROR {
+		findings = append(findings, NewDiagnosticFinding(DIAG_CHECK_PATTERNS, DIAG_WARNING, "skipped, the exchange is not usable", ""))
+		findings = append(findings, NewDiagnosticFinding(DIAG_CHECK_MAILBOX, DIAG_WARNING, "skipped, the exchange is not usable", ""))
+	} else {
+		var patternFindings []DiagnosticFinding
+		patternFindings, requiredBCs = d.checkServedPatterns()
+		findings = append(findings, patternFindings...)
+		findings = append(findings, d.checkMailbox())
+	}
+
+	findings = append(findings, d.checkPolicyPath())
+	findings = append(findings, d.checkDatabase())
+	findings = append(findings, d.checkBlockchains(requiredBCs)...)
+
+	for _, f := range findings {
+		if f.Status != DIAG_OK {
+			glog.Warningf(DiaglogString(f.String()))
+		}
+	}
+	return findings
+}
+
+// Make a single call to the exchange. The diagnostic does not retry when the exchange cannot be reached, because
+// that is exactly the kind of problem it is supposed to report.
+func (d *Diagnostics) getExchange(targetURL string, resp interface{}) error {
+	if err, tpErr := exchange.InvokeExchange(d.httpClient, "GET", targetURL, d.exchangeId, d.exchangeToken, nil, &resp); err != nil {
+		return err
+	} else if tpErr != nil {
+		return tpErr
+	}
+	return nil
+}
+
+func (d *Diagnostics) agbotURL() string {
+	return d.exchangeURL + "orgs/" + exchange.GetOrg(d.exchangeId) + "/agbots/" + exchange.GetId(d.exchangeId)
+}
+
+// The exchange has to be reachable and has to accept the agbot's credentials.
+func (d *Diagnostics) checkExchange() DiagnosticFinding {
+
+	var resp interface{}
+	resp = new(exchange.GetAgbotsResponse)
+	if err := d.getExchange(d.agbotURL(), resp); err != nil {
+		if anaxerrors.Is(err, anaxerrors.EXCHANGE_AUTH) {
+			return NewDiagnosticFinding(DIAG_CHECK_EXCHANGE, DIAG_ERROR, fmt.Sprintf("exchange rejected the credentials of agbot %v", d.exchangeId), "Check the AgreementBot ExchangeId and ExchangeToken in the agbot configuration.")
+		} else if anaxerrors.HTTPStatus(err) != 0 {
+			return NewDiagnosticFinding(DIAG_CHECK_EXCHANGE, DIAG_ERROR, fmt.Sprintf("exchange returned an error: %v", err), "Check the exchange server logs.")
+		}
+		return NewDiagnosticFinding(DIAG_CHECK_EXCHANGE, DIAG_ERROR, fmt.Sprintf("exchange at %v is not reachable: %v", d.exchangeURL, err), "Check the network connection to the exchange and the AgreementBot ExchangeURL in the agbot configuration.")
+	}
+
+	agbot, ok := resp.(*exchange.GetAgbotsResponse).Agbots[d.exchangeId]
+	if !ok {
+		return NewDiagnosticFinding(DIAG_CHECK_EXCHANGE, DIAG_ERROR, fmt.Sprintf("agbot %v is not defined in the exchange", d.exchangeId), "Create the agbot in the exchange with 'hzn exchange agbot' or correct the AgreementBot ExchangeId.")
+	} else if len(agbot.PublicKey) == 0 {
+		return NewDiagnosticFinding(DIAG_CHECK_EXCHANGE, DIAG_WARNING, "agbot has not published its messaging key in the exchange, nodes cannot reply to proposals", "Check the agbot log for errors patching the agbot public key, or restart the agbot.")
+	}
+	return NewDiagnosticFinding(DIAG_CHECK_EXCHANGE, DIAG_OK, fmt.Sprintf("exchange at %v is reachable and accepts the agbot credentials", d.exchangeURL), "")
+}
+
+// Every pattern the agbot serves has to exist and has to be convertible into policies. Returns the blockchains
+// that the served patterns use.
+func (d *Diagnostics) checkServedPatterns() ([]DiagnosticFinding, policy.BlockchainList) {
+
+	findings := make([]DiagnosticFinding, 0, 5)
+	bcs := make(policy.BlockchainList, 0, 5)
+
+	var resp interface{}
+	resp = new(exchange.GetAgbotsPatternsResponse)
+	if err := d.getExchange(d.agbotURL()+"/patterns", resp); err != nil {
+		findings = append(findings, NewDiagnosticFinding(DIAG_CHECK_PATTERNS, DIAG_ERROR, fmt.Sprintf("unable to read the served patterns: %v", err), "Check the exchange server logs."))
+		return findings, bcs
+	}
+
+	served := resp.(*exchange.GetAgbotsPatternsResponse).Patterns
+	if len(served) == 0 {
+		findings = append(findings, NewDiagnosticFinding(DIAG_CHECK_PATTERNS, DIAG_WARNING, "agbot is not serving any patterns", "Add patterns with 'hzn exchange agbot addpattern' if this agbot should make pattern based agreements."))
+		return findings, bcs
+	}
+
+	for _, sp := range served {
+		patternId := fmt.Sprintf("%v/%v", sp.Org, sp.Pattern)
+
+		var patResp interface{}
+		patResp = new(exchange.GetPatternResponse)
+		if err := d.getExchange(d.exchangeURL+"orgs/"+sp.Org+"/patterns/"+sp.Pattern, patResp); err != nil {
+			findings = append(findings, NewDiagnosticFinding(DIAG_CHECK_PATTERNS, DIAG_ERROR, fmt.Sprintf("unable to read served pattern %v: %v", patternId, err), "Check the exchange server logs."))
+			continue
+		}
+
+		pat, ok := patResp.(*exchange.GetPatternResponse).Patterns[patternId]
+		if !ok {
+			findings = append(findings, NewDiagnosticFinding(DIAG_CHECK_PATTERNS, DIAG_ERROR, fmt.Sprintf("served pattern %v does not exist", patternId), "Publish the pattern, or stop serving it with 'hzn exchange agbot removepattern'."))
+			continue
+		}
+
+		policies, err := exchange.ConvertToPolicies(patternId, &pat)
+		if err != nil {
+			findings = append(findings, NewDiagnosticFinding(DIAG_CHECK_PATTERNS, DIAG_ERROR, fmt.Sprintf("served pattern %v is not valid: %v", patternId, err), "Correct the pattern and publish it again."))
+			continue
+		} else if len(policies) == 0 {
+			findings = append(findings, NewDiagnosticFinding(DIAG_CHECK_PATTERNS, DIAG_WARNING, fmt.Sprintf("served pattern %v has nothing that needs an agreement", patternId), ""))
+			continue
+		}
+
+		for _, pol := range policies {
+			for _, agp := range pol.AgreementProtocols {
+				for _, bc := range agp.Blockchains {
+					bcs = append(bcs, bc)
+				}
+			}
+		}
+		findings = append(findings, NewDiagnosticFinding(DIAG_CHECK_PATTERNS, DIAG_OK, fmt.Sprintf("served pattern %v is valid", patternId), ""))
+	}
+
+	return findings, bcs
+}
+
+// A growing backlog of messages means the agbot is not keeping up with the replies from nodes.
+func (d *Diagnostics) checkMailbox() DiagnosticFinding {
+
+	var resp interface{}
+	resp = new(exchange.GetAgbotMessageResponse)
+	if err := d.getExchange(d.transport.AgbotMailboxURL(d.exchangeId), resp); err != nil {
+		return NewDiagnosticFinding(DIAG_CHECK_MAILBOX, DIAG_ERROR, fmt.Sprintf("unable to read the agbot mailbox from the %v: %v", d.transport.Name, err), fmt.Sprintf("Check the %v server logs.", d.transport.Name))
+	}
+
+	msgs := resp.(*exchange.GetAgbotMessageResponse).Messages
+	if len(msgs) > DIAG_MAILBOX_BACKLOG {
+		return NewDiagnosticFinding(DIAG_CHECK_MAILBOX, DIAG_WARNING, fmt.Sprintf("agbot mailbox has %v messages waiting", len(msgs)), "The agbot is not keeping up with node replies, check the agbot log for message processing errors or increase AgreementBot AgreementWorkers.")
+	}
+	return NewDiagnosticFinding(DIAG_CHECK_MAILBOX, DIAG_OK, fmt.Sprintf("agbot mailbox has %v messages waiting", len(msgs)), "")
+}
+
+// The agbot writes the policies it generates from patterns into the policy directory, unless they are kept in memory.
+func (d *Diagnostics) checkPolicyPath() DiagnosticFinding {
+
+	status := DIAG_ERROR
+	if d.inMemoryPolicies {
+		status = DIAG_WARNING
+	}
+
+	if d.policyPath == "" {
+		return NewDiagnosticFinding(DIAG_CHECK_POLICYPATH, status, "no policy directory is configured", "Set AgreementBot PolicyPath in the agbot configuration.")
+	} else if info, err := os.Stat(d.policyPath); err != nil {
+		return NewDiagnosticFinding(DIAG_CHECK_POLICYPATH, status, fmt.Sprintf("policy directory %v cannot be read: %v", d.policyPath, err), "Create the policy directory or correct AgreementBot PolicyPath.")
+	} else if !info.IsDir() {
+		return NewDiagnosticFinding(DIAG_CHECK_POLICYPATH, status, fmt.Sprintf("policy directory %v is not a directory", d.policyPath), "Correct AgreementBot PolicyPath.")
+	} else if f, err := ioutil.TempFile(d.policyPath, ".diagnostic"); err != nil {
+		return NewDiagnosticFinding(DIAG_CHECK_POLICYPATH, status, fmt.Sprintf("policy directory %v is not writable: %v", d.policyPath, err), "Give the agbot write access to the policy directory, or set AgreementBot InMemoryPatternPolicies.")
+	} else {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	return NewDiagnosticFinding(DIAG_CHECK_POLICYPATH, DIAG_OK, fmt.Sprintf("policy directory %v is writable", d.policyPath), "")
+}
+
+// Walk the whole database looking for corrupted pages.
+func (d *Diagnostics) checkDatabase() DiagnosticFinding {
+
+	if d.db == nil {
+		return NewDiagnosticFinding(DIAG_CHECK_DATABASE, DIAG_ERROR, "database is not open", "Check AgreementBot DBPath in the agbot configuration.")
+	}
+
+	problems := make([]string, 0, 5)
+	err := d.db.View(func(tx *bolt.Tx) error {
+		for cerr := range tx.Check() {
+			problems = append(problems, cerr.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if len(problems) != 0 {
+		return NewDiagnosticFinding(DIAG_CHECK_DATABASE, DIAG_ERROR, fmt.Sprintf("database %v is corrupted: %v", d.db.Path(), strings.Join(problems, ", ")), "Stop the agbot and restore the database from a backup.")
+	}
+	return NewDiagnosticFinding(DIAG_CHECK_DATABASE, DIAG_OK, fmt.Sprintf("database %v is consistent", d.db.Path()), "")
+}
+
+// Every running blockchain client has to accept connections. A blockchain used by a served pattern that has no client
+// is only a warning because the agbot starts blockchain clients when it first needs them.
+func (d *Diagnostics) checkBlockchains(required policy.BlockchainList) []DiagnosticFinding {
+
+	findings := make([]DiagnosticFinding, 0, 5)
+
+	for bcType, instances := range d.blockchains {
+		for name, endpoint := range instances {
+			if err := checkEndpoint(endpoint); err != nil {
+				findings = append(findings, NewDiagnosticFinding(DIAG_CHECK_BLOCKCHAIN, DIAG_ERROR, fmt.Sprintf("%v blockchain %v client at %v is not reachable: %v", bcType, name, endpoint, err), "Check the blockchain client container and its log."))
+			} else {
+				findings = append(findings, NewDiagnosticFinding(DIAG_CHECK_BLOCKCHAIN, DIAG_OK, fmt.Sprintf("%v blockchain %v client at %v is reachable", bcType, name, endpoint), ""))
+			}
+		}
+	}
+
+	reported := make(map[string]bool)
+	for _, bc := range required {
+		bcType := bc.Type
+		if bcType == "" {
+			bcType = policy.Ethereum_bc
+		}
+		key := fmt.Sprintf("%v/%v", bcType, bc.Name)
+		if _, ok := d.blockchains[bcType][bc.Name]; ok || reported[key] {
+			continue
+		}
+		reported[key] = true
+		findings = append(findings, NewDiagnosticFinding(DIAG_CHECK_BLOCKCHAIN, DIAG_WARNING, fmt.Sprintf("%v blockchain %v is used by a served pattern but has no client running", bcType, bc.Name), "The client is started when a node needs it, check the agbot log if agreements using this blockchain are not being made."))
+	}
+
+	return findings
+}
+
+func checkEndpoint(endpoint string) error {
+	if endpoint == "" {
+		return errors.New("no endpoint")
+	}
+	conn, err := net.DialTimeout("tcp", endpoint, DIAG_CONNECT_TIMEOUT_S*time.Second)
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	return nil
+}
+
+var DiaglogString = func(v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("Agbot Diagnostic", nil, v)
+	}
+	return fmt.Sprintf("Agbot Diagnostic: %v", v)
+}
@@ -0,0 +1,139 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/open-horizon/anax/policy"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Diagnostics_exchange(t *testing.T) {
+
+	patterns := `{"patterns":{"myorg_p1_myorg":{"patternOrgid":"myorg","pattern":"p1"},"myorg_p2_myorg":{"patternOrgid":"myorg","pattern":"p2"}}}`
+	p1 := `{"patterns":{"myorg/p1":{"workloads":[{"workloadUrl":"http://wl1","workloadOrgid":"myorg","workloadArch":"amd64","workloadVersions":[{"version":"1.0.0"}]}],"agreementProtocols":[{"name":"Basic"}]}}}`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/orgs/myorg/agbots/ag1":
+			fmt.Fprint(w, `{"agbots":{"myorg/ag1":{"publicKey":"a2V5"}}}`)
+		case "/orgs/myorg/agbots/ag1/patterns":
+			fmt.Fprint(w, patterns)
+		case "/orgs/myorg/patterns/p1":
+			fmt.Fprint(w, p1)
+		case "/orgs/myorg/agbots/ag1/msgs":
+			fmt.Fprint(w, `{"messages":[]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	d := NewDiagnostics(&http.Client{}, server.URL+"/", "myorg/ag1", "token", "", false, nil, BlockchainEndpoints{})
+
+	if f := d.checkExchange(); f.Status != DIAG_OK {
+		t.Errorf("expected exchange ok, got %v", f)
+	}
+
+	// Pattern p2 does not exist.
+	if findings, _ := d.checkServedPatterns(); len(findings) != 2 {
+		t.Errorf("expected 2 findings, got %v", findings)
+	} else {
+		for _, f := range findings {
+			if strings.Contains(f.Message, "myorg/p1") && f.Status != DIAG_OK {
+				t.Errorf("expected p1 to be ok, got %v", f)
+			} else if strings.Contains(f.Message, "myorg/p2") && f.Status != DIAG_ERROR {
+				t.Errorf("expected p2 to be an error, got %v", f)
+			}
+		}
+	}
+
+	if f := d.checkMailbox(); f.Status != DIAG_OK {
+		t.Errorf("expected mailbox ok, got %v", f)
+	}
+
+	// An agbot that is not in the exchange.
+	d = NewDiagnostics(&http.Client{}, server.URL+"/", "myorg/ag2", "token", "", false, nil, BlockchainEndpoints{})
+	if f := d.checkExchange(); f.Status != DIAG_ERROR || !strings.Contains(f.Message, "not defined") {
+		t.Errorf("expected missing agbot error, got %v", f)
+	}
+
+	// An exchange that cannot be reached skips the exchange checks.
+	server.Close()
+	d = NewDiagnostics(&http.Client{Timeout: time.Second}, server.URL+"/", "myorg/ag1", "token", "", false, nil, BlockchainEndpoints{})
+	findings := d.Run()
+	if findings[0].Status != DIAG_ERROR || !strings.Contains(findings[0].Message, "not reachable") {
+		t.Errorf("expected unreachable exchange, got %v", findings[0])
+	} else if findings[1].Check != DIAG_CHECK_PATTERNS || !strings.Contains(findings[1].Message, "skipped") {
+		t.Errorf("expected served patterns check to be skipped, got %v", findings[1])
+	}
+
+}
+
+func Test_Diagnostics_local(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "agbot-diag")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := bolt.Open(path.Join(dir, "agbot.db"), 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("unable to open db: %v", err)
+	}
+	defer db.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	defer listener.Close()
+
+	bcs := BlockchainEndpoints{policy.Ethereum_bc: {"bc1": listener.Addr().String(), "bc2": ""}}
+	d := NewDiagnostics(&http.Client{}, "", "myorg/ag1", "token", dir, false, db, bcs)
+
+	if f := d.checkPolicyPath(); f.Status != DIAG_OK {
+		t.Errorf("expected policy directory ok, got %v", f)
+	}
+
+	if f := d.checkDatabase(); f.Status != DIAG_OK {
+		t.Errorf("expected database ok, got %v", f)
+	}
+
+	required := policy.BlockchainList{{Type: policy.Ethereum_bc, Name: "bc1"}, {Type: policy.Ethereum_bc, Name: "bc3"}, {Name: "bc3"}}
+	status := make(map[string]string)
+	for _, f := range d.checkBlockchains(required) {
+		for _, name := range []string{"bc1", "bc2", "bc3"} {
+			if strings.Contains(f.Message, name+" ") {
+				if _, ok := status[name]; ok {
+					t.Errorf("blockchain %v reported more than once", name)
+				}
+				status[name] = f.Status
+			}
+		}
+	}
+	if status["bc1"] != DIAG_OK || status["bc2"] != DIAG_ERROR || status["bc3"] != DIAG_WARNING {
+		t.Errorf("wrong blockchain findings %v", status)
+	}
+
+	// A policy directory that does not exist is only a warning when policies are kept in memory.
+	d = NewDiagnostics(&http.Client{}, "", "myorg/ag1", "token", path.Join(dir, "nodir"), false, db, bcs)
+	if f := d.checkPolicyPath(); f.Status != DIAG_ERROR {
+		t.Errorf("expected policy directory error, got %v", f)
+	}
+	d = NewDiagnostics(&http.Client{}, "", "myorg/ag1", "token", path.Join(dir, "nodir"), true, db, bcs)
+	if f := d.checkPolicyPath(); f.Status != DIAG_WARNING {
+		t.Errorf("expected policy directory warning, got %v", f)
+	}
+
+}
@@ -1,22 +1,35 @@
 package agreementbot
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/golang/glog"
+	"github.com/open-horizon/anax/abstractprotocol"
 	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/metering"
 	"github.com/open-horizon/anax/policy"
 	"math"
 	"net/http"
+	"sync"
 	"time"
 )
 
 func (w *AgreementBotWorker) GovernAgreements() int {
 
+	start := time.Now()
 	unarchived := []AFilter{UnarchivedAFilter()}
 
+	// Agreements whose policy changed during maintenance mode are cancelled once maintenance mode ends.
+	maintenance := InMaintenanceMode(w.db)
+	if w.maintenance && !maintenance {
+		glog.V(3).Infof(logString(fmt.Sprintf("maintenance mode ended, checking for agreements with changed policies.")))
+		w.cancelChangedPolicyAgreements()
+	}
+	w.maintenance = maintenance
+
 	// The length of time this governance routine waits is based on several factors. The data verification check rate
 	// of any agreements that are being maintained and the default time specified in the agbot config. Assume that we
 	// start with the default and adjust as necessary. The node health check rate also applies to the amount of time
@@ -26,10 +39,9 @@ func (w *AgreementBotWorker) GovernAgreements() int {
 	// This is the amount of time for the routine to wait as discovered through scanning active agreements. Node health
 	// checks and data verification checks might be skipped if they each dont have to occur every time this function
 	// wakes up. The idea is to do one scan of all agreements and do as much checking as necessary, but not more.
-	discoveredDVWaitTime := uint64(0) // Shortest data verification check rate value across all agreements.
-	discoveredNHWaitTime := uint64(0) // Shortest node health check rate value across all agreements.
-	w.GovTiming.dvSkip = uint64(0)    // Number of times to skip data verification checks before actually doing the check.
-	w.GovTiming.nhSkip = uint64(0)    // Number of times to skip node health checks before actually doing the check.
+	discovered := governanceWaits{} // Shortest data verification and node health check rate values across all agreements.
+	w.GovTiming.dvSkip = uint64(0)  // Number of times to skip data verification checks before actually doing the check.
+	w.GovTiming.nhSkip = uint64(0)  // Number of times to skip node health checks before actually doing the check.
 
 	// A filter for limiting the returned set of agreements just to those that are in progress and not yet timed out.
 	notYetFinalFilter := func() AFilter {
@@ -40,6 +52,12 @@ func (w *AgreementBotWorker) GovernAgreements() int {
 	// info from the exchange. The exchange might return no updates, but at least the agbot asked for updates.
 	w.NHManager.ResetUpdateStatus()
 
+	// The agreements that are governed in this pass, so that the data pushed to the API for the other agreements is
+	// forgotten, and the work done by each shard.
+	governed := make(map[string]bool)
+	governedAll := true
+	shardStats := make([]GovernanceShardStats, 0)
+
 	// Look at all agreements across all protocols
 	for _, agp := range policy.AllAgreementProtocols() {
 
@@ -47,146 +65,25 @@ func (w *AgreementBotWorker) GovernAgreements() int {
 
 		// Find all agreements that are in progress. They might be waiting for a reply or not yet finalized on blockchain.
 		if agreements, err := FindAgreements(w.db, []AFilter{notYetFinalFilter(), UnarchivedAFilter()}, agp); err == nil {
-			activeDataVerification := true
-			allActiveAgreements := make(map[string][]string)
+			// The agreements that are left out of the batch of their shard are still governed, in a later pass.
 			for _, ag := range agreements {
+				governed[ag.CurrentAgreementId] = true
+			}
 
-				// Govern agreements that have seen a reply from the device
-				if protocolHandler.AlreadyReceivedReply(&ag) {
-
-					// For agreements that havent seen a blockchain write yet, check timeout
-					if ag.AgreementFinalizedTime == 0 {
-
-						glog.V(5).Infof("AgreementBot Governance detected agreement %v not yet final.", ag.CurrentAgreementId)
-						now := uint64(time.Now().Unix())
-						if ag.AgreementCreationTime+w.BaseWorker.Manager.Config.AgreementBot.AgreementTimeoutS < now {
-							// Start timing out the agreement
-							w.TerminateAgreement(&ag, protocolHandler.GetTerminationCode(TERM_REASON_NOT_FINALIZED_TIMEOUT))
-						}
-					}
-
-					// Do DV check only if not skipping it this time.
-					if w.GovTiming.dvSkip == 0 {
-
-						// Check for the receipt of data in the data ingest system (if necessary)
-						if !ag.DisableDataVerificationChecks {
-
-							// Capture the data verification check rate for later
-							if discoveredDVWaitTime == 0 || (discoveredDVWaitTime != 0 && uint64(ag.DataVerificationCheckRate) < discoveredDVWaitTime) {
-								discoveredDVWaitTime = uint64(ag.DataVerificationCheckRate)
-							}
-
-							// First check to see if this agreement is just not sending data. If so, terminate the agreement.
-							now := uint64(time.Now().Unix())
-							noDataLimit := w.BaseWorker.Manager.Config.AgreementBot.NoDataIntervalS
-							if ag.DataVerificationNoDataInterval != 0 {
-								noDataLimit = uint64(ag.DataVerificationNoDataInterval)
-							}
-							if now-ag.DataVerifiedTime >= noDataLimit {
-								// No data is being received, terminate the agreement
-								glog.V(3).Infof(logString(fmt.Sprintf("cancelling agreement %v due to lack of data", ag.CurrentAgreementId)))
-								w.TerminateAgreement(&ag, protocolHandler.GetTerminationCode(TERM_REASON_NO_DATA_RECEIVED))
-
-							} else if activeDataVerification {
-								// Otherwise make sure the device is still sending data
-								if ag.DataVerifiedTime+uint64(ag.DataVerificationCheckRate) > now {
-									// It's not time to check again
-									continue
-								} else if activeAgreements, err := GetActiveAgreements(allActiveAgreements, ag, w.BaseWorker.Manager.Config); err != nil {
-									glog.Errorf(logString(fmt.Sprintf("unable to retrieve active agreement list. Terminating data verification loop early, error: %v", err)))
-									activeDataVerification = false
-								} else if ActiveAgreementsContains(activeAgreements, ag, w.Config.AgreementBot.DVPrefix) {
-									if _, err := DataVerified(w.db, ag.CurrentAgreementId, agp); err != nil {
-										glog.Errorf(logString(fmt.Sprintf("unable to record data verification, error: %v", err)))
-									}
-
-									if ag.DataNotificationSent == 0 {
-										// Get message address of the device from the exchange. The device ensures that the exchange is kept current.
-										// If the address happens to be invalid, that should be a temporary condition. We will keep sending until
-										// we get an ack to our verification message.
-										if whisperTo, pubkeyTo, err := protocolHandler.GetDeviceMessageEndpoint(ag.DeviceId, "Governance"); err != nil {
-											glog.Errorf(logString(fmt.Sprintf("error obtaining message target for data notification: %v", err)))
-										} else if mt, err := exchange.CreateMessageTarget(ag.DeviceId, nil, pubkeyTo, whisperTo); err != nil {
-											glog.Errorf(logString(fmt.Sprintf("error creating message target: %v", err)))
-										} else if err := protocolHandler.AgreementProtocolHandler("", "", "").NotifyDataReceipt(ag.CurrentAgreementId, mt, protocolHandler.GetSendMessage()); err != nil {
-											glog.Errorf(logString(fmt.Sprintf("unable to send data notification, error: %v", err)))
-										}
-									}
-
-									// Check to see if it's time to send a metering notification
-									// Create Metering notification. If the policy is empty, there's nothing to do.
-									mp := policy.Meter{Tokens: ag.MeteringTokens, PerTimeUnit: ag.MeteringPerTimeUnit, NotificationIntervalS: ag.MeteringNotificationInterval}
-									if mp.IsEmpty() {
-										continue
-									} else if ag.MeteringNotificationSent == 0 || (ag.MeteringNotificationSent != 0 && (ag.MeteringNotificationSent+uint64(ag.MeteringNotificationInterval)) <= now) {
-										// Grab the blockchain info from the agreement if there is any
-
-										bcType, bcName, bcOrg := protocolHandler.GetKnownBlockchain(&ag)
-										glog.V(5).Info(logString(fmt.Sprintf("metering on %v %v", bcType, bcName)))
-
-										// If we can write to the blockchain then we have all the info we need to do metering.
-										if protocolHandler.IsBlockchainWritable(bcType, bcName, bcOrg) && protocolHandler.CanSendMeterRecord(&ag) {
-											if mn, err := protocolHandler.CreateMeteringNotification(mp, &ag); err != nil {
-												glog.Errorf(logString(fmt.Sprintf("unable to create metering notification, error: %v", err)))
-											} else if whisperTo, pubkeyTo, err := protocolHandler.GetDeviceMessageEndpoint(ag.DeviceId, "Governance"); err != nil {
-												glog.Errorf(logString(fmt.Sprintf("error obtaining message target for metering notification: %v", err)))
-											} else if mt, err := exchange.CreateMessageTarget(ag.DeviceId, nil, pubkeyTo, whisperTo); err != nil {
-												glog.Errorf(logString(fmt.Sprintf("error creating message target: %v", err)))
-											} else if msg, err := protocolHandler.AgreementProtocolHandler(bcType, bcName, bcOrg).NotifyMetering(ag.CurrentAgreementId, mn, mt, protocolHandler.GetSendMessage()); err != nil {
-												glog.Errorf(logString(fmt.Sprintf("unable to send metering notification, error: %v", err)))
-											} else if _, err := MeteringNotification(w.db, ag.CurrentAgreementId, agp, msg); err != nil {
-												glog.Errorf(logString(fmt.Sprintf("unable to record metering notification, error: %v", err)))
-											}
-										}
-									}
-
-									// Data verification has occured. If it has been maintained for the specified duration then we can turn off the
-									// workload rollback retry checking feature.
-									if wlUsage, err := FindSingleWorkloadUsageByDeviceAndPolicyName(w.db, ag.DeviceId, ag.PolicyName); err != nil {
-										glog.Errorf(logString(fmt.Sprintf("unable to find workload usage record, error: %v", err)))
-									} else if wlUsage != nil && !wlUsage.DisableRetry {
-										if wlUsage.VerifiedDurationS == 0 || (wlUsage.VerifiedDurationS != 0 && ag.DataNotificationSent != 0 && ag.DataVerifiedTime != ag.AgreementCreationTime && (ag.DataVerifiedTime > ag.DataNotificationSent) && ((ag.DataVerifiedTime - ag.DataNotificationSent) >= uint64(wlUsage.VerifiedDurationS))) {
-											glog.V(5).Infof(logString(fmt.Sprintf("disabling workload rollback for %v after %v seconds", ag.CurrentAgreementId, (ag.DataVerifiedTime - ag.DataNotificationSent))))
-											if _, err := DisableRollbackChecking(w.db, ag.DeviceId, ag.PolicyName); err != nil {
-												glog.Errorf(logString(fmt.Sprintf("unable to disable workload rollback retries, error: %v", err)))
-											}
-										}
-									}
-
-								} else if _, err := DataNotVerified(w.db, ag.CurrentAgreementId, agp); err != nil {
-									glog.Errorf(logString(fmt.Sprintf("unable to record data not verified, error: %v", err)))
-								}
-							}
-						}
-					}
-
-					// Do node health check only if not skipping it this time.
-					if w.GovTiming.nhSkip == 0 {
-
-						// Check for agreement termination based on node health issues. Checking node health might require an expensive
-						// call to the exchange for batch node status, so only do the health checks if we have to.
-						if checkrate, err := w.VerifyNodeHealth(&ag, protocolHandler); err != nil {
-							glog.Errorf(logString(fmt.Sprintf("unable to verify node health for %v, error: %v", ag.CurrentAgreementId, err)))
-						} else if checkrate != 0 && (discoveredNHWaitTime == 0 || (discoveredNHWaitTime != 0 && uint64(checkrate) < discoveredNHWaitTime)) {
-							discoveredNHWaitTime = uint64(checkrate)
-						}
-					}
+			waits, stats := w.governShards(agp, protocolHandler, agreements)
+			discovered.merge(waits)
+			shardStats = append(shardStats, stats...)
 
-					// Govern agreements that havent seen a proposal reply yet
-				} else {
-					// We are waiting for a reply
-					glog.V(5).Infof("AgreementBot Governance waiting for reply to %v.", ag.CurrentAgreementId)
-					now := uint64(time.Now().Unix())
-					if ag.AgreementCreationTime+w.BaseWorker.Manager.Config.AgreementBot.ProtocolTimeoutS < now {
-						w.TerminateAgreement(&ag, protocolHandler.GetTerminationCode(TERM_REASON_NO_REPLY))
-					}
-				}
-			}
 		} else {
 			glog.Errorf(logString(fmt.Sprintf("unable to read agreements from database, error: %v", err)))
+			governedAll = false
 		}
 	}
 
+	if governedAll {
+		w.dataPushes.Retain(governed)
+	}
+
 	// Proactively check the state of pending workload upgrades for HA devices. When the need for an upgrade is detected, one of the
 	// devices in the HA group is chosen for upgrade and the others are marked for a pending upgrade (in their workload usage record).
 	// The goal of this routine is to detect when 1 member of the group is upgraded and it's safe to start to upgrade another member.
@@ -249,7 +146,9 @@ func (w *AgreementBotWorker) GovernAgreements() int {
 
 			// If there is already one partner successfully upgraded and there are no partners in the middle of an upgrade, then
 			// begin upgrading the partner who needs it.
-			if upgradedPartnerFound != "" && partnerUpgrading == "" {
+			if upgradedPartnerFound != "" && partnerUpgrading == "" && maintenance {
+				glog.V(3).Infof(logString(fmt.Sprintf("not upgrading HA member %v in group %v during maintenance mode.", wlu.DeviceId, wlu.HAPartners)))
+			} else if upgradedPartnerFound != "" && partnerUpgrading == "" {
 				glog.V(3).Infof(logString(fmt.Sprintf("beginning upgrade of HA member %v in group %v.", wlu.DeviceId, wlu.HAPartners)))
 				if ag, err := FindSingleAgreementByAgreementIdAllProtocols(w.db, wlu.CurrentAgreementId, policy.AllAgreementProtocols(), unarchived); err != nil {
 					glog.Errorf(logString(fmt.Sprintf("unable to read agreement %v from database, error: %v", wlu.CurrentAgreementId, err)))
@@ -277,7 +176,7 @@ func (w *AgreementBotWorker) GovernAgreements() int {
 
 	// Dynamically adjust wait time to account for large differential between DV check rates and NH check rates.
 	if w.GovTiming.dvSkip == 0 && w.GovTiming.nhSkip == 0 {
-		w.GovTiming.dvSkip, w.GovTiming.nhSkip, waitTime = calculateSkipTime(discoveredDVWaitTime, discoveredNHWaitTime, w.BaseWorker.Manager.Config.AgreementBot.ProcessGovernanceIntervalS)
+		w.GovTiming.dvSkip, w.GovTiming.nhSkip, waitTime = calculateSkipTime(discovered.dv, discovered.nh, w.BaseWorker.Manager.Config.AgreementBot.ProcessGovernanceIntervalS)
 	} else {
 		// Decrement skip counts here to prepare for next iteration
 		if w.GovTiming.dvSkip > 0 {
@@ -287,11 +186,232 @@ func (w *AgreementBotWorker) GovernAgreements() int {
 			w.GovTiming.nhSkip = w.GovTiming.nhSkip - 1
 		}
 	}
+	w.governance.Record(start, shardStats)
 	glog.V(5).Infof(logString(fmt.Sprintf("sleeping for %v seconds, skipping data verification %v time(s), and skipping node health %v time(s).", waitTime, w.GovTiming.dvSkip, w.GovTiming.nhSkip)))
 	return int(waitTime)
 
 }
 
+// The shortest data verification and node health check rates seen while governing agreements.
+type governanceWaits struct {
+	dv uint64
+	nh uint64
+}
+
+func (g *governanceWaits) merge(other governanceWaits) {
+	if other.dv != 0 && (g.dv == 0 || other.dv < g.dv) {
+		g.dv = other.dv
+	}
+	if other.nh != 0 && (g.nh == 0 || other.nh < g.nh) {
+		g.nh = other.nh
+	}
+}
+
+// Govern the in progress agreements of a protocol, each shard of them in its own goroutine. Each shard has its own
+// data verifiers because they keep state for the pass.
+func (w *AgreementBotWorker) governShards(agp string, protocolHandler ConsumerProtocolHandler, agreements []Agreement) (governanceWaits, []GovernanceShardStats) {
+
+	shards := governanceShards(agreements, w.BaseWorker.Manager.Config.AgreementBot.GovernanceShards)
+	batch := w.BaseWorker.Manager.Config.AgreementBot.GovernanceShardBatch
+
+	shardWaits := make([]governanceWaits, len(shards))
+	stats := make([]GovernanceShardStats, len(shards))
+
+	var wg sync.WaitGroup
+	for i := range shards {
+		wg.Add(1)
+		go func(shard int) {
+			defer wg.Done()
+			start := time.Now()
+
+			cursor := ""
+			if batch != 0 {
+				if c, err := FindGovernanceCursor(w.db, agp, shard); err != nil {
+					glog.Errorf(logString(fmt.Sprintf("unable to read the cursor of %v governance shard %v, error: %v", agp, shard, err)))
+				} else {
+					cursor = c
+				}
+			}
+
+			agreements, next := governanceBatch(shards[shard], cursor, batch)
+			dataVerifiers := w.newDataVerifiers()
+			for j := range agreements {
+				w.governAgreement(&agreements[j], agp, protocolHandler, dataVerifiers, &shardWaits[shard])
+			}
+
+			if batch != 0 && next != cursor {
+				if err := SaveGovernanceCursor(w.db, agp, shard, next); err != nil {
+					glog.Errorf(logString(fmt.Sprintf("unable to save the cursor of %v governance shard %v, error: %v", agp, shard, err)))
+				}
+			}
+
+			stats[shard] = GovernanceShardStats{
+				Protocol:   agp,
+				Shard:      shard,
+				Agreements: len(shards[shard]),
+				Governed:   len(agreements),
+				Cursor:     next,
+				DurationMS: int64(time.Since(start) / time.Millisecond),
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	waits := governanceWaits{}
+	for _, sw := range shardWaits {
+		waits.merge(sw)
+	}
+	return waits, stats
+}
+
+// Govern an agreement that is in progress. The shortest check rates seen are saved in waits.
+func (w *AgreementBotWorker) governAgreement(ag *Agreement, agp string, protocolHandler ConsumerProtocolHandler, dataVerifiers *DataVerifiers, waits *governanceWaits) {
+
+	// Govern agreements that have seen a reply from the device
+	if protocolHandler.AlreadyReceivedReply(ag) {
+
+		// Cancel the agreement when the time moves outside of the time windows of its policy.
+		if windows, err := agreementTimeWindows(ag); err != nil {
+			glog.Errorf(logString(fmt.Sprintf("unable to get the time windows of agreement %v, error: %v", ag.CurrentAgreementId, err)))
+		} else if !windows.Allows(time.Now(), nil) {
+			glog.V(3).Infof(logString(fmt.Sprintf("cancelling agreement %v, outside of time windows %v", ag.CurrentAgreementId, windows)))
+			w.TerminateAgreement(ag, protocolHandler.GetTerminationCode(TERM_REASON_OUTSIDE_TIME_WINDOW))
+			return
+		}
+
+		// For agreements that havent seen a blockchain write yet, check timeout
+		if ag.AgreementFinalizedTime == 0 {
+
+			glog.V(5).Infof("AgreementBot Governance detected agreement %v not yet final.", ag.CurrentAgreementId)
+			now := uint64(time.Now().Unix())
+			if ag.AgreementCreationTime+ag.NotFinalizedTimeout(w.BaseWorker.Manager.Config.AgreementBot.AgreementTimeoutS) < now {
+				// Start timing out the agreement
+				w.TerminateAgreement(ag, protocolHandler.GetTerminationCode(TERM_REASON_NOT_FINALIZED_TIMEOUT))
+			}
+		}
+
+		// Do DV check only if not skipping it this time.
+		if w.GovTiming.dvSkip == 0 {
+
+			// Check for the receipt of data in the data ingest system (if necessary)
+			if !ag.DisableDataVerificationChecks {
+
+				// Capture the data verification check rate for later
+				if waits.dv == 0 || (waits.dv != 0 && uint64(ag.DataVerificationCheckRate) < waits.dv) {
+					waits.dv = uint64(ag.DataVerificationCheckRate)
+				}
+
+				// First check to see if this agreement is just not sending data. If so, terminate the agreement.
+				now := uint64(time.Now().Unix())
+				noDataLimit := w.BaseWorker.Manager.Config.AgreementBot.NoDataIntervalS
+				if ag.DataVerificationNoDataInterval != 0 {
+					noDataLimit = uint64(ag.DataVerificationNoDataInterval)
+				}
+				if now-ag.DataVerifiedTime >= noDataLimit {
+					// No data is being received, terminate the agreement
+					glog.V(3).Infof(logString(fmt.Sprintf("cancelling agreement %v due to lack of data", ag.CurrentAgreementId)))
+					w.TerminateAgreement(ag, protocolHandler.GetTerminationCode(TERM_REASON_NO_DATA_RECEIVED))
+
+				} else if !dataVerifiers.Failed(ag) {
+					// Otherwise make sure the device is still sending data
+					if ag.DataVerifiedTime+uint64(ag.DataVerificationCheckRate) > now {
+						// It's not time to check again
+						return
+					} else if received, err := dataVerifiers.DataReceived(ag); err != nil {
+						glog.Errorf(logString(fmt.Sprintf("%v. Skipping the agreements that use %v data verification until the next governance pass", err, dataVerificationType(ag))))
+					} else if received {
+						if _, err := DataVerified(w.db, ag.CurrentAgreementId, agp); err != nil {
+							glog.Errorf(logString(fmt.Sprintf("unable to record data verification, error: %v", err)))
+						}
+
+						if ag.DataNotificationSent == 0 {
+							// Get message address of the device from the exchange. The device ensures that the exchange is kept current.
+							// If the address happens to be invalid, that should be a temporary condition. We will keep sending until
+							// we get an ack to our verification message.
+							if whisperTo, pubkeyTo, err := protocolHandler.GetDeviceMessageEndpoint(ag.DeviceId, "Governance"); err != nil {
+								glog.Errorf(logString(fmt.Sprintf("error obtaining message target for data notification: %v", err)))
+							} else if mt, err := exchange.CreateMessageTarget(ag.DeviceId, nil, pubkeyTo, whisperTo); err != nil {
+								glog.Errorf(logString(fmt.Sprintf("error creating message target: %v", err)))
+							} else if err := protocolHandler.AgreementProtocolHandler("", "", "").NotifyDataReceipt(ag.CurrentAgreementId, mt, protocolHandler.GetSendMessage()); err != nil {
+								glog.Errorf(logString(fmt.Sprintf("unable to send data notification, error: %v", err)))
+							}
+						}
+
+						// Check to see if it's time to send a metering notification
+						// Create Metering notification. If the policy is empty, there's nothing to do.
+						mp := policy.Meter{Tokens: ag.MeteringTokens, PerTimeUnit: ag.MeteringPerTimeUnit, NotificationIntervalS: ag.MeteringNotificationInterval}
+						if mp.IsEmpty() {
+							return
+						} else if ag.MeteringNotificationSent == 0 || (ag.MeteringNotificationSent != 0 && (ag.MeteringNotificationSent+uint64(ag.MeteringNotificationInterval)) <= now) {
+							// Grab the blockchain info from the agreement if there is any
+
+							bcType, bcName, bcOrg := protocolHandler.GetKnownBlockchain(ag)
+							glog.V(5).Info(logString(fmt.Sprintf("metering on %v %v", bcType, bcName)))
+
+							// If we can write to the blockchain then we have all the info we need to do metering.
+							if protocolHandler.IsBlockchainWritable(bcType, bcName, bcOrg) && protocolHandler.CanSendMeterRecord(ag) {
+								if mn, err := protocolHandler.CreateMeteringNotification(mp, ag); err != nil {
+									glog.Errorf(logString(fmt.Sprintf("unable to create metering notification, error: %v", err)))
+								} else if whisperTo, pubkeyTo, err := protocolHandler.GetDeviceMessageEndpoint(ag.DeviceId, "Governance"); err != nil {
+									glog.Errorf(logString(fmt.Sprintf("error obtaining message target for metering notification: %v", err)))
+								} else if mt, err := exchange.CreateMessageTarget(ag.DeviceId, nil, pubkeyTo, whisperTo); err != nil {
+									glog.Errorf(logString(fmt.Sprintf("error creating message target: %v", err)))
+								} else if msg, err := protocolHandler.AgreementProtocolHandler(bcType, bcName, bcOrg).NotifyMetering(ag.CurrentAgreementId, mn, mt, protocolHandler.GetSendMessage()); err != nil {
+									glog.Errorf(logString(fmt.Sprintf("unable to send metering notification, error: %v", err)))
+								} else if _, err := MeteringNotification(w.db, ag.CurrentAgreementId, agp, msg); err != nil {
+									glog.Errorf(logString(fmt.Sprintf("unable to record metering notification, error: %v", err)))
+								} else {
+									w.meteringMQTT.Publish(&metering.MeteringRecord{Org: ag.Org, DeviceId: ag.DeviceId, PolicyName: ag.PolicyName, Protocol: agp, Notification: mn})
+									if _, err := RecordMeteringNotification(w.db, ag, agp, mn, w.Config.AgreementBot.MeteringRecordRetentionDays); err != nil {
+										glog.Errorf(logString(fmt.Sprintf("unable to save metering record of agreement %v for export, error: %v", ag.CurrentAgreementId, err)))
+									}
+								}
+							}
+						}
+
+						// Data verification has occured. If it has been maintained for the specified duration then we can turn off the
+						// workload rollback retry checking feature.
+						if wlUsage, err := FindSingleWorkloadUsageByDeviceAndPolicyName(w.db, ag.DeviceId, ag.PolicyName); err != nil {
+							glog.Errorf(logString(fmt.Sprintf("unable to find workload usage record, error: %v", err)))
+						} else if wlUsage != nil && !wlUsage.DisableRetry {
+							if wlUsage.VerifiedDurationS == 0 || (wlUsage.VerifiedDurationS != 0 && ag.DataNotificationSent != 0 && ag.DataVerifiedTime != ag.AgreementCreationTime && (ag.DataVerifiedTime > ag.DataNotificationSent) && ((ag.DataVerifiedTime - ag.DataNotificationSent) >= uint64(wlUsage.VerifiedDurationS))) {
+								glog.V(5).Infof(logString(fmt.Sprintf("disabling workload rollback for %v after %v seconds", ag.CurrentAgreementId, (ag.DataVerifiedTime - ag.DataNotificationSent))))
+								if _, err := DisableRollbackChecking(w.db, ag.DeviceId, ag.PolicyName); err != nil {
+									glog.Errorf(logString(fmt.Sprintf("unable to disable workload rollback retries, error: %v", err)))
+								}
+							}
+						}
+
+					} else if _, err := DataNotVerified(w.db, ag.CurrentAgreementId, agp); err != nil {
+						glog.Errorf(logString(fmt.Sprintf("unable to record data not verified, error: %v", err)))
+					}
+				}
+			}
+		}
+
+		// Do node health check only if not skipping it this time.
+		if w.GovTiming.nhSkip == 0 {
+
+			// Check for agreement termination based on node health issues. Checking node health might require an expensive
+			// call to the exchange for batch node status, so only do the health checks if we have to.
+			if checkrate, err := w.VerifyNodeHealth(ag, protocolHandler); err != nil {
+				glog.Errorf(logString(fmt.Sprintf("unable to verify node health for %v, error: %v", ag.CurrentAgreementId, err)))
+			} else if checkrate != 0 && (waits.nh == 0 || (waits.nh != 0 && uint64(checkrate) < waits.nh)) {
+				waits.nh = uint64(checkrate)
+			}
+		}
+
+		// Govern agreements that havent seen a proposal reply yet
+	} else {
+		// We are waiting for a reply
+		glog.V(5).Infof("AgreementBot Governance waiting for reply to %v.", ag.CurrentAgreementId)
+		now := uint64(time.Now().Unix())
+		if ag.AgreementCreationTime+ag.NoReplyTimeout(w.BaseWorker.Manager.Config.AgreementBot.ProtocolTimeoutS) < now {
+			w.TerminateAgreement(ag, protocolHandler.GetTerminationCode(TERM_REASON_NO_REPLY))
+		}
+	}
+}
+
 // Calculate wait time intervals for data verification and node health checks and come up with an aggregate wait time before we run
 // the next agreement iteration(s) again. When all skip counts are zero, this function will get called again to recalculate wait and skips.
 func calculateSkipTime(dvCheckrate uint64, nhCheckrate uint64, pgi uint64) (uint64, uint64, uint64) {
@@ -344,7 +464,7 @@ func (w *AgreementBotWorker) checkWorkloadUsageAgreement(partnerWLU *WorkloadUsa
 		// Check to make sure the partner is heart-beating to the exchange. This should tell us if we can expect this device to
 		// complete an agreement at some time, or not.
 
-		if dev, err := GetDevice(w.Config.Collaborators.HTTPClientFactory.NewHTTPClient(nil), partnerWLU.DeviceId, w.GetExchangeURL(), w.GetExchangeId(), w.GetExchangeToken()); err != nil {
+		if dev, err := GetDevice(w.Context(), w.Config.Collaborators.HTTPClientFactory.NewHTTPClient(nil), partnerWLU.DeviceId, w.GetExchangeURL(), w.GetExchangeId(), w.GetExchangeToken()); err != nil {
 			glog.Errorf(logString(fmt.Sprintf("error obtaining device %v heartbeat state: %v", partnerWLU.DeviceId, err)))
 		} else if len(dev.LastHeartbeat) != 0 && (uint64(cutil.TimeInSeconds(dev.LastHeartbeat)+300) > uint64(time.Now().Unix())) {
 			// If the device is still alive (heart beat received in the last 5 mins), then assume this partner is trying to make an
@@ -399,9 +519,15 @@ func (w *AgreementBotWorker) VerifyNodeHealth(ag *Agreement, cph ConsumerProtoco
 		return ag.NHCheckAgreementStatus, errors.New(fmt.Sprintf("unable to update node health for %v, error %v", ag.Pattern, err))
 	}
 
+	// The node health policy can give the node a grace of a few checks, otherwise the agbot's default applies.
+	graceChecks := ag.NHMissingHBGraceChecks
+	if graceChecks == 0 {
+		graceChecks = w.Config.AgreementBot.NodeHeartbeatGraceChecks
+	}
+
 	// If this agreement's node is out of policy, cancel the agreement and remove the node from the cache.
 	// If the agreement is missing, cancel it.
-	if w.NHManager.NodeOutOfPolicy(ag.Pattern, ag.Org, ag.DeviceId, ag.NHMissingHBInterval) {
+	if w.NHManager.NodeOutOfPolicyAfterGrace(ag.Pattern, ag.Org, ag.DeviceId, ag.NHMissingHBInterval, graceChecks) {
 		w.TerminateAgreement(ag, cph.GetTerminationCode(TERM_REASON_NODE_HEARTBEAT))
 	} else if ag.FinalizedWithinTolerance(finalizedTolerance) {
 		// The agreement might have been recently finalized but the device has not yet recorded the agreement in the exchange.
@@ -413,6 +539,20 @@ func (w *AgreementBotWorker) VerifyNodeHealth(ag *Agreement, cph ConsumerProtoco
 	return ag.NHCheckAgreementStatus, nil
 }
 
+// Returns the time windows of the agreement, from the terms and conditions of its proposal. The windows in the terms
+// and conditions already have the node's time zone.
+func agreementTimeWindows(ag *Agreement) (policy.TimeWindowList, error) {
+	if ag.Proposal == "" {
+		return nil, nil
+	} else if proposal, err := abstractprotocol.DemarshalProposal(ag.Proposal); err != nil {
+		return nil, err
+	} else if tcPolicy, err := policy.DemarshalPolicy(proposal.TsAndCs()); err != nil {
+		return nil, err
+	} else {
+		return tcPolicy.TimeWindows, nil
+	}
+}
+
 func (w *AgreementBotWorker) TerminateAgreement(ag *Agreement, reason uint) {
 	// Start timing out the agreement
 	glog.V(3).Infof(logString(fmt.Sprintf("detected agreement %v needs to terminate.", ag.CurrentAgreementId)))
@@ -426,7 +566,7 @@ func (w *AgreementBotWorker) TerminateAgreement(ag *Agreement, reason uint) {
 	w.consumerPH[ag.AgreementProtocol].HandleAgreementTimeout(NewAgreementTimeoutCommand(ag.CurrentAgreementId, ag.AgreementProtocol, reason), w.consumerPH[ag.AgreementProtocol])
 }
 
-func GetDevice(httpClient *http.Client, deviceId string, url string, agbotId string, token string) (*exchange.Device, error) {
+func GetDevice(ctx context.Context, httpClient *http.Client, deviceId string, url string, agbotId string, token string) (*exchange.Device, error) {
 
 	glog.V(5).Infof(logString(fmt.Sprintf("retrieving device %v from exchange", deviceId)))
 
@@ -434,7 +574,7 @@ func GetDevice(httpClient *http.Client, deviceId string, url string, agbotId str
 	resp = new(exchange.GetDevicesResponse)
 	targetURL := url + "orgs/" + exchange.GetOrg(deviceId) + "/nodes/" + exchange.GetId(deviceId)
 	for {
-		if err, tpErr := exchange.InvokeExchange(httpClient, "GET", targetURL, agbotId, token, nil, &resp); err != nil {
+		if err, tpErr := exchange.InvokeExchangeWithContext(ctx, httpClient, "GET", targetURL, agbotId, token, nil, &resp); err != nil {
 			glog.Errorf(logString(err.Error()))
 			return nil, err
 		} else if tpErr != nil {
@@ -453,6 +593,31 @@ func GetDevice(httpClient *http.Client, deviceId string, url string, agbotId str
 	}
 }
 
+// Get the agreements that a device has recorded in the exchange. These are the agreements made with all agbots, not
+// just this one.
+func GetDeviceAgreements(ctx context.Context, httpClient *http.Client, deviceId string, url string, agbotId string, token string) (map[string]exchange.DeviceAgreement, error) {
+
+	glog.V(5).Infof(logString(fmt.Sprintf("retrieving device %v agreements from exchange", deviceId)))
+
+	var resp interface{}
+	resp = new(exchange.AllDeviceAgreementsResponse)
+	targetURL := url + "orgs/" + exchange.GetOrg(deviceId) + "/nodes/" + exchange.GetId(deviceId) + "/agreements"
+	for {
+		if err, tpErr := exchange.InvokeExchangeWithContext(ctx, httpClient, "GET", targetURL, agbotId, token, nil, &resp); err != nil {
+			glog.Errorf(logString(err.Error()))
+			return nil, err
+		} else if tpErr != nil {
+			glog.Warningf(logString(tpErr.Error()))
+			time.Sleep(10 * time.Second)
+			continue
+		} else {
+			agreements := resp.(*exchange.AllDeviceAgreementsResponse).Agreements
+			glog.V(5).Infof(logString(fmt.Sprintf("retrieved device %v agreements from exchange %v", deviceId, agreements)))
+			return agreements, nil
+		}
+	}
+}
+
 // Govern the archived agreements, periodically deleting them from the database if they are old enough. The
 // age limit is defined by the agbot configuration, PurgeArchivedAgreementHours.
 //
@@ -502,26 +667,32 @@ func (w *AgreementBotWorker) GovernBlockchainNeeds() int {
 	for _, agp := range policy.AllAgreementProtocols() {
 
 		// If the agreement protocol doesnt require a blockchain then we can skip it.
-		if bcType := policy.RequiresBlockchainType(agp); bcType == "" {
+		if defaultType := policy.RequiresBlockchainType(agp); defaultType == "" {
 			continue
 		} else {
 
 			// Make a map of all blockchain names that we need to have running
-			neededBCs := make(map[string]map[string]bool)
+			neededBCs := make(map[string]map[string]map[string]bool)
 			if agreements, err := FindAgreements(w.db, []AFilter{UnarchivedAFilter()}, agp); err == nil {
 				for _, ag := range agreements {
-					_, bcName, bcOrg := w.consumerPH[agp].GetKnownBlockchain(&ag)
+					bcType, bcName, bcOrg := w.consumerPH[agp].GetKnownBlockchain(&ag)
 					if bcName != "" {
-						if _, ok := neededBCs[bcOrg]; !ok {
-							neededBCs[bcOrg] = make(map[string]bool)
+						if bcType == "" {
+							bcType = defaultType
 						}
-						neededBCs[bcOrg][bcName] = true
+						if _, ok := neededBCs[bcType]; !ok {
+							neededBCs[bcType] = make(map[string]map[string]bool)
+						}
+						if _, ok := neededBCs[bcType][bcOrg]; !ok {
+							neededBCs[bcType][bcOrg] = make(map[string]bool)
+						}
+						neededBCs[bcType][bcOrg][bcName] = true
 					}
 				}
 
-				// If we captured any needed blockchains, inform the blockchain worker
-				if len(neededBCs) != 0 {
-					w.Messages() <- events.NewReportNeededBlockchainsMessage(events.BC_NEEDED, bcType, neededBCs)
+				// If we captured any needed blockchains, inform the blockchain worker of each type
+				for bcType, typeBCs := range neededBCs {
+					w.Messages() <- events.NewReportNeededBlockchainsMessage(events.BC_NEEDED, bcType, typeBCs)
 				}
 
 			} else {
@@ -535,5 +706,8 @@ func (w *AgreementBotWorker) GovernBlockchainNeeds() int {
 
 // global log record prefix
 var logString = func(v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("AgreementBot Governance", nil, v)
+	}
 	return fmt.Sprintf("AgreementBot Governance: %v", v)
 }
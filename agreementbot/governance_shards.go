@@ -0,0 +1,184 @@
+package agreementbot
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The active agreements are governed by GovernanceShards goroutines in parallel, so that a governance pass over a
+// large number of agreements still fits in the governance interval. An agreement is always governed by the same
+// shard, the one its id hashes to. When GovernanceShardBatch is set, each shard governs at most that many agreements
+// in a pass and continues after the last one in the next pass. The last agreement governed by each shard is its
+// cursor, which is saved in the database so that an agbot restart does not start over with the same agreements.
+const GOVERNANCE_CURSORS = "governance_cursors"
+
+// Returns the shard that governs the agreement.
+func governanceShard(agreementId string, shards int) int {
+	if shards <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(agreementId))
+	return int(h.Sum32() % uint32(shards))
+}
+
+// Returns the agreements of each shard, sorted by agreement id so that a shard can continue after its cursor.
+func governanceShards(agreements []Agreement, shards int) [][]Agreement {
+	if shards < 1 {
+		shards = 1
+	}
+	byShard := make([][]Agreement, shards)
+	for _, ag := range agreements {
+		s := governanceShard(ag.CurrentAgreementId, shards)
+		byShard[s] = append(byShard[s], ag)
+	}
+	for _, ags := range byShard {
+		sort.Sort(AgreementsByAgreementId(ags))
+	}
+	return byShard
+}
+
+type AgreementsByAgreementId []Agreement
+
+func (s AgreementsByAgreementId) Len() int {
+	return len(s)
+}
+
+func (s AgreementsByAgreementId) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s AgreementsByAgreementId) Less(i, j int) bool {
+	return s[i].CurrentAgreementId < s[j].CurrentAgreementId
+}
+
+// Returns the next batch of the sorted agreements of a shard, the ones after the cursor, wrapping around to the first
+// agreements when there are not enough of them. Also returns the new cursor of the shard, which is empty when all
+// the agreements are in the batch so that the next pass starts from the beginning. A batch of 0 is all the agreements.
+func governanceBatch(agreements []Agreement, cursor string, batch int) ([]Agreement, string) {
+	if batch <= 0 || len(agreements) <= batch {
+		return agreements, ""
+	}
+
+	start := sort.Search(len(agreements), func(i int) bool { return agreements[i].CurrentAgreementId > cursor })
+	next := make([]Agreement, 0, batch)
+	for i := 0; i < batch; i++ {
+		next = append(next, agreements[(start+i)%len(agreements)])
+	}
+	return next, next[len(next)-1].CurrentAgreementId
+}
+
+func governanceCursorKey(protocol string, shard int) []byte {
+	return []byte(fmt.Sprintf("%v/%v", protocol, shard))
+}
+
+// Returns the id of the last agreement governed by a shard, or the empty string when the shard has no cursor.
+func FindGovernanceCursor(db *bolt.DB, protocol string, shard int) (string, error) {
+	cursor := ""
+
+	readErr := db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(GOVERNANCE_CURSORS)); b != nil {
+			cursor = string(b.Get(governanceCursorKey(protocol, shard)))
+		}
+		return nil
+	})
+
+	if readErr != nil {
+		return "", readErr
+	}
+	return cursor, nil
+}
+
+// Save the id of the last agreement governed by a shard. An empty cursor is removed.
+func SaveGovernanceCursor(db *bolt.DB, protocol string, shard int, cursor string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if b, err := tx.CreateBucketIfNotExists([]byte(GOVERNANCE_CURSORS)); err != nil {
+			return err
+		} else if cursor == "" {
+			return b.Delete(governanceCursorKey(protocol, shard))
+		} else {
+			return b.Put(governanceCursorKey(protocol, shard), []byte(cursor))
+		}
+	})
+}
+
+// The work done by one shard of the most recent governance pass, for one agreement protocol.
+type GovernanceShardStats struct {
+	Protocol   string `json:"protocol"`
+	Shard      int    `json:"shard"`
+	Agreements int    `json:"agreements"`  // the agreements of the shard
+	Governed   int    `json:"governed"`    // the agreements governed in the pass, less than agreements when the batch is limited
+	Cursor     string `json:"cursor"`      // the last agreement governed, empty when the next pass starts from the beginning
+	DurationMS int64  `json:"duration_ms"` // how long the shard took to govern its agreements
+}
+
+// The duration of the governance passes, so that it can be compared with the governance interval. An overrun is a
+// pass that took longer than the interval.
+type GovernanceStats struct {
+	Shards             int                    `json:"shards"`
+	BatchSize          int                    `json:"batch_size"`
+	IntervalS          uint64                 `json:"interval_s"`
+	Passes             uint64                 `json:"passes"`
+	Overruns           uint64                 `json:"overruns"`
+	LastPassTime       int64                  `json:"last_pass_time"`
+	LastDurationMS     int64                  `json:"last_duration_ms"`
+	MaxDurationMS      int64                  `json:"max_duration_ms"`
+	AverageDurationMS  int64                  `json:"average_duration_ms"`
+	LastPassAgreements int                    `json:"last_pass_agreements"`
+	LastPassShards     []GovernanceShardStats `json:"last_pass_shards"`
+}
+
+type GovernanceMetrics struct {
+	stats   GovernanceStats
+	totalMS int64
+	lock    sync.Mutex
+}
+
+func NewGovernanceMetrics(shards int, batch int, intervalS uint64) *GovernanceMetrics {
+	return &GovernanceMetrics{
+		stats: GovernanceStats{
+			Shards:         shards,
+			BatchSize:      batch,
+			IntervalS:      intervalS,
+			LastPassShards: []GovernanceShardStats{},
+		},
+	}
+}
+
+// Record a governance pass that started at the given time, with the work done by each of its shards.
+func (m *GovernanceMetrics) Record(start time.Time, shards []GovernanceShardStats) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	duration := int64(time.Since(start) / time.Millisecond)
+	m.stats.Passes += 1
+	if m.stats.IntervalS != 0 && duration > int64(m.stats.IntervalS)*1000 {
+		m.stats.Overruns += 1
+	}
+	m.stats.LastPassTime = start.Unix()
+	m.stats.LastDurationMS = duration
+	if duration > m.stats.MaxDurationMS {
+		m.stats.MaxDurationMS = duration
+	}
+	m.totalMS += duration
+	m.stats.AverageDurationMS = m.totalMS / int64(m.stats.Passes)
+	m.stats.LastPassAgreements = 0
+	for _, s := range shards {
+		m.stats.LastPassAgreements += s.Governed
+	}
+	m.stats.LastPassShards = shards
+}
+
+// Returns a copy of the governance stats.
+func (m *GovernanceMetrics) Stats() GovernanceStats {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	stats := m.stats
+	stats.LastPassShards = append([]GovernanceShardStats{}, m.stats.LastPassShards...)
+	return stats
+}
@@ -0,0 +1,137 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func Test_GovernanceShards(t *testing.T) {
+
+	agreements := make([]Agreement, 0)
+	for i := 0; i < 100; i++ {
+		agreements = append(agreements, Agreement{CurrentAgreementId: fmt.Sprintf("ag%03d", 99-i)})
+	}
+
+	// Every agreement is in exactly one shard, the one its id hashes to, and the shards are sorted by agreement id.
+	shards := governanceShards(agreements, 4)
+	if len(shards) != 4 {
+		t.Fatalf("expected 4 shards, got %v", len(shards))
+	}
+	total := 0
+	for s, ags := range shards {
+		total += len(ags)
+		for i, ag := range ags {
+			if governanceShard(ag.CurrentAgreementId, 4) != s {
+				t.Errorf("agreement %v is in shard %v instead of %v", ag.CurrentAgreementId, s, governanceShard(ag.CurrentAgreementId, 4))
+			} else if i > 0 && ags[i-1].CurrentAgreementId >= ag.CurrentAgreementId {
+				t.Errorf("shard %v is not sorted at %v", s, ag.CurrentAgreementId)
+			}
+		}
+	}
+	if total != len(agreements) {
+		t.Errorf("expected %v agreements in the shards, got %v", len(agreements), total)
+	}
+
+	if shards := governanceShards(agreements, 0); len(shards) != 1 || len(shards[0]) != len(agreements) {
+		t.Errorf("all the agreements should be in a single shard")
+	}
+}
+
+func Test_GovernanceBatch(t *testing.T) {
+
+	agreements := []Agreement{{CurrentAgreementId: "a"}, {CurrentAgreementId: "b"}, {CurrentAgreementId: "c"}, {CurrentAgreementId: "d"}, {CurrentAgreementId: "e"}}
+
+	ids := func(ags []Agreement) string {
+		s := ""
+		for _, ag := range ags {
+			s += ag.CurrentAgreementId
+		}
+		return s
+	}
+
+	// Without a batch size, or with a batch bigger than the shard, all the agreements are governed every pass.
+	if batch, cursor := governanceBatch(agreements, "c", 0); ids(batch) != "abcde" || cursor != "" {
+		t.Errorf("wrong batch %v and cursor %v without a batch size", ids(batch), cursor)
+	} else if batch, cursor := governanceBatch(agreements, "c", 5); ids(batch) != "abcde" || cursor != "" {
+		t.Errorf("wrong batch %v and cursor %v with a batch of all the agreements", ids(batch), cursor)
+	}
+
+	// The batches continue after the cursor and wrap around.
+	cursor := ""
+	expected := []string{"ab", "cd", "ea", "bc"}
+	for _, e := range expected {
+		var batch []Agreement
+		batch, cursor = governanceBatch(agreements, cursor, 2)
+		if ids(batch) != e || cursor != e[1:] {
+			t.Errorf("expected batch %v, got %v with cursor %v", e, ids(batch), cursor)
+		}
+	}
+
+	// The agreement of the cursor might be gone, the batch starts at the next one.
+	if batch, cursor := governanceBatch(agreements, "bb", 2); ids(batch) != "cd" || cursor != "d" {
+		t.Errorf("wrong batch %v and cursor %v after a missing agreement", ids(batch), cursor)
+	}
+}
+
+func Test_GovernanceCursors(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "agbot-governance")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := bolt.Open(path.Join(dir, "agbot.db"), 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("unable to open db: %v", err)
+	}
+	defer db.Close()
+
+	if cursor, err := FindGovernanceCursor(db, "Basic", 0); err != nil || cursor != "" {
+		t.Errorf("a new agbot should have no cursor, got %v, error %v", cursor, err)
+	}
+
+	if err := SaveGovernanceCursor(db, "Basic", 0, "ag1"); err != nil {
+		t.Fatalf("unable to save cursor: %v", err)
+	} else if err := SaveGovernanceCursor(db, "Basic", 1, "ag2"); err != nil {
+		t.Fatalf("unable to save cursor: %v", err)
+	}
+
+	if cursor, err := FindGovernanceCursor(db, "Basic", 0); err != nil || cursor != "ag1" {
+		t.Errorf("expected cursor ag1, got %v, error %v", cursor, err)
+	} else if cursor, err := FindGovernanceCursor(db, "Basic", 1); err != nil || cursor != "ag2" {
+		t.Errorf("expected cursor ag2, got %v, error %v", cursor, err)
+	} else if cursor, err := FindGovernanceCursor(db, "Citizen Scientist", 0); err != nil || cursor != "" {
+		t.Errorf("the cursors of another protocol should be separate, got %v, error %v", cursor, err)
+	}
+
+	if err := SaveGovernanceCursor(db, "Basic", 0, ""); err != nil {
+		t.Fatalf("unable to clear cursor: %v", err)
+	} else if cursor, err := FindGovernanceCursor(db, "Basic", 0); err != nil || cursor != "" {
+		t.Errorf("expected the cursor to be cleared, got %v, error %v", cursor, err)
+	}
+}
+
+func Test_GovernanceMetrics(t *testing.T) {
+
+	m := NewGovernanceMetrics(2, 0, 1)
+
+	m.Record(time.Now(), []GovernanceShardStats{{Protocol: "Basic", Shard: 0, Agreements: 3, Governed: 3}, {Protocol: "Basic", Shard: 1, Agreements: 2, Governed: 2}})
+	m.Record(time.Now().Add(-2*time.Second), []GovernanceShardStats{{Protocol: "Basic", Shard: 0, Agreements: 3, Governed: 1}})
+
+	stats := m.Stats()
+	if stats.Passes != 2 || stats.Overruns != 1 {
+		t.Errorf("expected 2 passes and 1 overrun, got %v", stats)
+	} else if stats.LastDurationMS < 2000 || stats.MaxDurationMS != stats.LastDurationMS || stats.AverageDurationMS >= stats.MaxDurationMS {
+		t.Errorf("wrong durations %v", stats)
+	} else if stats.LastPassAgreements != 1 || len(stats.LastPassShards) != 1 {
+		t.Errorf("wrong last pass %v", stats)
+	}
+}
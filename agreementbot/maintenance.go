@@ -0,0 +1,147 @@
+package agreementbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/policy"
+	"time"
+)
+
+// While the agbot is in maintenance mode, it doesn't make new agreements and it doesn't cancel agreements because
+// their policy changed or was deleted. Everything else goes on as usual: agreements in flight are finalized, node
+// heartbeats and data verification are governed, and agreements are cancelled when a node stops heartbeating. This
+// is used while the exchange is being upgraded, when patterns and business policies can briefly look changed or
+// missing and would otherwise cancel many agreements at once. The mode is saved in the database, so it survives an
+// agbot restart. When maintenance mode ends, the agreements whose policy changed in the meantime are cancelled.
+const MAINTENANCE = "maintenance"
+const MAINTENANCE_MODE = "mode"
+
+type MaintenanceMode struct {
+	Enabled     bool   `json:"enabled"`
+	Reason      string `json:"reason"`
+	EnabledTime uint64 `json:"enabled_time"`
+}
+
+func (m MaintenanceMode) String() string {
+	return fmt.Sprintf("Enabled: %v, Reason: %v, EnabledTime: %v", m.Enabled, m.Reason, m.EnabledTime)
+}
+
+// Returns the maintenance mode, the agbot is not in maintenance mode if it was never enabled.
+func FindMaintenanceMode(db *bolt.DB) (*MaintenanceMode, error) {
+	mode := &MaintenanceMode{}
+
+	readErr := db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(MAINTENANCE)); b == nil {
+			return nil
+		} else if v := b.Get([]byte(MAINTENANCE_MODE)); v == nil {
+			return nil
+		} else if err := json.Unmarshal(v, mode); err != nil {
+			return fmt.Errorf("Unable to deserialize maintenance mode: %v", err)
+		}
+		return nil
+	})
+
+	if readErr != nil {
+		return nil, readErr
+	}
+	return mode, nil
+}
+
+// Turn maintenance mode on or off. Turning it on again only changes the reason, the time it was enabled is kept.
+func SetMaintenanceMode(db *bolt.DB, enabled bool, reason string) (*MaintenanceMode, error) {
+	mode, err := FindMaintenanceMode(db)
+	if err != nil {
+		return nil, err
+	}
+
+	if !enabled {
+		mode = &MaintenanceMode{}
+	} else {
+		if !mode.Enabled {
+			mode.EnabledTime = uint64(time.Now().Unix())
+		}
+		mode.Enabled = true
+		mode.Reason = reason
+	}
+
+	writeErr := db.Update(func(tx *bolt.Tx) error {
+		if b, err := tx.CreateBucketIfNotExists([]byte(MAINTENANCE)); err != nil {
+			return err
+		} else if serial, err := json.Marshal(mode); err != nil {
+			return fmt.Errorf("Unable to serialize maintenance mode %v: %v", mode, err)
+		} else {
+			return b.Put([]byte(MAINTENANCE_MODE), serial)
+		}
+	})
+
+	if writeErr != nil {
+		return nil, writeErr
+	}
+	return mode, nil
+}
+
+// Returns true when the agbot is in maintenance mode. If the mode can't be read, the agbot behaves as if it is not
+// in maintenance mode.
+func InMaintenanceMode(db *bolt.DB) bool {
+	if mode, err := FindMaintenanceMode(db); err != nil {
+		glog.Errorf(AWlogString(fmt.Sprintf("unable to read maintenance mode, error: %v", err)))
+		return false
+	} else {
+		return mode.Enabled
+	}
+}
+
+// Cancel the agreements whose policy changed or was deleted while the agbot was in maintenance mode. This is the same
+// check that is done when a policy changes, for all the agreements at once.
+func (w *AgreementBotWorker) cancelChangedPolicyAgreements() {
+
+	InProgress := func() AFilter {
+		return func(e Agreement) bool { return e.AgreementCreationTime != 0 && e.AgreementTimedout == 0 }
+	}
+
+	for agp, cph := range w.consumerPH {
+		agreements, err := FindAgreements(w.db, []AFilter{UnarchivedAFilter(), InProgress()}, agp)
+		if err != nil {
+			glog.Errorf(AWlogString(fmt.Sprintf("error searching database: %v", err)))
+			continue
+		}
+
+		for _, ag := range agreements {
+			if pol, err := policy.DemarshalPolicy(ag.Policy); err != nil {
+				glog.Errorf(AWlogString(fmt.Sprintf("unable to demarshal policy for agreement %v, error %v", ag.CurrentAgreementId, err)))
+				continue
+			} else if w.pm.GetPolicy(ag.Org, pol.Header.Name) != nil {
+				if err := w.pm.MatchesMine(ag.Org, pol); err == nil {
+					continue
+				}
+			}
+			glog.V(3).Infof(AWlogString(fmt.Sprintf("agreement %v has a policy %v that changed during maintenance mode", ag.CurrentAgreementId, ag.PolicyName)))
+
+			// HA partners are upgraded one at a time, skip the agreement when another member of its group is upgrading.
+			if wlUsage, err := FindSingleWorkloadUsageByDeviceAndPolicyName(w.db, ag.DeviceId, ag.PolicyName); err != nil {
+				glog.Warningf(AWlogString(fmt.Sprintf("error retreiving workload usage for %v using policy %v, error: %v", ag.DeviceId, ag.PolicyName, err)))
+			} else if wlUsage != nil && len(wlUsage.HAPartners) != 0 && wlUsage.PendingUpgradeTime != 0 {
+				continue
+			} else if wlUsage != nil && len(wlUsage.HAPartners) != 0 {
+				for _, partnerId := range wlUsage.HAPartners {
+					if _, err := UpdatePendingUpgrade(w.db, partnerId, ag.PolicyName); err != nil {
+						glog.Warningf(AWlogString(fmt.Sprintf("could not update pending workload upgrade for %v using policy %v, error: %v", partnerId, ag.PolicyName, err)))
+					}
+				}
+			}
+
+			// Delete the workload usage record so that a new agreement will be made starting from the highest priority workload.
+			if err := DeleteWorkloadUsage(w.db, ag.DeviceId, ag.PolicyName); err != nil {
+				glog.Warningf(AWlogString(fmt.Sprintf("error deleting workload usage for %v using policy %v, error: %v", ag.DeviceId, ag.PolicyName, err)))
+			}
+			cph.WorkQueue() <- CancelAgreement{
+				workType:    CANCEL,
+				AgreementId: ag.CurrentAgreementId,
+				Protocol:    ag.AgreementProtocol,
+				Reason:      cph.GetTerminationCode(TERM_REASON_POLICY_CHANGED),
+			}
+		}
+	}
+}
@@ -0,0 +1,53 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"github.com/boltdb/bolt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func Test_MaintenanceMode(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "agbot-maintenance")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := bolt.Open(path.Join(dir, "agbot.db"), 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("unable to open db: %v", err)
+	}
+	defer db.Close()
+
+	if mode, err := FindMaintenanceMode(db); err != nil {
+		t.Fatalf("unable to find maintenance mode: %v", err)
+	} else if mode.Enabled || InMaintenanceMode(db) {
+		t.Errorf("a new agbot should not be in maintenance mode, mode is %v", mode)
+	}
+
+	mode, err := SetMaintenanceMode(db, true, "exchange upgrade")
+	if err != nil {
+		t.Fatalf("unable to enable maintenance mode: %v", err)
+	} else if !mode.Enabled || mode.Reason != "exchange upgrade" || mode.EnabledTime == 0 || !InMaintenanceMode(db) {
+		t.Errorf("wrong enabled maintenance mode %v", mode)
+	}
+
+	// Enabling it again only changes the reason.
+	if again, err := SetMaintenanceMode(db, true, "database upgrade"); err != nil {
+		t.Fatalf("unable to enable maintenance mode: %v", err)
+	} else if again.Reason != "database upgrade" || again.EnabledTime != mode.EnabledTime {
+		t.Errorf("wrong re-enabled maintenance mode %v", again)
+	}
+
+	if mode, err := SetMaintenanceMode(db, false, ""); err != nil {
+		t.Fatalf("unable to disable maintenance mode: %v", err)
+	} else if mode.Enabled || mode.Reason != "" || mode.EnabledTime != 0 || InMaintenanceMode(db) {
+		t.Errorf("wrong disabled maintenance mode %v", mode)
+	}
+}
@@ -0,0 +1,303 @@
+package agreementbot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/metering"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// The agbot keeps a record of every metering notification it sends, so that the tokens granted to the nodes can be
+// reconciled with a billing system. The notifications of an agreement carry the tokens granted since the agreement
+// started, each record also has the tokens granted since the previous notification of the agreement, so that the
+// records of a time window add up to the tokens granted in that window. The records are kept for a number of days
+// after they are sent, the agreements they are for are usually gone by then.
+const METERING_RECORDS = "metering_records"
+
+// The most recent amount of each agreement, keyed by agreement id, to work out the increment of the next record.
+const METERING_RECORDS_LATEST = "metering_records_latest"
+
+type IssuedMeteringRecord struct {
+	AgreementId string `json:"agreement_id"`
+	Protocol    string `json:"protocol"`
+	Org         string `json:"org"`
+	Pattern     string `json:"pattern,omitempty"`
+	PolicyName  string `json:"policy_name"`
+	DeviceId    string `json:"device_id"`
+	Amount      uint64 `json:"amount"`      // the tokens granted since the agreement started
+	Increment   uint64 `json:"increment"`   // the tokens granted since the previous notification of the agreement
+	MissedTime  uint64 `json:"missed_time"` // the seconds of missing data since the agreement started
+	StartTime   uint64 `json:"start_time"`  // when the agreement started, in seconds since 1970
+	Time        uint64 `json:"time"`        // when the notification was sent, in seconds since 1970
+}
+
+func (m IssuedMeteringRecord) String() string {
+	return fmt.Sprintf("AgreementId: %v, Protocol: %v, Org: %v, Pattern: %v, PolicyName: %v, DeviceId: %v, Amount: %v, Increment: %v, MissedTime: %v, StartTime: %v, Time: %v",
+		m.AgreementId, m.Protocol, m.Org, m.Pattern, m.PolicyName, m.DeviceId, m.Amount, m.Increment, m.MissedTime, m.StartTime, m.Time)
+}
+
+type latestMeteringAmount struct {
+	Amount uint64 `json:"amount"`
+	Time   uint64 `json:"time"`
+}
+
+// The records are keyed by the time they were sent, followed by the agreement id, so that they are read in time order
+// and a time window is a range of keys.
+func meteringRecordKey(t uint64, agreementId string) []byte {
+	key := make([]byte, 8, 8+len(agreementId))
+	binary.BigEndian.PutUint64(key, t)
+	return append(key, []byte(agreementId)...)
+}
+
+func meteringRecordTimeKey(t uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, t)
+	return key
+}
+
+// Record a metering notification that was sent for an agreement. The records that are older than retentionDays are
+// removed on the way.
+func RecordMeteringNotification(db *bolt.DB, ag *Agreement, protocol string, mn *metering.MeteringNotification, retentionDays int) (*IssuedMeteringRecord, error) {
+
+	record := &IssuedMeteringRecord{
+		AgreementId: ag.CurrentAgreementId,
+		Protocol:    protocol,
+		Org:         ag.Org,
+		Pattern:     ag.Pattern,
+		PolicyName:  ag.PolicyName,
+		DeviceId:    ag.DeviceId,
+		Amount:      mn.Amount,
+		Increment:   mn.Amount,
+		MissedTime:  mn.MissedTime,
+		StartTime:   mn.StartTime,
+		Time:        mn.CurrentTime,
+	}
+
+	updateErr := db.Update(func(tx *bolt.Tx) error {
+
+		b, err := tx.CreateBucketIfNotExists([]byte(METERING_RECORDS))
+		if err != nil {
+			return err
+		}
+		lb, err := tx.CreateBucketIfNotExists([]byte(METERING_RECORDS_LATEST))
+		if err != nil {
+			return err
+		}
+
+		var latest latestMeteringAmount
+		if v := lb.Get([]byte(record.AgreementId)); v != nil {
+			if err := json.Unmarshal(v, &latest); err != nil {
+				return fmt.Errorf("Unable to deserialize latest metering amount %v: %v", string(v), err)
+			} else if latest.Amount <= record.Amount {
+				record.Increment = record.Amount - latest.Amount
+			} else {
+				record.Increment = 0
+			}
+		}
+
+		if serial, err := json.Marshal(record); err != nil {
+			return fmt.Errorf("Unable to serialize metering record %v: %v", record, err)
+		} else if err := b.Put(meteringRecordKey(record.Time, record.AgreementId), serial); err != nil {
+			return err
+		}
+
+		latest = latestMeteringAmount{Amount: record.Amount, Time: record.Time}
+		if serial, err := json.Marshal(latest); err != nil {
+			return fmt.Errorf("Unable to serialize latest metering amount %v: %v", latest, err)
+		} else if err := lb.Put([]byte(record.AgreementId), serial); err != nil {
+			return err
+		}
+
+		if retentionDays > 0 {
+			return pruneMeteringRecords(b, lb, uint64(time.Now().Unix())-uint64(retentionDays)*24*3600)
+		}
+		return nil
+	})
+
+	if updateErr != nil {
+		return nil, updateErr
+	}
+	return record, nil
+}
+
+// Remove the records sent before the cutoff, and the latest amounts of the agreements that have not been metered since.
+func pruneMeteringRecords(b *bolt.Bucket, lb *bolt.Bucket, cutoff uint64) error {
+
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil && bytes.Compare(k[:8], meteringRecordTimeKey(cutoff)) < 0; k, _ = c.First() {
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+
+	stale := make([][]byte, 0)
+	lb.ForEach(func(k, v []byte) error {
+		var latest latestMeteringAmount
+		if err := json.Unmarshal(v, &latest); err != nil || latest.Time < cutoff {
+			stale = append(stale, append([]byte{}, k...))
+		}
+		return nil
+	})
+	for _, k := range stale {
+		if err := lb.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type MRFilter func(IssuedMeteringRecord) bool
+
+func OrgMRFilter(org string) MRFilter {
+	return func(m IssuedMeteringRecord) bool { return m.Org == org }
+}
+
+func PatternMRFilter(pattern string) MRFilter {
+	return func(m IssuedMeteringRecord) bool { return m.Pattern == pattern }
+}
+
+// Returns the metering records sent at or after since and before until, in the order they were sent. An until of
+// zero means up to now.
+func FindMeteringRecords(db *bolt.DB, since uint64, until uint64, filters []MRFilter) ([]IssuedMeteringRecord, error) {
+	records := make([]IssuedMeteringRecord, 0, 10)
+
+	readErr := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(METERING_RECORDS))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		for k, v := c.Seek(meteringRecordTimeKey(since)); k != nil; k, v = c.Next() {
+			if until != 0 && bytes.Compare(k[:8], meteringRecordTimeKey(until)) >= 0 {
+				break
+			}
+
+			var m IssuedMeteringRecord
+			if err := json.Unmarshal(v, &m); err != nil {
+				glog.Errorf(AWlogString(fmt.Sprintf("unable to deserialize metering record %v: %v", string(v), err)))
+				continue
+			}
+
+			exclude := false
+			for _, filter := range filters {
+				if !filter(m) {
+					exclude = true
+					break
+				}
+			}
+			if !exclude {
+				records = append(records, m)
+			}
+		}
+		return nil
+	})
+
+	if readErr != nil {
+		return nil, readErr
+	}
+	return records, nil
+}
+
+// The tokens granted to the nodes of a pattern in an org, within a time window.
+type MeteringAggregate struct {
+	Org           string `json:"org"`
+	Pattern       string `json:"pattern,omitempty"`
+	WindowStart   uint64 `json:"window_start"` // the time of the first record when the records are not split into windows
+	WindowEnd     uint64 `json:"window_end"`   // the time of the last record when the records are not split into windows
+	Agreements    int    `json:"agreements"`
+	Notifications int    `json:"notifications"`
+	Amount        uint64 `json:"amount"` // the sum of the increments of the records
+}
+
+func (m MeteringAggregate) String() string {
+	return fmt.Sprintf("Org: %v, Pattern: %v, WindowStart: %v, WindowEnd: %v, Agreements: %v, Notifications: %v, Amount: %v",
+		m.Org, m.Pattern, m.WindowStart, m.WindowEnd, m.Agreements, m.Notifications, m.Amount)
+}
+
+// Add up the records by org and pattern, and by time window when windowS is not zero. The windows are aligned to
+// multiples of windowS since 1970, so that the windows of two exports line up. The aggregates are sorted by org,
+// pattern and window.
+func AggregateMeteringRecords(records []IssuedMeteringRecord, windowS uint64) []MeteringAggregate {
+
+	type aggregateKey struct {
+		org     string
+		pattern string
+		window  uint64
+	}
+
+	aggregates := make(map[aggregateKey]*MeteringAggregate)
+	agreements := make(map[aggregateKey]map[string]bool)
+	for _, m := range records {
+		key := aggregateKey{org: m.Org, pattern: m.Pattern}
+		if windowS != 0 {
+			key.window = m.Time - m.Time%windowS
+		}
+
+		a, ok := aggregates[key]
+		if !ok {
+			a = &MeteringAggregate{Org: m.Org, Pattern: m.Pattern, WindowStart: m.Time, WindowEnd: m.Time}
+			if windowS != 0 {
+				a.WindowStart = key.window
+				a.WindowEnd = key.window + windowS
+			}
+			aggregates[key] = a
+			agreements[key] = make(map[string]bool)
+		}
+
+		if windowS == 0 && m.Time > a.WindowEnd {
+			a.WindowEnd = m.Time
+		}
+		a.Notifications += 1
+		a.Amount += m.Increment
+		agreements[key][m.AgreementId] = true
+		a.Agreements = len(agreements[key])
+	}
+
+	result := make([]MeteringAggregate, 0, len(aggregates))
+	for _, a := range aggregates {
+		result = append(result, *a)
+	}
+	sort.Sort(MeteringAggregatesByOrg(result))
+	return result
+}
+
+type MeteringAggregatesByOrg []MeteringAggregate
+
+func (s MeteringAggregatesByOrg) Len() int {
+	return len(s)
+}
+
+func (s MeteringAggregatesByOrg) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s MeteringAggregatesByOrg) Less(i, j int) bool {
+	if s[i].Org != s[j].Org {
+		return s[i].Org < s[j].Org
+	} else if s[i].Pattern != s[j].Pattern {
+		return s[i].Pattern < s[j].Pattern
+	}
+	return s[i].WindowStart < s[j].WindowStart
+}
+
+// The CSV header and rows of the records, and of the aggregates. The times are in seconds since 1970, like the JSON.
+var MeteringRecordCSVHeader = []string{"time", "agreement_id", "protocol", "org", "pattern", "policy_name", "device_id", "amount", "increment", "missed_time", "start_time"}
+
+func (m IssuedMeteringRecord) CSVRow() []string {
+	return []string{strconv.FormatUint(m.Time, 10), m.AgreementId, m.Protocol, m.Org, m.Pattern, m.PolicyName, m.DeviceId,
+		strconv.FormatUint(m.Amount, 10), strconv.FormatUint(m.Increment, 10), strconv.FormatUint(m.MissedTime, 10), strconv.FormatUint(m.StartTime, 10)}
+}
+
+var MeteringAggregateCSVHeader = []string{"org", "pattern", "window_start", "window_end", "agreements", "notifications", "amount"}
+
+func (m MeteringAggregate) CSVRow() []string {
+	return []string{m.Org, m.Pattern, strconv.FormatUint(m.WindowStart, 10), strconv.FormatUint(m.WindowEnd, 10),
+		strconv.Itoa(m.Agreements), strconv.Itoa(m.Notifications), strconv.FormatUint(m.Amount, 10)}
+}
@@ -0,0 +1,110 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/open-horizon/anax/metering"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func Test_MeteringRecords(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "agbot-metering")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := bolt.Open(path.Join(dir, "agbot.db"), 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("unable to open db: %v", err)
+	}
+	defer db.Close()
+
+	now := uint64(time.Now().Unix())
+	start := now - now%3600 - 2*3600
+
+	ag1 := &Agreement{CurrentAgreementId: "ag1", Org: "myorg", Pattern: "myorg/pat1", PolicyName: "pol1", DeviceId: "myorg/node1"}
+	ag2 := &Agreement{CurrentAgreementId: "ag2", Org: "myorg", PolicyName: "pol2", DeviceId: "myorg/node2"}
+	ag3 := &Agreement{CurrentAgreementId: "ag3", Org: "otherorg", PolicyName: "pol3", DeviceId: "otherorg/node3"}
+
+	record := func(ag *Agreement, amount uint64, sent uint64) *IssuedMeteringRecord {
+		mn := &metering.MeteringNotification{Amount: amount, StartTime: start, CurrentTime: sent}
+		r, err := RecordMeteringNotification(db, ag, "Basic", mn, 90)
+		if err != nil {
+			t.Fatalf("unable to record metering notification: %v", err)
+		}
+		return r
+	}
+
+	// The increments are the tokens granted since the previous notification of the agreement.
+	if r := record(ag1, 10, start+100); r.Increment != 10 {
+		t.Errorf("the first record should have the whole amount as increment, has %v", r)
+	}
+	if r := record(ag1, 25, start+3700); r.Increment != 15 {
+		t.Errorf("the increment should be 15, record is %v", r)
+	}
+	record(ag2, 5, start+200)
+	record(ag2, 8, start+3800)
+	record(ag3, 7, start+3900)
+
+	if records, err := FindMeteringRecords(db, 0, 0, []MRFilter{}); err != nil {
+		t.Errorf("unable to find records, error %v", err)
+	} else if len(records) != 5 || records[0].AgreementId != "ag1" || records[1].AgreementId != "ag2" || records[4].AgreementId != "ag3" {
+		t.Errorf("the records should be in the order they were sent, are %v", records)
+	}
+
+	if records, err := FindMeteringRecords(db, start+3600, start+3850, []MRFilter{}); err != nil {
+		t.Errorf("unable to find records, error %v", err)
+	} else if len(records) != 2 || records[0].Time != start+3700 || records[1].Time != start+3800 {
+		t.Errorf("since should be inclusive and until exclusive, records are %v", records)
+	}
+
+	if records, err := FindMeteringRecords(db, 0, 0, []MRFilter{OrgMRFilter("myorg"), PatternMRFilter("myorg/pat1")}); err != nil {
+		t.Errorf("unable to find records, error %v", err)
+	} else if len(records) != 2 || records[0].AgreementId != "ag1" || records[1].AgreementId != "ag1" {
+		t.Errorf("only the records of ag1 should be found, are %v", records)
+	}
+
+	// Records older than the retention are removed when the next one is recorded.
+	record(ag3, 1, now-91*24*3600)
+	record(ag3, 9, start+4000)
+	if records, err := FindMeteringRecords(db, 0, 0, []MRFilter{OrgMRFilter("otherorg")}); err != nil {
+		t.Errorf("unable to find records, error %v", err)
+	} else if len(records) != 2 || records[0].Time != start+3900 || records[1].Time != start+4000 {
+		t.Errorf("the expired record should be removed, records are %v", records)
+	}
+}
+
+func Test_AggregateMeteringRecords(t *testing.T) {
+
+	records := []IssuedMeteringRecord{
+		{AgreementId: "ag1", Org: "myorg", Pattern: "pat1", Increment: 10, Time: 3700},
+		{AgreementId: "ag2", Org: "myorg", Pattern: "pat1", Increment: 5, Time: 3800},
+		{AgreementId: "ag1", Org: "myorg", Pattern: "pat1", Increment: 3, Time: 7300},
+		{AgreementId: "ag3", Org: "anotherorg", Increment: 7, Time: 7400},
+	}
+
+	all := AggregateMeteringRecords(records, 0)
+	if len(all) != 2 {
+		t.Fatalf("there should be an aggregate for each org and pattern, are %v", all)
+	} else if all[0].Org != "anotherorg" || all[0].Amount != 7 || all[0].WindowStart != 7400 || all[0].WindowEnd != 7400 {
+		t.Errorf("the first aggregate should be anotherorg, is %v", all[0])
+	} else if all[1].Amount != 18 || all[1].Agreements != 2 || all[1].Notifications != 3 || all[1].WindowStart != 3700 || all[1].WindowEnd != 7300 {
+		t.Errorf("the second aggregate should add up the records of pat1, is %v", all[1])
+	}
+
+	windows := AggregateMeteringRecords(records, 3600)
+	if len(windows) != 3 {
+		t.Fatalf("there should be an aggregate for each window, are %v", windows)
+	} else if windows[1].WindowStart != 3600 || windows[1].WindowEnd != 7200 || windows[1].Amount != 15 || windows[1].Agreements != 2 {
+		t.Errorf("the first window of pat1 should have 15 tokens, is %v", windows[1])
+	} else if windows[2].WindowStart != 7200 || windows[2].Amount != 3 || windows[2].Agreements != 1 {
+		t.Errorf("the second window of pat1 should have 3 tokens, is %v", windows[2])
+	}
+}
@@ -0,0 +1,257 @@
+package agreementbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"time"
+)
+
+// The agbot keeps a short history of its negotiations with each node: the outcome of its most recent proposals, and
+// counts of the outcomes of all of them. The agreement records are deleted some time after they are archived, the
+// history outlives them, so that a node's track record is known the next time it is found in a search and support
+// can see why a node keeps failing to make agreements.
+const NEGOTIATION_HISTORY = "negotiation_history"
+
+// The outcomes of a proposal. A proposal is proposed until the node replies, and accepted until the agreement is
+// finalized. The other outcomes are final.
+const NEGOTIATION_PROPOSED = "proposed"
+const NEGOTIATION_ACCEPTED = "accepted"
+const NEGOTIATION_FINALIZED = "finalized"
+const NEGOTIATION_REJECTED = "rejected"     // the node declined the proposal
+const NEGOTIATION_NO_REPLY = "no_reply"     // the node did not reply in time
+const NEGOTIATION_CANCELLED = "cancelled"   // the agbot cancelled the proposal before the node accepted it
+const NEGOTIATION_TERMINATED = "terminated" // the agreement ended after the node accepted it
+
+type Negotiation struct {
+	AgreementId   string `json:"agreement_id"`
+	Protocol      string `json:"protocol"`
+	PolicyName    string `json:"policy_name"`
+	Pattern       string `json:"pattern,omitempty"`
+	Outcome       string `json:"outcome"`
+	ReasonCode    uint   `json:"reason_code,omitempty"`   // the termination reason, once the negotiation ended
+	Reason        string `json:"reason,omitempty"`        // the description of the termination reason
+	DeclineStage  string `json:"decline_stage,omitempty"` // the step of the node's decision that failed, when the node said why it declined
+	ProposedTime  uint64 `json:"proposed_time"`
+	AcceptedTime  uint64 `json:"accepted_time,omitempty"`
+	FinalizedTime uint64 `json:"finalized_time,omitempty"`
+	EndedTime     uint64 `json:"ended_time,omitempty"`
+}
+
+func (n Negotiation) String() string {
+	return fmt.Sprintf("AgreementId: %v, Protocol: %v, PolicyName: %v, Pattern: %v, Outcome: %v, ReasonCode: %v, Reason: %v, DeclineStage: %v, ProposedTime: %v, AcceptedTime: %v, FinalizedTime: %v, EndedTime: %v",
+		n.AgreementId, n.Protocol, n.PolicyName, n.Pattern, n.Outcome, n.ReasonCode, n.Reason, n.DeclineStage, n.ProposedTime, n.AcceptedTime, n.FinalizedTime, n.EndedTime)
+}
+
+type NodeNegotiationHistory struct {
+	DeviceId     string        `json:"device_id"`
+	Proposals    int           `json:"proposals"` // the counts are for all the proposals made to the node, not just the ones in negotiations
+	Accepted     int           `json:"accepted"`
+	Rejected     int           `json:"rejected"`
+	NoReply      int           `json:"no_reply"`
+	Cancelled    int           `json:"cancelled"`
+	Terminated   int           `json:"terminated"`
+	Negotiations []Negotiation `json:"negotiations"` // the most recent proposals, newest first
+}
+
+func (h NodeNegotiationHistory) String() string {
+	return fmt.Sprintf("DeviceId: %v, Proposals: %v, Accepted: %v, Rejected: %v, NoReply: %v, Cancelled: %v, Terminated: %v, Negotiations: %v",
+		h.DeviceId, h.Proposals, h.Accepted, h.Rejected, h.NoReply, h.Cancelled, h.Terminated, h.Negotiations)
+}
+
+func (h *NodeNegotiationHistory) find(agreementId string) *Negotiation {
+	for i := range h.Negotiations {
+		if h.Negotiations[i].AgreementId == agreementId {
+			return &h.Negotiations[i]
+		}
+	}
+	return nil
+}
+
+// Returns the negotiation history of all the nodes the agbot has made proposals to.
+func FindNegotiationHistories(db *bolt.DB) ([]NodeNegotiationHistory, error) {
+	all := make([]NodeNegotiationHistory, 0, 10)
+
+	readErr := db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(NEGOTIATION_HISTORY)); b != nil {
+			return b.ForEach(func(k, v []byte) error {
+				var h NodeNegotiationHistory
+				if err := json.Unmarshal(v, &h); err != nil {
+					glog.Errorf(AWlogString(fmt.Sprintf("unable to deserialize negotiation history %v: %v", string(v), err)))
+				} else {
+					all = append(all, h)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+
+	if readErr != nil {
+		return nil, readErr
+	}
+	return all, nil
+}
+
+// Returns the negotiation history of a node, nil when the agbot has not made a proposal to it.
+func FindNegotiationHistory(db *bolt.DB, deviceId string) (*NodeNegotiationHistory, error) {
+	var h *NodeNegotiationHistory
+
+	readErr := db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(NEGOTIATION_HISTORY)); b == nil {
+			return nil
+		} else if v := b.Get([]byte(deviceId)); v == nil {
+			return nil
+		} else {
+			h = new(NodeNegotiationHistory)
+			if err := json.Unmarshal(v, h); err != nil {
+				return fmt.Errorf("Unable to deserialize negotiation history: %v", err)
+			}
+		}
+		return nil
+	})
+
+	if readErr != nil {
+		return nil, readErr
+	}
+	return h, nil
+}
+
+// Update the negotiation history of a node within a transaction. The history is created when the node doesn't
+// have one yet.
+func updateNegotiationHistory(db *bolt.DB, deviceId string, fn func(h *NodeNegotiationHistory)) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(NEGOTIATION_HISTORY))
+		if err != nil {
+			return err
+		}
+
+		h := NodeNegotiationHistory{DeviceId: deviceId, Negotiations: []Negotiation{}}
+		if v := b.Get([]byte(deviceId)); v != nil {
+			if err := json.Unmarshal(v, &h); err != nil {
+				return fmt.Errorf("Unable to deserialize negotiation history: %v", err)
+			}
+		}
+
+		fn(&h)
+
+		if serial, err := json.Marshal(h); err != nil {
+			return fmt.Errorf("Unable to serialize negotiation history %v: %v", h, err)
+		} else {
+			return b.Put([]byte(deviceId), serial)
+		}
+	})
+}
+
+// Record a proposal sent to a node. Only the limit most recent proposals are kept.
+func NegotiationProposed(db *bolt.DB, deviceId string, agreementId string, protocol string, policyName string, pattern string, limit int) error {
+	return updateNegotiationHistory(db, deviceId, func(h *NodeNegotiationHistory) {
+		n := Negotiation{
+			AgreementId:  agreementId,
+			Protocol:     protocol,
+			PolicyName:   policyName,
+			Pattern:      pattern,
+			Outcome:      NEGOTIATION_PROPOSED,
+			ProposedTime: uint64(time.Now().Unix()),
+		}
+		h.Proposals += 1
+		h.Negotiations = append([]Negotiation{n}, h.Negotiations...)
+		if limit > 0 && len(h.Negotiations) > limit {
+			h.Negotiations = h.Negotiations[:limit]
+		}
+	})
+}
+
+// Record that the node accepted a proposal.
+func NegotiationAccepted(db *bolt.DB, deviceId string, agreementId string) error {
+	return updateNegotiationHistory(db, deviceId, func(h *NodeNegotiationHistory) {
+		if n := h.find(agreementId); n != nil && n.AcceptedTime == 0 && n.EndedTime == 0 {
+			h.Accepted += 1
+			n.Outcome = NEGOTIATION_ACCEPTED
+			n.AcceptedTime = uint64(time.Now().Unix())
+		}
+	})
+}
+
+// Record that an agreement with the node is final.
+func NegotiationFinalized(db *bolt.DB, deviceId string, agreementId string) error {
+	return updateNegotiationHistory(db, deviceId, func(h *NodeNegotiationHistory) {
+		if n := h.find(agreementId); n != nil && n.FinalizedTime == 0 && n.EndedTime == 0 {
+			n.Outcome = NEGOTIATION_FINALIZED
+			n.FinalizedTime = uint64(time.Now().Unix())
+		}
+	})
+}
+
+// Record the end of a negotiation. The outcome is rejected or no_reply when the node declined the proposal or didn't
+// answer it, and otherwise terminated, which is recorded as cancelled when the node had not accepted the proposal.
+func NegotiationEnded(db *bolt.DB, deviceId string, agreementId string, outcome string, reasonCode uint, reason string, declineStage string) error {
+	return updateNegotiationHistory(db, deviceId, func(h *NodeNegotiationHistory) {
+		n := h.find(agreementId)
+		if n == nil || n.EndedTime != 0 {
+			return
+		}
+
+		if outcome == NEGOTIATION_TERMINATED && n.AcceptedTime == 0 {
+			outcome = NEGOTIATION_CANCELLED
+		}
+		switch outcome {
+		case NEGOTIATION_REJECTED:
+			h.Rejected += 1
+		case NEGOTIATION_NO_REPLY:
+			h.NoReply += 1
+		case NEGOTIATION_CANCELLED:
+			h.Cancelled += 1
+		default:
+			h.Terminated += 1
+		}
+
+		n.Outcome = outcome
+		n.ReasonCode = reasonCode
+		n.Reason = reason
+		n.DeclineStage = declineStage
+		n.EndedTime = uint64(time.Now().Unix())
+	})
+}
+
+// Remove the negotiation history of a node.
+func ClearNegotiationHistory(db *bolt.DB, deviceId string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(NEGOTIATION_HISTORY)); b == nil {
+			return nil
+		} else {
+			return b.Delete([]byte(deviceId))
+		}
+	})
+}
+
+// Remove the negotiation history of all the nodes.
+func ClearAllNegotiationHistories(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(NEGOTIATION_HISTORY)); b == nil {
+			return nil
+		} else {
+			return tx.DeleteBucket([]byte(NEGOTIATION_HISTORY))
+		}
+	})
+}
+
+// The outcome of a negotiation that ended with the termination reason.
+func negotiationOutcome(cph ConsumerProtocolHandler, reason uint) string {
+	switch reason {
+	case cph.GetTerminationCode(TERM_REASON_NEGATIVE_REPLY), cph.GetTerminationCode(TERM_REASON_NODE_AGREEMENT_LIMIT), cph.GetTerminationCode(TERM_REASON_NODE_MISSING_DEVICES), cph.GetTerminationCode(TERM_REASON_NODE_WORKLOAD_ROLLBACK):
+		return NEGOTIATION_REJECTED
+	case cph.GetTerminationCode(TERM_REASON_NO_REPLY):
+		return NEGOTIATION_NO_REPLY
+	default:
+		return NEGOTIATION_TERMINATED
+	}
+}
+
+// Log the error of recording a step of a negotiation. The history is only informational, the negotiation goes on.
+func noteNegotiation(err error, deviceId string, agreementId string, outcome string) {
+	if err != nil {
+		glog.Errorf(AWlogString(fmt.Sprintf("unable to record %v negotiation %v in the history of node %v, error: %v", outcome, agreementId, deviceId, err)))
+	}
+}
@@ -0,0 +1,79 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"github.com/boltdb/bolt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func Test_NegotiationHistory(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "agbot-negotiation")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := bolt.Open(path.Join(dir, "agbot.db"), 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("unable to open db: %v", err)
+	}
+	defer db.Close()
+
+	if h, err := FindNegotiationHistory(db, "myorg/node1"); err != nil || h != nil {
+		t.Errorf("a node without proposals should not have a history, has %v, error %v", h, err)
+	}
+
+	// A proposal that is accepted, finalized and later terminated.
+	NegotiationProposed(db, "myorg/node1", "ag1", "Basic", "pol1", "", 3)
+	NegotiationAccepted(db, "myorg/node1", "ag1")
+	NegotiationFinalized(db, "myorg/node1", "ag1")
+	if h, err := FindNegotiationHistory(db, "myorg/node1"); err != nil || h == nil {
+		t.Fatalf("unable to find the history, error %v", err)
+	} else if len(h.Negotiations) != 1 || h.Negotiations[0].Outcome != NEGOTIATION_FINALIZED || h.Negotiations[0].FinalizedTime == 0 || h.Accepted != 1 {
+		t.Errorf("ag1 should be finalized, history is %v", h)
+	}
+	NegotiationEnded(db, "myorg/node1", "ag1", NEGOTIATION_TERMINATED, 206, "agreement bot user requested", "")
+
+	// A declined proposal, one without a reply, and one cancelled before the node replied.
+	NegotiationProposed(db, "myorg/node1", "ag2", "Basic", "pol1", "", 3)
+	NegotiationEnded(db, "myorg/node1", "ag2", NEGOTIATION_REJECTED, 202, "agreement bot received negative reply", "properties")
+	NegotiationProposed(db, "myorg/node1", "ag3", "Basic", "pol1", "", 3)
+	NegotiationEnded(db, "myorg/node1", "ag3", NEGOTIATION_NO_REPLY, 201, "agreement bot never received reply to proposal", "")
+	NegotiationProposed(db, "myorg/node1", "ag4", "Basic", "pol1", "", 3)
+	NegotiationEnded(db, "myorg/node1", "ag4", NEGOTIATION_TERMINATED, 204, "agreement bot policy changed", "")
+
+	// Ending a negotiation again, or one that isn't in the history, changes nothing.
+	NegotiationEnded(db, "myorg/node1", "ag4", NEGOTIATION_REJECTED, 202, "agreement bot received negative reply", "")
+	NegotiationEnded(db, "myorg/node1", "ag9", NEGOTIATION_REJECTED, 202, "agreement bot received negative reply", "")
+
+	if h, err := FindNegotiationHistory(db, "myorg/node1"); err != nil || h == nil {
+		t.Fatalf("unable to find the history, error %v", err)
+	} else if h.Proposals != 4 || h.Accepted != 1 || h.Rejected != 1 || h.NoReply != 1 || h.Cancelled != 1 || h.Terminated != 1 {
+		t.Errorf("wrong counts in history %v", h)
+	} else if len(h.Negotiations) != 3 {
+		t.Errorf("only the 3 most recent proposals should be kept, history is %v", h)
+	} else if h.Negotiations[0].AgreementId != "ag4" || h.Negotiations[0].Outcome != NEGOTIATION_CANCELLED {
+		t.Errorf("ag4 should be first and cancelled, is %v", h.Negotiations[0])
+	} else if h.Negotiations[2].AgreementId != "ag2" || h.Negotiations[2].Outcome != NEGOTIATION_REJECTED || h.Negotiations[2].DeclineStage != "properties" || h.Negotiations[2].ReasonCode != 202 {
+		t.Errorf("ag2 should be last and rejected, is %v", h.Negotiations[2])
+	}
+
+	NegotiationProposed(db, "myorg/node2", "ag5", "Basic", "pol2", "mypattern", 3)
+	if all, err := FindNegotiationHistories(db); err != nil || len(all) != 2 {
+		t.Errorf("there should be 2 histories, are %v, error %v", all, err)
+	} else if err := ClearNegotiationHistory(db, "myorg/node1"); err != nil {
+		t.Errorf("unable to clear history: %v", err)
+	} else if h, err := FindNegotiationHistory(db, "myorg/node2"); err != nil || h == nil || h.Negotiations[0].Pattern != "mypattern" {
+		t.Errorf("only the history of node1 should be cleared, node2 has %v, error %v", h, err)
+	} else if err := ClearAllNegotiationHistories(db); err != nil {
+		t.Errorf("unable to clear histories: %v", err)
+	} else if all, err := FindNegotiationHistories(db); err != nil || len(all) != 0 {
+		t.Errorf("all the histories should be cleared, are %v, error %v", all, err)
+	}
+}
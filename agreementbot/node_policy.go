@@ -0,0 +1,45 @@
+package agreementbot
+
+import (
+	"errors"
+	"fmt"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/policy"
+)
+
+// The matching of nodes with business policies. A node that is not using a pattern gets the service of a business
+// policy when the properties of its node policy, and of the policies of its registered services, satisfy the
+// constraints of the business policy, and the properties of the business policy satisfy the constraints of the node
+// policy. The node's properties and constraints are added to the producer policy, so that the node checks the same
+// properties and constraints when it decides on the proposal.
+
+// Add the properties and constraints of the node policy to the producer policy.
+func addNodePolicy(producerPolicy *policy.Policy, nodePolicy *exchange.NodePolicy) {
+	if nodePolicy == nil {
+		return
+	}
+	(&producerPolicy.Properties).Concatenate(&nodePolicy.Properties)
+	if len(nodePolicy.Constraints) != 0 {
+		producerPolicy.CounterPartyProperties = *(&producerPolicy.CounterPartyProperties).Merge(&nodePolicy.Constraints)
+	}
+}
+
+// Check the constraints of the business policy against the node's properties and the node's constraints against the
+// properties of the business policy.
+func matchBusinessPolicy(producerPolicy *policy.Policy, consumerPolicy *policy.Policy) error {
+	if err := (&consumerPolicy.CounterPartyProperties).IsSatisfiedBy(producerPolicy.Properties); err != nil {
+		return errors.New(fmt.Sprintf("node properties %v do not satisfy the constraints %v of the business policy, error: %v", producerPolicy.Properties, consumerPolicy.CounterPartyProperties, err))
+	} else if err := (&producerPolicy.CounterPartyProperties).IsSatisfiedBy(consumerPolicy.Properties); err != nil {
+		return errors.New(fmt.Sprintf("business policy properties %v do not satisfy the constraints %v of the node, error: %v", consumerPolicy.Properties, producerPolicy.CounterPartyProperties, err))
+	}
+	return nil
+}
+
+// Replace the node's properties in the producer policy with the values the node attested to. The attested values win
+// over the node policy and the policies of the node's services, which are not signed by the node.
+func applyNodeAttestation(producerPolicy *policy.Policy, attestation *exchange.NodeAttestation) {
+	if attestation == nil {
+		return
+	}
+	producerPolicy.Properties = attestation.Apply(producerPolicy.Properties)
+}
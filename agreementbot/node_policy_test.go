@@ -0,0 +1,58 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"encoding/json"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/policy"
+	"testing"
+)
+
+func Test_matchBusinessPolicy(t *testing.T) {
+
+	var nodePolicy exchange.NodePolicy
+	if err := json.Unmarshal([]byte(`{"properties": [{"name": "purpose", "value": "test"}], "constraints": {"and": [{"name": "owner", "value": "iot-team"}]}}`), &nodePolicy); err != nil {
+		t.Fatalf("unable to unmarshal node policy: %v", err)
+	} else if err := nodePolicy.IsValid(); err != nil {
+		t.Fatalf("node policy should be valid: %v", err)
+	}
+
+	bp := getTestBusinessPolicy()
+	if err := json.Unmarshal([]byte(`{"and": [{"name": "purpose", "value": "test"}]}`), &bp.Constraints); err != nil {
+		t.Fatalf("unable to unmarshal constraints: %v", err)
+	}
+	bp.Properties = policy.PropertyList{{Name: "owner", Value: "iot-team"}}
+	consumerPolicy, err := exchange.ConvertBusinessPolicyToPolicy("myorg1/bp1", &bp)
+	if err != nil {
+		t.Fatalf("unable to convert business policy: %v", err)
+	}
+
+	// Without the node policy, the node has no properties.
+	producerPolicy := policy.Policy_Factory("producer")
+	if err := matchBusinessPolicy(producerPolicy, consumerPolicy); err == nil {
+		t.Errorf("a node without properties should not match the constraints of the business policy")
+	}
+
+	// Both sides match once the node policy is added, adding it again changes nothing.
+	addNodePolicy(producerPolicy, &nodePolicy)
+	addNodePolicy(producerPolicy, nil)
+	if err := matchBusinessPolicy(producerPolicy, consumerPolicy); err != nil {
+		t.Errorf("node should match the business policy, error %v", err)
+	} else if len(producerPolicy.Properties) != 1 {
+		t.Errorf("producer policy should have the node property, has %v", producerPolicy.Properties)
+	}
+
+	// The node's constraints are checked against the business policy properties.
+	consumerPolicy.Properties = policy.PropertyList{{Name: "owner", Value: "other-team"}}
+	if err := matchBusinessPolicy(producerPolicy, consumerPolicy); err == nil {
+		t.Errorf("business policy properties should not satisfy the constraints of the node")
+	}
+
+	// Invalid node policies are rejected.
+	if err := (&exchange.NodePolicy{Properties: policy.PropertyList{{Value: "noname"}}}).IsValid(); err == nil {
+		t.Errorf("a property without a name should be rejected")
+	} else if err := (&exchange.NodePolicy{Constraints: policy.RequiredProperty{"xor": []interface{}{}}}).IsValid(); err == nil {
+		t.Errorf("constraints with an unknown operator should be rejected")
+	}
+}
@@ -0,0 +1,168 @@
+package agreementbot
+
+import (
+	"fmt"
+	"github.com/open-horizon/anax/exchange"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The node search cache keeps the nodes found by the exchange search of each policy, so that the agbot does not search
+// the exchange for every policy on every pass through its agreement loop. The nodes of a policy are searched again
+// when they have been cached for longer than the TTL, when the policy changes (which is also how a changed pattern
+// shows up), or when the exchange's change feed reports that a node changed in one of the orgs the search covered.
+//
+// The cache holds the search results as they were returned. The nodes are checked for HA partitions, agreements in
+// progress and placement hooks after they are taken from the cache, the same as fresh search results are.
+
+type nodeSearchEntry struct {
+	devices []exchange.SearchResultDevice
+	orgs    []string // the orgs of the nodes that the search covered
+	expires time.Time
+}
+
+type NodeSearchCache struct {
+	lock      sync.Mutex
+	ttl       time.Duration
+	entries   map[string]*nodeSearchEntry // keyed by the search org and policy name
+	changeIds map[string]uint64           // the position in the node change feed of each org, zero when not known
+}
+
+// Returns a cache that holds the search results for ttlS seconds. A nil cache caches nothing, so callers dont have
+// to check whether the cache is configured.
+func NewNodeSearchCache(ttlS int) *NodeSearchCache {
+	if ttlS <= 0 {
+		return nil
+	}
+	return &NodeSearchCache{
+		ttl:       time.Duration(ttlS) * time.Second,
+		entries:   make(map[string]*nodeSearchEntry),
+		changeIds: make(map[string]uint64),
+	}
+}
+
+func (c *NodeSearchCache) String() string {
+	if c == nil {
+		return "NodeSearchCache: disabled"
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return fmt.Sprintf("NodeSearchCache TTL: %v, Entries: %v, ChangeIds: %v", c.ttl, len(c.entries), c.changeIds)
+}
+
+func nodeSearchKey(searchOrg string, policyName string) string {
+	return searchOrg + "/" + policyName
+}
+
+// Returns the cached search result of the policy, and false when there is none or it has expired.
+func (c *NodeSearchCache) Get(searchOrg string, policyName string) ([]exchange.SearchResultDevice, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry, ok := c.entries[nodeSearchKey(searchOrg, policyName)]
+	if !ok || !time.Now().Before(entry.expires) {
+		return nil, false
+	}
+	devices := make([]exchange.SearchResultDevice, len(entry.devices))
+	copy(devices, entry.devices)
+	return devices, true
+}
+
+// Cache the search result of the policy. The orgs are the orgs of the nodes that the search covered, a change to a
+// node in one of them drops the result.
+func (c *NodeSearchCache) Put(searchOrg string, policyName string, orgs []string, devices []exchange.SearchResultDevice) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// Drop the expired results on the way, the policies they belong to might be gone.
+	now := time.Now()
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+
+	cached := make([]exchange.SearchResultDevice, len(devices))
+	copy(cached, devices)
+	c.entries[nodeSearchKey(searchOrg, policyName)] = &nodeSearchEntry{
+		devices: cached,
+		orgs:    orgs,
+		expires: now.Add(c.ttl),
+	}
+}
+
+// Drop the cached search result of a policy that changed or was deleted.
+func (c *NodeSearchCache) InvalidatePolicy(searchOrg string, policyName string) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.entries, nodeSearchKey(searchOrg, policyName))
+}
+
+// Drop the cached search results that cover the nodes of an org in which a node changed.
+func (c *NodeSearchCache) InvalidateNodeOrg(org string) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for key, entry := range c.entries {
+		for _, o := range entry.orgs {
+			if o == org {
+				delete(c.entries, key)
+				break
+			}
+		}
+	}
+}
+
+// Returns the orgs of the nodes covered by the cached search results, in order.
+func (c *NodeSearchCache) NodeOrgs() []string {
+	if c == nil {
+		return []string{}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	orgMap := make(map[string]bool)
+	for _, entry := range c.entries {
+		for _, o := range entry.orgs {
+			orgMap[o] = true
+		}
+	}
+	orgs := make([]string, 0, len(orgMap))
+	for o, _ := range orgMap {
+		orgs = append(orgs, o)
+	}
+	sort.Strings(orgs)
+	return orgs
+}
+
+// Returns the position in the node change feed of an org, zero when it is not known.
+func (c *NodeSearchCache) GetChangeId(org string) uint64 {
+	if c == nil {
+		return 0
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.changeIds[org]
+}
+
+// Remember the position in the node change feed of an org.
+func (c *NodeSearchCache) SetChangeId(org string, changeId uint64) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.changeIds[org] = changeId
+}
@@ -0,0 +1,66 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"github.com/open-horizon/anax/exchange"
+	"testing"
+	"time"
+)
+
+func Test_NodeSearchCache(t *testing.T) {
+
+	if c := NewNodeSearchCache(0); c != nil {
+		t.Errorf("The cache should be off without a TTL, was %v", c)
+	} else if _, ok := c.Get("myorg", "mypolicy"); ok {
+		t.Errorf("A nil cache should not return a search result")
+	}
+
+	c := NewNodeSearchCache(60)
+	devices := []exchange.SearchResultDevice{{Id: "myorg/node1"}, {Id: "myorg/node2"}}
+
+	c.Put("myorg", "pattern1", []string{"myorg"}, devices)
+	c.Put("myorg", "business1", []string{"nodeorg1", "nodeorg2"}, devices[:1])
+
+	if found, ok := c.Get("myorg", "pattern1"); !ok || len(found) != 2 {
+		t.Errorf("The search result should be cached, was %v", found)
+	} else if found[0].Id = "changed"; devices[0].Id != "myorg/node1" {
+		t.Errorf("Changing a result should not change the cache")
+	} else if again, _ := c.Get("myorg", "pattern1"); again[0].Id != "myorg/node1" {
+		t.Errorf("Changing a result should not change the cache, was %v", again)
+	} else if orgs := c.NodeOrgs(); len(orgs) != 3 || orgs[0] != "myorg" || orgs[1] != "nodeorg1" {
+		t.Errorf("The node orgs should be myorg, nodeorg1 and nodeorg2, was %v", orgs)
+	}
+
+	// A node change drops the searches that covered its org.
+	c.InvalidateNodeOrg("nodeorg2")
+	if _, ok := c.Get("myorg", "business1"); ok {
+		t.Errorf("The business policy search should be dropped")
+	} else if _, ok := c.Get("myorg", "pattern1"); !ok {
+		t.Errorf("The pattern search should still be cached")
+	}
+
+	// A policy change drops the search of the policy.
+	c.InvalidatePolicy("myorg", "pattern1")
+	if _, ok := c.Get("myorg", "pattern1"); ok {
+		t.Errorf("The pattern search should be dropped")
+	}
+
+	// An expired search is not returned, and is removed when another search is cached.
+	c.Put("myorg", "pattern1", []string{"myorg"}, devices)
+	c.entries[nodeSearchKey("myorg", "pattern1")].expires = time.Now().Add(-time.Second)
+	if _, ok := c.Get("myorg", "pattern1"); ok {
+		t.Errorf("The expired search should not be returned")
+	}
+	c.Put("myorg", "pattern2", []string{"myorg"}, devices)
+	if len(c.entries) != 1 {
+		t.Errorf("The expired search should be removed, was %v", c.entries)
+	}
+
+	c.SetChangeId("myorg", 10)
+	if id := c.GetChangeId("myorg"); id != 10 {
+		t.Errorf("The change id should be 10, was %v", id)
+	} else if id := c.GetChangeId("nodeorg1"); id != 0 {
+		t.Errorf("The change id of an unknown org should be 0, was %v", id)
+	}
+}
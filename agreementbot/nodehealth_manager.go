@@ -6,6 +6,7 @@ import (
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/exchange"
+	"sync"
 	"time"
 )
 
@@ -18,6 +19,11 @@ import (
 // in pattern groups. That is, all the nodes using a given pattern are updated in the cache with one
 // call to the exchange. An agbot will only obtain node status info for patterns which are used
 // by agreements that it is managing.
+//
+// A node whose heartbeat is overdue can be given a grace of a number of node health checks before its agreements are
+// cancelled, for nodes on links that drop out for a while. The manager counts the checks on which the heartbeat was
+// missing for each node, once per update of the node info from the exchange, and forgets the count when the node
+// heartbeats again.
 
 type NodeHealthHandler func(pattern string, org string, lastCallTime string) (*exchange.NodeHealthStatus, error)
 
@@ -43,8 +49,16 @@ func NewNHPatternEntry() *NHPatternEntry {
 	return nh
 }
 
+type nhMissedHeartbeats struct {
+	key      string // The pattern entry of the node
+	count    int    // The number of checks on which the heartbeat was missing
+	lastCall string // The LastCallTime of the pattern entry when the heartbeat was last counted as missing
+}
+
 type NodeHealthManager struct {
-	Patterns map[string]*NHPatternEntry // A map of patterns for which this agbot has agreements
+	Patterns map[string]*NHPatternEntry     // A map of patterns for which this agbot has agreements
+	missed   map[string]*nhMissedHeartbeats // The nodes whose heartbeat is overdue, keyed by device id
+	lock     sync.Mutex                     // The agreements are governed by several goroutines
 }
 
 func (n *NodeHealthManager) String() string {
@@ -55,12 +69,15 @@ func (n *NodeHealthManager) String() string {
 func NewNodeHealthManager() *NodeHealthManager {
 	nh := &NodeHealthManager{
 		Patterns: make(map[string]*NHPatternEntry),
+		missed:   make(map[string]*nhMissedHeartbeats),
 	}
 	return nh
 }
 
 // Make sure the manager has the latest status info from the exchange.
 func (m *NodeHealthManager) SetUpdatedStatus(pattern string, org string, nhHandler NodeHealthHandler) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 
 	updatedAsOfNow := cutil.FormattedTime()
 	if lastCallTime, isUpdated := m.hasUpdatedStatus(pattern, org); !isUpdated {
@@ -76,6 +93,8 @@ func (m *NodeHealthManager) SetUpdatedStatus(pattern string, org string, nhHandl
 // Clear the Updated flag in each pattern entry so that future requests for status will first go the
 // exchange to get any updates.
 func (m *NodeHealthManager) ResetUpdateStatus() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 	for _, pe := range m.Patterns {
 		pe.Updated = false
 	}
@@ -84,6 +103,46 @@ func (m *NodeHealthManager) ResetUpdateStatus() {
 // Determine if the input node's heartbeat is overdue, i.e. beyond the policy interval. Return false (not
 // out of policy) if the agrement is still present.
 func (m *NodeHealthManager) NodeOutOfPolicy(pattern string, org string, deviceId string, interval int) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.nodeOutOfPolicy(pattern, org, deviceId, interval)
+}
+
+// Determine if the input node's heartbeat has been overdue on more than graceChecks node health checks. Return false
+// (not out of policy) while the node is within its grace, or when its heartbeat is not overdue.
+func (m *NodeHealthManager) NodeOutOfPolicyAfterGrace(pattern string, org string, deviceId string, interval int, graceChecks int) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if !m.nodeOutOfPolicy(pattern, org, deviceId, interval) {
+		delete(m.missed, deviceId)
+		return false
+	} else if graceChecks <= 0 {
+		return true
+	}
+
+	key := getKey(pattern, org)
+	pe, ok := m.Patterns[key]
+	if !ok {
+		return true
+	}
+
+	// The node's agreements are all checked after the same update, the heartbeat is counted once for all of them.
+	missed, ok := m.missed[deviceId]
+	if !ok {
+		missed = &nhMissedHeartbeats{key: key}
+		m.missed[deviceId] = missed
+	}
+	if missed.lastCall != pe.LastCallTime {
+		missed.count += 1
+		missed.lastCall = pe.LastCallTime
+		glog.V(3).Infof("Node Health Manager: heartbeat of %v missed on %v checks, grace is %v checks", deviceId, missed.count, graceChecks)
+	}
+
+	return missed.count > graceChecks
+}
+
+func (m *NodeHealthManager) nodeOutOfPolicy(pattern string, org string, deviceId string, interval int) bool {
 
 	key := getKey(pattern, org)
 	if pe, ok := m.Patterns[key]; !ok {
@@ -104,6 +163,8 @@ func (m *NodeHealthManager) NodeOutOfPolicy(pattern string, org string, deviceId
 // Determine if the input agreement id is still present in the exchange. Return false (not out of policy)
 // if the agreement is still present.
 func (m *NodeHealthManager) AgreementOutOfPolicy(pattern string, org string, deviceId string, agreementId string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 
 	key := getKey(pattern, org)
 	if pe, ok := m.Patterns[key]; !ok {
@@ -145,6 +206,14 @@ func (m *NodeHealthManager) setNewStatus(pattern string, org string, lastCall st
 		m.Patterns[key] = pe
 	}
 
+	// Forget the missed heartbeats that were not counted since the previous update, the agreements of those nodes
+	// are gone.
+	for deviceId, missed := range m.missed {
+		if missed.key == key && missed.lastCall != pe.LastCallTime {
+			delete(m.missed, deviceId)
+		}
+	}
+
 	// Save cache update metadata
 	pe.LastCallTime = lastCall
 	pe.Updated = true
@@ -267,3 +267,59 @@ func getNoUpdateStatusHandler(node string, agreementId string, lastHB string) fu
 		return o, nil
 	}
 }
+
+func Test_NodeHealthStatus_grace(t *testing.T) {
+
+	nhm := NewNodeHealthManager()
+
+	mypattern := "mypattern"
+	myorg := "theorg"
+	mynode := "org/node1"
+	agid := "ag1"
+	oldHB := "2006-01-02T15:04:05.999Z[UTC]"
+
+	update := func(lastCall string, lastHB string) {
+		nhs, _ := getVariableStatusHandler(mynode, agid, lastHB)(mypattern, myorg, "")
+		nhm.setNewStatus(mypattern, myorg, lastCall, nhs)
+	}
+
+	// The heartbeat is missing on the first 2 checks, the agreements of the node are checked more than once per update.
+	update("call1", oldHB)
+	if op := nhm.NodeOutOfPolicyAfterGrace(mypattern, myorg, mynode, 300, 2); op {
+		t.Errorf("node %v should be within its grace on the first check, %v", mynode, nhm)
+	} else if op := nhm.NodeOutOfPolicyAfterGrace(mypattern, myorg, mynode, 300, 2); op {
+		t.Errorf("node %v should be counted once per update, %v", mynode, nhm)
+	}
+
+	update("call2", oldHB)
+	if op := nhm.NodeOutOfPolicyAfterGrace(mypattern, myorg, mynode, 300, 2); op {
+		t.Errorf("node %v should be within its grace on the second check, %v", mynode, nhm)
+	}
+
+	// The node heartbeats again, the count starts over.
+	update("call3", cutil.FormattedTime())
+	if op := nhm.NodeOutOfPolicyAfterGrace(mypattern, myorg, mynode, 300, 2); op {
+		t.Errorf("node %v heartbeat is not overdue, %v", mynode, nhm)
+	} else if _, ok := nhm.missed[mynode]; ok {
+		t.Errorf("missed heartbeats of node %v should be forgotten, %v", mynode, nhm.missed)
+	}
+
+	for i, call := range []string{"call4", "call5", "call6"} {
+		update(call, oldHB)
+		if op := nhm.NodeOutOfPolicyAfterGrace(mypattern, myorg, mynode, 300, 2); op != (i == 2) {
+			t.Errorf("node %v out of policy should be %v on check %v, %v", mynode, i == 2, i+1, nhm.missed[mynode])
+		}
+	}
+
+	// Without a grace the node is out of policy on the first check.
+	if op := nhm.NodeOutOfPolicyAfterGrace(mypattern, myorg, "org/node2", 300, 0); !op {
+		t.Errorf("node org/node2 was not detected as out of policy %v", nhm)
+	}
+
+	// A node that is no longer checked is forgotten after the next update.
+	update("call7", oldHB)
+	update("call8", oldHB)
+	if len(nhm.missed) != 0 {
+		t.Errorf("missed heartbeats should be forgotten, are %v", nhm.missed)
+	}
+}
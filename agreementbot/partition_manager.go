@@ -0,0 +1,171 @@
+package agreementbot
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+// Agbots that serve the same patterns are configured as a group of HA peers, so that they don't all make agreements
+// with the same nodes. The nodes are split into partitions by a hash of their id, and each partition is leased to one
+// live peer of the group. Only the owner of a node's partition makes agreements with the node. The exchange is the
+// database that all the peers share: a peer holds its leases by heartbeating to the exchange, and its leases expire
+// when its last heartbeat is older than HALeaseS. The partitions of an expired peer are taken over by the live peers,
+// and they go back to it when it heartbeats again. A partition is leased to the live peer with the highest rendezvous
+// hash for the partition, so the peers agree on the owners without talking to each other, and only the partitions of
+// a peer that expires or comes back change owner.
+type PartitionManager struct {
+	self       string          // the exchange id of this agbot
+	peers      []string        // the exchange ids of all the agbots in the group, including this one, sorted
+	partitions int             // the number of partitions the nodes are split into
+	leaseS     int             // how long a peer's lease lasts after its last heartbeat
+	live       map[string]bool // the peers whose lease has not expired
+	owned      map[int]bool    // the partitions leased to this agbot
+	lock       sync.RWMutex
+}
+
+func NewPartitionManager(self string, peers []string, partitions int, leaseS int) *PartitionManager {
+	group := []string{self}
+	for _, peer := range peers {
+		if peer != self {
+			group = append(group, peer)
+		}
+	}
+	sort.Strings(group)
+
+	// Until the leases are first read, all the peers are assumed to be live, so that no node is searched by two of
+	// them while this agbot starts.
+	live := make(map[string]bool)
+	for _, peer := range group {
+		live[peer] = true
+	}
+
+	pm := &PartitionManager{
+		self:       self,
+		peers:      group,
+		partitions: partitions,
+		leaseS:     leaseS,
+		live:       live,
+	}
+	pm.owned = pm.ownedPartitions()
+	return pm
+}
+
+func (pm *PartitionManager) String() string {
+	pm.lock.RLock()
+	defer pm.lock.RUnlock()
+	return fmt.Sprintf("Self: %v, Peers: %v, Partitions: %v, LeaseS: %v, Live: %v, Owned: %v", pm.self, pm.peers, pm.partitions, pm.leaseS, pm.live, len(pm.owned))
+}
+
+// Partitioning is only done when the agbot has HA peers.
+func (pm *PartitionManager) Enabled() bool {
+	return pm != nil && len(pm.peers) > 1 && pm.partitions > 0
+}
+
+// The other agbots in the group.
+func (pm *PartitionManager) Peers() []string {
+	peers := make([]string, 0, len(pm.peers))
+	for _, peer := range pm.peers {
+		if peer != pm.self {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// Returns the partition of the node.
+func (pm *PartitionManager) Partition(nodeId string) int {
+	h := fnv.New32a()
+	h.Write([]byte(nodeId))
+	return int(h.Sum32() % uint32(pm.partitions))
+}
+
+// Returns true when this agbot should make agreements with the node, which is always the case without HA peers.
+func (pm *PartitionManager) Owns(nodeId string) bool {
+	if !pm.Enabled() {
+		return true
+	}
+	pm.lock.RLock()
+	defer pm.lock.RUnlock()
+	return pm.owned[pm.Partition(nodeId)]
+}
+
+// Returns the peer that holds the lease of the partition.
+func (pm *PartitionManager) Owner(partition int) string {
+	pm.lock.RLock()
+	defer pm.lock.RUnlock()
+	return pm.owner(partition)
+}
+
+func (pm *PartitionManager) owner(partition int) string {
+	var owner string
+	var best uint64
+	for _, peer := range pm.peers {
+		if !pm.live[peer] {
+			continue
+		}
+		h := fnv.New64a()
+		h.Write([]byte(peer))
+		if score := mix64(h.Sum64() ^ mix64(uint64(partition))); owner == "" || score > best {
+			owner, best = peer, score
+		}
+	}
+	return owner
+}
+
+// The splitmix64 finalizer. The fnv hashes of peer names that only differ in their last characters are close to
+// each other, mixing them spreads the partitions evenly between the peers.
+func mix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+func (pm *PartitionManager) ownedPartitions() map[int]bool {
+	owned := make(map[int]bool)
+	for p := 0; p < pm.partitions; p++ {
+		if pm.owner(p) == pm.self {
+			owned[p] = true
+		}
+	}
+	return owned
+}
+
+// Expire the leases of the peers whose last heartbeat, in seconds, is older than the lease, and hand their partitions
+// to the live peers. A peer that is missing from the heartbeats keeps its previous state, because it could not be
+// read. This agbot's own lease never expires, it is running. Returns the partitions this agbot gained and lost.
+func (pm *PartitionManager) UpdateLeases(lastHeartbeats map[string]int64, now int64) ([]int, []int) {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	for _, peer := range pm.peers {
+		if peer == pm.self {
+			continue
+		} else if lastHB, ok := lastHeartbeats[peer]; ok {
+			live := lastHB+int64(pm.leaseS) > now
+			if live != pm.live[peer] {
+				if live {
+					glog.Infof(AWlogString(fmt.Sprintf("HA peer %v is heartbeating again, it takes back its partitions", peer)))
+				} else {
+					glog.Warningf(AWlogString(fmt.Sprintf("lease of HA peer %v expired, its last heartbeat was %v seconds ago, taking over its partitions", peer, now-lastHB)))
+				}
+			}
+			pm.live[peer] = live
+		}
+	}
+
+	owned := pm.ownedPartitions()
+	gained, lost := make([]int, 0), make([]int, 0)
+	for p := 0; p < pm.partitions; p++ {
+		if owned[p] && !pm.owned[p] {
+			gained = append(gained, p)
+		} else if !owned[p] && pm.owned[p] {
+			lost = append(lost, p)
+		}
+	}
+	pm.owned = owned
+	return gained, lost
+}
@@ -0,0 +1,119 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test_PartitionManager_disabled(t *testing.T) {
+
+	var nilPM *PartitionManager
+	if nilPM.Enabled() || !nilPM.Owns("myorg/node1") {
+		t.Errorf("without a partition manager every node should be owned")
+	}
+
+	pm := NewPartitionManager("myorg/agbot1", []string{"myorg/agbot1"}, 64, 180)
+	if pm.Enabled() {
+		t.Errorf("partitioning should be off without HA peers")
+	}
+	for i := 0; i < 100; i++ {
+		if !pm.Owns(fmt.Sprintf("myorg/node%v", i)) {
+			t.Errorf("without HA peers every node should be owned")
+		}
+	}
+}
+
+// Every partition is owned by exactly one of the peers that share the same view of the leases.
+func Test_PartitionManager_ownership(t *testing.T) {
+
+	peers := []string{"myorg/agbot1", "myorg/agbot2", "myorg/agbot3"}
+	pms := make([]*PartitionManager, 0, len(peers))
+	for _, peer := range peers {
+		pm := NewPartitionManager(peer, peers, 64, 180)
+		if !pm.Enabled() {
+			t.Fatalf("partitioning should be on with HA peers")
+		} else if len(pm.Peers()) != 2 {
+			t.Errorf("%v should have 2 peers, has %v", peer, pm.Peers())
+		}
+		pms = append(pms, pm)
+	}
+
+	for i := 0; i < 500; i++ {
+		node := fmt.Sprintf("myorg/node%v", i)
+		owners := 0
+		for _, pm := range pms {
+			if pm.Owns(node) {
+				owners += 1
+			}
+		}
+		if owners != 1 {
+			t.Errorf("node %v should be owned by exactly 1 agbot, is owned by %v", node, owners)
+		}
+	}
+
+	for _, pm := range pms {
+		if len(pm.owned) == 0 {
+			t.Errorf("%v should own some of the partitions", pm.self)
+		}
+	}
+}
+
+// The partitions of a peer whose lease expires are taken over by the live peers, and go back to it when it
+// heartbeats again. The partitions of the other peers don't move.
+func Test_PartitionManager_takeover(t *testing.T) {
+
+	peers := []string{"myorg/agbot1", "myorg/agbot2", "myorg/agbot3"}
+	pm1 := NewPartitionManager("myorg/agbot1", peers, 64, 180)
+	pm2 := NewPartitionManager("myorg/agbot2", peers, 64, 180)
+
+	now := int64(100000)
+	allLive := map[string]int64{"myorg/agbot1": now - 10, "myorg/agbot2": now - 10, "myorg/agbot3": now - 10}
+	if gained, lost := pm1.UpdateLeases(allLive, now); len(gained) != 0 || len(lost) != 0 {
+		t.Errorf("nothing should change while all the peers are live, gained %v lost %v", gained, lost)
+	}
+	pm2.UpdateLeases(allLive, now)
+
+	before := make(map[int]string)
+	for p := 0; p < 64; p++ {
+		before[p] = pm1.Owner(p)
+	}
+
+	// agbot3 stops heartbeating.
+	agbot3Down := map[string]int64{"myorg/agbot1": now - 10, "myorg/agbot2": now - 10, "myorg/agbot3": now - 200}
+	gained1, lost1 := pm1.UpdateLeases(agbot3Down, now)
+	gained2, _ := pm2.UpdateLeases(agbot3Down, now)
+	if len(lost1) != 0 {
+		t.Errorf("agbot1 should not lose partitions when agbot3 goes down, lost %v", lost1)
+	}
+	for _, p := range append(gained1, gained2...) {
+		if before[p] != "myorg/agbot3" {
+			t.Errorf("only the partitions of agbot3 should move, partition %v belonged to %v", p, before[p])
+		}
+	}
+	for p := 0; p < 64; p++ {
+		if owner := pm1.Owner(p); owner == "myorg/agbot3" {
+			t.Errorf("partition %v should not belong to expired agbot3", p)
+		} else if before[p] != "myorg/agbot3" && owner != before[p] {
+			t.Errorf("partition %v should still belong to %v, belongs to %v", p, before[p], owner)
+		} else if (owner == "myorg/agbot1") != pm1.owned[p] || (owner == "myorg/agbot2") != pm2.owned[p] {
+			t.Errorf("partition %v should be owned by exactly one of agbot1 and agbot2", p)
+		}
+	}
+
+	// A peer whose heartbeat could not be read keeps its lease state.
+	if gained, lost := pm1.UpdateLeases(map[string]int64{}, now); len(gained) != 0 || len(lost) != 0 {
+		t.Errorf("nothing should change when the heartbeats could not be read, gained %v lost %v", gained, lost)
+	}
+
+	// agbot3 comes back and gets its partitions back.
+	if _, lost := pm1.UpdateLeases(allLive, now); len(lost) != len(gained1) {
+		t.Errorf("agbot1 should give back the %v partitions it took over, gave back %v", len(gained1), len(lost))
+	}
+	for p := 0; p < 64; p++ {
+		if pm1.Owner(p) != before[p] {
+			t.Errorf("partition %v should belong to %v again, belongs to %v", p, before[p], pm1.Owner(p))
+		}
+	}
+}
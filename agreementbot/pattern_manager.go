@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/exchange"
-	"github.com/open-horizon/anax/policy"
 	"golang.org/x/crypto/sha3"
 	"time"
 )
@@ -17,26 +16,34 @@ type PatternEntry struct {
 	Updated         uint64            `json:"updatedTime,omitempty"`     // the time when this entry was updated
 	Hash            []byte            `json:"hash,omitempty"`            // a hash of the current entry to compare for matadata changes in the exchange
 	PolicyFileNames []string          `json:"policyFileNames,omitempty"` // the list of policy names generated for this pattern
+	Generation      string            `json:"generation,omitempty"`      // the generation of the policy files, when they are files
 }
 
 func (p *PatternEntry) String() string {
 	return fmt.Sprintf("Pattern Entry: "+
 		"Updated: %v "+
 		"Hash: %x "+
-		"Files: %v"+
+		"Files: %v "+
+		"Generation: %v "+
 		"Pattern: %v",
-		p.Updated, p.Hash, p.PolicyFileNames, p.Pattern)
+		p.Updated, p.Hash, p.PolicyFileNames, p.Generation, p.Pattern)
 }
 
 func (p *PatternEntry) ShortString() string {
-	return fmt.Sprintf("Files: %v", p.PolicyFileNames)
+	return fmt.Sprintf("Files: %v Generation: %v", p.PolicyFileNames, p.Generation)
 }
 
 func hashPattern(p *exchange.Pattern) ([]byte, error) {
-	if ps, err := json.Marshal(p); err != nil {
-		return nil, errors.New(fmt.Sprintf("unable to marshal pattern %v to a string, error %v", p, err))
+	return hashServedResource(p)
+}
+
+// Hash the exchange metadata of a served resource (a pattern or a business policy) so that changes to the metadata
+// can be detected.
+func hashServedResource(r interface{}) ([]byte, error) {
+	if rs, err := json.Marshal(r); err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to marshal %v to a string, error %v", r, err))
 	} else {
-		hash := sha3.Sum256([]byte(ps))
+		hash := sha3.Sum256([]byte(rs))
 		return hash[:], nil
 	}
 }
@@ -58,10 +65,28 @@ func (pe *PatternEntry) AddPolicyFileName(fileName string) {
 	pe.PolicyFileNames = append(pe.PolicyFileNames, fileName)
 }
 
-func (pe *PatternEntry) DeleteAllPolicyFiles(policyPath string, org string) error {
+func (pe *PatternEntry) DeleteAllPolicyFiles(store PatternPolicyStore, org string) error {
 
 	for _, fileName := range pe.PolicyFileNames {
-		if err := policy.DeletePolicyFile(fileName); err != nil {
+		if err := store.DeletePolicy(fileName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete the policies of a previous generation that are not in the current one. The in-memory store reuses the
+// names of the policies that are still generated.
+func (pe *PatternEntry) DeleteReplacedPolicyFiles(store PatternPolicyStore, oldFileNames []string) error {
+
+	current := make(map[string]bool)
+	for _, fileName := range pe.PolicyFileNames {
+		current[fileName] = true
+	}
+	for _, fileName := range oldFileNames {
+		if current[fileName] {
+			continue
+		} else if err := store.DeletePolicy(fileName); err != nil {
 			return err
 		}
 	}
@@ -75,8 +100,20 @@ func (pe *PatternEntry) UpdateEntry(pattern *exchange.Pattern, newHash []byte) {
 	pe.PolicyFileNames = make([]string, 0, 10)
 }
 
+// The position of the PatternManager in an org's exchange change feed.
+type OrgChangeState struct {
+	ChangeId     uint64 `json:"changeId,omitempty"`     // the most recent exchange change id that has been processed
+	LastFullSync uint64 `json:"lastFullSync,omitempty"` // the time when all the patterns in the org were last read from the exchange
+}
+
+func (o *OrgChangeState) String() string {
+	return fmt.Sprintf("ChangeId: %v, LastFullSync: %v", o.ChangeId, o.LastFullSync)
+}
+
 type PatternManager struct {
 	OrgPatterns map[string]map[string]*PatternEntry
+	OrgChanges  map[string]*OrgChangeState
+	PolicyStore PatternPolicyStore // where the policies generated from the patterns are kept
 }
 
 func (p *PatternManager) String() string {
@@ -108,6 +145,8 @@ func (p *PatternManager) ShortString() string {
 func NewPatternManager() *PatternManager {
 	pm := &PatternManager{
 		OrgPatterns: make(map[string]map[string]*PatternEntry),
+		OrgChanges:  make(map[string]*OrgChangeState),
+		PolicyStore: &FilePolicyStore{},
 	}
 	return pm
 }
@@ -192,14 +231,15 @@ func (pm *PatternManager) SetCurrentPatterns(servedPatterns map[string]exchange.
 }
 
 // Create all the policy files for the input pattern
-func createPolicyFiles(pe *PatternEntry, patternId string, pattern *exchange.Pattern, policyPath string, org string) error {
+func createPolicyFiles(store PatternPolicyStore, pe *PatternEntry, patternId string, pattern *exchange.Pattern, policyPath string, org string) error {
 	if policies, err := exchange.ConvertToPolicies(patternId, pattern); err != nil {
 		return errors.New(fmt.Sprintf("error converting pattern to policies, error %v", err))
 	} else {
-		for _, pol := range policies {
-			if fileName, err := policy.CreatePolicyFile(policyPath, org, pol.Header.Name, pol); err != nil {
-				return errors.New(fmt.Sprintf("error creating policy file, error %v", err))
-			} else {
+		if generation, fileNames, err := store.AddPatternPolicies(policyPath, org, patternId, policies); err != nil {
+			return errors.New(fmt.Sprintf("error creating policy files, error %v", err))
+		} else {
+			pe.Generation = generation
+			for _, fileName := range fileNames {
 				pe.AddPolicyFileName(fileName)
 			}
 		}
@@ -246,57 +286,142 @@ func (pm *PatternManager) UpdatePatternPolicies(org string, definedPatterns map[
 
 	// For each defined pattern, update it in the new PatternManager map
 	for patternId, pattern := range definedPatterns {
-		// If the PatternManager knows about this pattern, then its because this agbot is configured to serve it.
-		if pm.hasPattern(org, exchange.GetId(patternId)) {
-
-			// There might not be a PatternEntry for this pattern yet because the pattern might have just been
-			// discovered by the query of the agbot config. If there's no PatternEntry yet, create one and then
-			// create the policy files.
-			if pe := pm.OrgPatterns[org][exchange.GetId(patternId)]; pe == nil {
-				if newPE, err := NewPatternEntry(&pattern); err != nil {
-					return errors.New(fmt.Sprintf("unable to create pattern entry for %v, error %v", pattern, err))
-				} else {
-					pm.OrgPatterns[org][exchange.GetId(patternId)] = newPE
-					glog.V(5).Infof("Creating the policy files for pattern %v.", patternId)
-					if err := createPolicyFiles(newPE, patternId, &pattern, policyPath, org); err != nil {
-						return errors.New(fmt.Sprintf("unable to create policy files for %v, error %v", pattern, err))
-					}
-				}
-			} else {
-				// The PatternEntry was already there, so check if the pattern definition has changed.
-				// If the pattern has changed, recreate all policy files. Otherwise the pattern
-				// definition we have is current.
-				newHash, err := hashPattern(&pattern)
-				if err != nil {
-					return errors.New(fmt.Sprintf("unable to hash pattern %v for %v, error %v", pattern, org, err))
-				}
-				if !bytes.Equal(pe.Hash, newHash) {
-					glog.V(5).Infof("Deleting all the policy files for org %v because the old pattern %v does not match the new pattern %v", org, pe.Pattern, pattern)
-					if err := pe.DeleteAllPolicyFiles(policyPath, org); err != nil {
-						return errors.New(fmt.Sprintf("unable to delete policy files for %v, error %v", org, err))
-					}
-					pe.UpdateEntry(&pattern, newHash)
-					glog.V(5).Infof("Creating the policy files for pattern %v.", patternId)
-					if err := createPolicyFiles(pe, patternId, &pattern, policyPath, org); err != nil {
-						return errors.New(fmt.Sprintf("unable to create policy files for %v, error %v", pattern, err))
-					}
-				}
+		if err := pm.updatePattern(org, patternId, pattern, policyPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Apply the pattern changes from the exchange change feed for an org. Only the patterns that changed are passed in,
+// the patterns that were deleted from the exchange are identified by the change list.
+func (pm *PatternManager) UpdateChangedPatternPolicies(org string, changes []exchange.ResourceChange, changedPatterns map[string]exchange.Pattern, policyPath string) error {
+
+	// Exit early on error
+	if !pm.hasOrg(org) {
+		return errors.New(fmt.Sprintf("org %v not found in pattern manager", org))
+	}
+
+	for _, change := range changes {
+		if change.Resource != exchange.CHANGE_RESOURCE_PATTERN || exchange.GetOrg(change.Id) != org || !pm.hasPattern(org, exchange.GetId(change.Id)) {
+			continue
+		}
+
+		// A pattern that was changed and then deleted is not returned by the exchange, so it is treated as deleted.
+		if pattern, ok := changedPatterns[change.Id]; change.Operation == exchange.CHANGE_OPERATION_DELETED || !ok {
+			glog.V(5).Infof("Deletinging pattern %v and its policy files from the org %v from the pattern manager because the pattern no longer exists.", change.Id, org)
+			if err := pm.deletePattern(policyPath, org, exchange.GetId(change.Id)); err != nil {
+				return err
 			}
+		} else if err := pm.updatePattern(org, change.Id, pattern, policyPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Create or update the PatternEntry and policy files of a single pattern.
+func (pm *PatternManager) updatePattern(org string, patternId string, pattern exchange.Pattern, policyPath string) error {
+
+	// If the PatternManager does not know the pattern then the agbot is not configured to serve this pattern.
+	// We can safely ignore the pattern.
+	if !pm.hasPattern(org, exchange.GetId(patternId)) {
+		return nil
+	}
+
+	// There might not be a PatternEntry for this pattern yet because the pattern might have just been
+	// discovered by the query of the agbot config. If there's no PatternEntry yet, create one and then
+	// create the policy files.
+	if pe := pm.OrgPatterns[org][exchange.GetId(patternId)]; pe == nil {
+		if newPE, err := NewPatternEntry(&pattern); err != nil {
+			return errors.New(fmt.Sprintf("unable to create pattern entry for %v, error %v", pattern, err))
 		} else {
-			// The PatternManager does not know the pattern therefore the agbot is not configured to serve this pattern.
-			// We can safely ignore the pattern.
+			pm.OrgPatterns[org][exchange.GetId(patternId)] = newPE
+			glog.V(5).Infof("Creating the policy files for pattern %v.", patternId)
+			if err := createPolicyFiles(pm.PolicyStore, newPE, patternId, &pattern, policyPath, org); err != nil {
+				return errors.New(fmt.Sprintf("unable to create policy files for %v, error %v", pattern, err))
+			}
+		}
+	} else {
+		// The PatternEntry was already there, so check if the pattern definition has changed.
+		// If the pattern has changed, recreate all policy files. Otherwise the pattern
+		// definition we have is current.
+		newHash, err := hashPattern(&pattern)
+		if err != nil {
+			return errors.New(fmt.Sprintf("unable to hash pattern %v for %v, error %v", pattern, org, err))
+		}
+		// The new policy files are created before the old ones are deleted, so that the policies of the pattern
+		// are replaced all at once.
+		if !bytes.Equal(pe.Hash, newHash) {
+			glog.V(5).Infof("Replacing the policy files for pattern %v because the old pattern %v does not match the new pattern %v", patternId, pe.Pattern, pattern)
+			oldFileNames := pe.PolicyFileNames
+			pe.UpdateEntry(&pattern, newHash)
+			if err := createPolicyFiles(pm.PolicyStore, pe, patternId, &pattern, policyPath, org); err != nil {
+				// The old generation is still current. Keep its files so they are deleted by the next generation,
+				// and forget the hash so that the next generation is attempted again.
+				pe.PolicyFileNames = oldFileNames
+				pe.Hash = nil
+				return errors.New(fmt.Sprintf("unable to create policy files for %v, error %v", pattern, err))
+			}
+			if err := pe.DeleteReplacedPolicyFiles(pm.PolicyStore, oldFileNames); err != nil {
+				return errors.New(fmt.Sprintf("unable to delete the replaced policy files for %v, error %v", patternId, err))
+			}
 		}
 	}
 
 	return nil
 }
 
+// Returns true when all the patterns in the org have to be read from the exchange. This is the case when the org has
+// not been fully read yet, when there is a newly served pattern that has no PatternEntry, and periodically as a
+// safety net in case a change was missed. A resync interval of zero turns off the periodic full resync.
+func (pm *PatternManager) NeedsFullSync(org string, resyncIntervalS int) bool {
+
+	cs, ok := pm.OrgChanges[org]
+	if !ok || cs.ChangeId == 0 {
+		return true
+	} else if resyncIntervalS != 0 && uint64(time.Now().Unix()) >= cs.LastFullSync+uint64(resyncIntervalS) {
+		return true
+	}
+
+	for _, pe := range pm.OrgPatterns[org] {
+		if pe == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the most recent exchange change id that has been processed for the org, zero if there is none.
+func (pm *PatternManager) GetChangeId(org string) uint64 {
+	if cs, ok := pm.OrgChanges[org]; ok {
+		return cs.ChangeId
+	}
+	return 0
+}
+
+// Remember the position in the org's change feed. A change id of zero means that the exchange does not
+// support the change feed, so the org will be fully read again next time.
+func (pm *PatternManager) SetChangeId(org string, changeId uint64, fullSync bool) {
+	cs, ok := pm.OrgChanges[org]
+	if !ok {
+		cs = new(OrgChangeState)
+		pm.OrgChanges[org] = cs
+	}
+	cs.ChangeId = changeId
+	if fullSync {
+		cs.LastFullSync = uint64(time.Now().Unix())
+	}
+}
+
 // When an org is removed from the list of supported orgs and patterns, remove the org
 // from the PatternManager and delete all the policy files for it.
 func (pm *PatternManager) deleteOrg(policyPath string, org string) error {
 
 	// Delete all the policy files that are pattern based for the org
-	if err := policy.DeletePolicyFilesForOrg(policyPath, org, true); err != nil {
+	if err := pm.PolicyStore.DeleteOrgPolicies(policyPath, org); err != nil {
 		glog.Errorf("Error deleting policy files for org %v. %v", org, err)
 	}
 
@@ -304,6 +429,7 @@ func (pm *PatternManager) deleteOrg(policyPath string, org string) error {
 	if pm.hasOrg(org) {
 		delete(pm.OrgPatterns, org)
 	}
+	delete(pm.OrgChanges, org)
 
 	return nil
 }
@@ -312,7 +438,7 @@ func (pm *PatternManager) deleteOrg(policyPath string, org string) error {
 func (pm *PatternManager) deletePattern(policyPath string, org string, pattern string) error {
 
 	// delete the policy files
-	if err := policy.DeletePolicyFilesForPattern(policyPath, org, pattern); err != nil {
+	if err := pm.PolicyStore.DeletePatternPolicies(policyPath, org, pattern); err != nil {
 		glog.Errorf("Error deleting policy files for pattern %v/%v. %v", org, pattern, err)
 	}
 
@@ -906,6 +906,91 @@ func Test_pattern_manager_setpatterns6(t *testing.T) {
 	}
 }
 
+// Apply pattern changes from the exchange change feed
+func Test_pattern_manager_changedpatterns(t *testing.T) {
+
+	policyPath := "/tmp/servedpatterntest/"
+	myorg1 := "myorg1"
+	pattern1 := "pattern1"
+	pattern2 := "pattern2"
+
+	servedPatterns := map[string]exchange.ServedPattern{
+		"myorg1_pattern1": {
+			Org:     myorg1,
+			Pattern: pattern1,
+		},
+		"myorg1_pattern2": {
+			Org:     myorg1,
+			Pattern: pattern2,
+		},
+	}
+
+	p1 := getTestPattern()
+	p2 := getTestPattern()
+	p2.Label = "Pattern2"
+	definedPatterns := map[string]exchange.Pattern{
+		"myorg1/pattern1": p1,
+		"myorg1/pattern2": p2,
+	}
+
+	// setup test
+	if err := cleanTestDir(policyPath); err != nil {
+		t.Errorf(err.Error())
+	}
+
+	np := NewPatternManager()
+	if err := np.SetCurrentPatterns(servedPatterns, policyPath); err != nil {
+		t.Errorf("Error %v consuming served patterns %v", err, servedPatterns)
+	} else if !np.NeedsFullSync(myorg1, 3600) {
+		t.Errorf("Error: org %v should need a full sync before its patterns are read", myorg1)
+	} else if err := np.UpdatePatternPolicies(myorg1, definedPatterns, policyPath); err != nil {
+		t.Errorf("Error: error updating pattern policies, %v", err)
+	}
+
+	np.SetChangeId(myorg1, 10, true)
+	if np.NeedsFullSync(myorg1, 3600) {
+		t.Errorf("Error: org %v should not need a full sync, state %v", myorg1, np.OrgChanges[myorg1])
+	} else if np.GetChangeId(myorg1) != 10 {
+		t.Errorf("Error: change id should be 10, is %v", np.GetChangeId(myorg1))
+	}
+
+	lastSync := np.OrgChanges[myorg1].LastFullSync
+	np.OrgChanges[myorg1].LastFullSync = lastSync - 3600
+	if !np.NeedsFullSync(myorg1, 3600) {
+		t.Errorf("Error: org %v should need a full sync once the resync interval has passed", myorg1)
+	}
+	np.OrgChanges[myorg1].LastFullSync = lastSync
+
+	oldHash := np.OrgPatterns[myorg1][pattern1].Hash
+	p1.Label = "changed label"
+	changes := []exchange.ResourceChange{
+		{ChangeId: 11, Resource: exchange.CHANGE_RESOURCE_PATTERN, Id: "myorg1/pattern1", Operation: exchange.CHANGE_OPERATION_MODIFIED},
+		{ChangeId: 12, Resource: exchange.CHANGE_RESOURCE_PATTERN, Id: "myorg1/pattern2", Operation: exchange.CHANGE_OPERATION_DELETED},
+		{ChangeId: 13, Resource: exchange.CHANGE_RESOURCE_PATTERN, Id: "myorg1/notserved", Operation: exchange.CHANGE_OPERATION_CREATED},
+	}
+	changed := map[string]exchange.Pattern{"myorg1/pattern1": p1}
+
+	if err := np.UpdateChangedPatternPolicies(myorg1, changes, changed, policyPath); err != nil {
+		t.Errorf("Error: error updating changed pattern policies, %v", err)
+	} else if np.hasPattern(myorg1, pattern2) {
+		t.Errorf("Error: pattern %v should have been deleted, have %v", pattern2, np)
+	} else if np.hasPattern(myorg1, "notserved") {
+		t.Errorf("Error: pattern notserved should have been ignored, have %v", np)
+	} else if pe := np.OrgPatterns[myorg1][pattern1]; bytes.Equal(pe.Hash, oldHash) || pe.Pattern.Label != "changed label" {
+		t.Errorf("Error: pattern %v should have been updated, have %v", pattern1, pe)
+	} else if err := getPatternEntryFiles(pe.PolicyFileNames); err != nil {
+		t.Errorf("Error getting pattern entry files for %v %v, %v", myorg1, pattern1, err)
+	}
+
+	resp := exchange.GetChangesResponse{Changes: changes, MostRecentChangeId: 20}
+	if resp.LastChangeId() != 13 {
+		t.Errorf("Error: last change id should be 13, is %v", resp.LastChangeId())
+	} else if resp := (exchange.GetChangesResponse{MostRecentChangeId: 20}); resp.LastChangeId() != 20 {
+		t.Errorf("Error: last change id should be 20, is %v", resp.LastChangeId())
+	}
+
+}
+
 func getTestPattern() exchange.Pattern {
 	return exchange.Pattern{
 		Owner:       "u1/u1",
@@ -927,8 +1012,8 @@ func getTestPattern() exchange.Pattern {
 						DeploymentOverridesSignature: "ng/uu...",
 					},
 				},
-				DataVerify: exchange.DataVerification{false, "", "", "", 0, 0, exchange.Meter{0, "", 0}},
-				NodeH:      exchange.NodeHealth{600, 120},
+				DataVerify: exchange.DataVerification{false, "", "", "", "", 0, 0, exchange.Meter{0, "", 0}},
+				NodeH:      exchange.NodeHealth{600, 120, 0},
 			},
 
 			{
@@ -944,8 +1029,8 @@ func getTestPattern() exchange.Pattern {
 						DeploymentOverridesSignature: "N4gkO...",
 					},
 				},
-				DataVerify: exchange.DataVerification{false, "", "", "", 0, 0, exchange.Meter{0, "", 0}},
-				NodeH:      exchange.NodeHealth{600, 120},
+				DataVerify: exchange.DataVerification{false, "", "", "", "", 0, 0, exchange.Meter{0, "", 0}},
+				NodeH:      exchange.NodeHealth{600, 120, 0},
 			},
 
 			{
@@ -961,8 +1046,8 @@ func getTestPattern() exchange.Pattern {
 						DeploymentOverridesSignature: "p2Rwa...",
 					},
 				},
-				DataVerify: exchange.DataVerification{false, "", "", "", 0, 0, exchange.Meter{0, "", 0}},
-				NodeH:      exchange.NodeHealth{600, 120},
+				DataVerify: exchange.DataVerification{false, "", "", "", "", 0, 0, exchange.Meter{0, "", 0}},
+				NodeH:      exchange.NodeHealth{600, 120, 0},
 			},
 		},
 		AgreementProtocols: []exchange.AgreementProtocol{
@@ -995,8 +1080,8 @@ func getTestPattern2() exchange.Pattern {
 						DeploymentOverridesSignature: "ng/uu...",
 					},
 				},
-				DataVerify: exchange.DataVerification{false, "", "", "", 0, 0, exchange.Meter{0, "", 0}},
-				NodeH:      exchange.NodeHealth{600, 120},
+				DataVerify: exchange.DataVerification{false, "", "", "", "", 0, 0, exchange.Meter{0, "", 0}},
+				NodeH:      exchange.NodeHealth{600, 120, 0},
 			},
 
 			{
@@ -1012,8 +1097,8 @@ func getTestPattern2() exchange.Pattern {
 						DeploymentOverridesSignature: "N4gkO...",
 					},
 				},
-				DataVerify: exchange.DataVerification{false, "", "", "", 0, 0, exchange.Meter{0, "", 0}},
-				NodeH:      exchange.NodeHealth{600, 120},
+				DataVerify: exchange.DataVerification{false, "", "", "", "", 0, 0, exchange.Meter{0, "", 0}},
+				NodeH:      exchange.NodeHealth{600, 120, 0},
 			},
 
 			{
@@ -1029,8 +1114,8 @@ func getTestPattern2() exchange.Pattern {
 						DeploymentOverridesSignature: "p2Rwa...",
 					},
 				},
-				DataVerify: exchange.DataVerification{false, "", "", "", 0, 0, exchange.Meter{0, "", 0}},
-				NodeH:      exchange.NodeHealth{600, 120},
+				DataVerify: exchange.DataVerification{false, "", "", "", "", 0, 0, exchange.Meter{0, "", 0}},
+				NodeH:      exchange.NodeHealth{600, 120, 0},
 			},
 		},
 	}
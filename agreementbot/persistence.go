@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"github.com/boltdb/bolt"
 	"github.com/golang/glog"
+	"github.com/open-horizon/anax/abstractprotocol"
+	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/policy"
 	"time"
 )
@@ -30,6 +32,7 @@ type Agreement struct {
 	Policy                         string   `json:"policy"`                            // JSON serialization of the policy used to make the proposal
 	PolicyName                     string   `json:"policy_name"`                       // The name of the policy for this agreement, policy names are unique
 	CounterPartyAddress            string   `json:"counter_party_address"`             // The blockchain address of the counterparty in the agreement
+	DataVerificationType           string   `json:"data_verification_type"`            // How the data of this agreement is verified, see the policy's data verification types
 	DataVerificationURL            string   `json:"data_verification_URL"`             // The URL to use to ensure that this agreement is sending data.
 	DataVerificationUser           string   `json:"data_verification_user"`            // The user to use with the DataVerificationURL
 	DataVerificationPW             string   `json:"data_verification_pw"`              // The pw of the data verification user
@@ -53,8 +56,14 @@ type Agreement struct {
 	BCUpdateAckTime                uint64   `json:"blockchain_update_ack_time"`        // The time when the producer ACked our update ot him (new V2 protocol)
 	NHMissingHBInterval            int      `json:"missing_heartbeat_interval"`        // How long a heartbeat can be missing until it is considered missing (in seconds)
 	NHCheckAgreementStatus         int      `json:"check_agreement_status"`            // How often to check that the node agreement entry still exists in the exchange (in seconds)
+	NHMissingHBGraceChecks         int      `json:"missing_heartbeat_grace_checks"`    // How many more checks the heartbeat can be missing on before the agreement is cancelled, the agbot's default when zero
 	Pattern                        string   `json:"pattern"`                           // The pattern used to make the agreement
+	NoReplyTimeoutS                uint64   `json:"no_reply_timeout,omitempty"`        // How long to wait for the proposal reply (in seconds), the configured timeout when zero
+	NotFinalizedTimeoutS           uint64   `json:"not_finalized_timeout,omitempty"`   // How long to wait for the agreement to be finalized (in seconds), the configured timeout when zero
 
+	ResourceUsage       *events.ResourceUsage                 `json:"resource_usage,omitempty"`       // The latest resource usage reported by the node for the agreement
+	DecisionExplanation *abstractprotocol.DecisionExplanation `json:"decision_explanation,omitempty"` // Why the node declined the proposal, when it says
+	Timestamps          AgreementTimestamps                   `json:"timestamps"`                     // When each step of the agreement happened, the same for every agreement protocol
 }
 
 func (a Agreement) String() string {
@@ -74,6 +83,7 @@ func (a Agreement) String() string {
 		"ConsumerProposalSig: %v, "+
 		"Policy Name: %v, "+
 		"CounterPartyAddress: %v, "+
+		"DataVerificationType: %v, "+
 		"DataVerificationURL: %v, "+
 		"DataVerificationUser: %v, "+
 		"DataVerificationCheckRate: %v, "+
@@ -95,19 +105,68 @@ func (a Agreement) String() string {
 		"BCUpdateAckTime: %v, "+
 		"NHMissingHBInterval: %v, "+
 		"NHCheckAgreementStatus: %v, "+
-		"Pattern: %v",
+		"NHMissingHBGraceChecks: %v, "+
+		"Pattern: %v, "+
+		"NoReplyTimeoutS: %v, "+
+		"NotFinalizedTimeoutS: %v, "+
+		"Timestamps: %v",
 		a.Archived, a.CurrentAgreementId, a.Org, a.AgreementProtocol, a.AgreementProtocolVersion, a.DeviceId, a.HAPartners,
 		a.AgreementInceptionTime, a.AgreementCreationTime, a.AgreementFinalizedTime,
 		a.AgreementTimedout, a.ProposalSig, a.ProposalHash, a.ConsumerProposalSig, a.PolicyName, a.CounterPartyAddress,
-		a.DataVerificationURL, a.DataVerificationUser, a.DataVerificationCheckRate, a.DataVerificationMissedCount, a.DataVerificationNoDataInterval,
+		a.DataVerificationType, a.DataVerificationURL, a.DataVerificationUser, a.DataVerificationCheckRate, a.DataVerificationMissedCount, a.DataVerificationNoDataInterval,
 		a.DisableDataVerificationChecks, a.DataVerifiedTime, a.DataNotificationSent,
 		a.MeteringTokens, a.MeteringPerTimeUnit, a.MeteringNotificationInterval, a.MeteringNotificationSent, a.MeteringNotificationMsgs,
 		a.TerminatedReason, a.TerminatedDescription, a.BlockchainType, a.BlockchainName, a.BlockchainOrg, a.BCUpdateAckTime,
-		a.NHMissingHBInterval, a.NHCheckAgreementStatus, a.Pattern)
+		a.NHMissingHBInterval, a.NHCheckAgreementStatus, a.NHMissingHBGraceChecks, a.Pattern, a.NoReplyTimeoutS, a.NotFinalizedTimeoutS, a.Timestamps)
+}
+
+// The times, in seconds since the epoch, of the steps of an agreement. The protocols record the other times of an
+// agreement in their own way, e.g. an agreement is finalized when it is seen in the blockchain or when the node acks
+// the reply, but these are recorded at the same steps for every protocol, so that latency and SLA reports do not have
+// to know which protocol made the agreement. A time is zero until its step happens.
+type AgreementTimestamps struct {
+	ProposalSent  uint64 `json:"proposal_sent"`  // the proposal was sent to the node
+	ReplyReceived uint64 `json:"reply_received"` // the node accepted the proposal
+	Finalized     uint64 `json:"finalized"`      // the agreement is in effect
+	Terminated    uint64 `json:"terminated"`     // the agreement began to be cancelled, for whatever reason
+}
+
+func (t AgreementTimestamps) String() string {
+	return fmt.Sprintf("ProposalSent: %v, ReplyReceived: %v, Finalized: %v, Terminated: %v", t.ProposalSent, t.ReplyReceived, t.Finalized, t.Terminated)
+}
+
+// Each time moves once from zero to non-zero.
+func (t *AgreementTimestamps) merge(update AgreementTimestamps) {
+	if t.ProposalSent == 0 {
+		t.ProposalSent = update.ProposalSent
+	}
+	if t.ReplyReceived == 0 {
+		t.ReplyReceived = update.ReplyReceived
+	}
+	if t.Finalized == 0 {
+		t.Finalized = update.Finalized
+	}
+	if t.Terminated == 0 {
+		t.Terminated = update.Terminated
+	}
+}
+
+// Agreements that were made before the timestamps were recorded get the times that the protocol specific fields have.
+// Their reply time is not known.
+func (a *Agreement) normalizeTimestamps() {
+	if a.Timestamps.ProposalSent == 0 {
+		a.Timestamps.ProposalSent = a.AgreementCreationTime
+	}
+	if a.Timestamps.Finalized == 0 {
+		a.Timestamps.Finalized = a.AgreementFinalizedTime
+	}
+	if a.Timestamps.Terminated == 0 {
+		a.Timestamps.Terminated = a.AgreementTimedout
+	}
 }
 
 // private factory method for agreement w/out persistence safety:
-func agreement(agreementid string, org string, deviceid string, policyName string, bcType string, bcName string, bcOrg string, agreementProto string, pattern string, nhPolicy policy.NodeHealth) (*Agreement, error) {
+func agreement(agreementid string, org string, deviceid string, policyName string, bcType string, bcName string, bcOrg string, agreementProto string, pattern string, nhPolicy policy.NodeHealth, timeouts *policy.AgreementTimeouts) (*Agreement, error) {
 	if agreementid == "" || agreementProto == "" {
 		return nil, errors.New("Illegal input: agreement id or agreement protocol is empty")
 	} else {
@@ -129,6 +188,7 @@ func agreement(agreementid string, org string, deviceid string, policyName strin
 			Policy:                         "",
 			PolicyName:                     policyName,
 			CounterPartyAddress:            "",
+			DataVerificationType:           "",
 			DataVerificationURL:            "",
 			DataVerificationUser:           "",
 			DataVerificationPW:             "",
@@ -151,13 +211,16 @@ func agreement(agreementid string, org string, deviceid string, policyName strin
 			BCUpdateAckTime:                0,
 			NHMissingHBInterval:            nhPolicy.MissingHBInterval,
 			NHCheckAgreementStatus:         nhPolicy.CheckAgreementStatus,
+			NHMissingHBGraceChecks:         nhPolicy.MissingHBGraceChecks,
 			Pattern:                        pattern,
+			NoReplyTimeoutS:                timeouts.NoReply(0),
+			NotFinalizedTimeoutS:           timeouts.NotFinalized(0),
 		}, nil
 	}
 }
 
-func AgreementAttempt(db *bolt.DB, agreementid string, org string, deviceid string, policyName string, bcType string, bcName string, bcOrg string, agreementProto string, pattern string, nhPolicy policy.NodeHealth) error {
-	if agreement, err := agreement(agreementid, org, deviceid, policyName, bcType, bcName, bcOrg, agreementProto, pattern, nhPolicy); err != nil {
+func AgreementAttempt(db *bolt.DB, agreementid string, org string, deviceid string, policyName string, bcType string, bcName string, bcOrg string, agreementProto string, pattern string, nhPolicy policy.NodeHealth, timeouts *policy.AgreementTimeouts) error {
+	if agreement, err := agreement(agreementid, org, deviceid, policyName, bcType, bcName, bcOrg, agreementProto, pattern, nhPolicy, timeouts); err != nil {
 		return err
 	} else if err := PersistNew(db, agreement.CurrentAgreementId, bucketName(agreementProto), &agreement); err != nil {
 		return err
@@ -169,6 +232,7 @@ func AgreementAttempt(db *bolt.DB, agreementid string, org string, deviceid stri
 func AgreementUpdate(db *bolt.DB, agreementid string, proposal string, policy string, dvPolicy policy.DataVerification, defaultCheckRate uint64, hash string, sig string, protocol string, agreementProtoVersion int) (*Agreement, error) {
 	if agreement, err := singleAgreementUpdate(db, agreementid, protocol, func(a Agreement) *Agreement {
 		a.AgreementCreationTime = uint64(time.Now().Unix())
+		a.Timestamps.ProposalSent = a.AgreementCreationTime
 		a.Proposal = proposal
 		a.ProposalHash = hash
 		a.ConsumerProposalSig = sig
@@ -176,6 +240,7 @@ func AgreementUpdate(db *bolt.DB, agreementid string, proposal string, policy st
 		a.AgreementProtocolVersion = agreementProtoVersion
 		a.DisableDataVerificationChecks = !dvPolicy.Enabled
 		if dvPolicy.Enabled {
+			a.DataVerificationType = dvPolicy.VerificationType()
 			a.DataVerificationURL = dvPolicy.URL
 			a.DataVerificationUser = dvPolicy.URLUser
 			a.DataVerificationPW = dvPolicy.URLPassword
@@ -202,6 +267,7 @@ func AgreementMade(db *bolt.DB, agreementId string, counterParty string, signatu
 		a.CounterPartyAddress = counterParty
 		a.ProposalSig = signature
 		a.HAPartners = hapartners
+		a.Timestamps.ReplyReceived = uint64(time.Now().Unix())
 		a.BlockchainType = bcType
 		a.BlockchainName = bcName
 		a.BlockchainOrg = bcOrg
@@ -241,6 +307,7 @@ func AgreementBlockchainUpdateAck(db *bolt.DB, agreementId string, protocol stri
 func AgreementFinalized(db *bolt.DB, agreementid string, protocol string) (*Agreement, error) {
 	if agreement, err := singleAgreementUpdate(db, agreementid, protocol, func(a Agreement) *Agreement {
 		a.AgreementFinalizedTime = uint64(time.Now().Unix())
+		a.Timestamps.Finalized = a.AgreementFinalizedTime
 		return &a
 	}); err != nil {
 		return nil, err
@@ -252,6 +319,31 @@ func AgreementFinalized(db *bolt.DB, agreementid string, protocol string) (*Agre
 func AgreementTimedout(db *bolt.DB, agreementid string, protocol string) (*Agreement, error) {
 	if agreement, err := singleAgreementUpdate(db, agreementid, protocol, func(a Agreement) *Agreement {
 		a.AgreementTimedout = uint64(time.Now().Unix())
+		if a.Timestamps.Terminated == 0 {
+			a.Timestamps.Terminated = a.AgreementTimedout
+		}
+		return &a
+	}); err != nil {
+		return nil, err
+	} else {
+		return agreement, nil
+	}
+}
+
+func AgreementDecisionExplanation(db *bolt.DB, agreementid string, protocol string, explanation abstractprotocol.DecisionExplanation) (*Agreement, error) {
+	if agreement, err := singleAgreementUpdate(db, agreementid, protocol, func(a Agreement) *Agreement {
+		a.DecisionExplanation = &explanation
+		return &a
+	}); err != nil {
+		return nil, err
+	} else {
+		return agreement, nil
+	}
+}
+
+func AgreementResourceUsage(db *bolt.DB, agreementid string, protocol string, usage events.ResourceUsage) (*Agreement, error) {
+	if agreement, err := singleAgreementUpdate(db, agreementid, protocol, func(a Agreement) *Agreement {
+		a.ResourceUsage = &usage
 		return &a
 	}); err != nil {
 		return nil, err
@@ -313,6 +405,23 @@ func (a *Agreement) NodeHealthInUse() bool {
 	return a.NHMissingHBInterval != 0 || a.NHCheckAgreementStatus != 0
 }
 
+// Returns the number of seconds to wait for the proposal reply, the timeout of the agreement's policy when it has one.
+func (a *Agreement) NoReplyTimeout(defaultS uint64) uint64 {
+	if a.NoReplyTimeoutS != 0 {
+		return a.NoReplyTimeoutS
+	}
+	return defaultS
+}
+
+// Returns the number of seconds to wait for the agreement to be finalized, the timeout of the agreement's policy when
+// it has one.
+func (a *Agreement) NotFinalizedTimeout(defaultS uint64) uint64 {
+	if a.NotFinalizedTimeoutS != 0 {
+		return a.NotFinalizedTimeoutS
+	}
+	return defaultS
+}
+
 func (a *Agreement) FinalizedWithinTolerance(tolerance uint64) bool {
 	tolerate := uint64(time.Now().Unix()) - tolerance
 	return a.AgreementFinalizedTime > tolerate
@@ -323,6 +432,9 @@ func ArchiveAgreement(db *bolt.DB, agreementid string, protocol string, reason u
 		a.Archived = true
 		a.TerminatedReason = reason
 		a.TerminatedDescription = desc
+		if a.Timestamps.Terminated == 0 {
+			a.Timestamps.Terminated = uint64(time.Now().Unix())
+		}
 		return &a
 	}); err != nil {
 		return nil, err
@@ -420,6 +532,9 @@ func persistUpdatedAgreement(db *bolt.DB, agreementid string, protocol string, u
 				if mod.ProposalSig == "" { // 1 transition from empty to non-empty
 					mod.ProposalSig = update.ProposalSig
 				}
+				if mod.DataVerificationType == "" { // 1 transition from empty to non-empty
+					mod.DataVerificationType = update.DataVerificationType
+				}
 				if mod.DataVerificationURL == "" { // 1 transition from empty to non-empty
 					mod.DataVerificationURL = update.DataVerificationURL
 				}
@@ -489,6 +604,13 @@ func persistUpdatedAgreement(db *bolt.DB, agreementid string, protocol string, u
 				if mod.BCUpdateAckTime == 0 { // 1 transition from zero to non-zero
 					mod.BCUpdateAckTime = update.BCUpdateAckTime
 				}
+				if update.ResourceUsage != nil && (mod.ResourceUsage == nil || mod.ResourceUsage.SampleTime < update.ResourceUsage.SampleTime) { // Valid transitions must move forward
+					mod.ResourceUsage = update.ResourceUsage
+				}
+				if mod.DecisionExplanation == nil { // 1 transition from nil to non-nil
+					mod.DecisionExplanation = update.DecisionExplanation
+				}
+				mod.Timestamps.merge(update.Timestamps)
 				if serialized, err := json.Marshal(mod); err != nil {
 					return fmt.Errorf("Failed to serialize agreement record: %v", mod)
 				} else if err := b.Put([]byte(agreementid), serialized); err != nil {
@@ -545,6 +667,18 @@ func DevPolAFilter(deviceId string, policyName string) AFilter {
 	return func(a Agreement) bool { return a.DeviceId == deviceId && a.PolicyName == policyName }
 }
 
+func OrgAFilter(org string) AFilter {
+	return func(a Agreement) bool { return a.Org == org }
+}
+
+func PatternAFilter(pattern string) AFilter {
+	return func(a Agreement) bool { return a.Pattern == pattern }
+}
+
+func DeviceAFilter(deviceId string) AFilter {
+	return func(a Agreement) bool { return a.DeviceId == deviceId }
+}
+
 type AFilter func(Agreement) bool
 
 func FindAgreements(db *bolt.DB, filters []AFilter, protocol string) ([]Agreement, error) {
@@ -560,6 +694,7 @@ func FindAgreements(db *bolt.DB, filters []AFilter, protocol string) ([]Agreemen
 				if err := json.Unmarshal(v, &a); err != nil {
 					glog.Errorf("Unable to deserialize db record: %v", v)
 				} else {
+					a.normalizeTimestamps()
 					if !a.Archived {
 						glog.V(5).Infof("Demarshalled agreement in DB: %v", a)
 					}
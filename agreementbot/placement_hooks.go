@@ -0,0 +1,226 @@
+package agreementbot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/policy"
+	"io/ioutil"
+	"net/http"
+)
+
+// A PlacementHook reviews the nodes found by a search for a policy before the agbot makes proposals to them. This is
+// where an enterprise plugs in its own placement rules, without changing how the agbot negotiates agreements.
+type PlacementHook interface {
+	// Returns the decision on the candidates in the request. An error means that no decision was made.
+	Review(req *PlacementRequest) (*PlacementDecision, error)
+	String() string
+}
+
+// The JSON request POSTed to an external scheduler.
+type PlacementRequest struct {
+	Agbot      string               `json:"agbot"`
+	Org        string               `json:"org"`
+	Policy     PlacementPolicy      `json:"policy"`
+	Candidates []PlacementCandidate `json:"candidates"`
+}
+
+// The summary of the policy that the proposals would be made for.
+type PlacementPolicy struct {
+	Name           string              `json:"name"`
+	Pattern        string              `json:"pattern,omitempty"`
+	BusinessPolicy string              `json:"businessPolicy,omitempty"`
+	Services       []PlacementService  `json:"services"`
+	MaxAgreements  int                 `json:"maxAgreements,omitempty"`
+	Properties     policy.PropertyList `json:"properties,omitempty"`
+}
+
+type PlacementService struct {
+	URL      string `json:"url"`
+	Org      string `json:"org"`
+	Version  string `json:"version"`
+	Arch     string `json:"arch"`
+	Priority int    `json:"priority,omitempty"`
+}
+
+type PlacementCandidate struct {
+	Id       string   `json:"id"`
+	Name     string   `json:"name"`
+	Services []string `json:"services,omitempty"` // the URLs of the services the node registered, empty for nodes found by pattern
+}
+
+// The answer of an external scheduler. Nodes contains the ids of the candidates to make proposals to, in the order to
+// make them. The candidates that are not in Nodes are rejected, the scheduler can say why in Rejected.
+type PlacementDecision struct {
+	Nodes    []string          `json:"nodes"`
+	Rejected map[string]string `json:"rejected,omitempty"`
+}
+
+func NewPlacementRequest(agbotId string, org string, pol *policy.Policy, devices []exchange.SearchResultDevice) *PlacementRequest {
+	req := &PlacementRequest{
+		Agbot: agbotId,
+		Org:   org,
+		Policy: PlacementPolicy{
+			Name:           pol.Header.Name,
+			Pattern:        pol.PatternId,
+			BusinessPolicy: pol.BusinessPolId,
+			Services:       make([]PlacementService, 0, len(pol.Workloads)),
+			MaxAgreements:  pol.MaxAgreements,
+			Properties:     pol.Properties,
+		},
+		Candidates: make([]PlacementCandidate, 0, len(devices)),
+	}
+	for _, wl := range pol.Workloads {
+		req.Policy.Services = append(req.Policy.Services, PlacementService{URL: wl.WorkloadURL, Org: wl.Org, Version: wl.Version, Arch: wl.Arch, Priority: wl.Priority.PriorityValue})
+	}
+	for _, dev := range devices {
+		c := PlacementCandidate{Id: dev.Id, Name: dev.Name}
+		for _, ms := range dev.Services {
+			c.Services = append(c.Services, ms.Url)
+		}
+		req.Candidates = append(req.Candidates, c)
+	}
+	return req
+}
+
+// POSTs the request to an external scheduler.
+type httpPlacementHook struct {
+	config     config.PlacementHookConfig
+	httpClient *http.Client
+}
+
+func (h *httpPlacementHook) String() string {
+	return h.config.URL
+}
+
+func (h *httpPlacementHook) Review(req *PlacementRequest) (*PlacementDecision, error) {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to marshal placement request, error: %v", err))
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, h.config.URL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if h.config.Secret != "" {
+		httpReq.Header.Set(WEBHOOK_SIGNATURE_HEADER, "sha256="+WebhookSignature(h.config.Secret, payload))
+	}
+
+	resp, err := h.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to read the placement decision, error: %v", err))
+	} else if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, errors.New(fmt.Sprintf("scheduler returned HTTP status %v: %s", resp.StatusCode, body))
+	}
+
+	// A decision without a node list is an error, so that a scheduler that answers with something else does not
+	// reject every node.
+	decision := new(PlacementDecision)
+	if err := json.Unmarshal(body, decision); err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to demarshal the placement decision %s, error: %v", body, err))
+	} else if decision.Nodes == nil {
+		return nil, errors.New(fmt.Sprintf("placement decision %s has no nodes", body))
+	}
+	return decision, nil
+}
+
+type placementHookEntry struct {
+	hook     PlacementHook
+	failOpen bool
+}
+
+// The placement hooks are consulted in the order they are configured, each one reviews the nodes that the previous
+// one approved. A nil PlacementHooks approves every node as it was found, so callers dont have to check whether
+// hooks are configured.
+type PlacementHooks struct {
+	agbotId string
+	hooks   []placementHookEntry
+}
+
+func NewPlacementHooks(agbotId string, hooks []config.PlacementHookConfig, httpClientFactory *config.HTTPClientFactory) *PlacementHooks {
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	p := &PlacementHooks{
+		agbotId: agbotId,
+		hooks:   make([]placementHookEntry, 0, len(hooks)),
+	}
+	for _, hc := range hooks {
+		timeout := hc.TimeoutS
+		p.add(&httpPlacementHook{config: hc, httpClient: httpClientFactory.NewHTTPClient(&timeout)}, hc.FailOpen)
+	}
+	return p
+}
+
+func (p *PlacementHooks) add(hook PlacementHook, failOpen bool) {
+	p.hooks = append(p.hooks, placementHookEntry{hook: hook, failOpen: failOpen})
+}
+
+// Returns the nodes to make proposals to for the policy, in the order to make them. When a hook fails, the nodes it
+// was given are kept if the hook fails open, otherwise an error is returned and no proposals should be made.
+func (p *PlacementHooks) Review(org string, pol *policy.Policy, devices []exchange.SearchResultDevice) ([]exchange.SearchResultDevice, error) {
+	if p == nil || len(devices) == 0 {
+		return devices, nil
+	}
+
+	for _, entry := range p.hooks {
+		if len(devices) == 0 {
+			break
+		}
+
+		decision, err := entry.hook.Review(NewPlacementRequest(p.agbotId, org, pol, devices))
+		if err != nil && entry.failOpen {
+			glog.Warningf(PHlogstring(fmt.Sprintf("%v failed for %v, keeping all %v nodes, error: %v", entry.hook, pol.Header.Name, len(devices), err)))
+			continue
+		} else if err != nil {
+			return nil, errors.New(fmt.Sprintf("placement hook %v failed for %v, error: %v", entry.hook, pol.Header.Name, err))
+		}
+
+		devices = placeDevices(devices, decision)
+		for id, reason := range decision.Rejected {
+			glog.V(3).Infof(PHlogstring(fmt.Sprintf("%v rejected node %v for %v: %v", entry.hook, id, pol.Header.Name, reason)))
+		}
+		glog.V(5).Infof(PHlogstring(fmt.Sprintf("%v approved %v nodes for %v", entry.hook, len(devices), pol.Header.Name)))
+	}
+	return devices, nil
+}
+
+// Returns the candidates in the order of the decision. Ids that were not candidates, and repeated ids, are ignored.
+func placeDevices(devices []exchange.SearchResultDevice, decision *PlacementDecision) []exchange.SearchResultDevice {
+	byId := make(map[string]exchange.SearchResultDevice, len(devices))
+	for _, dev := range devices {
+		byId[dev.Id] = dev
+	}
+
+	placed := make([]exchange.SearchResultDevice, 0, len(decision.Nodes))
+	for _, id := range decision.Nodes {
+		if dev, ok := byId[id]; ok {
+			placed = append(placed, dev)
+			delete(byId, id)
+		} else {
+			glog.Warningf(PHlogstring(fmt.Sprintf("ignoring node %v in placement decision, it is not a candidate", id)))
+		}
+	}
+	return placed
+}
+
+var PHlogstring = func(v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("PlacementHooks", nil, v)
+	}
+	return fmt.Sprintf("PlacementHooks %v", v)
+}
@@ -0,0 +1,95 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/policy"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_PlacementHooks(t *testing.T) {
+
+	pol := policy.Policy_Factory("mypolicy")
+	pol.PatternId = "myorg/mypattern"
+	pol.Workloads = policy.WorkloadList{policy.Workload{WorkloadURL: "myservice", Org: "myorg", Version: "1.0.0", Arch: "amd64"}}
+
+	devices := []exchange.SearchResultDevice{
+		{Id: "myorg/node1", Name: "node1"},
+		{Id: "myorg/node2", Name: "node2"},
+		{Id: "myorg/node3", Name: "node3"},
+	}
+
+	// The scheduler reverses the candidates, rejects node2 and names a node that was not a candidate.
+	var received PlacementRequest
+	signature := ""
+	scheduler := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		signature = r.Header.Get(WEBHOOK_SIGNATURE_HEADER)
+		if signature != "sha256="+WebhookSignature("secret", body) {
+			t.Errorf("placement request has the wrong signature %v", signature)
+		}
+		json.Unmarshal(body, &received)
+		fmt.Fprintf(w, `{"nodes":["myorg/node3","myorg/other","myorg/node1"],"rejected":{"myorg/node2":"too busy"}}`)
+	}))
+	defer scheduler.Close()
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	noNodes := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"approved":["myorg/node1"]}`)
+	}))
+	defer noNodes.Close()
+
+	newHooks := func(hcs ...config.PlacementHookConfig) *PlacementHooks {
+		p := &PlacementHooks{agbotId: "myorg/agbot1"}
+		for _, hc := range hcs {
+			p.add(&httpPlacementHook{config: hc, httpClient: &http.Client{}}, hc.FailOpen)
+		}
+		return p
+	}
+
+	// Without hooks the nodes are kept as they were found.
+	var none *PlacementHooks
+	if placed, err := none.Review("myorg", pol, devices); err != nil || len(placed) != 3 {
+		t.Errorf("nil placement hooks should keep all the nodes, kept %v, error %v", placed, err)
+	}
+
+	// The decision reorders and rejects nodes, and the request summarizes the policy and the candidates.
+	hooks := newHooks(config.PlacementHookConfig{URL: scheduler.URL, Secret: "secret"})
+	if placed, err := hooks.Review("myorg", pol, devices); err != nil {
+		t.Errorf("unexpected error reviewing nodes: %v", err)
+	} else if len(placed) != 2 || placed[0].Id != "myorg/node3" || placed[1].Id != "myorg/node1" {
+		t.Errorf("placed nodes should be node3 and node1, are %v", placed)
+	}
+	if received.Agbot != "myorg/agbot1" || received.Org != "myorg" || received.Policy.Name != "mypolicy" || received.Policy.Pattern != "myorg/mypattern" {
+		t.Errorf("placement request has the wrong agbot or policy: %v", received)
+	} else if len(received.Policy.Services) != 1 || received.Policy.Services[0].URL != "myservice" || received.Policy.Services[0].Version != "1.0.0" {
+		t.Errorf("placement request has the wrong services: %v", received.Policy.Services)
+	} else if len(received.Candidates) != 3 || received.Candidates[1].Id != "myorg/node2" {
+		t.Errorf("placement request has the wrong candidates: %v", received.Candidates)
+	}
+
+	// A hook that fails closed stops the proposals, one that fails open keeps the nodes for the next hook.
+	if _, err := newHooks(config.PlacementHookConfig{URL: failing.URL}).Review("myorg", pol, devices); err == nil {
+		t.Errorf("failing placement hook should return an error")
+	}
+	if _, err := newHooks(config.PlacementHookConfig{URL: noNodes.URL}).Review("myorg", pol, devices); err == nil {
+		t.Errorf("placement decision without nodes should be an error")
+	}
+	hooks = newHooks(config.PlacementHookConfig{URL: failing.URL, FailOpen: true}, config.PlacementHookConfig{URL: scheduler.URL, Secret: "secret"})
+	if placed, err := hooks.Review("myorg", pol, devices); err != nil {
+		t.Errorf("failing open placement hook should not return an error: %v", err)
+	} else if len(placed) != 2 || placed[0].Id != "myorg/node3" {
+		t.Errorf("the next hook should place the nodes, placed %v", placed)
+	}
+}
@@ -0,0 +1,241 @@
+package agreementbot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/policy"
+	"strings"
+	"sync"
+)
+
+// The PatternManager generates a set of policies for each pattern served by the agbot, and the BusinessPolicyManager
+// generates a policy for each served business policy. A PatternPolicyStore is where those policies are kept. By default they are written as policy files into the agbot's policy directory,
+// where the policy file watcher discovers them. The in-memory store keeps them out of the filesystem entirely and
+// notifies the agbot of policy changes directly.
+type PatternPolicyStore interface {
+	AddPolicy(policyPath string, org string, pol *policy.Policy) (string, error) // returns the name used to delete the policy
+	// Add all the policies of a pattern at once, returns the generation of the policies and the names of the policies.
+	AddPatternPolicies(policyPath string, org string, patternId string, policies []*policy.Policy) (string, []string, error)
+	DeletePolicy(name string) error
+	DeletePatternPolicies(policyPath string, org string, pattern string) error
+	DeleteOrgPolicies(policyPath string, org string) error // only the policies generated from patterns
+	DeleteBusinessPolicyPolicies(policyPath string, org string, businessPol string) error
+	DeleteOrgBusinessPolicyPolicies(policyPath string, org string) error
+}
+
+// The policy file based store.
+type FilePolicyStore struct{}
+
+func (f *FilePolicyStore) AddPolicy(policyPath string, org string, pol *policy.Policy) (string, error) {
+	return policy.CreatePolicyFile(policyPath, org, pol.Header.Name, pol)
+}
+
+// The policies of a pattern are written as a new generation of policy files, which replaces the pattern's current
+// generation all at once.
+func (f *FilePolicyStore) AddPatternPolicies(policyPath string, org string, patternId string, policies []*policy.Policy) (string, []string, error) {
+	return policy.CreatePolicyGeneration(policyPath, org, patternId, policies)
+}
+
+func (f *FilePolicyStore) DeletePolicy(name string) error {
+	return policy.DeletePolicyFile(name)
+}
+
+func (f *FilePolicyStore) DeletePatternPolicies(policyPath string, org string, pattern string) error {
+	return policy.DeletePolicyFilesForPattern(policyPath, org, pattern)
+}
+
+func (f *FilePolicyStore) DeleteOrgPolicies(policyPath string, org string) error {
+	return policy.DeletePolicyFilesForOrg(policyPath, org, true)
+}
+
+func (f *FilePolicyStore) DeleteBusinessPolicyPolicies(policyPath string, org string, businessPol string) error {
+	return policy.DeletePolicyFilesForBusinessPolicy(policyPath, org, businessPol)
+}
+
+func (f *FilePolicyStore) DeleteOrgBusinessPolicyPolicies(policyPath string, org string) error {
+	return policy.DeletePolicyFilesForBusinessPolicyOrg(policyPath, org)
+}
+
+// The in-memory store. The policy directory is ignored. Policies are keyed by org and policy name, and changes are
+// reported through the same callbacks that the policy file watcher uses.
+type MemoryPolicyStore struct {
+	lock         sync.Mutex
+	policies     map[string]map[string]*policy.Policy
+	archSynonyms config.ArchSynonyms
+	validate     func(pol *policy.Policy) error
+	changed      func(org string, name string, pol *policy.Policy)
+	deleted      func(org string, name string, pol *policy.Policy)
+}
+
+func NewMemoryPolicyStore(archSynonyms config.ArchSynonyms,
+	validate func(pol *policy.Policy) error,
+	changed func(org string, name string, pol *policy.Policy),
+	deleted func(org string, name string, pol *policy.Policy)) *MemoryPolicyStore {
+
+	return &MemoryPolicyStore{
+		policies:     make(map[string]map[string]*policy.Policy),
+		archSynonyms: archSynonyms,
+		validate:     validate,
+		changed:      changed,
+		deleted:      deleted,
+	}
+}
+
+func (m *MemoryPolicyStore) String() string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	res := "Memory Policy Store: "
+	for org, orgMap := range m.policies {
+		res += fmt.Sprintf("Org: %v ", org)
+		for name, _ := range orgMap {
+			res += fmt.Sprintf("Policy: %v ", name)
+		}
+	}
+	return res
+}
+
+func memoryPolicyName(org string, name string) string {
+	return fmt.Sprintf("%v/%v", org, name)
+}
+
+// The store keeps its own copy of the policy, in the same form it would have if it was read back from a policy file.
+func (m *MemoryPolicyStore) AddPolicy(policyPath string, org string, pol *policy.Policy) (string, error) {
+
+	newPolicy := new(policy.Policy)
+	if bytes, err := json.Marshal(pol); err != nil {
+		return "", errors.New(fmt.Sprintf("unable to marshal policy %v, error: %v", pol, err))
+	} else if err := json.Unmarshal(bytes, newPolicy); err != nil {
+		return "", errors.New(fmt.Sprintf("unable to demarshal policy %v, error: %v", string(bytes), err))
+	}
+	newPolicy.ConvertSpecRefArchToGOARCH(m.archSynonyms)
+
+	name := memoryPolicyName(org, newPolicy.Header.Name)
+
+	// A policy that is not valid is reported and otherwise ignored, like a bad policy file would be.
+	if m.validate != nil {
+		if err := m.validate(newPolicy); err != nil {
+			glog.Errorf(fmt.Sprintf("Memory Policy Store ignoring policy %v, not self consistent, error: %v", name, err))
+			return name, nil
+		}
+	}
+
+	m.lock.Lock()
+	if _, ok := m.policies[org]; !ok {
+		m.policies[org] = make(map[string]*policy.Policy)
+	}
+	m.policies[org][newPolicy.Header.Name] = newPolicy
+	m.lock.Unlock()
+
+	glog.V(5).Infof(fmt.Sprintf("Memory Policy Store added policy %v", name))
+	if m.changed != nil {
+		m.changed(org, name, newPolicy)
+	}
+	return name, nil
+}
+
+// The policies in memory replace the policies with the same name as they are added, so there are no generations.
+func (m *MemoryPolicyStore) AddPatternPolicies(policyPath string, org string, patternId string, policies []*policy.Policy) (string, []string, error) {
+	names := make([]string, 0, len(policies))
+	for _, pol := range policies {
+		if name, err := m.AddPolicy(policyPath, org, pol); err != nil {
+			return "", nil, err
+		} else {
+			names = append(names, name)
+		}
+	}
+	return "", names, nil
+}
+
+func (m *MemoryPolicyStore) DeletePolicy(name string) error {
+
+	org, policyName := splitMemoryPolicyName(name)
+
+	m.lock.Lock()
+	pol, ok := m.policies[org][policyName]
+	if ok {
+		delete(m.policies[org], policyName)
+		if len(m.policies[org]) == 0 {
+			delete(m.policies, org)
+		}
+	}
+	m.lock.Unlock()
+
+	if ok {
+		glog.V(5).Infof(fmt.Sprintf("Memory Policy Store deleted policy %v", name))
+		if m.deleted != nil {
+			m.deleted(org, name, pol)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryPolicyStore) DeletePatternPolicies(policyPath string, org string, pattern string) error {
+	patternId := fmt.Sprintf("%v/%v", org, pattern)
+	for _, name := range m.policyNames(org, func(pol *policy.Policy) bool { return pol.PatternId == patternId }) {
+		if err := m.DeletePolicy(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryPolicyStore) DeleteOrgPolicies(policyPath string, org string) error {
+	return m.deletePolicies(org, func(pol *policy.Policy) bool { return pol.BusinessPolId == "" })
+}
+
+func (m *MemoryPolicyStore) DeleteBusinessPolicyPolicies(policyPath string, org string, businessPol string) error {
+	businessPolId := fmt.Sprintf("%v/%v", org, businessPol)
+	return m.deletePolicies(org, func(pol *policy.Policy) bool { return pol.BusinessPolId == businessPolId })
+}
+
+func (m *MemoryPolicyStore) DeleteOrgBusinessPolicyPolicies(policyPath string, org string) error {
+	return m.deletePolicies(org, func(pol *policy.Policy) bool { return pol.BusinessPolId != "" })
+}
+
+func (m *MemoryPolicyStore) deletePolicies(org string, match func(pol *policy.Policy) bool) error {
+	for _, name := range m.policyNames(org, match) {
+		if err := m.DeletePolicy(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns a copy of all the policies in the store, keyed by org.
+func (m *MemoryPolicyStore) GetAllPolicies() map[string][]policy.Policy {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	res := make(map[string][]policy.Policy)
+	for org, orgMap := range m.policies {
+		for _, pol := range orgMap {
+			res[org] = append(res[org], *pol)
+		}
+	}
+	return res
+}
+
+func (m *MemoryPolicyStore) policyNames(org string, match func(pol *policy.Policy) bool) []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	names := make([]string, 0, 10)
+	for policyName, pol := range m.policies[org] {
+		if match(pol) {
+			names = append(names, memoryPolicyName(org, policyName))
+		}
+	}
+	return names
+}
+
+// The org never contains a slash but the policy name might.
+func splitMemoryPolicyName(name string) (string, string) {
+	if parts := strings.SplitN(name, "/", 2); len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return "", name
+}
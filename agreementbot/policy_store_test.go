@@ -0,0 +1,73 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"errors"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/policy"
+	"testing"
+)
+
+func Test_MemoryPolicyStore_add_delete(t *testing.T) {
+
+	changed := make([]string, 0, 10)
+	deleted := make([]string, 0, 10)
+
+	validate := func(pol *policy.Policy) error {
+		if pol.Header.Name == "bad" {
+			return errors.New("bad policy")
+		}
+		return nil
+	}
+
+	store := NewMemoryPolicyStore(config.ArchSynonyms{},
+		validate,
+		func(org string, name string, pol *policy.Policy) { changed = append(changed, name) },
+		func(org string, name string, pol *policy.Policy) { deleted = append(deleted, name) })
+
+	newPolicy := func(name string, pattern string) *policy.Policy {
+		pol := policy.Policy_Factory(name)
+		pol.PatternId = pattern
+		return pol
+	}
+
+	if name, err := store.AddPolicy("/unused", "myorg", newPolicy("p1_w1", "myorg/p1")); err != nil {
+		t.Errorf("error adding policy: %v", err)
+	} else if name != "myorg/p1_w1" {
+		t.Errorf("wrong policy name %v", name)
+	}
+	store.AddPolicy("/unused", "myorg", newPolicy("p1_w2", "myorg/p1"))
+	store.AddPolicy("/unused", "myorg", newPolicy("p2_w1", "myorg/p2"))
+	store.AddPolicy("/unused", "otherorg", newPolicy("p1_w1", "otherorg/p1"))
+	store.AddPolicy("/unused", "myorg", newPolicy("bad", "myorg/p1"))
+
+	if len(changed) != 4 {
+		t.Errorf("expected 4 changed policies, got %v", changed)
+	} else if all := store.GetAllPolicies(); len(all["myorg"]) != 3 || len(all["otherorg"]) != 1 {
+		t.Errorf("wrong policies in store %v", all)
+	}
+
+	if err := store.DeletePatternPolicies("/unused", "myorg", "p1"); err != nil {
+		t.Errorf("error deleting pattern policies: %v", err)
+	} else if len(deleted) != 2 {
+		t.Errorf("expected 2 deleted policies, got %v", deleted)
+	} else if all := store.GetAllPolicies(); len(all["myorg"]) != 1 || all["myorg"][0].Header.Name != "p2_w1" {
+		t.Errorf("wrong policies in store %v", all)
+	}
+
+	if err := store.DeletePolicy("myorg/nosuchpolicy"); err != nil {
+		t.Errorf("error deleting unknown policy: %v", err)
+	} else if len(deleted) != 2 {
+		t.Errorf("unknown policy should not be reported as deleted, got %v", deleted)
+	}
+
+	if err := store.DeleteOrgPolicies("/unused", "otherorg"); err != nil {
+		t.Errorf("error deleting org policies: %v", err)
+	} else if len(deleted) != 3 || deleted[2] != "otherorg/p1_w1" {
+		t.Errorf("wrong deleted policies %v", deleted)
+	} else if all := store.GetAllPolicies(); len(all) != 1 {
+		t.Errorf("wrong policies in store %v", all)
+	}
+
+}
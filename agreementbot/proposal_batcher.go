@@ -0,0 +1,126 @@
+package agreementbot
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"sync"
+	"time"
+)
+
+// The proposal batcher coalesces proposal messages from the agreement workers so that they can be posted
+// to the exchange in a single call. Each caller blocks until the batch containing its message has been
+// posted, so that the agreement workers see the same synchronous behavior they get when messages are
+// sent one at a time.
+type proposalBatchEntry struct {
+	entry  exchange.BatchMessageEntry
+	result chan error
+}
+
+type ProposalBatcher struct {
+	name      string
+	batchSize int
+	wait      time.Duration
+	post      func(entries []exchange.BatchMessageEntry) error
+	pending   []*proposalBatchEntry
+	timer     *time.Timer
+	lock      sync.Mutex
+}
+
+func NewProposalBatcher(name string, batchSize int, waitMS int, post func(entries []exchange.BatchMessageEntry) error) *ProposalBatcher {
+	return &ProposalBatcher{
+		name:      name,
+		batchSize: batchSize,
+		wait:      time.Duration(waitMS) * time.Millisecond,
+		post:      post,
+		pending:   make([]*proposalBatchEntry, 0, batchSize),
+	}
+}
+
+func (p *ProposalBatcher) String() string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return fmt.Sprintf("Name: %v, BatchSize: %v, Wait: %v, Pending: %v", p.name, p.batchSize, p.wait, len(p.pending))
+}
+
+// Batching is only worth doing when more than 1 proposal can be placed into a batch.
+func (p *ProposalBatcher) Enabled() bool {
+	return p != nil && p.batchSize > 1
+}
+
+// Add a message to the current batch and wait for the batch to be posted. The batch is posted when it is
+// full or when the wait interval expires, whichever comes first.
+func (p *ProposalBatcher) Send(nodeId string, msg []byte) error {
+
+	be := &proposalBatchEntry{
+		entry: exchange.BatchMessageEntry{
+			NodeId:  nodeId,
+			Message: msg,
+		},
+		result: make(chan error, 1),
+	}
+
+	p.lock.Lock()
+	p.pending = append(p.pending, be)
+	if len(p.pending) >= p.batchSize {
+		batch := p.takeBatch()
+		p.lock.Unlock()
+		go p.flush(batch)
+	} else {
+		if p.timer == nil {
+			p.timer = time.AfterFunc(p.wait, p.flushOnTimer)
+		}
+		p.lock.Unlock()
+	}
+
+	return <-be.result
+}
+
+// Called by the timer when the wait interval expires before the batch is full.
+func (p *ProposalBatcher) flushOnTimer() {
+	p.lock.Lock()
+	batch := p.takeBatch()
+	p.lock.Unlock()
+	p.flush(batch)
+}
+
+// Remove the pending entries from the batcher. The caller must hold the lock.
+func (p *ProposalBatcher) takeBatch() []*proposalBatchEntry {
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	batch := p.pending
+	p.pending = make([]*proposalBatchEntry, 0, p.batchSize)
+	return batch
+}
+
+// Post the batch to the exchange and tell every waiting sender how it went.
+func (p *ProposalBatcher) flush(batch []*proposalBatchEntry) {
+	if len(batch) == 0 {
+		return
+	}
+
+	entries := make([]exchange.BatchMessageEntry, 0, len(batch))
+	for _, be := range batch {
+		entries = append(entries, be.entry)
+	}
+
+	glog.V(3).Infof(PBlogstring(p.name, fmt.Sprintf("posting batch of %v proposals", len(entries))))
+	err := p.post(entries)
+	if err != nil {
+		glog.Errorf(PBlogstring(p.name, fmt.Sprintf("unable to post batch of %v proposals, error: %v", len(entries), err)))
+	}
+
+	for _, be := range batch {
+		be.result <- err
+	}
+}
+
+var PBlogstring = func(name string, v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("ProposalBatcher", cutil.LogFields{"batcher": name}, v)
+	}
+	return fmt.Sprintf("ProposalBatcher (%v) %v", name, v)
+}
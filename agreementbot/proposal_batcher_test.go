@@ -0,0 +1,89 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"errors"
+	"github.com/open-horizon/anax/exchange"
+	"sync"
+	"testing"
+)
+
+func Test_proposal_batcher_full_batch(t *testing.T) {
+
+	posted := make([][]exchange.BatchMessageEntry, 0, 2)
+	lock := sync.Mutex{}
+	post := func(entries []exchange.BatchMessageEntry) error {
+		lock.Lock()
+		defer lock.Unlock()
+		posted = append(posted, entries)
+		return nil
+	}
+
+	// The wait is long enough that only a full batch will cause a post.
+	pb := NewProposalBatcher("test", 3, 60000, post)
+
+	var wg sync.WaitGroup
+	for _, id := range []string{"org/n1", "org/n2", "org/n3"} {
+		wg.Add(1)
+		go func(nodeId string) {
+			defer wg.Done()
+			if err := pb.Send(nodeId, []byte("msg")); err != nil {
+				t.Errorf("unexpected error sending to %v: %v", nodeId, err)
+			}
+		}(id)
+	}
+	wg.Wait()
+
+	if len(posted) != 1 {
+		t.Errorf("expected 1 batch, got %v", len(posted))
+	} else if len(posted[0]) != 3 {
+		t.Errorf("expected 3 messages in the batch, got %v", len(posted[0]))
+	}
+
+}
+
+func Test_proposal_batcher_timer(t *testing.T) {
+
+	count := 0
+	post := func(entries []exchange.BatchMessageEntry) error {
+		count += len(entries)
+		return nil
+	}
+
+	pb := NewProposalBatcher("test", 10, 10, post)
+
+	if err := pb.Send("org/n1", []byte("msg")); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if count != 1 {
+		t.Errorf("expected 1 message to be posted, got %v", count)
+	}
+
+}
+
+func Test_proposal_batcher_error(t *testing.T) {
+
+	post := func(entries []exchange.BatchMessageEntry) error {
+		return errors.New("exchange is down")
+	}
+
+	pb := NewProposalBatcher("test", 2, 10, post)
+
+	if err := pb.Send("org/n1", []byte("msg")); err == nil {
+		t.Errorf("expected an error from the batch post")
+	}
+
+}
+
+func Test_proposal_batcher_enabled(t *testing.T) {
+
+	var pb *ProposalBatcher
+	if pb.Enabled() {
+		t.Errorf("nil batcher should not be enabled")
+	} else if NewProposalBatcher("test", 1, 10, nil).Enabled() {
+		t.Errorf("batch size of 1 should not be enabled")
+	} else if !NewProposalBatcher("test", 2, 10, nil).Enabled() {
+		t.Errorf("batch size of 2 should be enabled")
+	}
+
+}
@@ -0,0 +1,365 @@
+package agreementbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"sort"
+	"sync"
+	"time"
+)
+
+// An agbot that is hosted for many orgs shares its agreement workers, its database and its exchange calls between
+// them. The org quotas keep one org from using up that capacity: the number of agreements with the org's nodes, the
+// number of proposals per minute made to the org's nodes, and the number of the org's patterns that are served. The
+// quotas are enforced by the agbot worker for every agreement protocol at once, before an agreement attempt is
+// queued. A node that is over its org's quota is not lost, the agbot finds it again on a later search.
+//
+// The quotas come from the OrgQuotas in the agbot config, and can be replaced at runtime through the API. The
+// replacements are saved in the database, so they survive an agbot restart. The quota of an org is looked up in
+// this order: the org's replacement, the org's config, the "*" replacement and the "*" config.
+const QUOTA = "quota"
+
+const QUOTA_DEFAULT_ORG = "*"
+
+// The quotas, as they are named in the events and the API.
+const QUOTA_AGREEMENTS = "agreements"
+const QUOTA_PROPOSALS_PER_MIN = "proposalsPerMin"
+const QUOTA_SERVED_PATTERNS = "servedPatterns"
+
+// Where the quota of an org comes from.
+const QUOTA_SOURCE_RUNTIME = "runtime"
+const QUOTA_SOURCE_CONFIG = "config"
+const QUOTA_SOURCE_NONE = "none"
+
+// The quotas of an org, zero means no limit.
+type OrgQuota struct {
+	MaxAgreements      int `json:"max_agreements"`
+	MaxProposalsPerMin int `json:"max_proposals_per_min"`
+	MaxServedPatterns  int `json:"max_served_patterns"`
+}
+
+func (q OrgQuota) String() string {
+	return fmt.Sprintf("MaxAgreements: %v, MaxProposalsPerMin: %v, MaxServedPatterns: %v", q.MaxAgreements, q.MaxProposalsPerMin, q.MaxServedPatterns)
+}
+
+func (q OrgQuota) Validate() error {
+	if q.MaxAgreements < 0 || q.MaxProposalsPerMin < 0 || q.MaxServedPatterns < 0 {
+		return fmt.Errorf("quotas can not be negative")
+	}
+	return nil
+}
+
+// How much of its quotas an org is using, as seen by the most recent search and served pattern update.
+type QuotaUsage struct {
+	Agreements     int      `json:"agreements"`
+	ServedPatterns int      `json:"served_patterns"`
+	Exceeded       []string `json:"exceeded"` // the quotas the org is over
+}
+
+func (u QuotaUsage) String() string {
+	return fmt.Sprintf("Agreements: %v, ServedPatterns: %v, Exceeded: %v", u.Agreements, u.ServedPatterns, u.Exceeded)
+}
+
+// The quota of an org, where it comes from, and its usage when the agbot is running.
+type OrgQuotaStatus struct {
+	Org    string      `json:"org"`
+	Quota  OrgQuota    `json:"quota"`
+	Source string      `json:"source"`
+	Usage  *QuotaUsage `json:"usage,omitempty"`
+}
+
+// Returns the quotas that were replaced at runtime, keyed by org.
+func FindOrgQuotas(db *bolt.DB) (map[string]OrgQuota, error) {
+	quotas := make(map[string]OrgQuota)
+
+	readErr := db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(QUOTA)); b != nil {
+			return b.ForEach(func(k, v []byte) error {
+				var q OrgQuota
+				if err := json.Unmarshal(v, &q); err != nil {
+					return fmt.Errorf("Unable to deserialize quota of org %v: %v", string(k), err)
+				}
+				quotas[string(k)] = q
+				return nil
+			})
+		}
+		return nil
+	})
+
+	if readErr != nil {
+		return nil, readErr
+	}
+	return quotas, nil
+}
+
+// Replace the quota of an org at runtime.
+func SetOrgQuota(db *bolt.DB, org string, quota OrgQuota) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if b, err := tx.CreateBucketIfNotExists([]byte(QUOTA)); err != nil {
+			return err
+		} else if serial, err := json.Marshal(quota); err != nil {
+			return fmt.Errorf("Unable to serialize quota %v of org %v: %v", quota, org, err)
+		} else {
+			return b.Put([]byte(org), serial)
+		}
+	})
+}
+
+// Remove the runtime replacement of an org's quota, the org goes back to its configured quota.
+func DeleteOrgQuota(db *bolt.DB, org string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(QUOTA)); b == nil {
+			return nil
+		} else {
+			return b.Delete([]byte(org))
+		}
+	})
+}
+
+// Returns the quota of an org and where it comes from, given the runtime replacements and the config.
+func lookupOrgQuota(org string, replaced map[string]OrgQuota, configured map[string]config.OrgQuotaConfig) (OrgQuota, string) {
+	for _, key := range []string{org, QUOTA_DEFAULT_ORG} {
+		if q, ok := replaced[key]; ok {
+			return q, QUOTA_SOURCE_RUNTIME
+		} else if c, ok := configured[key]; ok {
+			return OrgQuota{MaxAgreements: c.MaxAgreements, MaxProposalsPerMin: c.MaxProposalsPerMin, MaxServedPatterns: c.MaxServedPatterns}, QUOTA_SOURCE_CONFIG
+		}
+	}
+	return OrgQuota{}, QUOTA_SOURCE_NONE
+}
+
+// Returns the quota of an org and where it comes from.
+func EffectiveOrgQuota(db *bolt.DB, configured map[string]config.OrgQuotaConfig, org string) (OrgQuota, string, error) {
+	replaced, err := FindOrgQuotas(db)
+	if err != nil {
+		return OrgQuota{}, "", err
+	}
+	q, source := lookupOrgQuota(org, replaced, configured)
+	return q, source, nil
+}
+
+// The quota manager enforces the org quotas. The agreement counts are taken from the database at the start of each
+// search, and the attempts queued during the search are added to them. An event is sent to the webhooks when an org
+// goes over a quota. For the agreements and proposals quotas, the org is back within the quota once a whole search
+// goes by without the quota stopping a proposal, so that an org that stays at its limit does not send an event for
+// every search.
+type QuotaManager struct {
+	db         *bolt.DB
+	configured map[string]config.OrgQuotaConfig
+	webhooks   *WebhookNotifier
+	replaced   map[string]OrgQuota     // the runtime replacements, read at the start of each search
+	agreements map[string]int          // the agreements of each org, including the attempts queued during this search
+	patterns   map[string]int          // the served patterns of each org
+	proposals  map[string]*tokenBucket // the proposals per minute of each org
+	exceeded   map[string]bool         // the org/quota pairs that are over their agreements or proposals quota
+	hit        map[string]bool         // the org/quota pairs that stopped a proposal during this search
+	overServed map[string]bool         // the orgs that are over their served patterns quota
+	lock       sync.Mutex
+}
+
+func NewQuotaManager(db *bolt.DB, configured map[string]config.OrgQuotaConfig, webhooks *WebhookNotifier) *QuotaManager {
+	return &QuotaManager{
+		db:         db,
+		configured: configured,
+		webhooks:   webhooks,
+		replaced:   make(map[string]OrgQuota),
+		agreements: make(map[string]int),
+		patterns:   make(map[string]int),
+		proposals:  make(map[string]*tokenBucket),
+		exceeded:   make(map[string]bool),
+		hit:        make(map[string]bool),
+		overServed: make(map[string]bool),
+	}
+}
+
+func (m *QuotaManager) String() string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return fmt.Sprintf("Configured: %v, Replaced: %v, Agreements: %v, Patterns: %v, Exceeded: %v, OverServed: %v", m.configured, m.replaced, m.agreements, m.patterns, m.exceeded, m.overServed)
+}
+
+func quotaKey(org string, quota string) string {
+	return org + "/" + quota
+}
+
+func (m *QuotaManager) quota(org string) OrgQuota {
+	q, _ := lookupOrgQuota(org, m.replaced, m.configured)
+	return q
+}
+
+// Record that a quota stopped a proposal to a node of an org, and send the event when the org just went over it.
+func (m *QuotaManager) exceed(org string, quota string, limit int) {
+	key := quotaKey(org, quota)
+	m.hit[key] = true
+	if !m.exceeded[key] {
+		m.exceeded[key] = true
+		m.notify(org, quota, limit)
+	}
+}
+
+func (m *QuotaManager) notify(org string, quota string, limit int) {
+	glog.Warningf(QMlogString(fmt.Sprintf("org %v reached its %v quota of %v", org, quota, limit)))
+	m.webhooks.Notify(NewQuotaWebhookEvent(org, quota, limit))
+}
+
+// Read the runtime quotas, the previous ones are kept when they cant be read. The caller must hold the lock.
+func (m *QuotaManager) readReplaced() {
+	if replaced, err := FindOrgQuotas(m.db); err != nil {
+		glog.Errorf(QMlogString(fmt.Sprintf("unable to read the org quotas, keeping the previous ones, error: %v", err)))
+	} else {
+		m.replaced = replaced
+	}
+}
+
+// Start a search: read the runtime quotas and count the agreements of each org. The orgs whose agreements and
+// proposals quotas did not stop a proposal during the previous search are back within those quotas.
+func (m *QuotaManager) BeginSearch(protocols []string) {
+
+	agreements := make(map[string]int)
+	for _, agp := range protocols {
+		if ags, err := FindAgreements(m.db, []AFilter{UnarchivedAFilter()}, agp); err != nil {
+			glog.Errorf(QMlogString(fmt.Sprintf("unable to count the agreements for protocol %v, error: %v", agp, err)))
+		} else {
+			for _, ag := range ags {
+				agreements[ag.Org] += 1
+			}
+		}
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.readReplaced()
+	m.agreements = agreements
+	for key, _ := range m.exceeded {
+		if !m.hit[key] {
+			delete(m.exceeded, key)
+		}
+	}
+	m.hit = make(map[string]bool)
+}
+
+// Returns true when a proposal can be made to a node of the org, and counts it against the org's quotas.
+func (m *QuotaManager) Allow(org string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	q := m.quota(org)
+	if q.MaxAgreements > 0 && m.agreements[org] >= q.MaxAgreements {
+		m.exceed(org, QUOTA_AGREEMENTS, q.MaxAgreements)
+		return false
+	}
+
+	if q.MaxProposalsPerMin > 0 {
+		b := refill(m.proposals, org, q.MaxProposalsPerMin, time.Now())
+		if b.tokens < 1 {
+			m.exceed(org, QUOTA_PROPOSALS_PER_MIN, q.MaxProposalsPerMin)
+			return false
+		}
+		b.tokens -= 1
+	}
+
+	m.agreements[org] += 1
+	return true
+}
+
+type servedPatternNames []string
+
+func (s servedPatternNames) Len() int           { return len(s) }
+func (s servedPatternNames) Less(i, j int) bool { return s[i] < s[j] }
+func (s servedPatternNames) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// Returns the served patterns that are within the served patterns quota of their org. The patterns of an org are
+// kept in order of their name, so that the same patterns are served each time.
+func (m *QuotaManager) LimitServedPatterns(served map[string]exchange.ServedPattern) map[string]exchange.ServedPattern {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.readReplaced()
+	byOrg := make(map[string]servedPatternNames)
+	for key, sp := range served {
+		byOrg[sp.Org] = append(byOrg[sp.Org], key)
+	}
+
+	limited := make(map[string]exchange.ServedPattern)
+	patterns := make(map[string]int)
+	for org, keys := range byOrg {
+		sort.Sort(keys)
+		q := m.quota(org)
+		if q.MaxServedPatterns > 0 && len(keys) > q.MaxServedPatterns {
+			for _, key := range keys[q.MaxServedPatterns:] {
+				glog.V(3).Infof(QMlogString(fmt.Sprintf("not serving pattern %v, org %v is over its served patterns quota", served[key].Pattern, org)))
+			}
+			keys = keys[:q.MaxServedPatterns]
+			if !m.overServed[org] {
+				m.overServed[org] = true
+				m.notify(org, QUOTA_SERVED_PATTERNS, q.MaxServedPatterns)
+			}
+		} else {
+			delete(m.overServed, org)
+		}
+		for _, key := range keys {
+			limited[key] = served[key]
+		}
+		patterns[org] = len(keys)
+	}
+
+	// The orgs that are no longer served are within their quota.
+	for org, _ := range m.overServed {
+		if _, ok := byOrg[org]; !ok {
+			delete(m.overServed, org)
+		}
+	}
+	m.patterns = patterns
+	return limited
+}
+
+// Returns the usage of an org's quotas.
+func (m *QuotaManager) Usage(org string) *QuotaUsage {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	u := &QuotaUsage{
+		Agreements:     m.agreements[org],
+		ServedPatterns: m.patterns[org],
+		Exceeded:       make([]string, 0, 3),
+	}
+	for _, quota := range []string{QUOTA_AGREEMENTS, QUOTA_PROPOSALS_PER_MIN} {
+		if m.exceeded[quotaKey(org, quota)] {
+			u.Exceeded = append(u.Exceeded, quota)
+		}
+	}
+	if m.overServed[org] {
+		u.Exceeded = append(u.Exceeded, QUOTA_SERVED_PATTERNS)
+	}
+	return u
+}
+
+// Returns the orgs that the quota manager has seen agreements or served patterns for.
+func (m *QuotaManager) Orgs() []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	orgs := make([]string, 0, len(m.agreements)+len(m.patterns))
+	for org, _ := range m.agreements {
+		orgs = append(orgs, org)
+	}
+	for org, _ := range m.patterns {
+		if _, ok := m.agreements[org]; !ok {
+			orgs = append(orgs, org)
+		}
+	}
+	return orgs
+}
+
+var QMlogString = func(v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("QuotaManager", nil, v)
+	}
+	return fmt.Sprintf("QuotaManager %v", v)
+}
@@ -0,0 +1,185 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/policy"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func openQuotaDB(t *testing.T) (*bolt.DB, func()) {
+	dir, err := ioutil.TempDir("", "agbot-quota")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	db, err := bolt.Open(path.Join(dir, "agbot.db"), 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("unable to open db: %v", err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// The quota of an org is looked up in the org's runtime quota, the org's config, then the same for "*".
+func Test_OrgQuota_lookup(t *testing.T) {
+
+	db, cleanup := openQuotaDB(t)
+	defer cleanup()
+
+	configured := map[string]config.OrgQuotaConfig{
+		"*":     {MaxAgreements: 10},
+		"myorg": {MaxAgreements: 20, MaxServedPatterns: 2},
+	}
+
+	check := func(org string, max int, source string) {
+		if q, s, err := EffectiveOrgQuota(db, configured, org); err != nil {
+			t.Fatalf("unable to find quota of %v: %v", org, err)
+		} else if q.MaxAgreements != max || s != source {
+			t.Errorf("org %v should have max agreements %v from %v, has %v from %v", org, max, source, q, s)
+		}
+	}
+
+	check("myorg", 20, QUOTA_SOURCE_CONFIG)
+	check("other", 10, QUOTA_SOURCE_CONFIG)
+
+	if err := SetOrgQuota(db, "*", OrgQuota{MaxAgreements: 30}); err != nil {
+		t.Fatalf("unable to set quota: %v", err)
+	}
+	check("myorg", 20, QUOTA_SOURCE_CONFIG)
+	check("other", 30, QUOTA_SOURCE_RUNTIME)
+
+	if err := SetOrgQuota(db, "myorg", OrgQuota{MaxAgreements: 40}); err != nil {
+		t.Fatalf("unable to set quota: %v", err)
+	}
+	check("myorg", 40, QUOTA_SOURCE_RUNTIME)
+
+	if err := DeleteOrgQuota(db, "myorg"); err != nil {
+		t.Fatalf("unable to delete quota: %v", err)
+	} else if err := DeleteOrgQuota(db, "*"); err != nil {
+		t.Fatalf("unable to delete quota: %v", err)
+	}
+	check("myorg", 20, QUOTA_SOURCE_CONFIG)
+
+	if q, s, err := EffectiveOrgQuota(db, nil, "myorg"); err != nil {
+		t.Fatalf("unable to find quota: %v", err)
+	} else if q != (OrgQuota{}) || s != QUOTA_SOURCE_NONE {
+		t.Errorf("org without a quota should have no limits, has %v from %v", q, s)
+	}
+
+	if err := (OrgQuota{MaxProposalsPerMin: -1}).Validate(); err == nil {
+		t.Errorf("negative quota should not be valid")
+	}
+}
+
+// The agreements in the database and the attempts queued during a search count against the agreements quota. An
+// event is sent when the org goes over the quota, and not again until a search goes by without hitting it.
+func Test_QuotaManager_agreements(t *testing.T) {
+
+	db, cleanup := openQuotaDB(t)
+	defer cleanup()
+
+	for _, id := range []string{"ag1", "ag2"} {
+		if err := AgreementAttempt(db, id, "myorg", "myorg/"+id, "policy", "", "", "", policy.BasicProtocol, "", policy.NodeHealth{}, nil); err != nil {
+			t.Fatalf("unable to create agreement %v: %v", id, err)
+		}
+	}
+	if _, err := ArchiveAgreement(db, "ag2", policy.BasicProtocol, 0, ""); err != nil {
+		t.Fatalf("unable to archive agreement: %v", err)
+	}
+
+	m := NewQuotaManager(db, map[string]config.OrgQuotaConfig{"myorg": {MaxAgreements: 3}}, nil)
+	m.BeginSearch(policy.AllAgreementProtocols())
+	if u := m.Usage("myorg"); u.Agreements != 1 {
+		t.Errorf("archived agreements should not be counted, usage is %v", u)
+	}
+
+	if !m.Allow("myorg") || !m.Allow("myorg") {
+		t.Errorf("org should be allowed up to its quota")
+	} else if m.Allow("myorg") {
+		t.Errorf("org should be over its quota")
+	} else if u := m.Usage("myorg"); u.Agreements != 3 || len(u.Exceeded) != 1 || u.Exceeded[0] != QUOTA_AGREEMENTS {
+		t.Errorf("wrong usage %v", u)
+	} else if !m.Allow("other") {
+		t.Errorf("org without a quota should be allowed")
+	}
+
+	// Still over the quota during the next search.
+	m.BeginSearch(policy.AllAgreementProtocols())
+	if u := m.Usage("myorg"); len(u.Exceeded) != 1 {
+		t.Errorf("org that hit its quota in the previous search should still be over it, usage is %v", u)
+	}
+
+	// A larger quota at runtime is used from the next search.
+	if err := SetOrgQuota(db, "myorg", OrgQuota{MaxAgreements: 5}); err != nil {
+		t.Fatalf("unable to set quota: %v", err)
+	}
+	m.BeginSearch(policy.AllAgreementProtocols())
+	if !m.Allow("myorg") {
+		t.Errorf("org should be allowed by its runtime quota")
+	}
+	m.BeginSearch(policy.AllAgreementProtocols())
+	if u := m.Usage("myorg"); len(u.Exceeded) != 0 {
+		t.Errorf("org should be back within its quota, usage is %v", u)
+	}
+}
+
+func Test_QuotaManager_proposals(t *testing.T) {
+
+	db, cleanup := openQuotaDB(t)
+	defer cleanup()
+
+	m := NewQuotaManager(db, map[string]config.OrgQuotaConfig{"*": {MaxProposalsPerMin: 2}}, nil)
+	m.BeginSearch(policy.AllAgreementProtocols())
+	if !m.Allow("org1") || !m.Allow("org1") {
+		t.Errorf("org should be allowed up to its quota")
+	} else if m.Allow("org1") {
+		t.Errorf("org should be over its proposals quota")
+	} else if !m.Allow("org2") {
+		t.Errorf("each org should have its own proposals quota")
+	} else if u := m.Usage("org1"); len(u.Exceeded) != 1 || u.Exceeded[0] != QUOTA_PROPOSALS_PER_MIN {
+		t.Errorf("wrong usage %v", u)
+	}
+}
+
+// The patterns of an org beyond its quota are left out, in order of their name.
+func Test_QuotaManager_served_patterns(t *testing.T) {
+
+	db, cleanup := openQuotaDB(t)
+	defer cleanup()
+
+	served := map[string]exchange.ServedPattern{
+		"myorg_c":    {Org: "myorg", Pattern: "c"},
+		"myorg_a":    {Org: "myorg", Pattern: "a"},
+		"myorg_b":    {Org: "myorg", Pattern: "b"},
+		"otherorg_a": {Org: "otherorg", Pattern: "a"},
+	}
+
+	m := NewQuotaManager(db, map[string]config.OrgQuotaConfig{"myorg": {MaxServedPatterns: 2}}, nil)
+	limited := m.LimitServedPatterns(served)
+	if len(limited) != 3 {
+		t.Errorf("wrong served patterns %v", limited)
+	} else if _, ok := limited["myorg_c"]; ok {
+		t.Errorf("the last pattern by name should not be served, served %v", limited)
+	} else if _, ok := limited["otherorg_a"]; !ok {
+		t.Errorf("org without a quota should keep its patterns, served %v", limited)
+	} else if u := m.Usage("myorg"); u.ServedPatterns != 2 || len(u.Exceeded) != 1 || u.Exceeded[0] != QUOTA_SERVED_PATTERNS {
+		t.Errorf("wrong usage %v", u)
+	}
+
+	delete(served, "myorg_c")
+	if limited := m.LimitServedPatterns(served); len(limited) != 3 {
+		t.Errorf("wrong served patterns %v", limited)
+	} else if u := m.Usage("myorg"); len(u.Exceeded) != 0 {
+		t.Errorf("org should be back within its quota, usage is %v", u)
+	}
+}
@@ -0,0 +1,98 @@
+package agreementbot
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// The agreement rate limiter caps how fast a consumer protocol handler starts new agreements, separately for each
+// org and for each pattern. Without it, a pattern with a very large number of nodes keeps the agreement workers and
+// the exchange message API busy with its proposals, so the nodes of the other orgs served by the agbot wait a long
+// time for their agreements. Each org and each pattern gets a token bucket that refills at the configured rate per
+// minute and holds up to one minute's worth of tokens. A new agreement needs a token from both its org's and its
+// pattern's bucket. A node that is over the limit is not lost, the agbot finds it again on a later search.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type AgreementRateLimiter struct {
+	orgPerMin     int // new agreements per minute for each org, zero means no limit
+	patternPerMin int // new agreements per minute for each pattern, zero means no limit
+	orgs          map[string]*tokenBucket
+	patterns      map[string]*tokenBucket
+	lock          sync.Mutex
+}
+
+func NewAgreementRateLimiter(orgPerMin int, patternPerMin int) *AgreementRateLimiter {
+	return &AgreementRateLimiter{
+		orgPerMin:     orgPerMin,
+		patternPerMin: patternPerMin,
+		orgs:          make(map[string]*tokenBucket),
+		patterns:      make(map[string]*tokenBucket),
+	}
+}
+
+func (l *AgreementRateLimiter) String() string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return fmt.Sprintf("OrgPerMin: %v, PatternPerMin: %v, Orgs: %v, Patterns: %v", l.orgPerMin, l.patternPerMin, len(l.orgs), len(l.patterns))
+}
+
+// Rate limiting is only done when a limit is configured.
+func (l *AgreementRateLimiter) Enabled() bool {
+	return l != nil && (l.orgPerMin > 0 || l.patternPerMin > 0)
+}
+
+// Returns true when a new agreement can be started for the org and pattern, and uses up a token from each of their
+// buckets. The pattern is empty for agreements that are not made for a pattern.
+func (l *AgreementRateLimiter) Allow(org string, pattern string) bool {
+	if !l.Enabled() {
+		return true
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	var orgBucket, patternBucket *tokenBucket
+	if l.orgPerMin > 0 {
+		orgBucket = refill(l.orgs, org, l.orgPerMin, now)
+	}
+	if l.patternPerMin > 0 && pattern != "" {
+		patternBucket = refill(l.patterns, pattern, l.patternPerMin, now)
+	}
+
+	// Only take the tokens when both buckets have one, so that a pattern that is over its limit does not use up
+	// the tokens of its org.
+	if (orgBucket != nil && orgBucket.tokens < 1) || (patternBucket != nil && patternBucket.tokens < 1) {
+		return false
+	}
+	if orgBucket != nil {
+		orgBucket.tokens -= 1
+	}
+	if patternBucket != nil {
+		patternBucket.tokens -= 1
+	}
+	return true
+}
+
+// Add the tokens earned since the bucket was last used, up to one minute's worth, and return the bucket. A new
+// bucket starts full.
+func refill(buckets map[string]*tokenBucket, key string, perMin int, now time.Time) *tokenBucket {
+	capacity := float64(perMin)
+	b, ok := buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: capacity, last: now}
+		buckets[key] = b
+		return b
+	}
+
+	b.tokens += now.Sub(b.last).Minutes() * capacity
+	if b.tokens > capacity {
+		b.tokens = capacity
+	}
+	b.last = now
+	return b
+}
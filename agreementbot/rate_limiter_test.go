@@ -0,0 +1,77 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_rate_limiter_disabled(t *testing.T) {
+
+	var l *AgreementRateLimiter
+	if l.Enabled() || !l.Allow("org", "org/pattern") {
+		t.Errorf("a nil limiter should allow everything")
+	}
+
+	l = NewAgreementRateLimiter(0, 0)
+	for i := 0; i < 100; i++ {
+		if !l.Allow("org", "org/pattern") {
+			t.Errorf("a limiter without limits should allow everything")
+		}
+	}
+}
+
+// A pattern over its limit does not use up its org's tokens, so the org's other patterns still get agreements.
+func Test_rate_limiter_pattern(t *testing.T) {
+
+	l := NewAgreementRateLimiter(5, 3)
+	for i := 0; i < 3; i++ {
+		if !l.Allow("org", "org/big") {
+			t.Errorf("agreement %v for the pattern should be allowed", i)
+		}
+	}
+	if l.Allow("org", "org/big") {
+		t.Errorf("pattern should be over its limit")
+	}
+	if !l.Allow("org", "org/small") || !l.Allow("org", "org/small") {
+		t.Errorf("other patterns in the org should be allowed until the org is over its limit")
+	}
+	if l.Allow("org", "org/small") {
+		t.Errorf("org should be over its limit")
+	}
+	if !l.Allow("other", "other/big") {
+		t.Errorf("other orgs should not be limited")
+	}
+}
+
+func Test_rate_limiter_refill(t *testing.T) {
+
+	l := NewAgreementRateLimiter(60, 0)
+	for i := 0; i < 60; i++ {
+		l.Allow("org", "")
+	}
+	if l.Allow("org", "") {
+		t.Errorf("org should be over its limit")
+	}
+
+	// Pretend 2 seconds went by, which earns 2 tokens at 60 per minute.
+	l.orgs["org"].last = l.orgs["org"].last.Add(-2 * time.Second)
+	if !l.Allow("org", "") || !l.Allow("org", "") {
+		t.Errorf("org should have earned 2 tokens")
+	} else if l.Allow("org", "") {
+		t.Errorf("org should not have earned a third token")
+	}
+
+	// The bucket never holds more than a minute's worth.
+	l.orgs["org"].last = l.orgs["org"].last.Add(-time.Hour)
+	allowed := 0
+	for i := 0; i < 100; i++ {
+		if l.Allow("org", "") {
+			allowed++
+		}
+	}
+	if allowed != 60 {
+		t.Errorf("expected 60 agreements after a full refill, got %v", allowed)
+	}
+}
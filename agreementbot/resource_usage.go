@@ -0,0 +1,81 @@
+package agreementbot
+
+import (
+	"github.com/open-horizon/anax/events"
+	"sort"
+)
+
+// Nodes periodically report the resources used by the workloads of each agreement. The latest report is saved with
+// the agreement, and the reports of the active agreements are summarized so that operators can see how heavily the
+// fleet is used.
+
+type ResourceUsageSample struct {
+	AgreementId string               `json:"agreement_id"`
+	Org         string               `json:"org"`
+	DeviceId    string               `json:"device_id"`
+	Pattern     string               `json:"pattern"`
+	PolicyName  string               `json:"policy_name"`
+	Usage       events.ResourceUsage `json:"usage"`
+}
+
+type FleetResourceUsage struct {
+	Agreements    int                   `json:"agreements"` // the number of active agreements
+	Reporting     int                   `json:"reporting"`  // the number of active agreements with a resource usage report
+	Containers    int                   `json:"containers"`
+	CPUPercent    float64               `json:"cpu_percent"`
+	MemoryMB      float64               `json:"memory_mb"`
+	MemoryLimitMB float64               `json:"memory_limit_mb"`
+	DiskMB        float64               `json:"disk_mb"`
+	Samples       []ResourceUsageSample `json:"samples"`
+}
+
+// Summarize the resource usage of the active agreements. Archived agreements and agreements being terminated are
+// ignored. The samples are sorted by device id.
+func SummarizeResourceUsage(ags []Agreement) FleetResourceUsage {
+
+	fleet := FleetResourceUsage{Samples: []ResourceUsageSample{}}
+	for _, ag := range ags {
+		if ag.Archived || ag.AgreementTimedout != 0 {
+			continue
+		}
+		fleet.Agreements += 1
+		if ag.ResourceUsage == nil {
+			continue
+		}
+
+		fleet.Reporting += 1
+		fleet.Containers += ag.ResourceUsage.Containers
+		fleet.CPUPercent += ag.ResourceUsage.CPUPercent
+		fleet.MemoryMB += ag.ResourceUsage.MemoryMB
+		fleet.MemoryLimitMB += ag.ResourceUsage.MemoryLimitMB
+		fleet.DiskMB += ag.ResourceUsage.DiskMB
+		fleet.Samples = append(fleet.Samples, ResourceUsageSample{
+			AgreementId: ag.CurrentAgreementId,
+			Org:         ag.Org,
+			DeviceId:    ag.DeviceId,
+			Pattern:     ag.Pattern,
+			PolicyName:  ag.PolicyName,
+			Usage:       *ag.ResourceUsage,
+		})
+	}
+
+	sort.Sort(ResourceUsageSamplesByDeviceId(fleet.Samples))
+	return fleet
+}
+
+type ResourceUsageSamplesByDeviceId []ResourceUsageSample
+
+func (s ResourceUsageSamplesByDeviceId) Len() int {
+	return len(s)
+}
+
+func (s ResourceUsageSamplesByDeviceId) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s ResourceUsageSamplesByDeviceId) Less(i, j int) bool {
+	if s[i].DeviceId == s[j].DeviceId {
+		return s[i].AgreementId < s[j].AgreementId
+	}
+	return s[i].DeviceId < s[j].DeviceId
+}
@@ -0,0 +1,60 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/policy"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func Test_ResourceUsage(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "agbot-usage")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := bolt.Open(path.Join(dir, "agbot.db"), 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("unable to open db: %v", err)
+	}
+	defer db.Close()
+
+	for _, id := range []string{"ag1", "ag2", "ag3", "ag4"} {
+		if err := AgreementAttempt(db, id, "myorg", "myorg/"+id, "policy", "", "", "", policy.BasicProtocol, "myorg/pat", policy.NodeHealth{}, nil); err != nil {
+			t.Fatalf("unable to create agreement %v: %v", id, err)
+		}
+	}
+
+	// ag3 doesn't report, ag4 is being terminated.
+	for _, id := range []string{"ag2", "ag1", "ag4"} {
+		usage := events.ResourceUsage{SampleTime: 1, Containers: 1, CPUPercent: 10, MemoryMB: 20, MemoryLimitMB: 100, DiskMB: 1}
+		if ag, err := AgreementResourceUsage(db, id, policy.BasicProtocol, usage); err != nil {
+			t.Fatalf("unable to save resource usage for %v: %v", id, err)
+		} else if ag.ResourceUsage == nil || *ag.ResourceUsage != usage {
+			t.Errorf("wrong resource usage saved for %v: %v", id, ag.ResourceUsage)
+		}
+	}
+	if _, err := AgreementTimedout(db, "ag4", policy.BasicProtocol); err != nil {
+		t.Fatalf("unable to time out agreement: %v", err)
+	}
+
+	ags, err := FindAgreements(db, []AFilter{UnarchivedAFilter()}, policy.BasicProtocol)
+	if err != nil {
+		t.Fatalf("unable to find agreements: %v", err)
+	}
+
+	fleet := SummarizeResourceUsage(ags)
+	if fleet.Agreements != 3 || fleet.Reporting != 2 || fleet.Containers != 2 || fleet.CPUPercent != 20 || fleet.MemoryMB != 40 || fleet.MemoryLimitMB != 200 || fleet.DiskMB != 2 {
+		t.Errorf("wrong fleet usage %v", fleet)
+	} else if len(fleet.Samples) != 2 || fleet.Samples[0].DeviceId != "myorg/ag1" || fleet.Samples[1].AgreementId != "ag2" {
+		t.Errorf("wrong usage samples %v", fleet.Samples)
+	}
+}
@@ -0,0 +1,333 @@
+package agreementbot
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/abstractprotocol"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/policy"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// A simulation runs the checks that an agreement worker makes before it proposes an agreement to a node, and creates
+// the proposal, for each policy the agbot made from a pattern. Nothing is sent to the node and nothing is saved, so
+// it can be used to find out why a node does not get an agreement. The agbot's rate limits, maintenance mode and
+// the agreements it already has with the node are not considered.
+
+// The stage at which a simulation found that no agreement would be made.
+const SIM_STAGE_PATTERN = "pattern"                // the agbot does not serve the pattern or the node uses another pattern
+const SIM_STAGE_ARCH = "arch"                      // the workload is for another hardware architecture than the node
+const SIM_STAGE_DEFINITION = "workloadDefinition"  // the workload or service definition could not be read from the exchange
+const SIM_STAGE_POLICY = "policyCompatibility"     // the policies of the node and the agbot are not compatible
+const SIM_STAGE_BUSINESS_POLICY = "businessPolicy" // the node does not match the business policy
+const SIM_STAGE_API_SPECS = "apiSpecs"             // the node does not have the services, or versions, the workload needs
+const SIM_STAGE_HA_GROUP = "haGroup"               // a partner of the node is not registered
+const SIM_STAGE_PLACEMENT = "placement"            // the workloads running on the node do not satisfy the placement
+const SIM_STAGE_TIME_WINDOW = "timeWindow"         // it is outside of the time windows of the policy
+const SIM_STAGE_IGNORED = "ignoredProperty"        // the node has a property the agbot is configured to ignore
+const SIM_STAGE_NODE_NOT_READY = "nodeNotReady"    // the node has not published its messaging key
+const SIM_STAGE_PROTOCOL = "agreementProtocol"     // the agreement protocol, or its blockchain, is not ready
+const SIM_STAGE_PROPOSAL = "proposal"              // the proposal could not be created
+
+// A workload (or service) version that would not be proposed to the node.
+type SimulatedRejection struct {
+	Workload string                                `json:"workload"`
+	Mismatch *abstractprotocol.DecisionExplanation `json:"mismatch"`
+}
+
+// The outcome of simulating the agreement of 1 agbot policy with the node.
+type AgreementSimulation struct {
+	Node     string                                `json:"node"`
+	Pattern  string                                `json:"pattern"`
+	Policy   string                                `json:"policy,omitempty"`   // the name of the agbot policy made from the pattern
+	Protocol string                                `json:"protocol,omitempty"` // the agreement protocol that would be used
+	Workload string                                `json:"workload,omitempty"` // the workload (or service) version that would be proposed
+	Proposal *abstractprotocol.BaseProposal        `json:"proposal,omitempty"` // the proposal that would be sent to the node
+	TsAndCs  *policy.Policy                        `json:"tsandcs,omitempty"`  // the terms and conditions in the proposal, for reading
+	Rejected []SimulatedRejection                  `json:"rejected,omitempty"` // the workloads that were considered and not chosen, by priority
+	Mismatch *abstractprotocol.DecisionExplanation `json:"mismatch,omitempty"` // why no agreement would be proposed
+}
+
+func (s AgreementSimulation) String() string {
+	return fmt.Sprintf("Node: %v, Pattern: %v, Policy: %v, Protocol: %v, Workload: %v, Rejected: %v, Mismatch: %v",
+		s.Node, s.Pattern, s.Policy, s.Protocol, s.Workload, s.Rejected, s.Mismatch)
+}
+
+// The exchange and the agreement protocols that a simulation uses, so that it can be run without them.
+type simulationSources struct {
+	getDevice       func(id string) (*exchange.Device, error)
+	getNodePolicy   func(id string) (*exchange.NodePolicy, error)
+	getAgreements   func(id string) (map[string]exchange.DeviceAgreement, error)
+	resolve         exchange.WorkloadOrServiceResolverHandler
+	protocolHandler func(protocol string, bcType string, bcName string, bcOrg string) abstractprotocol.ProtocolHandler
+}
+
+func simulationMismatch(stage string, detail string) *abstractprotocol.DecisionExplanation {
+	return &abstractprotocol.DecisionExplanation{Stage: stage, Detail: detail}
+}
+
+// The workloads of a policy, highest priority first.
+type workloadsByPriority []policy.Workload
+
+func (w workloadsByPriority) Len() int { return len(w) }
+func (w workloadsByPriority) Less(i, j int) bool {
+	return w[i].Priority.PriorityValue < w[j].Priority.PriorityValue
+}
+func (w workloadsByPriority) Swap(i, j int) { w[i], w[j] = w[j], w[i] }
+
+// Returns the hardware architecture of the node, from the API specs of the policies it registered.
+func nodeArch(dev *exchange.Device) string {
+	for _, ms := range append(dev.RegisteredServices, dev.RegisteredMicroservices...) {
+		if pol, err := policy.DemarshalPolicy(ms.Policy); err == nil {
+			for _, apiSpec := range pol.APISpecs {
+				if apiSpec.Arch != "" {
+					return apiSpec.Arch
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// Simulate the agreements of the node with the policies the agbot made from the pattern.
+func simulateAgreements(cfg *config.HorizonConfig, src simulationSources, myId string, nodeId string, patternId string, policies []policy.Policy) ([]AgreementSimulation, error) {
+
+	dev, err := src.getDevice(nodeId)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to get node %v from the exchange, error: %v", nodeId, err))
+	}
+
+	if dev.Pattern != patternId {
+		return []AgreementSimulation{{Node: nodeId, Pattern: patternId, Mismatch: simulationMismatch(SIM_STAGE_PATTERN, fmt.Sprintf("node is registered with pattern '%v'", dev.Pattern))}}, nil
+	}
+
+	sims := []AgreementSimulation{}
+	for _, pol := range policies {
+		if pol.PatternId == patternId {
+			sims = append(sims, simulatePolicy(cfg, src, myId, nodeId, dev, pol))
+		}
+	}
+	if len(sims) == 0 {
+		return []AgreementSimulation{{Node: nodeId, Pattern: patternId, Mismatch: simulationMismatch(SIM_STAGE_PATTERN, "the agbot does not serve the pattern, or has not made policies from it yet")}}, nil
+	}
+	return sims, nil
+}
+
+// Run the checks of an agreement worker for 1 agbot policy, see BaseAgreementWorker.InitiateNewAgreement.
+func simulatePolicy(cfg *config.HorizonConfig, src simulationSources, myId string, nodeId string, dev *exchange.Device, consumerPolicy policy.Policy) AgreementSimulation {
+
+	sim := AgreementSimulation{Node: nodeId, Pattern: consumerPolicy.PatternId, Policy: consumerPolicy.Header.Name}
+
+	basePolicy := policy.Policy_Factory(consumerPolicy.Header.Name)
+	basePolicy.ServiceBased = consumerPolicy.IsServiceBased()
+
+	var nodePolicy *exchange.NodePolicy
+	if consumerPolicy.BusinessPolId != "" {
+		if np, err := src.getNodePolicy(nodeId); err != nil {
+			sim.Mismatch = simulationMismatch(SIM_STAGE_BUSINESS_POLICY, fmt.Sprintf("unable to get the node policy, error: %v", err))
+			return sim
+		} else {
+			nodePolicy = np
+			addNodePolicy(basePolicy, nodePolicy)
+		}
+	}
+
+	canonicalArch := func(arch string) string {
+		if c := cfg.ArchSynonyms.GetCanonicalArch(arch); c != "" {
+			return c
+		}
+		return arch
+	}
+	devArch := canonicalArch(nodeArch(dev))
+
+	// The workloads for any hardware architecture are simulated for the arch of the node, as they are when the agbot
+	// makes the agreement.
+	workloads := make(workloadsByPriority, len(consumerPolicy.Workloads))
+	if devArch != "" {
+		copy(workloads, consumerPolicy.Workloads.ForArch(devArch))
+	} else {
+		copy(workloads, consumerPolicy.Workloads)
+	}
+	sort.Stable(workloads)
+
+	var chosen *policy.Workload
+	var producerPolicy *policy.Policy
+	for ix := range workloads {
+		workload := &workloads[ix]
+		name := fmt.Sprintf("%v/%v %v %v", workload.Org, workload.WorkloadURL, workload.Version, workload.Arch)
+		reject := func(mismatch *abstractprotocol.DecisionExplanation) {
+			sim.Rejected = append(sim.Rejected, SimulatedRejection{Workload: name, Mismatch: mismatch})
+		}
+
+		if devArch != "" && workload.Arch != "" && canonicalArch(workload.Arch) != devArch {
+			mismatch := simulationMismatch(SIM_STAGE_ARCH, fmt.Sprintf("the node is %v, the workload is for %v", devArch, workload.Arch))
+			mismatch.Property = "arch"
+			reject(mismatch)
+			continue
+		}
+
+		asl, details, err := src.resolve(workload.WorkloadURL, workload.Org, workload.Version, workload.Arch)
+		if err != nil {
+			reject(simulationMismatch(SIM_STAGE_DEFINITION, err.Error()))
+			continue
+		}
+		for ix, apiSpec := range *asl {
+			if apiSpec.Arch != "" {
+				(*asl)[ix].Arch = canonicalArch(apiSpec.Arch)
+			}
+		}
+
+		producer := basePolicy
+		if merged, err := mergeServicePolicies(dev, asl, details.IsServiceBased(), cfg.AgreementBot.NoDataIntervalS); err != nil {
+			reject(abstractprotocol.NewDecisionExplanation(SIM_STAGE_POLICY, err))
+			continue
+		} else if merged != nil {
+			if err := policy.Are_Compatible(merged, &consumerPolicy); err != nil {
+				reject(abstractprotocol.NewDecisionExplanation(SIM_STAGE_POLICY, err))
+				continue
+			}
+			addNodePolicy(merged, nodePolicy)
+			producer = merged
+		}
+
+		if consumerPolicy.BusinessPolId != "" {
+			if err := matchBusinessPolicy(producer, &consumerPolicy); err != nil {
+				reject(abstractprotocol.NewDecisionExplanation(SIM_STAGE_BUSINESS_POLICY, err))
+				continue
+			}
+		}
+
+		if err := producer.APISpecs.Supports(*asl); err != nil {
+			reject(abstractprotocol.NewDecisionExplanation(SIM_STAGE_API_SPECS, policy.NewCompatibilityError(policy.COMPAT_API_SPECS, err.Error(), err)))
+			continue
+		}
+
+		if _, err := addWorkloadDetails(workload, details); err != nil {
+			reject(simulationMismatch(SIM_STAGE_DEFINITION, err.Error()))
+			continue
+		}
+		consumerPolicy.APISpecs = *asl
+		chosen = workload
+		producerPolicy = producer
+		sim.Workload = name
+		break
+	}
+
+	if chosen == nil {
+		if len(sim.Rejected) != 0 {
+			sim.Mismatch = sim.Rejected[0].Mismatch
+		} else {
+			sim.Mismatch = simulationMismatch(SIM_STAGE_API_SPECS, "the policy has no workloads")
+		}
+		return sim
+	}
+
+	// The checks that an agreement worker makes once it has chosen the workload.
+	for _, partnerId := range producerPolicy.HAGroup.Partners {
+		if _, err := src.getDevice(partnerId); err != nil {
+			sim.Mismatch = simulationMismatch(SIM_STAGE_HA_GROUP, fmt.Sprintf("could not obtain HA partner %v from the exchange: %v", partnerId, err))
+			return sim
+		}
+	}
+
+	if !consumerPolicy.Placement.IsEmpty() {
+		if agreements, err := src.getAgreements(nodeId); err != nil {
+			sim.Mismatch = simulationMismatch(SIM_STAGE_PLACEMENT, fmt.Sprintf("could not obtain the agreements of the node from the exchange: %v", err))
+			return sim
+		} else if err := consumerPolicy.Placement.Is_Satisfied_By(runningWorkloads(agreements)); err != nil {
+			sim.Mismatch = simulationMismatch(SIM_STAGE_PLACEMENT, err.Error())
+			return sim
+		}
+	}
+
+	if windows := consumerPolicy.TimeWindows.ForNode(producerPolicy.Properties); !windows.Allows(time.Now(), nil) {
+		sim.Mismatch = simulationMismatch(SIM_STAGE_TIME_WINDOW, fmt.Sprintf("outside of time windows %v", windows))
+		return sim
+	}
+
+	for _, prop := range producerPolicy.Properties {
+		if listContains(cfg.AgreementBot.IgnoreContractWithAttribs, prop.Name) {
+			sim.Mismatch = simulationMismatch(SIM_STAGE_IGNORED, fmt.Sprintf("the node has property %v", prop.Name))
+			sim.Mismatch.Property = prop.Name
+			return sim
+		}
+	}
+
+	if len(dev.PublicKey) == 0 {
+		sim.Mismatch = simulationMismatch(SIM_STAGE_NODE_NOT_READY, "the node has not published its messaging key")
+		return sim
+	}
+
+	// Create the proposal the way the agreement protocol would, without sending it.
+	sim.Protocol = policy.Select_Protocol(producerPolicy, &consumerPolicy)
+	bcType, bcName, bcOrg := producerPolicy.RequiresKnownBC(sim.Protocol)
+	ph := src.protocolHandler(sim.Protocol, bcType, bcName, bcOrg)
+	if ph == nil {
+		sim.Mismatch = simulationMismatch(SIM_STAGE_PROTOCOL, fmt.Sprintf("agreement protocol %v is not ready for %v %v", sim.Protocol, bcType, bcName))
+		return sim
+	}
+
+	agreementId, err := cutil.GenerateAgreementId()
+	if err != nil {
+		sim.Mismatch = simulationMismatch(SIM_STAGE_PROPOSAL, fmt.Sprintf("unable to generate an agreement id, error: %v", err))
+		return sim
+	}
+
+	version := producerPolicy.MinimumProtocolVersion(ph.Name(), &consumerPolicy, ph.Version())
+	proposal, err := abstractprotocol.CreateProposal(ph, agreementId, producerPolicy, &consumerPolicy, version, myId, chosen, cfg.AgreementBot.DefaultWorkloadPW, cfg.AgreementBot.NoDataIntervalS, consumerPolicy.AgreementTimeouts.NoReply(cfg.AgreementBot.ProtocolTimeoutS))
+	if err != nil {
+		sim.Mismatch = simulationMismatch(SIM_STAGE_PROPOSAL, err.Error())
+		return sim
+	}
+	sim.Proposal = proposal
+	if tsandcs, err := policy.DemarshalPolicy(proposal.TsAndCs()); err == nil {
+		sim.TsAndCs = tsandcs
+	}
+	return sim
+}
+
+// Simulate the agreements the agbot would propose to the node for the pattern.
+func (w *AgreementBotWorker) SimulateAgreements(nodeId string, patternId string) ([]AgreementSimulation, error) {
+
+	if w.pm == nil {
+		return nil, errors.New("the agbot has not read its policies yet")
+	}
+
+	policies := []policy.Policy{}
+	for _, org := range w.pm.GetAllPolicyOrgs() {
+		policies = append(policies, w.pm.GetAllAvailablePolicies(org)...)
+	}
+
+	httpClient := func() *http.Client { return w.Config.Collaborators.HTTPClientFactory.NewHTTPClient(nil) }
+	src := simulationSources{
+		getDevice: func(id string) (*exchange.Device, error) {
+			return GetDevice(exchange.ShutdownContext(), httpClient(), id, w.GetExchangeURL(), w.GetExchangeId(), w.GetExchangeToken())
+		},
+		getNodePolicy: func(id string) (*exchange.NodePolicy, error) {
+			return exchange.GetNodePolicy(exchange.ShutdownContext(), httpClient(), id, w.GetExchangeURL(), w.GetExchangeId(), w.GetExchangeToken())
+		},
+		getAgreements: func(id string) (map[string]exchange.DeviceAgreement, error) {
+			return GetDeviceAgreements(exchange.ShutdownContext(), httpClient(), id, w.GetExchangeURL(), w.GetExchangeId(), w.GetExchangeToken())
+		},
+		resolve: exchange.GetHTTPWorkloadOrServiceResolverHandler(w),
+		protocolHandler: func(protocol string, bcType string, bcName string, bcOrg string) abstractprotocol.ProtocolHandler {
+			w.consumerPHLock.RLock()
+			defer w.consumerPHLock.RUnlock()
+			if cph, ok := w.consumerPH[protocol]; ok {
+				return cph.AgreementProtocolHandler(bcType, bcName, bcOrg)
+			}
+			return nil
+		},
+	}
+
+	sims, err := simulateAgreements(w.Config, src, w.GetExchangeId(), nodeId, patternId, policies)
+	if err == nil {
+		glog.V(3).Infof(fmt.Sprintf("AgreementBotWorker simulated agreements with %v for pattern %v: %v", nodeId, patternId, sims))
+	}
+	return sims, err
+}
@@ -0,0 +1,143 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"errors"
+	"github.com/open-horizon/anax/abstractprotocol"
+	"github.com/open-horizon/anax/basicprotocol"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/policy"
+	"testing"
+)
+
+const simNetURL = "https://bluehorizon.network/services/network"
+
+// A node that registered version 1.0.0 of the network service for the pattern.
+func simulationNode(t *testing.T) *exchange.Device {
+	pol := policy.Policy_Factory("network")
+	pol.APISpecs = policy.APISpecList{{SpecRef: simNetURL, Org: "myorg", Version: "1.0.0", Arch: "amd64"}}
+	pol.AgreementProtocols = policy.AgreementProtocolList{*policy.AgreementProtocol_Factory(policy.BasicProtocol)}
+	pol.ServiceBased = true
+	polString, err := policy.MarshalPolicy(pol)
+	if err != nil {
+		t.Fatalf("unable to marshal node policy, error: %v", err)
+	}
+	return &exchange.Device{
+		Pattern:            "myorg/netspeed",
+		RegisteredServices: []exchange.Microservice{{Url: simNetURL, Policy: polString}},
+		PublicKey:          []byte("key"),
+	}
+}
+
+func simulationSourcesFor(dev *exchange.Device) simulationSources {
+	return simulationSources{
+		getDevice: func(id string) (*exchange.Device, error) {
+			if id != "myorg/node1" {
+				return nil, errors.New("not found")
+			}
+			return dev, nil
+		},
+		getNodePolicy: func(id string) (*exchange.NodePolicy, error) { return nil, nil },
+		getAgreements: func(id string) (map[string]exchange.DeviceAgreement, error) { return nil, nil },
+		// Each workload version requires a different version range of the network service.
+		resolve: func(wUrl string, wOrg string, wVersion string, wArch string) (*policy.APISpecList, exchange.ExchangeDefinition, error) {
+			versions := map[string]string{"3.0.0": "[1.0.0,INFINITY)", "2.0.0": "[2.0.0,3.0.0)", "1.0.0": "[1.0.0,2.0.0)"}
+			asl := policy.APISpecList{{SpecRef: simNetURL, Org: "myorg", Version: versions[wVersion], Arch: wArch}}
+			return &asl, &exchange.ServiceDefinition{URL: wUrl, Version: wVersion, Arch: wArch}, nil
+		},
+		protocolHandler: func(protocol string, bcType string, bcName string, bcOrg string) abstractprotocol.ProtocolHandler {
+			return basicprotocol.NewProtocolHandler(nil, nil)
+		},
+	}
+}
+
+func simulationPolicy() policy.Policy {
+	pol := policy.Policy_Factory("myorg_netspeed_amd64")
+	pol.PatternId = "myorg/netspeed"
+	pol.AgreementProtocols = policy.AgreementProtocolList{*policy.AgreementProtocol_Factory(policy.BasicProtocol)}
+	workload := func(version string, arch string, priority int) policy.Workload {
+		return policy.Workload{WorkloadURL: "https://bluehorizon.network/services/netspeed", Org: "myorg", Version: version, Arch: arch, Priority: policy.WorkloadPriority{PriorityValue: priority}}
+	}
+	pol.Workloads = []policy.Workload{workload("1.0.0", "amd64", 3), workload("3.0.0", "arm", 1), workload("2.0.0", "amd64", 2)}
+	return *pol
+}
+
+func Test_simulateAgreements(t *testing.T) {
+
+	cfg := &config.HorizonConfig{}
+	dev := simulationNode(t)
+
+	sims, err := simulateAgreements(cfg, simulationSourcesFor(dev), "myorg/agbot1", "myorg/node1", "myorg/netspeed", []policy.Policy{simulationPolicy()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(sims) != 1 {
+		t.Fatalf("expected 1 simulation, got %v", sims)
+	}
+
+	// The workloads are tried by priority, the arch and the version range of the service rule out the first 2.
+	sim := sims[0]
+	if sim.Mismatch != nil || sim.Proposal == nil {
+		t.Fatalf("expected a proposal, got %v", sim)
+	} else if sim.Protocol != policy.BasicProtocol {
+		t.Errorf("expected protocol %v, got %v", policy.BasicProtocol, sim.Protocol)
+	} else if sim.TsAndCs == nil || len(sim.TsAndCs.Workloads) != 1 || sim.TsAndCs.Workloads[0].Version != "1.0.0" {
+		t.Errorf("expected the terms and conditions to have workload version 1.0.0, got %v", sim.TsAndCs)
+	}
+	if len(sim.Rejected) != 2 {
+		t.Fatalf("expected 2 rejected workloads, got %v", sim.Rejected)
+	}
+	if m := sim.Rejected[0].Mismatch; m.Stage != SIM_STAGE_ARCH || m.Property != "arch" {
+		t.Errorf("expected an arch mismatch, got %v", m)
+	}
+	if m := sim.Rejected[1].Mismatch; m.Stage != SIM_STAGE_API_SPECS || m.SpecRef != simNetURL || m.VersionRange != "[2.0.0,3.0.0)" {
+		t.Errorf("expected a version range mismatch, got %v", m)
+	}
+
+	// A node with another pattern, and a pattern the agbot does not serve.
+	sims, err = simulateAgreements(cfg, simulationSourcesFor(dev), "myorg/agbot1", "myorg/node1", "myorg/other", []policy.Policy{simulationPolicy()})
+	if err != nil || len(sims) != 1 || sims[0].Mismatch == nil || sims[0].Mismatch.Stage != SIM_STAGE_PATTERN {
+		t.Errorf("expected a pattern mismatch, got %v %v", sims, err)
+	}
+	dev.Pattern = "myorg/other"
+	sims, err = simulateAgreements(cfg, simulationSourcesFor(dev), "myorg/agbot1", "myorg/node1", "myorg/other", []policy.Policy{simulationPolicy()})
+	if err != nil || len(sims) != 1 || sims[0].Mismatch == nil || sims[0].Mismatch.Stage != SIM_STAGE_PATTERN {
+		t.Errorf("expected a pattern mismatch, got %v %v", sims, err)
+	}
+
+	// A node that is not in the exchange.
+	if _, err := simulateAgreements(cfg, simulationSourcesFor(dev), "myorg/agbot1", "myorg/node2", "myorg/netspeed", nil); err == nil {
+		t.Errorf("expected an error for an unknown node")
+	}
+}
+
+// A pattern that deploys the service to any arch is resolved for the arch of the node.
+func Test_simulateAgreements_anyArch(t *testing.T) {
+
+	pol := simulationPolicy()
+	pol.Workloads = []policy.Workload{{WorkloadURL: "https://bluehorizon.network/services/netspeed", Org: "myorg", Version: "1.0.0", Arch: policy.ANY_ARCH}}
+
+	sims, err := simulateAgreements(&config.HorizonConfig{}, simulationSourcesFor(simulationNode(t)), "myorg/agbot1", "myorg/node1", "myorg/netspeed", []policy.Policy{pol})
+	if err != nil || len(sims) != 1 {
+		t.Fatalf("expected 1 simulation, got %v %v", sims, err)
+	} else if sim := sims[0]; sim.Mismatch != nil || sim.TsAndCs == nil || len(sim.TsAndCs.Workloads) != 1 {
+		t.Fatalf("expected a proposal, got %v", sim)
+	} else if arch := sim.TsAndCs.Workloads[0].Arch; arch != "amd64" {
+		t.Errorf("expected the workload to be for amd64, got %v", arch)
+	}
+}
+
+// Without its messaging key, the node can not be sent a proposal.
+func Test_simulateAgreements_nodeNotReady(t *testing.T) {
+
+	dev := simulationNode(t)
+	dev.PublicKey = nil
+
+	sims, err := simulateAgreements(&config.HorizonConfig{}, simulationSourcesFor(dev), "myorg/agbot1", "myorg/node1", "myorg/netspeed", []policy.Policy{simulationPolicy()})
+	if err != nil || len(sims) != 1 {
+		t.Fatalf("expected 1 simulation, got %v %v", sims, err)
+	} else if sims[0].Proposal != nil || sims[0].Mismatch == nil || sims[0].Mismatch.Stage != SIM_STAGE_NODE_NOT_READY {
+		t.Errorf("expected the node not to be ready, got %v", sims[0])
+	}
+}
@@ -0,0 +1,48 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"github.com/open-horizon/anax/abstractprotocol"
+	"github.com/open-horizon/anax/policy"
+	"testing"
+)
+
+// The codes are sent to the nodes, they must not change when the reasons move into the registries.
+func Test_GetTerminationCode(t *testing.T) {
+
+	basic := &BasicProtocolHandler{}
+	cs := &CSProtocolHandler{}
+
+	tests := []struct {
+		reason string
+		basic  uint
+		cs     uint
+	}{
+		{TERM_REASON_POLICY_CHANGED, 204, 204},
+		{TERM_REASON_NOT_FINALIZED_TIMEOUT, abstractprotocol.UNKNOWN_REASON_CODE, 200},
+		{TERM_REASON_NO_REPLY, 201, 201},
+		{TERM_REASON_DEVICE_REQUESTED, 105, abstractprotocol.UNKNOWN_REASON_CODE},
+		{TERM_REASON_CANCEL_BC_WRITE_FAILED, abstractprotocol.UNKNOWN_REASON_CODE, 208},
+		{TERM_REASON_NODE_HEARTBEAT, 208, 209},
+		{TERM_REASON_OUTSIDE_TIME_WINDOW, 211, 212},
+	}
+	for _, test := range tests {
+		if code := basic.GetTerminationCode(test.reason); code != test.basic {
+			t.Errorf("%v: expected basic code %v, got %v", test.reason, test.basic, code)
+		}
+		if code := cs.GetTerminationCode(test.reason); code != test.cs {
+			t.Errorf("%v: expected %v code %v, got %v", test.reason, policy.CitizenScientist, test.cs, code)
+		}
+	}
+
+	// Every protocol has a code for the reasons a user can choose.
+	for _, reason := range userTerminationReasons {
+		if code := basic.GetTerminationCode(reason); code == abstractprotocol.UNKNOWN_REASON_CODE {
+			t.Errorf("basic protocol has no code for %v", reason)
+		}
+		if code := cs.GetTerminationCode(reason); code == abstractprotocol.UNKNOWN_REASON_CODE {
+			t.Errorf("%v protocol has no code for %v", policy.CitizenScientist, reason)
+		}
+	}
+}
@@ -0,0 +1,463 @@
+package agreementbot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/policy"
+	"time"
+)
+
+// A workload upgrade rollout forces the workload upgrade of every node that has an agreement for a policy, but
+// instead of cancelling all of the agreements at once, it upgrades the nodes in batches. The next batch is only
+// started once every node in the current batch has a new, finalized agreement, and the batch interval has passed.
+// A node whose new agreement doesn't finalize in time counts as a failure, and the rollout pauses when there are
+// too many failures, so that a bad workload version reaches only a few nodes. A paused rollout can be resumed once
+// the problem has been fixed. Like a bulk cancel job, the rollout record is updated after every step so that a
+// rollout that is interrupted by an agbot restart resumes where it left off.
+const UPGRADE_ROLLOUT = "upgrade_rollout"
+
+// The states of a rollout.
+const (
+	ROLLOUT_RUNNING   = "running"
+	ROLLOUT_PAUSED    = "paused"
+	ROLLOUT_COMPLETED = "completed"
+	ROLLOUT_ABORTED   = "aborted"
+)
+
+const DEFAULT_ROLLOUT_BATCH_SIZE = 1
+const DEFAULT_ROLLOUT_BATCH_INTERVAL_S = 300
+const DEFAULT_ROLLOUT_FINALIZE_TIMEOUT_S = 600
+const DEFAULT_ROLLOUT_MAX_FAILURES = 1
+
+// The body of the API request that starts a rollout. The batch size is either a number of nodes or a percentage of
+// the nodes in the rollout, not both.
+type UpgradeRolloutRequest struct {
+	Org              string `json:"org"`                          // required
+	PolicyName       string `json:"policy"`                       // required, the policy whose nodes are upgraded
+	BatchSize        int    `json:"batch_size,omitempty"`         // defaults to DEFAULT_ROLLOUT_BATCH_SIZE
+	BatchPercent     int    `json:"batch_percent,omitempty"`      // the batch size as a percentage of the nodes, rounded up
+	BatchIntervalS   int    `json:"batch_interval_s,omitempty"`   // defaults to DEFAULT_ROLLOUT_BATCH_INTERVAL_S
+	FinalizeTimeoutS int    `json:"finalize_timeout_s,omitempty"` // defaults to DEFAULT_ROLLOUT_FINALIZE_TIMEOUT_S
+	MaxFailures      int    `json:"max_failures,omitempty"`       // defaults to DEFAULT_ROLLOUT_MAX_FAILURES
+}
+
+func (r UpgradeRolloutRequest) IsValid() error {
+	if r.Org == "" || r.PolicyName == "" {
+		return errors.New("the org and policy must be specified")
+	} else if r.BatchSize != 0 && r.BatchPercent != 0 {
+		return errors.New("only one of batch_size or batch_percent can be specified")
+	} else if r.BatchSize < 0 || r.BatchPercent < 0 || r.BatchPercent > 100 {
+		return errors.New("batch_size must be positive, batch_percent must be between 1 and 100")
+	} else if r.BatchIntervalS < 0 || r.FinalizeTimeoutS < 0 || r.MaxFailures < 0 {
+		return errors.New("batch_interval_s, finalize_timeout_s and max_failures must not be negative")
+	}
+	return nil
+}
+
+type RolloutNode struct {
+	DeviceId       string `json:"device_id"`
+	Protocol       string `json:"protocol"`
+	AgreementId    string `json:"agreement_id"`               // the agreement that is cancelled to upgrade the node
+	UpgradeTime    uint64 `json:"upgrade_time"`               // when the node's upgrade was started by the rollout
+	NewAgreementId string `json:"new_agreement_id,omitempty"` // the finalized agreement made after the upgrade
+	FinalizedTime  uint64 `json:"finalized_time"`             // when the new agreement was seen finalized
+	Failed         string `json:"failed,omitempty"`           // why the upgrade of the node failed
+}
+
+func (n RolloutNode) inProgress() bool {
+	return n.UpgradeTime != 0 && n.FinalizedTime == 0 && n.Failed == ""
+}
+
+type UpgradeRollout struct {
+	Name             string        `json:"name"`
+	Org              string        `json:"org"`
+	PolicyName       string        `json:"policy"`
+	BatchSize        int           `json:"batch_size"` // the number of nodes upgraded in each batch
+	BatchIntervalS   int           `json:"batch_interval_s"`
+	FinalizeTimeoutS int           `json:"finalize_timeout_s"`
+	MaxFailures      int           `json:"max_failures"` // the rollout pauses when this many nodes have failed
+	State            string        `json:"state"`
+	PausedReason     string        `json:"paused_reason,omitempty"`
+	StartedTime      uint64        `json:"started_time"`
+	LastBatchTime    uint64        `json:"last_batch_time"`
+	FinishedTime     uint64        `json:"finished_time"`
+	Nodes            []RolloutNode `json:"nodes"` // the nodes that had an agreement for the policy when the rollout started
+}
+
+func (r UpgradeRollout) String() string {
+	return fmt.Sprintf("Name: %v, "+
+		"Org: %v, "+
+		"PolicyName: %v, "+
+		"BatchSize: %v, "+
+		"BatchIntervalS: %v, "+
+		"FinalizeTimeoutS: %v, "+
+		"MaxFailures: %v, "+
+		"State: %v, "+
+		"PausedReason: %v, "+
+		"StartedTime: %v, "+
+		"LastBatchTime: %v, "+
+		"FinishedTime: %v, "+
+		"Nodes: %v",
+		r.Name, r.Org, r.PolicyName, r.BatchSize, r.BatchIntervalS, r.FinalizeTimeoutS, r.MaxFailures, r.State, r.PausedReason,
+		r.StartedTime, r.LastBatchTime, r.FinishedTime, len(r.Nodes))
+}
+
+// Returns the nodes that the rollout has not started to upgrade yet.
+func (r UpgradeRollout) Remaining() []RolloutNode {
+	remaining := make([]RolloutNode, 0)
+	for _, n := range r.Nodes {
+		if n.UpgradeTime == 0 {
+			remaining = append(remaining, n)
+		}
+	}
+	return remaining
+}
+
+// Returns the nodes that are being upgraded but don't have a finalized agreement yet.
+func (r UpgradeRollout) InProgress() []RolloutNode {
+	inProgress := make([]RolloutNode, 0)
+	for _, n := range r.Nodes {
+		if n.inProgress() {
+			inProgress = append(inProgress, n)
+		}
+	}
+	return inProgress
+}
+
+func (r UpgradeRollout) Failures() int {
+	failures := 0
+	for _, n := range r.Nodes {
+		if n.Failed != "" {
+			failures += 1
+		}
+	}
+	return failures
+}
+
+// Start a rollout for all the nodes that have an active agreement for the policy.
+func StartUpgradeRollout(db *bolt.DB, name string, req UpgradeRolloutRequest) (*UpgradeRollout, error) {
+
+	if name == "" {
+		return nil, errors.New("the rollout name must be specified")
+	} else if err := req.IsValid(); err != nil {
+		return nil, err
+	} else if existing, err := FindUpgradeRollout(db, name); err != nil {
+		return nil, err
+	} else if existing != nil {
+		return nil, errors.New(fmt.Sprintf("upgrade rollout %v already exists", name))
+	}
+
+	rollout := &UpgradeRollout{
+		Name:             name,
+		Org:              req.Org,
+		PolicyName:       req.PolicyName,
+		BatchSize:        req.BatchSize,
+		BatchIntervalS:   req.BatchIntervalS,
+		FinalizeTimeoutS: req.FinalizeTimeoutS,
+		MaxFailures:      req.MaxFailures,
+		State:            ROLLOUT_RUNNING,
+		StartedTime:      uint64(time.Now().Unix()),
+		Nodes:            make([]RolloutNode, 0),
+	}
+
+	for _, agp := range policy.AllAgreementProtocols() {
+		ags, err := FindAgreements(db, []AFilter{UnarchivedAFilter(), OrgAFilter(req.Org)}, agp)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("unable to find %v agreements, error: %v", agp, err))
+		}
+		for _, ag := range ags {
+			if ag.AgreementTimedout == 0 && ag.PolicyName == req.PolicyName {
+				rollout.Nodes = append(rollout.Nodes, RolloutNode{DeviceId: ag.DeviceId, Protocol: agp, AgreementId: ag.CurrentAgreementId})
+			}
+		}
+	}
+
+	if req.BatchPercent != 0 {
+		rollout.BatchSize = (len(rollout.Nodes)*req.BatchPercent + 99) / 100
+	}
+	if rollout.BatchSize <= 0 {
+		rollout.BatchSize = DEFAULT_ROLLOUT_BATCH_SIZE
+	}
+	if rollout.BatchIntervalS <= 0 {
+		rollout.BatchIntervalS = DEFAULT_ROLLOUT_BATCH_INTERVAL_S
+	}
+	if rollout.FinalizeTimeoutS <= 0 {
+		rollout.FinalizeTimeoutS = DEFAULT_ROLLOUT_FINALIZE_TIMEOUT_S
+	}
+	if rollout.MaxFailures <= 0 {
+		rollout.MaxFailures = DEFAULT_ROLLOUT_MAX_FAILURES
+	}
+
+	return rollout, persistUpgradeRollout(db, rollout)
+}
+
+func persistUpgradeRollout(db *bolt.DB, rollout *UpgradeRollout) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if b, err := tx.CreateBucketIfNotExists([]byte(UPGRADE_ROLLOUT)); err != nil {
+			return err
+		} else if serial, err := json.Marshal(rollout); err != nil {
+			return fmt.Errorf("Unable to serialize upgrade rollout %v: %v", rollout.Name, err)
+		} else {
+			return b.Put([]byte(rollout.Name), serial)
+		}
+	})
+}
+
+func FindUpgradeRollout(db *bolt.DB, name string) (*UpgradeRollout, error) {
+	var rollout *UpgradeRollout
+
+	readErr := db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(UPGRADE_ROLLOUT)); b != nil {
+			if v := b.Get([]byte(name)); v != nil {
+				rollout = new(UpgradeRollout)
+				if err := json.Unmarshal(v, rollout); err != nil {
+					return fmt.Errorf("Unable to deserialize upgrade rollout %v: %v", name, err)
+				}
+			}
+		}
+		return nil
+	})
+
+	if readErr != nil {
+		return nil, readErr
+	}
+	return rollout, nil
+}
+
+func FindUpgradeRollouts(db *bolt.DB) ([]UpgradeRollout, error) {
+	rollouts := make([]UpgradeRollout, 0)
+
+	readErr := db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(UPGRADE_ROLLOUT)); b != nil {
+			b.ForEach(func(k, v []byte) error {
+				var rollout UpgradeRollout
+				if err := json.Unmarshal(v, &rollout); err != nil {
+					glog.Errorf("Unable to deserialize upgrade rollout %v: %v", string(k), err)
+				} else {
+					rollouts = append(rollouts, rollout)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+
+	if readErr != nil {
+		return nil, readErr
+	}
+	return rollouts, nil
+}
+
+// Change a rollout in a single transaction. The fn can return an error to leave the rollout unchanged.
+func UpdateUpgradeRollout(db *bolt.DB, name string, fn func(*UpgradeRollout) error) (*UpgradeRollout, error) {
+	var rollout *UpgradeRollout
+
+	updateErr := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(UPGRADE_ROLLOUT))
+		if b == nil {
+			return errors.New(fmt.Sprintf("upgrade rollout %v not found", name))
+		}
+		v := b.Get([]byte(name))
+		if v == nil {
+			return errors.New(fmt.Sprintf("upgrade rollout %v not found", name))
+		}
+		rollout = new(UpgradeRollout)
+		if err := json.Unmarshal(v, rollout); err != nil {
+			return fmt.Errorf("Unable to deserialize upgrade rollout %v: %v", name, err)
+		} else if err := fn(rollout); err != nil {
+			return err
+		} else if serial, err := json.Marshal(rollout); err != nil {
+			return fmt.Errorf("Unable to serialize upgrade rollout %v: %v", name, err)
+		} else {
+			return b.Put([]byte(name), serial)
+		}
+	})
+
+	if updateErr != nil {
+		return nil, updateErr
+	}
+	return rollout, nil
+}
+
+// Stop starting new batches. The nodes that are already being upgraded are still monitored.
+func PauseUpgradeRollout(db *bolt.DB, name string) (*UpgradeRollout, error) {
+	return UpdateUpgradeRollout(db, name, func(rollout *UpgradeRollout) error {
+		if rollout.State != ROLLOUT_RUNNING {
+			return errors.New(fmt.Sprintf("upgrade rollout %v is %v, only a running rollout can be paused", name, rollout.State))
+		}
+		rollout.State = ROLLOUT_PAUSED
+		rollout.PausedReason = "paused by the user"
+		return nil
+	})
+}
+
+// Resume a paused rollout. The failures so far are forgiven, so the rollout doesn't pause again right away.
+func ResumeUpgradeRollout(db *bolt.DB, name string) (*UpgradeRollout, error) {
+	return UpdateUpgradeRollout(db, name, func(rollout *UpgradeRollout) error {
+		if rollout.State != ROLLOUT_PAUSED {
+			return errors.New(fmt.Sprintf("upgrade rollout %v is %v, only a paused rollout can be resumed", name, rollout.State))
+		}
+		rollout.State = ROLLOUT_RUNNING
+		rollout.PausedReason = ""
+		rollout.MaxFailures += rollout.Failures()
+		return nil
+	})
+}
+
+// Stop a rollout for good. The nodes that were already upgraded stay upgraded.
+func AbortUpgradeRollout(db *bolt.DB, name string) (*UpgradeRollout, error) {
+	return UpdateUpgradeRollout(db, name, func(rollout *UpgradeRollout) error {
+		if rollout.State != ROLLOUT_RUNNING && rollout.State != ROLLOUT_PAUSED {
+			return errors.New(fmt.Sprintf("upgrade rollout %v is already %v", name, rollout.State))
+		}
+		rollout.State = ROLLOUT_ABORTED
+		rollout.FinishedTime = uint64(time.Now().Unix())
+		return nil
+	})
+}
+
+// Remove the record of a rollout that is not running.
+func DeleteUpgradeRollout(db *bolt.DB, name string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(UPGRADE_ROLLOUT))
+		if b == nil {
+			return nil
+		} else if v := b.Get([]byte(name)); v == nil {
+			return nil
+		} else {
+			var rollout UpgradeRollout
+			if err := json.Unmarshal(v, &rollout); err == nil && rollout.State == ROLLOUT_RUNNING {
+				return errors.New(fmt.Sprintf("upgrade rollout %v is running, abort it before deleting it", name))
+			}
+			return b.Delete([]byte(name))
+		}
+	})
+}
+
+// Check whether a node that is being upgraded has a new finalized agreement, or has run out of time to get one.
+func checkRolloutNode(db *bolt.DB, node *RolloutNode, policyName string, finalizeTimeoutS int, now uint64) error {
+
+	ags, err := FindAgreements(db, []AFilter{UnarchivedAFilter(), DevPolAFilter(node.DeviceId, policyName)}, node.Protocol)
+	if err != nil {
+		return err
+	}
+
+	for _, ag := range ags {
+		if ag.CurrentAgreementId != node.AgreementId && ag.AgreementInceptionTime >= node.UpgradeTime && ag.AgreementFinalizedTime != 0 && ag.AgreementTimedout == 0 {
+			node.NewAgreementId = ag.CurrentAgreementId
+			node.FinalizedTime = now
+			return nil
+		}
+	}
+
+	if now >= node.UpgradeTime+uint64(finalizeTimeoutS) {
+		node.Failed = fmt.Sprintf("no finalized agreement within %v seconds of the upgrade", finalizeTimeoutS)
+	}
+	return nil
+}
+
+// Move a rollout forward. The nodes being upgraded are checked first, the rollout pauses if too many of them have
+// failed. Otherwise, once the current batch is done and the batch interval has passed, the next batch is chosen.
+// Returns the nodes that have to be upgraded now.
+func advanceUpgradeRollout(db *bolt.DB, rollout *UpgradeRollout, now uint64) ([]RolloutNode, error) {
+
+	for ix := range rollout.Nodes {
+		if rollout.Nodes[ix].inProgress() {
+			if err := checkRolloutNode(db, &rollout.Nodes[ix], rollout.PolicyName, rollout.FinalizeTimeoutS, now); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if rollout.Failures() >= rollout.MaxFailures {
+		rollout.State = ROLLOUT_PAUSED
+		rollout.PausedReason = fmt.Sprintf("%v nodes failed to upgrade", rollout.Failures())
+		return nil, nil
+	} else if len(rollout.InProgress()) != 0 {
+		return nil, nil
+	}
+
+	remaining := rollout.Remaining()
+	if len(remaining) == 0 {
+		rollout.State = ROLLOUT_COMPLETED
+		rollout.FinishedTime = now
+		return nil, nil
+	} else if rollout.LastBatchTime+uint64(rollout.BatchIntervalS) > now {
+		return nil, nil
+	}
+
+	if len(remaining) > rollout.BatchSize {
+		remaining = remaining[:rollout.BatchSize]
+	}
+	for _, node := range remaining {
+		for ix := range rollout.Nodes {
+			if rollout.Nodes[ix].DeviceId == node.DeviceId && rollout.Nodes[ix].Protocol == node.Protocol {
+				rollout.Nodes[ix].UpgradeTime = now
+			}
+		}
+	}
+	rollout.LastBatchTime = now
+	return remaining, nil
+}
+
+// The subworker that runs the upgrade rollouts.
+func (w *AgreementBotWorker) GovernUpgradeRollouts() int {
+
+	// Once the agbot is draining, it doesnt pick up any new work. The running rollouts resume after the restart.
+	if w.draining {
+		return 0
+	}
+
+	// Upgraded nodes can't make new agreements during maintenance mode, so the rollouts wait until it ends.
+	if InMaintenanceMode(w.db) {
+		return 0
+	}
+
+	rollouts, err := FindUpgradeRollouts(w.db)
+	if err != nil {
+		glog.Errorf(AWlogString(fmt.Sprintf("unable to find upgrade rollouts, error: %v", err)))
+		return 0
+	}
+
+	for _, rollout := range rollouts {
+		if rollout.State != ROLLOUT_RUNNING {
+			continue
+		}
+
+		// The agreements are looked up outside of the update transaction, bolt doesnt allow a read transaction inside
+		// of a write transaction. The rollout might have been paused or aborted through the API in the meantime, in
+		// which case the progress is thrown away and no new batch is started.
+		batch, err := advanceUpgradeRollout(w.db, &rollout, uint64(time.Now().Unix()))
+		if err != nil {
+			glog.Errorf(AWlogString(fmt.Sprintf("unable to advance upgrade rollout %v, error: %v", rollout.Name, err)))
+			continue
+		}
+		if _, err := UpdateUpgradeRollout(w.db, rollout.Name, func(r *UpgradeRollout) error {
+			if r.State != ROLLOUT_RUNNING {
+				return errors.New(fmt.Sprintf("upgrade rollout %v is now %v", r.Name, r.State))
+			}
+			*r = rollout
+			return nil
+		}); err != nil {
+			glog.Warningf(AWlogString(fmt.Sprintf("unable to save upgrade rollout %v, error: %v", rollout.Name, err)))
+			continue
+		} else if rollout.State == ROLLOUT_PAUSED {
+			glog.Warningf(AWlogString(fmt.Sprintf("upgrade rollout %v paused, %v", rollout.Name, rollout.PausedReason)))
+		} else if rollout.State == ROLLOUT_COMPLETED {
+			glog.V(3).Infof(AWlogString(fmt.Sprintf("upgrade rollout %v completed", rollout.Name)))
+		}
+
+		for _, node := range batch {
+			glog.V(3).Infof(AWlogString(fmt.Sprintf("upgrade rollout %v upgrading node %v", rollout.Name, node.DeviceId)))
+			if cph, ok := w.consumerPH[node.Protocol]; ok {
+				cmd := NewWorkloadUpgradeCommand(*events.NewABApiWorkloadUpgradeMessage(events.WORKLOAD_UPGRADE, node.Protocol, node.AgreementId, node.DeviceId, rollout.PolicyName))
+				cph.HandleWorkloadUpgrade(cmd, cph)
+			}
+		}
+	}
+	return 0
+}
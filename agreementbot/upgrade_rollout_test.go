@@ -0,0 +1,118 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/open-horizon/anax/policy"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// Make a new agreement for a node, as if it had been upgraded, and finalize it.
+func rolloutFinalizedAgreement(t *testing.T, db *bolt.DB, id string, deviceId string) {
+	if err := AgreementAttempt(db, id, "myorg", deviceId, "policy", "", "", "", policy.BasicProtocol, "myorg/pat", policy.NodeHealth{}, nil); err != nil {
+		t.Fatalf("unable to create agreement %v: %v", id, err)
+	} else if _, err := AgreementFinalized(db, id, policy.BasicProtocol); err != nil {
+		t.Fatalf("unable to finalize agreement %v: %v", id, err)
+	}
+}
+
+func Test_UpgradeRollout(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "agbot-rollout")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := bolt.Open(path.Join(dir, "agbot.db"), 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("unable to open db: %v", err)
+	}
+	defer db.Close()
+
+	for _, ag := range []struct{ id, pol string }{{"ag1", "policy"}, {"ag2", "policy"}, {"ag3", "policy"}, {"ag4", "other"}} {
+		if err := AgreementAttempt(db, ag.id, "myorg", "myorg/"+ag.id, ag.pol, "", "", "", policy.BasicProtocol, "myorg/pat", policy.NodeHealth{}, nil); err != nil {
+			t.Fatalf("unable to create agreement %v: %v", ag.id, err)
+		}
+	}
+
+	if _, err := StartUpgradeRollout(db, "r1", UpgradeRolloutRequest{Org: "myorg", PolicyName: "policy", BatchSize: 1, BatchPercent: 50}); err == nil {
+		t.Errorf("a rollout with a batch size and a batch percentage should not be valid")
+	}
+
+	// Half of the 3 nodes is rounded up to a batch of 2.
+	rollout, err := StartUpgradeRollout(db, "r1", UpgradeRolloutRequest{Org: "myorg", PolicyName: "policy", BatchPercent: 50, BatchIntervalS: 10, FinalizeTimeoutS: 100})
+	if err != nil {
+		t.Fatalf("unable to start rollout: %v", err)
+	} else if rollout.State != ROLLOUT_RUNNING || rollout.BatchSize != 2 || rollout.MaxFailures != DEFAULT_ROLLOUT_MAX_FAILURES {
+		t.Errorf("wrong started rollout %v", rollout)
+	} else if len(rollout.Remaining()) != 3 {
+		t.Errorf("rollout should have 3 nodes, has %v", rollout.Nodes)
+	} else if _, err := StartUpgradeRollout(db, "r1", UpgradeRolloutRequest{Org: "myorg", PolicyName: "other"}); err == nil {
+		t.Errorf("starting a rollout with the same name should fail")
+	}
+
+	// The rollout is moved along in the past, so that the agreements made by the test are newer than the upgrades.
+	now := rollout.StartedTime - 200
+	if batch, err := advanceUpgradeRollout(db, rollout, now); err != nil {
+		t.Errorf("unable to advance rollout: %v", err)
+	} else if len(batch) != 2 || len(rollout.InProgress()) != 2 || rollout.LastBatchTime != now {
+		t.Errorf("the first batch should upgrade 2 nodes, upgraded %v", batch)
+	}
+
+	// One node of the batch makes a new agreement, the next batch waits for the other one.
+	rolloutFinalizedAgreement(t, db, "ag1b", "myorg/ag1")
+	if batch, err := advanceUpgradeRollout(db, rollout, now+20); err != nil {
+		t.Errorf("unable to advance rollout: %v", err)
+	} else if len(batch) != 0 || len(rollout.InProgress()) != 1 {
+		t.Errorf("the next batch should wait for the nodes in progress, upgraded %v", batch)
+	} else if rollout.Nodes[0].NewAgreementId != "ag1b" && rollout.Nodes[1].NewAgreementId != "ag1b" {
+		t.Errorf("the new agreement should be recorded, nodes are %v", rollout.Nodes)
+	}
+
+	// The other node runs out of time, which pauses the rollout.
+	if batch, err := advanceUpgradeRollout(db, rollout, now+100); err != nil {
+		t.Errorf("unable to advance rollout: %v", err)
+	} else if len(batch) != 0 || rollout.State != ROLLOUT_PAUSED || rollout.Failures() != 1 || rollout.PausedReason == "" {
+		t.Errorf("the rollout should pause on a failure, rollout is %v", rollout)
+	} else if err := persistUpgradeRollout(db, rollout); err != nil {
+		t.Fatalf("unable to save rollout: %v", err)
+	}
+
+	if _, err := PauseUpgradeRollout(db, "r1"); err == nil {
+		t.Errorf("pausing a paused rollout should fail")
+	} else if rollout, err = ResumeUpgradeRollout(db, "r1"); err != nil {
+		t.Fatalf("unable to resume rollout: %v", err)
+	} else if rollout.State != ROLLOUT_RUNNING || rollout.MaxFailures != 2 {
+		t.Errorf("wrong resumed rollout %v", rollout)
+	}
+
+	// The last node is upgraded in the next batch, after which the rollout is done.
+	if batch, err := advanceUpgradeRollout(db, rollout, now+101); err != nil {
+		t.Errorf("unable to advance rollout: %v", err)
+	} else if len(batch) != 1 || batch[0].DeviceId != "myorg/ag3" {
+		t.Errorf("the second batch should upgrade the last node, upgraded %v", batch)
+	}
+
+	rolloutFinalizedAgreement(t, db, "ag3b", "myorg/ag3")
+	if batch, err := advanceUpgradeRollout(db, rollout, now+102); err != nil {
+		t.Errorf("unable to advance rollout: %v", err)
+	} else if len(batch) != 0 || rollout.State != ROLLOUT_COMPLETED || rollout.FinishedTime == 0 {
+		t.Errorf("the rollout should be completed, rollout is %v", rollout)
+	} else if err := persistUpgradeRollout(db, rollout); err != nil {
+		t.Fatalf("unable to save rollout: %v", err)
+	}
+
+	if _, err := AbortUpgradeRollout(db, "r1"); err == nil {
+		t.Errorf("aborting a completed rollout should fail")
+	} else if err := DeleteUpgradeRollout(db, "r1"); err != nil {
+		t.Errorf("unable to delete rollout: %v", err)
+	} else if rollouts, err := FindUpgradeRollouts(db); err != nil || len(rollouts) != 0 {
+		t.Errorf("there should be no rollouts, found %v, error: %v", rollouts, err)
+	}
+}
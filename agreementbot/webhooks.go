@@ -0,0 +1,213 @@
+package agreementbot
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"net/http"
+	"time"
+)
+
+// The agreement events that are sent to webhooks.
+const WEBHOOK_AGREEMENT_REACHED = "reached"       // the node accepted the proposal
+const WEBHOOK_AGREEMENT_FINALIZED = "finalized"   // the agreement is final, the workload is about to run
+const WEBHOOK_AGREEMENT_TERMINATED = "terminated" // the agreement was cancelled, by either side
+const WEBHOOK_QUOTA_EXCEEDED = "quota"            // an org reached one of its quotas, see QuotaManager
+
+// The header that carries the HMAC-SHA256 signature of the payload, when the webhook has a secret.
+const WEBHOOK_SIGNATURE_HEADER = "X-Horizon-Signature"
+
+// The number of events that can be waiting for a webhook. When the endpoint is down for long enough to fill the
+// queue, new events are dropped rather than holding up the agreement workers.
+const WEBHOOK_QUEUE_SIZE = 200
+
+// The JSON payload that is POSTed to a webhook.
+type WebhookEvent struct {
+	Event       string `json:"event"`
+	AgreementId string `json:"agreementId"`
+	Protocol    string `json:"protocol"`
+	Org         string `json:"org"`
+	DeviceId    string `json:"deviceId"`
+	Pattern     string `json:"pattern,omitempty"`
+	PolicyName  string `json:"policyName"`
+	Time        uint64 `json:"time"`
+	ReasonCode  uint   `json:"reasonCode,omitempty"` // only for terminated events
+	Reason      string `json:"reason,omitempty"`     // only for terminated events
+	Quota       string `json:"quota,omitempty"`      // only for quota events
+	Limit       int    `json:"limit,omitempty"`      // only for quota events
+}
+
+func (e WebhookEvent) String() string {
+	return fmt.Sprintf("Event: %v, AgreementId: %v, Protocol: %v, DeviceId: %v, ReasonCode: %v", e.Event, e.AgreementId, e.Protocol, e.DeviceId, e.ReasonCode)
+}
+
+func NewWebhookEvent(event string, ag *Agreement, reasonCode uint, reason string) *WebhookEvent {
+	return &WebhookEvent{
+		Event:       event,
+		AgreementId: ag.CurrentAgreementId,
+		Protocol:    ag.AgreementProtocol,
+		Org:         ag.Org,
+		DeviceId:    ag.DeviceId,
+		Pattern:     ag.Pattern,
+		PolicyName:  ag.PolicyName,
+		Time:        uint64(time.Now().Unix()),
+		ReasonCode:  reasonCode,
+		Reason:      reason,
+	}
+}
+
+// The event sent when an org reaches one of its quotas. It is not about an agreement, so only the org is set.
+func NewQuotaWebhookEvent(org string, quota string, limit int) *WebhookEvent {
+	return &WebhookEvent{
+		Event: WEBHOOK_QUOTA_EXCEEDED,
+		Org:   org,
+		Time:  uint64(time.Now().Unix()),
+		Quota: quota,
+		Limit: limit,
+	}
+}
+
+// A webhook and the queue of payloads waiting to be sent to it. Each webhook is served by its own goroutine so
+// that the events reach it in the order they happened, and a slow endpoint does not delay the others.
+type webhook struct {
+	config config.WebhookConfig
+	retry  *exchange.RetryPolicy
+	queue  chan []byte
+}
+
+func (h *webhook) wants(event string) bool {
+	if len(h.config.Events) == 0 {
+		return true
+	}
+	for _, e := range h.config.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// The webhook notifier sends agreement events to the configured webhooks. A nil notifier sends nothing, so callers
+// dont have to check whether webhooks are configured.
+type WebhookNotifier struct {
+	name       string
+	httpClient *http.Client
+	hooks      []*webhook
+}
+
+func NewWebhookNotifier(name string, hooks []config.WebhookConfig, httpClient *http.Client) *WebhookNotifier {
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	n := &WebhookNotifier{
+		name:       name,
+		httpClient: httpClient,
+		hooks:      make([]*webhook, 0, len(hooks)),
+	}
+	for _, hc := range hooks {
+		h := &webhook{
+			config: hc,
+			retry:  exchange.NewRetryPolicy(hc.MaxRetries, time.Duration(hc.RetryIntervalS)*time.Second),
+			queue:  make(chan []byte, WEBHOOK_QUEUE_SIZE),
+		}
+		n.hooks = append(n.hooks, h)
+		go n.serve(h)
+	}
+	return n
+}
+
+// Queue an agreement event for every webhook that wants it. This never blocks.
+func (n *WebhookNotifier) Notify(event *WebhookEvent) {
+	if n == nil {
+		return
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		glog.Errorf(WHlogstring(n.name, fmt.Sprintf("unable to marshal webhook event %v, error: %v", event, err)))
+		return
+	}
+
+	for _, h := range n.hooks {
+		if !h.wants(event.Event) {
+			continue
+		}
+		select {
+		case h.queue <- payload:
+			glog.V(5).Infof(WHlogstring(n.name, fmt.Sprintf("queued %v for %v", event, h.config.URL)))
+		default:
+			glog.Errorf(WHlogstring(n.name, fmt.Sprintf("queue for %v is full, dropping %v", h.config.URL, event)))
+		}
+	}
+}
+
+func (n *WebhookNotifier) serve(h *webhook) {
+	for payload := range h.queue {
+		n.deliver(h, payload)
+	}
+}
+
+// POST the payload to the webhook, retrying with backoff until it is accepted or the retries run out.
+func (n *WebhookNotifier) deliver(h *webhook, payload []byte) {
+	for retry := 0; ; retry++ {
+		if retry > 0 {
+			time.Sleep(h.retry.Backoff(retry))
+		}
+
+		err := n.post(h, payload)
+		if err == nil {
+			glog.V(5).Infof(WHlogstring(n.name, fmt.Sprintf("sent %s to %v", payload, h.config.URL)))
+			return
+		} else if retry >= h.retry.MaxRetries {
+			glog.Errorf(WHlogstring(n.name, fmt.Sprintf("giving up sending %s to %v after %v attempts, error: %v", payload, h.config.URL, retry+1, err)))
+			return
+		}
+		glog.Warningf(WHlogstring(n.name, fmt.Sprintf("unable to send to %v, will retry, error: %v", h.config.URL, err)))
+	}
+}
+
+func (n *WebhookNotifier) post(h *webhook, payload []byte) error {
+	req, err := http.NewRequest(http.MethodPost, h.config.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.config.Secret != "" {
+		req.Header.Set(WEBHOOK_SIGNATURE_HEADER, "sha256="+WebhookSignature(h.config.Secret, payload))
+	}
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New(fmt.Sprintf("webhook returned HTTP status %v", resp.StatusCode))
+	}
+	return nil
+}
+
+// The hex encoded HMAC-SHA256 of the payload. A webhook receiver computes the same signature with its copy of the
+// secret to check that the event came from this agbot.
+func WebhookSignature(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+var WHlogstring = func(name string, v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("Webhooks", cutil.LogFields{cutil.LOG_PROTOCOL: name}, v)
+	}
+	return fmt.Sprintf("Webhooks (%v) %v", name, v)
+}
@@ -0,0 +1,69 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"encoding/json"
+	"github.com/open-horizon/anax/config"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_WebhookNotifier(t *testing.T) {
+
+	if n := NewWebhookNotifier("test", nil, http.DefaultClient); n != nil {
+		t.Errorf("there should be no notifier without webhooks")
+	}
+
+	// The nil notifier quietly sends nothing.
+	ag := &Agreement{CurrentAgreementId: "ag1", AgreementProtocol: "Basic", Org: "myorg", DeviceId: "myorg/node1"}
+	var noNotifier *WebhookNotifier
+	noNotifier.Notify(NewWebhookEvent(WEBHOOK_AGREEMENT_REACHED, ag, 0, ""))
+
+	// The webhook fails the first POST, so the event is retried.
+	received := make(chan *WebhookEvent, 10)
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts += 1
+		body, _ := ioutil.ReadAll(r.Body)
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		} else if r.Header.Get(WEBHOOK_SIGNATURE_HEADER) != "sha256="+WebhookSignature("secret", body) {
+			t.Errorf("wrong signature %v for %s", r.Header.Get(WEBHOOK_SIGNATURE_HEADER), body)
+		}
+		event := new(WebhookEvent)
+		if err := json.Unmarshal(body, event); err != nil {
+			t.Errorf("unable to unmarshal %s, error %v", body, err)
+		}
+		received <- event
+	}))
+	defer server.Close()
+
+	n := NewWebhookNotifier("test", []config.WebhookConfig{{URL: server.URL, Secret: "secret", Events: []string{WEBHOOK_AGREEMENT_TERMINATED}, MaxRetries: 2}}, http.DefaultClient)
+
+	// Only the terminated event is wanted by the webhook.
+	n.Notify(NewWebhookEvent(WEBHOOK_AGREEMENT_REACHED, ag, 0, ""))
+	n.Notify(NewWebhookEvent(WEBHOOK_AGREEMENT_TERMINATED, ag, 202, "agreement bot received negative reply"))
+
+	select {
+	case event := <-received:
+		if event.Event != WEBHOOK_AGREEMENT_TERMINATED || event.AgreementId != "ag1" || event.ReasonCode != 202 || event.Reason == "" {
+			t.Errorf("wrong event received %v", event)
+		} else if attempts != 2 {
+			t.Errorf("the event should have been sent twice, was sent %v times", attempts)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the event was not received")
+	}
+
+	select {
+	case event := <-received:
+		t.Errorf("only one event should be received, also got %v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+}
@@ -0,0 +1,135 @@
+package anaxerrors
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// The purpose of this package is to give errors a category that can be checked in code, so that callers dont have to
+// look for strings in error messages. The daemons, the CLI and the API all use the same categories: the CLI maps
+// them to exit codes and the API maps them to HTTP status codes. The message of a categorized error is exactly the
+// message it was created with, so it logs the same way as a plain error.
+
+type Category string
+
+const (
+	EXCHANGE_AUTH      Category = "exchange_auth"      // the exchange rejected the credentials
+	EXCHANGE_NOT_FOUND Category = "exchange_not_found" // the exchange resource does not exist
+	NETWORK            Category = "network"            // the call did not reach the server, or the server was unavailable
+	VALIDATION         Category = "validation"         // the input was not valid
+	SIGNATURE          Category = "signature"          // a signature did not verify
+	PERSISTENCE        Category = "persistence"        // the local database or file system failed
+	UNCATEGORIZED      Category = ""                   // any other error
+)
+
+type Error struct {
+	Category   Category
+	Msg        string
+	HTTPStatus int   // the HTTP status code returned by the server, zero when the error did not come from an HTTP response
+	Cause      error // the underlying error, if any
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+func (e *Error) String() string {
+	return fmt.Sprintf("Category: %v, HTTPStatus: %v, Error: %v", e.Category, e.HTTPStatus, e.Msg)
+}
+
+func New(category Category, msg string) *Error {
+	return &Error{
+		Category: category,
+		Msg:      msg,
+	}
+}
+
+// Give an existing error a category. The message of the returned error is the message of the cause.
+func Wrap(category Category, cause error) error {
+	if cause == nil {
+		return nil
+	}
+	return &Error{
+		Category: category,
+		Msg:      cause.Error(),
+		Cause:    cause,
+	}
+}
+
+// Give an error a new message that explains it, keeping the category and HTTP status of the error.
+func Annotate(cause error, msg string) error {
+	if cause == nil {
+		return nil
+	}
+	return &Error{
+		Category:   CategoryOf(cause),
+		Msg:        msg,
+		HTTPStatus: HTTPStatus(cause),
+		Cause:      cause,
+	}
+}
+
+// Create the error for an HTTP response with a status code that the caller did not expect. The category is derived
+// from the status code.
+func NewHTTPError(status int, msg string) *Error {
+	return &Error{
+		Category:   StatusCategory(status),
+		Msg:        msg,
+		HTTPStatus: status,
+	}
+}
+
+// Returns the category that an HTTP status code returned by a server falls into.
+func StatusCategory(status int) Category {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return EXCHANGE_AUTH
+	case http.StatusNotFound:
+		return EXCHANGE_NOT_FOUND
+	case http.StatusBadRequest:
+		return VALIDATION
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return NETWORK
+	default:
+		return UNCATEGORIZED
+	}
+}
+
+// Returns the first categorized error in the chain of causes, or nil.
+func find(err error) *Error {
+	for err != nil {
+		if e, ok := err.(*Error); ok && (e.Category != UNCATEGORIZED || e.HTTPStatus != 0) {
+			return e
+		} else if u, ok := err.(interface{ Unwrap() error }); ok {
+			err = u.Unwrap()
+		} else {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Returns the category of the error, UNCATEGORIZED when it doesnt have one.
+func CategoryOf(err error) Category {
+	if e := find(err); e != nil {
+		return e.Category
+	}
+	return UNCATEGORIZED
+}
+
+// Returns true when the error is in the category.
+func Is(err error, category Category) bool {
+	return err != nil && CategoryOf(err) == category
+}
+
+// Returns the HTTP status code of the error, zero when the error did not come from an HTTP response.
+func HTTPStatus(err error) int {
+	if e := find(err); e != nil {
+		return e.HTTPStatus
+	}
+	return 0
+}
@@ -0,0 +1,59 @@
+// +build unit
+
+package anaxerrors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func Test_NewHTTPError(t *testing.T) {
+
+	cases := map[int]Category{
+		http.StatusUnauthorized:        EXCHANGE_AUTH,
+		http.StatusForbidden:           EXCHANGE_AUTH,
+		http.StatusNotFound:            EXCHANGE_NOT_FOUND,
+		http.StatusBadRequest:          VALIDATION,
+		http.StatusServiceUnavailable:  NETWORK,
+		http.StatusGatewayTimeout:      NETWORK,
+		http.StatusInternalServerError: UNCATEGORIZED,
+	}
+
+	for status, category := range cases {
+		err := NewHTTPError(status, fmt.Sprintf("status: %v", status))
+		if CategoryOf(err) != category {
+			t.Errorf("status %v should have category %v, was %v", status, category, CategoryOf(err))
+		} else if HTTPStatus(err) != status {
+			t.Errorf("status %v was returned as %v", status, HTTPStatus(err))
+		} else if err.Error() != fmt.Sprintf("status: %v", status) {
+			t.Errorf("message should not change, was %v", err.Error())
+		}
+	}
+}
+
+func Test_Category(t *testing.T) {
+
+	plain := errors.New("plain error")
+	if CategoryOf(plain) != UNCATEGORIZED || HTTPStatus(plain) != 0 {
+		t.Errorf("plain error should not have a category or status")
+	} else if Is(nil, UNCATEGORIZED) {
+		t.Errorf("nil should not be in any category")
+	} else if Wrap(SIGNATURE, nil) != nil {
+		t.Errorf("wrapping nil should return nil")
+	}
+
+	wrapped := Wrap(PERSISTENCE, plain)
+	if !Is(wrapped, PERSISTENCE) {
+		t.Errorf("wrapped error should be a persistence error, was %v", CategoryOf(wrapped))
+	} else if wrapped.Error() != plain.Error() {
+		t.Errorf("wrapped error should have the message of the cause, was %v", wrapped.Error())
+	}
+
+	// The category of a cause is found through an uncategorized wrapper.
+	outer := &Error{Msg: "outer", Cause: New(SIGNATURE, "bad signature")}
+	if !Is(outer, SIGNATURE) {
+		t.Errorf("category should be found in the cause, was %v", CategoryOf(outer))
+	}
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/open-horizon/anax/cutil"
 	"net/http"
 	"sync"
 
@@ -26,6 +27,7 @@ type API struct {
 	bcState        map[string]map[string]apicommon.BlockchainState
 	bcStateLock    sync.Mutex
 	shutdownError  string
+	drainTimedOut  bool // the node did not drain in time when it was unconfigured
 	EC             *worker.BaseExchangeContext
 }
 
@@ -69,6 +71,9 @@ func (a *API) router(includeStaticRedirects bool) *mux.Router {
 	router.HandleFunc("/agreement", a.agreement).Methods("GET", "OPTIONS")
 	router.HandleFunc("/agreement/{id}", a.agreement).Methods("GET", "DELETE", "OPTIONS")
 
+	// For obtaining the earnings accrued by metered agreements
+	router.HandleFunc("/metering/summary", a.meteringSummary).Methods("GET", "OPTIONS")
+
 	// For obtaining microservice info or configuring a microservice (sensor) userInput variables
 	router.HandleFunc("/microservice", a.microservice).Methods("GET", "OPTIONS")
 	router.HandleFunc("/microservice/config", a.microserviceconfig).Methods("GET", "POST", "OPTIONS")
@@ -76,12 +81,13 @@ func (a *API) router(includeStaticRedirects bool) *mux.Router {
 
 	// For obtaining microservice info or configuring a microservice (sensor) userInput variables
 	router.HandleFunc("/service", a.service).Methods("GET", "OPTIONS")
-	router.HandleFunc("/service/config", a.serviceconfig).Methods("GET", "POST", "OPTIONS")
+	router.HandleFunc("/service/config", a.serviceconfig).Methods("GET", "POST", "PATCH", "OPTIONS")
 	router.HandleFunc("/service/policy", a.servicepolicy).Methods("GET", "OPTIONS")
 
 	// Connectivity and blockchain status info
 	router.HandleFunc("/status", a.status).Methods("GET", "OPTIONS")
 	router.HandleFunc("/status/workers", a.workerstatus).Methods("GET", "OPTIONS")
+	router.HandleFunc("/status/exchange", a.exchangestatus).Methods("GET", "OPTIONS")
 
 	// Used by the Registration UI to obtain a random token string
 	router.HandleFunc("/token/random", tokenRandom).Methods("GET", "OPTIONS")
@@ -89,6 +95,7 @@ func (a *API) router(includeStaticRedirects bool) *mux.Router {
 	// Used to configure a node to participate in the Horizon platform
 	router.HandleFunc("/node", a.node).Methods("GET", "HEAD", "POST", "PATCH", "DELETE", "OPTIONS")
 	router.HandleFunc("/node/configstate", a.nodeconfigstate).Methods("GET", "HEAD", "PUT", "OPTIONS")
+	router.HandleFunc("/node/agreementlimits", a.nodeagreementlimits).Methods("GET", "PUT", "OPTIONS")
 
 	// Used to configure workload userInputs for workloads that are expected to be run on this node.
 	router.HandleFunc("/workload", a.workload).Methods("GET", "OPTIONS")
@@ -127,7 +134,9 @@ func (a *API) listen(apiListen string) {
 	// This routine does not need to be a subworker because there is no way to terminate. It will terminate when
 	// the main anax process goes away.
 	go func() {
-		http.ListenAndServe(apiListen, nocache(a.router(true)))
+		if err := apicommon.ListenAndServe("Anax API", apiListen, nocache(a.router(true)), a.Config.Edge.APITLS); err != nil {
+			glog.Errorf(apiLogString(fmt.Sprintf("API server on %v stopped, error: %v", apiListen, err)))
+		}
 	}()
 }
 
@@ -179,6 +188,7 @@ func (a *API) saveShutdownError(msg events.Message) {
 	case *events.NodeShutdownCompleteMessage:
 		m, _ := msg.(*events.NodeShutdownCompleteMessage)
 		a.shutdownError = m.Err()
+		a.drainTimedOut = m.DrainTimedOut()
 	}
 }
 
@@ -272,5 +282,8 @@ func (a *API) existingDeviceOrError(w http.ResponseWriter) (*persistence.Exchang
 }
 
 var apiLogString = func(v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("API", nil, v)
+	}
 	return fmt.Sprintf("API: %v", v)
 }
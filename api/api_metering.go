@@ -0,0 +1,32 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/golang/glog"
+)
+
+func (a *API) meteringSummary(w http.ResponseWriter, r *http.Request) {
+
+	resource := "metering/summary"
+	errorhandler := GetHTTPErrorHandler(w)
+
+	switch r.Method {
+	case "GET":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		// Gather the metering summaries from the local database and format them for output.
+		if out, err := FindMeteringSummariesForOutput(a.db); err != nil {
+			errorhandler(NewSystemError(fmt.Sprintf("Error getting %v for output, error %v", resource, err)))
+		} else {
+			writeResponse(w, out, http.StatusOK)
+		}
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
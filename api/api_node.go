@@ -105,14 +105,19 @@ func (a *API) node(w http.ResponseWriter, r *http.Request) {
 		// Retrieve the optional query parameter
 		removeNode := r.URL.Query().Get("removeNode")
 		block := r.URL.Query().Get("block")
+		drain := r.URL.Query().Get("drain")
+		drainTimeout := r.URL.Query().Get("drainTimeout")
 
 		// Validate the DELETE request and delete the object from the database.
-		errHandled := DeleteHorizonDevice(removeNode, block, a.em, a.Messages(), errorHandler, a.db)
+		errHandled := DeleteHorizonDevice(removeNode, block, drain, drainTimeout, a.em, a.Messages(), errorHandler, a.db)
 		if errHandled {
 			return
 		}
 
-		if a.shutdownError != "" {
+		if a.drainTimedOut {
+			errorHandler(NewTimeoutError(fmt.Sprintf("the node did not drain in time, its local state was not removed, error: %v", a.shutdownError)))
+			return
+		} else if a.shutdownError != "" {
 			errorHandler(NewSystemError(fmt.Sprintf("received error handling %v on resource %v, error: %v", r.Method, resource, a.shutdownError)))
 			return
 		}
@@ -164,11 +169,13 @@ func (a *API) nodeconfigstate(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		microserviceHandler := exchange.GetHTTPMicroserviceHandler(a)
-		patternHandler := exchange.GetHTTPExchangePatternHandler(a)
-		workloadResolver := exchange.GetHTTPWorkloadResolverHandler(a)
-		serviceResolver := exchange.GetHTTPServiceResolverHandler(a)
-		getService := exchange.GetHTTPServiceHandler(a)
+		// The definitions read while configuring the node are cached, so that the node can restart its workloads
+		// when it is restarted while the exchange is unreachable.
+		microserviceHandler := exchange.GetCachedMicroserviceHandler(a, a.db)
+		patternHandler := exchange.GetCachedExchangePatternHandler(a, a.db)
+		workloadResolver := exchange.GetCachedWorkloadResolverHandler(a, a.db)
+		serviceResolver := exchange.GetCachedServiceResolverHandler(a, a.db)
+		getService := exchange.GetCachedServiceHandler(a, a.db)
 
 		// Read in the HTTP body and pass the device registration off to be validated and created.
 		var configState Configstate
@@ -207,3 +214,45 @@ func (a *API) nodeconfigstate(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
+
+func (a *API) nodeagreementlimits(w http.ResponseWriter, r *http.Request) {
+
+	resource := "node/agreementlimits"
+
+	errorHandler := GetHTTPErrorHandler(w)
+
+	switch r.Method {
+	case "GET":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		if out, err := FindAgreementLimitsForOutput(a.db); err != nil {
+			errorHandler(NewSystemError(fmt.Sprintf("Error getting %v for output, error %v", resource, err)))
+		} else if out == nil {
+			errorHandler(NewNotFoundError("Exchange registration not recorded. Complete account and node registration with an exchange and then record node registration using this API's /node path.", "node"))
+		} else {
+			writeResponse(w, out, http.StatusOK)
+		}
+
+	case "PUT":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		var limits AgreementLimits
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &limits); err != nil {
+			errorHandler(NewAPIUserInputError(fmt.Sprintf("Input body couldn't be deserialized to %v object: %v, error: %v", resource, string(body), err), "agreementlimits"))
+			return
+		}
+
+		// Validate and update the limits. They are read from the node every time a proposal is received, so
+		// there is nothing else to tell.
+		if errHandled, out := UpdateAgreementLimits(&limits, errorHandler, a.db); !errHandled {
+			writeResponse(w, out, http.StatusOK)
+		}
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, PUT, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
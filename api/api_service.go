@@ -125,8 +125,36 @@ func (a *API) serviceconfig(w http.ResponseWriter, r *http.Request) {
 		// Write the new service back to the caller.
 		writeResponse(w, newService, http.StatusCreated)
 
+	case "PATCH":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		// Input should be: the service url and the user input variables to change
+		var input ServiceUserInput
+		body, _ := ioutil.ReadAll(r.Body)
+
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+
+		if err := decoder.Decode(&input); err != nil {
+			errorhandler(NewAPIUserInputError(fmt.Sprintf("Input body couldn't be deserialized to %v object: %v, error: %v", resource, string(body), err), "service"))
+			return
+		}
+
+		// Change the variables, the containers that use them are started again by the governance worker.
+		errHandled, userInput, msg := UpdateServiceUserInput(&input, errorhandler, a.db)
+		if errHandled {
+			return
+		}
+
+		if msg != nil {
+			a.Messages() <- msg
+		}
+
+		// Write the user input variables of the service back to the caller.
+		writeResponse(w, toOutModel(userInput), http.StatusOK)
+
 	case "OPTIONS":
-		w.Header().Set("Allow", "GET, POST, OPTIONS")
+		w.Header().Set("Allow", "GET, POST, PATCH, OPTIONS")
 		w.WriteHeader(http.StatusOK)
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
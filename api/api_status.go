@@ -34,6 +34,9 @@ func (a *API) status(w http.ResponseWriter, r *http.Request) {
 			info.AddGeth(geth)
 		}
 
+		// The status of the workers shows which part of the node is stuck or failing.
+		info.Workers = worker.GetWorkerStatusManager().GetAllWorkerStatus()
+
 		writeResponse(w, info, http.StatusOK)
 	case "OPTIONS":
 		w.Header().Set("Allow", "GET, OPTIONS")
@@ -55,3 +58,16 @@ func (a *API) workerstatus(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
+
+// Get the metadata of the most recent exchange calls.
+func (a *API) exchangestatus(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeResponse(w, apicommon.NewExchangeStatus(), http.StatusOK)
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
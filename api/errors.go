@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/golang/glog"
+	"github.com/open-horizon/anax/anaxerrors"
 	"net/http"
 )
 
@@ -114,6 +115,21 @@ func NewNotFoundError(err string, input string) *NotFoundError {
 	}
 }
 
+// Timeout Errors are returned when something the API waits for did not happen in time, the caller can try again.
+type TimeoutError struct {
+	msg string
+}
+
+func (e TimeoutError) Error() string {
+	return e.msg
+}
+
+func NewTimeoutError(err string) *TimeoutError {
+	return &TimeoutError{
+		msg: err,
+	}
+}
+
 // System Errors are generally unexpected, infrastructural problems that just need to be reported out to the caller.
 type SystemError struct {
 	msg string
@@ -166,6 +182,11 @@ func GetHTTPErrorHandler(w http.ResponseWriter) ErrorHandler {
 				glog.Errorf(apiLogString(sysErr.Error()))
 				http.Error(w, sysErr.Error(), http.StatusInternalServerError)
 
+			case *TimeoutError:
+				toErr := err.(*TimeoutError)
+				glog.Errorf(apiLogString(toErr.Error()))
+				http.Error(w, toErr.Error(), http.StatusGatewayTimeout)
+
 			case *ConflictError:
 				conErr := err.(*ConflictError)
 				glog.Errorf(apiLogString(conErr.Error()))
@@ -181,6 +202,16 @@ func GetHTTPErrorHandler(w http.ResponseWriter) ErrorHandler {
 				apiErr := NewAPIUserInputError(notErr.Err, notErr.Input)
 				writeInputErr(w, http.StatusNotFound, apiErr)
 
+			case *anaxerrors.Error:
+				anaxErr := err.(*anaxerrors.Error)
+				status := categoryStatus(anaxErr.Category)
+				if status == http.StatusBadRequest || status == http.StatusNotFound {
+					writeInputErr(w, status, NewAPIUserInputError(anaxErr.Error(), ""))
+				} else {
+					glog.Errorf(apiLogString(anaxErr.Error()))
+					http.Error(w, anaxErr.Error(), status)
+				}
+
 			default:
 				glog.Errorf(apiLogString(fmt.Sprintf("unknown error (%T) %v", err, err.Error())))
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -195,6 +226,22 @@ func GetHTTPErrorHandler(w http.ResponseWriter) ErrorHandler {
 	}
 }
 
+// Returns the HTTP status code that the API responds with for a categorized error.
+func categoryStatus(category anaxerrors.Category) int {
+	switch category {
+	case anaxerrors.VALIDATION, anaxerrors.SIGNATURE:
+		return http.StatusBadRequest
+	case anaxerrors.EXCHANGE_NOT_FOUND:
+		return http.StatusNotFound
+	case anaxerrors.EXCHANGE_AUTH:
+		return http.StatusUnauthorized
+	case anaxerrors.NETWORK:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 // use this function to properly write a User Input Error to the http response.
 func writeInputErr(writer http.ResponseWriter, status int, inputErr *APIUserInputError) {
 	if serial, err := json.Marshal(inputErr); err != nil {
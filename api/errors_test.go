@@ -3,6 +3,9 @@
 package api
 
 import (
+	"github.com/open-horizon/anax/anaxerrors"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 )
@@ -61,3 +64,26 @@ func Test_PassthruHandler(t *testing.T) {
 	}
 
 }
+
+func Test_HTTPErrorHandler_categorized(t *testing.T) {
+
+	cases := map[anaxerrors.Category]int{
+		anaxerrors.VALIDATION:         http.StatusBadRequest,
+		anaxerrors.EXCHANGE_NOT_FOUND: http.StatusNotFound,
+		anaxerrors.EXCHANGE_AUTH:      http.StatusUnauthorized,
+		anaxerrors.NETWORK:            http.StatusServiceUnavailable,
+		anaxerrors.PERSISTENCE:        http.StatusInternalServerError,
+	}
+
+	for category, status := range cases {
+		w := httptest.NewRecorder()
+		if !GetHTTPErrorHandler(w)(anaxerrors.New(category, "categorized test error")) {
+			t.Errorf("Handler should return true for an error")
+		} else if w.Code != status {
+			t.Errorf("Category %v should return status %v, returned %v", category, status, w.Code)
+		} else if !strings.Contains(w.Body.String(), "categorized test error") {
+			t.Errorf("Response should contain the error, was %v", w.Body.String())
+		}
+	}
+
+}
@@ -21,6 +21,26 @@ func (c *Configstate) String() string {
 	}
 }
 
+// The agreement limits of the node. A limit of zero means no limit.
+type AgreementLimits struct {
+	MaxAgreementsPerWorkload *int `json:"maxAgreementsPerWorkload"`
+	MaxTotalAgreements       *int `json:"maxTotalAgreements"`
+}
+
+func (a *AgreementLimits) String() string {
+	if a == nil {
+		return "AgreementLimits: not set"
+	}
+
+	getInt := func(v *int) string {
+		if v == nil {
+			return "not set"
+		}
+		return strconv.Itoa(*v)
+	}
+	return fmt.Sprintf("MaxAgreementsPerWorkload: %v, MaxTotalAgreements: %v", getInt(a.MaxAgreementsPerWorkload), getInt(a.MaxTotalAgreements))
+}
+
 type HorizonDevice struct {
 	Id                 *string      `json:"id"`
 	Org                *string      `json:"organization"`
@@ -283,6 +303,27 @@ func NewService(url string, org string, name string, arch string, v string) *Ser
 	}
 }
 
+// The user input variables of a configured service to change with a PATCH on service/config. The variables that
+// are not in the body keep their values.
+type ServiceUserInput struct {
+	Url       *string                 `json:"url"`
+	Variables *map[string]interface{} `json:"variables"`
+}
+
+func (s *ServiceUserInput) String() string {
+	sURL := ""
+	if s.Url != nil {
+		sURL = *s.Url
+	}
+
+	var variables map[string]interface{}
+	if s.Variables != nil {
+		variables = *s.Variables
+	}
+
+	return fmt.Sprintf("Url: %v, Variables: %v", sURL, variables)
+}
+
 // This section is for handling the workloadConfig API input
 type WorkloadConfig struct {
 	WorkloadURL string      `json:"workload_url"`
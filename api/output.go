@@ -215,3 +215,23 @@ func (s MicroserviceInstanceByCleanupStartTime) Swap(i, j int) {
 func (s MicroserviceInstanceByCleanupStartTime) Less(i, j int) bool {
 	return s[i].(MicroserviceInstanceOutput).CleanupStartTime < s[j].(MicroserviceInstanceOutput).CleanupStartTime
 }
+
+// The summaries are sorted by workload and then by consumer.
+type MeteringSummaryByWorkload []persistence.MeteringSummary
+
+func (s MeteringSummaryByWorkload) Len() int {
+	return len(s)
+}
+
+func (s MeteringSummaryByWorkload) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s MeteringSummaryByWorkload) Less(i, j int) bool {
+	if s[i].Org != s[j].Org {
+		return s[i].Org < s[j].Org
+	} else if s[i].WorkloadURL != s[j].WorkloadURL {
+		return s[i].WorkloadURL < s[j].WorkloadURL
+	}
+	return s[i].ConsumerId < s[j].ConsumerId
+}
@@ -309,6 +309,13 @@ func parseProperty(errorhandler ErrorHandler, permitEmpty bool, given *Attribute
 		return nil, errorhandler(NewAPIUserInputError("partial update unsupported", "property.mappings")), nil
 	}
 
+	// A computed property must refer to a property provider that is registered in this node.
+	for name, value := range *given.Mappings {
+		if err := (policy.PropertyList{policy.Property{Name: name, Value: value}}).ValidateComputed(); err != nil {
+			return nil, errorhandler(NewAPIUserInputError(err.Error(), fmt.Sprintf("property.mappings.%v", name))), nil
+		}
+	}
+
 	return &persistence.PropertyAttributes{
 		Meta:     generateAttributeMetadata(*given, reflect.TypeOf(persistence.PropertyAttributes{}).Name()),
 		Mappings: (*given.Mappings)}, false, nil
@@ -324,6 +331,11 @@ func parseCounterPartyProperty(errorhandler ErrorHandler, permitEmpty bool, give
 		return nil, errorhandler(NewAPIUserInputError("missing key", "counterpartyproperty.mappings.expression")), nil
 	}
 
+	// A string is a constraint expression, it is kept in the same form as in a RequiredProperty.
+	if text, ok := rawExpression.(string); ok {
+		rawExpression = map[string]interface{}{policy.CONSTRAINT_EXPRESSION: text}
+	}
+
 	if exp, ok := rawExpression.(map[string]interface{}); !ok {
 		return nil, errorhandler(NewAPIUserInputError(fmt.Sprintf("expected map[string]interface{}, is %T", rawExpression), "counterpartyproperty.mappings.expression")), nil
 	} else if rp := policy.RequiredProperty_Factory(); rp == nil {
@@ -373,7 +385,7 @@ func parseAgreementProtocol(errorhandler ErrorHandler, permitEmpty bool, given *
 							return nil, errorhandler(NewAPIUserInputError(fmt.Sprintf("blockchain type is not string, it is %T", bcDef["type"]), "agreementprotocol.mappings.protocols.blockchain.type")), nil
 						} else if _, ok := bcDef["name"].(string); bcDef["name"] != nil && !ok {
 							return nil, errorhandler(NewAPIUserInputError(fmt.Sprintf("blockchain name is not string, it is %T", bcDef["name"]), "agreementprotocol.mappings.protocols.blockchain.name")), nil
-						} else if bcDef["type"] != nil && bcDef["type"].(string) != "" && bcDef["type"].(string) != policy.RequiresBlockchainType(protocolName) {
+						} else if bcDef["type"] != nil && bcDef["type"].(string) != "" && !policy.SupportsBlockchainType(protocolName, bcDef["type"].(string)) {
 							return nil, errorhandler(NewAPIUserInputError(fmt.Sprintf("blockchain type %v is not supported for protocol %v", bcDef["type"].(string), protocolName), "agreementprotocol.mappings.protocols.blockchain.type")), nil
 						} else {
 							bcType := ""
@@ -0,0 +1,23 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/open-horizon/anax/persistence"
+	"sort"
+)
+
+func FindMeteringSummariesForOutput(db *bolt.DB) (map[string][]persistence.MeteringSummary, error) {
+
+	summaries, err := persistence.FindMeteringSummaries(db)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to read metering summary objects, error %v", err))
+	}
+
+	sort.Sort(MeteringSummaryByWorkload(summaries))
+
+	out := make(map[string][]persistence.MeteringSummary, 0)
+	out["summaries"] = summaries
+	return out, nil
+}
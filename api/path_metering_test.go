@@ -0,0 +1,44 @@
+// +build unit
+
+package api
+
+import (
+	"github.com/open-horizon/anax/persistence"
+	"testing"
+)
+
+func Test_FindMeteringSummariesForOutput(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	// No summaries in the DB yet.
+	if out, err := FindMeteringSummariesForOutput(db); err != nil {
+		t.Errorf("error finding metering summaries: %v", err)
+	} else if len(out["summaries"]) != 0 {
+		t.Errorf("expecting 0 summaries, have %v", out["summaries"])
+	}
+
+	if _, err := persistence.UpdateMeteringSummary(db, "url2", "org", "consumer1", "agreementId1", persistence.MeteringNotification{Amount: 10, CurrentTime: 100}); err != nil {
+		t.Errorf("error writing summary: %v", err)
+	} else if _, err := persistence.UpdateMeteringSummary(db, "url1", "org", "consumer2", "agreementId2", persistence.MeteringNotification{Amount: 10, CurrentTime: 100}); err != nil {
+		t.Errorf("error writing summary: %v", err)
+	} else if _, err := persistence.UpdateMeteringSummary(db, "url1", "org", "consumer1", "agreementId3", persistence.MeteringNotification{Amount: 10, CurrentTime: 100}); err != nil {
+		t.Errorf("error writing summary: %v", err)
+	}
+
+	if out, err := FindMeteringSummariesForOutput(db); err != nil {
+		t.Errorf("error finding metering summaries: %v", err)
+	} else if summaries := out["summaries"]; len(summaries) != 3 {
+		t.Errorf("expecting 3 summaries, have %v", summaries)
+	} else if summaries[0].WorkloadURL != "url1" || summaries[0].ConsumerId != "consumer1" {
+		t.Errorf("first summary should be url1 consumer1, was %v", summaries[0])
+	} else if summaries[1].WorkloadURL != "url1" || summaries[1].ConsumerId != "consumer2" {
+		t.Errorf("second summary should be url1 consumer2, was %v", summaries[1])
+	} else if summaries[2].WorkloadURL != "url2" {
+		t.Errorf("third summary should be url2, was %v", summaries[2])
+	}
+}
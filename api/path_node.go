@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"github.com/boltdb/bolt"
 	"github.com/golang/glog"
+	"github.com/open-horizon/anax/anaxerrors"
 	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/exchange"
 	"github.com/open-horizon/anax/persistence"
 	"os"
+	"strconv"
 	"time"
 )
 
@@ -16,6 +18,10 @@ import (
 // object because it eventually gets deleted at the end of unconfiguration.
 var Unconfiguring bool
 
+// The number of seconds a node that is unconfigured with drain=true waits for its workloads to stop, unless the caller
+// sets drainTimeout.
+const DEFAULT_DRAIN_TIMEOUT_S = 300
+
 func FindHorizonDeviceForOutput(db *bolt.DB) (*HorizonDevice, error) {
 
 	var device *HorizonDevice
@@ -63,7 +69,7 @@ func CreateHorizonDevice(device *HorizonDevice,
 	// to the HTTP response.
 
 	if pDevice, err := persistence.FindExchangeDevice(db); err != nil {
-		return errorhandler(NewSystemError(fmt.Sprintf("unable to read node object, error %v", err))), nil, nil
+		return errorhandler(anaxerrors.New(anaxerrors.PERSISTENCE, fmt.Sprintf("unable to read node object, error %v", err))), nil, nil
 	} else if pDevice != nil {
 		return errorhandler(NewConflictError("device is already registered")), nil, nil
 	} else if Unconfiguring {
@@ -109,7 +115,10 @@ func CreateHorizonDevice(device *HorizonDevice,
 
 	// Verify that the input organization exists in the exchange.
 	deviceId := fmt.Sprintf("%v/%v", *device.Org, *device.Id)
-	if _, err := getOrg(*device.Org, deviceId, *device.Token); err != nil {
+	if _, err := getOrg(*device.Org, deviceId, *device.Token); anaxerrors.Is(err, anaxerrors.EXCHANGE_AUTH) || anaxerrors.Is(err, anaxerrors.NETWORK) {
+		// The organization might exist, the exchange just could not be asked about it.
+		return errorhandler(anaxerrors.Annotate(err, fmt.Sprintf("unable to verify organization %v in exchange, error: %v", *device.Org, err))), nil, nil
+	} else if err != nil {
 		return errorhandler(NewAPIUserInputError(fmt.Sprintf("organization %v not found in exchange, error: %v", *device.Org, err), "device.organization")), nil, nil
 	}
 
@@ -130,7 +139,7 @@ func CreateHorizonDevice(device *HorizonDevice,
 
 	pDev, err := persistence.SaveNewExchangeDevice(db, *device.Id, *device.Token, *device.Name, haDevice, *device.Org, *device.Pattern, persistence.CONFIGSTATE_CONFIGURING, false, false)
 	if err != nil {
-		return errorhandler(NewSystemError(fmt.Sprintf("error persisting new device registration: %v", err))), nil, nil
+		return errorhandler(anaxerrors.New(anaxerrors.PERSISTENCE, fmt.Sprintf("error persisting new device registration: %v", err))), nil, nil
 	}
 
 	glog.V(5).Infof(apiLogString(fmt.Sprintf("Create node updated: %v", pDev)))
@@ -151,7 +160,7 @@ func UpdateHorizonDevice(device *HorizonDevice,
 	// to the HTTP response.
 	pDevice, err := persistence.FindExchangeDevice(db)
 	if err != nil {
-		return errorhandler(NewSystemError(fmt.Sprintf("Unable to read node object, error %v", err))), nil, nil
+		return errorhandler(anaxerrors.New(anaxerrors.PERSISTENCE, fmt.Sprintf("Unable to read node object, error %v", err))), nil, nil
 	} else if pDevice == nil {
 		return errorhandler(NewNotFoundError("Exchange registration not recorded. Complete account and device registration with an exchange and then record device registration using this API.", "node")), nil, nil
 	} else if !pDevice.IsState(persistence.CONFIGSTATE_CONFIGURING) {
@@ -172,7 +181,7 @@ func UpdateHorizonDevice(device *HorizonDevice,
 
 	updatedDev, err := pDevice.SetExchangeDeviceToken(db, *device.Id, *device.Token)
 	if err != nil {
-		return errorhandler(NewSystemError(fmt.Sprintf("error persisting token update on node object: %v", err))), nil, nil
+		return errorhandler(anaxerrors.New(anaxerrors.PERSISTENCE, fmt.Sprintf("error persisting token update on node object: %v", err))), nil, nil
 	}
 
 	// Return 2 device objects, the first is the fully populated newly updated device object. The second is a device
@@ -185,6 +194,8 @@ func UpdateHorizonDevice(device *HorizonDevice,
 // Handles the DELETE verb on this resource.
 func DeleteHorizonDevice(removeNode string,
 	block string,
+	drain string,
+	drainTimeout string,
 	em *events.EventStateManager,
 	msgQueue chan events.Message,
 	errorhandler ErrorHandler,
@@ -194,11 +205,15 @@ func DeleteHorizonDevice(removeNode string,
 	// to the HTTP response.
 	pDevice, err := persistence.FindExchangeDevice(db)
 	if err != nil {
-		return errorhandler(NewSystemError(fmt.Sprintf("Unable to read node object, error %v", err)))
+		return errorhandler(anaxerrors.New(anaxerrors.PERSISTENCE, fmt.Sprintf("Unable to read node object, error %v", err)))
 	} else if pDevice == nil {
 		return errorhandler(NewNotFoundError("Exchange registration not recorded. Complete account and device registration with an exchange and then record device registration using this API.", "node"))
-	} else if !pDevice.IsState(persistence.CONFIGSTATE_CONFIGURED) && !pDevice.IsState(persistence.CONFIGSTATE_CONFIGURING) {
-		return errorhandler(NewBadRequestError(fmt.Sprintf("The node must be in configured or configuring state in order to unconfigure it.")))
+	} else if Unconfiguring {
+		return errorhandler(NewBadRequestError(fmt.Sprintf("The node is already being unconfigured.")))
+	} else if !pDevice.IsState(persistence.CONFIGSTATE_CONFIGURED) && !pDevice.IsState(persistence.CONFIGSTATE_CONFIGURING) && !pDevice.IsState(persistence.CONFIGSTATE_UNCONFIGURING) {
+		// A node is left in the unconfiguring state when a previous unconfigure did not finish, for example when the
+		// node did not drain in time, so it can be unconfigured again.
+		return errorhandler(NewBadRequestError(fmt.Sprintf("The node must be in configured, configuring or unconfiguring state in order to unconfigure it.")))
 	}
 
 	// Verify optional input
@@ -210,6 +225,19 @@ func DeleteHorizonDevice(removeNode string,
 		return errorhandler(NewAPIUserInputError("%v is an incorrect value for block", "url.block"))
 	}
 
+	if drain != "" && drain != "true" && drain != "false" {
+		return errorhandler(NewAPIUserInputError(fmt.Sprintf("%v is an incorrect value for drain", drain), "url.drain"))
+	}
+
+	drainTimeoutS := DEFAULT_DRAIN_TIMEOUT_S
+	if drainTimeout != "" {
+		if t, err := strconv.Atoi(drainTimeout); err != nil || t <= 0 {
+			return errorhandler(NewAPIUserInputError(fmt.Sprintf("%v is an incorrect value for drainTimeout, it must be a positive number of seconds", drainTimeout), "url.drainTimeout"))
+		} else {
+			drainTimeoutS = t
+		}
+	}
+
 	// Establish defaults for optional inputs
 	rNode := false
 	if removeNode == "true" {
@@ -223,7 +251,7 @@ func DeleteHorizonDevice(removeNode string,
 	// Mark the device as "unconfigure in progress"
 	_, err = pDevice.SetConfigstate(db, pDevice.Id, persistence.CONFIGSTATE_UNCONFIGURING, pDevice.ServiceBased)
 	if err != nil {
-		return errorhandler(NewSystemError(fmt.Sprintf("error persisting unconfiguring on node object: %v", err)))
+		return errorhandler(anaxerrors.New(anaxerrors.PERSISTENCE, fmt.Sprintf("error persisting unconfiguring on node object: %v", err)))
 	}
 
 	// Remember that unconfiguration is in progress.
@@ -231,6 +259,9 @@ func DeleteHorizonDevice(removeNode string,
 
 	// Fire the NodeShutdown event to get the node to quiesce itself.
 	ns := events.NewNodeShutdownMessage(events.START_UNCONFIGURE, blocking, rNode)
+	if drain == "true" {
+		ns = events.NewNodeDrainMessage(events.START_UNCONFIGURE, blocking, rNode, drainTimeoutS)
+	}
 	msgQueue <- ns
 
 	// Wait (if allowed) for the ShutdownComplete event
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/persistence"
+)
+
+func FindAgreementLimitsForOutput(db *bolt.DB) (*AgreementLimits, error) {
+
+	pDevice, err := persistence.FindExchangeDevice(db)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to read node object, error %v", err))
+	} else if pDevice == nil {
+		return nil, nil
+	}
+
+	return &AgreementLimits{
+		MaxAgreementsPerWorkload: &pDevice.AgreementLimits.MaxAgreementsPerWorkload,
+		MaxTotalAgreements:       &pDevice.AgreementLimits.MaxTotalAgreements,
+	}, nil
+
+}
+
+// Given a demarshalled AgreementLimits object, validate it and save, returning any errors. A limit that is not
+// in the input keeps its current value.
+func UpdateAgreementLimits(limits *AgreementLimits,
+	errorhandler ErrorHandler,
+	db *bolt.DB) (bool, *AgreementLimits) {
+
+	pDevice, err := persistence.FindExchangeDevice(db)
+	if err != nil {
+		return errorhandler(NewSystemError(fmt.Sprintf("Unable to read node object, error %v", err))), nil
+	} else if pDevice == nil {
+		return errorhandler(NewNotFoundError("Exchange registration not recorded. Complete account and node registration with an exchange and then record node registration using this API's /node path.", "node")), nil
+	}
+
+	newLimits := pDevice.AgreementLimits
+	if limits.MaxAgreementsPerWorkload != nil {
+		if *limits.MaxAgreementsPerWorkload < 0 {
+			return errorhandler(NewAPIUserInputError("must not be negative, use 0 for no limit", "agreementlimits.maxAgreementsPerWorkload")), nil
+		}
+		newLimits.MaxAgreementsPerWorkload = *limits.MaxAgreementsPerWorkload
+	}
+	if limits.MaxTotalAgreements != nil {
+		if *limits.MaxTotalAgreements < 0 {
+			return errorhandler(NewAPIUserInputError("must not be negative, use 0 for no limit", "agreementlimits.maxTotalAgreements")), nil
+		}
+		newLimits.MaxTotalAgreements = *limits.MaxTotalAgreements
+	}
+
+	glog.V(3).Infof(apiLogString(fmt.Sprintf("Update agreement limits from %v to %v", pDevice.AgreementLimits, newLimits)))
+
+	updatedDevice, err := pDevice.SetAgreementLimits(db, pDevice.Id, newLimits)
+	if err != nil {
+		return errorhandler(NewSystemError(fmt.Sprintf("Unable to update agreement limits, error %v", err))), nil
+	}
+
+	return false, &AgreementLimits{
+		MaxAgreementsPerWorkload: &updatedDevice.AgreementLimits.MaxAgreementsPerWorkload,
+		MaxTotalAgreements:       &updatedDevice.AgreementLimits.MaxTotalAgreements,
+	}
+}
@@ -0,0 +1,93 @@
+// +build unit
+
+package api
+
+import (
+	"github.com/open-horizon/anax/persistence"
+	"testing"
+)
+
+// no node registered, nothing to update
+func Test_UpdateAgreementLimits_nonode(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	var myError error
+	errorhandler := GetPassThroughErrorHandler(&myError)
+
+	if out, err := FindAgreementLimitsForOutput(db); err != nil || out != nil {
+		t.Errorf("there should be no limits without a node, found %v, error %v", out, err)
+	}
+
+	max := 2
+	errHandled, out := UpdateAgreementLimits(&AgreementLimits{MaxTotalAgreements: &max}, errorhandler, db)
+	if !errHandled || out != nil {
+		t.Errorf("update should have failed, returned %v", out)
+	} else if _, ok := myError.(*NotFoundError); !ok {
+		t.Errorf("wrong error returned: %v", myError)
+	}
+
+}
+
+// set one limit at a time, a limit missing from the input is kept
+func Test_UpdateAgreementLimits(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	var myError error
+	errorhandler := GetPassThroughErrorHandler(&myError)
+
+	if _, err := persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", false, "myorg", "apattern", persistence.CONFIGSTATE_CONFIGURED, false, false); err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	if out, err := FindAgreementLimitsForOutput(db); err != nil {
+		t.Errorf("failed to find limits, error %v", err)
+	} else if *out.MaxAgreementsPerWorkload != 0 || *out.MaxTotalAgreements != 0 {
+		t.Errorf("a new node should have no limits, found %v", out)
+	}
+
+	perWorkload := 1
+	if errHandled, out := UpdateAgreementLimits(&AgreementLimits{MaxAgreementsPerWorkload: &perWorkload}, errorhandler, db); errHandled {
+		t.Errorf("unexpected error %v", myError)
+	} else if *out.MaxAgreementsPerWorkload != 1 || *out.MaxTotalAgreements != 0 {
+		t.Errorf("wrong limits returned %v", out)
+	}
+
+	total := 3
+	if errHandled, out := UpdateAgreementLimits(&AgreementLimits{MaxTotalAgreements: &total}, errorhandler, db); errHandled {
+		t.Errorf("unexpected error %v", myError)
+	} else if *out.MaxAgreementsPerWorkload != 1 || *out.MaxTotalAgreements != 3 {
+		t.Errorf("wrong limits returned %v", out)
+	}
+
+	negative := -1
+	if errHandled, _ := UpdateAgreementLimits(&AgreementLimits{MaxTotalAgreements: &negative}, errorhandler, db); !errHandled {
+		t.Errorf("a negative limit should be rejected")
+	} else if _, ok := myError.(*APIUserInputError); !ok {
+		t.Errorf("wrong error returned: %v", myError)
+	}
+
+	// Changing the config state through an older copy of the node must not lose the limits.
+	if pDevice, err := persistence.FindExchangeDevice(db); err != nil {
+		t.Errorf("failed to find device, error %v", err)
+	} else if pDevice.AgreementLimits != (persistence.AgreementLimits{MaxAgreementsPerWorkload: 1, MaxTotalAgreements: 3}) {
+		t.Errorf("wrong limits saved %v", pDevice.AgreementLimits)
+	} else {
+		pDevice.AgreementLimits = persistence.AgreementLimits{}
+		if updated, err := pDevice.SetConfigstate(db, pDevice.Id, persistence.CONFIGSTATE_CONFIGURING, false); err != nil {
+			t.Errorf("failed to update config state, error %v", err)
+		} else if updated.AgreementLimits.MaxTotalAgreements != 3 {
+			t.Errorf("config state update lost the limits: %v", updated.AgreementLimits)
+		}
+	}
+
+}
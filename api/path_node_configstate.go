@@ -154,14 +154,18 @@ func UpdateConfigstate(cfg *Configstate,
 		if pattern.UsingServiceModel() {
 			for _, service := range pattern.Services {
 
-				// Ignore top-level services that don't match this node's hardware architecture.
+				// Ignore top-level services that don't match this node's hardware architecture. A service for any
+				// architecture is registered for this node's.
 				thisArch := cutil.ArchString()
-				if service.ServiceArch != thisArch && config.ArchSynonyms.GetCanonicalArch(service.ServiceArch) != thisArch {
+				serviceArch := service.ServiceArch
+				if serviceArch == policy.ANY_ARCH {
+					serviceArch = thisArch
+				} else if serviceArch != thisArch && config.ArchSynonyms.GetCanonicalArch(serviceArch) != thisArch {
 					glog.Infof(apiLogString(fmt.Sprintf("skipping service because it is for a different hardware architecture, this node is %v. Skipped service is: %v", thisArch, service.ServiceArch)))
 					continue
 				}
 
-				s := NewService(service.ServiceURL, service.ServiceOrg, makeServiceName(service.ServiceURL, service.ServiceOrg, "[0.0.0,INFINITY)"), service.ServiceArch, "[0.0.0,INFINITY)")
+				s := NewService(service.ServiceURL, service.ServiceOrg, makeServiceName(service.ServiceURL, service.ServiceOrg, "[0.0.0,INFINITY)"), serviceArch, "[0.0.0,INFINITY)")
 				if errHandled := configureService(s, getPatterns, resolveService, getService, errorhandler, &msgs, db, config); errHandled {
 					return errHandled, nil, nil
 				}
@@ -330,8 +334,12 @@ func getSpecRefsForPattern(patName string,
 
 		for _, service := range patternDef.Services {
 
-			// Ignore top-level services that don't match this node's hardware architecture.
-			if service.ServiceArch != thisArch && config.ArchSynonyms.GetCanonicalArch(service.ServiceArch) != thisArch {
+			// Ignore top-level services that don't match this node's hardware architecture. A service for any
+			// architecture is resolved for this node's.
+			serviceArch := service.ServiceArch
+			if serviceArch == policy.ANY_ARCH {
+				serviceArch = thisArch
+			} else if serviceArch != thisArch && config.ArchSynonyms.GetCanonicalArch(serviceArch) != thisArch {
 				glog.Infof(apiLogString(fmt.Sprintf("skipping service because it is for a different hardware architecture, this node is %v. Skipped service is: %v", thisArch, service.ServiceArch)))
 				continue
 			}
@@ -340,7 +348,7 @@ func getSpecRefsForPattern(patName string,
 			// we need to iterate each "workloadChoice" to grab the version.
 			for _, serviceChoice := range service.ServiceVersions {
 
-				apiSpecList, serviceDef, err := resolveService(service.ServiceURL, service.ServiceOrg, serviceChoice.Version, service.ServiceArch)
+				apiSpecList, serviceDef, err := resolveService(service.ServiceURL, service.ServiceOrg, serviceChoice.Version, serviceArch)
 				if err != nil {
 					return nil, nil, NewSystemError(fmt.Sprintf("Error resolving service %v %v %v %v, error %v", service.ServiceURL, service.ServiceOrg, serviceChoice.Version, thisArch, err))
 				}
@@ -378,8 +386,12 @@ func getSpecRefsForPattern(patName string,
 
 		for _, workload := range patternDef.Workloads {
 
-			// Ignore workloads that don't match this node's hardware architecture.
-			if workload.WorkloadArch != thisArch && config.ArchSynonyms.GetCanonicalArch(workload.WorkloadArch) != thisArch {
+			// Ignore workloads that don't match this node's hardware architecture. A workload for any architecture is
+			// resolved for this node's.
+			workloadArch := workload.WorkloadArch
+			if workloadArch == policy.ANY_ARCH {
+				workloadArch = thisArch
+			} else if workloadArch != thisArch && config.ArchSynonyms.GetCanonicalArch(workloadArch) != thisArch {
 				glog.Infof(apiLogString(fmt.Sprintf("skipping workload because it is for a different hardware architecture, this node is %v. Skipped workload is: %v", thisArch, workload.WorkloadArch)))
 				continue
 			}
@@ -388,7 +400,7 @@ func getSpecRefsForPattern(patName string,
 			// we need to iterate each "workloadChoice" to grab the version.
 			for _, workloadChoice := range workload.WorkloadVersions {
 
-				apiSpecList, workloadDef, err := resolveWorkload(workload.WorkloadURL, workload.WorkloadOrg, workloadChoice.Version, workload.WorkloadArch)
+				apiSpecList, workloadDef, err := resolveWorkload(workload.WorkloadURL, workload.WorkloadOrg, workloadChoice.Version, workloadArch)
 				if err != nil {
 					return nil, nil, NewSystemError(fmt.Sprintf("Error resolving workload %v %v %v %v, error %v", workload.WorkloadURL, workload.WorkloadOrg, workloadChoice.Version, thisArch, err))
 				}
@@ -600,7 +600,7 @@ func Test_DeleteHorizonDevice_success(t *testing.T) {
 	removeNode := "false"
 	blocking := "false"
 	msgQueue := make(chan events.Message, 10)
-	errHandled := DeleteHorizonDevice(removeNode, blocking, events.NewEventStateManager(), msgQueue, errorhandler, db)
+	errHandled := DeleteHorizonDevice(removeNode, blocking, "", "", events.NewEventStateManager(), msgQueue, errorhandler, db)
 
 	if errHandled {
 		t.Errorf("unexpected error %v", myError)
@@ -614,6 +614,71 @@ func Test_DeleteHorizonDevice_success(t *testing.T) {
 
 }
 
+// Delete of horizondevice with drain asks the node to drain before it quiesces
+func Test_DeleteHorizonDevice_drain(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	myOrg := "testOrg"
+	myPattern := "testPattern"
+	device := getBasicDevice(myOrg, myPattern)
+
+	_, err = persistence.SaveNewExchangeDevice(db, *device.Id, *device.Token, *device.Name, false, *device.Org, *device.Pattern, persistence.CONFIGSTATE_CONFIGURED, false, false)
+	if err != nil {
+		t.Errorf("unexpected error creating device %v", err)
+	}
+
+	var myError error
+	errorhandler := GetPassThroughErrorHandler(&myError)
+	msgQueue := make(chan events.Message, 10)
+	Unconfiguring = false
+
+	// The drain timeout must be a positive number of seconds.
+	errHandled := DeleteHorizonDevice("false", "false", "true", "soon", events.NewEventStateManager(), msgQueue, errorhandler, db)
+	if !errHandled {
+		t.Errorf("expected error")
+	} else if _, ok := myError.(*APIUserInputError); !ok {
+		t.Errorf("myError has the wrong type (%T)", myError)
+	} else if len(msgQueue) != 0 {
+		t.Errorf("there should not be a message on the queue")
+	}
+
+	errHandled = DeleteHorizonDevice("false", "false", "true", "60", events.NewEventStateManager(), msgQueue, errorhandler, db)
+	if errHandled {
+		t.Errorf("unexpected error %v", myError)
+	} else if len(msgQueue) != 1 {
+		t.Errorf("there should be a message on the queue")
+	} else if msg, ok := (<-msgQueue).(*events.NodeShutdownMessage); !ok {
+		t.Errorf("the message on the queue should be a node shutdown message")
+	} else if msg.DrainTimeout() != 60 {
+		t.Errorf("the node should drain for 60 seconds, not %v", msg.DrainTimeout())
+	}
+
+	// The node can not be unconfigured again while it is being unconfigured.
+	errHandled = DeleteHorizonDevice("false", "false", "true", "", events.NewEventStateManager(), msgQueue, errorhandler, db)
+	if !errHandled {
+		t.Errorf("expected error")
+	} else if _, ok := myError.(*BadRequestError); !ok {
+		t.Errorf("myError has the wrong type (%T)", myError)
+	}
+
+	// A node that is left unconfiguring by an unconfigure that did not finish can be unconfigured again.
+	Unconfiguring = false
+	errHandled = DeleteHorizonDevice("false", "false", "true", "", events.NewEventStateManager(), msgQueue, errorhandler, db)
+	if errHandled {
+		t.Errorf("unexpected error %v", myError)
+	} else if msg, ok := (<-msgQueue).(*events.NodeShutdownMessage); !ok {
+		t.Errorf("the message on the queue should be a node shutdown message")
+	} else if msg.DrainTimeout() != DEFAULT_DRAIN_TIMEOUT_S {
+		t.Errorf("the node should drain for the default timeout, not %v", msg.DrainTimeout())
+	}
+
+}
+
 // Delete of horizondevice fails because its in the wrong state
 func Test_DeleteHorizonDevice_fail1(t *testing.T) {
 
@@ -638,7 +703,7 @@ func Test_DeleteHorizonDevice_fail1(t *testing.T) {
 	removeNode := "false"
 	blocking := "false"
 	msgQueue := make(chan events.Message, 10)
-	errHandled := DeleteHorizonDevice(removeNode, blocking, events.NewEventStateManager(), msgQueue, errorhandler, db)
+	errHandled := DeleteHorizonDevice(removeNode, blocking, "", "", events.NewEventStateManager(), msgQueue, errorhandler, db)
 
 	if !errHandled {
 		t.Errorf("expected error")
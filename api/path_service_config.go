@@ -13,6 +13,7 @@ import (
 	"github.com/open-horizon/anax/persistence"
 	"github.com/open-horizon/anax/policy"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -444,3 +445,90 @@ func CreateService(service *Service,
 	}
 
 }
+
+// Given a demarshalled ServiceUserInput object, change the user input variables of a configured service, returning any
+// errors. The variables are checked against the user inputs of the service definition. The containers that were started
+// with the old values are started again by the governance worker, when it receives the returned message.
+func UpdateServiceUserInput(input *ServiceUserInput,
+	errorhandler ErrorHandler,
+	db *bolt.DB) (bool, *persistence.UserInputAttributes, *events.ServiceUserInputChangedMessage) {
+
+	glog.V(5).Infof(apiLogString(fmt.Sprintf("Service user input PATCH input: %v", input)))
+
+	pDevice, err := persistence.FindExchangeDevice(db)
+	if err != nil {
+		return errorhandler(NewSystemError(fmt.Sprintf("Unable to read horizondevice object, error %v", err))), nil, nil
+	} else if pDevice == nil {
+		return errorhandler(NewAPIUserInputError("Exchange registration not recorded. Complete account and device registration with an exchange and then record device registration using this API's /horizondevice path.", "service")), nil, nil
+	} else if !pDevice.IsServiceBased() {
+		return errorhandler(NewAPIUserInputError("The node is not configured to use services.", "service")), nil, nil
+	}
+
+	// Validate the inputs.
+	if input.Url == nil || *input.Url == "" {
+		return errorhandler(NewAPIUserInputError("not specified", "service.url")), nil, nil
+	} else if bail := checkInputString(errorhandler, "service.url", input.Url); bail {
+		return true, nil, nil
+	} else if input.Variables == nil || len(*input.Variables) == 0 {
+		return errorhandler(NewAPIUserInputError("not specified", "service.variables")), nil, nil
+	}
+
+	// The service must be configured, its definition has the user inputs that the variables are checked against.
+	msdefs, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter(), persistence.UrlMSFilter(*input.Url)})
+	if err != nil {
+		return errorhandler(NewSystemError(fmt.Sprintf("Error accessing db to find service definition: %v", err))), nil, nil
+	} else if len(msdefs) == 0 {
+		return errorhandler(NewNotFoundError(fmt.Sprintf("service %v is not configured on this node", *input.Url), "service.url")), nil, nil
+	}
+
+	names := make([]string, 0, len(*input.Variables))
+	for varName, varValue := range *input.Variables {
+		if ui := msdefs[0].GetUserInputName(varName); ui == nil {
+			return errorhandler(NewAPIUserInputError(fmt.Sprintf("variable %v is not a user input of service %v", varName, *input.Url), "service.variables")), nil, nil
+		} else if err := cutil.VerifyWorkloadVarTypes(varValue, ui.Type); err != nil {
+			return errorhandler(NewAPIUserInputError(fmt.Sprintf("variable %v is %v", varName, err), "service.variables")), nil, nil
+		}
+		names = append(names, varName)
+	}
+	sort.Strings(names)
+
+	// Merge the variables into the user input attribute of the service, or create one if the service was configured
+	// without user input.
+	attrs, err := persistence.FindApplicableAttributes(db, *input.Url)
+	if err != nil {
+		return errorhandler(NewSystemError(fmt.Sprintf("Unable to fetch service %v attributes, error: %v", *input.Url, err))), nil, nil
+	}
+
+	var existing persistence.Attribute
+	for _, attr := range attrs {
+		if attr.GetMeta().Type == reflect.TypeOf(persistence.UserInputAttributes{}).Name() {
+			for _, url := range attr.GetMeta().SensorUrls {
+				if url == *input.Url {
+					existing = attr
+				}
+			}
+		}
+	}
+
+	id := ""
+	userInput := &persistence.UserInputAttributes{
+		Meta:     &persistence.AttributeMeta{Type: reflect.TypeOf(persistence.UserInputAttributes{}).Name(), SensorUrls: []string{*input.Url}, Label: "User input variables"},
+		Mappings: make(map[string]interface{}),
+	}
+	if existing != nil {
+		id = existing.GetMeta().Id
+		userInput.Meta = existing.GetMeta()
+		userInput.Mappings = existing.GetGenericMappings()
+	}
+	for varName, varValue := range *input.Variables {
+		userInput.Mappings[varName] = varValue
+	}
+
+	if _, err := persistence.SaveOrUpdateAttribute(db, userInput, id, false); err != nil {
+		return errorhandler(NewSystemError(fmt.Sprintf("error saving attribute %v, error %v", userInput, err))), nil, nil
+	}
+
+	glog.V(3).Infof(apiLogString(fmt.Sprintf("Changed user input variables %v of service %v", names, *input.Url)))
+
+	return false, userInput, events.NewServiceUserInputChangedMessage(events.SERVICE_USER_INPUT_CHANGED, *input.Url, names)
+}
@@ -3,6 +3,7 @@
 package api
 
 import (
+	"encoding/json"
 	"flag"
 	"github.com/open-horizon/anax/exchange"
 	"github.com/open-horizon/anax/persistence"
@@ -57,3 +58,77 @@ func Test_CreateService0(t *testing.T) {
 	}
 
 }
+
+func Test_UpdateServiceUserInput(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	surl := "http://dummy.com"
+	myOrg := "myorg"
+
+	_, err = persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", false, myOrg, "apattern", persistence.CONFIGSTATE_CONFIGURED, true, false)
+	if err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	var myError error
+	errorhandler := GetPassThroughErrorHandler(&myError)
+
+	// The service has to be configured.
+	vars := map[string]interface{}{"var1": "a"}
+	input := &ServiceUserInput{Url: &surl, Variables: &vars}
+	if errHandled, _, _ := UpdateServiceUserInput(input, errorhandler, db); !errHandled {
+		t.Errorf("expected an error for a service that is not configured")
+	} else if _, ok := myError.(*NotFoundError); !ok {
+		t.Errorf("expected a not found error, got (%T) %v", myError, myError)
+	}
+
+	msdef := &persistence.MicroserviceDefinition{
+		SpecRef:    surl,
+		Org:        myOrg,
+		Version:    "1.0.0",
+		UserInputs: []persistence.UserInput{{Name: "var1", Type: "string"}, {Name: "var2", Type: "int", DefaultValue: "5"}},
+	}
+	if err := persistence.SaveOrUpdateMicroserviceDef(db, msdef); err != nil {
+		t.Errorf("failed to save service definition, error %v", err)
+	}
+
+	// The first change creates the user input attribute of the service.
+	myError = nil
+	if errHandled, userInput, msg := UpdateServiceUserInput(input, errorhandler, db); errHandled {
+		t.Errorf("unexpected error (%T) %v", myError, myError)
+	} else if userInput == nil || userInput.Mappings["var1"] != "a" {
+		t.Errorf("the user input should have var1, is %v", userInput)
+	} else if msg == nil || msg.ServiceURL != surl || len(msg.Variables) != 1 || msg.Variables[0] != "var1" {
+		t.Errorf("the message should name var1, is %v", msg)
+	}
+
+	// Later changes keep the variables that are not in the body.
+	vars = map[string]interface{}{"var2": json.Number("7")}
+	if errHandled, userInput, _ := UpdateServiceUserInput(input, errorhandler, db); errHandled {
+		t.Errorf("unexpected error (%T) %v", myError, myError)
+	} else if userInput.Mappings["var1"] != "a" || userInput.Mappings["var2"] != json.Number("7") {
+		t.Errorf("the user input should have var1 and var2, is %v", userInput)
+	}
+
+	if attrs, err := persistence.FindApplicableAttributes(db, surl); err != nil {
+		t.Errorf("failed to read attributes, error %v", err)
+	} else if len(attrs) != 1 {
+		t.Errorf("there should be one user input attribute, are %v", attrs)
+	}
+
+	// The variables are checked against the service definition.
+	for _, bad := range []map[string]interface{}{{"var3": "a"}, {"var1": json.Number("1")}} {
+		vars = bad
+		myError = nil
+		if errHandled, _, _ := UpdateServiceUserInput(input, errorhandler, db); !errHandled {
+			t.Errorf("expected an error for variables %v", bad)
+		} else if _, ok := myError.(*APIUserInputError); !ok {
+			t.Errorf("expected a user input error for variables %v, got (%T) %v", bad, myError, myError)
+		}
+	}
+}
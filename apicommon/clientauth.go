@@ -0,0 +1,122 @@
+package apicommon
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// The clients of an API that listens on the LAN can be required to authenticate, see config.APITLSConfig. A client
+// authenticates with a client cert that the TLS handshake verified against the client CAs, or with a bearer token.
+// Connections over loopback are trusted the way they were before the API could be reached from the LAN, so that hzn
+// keeps working on the node itself.
+
+// Read the CA certs that sign the accepted client certs.
+func readClientCAs(caFile string) (*x509.CertPool, error) {
+	caBytes, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to read API client CA file %v, error: %v", caFile, err))
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caBytes) {
+		return nil, errors.New(fmt.Sprintf("API client CA file %v does not contain a PEM cert", caFile))
+	}
+	return pool, nil
+}
+
+// Read the accepted tokens, one per line. Empty lines and lines starting with # are ignored.
+func readAPITokens(tokenFile string) ([]string, error) {
+	tokenBytes, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to read API token file %v, error: %v", tokenFile, err))
+	}
+	tokens := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(tokenBytes))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			tokens = append(tokens, line)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New(fmt.Sprintf("API token file %v does not contain a token", tokenFile))
+	}
+	return tokens, nil
+}
+
+// Returns true when the request came over the loopback interface.
+func isLoopbackRequest(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Returns true when the request has one of the tokens as its bearer token.
+func hasAPIToken(r *http.Request, tokens []string) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	presented := []byte(strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")))
+	found := false
+	for _, token := range tokens {
+		// Compare against all the tokens, so that the time taken does not tell which one is closest.
+		if subtle.ConstantTimeCompare(presented, []byte(token)) == 1 {
+			found = true
+		}
+	}
+	return found
+}
+
+// Wrap the API handler so that it rejects the requests of the clients that did not authenticate. OPTIONS requests
+// are let through, browsers send them without credentials.
+func RequireClientAuth(handler http.Handler, tokens []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions || isLoopbackRequest(r) {
+			handler.ServeHTTP(w, r)
+		} else if r.TLS != nil && len(r.TLS.VerifiedChains) != 0 {
+			handler.ServeHTTP(w, r)
+		} else if len(tokens) != 0 && hasAPIToken(r, tokens) {
+			handler.ServeHTTP(w, r)
+		} else {
+			glog.V(3).Infof(tlsLogString(fmt.Sprintf("rejected unauthenticated %v %v from %v", r.Method, r.URL.Path, r.RemoteAddr)))
+			if len(tokens) != 0 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="horizon"`)
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+}
+
+// Set up client authentication for an API, returns the handler that checks the requests.
+func configureClientAuth(handler http.Handler, tlsCfg config.APITLSConfig, serverTLS *tls.Config) (http.Handler, error) {
+	var tokens []string
+	if tlsCfg.TokenFile != "" {
+		var err error
+		if tokens, err = readAPITokens(tlsCfg.TokenFile); err != nil {
+			return nil, err
+		}
+	}
+	if tlsCfg.ClientCAFile != "" {
+		pool, err := readClientCAs(tlsCfg.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		serverTLS.ClientCAs = pool
+		// A cert is not required at the TLS level, because loopback and token clients connect without one.
+		serverTLS.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return RequireClientAuth(handler, tokens), nil
+}
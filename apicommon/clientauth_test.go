@@ -0,0 +1,90 @@
+// +build unit
+
+package apicommon
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+func authRequest(method string, remoteAddr string, token string) *http.Request {
+	r := httptest.NewRequest(method, "http://agent.example.com/node", nil)
+	r.RemoteAddr = remoteAddr
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+func Test_RequireClientAuth(t *testing.T) {
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	handler := RequireClientAuth(ok, []string{"t0ken1", "t0ken2"})
+
+	tests := []struct {
+		req    *http.Request
+		status int
+		msg    string
+	}{
+		{authRequest("GET", "127.0.0.1:5000", ""), http.StatusOK, "loopback clients do not authenticate"},
+		{authRequest("GET", "[::1]:5000", ""), http.StatusOK, "ipv6 loopback clients do not authenticate"},
+		{authRequest("GET", "10.0.0.5:5000", ""), http.StatusUnauthorized, "LAN clients must authenticate"},
+		{authRequest("GET", "10.0.0.5:5000", "t0ken2"), http.StatusOK, "a configured token is accepted"},
+		{authRequest("GET", "10.0.0.5:5000", "wrong"), http.StatusUnauthorized, "an unknown token is rejected"},
+		{authRequest("OPTIONS", "10.0.0.5:5000", ""), http.StatusOK, "OPTIONS requests are let through"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, test.req)
+		assert.Equal(t, test.status, w.Code, test.msg)
+	}
+
+	// A client cert that the TLS handshake verified is accepted.
+	r := authRequest("GET", "10.0.0.5:5000", "")
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{&x509.Certificate{}}}}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code, "a verified client cert is accepted")
+
+	// Without a token file, a client that is not on the node can only use a client cert.
+	handler = RequireClientAuth(ok, nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, authRequest("GET", "10.0.0.5:5000", ""))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "", w.Header().Get("WWW-Authenticate"))
+}
+
+func Test_readAPITokens(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "api-tokens-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	tokenFile := path.Join(dir, "tokens")
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte("# management tools\nt0ken1\n\n  t0ken2  \n"), 0600))
+	tokens, err := readAPITokens(tokenFile)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"t0ken1", "t0ken2"}, tokens)
+
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte("# no tokens\n"), 0600))
+	_, err = readAPITokens(tokenFile)
+	assert.NotNil(t, err, "a token file without tokens is an error")
+
+	_, err = readClientCAs(path.Join(dir, "missing"))
+	assert.NotNil(t, err)
+}
+
+func Test_isLoopbackListen(t *testing.T) {
+	assert.True(t, isLoopbackListen("localhost:8510"))
+	assert.True(t, isLoopbackListen("127.0.0.1:80"))
+	assert.False(t, isLoopbackListen("0.0.0.0:8510"))
+	assert.False(t, isLoopbackListen(":8510"))
+	assert.False(t, isLoopbackListen("10.0.0.1:8510"))
+}
@@ -12,9 +12,11 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/exchange"
 	"github.com/open-horizon/anax/version"
+	"github.com/open-horizon/anax/worker"
 )
 
 type Configuration struct {
@@ -27,16 +29,30 @@ type Configuration struct {
 }
 
 type Info struct {
-	Geths         []Geth          `json:"geth"`
-	Configuration *Configuration  `json:"configuration"`
-	Connectivity  map[string]bool `json:"connectivity"`
+	Geths          []Geth                          `json:"geth"`
+	Configuration  *Configuration                  `json:"configuration"`
+	Connectivity   map[string]bool                 `json:"connectivity"`
+	ExchangeOutage exchange.OutageState            `json:"exchange_outage"`
+	DiskSpace      *cutil.DiskSpaceState           `json:"disk_space,omitempty"` // only on nodes that check their disk space
+	Workers        map[string]*worker.WorkerStatus `json:"workers,omitempty"`    // only on nodes
 }
 
 func NewInfo(httpClientFactory *config.HTTPClientFactory, exchangeUrl string, id string, token string) *Info {
 
-	exch_version, err := exchange.GetExchangeVersion(httpClientFactory, exchangeUrl, id, token)
-	if err != nil {
-		glog.Errorf("Failed to get exchange version: %v", err)
+	// Getting the exchange version retries until the exchange responds, so it is not attempted while the exchange is
+	// known to be unreachable.
+	exch_version := ""
+	if !exchange.ExchangeDegraded() {
+		var err error
+		if exch_version, err = exchange.GetExchangeVersion(httpClientFactory, exchangeUrl, id, token); err != nil {
+			glog.Errorf("Failed to get exchange version: %v", err)
+		}
+	}
+
+	// The disk space is reported once it has been checked, so that a node can see why it rejects agreements.
+	var diskSpace *cutil.DiskSpaceState
+	if ds := cutil.GetDiskSpaceState(); ds.LastCheck != 0 {
+		diskSpace = &ds
 	}
 
 	return &Info{
@@ -49,7 +65,22 @@ func NewInfo(httpClientFactory *config.HTTPClientFactory, exchangeUrl string, id
 			Arch:            runtime.GOARCH,
 			HorizonVersion:  version.HORIZON_VERSION,
 		},
-		Connectivity: map[string]bool{},
+		Connectivity:   map[string]bool{},
+		ExchangeOutage: exchange.GetOutageState(),
+		DiskSpace:      diskSpace,
+	}
+}
+
+// The recent exchange calls of this anax instance, as they are collected into a support bundle.
+type ExchangeStatus struct {
+	Outage       exchange.OutageState           `json:"outage"`
+	Interactions []exchange.ExchangeInteraction `json:"interactions"`
+}
+
+func NewExchangeStatus() *ExchangeStatus {
+	return &ExchangeStatus{
+		Outage:       exchange.GetOutageState(),
+		Interactions: exchange.GetRecentInteractions(),
 	}
 }
 
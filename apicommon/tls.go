@@ -0,0 +1,286 @@
+package apicommon
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The node and the agbot can serve their API over https, see config.APITLSConfig. The API server cert is signed by
+// a CA that is generated for the API, so that clients only have to trust the CA once, and keep trusting it when the
+// server cert is replaced. The server presents the CA cert along with its own cert, which is how 'hzn util cert
+// fetch' retrieves it. The fingerprint of the CA cert is logged when the API starts, so that it can be compared with
+// the fingerprint of the fetched cert.
+
+const API_TLS_CA_CERT = "ca.crt"
+const API_TLS_CA_KEY = "ca.key"
+const API_TLS_CERT = "server.crt"
+const API_TLS_KEY = "server.key"
+
+// The generated CA is valid for 10 years.
+const API_TLS_CA_VALIDITY = 10 * 365 * 24 * time.Hour
+
+type APICertManager struct {
+	cfg      config.APITLSConfig
+	lock     sync.Mutex
+	cert     *tls.Certificate // the server cert followed by the CA cert
+	notAfter time.Time        // when the server cert expires
+}
+
+// Create the cert manager for an API. The certs are generated now if they are missing, so that a problem with the
+// cert directory is found when the API starts.
+func NewAPICertManager(cfg config.APITLSConfig) (*APICertManager, error) {
+	if cfg.CertDir == "" {
+		return nil, errors.New(fmt.Sprintf("no directory configured for the API TLS certs"))
+	}
+	m := &APICertManager{
+		cfg: cfg,
+	}
+	if err := m.load(time.Now()); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// The tls.Config callback that returns the server cert. The cert is replaced when it is close to expiring.
+func (m *APICertManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.needsRenewal(time.Now()) {
+		if err := m.load(time.Now()); err != nil {
+			glog.Errorf(tlsLogString(fmt.Sprintf("unable to renew API server cert, error: %v", err)))
+			if m.cert == nil {
+				return nil, err
+			}
+		}
+	}
+	return m.cert, nil
+}
+
+// Returns the SHA-256 fingerprint of the CA cert.
+func (m *APICertManager) CAFingerprint() (string, error) {
+	if caCert, _, err := readCertAndKey(path.Join(m.cfg.CertDir, API_TLS_CA_CERT), path.Join(m.cfg.CertDir, API_TLS_CA_KEY)); err != nil {
+		return "", err
+	} else {
+		return CertFingerprint(caCert), nil
+	}
+}
+
+func (m *APICertManager) needsRenewal(now time.Time) bool {
+	renewBefore := time.Duration(m.cfg.RenewBeforeDays) * 24 * time.Hour
+	return m.cert == nil || now.Add(renewBefore).After(m.notAfter)
+}
+
+// Load the CA and the server cert from the cert directory, generating the ones that are missing, expiring or no
+// longer match each other.
+func (m *APICertManager) load(now time.Time) error {
+
+	if err := os.MkdirAll(m.cfg.CertDir, 0700); err != nil {
+		return errors.New(fmt.Sprintf("unable to create API TLS cert directory %v, error: %v", m.cfg.CertDir, err))
+	}
+
+	caCertFile, caKeyFile := path.Join(m.cfg.CertDir, API_TLS_CA_CERT), path.Join(m.cfg.CertDir, API_TLS_CA_KEY)
+	certFile, keyFile := path.Join(m.cfg.CertDir, API_TLS_CERT), path.Join(m.cfg.CertDir, API_TLS_KEY)
+
+	caCert, caKey, err := readCertAndKey(caCertFile, caKeyFile)
+	if err != nil || now.Add(API_TLS_CA_VALIDITY/10).After(caCert.NotAfter) {
+		glog.V(3).Infof(tlsLogString(fmt.Sprintf("generating API CA cert in %v, existing cert not usable: %v", m.cfg.CertDir, err)))
+		if caCert, caKey, err = generateCert(nil, nil, nil, now, API_TLS_CA_VALIDITY); err != nil {
+			return err
+		} else if err := writeCertAndKey(caCertFile, caKeyFile, caCert, caKey); err != nil {
+			return err
+		}
+	}
+
+	renewBefore := time.Duration(m.cfg.RenewBeforeDays) * 24 * time.Hour
+	cert, key, err := readCertAndKey(certFile, keyFile)
+	if err == nil && cert.CheckSignatureFrom(caCert) != nil {
+		err = errors.New("server cert is not signed by the CA")
+	} else if err == nil && now.Add(renewBefore).After(cert.NotAfter) {
+		err = errors.New(fmt.Sprintf("server cert expires at %v", cert.NotAfter))
+	}
+	if err != nil {
+		glog.V(3).Infof(tlsLogString(fmt.Sprintf("generating API server cert in %v, existing cert not usable: %v", m.cfg.CertDir, err)))
+		validity := time.Duration(m.cfg.CertValidityDays) * 24 * time.Hour
+		if cert, key, err = generateCert(caCert, caKey, m.cfg.Hosts, now, validity); err != nil {
+			return err
+		} else if err := writeCertAndKey(certFile, keyFile, cert, key); err != nil {
+			return err
+		}
+	}
+
+	m.cert = &tls.Certificate{
+		Certificate: [][]byte{cert.Raw, caCert.Raw},
+		PrivateKey:  key,
+		Leaf:        cert,
+	}
+	m.notAfter = cert.NotAfter
+	return nil
+}
+
+// Generate a cert and its key. When the CA is nil, the cert is a self-signed CA cert, otherwise it is a server cert
+// for localhost and the hosts, signed by the CA.
+func generateCert(ca *x509.Certificate, caKey *ecdsa.PrivateKey, hosts []string, now time.Time, validity time.Duration) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("unable to generate key, error: %v", err))
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("unable to generate cert serial number, error: %v", err))
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     now.Add(validity),
+	}
+
+	signer, signerKey := template, key
+	if ca == nil {
+		template.Subject = pkix.Name{Organization: []string{"Horizon"}, CommonName: "Horizon API CA"}
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		template.Subject = pkix.Name{Organization: []string{"Horizon"}, CommonName: "localhost"}
+		template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		for _, host := range append([]string{"localhost", "127.0.0.1", "::1"}, hosts...) {
+			if ip := net.ParseIP(host); ip != nil {
+				template.IPAddresses = append(template.IPAddresses, ip)
+			} else {
+				template.DNSNames = append(template.DNSNames, host)
+			}
+		}
+		signer, signerKey = ca, caKey
+	}
+
+	if der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey); err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("unable to create cert, error: %v", err))
+	} else if cert, err := x509.ParseCertificate(der); err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("unable to parse generated cert, error: %v", err))
+	} else {
+		return cert, key, nil
+	}
+}
+
+func readCertAndKey(certFile string, keyFile string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	if certBytes, err := ioutil.ReadFile(certFile); err != nil {
+		return nil, nil, err
+	} else if keyBytes, err := ioutil.ReadFile(keyFile); err != nil {
+		return nil, nil, err
+	} else if certBlock, _ := pem.Decode(certBytes); certBlock == nil {
+		return nil, nil, errors.New(fmt.Sprintf("no PEM cert in %v", certFile))
+	} else if keyBlock, _ := pem.Decode(keyBytes); keyBlock == nil {
+		return nil, nil, errors.New(fmt.Sprintf("no PEM key in %v", keyFile))
+	} else if cert, err := x509.ParseCertificate(certBlock.Bytes); err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("unable to parse cert %v, error: %v", certFile, err))
+	} else if key, err := x509.ParseECPrivateKey(keyBlock.Bytes); err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("unable to parse key %v, error: %v", keyFile, err))
+	} else {
+		return cert, key, nil
+	}
+}
+
+func writeCertAndKey(certFile string, keyFile string, cert *x509.Certificate, key *ecdsa.PrivateKey) error {
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return errors.New(fmt.Sprintf("unable to marshal key for %v, error: %v", certFile, err))
+	}
+
+	// Write the key first, a cert without its key is useless.
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0600); err != nil {
+		return errors.New(fmt.Sprintf("unable to write key %v, error: %v", keyFile, err))
+	} else if err := ioutil.WriteFile(certFile, CertPEM(cert), 0644); err != nil {
+		return errors.New(fmt.Sprintf("unable to write cert %v, error: %v", certFile, err))
+	}
+	return nil
+}
+
+// Returns the cert in PEM form.
+func CertPEM(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+// Returns the SHA-256 fingerprint of the cert, as colon separated hex bytes.
+func CertFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	hexBytes := make([]string, 0, len(sum))
+	for _, b := range sum {
+		hexBytes = append(hexBytes, fmt.Sprintf("%02X", b))
+	}
+	return strings.Join(hexBytes, ":")
+}
+
+// Serve the API, over https when TLS is enabled in the config.
+func ListenAndServe(name string, apiListen string, handler http.Handler, tlsCfg config.APITLSConfig) error {
+	if !tlsCfg.Enabled {
+		return http.ListenAndServe(apiListen, handler)
+	}
+
+	certManager, err := NewAPICertManager(tlsCfg)
+	if err != nil {
+		return err
+	}
+	if fingerprint, err := certManager.CAFingerprint(); err == nil {
+		glog.Infof(tlsLogString(fmt.Sprintf("%v serving https on %v, CA cert %v has SHA-256 fingerprint %v", name, apiListen, path.Join(tlsCfg.CertDir, API_TLS_CA_CERT), fingerprint)))
+	}
+
+	serverTLS := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certManager.GetCertificate,
+	}
+	if tlsCfg.ClientAuthEnabled() {
+		if handler, err = configureClientAuth(handler, tlsCfg, serverTLS); err != nil {
+			return err
+		}
+		glog.Infof(tlsLogString(fmt.Sprintf("%v requires client authentication for connections that are not over loopback", name)))
+	} else if !isLoopbackListen(apiListen) {
+		glog.Warningf(tlsLogString(fmt.Sprintf("%v listens on %v without client authentication, anyone who can reach it can use it", name, apiListen)))
+	}
+
+	server := &http.Server{
+		Addr:      apiListen,
+		Handler:   handler,
+		TLSConfig: serverTLS,
+	}
+	return server.ListenAndServeTLS("", "")
+}
+
+// Returns true when the listen address only accepts connections over loopback.
+func isLoopbackListen(apiListen string) bool {
+	host, _, err := net.SplitHostPort(apiListen)
+	if err != nil {
+		return false
+	} else if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+var tlsLogString = func(v interface{}) string {
+	return fmt.Sprintf("API TLS: %v", v)
+}
@@ -0,0 +1,65 @@
+// +build unit
+
+package apicommon
+
+import (
+	"crypto/x509"
+	"github.com/open-horizon/anax/config"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func Test_APICertManager(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "api-tls-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := config.APITLSConfig{
+		Enabled:          true,
+		CertDir:          path.Join(dir, "certs"),
+		Hosts:            []string{"agent.example.com", "10.0.0.1"},
+		CertValidityDays: 90,
+		RenewBeforeDays:  30,
+	}
+
+	// The CA and the server cert are generated when the manager is created.
+	m, err := NewAPICertManager(cfg)
+	assert.Nil(t, err)
+	cert, err := m.GetCertificate(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(cert.Certificate), "the CA cert should be served after the server cert")
+
+	caCert, err := x509.ParseCertificate(cert.Certificate[1])
+	assert.Nil(t, err)
+	assert.True(t, caCert.IsCA)
+	assert.Nil(t, cert.Leaf.CheckSignatureFrom(caCert))
+	assert.Nil(t, cert.Leaf.VerifyHostname("localhost"))
+	assert.Nil(t, cert.Leaf.VerifyHostname("agent.example.com"))
+	assert.Nil(t, cert.Leaf.VerifyHostname("10.0.0.1"))
+
+	info, err := os.Stat(path.Join(cfg.CertDir, API_TLS_KEY))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "the key should only be readable by its owner")
+
+	fingerprint, err := m.CAFingerprint()
+	assert.Nil(t, err)
+	assert.Equal(t, CertFingerprint(caCert), fingerprint)
+
+	// A new manager reuses the certs on disk.
+	m2, err := NewAPICertManager(cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, cert.Certificate, m2.cert.Certificate)
+
+	// The server cert is replaced when it is within RenewBeforeDays of expiring, the CA is kept.
+	later := time.Now().Add(70 * 24 * time.Hour)
+	assert.True(t, m2.needsRenewal(later))
+	assert.Nil(t, m2.load(later))
+	assert.NotEqual(t, cert.Certificate[0], m2.cert.Certificate[0])
+	assert.Equal(t, cert.Certificate[1], m2.cert.Certificate[1])
+	assert.False(t, m2.needsRenewal(later))
+}
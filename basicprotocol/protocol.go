@@ -6,19 +6,28 @@ import (
 	"fmt"
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/abstractprotocol"
+	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/metering"
 	"github.com/open-horizon/anax/policy"
 	"net/http"
 )
 
 const PROTOCOL_NAME = "Basic"
-const PROTOCOL_CURRENT_VERSION = 1
+const PROTOCOL_CURRENT_VERSION = 4
+
+// Proposals are compressed from V3 of the protocol, so that large policies fit in an exchange message.
+const PROTOCOL_COMPRESSION_VERSION = 3
+
+// Messages are sealed from V4 of the protocol, so that the exchange mailbox does not reveal which message it holds.
+const PROTOCOL_SEALED_VERSION = 4
 
 // Protocol specific extension messages go here.
 
 // Extended message types
 const MsgTypeVerifyAgreement = "basicagreementverification"
 const MsgTypeVerifyAgreementReply = "basicagreementverificationreply"
+const MsgTypeMessageAck = "basicmessageack" // new in V2 protocol
+const MsgTypeResourceUsage = "basicresourceusage"
 
 // This message enables a producer to ask the consumer to verify that a specific agreement still exists. If the
 // consumer replies with NO (false), the producer can cancel the agreement.
@@ -69,6 +78,32 @@ func NewBAgreementVerifyReply(bp *abstractprotocol.BaseProtocolMessage, exists b
 	}
 }
 
+// This message enables a producer to tell the consumer how much of the node's resources the workload of an agreement
+// is using. It is sent periodically and is not acknowledged, a lost report is replaced by the next one.
+type BResourceUsage struct {
+	*abstractprotocol.BaseProtocolMessage
+	Usage events.ResourceUsage `json:"usage"`
+}
+
+func (b *BResourceUsage) String() string {
+	return b.BaseProtocolMessage.String() + fmt.Sprintf(", Usage: %v", b.Usage)
+}
+
+func (b *BResourceUsage) ShortString() string {
+	return b.String()
+}
+
+func (b *BResourceUsage) IsValid() bool {
+	return b.BaseProtocolMessage.IsValid() && b.MsgType == MsgTypeResourceUsage && b.Usage.SampleTime != 0
+}
+
+func NewBResourceUsage(bp *abstractprotocol.BaseProtocolMessage, usage events.ResourceUsage) *BResourceUsage {
+	return &BResourceUsage{
+		BaseProtocolMessage: bp,
+		Usage:               usage,
+	}
+}
+
 // This is the object which users of the agreement protocol use to get access to the protocol functions. It MUST
 // implement all the functions in the abstract ProtocolHandler interface.
 type ProtocolHandler struct {
@@ -81,6 +116,8 @@ func NewProtocolHandler(httpClient *http.Client, pm *policy.PolicyManager) *Prot
 		PROTOCOL_CURRENT_VERSION,
 		httpClient,
 		pm)
+	bph.CompressProposalsFrom(PROTOCOL_COMPRESSION_VERSION)
+	bph.SealMessagesFrom(PROTOCOL_SEALED_VERSION)
 
 	return &ProtocolHandler{
 		BaseProtocolHandler: bph,
@@ -97,9 +134,14 @@ func (p *ProtocolHandler) InitiateAgreement(agreementId string,
 	workload *policy.Workload,
 	defaultPW string,
 	defaultNoData uint64,
+	proposalTimeoutS uint64,
 	sendMessage func(msgTarget interface{}, pay []byte) error) (abstractprotocol.Proposal, error) {
 
-	if bp, err := abstractprotocol.CreateProposal(p, agreementId, producerPolicy, consumerPolicy, PROTOCOL_CURRENT_VERSION, myId, workload, defaultPW, defaultNoData); err != nil {
+	// Determine which protocol version to use. V2 is only used when the node supports reliable messaging, V3 when
+	// it also supports compressed proposals, and V4 when it also supports sealed messages.
+	protocolVersion := producerPolicy.MinimumProtocolVersion(p.Name(), consumerPolicy, PROTOCOL_CURRENT_VERSION)
+
+	if bp, err := abstractprotocol.CreateProposal(p, agreementId, producerPolicy, consumerPolicy, protocolVersion, myId, workload, defaultPW, defaultNoData, proposalTimeoutS); err != nil {
 		return nil, err
 	} else {
 
@@ -173,6 +215,51 @@ func (p *ProtocolHandler) SendAgreementVerificationReply(
 
 }
 
+// Acknowledge a message of a V2 agreement, so that the sender stops sending it.
+func (p *ProtocolHandler) SendMessageAck(
+	agreementId string,
+	msgId string,
+	messageTarget interface{},
+	sendMessage func(mt interface{}, pay []byte) error) error {
+
+	ack := NewBMessageAck(&abstractprotocol.BaseProtocolMessage{
+		MsgType:   MsgTypeMessageAck,
+		AProtocol: p.Name(),
+		AVersion:  PROTOCOL_CURRENT_VERSION,
+		AgreeId:   agreementId,
+	},
+		msgId)
+
+	// Send the message
+	if err := abstractprotocol.SendProtocolMessage(messageTarget, ack, sendMessage); err != nil {
+		return errors.New(fmt.Sprintf("Protocol %v error sending message ack %v, %v", p.Name(), ack, err))
+	}
+	return nil
+
+}
+
+func (p *ProtocolHandler) SendResourceUsage(
+	agreementId string,
+	usage events.ResourceUsage,
+	messageTarget interface{},
+	sendMessage func(mt interface{}, pay []byte) error) error {
+
+	report := NewBResourceUsage(&abstractprotocol.BaseProtocolMessage{
+		MsgType:   MsgTypeResourceUsage,
+		AProtocol: p.Name(),
+		AVersion:  PROTOCOL_CURRENT_VERSION,
+		AgreeId:   agreementId,
+	},
+		usage)
+
+	// Send the message
+	if err := abstractprotocol.SendProtocolMessage(messageTarget, report, sendMessage); err != nil {
+		return errors.New(fmt.Sprintf("Protocol %v error sending resource usage %v, %v", p.Name(), report, err))
+	}
+	return nil
+
+}
+
 // The following methods dont implement any extensions to the base agreement protocol.
 func (p *ProtocolHandler) Confirm(replyValid bool,
 	agreementId string,
@@ -261,6 +348,36 @@ func (p *ProtocolHandler) ValidateAgreementVerifyReply(verify string) (*BAgreeme
 
 }
 
+func (p *ProtocolHandler) ValidateMessageAck(ack string) (*BMessageAck, error) {
+
+	// attempt deserialization of message
+	aObj := new(BMessageAck)
+
+	if err := json.Unmarshal([]byte(ack), aObj); err != nil {
+		return nil, errors.New(fmt.Sprintf("Error deserializing message ack: %s, error: %v", ack, err))
+	} else if aObj.BaseProtocolMessage == nil || !aObj.IsValid() {
+		return nil, errors.New(fmt.Sprintf("Message is not a message ack."))
+	} else {
+		return aObj, nil
+	}
+
+}
+
+func (p *ProtocolHandler) ValidateResourceUsage(report string) (*BResourceUsage, error) {
+
+	// attempt deserialization of message
+	uObj := new(BResourceUsage)
+
+	if err := json.Unmarshal([]byte(report), uObj); err != nil {
+		return nil, errors.New(fmt.Sprintf("Error deserializing resource usage: %s, error: %v", report, err))
+	} else if uObj.BaseProtocolMessage == nil || !uObj.IsValid() {
+		return nil, errors.New(fmt.Sprintf("Message is not a resource usage report."))
+	} else {
+		return uObj, nil
+	}
+
+}
+
 func (p *ProtocolHandler) DemarshalProposal(proposal string) (abstractprotocol.Proposal, error) {
 	return abstractprotocol.DemarshalProposal(proposal)
 }
@@ -326,77 +443,62 @@ func (p *ProtocolHandler) RecordMeter(agreementId string, mn *metering.MeteringN
 
 }
 
-// constants indicating why an agreement is cancelled by the producer
+// constants indicating why an agreement is cancelled by the producer. The shared codes are defined by
+// abstractprotocol, they are repeated here so that the code of the protocol can use them as before.
 // const CANCEL_NOT_FINALIZED_TIMEOUT = 100  // x64
-const CANCEL_POLICY_CHANGED = 101
+const CANCEL_POLICY_CHANGED = abstractprotocol.CANCEL_POLICY_CHANGED
 
 //const CANCEL_TORRENT_FAILURE = 102  it is subdivided into IMAGE code now
-const CANCEL_CONTAINER_FAILURE = 103
-const CANCEL_NOT_EXECUTED_TIMEOUT = 104
-const CANCEL_USER_REQUESTED = 105
-const CANCEL_AGBOT_REQUESTED = 106 // x6a
-const CANCEL_NO_REPLY_ACK = 107
-const CANCEL_MICROSERVICE_FAILURE = 108
-const CANCEL_WL_IMAGE_LOAD_FAILURE = 109
-const CANCEL_MS_IMAGE_LOAD_FAILURE = 110
-const CANCEL_MS_UPGRADE_REQUIRED = 111
-const CANCEL_IMAGE_DATA_ERROR = 112 // x70
-const CANCEL_IMAGE_FETCH_FAILURE = 113
-const CANCEL_IMAGE_FETCH_AUTH_FAILURE = 114
-const CANCEL_IMAGE_SIG_VERIF_FAILURE = 115
-const CANCEL_NODE_SHUTDOWN = 116 // x74
-const CANCEL_MS_IMAGE_FETCH_FAILURE = 117
-const CANCEL_MS_DOWNGRADE_REQUIRED = 118
+const CANCEL_CONTAINER_FAILURE = abstractprotocol.CANCEL_CONTAINER_FAILURE
+const CANCEL_NOT_EXECUTED_TIMEOUT = abstractprotocol.CANCEL_NOT_EXECUTED_TIMEOUT
+const CANCEL_USER_REQUESTED = abstractprotocol.CANCEL_USER_REQUESTED
+const CANCEL_AGBOT_REQUESTED = abstractprotocol.CANCEL_AGBOT_REQUESTED
+const CANCEL_NO_REPLY_ACK = abstractprotocol.CANCEL_NO_REPLY_ACK
+const CANCEL_MICROSERVICE_FAILURE = abstractprotocol.CANCEL_MICROSERVICE_FAILURE
+const CANCEL_WL_IMAGE_LOAD_FAILURE = abstractprotocol.CANCEL_WL_IMAGE_LOAD_FAILURE
+const CANCEL_MS_IMAGE_LOAD_FAILURE = abstractprotocol.CANCEL_MS_IMAGE_LOAD_FAILURE
+const CANCEL_MS_UPGRADE_REQUIRED = abstractprotocol.CANCEL_MS_UPGRADE_REQUIRED
+const CANCEL_IMAGE_DATA_ERROR = abstractprotocol.CANCEL_IMAGE_DATA_ERROR
+const CANCEL_IMAGE_FETCH_FAILURE = abstractprotocol.CANCEL_IMAGE_FETCH_FAILURE
+const CANCEL_IMAGE_FETCH_AUTH_FAILURE = abstractprotocol.CANCEL_IMAGE_FETCH_AUTH_FAILURE
+const CANCEL_IMAGE_SIG_VERIF_FAILURE = abstractprotocol.CANCEL_IMAGE_SIG_VERIF_FAILURE
+const CANCEL_NODE_SHUTDOWN = abstractprotocol.CANCEL_NODE_SHUTDOWN
+const CANCEL_MS_IMAGE_FETCH_FAILURE = abstractprotocol.CANCEL_MS_IMAGE_FETCH_FAILURE
+const CANCEL_MS_DOWNGRADE_REQUIRED = abstractprotocol.CANCEL_MS_DOWNGRADE_REQUIRED
+const CANCEL_NODE_DECOMMISSIONED = abstractprotocol.CANCEL_NODE_DECOMMISSIONED
+const CANCEL_WL_ROLLBACK = abstractprotocol.CANCEL_WL_ROLLBACK
+const CANCEL_USER_INPUT_CHANGED = abstractprotocol.CANCEL_USER_INPUT_CHANGED
 
 // These constants represent consumer cancellation reason codes
 // const AB_CANCEL_NOT_FINALIZED_TIMEOUT = 200  // xc8
-const AB_CANCEL_NO_REPLY = 201
-const AB_CANCEL_NEGATIVE_REPLY = 202
-const AB_CANCEL_NO_DATA_RECEIVED = 203
-const AB_CANCEL_POLICY_CHANGED = 204
-const AB_CANCEL_DISCOVERED = 205 // xcd
-const AB_USER_REQUESTED = 206
-const AB_CANCEL_FORCED_UPGRADE = 207
+const AB_CANCEL_NO_REPLY = abstractprotocol.AB_CANCEL_NO_REPLY
+const AB_CANCEL_NEGATIVE_REPLY = abstractprotocol.AB_CANCEL_NEGATIVE_REPLY
+const AB_CANCEL_NO_DATA_RECEIVED = abstractprotocol.AB_CANCEL_NO_DATA_RECEIVED
+const AB_CANCEL_POLICY_CHANGED = abstractprotocol.AB_CANCEL_POLICY_CHANGED
+const AB_CANCEL_DISCOVERED = abstractprotocol.AB_CANCEL_DISCOVERED
+const AB_USER_REQUESTED = abstractprotocol.AB_USER_REQUESTED
+const AB_CANCEL_FORCED_UPGRADE = abstractprotocol.AB_CANCEL_FORCED_UPGRADE
+
+// The consumer codes of this protocol, other protocols use these codes for other reasons.
 const AB_CANCEL_NODE_HEARTBEAT = 208
 const AB_CANCEL_AG_MISSING = 209
+const AB_CANCEL_NODE_AGREEMENT_LIMIT = 210
+const AB_CANCEL_OUTSIDE_TIME_WINDOW = 211
+const AB_CANCEL_NODE_MISSING_DEVICES = 212
+const AB_CANCEL_NODE_WORKLOAD_ROLLBACK = 213
 
 // const AB_CANCEL_BC_WRITE_FAILED       = 208  // xd0
 
-func DecodeReasonCode(code uint64) string {
+// The termination reasons of the protocol.
+var Reasons = abstractprotocol.MustNewReasonRegistry(PROTOCOL_NAME, []abstractprotocol.TerminationReason{
+	{Code: AB_CANCEL_NODE_HEARTBEAT, Party: abstractprotocol.REASON_CONSUMER, Name: "NodeHeartbeat", Description: "agreement bot detected node heartbeat stopped"},
+	{Code: AB_CANCEL_AG_MISSING, Party: abstractprotocol.REASON_CONSUMER, Name: "AgreementMissing", Description: "agreement bot detected agreement missing from node"},
+	{Code: AB_CANCEL_NODE_AGREEMENT_LIMIT, Party: abstractprotocol.REASON_CONSUMER, Name: "NodeAgreementLimit", Description: "agreement bot received rejection, node reached its agreement limit"},
+	{Code: AB_CANCEL_OUTSIDE_TIME_WINDOW, Party: abstractprotocol.REASON_CONSUMER, Name: "OutsideTimeWindow", Description: "agreement bot detected time outside of the policy time windows"},
+	{Code: AB_CANCEL_NODE_MISSING_DEVICES, Party: abstractprotocol.REASON_CONSUMER, Name: "NodeMissingDevices", Description: "agreement bot received rejection, node is missing devices the workload needs"},
+	{Code: AB_CANCEL_NODE_WORKLOAD_ROLLBACK, Party: abstractprotocol.REASON_CONSUMER, Name: "NodeWorkloadRollback", Description: "agreement bot received rejection, node rolled back from the workload version"},
+})
 
-	codeMeanings := map[uint64]string{CANCEL_POLICY_CHANGED: "producer policy changed",
-		// CANCEL_TORRENT_FAILURE:          "torrent failed to download",
-		CANCEL_CONTAINER_FAILURE:        "workload terminated",
-		CANCEL_NOT_EXECUTED_TIMEOUT:     "workload start timeout",
-		CANCEL_USER_REQUESTED:           "user requested",
-		CANCEL_AGBOT_REQUESTED:          "agbot requested",
-		CANCEL_NO_REPLY_ACK:             "agreement protocol incomplete, no reply ack received",
-		CANCEL_MICROSERVICE_FAILURE:     "microservice failed",
-		CANCEL_WL_IMAGE_LOAD_FAILURE:    "workload image loading failed",
-		CANCEL_MS_IMAGE_LOAD_FAILURE:    "microservice image loading failed",
-		CANCEL_MS_IMAGE_FETCH_FAILURE:   "microservice image fetching failed",
-		CANCEL_MS_UPGRADE_REQUIRED:      "required by microservice upgrade process",
-		CANCEL_MS_DOWNGRADE_REQUIRED:    "microservice failed, need downgrading to lower version",
-		CANCEL_IMAGE_DATA_ERROR:         "image data error",
-		CANCEL_IMAGE_FETCH_FAILURE:      "image fetching failed",
-		CANCEL_IMAGE_FETCH_AUTH_FAILURE: "authorization failed for image fetching",
-		CANCEL_IMAGE_SIG_VERIF_FAILURE:  "image signature verification failed",
-		CANCEL_NODE_SHUTDOWN:            "node was unconfigured",
-		// AB_CANCEL_NOT_FINALIZED_TIMEOUT: "agreement bot never detected agreement on the blockchain",
-		AB_CANCEL_NO_REPLY:         "agreement bot never received reply to proposal",
-		AB_CANCEL_NEGATIVE_REPLY:   "agreement bot received negative reply",
-		AB_CANCEL_NO_DATA_RECEIVED: "agreement bot did not detect data",
-		AB_CANCEL_POLICY_CHANGED:   "agreement bot policy changed",
-		AB_CANCEL_DISCOVERED:       "agreement bot discovered cancellation from producer",
-		AB_USER_REQUESTED:          "agreement bot user requested",
-		AB_CANCEL_FORCED_UPGRADE:   "agreement bot user requested workload upgrade",
-		// AB_CANCEL_BC_WRITE_FAILED:   "agreement bot agreement write failed"}
-		AB_CANCEL_NODE_HEARTBEAT: "agreement bot detected node heartbeat stopped",
-		AB_CANCEL_AG_MISSING:     "agreement bot detected agreement missing from node"}
-
-	if reasonString, ok := codeMeanings[code]; !ok {
-		return "unknown reason code, device might be downlevel"
-	} else {
-		return reasonString
-	}
+func DecodeReasonCode(code uint64) string {
+	return Reasons.Description(code)
 }
@@ -0,0 +1,347 @@
+package basicprotocol
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/abstractprotocol"
+	"github.com/open-horizon/anax/cutil"
+	"time"
+)
+
+// Version 2 of the basic protocol makes the protocol messages reliable. Every message of a version 2 agreement carries
+// a message id. The receiver acknowledges each message it gets with a message ack, and remembers the ids it has seen
+// so that a message which is sent again is only processed once. The sender keeps each message in its database until
+// it is acknowledged, sending it again with an increasing delay between attempts. This allows an agreement to survive
+// a message that is lost in the exchange, instead of waiting for the protocol timeout to give up on it.
+
+// The buckets that hold the state of the reliable messages.
+const UNACKED_MESSAGES = "basic_unacked_messages"
+const RECEIVED_MESSAGES = "basic_received_messages"
+
+// The delay before a message is sent again doubles after each attempt, up to the maximum. The message is dropped
+// when it has been sent MESSAGE_RETRY_LIMIT times without being acknowledged.
+const MESSAGE_RETRY_INTERVAL_S = 20
+const MESSAGE_RETRY_MAX_INTERVAL_S = 300
+const MESSAGE_RETRY_LIMIT = 8
+
+// How long the receiver remembers a message id. This has to be longer than the time a sender keeps trying.
+const RECEIVED_MESSAGE_RETENTION_S = 24 * 60 * 60
+
+// This message acknowledges a message of a version 2 agreement.
+type BMessageAck struct {
+	*abstractprotocol.BaseProtocolMessage
+	MsgId string `json:"msgId"` // the id of the message being acknowledged
+}
+
+func (b *BMessageAck) String() string {
+	return b.BaseProtocolMessage.String() + fmt.Sprintf(", MsgId: %v", b.MsgId)
+}
+
+func (b *BMessageAck) ShortString() string {
+	return b.String()
+}
+
+func (b *BMessageAck) IsValid() bool {
+	return b.BaseProtocolMessage.IsValid() && b.MsgType == MsgTypeMessageAck && b.MsgId != ""
+}
+
+func NewBMessageAck(bp *abstractprotocol.BaseProtocolMessage, msgId string) *BMessageAck {
+	return &BMessageAck{
+		BaseProtocolMessage: bp,
+		MsgId:               msgId,
+	}
+}
+
+// The id of a reliable message is added to the message itself, so that the message types dont have to change.
+type reliableHeader struct {
+	abstractprotocol.BaseProtocolMessage
+	MsgId string `json:"msgId,omitempty"`
+}
+
+// Returns the header of a basic protocol message that has to be sent reliably, or nil if the message is for another
+// protocol or is a message ack. Message acks are never acknowledged themselves, and resource usage reports are
+// replaced by the next report instead of being sent again.
+func ReliableMessageHeader(pay []byte) *abstractprotocol.BaseProtocolMessage {
+	h := new(reliableHeader)
+	if err := json.Unmarshal(pay, h); err != nil {
+		return nil
+	} else if h.Protocol() != PROTOCOL_NAME || h.Type() == MsgTypeMessageAck || h.Type() == MsgTypeResourceUsage || !h.BaseProtocolMessage.IsValid() {
+		return nil
+	}
+	return &h.BaseProtocolMessage
+}
+
+// Proposals and replies carry the protocol version of the agreement, the other messages carry the version of the
+// sender's protocol handler.
+func CarriesAgreementVersion(msg *abstractprotocol.BaseProtocolMessage) bool {
+	return msg.Type() == abstractprotocol.MsgTypeProposal || msg.Type() == abstractprotocol.MsgTypeReply
+}
+
+// Returns the message id of a received message, or an empty string if the sender did not ask for an ack.
+func GetMessageId(msg string) string {
+	h := new(reliableHeader)
+	if err := json.Unmarshal([]byte(msg), h); err != nil {
+		return ""
+	}
+	return h.MsgId
+}
+
+func setMessageId(pay []byte, msgId string) ([]byte, error) {
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(pay, &fields); err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to demarshal message %v, error %v", string(pay), err))
+	}
+	fields["msgId"] = msgId
+	return json.Marshal(fields)
+}
+
+func newMessageId() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// A message that has not been acknowledged yet.
+type UnackedMessage struct {
+	MsgId       string `json:"msg_id"`
+	AgreementId string `json:"agreement_id"`
+	ReceiverId  string `json:"receiver_id"` // org qualified exchange id of the receiver
+	Payload     []byte `json:"payload"`     // the message, including its message id
+	Attempts    int    `json:"attempts"`
+	FirstSent   int64  `json:"first_sent"`
+	NextRetry   int64  `json:"next_retry"`
+}
+
+func (m UnackedMessage) String() string {
+	return fmt.Sprintf("MsgId: %v, AgreementId: %v, ReceiverId: %v, Attempts: %v, FirstSent: %v, NextRetry: %v", m.MsgId, m.AgreementId, m.ReceiverId, m.Attempts, m.FirstSent, m.NextRetry)
+}
+
+// The id of a message that was received, and the exchange message it arrived in.
+type ReceivedMessage struct {
+	ExchangeMsgId int   `json:"exchange_msg_id"`
+	Received      int64 `json:"received"`
+}
+
+// The reliable messenger keeps the state of the reliable messages in the database, so that it survives a restart.
+// It holds no state of its own, so every protocol handler instance sharing a database can have its own messenger.
+type ReliableMessenger struct {
+	db                *bolt.DB
+	retryIntervalS    int64
+	maxRetryIntervalS int64
+	retryLimit        int
+}
+
+func NewReliableMessenger(db *bolt.DB) *ReliableMessenger {
+	return &ReliableMessenger{
+		db:                db,
+		retryIntervalS:    MESSAGE_RETRY_INTERVAL_S,
+		maxRetryIntervalS: MESSAGE_RETRY_MAX_INTERVAL_S,
+		retryLimit:        MESSAGE_RETRY_LIMIT,
+	}
+}
+
+// Give the message a message id, remember it until it is acknowledged and send it. The message is remembered even if
+// it could not be sent, it will be sent again later.
+func (r *ReliableMessenger) Send(agreementId string, receiverId string, pay []byte, send func(pay []byte) error) error {
+
+	msgId, err := newMessageId()
+	if err != nil {
+		return errors.New(fmt.Sprintf("unable to generate message id, error %v", err))
+	}
+
+	withId, err := setMessageId(pay, msgId)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	msg := UnackedMessage{
+		MsgId:       msgId,
+		AgreementId: agreementId,
+		ReceiverId:  receiverId,
+		Payload:     withId,
+		Attempts:    1,
+		FirstSent:   now,
+		NextRetry:   now + r.retryIntervalS,
+	}
+	if err := r.saveUnacked(msg); err != nil {
+		return err
+	}
+
+	if err := send(withId); err != nil {
+		glog.Warningf(RMlogString(fmt.Sprintf("unable to send message %v, it will be sent again, error %v", msg, err)))
+	}
+	return nil
+}
+
+// The receiver acknowledged the message, it doesnt have to be sent again.
+func (r *ReliableMessenger) Acknowledged(msgId string) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(UNACKED_MESSAGES)); b != nil {
+			return b.Delete([]byte(msgId))
+		}
+		return nil
+	})
+}
+
+// Send the messages that are due again. Messages that have been sent too many times are dropped, the agreement
+// protocol timeouts take care of the agreement when that happens. The message ids that were received so long ago
+// that they cant be sent again are forgotten.
+func (r *ReliableMessenger) Resend(send func(receiverId string, pay []byte) error) {
+
+	now := time.Now().Unix()
+	due := make([]UnackedMessage, 0)
+
+	if err := r.db.Update(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(UNACKED_MESSAGES)); b != nil {
+			drop := make([]string, 0)
+			b.ForEach(func(k, v []byte) error {
+				var msg UnackedMessage
+				if err := json.Unmarshal(v, &msg); err != nil {
+					glog.Errorf(RMlogString(fmt.Sprintf("unable to demarshal unacked message %v, error %v", string(v), err)))
+					drop = append(drop, string(k))
+				} else if msg.NextRetry > now {
+					// not due yet
+				} else if msg.Attempts >= r.retryLimit {
+					glog.Warningf(RMlogString(fmt.Sprintf("giving up on message %v, it was never acknowledged", msg)))
+					drop = append(drop, string(k))
+				} else {
+					due = append(due, msg)
+				}
+				return nil
+			})
+			for _, k := range drop {
+				if err := b.Delete([]byte(k)); err != nil {
+					return err
+				}
+			}
+		}
+
+		if b := tx.Bucket([]byte(RECEIVED_MESSAGES)); b != nil {
+			forget := make([]string, 0)
+			b.ForEach(func(k, v []byte) error {
+				var rm ReceivedMessage
+				if err := json.Unmarshal(v, &rm); err != nil || rm.Received+RECEIVED_MESSAGE_RETENTION_S < now {
+					forget = append(forget, string(k))
+				}
+				return nil
+			})
+			for _, k := range forget {
+				if err := b.Delete([]byte(k)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		glog.Errorf(RMlogString(fmt.Sprintf("unable to read unacked messages, error %v", err)))
+		return
+	}
+
+	for _, msg := range due {
+		glog.V(3).Infof(RMlogString(fmt.Sprintf("sending unacked message %v again", msg)))
+		if err := send(msg.ReceiverId, msg.Payload); err != nil {
+			glog.Warningf(RMlogString(fmt.Sprintf("unable to send message %v again, error %v", msg, err)))
+		}
+
+		// Back off before the next attempt.
+		interval := r.retryIntervalS << uint(msg.Attempts)
+		if interval > r.maxRetryIntervalS {
+			interval = r.maxRetryIntervalS
+		}
+		msg.Attempts += 1
+		msg.NextRetry = now + interval
+
+		if err := r.updateUnacked(msg); err != nil {
+			glog.Errorf(RMlogString(fmt.Sprintf("unable to save unacked message %v, error %v", msg, err)))
+		}
+	}
+}
+
+// Record that a message was received. Returns true if the message was already received in another exchange message,
+// in which case it has already been processed. An exchange message that is read again, because it was not deleted
+// from the exchange the first time, is not a duplicate.
+func (r *ReliableMessenger) Received(msgId string, exchangeMsgId int) (bool, error) {
+
+	duplicate := false
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(RECEIVED_MESSAGES))
+		if err != nil {
+			return err
+		}
+
+		if v := b.Get([]byte(msgId)); v != nil {
+			var rm ReceivedMessage
+			if err := json.Unmarshal(v, &rm); err == nil && rm.ExchangeMsgId != exchangeMsgId {
+				duplicate = true
+				return nil
+			}
+		}
+
+		if serial, err := json.Marshal(ReceivedMessage{ExchangeMsgId: exchangeMsgId, Received: time.Now().Unix()}); err != nil {
+			return err
+		} else {
+			return b.Put([]byte(msgId), serial)
+		}
+	})
+	return duplicate, err
+}
+
+// Returns the messages that have not been acknowledged yet.
+func (r *ReliableMessenger) UnackedMessages() ([]UnackedMessage, error) {
+	msgs := make([]UnackedMessage, 0)
+	err := r.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(UNACKED_MESSAGES)); b != nil {
+			b.ForEach(func(k, v []byte) error {
+				var msg UnackedMessage
+				if err := json.Unmarshal(v, &msg); err != nil {
+					glog.Errorf(RMlogString(fmt.Sprintf("unable to demarshal unacked message %v, error %v", string(v), err)))
+				} else {
+					msgs = append(msgs, msg)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return msgs, err
+}
+
+func (r *ReliableMessenger) saveUnacked(msg UnackedMessage) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		if b, err := tx.CreateBucketIfNotExists([]byte(UNACKED_MESSAGES)); err != nil {
+			return err
+		} else if serial, err := json.Marshal(msg); err != nil {
+			return errors.New(fmt.Sprintf("unable to serialize unacked message %v, error %v", msg, err))
+		} else {
+			return b.Put([]byte(msg.MsgId), serial)
+		}
+	})
+}
+
+// Save the new retry state of a message, unless it was acknowledged while it was being sent again.
+func (r *ReliableMessenger) updateUnacked(msg UnackedMessage) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(UNACKED_MESSAGES)); b == nil || b.Get([]byte(msg.MsgId)) == nil {
+			return nil
+		} else if serial, err := json.Marshal(msg); err != nil {
+			return errors.New(fmt.Sprintf("unable to serialize unacked message %v, error %v", msg, err))
+		} else {
+			return b.Put([]byte(msg.MsgId), serial)
+		}
+	})
+}
+
+var RMlogString = func(v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("Basic Protocol Reliable Messaging", cutil.LogFields{cutil.LOG_PROTOCOL: PROTOCOL_NAME}, v)
+	}
+	return fmt.Sprintf("Basic Protocol Reliable Messaging: %v", v)
+}
@@ -0,0 +1,167 @@
+// +build unit
+
+package basicprotocol
+
+import (
+	"encoding/json"
+	"github.com/boltdb/bolt"
+	"github.com/open-horizon/anax/abstractprotocol"
+	"github.com/open-horizon/anax/events"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func reliableDB(t *testing.T) (*bolt.DB, func()) {
+	dir, err := ioutil.TempDir("", "basic-reliable")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	db, err := bolt.Open(path.Join(dir, "test.db"), 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("unable to open db: %v", err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func Test_ReliableMessageHeader(t *testing.T) {
+
+	ra := abstractprotocol.NewReplyAck(PROTOCOL_NAME, PROTOCOL_CURRENT_VERSION, true, "ag1")
+	pay, _ := json.Marshal(ra)
+	if h := ReliableMessageHeader(pay); h == nil || h.AgreementId() != "ag1" || CarriesAgreementVersion(h) {
+		t.Errorf("reply ack %v should be sent reliably, header %v", string(pay), h)
+	}
+
+	ack := NewBMessageAck(&abstractprotocol.BaseProtocolMessage{MsgType: MsgTypeMessageAck, AProtocol: PROTOCOL_NAME, AVersion: PROTOCOL_CURRENT_VERSION, AgreeId: "ag1"}, "m1")
+	pay, _ = json.Marshal(ack)
+	if h := ReliableMessageHeader(pay); h != nil {
+		t.Errorf("message ack %v should not be acknowledged", string(pay))
+	}
+
+	ph := NewProtocolHandler(nil, nil)
+	if a, err := ph.ValidateMessageAck(string(pay)); err != nil || a.MsgId != "m1" {
+		t.Errorf("message ack %v should be valid, error %v", string(pay), err)
+	} else if _, err := ph.ValidateMessageAck(`{"type":"replyack","protocol":"Basic","version":2,"agreementId":"ag1"}`); err == nil {
+		t.Errorf("reply ack should not be a valid message ack")
+	}
+
+	usage := NewBResourceUsage(&abstractprotocol.BaseProtocolMessage{MsgType: MsgTypeResourceUsage, AProtocol: PROTOCOL_NAME, AVersion: PROTOCOL_CURRENT_VERSION, AgreeId: "ag1"}, events.ResourceUsage{SampleTime: 1, Containers: 1, CPUPercent: 12.5})
+	pay, _ = json.Marshal(usage)
+	if h := ReliableMessageHeader(pay); h != nil {
+		t.Errorf("resource usage %v should not be sent reliably", string(pay))
+	} else if u, err := ph.ValidateResourceUsage(string(pay)); err != nil || u.Usage.CPUPercent != 12.5 {
+		t.Errorf("resource usage %v should be valid, error %v", string(pay), err)
+	} else if _, err := ph.ValidateResourceUsage(`{"type":"basicresourceusage","protocol":"Basic","version":2,"agreementId":"ag1"}`); err == nil {
+		t.Errorf("resource usage without a sample should not be valid")
+	}
+
+	pay, _ = json.Marshal(abstractprotocol.NewReplyAck("Citizen Scientist", 2, true, "ag1"))
+	if h := ReliableMessageHeader(pay); h != nil {
+		t.Errorf("message of another protocol %v should not be sent reliably", string(pay))
+	}
+}
+
+func Test_ReliableMessenger_send(t *testing.T) {
+
+	db, cleanup := reliableDB(t)
+	defer cleanup()
+
+	r := NewReliableMessenger(db)
+	r.retryIntervalS = 0
+	r.maxRetryIntervalS = 0
+	r.retryLimit = 3
+
+	pay, _ := json.Marshal(abstractprotocol.NewReplyAck(PROTOCOL_NAME, PROTOCOL_CURRENT_VERSION, true, "ag1"))
+
+	// The message is given a message id when it is sent. It is kept even when it could not be sent.
+	var sent []byte
+	if err := r.Send("ag1", "myorg/node1", pay, func(p []byte) error { sent = p; return nil }); err != nil {
+		t.Fatalf("unable to send message: %v", err)
+	} else if err := r.Send("ag2", "myorg/node2", pay, func(p []byte) error { return os.ErrClosed }); err != nil {
+		t.Fatalf("a message that could not be sent should be kept, error: %v", err)
+	}
+
+	msgId := GetMessageId(string(sent))
+	if msgId == "" {
+		t.Fatalf("sent message %v has no message id", string(sent))
+	} else if ra, err := abstractprotocol.ValidateReplyAck(string(sent)); err != nil || ra.AgreementId() != "ag1" {
+		t.Errorf("sent message %v should still be a reply ack, error %v", string(sent), err)
+	} else if msgs, err := r.UnackedMessages(); err != nil || len(msgs) != 2 {
+		t.Errorf("there should be 2 unacked messages, have %v, error %v", msgs, err)
+	}
+
+	// Once acknowledged, the message is not sent again.
+	if err := r.Acknowledged(msgId); err != nil {
+		t.Errorf("unable to acknowledge message: %v", err)
+	}
+
+	resent := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		r.Resend(func(receiverId string, p []byte) error {
+			resent[receiverId] += 1
+			if GetMessageId(string(p)) == "" {
+				t.Errorf("resent message %v has no message id", string(p))
+			}
+			return nil
+		})
+	}
+
+	// The unacknowledged message is dropped when it has been sent as many times as allowed.
+	if resent["myorg/node1"] != 0 || resent["myorg/node2"] != 2 {
+		t.Errorf("wrong messages sent again %v", resent)
+	} else if msgs, err := r.UnackedMessages(); err != nil || len(msgs) != 0 {
+		t.Errorf("there should be no unacked messages, have %v, error %v", msgs, err)
+	}
+}
+
+func Test_ReliableMessenger_backoff(t *testing.T) {
+
+	db, cleanup := reliableDB(t)
+	defer cleanup()
+
+	r := NewReliableMessenger(db)
+	r.retryIntervalS = 0
+
+	pay, _ := json.Marshal(abstractprotocol.NewReplyAck(PROTOCOL_NAME, PROTOCOL_CURRENT_VERSION, true, "ag1"))
+	r.Send("ag1", "myorg/node1", pay, func(p []byte) error { return nil })
+
+	// The next attempt is due right away, after that the delay doubles up to the maximum.
+	r.retryIntervalS = 100
+	r.Resend(func(receiverId string, p []byte) error { return nil })
+
+	if msgs, err := r.UnackedMessages(); err != nil || len(msgs) != 1 {
+		t.Fatalf("there should be 1 unacked message, have %v, error %v", msgs, err)
+	} else if delay := msgs[0].NextRetry - time.Now().Unix(); msgs[0].Attempts != 2 || delay < 190 || delay > 200 {
+		t.Errorf("message %v should be sent again in 200 seconds", msgs[0])
+	}
+
+	// Not due yet.
+	r.Resend(func(receiverId string, p []byte) error {
+		t.Errorf("message should not be sent again yet")
+		return nil
+	})
+}
+
+func Test_ReliableMessenger_received(t *testing.T) {
+
+	db, cleanup := reliableDB(t)
+	defer cleanup()
+
+	r := NewReliableMessenger(db)
+
+	if dup, err := r.Received("m1", 10); err != nil || dup {
+		t.Errorf("first message should not be a duplicate, error %v", err)
+	} else if dup, err := r.Received("m1", 10); err != nil || dup {
+		t.Errorf("the same exchange message read again should not be a duplicate, error %v", err)
+	} else if dup, err := r.Received("m1", 11); err != nil || !dup {
+		t.Errorf("the message sent again should be a duplicate, error %v", err)
+	} else if dup, err := r.Received("m2", 11); err != nil || dup {
+		t.Errorf("another message should not be a duplicate, error %v", err)
+	}
+}
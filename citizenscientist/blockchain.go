@@ -0,0 +1,77 @@
+package citizenscientist
+
+import (
+	"errors"
+	"fmt"
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/metering"
+	"github.com/open-horizon/anax/policy"
+	"net/http"
+)
+
+// The citizen scientist protocol records agreements and metering on a blockchain. The blockchain specific functions
+// are implemented by a provider for each blockchain type, the protocol handler of a blockchain instance uses the
+// provider of the instance's type. The type of the blockchain is chosen by the policy.
+type BlockchainProvider interface {
+	// Initialize the provider once the blockchain client of the instance is up and its account is funded.
+	InitBlockchain(ev *events.AccountFundedMessage) error
+
+	// Returns true when the provider has been initialized and can write to the blockchain.
+	Ready() bool
+
+	// The address of this party's account on the blockchain.
+	Address() string
+
+	// Sign the hash with this party's blockchain identity. The signature is returned as a hex string without a 0x prefix.
+	SignHash(hash string) (string, error)
+
+	// Write the agreement to the blockchain, with the hash of the terms and conditions and the signature of the counterparty.
+	RecordAgreement(agreementId string, tcHash []byte, signature string, address string) error
+
+	// Terminate the agreement with the counterparty on the blockchain.
+	TerminateAgreement(counterParty string, agreementId string, reason uint) error
+
+	// Returns the signature of the agreement that the counterparty wrote to the blockchain.
+	ProducerSignature(counterParty string, agreementId string) (string, error)
+
+	// Write the metering notification of the agreement to the blockchain.
+	RecordMeter(agreementId string, mn *metering.MeteringNotification) error
+
+	// Convert a raw event of the blockchain into a blockchain event.
+	DemarshalEvent(ev string) (*BlockchainEvent, error)
+}
+
+// The kinds of blockchain events that the protocol reacts to.
+const BC_EVENT_AGREEMENT_CREATED = "agreement_created"
+const BC_EVENT_CONSUMER_TERMINATED = "consumer_terminated"
+const BC_EVENT_PRODUCER_TERMINATED = "producer_terminated"
+const BC_EVENT_OTHER = "other"
+
+// A blockchain event, independent of the type of blockchain that it came from.
+type BlockchainEvent struct {
+	Kind        string `json:"type"`
+	AgreementId string `json:"agreementId"`
+	Reason      uint64 `json:"reason"`
+}
+
+func (e BlockchainEvent) String() string {
+	return fmt.Sprintf("Kind: %v, AgreementId: %v, Reason: %v", e.Kind, e.AgreementId, e.Reason)
+}
+
+// The providers of the blockchain types that the protocol supports.
+var blockchainProviders = map[string]func(httpClient *http.Client) BlockchainProvider{
+	policy.Ethereum_bc: func(httpClient *http.Client) BlockchainProvider { return NewEthereumProvider() },
+	policy.Fabric_bc:   func(httpClient *http.Client) BlockchainProvider { return NewFabricProvider(httpClient) },
+}
+
+// Returns a new, uninitialized provider for the blockchain type. An empty type is the default type of the protocol.
+func NewBlockchainProvider(bcType string, httpClient *http.Client) (BlockchainProvider, error) {
+	if bcType == "" {
+		bcType = policy.RequiresBlockchainType(PROTOCOL_NAME)
+	}
+	if f, ok := blockchainProviders[bcType]; !ok {
+		return nil, errors.New(fmt.Sprintf("%v Protocol does not support blockchain type %v", PROTOCOL_NAME, bcType))
+	} else {
+		return f(httpClient), nil
+	}
+}
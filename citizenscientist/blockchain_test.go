@@ -0,0 +1,117 @@
+// +build unit
+
+package citizenscientist
+
+import (
+	"encoding/json"
+	"github.com/open-horizon/anax/events"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func Test_NewBlockchainProvider(t *testing.T) {
+
+	if p, err := NewBlockchainProvider("", nil); err != nil {
+		t.Errorf("the default blockchain type should be supported, error %v", err)
+	} else if _, ok := p.(*EthereumProvider); !ok {
+		t.Errorf("the default provider should be ethereum, is %T", p)
+	}
+
+	if p, err := NewBlockchainProvider("fabric", nil); err != nil {
+		t.Errorf("fabric should be supported, error %v", err)
+	} else if _, ok := p.(*FabricProvider); !ok {
+		t.Errorf("the fabric provider should be returned, is %T", p)
+	}
+
+	if _, err := NewBlockchainProvider("fred", nil); err == nil {
+		t.Errorf("blockchain type fred should not be supported")
+	}
+}
+
+// The events of both types of blockchain are demarshalled into the same form.
+func Test_DemarshalEvent(t *testing.T) {
+
+	ph := NewProtocolHandler(nil, nil)
+
+	ethEvent := `{"topics":["` + AGREEMENT_CONSUMER_TERM + `","0x01","0x02","0xdeadbeef"],"data":"0xc8"}`
+	if ev, err := ph.DemarshalEvent("ethereum", ethEvent); err != nil {
+		t.Errorf("unable to demarshal %v, error %v", ethEvent, err)
+	} else if !ph.ConsumerTermination(ev) || ph.GetAgreementId(ev) != "deadbeef" {
+		t.Errorf("wrong event %v from %v", ev, ethEvent)
+	} else if reason, _ := ph.GetReasonCode(ev); reason != 200 {
+		t.Errorf("wrong reason %v from %v", reason, ethEvent)
+	}
+
+	fabricEvent := `{"type":"agreement_created","agreementId":"deadbeef"}`
+	if ev, err := ph.DemarshalEvent("fabric", fabricEvent); err != nil {
+		t.Errorf("unable to demarshal %v, error %v", fabricEvent, err)
+	} else if !ph.AgreementCreated(ev) || ph.GetAgreementId(ev) != "deadbeef" {
+		t.Errorf("wrong event %v from %v", ev, fabricEvent)
+	}
+
+	if ev, err := ph.DemarshalEvent("fabric", `{"type":"block_committed"}`); err != nil || ev.Kind != BC_EVENT_OTHER {
+		t.Errorf("an unknown fabric event should be ignored, got %v, error %v", ev, err)
+	} else if _, err := ph.DemarshalEvent("fabric", `{"type":"agreement_created"}`); err == nil {
+		t.Errorf("an agreement event without an agreement id should be an error")
+	}
+}
+
+// The fabric provider records agreements through the gateway of the blockchain instance.
+func Test_FabricProvider(t *testing.T) {
+
+	recorded := make(map[string]string)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch r.Method + " " + r.URL.Path {
+		case "GET /identity":
+			w.Write([]byte(`{"address":"agbot1"}`))
+		case "POST /sign":
+			w.Write([]byte(`{"signature":"0xabcd"}`))
+		case "POST /agreements", "POST /agreements/deadbeef/terminate":
+			recorded[r.URL.Path] = string(body)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	ev := events.NewAccountFundedMessage(events.ACCOUNT_FUNDED, "", "fabric", "bc1", "myorg", u.Hostname(), u.Port(), "")
+
+	ph := NewProtocolHandler(http.DefaultClient, nil)
+	if ph.BlockchainReady() {
+		t.Errorf("the blockchain should not be ready before it is initialized")
+	} else if err := ph.InitBlockchain(ev); err != nil {
+		t.Fatalf("unable to initialize the fabric provider, error %v", err)
+	} else if !ph.BlockchainReady() || ph.MyAddress != "agbot1" {
+		t.Errorf("the fabric provider is not initialized, address %v", ph.MyAddress)
+	}
+
+	if sig, err := ph.signHash("1234"); err != nil || sig != "abcd" {
+		t.Errorf("wrong signature %v, error %v", sig, err)
+	}
+
+	if err := ph.Provider.RecordAgreement("deadbeef", []byte{1, 2}, "abcd", "node1"); err != nil {
+		t.Errorf("unable to record the agreement, error %v", err)
+	} else {
+		ag := new(fabricAgreement)
+		json.Unmarshal([]byte(recorded["/agreements"]), ag)
+		if ag.AgreementId != "deadbeef" || ag.Hash != "0102" || ag.Signature != "abcd" || ag.Address != "node1" {
+			t.Errorf("wrong agreement recorded %v", recorded["/agreements"])
+		}
+	}
+
+	if err := ph.Provider.TerminateAgreement("node1", "deadbeef", AB_USER_REQUESTED); err != nil {
+		t.Errorf("unable to terminate the agreement, error %v", err)
+	} else if _, ok := recorded["/agreements/deadbeef/terminate"]; !ok {
+		t.Errorf("the termination was not sent to the gateway")
+	}
+
+	if _, err := ph.Provider.ProducerSignature("node1", "deadbeef"); err == nil {
+		t.Errorf("an error from the gateway should be returned")
+	}
+}
@@ -0,0 +1,192 @@
+package citizenscientist
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/ethblockchain"
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/metering"
+	"github.com/open-horizon/go-solidity/contract_api"
+	"strconv"
+)
+
+// The ethereum provider uses the agreement and metering contracts of the platform, through the geth client of the
+// blockchain instance.
+type EthereumProvider struct {
+	GethURL              string
+	ColonusDir           string
+	MyAddress            string
+	EthAgreementContract *contract_api.SolidityContract
+	EthMeterContract     *contract_api.SolidityContract
+}
+
+func NewEthereumProvider() *EthereumProvider {
+	return &EthereumProvider{}
+}
+
+func (e *EthereumProvider) String() string {
+	return fmt.Sprintf("GethURL: %v, ColonusDir: %v, Address: %v", e.GethURL, e.ColonusDir, e.MyAddress)
+}
+
+func (e *EthereumProvider) InitBlockchain(ev *events.AccountFundedMessage) error {
+
+	e.GethURL = fmt.Sprintf("http://%v:%v", ev.ServiceName(), ev.ServicePort())
+
+	acct, _ := ethblockchain.AccountId(ev.ColonusDir())
+
+	dir, _ := ethblockchain.DirectoryAddress(ev.ColonusDir())
+	bc, err := ethblockchain.InitBaseContracts(acct, e.GethURL, dir)
+	if err != nil {
+		return errors.New(fmt.Sprintf("%v Protocol Handler unable to initialize platform contracts, error: %v", PROTOCOL_NAME, err))
+	}
+
+	e.MyAddress = acct
+	e.EthAgreementContract = bc.Agreements
+	e.EthMeterContract = bc.Metering
+	e.ColonusDir = ev.ColonusDir()
+
+	return nil
+}
+
+func (e *EthereumProvider) Ready() bool {
+	return e.EthAgreementContract != nil
+}
+
+func (e *EthereumProvider) Address() string {
+	return e.MyAddress
+}
+
+func (e *EthereumProvider) SignHash(hash string) (string, error) {
+	if signature, err := ethblockchain.SignHash(hash, e.ColonusDir, e.GethURL); err != nil {
+		return "", err
+	} else if len(signature) <= 2 {
+		return "", errors.New(fmt.Sprintf("received incorrect signature %v from eth_sign.", signature))
+	} else {
+		return signature[2:], nil
+	}
+}
+
+func (e *EthereumProvider) RecordAgreement(agreementId string, tcHash []byte, signature string, address string) error {
+
+	if binaryAgreementId, err := hex.DecodeString(agreementId); err != nil {
+		return errors.New(fmt.Sprintf("Error converting agreement ID %v to binary, error: %v", agreementId, err))
+	} else {
+		params := make([]interface{}, 0, 10)
+		params = append(params, binaryAgreementId)
+		params = append(params, tcHash)
+		params = append(params, signature)
+		params = append(params, address)
+
+		if _, err := e.EthAgreementContract.Invoke_method("create_agreement", params); err != nil {
+			return errors.New(fmt.Sprintf("Error invoking create_agreement %v with %v, error: %v", agreementId, params, err))
+		}
+	}
+	return nil
+}
+
+func (e *EthereumProvider) TerminateAgreement(counterParty string, agreementId string, reason uint) error {
+
+	if binaryAgreementId, err := hex.DecodeString(agreementId); err != nil {
+		return errors.New(fmt.Sprintf("Error converting agreement ID %v to binary, error: %v", agreementId, err))
+	} else {
+		params := make([]interface{}, 0, 10)
+		params = append(params, counterParty)
+		params = append(params, binaryAgreementId)
+		params = append(params, int(reason))
+
+		if _, err := e.EthAgreementContract.Invoke_method("terminate_agreement", params); err != nil {
+			return errors.New(fmt.Sprintf("Error invoking terminate_agreement %v with %v, error: %v", agreementId, params, err))
+		}
+	}
+	return nil
+}
+
+func (e *EthereumProvider) ProducerSignature(counterParty string, agreementId string) (string, error) {
+
+	if binaryAgreementId, err := hex.DecodeString(agreementId); err != nil {
+		return "", errors.New(fmt.Sprintf("Error converting agreement ID %v to binary, error: %v", agreementId, err))
+	} else {
+		params := make([]interface{}, 0, 10)
+		params = append(params, counterParty)
+		params = append(params, binaryAgreementId)
+
+		if returnedSig, err := e.EthAgreementContract.Invoke_method("get_producer_signature", params); err != nil {
+			return "", errors.New(fmt.Sprintf("Error invoking get_contract_signature for %v with %v, error: %v", agreementId, params, err))
+		} else {
+			return hex.EncodeToString(returnedSig.([]byte)), nil
+		}
+	}
+}
+
+func (e *EthereumProvider) RecordMeter(agreementId string, mn *metering.MeteringNotification) error {
+
+	if binaryAgreementId, err := hex.DecodeString(agreementId); err != nil {
+		return errors.New(fmt.Sprintf("Error converting agreement ID %v to binary, error: %v", agreementId, err))
+	} else if e.EthMeterContract != nil {
+		glog.V(5).Infof("CS Protocol writing Metering Notification %v to the blockchain for %v.", *mn, agreementId)
+		params := make([]interface{}, 0, 10)
+		params = append(params, mn.Amount)
+		params = append(params, mn.CurrentTime)
+		params = append(params, binaryAgreementId)
+		params = append(params, mn.GetMeterHash()[2:])
+		params = append(params, mn.ConsumerMeterSignature)
+		params = append(params, mn.AgreementHash)
+		params = append(params, mn.ProducerSignature)
+		params = append(params, mn.ConsumerSignature)
+		params = append(params, mn.ConsumerAddress)
+		if _, err = e.EthMeterContract.Invoke_method("create_meter", params); err != nil {
+			return errors.New(fmt.Sprintf("Error invoking create_meter %v with %v, error: %v", agreementId, params, err))
+		}
+	} else {
+		glog.V(3).Infof(fmt.Sprintf("CS Protocol skipping blockchain metering record for %v, because the metering contract is not initialized.", agreementId))
+	}
+	return nil
+}
+
+// The topics of the events of the agreement contract.
+const AGREEMENT_CREATE = "0x0000000000000000000000000000000000000000000000000000000000000000"
+const AGREEMENT_DETAIL = "0x0000000000000000000000000000000000000000000000000000000000000001"
+const AGREEMENT_FRAUD = "0x0000000000000000000000000000000000000000000000000000000000000002"
+const AGREEMENT_CONSUMER_TERM = "0x0000000000000000000000000000000000000000000000000000000000000003"
+const AGREEMENT_PRODUCER_TERM = "0x0000000000000000000000000000000000000000000000000000000000000004"
+const AGREEMENT_FRAUD_TERM = "0x0000000000000000000000000000000000000000000000000000000000000005"
+const AGREEMENT_ADMIN_TERM = "0x0000000000000000000000000000000000000000000000000000000000000006"
+
+// The first topic of an event of the agreement contract is the kind of event and the fourth is the agreement id.
+// The data of a termination event is the reason code.
+func (e *EthereumProvider) DemarshalEvent(ev string) (*BlockchainEvent, error) {
+	rawEvent := new(ethblockchain.Raw_Event)
+	if err := json.Unmarshal([]byte(ev), rawEvent); err != nil {
+		return nil, err
+	} else if len(rawEvent.Topics) == 0 {
+		return nil, errors.New(fmt.Sprintf("event %v has no topics", ev))
+	}
+
+	bcEvent := &BlockchainEvent{Kind: BC_EVENT_OTHER}
+	switch rawEvent.Topics[0] {
+	case AGREEMENT_CREATE:
+		bcEvent.Kind = BC_EVENT_AGREEMENT_CREATED
+	case AGREEMENT_CONSUMER_TERM:
+		bcEvent.Kind = BC_EVENT_CONSUMER_TERMINATED
+	case AGREEMENT_PRODUCER_TERM:
+		bcEvent.Kind = BC_EVENT_PRODUCER_TERMINATED
+	}
+
+	if len(rawEvent.Topics) > 3 && len(rawEvent.Topics[3]) > 2 {
+		bcEvent.AgreementId = rawEvent.Topics[3][2:]
+	}
+
+	if bcEvent.Kind == BC_EVENT_CONSUMER_TERMINATED || bcEvent.Kind == BC_EVENT_PRODUCER_TERMINATED {
+		if len(rawEvent.Data) <= 2 {
+			return nil, errors.New(fmt.Sprintf("termination event %v has no reason code", ev))
+		} else if reason, err := strconv.ParseUint(rawEvent.Data[2:], 16, 64); err != nil {
+			return nil, errors.New(fmt.Sprintf("unable to retrieve reason code from %v, error %v", ev, err))
+		} else {
+			bcEvent.Reason = reason
+		}
+	}
+	return bcEvent, nil
+}
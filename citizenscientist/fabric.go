@@ -0,0 +1,199 @@
+package citizenscientist
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/metering"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// The Hyperledger Fabric provider records the agreements through the REST gateway of the blockchain instance, which
+// invokes the agreement chaincode of the channel with the Fabric identity of this party. The gateway API is:
+//
+//	GET  /identity                                       -> {"address": "..."}
+//	POST /sign {"hash": "..."}                           -> {"signature": "..."}
+//	POST /agreements {"agreementId", "hash", "signature", "address"}
+//	POST /agreements/<id>/terminate {"counterParty", "reason"}
+//	GET  /agreements/<id>/signature?counterParty=<addr> -> {"signature": "..."}
+//	POST /meters <metering notification>
+//
+// The chaincode events of the gateway are the json form of BlockchainEvent.
+type FabricProvider struct {
+	httpClient *http.Client
+	GatewayURL string
+	MyAddress  string
+}
+
+func NewFabricProvider(httpClient *http.Client) *FabricProvider {
+	return &FabricProvider{
+		httpClient: httpClient,
+	}
+}
+
+func (f *FabricProvider) String() string {
+	return fmt.Sprintf("GatewayURL: %v, Address: %v", f.GatewayURL, f.MyAddress)
+}
+
+type fabricIdentity struct {
+	Address string `json:"address"`
+}
+
+type fabricSignature struct {
+	Hash      string `json:"hash,omitempty"`
+	Signature string `json:"signature"`
+}
+
+type fabricAgreement struct {
+	AgreementId string `json:"agreementId"`
+	Hash        string `json:"hash"`
+	Signature   string `json:"signature"`
+	Address     string `json:"address"`
+}
+
+type fabricTermination struct {
+	CounterParty string `json:"counterParty"`
+	Reason       uint   `json:"reason"`
+}
+
+func (f *FabricProvider) InitBlockchain(ev *events.AccountFundedMessage) error {
+
+	gatewayURL := fmt.Sprintf("http://%v:%v", ev.ServiceName(), ev.ServicePort())
+
+	id := new(fabricIdentity)
+	if err := f.invoke(gatewayURL, http.MethodGet, "identity", nil, id); err != nil {
+		return errors.New(fmt.Sprintf("%v Protocol Handler unable to get the fabric identity, error: %v", PROTOCOL_NAME, err))
+	} else if id.Address == "" {
+		return errors.New(fmt.Sprintf("%v Protocol Handler received an empty fabric identity from %v", PROTOCOL_NAME, gatewayURL))
+	}
+
+	f.GatewayURL = gatewayURL
+	f.MyAddress = id.Address
+	return nil
+}
+
+func (f *FabricProvider) Ready() bool {
+	return f.GatewayURL != ""
+}
+
+func (f *FabricProvider) Address() string {
+	return f.MyAddress
+}
+
+func (f *FabricProvider) SignHash(hash string) (string, error) {
+	sig := new(fabricSignature)
+	if err := f.invoke(f.GatewayURL, http.MethodPost, "sign", fabricSignature{Hash: hash}, sig); err != nil {
+		return "", err
+	} else if sig.Signature == "" {
+		return "", errors.New(fmt.Sprintf("received an empty signature of %v from the fabric gateway", hash))
+	} else {
+		return strings.TrimPrefix(sig.Signature, "0x"), nil
+	}
+}
+
+func (f *FabricProvider) RecordAgreement(agreementId string, tcHash []byte, signature string, address string) error {
+	ag := fabricAgreement{
+		AgreementId: agreementId,
+		Hash:        hex.EncodeToString(tcHash),
+		Signature:   signature,
+		Address:     address,
+	}
+	if err := f.invoke(f.GatewayURL, http.MethodPost, "agreements", ag, nil); err != nil {
+		return errors.New(fmt.Sprintf("Error recording agreement %v on fabric, error: %v", agreementId, err))
+	}
+	return nil
+}
+
+func (f *FabricProvider) TerminateAgreement(counterParty string, agreementId string, reason uint) error {
+	term := fabricTermination{
+		CounterParty: counterParty,
+		Reason:       reason,
+	}
+	if err := f.invoke(f.GatewayURL, http.MethodPost, "agreements/"+url.PathEscape(agreementId)+"/terminate", term, nil); err != nil {
+		return errors.New(fmt.Sprintf("Error terminating agreement %v on fabric, error: %v", agreementId, err))
+	}
+	return nil
+}
+
+func (f *FabricProvider) ProducerSignature(counterParty string, agreementId string) (string, error) {
+	sig := new(fabricSignature)
+	resource := "agreements/" + url.PathEscape(agreementId) + "/signature?counterParty=" + url.QueryEscape(counterParty)
+	if err := f.invoke(f.GatewayURL, http.MethodGet, resource, nil, sig); err != nil {
+		return "", errors.New(fmt.Sprintf("Error getting the signature of agreement %v from fabric, error: %v", agreementId, err))
+	}
+	return strings.TrimPrefix(sig.Signature, "0x"), nil
+}
+
+func (f *FabricProvider) RecordMeter(agreementId string, mn *metering.MeteringNotification) error {
+	glog.V(5).Infof("CS Protocol writing Metering Notification %v to fabric for %v.", *mn, agreementId)
+	if err := f.invoke(f.GatewayURL, http.MethodPost, "meters", mn, nil); err != nil {
+		return errors.New(fmt.Sprintf("Error recording meter of agreement %v on fabric, error: %v", agreementId, err))
+	}
+	return nil
+}
+
+func (f *FabricProvider) DemarshalEvent(ev string) (*BlockchainEvent, error) {
+	bcEvent := new(BlockchainEvent)
+	if err := json.Unmarshal([]byte(ev), bcEvent); err != nil {
+		return nil, err
+	}
+	switch bcEvent.Kind {
+	case BC_EVENT_AGREEMENT_CREATED, BC_EVENT_CONSUMER_TERMINATED, BC_EVENT_PRODUCER_TERMINATED:
+		if bcEvent.AgreementId == "" {
+			return nil, errors.New(fmt.Sprintf("event %v has no agreement id", ev))
+		}
+	default:
+		bcEvent.Kind = BC_EVENT_OTHER
+	}
+	return bcEvent, nil
+}
+
+// Send a request to the fabric gateway, the input and output are json. A nil output ignores the response body.
+func (f *FabricProvider) invoke(gatewayURL string, method string, resource string, input interface{}, output interface{}) error {
+
+	if gatewayURL == "" {
+		return errors.New(fmt.Sprintf("the fabric gateway is not initialized"))
+	}
+
+	var body *bytes.Reader
+	if input != nil {
+		if b, err := json.Marshal(input); err != nil {
+			return errors.New(fmt.Sprintf("unable to marshal %v, error: %v", input, err))
+		} else {
+			body = bytes.NewReader(b)
+		}
+	} else {
+		body = bytes.NewReader([]byte{})
+	}
+
+	req, err := http.NewRequest(method, gatewayURL+"/"+resource, body)
+	if err != nil {
+		return errors.New(fmt.Sprintf("unable to create %v request for %v, error: %v", method, resource, err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return errors.New(fmt.Sprintf("%v %v failed, error: %v", method, resource, err))
+	}
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.New(fmt.Sprintf("unable to read the response of %v %v, error: %v", method, resource, err))
+	} else if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return errors.New(fmt.Sprintf("%v %v returned %v: %v", method, resource, resp.StatusCode, string(content)))
+	} else if output != nil {
+		if err := json.Unmarshal(content, output); err != nil {
+			return errors.New(fmt.Sprintf("unable to demarshal the response of %v %v, error: %v", method, resource, err))
+		}
+	}
+	return nil
+}
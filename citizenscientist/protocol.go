@@ -7,14 +7,11 @@ import (
 	"fmt"
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/abstractprotocol"
-	"github.com/open-horizon/anax/ethblockchain"
 	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/metering"
 	"github.com/open-horizon/anax/policy"
-	"github.com/open-horizon/go-solidity/contract_api"
 	"golang.org/x/crypto/sha3"
 	"net/http"
-	"strconv"
 )
 
 const PROTOCOL_NAME = "Citizen Scientist"
@@ -198,14 +195,12 @@ func NewCSBlockchainProducerUpdateAck(bp *abstractprotocol.BaseProtocolMessage)
 }
 
 // This is the object which users of the agreement protocol use to get access to the protocol functions. It MUST
-// implement all the functions in the abstract ProtocolHandler interface.
+// implement all the functions in the abstract ProtocolHandler interface. The blockchain specific functions are done
+// by the provider of the blockchain type, which is set when the blockchain is initialized.
 type ProtocolHandler struct {
 	*abstractprotocol.BaseProtocolHandler
-	GethURL              string
-	ColonusDir           string
-	MyAddress            string
-	EthAgreementContract *contract_api.SolidityContract
-	EthMeterContract     *contract_api.SolidityContract
+	MyAddress string
+	Provider  BlockchainProvider
 }
 
 func NewProtocolHandler(httpClient *http.Client, pm *policy.PolicyManager) *ProtocolHandler {
@@ -216,36 +211,40 @@ func NewProtocolHandler(httpClient *http.Client, pm *policy.PolicyManager) *Prot
 		pm)
 
 	return &ProtocolHandler{
-		BaseProtocolHandler:  bph,
-		GethURL:              "",
-		ColonusDir:           "",
-		MyAddress:            "",
-		EthAgreementContract: nil,
-		EthMeterContract:     nil,
+		BaseProtocolHandler: bph,
+		MyAddress:           "",
+		Provider:            nil,
 	}
 }
 
 func (p *ProtocolHandler) InitBlockchain(ev *events.AccountFundedMessage) error {
 
-	p.GethURL = fmt.Sprintf("http://%v:%v", ev.ServiceName(), ev.ServicePort())
-
-	acct, _ := ethblockchain.AccountId(ev.ColonusDir())
-
-	dir, _ := ethblockchain.DirectoryAddress(ev.ColonusDir())
-	bc, err := ethblockchain.InitBaseContracts(acct, p.GethURL, dir)
+	provider, err := NewBlockchainProvider(ev.BlockchainType(), p.HTTPClient())
 	if err != nil {
-		return errors.New(fmt.Sprintf("%v Protocol Handler unable to initialize platform contracts, error: %v", PROTOCOL_NAME, err))
+		return err
+	} else if err := provider.InitBlockchain(ev); err != nil {
+		return err
 	}
 
-	p.MyAddress = acct
-	p.EthAgreementContract = bc.Agreements
-	p.EthMeterContract = bc.Metering
-	p.ColonusDir = ev.ColonusDir()
+	p.Provider = provider
+	p.MyAddress = provider.Address()
 
 	return nil
 
 }
 
+// Returns true when the blockchain of the handler is initialized.
+func (p *ProtocolHandler) BlockchainReady() bool {
+	return p.Provider != nil && p.Provider.Ready()
+}
+
+func (p *ProtocolHandler) signHash(hash string) (string, error) {
+	if p.Provider == nil {
+		return "", errors.New(fmt.Sprintf("the blockchain is not initialized"))
+	}
+	return p.Provider.SignHash(hash)
+}
+
 // The implementation of this protocol method handles multiple versions of the protocol depending on which versions are supported
 // by both parties. Each protocol version behaves slightly differently WRT the fields it fills in on the initial proposal.
 // In V1, the proposal has the ethereum specific address of the consumer.
@@ -259,6 +258,7 @@ func (p *ProtocolHandler) InitiateAgreement(agreementId string,
 	workload *policy.Workload,
 	defaultPW string,
 	defaultNoData uint64,
+	proposalTimeoutS uint64,
 	sendMessage func(msgTarget interface{}, pay []byte) error) (abstractprotocol.Proposal, error) {
 
 	// Determine which protocol version to use.
@@ -267,7 +267,7 @@ func (p *ProtocolHandler) InitiateAgreement(agreementId string,
 	// Create a proposal and augment it with the additional data we need in this protocol.
 	var newProposal *CSProposal
 
-	if bp, err := abstractprotocol.CreateProposal(p, agreementId, producerPolicy, consumerPolicy, protocolVersion, myId, workload, defaultPW, defaultNoData); err != nil {
+	if bp, err := abstractprotocol.CreateProposal(p, agreementId, producerPolicy, consumerPolicy, protocolVersion, myId, workload, defaultPW, defaultNoData, proposalTimeoutS); err != nil {
 		return nil, err
 	} else if protocolVersion == 2 {
 		newProposal = NewCSProposal(bp, "")
@@ -285,8 +285,8 @@ func (p *ProtocolHandler) InitiateAgreement(agreementId string,
 
 }
 
-// This is an extra method in the citizen scientist protocol that is not part of the base agrement protocol because it is ethereum specific.
-// The hash and ethereum signature of the propsal are needed to support metering.
+// This is an extra method in the citizen scientist protocol that is not part of the base agrement protocol because it is blockchain specific.
+// The hash and blockchain signature of the propsal are needed to support metering.
 func (p *ProtocolHandler) SignProposal(newProposal abstractprotocol.Proposal) (string, string, error) {
 	// Save the hash and our signature of it for later usage
 	sig := ""
@@ -294,18 +294,17 @@ func (p *ProtocolHandler) SignProposal(newProposal abstractprotocol.Proposal) (s
 	hash := hex.EncodeToString(hashBytes[:])
 	glog.V(5).Infof(fmt.Sprintf("Protocol %v using hash %v with agreement %v", p.Name(), hash, newProposal.AgreementId()))
 
-	if signature, err := ethblockchain.SignHash(hash, p.ColonusDir, p.GethURL); err != nil {
+	if signature, err := p.signHash(hash); err != nil {
 		return "", "", errors.New(fmt.Sprintf("received error signing hash %v, error %v", hash, err))
-	} else if len(signature) <= 2 {
-		return "", "", errors.New(fmt.Sprintf("received incorrect signature %v from eth_sign.", signature))
 	} else {
-		sig = signature[2:]
+		sig = signature
 	}
 	return hash, sig, nil
 }
 
-// This is an implementation of the Decide on proposal API. It has been extended to support ethereum and a signature
-// of the proposal from the producer.
+// This is an implementation of the Decide on proposal API. It has been extended to choose one of the blockchains of the
+// proposal and a signature of the proposal from the producer. The running blockchains are described by their type,
+// name and org.
 func (p *ProtocolHandler) DecideOnProposal(proposal abstractprotocol.Proposal,
 	myId string,
 	myOrg string,
@@ -323,13 +322,26 @@ func (p *ProtocolHandler) DecideOnProposal(proposal abstractprotocol.Proposal,
 			replyErr = errors.New(fmt.Sprintf("Protocol %v decide on proposal received error demarshalling TsAndCs, %v", p.Name(), err))
 			newReply.DoNotAcceptProposal()
 		} else {
+			defaultType := policy.RequiresBlockchainType(p.Name())
 			bcChoices := tcPolicy.AgreementProtocols[0].Blockchains
 			bcRunning := (*new(policy.BlockchainList))
 			for _, bc := range runningBlockchains {
-				bcRunning.Add_Blockchain(policy.Blockchain_Factory(policy.Ethereum_bc, bc["name"], bc["org"]))
+				bcType := bc["type"]
+				if bcType == "" {
+					bcType = defaultType
+				}
+				bcRunning.Add_Blockchain(policy.Blockchain_Factory(bcType, bc["name"], bc["org"]))
+			}
+			if bcIntersect, err := bcRunning.Intersects_With(&bcChoices, defaultType, policy.Default_Blockchain_org); err != nil || len(*bcIntersect) == 0 {
+				replyErr = errors.New(fmt.Sprintf("Protocol %v decide on proposal found no usable blockchain in %v, error: %v", p.Name(), bcChoices, err))
+				newReply.DoNotAcceptProposal()
+			} else {
+				bcType := (*bcIntersect)[0].Type
+				if bcType == "" {
+					bcType = defaultType
+				}
+				newReply.SetBlockchain(bcType, (*bcIntersect)[0].Name, (*bcIntersect)[0].Org)
 			}
-			bcIntersect, _ := bcRunning.Intersects_With(&bcChoices, policy.Ethereum_bc, policy.Default_Blockchain_org)
-			newReply.SetBlockchain(policy.Ethereum_bc, (*bcIntersect)[0].Name, (*bcIntersect)[0].Org)
 		}
 
 	}
@@ -452,12 +464,10 @@ func (p *ProtocolHandler) NotifyMetering(agreementId string,
 	hash := mn.GetMeterHash()
 	glog.V(5).Infof("CS Protocol signing hash %v for %v, metering notification %v", hash, agreementId, mn)
 	sig := ""
-	if signature, err := ethblockchain.SignHash(hash, p.ColonusDir, p.GethURL); err != nil {
+	if signature, err := p.signHash(hash); err != nil {
 		return "", errors.New(fmt.Sprintf("CS Protocol sending meter notification received error signing hash %v, error %v", hash, err))
-	} else if len(signature) <= 2 {
-		return "", errors.New(fmt.Sprintf("CS Protocol sending meter notification received incorrect signature %v from eth_sign.", signature))
 	} else {
-		sig = signature[2:]
+		sig = signature
 	}
 
 	mn.SetConsumerMeterSignature(sig)
@@ -601,8 +611,10 @@ func (p *ProtocolHandler) RecordAgreement(newProposal abstractprotocol.Proposal,
 		signature = csReply.Signature
 	}
 
-	if binaryAgreementId, err := hex.DecodeString(newProposal.AgreementId()); err != nil {
+	if _, err := hex.DecodeString(newProposal.AgreementId()); err != nil {
 		return errors.New(fmt.Sprintf("Error converting agreement ID %v to binary, error: %v", newProposal.AgreementId(), err))
+	} else if !p.BlockchainReady() {
+		return errors.New(fmt.Sprintf("Error recording agreement %v, the blockchain is not initialized", newProposal.AgreementId()))
 	} else {
 
 		// Tell the policy manager that we're in this agreement
@@ -613,14 +625,8 @@ func (p *ProtocolHandler) RecordAgreement(newProposal abstractprotocol.Proposal,
 		tcHash := sha3.Sum256([]byte(newProposal.TsAndCs()))
 		glog.V(5).Infof("CS Protocol using hash %v to record agreement %v", hex.EncodeToString(tcHash[:]), newProposal.AgreementId())
 
-		params := make([]interface{}, 0, 10)
-		params = append(params, binaryAgreementId)
-		params = append(params, tcHash[:])
-		params = append(params, signature)
-		params = append(params, address)
-
-		if _, err := p.EthAgreementContract.Invoke_method("create_agreement", params); err != nil {
-			return errors.New(fmt.Sprintf("Error invoking create_agreement %v with %v, error: %v", newProposal.AgreementId(), params, err))
+		if err := p.Provider.RecordAgreement(newProposal.AgreementId(), tcHash[:], signature, address); err != nil {
+			return err
 		}
 	}
 
@@ -636,7 +642,7 @@ func (p *ProtocolHandler) TerminateAgreement(policies []policy.Policy,
 	messageTarget interface{},
 	sendMessage func(mt interface{}, pay []byte) error) error {
 
-	if binaryAgreementId, err := hex.DecodeString(agreementId); err != nil {
+	if _, err := hex.DecodeString(agreementId); err != nil {
 		return errors.New(fmt.Sprintf("Error converting agreement ID %v to binary, error: %v", agreementId, err))
 	} else {
 
@@ -647,18 +653,12 @@ func (p *ProtocolHandler) TerminateAgreement(policies []policy.Policy,
 
 		// If the cancel reason is due to a blockchain write failure, then we dont need to do the cancel on the blockchain.
 		// If the blockchain is not ready yet, then we dont need to send a cancel to it.
-		if p.EthAgreementContract != nil && counterParty != "" && reason != AB_CANCEL_BC_WRITE_FAILED {
-			// Setup parameters for call to the blockchain
-			params := make([]interface{}, 0, 10)
-			params = append(params, counterParty)
-			params = append(params, binaryAgreementId)
-			params = append(params, int(reason))
-
-			if _, err := p.EthAgreementContract.Invoke_method("terminate_agreement", params); err != nil {
-				return errors.New(fmt.Sprintf("Error invoking terminate_agreement %v with %v, error: %v", agreementId, params, err))
+		if p.BlockchainReady() && counterParty != "" && reason != AB_CANCEL_BC_WRITE_FAILED {
+			if err := p.Provider.TerminateAgreement(counterParty, agreementId, reason); err != nil {
+				return err
 			}
 		} else {
-			glog.V(3).Infof(fmt.Sprintf("Protocol %v skipping blockchain cancel for %v, Agreement Contract %v Counterparty: %v Reason :%v", p.Name(), agreementId, p.EthAgreementContract, counterParty, reason))
+			glog.V(3).Infof(fmt.Sprintf("Protocol %v skipping blockchain cancel for %v, Blockchain ready: %v Counterparty: %v Reason :%v", p.Name(), agreementId, p.BlockchainReady(), counterParty, reason))
 		}
 	}
 
@@ -672,25 +672,19 @@ func (p *ProtocolHandler) VerifyAgreement(agreementId string,
 	messageTarget interface{},
 	sendMessage func(mt interface{}, pay []byte) error) (bool, error) {
 
-	if binaryAgreementId, err := hex.DecodeString(agreementId); err != nil {
+	if _, err := hex.DecodeString(agreementId); err != nil {
 		return false, errors.New(fmt.Sprintf("Error converting agreement ID %v to binary, error: %v", agreementId, err))
+	} else if !p.BlockchainReady() {
+		return false, errors.New(fmt.Sprintf("Error verifying agreement %v, the blockchain is not initialized", agreementId))
+	} else if sigString, err := p.Provider.ProducerSignature(counterPartyAddress, agreementId); err != nil {
+		return false, err
 	} else {
-
-		params := make([]interface{}, 0, 10)
-		params = append(params, counterPartyAddress)
-		params = append(params, binaryAgreementId)
-
-		if returnedSig, err := p.EthAgreementContract.Invoke_method("get_producer_signature", params); err != nil {
-			return false, errors.New(fmt.Sprintf("Error invoking get_contract_signature for %v with %v, error: %v", agreementId, params, err))
+		glog.V(5).Infof("Verify agreement for %v with %v returned signature: %v", agreementId, counterPartyAddress, sigString)
+		if sigString == expectedSignature {
+			return true, nil
 		} else {
-			sigString := hex.EncodeToString(returnedSig.([]byte))
-			glog.V(5).Infof("Verify agreement for %v with %v returned signature: %v", agreementId, counterPartyAddress, sigString)
-			if sigString == expectedSignature {
-				return true, nil
-			} else {
-				glog.V(3).Infof("CS Protocol returned signature %v does not match expected signature %v for %v", sigString, expectedSignature, agreementId)
-				return false, nil
-			}
+			glog.V(3).Infof("CS Protocol returned signature %v does not match expected signature %v for %v", sigString, expectedSignature, agreementId)
+			return false, nil
 		}
 	}
 
@@ -698,141 +692,107 @@ func (p *ProtocolHandler) VerifyAgreement(agreementId string,
 
 func (p *ProtocolHandler) RecordMeter(agreementId string, mn *metering.MeteringNotification) error {
 
-	if binaryAgreementId, err := hex.DecodeString(agreementId); err != nil {
+	if _, err := hex.DecodeString(agreementId); err != nil {
 		return errors.New(fmt.Sprintf("Error converting agreement ID %v to binary, error: %v", agreementId, err))
-	} else if p.EthMeterContract != nil {
-		glog.V(5).Infof("CS Protocol writing Metering Notification %v to the blockchain for %v.", *mn, agreementId)
-		params := make([]interface{}, 0, 10)
-		params = append(params, mn.Amount)
-		params = append(params, mn.CurrentTime)
-		params = append(params, binaryAgreementId)
-		params = append(params, mn.GetMeterHash()[2:])
-		params = append(params, mn.ConsumerMeterSignature)
-		params = append(params, mn.AgreementHash)
-		params = append(params, mn.ProducerSignature)
-		params = append(params, mn.ConsumerSignature)
-		params = append(params, mn.ConsumerAddress)
-		if _, err = p.EthMeterContract.Invoke_method("create_meter", params); err != nil {
-			return errors.New(fmt.Sprintf("Error invoking create_meter %v with %v, error: %v", agreementId, p, err))
-		}
+	} else if p.BlockchainReady() {
+		return p.Provider.RecordMeter(agreementId, mn)
 	} else {
-		glog.V(3).Infof(fmt.Sprintf("Protocol %v skipping blockchain metering record for %v, Metering Contract: %v, because blockchain is not up.", p.Name(), agreementId, p.EthMeterContract))
+		glog.V(3).Infof(fmt.Sprintf("Protocol %v skipping blockchain metering record for %v, because blockchain is not up.", p.Name(), agreementId))
 	}
 
 	return nil
 
 }
 
-// Functions that work with blockchain events
+// Functions that work with blockchain events. The raw event is demarshalled by the provider of the type of
+// blockchain that it came from.
 
-const AGREEMENT_CREATE = "0x0000000000000000000000000000000000000000000000000000000000000000"
-const AGREEMENT_DETAIL = "0x0000000000000000000000000000000000000000000000000000000000000001"
-const AGREEMENT_FRAUD = "0x0000000000000000000000000000000000000000000000000000000000000002"
-const AGREEMENT_CONSUMER_TERM = "0x0000000000000000000000000000000000000000000000000000000000000003"
-const AGREEMENT_PRODUCER_TERM = "0x0000000000000000000000000000000000000000000000000000000000000004"
-const AGREEMENT_FRAUD_TERM = "0x0000000000000000000000000000000000000000000000000000000000000005"
-const AGREEMENT_ADMIN_TERM = "0x0000000000000000000000000000000000000000000000000000000000000006"
-
-func (p *ProtocolHandler) DemarshalEvent(ev string) (*ethblockchain.Raw_Event, error) {
-	rawEvent := new(ethblockchain.Raw_Event)
-	if err := json.Unmarshal([]byte(ev), rawEvent); err != nil {
+func (p *ProtocolHandler) DemarshalEvent(bcType string, ev string) (*BlockchainEvent, error) {
+	if provider, err := NewBlockchainProvider(bcType, p.HTTPClient()); err != nil {
 		return nil, err
 	} else {
-		return rawEvent, nil
+		return provider.DemarshalEvent(ev)
 	}
 }
 
-func (p *ProtocolHandler) AgreementCreated(ev *ethblockchain.Raw_Event) bool {
-	return ev.Topics[0] == AGREEMENT_CREATE
+func (p *ProtocolHandler) AgreementCreated(ev *BlockchainEvent) bool {
+	return ev.Kind == BC_EVENT_AGREEMENT_CREATED
 }
 
-func (p *ProtocolHandler) ConsumerTermination(ev *ethblockchain.Raw_Event) bool {
-	return ev.Topics[0] == AGREEMENT_CONSUMER_TERM
+func (p *ProtocolHandler) ConsumerTermination(ev *BlockchainEvent) bool {
+	return ev.Kind == BC_EVENT_CONSUMER_TERMINATED
 }
 
-func (p *ProtocolHandler) ProducerTermination(ev *ethblockchain.Raw_Event) bool {
-	return ev.Topics[0] == AGREEMENT_PRODUCER_TERM
+func (p *ProtocolHandler) ProducerTermination(ev *BlockchainEvent) bool {
+	return ev.Kind == BC_EVENT_PRODUCER_TERMINATED
 }
 
-func (p *ProtocolHandler) GetAgreementId(ev *ethblockchain.Raw_Event) string {
-	return ev.Topics[3][2:]
+func (p *ProtocolHandler) GetAgreementId(ev *BlockchainEvent) string {
+	return ev.AgreementId
 }
 
-func (p *ProtocolHandler) GetReasonCode(ev *ethblockchain.Raw_Event) (uint64, error) {
-	return strconv.ParseUint(ev.Data[2:], 16, 64)
+func (p *ProtocolHandler) GetReasonCode(ev *BlockchainEvent) (uint64, error) {
+	return ev.Reason, nil
 }
 
-// constants indicating why an agreement is cancelled by the producer
+// constants indicating why an agreement is cancelled by the producer. The shared codes are defined by
+// abstractprotocol, they are repeated here so that the code of the protocol can use them as before.
 const CANCEL_NOT_FINALIZED_TIMEOUT = 100 // x64
-const CANCEL_POLICY_CHANGED = 101
+const CANCEL_POLICY_CHANGED = abstractprotocol.CANCEL_POLICY_CHANGED
 
 //const CANCEL_TORRENT_FAILURE = 102  it is subdivided into IMAGE code now
-const CANCEL_CONTAINER_FAILURE = 103
-const CANCEL_NOT_EXECUTED_TIMEOUT = 104
-const CANCEL_USER_REQUESTED = 105
-const CANCEL_AGBOT_REQUESTED = 106 // x6a
-const CANCEL_NO_REPLY_ACK = 107
-const CANCEL_MICROSERVICE_FAILURE = 108
-const CANCEL_WL_IMAGE_LOAD_FAILURE = 109
-const CANCEL_MS_IMAGE_LOAD_FAILURE = 110
-const CANCEL_MS_UPGRADE_REQUIRED = 111
-const CANCEL_IMAGE_DATA_ERROR = 112 // x70
-const CANCEL_IMAGE_FETCH_FAILURE = 113
-const CANCEL_IMAGE_FETCH_AUTH_FAILURE = 114
-const CANCEL_IMAGE_SIG_VERIF_FAILURE = 115
-const CANCEL_NODE_SHUTDOWN = 116 // x74
-const CANCEL_MS_IMAGE_FETCH_FAILURE = 117
-const CANCEL_MS_DOWNGRADE_REQUIRED = 118
+const CANCEL_CONTAINER_FAILURE = abstractprotocol.CANCEL_CONTAINER_FAILURE
+const CANCEL_NOT_EXECUTED_TIMEOUT = abstractprotocol.CANCEL_NOT_EXECUTED_TIMEOUT
+const CANCEL_USER_REQUESTED = abstractprotocol.CANCEL_USER_REQUESTED
+const CANCEL_AGBOT_REQUESTED = abstractprotocol.CANCEL_AGBOT_REQUESTED
+const CANCEL_NO_REPLY_ACK = abstractprotocol.CANCEL_NO_REPLY_ACK
+const CANCEL_MICROSERVICE_FAILURE = abstractprotocol.CANCEL_MICROSERVICE_FAILURE
+const CANCEL_WL_IMAGE_LOAD_FAILURE = abstractprotocol.CANCEL_WL_IMAGE_LOAD_FAILURE
+const CANCEL_MS_IMAGE_LOAD_FAILURE = abstractprotocol.CANCEL_MS_IMAGE_LOAD_FAILURE
+const CANCEL_MS_UPGRADE_REQUIRED = abstractprotocol.CANCEL_MS_UPGRADE_REQUIRED
+const CANCEL_IMAGE_DATA_ERROR = abstractprotocol.CANCEL_IMAGE_DATA_ERROR
+const CANCEL_IMAGE_FETCH_FAILURE = abstractprotocol.CANCEL_IMAGE_FETCH_FAILURE
+const CANCEL_IMAGE_FETCH_AUTH_FAILURE = abstractprotocol.CANCEL_IMAGE_FETCH_AUTH_FAILURE
+const CANCEL_IMAGE_SIG_VERIF_FAILURE = abstractprotocol.CANCEL_IMAGE_SIG_VERIF_FAILURE
+const CANCEL_NODE_SHUTDOWN = abstractprotocol.CANCEL_NODE_SHUTDOWN
+const CANCEL_MS_IMAGE_FETCH_FAILURE = abstractprotocol.CANCEL_MS_IMAGE_FETCH_FAILURE
+const CANCEL_MS_DOWNGRADE_REQUIRED = abstractprotocol.CANCEL_MS_DOWNGRADE_REQUIRED
+const CANCEL_NODE_DECOMMISSIONED = abstractprotocol.CANCEL_NODE_DECOMMISSIONED
+const CANCEL_WL_ROLLBACK = abstractprotocol.CANCEL_WL_ROLLBACK
+const CANCEL_USER_INPUT_CHANGED = abstractprotocol.CANCEL_USER_INPUT_CHANGED
 
 // These constants represent consumer cancellation reason codes
 const AB_CANCEL_NOT_FINALIZED_TIMEOUT = 200 // xc8
-const AB_CANCEL_NO_REPLY = 201
-const AB_CANCEL_NEGATIVE_REPLY = 202
-const AB_CANCEL_NO_DATA_RECEIVED = 203
-const AB_CANCEL_POLICY_CHANGED = 204
-const AB_CANCEL_DISCOVERED = 205 // xcd
-const AB_USER_REQUESTED = 206
-const AB_CANCEL_FORCED_UPGRADE = 207
+const AB_CANCEL_NO_REPLY = abstractprotocol.AB_CANCEL_NO_REPLY
+const AB_CANCEL_NEGATIVE_REPLY = abstractprotocol.AB_CANCEL_NEGATIVE_REPLY
+const AB_CANCEL_NO_DATA_RECEIVED = abstractprotocol.AB_CANCEL_NO_DATA_RECEIVED
+const AB_CANCEL_POLICY_CHANGED = abstractprotocol.AB_CANCEL_POLICY_CHANGED
+const AB_CANCEL_DISCOVERED = abstractprotocol.AB_CANCEL_DISCOVERED
+const AB_USER_REQUESTED = abstractprotocol.AB_USER_REQUESTED
+const AB_CANCEL_FORCED_UPGRADE = abstractprotocol.AB_CANCEL_FORCED_UPGRADE
+
+// The consumer codes of this protocol, other protocols use these codes for other reasons.
 const AB_CANCEL_BC_WRITE_FAILED = 208 // xd0
 const AB_CANCEL_NODE_HEARTBEAT = 209
 const AB_CANCEL_AG_MISSING = 210
+const AB_CANCEL_NODE_AGREEMENT_LIMIT = 211
+const AB_CANCEL_OUTSIDE_TIME_WINDOW = 212
+const AB_CANCEL_NODE_MISSING_DEVICES = 213
+const AB_CANCEL_NODE_WORKLOAD_ROLLBACK = 214
+
+// The termination reasons of the protocol.
+var Reasons = abstractprotocol.MustNewReasonRegistry(PROTOCOL_NAME, []abstractprotocol.TerminationReason{
+	{Code: CANCEL_NOT_FINALIZED_TIMEOUT, Party: abstractprotocol.REASON_PRODUCER, Name: "NotFinalized", Description: "agreement never appeared on the blockchain"},
+	{Code: AB_CANCEL_NOT_FINALIZED_TIMEOUT, Party: abstractprotocol.REASON_CONSUMER, Name: "NotFinalized", Description: "agreement bot never detected agreement on the blockchain"},
+	{Code: AB_CANCEL_BC_WRITE_FAILED, Party: abstractprotocol.REASON_CONSUMER, Name: "WriteFailed", Description: "agreement bot agreement write failed"},
+	{Code: AB_CANCEL_NODE_HEARTBEAT, Party: abstractprotocol.REASON_CONSUMER, Name: "NodeHeartbeat", Description: "agreement bot detected node heartbeat stopped"},
+	{Code: AB_CANCEL_AG_MISSING, Party: abstractprotocol.REASON_CONSUMER, Name: "AgreementMissing", Description: "agreement bot detected agreement missing from node"},
+	{Code: AB_CANCEL_NODE_AGREEMENT_LIMIT, Party: abstractprotocol.REASON_CONSUMER, Name: "NodeAgreementLimit", Description: "agreement bot received rejection, node reached its agreement limit"},
+	{Code: AB_CANCEL_OUTSIDE_TIME_WINDOW, Party: abstractprotocol.REASON_CONSUMER, Name: "OutsideTimeWindow", Description: "agreement bot detected time outside of the policy time windows"},
+	{Code: AB_CANCEL_NODE_MISSING_DEVICES, Party: abstractprotocol.REASON_CONSUMER, Name: "NodeMissingDevices", Description: "agreement bot received rejection, node is missing devices the workload needs"},
+	{Code: AB_CANCEL_NODE_WORKLOAD_ROLLBACK, Party: abstractprotocol.REASON_CONSUMER, Name: "NodeWorkloadRollback", Description: "agreement bot received rejection, node rolled back from the workload version"},
+})
 
 func DecodeReasonCode(code uint64) string {
-
-	codeMeanings := map[uint64]string{CANCEL_NOT_FINALIZED_TIMEOUT: "agreement never appeared on the blockchain",
-		CANCEL_POLICY_CHANGED: "producer policy changed",
-		// CANCEL_TORRENT_FAILURE:          "torrent failed to download",
-		CANCEL_CONTAINER_FAILURE:        "workload terminated",
-		CANCEL_NOT_EXECUTED_TIMEOUT:     "workload start timeout",
-		CANCEL_USER_REQUESTED:           "user requested",
-		CANCEL_AGBOT_REQUESTED:          "agbot requested",
-		CANCEL_NO_REPLY_ACK:             "agreement protocol incomplete, no reply ack received",
-		CANCEL_MICROSERVICE_FAILURE:     "microservice failed",
-		CANCEL_WL_IMAGE_LOAD_FAILURE:    "workload image loading failed",
-		CANCEL_MS_IMAGE_LOAD_FAILURE:    "microservice image loading failed",
-		CANCEL_MS_IMAGE_FETCH_FAILURE:   "microservice image fetching failed",
-		CANCEL_MS_UPGRADE_REQUIRED:      "required by microservice upgrade process",
-		CANCEL_MS_DOWNGRADE_REQUIRED:    "microservice failed, need downgrading to lower version",
-		CANCEL_IMAGE_DATA_ERROR:         "image data error",
-		CANCEL_IMAGE_FETCH_FAILURE:      "image fetching failed",
-		CANCEL_IMAGE_FETCH_AUTH_FAILURE: "authorization failed for image fetching",
-		CANCEL_IMAGE_SIG_VERIF_FAILURE:  "image signature verification failed",
-		CANCEL_NODE_SHUTDOWN:            "node was unconfigured",
-		AB_CANCEL_NOT_FINALIZED_TIMEOUT: "agreement bot never detected agreement on the blockchain",
-		AB_CANCEL_NO_REPLY:              "agreement bot never received reply to proposal",
-		AB_CANCEL_NEGATIVE_REPLY:        "agreement bot received negative reply",
-		AB_CANCEL_NO_DATA_RECEIVED:      "agreement bot did not detect data",
-		AB_CANCEL_POLICY_CHANGED:        "agreement bot policy changed",
-		AB_CANCEL_DISCOVERED:            "agreement bot discovered cancellation from producer",
-		AB_USER_REQUESTED:               "agreement bot user requested",
-		AB_CANCEL_FORCED_UPGRADE:        "agreement bot user requested workload upgrade",
-		AB_CANCEL_BC_WRITE_FAILED:       "agreement bot agreement write failed",
-		AB_CANCEL_NODE_HEARTBEAT:        "agreement bot detected node heartbeat stopped",
-		AB_CANCEL_AG_MISSING:            "agreement bot detected agreement missing from node"}
-
-	if reasonString, ok := codeMeanings[code]; !ok {
-		return "unknown reason code, device might be downlevel"
-	} else {
-		return reasonString
-	}
+	return Reasons.Description(code)
 }
@@ -3,7 +3,9 @@
 package citizenscientist
 
 import (
+	"fmt"
 	"testing"
+	"time"
 )
 
 // Validation tests
@@ -17,3 +19,27 @@ func Test_Proposal_validation(t *testing.T) {
 	}
 
 }
+
+func Test_Proposal_expiry(t *testing.T) {
+
+	ph := new(ProtocolHandler)
+	now := uint64(time.Now().Unix())
+
+	for _, c := range []struct {
+		expiry  string
+		expired bool
+	}{
+		{"", false},
+		{fmt.Sprintf(`,"expiry":%v`, now+60), false},
+		{fmt.Sprintf(`,"expiry":%v`, now-10), false}, // within the clock tolerance
+		{fmt.Sprintf(`,"expiry":%v`, now-600), true},
+	} {
+		proposal := `{"address":"123456","tsandcs":"abc","producerPolicy":"policy","consumerId":"ag12345","type":"proposal","protocol":"Citizen Scientist","version":1,"agreementId":"deadbeef"` + c.expiry + `}`
+		if p, err := ph.ValidateProposal(proposal); err != nil {
+			t.Errorf("Error validating %v, error %v", proposal, err)
+		} else if p.IsExpired() != c.expired {
+			t.Errorf("proposal %v should be expired %v", proposal, c.expired)
+		}
+	}
+
+}
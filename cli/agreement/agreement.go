@@ -1,10 +1,14 @@
 package agreement
 
 import (
-	"encoding/json"
 	"fmt"
+	"github.com/open-horizon/anax/abstractprotocol"
+	"github.com/open-horizon/anax/basicprotocol"
+	"github.com/open-horizon/anax/citizenscientist"
 	"github.com/open-horizon/anax/cli/cliutils"
 	"github.com/open-horizon/anax/persistence"
+	"strings"
+	"time"
 )
 
 type ActiveAgreement struct {
@@ -92,19 +96,111 @@ func getAgreements(archivedAgreements bool) (apiAgreements []persistence.Establi
 	return
 }
 
-func List(archivedAgreements bool, agreementId string) {
-	apiAgreements := getAgreements(archivedAgreements)
+// The phases of an agreement that the agreements can be filtered by. The state column of the watch table is more
+// detailed.
+const PHASE_NEGOTIATING = "negotiating"
+const PHASE_FINALIZED = "finalized"
+const PHASE_TERMINATING = "terminating"
+
+var phases = []string{PHASE_NEGOTIATING, PHASE_FINALIZED, PHASE_TERMINATING}
+
+// The filters of 'hzn agreement list'. The zero value matches all the agreements.
+type ListFilter struct {
+	WorkloadURL string        // the URL of the workload or service that the agreement runs
+	Phase       string        // one of the phases
+	NewerThan   time.Duration // the agreement was created less than this long ago
+	OlderThan   time.Duration // the agreement was created at least this long ago
+}
+
+// Create the filter from the flags of 'hzn agreement list'. The ages are durations like 90s, 15m or 2h.
+func NewListFilter(workloadURL string, phase string, newerThan string, olderThan string) *ListFilter {
+	f := &ListFilter{WorkloadURL: workloadURL}
+
+	if phase != "" {
+		for _, p := range phases {
+			if strings.EqualFold(phase, p) {
+				f.Phase = p
+			}
+		}
+		if f.Phase == "" {
+			cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "unknown agreement state %v, the states are: %v", phase, strings.Join(phases, ", "))
+		}
+	}
+
+	var err error
+	if newerThan != "" {
+		if f.NewerThan, err = time.ParseDuration(newerThan); err != nil || f.NewerThan <= 0 {
+			cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "--newer-than %v is not a duration like 15m or 2h", newerThan)
+		}
+	}
+	if olderThan != "" {
+		if f.OlderThan, err = time.ParseDuration(olderThan); err != nil || f.OlderThan <= 0 {
+			cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "--older-than %v is not a duration like 15m or 2h", olderThan)
+		}
+	}
+	return f
+}
+
+func (f *ListFilter) IsEmpty() bool {
+	return f == nil || (f.WorkloadURL == "" && f.Phase == "" && f.NewerThan == 0 && f.OlderThan == 0)
+}
+
+// The phase of an agreement: it is negotiating until it is finalized, and terminating once it is terminated. The
+// archived agreements are all terminating.
+func agreementPhase(ag persistence.EstablishedAgreement) string {
+	if ag.AgreementTerminatedTime != 0 {
+		return PHASE_TERMINATING
+	} else if ag.AgreementFinalizedTime != 0 {
+		return PHASE_FINALIZED
+	}
+	return PHASE_NEGOTIATING
+}
+
+func (f *ListFilter) Matches(ag persistence.EstablishedAgreement, now time.Time) bool {
+	if f.IsEmpty() {
+		return true
+	}
+
+	age := now.Sub(time.Unix(int64(ag.AgreementCreationTime), 0))
+	if f.WorkloadURL != "" && ag.RunningWorkload.URL != f.WorkloadURL {
+		return false
+	} else if f.Phase != "" && agreementPhase(ag) != f.Phase {
+		return false
+	} else if f.NewerThan != 0 && age >= f.NewerThan {
+		return false
+	} else if f.OlderThan != 0 && age < f.OlderThan {
+		return false
+	}
+	return true
+}
+
+func (f *ListFilter) Filter(apiAgreements []persistence.EstablishedAgreement) []persistence.EstablishedAgreement {
+	if f.IsEmpty() {
+		return apiAgreements
+	}
+	now := time.Now()
+	filtered := make([]persistence.EstablishedAgreement, 0, len(apiAgreements))
+	for _, ag := range apiAgreements {
+		if f.Matches(ag, now) {
+			filtered = append(filtered, ag)
+		}
+	}
+	return filtered
+}
+
+func List(archivedAgreements bool, agreementId string, filter *ListFilter) {
+	if agreementId != "" && !filter.IsEmpty() {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "the filters can not be used with an agreement id")
+	}
+
+	apiAgreements := filter.Filter(getAgreements(archivedAgreements))
 
 	if agreementId != "" {
 		// Look for our agreement id. This works for either active or archived
 		for i := range apiAgreements {
 			if agreementId == apiAgreements[i].CurrentAgreementId {
 				// Found it
-				jsonBytes, err := json.MarshalIndent(apiAgreements[i], "", cliutils.JSON_INDENT)
-				if err != nil {
-					cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to marshal agreement with index %d: %v", i, err)
-				}
-				fmt.Printf("%s\n", jsonBytes)
+				cliutils.PrintOutput("hzn agreement list AGREEMENT", apiAgreements[i])
 				return
 			}
 		}
@@ -117,23 +213,76 @@ func List(archivedAgreements bool, agreementId string) {
 			for i := range apiAgreements {
 				agreements[i].CopyAgreementInto(apiAgreements[i])
 			}
-			jsonBytes, err := json.MarshalIndent(agreements, "", cliutils.JSON_INDENT)
-			if err != nil {
-				cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to marshal 'hzn agreement list' output: %v", err)
-			}
-			fmt.Printf("%s\n", jsonBytes)
+			cliutils.PrintOutput("hzn agreement list", agreements)
 		} else {
 			// Archived agreements
 			agreements := make([]ArchivedAgreement, len(apiAgreements))
 			for i := range apiAgreements {
 				agreements[i].CopyAgreementInto(apiAgreements[i])
 			}
-			jsonBytes, err := json.MarshalIndent(agreements, "", cliutils.JSON_INDENT)
-			if err != nil {
-				cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to marshal 'hzn agreement list' output: %v", err)
-			}
-			fmt.Printf("%s\n", jsonBytes)
+			cliutils.PrintOutput("hzn agreement list --archived", agreements)
+		}
+	}
+}
+
+// The state of an active agreement, from the latest of its milestones.
+func agreementState(ag persistence.EstablishedAgreement) (string, uint64) {
+	switch {
+	case ag.AgreementTerminatedTime != 0:
+		return "terminating", ag.AgreementTerminatedTime
+	case ag.AgreementDataReceivedTime != 0:
+		return "data received", ag.AgreementDataReceivedTime
+	case ag.AgreementExecutionStartTime != 0:
+		return "executing", ag.AgreementExecutionStartTime
+	case ag.AgreementFinalizedTime != 0:
+		return "finalized", ag.AgreementFinalizedTime
+	case ag.AgreementAcceptedTime != 0:
+		return "accepted", ag.AgreementAcceptedTime
+	default:
+		return "proposed", ag.AgreementCreationTime
+	}
+}
+
+func agreementTable(apiAgreements []persistence.EstablishedAgreement, archivedAgreements bool) ([]string, [][]string) {
+	rows := make([][]string, 0, len(apiAgreements))
+	if !archivedAgreements {
+		header := []string{"AGREEMENT", "WORKLOAD", "VERSION", "AGBOT", "PROTOCOL", "STATE", "SINCE"}
+		for _, ag := range apiAgreements {
+			state, since := agreementState(ag)
+			rows = append(rows, []string{ag.CurrentAgreementId, cliutils.WatchCell(ag.RunningWorkload.URL), cliutils.WatchCell(ag.RunningWorkload.Version), cliutils.WatchCell(ag.ConsumerId), cliutils.WatchCell(ag.AgreementProtocol), state, cliutils.WatchTime(since)})
 		}
+		return header, rows
+	}
+
+	header := []string{"AGREEMENT", "WORKLOAD", "VERSION", "AGBOT", "TERMINATED", "REASON"}
+	for _, ag := range apiAgreements {
+		rows = append(rows, []string{ag.CurrentAgreementId, cliutils.WatchCell(ag.RunningWorkload.URL), cliutils.WatchCell(ag.RunningWorkload.Version), cliutils.WatchCell(ag.ConsumerId), cliutils.WatchTime(ag.AgreementTerminatedTime), cliutils.WatchCell(ag.TerminatedDescription)})
+	}
+	return header, rows
+}
+
+// Display a table of the active or archived agreements that is updated as the agreements change, instead of running
+// 'hzn agreement list' in a loop. With diff, the changes to the agreements are printed as they happen instead, which
+// keeps the history of a registration that is being debugged.
+func Watch(archivedAgreements bool, agreementId string, intervalS int, filter *ListFilter, diff bool) {
+	if agreementId != "" {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "--watch lists all the agreements, it can not be used with an agreement id")
+	}
+
+	title := "hzn agreement list"
+	if archivedAgreements {
+		title += " --archived"
+	}
+	header, _ := agreementTable(nil, archivedAgreements)
+	getRows := func() [][]string {
+		_, rows := agreementTable(filter.Filter(getAgreements(archivedAgreements)), archivedAgreements)
+		return rows
+	}
+
+	if diff {
+		cliutils.WatchChanges(title, intervalS, header, getRows)
+	} else {
+		cliutils.Watch(title, intervalS, header, getRows)
 	}
 }
 
@@ -161,3 +310,26 @@ func Cancel(agreementId string, allAgreements bool) {
 		cliutils.HorizonDelete("agreement/"+id, []int{200, 204})
 	}
 }
+
+// The termination reasons of an agreement protocol.
+type ProtocolReasons struct {
+	Protocol string                               `json:"protocol"`
+	Reasons  []abstractprotocol.TerminationReason `json:"reasons"`
+}
+
+// List the reason codes that an agreement can be terminated with, so that the terminated_reason of an archived
+// agreement can be looked up. The reasons are built into hzn, the Horizon agent does not have to be running.
+func Reasons(protocol string) {
+	output := []ProtocolReasons{}
+	names := []string{}
+	for _, registry := range []*abstractprotocol.ReasonRegistry{basicprotocol.Reasons, citizenscientist.Reasons} {
+		names = append(names, registry.Protocol())
+		if protocol == "" || strings.EqualFold(protocol, registry.Protocol()) {
+			output = append(output, ProtocolReasons{Protocol: registry.Protocol(), Reasons: registry.Reasons()})
+		}
+	}
+	if len(output) == 0 {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "unknown agreement protocol %v, the protocols are: %v", protocol, strings.Join(names, ", "))
+	}
+	cliutils.PrintOutput("hzn agreement reasons", output)
+}
@@ -0,0 +1,56 @@
+// +build unit
+
+package agreement
+
+import (
+	"github.com/open-horizon/anax/persistence"
+	"testing"
+	"time"
+)
+
+func Test_ListFilter(t *testing.T) {
+
+	now := time.Now()
+	ago := func(d time.Duration) uint64 { return uint64(now.Add(-d).Unix()) }
+
+	negotiating := persistence.EstablishedAgreement{CurrentAgreementId: "a1", AgreementCreationTime: ago(time.Minute), RunningWorkload: persistence.WorkloadInfo{URL: "https://bluehorizon.network/workloads/netspeed"}}
+	finalized := persistence.EstablishedAgreement{CurrentAgreementId: "a2", AgreementCreationTime: ago(3 * time.Hour), AgreementFinalizedTime: ago(2 * time.Hour), RunningWorkload: persistence.WorkloadInfo{URL: "https://bluehorizon.network/workloads/gps"}}
+	terminating := persistence.EstablishedAgreement{CurrentAgreementId: "a3", AgreementCreationTime: ago(2 * time.Hour), AgreementFinalizedTime: ago(time.Hour), AgreementTerminatedTime: ago(time.Second)}
+	agreements := []persistence.EstablishedAgreement{negotiating, finalized, terminating}
+
+	ids := func(ags []persistence.EstablishedAgreement) string {
+		s := ""
+		for _, ag := range ags {
+			s += ag.CurrentAgreementId
+		}
+		return s
+	}
+
+	tests := []struct {
+		filter   *ListFilter
+		expected string
+	}{
+		{nil, "a1a2a3"},
+		{&ListFilter{}, "a1a2a3"},
+		{&ListFilter{WorkloadURL: "https://bluehorizon.network/workloads/gps"}, "a2"},
+		{&ListFilter{Phase: PHASE_NEGOTIATING}, "a1"},
+		{&ListFilter{Phase: PHASE_FINALIZED}, "a2"},
+		{&ListFilter{Phase: PHASE_TERMINATING}, "a3"},
+		{&ListFilter{NewerThan: time.Hour}, "a1"},
+		{&ListFilter{OlderThan: time.Hour}, "a2a3"},
+		{&ListFilter{OlderThan: time.Hour, NewerThan: 150 * time.Minute}, "a3"},
+		{&ListFilter{Phase: PHASE_FINALIZED, WorkloadURL: "https://bluehorizon.network/workloads/netspeed"}, ""},
+	}
+
+	for _, test := range tests {
+		if filtered := test.filter.Filter(agreements); ids(filtered) != test.expected {
+			t.Errorf("filter %v should match %v, matched %v", test.filter, test.expected, ids(filtered))
+		}
+	}
+
+	if f := NewListFilter("", "Finalized", "2h", ""); f.Phase != PHASE_FINALIZED || f.NewerThan != 2*time.Hour || f.IsEmpty() {
+		t.Errorf("wrong filter from the flags: %v", f)
+	} else if !NewListFilter("", "", "", "").IsEmpty() {
+		t.Errorf("the filter without flags should be empty")
+	}
+}
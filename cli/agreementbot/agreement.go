@@ -1,8 +1,8 @@
 package agreementbot
 
 import (
-	"encoding/json"
 	"fmt"
+	"github.com/open-horizon/anax/abstractprotocol"
 	agbot "github.com/open-horizon/anax/agreementbot"
 	"github.com/open-horizon/anax/cli/cliutils"
 	"os"
@@ -43,10 +43,11 @@ func NewActiveAgreement(agreement agbot.Agreement) *ActiveAgreement {
 }
 
 type ArchivedAgreement struct {
-	ActiveAgreement              // inheritance
-	AgreementTimedout     string `json:"agreement_timeout"`      // agreement was not finalized before it timed out
-	TerminatedReason      uint   `json:"terminated_reason"`      // The reason the agreement was terminated
-	TerminatedDescription string `json:"terminated_description"` // The description of why the agreement was terminated
+	ActiveAgreement                                             // inheritance
+	AgreementTimedout     string                                `json:"agreement_timeout"`              // agreement was not finalized before it timed out
+	TerminatedReason      uint                                  `json:"terminated_reason"`              // The reason the agreement was terminated
+	TerminatedDescription string                                `json:"terminated_description"`         // The description of why the agreement was terminated
+	DecisionExplanation   *abstractprotocol.DecisionExplanation `json:"decision_explanation,omitempty"` // Why the edge node declined the proposal, when it says
 
 }
 
@@ -58,6 +59,7 @@ func NewArchivedAgreement(agreement agbot.Agreement) *ArchivedAgreement {
 		cliutils.ConvertTime(agreement.AgreementTimedout),
 		agreement.TerminatedReason,
 		agreement.TerminatedDescription,
+		agreement.DecisionExplanation,
 	}
 
 	return &a
@@ -65,7 +67,7 @@ func NewArchivedAgreement(agreement agbot.Agreement) *ArchivedAgreement {
 
 func getAgreements(archivedAgreements bool) (apiAgreements []agbot.Agreement) {
 	// set env to call agbot url
-	os.Setenv("HORIZON_URL", cliutils.AGBOT_HZN_API)
+	os.Setenv("HORIZON_URL", cliutils.GetAgbotUrlBase())
 
 	// Get horizon api agreement output and drill down to the category we want
 	apiOutput := make(map[string]map[string][]agbot.Agreement, 0)
@@ -94,21 +96,13 @@ func AgreementList(archivedAgreements bool, agreement string) {
 		for i := range apiAgreements {
 			agreements[i] = *NewActiveAgreement(apiAgreements[i])
 		}
-		jsonBytes, err := json.MarshalIndent(agreements, "", cliutils.JSON_INDENT)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to marshal 'agreement list' output: %v", err)
-		}
-		fmt.Printf("%s\n", jsonBytes)
+		cliutils.PrintOutput("hzn agbot agreement list", agreements)
 	} else {
 		agreements := make([]ArchivedAgreement, len(apiAgreements))
 		for i := range apiAgreements {
 			agreements[i] = *NewArchivedAgreement(apiAgreements[i])
 		}
-		jsonBytes, err := json.MarshalIndent(agreements, "", cliutils.JSON_INDENT)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to marshal 'agreement list' output: %v", err)
-		}
-		fmt.Printf("%s\n", jsonBytes)
+		cliutils.PrintOutput("hzn agbot agreement list --archived", agreements)
 	}
 }
 
@@ -131,7 +125,7 @@ func AgreementCancel(agreementId string, allAgreements bool) {
 	}
 
 	// Cancel the agreements
-	os.Setenv("HORIZON_URL", cliutils.AGBOT_HZN_API)
+	os.Setenv("HORIZON_URL", cliutils.GetAgbotUrlBase())
 	for _, id := range agrIds {
 		fmt.Printf("Canceling agreement %s ...\n", id)
 		cliutils.HorizonDelete("agreement/"+id, []int{200, 204})
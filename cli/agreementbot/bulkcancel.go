@@ -0,0 +1,160 @@
+package agreementbot
+
+import (
+	"encoding/json"
+	"fmt"
+	agbot "github.com/open-horizon/anax/agreementbot"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"net/http"
+	"os"
+)
+
+type BulkCancelAgreement struct {
+	AgreementId   string `json:"agreement_id"`
+	Protocol      string `json:"protocol"`
+	EdgeNodeId    string `json:"edge_node_id"`
+	CancelledTime string `json:"cancelled_time,omitempty"`
+	Skipped       string `json:"skipped,omitempty"`
+}
+
+type BulkCancelJob struct {
+	Name           string                 `json:"name"`
+	Filter         agbot.BulkCancelFilter `json:"filter"`
+	BatchSize      int                    `json:"batch_size"`
+	BatchIntervalS int                    `json:"batch_interval_s"`
+	State          string                 `json:"state"`
+	PlannedTime    string                 `json:"planned_time"`
+	StartedTime    string                 `json:"started_time"`
+	LastBatchTime  string                 `json:"last_batch_time"`
+	FinishedTime   string                 `json:"finished_time"`
+	Cancelled      int                    `json:"cancelled"`
+	Skipped        int                    `json:"skipped"`
+	Remaining      int                    `json:"remaining"`
+	Agreements     []BulkCancelAgreement  `json:"agreements,omitempty"`
+}
+
+// create a BulkCancelJob object, the agreements are only included when asked for
+func NewBulkCancelJob(job agbot.BulkCancelJob, withAgreements bool) *BulkCancelJob {
+	j := BulkCancelJob{
+		Name:           job.Name,
+		Filter:         job.Filter,
+		BatchSize:      job.BatchSize,
+		BatchIntervalS: job.BatchIntervalS,
+		State:          job.State,
+		PlannedTime:    cliutils.ConvertTime(job.PlannedTime),
+		StartedTime:    cliutils.ConvertTime(job.StartedTime),
+		LastBatchTime:  cliutils.ConvertTime(job.LastBatchTime),
+		FinishedTime:   cliutils.ConvertTime(job.FinishedTime),
+	}
+
+	for _, a := range job.Agreements {
+		if a.CancelledTime != 0 {
+			j.Cancelled += 1
+		} else if a.Skipped != "" {
+			j.Skipped += 1
+		} else {
+			j.Remaining += 1
+		}
+		if withAgreements {
+			j.Agreements = append(j.Agreements, BulkCancelAgreement{
+				AgreementId:   a.AgreementId,
+				Protocol:      a.Protocol,
+				EdgeNodeId:    a.DeviceId,
+				CancelledTime: cliutils.ConvertTime(a.CancelledTime),
+				Skipped:       a.Skipped,
+			})
+		}
+	}
+
+	return &j
+}
+
+func printBulkCancelJob(job interface{}, cmd string) {
+	jsonBytes, err := json.MarshalIndent(job, "", cliutils.JSON_INDENT)
+	if err != nil {
+		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to marshal 'bulkcancel %v' output: %v", cmd, err)
+	}
+	fmt.Printf("%s\n", jsonBytes)
+}
+
+func getBulkCancelJob(name string) *agbot.BulkCancelJob {
+	var job agbot.BulkCancelJob
+	if httpCode := cliutils.HorizonGet("bulkcancel/"+name, []int{200, 400}, &job); httpCode == 400 {
+		cliutils.Fatal(cliutils.NOT_FOUND, "bulk cancel job '%v' not found", name)
+	}
+	return &job
+}
+
+// Plan a bulk cancel job and display the agreements it would cancel. Nothing is cancelled until the job is started.
+func BulkCancelPlan(name string, org string, pattern string, workloadURL string, workloadVersion string, nodes []string, batchSize int, batchIntervalS int) {
+	// set env to call agbot url
+	os.Setenv("HORIZON_URL", cliutils.GetAgbotUrlBase())
+
+	plan := agbot.BulkCancelPlan{
+		Filter: agbot.BulkCancelFilter{
+			Org:             org,
+			Pattern:         pattern,
+			WorkloadURL:     workloadURL,
+			WorkloadVersion: workloadVersion,
+			Nodes:           nodes,
+		},
+		BatchSize:      batchSize,
+		BatchIntervalS: batchIntervalS,
+	}
+	if err := plan.Filter.IsValid(); err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "invalid filter: %v", err)
+	}
+
+	if httpCode := cliutils.HorizonPutPost(http.MethodPost, "bulkcancel/"+name, []int{201, 409}, plan); httpCode == 409 {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "bulk cancel job '%v' already exists, remove it or use a different name", name)
+	}
+
+	job := getBulkCancelJob(name)
+	printBulkCancelJob(NewBulkCancelJob(*job, true), "plan")
+	fmt.Printf("Review the %v agreements above, then run 'hzn agbot bulkcancel start %v' to cancel them.\n", len(job.Agreements), name)
+}
+
+func BulkCancelList(name string, withAgreements bool) {
+	// set env to call agbot url
+	os.Setenv("HORIZON_URL", cliutils.GetAgbotUrlBase())
+
+	if name != "" {
+		printBulkCancelJob(NewBulkCancelJob(*getBulkCancelJob(name), withAgreements), "list")
+		return
+	}
+
+	apiJobs := make([]agbot.BulkCancelJob, 0)
+	cliutils.HorizonGet("bulkcancel", []int{200}, &apiJobs)
+	jobs := make([]BulkCancelJob, len(apiJobs))
+	for i := range apiJobs {
+		jobs[i] = *NewBulkCancelJob(apiJobs[i], withAgreements)
+	}
+	printBulkCancelJob(jobs, "list")
+}
+
+// Start or abort a bulk cancel job.
+func BulkCancelAction(name string, action string) {
+	// set env to call agbot url
+	os.Setenv("HORIZON_URL", cliutils.GetAgbotUrlBase())
+
+	done := map[string]string{"start": "started", "abort": "aborted"}[action]
+	if httpCode := cliutils.HorizonPutPost(http.MethodPost, fmt.Sprintf("bulkcancel/%v/%v", name, action), []int{200, 400, 409}, nil); httpCode == 400 {
+		cliutils.Fatal(cliutils.NOT_FOUND, "bulk cancel job '%v' not found", name)
+	} else if httpCode == 409 {
+		job := getBulkCancelJob(name)
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "bulk cancel job '%v' can not be %v, it is %v", name, done, job.State)
+	}
+	fmt.Printf("Bulk cancel job '%v' %v.\n", name, done)
+}
+
+func BulkCancelRemove(name string) {
+	// set env to call agbot url
+	os.Setenv("HORIZON_URL", cliutils.GetAgbotUrlBase())
+
+	if httpCode := cliutils.HorizonDelete("bulkcancel/"+name, []int{204, 400, 409}); httpCode == 400 {
+		cliutils.Fatal(cliutils.NOT_FOUND, "bulk cancel job '%v' not found", name)
+	} else if httpCode == 409 {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "bulk cancel job '%v' is running, abort it before removing it", name)
+	}
+	fmt.Printf("Bulk cancel job '%v' removed.\n", name)
+}
@@ -0,0 +1,34 @@
+package agreementbot
+
+import (
+	"fmt"
+	"github.com/open-horizon/anax/agreementbot"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"os"
+)
+
+// Diagnose runs the agbot self-diagnostic and displays the findings. Exits with an error if any check failed.
+func Diagnose(problemsOnly bool) {
+	// set env to call agbot url
+	os.Setenv("HORIZON_URL", cliutils.GetAgbotUrlBase())
+
+	findings := []agreementbot.DiagnosticFinding{}
+	cliutils.HorizonGet("diagnostic", []int{200}, &findings)
+
+	output := []agreementbot.DiagnosticFinding{}
+	failed := 0
+	for _, f := range findings {
+		if f.Status == agreementbot.DIAG_ERROR {
+			failed += 1
+		}
+		if !problemsOnly || f.Status != agreementbot.DIAG_OK {
+			output = append(output, f)
+		}
+	}
+
+	fmt.Println(cliutils.MarshalIndent(output, "agbot diagnose"))
+
+	if failed != 0 {
+		cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, "%v of the agbot diagnostic checks failed", failed)
+	}
+}
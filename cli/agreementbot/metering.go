@@ -0,0 +1,76 @@
+package agreementbot
+
+import (
+	"fmt"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// MeterExport writes the metering records sent by the agbot, or their totals by org and pattern, for reconciliation
+// with a billing system. The times are RFC3339 times, seconds since 1970, or durations like 24h meaning that long ago.
+func MeterExport(since string, until string, org string, pattern string, format string, aggregate bool, window string, file string) {
+	// set env to call agbot url
+	os.Setenv("HORIZON_URL", cliutils.GetAgbotUrlBase())
+
+	if format != "json" && format != "csv" {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "--format must be json or csv")
+	}
+
+	query := url.Values{}
+	query.Set("since", strconv.FormatInt(parseMeterTime("--since", since), 10))
+	if until != "" {
+		query.Set("until", strconv.FormatInt(parseMeterTime("--until", until), 10))
+	}
+	if org != "" {
+		query.Set("org", org)
+	}
+	if pattern != "" {
+		query.Set("pattern", pattern)
+	}
+	query.Set("format", format)
+	if aggregate {
+		query.Set("aggregate", "true")
+		if window != "" {
+			if w, err := time.ParseDuration(window); err != nil || w < time.Second {
+				cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "--window %v is not a duration like 1h or 24h", window)
+			} else {
+				query.Set("window", strconv.FormatInt(int64(w/time.Second), 10))
+			}
+		}
+	} else if window != "" {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "--window can only be used with --aggregate")
+	}
+
+	body, err := cliutils.HorizonGetBody("metering?" + query.Encode())
+	if err != nil {
+		cliutils.Fatal(cliutils.HTTP_ERROR, err.Error())
+	}
+
+	if file == "" {
+		fmt.Print(string(body))
+		if format == "json" {
+			fmt.Println()
+		}
+	} else if err := ioutil.WriteFile(file, body, 0644); err != nil {
+		cliutils.Fatal(cliutils.FILE_IO_ERROR, "unable to write the metering records to %v: %v", file, err)
+	} else {
+		fmt.Printf("Metering records written to %v.\n", file)
+	}
+}
+
+// Returns the seconds since 1970 of an RFC3339 time, a number of seconds since 1970, or a duration ago.
+func parseMeterTime(flag string, value string) int64 {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.Unix()
+	} else if s, err := strconv.ParseInt(value, 10, 64); err == nil && s >= 0 {
+		return s
+	} else if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return time.Now().Add(-d).Unix()
+	}
+	cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "%v %v is not an RFC3339 time, a number of seconds since 1970, or a duration like 24h", flag, value)
+	return 0
+}
@@ -37,7 +37,7 @@ func (n *AgbotAndStatus) CopyStatusInto(status *apicommon.Info) {
 
 func List() {
 	// set env to call agbot url
-	os.Setenv("HORIZON_URL", cliutils.AGBOT_HZN_API)
+	os.Setenv("HORIZON_URL", cliutils.GetAgbotUrlBase())
 
 	// Get the agbot info
 	horDevice := agreementbot.HorizonAgbot{}
@@ -11,7 +11,7 @@ import (
 // get the policy names that the agbot hosts
 func getPolicyNames(org string) (map[string][]string, int) {
 	// set env to call agbot url
-	os.Setenv("HORIZON_URL", cliutils.AGBOT_HZN_API)
+	os.Setenv("HORIZON_URL", cliutils.GetAgbotUrlBase())
 
 	// Get horizon api policy output
 	apiOutput := make(map[string][]string, 0)
@@ -31,7 +31,7 @@ func getPolicyNames(org string) (map[string][]string, int) {
 // get the policy with the given name for the given org
 func getPolicy(org string, name string) (*policy.Policy, int) {
 	// set env to call agbot url
-	os.Setenv("HORIZON_URL", cliutils.AGBOT_HZN_API)
+	os.Setenv("HORIZON_URL", cliutils.GetAgbotUrlBase())
 
 	// Get horizon api policy output
 	var apiOutput policy.Policy
@@ -0,0 +1,49 @@
+package agreementbot
+
+import (
+	"fmt"
+	agbot "github.com/open-horizon/anax/agreementbot"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"net/http"
+	"os"
+)
+
+// QuotaList displays the quotas of the orgs served by the agbot, or of one org, with their usage.
+func QuotaList(org string) {
+	// set env to call agbot url
+	os.Setenv("HORIZON_URL", cliutils.GetAgbotUrlBase())
+
+	if org != "" {
+		var quota agbot.OrgQuotaStatus
+		cliutils.HorizonGet("quota/"+org, []int{200}, &quota)
+		fmt.Println(cliutils.MarshalIndent(quota, "agbot quota list"))
+		return
+	}
+
+	quotas := make([]agbot.OrgQuotaStatus, 0)
+	cliutils.HorizonGet("quota", []int{200}, &quotas)
+	fmt.Println(cliutils.MarshalIndent(quotas, "agbot quota list"))
+}
+
+// QuotaSet replaces the quota of an org until it is removed, the agbot config is not changed.
+func QuotaSet(org string, maxAgreements int, maxProposalsPerMin int, maxServedPatterns int) {
+	// set env to call agbot url
+	os.Setenv("HORIZON_URL", cliutils.GetAgbotUrlBase())
+
+	if maxAgreements < 0 || maxProposalsPerMin < 0 || maxServedPatterns < 0 {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "quotas can not be negative")
+	}
+
+	quota := agbot.OrgQuota{MaxAgreements: maxAgreements, MaxProposalsPerMin: maxProposalsPerMin, MaxServedPatterns: maxServedPatterns}
+	cliutils.HorizonPutPost(http.MethodPut, "quota/"+org, []int{200}, quota)
+	fmt.Printf("Quota of org '%v' set.\n", org)
+}
+
+// QuotaRemove removes the quota set with QuotaSet, the org goes back to the quota in the agbot config.
+func QuotaRemove(org string) {
+	// set env to call agbot url
+	os.Setenv("HORIZON_URL", cliutils.GetAgbotUrlBase())
+
+	cliutils.HorizonDelete("quota/"+org, []int{200})
+	fmt.Printf("Quota of org '%v' removed, the configured quota is used.\n", org)
+}
@@ -0,0 +1,35 @@
+package agreementbot
+
+import (
+	"fmt"
+	"github.com/open-horizon/anax/agreementbot"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Simulate displays the agreements the agbot would propose to the node for the pattern, or why it would not propose
+// them. Exits with an error if no agreement would be proposed.
+func Simulate(node string, pattern string) {
+	// set env to call agbot url
+	os.Setenv("HORIZON_URL", cliutils.GetAgbotUrlBase())
+
+	if len(strings.Split(node, "/")) != 2 {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "the node must be specified as org/id")
+	} else if len(strings.Split(pattern, "/")) != 2 {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "the pattern must be specified as org/name")
+	}
+
+	sims := []agreementbot.AgreementSimulation{}
+	cliutils.HorizonGet(fmt.Sprintf("simulate?node=%v&pattern=%v", url.QueryEscape(node), url.QueryEscape(pattern)), []int{200}, &sims)
+
+	fmt.Println(cliutils.MarshalIndent(sims, "agbot simulate"))
+
+	for _, sim := range sims {
+		if sim.Proposal != nil {
+			return
+		}
+	}
+	cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, "the agbot would not propose an agreement to node %v for pattern %v", node, pattern)
+}
@@ -1,8 +1,6 @@
 package attribute
 
 import (
-	"encoding/json"
-	"fmt"
 	"github.com/open-horizon/anax/api"
 	"github.com/open-horizon/anax/cli/cliutils"
 )
@@ -41,9 +39,5 @@ func List() {
 	}
 
 	// Convert to json and output
-	jsonBytes, err := json.MarshalIndent(attrs, "", cliutils.JSON_INDENT)
-	if err != nil {
-		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to marshal 'hzn attribute list' output: %v", err)
-	}
-	fmt.Printf("%s\n", jsonBytes)
+	cliutils.PrintOutput("hzn attribute list", attrs)
 }
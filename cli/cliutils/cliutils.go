@@ -2,11 +2,14 @@ package cliutils
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	dockerclient "github.com/fsouza/go-dockerclient"
+	"github.com/open-horizon/anax/anaxerrors"
 	"github.com/open-horizon/anax/apicommon"
 	"github.com/open-horizon/anax/exchange"
 	"io"
@@ -33,21 +36,28 @@ const (
 	FILE_IO_ERROR      = 4
 	HTTP_ERROR         = 5
 	//EXEC_CMD_ERROR = 6
-	CLI_GENERAL_ERROR = 7
-	NOT_FOUND         = 8
-	SIGNATURE_INVALID = 9
-	INTERNAL_ERROR    = 99
+	CLI_GENERAL_ERROR   = 7
+	NOT_FOUND           = 8
+	SIGNATURE_INVALID   = 9
+	EXCHANGE_AUTH_ERROR = 10
+	DRAIN_TIMEOUT       = 11 // the node did not drain in time during 'hzn unregister --drain', its local state was not removed
+	INTERNAL_ERROR      = 99
 
 	// Anax API HTTP Codes
 	ANAX_ALREADY_CONFIGURED = 409
 	ANAX_NOT_CONFIGURED_YET = 424
+
+	// Exchange retry defaults, can be overridden with HZN_EXCHANGE_MAX_RETRIES and HZN_EXCHANGE_RETRY_INTERVAL_MS
+	DEFAULT_EXCHANGE_MAX_RETRIES       = 3
+	DEFAULT_EXCHANGE_RETRY_INTERVAL_MS = 1000
 )
 
 // Holds the cmd line flags that were set so other pkgs can access
 type GlobalOptions struct {
-	Verbose     *bool
-	IsDryRun    *bool
-	UsingApiKey bool // should go away soon
+	Verbose       *bool
+	IsDryRun      *bool
+	OutputVersion *int // the version of the json output of the list commands, 0 for the current version
+	UsingApiKey   bool // should go away soon
 }
 
 var Opts GlobalOptions
@@ -76,6 +86,38 @@ func Fatal(exitCode int, msg string, args ...interface{}) {
 	os.Exit(exitCode)
 }
 
+// FatalError prints the message and exits with the exit code for the category of err.
+func FatalError(err error, msg string, args ...interface{}) {
+	Fatal(ExitCode(err), msg, args...)
+}
+
+// ExitCode returns the exit code for the category of err, so that the same kind of error always exits the same way.
+func ExitCode(err error) int {
+	switch anaxerrors.CategoryOf(err) {
+	case anaxerrors.EXCHANGE_AUTH:
+		return EXCHANGE_AUTH_ERROR
+	case anaxerrors.EXCHANGE_NOT_FOUND:
+		return NOT_FOUND
+	case anaxerrors.NETWORK:
+		return HTTP_ERROR
+	case anaxerrors.VALIDATION:
+		return CLI_INPUT_ERROR
+	case anaxerrors.SIGNATURE:
+		return SIGNATURE_INVALID
+	case anaxerrors.PERSISTENCE:
+		return FILE_IO_ERROR
+	}
+	if anaxerrors.HTTPStatus(err) != 0 {
+		return HTTP_ERROR
+	}
+	return CLI_GENERAL_ERROR
+}
+
+// HTTPExitCode returns the exit code for an unexpected HTTP status code from the exchange or the anax api.
+func HTTPExitCode(httpCode int) int {
+	return ExitCode(anaxerrors.NewHTTPError(httpCode, fmt.Sprintf("HTTP code %d", httpCode)))
+}
+
 func Warning(msg string, args ...interface{}) {
 	if !strings.HasSuffix(msg, "\n") {
 		msg += "\n"
@@ -164,42 +206,10 @@ func GetDockerAuth(domain string) (auth dockerclient.AuthConfiguration, err erro
 	return
 }
 
-// PushDockerImage pushes the image to its docker registry, outputting progress to stdout. It returns the repo digest. If there is an error, it prints the error and exits.
+// PushDockerImage pushes the image to its docker registry with the 'docker login' credentials, outputting progress to stdout. It returns the repo digest. If there is an error, it prints the error and exits.
 // We don't have to handle the case of a digest in the image name, because in that case we assume the image has already been pushed (that is the way to get the digest).
 func PushDockerImage(client *dockerclient.Client, domain, path, tag string) (digest string) {
-	var repository string // for PushImageOptions later on
-	if domain == "" {
-		repository = path
-	} else {
-		repository = domain + "/" + path
-	}
-	fmt.Printf("Pushing %v:%v...\n", repository, tag) // Note: tag can be the empty string
-
-	// Get the docker client object for this registry, and set the push options and creds
-	var buf bytes.Buffer
-	multiWriter := io.MultiWriter(os.Stdout, &buf)                                               // we want output of the push to go 2 places: stdout (for the user to see progess) and a variable (so we can get the digest value)
-	opts := dockerclient.PushImageOptions{Name: repository, Tag: tag, OutputStream: multiWriter} // do not set InactivityTimeout because the user will ctrl-c if they think something is wrong
-
-	var auth dockerclient.AuthConfiguration
-	var err error
-	if auth, err = GetDockerAuth(domain); err != nil {
-		Fatal(CLI_INPUT_ERROR, "could not get docker credentials from ~/.docker/config.json: %v. Maybe you need to run 'docker login ...' to provide credentials for the image registry.", err)
-	}
-
-	// Now actually push the image
-	if err = client.PushImage(opts, auth); err != nil {
-		Fatal(CLI_GENERAL_ERROR, "unable to push docker image %v: %v", repository+":"+tag, err)
-	}
-
-	// Get the digest value that docker calculated when pushing the image
-	//fmt.Printf("DEBUG: docker push output is: %s\n", buf.String())
-	reDigest := regexp.MustCompile(`\s+digest:\s+(\S+)\s+size:`)
-	var matches []string
-	if matches = reDigest.FindStringSubmatch(buf.String()); len(matches) < 2 {
-		Fatal(CLI_GENERAL_ERROR, "could not find the image digest in the docker push output")
-	}
-	digest = matches[1]
-	return
+	return PushDockerImageToRegistries(client, domain, path, tag, nil)
 }
 
 // OrgAndCreds prepends the org to creds (separated by /) unless creds already has an org prepended
@@ -359,6 +369,72 @@ func GetHorizonUrlBase() string {
 	return HZN_API
 }
 
+// GetAgbotUrlBase returns the base part of the agbot api url (which can be overridden by env var HZN_AGBOT_URL)
+func GetAgbotUrlBase() string {
+	envVar := os.Getenv("HZN_AGBOT_URL")
+	if envVar != "" {
+		return envVar
+	}
+	return AGBOT_HZN_API
+}
+
+// horizonHTTPClient returns the client for the anax api. When the api is served over https with a self-signed cert,
+// HZN_API_CA_CERT is the file that holds the CA cert (see 'hzn util cert fetch'). When the api requires clients that
+// are not on the node to authenticate, HZN_API_CLIENT_CERT and HZN_API_CLIENT_KEY are the client cert and its key,
+// or HZN_API_TOKEN is a token the api accepts.
+func horizonHTTPClient() *http.Client {
+	caFile := os.Getenv("HZN_API_CA_CERT")
+	certFile, keyFile := os.Getenv("HZN_API_CLIENT_CERT"), os.Getenv("HZN_API_CLIENT_KEY")
+	token := os.Getenv("HZN_API_TOKEN")
+	if caFile == "" && certFile == "" && token == "" {
+		return &http.Client{}
+	}
+
+	tlsConfig := &tls.Config{}
+	if caFile != "" {
+		caBytes, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			Fatal(FILE_IO_ERROR, "failed to read HZN_API_CA_CERT file %s: %v", caFile, err)
+		}
+		caPool := x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(caBytes) {
+			Fatal(CLI_INPUT_ERROR, "HZN_API_CA_CERT file %s does not contain a PEM certificate", caFile)
+		}
+		tlsConfig.RootCAs = caPool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			Fatal(CLI_INPUT_ERROR, "failed to load HZN_API_CLIENT_CERT %s and HZN_API_CLIENT_KEY %s: %v", certFile, keyFile, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	var transport http.RoundTripper = &http.Transport{TLSClientConfig: tlsConfig}
+	if token != "" {
+		transport = &tokenTransport{base: transport, token: token}
+	}
+	return &http.Client{Transport: transport}
+}
+
+// tokenTransport adds the HZN_API_TOKEN to the requests to the anax api.
+type tokenTransport struct {
+	base  http.RoundTripper
+	token string
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not change the request it is given.
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Authorization", "Bearer "+t.token)
+	return t.base.RoundTrip(r)
+}
+
 // GetRespBodyAsString converts an http response body to a string
 func GetRespBodyAsString(responseBody io.ReadCloser) string {
 	buf := new(bytes.Buffer)
@@ -393,7 +469,7 @@ func HorizonGet(urlSuffix string, goodHttpCodes []int, structure interface{}) (h
 	url := GetHorizonUrlBase() + "/" + urlSuffix
 	apiMsg := http.MethodGet + " " + url
 	Verbose(apiMsg)
-	resp, err := http.Get(url)
+	resp, err := horizonHTTPClient().Get(url)
 	if err != nil {
 		printHorizonRestError(apiMsg, err)
 	}
@@ -401,7 +477,7 @@ func HorizonGet(urlSuffix string, goodHttpCodes []int, structure interface{}) (h
 	httpCode = resp.StatusCode
 	Verbose("HTTP code: %d", httpCode)
 	if !isGoodCode(httpCode, goodHttpCodes) {
-		Fatal(HTTP_ERROR, "bad HTTP code from %s: %d", apiMsg, httpCode)
+		Fatal(HTTPExitCode(httpCode), "bad HTTP code from %s: %d", apiMsg, httpCode)
 	}
 	if httpCode == goodHttpCodes[0] {
 		bodyBytes, err := ioutil.ReadAll(resp.Body)
@@ -423,6 +499,27 @@ func HorizonGet(urlSuffix string, goodHttpCodes []int, structure interface{}) (h
 	return
 }
 
+// HorizonGetBody runs a GET on the anax api and returns the response body. Unlike HorizonGet it does not exit when the
+// api can not be reached or returns an error, so that callers can carry on collecting what is available.
+func HorizonGetBody(urlSuffix string) ([]byte, error) {
+	url := GetHorizonUrlBase() + "/" + urlSuffix
+	apiMsg := http.MethodGet + " " + url
+	Verbose(apiMsg)
+	resp, err := horizonHTTPClient().Get(url)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("can't connect to the Horizon REST API to run %s: %v", apiMsg, err))
+	}
+	defer resp.Body.Close()
+	Verbose("HTTP code: %d", resp.StatusCode)
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to read body response from %s: %v", apiMsg, err))
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("bad HTTP code from %s: %d, response: %s", apiMsg, resp.StatusCode, string(bodyBytes)))
+	}
+	return bodyBytes, nil
+}
+
 // HorizonDelete runs a DELETE on the anax api.
 // If the list of goodHttpCodes is not empty and none match the actual http code, it will exit with an error. Otherwise the actual code is returned.
 func HorizonDelete(urlSuffix string, goodHttpCodes []int) (httpCode int) {
@@ -432,7 +529,7 @@ func HorizonDelete(urlSuffix string, goodHttpCodes []int) (httpCode int) {
 	if IsDryRun() {
 		return 204
 	}
-	httpClient := &http.Client{}
+	httpClient := horizonHTTPClient()
 	req, err := http.NewRequest(http.MethodDelete, url, nil)
 	if err != nil {
 		Fatal(HTTP_ERROR, "%s new request failed: %v", apiMsg, err)
@@ -445,7 +542,7 @@ func HorizonDelete(urlSuffix string, goodHttpCodes []int) (httpCode int) {
 	httpCode = resp.StatusCode
 	Verbose("HTTP code: %d", httpCode)
 	if !isGoodCode(httpCode, goodHttpCodes) {
-		Fatal(HTTP_ERROR, "bad HTTP code %d from %s: %s", httpCode, apiMsg, GetRespBodyAsString(resp.Body))
+		Fatal(HTTPExitCode(httpCode), "bad HTTP code %d from %s: %s", httpCode, apiMsg, GetRespBodyAsString(resp.Body))
 	}
 	return
 }
@@ -459,7 +556,7 @@ func HorizonPutPost(method string, urlSuffix string, goodHttpCodes []int, body i
 	if IsDryRun() {
 		return 201
 	}
-	httpClient := &http.Client{}
+	httpClient := horizonHTTPClient()
 
 	// Prepare body
 	var jsonBytes []byte
@@ -503,7 +600,7 @@ func HorizonPutPost(method string, urlSuffix string, goodHttpCodes []int, body i
 	httpCode = resp.StatusCode
 	Verbose("HTTP code: %d", httpCode)
 	if !isGoodCode(httpCode, goodHttpCodes) {
-		Fatal(HTTP_ERROR, "bad HTTP code %d from %s: %s", httpCode, apiMsg, GetRespBodyAsString(resp.Body))
+		Fatal(HTTPExitCode(httpCode), "bad HTTP code %d from %s: %s", httpCode, apiMsg, GetRespBodyAsString(resp.Body))
 	}
 	return
 }
@@ -540,18 +637,17 @@ func ExchangeGet(urlBase string, urlSuffix string, credentials string, goodHttpC
 	apiMsg := http.MethodGet + " " + url
 	Verbose(apiMsg)
 	httpClient := &http.Client{}
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		Fatal(HTTP_ERROR, "%s new request failed: %v", apiMsg, err)
-	}
-	req.Header.Add("Accept", "application/json")
-	if credentials != "" {
-		req.Header.Add("Authorization", fmt.Sprintf("Basic %v", base64.StdEncoding.EncodeToString([]byte(credentials))))
-	}
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		printHorizonExchRestError(apiMsg, err)
-	}
+	resp := invokeExchangeWithRetry(httpClient, http.MethodGet, apiMsg, func() *http.Request {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			Fatal(HTTP_ERROR, "%s new request failed: %v", apiMsg, err)
+		}
+		req.Header.Add("Accept", "application/json")
+		if credentials != "" {
+			req.Header.Add("Authorization", fmt.Sprintf("Basic %v", base64.StdEncoding.EncodeToString([]byte(credentials))))
+		}
+		return req
+	})
 	defer resp.Body.Close()
 	bodyBytes, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
@@ -560,7 +656,7 @@ func ExchangeGet(urlBase string, urlSuffix string, credentials string, goodHttpC
 	httpCode = resp.StatusCode
 	Verbose("HTTP code: %d", httpCode)
 	if !isGoodCode(httpCode, goodHttpCodes) {
-		Fatal(HTTP_ERROR, "bad HTTP code %d from %s, output: %s", httpCode, apiMsg, string(bodyBytes))
+		Fatal(HTTPExitCode(httpCode), "bad HTTP code %d from %s, output: %s", httpCode, apiMsg, string(bodyBytes))
 	}
 
 	if len(bodyBytes) > 0 && structure != nil { // the DP front-end of exchange will return nothing when auth problem
@@ -620,26 +716,24 @@ func ExchangePutPost(method string, urlBase string, urlSuffix string, credential
 			Fatal(JSON_PARSING_ERROR, "failed to marshal exchange body for %s: %v", apiMsg, err)
 		}
 	}
-	requestBody := bytes.NewBuffer(jsonBytes)
 
 	// Create the request and run it
-	req, err := http.NewRequest(method, url, requestBody)
-	if err != nil {
-		Fatal(HTTP_ERROR, "%s new request failed: %v", apiMsg, err)
-	}
-	req.Header.Add("Accept", "application/json")
-	if bodyIsBytes {
-		req.Header.Add("Content-Length", strconv.Itoa(len(jsonBytes)))
-	} else {
-		req.Header.Add("Content-Type", "application/json")
-	}
-	if credentials != "" {
-		req.Header.Add("Authorization", fmt.Sprintf("Basic %v", base64.StdEncoding.EncodeToString([]byte(credentials))))
-	} // else it is an anonymous call
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		printHorizonExchRestError(apiMsg, err)
-	}
+	resp := invokeExchangeWithRetry(httpClient, method, apiMsg, func() *http.Request {
+		req, err := http.NewRequest(method, url, bytes.NewBuffer(jsonBytes))
+		if err != nil {
+			Fatal(HTTP_ERROR, "%s new request failed: %v", apiMsg, err)
+		}
+		req.Header.Add("Accept", "application/json")
+		if bodyIsBytes {
+			req.Header.Add("Content-Length", strconv.Itoa(len(jsonBytes)))
+		} else {
+			req.Header.Add("Content-Type", "application/json")
+		}
+		if credentials != "" {
+			req.Header.Add("Authorization", fmt.Sprintf("Basic %v", base64.StdEncoding.EncodeToString([]byte(credentials))))
+		} // else it is an anonymous call
+		return req
+	})
 	defer resp.Body.Close()
 	httpCode = resp.StatusCode
 	Verbose("HTTP code: %d", httpCode)
@@ -651,9 +745,9 @@ func ExchangePutPost(method string, urlBase string, urlSuffix string, credential
 		respMsg := exchange.PostDeviceResponse{}
 		err = json.Unmarshal(bodyBytes, &respMsg)
 		if err != nil {
-			Fatal(HTTP_ERROR, "bad HTTP code %d from %s: %s", httpCode, apiMsg, string(bodyBytes))
+			Fatal(HTTPExitCode(httpCode), "bad HTTP code %d from %s: %s", httpCode, apiMsg, string(bodyBytes))
 		}
-		Fatal(HTTP_ERROR, "bad HTTP code %d from %s: %s, %s", httpCode, apiMsg, respMsg.Code, respMsg.Msg)
+		Fatal(HTTPExitCode(httpCode), "bad HTTP code %d from %s: %s, %s", httpCode, apiMsg, respMsg.Code, respMsg.Msg)
 	}
 	return
 }
@@ -668,24 +762,74 @@ func ExchangeDelete(urlBase string, urlSuffix string, credentials string, goodHt
 		return 204
 	}
 	httpClient := &http.Client{}
-	req, err := http.NewRequest(http.MethodDelete, url, nil)
-	if err != nil {
-		Fatal(HTTP_ERROR, "%s new request failed: %v", apiMsg, err)
-	}
-	req.Header.Add("Authorization", fmt.Sprintf("Basic %v", base64.StdEncoding.EncodeToString([]byte(credentials))))
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		printHorizonExchRestError(apiMsg, err)
-	}
+	resp := invokeExchangeWithRetry(httpClient, http.MethodDelete, apiMsg, func() *http.Request {
+		req, err := http.NewRequest(http.MethodDelete, url, nil)
+		if err != nil {
+			Fatal(HTTP_ERROR, "%s new request failed: %v", apiMsg, err)
+		}
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %v", base64.StdEncoding.EncodeToString([]byte(credentials))))
+		return req
+	})
 	// delete never returns a body
 	httpCode = resp.StatusCode
 	Verbose("HTTP code: %d", httpCode)
 	if !isGoodCode(httpCode, goodHttpCodes) {
-		Fatal(HTTP_ERROR, "bad HTTP code %d from %s", httpCode, apiMsg)
+		Fatal(HTTPExitCode(httpCode), "bad HTTP code %d from %s", httpCode, apiMsg)
 	}
 	return
 }
 
+// ExchangeRetryPolicy returns the policy for retrying transient exchange errors, configured by the HZN_EXCHANGE_MAX_RETRIES
+// and HZN_EXCHANGE_RETRY_INTERVAL_MS env vars.
+func ExchangeRetryPolicy() *exchange.RetryPolicy {
+	maxRetries := DEFAULT_EXCHANGE_MAX_RETRIES
+	if env := os.Getenv("HZN_EXCHANGE_MAX_RETRIES"); env != "" {
+		if n, err := strconv.Atoi(env); err != nil {
+			Fatal(CLI_INPUT_ERROR, "environment variable HZN_EXCHANGE_MAX_RETRIES must be an integer, was %v", env)
+		} else {
+			maxRetries = n
+		}
+	}
+	intervalMS := DEFAULT_EXCHANGE_RETRY_INTERVAL_MS
+	if env := os.Getenv("HZN_EXCHANGE_RETRY_INTERVAL_MS"); env != "" {
+		if n, err := strconv.Atoi(env); err != nil {
+			Fatal(CLI_INPUT_ERROR, "environment variable HZN_EXCHANGE_RETRY_INTERVAL_MS must be an integer, was %v", env)
+		} else {
+			intervalMS = n
+		}
+	}
+	return exchange.NewRetryPolicy(maxRetries, time.Duration(intervalMS)*time.Millisecond)
+}
+
+// invokeExchangeWithRetry runs the request returned by newReq, retrying transient errors according to the exchange retry
+// policy. The request is created again for every attempt because its body can only be read once.
+func invokeExchangeWithRetry(httpClient *http.Client, method string, apiMsg string, newReq func() *http.Request) *http.Response {
+	policy := ExchangeRetryPolicy()
+	for retry := 1; ; retry++ {
+		resp, err := httpClient.Do(newReq())
+		httpCode := 0
+		if err == nil {
+			httpCode = resp.StatusCode
+		}
+		if !policy.Retryable(retry, method, httpCode, err) {
+			if err != nil {
+				printHorizonExchRestError(apiMsg, err)
+			}
+			return resp
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		wait := policy.Backoff(retry)
+		if err != nil {
+			Verbose("%s failed: %v. Retry %d of %d in %v", apiMsg, err, retry, policy.MaxRetries, wait)
+		} else {
+			Verbose("%s failed with HTTP code %d. Retry %d of %d in %v", apiMsg, httpCode, retry, policy.MaxRetries, wait)
+		}
+		time.Sleep(wait)
+	}
+}
+
 func ConvertTime(unixSeconds uint64) string {
 	if unixSeconds == 0 {
 		return ""
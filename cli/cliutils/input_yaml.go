@@ -0,0 +1,306 @@
+package cliutils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The hzn input files can be written in yaml. Only the block style subset of yaml that people write by hand is
+// supported: maps, lists, comments, and plain, single quoted and double quoted scalars. Flow style is only supported
+// for lists of scalars and for empty maps and lists. Anchors, tags, multi-line strings and multiple documents are not
+// supported. The parsed document is converted the same way json is, so the json field names of v are used.
+
+// Unmarshal a yaml document into v.
+func UnmarshalYAML(data []byte, v interface{}) error {
+	generic, err := parseYAML(data)
+	if err != nil {
+		return err
+	}
+	if jsonBytes, err := json.Marshal(generic); err != nil {
+		return err
+	} else {
+		return json.Unmarshal(jsonBytes, v)
+	}
+}
+
+// Returns true when the document looks like json rather than yaml.
+func IsJSONDocument(data []byte) bool {
+	trimmed := strings.TrimSpace(string(data))
+	return strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")
+}
+
+type yamlLine struct {
+	num     int // line number in the document, for error messages
+	indent  int
+	content string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func parseYAML(data []byte) (interface{}, error) {
+	p := &yamlParser{lines: make([]yamlLine, 0, 10)}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(yamlStripComment(line), " \t\r")
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || trimmed == "---" {
+			continue
+		} else if strings.HasPrefix(trimmed, "\t") {
+			return nil, errors.New(fmt.Sprintf("line %v: tabs are not allowed for indentation", i+1))
+		}
+		p.lines = append(p.lines, yamlLine{num: i + 1, indent: len(line) - len(trimmed), content: trimmed})
+	}
+
+	if len(p.lines) == 0 {
+		return map[string]interface{}{}, nil
+	}
+	v, err := p.parseBlock(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	} else if p.pos < len(p.lines) {
+		return nil, errors.New(fmt.Sprintf("line %v: unexpected indentation", p.lines[p.pos].num))
+	}
+	return v, nil
+}
+
+// Remove a comment from the end of a line. A # starts a comment at the beginning of the line or after a space, when
+// it is not in a quoted string. A quote only starts a string at the beginning of a scalar, so that an apostrophe in
+// a plain scalar is not taken for one.
+func yamlStripComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++ // skip the escaped character
+		case quote == '\'' && c == '\'' && i+1 < len(line) && line[i+1] == '\'':
+			i++ // '' is an escaped quote
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" \t[,", line[i-1]) >= 0):
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func yamlIsListItem(content string) bool {
+	return content == "-" || strings.HasPrefix(content, "- ")
+}
+
+// Parse the map or list that starts at the current line, whose lines have the indent.
+func (p *yamlParser) parseBlock(indent int) (interface{}, error) {
+	if yamlIsListItem(p.lines[p.pos].content) {
+		return p.parseList(indent)
+	}
+	return p.parseMap(indent)
+}
+
+func (p *yamlParser) parseList(indent int) (interface{}, error) {
+	list := make([]interface{}, 0, 5)
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && yamlIsListItem(p.lines[p.pos].content) {
+		line := p.lines[p.pos]
+		item := strings.TrimLeft(strings.TrimPrefix(line.content, "-"), " ")
+
+		if item == "" {
+			// The item is the block on the following lines.
+			p.pos++
+			if v, err := p.parseNested(indent, false); err != nil {
+				return nil, err
+			} else {
+				list = append(list, v)
+			}
+		} else if _, _, isKey := yamlSplitKey(item); isKey {
+			// The item is a map whose first key is on the same line as the dash, the rest of its keys are indented
+			// to line up with the first one.
+			itemIndent := indent + len(line.content) - len(item)
+			p.lines[p.pos] = yamlLine{num: line.num, indent: itemIndent, content: item}
+			if v, err := p.parseMap(itemIndent); err != nil {
+				return nil, err
+			} else {
+				list = append(list, v)
+			}
+		} else {
+			p.pos++
+			if v, err := yamlScalarValue(item, line.num); err != nil {
+				return nil, err
+			} else {
+				list = append(list, v)
+			}
+		}
+	}
+	return list, nil
+}
+
+func (p *yamlParser) parseMap(indent int) (interface{}, error) {
+	m := make(map[string]interface{})
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && !yamlIsListItem(p.lines[p.pos].content) {
+		line := p.lines[p.pos]
+		key, value, isKey := yamlSplitKey(line.content)
+		if !isKey {
+			return nil, errors.New(fmt.Sprintf("line %v: expected 'key: value', found %v", line.num, line.content))
+		}
+		if k, err := yamlScalarValue(key, line.num); err != nil {
+			return nil, err
+		} else {
+			key = fmt.Sprintf("%v", k)
+		}
+		if _, ok := m[key]; ok {
+			return nil, errors.New(fmt.Sprintf("line %v: duplicate key %v", line.num, key))
+		}
+
+		p.pos++
+		if value == "" {
+			// The value is the block on the following lines. A list can have the same indent as its key.
+			if v, err := p.parseNested(indent, true); err != nil {
+				return nil, err
+			} else {
+				m[key] = v
+			}
+		} else if v, err := yamlScalarValue(value, line.num); err != nil {
+			return nil, err
+		} else {
+			m[key] = v
+		}
+	}
+	return m, nil
+}
+
+// Parse the block that is the value of a key or list item with the indent. Returns nil when there is no block.
+func (p *yamlParser) parseNested(indent int, sameIndentList bool) (interface{}, error) {
+	if p.pos >= len(p.lines) {
+		return nil, nil
+	}
+	next := p.lines[p.pos]
+	if next.indent > indent {
+		return p.parseBlock(next.indent)
+	} else if sameIndentList && next.indent == indent && yamlIsListItem(next.content) {
+		return p.parseList(indent)
+	}
+	return nil, nil
+}
+
+// Split "key: value" into the key and the value. The key can be quoted.
+func yamlSplitKey(content string) (string, string, bool) {
+	end := 0
+	if strings.HasPrefix(content, "\"") || strings.HasPrefix(content, "'") {
+		// Find the closing quote, the colon must follow it.
+		quote := content[0]
+		end = 1
+		for end < len(content) && content[end] != quote {
+			if content[end] == '\\' && quote == '"' {
+				end++
+			}
+			end++
+		}
+		end++
+		if end >= len(content) || content[end] != ':' {
+			return "", "", false
+		}
+	} else if i := strings.Index(content, ": "); i >= 0 {
+		end = i
+	} else if strings.HasSuffix(content, ":") {
+		end = len(content) - 1
+	} else {
+		return "", "", false
+	}
+	if end+1 < len(content) && content[end+1] != ' ' {
+		return "", "", false
+	}
+	return strings.TrimSpace(content[:end]), strings.TrimSpace(content[end+1:]), true
+}
+
+var yamlInt = regexp.MustCompile(`^[-+]?[0-9]+$`)
+var yamlFloat = regexp.MustCompile(`^[-+]?([0-9]+\.[0-9]*|\.[0-9]+)([eE][-+]?[0-9]+)?$|^[-+]?[0-9]+[eE][-+]?[0-9]+$`)
+
+// Convert a scalar to the value it represents.
+func yamlScalarValue(s string, lineNum int) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(s, "\""):
+		if v, err := strconv.Unquote(s); err != nil {
+			return nil, errors.New(fmt.Sprintf("line %v: invalid double quoted string %v", lineNum, s))
+		} else {
+			return v, nil
+		}
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, errors.New(fmt.Sprintf("line %v: invalid single quoted string %v", lineNum, s))
+		}
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	case s == "{}":
+		return map[string]interface{}{}, nil
+	case strings.HasPrefix(s, "["):
+		return yamlFlowList(s, lineNum)
+	case strings.HasPrefix(s, "{"), strings.HasPrefix(s, "|"), strings.HasPrefix(s, ">"), strings.HasPrefix(s, "&"), strings.HasPrefix(s, "*"), strings.HasPrefix(s, "!"):
+		return nil, errors.New(fmt.Sprintf("line %v: unsupported yaml syntax %v", lineNum, s))
+	case s == "~" || s == "null" || s == "Null" || s == "NULL":
+		return nil, nil
+	case s == "true" || s == "True" || s == "TRUE":
+		return true, nil
+	case s == "false" || s == "False" || s == "FALSE":
+		return false, nil
+	case yamlInt.MatchString(s):
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i, nil
+		}
+	case yamlFloat.MatchString(s):
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f, nil
+		}
+	}
+	return s, nil
+}
+
+// Parse a flow style list of scalars, like [a, "b", 3].
+func yamlFlowList(s string, lineNum int) (interface{}, error) {
+	if !strings.HasSuffix(s, "]") {
+		return nil, errors.New(fmt.Sprintf("line %v: unterminated list %v", lineNum, s))
+	}
+	list := make([]interface{}, 0, 5)
+	inner := strings.TrimSpace(s[1 : len(s)-1])
+	if inner == "" {
+		return list, nil
+	}
+
+	// Split on the commas that are not in quoted strings.
+	items := make([]string, 0, 5)
+	quote, start := byte(0), 0
+	for i := 0; i < len(inner); i++ {
+		c := inner[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			return nil, errors.New(fmt.Sprintf("line %v: nested flow collections are not supported: %v", lineNum, s))
+		case c == ',':
+			items = append(items, inner[start:i])
+			start = i + 1
+		}
+	}
+	items = append(items, inner[start:])
+
+	for _, item := range items {
+		if v, err := yamlScalarValue(strings.TrimSpace(item), lineNum); err != nil {
+			return nil, err
+		} else {
+			list = append(list, v)
+		}
+	}
+	return list, nil
+}
@@ -0,0 +1,136 @@
+// +build unit
+
+package cliutils
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func Test_UnmarshalYAML(t *testing.T) {
+
+	type decl struct {
+		Org        string                   `json:"org"`
+		Node       map[string]string        `json:"node"`
+		Properties map[string]interface{}   `json:"properties"`
+		Global     []map[string]interface{} `json:"global"`
+		Tags       []interface{}            `json:"tags"`
+		Empty      map[string]interface{}   `json:"empty"`
+	}
+
+	doc := `# registration
+org: myorg   # trailing comment
+node:
+  id: "node 1"
+  token: 'it''s #secret'
+properties:
+  gpu: false
+  cores: 4
+  ratio: 0.5
+  url: http://example.com/a#b
+global:
+- type: LocationAttributes
+  variables:
+    lat: 43.1
+    use_gps: false
+- type: Other
+tags: [a, "b, c", 3]
+empty: {}
+`
+
+	var d decl
+	if err := UnmarshalYAML([]byte(doc), &d); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if d.Org != "myorg" {
+		t.Errorf("org should be myorg, was %v", d.Org)
+	} else if d.Node["id"] != "node 1" || d.Node["token"] != "it's #secret" {
+		t.Errorf("quoted strings not parsed correctly: %v", d.Node)
+	} else if d.Properties["gpu"] != false || d.Properties["cores"] != float64(4) || d.Properties["ratio"] != 0.5 {
+		t.Errorf("scalars not parsed correctly: %v", d.Properties)
+	} else if d.Properties["url"] != "http://example.com/a#b" {
+		t.Errorf("# in a plain scalar should not start a comment: %v", d.Properties["url"])
+	} else if len(d.Global) != 2 || d.Global[1]["type"] != "Other" {
+		t.Errorf("list of maps not parsed correctly: %v", d.Global)
+	} else if vars, ok := d.Global[0]["variables"].(map[string]interface{}); !ok || vars["lat"] != 43.1 {
+		t.Errorf("nested map in list not parsed correctly: %v", d.Global[0])
+	} else if !reflect.DeepEqual(d.Tags, []interface{}{"a", "b, c", float64(3)}) {
+		t.Errorf("flow list not parsed correctly: %v", d.Tags)
+	} else if d.Empty == nil || len(d.Empty) != 0 {
+		t.Errorf("empty map not parsed correctly: %v", d.Empty)
+	}
+}
+
+func Test_UnmarshalYAML_errors(t *testing.T) {
+
+	docs := map[string]string{
+		"bad indentation":  "a: 1\n  b: 2\n",
+		"not a key":        "a: 1\njust text\n",
+		"duplicate key":    "a: 1\na: 2\n",
+		"tab indentation":  "a:\n\t b: 1\n",
+		"multi-line":       "a: |\n  text\n",
+		"unterminated":     "a: \"text\n",
+		"nested flow list": "a: [[1]]\n",
+	}
+
+	for name, doc := range docs {
+		var v map[string]interface{}
+		if err := UnmarshalYAML([]byte(doc), &v); err == nil {
+			t.Errorf("%v: expected an error, got %v", name, v)
+		}
+	}
+}
+
+// A document written by MarshalYAML can be read back.
+func Test_UnmarshalYAML_roundtrip(t *testing.T) {
+
+	v := map[string]interface{}{
+		"label":   "GPS: with a colon",
+		"public":  true,
+		"version": "1.0",
+		"yes":     "yes",
+		"none":    nil,
+		"list":    []interface{}{map[string]interface{}{"a": "x", "b": []interface{}{}}, "y"},
+	}
+
+	yamlBytes, err := MarshalYAML(v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var back map[string]interface{}
+	if err := UnmarshalYAML(yamlBytes, &back); err != nil {
+		t.Fatalf("unexpected error reading %v: %v", string(yamlBytes), err)
+	}
+
+	expected, _ := json.Marshal(v)
+	actual, _ := json.Marshal(back)
+	if string(expected) != string(actual) {
+		t.Errorf("expected %v, got %v from\n%v", string(expected), string(actual), string(yamlBytes))
+	}
+}
+
+func Test_IsJSONDocument(t *testing.T) {
+	if !IsJSONDocument([]byte("  \n{\"a\": 1}")) {
+		t.Errorf("json object should be detected")
+	} else if IsJSONDocument([]byte("a: 1")) {
+		t.Errorf("yaml should not be detected as json")
+	}
+}
+
+// The sample registration file can be read.
+func Test_UnmarshalYAML_sample(t *testing.T) {
+
+	var v map[string]interface{}
+	if err := UnmarshalYAML(ReadFile("../samples/node.yaml"), &v); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if v["org"] != "IBM" || v["pattern"] != "netspeed" {
+		t.Errorf("org and pattern not read from the sample: %v", v)
+	} else if node, ok := v["node"].(map[string]interface{}); !ok || node["name"] != "My edge node" {
+		t.Errorf("node not read from the sample: %v", v["node"])
+	} else if workloads, ok := v["workloads"].([]interface{}); !ok || len(workloads) != 1 {
+		t.Errorf("workloads not read from the sample: %v", v["workloads"])
+	}
+}
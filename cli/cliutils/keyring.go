@@ -0,0 +1,103 @@
+package cliutils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// The exchange credentials that 'hzn login' caches in the OS keyring (libsecret on Linux, the Keychain on macOS, the
+// Credential Manager on Windows), so they do not have to be kept in environment variables or plaintext files.
+const (
+	KEYRING_SERVICE = "open-horizon-hzn"
+	KEYRING_ACCOUNT = "exchange"
+	KEYRING_LABEL   = "Horizon Exchange credentials"
+)
+
+// Returned by the OS specific keyring functions when there is nothing stored for the service and account.
+var ErrKeyringNotFound = errors.New("no Horizon Exchange credentials in the OS keyring")
+
+type CachedCredentials struct {
+	Org    string `json:"org"`     // the default org for the exchange sub-commands
+	UserPw string `json:"user_pw"` // the user credentials, in the form org/user:pw
+}
+
+// SaveCachedCredentials stores the credentials in the OS keyring, replacing any that are already there. The secret is
+// base64 encoded so that it never needs to be quoted for the OS keyring tools.
+func SaveCachedCredentials(creds CachedCredentials) error {
+	jsonBytes, err := json.Marshal(creds)
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed to marshal the credentials: %v", err))
+	}
+	return keyringSet(KEYRING_SERVICE, KEYRING_ACCOUNT, base64.StdEncoding.EncodeToString(jsonBytes))
+}
+
+// GetCachedCredentials returns the credentials stored by 'hzn login', or ErrKeyringNotFound if there are none.
+func GetCachedCredentials() (*CachedCredentials, error) {
+	secret, err := keyringGet(KEYRING_SERVICE, KEYRING_ACCOUNT)
+	if err != nil {
+		return nil, err
+	}
+	jsonBytes, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to decode the credentials in the OS keyring: %v", err))
+	}
+	creds := new(CachedCredentials)
+	if err := json.Unmarshal(jsonBytes, creds); err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to unmarshal the credentials in the OS keyring: %v", err))
+	}
+	return creds, nil
+}
+
+// DeleteCachedCredentials removes the credentials stored by 'hzn login', or returns ErrKeyringNotFound if there are none.
+func DeleteCachedCredentials() error {
+	return keyringDelete(KEYRING_SERVICE, KEYRING_ACCOUNT)
+}
+
+// The keyring is only read when a value is not in a flag or an env var, and then only once per invocation of hzn.
+var cachedCredentials *CachedCredentials
+var cachedCredentialsRead bool
+
+func loginCredentials() *CachedCredentials {
+	if !cachedCredentialsRead {
+		cachedCredentialsRead = true
+		var err error
+		if cachedCredentials, err = GetCachedCredentials(); err != nil && err != ErrKeyringNotFound {
+			Verbose("unable to read the credentials cached by 'hzn login': %v", err)
+		}
+	}
+	return cachedCredentials
+}
+
+// WithDefaultEnvVarOrLogin is like WithDefaultEnvVar, but if neither the flag nor the env var has a value it falls back
+// to the field of the credentials cached by 'hzn login' that is returned by the cached function.
+func WithDefaultEnvVarOrLogin(flag *string, envVarName string, cached func(*CachedCredentials) string) *string {
+	if *flag != "" {
+		return flag
+	}
+	if newFlag := os.Getenv(envVarName); newFlag != "" {
+		return &newFlag
+	}
+	if creds := loginCredentials(); creds != nil {
+		if newFlag := cached(creds); newFlag != "" {
+			Verbose("using the %s value cached by 'hzn login'", envVarName)
+			return &newFlag
+		}
+	}
+	return flag // it is empty, but we did not find an env var or cached value
+}
+
+// RequiredWithDefaultEnvVarOrLogin is like RequiredWithDefaultEnvVar, but also falls back to the credentials cached by 'hzn login'.
+func RequiredWithDefaultEnvVarOrLogin(flag *string, envVarName string, cached func(*CachedCredentials) string, errMsg string) *string {
+	if newFlag := WithDefaultEnvVarOrLogin(flag, envVarName, cached); *newFlag != "" {
+		return newFlag
+	}
+	Fatal(CLI_INPUT_ERROR, errMsg)
+	return flag // won't ever happen, here just to make intellij happy
+}
+
+// Selectors for the cached credentials, for use with WithDefaultEnvVarOrLogin.
+func CachedOrg(creds *CachedCredentials) string    { return creds.Org }
+func CachedUserPw(creds *CachedCredentials) string { return creds.UserPw }
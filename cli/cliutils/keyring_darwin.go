@@ -0,0 +1,57 @@
+package cliutils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// On macOS the credentials are kept in the login Keychain using the security command. Commands that carry the secret
+// are fed to 'security -i' on stdin so the secret does not show up in the process list.
+
+func securityCmd(stdin string, args ...string) (string, string, error) {
+	cmd := exec.Command("security", args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return stdout.String(), strings.TrimSpace(stderr.String()), err
+}
+
+// security reports a missing item with exit code 44 and a message saying it could not be found.
+func isKeychainNotFound(stderr string) bool {
+	return strings.Contains(stderr, "could not be found")
+}
+
+func keyringSet(service, account, secret string) error {
+	// The secret is base64 encoded and the service and account have no spaces, so only the label needs quotes.
+	command := fmt.Sprintf("add-generic-password -U -s %v -a %v -l \"%v\" -w %v\n", service, account, KEYRING_LABEL, secret)
+	if _, stderr, err := securityCmd(command, "-i"); err != nil || stderr != "" {
+		return errors.New(fmt.Sprintf("failed to store the credentials in the Keychain: %v %v", err, stderr))
+	}
+	return nil
+}
+
+func keyringGet(service, account string) (string, error) {
+	stdout, stderr, err := securityCmd("", "find-generic-password", "-s", service, "-a", account, "-w")
+	if err != nil {
+		if isKeychainNotFound(stderr) {
+			return "", ErrKeyringNotFound
+		}
+		return "", errors.New(fmt.Sprintf("failed to look up the credentials in the Keychain: %v %v", err, stderr))
+	}
+	return strings.TrimSpace(stdout), nil
+}
+
+func keyringDelete(service, account string) error {
+	if _, stderr, err := securityCmd("", "delete-generic-password", "-s", service, "-a", account); err != nil {
+		if isKeychainNotFound(stderr) {
+			return ErrKeyringNotFound
+		}
+		return errors.New(fmt.Sprintf("failed to remove the credentials from the Keychain: %v %v", err, stderr))
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package cliutils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// On Linux the credentials are kept in the Secret Service (e.g. gnome-keyring or kwallet) using the secret-tool command
+// from libsecret. The secret is passed on stdin so it does not show up in the process list.
+
+func secretTool(stdin string, args ...string) (string, string, error) {
+	cmd := exec.Command("secret-tool", args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return "", "", errors.New(fmt.Sprintf("unable to run secret-tool, make sure libsecret-tools is installed: %v", err))
+		}
+		return stdout.String(), strings.TrimSpace(stderr.String()), err
+	}
+	return stdout.String(), strings.TrimSpace(stderr.String()), nil
+}
+
+func keyringSet(service, account, secret string) error {
+	if _, stderr, err := secretTool(secret, "store", "--label="+KEYRING_LABEL, "service", service, "account", account); err != nil {
+		return errors.New(fmt.Sprintf("failed to store the credentials with secret-tool: %v %v", err, stderr))
+	}
+	return nil
+}
+
+func keyringGet(service, account string) (string, error) {
+	stdout, stderr, err := secretTool("", "lookup", "service", service, "account", account)
+	if err != nil {
+		// secret-tool exits with 1 and no message when there is no matching secret
+		if _, ok := err.(*exec.ExitError); ok && stderr == "" {
+			return "", ErrKeyringNotFound
+		}
+		return "", errors.New(fmt.Sprintf("failed to look up the credentials with secret-tool: %v %v", err, stderr))
+	} else if strings.TrimSpace(stdout) == "" {
+		return "", ErrKeyringNotFound
+	}
+	return strings.TrimSpace(stdout), nil
+}
+
+func keyringDelete(service, account string) error {
+	// secret-tool clear succeeds whether or not there was a matching secret, so look it up first
+	if _, err := keyringGet(service, account); err != nil {
+		return err
+	}
+	if _, stderr, err := secretTool("", "clear", "service", service, "account", account); err != nil {
+		return errors.New(fmt.Sprintf("failed to remove the credentials with secret-tool: %v %v", err, stderr))
+	}
+	return nil
+}
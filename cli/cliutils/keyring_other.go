@@ -0,0 +1,23 @@
+// +build !linux,!darwin,!windows
+
+package cliutils
+
+import (
+	"errors"
+	"runtime"
+)
+
+// There is no OS keyring support on this platform, so 'hzn login' is not available and the credentials must be
+// specified in the flags or env vars.
+
+func keyringSet(service, account, secret string) error {
+	return errors.New("the OS keyring is not supported on " + runtime.GOOS)
+}
+
+func keyringGet(service, account string) (string, error) {
+	return "", errors.New("the OS keyring is not supported on " + runtime.GOOS)
+}
+
+func keyringDelete(service, account string) error {
+	return errors.New("the OS keyring is not supported on " + runtime.GOOS)
+}
@@ -0,0 +1,107 @@
+package cliutils
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// On Windows the credentials are kept in the Credential Manager as a generic credential, using the wincred API.
+
+var (
+	advapi32        = syscall.NewLazyDLL("advapi32.dll")
+	procCredReadW   = advapi32.NewProc("CredReadW")
+	procCredWriteW  = advapi32.NewProc("CredWriteW")
+	procCredDeleteW = advapi32.NewProc("CredDeleteW")
+	procCredFree    = advapi32.NewProc("CredFree")
+)
+
+const (
+	CRED_TYPE_GENERIC          = 1
+	CRED_PERSIST_LOCAL_MACHINE = 2
+)
+
+// The CREDENTIALW structure from wincred.h
+type winCredential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+func credTarget(service, account string) (*uint16, error) {
+	return syscall.UTF16PtrFromString(service + ":" + account)
+}
+
+func keyringSet(service, account, secret string) error {
+	target, err := credTarget(service, account)
+	if err != nil {
+		return err
+	}
+	user, err := syscall.UTF16PtrFromString(account)
+	if err != nil {
+		return err
+	}
+	comment, err := syscall.UTF16PtrFromString(KEYRING_LABEL)
+	if err != nil {
+		return err
+	}
+	blob := []byte(secret)
+	cred := winCredential{
+		Type:               CRED_TYPE_GENERIC,
+		TargetName:         target,
+		Comment:            comment,
+		CredentialBlobSize: uint32(len(blob)),
+		CredentialBlob:     &blob[0],
+		Persist:            CRED_PERSIST_LOCAL_MACHINE,
+		UserName:           user,
+	}
+	if r, _, err := procCredWriteW.Call(uintptr(unsafe.Pointer(&cred)), 0); r == 0 {
+		return errors.New(fmt.Sprintf("failed to store the credentials in the Credential Manager: %v", err))
+	}
+	return nil
+}
+
+func keyringGet(service, account string) (string, error) {
+	target, err := credTarget(service, account)
+	if err != nil {
+		return "", err
+	}
+	var cred *winCredential
+	if r, _, err := procCredReadW.Call(uintptr(unsafe.Pointer(target)), CRED_TYPE_GENERIC, 0, uintptr(unsafe.Pointer(&cred))); r == 0 {
+		if err == syscall.ERROR_NOT_FOUND {
+			return "", ErrKeyringNotFound
+		}
+		return "", errors.New(fmt.Sprintf("failed to look up the credentials in the Credential Manager: %v", err))
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	blob := make([]byte, cred.CredentialBlobSize)
+	for i := range blob {
+		blob[i] = *(*byte)(unsafe.Pointer(uintptr(unsafe.Pointer(cred.CredentialBlob)) + uintptr(i)))
+	}
+	return string(blob), nil
+}
+
+func keyringDelete(service, account string) error {
+	target, err := credTarget(service, account)
+	if err != nil {
+		return err
+	}
+	if r, _, err := procCredDeleteW.Call(uintptr(unsafe.Pointer(target)), CRED_TYPE_GENERIC, 0); r == 0 {
+		if err == syscall.ERROR_NOT_FOUND {
+			return ErrKeyringNotFound
+		}
+		return errors.New(fmt.Sprintf("failed to remove the credentials from the Credential Manager: %v", err))
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package cliutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+)
+
+// The formats that list commands can display their output in, see the --output flag.
+const (
+	OUTPUT_JSON        = "json"
+	OUTPUT_YAML        = "yaml"
+	OUTPUT_TABLE       = "table"
+	OUTPUT_GO_TEMPLATE = "go-template"
+)
+
+const OUTPUT_FORMATS = "json, yaml, table, or go-template=TEMPLATE"
+
+type OutputFormat struct {
+	Format   string
+	Template *template.Template // only set for go-template
+}
+
+// Parse the value of an --output flag. A go-template is given as go-template=TEMPLATE, it is executed against the
+// same data that is displayed in json, so the fields are referred to by their json names.
+func ParseOutputFormat(output string) (*OutputFormat, error) {
+	switch {
+	case output == "" || output == OUTPUT_JSON:
+		return &OutputFormat{Format: OUTPUT_JSON}, nil
+	case output == OUTPUT_YAML || output == OUTPUT_TABLE:
+		return &OutputFormat{Format: output}, nil
+	case strings.HasPrefix(output, OUTPUT_GO_TEMPLATE+"="):
+		tmpl, err := template.New("output").Parse(strings.TrimPrefix(output, OUTPUT_GO_TEMPLATE+"="))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid go-template: %v", err))
+		}
+		return &OutputFormat{Format: OUTPUT_GO_TEMPLATE, Template: tmpl}, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("unsupported output format %v, use %v", output, OUTPUT_FORMATS))
+	}
+}
+
+// Write v in the output format. The table format is made of the header and the rows, the other formats are made
+// from v.
+func (o *OutputFormat) Write(w io.Writer, v interface{}, header []string, rows [][]string) error {
+	switch o.Format {
+	case OUTPUT_YAML:
+		if yamlBytes, err := MarshalYAML(v); err != nil {
+			return err
+		} else {
+			_, err := w.Write(yamlBytes)
+			return err
+		}
+
+	case OUTPUT_TABLE:
+		tw := tabwriter.NewWriter(w, 0, 4, 3, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(header, "\t"))
+		for _, row := range rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+
+	case OUTPUT_GO_TEMPLATE:
+		if generic, err := toGeneric(v); err != nil {
+			return err
+		} else if err := o.Template.Execute(w, generic); err != nil {
+			return errors.New(fmt.Sprintf("unable to execute go-template: %v", err))
+		}
+		return nil
+
+	default:
+		jsonBytes, err := json.MarshalIndent(v, "", JSON_INDENT)
+		if err != nil {
+			return errors.New(fmt.Sprintf("unable to marshal output: %v", err))
+		}
+		_, err = fmt.Fprintf(w, "%s\n", jsonBytes)
+		return err
+	}
+}
+
+// Convert v to the maps, slices and scalars that it is displayed as in json.
+func toGeneric(v interface{}) (interface{}, error) {
+	jsonBytes, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to marshal output: %v", err))
+	}
+	var generic interface{}
+	dec := json.NewDecoder(bytes.NewReader(jsonBytes))
+	dec.UseNumber()
+	if err := dec.Decode(&generic); err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to unmarshal output: %v", err))
+	}
+	return generic, nil
+}
+
+// Marshal v as a yaml document. v is converted the same way it would be for json, so the json field names and
+// omitempty tags are honored. Map keys are sorted, and strings are quoted whenever they could be read as something
+// other than a string.
+func MarshalYAML(v interface{}) ([]byte, error) {
+	generic, err := toGeneric(v)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	switch t := generic.(type) {
+	case map[string]interface{}:
+		if len(t) == 0 {
+			buf.WriteString("{}\n")
+		} else {
+			yamlMap(buf, t, 0, false)
+		}
+	case []interface{}:
+		if len(t) == 0 {
+			buf.WriteString("[]\n")
+		} else {
+			yamlList(buf, t, 0)
+		}
+	default:
+		buf.WriteString(yamlScalar(t) + "\n")
+	}
+	return buf.Bytes(), nil
+}
+
+func yamlMap(buf *bytes.Buffer, m map[string]interface{}, indent int, inList bool) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for i, k := range keys {
+		// The first key of a map in a list goes on the same line as the dash.
+		if i != 0 || !inList {
+			buf.WriteString(strings.Repeat(" ", indent))
+		}
+		buf.WriteString(yamlString(k) + ":")
+		yamlValue(buf, m[k], indent)
+	}
+}
+
+func yamlList(buf *bytes.Buffer, l []interface{}, indent int) {
+	for _, item := range l {
+		buf.WriteString(strings.Repeat(" ", indent) + "-")
+		if m, ok := item.(map[string]interface{}); ok && len(m) != 0 {
+			buf.WriteString(" ")
+			yamlMap(buf, m, indent+2, true)
+		} else {
+			yamlValue(buf, item, indent)
+		}
+	}
+}
+
+// Write a value that follows a "key:" or a "-".
+func yamlValue(buf *bytes.Buffer, v interface{}, indent int) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if len(t) == 0 {
+			buf.WriteString(" {}\n")
+		} else {
+			buf.WriteString("\n")
+			yamlMap(buf, t, indent+2, false)
+		}
+	case []interface{}:
+		if len(t) == 0 {
+			buf.WriteString(" []\n")
+		} else {
+			buf.WriteString("\n")
+			yamlList(buf, t, indent+2)
+		}
+	default:
+		buf.WriteString(" " + yamlScalar(t) + "\n")
+	}
+}
+
+func yamlScalar(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(t)
+	case json.Number:
+		return t.String()
+	case string:
+		return yamlString(t)
+	default:
+		return yamlString(fmt.Sprintf("%v", t))
+	}
+}
+
+var yamlPlain = regexp.MustCompile(`^[A-Za-z0-9_/][A-Za-z0-9_./@+-]*( [A-Za-z0-9_./@+-]+)*$`)
+var yamlReserved = regexp.MustCompile(`^(?i:true|false|yes|no|on|off|y|n|null|~)$`)
+
+// Strings that yaml could read as a number, a boolean or null, or that have special characters, are quoted.
+func yamlString(s string) string {
+	if !yamlPlain.MatchString(s) || yamlReserved.MatchString(s) {
+		return strconv.Quote(s)
+	} else if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return strconv.Quote(s)
+	} else if _, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,88 @@
+// +build unit
+
+package cliutils
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_MarshalYAML(t *testing.T) {
+
+	type workload struct {
+		Version string   `json:"version"`
+		Arch    string   `json:"arch,omitempty"`
+		Ports   []int    `json:"ports"`
+		Env     []string `json:"env"`
+	}
+
+	v := map[string]interface{}{
+		"myorg/gps_1.0.0": map[string]interface{}{
+			"label":     "GPS: with a colon",
+			"public":    true,
+			"sharable":  "single",
+			"version":   "1.0",
+			"workloads": []workload{{Version: "1.0.0", Ports: []int{80, 443}, Env: []string{}}},
+			"matchHw":   map[string]string{},
+			"userInput": nil,
+			"empty":     "",
+			"yes":       "yes",
+		},
+	}
+
+	expected := `myorg/gps_1.0.0:
+  empty: ""
+  label: "GPS: with a colon"
+  matchHw: {}
+  public: true
+  sharable: single
+  userInput: null
+  version: "1.0"
+  workloads:
+    - env: []
+      ports:
+        - 80
+        - 443
+      version: 1.0.0
+  "yes": "yes"
+`
+
+	if yamlBytes, err := MarshalYAML(v); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if string(yamlBytes) != expected {
+		t.Errorf("yaml should be:\n%v\nwas:\n%v", expected, string(yamlBytes))
+	}
+}
+
+func Test_OutputFormat(t *testing.T) {
+
+	if _, err := ParseOutputFormat("xml"); err == nil {
+		t.Errorf("xml should not be a supported output format")
+	} else if _, err := ParseOutputFormat("go-template={{.x"); err == nil {
+		t.Errorf("an invalid template should be rejected")
+	}
+
+	v := map[string]interface{}{"b": map[string]string{"version": "2.0.0"}, "a": map[string]string{"version": "1.0.0"}}
+	header := []string{"ID", "VERSION"}
+	rows := [][]string{{"a", "1.0.0"}, {"b", "2.0.0"}}
+
+	tests := []struct {
+		output   string
+		expected string
+	}{
+		{"", "{\n  \"a\": {\n    \"version\": \"1.0.0\"\n  },\n  \"b\": {\n    \"version\": \"2.0.0\"\n  }\n}\n"},
+		{"table", "ID   VERSION\na    1.0.0\nb    2.0.0\n"},
+		{"go-template={{range $id, $m := .}}{{$id}}={{$m.version}} {{end}}", "a=1.0.0 b=2.0.0 "},
+	}
+
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		if o, err := ParseOutputFormat(test.output); err != nil {
+			t.Errorf("output %v: unexpected error %v", test.output, err)
+		} else if err := o.Write(buf, v, header, rows); err != nil {
+			t.Errorf("output %v: unexpected error %v", test.output, err)
+		} else if buf.String() != test.expected {
+			t.Errorf("output %v should be:\n%q\nwas:\n%q", test.output, test.expected, buf.String())
+		}
+	}
+}
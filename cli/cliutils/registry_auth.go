@@ -0,0 +1,187 @@
+package cliutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	dockerclient "github.com/fsouza/go-dockerclient"
+	"github.com/open-horizon/anax/cutil"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// The credentials used to push images to docker registries when their digests are put into a deployment string. They
+// come from a json file given with --registry-auth-file or HZN_REGISTRY_AUTH_FILE, or from the same json in
+// HZN_REGISTRY_AUTH. A registry that isn't in them falls back to the credentials that 'docker login' stored in
+// ~/.docker/config.json. The json is like {"registries": {"myregistry.com:5000": {"username": "me", "password": "secret"}}}.
+type RegistryCreds struct {
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	Email         string `json:"email,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+}
+
+type RegistryAuth struct {
+	Registries map[string]RegistryCreds `json:"registries"`
+	source     string                   // where the credentials were read from, for error messages
+}
+
+// Where the credentials of a registry are looked up when they are not in the registry auth. This is a variable so
+// that the unit tests don't depend on the docker config of the user running them.
+var dockerLoginAuth = GetDockerAuth
+
+// Read the registry auth from the file, or from HZN_REGISTRY_AUTH_FILE or HZN_REGISTRY_AUTH when the file isn't
+// specified. The registry auth is empty when none of them are set, so that only the 'docker login' credentials are
+// used.
+func LoadRegistryAuth(filePath string) (*RegistryAuth, error) {
+	ra := &RegistryAuth{Registries: make(map[string]RegistryCreds)}
+
+	var authBytes []byte
+	if filePath == "" {
+		filePath = os.Getenv("HZN_REGISTRY_AUTH_FILE")
+	}
+	if filePath != "" {
+		var err error
+		if authBytes, err = ioutil.ReadFile(filePath); err != nil {
+			return nil, errors.New(fmt.Sprintf("unable to read registry auth file %v: %v", filePath, err))
+		}
+		ra.source = filePath
+	} else if env := os.Getenv("HZN_REGISTRY_AUTH"); env != "" {
+		authBytes = []byte(env)
+		ra.source = "HZN_REGISTRY_AUTH"
+	} else {
+		return ra, nil
+	}
+
+	if err := json.Unmarshal(authBytes, ra); err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to parse registry auth from %v: %v", ra.source, err))
+	} else if ra.Registries == nil {
+		ra.Registries = make(map[string]RegistryCreds)
+	}
+	for registry, creds := range ra.Registries {
+		if creds.IdentityToken == "" && (creds.Username == "" || creds.Password == "") {
+			return nil, errors.New(fmt.Sprintf("the credentials of registry %v in %v must have a username and password, or an identitytoken", registry, ra.source))
+		}
+	}
+	return ra, nil
+}
+
+// The registry part of a registry auth key. The keys can be urls, like the ones 'docker login' uses for docker hub.
+func registryHost(key string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	if ix := strings.Index(host, "/"); ix != -1 {
+		host = host[:ix]
+	}
+	return host
+}
+
+// Returns the credentials for the registry, and where they came from. An empty registry means docker hub, which can
+// be listed under any of its names. When the registry isn't in the registry auth, the 'docker login' credentials are
+// used.
+func (ra *RegistryAuth) Lookup(registry string) (dockerclient.AuthConfiguration, string, error) {
+	isHub := cutil.ImageReference{Registry: registry}.IsDockerHub()
+	if ra != nil {
+		for key, creds := range ra.Registries {
+			host := registryHost(key)
+			if host == registry || (isHub && cutil.ImageReference{Registry: host}.IsDockerHub()) {
+				Verbose("using credentials from %v for registry %v", ra.source, key)
+				return dockerclient.AuthConfiguration{Username: creds.Username, Password: creds.Password, Email: creds.Email, IdentityToken: creds.IdentityToken, ServerAddress: key}, ra.source, nil
+			}
+		}
+	}
+
+	auth, err := dockerLoginAuth(registry)
+	if err != nil {
+		if ra != nil && ra.source != "" {
+			return auth, "", errors.New(fmt.Sprintf("registry %v is not in %v, and %v", displayRegistry(registry), ra.source, err))
+		}
+		return auth, "", err
+	}
+	return auth, "~/.docker/config.json", nil
+}
+
+func displayRegistry(registry string) string {
+	if registry == "" {
+		return cutil.DEFAULT_IMAGE_REGISTRY
+	}
+	return registry
+}
+
+// How the images in a deployment string are pushed: the credentials for the registries, and the registries the
+// images are pushed to besides the one in their image path.
+type RegistryPushConfig struct {
+	Auth            *RegistryAuth
+	ExtraRegistries []string
+}
+
+// Create the push config from the registry auth file and the extra registries, checking that the extra registries
+// are valid registry names.
+func NewRegistryPushConfig(authFilePath string, extraRegistries []string) (*RegistryPushConfig, error) {
+	ra, err := LoadRegistryAuth(authFilePath)
+	if err != nil {
+		return nil, err
+	}
+	for _, registry := range extraRegistries {
+		if ref, err := cutil.ParseImageReference(registry + "/image"); err != nil || ref.Registry != registry {
+			return nil, errors.New(fmt.Sprintf("%v is not a valid registry, it must be a host name or IP address with an optional port", registry))
+		}
+	}
+	return &RegistryPushConfig{Auth: ra, ExtraRegistries: extraRegistries}, nil
+}
+
+// Push the image to its own registry and to the extra registries of the push config, which can be nil. Returns the
+// repo digest from its own registry. If there is an error, it prints the error, with the image and registry that
+// failed, and exits.
+func PushDockerImageToRegistries(client *dockerclient.Client, registry, repository, tag string, pushConfig *RegistryPushConfig) (digest string) {
+	var ra *RegistryAuth
+	var extraRegistries []string
+	if pushConfig != nil {
+		ra, extraRegistries = pushConfig.Auth, pushConfig.ExtraRegistries
+	}
+
+	image := cutil.ImageReference{Registry: registry, Repository: repository, Tag: tag}
+	digest = pushDockerImageWithAuth(client, image, ra)
+
+	for _, extra := range extraRegistries {
+		if extra == registry {
+			continue
+		}
+		extraImage := cutil.ImageReference{Registry: extra, Repository: repository, Tag: tag}
+		if err := client.TagImage(image.String(), dockerclient.TagImageOptions{Repo: extraImage.Name(), Tag: tag}); err != nil {
+			Fatal(CLI_GENERAL_ERROR, "unable to tag docker image %v as %v for registry %v: %v", image, extraImage, extra, err)
+		}
+		if extraDigest := pushDockerImageWithAuth(client, extraImage, ra); extraDigest != digest {
+			fmt.Printf("Warning: image %v has digest %v in registry %v, and %v in registry %v\n", repository, digest, displayRegistry(registry), extraDigest, extra)
+		}
+	}
+	return
+}
+
+func pushDockerImageWithAuth(client *dockerclient.Client, image cutil.ImageReference, ra *RegistryAuth) string {
+	fmt.Printf("Pushing %v...\n", image)
+
+	auth, source, err := ra.Lookup(image.Registry)
+	if err != nil {
+		Fatal(CLI_INPUT_ERROR, "could not get credentials for registry %v to push image %v: %v. Maybe you need to run '%v', or add the registry to the registry auth file.", displayRegistry(image.Registry), image, err, strings.TrimSpace("docker login "+image.Registry))
+	}
+	Verbose("pushing %v with the credentials from %v", image, source)
+
+	// The output of the push goes to stdout, for the user to see the progress, and to a buffer to get the digest from.
+	var buf bytes.Buffer
+	multiWriter := io.MultiWriter(os.Stdout, &buf)
+	opts := dockerclient.PushImageOptions{Name: image.Name(), Tag: image.Tag, OutputStream: multiWriter} // do not set InactivityTimeout because the user will ctrl-c if they think something is wrong
+	if err := client.PushImage(opts, auth); err != nil {
+		Fatal(CLI_GENERAL_ERROR, "unable to push docker image %v to registry %v with the credentials from %v: %v", image, displayRegistry(image.Registry), source, err)
+	}
+
+	reDigest := regexp.MustCompile(`\s+digest:\s+(\S+)\s+size:`)
+	matches := reDigest.FindStringSubmatch(buf.String())
+	if len(matches) < 2 {
+		Fatal(CLI_GENERAL_ERROR, "could not find the digest of image %v in the docker push output for registry %v", image, displayRegistry(image.Registry))
+	}
+	return matches[1]
+}
@@ -0,0 +1,110 @@
+// +build unit
+
+package cliutils
+
+import (
+	"errors"
+	"fmt"
+	dockerclient "github.com/fsouza/go-dockerclient"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// Replace the 'docker login' credentials with credentials for loginregistry.com only.
+func fakeDockerLogin() func() {
+	saved := dockerLoginAuth
+	dockerLoginAuth = func(domain string) (dockerclient.AuthConfiguration, error) {
+		if domain == "loginregistry.com" {
+			return dockerclient.AuthConfiguration{Username: "login"}, nil
+		}
+		return dockerclient.AuthConfiguration{}, errors.New(fmt.Sprintf("unable to find docker credentials for %v", domain))
+	}
+	return func() { dockerLoginAuth = saved }
+}
+
+func Test_LoadRegistryAuth(t *testing.T) {
+
+	verbose := false
+	Opts.Verbose = &verbose
+	defer fakeDockerLogin()()
+	os.Unsetenv("HZN_REGISTRY_AUTH_FILE")
+	os.Unsetenv("HZN_REGISTRY_AUTH")
+
+	dir, err := ioutil.TempDir("", "registry-auth")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	authFile := path.Join(dir, "auth.json")
+	ioutil.WriteFile(authFile, []byte(`{"registries": {"myregistry.com:5000": {"username": "me", "password": "secret"}, "https://index.docker.io/v1/": {"identitytoken": "hubtoken"}}}`), 0600)
+
+	// The registries in the file use its credentials, the others fall back to docker login.
+	ra, err := LoadRegistryAuth(authFile)
+	if err != nil {
+		t.Fatalf("unable to load registry auth: %v", err)
+	}
+	if auth, source, err := ra.Lookup("myregistry.com:5000"); err != nil || auth.Username != "me" || auth.Password != "secret" || source != authFile {
+		t.Errorf("wrong credentials for myregistry.com:5000: %v from %v, error %v", auth, source, err)
+	}
+	for _, hub := range []string{"", "docker.io"} {
+		if auth, _, err := ra.Lookup(hub); err != nil || auth.IdentityToken != "hubtoken" {
+			t.Errorf("wrong credentials for docker hub %v: %v, error %v", hub, auth, err)
+		}
+	}
+	if auth, source, err := ra.Lookup("loginregistry.com"); err != nil || auth.Username != "login" || source != "~/.docker/config.json" {
+		t.Errorf("wrong docker login credentials for loginregistry.com: %v from %v, error %v", auth, source, err)
+	}
+	if _, _, err := ra.Lookup("other.com"); err == nil || !strings.Contains(err.Error(), "other.com") || !strings.Contains(err.Error(), authFile) {
+		t.Errorf("error for a registry without credentials should name the registry and the auth file, was %v", err)
+	}
+
+	// The file can be given in the environment, or the json itself.
+	os.Setenv("HZN_REGISTRY_AUTH_FILE", authFile)
+	if ra, err := LoadRegistryAuth(""); err != nil || len(ra.Registries) != 2 {
+		t.Errorf("registry auth should be read from HZN_REGISTRY_AUTH_FILE, was %v, error %v", ra, err)
+	}
+	os.Unsetenv("HZN_REGISTRY_AUTH_FILE")
+	os.Setenv("HZN_REGISTRY_AUTH", `{"registries": {"envregistry.com": {"username": "env", "password": "secret"}}}`)
+	defer os.Unsetenv("HZN_REGISTRY_AUTH")
+	if ra, err := LoadRegistryAuth(""); err != nil {
+		t.Errorf("unable to load registry auth from HZN_REGISTRY_AUTH: %v", err)
+	} else if auth, source, err := ra.Lookup("envregistry.com"); err != nil || auth.Username != "env" || source != "HZN_REGISTRY_AUTH" {
+		t.Errorf("wrong credentials for envregistry.com: %v from %v, error %v", auth, source, err)
+	}
+
+	// Without any registry auth, only docker login is used.
+	os.Unsetenv("HZN_REGISTRY_AUTH")
+	if ra, err := LoadRegistryAuth(""); err != nil || len(ra.Registries) != 0 {
+		t.Errorf("registry auth should be empty, was %v, error %v", ra, err)
+	} else if auth, _, err := ra.Lookup("loginregistry.com"); err != nil || auth.Username != "login" {
+		t.Errorf("wrong docker login credentials for loginregistry.com: %v, error %v", auth, err)
+	}
+
+	// Invalid registry auth.
+	ioutil.WriteFile(authFile, []byte(`{"registries": {"myregistry.com": {"username": "me"}}}`), 0600)
+	if _, err := LoadRegistryAuth(authFile); err == nil {
+		t.Errorf("credentials without a password should be rejected")
+	}
+	if _, err := LoadRegistryAuth(path.Join(dir, "missing.json")); err == nil {
+		t.Errorf("a missing registry auth file should be an error")
+	}
+}
+
+func Test_NewRegistryPushConfig(t *testing.T) {
+
+	os.Unsetenv("HZN_REGISTRY_AUTH_FILE")
+	os.Unsetenv("HZN_REGISTRY_AUTH")
+
+	if pc, err := NewRegistryPushConfig("", []string{"myregistry.com:5000", "localhost", "[::1]:5000"}); err != nil || len(pc.ExtraRegistries) != 3 {
+		t.Errorf("valid registries should be accepted, got %v, error %v", pc, err)
+	}
+	for _, registry := range []string{"myregistry.com/path", "my_registry.com", "myregistry.com:port", ""} {
+		if _, err := NewRegistryPushConfig("", []string{registry}); err == nil {
+			t.Errorf("%v should not be accepted as a registry", registry)
+		}
+	}
+}
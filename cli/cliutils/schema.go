@@ -0,0 +1,168 @@
+package cliutils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The json output of the list commands is a contract that automation can rely on. The output is versioned as a
+// whole: OUTPUT_VERSION is increased whenever a field of the output of any of these commands is renamed, removed or
+// changes its type, and the change is added to OutputChanges so that a script pinned to an older version with
+// --output-version keeps getting the output it was written for. Adding a field does not need a new version.
+// The schema of the output of each command is generated from its Go types by 'hzn util output-schema'.
+const OUTPUT_VERSION = 1
+
+// A change to the json output of a command. Revert turns the output of the version the change was made in back
+// into the output of the previous version. It is given the output as json maps, slices and scalars.
+type OutputChange struct {
+	Version     int                                  `json:"version"`
+	Command     string                               `json:"command"`
+	Description string                               `json:"description"`
+	Revert      func(output interface{}) interface{} `json:"-"`
+}
+
+// The changes made to the json output since version 1, oldest first.
+var OutputChanges = []OutputChange{}
+
+// Returns the output of the command in the output version, where 0 means the current version.
+func VersionedOutput(command string, v interface{}, version int) (interface{}, error) {
+	return versionedOutput(command, v, version, OUTPUT_VERSION)
+}
+
+func versionedOutput(command string, v interface{}, version int, current int) (interface{}, error) {
+	if version == 0 || version == current {
+		return v, nil
+	} else if version < 1 || version > current {
+		return nil, errors.New(fmt.Sprintf("output version %v is not supported, this version of hzn supports output versions 1 to %v", version, current))
+	}
+
+	output, err := toGeneric(v)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(OutputChanges) - 1; i >= 0; i-- {
+		if change := OutputChanges[i]; change.Version > version && change.Command == command {
+			output = change.Revert(output)
+		}
+	}
+	return output, nil
+}
+
+// Print the json output of a list command, in the output version requested with --output-version.
+func PrintOutput(command string, v interface{}) {
+	version := 0
+	if Opts.OutputVersion != nil {
+		version = *Opts.OutputVersion
+	}
+	output, err := VersionedOutput(command, v, version)
+	if err != nil {
+		Fatal(CLI_INPUT_ERROR, "%v", err)
+	}
+	jsonBytes, err := json.MarshalIndent(output, "", JSON_INDENT)
+	if err != nil {
+		Fatal(JSON_PARSING_ERROR, "failed to marshal '%v' output: %v", command, err)
+	}
+	fmt.Printf("%s\n", jsonBytes)
+}
+
+// Generate the json schema of the json that v is marshaled to. The json field names and omitempty tags are honored,
+// fields without omitempty are required. Types that marshal themselves are described as any value, except for
+// time.Time.
+func JSONSchema(v interface{}) map[string]interface{} {
+	return typeSchema(reflect.TypeOf(v), map[reflect.Type]bool{})
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+var marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+func typeSchema(t reflect.Type, visiting map[reflect.Type]bool) map[string]interface{} {
+	if t == nil {
+		return map[string]interface{}{}
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	} else if t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType) {
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), visiting)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem(), visiting)}
+	case reflect.Struct:
+		// A type that contains itself is only described once.
+		if visiting[t] {
+			return map[string]interface{}{"type": "object"}
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+
+		properties := make(map[string]interface{})
+		required := make([]string, 0)
+		structSchema(t, visiting, properties, &required)
+		sort.Strings(required)
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) != 0 {
+			schema["required"] = required
+		}
+		return schema
+	default:
+		// interface{} can hold any value
+		return map[string]interface{}{}
+	}
+}
+
+// Add the fields of the struct to the properties, including the fields of embedded structs that json promotes.
+func structSchema(t reflect.Type, visiting map[reflect.Type]bool, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if ix := strings.Index(tag, ","); ix != -1 {
+			name, opts = tag[:ix], tag[ix+1:]
+		}
+
+		ft := field.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if field.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			structSchema(ft, visiting, properties, required)
+			continue
+		} else if field.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = typeSchema(field.Type, visiting)
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// +build unit
+
+package cliutils
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type schemaInner struct {
+	Name string `json:"name"`
+}
+
+type SchemaEmbedded struct {
+	Embedded string `json:"embedded"`
+}
+
+type schemaTest struct {
+	SchemaEmbedded
+	Id       string                 `json:"id"`
+	Count    uint64                 `json:"count,omitempty"`
+	Ratio    float64                `json:"ratio"`
+	Tags     []string               `json:"tags"`
+	Inner    *schemaInner           `json:"inner,omitempty"`
+	Vars     map[string]interface{} `json:"vars"`
+	Started  time.Time              `json:"started"`
+	Children []schemaTest           `json:"children,omitempty"`
+	Hidden   string                 `json:"-"`
+	internal string
+}
+
+func Test_JSONSchema(t *testing.T) {
+
+	schema := JSONSchema([]schemaTest{})
+	if schema["type"] != "array" {
+		t.Fatalf("expected an array schema, got %v", schema)
+	}
+	item := schema["items"].(map[string]interface{})
+	props := item["properties"].(map[string]interface{})
+
+	names := make([]string, 0)
+	for name := range props {
+		names = append(names, name)
+	}
+	if len(props) != 9 {
+		t.Errorf("expected 9 properties, got %v", names)
+	} else if props["embedded"] == nil || props["Hidden"] != nil || props["internal"] != nil {
+		t.Errorf("embedded fields should be promoted and hidden fields left out, got %v", names)
+	} else if props["count"].(map[string]interface{})["type"] != "integer" || props["ratio"].(map[string]interface{})["type"] != "number" {
+		t.Errorf("numbers not described correctly: %v %v", props["count"], props["ratio"])
+	} else if props["started"].(map[string]interface{})["format"] != "date-time" {
+		t.Errorf("time should be a date-time string: %v", props["started"])
+	} else if props["inner"].(map[string]interface{})["properties"].(map[string]interface{})["name"] == nil {
+		t.Errorf("pointer to struct not described: %v", props["inner"])
+	} else if children := props["children"].(map[string]interface{})["items"].(map[string]interface{}); children["properties"] != nil {
+		t.Errorf("recursive type should not be described again: %v", children)
+	}
+
+	expected := []string{"embedded", "id", "ratio", "started", "tags", "vars"}
+	if !reflect.DeepEqual(item["required"], expected) {
+		t.Errorf("expected required %v, got %v", expected, item["required"])
+	}
+}
+
+func Test_VersionedOutput(t *testing.T) {
+
+	saved := OutputChanges
+	defer func() { OutputChanges = saved }()
+
+	// Pretend that version 2 renamed the id field of the test command.
+	OutputChanges = []OutputChange{
+		{
+			Version: 2,
+			Command: "hzn test list",
+			Revert: func(output interface{}) interface{} {
+				for _, item := range output.([]interface{}) {
+					m := item.(map[string]interface{})
+					m["old_id"] = m["id"]
+					delete(m, "id")
+				}
+				return output
+			},
+		},
+	}
+
+	v := []schemaTest{{Id: "a", Count: 3}}
+	if out, err := VersionedOutput("hzn test list", v, 0); err != nil || !reflect.DeepEqual(out, v) {
+		t.Errorf("current version output should not be changed, got %v %v", out, err)
+	} else if _, err := VersionedOutput("hzn test list", v, OUTPUT_VERSION+1); err == nil {
+		t.Errorf("a future output version should not be supported")
+	} else if _, err := VersionedOutput("hzn test list", v, -1); err == nil {
+		t.Errorf("a negative output version should not be supported")
+	}
+
+	// With version 3 current, output asked for in version 1 is reverted by the version 2 change.
+	out, err := versionedOutput("hzn test list", v, 1, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	jsonBytes, _ := json.Marshal(out)
+	var back []map[string]interface{}
+	json.Unmarshal(jsonBytes, &back)
+	if back[0]["old_id"] != "a" || back[0]["id"] != nil {
+		t.Errorf("output should have been reverted, got %v", string(jsonBytes))
+	}
+
+	// Output asked for in version 2 already has the change.
+	if out, err := versionedOutput("hzn test list", v, 2, 3); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if m := out.([]interface{})[0].(map[string]interface{}); m["id"] != "a" || m["old_id"] != nil {
+		t.Errorf("version 2 output should not be reverted, got %v", m)
+	}
+}
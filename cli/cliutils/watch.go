@@ -0,0 +1,135 @@
+package cliutils
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Moves the cursor to the top left corner of the terminal and clears it.
+const CLEAR_SCREEN = "\033[H\033[2J"
+
+const DEFAULT_WATCH_INTERVAL = "2" // seconds, the default of the --interval flag of the watch commands
+
+// Display a table that is kept up to date until the user presses ctrl-c. The rows are read again every interval, and
+// the screen is only redrawn when the table changed, so that it doesn't flicker and the title says when the table
+// last changed. getRows calls Fatal when it can't read the rows, like the list commands do.
+func Watch(title string, intervalS int, header []string, getRows func() [][]string) {
+	if intervalS <= 0 {
+		Fatal(CLI_INPUT_ERROR, "the watch interval must be at least 1 second, was %v", intervalS)
+	}
+
+	last := ""
+	for {
+		table := WatchTable(header, getRows())
+		if table != last {
+			fmt.Print(CLEAR_SCREEN + WatchScreen(title, intervalS, time.Now(), table))
+			last = table
+		}
+		time.Sleep(time.Duration(intervalS) * time.Second)
+	}
+}
+
+// Format the rows as a table with aligned columns. A table without rows says so under the header.
+func WatchTable(header []string, rows [][]string) string {
+	buf := new(bytes.Buffer)
+	(&OutputFormat{Format: OUTPUT_TABLE}).Write(buf, nil, header, rows)
+	if len(rows) == 0 {
+		buf.WriteString("(none)\n")
+	}
+	return buf.String()
+}
+
+// The whole screen of a watch: a title line with the interval and the time the table changed, then the table.
+func WatchScreen(title string, intervalS int, changed time.Time, table string) string {
+	return fmt.Sprintf("Every %vs: %v    changed at %v (ctrl-c to exit)\n\n%v", intervalS, title, changed.Format("15:04:05"), table)
+}
+
+// A short form of a unix time for the columns of a watch table.
+func WatchTime(unixSeconds uint64) string {
+	if unixSeconds == 0 {
+		return "-"
+	}
+	return time.Unix(int64(unixSeconds), 0).Format("2006-01-02 15:04:05")
+}
+
+// Values that are empty are displayed as a dash, so that the columns of a table stay aligned.
+func WatchCell(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return "-"
+	}
+	return s
+}
+
+// Print the changes to a table as they happen, until the user presses ctrl-c, instead of redrawing the table. The
+// output is a log of the changes that stays in the scrollback and can be redirected to a file. The first column of
+// the rows is the key of a row, the rows that are there at the start are printed as added.
+func WatchChanges(title string, intervalS int, header []string, getRows func() [][]string) {
+	if intervalS <= 0 {
+		Fatal(CLI_INPUT_ERROR, "the watch interval must be at least 1 second, was %v", intervalS)
+	}
+
+	fmt.Printf("Every %vs: %v (ctrl-c to exit)\n", intervalS, title)
+	var last [][]string
+	for {
+		rows := getRows()
+		now := time.Now().Format("15:04:05")
+		for _, change := range WatchDiff(header, last, rows) {
+			fmt.Printf("%v %v\n", now, change)
+		}
+		last = rows
+		time.Sleep(time.Duration(intervalS) * time.Second)
+	}
+}
+
+// The differences between two versions of a table, one line per row that was added (+), removed (-) or changed (~).
+// The rows are matched by their first column. An added row lists all its columns, a changed row only the columns
+// that changed, with their old and new values.
+func WatchDiff(header []string, before [][]string, after [][]string) []string {
+
+	column := func(row []string, i int) string {
+		if i < len(row) {
+			return row[i]
+		}
+		return "-"
+	}
+
+	key := func(row []string) string {
+		return column(row, 0)
+	}
+
+	beforeRows := make(map[string][]string)
+	for _, row := range before {
+		beforeRows[key(row)] = row
+	}
+	afterKeys := make(map[string]bool)
+
+	changes := []string{}
+	for _, row := range after {
+		afterKeys[key(row)] = true
+		fields := []string{}
+		if old, ok := beforeRows[key(row)]; !ok {
+			for i := 1; i < len(header); i++ {
+				fields = append(fields, fmt.Sprintf("%v=%v", header[i], column(row, i)))
+			}
+			changes = append(changes, fmt.Sprintf("+ %v %v", key(row), strings.Join(fields, " ")))
+		} else {
+			for i := 1; i < len(header); i++ {
+				if column(old, i) != column(row, i) {
+					fields = append(fields, fmt.Sprintf("%v: %v -> %v", header[i], column(old, i), column(row, i)))
+				}
+			}
+			if len(fields) != 0 {
+				changes = append(changes, fmt.Sprintf("~ %v %v", key(row), strings.Join(fields, ", ")))
+			}
+		}
+	}
+
+	for _, row := range before {
+		if !afterKeys[key(row)] {
+			changes = append(changes, fmt.Sprintf("- %v", key(row)))
+		}
+	}
+	return changes
+}
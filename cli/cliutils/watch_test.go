@@ -0,0 +1,62 @@
+// +build unit
+
+package cliutils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_WatchTable(t *testing.T) {
+
+	header := []string{"AGREEMENT", "STATE"}
+
+	expected := "AGREEMENT   STATE\n" +
+		"a1          executing\n" +
+		"a22         -\n"
+	if table := WatchTable(header, [][]string{{"a1", "executing"}, {"a22", WatchCell(" ")}}); table != expected {
+		t.Errorf("table should be:\n%v\nwas:\n%v", expected, table)
+	}
+
+	if table := WatchTable(header, nil); !strings.HasSuffix(table, "(none)\n") {
+		t.Errorf("a table without rows should say so, was:\n%v", table)
+	}
+
+	// The same rows make the same table, so the screen is not redrawn.
+	if WatchTable(header, [][]string{{"a1", "executing"}}) != WatchTable(header, [][]string{{"a1", "executing"}}) {
+		t.Errorf("the same rows should make the same table")
+	}
+
+	changed := time.Date(2018, 3, 1, 14, 5, 9, 0, time.Local)
+	if screen := WatchScreen("hzn agreement list", 2, changed, "TABLE\n"); !strings.HasPrefix(screen, "Every 2s: hzn agreement list") || !strings.Contains(screen, "14:05:09") || !strings.HasSuffix(screen, "\n\nTABLE\n") {
+		t.Errorf("wrong watch screen:\n%v", screen)
+	}
+
+	if WatchTime(0) != "-" {
+		t.Errorf("a zero time should be displayed as a dash, was %v", WatchTime(0))
+	}
+}
+
+func Test_WatchDiff(t *testing.T) {
+
+	header := []string{"AGREEMENT", "STATE", "SINCE"}
+	before := [][]string{{"a1", "proposed", "10:00"}, {"a2", "finalized", "09:00"}}
+	after := [][]string{{"a1", "accepted", "10:01"}, {"a2", "finalized", "09:00"}, {"a3", "proposed", "10:02"}}
+
+	expected := []string{
+		"~ a1 STATE: proposed -> accepted, SINCE: 10:00 -> 10:01",
+		"+ a3 STATE=proposed SINCE=10:02",
+	}
+	if changes := WatchDiff(header, before, after); strings.Join(changes, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("changes should be:\n%v\nwere:\n%v", strings.Join(expected, "\n"), strings.Join(changes, "\n"))
+	}
+
+	if changes := WatchDiff(header, after, after[1:]); len(changes) != 1 || changes[0] != "- a1" {
+		t.Errorf("the removed row should be the only change, were %v", changes)
+	}
+
+	if changes := WatchDiff(header, nil, before); len(changes) != 2 || !strings.HasPrefix(changes[0], "+ a1") {
+		t.Errorf("all the rows should be added at the start, were %v", changes)
+	}
+}
@@ -724,7 +724,9 @@ func getExchangeDefinition(homeDirectory string, specRef string, surl string, or
 			}
 
 			// convert to torrent structure only if the torrent string exists on the exchange
-			if ms_workload.Torrent != "" {
+			if exchange.IsLegacyTorrentField(ms_workload.Torrent) {
+				fmt.Printf("Warning: microservice %v has a deprecated torrent field, run 'hzn exchange microservice migrate' to remove it.\n", microserviceDef.SpecRef)
+			} else if ms_workload.Torrent != "" {
 				if err := json.Unmarshal([]byte(ms_workload.Torrent), &torrent); err != nil {
 					return nil, fmt.Errorf("The torrent definition for microservice %v has error: %v", microserviceDef.SpecRef, err)
 				}
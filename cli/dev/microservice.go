@@ -52,7 +52,7 @@ func MicroserviceNew(homeDirectory string, org string) {
 	fmt.Printf("Created horizon metadata files in %v. Edit these files to define and configure your new %v.\n", dir, MICROSERVICE_COMMAND)
 }
 
-func MicroserviceStartTest(homeDirectory string, userInputFile string) {
+func MicroserviceStartTest(homeDirectory string, userInputFile string, overridesFile string) {
 
 	// Run verification before trying to start anything.
 	MicroserviceValidate(homeDirectory, userInputFile)
@@ -71,6 +71,11 @@ func MicroserviceStartTest(homeDirectory string, userInputFile string) {
 		cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, "'%v %v' %v", MICROSERVICE_COMMAND, MICROSERVICE_START_COMMAND, cerr)
 	}
 
+	// Merge in the local overrides, if there are any. These are never part of the published definition.
+	if err := applyDeploymentOverrides(dir, overridesFile, dc); err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "'%v %v' %v", MICROSERVICE_COMMAND, MICROSERVICE_START_COMMAND, err)
+	}
+
 	// Now we can start the microservice container.
 	_, err := startMicroservice(deployment, microserviceDef.SpecRef, microserviceDef.Version, userInputs.Global, microserviceDef.UserInputs, userInputs.Microservices, microserviceDef.Org, dc, cw, map[string]docker.ContainerNetwork{})
 	if err != nil {
@@ -123,7 +128,7 @@ func MicroserviceValidate(homeDirectory string, userInputFile string) {
 	fmt.Printf("Microservice project %v verified.\n", dir)
 }
 
-func MicroserviceDeploy(homeDirectory string, keyFile string, pubKeyFilePath string, userCreds string, dontTouchImage bool) {
+func MicroserviceDeploy(homeDirectory string, keyFile string, pubKeyFilePath string, userCreds string, dontTouchImage bool, strict bool) {
 
 	// Validate the inputs
 	if keyFile == "" {
@@ -165,7 +170,7 @@ func MicroserviceDeploy(homeDirectory string, keyFile string, pubKeyFilePath str
 	cliutils.SetWhetherUsingApiKey(userCreds)
 
 	// Invoke the re-usable part of hzn exchange microservice publish to actually do the publish.
-	microserviceDef.SignAndPublish(microserviceDef.Org, userCreds, keyFile, pubKeyFilePath, dontTouchImage)
+	microserviceDef.SignAndPublish(microserviceDef.Org, userCreds, keyFile, pubKeyFilePath, dontTouchImage, nil, strict)
 
 	fmt.Printf("Microservice project %v deployed.\n", dir)
 }
@@ -99,6 +99,7 @@ func ValidateMicroserviceDefinition(directory string, fileName string) error {
 			if err := depConfig.CanStartStop(); err != nil {
 				return errors.New(fmt.Sprintf("%v: workloads array element at index %v, %v", filePath, ix, err))
 			}
+			warnMissingDevices(filePath, depConfig)
 		}
 		for ix, ui := range msDef.UserInputs {
 			if (ui.Name != "" && ui.Type == "") || (ui.Name == "" && (ui.Type != "" || ui.DefaultValue != "")) {
@@ -0,0 +1,132 @@
+package dev
+
+import (
+	"errors"
+	"fmt"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/open-horizon/anax/cli/cliutils"
+	cliexchange "github.com/open-horizon/anax/cli/exchange"
+	"github.com/open-horizon/anax/containermessage"
+	"path/filepath"
+	"strings"
+)
+
+// The overrides file holds changes to the deployment config that are only used when the project is run locally by
+// the hzn dev start commands. It is never read by verify or publish, so the published deployment config is unaffected.
+const OVERRIDES_FILE = "dev.overrides.json"
+
+// The changes made to one container in the deployment config. Environment variables replace the variable of the same
+// name and binds replace the bind of the same container path, anything else is added. Ports replace the ports
+// of the container when they are set. The image tag replaces the tag (or digest) of the container's image.
+type ServiceOverride struct {
+	ImageTag      string                  `json:"image_tag,omitempty"`
+	Environment   []string                `json:"environment,omitempty"`
+	Binds         []string                `json:"binds,omitempty"`
+	Ports         []containermessage.Port `json:"ports,omitempty"`
+	SpecificPorts []docker.PortBinding    `json:"specific_ports,omitempty"`
+}
+
+type DeploymentOverrides struct {
+	Services map[string]ServiceOverride `json:"services"`
+}
+
+// Sort of like a constructor, it creates an in memory object from the overrides file. If no file is specified, the
+// default overrides file in the project is used when it exists. Returns nil when there is nothing to override.
+func GetDeploymentOverrides(homeDirectory string, overridesFile string) (*DeploymentOverrides, error) {
+
+	filePath := filepath.Join(homeDirectory, OVERRIDES_FILE)
+	if overridesFile != "" {
+		var err error
+		if filePath, err = filepath.Abs(overridesFile); err != nil {
+			return nil, err
+		}
+	} else if exists, err := FileExists(homeDirectory, OVERRIDES_FILE); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, nil
+	}
+
+	overrides := new(DeploymentOverrides)
+	if err := GetFile(filepath.Dir(filePath), filepath.Base(filePath), overrides); err != nil {
+		return nil, err
+	}
+
+	cliutils.Verbose("Using deployment overrides from %v", filePath)
+	return overrides, nil
+}
+
+// Merge the overrides into the deployment config. The deployment config is modified by this function.
+func (d *DeploymentOverrides) Apply(dc *cliexchange.DeploymentConfig) error {
+	if d == nil || dc == nil {
+		return nil
+	}
+
+	for name, o := range d.Services {
+		svc, ok := dc.Services[name]
+		if !ok || svc == nil {
+			return errors.New(fmt.Sprintf("overrides container %v is not in the deployment config", name))
+		}
+
+		if o.ImageTag != "" {
+			svc.Image = replaceImageTag(svc.Image, o.ImageTag)
+		}
+		for _, env := range o.Environment {
+			svc.Environment = mergeEntry(svc.Environment, env, envVarName)
+		}
+		for _, bind := range o.Binds {
+			svc.Binds = mergeEntry(svc.Binds, bind, bindContainerPath)
+		}
+		if len(o.Ports) != 0 {
+			svc.Ports = o.Ports
+		}
+		if len(o.SpecificPorts) != 0 {
+			svc.SpecificPorts = o.SpecificPorts
+		}
+	}
+	return nil
+}
+
+// Read the overrides for the project and merge them into the deployment config.
+func applyDeploymentOverrides(dir string, overridesFile string, dc *cliexchange.DeploymentConfig) error {
+	if overrides, err := GetDeploymentOverrides(dir, overridesFile); err != nil {
+		return err
+	} else if err := overrides.Apply(dc); err != nil {
+		return errors.New(fmt.Sprintf("unable to apply deployment overrides, %v", err))
+	}
+	return nil
+}
+
+// Replace the entry with the same key, or add the entry when there is none.
+func mergeEntry(entries []string, entry string, key func(string) string) []string {
+	for ix, e := range entries {
+		if key(e) == key(entry) {
+			entries[ix] = entry
+			return entries
+		}
+	}
+	return append(entries, entry)
+}
+
+// Environment variables are in the form name=value.
+func envVarName(env string) string {
+	return strings.SplitN(env, "=", 2)[0]
+}
+
+// Binds are in the form host_path:container_path[:options].
+func bindContainerPath(bind string) string {
+	if parts := strings.Split(bind, ":"); len(parts) > 1 {
+		return parts[1]
+	}
+	return bind
+}
+
+// Image references are in the form [registry[:port]/]name[:tag][@digest].
+func replaceImageTag(image string, tag string) string {
+	if ix := strings.Index(image, "@"); ix != -1 {
+		image = image[:ix]
+	}
+	if ix := strings.LastIndex(image, ":"); ix > strings.LastIndex(image, "/") {
+		image = image[:ix]
+	}
+	return image + ":" + tag
+}
@@ -0,0 +1,124 @@
+// +build unit
+
+package dev
+
+import (
+	"github.com/open-horizon/anax/cli/cliutils"
+	cliexchange "github.com/open-horizon/anax/cli/exchange"
+	"github.com/open-horizon/anax/containermessage"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_replaceImageTag(t *testing.T) {
+
+	tests := map[string]string{
+		"myimage":                              "myimage:dev",
+		"myimage:1.0":                          "myimage:dev",
+		"myregistry:5000/myorg/myimage":        "myregistry:5000/myorg/myimage:dev",
+		"myregistry:5000/myorg/myimage:1.0":    "myregistry:5000/myorg/myimage:dev",
+		"myorg/myimage:1.0@sha256:0123456789a": "myorg/myimage:dev",
+	}
+
+	for image, expected := range tests {
+		if newImage := replaceImageTag(image, "dev"); newImage != expected {
+			t.Errorf("image %v should become %v, was %v", image, expected, newImage)
+		}
+	}
+}
+
+func Test_DeploymentOverrides_Apply(t *testing.T) {
+
+	dc := &cliexchange.DeploymentConfig{
+		Services: map[string]*containermessage.Service{
+			"netspeed": &containermessage.Service{
+				Image:       "myorg/netspeed:1.0",
+				Environment: []string{"MODE=prod", "LEVEL=1"},
+				Binds:       []string{"/var/data:/data:ro"},
+				Ports:       []containermessage.Port{containermessage.Port{PortAndProtocol: "8080/tcp"}},
+			},
+			"other": &containermessage.Service{
+				Image: "myorg/other:1.0",
+			},
+		},
+	}
+
+	overrides := &DeploymentOverrides{
+		Services: map[string]ServiceOverride{
+			"netspeed": ServiceOverride{
+				ImageTag:    "dev",
+				Environment: []string{"MODE=mock", "MOCK_URL=http://localhost:9999"},
+				Binds:       []string{"/tmp/data:/data", "/tmp/cfg:/cfg"},
+				Ports:       []containermessage.Port{containermessage.Port{LocalhostOnly: true, PortAndProtocol: "9090/tcp"}},
+			},
+		},
+	}
+
+	if err := overrides.Apply(dc); err != nil {
+		t.Fatalf("unable to apply overrides: %v", err)
+	}
+
+	svc := dc.Services["netspeed"]
+	if svc.Image != "myorg/netspeed:dev" {
+		t.Errorf("wrong image %v", svc.Image)
+	} else if len(svc.Environment) != 3 || svc.Environment[0] != "MODE=mock" || svc.Environment[1] != "LEVEL=1" || svc.Environment[2] != "MOCK_URL=http://localhost:9999" {
+		t.Errorf("wrong environment %v", svc.Environment)
+	} else if len(svc.Binds) != 2 || svc.Binds[0] != "/tmp/data:/data" || svc.Binds[1] != "/tmp/cfg:/cfg" {
+		t.Errorf("wrong binds %v", svc.Binds)
+	} else if len(svc.Ports) != 1 || svc.Ports[0].PortAndProtocol != "9090/tcp" {
+		t.Errorf("wrong ports %v", svc.Ports)
+	} else if dc.Services["other"].Image != "myorg/other:1.0" {
+		t.Errorf("container without overrides should not change, image %v", dc.Services["other"].Image)
+	}
+
+	// A container that is not in the deployment config is an error.
+	overrides.Services["missing"] = ServiceOverride{ImageTag: "dev"}
+	if err := overrides.Apply(dc); err == nil {
+		t.Errorf("overrides for an unknown container should be rejected")
+	}
+
+	// No overrides is not an error.
+	var none *DeploymentOverrides
+	if err := none.Apply(dc); err != nil {
+		t.Errorf("nil overrides should not be an error: %v", err)
+	}
+}
+
+func Test_GetDeploymentOverrides(t *testing.T) {
+
+	verbose := false
+	cliutils.Opts.Verbose = &verbose
+
+	dir, err := ioutil.TempDir("", "overrides")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The default overrides file is optional.
+	if overrides, err := GetDeploymentOverrides(dir, ""); err != nil || overrides != nil {
+		t.Errorf("project without an overrides file should have no overrides, have %v, error %v", overrides, err)
+	}
+
+	content := []byte(`{"services":{"netspeed":{"image_tag":"dev","environment":["MODE=mock"]}}}`)
+	if err := ioutil.WriteFile(path.Join(dir, OVERRIDES_FILE), content, 0664); err != nil {
+		t.Fatalf("unable to write overrides file: %v", err)
+	} else if err := ioutil.WriteFile(path.Join(dir, "other.json"), []byte(`{"services":{"other":{"image_tag":"test"}}}`), 0664); err != nil {
+		t.Fatalf("unable to write overrides file: %v", err)
+	}
+
+	if overrides, err := GetDeploymentOverrides(dir, ""); err != nil {
+		t.Errorf("unable to read overrides: %v", err)
+	} else if o, ok := overrides.Services["netspeed"]; !ok || o.ImageTag != "dev" || len(o.Environment) != 1 {
+		t.Errorf("wrong overrides %v", overrides)
+	}
+
+	// An explicit overrides file is used instead of the default one.
+	if overrides, err := GetDeploymentOverrides(dir, path.Join(dir, "other.json")); err != nil {
+		t.Errorf("unable to read overrides: %v", err)
+	} else if _, ok := overrides.Services["netspeed"]; ok || overrides.Services["other"].ImageTag != "test" {
+		t.Errorf("wrong overrides %v", overrides)
+	}
+}
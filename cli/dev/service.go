@@ -5,6 +5,8 @@ import (
 	"github.com/open-horizon/anax/cli/cliutils"
 	"github.com/open-horizon/anax/cutil"
 	"os"
+	"path"
+	"strings"
 )
 
 // These constants define the hzn dev subcommands supported by this module.
@@ -15,8 +17,9 @@ const SERVICE_STOP_COMMAND = "stop"
 const SERVICE_VERIFY_COMMAND = "verify"
 const SERVICE_DEPLOY_COMMAND = "publish"
 
-// Create skeletal horizon metadata files to establish a new microservice project.
-func ServiceNew(homeDirectory string, org string) {
+// Create skeletal horizon metadata files to establish a new microservice project. With a template, a working example
+// service is created instead, with its source code, Dockerfile and Makefile.
+func ServiceNew(homeDirectory string, org string, templateName string) {
 
 	// Verify that env vars are set properly and determine the working directory.
 	dir, err := VerifyEnvironment(homeDirectory, false, false, "")
@@ -28,6 +31,10 @@ func ServiceNew(homeDirectory string, org string) {
 		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "'%v %v' must specify either --org or set the %v environment variable.", SERVICE_COMMAND, SERVICE_CREATION_COMMAND, DEVTOOL_HZN_ORG)
 	}
 
+	if templateName != "" && !IsServiceTemplate(templateName) {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "'%v %v' template %v is not supported, must be one of: %v", SERVICE_COMMAND, SERVICE_CREATION_COMMAND, templateName, strings.Join(ServiceTemplateNames(), ", "))
+	}
+
 	// Create the working directory.
 	if err := CreateWorkingDir(dir); err != nil {
 		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "'%v %v' %v", SERVICE_COMMAND, SERVICE_CREATION_COMMAND, err)
@@ -43,6 +50,14 @@ func ServiceNew(homeDirectory string, org string) {
 	}
 
 	// Create the metadata files.
+	if templateName != "" {
+		if err := CreateServiceFromTemplate(dir, org, templateName); err != nil {
+			cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "'%v %v' %v", SERVICE_COMMAND, SERVICE_CREATION_COMMAND, err)
+		}
+		fmt.Printf("Created a %v %v project in %v, with its horizon metadata files in %v. Run 'make run' in %v to build and start it.\n", templateName, SERVICE_COMMAND, path.Dir(dir), dir, path.Dir(dir))
+		return
+	}
+
 	if err := CreateUserInputs(dir, false, true, org); err != nil {
 		cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, "'%v %v' %v", SERVICE_COMMAND, SERVICE_CREATION_COMMAND, err)
 	} else if err := CreateServiceDefinition(dir, org); err != nil {
@@ -52,7 +67,7 @@ func ServiceNew(homeDirectory string, org string) {
 	fmt.Printf("Created horizon metadata files in %v. Edit these files to define and configure your new %v.\n", dir, SERVICE_COMMAND)
 }
 
-func ServiceStartTest(homeDirectory string, userInputFile string) {
+func ServiceStartTest(homeDirectory string, userInputFile string, overridesFile string) {
 
 	// Run verification before trying to start anything.
 	ServiceValidate(homeDirectory, userInputFile)
@@ -73,6 +88,11 @@ func ServiceStartTest(homeDirectory string, userInputFile string) {
 		cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, "'%v %v' unable to get service dependencies, %v", SERVICE_COMMAND, SERVICE_START_COMMAND, derr)
 	}
 
+	// Every required service has to be started along with the service.
+	if err := verifyServiceDependencies(deps, serviceDef.RequiredServices); err != nil {
+		cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, "'%v %v' service %v", SERVICE_COMMAND, SERVICE_START_COMMAND, err)
+	}
+
 	// Log the starting of dependencies if there are any.
 	if len(deps) != 0 {
 		cliutils.Verbose("Starting dependencies.")
@@ -90,6 +110,11 @@ func ServiceStartTest(homeDirectory string, userInputFile string) {
 		cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, "'%v %v' %v", SERVICE_COMMAND, SERVICE_START_COMMAND, cerr)
 	}
 
+	// Merge in the local overrides, if there are any. These are never part of the published definition.
+	if err := applyDeploymentOverrides(dir, overridesFile, dc); err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "'%v %v' %v", SERVICE_COMMAND, SERVICE_START_COMMAND, err)
+	}
+
 	// Generate an agreement id for testing purposes.
 	agreementId, aerr := cutil.GenerateAgreementId()
 	if aerr != nil {
@@ -96,6 +96,7 @@ func ValidateServiceDefinition(directory string, fileName string) error {
 		if err := depConfig.CanStartStop(); err != nil {
 			return errors.New(fmt.Sprintf("%v: deployment configuration, %v", filePath, err))
 		}
+		warnMissingDevices(filePath, depConfig)
 		for ix, ui := range sDef.UserInputs {
 			if (ui.Name != "" && ui.Type == "") || (ui.Name == "" && (ui.Type != "" || ui.DefaultValue != "")) {
 				return errors.New(fmt.Sprintf("%v: userInput array index %v does not have name and type specified.", filePath, ix))
@@ -0,0 +1,305 @@
+package dev
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	cliexchange "github.com/open-horizon/anax/cli/exchange"
+	"github.com/open-horizon/anax/cli/register"
+	"github.com/open-horizon/anax/containermessage"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// A service project created from a template is a working example: the horizon metadata files are filled in for a
+// small service that logs a greeting, and the source code, Dockerfile and Makefile of the service are created in the
+// parent directory of the horizon metadata directory.
+const TEMPLATE_GO = "go"
+const TEMPLATE_PYTHON = "python"
+const TEMPLATE_NODE = "node"
+
+const DEFAULT_TEMPLATE_SERVICE_NAME = "myservice"
+const DEFAULT_TEMPLATE_VERSION = "1.0.0"
+
+// The values that are filled into the files of a template.
+type templateValues struct {
+	Name    string // the service name, also the container name
+	Org     string
+	Version string
+	Arch    string
+	Image   string // the docker image built by the Makefile and deployed by the service definition
+	Dir     string // the name of the horizon metadata directory, relative to the project directory
+}
+
+// The source files of each template, by file name. The Makefile is the same for all the templates.
+var serviceTemplates = map[string]map[string]string{
+	TEMPLATE_GO: {
+		"main.go":    goMainTemplate,
+		"Dockerfile": goDockerfileTemplate,
+	},
+	TEMPLATE_PYTHON: {
+		"service.py": pythonServiceTemplate,
+		"Dockerfile": pythonDockerfileTemplate,
+	},
+	TEMPLATE_NODE: {
+		"service.js":   nodeServiceTemplate,
+		"package.json": nodePackageTemplate,
+		"Dockerfile":   nodeDockerfileTemplate,
+	},
+}
+
+// Returns the names of the templates, for messages.
+func ServiceTemplateNames() []string {
+	names := make([]string, 0, len(serviceTemplates))
+	for name, _ := range serviceTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func IsServiceTemplate(templateName string) bool {
+	_, ok := serviceTemplates[templateName]
+	return ok
+}
+
+// The service is named after the project directory, in the form docker accepts for container and image names.
+func templateServiceName(projectDir string) string {
+	name := strings.ToLower(path.Base(projectDir))
+	name = regexp.MustCompile(`[^a-z0-9]+`).ReplaceAllString(name, "-")
+	name = strings.Trim(name, "-")
+	if name == "" {
+		return DEFAULT_TEMPLATE_SERVICE_NAME
+	}
+	return name
+}
+
+// Create the horizon metadata files in metadataDir and the source files of the template in its parent directory. None
+// of the files may exist already, so nothing is written unless the whole project can be created.
+func CreateServiceFromTemplate(metadataDir string, org string, templateName string) error {
+
+	files, ok := serviceTemplates[templateName]
+	if !ok {
+		return errors.New(fmt.Sprintf("template %v is not supported, must be one of: %v", templateName, strings.Join(ServiceTemplateNames(), ", ")))
+	}
+
+	projectDir := path.Dir(metadataDir)
+	name := templateServiceName(projectDir)
+	values := templateValues{
+		Name:    name,
+		Org:     org,
+		Version: DEFAULT_TEMPLATE_VERSION,
+		Arch:    cutil.ArchString(),
+		Image:   fmt.Sprintf("%v/%v_%v", strings.ToLower(org), name, cutil.ArchString()),
+		Dir:     path.Base(metadataDir),
+	}
+
+	// Render all the files before writing any of them.
+	rendered := map[string]string{}
+	for fileName, text := range files {
+		if content, err := renderTemplate(fileName, text, values); err != nil {
+			return err
+		} else {
+			rendered[fileName] = content
+		}
+	}
+	if content, err := renderTemplate("Makefile", makefileTemplate, values); err != nil {
+		return err
+	} else {
+		rendered["Makefile"] = content
+	}
+
+	for fileName, _ := range rendered {
+		if exists, err := FileExists(projectDir, fileName); err != nil {
+			return err
+		} else if exists {
+			return errors.New(fmt.Sprintf("project directory %v already contains %v.", projectDir, fileName))
+		}
+	}
+
+	if err := createTemplateUserInputs(metadataDir, values); err != nil {
+		return err
+	} else if err := createTemplateServiceDefinition(metadataDir, values); err != nil {
+		return err
+	}
+
+	for fileName, content := range rendered {
+		filePath := path.Join(projectDir, fileName)
+		if err := ioutil.WriteFile(filePath, []byte(content), 0664); err != nil {
+			return errors.New(fmt.Sprintf("unable to write %v, error: %v", filePath, err))
+		}
+	}
+	return nil
+}
+
+func renderTemplate(fileName string, text string, values templateValues) (string, error) {
+	buf := new(bytes.Buffer)
+	if t, err := template.New(fileName).Parse(text); err != nil {
+		return "", errors.New(fmt.Sprintf("unable to parse the template of %v, error: %v", fileName, err))
+	} else if err := t.Execute(buf, values); err != nil {
+		return "", errors.New(fmt.Sprintf("unable to fill in the template of %v, error: %v", fileName, err))
+	}
+	return buf.String(), nil
+}
+
+// The service definition of the template, complete enough to be verified and started right away.
+func createTemplateServiceDefinition(directory string, values templateValues) error {
+
+	res := new(cliexchange.ServiceFile)
+	res.Label = values.Name
+	res.Description = fmt.Sprintf("The %v service, created from a template.", values.Name)
+	res.Public = true
+	res.URL = values.Name
+	res.Version = values.Version
+	res.Arch = values.Arch
+	res.Sharable = exchange.MS_SHARING_MODE_MULTIPLE
+	res.UserInputs = []exchange.UserInput{
+		exchange.UserInput{
+			Name:         "HW_WHO",
+			Label:        "Who to say hello to",
+			Type:         "string",
+			DefaultValue: "World",
+		},
+	}
+	res.MatchHardware = map[string]interface{}{}
+	res.RequiredServices = []exchange.ServiceDependency{}
+	res.Deployment = map[string]interface{}{
+		"services": map[string]*containermessage.Service{
+			values.Name: &containermessage.Service{
+				Image: fmt.Sprintf("%v:%v", values.Image, values.Version),
+			},
+		},
+	}
+	res.DeploymentSignature = ""
+	res.ImageStore = map[string]interface{}{}
+	res.Org = values.Org
+
+	return CreateFile(directory, SERVICE_DEFINITION_FILE, res)
+}
+
+// The user input file of the template sets the service's variable, so that running the service shows where the
+// values of the variables come from.
+func createTemplateUserInputs(directory string, values templateValues) error {
+
+	res := new(register.InputFile)
+	res.Global = []register.GlobalSet{}
+	res.Services = []register.MicroWork{
+		register.MicroWork{
+			Org:          values.Org,
+			Url:          values.Name,
+			VersionRange: "[0.0.0,INFINITY)",
+			Variables: map[string]interface{}{
+				"HW_WHO": "Horizon",
+			},
+		},
+	}
+
+	return CreateFile(directory, USERINPUT_FILE, res)
+}
+
+const makefileTemplate = `# Build, run and publish the {{.Name}} service. The Horizon metadata of the service is in the {{.Dir}} directory.
+
+SERVICE_NAME ?= {{.Name}}
+SERVICE_VERSION ?= {{.Version}}
+DOCKER_IMAGE ?= {{.Image}}:$(SERVICE_VERSION)
+
+# The signing key pair in the hzn keystore. Create it once with 'make key'.
+SIGNING_KEY ?= $(SERVICE_NAME)
+
+build:
+	docker build -t $(DOCKER_IMAGE) .
+
+verify:
+	hzn dev service verify -d {{.Dir}}
+
+run: build verify
+	hzn dev service start -d {{.Dir}}
+
+stop:
+	hzn dev service stop -d {{.Dir}}
+
+key:
+	hzn key create --name $(SIGNING_KEY) {{.Org}} $(USER)@{{.Org}}
+
+publish: build verify
+	hzn exchange service publish -f {{.Dir}}/service.definition.json -k $(SIGNING_KEY)
+
+.PHONY: build verify run stop key publish
+`
+
+const goMainTemplate = `// The {{.Name}} service logs a greeting every 10 seconds. HW_WHO is a user input of the service, HZN_DEVICE_ID is
+// set by the Horizon agent.
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+func main() {
+	who := os.Getenv("HW_WHO")
+	if who == "" {
+		who = "World"
+	}
+	for {
+		fmt.Printf("%v says: Hello %v!\n", os.Getenv("HZN_DEVICE_ID"), who)
+		time.Sleep(10 * time.Second)
+	}
+}
+`
+
+const goDockerfileTemplate = `FROM golang:alpine AS build
+WORKDIR /go/src/{{.Name}}
+COPY main.go .
+RUN CGO_ENABLED=0 go build -o /{{.Name}} main.go
+
+FROM alpine:latest
+COPY --from=build /{{.Name}} /usr/local/bin/{{.Name}}
+CMD ["/usr/local/bin/{{.Name}}"]
+`
+
+const pythonServiceTemplate = `# The {{.Name}} service logs a greeting every 10 seconds. HW_WHO is a user input of the service, HZN_DEVICE_ID is
+# set by the Horizon agent.
+import os
+import time
+
+who = os.environ.get("HW_WHO", "World")
+while True:
+    print("%s says: Hello %s!" % (os.environ.get("HZN_DEVICE_ID", ""), who))
+    time.sleep(10)
+`
+
+const pythonDockerfileTemplate = `FROM python:3-alpine
+COPY service.py /
+CMD ["python3", "-u", "/service.py"]
+`
+
+const nodeServiceTemplate = `// The {{.Name}} service logs a greeting every 10 seconds. HW_WHO is a user input of the service, HZN_DEVICE_ID is
+// set by the Horizon agent.
+const who = process.env.HW_WHO || "World";
+
+setInterval(() => {
+  console.log(` + "`${process.env.HZN_DEVICE_ID || \"\"} says: Hello ${who}!`" + `);
+}, 10000);
+`
+
+const nodePackageTemplate = `{
+  "name": "{{.Name}}",
+  "version": "{{.Version}}",
+  "private": true,
+  "main": "service.js"
+}
+`
+
+const nodeDockerfileTemplate = `FROM node:alpine
+WORKDIR /app
+COPY package.json service.js ./
+CMD ["node", "service.js"]
+`
@@ -0,0 +1,77 @@
+// +build unit
+
+package dev
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func Test_templateServiceName(t *testing.T) {
+
+	tests := map[string]string{
+		"/home/me/netspeed":       "netspeed",
+		"/home/me/My Service_v2":  "my-service-v2",
+		"/home/me/--cpu.reader--": "cpu-reader",
+		"/":                       DEFAULT_TEMPLATE_SERVICE_NAME,
+	}
+
+	for dir, expected := range tests {
+		if name := templateServiceName(dir); name != expected {
+			t.Errorf("service in %v should be named %v, was %v", dir, expected, name)
+		}
+	}
+}
+
+func Test_CreateServiceFromTemplate(t *testing.T) {
+
+	tmp, err := ioutil.TempDir("", "hzn-dev-template")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	projectDir := path.Join(tmp, "Hello World")
+	metadataDir := path.Join(projectDir, DEFAULT_WORKING_DIR)
+	if err := os.MkdirAll(metadataDir, 0755); err != nil {
+		t.Fatalf("unable to create project dir: %v", err)
+	}
+
+	if err := CreateServiceFromTemplate(metadataDir, "MyOrg", "cobol"); err == nil {
+		t.Errorf("an unknown template should be refused")
+	}
+
+	if err := CreateServiceFromTemplate(metadataDir, "MyOrg", TEMPLATE_NODE); err != nil {
+		t.Fatalf("unable to create service from template: %v", err)
+	}
+
+	// The metadata of the template is complete, and the Makefile builds the image that the definition deploys.
+	for _, fileName := range []string{"Makefile", "Dockerfile", "service.js", "package.json"} {
+		if exists, _ := FileExists(projectDir, fileName); !exists {
+			t.Errorf("template should create %v", fileName)
+		}
+	}
+	if err := ValidateServiceDefinition(metadataDir, SERVICE_DEFINITION_FILE); err != nil {
+		t.Errorf("the service definition of the template should be valid: %v", err)
+	} else if sDef, err := GetServiceDefinition(metadataDir, SERVICE_DEFINITION_FILE); err != nil {
+		t.Errorf("unable to read the service definition: %v", err)
+	} else if sDef.URL != "hello-world" || sDef.Org != "MyOrg" {
+		t.Errorf("wrong service definition %v", sDef)
+	} else if makefile, err := ioutil.ReadFile(path.Join(projectDir, "Makefile")); err != nil {
+		t.Errorf("unable to read the Makefile: %v", err)
+	} else if !strings.Contains(string(makefile), "DOCKER_IMAGE ?= myorg/hello-world_") || !strings.Contains(string(makefile), "\thzn dev service start -d horizon\n") {
+		t.Errorf("wrong Makefile:\n%v", string(makefile))
+	}
+
+	// A project is never created over an existing one.
+	os.Remove(path.Join(metadataDir, SERVICE_DEFINITION_FILE))
+	os.Remove(path.Join(metadataDir, USERINPUT_FILE))
+	if err := CreateServiceFromTemplate(metadataDir, "MyOrg", TEMPLATE_GO); err == nil {
+		t.Errorf("a template should not be created over the files of another project")
+	} else if exists, _ := FileExists(metadataDir, SERVICE_DEFINITION_FILE); exists {
+		t.Errorf("no files should be written when the project can't be created")
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"github.com/open-horizon/anax/torrent"
 	"github.com/satori/go.uuid"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -223,6 +224,14 @@ func AbstractServiceValidation(dir string, serviceExpected bool) error {
 	return nil
 }
 
+// A node that is missing the devices a deployment configuration needs rejects the agreement, and the containers can not
+// be started on this host either. This host is not necessarily the kind of node the project is for, so it is only a warning.
+func warnMissingDevices(filePath string, depConfig *cliexchange.DeploymentConfig) {
+	if missing := depConfig.MissingDevices(); len(missing) != 0 {
+		cliutils.Warning("%v: the deployment configuration needs devices %v, which are missing from this host. A node without them rejects agreements for it.", filePath, strings.Join(missing, ", "))
+	}
+}
+
 // Sort of like a constructor, it creates an in memory object except that it is created from either a microservice or a service
 // definition config file in the current project. This function assumes the caller has determined the exact location of the file.
 // This function also assumes that the project pointed to by the directory parameter is assuemd to contain the kind of definition
@@ -502,6 +511,8 @@ func startDependent(dir string,
 
 		if deps, err := GetServiceDependencies(dir, serviceDef.RequiredServices); err != nil {
 			return nil, errors.New(fmt.Sprintf("unable to retrieve dependency metadata: %v", err))
+		} else if err := verifyServiceDependencies(deps, serviceDef.RequiredServices); err != nil {
+			return nil, errors.New(fmt.Sprintf("dependency %v/%v %v", serviceDef.Org, serviceDef.URL, err))
 			// Start this service's dependencies
 		} else if msn, err := processStartDependencies(dir, deps, globals, configUserInputs, cw); err != nil {
 			return nil, errors.New(fmt.Sprintf("unable to start dependencies: %v", err))
@@ -597,6 +608,11 @@ func startContainers(deployment *containermessage.DeploymentDescription,
 
 	cliutils.Verbose("Passing environment variables: %v", environmentAdditions)
 
+	// The images were pulled when the dependency was fetched, but they might have been removed from docker since then.
+	if err := pullMissingImages(deployment, globals, cw); err != nil {
+		return nil, err
+	}
+
 	// Start the dependency microservice
 
 	fmt.Printf("Start %v: %v with instance id prefix %v\n", logName, dc.CLIString(), id)
@@ -613,6 +629,68 @@ func startContainers(deployment *containermessage.DeploymentDescription,
 	return getContainerNetworks(dc, cw)
 }
 
+// Make sure that every required service has a dependency definition in the project, in a version within the required
+// range. Otherwise the required service would silently be left out of the services that get started.
+func verifyServiceDependencies(deps []*cliexchange.ServiceFile, required []exchange.ServiceDependency) error {
+
+	for _, rs := range required {
+		versionRange := rs.Version
+		if versionRange == "" {
+			versionRange = "0.0.0"
+		}
+		vExp, err := policy.Version_Expression_Factory(versionRange)
+		if err != nil {
+			return errors.New(fmt.Sprintf("has required service %v/%v with an invalid version %v, %v", rs.Org, rs.URL, rs.Version, err))
+		}
+
+		found := false
+		for _, dep := range deps {
+			if dep.URL != rs.URL || dep.Org != rs.Org {
+				continue
+			} else if inRange, err := vExp.Is_within_range(dep.Version); err != nil {
+				return errors.New(fmt.Sprintf("unable to verify that dependency %v/%v version %v is within %v, %v", dep.Org, dep.URL, dep.Version, versionRange, err))
+			} else if inRange {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return errors.New(fmt.Sprintf("requires service %v/%v version %v, which is not a dependency of this project. Use 'hzn dev dependency fetch' to add it.", rs.Org, rs.URL, versionRange))
+		}
+	}
+	return nil
+}
+
+// Pull the images in the deployment that are not in the local docker server.
+func pullMissingImages(deployment *containermessage.DeploymentDescription, globals []register.GlobalSet, cw *container.ContainerWorker) error {
+
+	missing := containermessage.DeploymentDescription{Services: make(map[string]*containermessage.Service)}
+	for name, svc := range deployment.Services {
+		if _, err := cw.GetClient().InspectImage(svc.Image); err == docker.ErrNoSuchImage {
+			missing.Services[name] = svc
+		} else if err != nil {
+			return errors.New(fmt.Sprintf("unable to inspect image %v, %v", svc.Image, err))
+		}
+	}
+
+	if len(missing.Services) == 0 {
+		return nil
+	}
+
+	deploymentBytes, err := json.Marshal(missing)
+	if err != nil {
+		return errors.New(fmt.Sprintf("unable to marshal deployment %v, %v", missing, err))
+	}
+
+	// An empty image server URL means the images are pulled from their docker registries.
+	cc := events.NewContainerConfig(url.URL{}, "", string(deploymentBytes), "", "", "", make([]events.ImageDockerAuth, 0))
+	if err := getContainerImages(cc, []string{}, &register.InputFile{Global: globals}); err != nil {
+		return errors.New(fmt.Sprintf("unable to pull missing images, %v", err))
+	}
+	return nil
+}
+
 func processStopDependencies(dir string, deps []*cliexchange.ServiceFile, cw *container.ContainerWorker) error {
 
 	// Log the stopping of dependencies if there are any.
@@ -3,6 +3,8 @@
 package dev
 
 import (
+	cliexchange "github.com/open-horizon/anax/cli/exchange"
+	"github.com/open-horizon/anax/exchange"
 	"os"
 	"path"
 	"strings"
@@ -120,3 +122,44 @@ func Test_workingdir_bad_dashd_rel(t *testing.T) {
 	}
 
 }
+
+// Every required service needs a dependency with the same org and url, in a version within the required range.
+func Test_verifyServiceDependencies(t *testing.T) {
+
+	deps := []*cliexchange.ServiceFile{
+		&cliexchange.ServiceFile{Org: "myorg", URL: "http://mydomain.com/gps", Version: "1.2.0"},
+		&cliexchange.ServiceFile{Org: "myorg", URL: "http://mydomain.com/cpu", Version: "2.0.0"},
+	}
+
+	required := []exchange.ServiceDependency{
+		exchange.ServiceDependency{Org: "myorg", URL: "http://mydomain.com/gps", Version: "1.0.0"},
+		exchange.ServiceDependency{Org: "myorg", URL: "http://mydomain.com/cpu", Version: ""},
+	}
+
+	if err := verifyServiceDependencies(deps, required); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if err := verifyServiceDependencies(deps, []exchange.ServiceDependency{}); err != nil {
+		t.Errorf("unexpected error for no required services %v", err)
+	}
+
+	missing := append(required, exchange.ServiceDependency{Org: "myorg", URL: "http://mydomain.com/net", Version: "1.0.0"})
+	if err := verifyServiceDependencies(deps, missing); err == nil {
+		t.Errorf("expected error for missing dependency")
+	} else if !strings.Contains(err.Error(), "http://mydomain.com/net") {
+		t.Errorf("error should name the missing service, was %v", err)
+	}
+
+	outOfRange := []exchange.ServiceDependency{
+		exchange.ServiceDependency{Org: "myorg", URL: "http://mydomain.com/cpu", Version: "[1.0.0,2.0.0)"},
+	}
+	if err := verifyServiceDependencies(deps, outOfRange); err == nil {
+		t.Errorf("expected error for dependency version out of range")
+	}
+
+	otherOrg := []exchange.ServiceDependency{
+		exchange.ServiceDependency{Org: "otherorg", URL: "http://mydomain.com/gps", Version: "1.0.0"},
+	}
+	if err := verifyServiceDependencies(deps, otherOrg); err == nil {
+		t.Errorf("expected error for dependency in a different org")
+	}
+}
@@ -61,7 +61,7 @@ func WorkloadNew(homeDirectory string, org string) {
 
 }
 
-func WorkloadStartTest(homeDirectory string, userInputFile string) {
+func WorkloadStartTest(homeDirectory string, userInputFile string, overridesFile string) {
 
 	// Run verification before trying to start anything.
 	WorkloadValidate(homeDirectory, userInputFile)
@@ -152,6 +152,11 @@ func WorkloadStartTest(homeDirectory string, userInputFile string) {
 		cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, "'%v %v' %v", WORKLOAD_COMMAND, WORKLOAD_START_COMMAND, cerr)
 	}
 
+	// Merge in the local overrides, if there are any. These are never part of the published definition.
+	if err := applyDeploymentOverrides(dir, overridesFile, dc); err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "'%v %v' %v", WORKLOAD_COMMAND, WORKLOAD_START_COMMAND, err)
+	}
+
 	fmt.Printf("Starting workload: %v in agreement id %v\n", dc.CLIString(), agreementId)
 
 	// Start the workload container image
@@ -250,7 +255,7 @@ func WorkloadValidate(homeDirectory string, userInputFile string) {
 	fmt.Printf("Workload project %v verified.\n", dir)
 }
 
-func WorkloadDeploy(homeDirectory string, keyFile string, pubKeyFilePath string, userCreds string, dontTouchImage bool) {
+func WorkloadDeploy(homeDirectory string, keyFile string, pubKeyFilePath string, userCreds string, dontTouchImage bool, strict bool) {
 
 	// Validate the inputs
 	if keyFile == "" {
@@ -291,7 +296,7 @@ func WorkloadDeploy(homeDirectory string, keyFile string, pubKeyFilePath string,
 	cliutils.SetWhetherUsingApiKey(userCreds)
 
 	// Invoke the re-usable part of hzn exchange workload publish to actually do the publish.
-	workloadDef.SignAndPublish(workloadDef.Org, userCreds, keyFile, pubKeyFilePath, dontTouchImage)
+	workloadDef.SignAndPublish(workloadDef.Org, userCreds, keyFile, pubKeyFilePath, dontTouchImage, strict)
 
 	fmt.Printf("Workload project %v deployed.\n", dir)
 }
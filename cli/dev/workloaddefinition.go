@@ -101,6 +101,7 @@ func ValidateWorkloadDefinition(directory string) error {
 			} else if err := depConfig.CanStartStop(); err != nil {
 				return errors.New(fmt.Sprintf("%v: Workloads index %v %v", filePath, ix, err))
 			}
+			warnMissingDevices(filePath, depConfig)
 		}
 		for ix, ui := range workloadDef.UserInputs {
 			if (ui.Name != "" && ui.Type == "") || (ui.Name == "" && (ui.Type != "" || ui.DefaultValue != "")) {
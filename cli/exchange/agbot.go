@@ -88,3 +88,46 @@ func AgbotRemovePattern(org, userPw, agbot, patternOrg, pattern string) {
 	patternId := formPatternId(patternOrg, pattern)
 	cliutils.ExchangeDelete(cliutils.GetExchangeUrl(), "orgs/"+org+"/agbots/"+agbot+"/patterns/"+patternId, cliutils.OrgAndCreds(org, userPw), []int{204})
 }
+
+func formBusinessPolId(polOrg, pol, nodeOrg string) string {
+	return polOrg + "_" + pol + "_" + nodeOrg
+}
+
+type ExchangeAgbotBusinessPols struct {
+	BusinessPols map[string]interface{} `json:"businessPols"`
+}
+
+func AgbotListBusinessPolicy(org, userPw, agbot string) {
+	cliutils.SetWhetherUsingApiKey(userPw)
+	org, agbot = cliutils.TrimOrg(org, agbot)
+	var pols ExchangeAgbotBusinessPols
+	cliutils.ExchangeGet(cliutils.GetExchangeUrl(), "orgs/"+org+"/agbots/"+agbot+"/businesspols", cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &pols)
+	output := cliutils.MarshalIndent(pols.BusinessPols, "exchange agbot listbusinesspol")
+	fmt.Println(output)
+}
+
+type ServedBusinessPolicy struct {
+	BusinessPolOrg string `json:"businessPolOrgid"`
+	BusinessPol    string `json:"businessPol"`
+	NodeOrg        string `json:"nodeOrgid"`
+}
+
+// Serve the business policy for the nodes in nodeOrg, which defaults to the org of the business policy.
+func AgbotAddBusinessPolicy(org, userPw, agbot, polOrg, pol, nodeOrg string) {
+	cliutils.SetWhetherUsingApiKey(userPw)
+	org, agbot = cliutils.TrimOrg(org, agbot)
+	if nodeOrg == "" {
+		nodeOrg = polOrg
+	}
+	input := ServedBusinessPolicy{BusinessPolOrg: polOrg, BusinessPol: pol, NodeOrg: nodeOrg}
+	cliutils.ExchangePutPost(http.MethodPut, cliutils.GetExchangeUrl(), "orgs/"+org+"/agbots/"+agbot+"/businesspols/"+formBusinessPolId(polOrg, pol, nodeOrg), cliutils.OrgAndCreds(org, userPw), []int{201}, input)
+}
+
+func AgbotRemoveBusinessPolicy(org, userPw, agbot, polOrg, pol, nodeOrg string) {
+	cliutils.SetWhetherUsingApiKey(userPw)
+	org, agbot = cliutils.TrimOrg(org, agbot)
+	if nodeOrg == "" {
+		nodeOrg = polOrg
+	}
+	cliutils.ExchangeDelete(cliutils.GetExchangeUrl(), "orgs/"+org+"/agbots/"+agbot+"/businesspols/"+formBusinessPolId(polOrg, pol, nodeOrg), cliutils.OrgAndCreds(org, userPw), []int{204})
+}
@@ -0,0 +1,94 @@
+package exchange
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/policy"
+	"net/http"
+)
+
+// We only care about handling the business policy names, so the rest is left as interface{} and will be passed from the exchange to the display
+type ExchangeBusinessPolicies struct {
+	LastIndex      int                    `json:"lastIndex"`
+	BusinessPolicy map[string]interface{} `json:"businessPolicy"`
+}
+
+// The input file of 'hzn exchange business addpolicy'. The owner and the timestamps are set by the exchange.
+type BusinessPolicyInput struct {
+	Label              string                   `json:"label"`
+	Description        string                   `json:"description"`
+	Service            exchange.BusinessService `json:"service"`
+	Properties         policy.PropertyList      `json:"properties,omitempty"`         // the properties the agbot advertises to the node
+	Constraints        policy.RequiredProperty  `json:"constraints,omitempty"`        // the node properties required to run the service
+	RequireAttestation bool                     `json:"requireAttestation,omitempty"` // match the constraints with the properties the node signed
+}
+
+func BusinessListPolicy(org string, userPw string, policyName string, namesOnly bool) {
+	cliutils.SetWhetherUsingApiKey(userPw)
+	org, policyName = cliutils.TrimOrg(org, policyName)
+	var pols ExchangeBusinessPolicies
+	httpCode := cliutils.ExchangeGet(cliutils.GetExchangeUrl(), "orgs/"+org+"/business/policies"+cliutils.AddSlash(policyName), cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &pols)
+	if httpCode == 404 && policyName != "" {
+		cliutils.Fatal(cliutils.NOT_FOUND, "business policy '%s' not found in org %s", policyName, org)
+	}
+	if namesOnly && policyName == "" {
+		// Only display the names
+		names := []string{}
+		for p := range pols.BusinessPolicy {
+			names = append(names, p)
+		}
+		jsonBytes, err := json.MarshalIndent(names, "", cliutils.JSON_INDENT)
+		if err != nil {
+			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to marshal 'exchange business listpolicy' output: %v", err)
+		}
+		fmt.Printf("%s\n", jsonBytes)
+	} else {
+		// Display the full resources
+		output := cliutils.MarshalIndent(pols.BusinessPolicy, "exchange business listpolicy")
+		fmt.Println(output)
+	}
+}
+
+// Create or update a business policy. The business policy is converted to a policy the same way the agbot does it,
+// so that a business policy the agbot can't use is rejected here.
+func BusinessAddPolicy(org string, userPw string, policyName string, jsonFilePath string) {
+	cliutils.SetWhetherUsingApiKey(userPw)
+	org, policyName = cliutils.TrimOrg(org, policyName)
+
+	newBytes := cliutils.ReadJsonFile(jsonFilePath)
+	var polInput BusinessPolicyInput
+	if err := json.Unmarshal(newBytes, &polInput); err != nil {
+		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to unmarshal json input file %s: %v", jsonFilePath, err)
+	}
+	bp := exchange.BusinessPolicy{Label: polInput.Label, Description: polInput.Description, Service: polInput.Service, Properties: polInput.Properties, Constraints: polInput.Constraints, RequireAttestation: polInput.RequireAttestation}
+	if _, err := exchange.ConvertBusinessPolicyToPolicy(cliutils.OrgAndCreds(org, policyName), &bp); err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "invalid business policy in %s: %v", jsonFilePath, err)
+	}
+
+	var output string
+	httpCode := cliutils.ExchangeGet(cliutils.GetExchangeUrl(), "orgs/"+org+"/business/policies/"+policyName, cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &output)
+	if httpCode == 200 {
+		// Business policy exists, update it
+		fmt.Printf("Updating business policy %s in the exchange...\n", policyName)
+		cliutils.ExchangePutPost(http.MethodPut, cliutils.GetExchangeUrl(), "orgs/"+org+"/business/policies/"+policyName, cliutils.OrgAndCreds(org, userPw), []int{201}, polInput)
+	} else {
+		// Business policy not there, create it
+		fmt.Printf("Creating business policy %s in the exchange...\n", policyName)
+		cliutils.ExchangePutPost(http.MethodPost, cliutils.GetExchangeUrl(), "orgs/"+org+"/business/policies/"+policyName, cliutils.OrgAndCreds(org, userPw), []int{201}, polInput)
+	}
+}
+
+func BusinessRemovePolicy(org string, userPw string, policyName string, force bool) {
+	cliutils.SetWhetherUsingApiKey(userPw)
+	org, policyName = cliutils.TrimOrg(org, policyName)
+	if !force {
+		cliutils.ConfirmRemove("Are you sure you want to remove business policy '" + org + "/" + policyName + "' from the Horizon Exchange?")
+	}
+
+	httpCode := cliutils.ExchangeDelete(cliutils.GetExchangeUrl(), "orgs/"+org+"/business/policies/"+policyName, cliutils.OrgAndCreds(org, userPw), []int{204, 404})
+	if httpCode == 404 {
+		cliutils.Fatal(cliutils.NOT_FOUND, "business policy '%s' not found in org %s", policyName, org)
+	}
+}
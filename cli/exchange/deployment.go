@@ -0,0 +1,252 @@
+package exchange
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/policy"
+)
+
+// The result of checking whether one version of a workload or service in a pattern can be deployed to a node.
+type DeploymentCheckResult struct {
+	Org        string   `json:"org"`
+	Url        string   `json:"url"`
+	Version    string   `json:"version"`
+	Arch       string   `json:"arch"`
+	Deployable bool     `json:"deployable"`
+	Reasons    []string `json:"reasons,omitempty"`  // why the agbot would not make an agreement for this version
+	Warnings   []string `json:"warnings,omitempty"` // things that could not be checked from the exchange
+}
+
+// The parts of the registration input file (see 'hzn register -f') that are needed to check the user input.
+type deploymentCheckInput struct {
+	Org       string                 `json:"org"`
+	Url       string                 `json:"url"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+type deploymentCheckInputFile struct {
+	Services  []deploymentCheckInput `json:"services,omitempty"`
+	Workloads []deploymentCheckInput `json:"workloads,omitempty"`
+}
+
+// The definition of a workload or service version, reduced to what the deployment check needs.
+type deploymentCheckDefinition struct {
+	apiSpecs   *policy.APISpecList
+	userInputs []exchange.UserInput
+}
+
+// DeploymentCheck walks through the same checks the agbot makes before it proposes an agreement to a node, for every
+// workload (or service) version in the pattern, and displays which of them could be deployed to the node and why not.
+func DeploymentCheck(org, userPw, node, pattern, inputFilePath string) {
+	cliutils.SetWhetherUsingApiKey(userPw)
+	org, node = cliutils.TrimOrg(org, node)
+	patOrg, patName := cliutils.TrimOrg(org, pattern)
+	patternId := cliutils.OrgAndCreds(patOrg, patName)
+	exchUrl := cliutils.GetExchangeUrl()
+
+	// Get the node
+	var nodes exchange.GetDevicesResponse
+	httpCode := cliutils.ExchangeGet(exchUrl, "orgs/"+org+"/nodes/"+node, cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &nodes)
+	dev, ok := nodes.Devices[cliutils.OrgAndCreds(org, node)]
+	if httpCode == 404 || !ok {
+		cliutils.Fatal(cliutils.NOT_FOUND, "node '%s' not found in org %s", node, org)
+	}
+
+	// Get the pattern
+	var patterns exchange.GetPatternResponse
+	httpCode = cliutils.ExchangeGet(exchUrl, "orgs/"+patOrg+"/patterns/"+patName, cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &patterns)
+	pat, ok := patterns.Patterns[patternId]
+	if httpCode == 404 || !ok {
+		cliutils.Fatal(cliutils.NOT_FOUND, "pattern '%s' not found in org %s", patName, patOrg)
+	}
+
+	var inputFile *deploymentCheckInputFile
+	if inputFilePath != "" {
+		inputFile = new(deploymentCheckInputFile)
+		if err := json.Unmarshal(cliutils.ReadJsonFile(inputFilePath), inputFile); err != nil {
+			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to unmarshal json input file %s: %v", inputFilePath, err)
+		}
+	}
+
+	// Problems with the node itself prevent every workload from being deployed.
+	nodeReasons := []string{}
+	if dev.Pattern != patternId {
+		nodeReasons = append(nodeReasons, fmt.Sprintf("node is registered with pattern '%s', not %s", dev.Pattern, patternId))
+	}
+	if len(dev.PublicKey) == 0 {
+		nodeReasons = append(nodeReasons, "node has not published its messaging key, it is not ready to make agreements")
+	}
+
+	// The policies the node registered, keyed by the microservice or service url.
+	registered := dev.RegisteredMicroservices
+	if pat.UsingServiceModel() {
+		registered = dev.RegisteredServices
+	}
+	nodePolicies := make(map[string]*policy.Policy)
+	nodeArch := ""
+	for _, ms := range registered {
+		pol := new(policy.Policy)
+		if err := json.Unmarshal([]byte(ms.Policy), pol); err != nil {
+			nodeReasons = append(nodeReasons, fmt.Sprintf("unable to demarshal the policy node registered for %s: %v", ms.Url, err))
+			continue
+		}
+		nodePolicies[ms.Url] = pol
+		for _, apiSpec := range pol.APISpecs {
+			if apiSpec.Arch != "" {
+				nodeArch = apiSpec.Arch
+			}
+		}
+	}
+
+	results := []DeploymentCheckResult{}
+	if pat.UsingServiceModel() {
+		for _, service := range pat.Services {
+			for _, choice := range service.ServiceVersions {
+				res := DeploymentCheckResult{Org: service.ServiceOrg, Url: service.ServiceURL, Version: choice.Version, Arch: service.ServiceArch, Reasons: append([]string{}, nodeReasons...)}
+				if service.AgreementLess {
+					res.Warnings = append(res.Warnings, "agreement-less service, it is started by the node without an agreement")
+				}
+				def := getServiceCheckDefinition(exchUrl, org, userPw, service.ServiceOrg, service.ServiceURL, choice.Version, service.ServiceArch, &res)
+				checkDeployment(&pat, patternId, service.DataVerify, service.NodeH, def, nodeArch, nodePolicies, inputFile, inputFile.services(), &res)
+				results = append(results, res)
+			}
+		}
+	} else {
+		for _, workload := range pat.Workloads {
+			for _, choice := range workload.WorkloadVersions {
+				res := DeploymentCheckResult{Org: workload.WorkloadOrg, Url: workload.WorkloadURL, Version: choice.Version, Arch: workload.WorkloadArch, Reasons: append([]string{}, nodeReasons...)}
+				def := getWorkloadCheckDefinition(exchUrl, org, userPw, workload.WorkloadOrg, workload.WorkloadURL, choice.Version, workload.WorkloadArch, &res)
+				checkDeployment(&pat, patternId, workload.DataVerify, workload.NodeH, def, nodeArch, nodePolicies, inputFile, inputFile.workloads(), &res)
+				results = append(results, res)
+			}
+		}
+	}
+
+	output := cliutils.MarshalIndent(results, "exchange deployment check")
+	fmt.Println(output)
+}
+
+func (f *deploymentCheckInputFile) services() []deploymentCheckInput {
+	if f == nil {
+		return nil
+	}
+	return f.Services
+}
+
+func (f *deploymentCheckInputFile) workloads() []deploymentCheckInput {
+	if f == nil {
+		return nil
+	}
+	return f.Workloads
+}
+
+// Get the workload definition for this version of the workload. Returns nil, after recording the reason, if the
+// workload is not in the exchange.
+func getWorkloadCheckDefinition(exchUrl, org, userPw, wOrg, wUrl, version, arch string, res *DeploymentCheckResult) *deploymentCheckDefinition {
+	exchId := cliutils.FormExchangeId(wUrl, version, arch)
+	var workOutput exchange.GetWorkloadsResponse
+	cliutils.ExchangeGet(exchUrl, "orgs/"+wOrg+"/workloads/"+exchId, cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &workOutput)
+	work, ok := workOutput.Workloads[cliutils.OrgAndCreds(wOrg, exchId)]
+	if !ok {
+		res.Reasons = append(res.Reasons, fmt.Sprintf("workload %s/%s not found in the exchange", wOrg, exchId))
+		return nil
+	}
+
+	def := &deploymentCheckDefinition{apiSpecs: new(policy.APISpecList), userInputs: work.UserInputs}
+	for _, m := range work.APISpecs {
+		*def.apiSpecs = append(*def.apiSpecs, *policy.APISpecification_Factory(m.SpecRef, m.Org, m.Version, m.Arch))
+	}
+	return def
+}
+
+// Get the service definition for this version of the service. Returns nil, after recording the reason, if the
+// service is not in the exchange.
+func getServiceCheckDefinition(exchUrl, org, userPw, sOrg, sUrl, version, arch string, res *DeploymentCheckResult) *deploymentCheckDefinition {
+	exchId := cliutils.FormExchangeId(sUrl, version, arch)
+	var svcOutput exchange.GetServicesResponse
+	cliutils.ExchangeGet(exchUrl, "orgs/"+sOrg+"/services/"+exchId, cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &svcOutput)
+	svc, ok := svcOutput.Services[cliutils.OrgAndCreds(sOrg, exchId)]
+	if !ok {
+		res.Reasons = append(res.Reasons, fmt.Sprintf("service %s/%s not found in the exchange", sOrg, exchId))
+		return nil
+	}
+
+	def := &deploymentCheckDefinition{apiSpecs: new(policy.APISpecList), userInputs: svc.UserInputs}
+	for _, s := range svc.RequiredServices {
+		*def.apiSpecs = append(*def.apiSpecs, *policy.APISpecification_Factory(s.URL, s.Org, s.Version, s.Arch))
+	}
+	return def
+}
+
+// Check one workload or service version against the node, in the same order the agbot does: architecture, the
+// dependencies the node has registered and their version ranges, the merged node policy against the policy generated
+// from the pattern (properties and agreement protocols), and finally the user input the node has to provide.
+func checkDeployment(pat *exchange.Pattern, patternId string, dv exchange.DataVerification, nh exchange.NodeHealth, def *deploymentCheckDefinition, nodeArch string, nodePolicies map[string]*policy.Policy, inputFile *deploymentCheckInputFile, inputs []deploymentCheckInput, res *DeploymentCheckResult) {
+
+	defer func() { res.Deployable = len(res.Reasons) == 0 }()
+
+	if nodeArch != "" && nodeArch != res.Arch {
+		res.Reasons = append(res.Reasons, fmt.Sprintf("node architecture is %s, not %s", nodeArch, res.Arch))
+	} else if nodeArch == "" {
+		res.Warnings = append(res.Warnings, "node has not registered any policies, unable to check the node architecture")
+	}
+
+	if def == nil {
+		return
+	}
+
+	// Every dependency has to be registered by the node in a version that is within the required range, and the
+	// node's policies for all of them have to be compatible with each other.
+	var producerPolicy *policy.Policy
+	for _, apiSpec := range *def.apiSpecs {
+		nodePol, ok := nodePolicies[apiSpec.SpecRef]
+		if !ok {
+			res.Reasons = append(res.Reasons, fmt.Sprintf("node has not registered %s/%s", apiSpec.Org, apiSpec.SpecRef))
+			continue
+		}
+		required := policy.APISpecList{apiSpec}
+		if err := nodePol.APISpecs.Supports(required); err != nil {
+			res.Reasons = append(res.Reasons, fmt.Sprintf("node registered %s in a version outside of the required range %s: %v", apiSpec.SpecRef, apiSpec.Version, err))
+		} else if merged, err := policy.Are_Compatible_Producers(producerPolicy, nodePol, 0); err != nil {
+			res.Reasons = append(res.Reasons, fmt.Sprintf("node policy for %s is not compatible with its other policies: %v", apiSpec.SpecRef, err))
+		} else {
+			producerPolicy = merged
+		}
+	}
+
+	// Compare the merged node policy with the policy the agbot generates for this workload version.
+	if producerPolicy != nil && len(res.Reasons) == 0 {
+		consumerPolicy := policy.Policy_Factory(fmt.Sprintf("%v_%v_%v", patternId, res.Url, res.Version))
+		consumerPolicy.ServiceBased = pat.UsingServiceModel()
+		consumerPolicy.APISpecs = *def.apiSpecs
+		exchange.ConvertCommon(pat, patternId, dv, nh, consumerPolicy)
+		if err := policy.Are_Compatible(producerPolicy, consumerPolicy); err != nil {
+			res.Reasons = append(res.Reasons, fmt.Sprintf("node policy is not compatible with the pattern: %v", err))
+		}
+	}
+
+	// User input without a default value has to be set by the node. The node does not publish its user input, so
+	// this can only be checked against the node's registration input file.
+	for _, ui := range def.userInputs {
+		if ui.DefaultValue != "" {
+			continue
+		} else if inputFile == nil {
+			res.Warnings = append(res.Warnings, fmt.Sprintf("user input %s has no default value, the node has to set it", ui.Name))
+		} else if !deploymentCheckInputSet(inputs, res.Org, res.Url, ui.Name) {
+			res.Reasons = append(res.Reasons, fmt.Sprintf("user input %s has no default value and is not set in the input file", ui.Name))
+		}
+	}
+}
+
+func deploymentCheckInputSet(inputs []deploymentCheckInput, org string, url string, name string) bool {
+	for _, input := range inputs {
+		if input.Url != url || (input.Org != "" && input.Org != org) {
+			continue
+		} else if _, ok := input.Variables[name]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+// +build unit
+
+package exchange
+
+import (
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/policy"
+	"strings"
+	"testing"
+)
+
+func Test_checkDeployment(t *testing.T) {
+
+	pat := &exchange.Pattern{
+		AgreementProtocols: []exchange.AgreementProtocol{{Name: policy.BasicProtocol}},
+	}
+
+	nodePolicy := func(specRef string, version string, protocol string) *policy.Policy {
+		pol := policy.Policy_Factory(specRef)
+		pol.APISpecs = append(pol.APISpecs, *policy.APISpecification_Factory(specRef, "myorg", version, "amd64"))
+		pol.AgreementProtocols.Add_Agreement_Protocol(policy.AgreementProtocol_Factory(protocol))
+		return pol
+	}
+
+	newDef := func() *deploymentCheckDefinition {
+		def := &deploymentCheckDefinition{
+			apiSpecs:   new(policy.APISpecList),
+			userInputs: []exchange.UserInput{{Name: "var1"}, {Name: "var2", DefaultValue: "x"}},
+		}
+		*def.apiSpecs = append(*def.apiSpecs, *policy.APISpecification_Factory("http://ms1", "myorg", "[1.0.0,2.0.0)", "amd64"))
+		return def
+	}
+
+	check := func(nodeArch string, nodePolicies map[string]*policy.Policy, inputFile *deploymentCheckInputFile) DeploymentCheckResult {
+		res := DeploymentCheckResult{Org: "myorg", Url: "http://wl1", Version: "1.0.0", Arch: "amd64"}
+		checkDeployment(pat, "myorg/pat1", exchange.DataVerification{}, exchange.NodeHealth{}, newDef(), nodeArch, nodePolicies, inputFile, inputFile.workloads(), &res)
+		return res
+	}
+
+	inputFile := &deploymentCheckInputFile{Workloads: []deploymentCheckInput{{Org: "myorg", Url: "http://wl1", Variables: map[string]interface{}{"var1": "a"}}}}
+
+	// Everything matches.
+	if res := check("amd64", map[string]*policy.Policy{"http://ms1": nodePolicy("http://ms1", "1.5.0", policy.BasicProtocol)}, inputFile); !res.Deployable {
+		t.Errorf("expected deployable, got %v", res)
+	}
+
+	// User input is only a warning without an input file.
+	if res := check("amd64", map[string]*policy.Policy{"http://ms1": nodePolicy("http://ms1", "1.5.0", policy.BasicProtocol)}, nil); !res.Deployable || len(res.Warnings) != 1 {
+		t.Errorf("expected deployable with a warning, got %v", res)
+	}
+
+	// Each of these is not deployable for the reason given.
+	notDeployable := []struct {
+		nodeArch     string
+		nodePolicies map[string]*policy.Policy
+		inputFile    *deploymentCheckInputFile
+		reason       string
+	}{
+		{"arm", map[string]*policy.Policy{"http://ms1": nodePolicy("http://ms1", "1.5.0", policy.BasicProtocol)}, inputFile, "architecture"},
+		{"amd64", map[string]*policy.Policy{}, inputFile, "not registered"},
+		{"amd64", map[string]*policy.Policy{"http://ms1": nodePolicy("http://ms1", "2.0.0", policy.BasicProtocol)}, inputFile, "version"},
+		{"amd64", map[string]*policy.Policy{"http://ms1": nodePolicy("http://ms1", "1.5.0", policy.CitizenScientist)}, inputFile, "Agreement Protocols"},
+		{"amd64", map[string]*policy.Policy{"http://ms1": nodePolicy("http://ms1", "1.5.0", policy.BasicProtocol)}, &deploymentCheckInputFile{}, "user input var1"},
+	}
+
+	for _, nd := range notDeployable {
+		if res := check(nd.nodeArch, nd.nodePolicies, nd.inputFile); res.Deployable {
+			t.Errorf("expected not deployable because of %v, got %v", nd.reason, res)
+		} else if len(res.Reasons) != 1 || !strings.Contains(res.Reasons[0], nd.reason) {
+			t.Errorf("expected one reason containing %v, got %v", nd.reason, res.Reasons)
+		}
+	}
+
+}
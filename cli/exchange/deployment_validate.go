@@ -0,0 +1,286 @@
+package exchange
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"github.com/open-horizon/anax/containermessage"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The deployment config is signed as it is written in the input file, so a misspelled field (e.g. "enviroment") or a
+// malformed port is only noticed when anax tries to start the container, long after the definition was published.
+// ValidateDeployment checks the deployment config against the schema of the containers anax starts
+// (containermessage.Service) before it is signed. Each problem found is returned with its location in the input file,
+// e.g. deployment.services.netspeed.enviroment.
+func ValidateDeployment(location string, deployment interface{}) []string {
+
+	// A deployment config that is still an escaped json string is parsed the same way ConvertToDeploymentConfig does.
+	if depString, ok := deployment.(string); ok {
+		if depString == "" {
+			return nil
+		} else if err := json.Unmarshal([]byte(depString), &deployment); err != nil {
+			return []string{fmt.Sprintf("%v: is not valid json: %v", location, err)}
+		}
+	}
+
+	if deployment == nil {
+		return nil
+	}
+
+	problems := checkDeploymentSchema(location, deployment, reflect.TypeOf(DeploymentConfig{}))
+	if len(problems) != 0 {
+		sort.Strings(problems)
+		return problems
+	}
+
+	// The deployment config has the right structure, now check the values anax is going to interpret.
+	jsonBytes, err := json.Marshal(deployment)
+	if err != nil {
+		return []string{fmt.Sprintf("%v: unable to marshal: %v", location, err)}
+	}
+	depConfig := new(DeploymentConfig)
+	if err := json.Unmarshal(jsonBytes, depConfig); err != nil {
+		return []string{fmt.Sprintf("%v: unable to demarshal: %v", location, err)}
+	}
+
+	for name, svc := range depConfig.Services {
+		problems = append(problems, checkDeploymentService(fmt.Sprintf("%v.services.%v", location, name), svc)...)
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// CheckDeployment validates the deployment config and exits with all of the problems found, if there are any.
+func CheckDeployment(location string, deployment interface{}) {
+	if problems := ValidateDeployment(location, deployment); len(problems) != 0 {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "the deployment config is not valid, it was not signed or published:\n  %v\nSee https://github.com/open-horizon/anax/blob/master/doc/deployment_string.md, or use --no-strict to publish it anyway.", strings.Join(problems, "\n  "))
+	}
+}
+
+// Compare a value read from json with the go type it is demarshalled into. Fields that are not in the go type are
+// reported, because the json demarshaller would silently drop them.
+func checkDeploymentSchema(location string, value interface{}, t reflect.Type) []string {
+	if value == nil {
+		return nil
+	}
+
+	problems := []string{}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return checkDeploymentSchema(location, value, t.Elem())
+
+	case reflect.Struct:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%v: must be a json object", location)}
+		}
+		fields := jsonFields(t)
+		for key, v := range obj {
+			if f, ok := findJsonField(key, fields); !ok {
+				problems = append(problems, fmt.Sprintf("%v.%v: unknown field%v", location, key, suggestField(key, fields)))
+			} else {
+				problems = append(problems, checkDeploymentSchema(location+"."+key, v, f.Type)...)
+			}
+		}
+
+	case reflect.Map:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%v: must be a json object", location)}
+		}
+		for key, v := range obj {
+			problems = append(problems, checkDeploymentSchema(location+"."+key, v, t.Elem())...)
+		}
+
+	case reflect.Slice:
+		array, ok := value.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%v: must be a json array", location)}
+		}
+		for ix, v := range array {
+			problems = append(problems, checkDeploymentSchema(fmt.Sprintf("%v[%v]", location, ix), v, t.Elem())...)
+		}
+
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			problems = append(problems, fmt.Sprintf("%v: must be a string", location))
+		}
+
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			problems = append(problems, fmt.Sprintf("%v: must be true or false", location))
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n, ok := value.(float64); !ok || n != float64(int64(n)) {
+			problems = append(problems, fmt.Sprintf("%v: must be an integer", location))
+		}
+	}
+	return problems
+}
+
+// Returns the fields of a struct, keyed by the name they have in json.
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		} else if name == "" {
+			name = f.Name
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+// The json demarshaller matches field names without regard to case, so the same is done here.
+func findJsonField(key string, fields map[string]reflect.StructField) (reflect.StructField, bool) {
+	if f, ok := fields[key]; ok {
+		return f, true
+	}
+	for name, f := range fields {
+		if strings.EqualFold(name, key) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// Find the field the user most likely meant, to make misspellings easy to fix.
+func suggestField(key string, fields map[string]reflect.StructField) string {
+	best, bestDistance := "", 3 // more than 2 edits is not a misspelling
+	for name := range fields {
+		if d := editDistance(strings.ToLower(key), strings.ToLower(name)); d < bestDistance || (d == bestDistance && name < best) {
+			best, bestDistance = name, d
+		}
+	}
+	if best != "" {
+		return fmt.Sprintf(", did you mean '%v'?", best)
+	}
+	return ""
+}
+
+// The Levenshtein distance between 2 strings.
+func editDistance(a string, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Check the values of one container that anax parses when it starts the container.
+func checkDeploymentService(location string, svc *containermessage.Service) []string {
+	problems := []string{}
+	if svc == nil {
+		return []string{fmt.Sprintf("%v: must be a json object", location)}
+	} else if svc.Image == "" {
+		problems = append(problems, fmt.Sprintf("%v.image: is required", location))
+	}
+
+	for ix, env := range svc.Environment {
+		if parts := strings.SplitN(env, "=", 2); len(parts) != 2 || parts[0] == "" {
+			problems = append(problems, fmt.Sprintf("%v.environment[%v]: '%v' must be in the form NAME=value", location, ix, env))
+		}
+	}
+
+	for ix, bind := range svc.Binds {
+		if parts := strings.Split(bind, ":"); len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			problems = append(problems, fmt.Sprintf("%v.binds[%v]: '%v' must be in the form host_path:container_path[:options]", location, ix, bind))
+		}
+	}
+
+	for ix, dev := range svc.Devices {
+		if parts := strings.Split(dev, ":"); len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			problems = append(problems, fmt.Sprintf("%v.devices[%v]: '%v' must be in the form host_path:container_path[:permissions]", location, ix, dev))
+		}
+	}
+
+	for ix, dev := range svc.DeviceMappings {
+		if !strings.HasPrefix(dev.HostPath, "/") {
+			problems = append(problems, fmt.Sprintf("%v.device_mappings[%v].host_path: '%v' must be an absolute path", location, ix, dev.HostPath))
+		}
+		if dev.ContainerPath != "" && !strings.HasPrefix(dev.ContainerPath, "/") {
+			problems = append(problems, fmt.Sprintf("%v.device_mappings[%v].container_path: '%v' must be an absolute path", location, ix, dev.ContainerPath))
+		}
+		if strings.Trim(dev.Permissions, "rwm") != "" {
+			problems = append(problems, fmt.Sprintf("%v.device_mappings[%v].permissions: '%v' must be made of r, w and m", location, ix, dev.Permissions))
+		}
+	}
+
+	if svc.GPU != nil {
+		if svc.GPU.Vendor != containermessage.GPU_VENDOR_NVIDIA {
+			problems = append(problems, fmt.Sprintf("%v.gpu.vendor: '%v' is not supported, it must be %v", location, svc.GPU.Vendor, containermessage.GPU_VENDOR_NVIDIA))
+		}
+		if svc.GPU.Count < 1 {
+			problems = append(problems, fmt.Sprintf("%v.gpu.count: must be at least 1", location))
+		}
+	}
+
+	if svc.Logging != nil {
+		if err := svc.Logging.Validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("%v.logging: %v", location, err))
+		}
+	}
+
+	if err := svc.ValidateSecrets(); err != nil {
+		problems = append(problems, fmt.Sprintf("%v.secrets: %v", location, err))
+	}
+
+	for ix, port := range svc.Ports {
+		if !validPortSpec(port.PortAndProtocol) {
+			problems = append(problems, fmt.Sprintf("%v.ports[%v].port_and_protocol: '%v' must be in the form port[/tcp|/udp]", location, ix, port.PortAndProtocol))
+		}
+	}
+
+	// Specific ports are in the form [host_port:]container_port, each with an optional protocol.
+	for ix, port := range svc.SpecificPorts {
+		if port.HostIP != "" && net.ParseIP(port.HostIP) == nil {
+			problems = append(problems, fmt.Sprintf("%v.specific_ports[%v].HostIp: '%v' is not an IP address", location, ix, port.HostIP))
+		}
+		pieces := strings.Split(port.HostPort, ":")
+		valid := len(pieces) <= 2
+		for _, p := range pieces {
+			valid = valid && validPortSpec(p)
+		}
+		if !valid {
+			problems = append(problems, fmt.Sprintf("%v.specific_ports[%v].HostPort: '%v' must be in the form [host_port:]container_port[/tcp|/udp]", location, ix, port.HostPort))
+		}
+	}
+	return problems
+}
+
+func validPortSpec(spec string) bool {
+	parts := strings.SplitN(spec, "/", 2)
+	if len(parts) == 2 && parts[1] != "tcp" && parts[1] != "udp" {
+		return false
+	}
+	port, err := strconv.Atoi(parts[0])
+	return err == nil && port > 0 && port <= 65535
+}
@@ -0,0 +1,67 @@
+// +build unit
+
+package exchange
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func Test_ValidateDeployment(t *testing.T) {
+
+	parse := func(s string) interface{} {
+		var dep interface{}
+		if err := json.Unmarshal([]byte(s), &dep); err != nil {
+			t.Fatalf("bad test input %v: %v", s, err)
+		}
+		return dep
+	}
+
+	valid := `{"services":{"netspeed":{"image":"myorg/netspeed:1.0","privileged":true,"environment":["MODE=prod"],"binds":["/var/data:/data:ro"],
+		"ports":[{"localhost_only":true,"port_and_protocol":"8080/tcp"}],"specific_ports":[{"HostIp":"127.0.0.1","HostPort":"9000:8080/udp"}],
+		"logging":{"driver":"json-file","max_size":"10m","options":{"max-file":"3"}},
+		"secrets":[{"name":"db-password"},{"name":"api.key","env":"API_KEY"}]}}}`
+
+	if problems := ValidateDeployment("deployment", parse(valid)); len(problems) != 0 {
+		t.Errorf("valid deployment config has problems: %v", problems)
+	} else if problems := ValidateDeployment("deployment", valid); len(problems) != 0 {
+		t.Errorf("valid deployment string has problems: %v", problems)
+	} else if problems := ValidateDeployment("deployment", nil); len(problems) != 0 {
+		t.Errorf("no deployment config should have no problems: %v", problems)
+	} else if problems := ValidateDeployment("deployment", parse(`{}`)); len(problems) != 0 {
+		t.Errorf("empty deployment config should have no problems: %v", problems)
+	}
+
+	// Each of these has exactly one problem, at the location given.
+	invalid := map[string]string{
+		`{"services":{"ns":{"image":"x","enviroment":["A=1"]}}}`: "deployment.services.ns.enviroment: unknown field, did you mean 'environment'?",
+		`{"service":{}}`: "deployment.service: unknown field, did you mean 'services'?",
+		`{"services":{"ns":{"image":"x","Enviroment":["A=1"]}}}`:                                          "deployment.services.ns.Enviroment: unknown field, did you mean 'environment'?",
+		`{"services":{"ns":{"image":"x","ports":[{"port_and_protocl":"80"}]}}}`:                           "deployment.services.ns.ports[0].port_and_protocl: unknown field",
+		`{"services":{"ns":{"image":"x","privileged":"true"}}}`:                                           "deployment.services.ns.privileged: must be true or false",
+		`{"services":{"ns":{"image":"x","environment":"A=1"}}}`:                                           "deployment.services.ns.environment: must be a json array",
+		`{"services":{"ns":{"privileged":false}}}`:                                                        "deployment.services.ns.image: is required",
+		`{"services":{"ns":{"image":"x","environment":["A"]}}}`:                                           "deployment.services.ns.environment[0]: 'A' must be in the form NAME=value",
+		`{"services":{"ns":{"image":"x","binds":["/data"]}}}`:                                             "deployment.services.ns.binds[0]",
+		`{"services":{"ns":{"image":"x","ports":[{"port_and_protocol":"http/tcp"}]}}}`:                    "deployment.services.ns.ports[0].port_and_protocol",
+		`{"services":{"ns":{"image":"x","ports":[{"port_and_protocol":"80/sctp"}]}}}`:                     "deployment.services.ns.ports[0].port_and_protocol",
+		`{"services":{"ns":{"image":"x","specific_ports":[{"HostPort":"1:2:3"}]}}}`:                       "deployment.services.ns.specific_ports[0].HostPort",
+		`{"services":{"ns":{"image":"x","specific_ports":[{"HostIp":"localhost","HostPort":"8080"}]}}}`:   "deployment.services.ns.specific_ports[0].HostIp",
+		`{"services":{"ns":{"image":"x","specific_ports":[{"HostIp":"0.0.0.0","HostPort":"70000:80"}]}}}`: "deployment.services.ns.specific_ports[0].HostPort",
+		`{"services":{"ns":{"image":"x","logging":{"driver":"syslog","max_size":"10m"}}}}`:                "deployment.services.ns.logging: max_size can only be used",
+		`{"services":{"ns":{"image":"x","logging":{"syslog_adress":"udp://logs:514"}}}}`:                  "deployment.services.ns.logging.syslog_adress: unknown field, did you mean 'syslog_address'?",
+		`{"services":{"ns":{"image":"x","secrets":[{"name":"../etc/passwd"}]}}}`:                          "deployment.services.ns.secrets: secret name '../etc/passwd'",
+		`{"services":{"ns":{"image":"x","secrets":[{"name":"a"}],"environment":["A=1"]}}}`:                "deployment.services.ns.secrets: secret a: environment variable A is also set",
+	}
+
+	for dep, expected := range invalid {
+		if problems := ValidateDeployment("deployment", parse(dep)); len(problems) != 1 || !strings.HasPrefix(problems[0], expected) {
+			t.Errorf("deployment config %v should have the problem %v, has %v", dep, expected, problems)
+		}
+	}
+
+	if problems := ValidateDeployment("workloads[0].deployment", `{"services":`); len(problems) != 1 || !strings.HasPrefix(problems[0], "workloads[0].deployment: is not valid json") {
+		t.Errorf("wrong problems for a deployment string that is not json: %v", problems)
+	}
+}
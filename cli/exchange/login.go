@@ -0,0 +1,37 @@
+package exchange
+
+import (
+	"fmt"
+	"github.com/open-horizon/anax/cli/cliutils"
+)
+
+// Login verifies the user credentials with the exchange and then caches them, and the org, in the OS keyring.
+func Login(org, userPw string) {
+	cliutils.SetWhetherUsingApiKey(userPw)
+	creds := cliutils.OrgAndCreds(org, userPw)
+	id, _ := cliutils.SplitIdToken(creds)
+	userOrg, user := cliutils.TrimOrg(org, id)
+
+	// Verify the credentials before storing them, so a typo is not silently cached
+	var users ExchangeUsers
+	httpCode := cliutils.ExchangeGet(cliutils.GetExchangeUrl(), "orgs/"+userOrg+"/users/"+user, creds, []int{200, 401, 403, 404}, &users)
+	if httpCode != 200 {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "invalid credentials for user '%s' in org %s", user, userOrg)
+	}
+
+	if err := cliutils.SaveCachedCredentials(cliutils.CachedCredentials{Org: org, UserPw: creds}); err != nil {
+		cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, "unable to cache the credentials in the OS keyring: %v", err)
+	}
+	fmt.Printf("Credentials for user %s/%s cached in the OS keyring.\n", userOrg, user)
+}
+
+// Logout removes the credentials cached by Login from the OS keyring.
+func Logout() {
+	if err := cliutils.DeleteCachedCredentials(); err == cliutils.ErrKeyringNotFound {
+		fmt.Println("No credentials cached in the OS keyring.")
+	} else if err != nil {
+		cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, "unable to remove the credentials from the OS keyring: %v", err)
+	} else {
+		fmt.Println("Credentials removed from the OS keyring.")
+	}
+}
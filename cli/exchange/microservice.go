@@ -9,12 +9,17 @@ import (
 	"github.com/open-horizon/anax/containermessage"
 	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/policy"
 	"github.com/open-horizon/rsapss-tool/sign"
 	"github.com/open-horizon/rsapss-tool/verify"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 type DeploymentConfig struct {
@@ -60,17 +65,31 @@ func (dc DeploymentConfig) CanStartStop() error {
 				return errors.New(fmt.Sprintf("no service name"))
 			} else if len(service.Image) == 0 {
 				return errors.New(fmt.Sprintf("no docker image for service %s", serviceName))
+			} else if err := service.ValidateDevices(); err != nil {
+				return errors.New(fmt.Sprintf("service %s: %v", serviceName, err))
+			} else if err := service.ValidateSecrets(); err != nil {
+				return errors.New(fmt.Sprintf("service %s: %v", serviceName, err))
+			} else if service.Logging != nil {
+				if err := service.Logging.Validate(); err != nil {
+					return errors.New(fmt.Sprintf("service %s: %v", serviceName, err))
+				}
 			}
 		}
 	}
 	return nil
 }
 
+// Returns the host devices that the services need and that are missing from this host.
+func (dc DeploymentConfig) MissingDevices() []string {
+	return containermessage.DeploymentDescription{Services: dc.Services}.MissingDevices(containermessage.HostDeviceExists)
+}
+
 type WorkloadDeployment struct {
 	//Deployment          DeploymentConfig `json:"deployment"`
-	Deployment          interface{} `json:"deployment"`
-	DeploymentSignature string      `json:"deployment_signature"`
-	Torrent             string      `json:"torrent"`
+	Deployment          interface{}       `json:"deployment"`
+	DeploymentSignature string            `json:"deployment_signature"`
+	Torrent             string            `json:"torrent"`
+	ImageFetch          *cutil.ImageFetch `json:"imageFetch,omitempty"`
 }
 
 type MicroserviceFile struct {
@@ -192,34 +211,132 @@ type MicroserviceInput struct {
 	Workloads     []exchange.WorkloadDeployment `json:"workloads"`
 }
 
-func MicroserviceList(org string, userPw string, microservice string, namesOnly bool) {
+// The filters of 'hzn exchange microservice list', given as key=value. The spec ref and the arch are passed to the
+// exchange, and all of the filters are also applied to the microservices that the exchange returns.
+type MicroserviceFilter struct {
+	SpecRef string
+	Arch    string
+	Version string                     // a single version, only that version matches
+	Range   *policy.Version_Expression // a version range
+}
+
+func NewMicroserviceFilter(filters []string) (*MicroserviceFilter, error) {
+	f := &MicroserviceFilter{}
+	for _, filter := range filters {
+		parts := strings.SplitN(filter, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, errors.New(fmt.Sprintf("filter %v is not in the form key=value", filter))
+		}
+		switch parts[0] {
+		case "specRef":
+			f.SpecRef = parts[1]
+		case "arch":
+			f.Arch = parts[1]
+		case "version":
+			if policy.IsVersionString(parts[1]) {
+				f.Version = parts[1]
+			} else if vExp, err := policy.Version_Expression_Factory(parts[1]); err != nil {
+				return nil, errors.New(fmt.Sprintf("filter %v does not have a valid version or version range: %v", filter, err))
+			} else {
+				f.Range = vExp
+			}
+		default:
+			return nil, errors.New(fmt.Sprintf("unsupported filter key %v, use specRef, version or arch", parts[0]))
+		}
+	}
+	return f, nil
+}
+
+// The query parameters for the filters that the exchange supports.
+func (f *MicroserviceFilter) QueryParams() string {
+	params := url.Values{}
+	if f.SpecRef != "" {
+		params.Set("specRef", f.SpecRef)
+	}
+	if f.Arch != "" {
+		params.Set("arch", f.Arch)
+	}
+	if len(params) == 0 {
+		return ""
+	}
+	return "?" + params.Encode()
+}
+
+func (f *MicroserviceFilter) Matches(m exchange.MicroserviceDefinition) bool {
+	if f.SpecRef != "" && f.SpecRef != m.SpecRef {
+		return false
+	} else if f.Arch != "" && f.Arch != m.Arch {
+		return false
+	} else if f.Version != "" {
+		if c, err := policy.CompareVersions(f.Version, m.Version); err != nil || c != 0 {
+			return false
+		}
+	} else if f.Range != nil {
+		if inRange, err := f.Range.Is_within_range(m.Version); err != nil || !inRange {
+			return false
+		}
+	}
+	return true
+}
+
+// Remove the microservices that don't match the filter.
+func (f *MicroserviceFilter) Apply(microservices map[string]exchange.MicroserviceDefinition) map[string]exchange.MicroserviceDefinition {
+	filtered := make(map[string]exchange.MicroserviceDefinition)
+	for id, m := range microservices {
+		if f.Matches(m) {
+			filtered[id] = m
+		}
+	}
+	return filtered
+}
+
+func MicroserviceList(org string, userPw string, microservice string, namesOnly bool, filters []string, output string) {
 	cliutils.SetWhetherUsingApiKey(userPw)
 	org, microservice = cliutils.TrimOrg(org, microservice)
+	filter, err := NewMicroserviceFilter(filters)
+	if err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "%v", err)
+	}
+	outputFormat, err := cliutils.ParseOutputFormat(output)
+	if err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "%v", err)
+	}
+
+	urlSuffix := "orgs/" + org + "/microservices" + cliutils.AddSlash(microservice)
+	if microservice == "" {
+		urlSuffix += filter.QueryParams()
+	}
+	var resp exchange.GetMicroservicesResponse
+	httpCode := cliutils.ExchangeGet(cliutils.GetExchangeUrl(), urlSuffix, cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &resp)
+	if httpCode == 404 && microservice != "" {
+		cliutils.Fatal(cliutils.NOT_FOUND, "microservice '%s' not found in org %s", microservice, org)
+	}
+	microservices := filter.Apply(resp.Microservices)
+
+	ids := make([]string, 0, len(microservices))
+	for id := range microservices {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
 	if namesOnly && microservice == "" {
 		// Only display the names
-		var resp exchange.GetMicroservicesResponse
-		cliutils.ExchangeGet(cliutils.GetExchangeUrl(), "orgs/"+org+"/microservices"+cliutils.AddSlash(microservice), cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &resp)
-		microservices := []string{}
-		for k := range resp.Microservices {
-			microservices = append(microservices, k)
+		rows := [][]string{}
+		for _, id := range ids {
+			rows = append(rows, []string{id})
 		}
-		jsonBytes, err := json.MarshalIndent(microservices, "", cliutils.JSON_INDENT)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to marshal 'hzn exchange microservice list' output: %v", err)
-		}
-		fmt.Printf("%s\n", jsonBytes)
+		err = outputFormat.Write(os.Stdout, ids, []string{"MICROSERVICE"}, rows)
 	} else {
 		// Display the full resources
-		var microservices exchange.GetMicroservicesResponse
-		httpCode := cliutils.ExchangeGet(cliutils.GetExchangeUrl(), "orgs/"+org+"/microservices"+cliutils.AddSlash(microservice), cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &microservices)
-		if httpCode == 404 && microservice != "" {
-			cliutils.Fatal(cliutils.NOT_FOUND, "microservice '%s' not found in org %s", microservice, org)
+		rows := [][]string{}
+		for _, id := range ids {
+			m := microservices[id]
+			rows = append(rows, []string{id, m.SpecRef, m.Version, m.Arch, m.Sharable, strconv.FormatBool(m.Public), m.Owner})
 		}
-		jsonBytes, err := json.MarshalIndent(microservices.Microservices, "", cliutils.JSON_INDENT)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to marshal 'hzn exchange microservice list' output: %v", err)
-		}
-		fmt.Println(string(jsonBytes))
+		err = outputFormat.Write(os.Stdout, microservices, []string{"MICROSERVICE", "SPECREF", "VERSION", "ARCH", "SHARABLE", "PUBLIC", "OWNER"}, rows)
+	}
+	if err != nil {
+		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to display 'hzn exchange microservice list' output: %v", err)
 	}
 }
 
@@ -227,8 +344,9 @@ func MicroserviceList(org string, userPw string, microservice string, namesOnly
 - if the tag is a regular tag and !dontTouchImage, it pushes the image to the registry, gets the repo digest value, and changes the tag to the digest value (this is the "signing" since it gets signed as part of the deployment string)
 - if the tag is already the repo digest value, then do nothing (it must have already been pushed by the user to get the digest)
 - if the tag is a regular tag and dontTouchImage set, add this image path to the returned list that the user needs to push themselves
+The pushConfig has the registry credentials and the other registries the images are also pushed to, it can be nil to push only to the image's own registry with the 'docker login' credentials.
 */
-func SignImagesFromDeploymentField(deployment *DeploymentConfig, dontTouchImage bool) (imageList []string) {
+func SignImagesFromDeploymentField(deployment *DeploymentConfig, dontTouchImage bool, pushConfig *cliutils.RegistryPushConfig) (imageList []string) {
 	if deployment == nil || deployment.Services == nil {
 		return
 	}
@@ -244,11 +362,13 @@ func SignImagesFromDeploymentField(deployment *DeploymentConfig, dontTouchImage
 			continue
 		}
 
-		domain, path, tag, digest := cutil.ParseDockerImagePath(imagePath)
-		cliutils.Verbose("%s parsed into: domain=%s, path=%s, tag=%s", imagePath, domain, path, tag)
-		if path == "" {
-			fmt.Printf("Warning: could not parse image path '%v'. Not pushing it to a docker registry, just including it in the 'deployment' field as-is.\n", imagePath)
-		} else if digest == "" {
+		ref, err := cutil.ParseImageReference(imagePath)
+		if err != nil {
+			fmt.Printf("Warning: %v. Not pushing it to a docker registry, just including it in the 'deployment' field as-is.\n", err)
+			continue
+		}
+		cliutils.Verbose("%s parsed into: registry=%s, repository=%s, tag=%s", imagePath, ref.Registry, ref.Repository, ref.Tag)
+		if ref.Digest == "" {
 			// This image has a tag, or default tag
 			if dontTouchImage {
 				imageList = append(imageList, imagePath) // tell them they have to push it themselves
@@ -257,11 +377,8 @@ func SignImagesFromDeploymentField(deployment *DeploymentConfig, dontTouchImage
 				if client == nil {
 					client = cliutils.NewDockerClient()
 				}
-				digest := cliutils.PushDockerImage(client, domain, path, tag) // this will error out if the push fails or can't get the digest
-				if domain != "" {
-					domain = domain + "/"
-				}
-				newImagePath := domain + path + "@" + digest
+				digest := cliutils.PushDockerImageToRegistries(client, ref.Registry, ref.Repository, ref.Tag, pushConfig) // this will error out if the push fails or can't get the digest
+				newImagePath := cutil.ImageReference{Registry: ref.Registry, Repository: ref.Repository, Digest: digest}.String()
 				fmt.Printf("Using '%s' in 'deployment' field instead of '%s'\n", newImagePath, imagePath)
 				deployment.Services[svcName].Image = newImagePath
 			}
@@ -271,12 +388,26 @@ func SignImagesFromDeploymentField(deployment *DeploymentConfig, dontTouchImage
 	return
 }
 
-func CheckTorrentField(torrent string, index int) {
+// CheckTorrentField verifies the torrent field and returns it in its normalized form. The torrent field is deprecated,
+// the legacy form that indicates the images are stored in a docker registry is still accepted (with a warning) but
+// is dropped before the resource is published. The imageFetch field replaces it.
+// CheckImageFetchField verifies the imageFetch field. where names the part of the input file the field is in, for the error message.
+func CheckImageFetchField(imageFetch *cutil.ImageFetch, where string) *cutil.ImageFetch {
+	if imageFetch == nil {
+		return nil
+	}
+	if err := imageFetch.Validate(); err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "the imageFetch field in %s is not valid: %v", where, err)
+	}
+	return imageFetch
+}
+
+func CheckTorrentField(torrent string, index int) string {
 	// Verify the torrent field is the form necessary for the containers that are stored in a docker registry (because that is all we support from hzn right now)
 	torrentErrorString := `currently the torrent field must either be empty or be like this to indicate the images are stored in a docker registry: {\"url\":\"\",\"signature\":\"\"}`
 	if torrent == "" {
 		//cliutils.Fatal(cliutils.CLI_INPUT_ERROR, torrentErrorString)
-		return
+		return ""
 	}
 	var torrentMap map[string]string
 	if err := json.Unmarshal([]byte(torrent), &torrentMap); err != nil {
@@ -288,15 +419,25 @@ func CheckTorrentField(torrent string, index int) {
 	if signature, ok := torrentMap["signature"]; !ok || signature != "" {
 		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, torrentErrorString)
 	}
+	fmt.Printf("Warning: the torrent field in workload %d is deprecated and will not be published, remove it from the input file. Use the imageFetch field to set the mirrors, digests and auths of the images.\n", index+1)
+	return ""
 }
 
 // MicroservicePublish signs the MS def and puts it in the exchange
-func MicroservicePublish(org, userPw, jsonFilePath, keyFilePath, pubKeyFilePath string, dontTouchImage bool) {
+func MicroservicePublish(org, userPw, jsonFilePath, keyFilePath, pubKeyFilePath string, dontTouchImage bool, registryAuthFile string, pushTo []string, strict bool) {
 	cliutils.SetWhetherUsingApiKey(userPw)
+	if dontTouchImage && len(pushTo) != 0 {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "--push-to-registry can not be used with --dont-change-image-tag, because the images are not pushed")
+	}
+	pushConfig, err := cliutils.NewRegistryPushConfig(registryAuthFile, pushTo)
+	if err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "%v", err)
+	}
+
 	// Read in the MS metadata
 	newBytes := cliutils.ReadJsonFile(jsonFilePath)
 	var microFile MicroserviceFile
-	err := json.Unmarshal(newBytes, &microFile)
+	err = json.Unmarshal(newBytes, &microFile)
 	if err != nil {
 		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to unmarshal json input file %s: %v", jsonFilePath, err)
 	}
@@ -304,11 +445,13 @@ func MicroservicePublish(org, userPw, jsonFilePath, keyFilePath, pubKeyFilePath
 		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "the org specified in the input file (%s) must match the org specified on the command line (%s)", microFile.Org, org)
 	}
 
-	microFile.SignAndPublish(org, userPw, keyFilePath, pubKeyFilePath, dontTouchImage)
+	microFile.SignAndPublish(org, userPw, keyFilePath, pubKeyFilePath, dontTouchImage, pushConfig, strict)
 }
 
 // Sign and publish the microservice definition. This is a function that is reusable across different hzn commands.
-func (mf *MicroserviceFile) SignAndPublish(org, userPw, keyFilePath, pubKeyFilePath string, dontTouchImage bool) {
+// When strict is set, the deployment config is validated before it is signed. The pushConfig says how the images are
+// pushed, nil pushes them to their own registry with the 'docker login' credentials.
+func (mf *MicroserviceFile) SignAndPublish(org, userPw, keyFilePath, pubKeyFilePath string, dontTouchImage bool, pushConfig *cliutils.RegistryPushConfig, strict bool) {
 	microInput := MicroserviceInput{Label: mf.Label, Description: mf.Description, Public: mf.Public, SpecRef: mf.SpecRef, Version: mf.Version, Arch: mf.Arch, Sharable: mf.Sharable, MatchHardware: mf.MatchHardware, UserInputs: mf.UserInputs, Workloads: make([]exchange.WorkloadDeployment, len(mf.Workloads))}
 
 	// Loop thru the workloads array, sign the deployment strings, and copy all 3 fields to microInput
@@ -328,8 +471,12 @@ func (mf *MicroserviceFile) SignAndPublish(org, userPw, keyFilePath, pubKeyFileP
 			microInput.Workloads[i].Deployment = ""
 			microInput.Workloads[i].DeploymentSignature = ""
 		} else {
+			if strict {
+				CheckDeployment(fmt.Sprintf("workloads[%d].deployment", i), mf.Workloads[i].Deployment)
+			}
+
 			// Go thru the docker image paths to push/get sha256 tag and/or gather list of images that user needs to push
-			imageList = SignImagesFromDeploymentField(depConfig, dontTouchImage)
+			imageList = SignImagesFromDeploymentField(depConfig, dontTouchImage, pushConfig)
 
 			fmt.Printf("Signing deployment string %d\n", i+1)
 			deployment, err = json.Marshal(depConfig)
@@ -347,9 +494,8 @@ func (mf *MicroserviceFile) SignAndPublish(org, userPw, keyFilePath, pubKeyFileP
 			}
 		}
 
-		microInput.Workloads[i].Torrent = mf.Workloads[i].Torrent
-
-		CheckTorrentField(microInput.Workloads[i].Torrent, i)
+		microInput.Workloads[i].Torrent = CheckTorrentField(mf.Workloads[i].Torrent, i)
+		microInput.Workloads[i].ImageFetch = CheckImageFetchField(mf.Workloads[i].ImageFetch, fmt.Sprintf("workload %d", i+1))
 	}
 
 	// Create or update resource in the exchange
@@ -387,9 +533,18 @@ func (mf *MicroserviceFile) SignAndPublish(org, userPw, keyFilePath, pubKeyFileP
 }
 
 // MicroserviceVerify verifies the deployment strings of the specified microservice resource in the exchange.
-func MicroserviceVerify(org, userPw, microservice, keyFilePath string) {
+// MicroserviceVerify verifies the deployment signatures of a microservice with the public key file. When exchangeKeys
+// is set, each signature is instead verified with all of the public keys stored with the microservice in the exchange,
+// and the key that verifies it is displayed.
+func MicroserviceVerify(org, userPw, microservice, keyFilePath string, exchangeKeys bool) {
 	cliutils.SetWhetherUsingApiKey(userPw)
 	org, microservice = cliutils.TrimOrg(org, microservice)
+	if keyFilePath == "" && !exchangeKeys {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "either --public-key-file or --exchange-keys must be specified")
+	} else if keyFilePath != "" && exchangeKeys {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "--public-key-file and --exchange-keys can not both be specified")
+	}
+
 	// Get microservice resource from exchange
 	var output exchange.GetMicroservicesResponse
 	httpCode := cliutils.ExchangeGet(cliutils.GetExchangeUrl(), "orgs/"+org+"/microservices/"+microservice, cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &output)
@@ -402,6 +557,12 @@ func MicroserviceVerify(org, userPw, microservice, keyFilePath string) {
 	if !ok {
 		cliutils.Fatal(cliutils.INTERNAL_ERROR, "key '%s' not found in resources returned from exchange", org+"/"+microservice)
 	}
+
+	if exchangeKeys {
+		verifyWithExchangeKeys(cliutils.GetExchangeUrl(), org, userPw, "orgs/"+org+"/microservices/"+microservice, micro.Workloads)
+		return
+	}
+
 	someInvalid := false
 	for i := range micro.Workloads {
 		cliutils.Verbose("verifying deployment string %d", i+1)
@@ -422,6 +583,36 @@ func MicroserviceVerify(org, userPw, microservice, keyFilePath string) {
 	}
 }
 
+// Verify each deployment signature with the public keys stored with the resource in the exchange, and display which
+// key verified it. Exits with SIGNATURE_INVALID if any of them did not verify.
+func verifyWithExchangeKeys(exchUrl, org, userPw, resourcePath string, workloads []exchange.WorkloadDeployment) {
+	keys := getSigningKeys(exchUrl, org, userPw, resourcePath)
+	keyNames := make([]string, 0, len(keys))
+	for keyName, _ := range keys {
+		keyNames = append(keyNames, keyName)
+	}
+	sort.Strings(keyNames)
+	cliutils.Verbose("verifying with the public keys stored in the exchange: %v", keyNames)
+
+	someInvalid := false
+	for i, wd := range workloads {
+		if wd.Deployment == "" {
+			fmt.Printf("Deployment string %d is empty, there is nothing to verify.\n", i+1)
+		} else if keyName, err := findSigningKey(keys, wd.DeploymentSignature, wd.Deployment); err != nil {
+			fmt.Printf("Deployment string %d was not verified: %v\n", i+1, err)
+			someInvalid = true
+		} else {
+			fmt.Printf("Deployment string %d verified with public key %s.\n", i+1, keyName)
+		}
+	}
+
+	if someInvalid {
+		os.Exit(cliutils.SIGNATURE_INVALID)
+	} else {
+		fmt.Println("All signatures verified")
+	}
+}
+
 func MicroserviceRemove(org, userPw, microservice string, force bool) {
 	cliutils.SetWhetherUsingApiKey(userPw)
 	org, microservice = cliutils.TrimOrg(org, microservice)
@@ -0,0 +1,57 @@
+// +build unit
+
+package exchange
+
+import (
+	"github.com/open-horizon/anax/exchange"
+	"testing"
+)
+
+func Test_MicroserviceFilter(t *testing.T) {
+
+	microservices := map[string]exchange.MicroserviceDefinition{
+		"myorg/gps_1.0.0_amd64": exchange.MicroserviceDefinition{SpecRef: "https://bluehorizon.network/microservices/gps", Version: "1.0.0", Arch: "amd64"},
+		"myorg/gps_2.1.0_amd64": exchange.MicroserviceDefinition{SpecRef: "https://bluehorizon.network/microservices/gps", Version: "2.1.0", Arch: "amd64"},
+		"myorg/gps_2.1.0_arm":   exchange.MicroserviceDefinition{SpecRef: "https://bluehorizon.network/microservices/gps", Version: "2.1.0", Arch: "arm"},
+		"myorg/cpu_1.5.0_amd64": exchange.MicroserviceDefinition{SpecRef: "https://bluehorizon.network/microservices/cpu", Version: "1.5.0", Arch: "amd64"},
+	}
+
+	tests := []struct {
+		filters []string
+		query   string
+		ids     []string
+	}{
+		{nil, "", []string{"myorg/gps_1.0.0_amd64", "myorg/gps_2.1.0_amd64", "myorg/gps_2.1.0_arm", "myorg/cpu_1.5.0_amd64"}},
+		{[]string{"arch=arm"}, "?arch=arm", []string{"myorg/gps_2.1.0_arm"}},
+		{[]string{"specRef=https://bluehorizon.network/microservices/gps", "arch=amd64"}, "?arch=amd64&specRef=https%3A%2F%2Fbluehorizon.network%2Fmicroservices%2Fgps", []string{"myorg/gps_1.0.0_amd64", "myorg/gps_2.1.0_amd64"}},
+		{[]string{"version=2.1"}, "", []string{"myorg/gps_2.1.0_amd64", "myorg/gps_2.1.0_arm"}},
+		{[]string{"version=[1.0.0,2.0.0)"}, "", []string{"myorg/gps_1.0.0_amd64", "myorg/cpu_1.5.0_amd64"}},
+		{[]string{"version=(1.0.0,INFINITY)", "arch=amd64"}, "?arch=amd64", []string{"myorg/gps_2.1.0_amd64", "myorg/cpu_1.5.0_amd64"}},
+	}
+
+	for _, test := range tests {
+		f, err := NewMicroserviceFilter(test.filters)
+		if err != nil {
+			t.Errorf("filters %v: unexpected error %v", test.filters, err)
+			continue
+		}
+		if q := f.QueryParams(); q != test.query {
+			t.Errorf("filters %v: query params should be %v, was %v", test.filters, test.query, q)
+		}
+		filtered := f.Apply(microservices)
+		if len(filtered) != len(test.ids) {
+			t.Errorf("filters %v: should match %v, matched %v", test.filters, test.ids, filtered)
+		}
+		for _, id := range test.ids {
+			if _, ok := filtered[id]; !ok {
+				t.Errorf("filters %v: should match %v", test.filters, id)
+			}
+		}
+	}
+
+	for _, bad := range []string{"arch", "arch=", "owner=me", "version=[2.0.0,1.0.0", "version=abc"} {
+		if _, err := NewMicroserviceFilter([]string{bad}); err == nil {
+			t.Errorf("filter %v should be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package exchange
+
+import (
+	"fmt"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"github.com/open-horizon/anax/exchange"
+	"net/http"
+)
+
+// The torrent field in microservice and workload resources is deprecated. These commands rewrite the resources
+// already published in the exchange so that the torrent field is in its normalized form, which for images stored
+// in a docker registry means an empty torrent field. The deployment string is not touched, so the existing deployment
+// signature remains valid and no signing key is needed.
+
+// Normalize the torrent field of each workload deployment. Returns true if any of them changed.
+func migrateTorrentFields(resource string, workloads []exchange.WorkloadDeployment) bool {
+	changed := false
+	for i := range workloads {
+		newTorrent, diff, err := exchange.NormalizeTorrentField(workloads[i].Torrent)
+		if err != nil {
+			cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, "unable to migrate the torrent field of %s workload %d: %v", resource, i+1, err)
+		} else if diff {
+			cliutils.Verbose("%s workload %d: torrent field '%s' becomes '%s'", resource, i+1, workloads[i].Torrent, newTorrent)
+			workloads[i].Torrent = newTorrent
+			changed = true
+		}
+	}
+	return changed
+}
+
+// MicroserviceMigrate normalizes the deprecated torrent field in one or all of the microservices in the org.
+func MicroserviceMigrate(org, userPw, microservice string, dryRun bool) {
+	cliutils.SetWhetherUsingApiKey(userPw)
+	org, microservice = cliutils.TrimOrg(org, microservice)
+
+	var resp exchange.GetMicroservicesResponse
+	httpCode := cliutils.ExchangeGet(cliutils.GetExchangeUrl(), "orgs/"+org+"/microservices"+cliutils.AddSlash(microservice), cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &resp)
+	if httpCode == 404 && microservice != "" {
+		cliutils.Fatal(cliutils.NOT_FOUND, "microservice '%s' not found in org %s", microservice, org)
+	}
+
+	migrated := 0
+	for msId, ms := range resp.Microservices {
+		if !migrateTorrentFields(msId, ms.Workloads) {
+			continue
+		}
+		migrated += 1
+		if dryRun {
+			fmt.Printf("Would migrate %s\n", msId)
+			continue
+		}
+
+		matchHW := make(map[string]string)
+		for key, val := range ms.MatchHardware {
+			matchHW[key] = fmt.Sprintf("%v", val)
+		}
+
+		microInput := MicroserviceInput{Label: ms.Label, Description: ms.Description, Public: ms.Public, SpecRef: ms.SpecRef, Version: ms.Version, Arch: ms.Arch, Sharable: ms.Sharable, MatchHardware: matchHW, UserInputs: ms.UserInputs, Workloads: ms.Workloads}
+		_, id := cliutils.TrimOrg(org, msId)
+		fmt.Printf("Migrating %s in the exchange...\n", msId)
+		cliutils.ExchangePutPost(http.MethodPut, cliutils.GetExchangeUrl(), "orgs/"+org+"/microservices/"+id, cliutils.OrgAndCreds(org, userPw), []int{201}, microInput)
+	}
+
+	if migrated == 0 {
+		fmt.Println("No microservices need to be migrated")
+	}
+}
+
+// WorkloadMigrate normalizes the deprecated torrent field in one or all of the workloads in the org.
+func WorkloadMigrate(org, userPw, workload string, dryRun bool) {
+	cliutils.SetWhetherUsingApiKey(userPw)
+	org, workload = cliutils.TrimOrg(org, workload)
+
+	var resp exchange.GetWorkloadsResponse
+	httpCode := cliutils.ExchangeGet(cliutils.GetExchangeUrl(), "orgs/"+org+"/workloads"+cliutils.AddSlash(workload), cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &resp)
+	if httpCode == 404 && workload != "" {
+		cliutils.Fatal(cliutils.NOT_FOUND, "workload '%s' not found in org %s", workload, org)
+	}
+
+	migrated := 0
+	for wlId, wl := range resp.Workloads {
+		if !migrateTorrentFields(wlId, wl.Workloads) {
+			continue
+		}
+		migrated += 1
+		if dryRun {
+			fmt.Printf("Would migrate %s\n", wlId)
+			continue
+		}
+
+		workInput := WorkloadInput{Label: wl.Label, Description: wl.Description, Public: wl.Public, WorkloadURL: wl.WorkloadURL, Version: wl.Version, Arch: wl.Arch, APISpecs: wl.APISpecs, UserInputs: wl.UserInputs, Workloads: wl.Workloads}
+		_, id := cliutils.TrimOrg(org, wlId)
+		fmt.Printf("Migrating %s in the exchange...\n", wlId)
+		cliutils.ExchangePutPost(http.MethodPut, cliutils.GetExchangeUrl(), "orgs/"+org+"/workloads/"+id, cliutils.OrgAndCreds(org, userPw), []int{201}, workInput)
+	}
+
+	if migrated == 0 {
+		fmt.Println("No workloads need to be migrated")
+	}
+}
@@ -90,3 +90,47 @@ func NodeRemove(org, userPw, node string, force bool) {
 		cliutils.Fatal(cliutils.NOT_FOUND, "node '%s' not found in org %s", node, org)
 	}
 }
+
+func NodeListPolicy(org string, userPw string, node string) {
+	cliutils.SetWhetherUsingApiKey(userPw)
+	org, node = cliutils.TrimOrg(org, node)
+	var nodePolicy exchange.NodePolicy
+	httpCode := cliutils.ExchangeGet(cliutils.GetExchangeUrl(), "orgs/"+org+"/nodes/"+node+"/policy", cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &nodePolicy)
+	if httpCode == 404 {
+		cliutils.Fatal(cliutils.NOT_FOUND, "node policy for node '%s' not found in org %s", node, org)
+	}
+	output := cliutils.MarshalIndent(nodePolicy, "exchange node listpolicy")
+	fmt.Println(output)
+}
+
+// Publish the properties and constraints of a node that is not using a pattern, so that the agbots can match it with
+// business policies.
+func NodeAddPolicy(org string, userPw string, node string, jsonFilePath string) {
+	cliutils.SetWhetherUsingApiKey(userPw)
+	org, node = cliutils.TrimOrg(org, node)
+
+	newBytes := cliutils.ReadJsonFile(jsonFilePath)
+	var nodePolicy exchange.NodePolicy
+	if err := json.Unmarshal(newBytes, &nodePolicy); err != nil {
+		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to unmarshal json input file %s: %v", jsonFilePath, err)
+	} else if err := nodePolicy.IsValid(); err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "invalid node policy in %s: %v", jsonFilePath, err)
+	}
+	nodePolicy.LastUpdated = ""
+
+	fmt.Printf("Updating node policy for node %s in the exchange...\n", node)
+	cliutils.ExchangePutPost(http.MethodPut, cliutils.GetExchangeUrl(), "orgs/"+org+"/nodes/"+node+"/policy", cliutils.OrgAndCreds(org, userPw), []int{201}, nodePolicy)
+}
+
+func NodeRemovePolicy(org, userPw, node string, force bool) {
+	cliutils.SetWhetherUsingApiKey(userPw)
+	org, node = cliutils.TrimOrg(org, node)
+	if !force {
+		cliutils.ConfirmRemove("Are you sure you want to remove the node policy of node '" + org + "/" + node + "' from the Horizon Exchange?")
+	}
+
+	httpCode := cliutils.ExchangeDelete(cliutils.GetExchangeUrl(), "orgs/"+org+"/nodes/"+node+"/policy", cliutils.OrgAndCreds(org, userPw), []int{204, 404})
+	if httpCode == 404 {
+		cliutils.Fatal(cliutils.NOT_FOUND, "node policy for node '%s' not found in org %s", node, org)
+	}
+}
@@ -0,0 +1,103 @@
+package exchange
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"github.com/open-horizon/anax/exchange"
+	"net/http"
+	"reflect"
+)
+
+// The part of a node's exchange resource that identical devices have in common. It is copied from a node that is set
+// up the way the rest of the fleet should be, and applied to the other nodes, so that they all have the same exchange
+// state. The registered services are a hint, the agent on a node replaces them with the services it registers.
+type NodeConfigTemplate struct {
+	Pattern            string                   `json:"pattern"`
+	RegisteredServices []exchange.Microservice  `json:"registeredServices,omitempty"`
+	SoftwareVersions   exchange.SoftwareVersion `json:"softwareVersions,omitempty"`
+	PublicKey          []byte                   `json:"publicKey,omitempty"`
+}
+
+// Returns the template of the node's configuration. The number of agreements of each service is left out, it is not
+// configuration.
+func nodeConfigTemplate(dev exchange.Device) NodeConfigTemplate {
+	tmpl := NodeConfigTemplate{
+		Pattern:            dev.Pattern,
+		RegisteredServices: make([]exchange.Microservice, 0, len(dev.RegisteredServices)),
+		SoftwareVersions:   dev.SoftwareVersions,
+		PublicKey:          dev.PublicKey,
+	}
+	for _, svc := range dev.RegisteredServices {
+		svc.NumAgreements = 0
+		tmpl.RegisteredServices = append(tmpl.RegisteredServices, svc)
+	}
+	return tmpl
+}
+
+// Returns the exchange node PATCH bodies that make the node match the template, one per attribute because the
+// exchange only changes one attribute of a node per PATCH. Attributes that already match are left alone.
+func nodeConfigPatches(dev exchange.Device, tmpl NodeConfigTemplate) []map[string]interface{} {
+	patches := make([]map[string]interface{}, 0, 4)
+	if dev.Pattern != tmpl.Pattern {
+		patches = append(patches, map[string]interface{}{"pattern": tmpl.Pattern})
+	}
+	if current := nodeConfigTemplate(dev).RegisteredServices; len(tmpl.RegisteredServices) != 0 && !reflect.DeepEqual(current, tmpl.RegisteredServices) {
+		patches = append(patches, map[string]interface{}{"registeredServices": tmpl.RegisteredServices})
+	}
+	if len(tmpl.SoftwareVersions) != 0 && !reflect.DeepEqual(dev.SoftwareVersions, tmpl.SoftwareVersions) {
+		patches = append(patches, map[string]interface{}{"softwareVersions": tmpl.SoftwareVersions})
+	}
+	if len(tmpl.PublicKey) != 0 && !bytes.Equal(dev.PublicKey, tmpl.PublicKey) {
+		patches = append(patches, map[string]interface{}{"publicKey": tmpl.PublicKey})
+	}
+	return patches
+}
+
+func getExchangeNode(org string, userPw string, node string) exchange.Device {
+	var resp exchange.GetDevicesResponse
+	httpCode := cliutils.ExchangeGet(cliutils.GetExchangeUrl(), "orgs/"+org+"/nodes/"+node, cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &resp)
+	if httpCode == 404 {
+		cliutils.Fatal(cliutils.NOT_FOUND, "node '%s' not found in org %s", node, org)
+	}
+	dev, ok := resp.Devices[org+"/"+node]
+	if !ok {
+		cliutils.Fatal(cliutils.INTERNAL_ERROR, "key '%s' not found in exchange nodes output", org+"/"+node)
+	}
+	return dev
+}
+
+// Display the configuration of the node as a template that NodeApplyConfig can apply to other nodes.
+func NodeCopyConfig(org string, userPw string, node string) {
+	cliutils.SetWhetherUsingApiKey(userPw)
+	org, node = cliutils.TrimOrg(org, node)
+
+	output := cliutils.MarshalIndent(nodeConfigTemplate(getExchangeNode(org, userPw, node)), "exchange node copy-config")
+	fmt.Println(output)
+}
+
+// Update the nodes so that their configuration matches the template made by NodeCopyConfig.
+func NodeApplyConfig(org string, userPw string, nodes []string, templatePath string) {
+	cliutils.SetWhetherUsingApiKey(userPw)
+
+	var tmpl NodeConfigTemplate
+	if err := json.Unmarshal(cliutils.ReadJsonFile(templatePath), &tmpl); err != nil {
+		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to unmarshal json input file %s: %v", templatePath, err)
+	}
+
+	for _, n := range nodes {
+		nodeOrg, node := cliutils.TrimOrg(org, n)
+		patches := nodeConfigPatches(getExchangeNode(nodeOrg, userPw, node), tmpl)
+		if len(patches) == 0 {
+			fmt.Printf("Node %s/%s already matches the template.\n", nodeOrg, node)
+			continue
+		}
+		for _, patch := range patches {
+			for attr := range patch {
+				fmt.Printf("Updating %s of node %s/%s in the exchange...\n", attr, nodeOrg, node)
+			}
+			cliutils.ExchangePutPost(http.MethodPatch, cliutils.GetExchangeUrl(), "orgs/"+nodeOrg+"/nodes/"+node, cliutils.OrgAndCreds(nodeOrg, userPw), []int{201}, patch)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+// +build unit
+
+package exchange
+
+import (
+	"github.com/open-horizon/anax/exchange"
+	"testing"
+)
+
+func Test_NodeConfigTemplate(t *testing.T) {
+
+	gps := exchange.Microservice{Url: "https://bluehorizon.network/services/gps", Properties: []exchange.MSProp{{Name: "arch", Value: "arm", PropType: "string", Op: "in"}}, NumAgreements: 1}
+	source := exchange.Device{
+		Token:              "secret",
+		Name:               "node1",
+		Pattern:            "myorg/mypattern",
+		RegisteredServices: []exchange.Microservice{gps},
+		MsgEndPoint:        "endpoint1",
+		SoftwareVersions:   exchange.SoftwareVersion{"horizon": "2.17.2"},
+		PublicKey:          []byte("key1"),
+	}
+
+	tmpl := nodeConfigTemplate(source)
+	if tmpl.Pattern != "myorg/mypattern" || len(tmpl.RegisteredServices) != 1 || tmpl.SoftwareVersions["horizon"] != "2.17.2" || string(tmpl.PublicKey) != "key1" {
+		t.Errorf("template should have the configuration of the node, has %v", tmpl)
+	} else if tmpl.RegisteredServices[0].NumAgreements != 0 || source.RegisteredServices[0].NumAgreements != 1 {
+		t.Errorf("template should leave out the number of agreements, without changing the node, has %v", tmpl.RegisteredServices)
+	}
+
+	// The source node already matches its template, although its services have agreements.
+	if patches := nodeConfigPatches(source, tmpl); len(patches) != 0 {
+		t.Errorf("source node should match its template, patches are %v", patches)
+	}
+
+	// A new node gets every attribute, one per patch.
+	if patches := nodeConfigPatches(exchange.Device{Name: "node2"}, tmpl); len(patches) != 4 {
+		t.Errorf("new node should get 4 patches, gets %v", patches)
+	} else if patches[0]["pattern"] != "myorg/mypattern" || patches[3]["publicKey"] == nil {
+		t.Errorf("patches should be the pattern first and the public key last, are %v", patches)
+	}
+
+	// A template without a public key, or without registered services, leaves them as they are.
+	tmpl.PublicKey = nil
+	tmpl.RegisteredServices = nil
+	other := source
+	other.PublicKey = []byte("key2")
+	other.RegisteredServices = nil
+	other.SoftwareVersions = exchange.SoftwareVersion{"horizon": "2.16.0"}
+	if patches := nodeConfigPatches(other, tmpl); len(patches) != 1 || patches[0]["softwareVersions"] == nil {
+		t.Errorf("only the software versions should be patched, patches are %v", patches)
+	}
+}
@@ -0,0 +1,200 @@
+package exchange
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The org sub-commands manage the organizations of the exchange and the users in them, so that a new tenant can be set
+// up without calling the exchange APIs directly. Creating, updating and removing orgs needs the exchange root user,
+// for example -u root/root:PASSWORD. The org in the -o flag is only used for the credentials, the org being managed
+// is an argument of each sub-command.
+
+// We only care about handling the org names, so the rest is left as interface{} and will be passed from the exchange to the display
+type ExchangeOrgs struct {
+	Orgs      map[string]interface{} `json:"orgs"`
+	LastIndex int                    `json:"lastIndex"`
+}
+
+type OrgExchangeReq struct {
+	Label       string `json:"label"`
+	Description string `json:"description"`
+}
+
+func OrgList(org, userPw, theOrg string, namesOnly bool) {
+	cliutils.SetWhetherUsingApiKey(userPw)
+	var orgs ExchangeOrgs
+	httpCode := cliutils.ExchangeGet(cliutils.GetExchangeUrl(), "orgs"+cliutils.AddSlash(theOrg), cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &orgs)
+	if httpCode == 404 && theOrg != "" {
+		cliutils.Fatal(cliutils.NOT_FOUND, "org '%s' not found", theOrg)
+	}
+	if namesOnly && theOrg == "" {
+		// Only display the names
+		names := []string{}
+		for o := range orgs.Orgs {
+			names = append(names, o)
+		}
+		sort.Strings(names)
+		jsonBytes, err := json.MarshalIndent(names, "", cliutils.JSON_INDENT)
+		if err != nil {
+			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to marshal 'exchange org list' output: %v", err)
+		}
+		fmt.Printf("%s\n", jsonBytes)
+	} else {
+		output := cliutils.MarshalIndent(orgs.Orgs, "exchange org list")
+		fmt.Println(output)
+	}
+}
+
+func OrgCreate(org, userPw, theOrg, label, description string) {
+	cliutils.SetWhetherUsingApiKey(userPw)
+	if label == "" {
+		label = theOrg
+	}
+	postOrgReq := OrgExchangeReq{Label: label, Description: description}
+	cliutils.ExchangePutPost(http.MethodPost, cliutils.GetExchangeUrl(), "orgs/"+theOrg, cliutils.OrgAndCreds(org, userPw), []int{201}, postOrgReq)
+	fmt.Printf("Org %s created in the Horizon Exchange.\n", theOrg)
+}
+
+func OrgUpdate(org, userPw, theOrg, label, description string) {
+	cliutils.SetWhetherUsingApiKey(userPw)
+	if label == "" && description == "" {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "at least one of --label and --description must be specified")
+	}
+
+	// The exchange changes one attribute of an org at a time.
+	attrs := []map[string]string{}
+	if label != "" {
+		attrs = append(attrs, map[string]string{"label": label})
+	}
+	if description != "" {
+		attrs = append(attrs, map[string]string{"description": description})
+	}
+	for _, attr := range attrs {
+		httpCode := cliutils.ExchangePutPost(http.MethodPatch, cliutils.GetExchangeUrl(), "orgs/"+theOrg, cliutils.OrgAndCreds(org, userPw), []int{201, 404}, attr)
+		if httpCode == 404 {
+			cliutils.Fatal(cliutils.NOT_FOUND, "org '%s' not found", theOrg)
+		}
+	}
+}
+
+func OrgRemove(org, userPw, theOrg string, force bool) {
+	cliutils.SetWhetherUsingApiKey(userPw)
+	if !force {
+		cliutils.ConfirmRemove("Warning: this will also delete all Exchange resources in the org (users, nodes, agbots, services, patterns, etc). Are you sure you want to remove org '" + theOrg + "' from the Horizon Exchange?")
+	}
+
+	httpCode := cliutils.ExchangeDelete(cliutils.GetExchangeUrl(), "orgs/"+theOrg, cliutils.OrgAndCreds(org, userPw), []int{204, 404})
+	if httpCode == 404 {
+		cliutils.Fatal(cliutils.NOT_FOUND, "org '%s' not found", theOrg)
+	}
+}
+
+func OrgUserList(org, userPw, theOrg, user string) {
+	cliutils.SetWhetherUsingApiKey(userPw)
+	var users ExchangeUsers
+	httpCode := cliutils.ExchangeGet(cliutils.GetExchangeUrl(), "orgs/"+theOrg+"/users"+cliutils.AddSlash(user), cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &users)
+	if httpCode == 404 && user != "" {
+		cliutils.Fatal(cliutils.NOT_FOUND, "user '%s' not found in org %s", user, theOrg)
+	}
+	output := cliutils.MarshalIndent(users.Users, "exchange org user list")
+	fmt.Println(output)
+}
+
+// Create a user in any org, unlike 'hzn exchange user create' which creates the user in the public org with its own
+// credentials. The user can be made an admin of the org, who can then manage the rest of the org.
+func OrgUserCreate(org, userPw, theOrg, newUserPw, email string, admin bool) {
+	cliutils.SetWhetherUsingApiKey(userPw)
+	user, pw := cliutils.SplitIdToken(newUserPw)
+	if user == "" || pw == "" {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "the user to create must be specified as USER:PW")
+	}
+	postUserReq := cliutils.UserExchangeReq{Password: pw, Admin: admin, Email: email}
+	cliutils.ExchangePutPost(http.MethodPost, cliutils.GetExchangeUrl(), "orgs/"+theOrg+"/users/"+user, cliutils.OrgAndCreds(org, userPw), []int{201}, postUserReq)
+}
+
+func OrgUserRemove(org, userPw, theOrg, user string, force bool) {
+	cliutils.SetWhetherUsingApiKey(userPw)
+	if !force {
+		cliutils.ConfirmRemove("Warning: this will also delete all Exchange resources owned by this user (nodes, services, patterns, etc). Are you sure you want to remove user '" + theOrg + "/" + user + "' from the Horizon Exchange?")
+	}
+
+	httpCode := cliutils.ExchangeDelete(cliutils.GetExchangeUrl(), "orgs/"+theOrg+"/users/"+user, cliutils.OrgAndCreds(org, userPw), []int{204, 404})
+	if httpCode == 404 {
+		cliutils.Fatal(cliutils.NOT_FOUND, "user '%s' not found in org %s", user, theOrg)
+	}
+}
+
+// The exchange keeps the public signing keys with each service and pattern, the org key sub-commands manage a key
+// across all the services and patterns of an org, so that the nodes of a new tenant trust the tenant's signing key.
+
+// Returns the exchange paths of the services and patterns of an org, in order.
+func orgKeyResources(org, userPw, theOrg string) []string {
+	var services GetServicesResponse
+	cliutils.ExchangeGet(cliutils.GetExchangeUrl(), "orgs/"+theOrg+"/services", cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &services)
+	var patterns ExchangePatterns
+	cliutils.ExchangeGet(cliutils.GetExchangeUrl(), "orgs/"+theOrg+"/patterns", cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &patterns)
+
+	resources := []string{}
+	for id := range services.Services {
+		_, id = cliutils.TrimOrg(theOrg, id)
+		resources = append(resources, "orgs/"+theOrg+"/services/"+id)
+	}
+	for id := range patterns.Patterns {
+		_, id = cliutils.TrimOrg(theOrg, id)
+		resources = append(resources, "orgs/"+theOrg+"/patterns/"+id)
+	}
+	sort.Strings(resources)
+	return resources
+}
+
+// Display the names of the keys stored with each service and pattern of the org.
+func OrgListKey(org, userPw, theOrg string) {
+	cliutils.SetWhetherUsingApiKey(userPw)
+	keys := make(map[string][]string)
+	for _, resource := range orgKeyResources(org, userPw, theOrg) {
+		var keyNames []string
+		cliutils.ExchangeGet(cliutils.GetExchangeUrl(), resource+"/keys", cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &keyNames)
+		if keyNames == nil {
+			keyNames = []string{}
+		}
+		keys[strings.TrimPrefix(resource, "orgs/")] = keyNames
+	}
+	output := cliutils.MarshalIndent(keys, "exchange org listkey")
+	fmt.Println(output)
+}
+
+// Store a public key with every service and pattern of the org.
+func OrgAddKey(org, userPw, theOrg, pubKeyFile string) {
+	cliutils.SetWhetherUsingApiKey(userPw)
+	bodyBytes := cliutils.ReadFile(pubKeyFile)
+	baseName := filepath.Base(pubKeyFile)
+	for _, resource := range orgKeyResources(org, userPw, theOrg) {
+		fmt.Printf("Storing %s with %s in the exchange...\n", baseName, strings.TrimPrefix(resource, "orgs/"))
+		cliutils.ExchangePutPost(http.MethodPut, cliutils.GetExchangeUrl(), resource+"/keys/"+baseName, cliutils.OrgAndCreds(org, userPw), []int{201}, bodyBytes)
+	}
+}
+
+// Remove a public key from every service and pattern of the org that has it.
+func OrgRemoveKey(org, userPw, theOrg, keyName string, force bool) {
+	cliutils.SetWhetherUsingApiKey(userPw)
+	if !force {
+		cliutils.ConfirmRemove("Are you sure you want to remove key '" + keyName + "' from all the services and patterns of org '" + theOrg + "'? Nodes will no longer accept deployments signed only with it.")
+	}
+
+	found := false
+	for _, resource := range orgKeyResources(org, userPw, theOrg) {
+		if httpCode := cliutils.ExchangeDelete(cliutils.GetExchangeUrl(), resource+"/keys/"+keyName, cliutils.OrgAndCreds(org, userPw), []int{204, 404}); httpCode == 204 {
+			fmt.Printf("Removed %s from %s.\n", keyName, strings.TrimPrefix(resource, "orgs/"))
+			found = true
+		}
+	}
+	if !found {
+		cliutils.Fatal(cliutils.NOT_FOUND, "key '%s' not found in org %s", keyName, theOrg)
+	}
+}
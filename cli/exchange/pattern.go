@@ -47,12 +47,14 @@ type WorkloadChoiceFile struct {
 	DeploymentOverridesSignature string      `json:"deployment_overrides_signature"` // signature of env var overrides
 }
 type WorkloadReferenceFile struct {
-	WorkloadURL      string                    `json:"workloadUrl"`      // refers to a workload definition in the exchange
-	WorkloadOrg      string                    `json:"workloadOrgid"`    // the org holding the workload definition
-	WorkloadArch     string                    `json:"workloadArch"`     // the hardware architecture of the workload definition
-	WorkloadVersions []WorkloadChoiceFile      `json:"workloadVersions"` // a list of workload version for rollback
-	DataVerify       exchange.DataVerification `json:"dataVerification"` // policy for verifying that the node is sending data
-	NodeH            exchange.NodeHealth       `json:"nodeHealth"`       // policy for determining when a node's health is violating its agreements
+	WorkloadURL      string                    `json:"workloadUrl"`           // refers to a workload definition in the exchange
+	WorkloadOrg      string                    `json:"workloadOrgid"`         // the org holding the workload definition
+	WorkloadArch     string                    `json:"workloadArch"`          // the hardware architecture of the workload definition
+	WorkloadVersions []WorkloadChoiceFile      `json:"workloadVersions"`      // a list of workload version for rollback
+	DataVerify       exchange.DataVerification `json:"dataVerification"`      // policy for verifying that the node is sending data
+	NodeH            exchange.NodeHealth       `json:"nodeHealth"`            // policy for determining when a node's health is violating its agreements
+	Placement        *exchange.Placement       `json:"placement,omitempty"`   // which other workloads must or must not be on the node
+	TimeWindows      []exchange.TimeWindow     `json:"timeWindows,omitempty"` // the times of day during which the workload can run
 }
 type ServiceChoiceFile struct {
 	Version                      string                    `json:"version"`  // the version of the service
@@ -69,6 +71,8 @@ type ServiceReferenceFile struct {
 	ServiceVersions []ServiceChoiceFile       `json:"serviceVersions"`         // a list of service version for rollback
 	DataVerify      exchange.DataVerification `json:"dataVerification"`        // policy for verifying that the node is sending data
 	NodeH           exchange.NodeHealth       `json:"nodeHealth"`              // policy for determining when a node's health is violating its agreements
+	Placement       *exchange.Placement       `json:"placement,omitempty"`     // which other services must or must not be on the node
+	TimeWindows     []exchange.TimeWindow     `json:"timeWindows,omitempty"`   // the times of day during which the service can run
 }
 type PatternFile struct {
 	Org                string                       `json:"org"` // optional
@@ -90,12 +94,14 @@ type WorkloadChoice struct {
 	DeploymentOverridesSignature string                    `json:"deployment_overrides_signature"` // signature of env var overrides
 }
 type WorkloadReference struct {
-	WorkloadURL      string                    `json:"workloadUrl"`      // refers to a workload definition in the exchange
-	WorkloadOrg      string                    `json:"workloadOrgid"`    // the org holding the workload definition
-	WorkloadArch     string                    `json:"workloadArch"`     // the hardware architecture of the workload definition
-	WorkloadVersions []WorkloadChoice          `json:"workloadVersions"` // a list of workload version for rollback
-	DataVerify       exchange.DataVerification `json:"dataVerification"` // policy for verifying that the node is sending data
-	NodeH            exchange.NodeHealth       `json:"nodeHealth"`       // policy for determining when a node's health is violating its agreements
+	WorkloadURL      string                    `json:"workloadUrl"`           // refers to a workload definition in the exchange
+	WorkloadOrg      string                    `json:"workloadOrgid"`         // the org holding the workload definition
+	WorkloadArch     string                    `json:"workloadArch"`          // the hardware architecture of the workload definition
+	WorkloadVersions []WorkloadChoice          `json:"workloadVersions"`      // a list of workload version for rollback
+	DataVerify       exchange.DataVerification `json:"dataVerification"`      // policy for verifying that the node is sending data
+	NodeH            exchange.NodeHealth       `json:"nodeHealth"`            // policy for determining when a node's health is violating its agreements
+	Placement        *exchange.Placement       `json:"placement,omitempty"`   // which other workloads must or must not be on the node
+	TimeWindows      []exchange.TimeWindow     `json:"timeWindows,omitempty"` // the times of day during which the workload can run
 }
 type ServiceChoice struct {
 	Version                      string                    `json:"version"`  // the version of the service
@@ -112,6 +118,8 @@ type ServiceReference struct {
 	ServiceVersions []ServiceChoice           `json:"serviceVersions"`         // a list of service version for rollback
 	DataVerify      exchange.DataVerification `json:"dataVerification"`        // policy for verifying that the node is sending data
 	NodeH           exchange.NodeHealth       `json:"nodeHealth"`              // policy for determining when a node's health is violating its agreements
+	Placement       *exchange.Placement       `json:"placement,omitempty"`     // which other services must or must not be on the node
+	TimeWindows     []exchange.TimeWindow     `json:"timeWindows,omitempty"`   // the times of day during which the service can run
 }
 type PatternInput struct {
 	Label              string                       `json:"label"`
@@ -188,7 +196,7 @@ func ConvertToDeploymentOverrides(deployment interface{}) *DeploymentOverrides {
 }
 
 // PatternPublish signs the MS def and puts it in the exchange
-func PatternPublish(org, userPw, jsonFilePath, keyFilePath, pubKeyFilePath, patName string) {
+func PatternPublish(org, userPw, jsonFilePath, keyFilePath, pubKeyFilePath, patName string, verifyRefs bool) {
 	cliutils.SetWhetherUsingApiKey(userPw)
 	// Read in the pattern metadata
 	newBytes := cliutils.ReadJsonFile(jsonFilePath)
@@ -216,6 +224,8 @@ func PatternPublish(org, userPw, jsonFilePath, keyFilePath, pubKeyFilePath, patN
 			patInput.Services[i].ServiceVersions = make([]ServiceChoice, len(patFile.Services[i].ServiceVersions))
 			patInput.Services[i].DataVerify = patFile.Services[i].DataVerify
 			patInput.Services[i].NodeH = patFile.Services[i].NodeH
+			patInput.Services[i].Placement = patFile.Services[i].Placement
+			patInput.Services[i].TimeWindows = patFile.Services[i].TimeWindows
 			for j := range patFile.Services[i].ServiceVersions {
 				patInput.Services[i].ServiceVersions[j].Version = patFile.Services[i].ServiceVersions[j].Version
 				patInput.Services[i].ServiceVersions[j].Priority = patFile.Services[i].ServiceVersions[j].Priority
@@ -259,6 +269,8 @@ func PatternPublish(org, userPw, jsonFilePath, keyFilePath, pubKeyFilePath, patN
 			patInput.Workloads[i].WorkloadVersions = make([]WorkloadChoice, len(patFile.Workloads[i].WorkloadVersions))
 			patInput.Workloads[i].DataVerify = patFile.Workloads[i].DataVerify
 			patInput.Workloads[i].NodeH = patFile.Workloads[i].NodeH
+			patInput.Workloads[i].Placement = patFile.Workloads[i].Placement
+			patInput.Workloads[i].TimeWindows = patFile.Workloads[i].TimeWindows
 			for j := range patFile.Workloads[i].WorkloadVersions {
 				patInput.Workloads[i].WorkloadVersions[j].Version = patFile.Workloads[i].WorkloadVersions[j].Version
 				patInput.Workloads[i].WorkloadVersions[j].Priority = patFile.Workloads[i].WorkloadVersions[j].Priority
@@ -295,6 +307,11 @@ func PatternPublish(org, userPw, jsonFilePath, keyFilePath, pubKeyFilePath, patN
 		}
 	}
 
+	// Fail before anything is published if nodes would reject agreements for the referenced workloads or services
+	if verifyRefs {
+		verifyPatternReferences(org, userPw, &patInput)
+	}
+
 	// Create or update resource in the exchange
 	var exchId string
 	if patName != "" {
@@ -446,6 +463,8 @@ func PatternAddWorkload(org, userPw, pattern, workloadFilePath, keyFilePath, pub
 	workInput.WorkloadVersions = make([]WorkloadChoice, len(workFile.WorkloadVersions))
 	workInput.DataVerify = workFile.DataVerify
 	workInput.NodeH = workFile.NodeH
+	workInput.Placement = workFile.Placement
+	workInput.TimeWindows = workFile.TimeWindows
 	for i := range workFile.WorkloadVersions {
 		cliutils.Verbose("signing deployment_overrides string in workloadVersion element number %d", i+1)
 		workInput.WorkloadVersions[i].Version = workFile.WorkloadVersions[i].Version
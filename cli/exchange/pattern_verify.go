@@ -0,0 +1,197 @@
+package exchange
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/open-horizon/anax/anaxerrors"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/policy"
+	"github.com/open-horizon/rsapss-tool/verify"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// The result of checking one workload or service version referenced by a pattern. A node that is given an agreement
+// for a version whose definition is missing from the exchange, or whose deployment signature does not verify, will
+// reject the agreement, so these are checked before the pattern is published.
+type PatternReferenceCheck struct {
+	Type     string   `json:"type"` // workload or service
+	Org      string   `json:"org"`
+	Url      string   `json:"url"`
+	Version  string   `json:"version"`
+	Arch     string   `json:"arch"`
+	Verified bool     `json:"verified"`
+	Reasons  []string `json:"reasons,omitempty"` // why the reference did not verify
+}
+
+// Check every workload and service version referenced by the pattern.
+func checkPatternReferences(exchUrl, org, userPw string, patInput *PatternInput) []PatternReferenceCheck {
+	checks := make([]PatternReferenceCheck, 0)
+	for _, sref := range patInput.Services {
+		for _, sv := range sref.ServiceVersions {
+			check := PatternReferenceCheck{Type: "service", Org: sref.ServiceOrg, Url: sref.ServiceURL, Version: sv.Version, Arch: sref.ServiceArch}
+			checkServiceReference(exchUrl, org, userPw, &check)
+			checks = append(checks, check)
+		}
+	}
+	for _, wref := range patInput.Workloads {
+		for _, wv := range wref.WorkloadVersions {
+			check := PatternReferenceCheck{Type: "workload", Org: wref.WorkloadOrg, Url: wref.WorkloadURL, Version: wv.Version, Arch: wref.WorkloadArch}
+			checkWorkloadReference(exchUrl, org, userPw, &check)
+			checks = append(checks, check)
+		}
+	}
+	return checks
+}
+
+func checkServiceReference(exchUrl, org, userPw string, check *PatternReferenceCheck) {
+	defer func() { check.Verified = len(check.Reasons) == 0 }()
+
+	// A service for any hardware architecture is checked in every arch it is published for.
+	exchIds := make([]string, 0)
+	var svcOutput GetServicesResponse
+	if check.Arch == policy.ANY_ARCH {
+		cliutils.ExchangeGet(exchUrl, "orgs/"+check.Org+"/services?url="+check.Url+"&version="+check.Version, cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &svcOutput)
+		for id, _ := range svcOutput.Services {
+			exchIds = append(exchIds, exchange.GetId(id))
+		}
+		sort.Strings(exchIds)
+		if len(exchIds) == 0 {
+			check.Reasons = append(check.Reasons, fmt.Sprintf("service %s/%s version %s not found in the exchange for any architecture", check.Org, check.Url, check.Version))
+			return
+		}
+	} else {
+		exchId := cliutils.FormExchangeId(check.Url, check.Version, check.Arch)
+		cliutils.ExchangeGet(exchUrl, "orgs/"+check.Org+"/services/"+exchId, cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &svcOutput)
+		if _, ok := svcOutput.Services[check.Org+"/"+exchId]; !ok {
+			check.Reasons = append(check.Reasons, fmt.Sprintf("service %s/%s not found in the exchange", check.Org, exchId))
+			return
+		}
+		exchIds = append(exchIds, exchId)
+	}
+
+	for _, exchId := range exchIds {
+		// A service without a deployment has no containers, so there is nothing for the node to verify.
+		svc := svcOutput.Services[check.Org+"/"+exchId]
+		if svc.Deployment == "" {
+			continue
+		}
+		keys := getSigningKeys(exchUrl, org, userPw, "orgs/"+check.Org+"/services/"+exchId)
+		if err := verifyDeploymentSignature(keys, svc.DeploymentSignature, svc.Deployment); err != nil {
+			check.Reasons = append(check.Reasons, fmt.Sprintf("service %s/%s: %v", check.Org, exchId, err))
+		}
+	}
+}
+
+func checkWorkloadReference(exchUrl, org, userPw string, check *PatternReferenceCheck) {
+	defer func() { check.Verified = len(check.Reasons) == 0 }()
+
+	exchId := cliutils.FormExchangeId(check.Url, check.Version, check.Arch)
+	var workOutput exchange.GetWorkloadsResponse
+	cliutils.ExchangeGet(exchUrl, "orgs/"+check.Org+"/workloads/"+exchId, cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &workOutput)
+	work, ok := workOutput.Workloads[check.Org+"/"+exchId]
+	if !ok {
+		check.Reasons = append(check.Reasons, fmt.Sprintf("workload %s/%s not found in the exchange", check.Org, exchId))
+		return
+	}
+
+	var keys map[string][]byte
+	for i, wd := range work.Workloads {
+		if wd.Deployment == "" {
+			continue
+		} else if keys == nil {
+			keys = getSigningKeys(exchUrl, org, userPw, "orgs/"+check.Org+"/workloads/"+exchId)
+		}
+		if err := verifyDeploymentSignature(keys, wd.DeploymentSignature, wd.Deployment); err != nil {
+			check.Reasons = append(check.Reasons, fmt.Sprintf("workload %s/%s deployment %d: %v", check.Org, exchId, i+1, err))
+		}
+	}
+}
+
+// Get the public keys stored with a workload or service in the exchange, keyed by key name.
+func getSigningKeys(exchUrl, org, userPw, resourcePath string) map[string][]byte {
+	keys := make(map[string][]byte)
+	keyNames := make([]string, 0)
+	cliutils.ExchangeGet(exchUrl, resourcePath+"/keys", cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &keyNames)
+	for _, keyName := range keyNames {
+		var content []byte
+		if httpCode := cliutils.ExchangeGet(exchUrl, resourcePath+"/keys/"+keyName, cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &content); httpCode == 200 && len(content) > 0 {
+			keys[keyName] = content
+		}
+	}
+	return keys
+}
+
+// Verify a deployment signature with any of the keys, the same way the agent does.
+func verifyDeploymentSignature(keys map[string][]byte, signature string, deployment string) error {
+	_, err := findSigningKey(keys, signature, deployment)
+	return err
+}
+
+// Find which of the keys verifies a deployment signature, and return its name. The keys are written to a temporary
+// directory because the verification works on key files.
+func findSigningKey(keys map[string][]byte, signature string, deployment string) (string, error) {
+	if signature == "" {
+		return "", anaxerrors.New(anaxerrors.SIGNATURE, "the deployment is not signed")
+	} else if len(keys) == 0 {
+		return "", anaxerrors.New(anaxerrors.SIGNATURE, "no public keys are stored with it in the exchange, so nodes can not verify its deployment signature")
+	}
+
+	dir, err := ioutil.TempDir("", "hzn-keys")
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("unable to create a directory for the public keys: %v", err))
+	}
+	defer os.RemoveAll(dir)
+
+	keyFiles := make([]string, 0, len(keys))
+	keyNames := make(map[string]string)
+	for keyName, content := range keys {
+		keyFile := path.Join(dir, path.Base(keyName))
+		if err := ioutil.WriteFile(keyFile, content, 0600); err != nil {
+			return "", errors.New(fmt.Sprintf("unable to write public key %v: %v", keyName, err))
+		}
+		keyFiles = append(keyFiles, keyFile)
+		keyNames[keyFile] = keyName
+	}
+	sort.Strings(keyFiles)
+
+	verified, keyFile, failed := verify.InputVerifiedByAnyKey(keyFiles, signature, []byte(deployment))
+	if !verified {
+		reasons := make([]string, 0, len(failed))
+		for keyFile, err := range failed {
+			reasons = append(reasons, fmt.Sprintf("%v: %v", path.Base(keyFile), err))
+		}
+		sort.Strings(reasons)
+		return "", anaxerrors.New(anaxerrors.SIGNATURE, fmt.Sprintf("the deployment signature does not verify with any of the public keys stored with it (%v)", strings.Join(reasons, ", ")))
+	}
+	return keyNames[keyFile], nil
+}
+
+// Check the references of the pattern and display the report. Exits without returning if any reference failed.
+func verifyPatternReferences(org, userPw string, patInput *PatternInput) {
+	fmt.Println("Verifying the workloads and services referenced by the pattern...")
+	checks := checkPatternReferences(cliutils.GetExchangeUrl(), org, userPw, patInput)
+
+	failed := 0
+	for _, c := range checks {
+		if !c.Verified {
+			failed += 1
+		}
+	}
+	if failed == 0 {
+		fmt.Printf("All %d references verified\n", len(checks))
+		return
+	}
+
+	jsonBytes, err := json.MarshalIndent(checks, "", cliutils.JSON_INDENT)
+	if err != nil {
+		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to marshal the pattern verification report: %v", err)
+	}
+	fmt.Printf("%s\n", jsonBytes)
+	cliutils.Fatal(cliutils.SIGNATURE_INVALID, "%d of the %d references failed verification, the pattern was not published", failed, len(checks))
+}
@@ -0,0 +1,89 @@
+// +build unit
+
+package exchange
+
+import (
+	"encoding/json"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"github.com/open-horizon/anax/exchange"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_checkPatternReferences(t *testing.T) {
+
+	verbose := false
+	cliutils.Opts.Verbose = &verbose
+
+	svcId := func(version string) string {
+		return cliutils.FormExchangeId("http://mydomain.com/svc", version, "amd64")
+	}
+
+	// An exchange with 3 versions of a service: 1.0.0 has no containers, 1.1.0 is not signed and 1.2.0 is signed but
+	// has no keys stored with it. Version 2.0.0 is not in the exchange. The workload has no deployments.
+	services := map[string]ServiceExch{
+		"myorg/" + svcId("1.0.0"): ServiceExch{},
+		"myorg/" + svcId("1.1.0"): ServiceExch{Deployment: "{}"},
+		"myorg/" + svcId("1.2.0"): ServiceExch{Deployment: "{}", DeploymentSignature: "sig"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if len(parts) == 4 && parts[2] == "services" {
+			if svc, ok := services[parts[1]+"/"+parts[3]]; ok {
+				json.NewEncoder(w).Encode(GetServicesResponse{Services: map[string]ServiceExch{parts[1] + "/" + parts[3]: svc}})
+				return
+			}
+		} else if len(parts) == 4 && parts[2] == "workloads" {
+			json.NewEncoder(w).Encode(exchange.GetWorkloadsResponse{Workloads: map[string]exchange.WorkloadDefinition{parts[1] + "/" + parts[3]: exchange.WorkloadDefinition{}}})
+			return
+		} else if len(parts) == 5 && parts[4] == "keys" {
+			w.Write([]byte("[]"))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	patInput := &PatternInput{
+		Services: []ServiceReference{{
+			ServiceURL:      "http://mydomain.com/svc",
+			ServiceOrg:      "myorg",
+			ServiceArch:     "amd64",
+			ServiceVersions: []ServiceChoice{{Version: "1.0.0"}, {Version: "1.1.0"}, {Version: "1.2.0"}, {Version: "2.0.0"}},
+		}},
+		Workloads: []WorkloadReference{{
+			WorkloadURL:      "http://mydomain.com/wl",
+			WorkloadOrg:      "myorg",
+			WorkloadArch:     "amd64",
+			WorkloadVersions: []WorkloadChoice{{Version: "1.0.0"}},
+		}},
+	}
+
+	checks := checkPatternReferences(server.URL, "myorg", "user:pw", patInput)
+	if len(checks) != 5 {
+		t.Fatalf("expected 5 checks, got %v", checks)
+	}
+
+	expected := []struct {
+		verified bool
+		reason   string
+	}{
+		{true, ""},
+		{false, "not signed"},
+		{false, "no public keys"},
+		{false, "not found"},
+		{true, ""},
+	}
+	for i, e := range expected {
+		if checks[i].Verified != e.verified {
+			t.Errorf("check %v should be verified %v", checks[i], e.verified)
+		} else if !e.verified && (len(checks[i].Reasons) != 1 || !strings.Contains(checks[i].Reasons[0], e.reason)) {
+			t.Errorf("check %v should fail because %v", checks[i], e.reason)
+		}
+	}
+	if checks[4].Type != "workload" {
+		t.Errorf("last check should be for the workload, was %v", checks[4])
+	}
+}
@@ -19,7 +19,7 @@ import (
 )
 
 // This can't be a const because a map literal isn't a const in go
-var VALID_DEPLOYMENT_FIELDS = map[string]int8{"image": 1, "privileged": 1, "cap_add": 1, "environment": 1, "devices": 1, "binds": 1, "specific_ports": 1, "command": 1, "ports": 1}
+var VALID_DEPLOYMENT_FIELDS = map[string]int8{"image": 1, "privileged": 1, "cap_add": 1, "environment": 1, "devices": 1, "device_mappings": 1, "gpu": 1, "binds": 1, "specific_ports": 1, "command": 1, "ports": 1}
 
 type AbstractServiceFile interface {
 	GetOrg() string
@@ -45,6 +45,7 @@ type ServiceFile struct {
 	Deployment          interface{}                  `json:"deployment"` // interface{} because pre-signed services can be stringified json
 	DeploymentSignature string                       `json:"deploymentSignature"`
 	ImageStore          map[string]interface{}       `json:"imageStore"`
+	ImageFetch          *cutil.ImageFetch            `json:"imageFetch,omitempty"`
 }
 
 type GetServicesResponse struct {
@@ -68,6 +69,7 @@ type ServiceExch struct {
 	Deployment          string                       `json:"deployment"`
 	DeploymentSignature string                       `json:"deploymentSignature"`
 	ImageStore          map[string]interface{}       `json:"imageStore"`
+	ImageFetch          *cutil.ImageFetch            `json:"imageFetch,omitempty"`
 	LastUpdated         string                       `json:"lastUpdated,omitempty"`
 }
 
@@ -184,7 +186,7 @@ func ServiceList(org, userPw, service string, namesOnly bool) {
 }
 
 // ServicePublish signs the MS def and puts it in the exchange
-func ServicePublish(org, userPw, jsonFilePath, keyFilePath, pubKeyFilePath string, dontTouchImage bool, registryTokens []string) {
+func ServicePublish(org, userPw, jsonFilePath, keyFilePath, pubKeyFilePath string, dontTouchImage bool, registryTokens []string, strict bool) {
 	cliutils.SetWhetherUsingApiKey(userPw)
 	// Read in the service metadata
 	newBytes := cliutils.ReadJsonFile(jsonFilePath)
@@ -196,7 +198,7 @@ func ServicePublish(org, userPw, jsonFilePath, keyFilePath, pubKeyFilePath strin
 	if svcFile.Org != "" && svcFile.Org != org {
 		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "the org specified in the input file (%s) must match the org specified on the command line (%s)", svcFile.Org, org)
 	}
-	svcFile.SignAndPublish(org, userPw, keyFilePath, pubKeyFilePath, dontTouchImage, registryTokens)
+	svcFile.SignAndPublish(org, userPw, keyFilePath, pubKeyFilePath, dontTouchImage, registryTokens, strict)
 }
 
 // CheckDeploymentService verifies it has the required 'image' key, and checks for keys we don't recognize.
@@ -231,11 +233,13 @@ func SignImagesFromDeploymentMap(deployment map[string]interface{}, dontTouchIma
 				CheckDeploymentService(k, s)
 				switch image := s["image"].(type) {
 				case string:
-					domain, path, tag, digest := cutil.ParseDockerImagePath(image)
-					cliutils.Verbose("%s parsed into: domain=%s, path=%s, tag=%s", image, domain, path, tag)
-					if path == "" {
-						fmt.Printf("Warning: could not parse image path '%v'. Not pushing it to a docker registry, just including it in the 'deployment' field as-is.\n", image)
-					} else if digest == "" {
+					ref, err := cutil.ParseImageReference(image)
+					if err != nil {
+						fmt.Printf("Warning: %v. Not pushing it to a docker registry, just including it in the 'deployment' field as-is.\n", err)
+						continue
+					}
+					cliutils.Verbose("%s parsed into: registry=%s, repository=%s, tag=%s", image, ref.Registry, ref.Repository, ref.Tag)
+					if ref.Digest == "" {
 						// This image has a tag, or default tag
 						if dontTouchImage {
 							imageList = append(imageList, image)
@@ -244,11 +248,8 @@ func SignImagesFromDeploymentMap(deployment map[string]interface{}, dontTouchIma
 							if client == nil {
 								client = cliutils.NewDockerClient()
 							}
-							digest := cliutils.PushDockerImage(client, domain, path, tag) // this will error out if the push fails or can't get the digest
-							if domain != "" {
-								domain = domain + "/"
-							}
-							newImage := domain + path + "@" + digest
+							digest := cliutils.PushDockerImage(client, ref.Registry, ref.Repository, ref.Tag) // this will error out if the push fails or can't get the digest
+							newImage := cutil.ImageReference{Registry: ref.Registry, Repository: ref.Repository, Digest: digest}.String()
 							fmt.Printf("Using '%s' in 'deployment' field instead of '%s'\n", newImage, image)
 							s["image"] = newImage
 						}
@@ -265,8 +266,9 @@ func SignImagesFromDeploymentMap(deployment map[string]interface{}, dontTouchIma
 }
 
 // Sign and publish the service definition. This is a function that is reusable across different hzn commands.
-func (sf *ServiceFile) SignAndPublish(org, userPw, keyFilePath, pubKeyFilePath string, dontTouchImage bool, registryTokens []string) {
-	svcInput := ServiceExch{Label: sf.Label, Description: sf.Description, Public: sf.Public, URL: sf.URL, Version: sf.Version, Arch: sf.Arch, Sharable: sf.Sharable, MatchHardware: sf.MatchHardware, RequiredServices: sf.RequiredServices, UserInputs: sf.UserInputs, ImageStore: sf.ImageStore}
+// When strict is set, the deployment config is validated before it is signed.
+func (sf *ServiceFile) SignAndPublish(org, userPw, keyFilePath, pubKeyFilePath string, dontTouchImage bool, registryTokens []string, strict bool) {
+	svcInput := ServiceExch{Label: sf.Label, Description: sf.Description, Public: sf.Public, URL: sf.URL, Version: sf.Version, Arch: sf.Arch, Sharable: sf.Sharable, MatchHardware: sf.MatchHardware, RequiredServices: sf.RequiredServices, UserInputs: sf.UserInputs, ImageStore: sf.ImageStore, ImageFetch: CheckImageFetchField(sf.ImageFetch, "the service")}
 	var imageList []string
 
 	// The deployment field can be json object (map), string (for pre-signed), or nil
@@ -279,6 +281,10 @@ func (sf *ServiceFile) SignAndPublish(org, userPw, keyFilePath, pubKeyFilePath s
 		svcInput.DeploymentSignature = ""
 
 	case map[string]interface{}:
+		if strict {
+			CheckDeployment("deployment", dep)
+		}
+
 		// Go thru the docker image paths to push/get sha256 tag and/or gather list of images that user needs to push
 		if storeType, ok := svcInput.ImageStore["storeType"]; !ok || storeType != "imageServer" {
 			imageList = SignImagesFromDeploymentMap(dep, dontTouchImage)
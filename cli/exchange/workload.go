@@ -114,7 +114,7 @@ func WorkloadList(org, userPw, workload string, namesOnly bool) {
 }
 
 // WorkloadPublish signs the MS def and puts it in the exchange
-func WorkloadPublish(org, userPw, jsonFilePath, keyFilePath, pubKeyFilePath string, dontTouchImage bool) {
+func WorkloadPublish(org, userPw, jsonFilePath, keyFilePath, pubKeyFilePath string, dontTouchImage bool, strict bool) {
 	cliutils.SetWhetherUsingApiKey(userPw)
 	// Read in the workload metadata
 	newBytes := cliutils.ReadJsonFile(jsonFilePath)
@@ -126,11 +126,12 @@ func WorkloadPublish(org, userPw, jsonFilePath, keyFilePath, pubKeyFilePath stri
 	if workFile.Org != "" && workFile.Org != org {
 		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "the org specified in the input file (%s) must match the org specified on the command line (%s)", workFile.Org, org)
 	}
-	workFile.SignAndPublish(org, userPw, keyFilePath, pubKeyFilePath, dontTouchImage)
+	workFile.SignAndPublish(org, userPw, keyFilePath, pubKeyFilePath, dontTouchImage, strict)
 }
 
 // Sign and publish the workload definition. This is a function that is reusable across different hzn commands.
-func (wf *WorkloadFile) SignAndPublish(org, userPw, keyFilePath, pubKeyFilePath string, dontTouchImage bool) {
+// When strict is set, the deployment config is validated before it is signed.
+func (wf *WorkloadFile) SignAndPublish(org, userPw, keyFilePath, pubKeyFilePath string, dontTouchImage bool, strict bool) {
 	workInput := WorkloadInput{Label: wf.Label, Description: wf.Description, Public: wf.Public, WorkloadURL: wf.WorkloadURL, Version: wf.Version, Arch: wf.Arch, APISpecs: wf.APISpecs, UserInputs: wf.UserInputs, Workloads: make([]exchange.WorkloadDeployment, len(wf.Workloads))}
 
 	// Loop thru the workloads array and sign the deployment strings
@@ -150,8 +151,12 @@ func (wf *WorkloadFile) SignAndPublish(org, userPw, keyFilePath, pubKeyFilePath
 			workInput.Workloads[i].Deployment = ""
 			workInput.Workloads[i].DeploymentSignature = ""
 		} else {
+			if strict {
+				CheckDeployment(fmt.Sprintf("workloads[%d].deployment", i), wf.Workloads[i].Deployment)
+			}
+
 			// Go thru the docker image paths to push/get sha256 tag and/or gather list of images that user needs to push
-			imageList = SignImagesFromDeploymentField(depConfig, dontTouchImage)
+			imageList = SignImagesFromDeploymentField(depConfig, dontTouchImage, nil)
 
 			fmt.Printf("Signing deployment string %d\n", i+1)
 			deployment, err = json.Marshal(depConfig)
@@ -169,9 +174,8 @@ func (wf *WorkloadFile) SignAndPublish(org, userPw, keyFilePath, pubKeyFilePath
 			}
 		}
 
-		workInput.Workloads[i].Torrent = wf.Workloads[i].Torrent
-
-		CheckTorrentField(workInput.Workloads[i].Torrent, i)
+		workInput.Workloads[i].Torrent = CheckTorrentField(wf.Workloads[i].Torrent, i)
+		workInput.Workloads[i].ImageFetch = CheckImageFetchField(wf.Workloads[i].ImageFetch, fmt.Sprintf("workload %d", i+1))
 	}
 
 	// Create or update resource in the exchange
@@ -3,6 +3,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"github.com/open-horizon/anax/cli/agreement"
 	"github.com/open-horizon/anax/cli/agreementbot"
 	"github.com/open-horizon/anax/cli/attribute"
@@ -15,6 +16,7 @@ import (
 	"github.com/open-horizon/anax/cli/register"
 	"github.com/open-horizon/anax/cli/service"
 	"github.com/open-horizon/anax/cli/status"
+	"github.com/open-horizon/anax/cli/support"
 	"github.com/open-horizon/anax/cli/unregister"
 	"github.com/open-horizon/anax/cli/utilcmds"
 	"github.com/open-horizon/anax/cli/wiotp"
@@ -36,16 +38,32 @@ func main() {
 Environment Variables:
   HORIZON_URL:  Override the URL at which hzn contacts the Horizon Agent API.
       This can facilitate using a remote Horizon Agent via an ssh tunnel.
+  HZN_AGBOT_URL:  Override the URL at which hzn contacts the agbot API.
+  HZN_API_CA_CERT:  The CA cert file to trust when the agent or agbot API is
+      served over https with a self-signed cert. See 'hzn util cert fetch'.
+  HZN_API_CLIENT_CERT, HZN_API_CLIENT_KEY:  The client cert and key files to
+      authenticate with, when a remote agent or agbot API requires it.
+  HZN_API_TOKEN:  A token to authenticate with instead of a client cert.
   HZN_EXCHANGE_URL:  Override the URL that the 'hzn exchange' sub-commands use
       to communicate with the Horizon Exchange, for example
       https://exchange.bluehorizon.network/api/v1. (By default hzn will ask the
       Horizon Agent for the URL.)
-  HZN_ORG_ID:  Default value for the 'hzn exchange -o' or 'hzn wiotp -o' flag,
-      to specify the organization ID'.
+  HZN_EXCHANGE_MAX_RETRIES:  The number of times a call to the Horizon Exchange
+      is retried after a transient error. The default is 3.
+  HZN_EXCHANGE_RETRY_INTERVAL_MS:  The number of milliseconds to wait before the
+      first retry of a call to the Horizon Exchange. The wait is doubled for
+      every further retry. The default is 1000.
+  HZN_ORG_ID:  Default value for the 'hzn exchange -o', 'hzn key -o' or 'hzn
+      wiotp -o' flag, to specify the organization ID'.
   HZN_EXCHANGE_USER_AUTH:  Default value for the 'hzn exchange -u' or 'hzn
       register -u' flag, in the form '[org/]user:pw'.
+  (If neither the flag nor HZN_ORG_ID or HZN_EXCHANGE_USER_AUTH is set, the
+      values cached in the OS keyring by 'hzn login' are used.)
   HZN_EXCHANGE_API_AUTH:  Default value for the 'hzn wiotp -A' flag, in the
       form 'apikey:apitoken'.
+  HZN_KEYSTORE:  The directory of the local keystore used by 'hzn key --name'
+      and by the key name arguments of the publish and verify commands. The
+      default is ~/.hzn/keys.
   HZN_DONT_SUBST_ENV_VARS:  Set this to "1" to indicate that input json files
       should *not* be processed to replace environment variable references with
       their values.
@@ -57,9 +75,15 @@ Environment Variables:
 	app.UsageTemplate(kingpin.CompactUsageTemplate)
 	cliutils.Opts.Verbose = app.Flag("verbose", "Verbose output.").Short('v').Bool()
 	cliutils.Opts.IsDryRun = app.Flag("dry-run", "When calling the Horizon or Exchange API, do GETs, but don't do PUTs, POSTs, or DELETEs.").Bool()
+	cliutils.Opts.OutputVersion = app.Flag("output-version", fmt.Sprintf("The version of the json output of the list commands, so that scripts keep getting the output they were written for when fields change in newer releases. The current version is %v. See 'hzn util output-schema'.", cliutils.OUTPUT_VERSION)).PlaceHolder("VERSION").Int()
 
 	versionCmd := app.Command("version", "Show the Horizon version.") // using a cmd for this instead of --version flag, because kingpin takes over the latter and can't get version only when it is needed
 
+	loginCmd := app.Command("login", "Verify Horizon Exchange user credentials and cache them in the OS keyring (libsecret on Linux, the Keychain on macOS), so they do not need to be specified with the -o and -u flags or environment variables of the exchange sub-commands.")
+	loginOrg := loginCmd.Flag("org", "The Horizon exchange organization ID to use by default. If not specified, HZN_ORG_ID will be used as a default.").Short('o').String()
+	loginUserPw := loginCmd.Flag("user-pw", "Horizon Exchange user credentials to cache. If not specified, HZN_EXCHANGE_USER_AUTH will be used as a default. If you don't prepend it with the user's org, it will automatically be prepended with the -o value.").Short('u').PlaceHolder("USER:PW").String()
+	logoutCmd := app.Command("logout", "Remove the Horizon Exchange user credentials cached by 'hzn login' from the OS keyring.")
+
 	exchangeCmd := app.Command("exchange", "List and manage Horizon Exchange resources.")
 	exOrg := exchangeCmd.Flag("org", "The Horizon exchange organization ID. If not specified, HZN_ORG_ID will be used as a default.").Short('o').String()
 	exUserPw := exchangeCmd.Flag("user-pw", "Horizon Exchange user credentials to query and create exchange resources. If not specified, HZN_EXCHANGE_USER_AUTH will be used as a default. If you don't prepend it with the user's org, it will automatically be prepended with the -o value.").Short('u').PlaceHolder("USER:PW").String()
@@ -76,6 +100,44 @@ Environment Variables:
 	exDelUser := exUserDelCmd.Arg("user", "The user to remove.").Required().String()
 	exUserDelForce := exUserDelCmd.Flag("force", "Skip the 'are you sure?' prompt.").Short('f').Bool()
 
+	exOrgCmd := exchangeCmd.Command("org", "List and manage organizations in the Horizon Exchange, and the users and keys in them. Creating, updating and removing organizations requires the exchange root user, for example -u root/root:PASSWORD. The -o flag is only used for the credentials.")
+	exOrgListCmd := exOrgCmd.Command("list", "Display the organization resources from the Horizon Exchange. (Normally you can only display your own organization.)")
+	exOrgListOrg := exOrgListCmd.Arg("organization", "List just this one organization.").String()
+	exOrgListLong := exOrgListCmd.Flag("long", "When listing all of the organizations, show the entire resource of each organization, instead of just the name.").Short('l').Bool()
+	exOrgCreateCmd := exOrgCmd.Command("create", "Create the organization resource in the Horizon Exchange.")
+	exOrgCreateOrg := exOrgCreateCmd.Arg("organization", "The organization to create.").Required().String()
+	exOrgCreateLabel := exOrgCreateCmd.Flag("label", "The label of the organization. If not specified, the organization ID is used.").Short('l').String()
+	exOrgCreateDesc := exOrgCreateCmd.Flag("description", "The description of the organization.").Short('d').Required().String()
+	exOrgUpdateCmd := exOrgCmd.Command("update", "Change the label or description of an organization in the Horizon Exchange.")
+	exOrgUpdateOrg := exOrgUpdateCmd.Arg("organization", "The organization to update.").Required().String()
+	exOrgUpdateLabel := exOrgUpdateCmd.Flag("label", "The new label of the organization.").Short('l').String()
+	exOrgUpdateDesc := exOrgUpdateCmd.Flag("description", "The new description of the organization.").Short('d').String()
+	exOrgDelCmd := exOrgCmd.Command("remove", "Remove an organization resource from the Horizon Exchange. Warning: this will cause all exchange resources in the organization to also be deleted (users, nodes, agbots, services, patterns, etc).")
+	exOrgDelOrg := exOrgDelCmd.Arg("organization", "The organization to remove.").Required().String()
+	exOrgDelForce := exOrgDelCmd.Flag("force", "Skip the 'are you sure?' prompt.").Short('f').Bool()
+	exOrgUserCmd := exOrgCmd.Command("user", "List and manage the users of an organization in the Horizon Exchange. Requires the exchange root user or an admin user of the organization.")
+	exOrgUserListCmd := exOrgUserCmd.Command("list", "Display the user resources of an organization from the Horizon Exchange.")
+	exOrgUserListOrg := exOrgUserListCmd.Arg("organization", "The organization of the users.").Required().String()
+	exOrgUserListUser := exOrgUserListCmd.Arg("user", "List just this one user.").String()
+	exOrgUserCreateCmd := exOrgUserCmd.Command("create", "Create a user resource in an organization in the Horizon Exchange.")
+	exOrgUserCreateOrg := exOrgUserCreateCmd.Arg("organization", "The organization of the user.").Required().String()
+	exOrgUserCreateUserPw := exOrgUserCreateCmd.Arg("user-pw", "The user to create and its password, in the form USER:PW.").Required().String()
+	exOrgUserCreateEmail := exOrgUserCreateCmd.Flag("email", "The email address that should be associated with the user.").Short('e').Required().String()
+	exOrgUserCreateAdmin := exOrgUserCreateCmd.Flag("admin", "Make the user an admin of the organization, so that it can manage the other users and resources of the organization.").Short('A').Bool()
+	exOrgUserDelCmd := exOrgUserCmd.Command("remove", "Remove a user resource from an organization in the Horizon Exchange. Warning: this will cause all exchange resources owned by this user to also be deleted (nodes, services, patterns, etc).")
+	exOrgUserDelOrg := exOrgUserDelCmd.Arg("organization", "The organization of the user.").Required().String()
+	exOrgUserDelUser := exOrgUserDelCmd.Arg("user", "The user to remove.").Required().String()
+	exOrgUserDelForce := exOrgUserDelCmd.Flag("force", "Skip the 'are you sure?' prompt.").Short('f').Bool()
+	exOrgListKeyCmd := exOrgCmd.Command("listkey", "List the signing public keys stored with each service and pattern of an organization in the Horizon Exchange.")
+	exOrgListKeyOrg := exOrgListKeyCmd.Arg("organization", "The organization of the services and patterns.").Required().String()
+	exOrgAddKeyCmd := exOrgCmd.Command("addkey", "Store a signing public key with every service and pattern of an organization in the Horizon Exchange, so that nodes accept deployments signed with its private key.")
+	exOrgAddKeyOrg := exOrgAddKeyCmd.Arg("organization", "The organization of the services and patterns.").Required().String()
+	exOrgAddKeyFile := exOrgAddKeyCmd.Arg("pubkey-file", "The path of the public key file. Its base name is the name of the key in the exchange.").Required().String()
+	exOrgRemKeyCmd := exOrgCmd.Command("removekey", "Remove a signing public key from every service and pattern of an organization in the Horizon Exchange.")
+	exOrgRemKeyOrg := exOrgRemKeyCmd.Arg("organization", "The organization of the services and patterns.").Required().String()
+	exOrgRemKeyKey := exOrgRemKeyCmd.Arg("key-name", "The name of the key to remove, as displayed by 'hzn exchange org listkey'.").Required().String()
+	exOrgRemKeyForce := exOrgRemKeyCmd.Flag("force", "Skip the 'are you sure?' prompt.").Short('f').Bool()
+
 	exNodeCmd := exchangeCmd.Command("node", "List and manage nodes in the Horizon Exchange")
 	exNodeListCmd := exNodeCmd.Command("list", "Display the node resources from the Horizon Exchange.")
 	exNode := exNodeListCmd.Arg("node", "List just this one node.").String()
@@ -86,6 +148,17 @@ Environment Variables:
 	exNodeDelCmd := exNodeCmd.Command("remove", "Remove a node resource from the Horizon Exchange. Do NOT do this when an edge node is registered with this node id.")
 	exDelNode := exNodeDelCmd.Arg("node", "The node to remove.").Required().String()
 	exNodeDelForce := exNodeDelCmd.Flag("force", "Skip the 'are you sure?' prompt.").Short('f').Bool()
+	exNodeCopyConfigCmd := exNodeCmd.Command("copy-config", "Display the pattern, registered services, software versions and public key of a node as a template for provisioning similar nodes. With --apply, update the nodes to match a template instead, so that a fleet of identical devices has the same exchange configuration.")
+	exNodeCCNodes := exNodeCopyConfigCmd.Arg("node", "The node to copy the configuration of. With --apply, the nodes to apply the template to.").Required().Strings()
+	exNodeCCApply := exNodeCopyConfigCmd.Flag("apply", "The path of a template displayed by copy-config, to apply to the nodes. Only the attributes that differ from the template are updated. The public key is only applied when the template has one, remove it from the template unless the nodes share their messaging keys. Specify -a- to read from stdin.").Short('a').String()
+	exNodeListPolicyCmd := exNodeCmd.Command("listpolicy", "Display the node policy of the node in the Horizon Exchange.")
+	exNodeLPNode := exNodeListPolicyCmd.Arg("node", "The node to list the node policy for.").Required().String()
+	exNodeAddPolicyCmd := exNodeCmd.Command("addpolicy", "Add or replace the node policy of the node in the Horizon Exchange. The agbots match the properties and constraints of the node policy with business policies, for nodes that are not using a pattern.")
+	exNodeAPNode := exNodeAddPolicyCmd.Arg("node", "The node to add the node policy to.").Required().String()
+	exNodeAPJsonFile := exNodeAddPolicyCmd.Flag("json-file", "The path of a JSON file containing the properties and constraints of the node. See /usr/horizon/samples/node_policy.json. Specify -f- to read from stdin.").Short('f').Required().String()
+	exNodeRemovePolicyCmd := exNodeCmd.Command("removepolicy", "Remove the node policy of the node from the Horizon Exchange.")
+	exNodeRPNode := exNodeRemovePolicyCmd.Arg("node", "The node to remove the node policy from.").Required().String()
+	exNodeRPForce := exNodeRemovePolicyCmd.Flag("force", "Skip the 'are you sure?' prompt.").Short('f').Bool()
 
 	exAgbotCmd := exchangeCmd.Command("agbot", "List and manage agbots in the Horizon Exchange")
 	exAgbotListCmd := exAgbotCmd.Command("list", "Display the agbot resources from the Horizon Exchange.")
@@ -103,6 +176,29 @@ Environment Variables:
 	exAgbotDP := exAgbotDelPatCmd.Arg("agbot", "The agbot to remove the pattern from.").Required().String()
 	exAgbotDPPatOrg := exAgbotDelPatCmd.Arg("patternorg", "The organization of the pattern to remove.").Required().String()
 	exAgbotDPPat := exAgbotDelPatCmd.Arg("pattern", "The name of the pattern to remove.").Required().String()
+	exAgbotListBPCmd := exAgbotCmd.Command("listbusinesspol", "Display the business policies that this agbot is serving.")
+	exAgbotLBP := exAgbotListBPCmd.Arg("agbot", "The agbot to list the business policies for.").Required().String()
+	exAgbotAddBPCmd := exAgbotCmd.Command("addbusinesspol", "Add this business policy to the list of business policies this agbot is serving.")
+	exAgbotABP := exAgbotAddBPCmd.Arg("agbot", "The agbot to add the business policy to.").Required().String()
+	exAgbotABPPolOrg := exAgbotAddBPCmd.Arg("policyorg", "The organization of the business policy to add.").Required().String()
+	exAgbotABPPol := exAgbotAddBPCmd.Arg("policy", "The name of the business policy to add.").Required().String()
+	exAgbotABPNodeOrg := exAgbotAddBPCmd.Arg("nodeorg", "The organization of the nodes the business policy is deployed to. Defaults to the organization of the business policy.").String()
+	exAgbotDelBPCmd := exAgbotCmd.Command("removebusinesspol", "Remove this business policy from the list of business policies this agbot is serving.")
+	exAgbotDBP := exAgbotDelBPCmd.Arg("agbot", "The agbot to remove the business policy from.").Required().String()
+	exAgbotDBPPolOrg := exAgbotDelBPCmd.Arg("policyorg", "The organization of the business policy to remove.").Required().String()
+	exAgbotDBPPol := exAgbotDelBPCmd.Arg("policy", "The name of the business policy to remove.").Required().String()
+	exAgbotDBPNodeOrg := exAgbotDelBPCmd.Arg("nodeorg", "The organization of the nodes the business policy is deployed to. Defaults to the organization of the business policy.").String()
+
+	exBusinessCmd := exchangeCmd.Command("business", "List and manage business policies in the Horizon Exchange")
+	exBusinessListPolicyCmd := exBusinessCmd.Command("listpolicy", "Display the business policy resources from the Horizon Exchange.")
+	exBusinessLP := exBusinessListPolicyCmd.Arg("policy", "List just this one business policy.").String()
+	exBusinessLPLong := exBusinessListPolicyCmd.Flag("long", "When listing all of the business policies, show the entire resource of each business policy, instead of just the name.").Short('l').Bool()
+	exBusinessAddPolicyCmd := exBusinessCmd.Command("addpolicy", "Add or replace a business policy in the Horizon Exchange. A business policy deploys a service to the nodes without a pattern whose node policy matches it.")
+	exBusinessAP := exBusinessAddPolicyCmd.Arg("policy", "The name of the business policy to add or replace.").Required().String()
+	exBusinessAPJsonFile := exBusinessAddPolicyCmd.Flag("json-file", "The path of a JSON file containing the label, description, service, properties and constraints of the business policy. See /usr/horizon/samples/business_policy.json. Specify -f- to read from stdin.").Short('f').Required().String()
+	exBusinessRemovePolicyCmd := exBusinessCmd.Command("removepolicy", "Remove a business policy from the Horizon Exchange.")
+	exBusinessRP := exBusinessRemovePolicyCmd.Arg("policy", "The business policy to remove.").Required().String()
+	exBusinessRPForce := exBusinessRemovePolicyCmd.Flag("force", "Skip the 'are you sure?' prompt.").Short('f').Bool()
 
 	exPatternCmd := exchangeCmd.Command("pattern", "List and manage patterns in the Horizon Exchange")
 	exPatternListCmd := exPatternCmd.Command("list", "Display the pattern resources from the Horizon Exchange.")
@@ -110,20 +206,21 @@ Environment Variables:
 	exPatternLong := exPatternListCmd.Flag("long", "When listing all of the patterns, show the entire resource of each pattern, instead of just the name.").Short('l').Bool()
 	exPatternPublishCmd := exPatternCmd.Command("publish", "Sign and create/update the pattern resource in the Horizon Exchange.")
 	exPatJsonFile := exPatternPublishCmd.Flag("json-file", "The path of a JSON file containing the metadata necessary to create/update the pattern in the Horizon exchange. See /usr/horizon/samples/pattern.json. Specify -f- to read from stdin.").Short('f').Required().String()
-	exPatKeyFile := exPatternPublishCmd.Flag("private-key-file", "The path of a private key file to be used to sign the pattern.").Short('k').ExistingFile()
-	exPatPubPubKeyFile := exPatternPublishCmd.Flag("public-key-file", "The path of public key file (that corresponds to the private key) that should be stored with the pattern, to be used by the Horizon Agent to verify the signature.").Short('K').ExistingFile()
+	exPatKeyFile := exPatternPublishCmd.Flag("private-key-file", "The path of a private key file, or the name of a key pair in the local keystore, to be used to sign the pattern.").Short('k').String()
+	exPatPubPubKeyFile := exPatternPublishCmd.Flag("public-key-file", "The path of public key file (that corresponds to the private key) that should be stored with the pattern, to be used by the Horizon Agent to verify the signature. Defaults to the public key of the keystore key pair when the private key is a keystore name.").Short('K').String()
 	exPatName := exPatternPublishCmd.Flag("pattern-name", "The name to use for this pattern in the Horizon exchange. If not specified, will default to the base name of the file path specified in -f.").Short('p').String()
+	exPatVerifyRefs := exPatternPublishCmd.Flag("verify", "Before publishing, verify that every workload or service version the pattern references exists in the Horizon exchange and that its deployment signature verifies with a public key stored with it. If any reference fails, a report is displayed and the pattern is not published.").Bool()
 	exPatternVerifyCmd := exPatternCmd.Command("verify", "Verify the signatures of a pattern resource in the Horizon Exchange.")
 	exVerPattern := exPatternVerifyCmd.Arg("pattern", "The pattern to verify.").Required().String()
-	exPatPubKeyFile := exPatternVerifyCmd.Flag("public-key-file", "The path of a pem public key file to be used to verify the pattern. ").Short('k').Required().ExistingFile()
+	exPatPubKeyFile := exPatternVerifyCmd.Flag("public-key-file", "The path of a pem public key file, or the name of a key pair in the local keystore, to be used to verify the pattern.").Short('k').Required().String()
 	exPatDelCmd := exPatternCmd.Command("remove", "Remove a pattern resource from the Horizon Exchange.")
 	exDelPat := exPatDelCmd.Arg("pattern", "The pattern to remove.").Required().String()
 	exPatDelForce := exPatDelCmd.Flag("force", "Skip the 'are you sure?' prompt.").Short('f').Bool()
 	exPatternAddWorkCmd := exPatternCmd.Command("insertworkload", "Add or replace a workload in an existing pattern resource in the Horizon Exchange.")
 	exPatAddWork := exPatternAddWorkCmd.Arg("pattern", "The existing pattern that the workload should be inserted into.").Required().String()
 	exPatAddWorkJsonFile := exPatternAddWorkCmd.Flag("json-file", "The path of a JSON file containing the additional workload metadata. See /usr/horizon/samples/insert-workload-into-pattern.json. Specify -f- to read from stdin.").Short('f').Required().String()
-	exPatAddWorkKeyFile := exPatternAddWorkCmd.Flag("private-key-file", "The path of a private key file to be used to sign the inserted workload. ").Short('k').ExistingFile()
-	exPatAddWorkPubKeyFile := exPatternAddWorkCmd.Flag("public-key-file", "The path of public key file (that corresponds to the private key) that should be stored with the pattern, to be used by the Horizon Agent to verify the signature.").Short('K').ExistingFile()
+	exPatAddWorkKeyFile := exPatternAddWorkCmd.Flag("private-key-file", "The path of a private key file, or the name of a key pair in the local keystore, to be used to sign the inserted workload.").Short('k').String()
+	exPatAddWorkPubKeyFile := exPatternAddWorkCmd.Flag("public-key-file", "The path of public key file (that corresponds to the private key) that should be stored with the pattern, to be used by the Horizon Agent to verify the signature. Defaults to the public key of the keystore key pair when the private key is a keystore name.").Short('K').String()
 	exPatternDelWorkCmd := exPatternCmd.Command("removeworkload", "Remove a workload from an existing pattern resource in the Horizon Exchange.")
 	exPatDelWorkPat := exPatternDelWorkCmd.Arg("pattern", "The existing pattern that the workload should be removed from.").Required().String()
 	exPatDelWorkOrg := exPatternDelWorkCmd.Arg("workload-org", "The org of the workload to remove.").Required().String()
@@ -142,12 +239,13 @@ Environment Variables:
 	exWorkloadLong := exWorkloadListCmd.Flag("long", "When listing all of the workloads, show the entire resource of each workloads, instead of just the name.").Short('l').Bool()
 	exWorkloadPublishCmd := exWorkloadCmd.Command("publish", "Sign and create/update the workload resource in the Horizon Exchange.")
 	exWorkJsonFile := exWorkloadPublishCmd.Flag("json-file", "The path of a JSON file containing the metadata necessary to create/update the workload in the Horizon exchange. See /usr/horizon/samples/workload.json. Specify -f- to read from stdin.").Short('f').Required().String()
-	exWorkPrivKeyFile := exWorkloadPublishCmd.Flag("private-key-file", "The path of a private key file to be used to sign the workload. ").Short('k').ExistingFile()
-	exWorkPubPubKeyFile := exWorkloadPublishCmd.Flag("public-key-file", "The path of public key file (that corresponds to the private key) that should be stored with the workload, to be used by the Horizon Agent to verify the signature.").Short('K').ExistingFile()
+	exWorkPrivKeyFile := exWorkloadPublishCmd.Flag("private-key-file", "The path of a private key file, or the name of a key pair in the local keystore, to be used to sign the workload.").Short('k').String()
+	exWorkPubPubKeyFile := exWorkloadPublishCmd.Flag("public-key-file", "The path of public key file (that corresponds to the private key) that should be stored with the workload, to be used by the Horizon Agent to verify the signature. Defaults to the public key of the keystore key pair when the private key is a keystore name.").Short('K').String()
 	exWorkPubDontTouchImage := exWorkloadPublishCmd.Flag("dont-change-image-tag", "The image paths in the deployment field have regular tags and should not be changed to sha256 digest values. This should only be used during development when testing new versions often.").Short('I').Bool()
+	exWorkPubStrict := exWorkloadPublishCmd.Flag("strict", "Validate the deployment field against the container schema before signing it, and refuse to publish it if it has unknown fields or invalid values. Use --no-strict to skip the validation.").Default("true").Bool()
 	exWorkloadVerifyCmd := exWorkloadCmd.Command("verify", "Verify the signatures of a workload resource in the Horizon Exchange.")
 	exVerWorkload := exWorkloadVerifyCmd.Arg("workload", "The workload to verify.").Required().String()
-	exWorkPubKeyFile := exWorkloadVerifyCmd.Flag("public-key-file", "The path of a pem public key file to be used to verify the workload. ").Short('k').Required().ExistingFile()
+	exWorkPubKeyFile := exWorkloadVerifyCmd.Flag("public-key-file", "The path of a pem public key file, or the name of a key pair in the local keystore, to be used to verify the workload.").Short('k').Required().String()
 	exWorkDelCmd := exWorkloadCmd.Command("remove", "Remove a workload resource from the Horizon Exchange.")
 	exDelWork := exWorkDelCmd.Arg("workload", "The workload to remove.").Required().String()
 	exWorkDelForce := exWorkDelCmd.Flag("force", "Skip the 'are you sure?' prompt.").Short('f').Bool()
@@ -157,19 +255,28 @@ Environment Variables:
 	exWorkloadRemKeyCmd := exWorkloadCmd.Command("removekey", "Remove a signing public key/cert for this workload resource in the Horizon Exchange.")
 	exWorkRemKeyWork := exWorkloadRemKeyCmd.Arg("workload", "The existing workload to remove the key from.").Required().String()
 	exWorkRemKeyKey := exWorkloadRemKeyCmd.Arg("key-name", "The existing key name to remove.").Required().String()
+	exWorkloadMigrateCmd := exWorkloadCmd.Command("migrate", "Remove the deprecated torrent field from workload resources in the Horizon Exchange, or convert it to its normalized form.")
+	exWorkMigrateWork := exWorkloadMigrateCmd.Arg("workload", "Migrate just this one workload. If omitted, all workloads in the org are migrated.").String()
+	exWorkMigrateDryRun := exWorkloadMigrateCmd.Flag("dry-run", "Only display the workloads that would be migrated, do not change them.").Bool()
 
 	exMicroserviceCmd := exchangeCmd.Command("microservice", "List and manage microservices in the Horizon Exchange")
 	exMicroserviceListCmd := exMicroserviceCmd.Command("list", "Display the microservice resources from the Horizon Exchange.")
 	exMicroservice := exMicroserviceListCmd.Arg("microservice", "List just this one microservice.").String()
 	exMicroserviceLong := exMicroserviceListCmd.Flag("long", "When listing all of the microservices, show the entire resource of each microservices, instead of just the name.").Short('l').Bool()
+	exMicroserviceFilters := exMicroserviceListCmd.Flag("filter", "Only list the microservices that match this filter, in the form key=value. The keys are specRef, arch and version. The version can be a single version or a version range, for example [1.0.0,2.0.0). This flag can be repeated, and a microservice must match all of the filters.").Strings()
+	exMicroserviceOutput := exMicroserviceListCmd.Flag("output", "The format of the output: "+cliutils.OUTPUT_FORMATS+". The go-template is executed against the json output, so fields are referred to by their json names.").Short('o').Default(cliutils.OUTPUT_JSON).String()
 	exMicroservicePublishCmd := exMicroserviceCmd.Command("publish", "Sign and create/update the microservice resource in the Horizon Exchange.")
 	exMicroJsonFile := exMicroservicePublishCmd.Flag("json-file", "The path of a JSON file containing the metadata necessary to create/update the microservice in the Horizon exchange. See /usr/horizon/samples/microservice.json. Specify -f- to read from stdin.").Short('f').Required().String()
-	exMicroKeyFile := exMicroservicePublishCmd.Flag("private-key-file", "The path of a private key file to be used to sign the microservice. ").Short('k').ExistingFile()
-	exMicroPubPubKeyFile := exMicroservicePublishCmd.Flag("public-key-file", "The path of public key file (that corresponds to the private key) that should be stored with the microservice, to be used by the Horizon Agent to verify the signature.").Short('K').ExistingFile()
+	exMicroKeyFile := exMicroservicePublishCmd.Flag("private-key-file", "The path of a private key file, or the name of a key pair in the local keystore, to be used to sign the microservice.").Short('k').String()
+	exMicroPubPubKeyFile := exMicroservicePublishCmd.Flag("public-key-file", "The path of public key file (that corresponds to the private key) that should be stored with the microservice, to be used by the Horizon Agent to verify the signature. Defaults to the public key of the keystore key pair when the private key is a keystore name.").Short('K').String()
 	exMicroPubDontTouchImage := exMicroservicePublishCmd.Flag("dont-change-image-tag", "The image paths in the deployment field have regular tags and should not be changed to sha256 digest values. This should only be used during development when testing new versions often.").Short('I').Bool()
+	exMicroPubRegistryAuthFile := exMicroservicePublishCmd.Flag("registry-auth-file", "The path of a JSON file with the credentials for pushing the images to their docker registries, in the format {\"registries\": {\"myregistry.com:5000\": {\"username\": \"me\", \"password\": \"secret\"}}}. If not specified, HZN_REGISTRY_AUTH_FILE or the JSON in HZN_REGISTRY_AUTH is used. The registries that are not in it use the credentials stored by 'docker login'.").String()
+	exMicroPubPushTo := exMicroservicePublishCmd.Flag("push-to-registry", "A docker registry, like myregistry.com:5000, that the images are also pushed to, besides the registry in their image path. The deployment field refers to the images in the registry in their image path. This flag can be repeated.").Strings()
+	exMicroPubStrict := exMicroservicePublishCmd.Flag("strict", "Validate the deployment field against the container schema before signing it, and refuse to publish it if it has unknown fields or invalid values. Use --no-strict to skip the validation.").Default("true").Bool()
 	exMicroVerifyCmd := exMicroserviceCmd.Command("verify", "Verify the signatures of a microservice resource in the Horizon Exchange.")
 	exVerMicro := exMicroVerifyCmd.Arg("microservice", "The microservice to verify.").Required().String()
-	exMicroPubKeyFile := exMicroVerifyCmd.Flag("public-key-file", "The path of a pem public key file to be used to verify the microservice. ").Short('k').Required().ExistingFile()
+	exMicroPubKeyFile := exMicroVerifyCmd.Flag("public-key-file", "The path of a pem public key file, or the name of a key pair in the local keystore, to be used to verify the microservice.").Short('k').String()
+	exMicroVerifyExchKeys := exMicroVerifyCmd.Flag("exchange-keys", "Verify the microservice with each of the public keys stored with it in the Horizon Exchange, instead of a public key file, and display which key verifies each deployment string.").Bool()
 	exMicroDelCmd := exMicroserviceCmd.Command("remove", "Remove a microservice resource from the Horizon Exchange.")
 	exDelMicro := exMicroDelCmd.Arg("microservice", "The microservice to remove.").Required().String()
 	exMicroDelForce := exMicroDelCmd.Flag("force", "Skip the 'are you sure?' prompt.").Short('f').Bool()
@@ -179,6 +286,9 @@ Environment Variables:
 	exMicroRemKeyCmd := exMicroserviceCmd.Command("removekey", "Remove a signing public key/cert for this microservice resource in the Horizon Exchange.")
 	exMicroRemKeyMicro := exMicroRemKeyCmd.Arg("microservice", "The existing microservice to remove the key from.").Required().String()
 	exMicroRemKeyKey := exMicroRemKeyCmd.Arg("key-name", "The existing key name to remove.").Required().String()
+	exMicroMigrateCmd := exMicroserviceCmd.Command("migrate", "Remove the deprecated torrent field from microservice resources in the Horizon Exchange, or convert it to its normalized form.")
+	exMicroMigrateMicro := exMicroMigrateCmd.Arg("microservice", "Migrate just this one microservice. If omitted, all microservices in the org are migrated.").String()
+	exMicroMigrateDryRun := exMicroMigrateCmd.Flag("dry-run", "Only display the microservices that would be migrated, do not change them.").Bool()
 
 	exServiceCmd := exchangeCmd.Command("service", "List and manage services in the Horizon Exchange")
 	exServiceListCmd := exServiceCmd.Command("list", "Display the service resources from the Horizon Exchange.")
@@ -186,13 +296,14 @@ Environment Variables:
 	exServiceLong := exServiceListCmd.Flag("long", "When listing all of the services, show the entire resource of each services, instead of just the name.").Short('l').Bool()
 	exServicePublishCmd := exServiceCmd.Command("publish", "Sign and create/update the service resource in the Horizon Exchange.")
 	exSvcJsonFile := exServicePublishCmd.Flag("json-file", "The path of a JSON file containing the metadata necessary to create/update the service in the Horizon exchange. See /usr/horizon/samples/service.json. Specify -f- to read from stdin.").Short('f').Required().String()
-	exSvcPrivKeyFile := exServicePublishCmd.Flag("private-key-file", "The path of a private key file to be used to sign the service. ").Short('k').ExistingFile()
-	exSvcPubPubKeyFile := exServicePublishCmd.Flag("public-key-file", "The path of public key file (that corresponds to the private key) that should be stored with the service, to be used by the Horizon Agent to verify the signature.").Short('K').ExistingFile()
+	exSvcPrivKeyFile := exServicePublishCmd.Flag("private-key-file", "The path of a private key file, or the name of a key pair in the local keystore, to be used to sign the service.").Short('k').String()
+	exSvcPubPubKeyFile := exServicePublishCmd.Flag("public-key-file", "The path of public key file (that corresponds to the private key) that should be stored with the service, to be used by the Horizon Agent to verify the signature. Defaults to the public key of the keystore key pair when the private key is a keystore name.").Short('K').String()
 	exSvcPubDontTouchImage := exServicePublishCmd.Flag("dont-change-image-tag", "The image paths in the deployment field have regular tags and should not be changed to sha256 digest values. This should only be used during development when testing new versions often.").Short('I').Bool()
+	exSvcPubStrict := exServicePublishCmd.Flag("strict", "Validate the deployment field against the container schema before signing it, and refuse to publish it if it has unknown fields or invalid values. Use --no-strict to skip the validation.").Default("true").Bool()
 	exSvcRegistryTokens := exServicePublishCmd.Flag("registry-token", "Docker registry domain and auth token that should be stored with the service, to enable the Horizon edge node to access the service's docker images. This flag can be repeated, and each flag should be in the format: registry:token").Short('r').Strings()
 	exServiceVerifyCmd := exServiceCmd.Command("verify", "Verify the signatures of a service resource in the Horizon Exchange.")
 	exVerService := exServiceVerifyCmd.Arg("service", "The service to verify.").Required().String()
-	exSvcPubKeyFile := exServiceVerifyCmd.Flag("public-key-file", "The path of a pem public key file to be used to verify the service. ").Short('k').Required().ExistingFile()
+	exSvcPubKeyFile := exServiceVerifyCmd.Flag("public-key-file", "The path of a pem public key file, or the name of a key pair in the local keystore, to be used to verify the service.").Short('k').Required().String()
 	exSvcDelCmd := exServiceCmd.Command("remove", "Remove a service resource from the Horizon Exchange.")
 	exDelSvc := exSvcDelCmd.Arg("service", "The service to remove.").Required().String()
 	exSvcDelForce := exSvcDelCmd.Flag("force", "Skip the 'are you sure?' prompt.").Short('f').Bool()
@@ -209,6 +320,12 @@ Environment Variables:
 	exSvcRemAuthSvc := exServiceRemAuthCmd.Arg("service", "The existing service to remove the docker auth from.").Required().String()
 	exSvcRemAuthId := exServiceRemAuthCmd.Arg("auth-name", "The existing docker auth id to remove.").Required().Uint()
 
+	exDeploymentCmd := exchangeCmd.Command("deployment", "Check the deployment of patterns to nodes in the Horizon Exchange")
+	exDeploymentCheckCmd := exDeploymentCmd.Command("check", "Run the checks the agbot makes before it proposes an agreement, for every workload (or service) version in the pattern, and display which of them could be deployed to the node and why not.")
+	exDepCheckNode := exDeploymentCheckCmd.Arg("node", "The node to check.").Required().String()
+	exDepCheckPattern := exDeploymentCheckCmd.Arg("pattern", "The pattern to check. Prepend it with the pattern org if it is not in the -o org.").Required().String()
+	exDepCheckInputFile := exDeploymentCheckCmd.Flag("input-file", "The JSON input file the node was (or will be) registered with, used to check that user input without a default value is set. If not specified, that user input is only listed. Specify -f- to read from stdin.").Short('f').String()
+
 	wiotpCmd := app.Command("wiotp", "List and manage WIoTP objects.")
 	wiotpOrg := wiotpCmd.Flag("org", "The WIoTP organization ID. If not specified, HZN_ORG_ID will be used as a default.").Short('o').String()
 	wiotpApiKeyToken := wiotpCmd.Flag("apikey-token", "WIoTP API key and token to query and create WIoTP objects. If not specified, HZN_EXCHANGE_API_AUTH will be used as a default.").Short('A').PlaceHolder("APIKEY:TOKEN").String()
@@ -254,14 +371,17 @@ Environment Variables:
 	nodeIdTok := registerCmd.Flag("node-id-tok", "The Horizon exchange node ID and token. The node ID must be unique within the organization. If not specified, the node ID will be created by Horizon from the machine serial number or fully qualified hostname. If the token is not specified, Horizon will create a random token. If node resource in the exchange identified by the ID and token does not yet exist, you must also specify the -u flag so it can be created.").Short('n').PlaceHolder("ID:TOK").String()
 	userPw := registerCmd.Flag("user-pw", "User credentials to create the node resource in the Horizon exchange if it does not already exist.").Short('u').PlaceHolder("USER:PW").String()
 	email := registerCmd.Flag("email", "Your email address. Only needs to be specified if: the node resource does not yet exist in the Horizon exchange, and the user specified in the -u flag does not exist, and you specified the 'public' org. If all of these things are true we will create the user and include this value as the email attribute.").Short('e').String()
-	inputFile := registerCmd.Flag("input-file", "A JSON file that sets or overrides variables needed by the node, workloads, and microservices that are part of this pattern. See /usr/horizon/samples/input.json and /usr/horizon/samples/more-examples.json. Specify -f- to read from stdin.").Short('f').String() // not using ExistingFile() because it can be - for stdin
-	org := registerCmd.Arg("organization", "The Horizon exchange organization ID.").Required().String()
-	pattern := registerCmd.Arg("pattern", "The Horizon exchange pattern that describes what workloads that should be deployed to this node.").Required().String()
+	inputFile := registerCmd.Flag("input-file", "A JSON or YAML file that sets or overrides variables needed by the node, workloads, and microservices that are part of this pattern. The file can also declare the organization, pattern, node ID, token and name, and node properties, so that the node can be registered with just this flag. See /usr/horizon/samples/input.json, /usr/horizon/samples/more-examples.json and /usr/horizon/samples/node.yaml. Specify -f- to read from stdin.").Short('f').String() // not using ExistingFile() because it can be - for stdin
+	org := registerCmd.Arg("organization", "The Horizon exchange organization ID. Required unless it is declared in the input file.").String()
+	pattern := registerCmd.Arg("pattern", "The Horizon exchange pattern that describes what workloads that should be deployed to this node. Required unless it is declared in the input file.").String()
 
 	keyCmd := app.Command("key", "List and manage keys for signing and verifying services.")
+	keyOrg := keyCmd.Flag("org", "The Horizon exchange organization ID of the services and patterns that public keys are uploaded to with --upload. If not specified, HZN_ORG_ID will be used as a default.").Short('o').String()
+	keyUserPw := keyCmd.Flag("user-pw", "Horizon Exchange user credentials to upload public keys with --upload. If not specified, HZN_EXCHANGE_USER_AUTH will be used as a default.").Short('u').PlaceHolder("USER:PW").String()
 	keyListCmd := keyCmd.Command("list", "List the signing keys that have been imported into this Horizon agent.")
 	keyName := keyListCmd.Arg("key-name", "The name of a specific key to show.").String()
 	keyListAll := keyListCmd.Flag("all", "List the names of all signing keys, even the older public keys not wrapped in a certificate.").Short('a').Bool()
+	keyListKeystore := keyListCmd.Flag("keystore", "List the key pairs in the local keystore instead of the keys imported into the Horizon agent. The keystore is in ~/.hzn/keys, or in HZN_KEYSTORE if it is set.").Short('s').Bool()
 	keyCreateCmd := keyCmd.Command("create", "Generate a signing key pair.")
 	keyX509Org := keyCreateCmd.Arg("x509-org", "x509 certificate Organization (O) field (preferably a company name or other organization's name).").Required().String()
 	keyX509CN := keyCreateCmd.Arg("x509-cn", "x509 certificate Common Name (CN) field (preferably an email address issued by x509org).").Required().String()
@@ -269,31 +389,58 @@ Environment Variables:
 	keyLength := keyCreateCmd.Flag("length", "The length of the key to create.").Short('l').Default("4096").Int()
 	keyDaysValid := keyCreateCmd.Flag("days-valid", "x509 certificate validity (Validity > Not After) expressed in days from the day of generation.").Default("1461").Int()
 	keyImportFlag := keyCreateCmd.Flag("import", "Automatically import the created public key into the local Horizon agent.").Short('i').Bool()
+	keyCreateName := keyCreateCmd.Flag("name", "Create the key pair in the local keystore under this name, instead of in the output directory. The name can then be used in place of the key file paths in the publish and verify commands.").Short('n').String()
+	keyCreateUploads := keyCreateCmd.Flag("upload", "Store the created public key with this exchange resource, in the form service:<service-id> or pattern:<pattern-name>. This flag can be repeated.").Strings()
 	keyImportCmd := keyCmd.Command("import", "Imports a signing public key into the Horizon agent.")
 	keyImportPubKeyFile := keyImportCmd.Flag("public-key-file", "The path of a pem public key file to be imported. The base name in the path is also used as the key name in the Horizon agent. ").Short('k').Required().ExistingFile()
+	keyImportName := keyImportCmd.Flag("name", "Import the key into the local keystore under this name, instead of into the Horizon agent.").Short('n').String()
+	keyImportPrivKeyFile := keyImportCmd.Flag("private-key-file", "The path of the private key file that corresponds to the public key. Only used with --name.").ExistingFile()
+	keyImportUploads := keyImportCmd.Flag("upload", "Store the imported public key with this exchange resource, in the form service:<service-id> or pattern:<pattern-name>. This flag can be repeated.").Strings()
 	keyDelCmd := keyCmd.Command("remove", "Remove the specified signing key from this Horizon agent.")
 	keyDelName := keyDelCmd.Arg("key-name", "The name of a specific key to remove.").Required().String()
+	keyDelKeystore := keyDelCmd.Flag("keystore", "Remove the key pair from the local keystore instead of from the Horizon agent.").Short('s').Bool()
 
 	nodeCmd := app.Command("node", "List and manage general information about this Horizon edge node.")
 	nodeListCmd := nodeCmd.Command("list", "Display general information about this Horizon edge node.")
+	nodeStatusCmd := nodeCmd.Command("status", "Display the status of the workers of this Horizon edge node: whether each one is running, when it last did some work, and how many errors it had.")
+	nodeStatusOutput := nodeStatusCmd.Flag("output", "The format of the output: "+cliutils.OUTPUT_FORMATS+". The go-template is executed against the json output, so fields are referred to by their json names.").Short('o').Default(cliutils.OUTPUT_TABLE).String()
+	nodeScanCmd := nodeCmd.Command("scan", "Check whether this machine is ready to be registered as a Horizon edge node: docker and its version, reachability of the exchange and the CSS, clock skew with the exchange, memory, free disk space, and whether a pattern has services for the architecture of this machine. The results are displayed as json. Does not need the Horizon agent to be running.")
+	nodeScanExchangeUrl := nodeScanCmd.Flag("exchange", "The URL of the exchange API. If not specified, HZN_EXCHANGE_URL is used, or the exchange of the Horizon agent if it is running.").String()
+	nodeScanCssUrl := nodeScanCmd.Flag("css", "The URL of the CSS (cloud sync service). If not specified, HZN_FSS_CSSURL is used. The CSS is not checked when neither is set.").String()
+	nodeScanPattern := nodeScanCmd.Flag("pattern", "The pattern the node will be registered with, as org/pattern or pattern. The architecture is only checked when a pattern is specified.").String()
+	nodeScanOrg := nodeScanCmd.Flag("org", "The Horizon exchange organization ID. If not specified, HZN_ORG_ID will be used as a default.").Short('o').String()
+	nodeScanUserPw := nodeScanCmd.Flag("user-pw", "Horizon exchange user credentials to read the pattern. If not specified, HZN_EXCHANGE_USER_AUTH will be used as a default.").Short('u').PlaceHolder("USER:PW").String()
+	nodeScanProblems := nodeScanCmd.Flag("problems", "Only display the checks that found a problem.").Short('p').Bool()
 
 	agreementCmd := app.Command("agreement", "List or manage the active or archived agreements this edge node has made with a Horizon agreement bot.")
 	agreementListCmd := agreementCmd.Command("list", "List the active or archived agreements this edge node has made with a Horizon agreement bot.")
 	listAgreementId := agreementListCmd.Arg("agreement-id", "Show the details of this active or archived agreement.").String()
 	listArchivedAgreements := agreementListCmd.Flag("archived", "List archived agreements instead of the active agreements.").Short('r').Bool()
+	listWatchAgreements := agreementListCmd.Flag("watch", "Display a table of the agreements that is updated as they change, until ctrl-c is pressed.").Short('w').Bool()
+	listWatchAgreementsInterval := agreementListCmd.Flag("interval", "With --watch, how often the agreements are read from the Horizon agent, in seconds.").Default(cliutils.DEFAULT_WATCH_INTERVAL).Int()
+	listWatchAgreementsDiff := agreementListCmd.Flag("diff", "With --watch, print a line for each agreement that is added, changed or removed, with the columns that changed, instead of redrawing the table.").Bool()
+	listAgreementsWorkload := agreementListCmd.Flag("workload", "List only the agreements that run this workload or service URL.").String()
+	listAgreementsState := agreementListCmd.Flag("state", "List only the agreements in this state: negotiating, finalized or terminating.").String()
+	listAgreementsNewer := agreementListCmd.Flag("newer-than", "List only the agreements created less than this long ago, e.g. 30m or 2h.").PlaceHolder("DURATION").String()
+	listAgreementsOlder := agreementListCmd.Flag("older-than", "List only the agreements created at least this long ago, e.g. 30m or 2h.").PlaceHolder("DURATION").String()
 	agreementCancelCmd := agreementCmd.Command("cancel", "Cancel 1 or all of the active agreements this edge node has made with a Horizon agreement bot. Usually an agbot will immediately negotiated a new agreement. If you want to cancel all agreements and not have this edge accept new agreements, run 'hzn unregister'.")
 	cancelAllAgreements := agreementCancelCmd.Flag("all", "Cancel all of the current agreements.").Short('a').Bool()
 	cancelAgreementId := agreementCancelCmd.Arg("agreement-id", "The active agreement to cancel.").String()
+	agreementReasonsCmd := agreementCmd.Command("reasons", "List the reason codes an agreement can be terminated with, for each agreement protocol. Shared reasons have the same code in every protocol.")
+	reasonsProtocol := agreementReasonsCmd.Flag("protocol", "List only the reasons of this agreement protocol, e.g. Basic.").Short('p').String()
 
 	meteringCmd := app.Command("metering", "List or manage the metering (payment) information for the active or archived agreements.")
 	meteringListCmd := meteringCmd.Command("list", "List the metering (payment) information for the active or archived agreements.")
 	listArchivedMetering := meteringListCmd.Flag("archived", "List archived agreement metering information instead of metering for the active agreements.").Short('r').Bool()
+	meteringSummaryCmd := meteringCmd.Command("summary", "Summarize the tokens earned by each workload from each agbot, across the active and archived agreements.")
 
 	attributeCmd := app.Command("attribute", "List or manage the global attributes that are currently registered on this Horizon edge node.")
 	attributeListCmd := attributeCmd.Command("list", "List the global attributes that are currently registered on this Horizon edge node.")
 
 	serviceCmd := app.Command("service", "List or manage the microservices that are currently registered on this Horizon edge node.")
 	serviceListCmd := serviceCmd.Command("list", "List the microservices variable configuration that has been done on this Horizon edge node.")
+	listWatchServices := serviceListCmd.Flag("watch", "Instead of the variable configuration, display a table of the running service instances and the state of their containers, that is updated as they change, until ctrl-c is pressed.").Short('w').Bool()
+	listWatchServicesInterval := serviceListCmd.Flag("interval", "With --watch, how often the services are read from the Horizon agent, in seconds.").Default(cliutils.DEFAULT_WATCH_INTERVAL).Int()
 	serviceRegisteredCmd := serviceCmd.Command("registered", "List the microservices that are currently registered on this Horizon edge node.")
 
 	workloadCmd := app.Command("workload", "List or manage the workloads that are currently registered on this Horizon edge node.")
@@ -302,10 +449,20 @@ Environment Variables:
 	unregisterCmd := app.Command("unregister", "Unregister and reset this Horizon edge node so that it is ready to be registered again. Warning: this will stop all the Horizon workloads running on this edge node, and restart the Horizon agent.")
 	forceUnregister := unregisterCmd.Flag("force", "Skip the 'are you sure?' prompt.").Short('f').Bool()
 	removeNodeUnregister := unregisterCmd.Flag("remove", "Also remove this node resource from the Horizon exchange (because you no longer want to use this node with Horizon).").Short('r').Bool()
+	drainUnregister := unregisterCmd.Flag("drain", "Drain the node first: cancel the agreements because the node is decommissioned, and wait for the workloads to stop and the agreements to be removed from the exchange before removing the local state. If the node does not drain within --timeout seconds, the local state is kept and hzn exits with code 11.").Bool()
+	drainTimeoutUnregister := unregisterCmd.Flag("timeout", "With --drain, the max number of seconds to wait for the node to drain.").Default("300").Int()
 
 	statusCmd := app.Command("status", "Display the current horizon internal status for the node.")
 	statusLong := statusCmd.Flag("long", "Show detailed status").Short('l').Bool()
 
+	supportCmd := app.Command("support", "Collect information about this Horizon edge node for a problem report.")
+	supportBundleCmd := supportCmd.Command("bundle", "Gather the status, the worker status log, the recent exchange calls, an agreement summary, the configuration and the recent log of the Horizon agent into a single archive that can be attached to a problem report. Tokens, passwords and other secrets are removed.")
+	supportBundleFile := supportBundleCmd.Flag("file", "The archive to write. Defaults to horizon-node-support-<time>.tar.gz in the current directory.").Short('f').String()
+	supportBundleConfig := supportBundleCmd.Flag("config", "The Horizon agent configuration file.").Short('c').Default(support.DEFAULT_CONFIG_FILE).String()
+	supportBundleLogFile := supportBundleCmd.Flag("log-file", "A file with the Horizon agent log. If omitted, the log is read from the journal of the --unit systemd unit.").Short('l').String()
+	supportBundleUnit := supportBundleCmd.Flag("unit", "The systemd unit the Horizon agent runs as.").Default(support.DEFAULT_LOG_UNIT).String()
+	supportBundleLines := supportBundleCmd.Flag("lines", "The number of the most recent log lines to include.").Short('n').Default(support.DEFAULT_LOG_LINES).Int()
+
 	devCmd := app.Command("dev", "Developmnt tools for creation of workloads and microservices.")
 	devHomeDirectory := devCmd.Flag("directory", "Directory containing Horizon project metadata.").Short('d').String()
 
@@ -314,12 +471,14 @@ Environment Variables:
 	devWorkloadNewCmdOrg := devWorkloadNewCmd.Flag("org", "The Org id that the workload is defined within. If this flag is omitted, the HZN_ORG_ID environment variable is ued.").Short('o').String()
 	devWorkloadStartTestCmd := devWorkloadCmd.Command("start", "Run a workload in a mocked Horizon Agent environment.")
 	devWorkloadUserInputFile := devWorkloadStartTestCmd.Flag("userInputFile", "File containing user input values for running a test.").Short('f').String()
+	devWorkloadOverridesFile := devWorkloadStartTestCmd.Flag("overridesFile", "File containing deployment config overrides (image tag, environment, binds, ports) that are only used when running the workload locally. If omitted, dev.overrides.json in the project is used when it exists.").Short('O').String()
 	devWorkloadStopTestCmd := devWorkloadCmd.Command("stop", "Stop a workload that is running in a mocked Horizon Agent environment.")
 	devWorkloadDeployCmd := devWorkloadCmd.Command("publish", "Publish a workload to a Horizon Exchange.")
 	devWorkloadDeployCmdUserPw := devWorkloadDeployCmd.Flag("user-pw", "Horizon Exchange user credentials to create exchange resources. If you don't prepend it with the user's org, it will automatically be prepended with the value of the HZN_ORG_ID environment variable.").Short('u').PlaceHolder("USER:PW").String()
-	devWorkloadKeyfile := devWorkloadDeployCmd.Flag("keyFile", "File containing a private key used to sign the deployment configuration.").Short('k').String()
-	devWorkPubKeyFile := devWorkloadDeployCmd.Flag("public-key-file", "The path of public key file (that corresponds to the private key) that should be stored with the workload, to be used by the Horizon Agent to verify the signature.").Short('K').ExistingFile()
+	devWorkloadKeyfile := devWorkloadDeployCmd.Flag("keyFile", "File containing a private key, or the name of a key pair in the local keystore, used to sign the deployment configuration.").Short('k').String()
+	devWorkPubKeyFile := devWorkloadDeployCmd.Flag("public-key-file", "The path of public key file (that corresponds to the private key) that should be stored with the workload, to be used by the Horizon Agent to verify the signature. Defaults to the public key of the keystore key pair when the private key is a keystore name.").Short('K').String()
 	devWorkPubDontTouchImage := devWorkloadDeployCmd.Flag("dont-change-image-tag", "The image paths in the deployment field have regular tags and should not be changed to sha256 values. This should only be used during development when testing new versions often.").Short('I').Bool()
+	devWorkPubStrict := devWorkloadDeployCmd.Flag("strict", "Validate the deployment field against the container schema before signing it, and refuse to publish it if it has unknown fields or invalid values. Use --no-strict to skip the validation.").Default("true").Bool()
 	devWorkloadValidateCmd := devWorkloadCmd.Command("verify", "Validate the project for completeness and schema compliance.")
 	devWorkloadVerifyUserInputFile := devWorkloadValidateCmd.Flag("userInputFile", "File containing user input values for verification of a project.").Short('f').String()
 
@@ -328,20 +487,24 @@ Environment Variables:
 	devMicroserviceNewCmdOrg := devMicroserviceNewCmd.Flag("org", "The Org id that the microservice is defined within. If this flag is omitted, the HZN_ORG_ID environment variable is ued.").Short('o').String()
 	devMicroserviceStartTestCmd := devMicroserviceCmd.Command("start", "Run a microservice in a mocked Horizon Agent environment.")
 	devMicroserviceUserInputFile := devMicroserviceStartTestCmd.Flag("userInputFile", "File containing user input values for running a test.").Short('f').String()
+	devMicroserviceOverridesFile := devMicroserviceStartTestCmd.Flag("overridesFile", "File containing deployment config overrides (image tag, environment, binds, ports) that are only used when running the microservice locally. If omitted, dev.overrides.json in the project is used when it exists.").Short('O').String()
 	devMicroserviceStopTestCmd := devMicroserviceCmd.Command("stop", "Stop a microservice that is running in a mocked Horizon Agent environment.")
 	devMicroserviceDeployCmd := devMicroserviceCmd.Command("publish", "Publish a microservice to a Horizon Exchange.")
 	devMicroserviceDeployCmdUserPw := devMicroserviceDeployCmd.Flag("user-pw", "Horizon Exchange user credentials to create exchange resources. If you don't prepend it with the user's org, it will automatically be prepended with the value of the HZN_ORG_ID environment variable.").Short('u').PlaceHolder("USER:PW").String()
-	devMicroserviceKeyfile := devMicroserviceDeployCmd.Flag("keyFile", "File containing a private key used to sign the deployment configuration.").Short('k').String()
-	devMicroservicePubKeyFile := devMicroserviceDeployCmd.Flag("public-key-file", "The path of public key file (that corresponds to the private key) that should be stored with the microservice, to be used by the Horizon Agent to verify the signature.").Short('K').ExistingFile()
+	devMicroserviceKeyfile := devMicroserviceDeployCmd.Flag("keyFile", "File containing a private key, or the name of a key pair in the local keystore, used to sign the deployment configuration.").Short('k').String()
+	devMicroservicePubKeyFile := devMicroserviceDeployCmd.Flag("public-key-file", "The path of public key file (that corresponds to the private key) that should be stored with the microservice, to be used by the Horizon Agent to verify the signature. Defaults to the public key of the keystore key pair when the private key is a keystore name.").Short('K').String()
 	devMicroservicePubDontTouchImage := devMicroserviceDeployCmd.Flag("dont-change-image-tag", "The image paths in the deployment field have regular tags and should not be changed to sha256 digest values. This should only be used during development when testing new versions often.").Short('I').Bool()
+	devMicroservicePubStrict := devMicroserviceDeployCmd.Flag("strict", "Validate the deployment field against the container schema before signing it, and refuse to publish it if it has unknown fields or invalid values. Use --no-strict to skip the validation.").Default("true").Bool()
 	devMicroserviceValidateCmd := devMicroserviceCmd.Command("verify", "Validate the project for completeness and schema compliance.")
 	devMicroserviceVerifyUserInputFile := devMicroserviceValidateCmd.Flag("userInputFile", "File containing user input values for verification of a project.").Short('f').String()
 
 	devServiceCmd := devCmd.Command("service", "For working with a service project.")
 	devServiceNewCmd := devServiceCmd.Command("new", "Create a new service project.")
 	devServiceNewCmdOrg := devServiceNewCmd.Flag("org", "The Org id that the service is defined within. If this flag is omitted, the HZN_ORG_ID environment variable is ued.").Short('o').String()
+	devServiceNewCmdTemplate := devServiceNewCmd.Flag("template", "Create a working example service written in this language: go, python or node. The source code, Dockerfile and Makefile of the service are created in the parent directory of the horizon metadata directory, and the service is named after that directory.").Short('t').String()
 	devServiceStartTestCmd := devServiceCmd.Command("start", "Run a service in a mocked Horizon Agent environment.")
 	devServiceUserInputFile := devServiceStartTestCmd.Flag("userInputFile", "File containing user input values for running a test.").Short('f').String()
+	devServiceOverridesFile := devServiceStartTestCmd.Flag("overridesFile", "File containing deployment config overrides (image tag, environment, binds, ports) that are only used when running the service locally. If omitted, dev.overrides.json in the project is used when it exists.").Short('O').String()
 	devServiceStopTestCmd := devServiceCmd.Command("stop", "Stop a service that is running in a mocked Horizon Agent environment.")
 	devServiceValidateCmd := devServiceCmd.Command("verify", "Validate the project for completeness and schema compliance.")
 	devServiceVerifyUserInputFile := devServiceValidateCmd.Flag("userInputFile", "File containing user input values for verification of a project.").Short('f').String()
@@ -375,13 +538,76 @@ Environment Variables:
 	agbotPolicyName := agbotPolicyListCmd.Arg("name", "The policy name.").String()
 	agbotStatusCmd := agbotCmd.Command("status", "Display the current horizon internal status for the Horizon agreement bot.")
 	agbotStatusLong := agbotStatusCmd.Flag("long", "Show detailed status").Short('l').Bool()
+	agbotDiagnoseCmd := agbotCmd.Command("diagnose", "Check the Horizon agreement bot's connection to the exchange, served patterns, policy directory, database, blockchain clients and mailbox, and display what to do about each problem found.")
+	agbotDiagnoseProblems := agbotDiagnoseCmd.Flag("problems", "Only display the checks that found a problem.").Short('p').Bool()
+	agbotSimulateCmd := agbotCmd.Command("simulate", "Run the policy checks the Horizon agreement bot makes before it proposes an agreement to a node, and display the proposal it would send or why it would not send one. Nothing is sent to the node.")
+	agbotSimulateNode := agbotSimulateCmd.Flag("node", "The node to simulate the agreement with, as org/id.").Short('n').Required().String()
+	agbotSimulatePattern := agbotSimulateCmd.Flag("pattern", "The pattern the agreement would be made for, as org/name.").Short('p').Required().String()
+	agbotSupportCmd := agbotCmd.Command("support", "Collect information about this Horizon agreement bot for a problem report.")
+	agbotSupportBundleCmd := agbotSupportCmd.Command("bundle", "Gather the status, the worker status log, the recent exchange calls, an agreement summary, the diagnostic checks, the configuration and the recent log of the Horizon agreement bot into a single archive that can be attached to a problem report. Tokens, passwords and other secrets are removed.")
+	agbotSupportBundleFile := agbotSupportBundleCmd.Flag("file", "The archive to write. Defaults to horizon-agbot-support-<time>.tar.gz in the current directory.").Short('f').String()
+	agbotSupportBundleConfig := agbotSupportBundleCmd.Flag("config", "The Horizon agreement bot configuration file.").Short('c').Default(support.DEFAULT_CONFIG_FILE).String()
+	agbotSupportBundleLogFile := agbotSupportBundleCmd.Flag("log-file", "A file with the Horizon agreement bot log. If omitted, the log is read from the journal of the --unit systemd unit.").Short('l').String()
+	agbotSupportBundleUnit := agbotSupportBundleCmd.Flag("unit", "The systemd unit the Horizon agreement bot runs as.").Default(support.DEFAULT_LOG_UNIT).String()
+	agbotSupportBundleLines := agbotSupportBundleCmd.Flag("lines", "The number of the most recent log lines to include.").Short('n').Default(support.DEFAULT_LOG_LINES).Int()
+	agbotBulkCancelCmd := agbotCmd.Command("bulkcancel", "Cancel all the agreements that match a filter, in paced batches, for example to withdraw a bad workload version from all the edge nodes. A job must be planned, which lists the agreements it would cancel without cancelling any, before it can be started.")
+	agbotBulkCancelPlanCmd := agbotBulkCancelCmd.Command("plan", "Plan a bulk cancel job and display the active agreements it would cancel. No agreements are cancelled until the job is started.")
+	agbotBulkCancelPlanName := agbotBulkCancelPlanCmd.Arg("name", "The name of the job.").Required().String()
+	agbotBulkCancelOrg := agbotBulkCancelPlanCmd.Flag("org", "Cancel agreements made with policies in this organization.").Short('o').Required().String()
+	agbotBulkCancelPattern := agbotBulkCancelPlanCmd.Flag("pattern", "Cancel agreements made for this pattern.").Short('p').String()
+	agbotBulkCancelWorkloadURL := agbotBulkCancelPlanCmd.Flag("workload-url", "Cancel agreements running this workload or service.").Short('w').String()
+	agbotBulkCancelWorkloadVersion := agbotBulkCancelPlanCmd.Flag("workload-version", "Cancel agreements running this version, or a version in this range (e.g. '[1.0.0,1.2.0)'), of the workload. Requires --workload-url.").Short('v').String()
+	agbotBulkCancelNodes := agbotBulkCancelPlanCmd.Flag("node", "Cancel agreements with this org qualified node id. This flag can be repeated.").Short('n').Strings()
+	agbotBulkCancelBatchSize := agbotBulkCancelPlanCmd.Flag("batch-size", "The number of agreements to cancel in each batch.").Default("10").Int()
+	agbotBulkCancelBatchInterval := agbotBulkCancelPlanCmd.Flag("batch-interval", "The number of seconds to wait between batches.").Default("30").Int()
+	agbotBulkCancelListCmd := agbotBulkCancelCmd.Command("list", "Display the bulk cancel jobs and their progress.")
+	agbotBulkCancelListName := agbotBulkCancelListCmd.Arg("name", "List just this one job.").String()
+	agbotBulkCancelListLong := agbotBulkCancelListCmd.Flag("long", "Also list the agreements of each job.").Short('l').Bool()
+	agbotBulkCancelStartCmd := agbotBulkCancelCmd.Command("start", "Start cancelling the agreements of a planned job. A job that is running when the agbot restarts resumes where it left off.")
+	agbotBulkCancelStartName := agbotBulkCancelStartCmd.Arg("name", "The name of the job.").Required().String()
+	agbotBulkCancelAbortCmd := agbotBulkCancelCmd.Command("abort", "Stop a planned or running job. The agreements already cancelled by the job stay cancelled.")
+	agbotBulkCancelAbortName := agbotBulkCancelAbortCmd.Arg("name", "The name of the job.").Required().String()
+	agbotBulkCancelRemoveCmd := agbotBulkCancelCmd.Command("remove", "Remove a job that is not running.")
+	agbotBulkCancelRemoveName := agbotBulkCancelRemoveCmd.Arg("name", "The name of the job.").Required().String()
+	agbotQuotaCmd := agbotCmd.Command("quota", "List and manage the quotas of the organizations this Horizon agreement bot serves: the max number of agreements, proposals per minute and served patterns of each organization.")
+	agbotQuotaListCmd := agbotQuotaCmd.Command("list", "Display the quota of each organization, where it comes from and how much of it is used.")
+	agbotQuotaListOrg := agbotQuotaListCmd.Arg("org", "List just this one organization.").String()
+	agbotQuotaSetCmd := agbotQuotaCmd.Command("set", "Replace the quota of an organization, until it is removed. Use '*' for the organizations that don't have their own quota. Zero means no limit.")
+	agbotQuotaSetOrg := agbotQuotaSetCmd.Arg("org", "The organization.").Required().String()
+	agbotQuotaSetAgreements := agbotQuotaSetCmd.Flag("agreements", "The max number of agreements with the nodes of the organization.").Short('a').Default("0").Int()
+	agbotQuotaSetProposals := agbotQuotaSetCmd.Flag("proposals", "The max number of proposals per minute made to the nodes of the organization.").Short('p').Default("0").Int()
+	agbotQuotaSetPatterns := agbotQuotaSetCmd.Flag("patterns", "The max number of the organization's patterns that are served.").Short('s').Default("0").Int()
+	agbotQuotaRemoveCmd := agbotQuotaCmd.Command("remove", "Remove the quota set with 'hzn agbot quota set', so that the organization goes back to the quota in the agbot configuration.")
+	agbotQuotaRemoveOrg := agbotQuotaRemoveCmd.Arg("org", "The organization.").Required().String()
+	agbotMeterCmd := agbotCmd.Command("meter", "Export the metering records this Horizon agreement bot sent to the nodes, for reconciliation with a billing system.")
+	agbotMeterExportCmd := agbotMeterCmd.Command("export", "Display or save the metering records sent in a time range, or their totals by organization and pattern.")
+	agbotMeterExportSince := agbotMeterExportCmd.Flag("since", "The start of the time range: an RFC3339 time, seconds since 1970, or a duration like 24h meaning that long ago.").Required().String()
+	agbotMeterExportUntil := agbotMeterExportCmd.Flag("until", "The end of the time range, in the same forms as --since. Defaults to now.").String()
+	agbotMeterExportOrg := agbotMeterExportCmd.Flag("org", "Export just the records of the agreements with the nodes of this organization.").Short('o').String()
+	agbotMeterExportPattern := agbotMeterExportCmd.Flag("pattern", "Export just the records of the agreements made for this pattern.").Short('p').String()
+	agbotMeterExportFormat := agbotMeterExportCmd.Flag("format", "The format of the export: json or csv.").Short('f').Default("json").String()
+	agbotMeterExportAggregate := agbotMeterExportCmd.Flag("aggregate", "Export the tokens granted by organization and pattern instead of the records.").Short('a').Bool()
+	agbotMeterExportWindow := agbotMeterExportCmd.Flag("window", "With --aggregate, add up the tokens by time windows of this duration, like 1h or 24h.").Short('w').String()
+	agbotMeterExportFile := agbotMeterExportCmd.Flag("file", "Write the export to this file instead of standard output.").String()
 
 	utilCmd := app.Command("util", "Utility commands.")
 	utilSignCmd := utilCmd.Command("sign", "Sign the text in stdin. The signature is sent to stdout.")
 	utilSignPrivKeyFile := utilSignCmd.Flag("private-key-file", "The path of a private key file to be used to sign the stdin. ").Short('k').Required().ExistingFile()
+	utilSignDeployment := utilSignCmd.Flag("deployment", "Stdin is a deployment config, a json object or an escaped json string. It is converted to its deployment string, as 'hzn util deployment canonicalize' does, and the deployment string and its signature are sent to stdout as json, with the field names of the 'hzn exchange ... publish' input files.").Bool()
+	utilSignStrict := utilSignCmd.Flag("strict", "With --deployment, validate the deployment config against the container schema before signing it. Use --no-strict to skip the validation.").Default("true").Bool()
 	utilVerifyCmd := utilCmd.Command("verify", "Verify that the signature specified via -s is a valid signature for the text in stdin.")
 	utilVerifyPubKeyFile := utilVerifyCmd.Flag("public-key-file", "The path of public key file (that corresponds to the private key that was used to sign) to verify the signature of stdin.").Short('K').Required().ExistingFile()
 	utilVerifySig := utilVerifyCmd.Flag("signature", "The supposed signature of stdin.").Short('s').Required().String()
+	utilVerifyDeployment := utilVerifyCmd.Flag("deployment", "Stdin is a deployment config. A json object is verified as the deployment string 'hzn util sign --deployment' creates from it, a json string is verified as it is.").Bool()
+	utilDeploymentCmd := utilCmd.Command("deployment", "Deployment configs of workloads, microservices and services.")
+	utilDeploymentCanonicalizeCmd := utilDeploymentCmd.Command("canonicalize", "Convert the deployment config in stdin, a json object or an escaped json string, to the deployment string that is signed and published: compact json with the keys sorted. The deployment string is sent to stdout.")
+	utilDeploymentCanonicalizeStrict := utilDeploymentCanonicalizeCmd.Flag("strict", "Validate the deployment config against the container schema, like 'hzn exchange service publish' does. Use --no-strict to skip the validation.").Default("true").Bool()
+	utilCertCmd := utilCmd.Command("cert", "Certificates of the Horizon agent and agbot APIs.")
+	utilCertFetchCmd := utilCertCmd.Command("fetch", "Fetch the CA cert of the self-signed cert that the Horizon agent API is served with over https, so that it can be trusted with HZN_API_CA_CERT. The cert is written to stdout and its fingerprint to stderr.")
+	utilCertFetchAgbot := utilCertFetchCmd.Flag("agbot", "Fetch the CA cert of the agbot API instead of the agent API.").Bool()
+	utilCertFetchFile := utilCertFetchCmd.Flag("file", "The file to write the CA cert to, instead of stdout.").Short('f').String()
+	utilOutputSchemaCmd := utilCmd.Command("output-schema", "Display the json schema of the output of the list commands, for the current output version, and the changes made to the output in each version. Automation that parses the json output can pin the version it was written for with --output-version.")
+	utilOutputSchemaCommand := utilOutputSchemaCmd.Arg("command", "Only display the schema of the output of this command, for example 'hzn agreement list'.").String()
 
 	app.Version("Run 'hzn version' to see the Horizon version.")
 	/* trying to override the base --version behavior does not work....
@@ -396,21 +622,39 @@ Environment Variables:
 	fullCmd := kingpin.MustParse(app.Parse(os.Args[1:]))
 	//cliutils.Verbose("Full command: %s", fullCmd)
 	if strings.HasPrefix(fullCmd, "exchange") {
-		exOrg = cliutils.RequiredWithDefaultEnvVar(exOrg, "HZN_ORG_ID", "organization ID must be specified with either the -o flag or HZN_ORG_ID")
-		exUserPw = cliutils.RequiredWithDefaultEnvVar(exUserPw, "HZN_EXCHANGE_USER_AUTH", "exchange user authentication must be specified with either the -u flag or HZN_EXCHANGE_USER_AUTH")
+		exOrg = cliutils.RequiredWithDefaultEnvVarOrLogin(exOrg, "HZN_ORG_ID", cliutils.CachedOrg, "organization ID must be specified with either the -o flag, HZN_ORG_ID, or 'hzn login'")
+		exUserPw = cliutils.RequiredWithDefaultEnvVarOrLogin(exUserPw, "HZN_EXCHANGE_USER_AUTH", cliutils.CachedUserPw, "exchange user authentication must be specified with either the -u flag, HZN_EXCHANGE_USER_AUTH, or 'hzn login'")
+	}
+	if fullCmd == loginCmd.FullCommand() {
+		loginOrg = cliutils.RequiredWithDefaultEnvVar(loginOrg, "HZN_ORG_ID", "organization ID must be specified with either the -o flag or HZN_ORG_ID")
+		loginUserPw = cliutils.RequiredWithDefaultEnvVar(loginUserPw, "HZN_EXCHANGE_USER_AUTH", "exchange user authentication must be specified with either the -u flag or HZN_EXCHANGE_USER_AUTH")
 	}
 	if strings.HasPrefix(fullCmd, "wiotp") {
 		wiotpOrg = cliutils.RequiredWithDefaultEnvVar(wiotpOrg, "HZN_ORG_ID", "organization ID must be specified with either the -o flag or HZN_ORG_ID")
 		wiotpApiKeyToken = cliutils.RequiredWithDefaultEnvVar(wiotpApiKeyToken, "HZN_EXCHANGE_API_AUTH", "WIoTP API key authentication must be specified with either the -A flag or HZN_EXCHANGE_API_AUTH")
 	}
+	if (fullCmd == keyCreateCmd.FullCommand() && len(*keyCreateUploads) != 0) || (fullCmd == keyImportCmd.FullCommand() && len(*keyImportUploads) != 0) {
+		keyOrg = cliutils.RequiredWithDefaultEnvVarOrLogin(keyOrg, "HZN_ORG_ID", cliutils.CachedOrg, "organization ID must be specified with either the -o flag, HZN_ORG_ID, or 'hzn login'")
+		keyUserPw = cliutils.RequiredWithDefaultEnvVarOrLogin(keyUserPw, "HZN_EXCHANGE_USER_AUTH", cliutils.CachedUserPw, "exchange user authentication must be specified with either the -u flag, HZN_EXCHANGE_USER_AUTH, or 'hzn login'")
+	}
+	if fullCmd == nodeScanCmd.FullCommand() {
+		nodeScanExchangeUrl = cliutils.WithDefaultEnvVar(nodeScanExchangeUrl, "HZN_EXCHANGE_URL")
+		nodeScanCssUrl = cliutils.WithDefaultEnvVar(nodeScanCssUrl, "HZN_FSS_CSSURL")
+		nodeScanOrg = cliutils.WithDefaultEnvVarOrLogin(nodeScanOrg, "HZN_ORG_ID", cliutils.CachedOrg)
+		nodeScanUserPw = cliutils.WithDefaultEnvVarOrLogin(nodeScanUserPw, "HZN_EXCHANGE_USER_AUTH", cliutils.CachedUserPw)
+	}
 	if strings.HasPrefix(fullCmd, "register") {
-		userPw = cliutils.WithDefaultEnvVar(userPw, "HZN_EXCHANGE_USER_AUTH")
+		userPw = cliutils.WithDefaultEnvVarOrLogin(userPw, "HZN_EXCHANGE_USER_AUTH", cliutils.CachedUserPw)
 	}
 
 	// Decide which command to run
 	switch fullCmd {
 	case versionCmd.FullCommand():
 		node.Version()
+	case loginCmd.FullCommand():
+		exchange.Login(*loginOrg, *loginUserPw)
+	case logoutCmd.FullCommand():
+		exchange.Logout()
 	case exVersionCmd.FullCommand():
 		exchange.Version(*exOrg, *exUserPw)
 	case exStatusCmd.FullCommand():
@@ -421,12 +665,46 @@ Environment Variables:
 		exchange.UserCreate(*exOrg, *exUserPw, *exUserCreateEmail)
 	case exUserDelCmd.FullCommand():
 		exchange.UserRemove(*exOrg, *exUserPw, *exDelUser, *exUserDelForce)
+	case exOrgListCmd.FullCommand():
+		exchange.OrgList(*exOrg, *exUserPw, *exOrgListOrg, !*exOrgListLong)
+	case exOrgCreateCmd.FullCommand():
+		exchange.OrgCreate(*exOrg, *exUserPw, *exOrgCreateOrg, *exOrgCreateLabel, *exOrgCreateDesc)
+	case exOrgUpdateCmd.FullCommand():
+		exchange.OrgUpdate(*exOrg, *exUserPw, *exOrgUpdateOrg, *exOrgUpdateLabel, *exOrgUpdateDesc)
+	case exOrgDelCmd.FullCommand():
+		exchange.OrgRemove(*exOrg, *exUserPw, *exOrgDelOrg, *exOrgDelForce)
+	case exOrgUserListCmd.FullCommand():
+		exchange.OrgUserList(*exOrg, *exUserPw, *exOrgUserListOrg, *exOrgUserListUser)
+	case exOrgUserCreateCmd.FullCommand():
+		exchange.OrgUserCreate(*exOrg, *exUserPw, *exOrgUserCreateOrg, *exOrgUserCreateUserPw, *exOrgUserCreateEmail, *exOrgUserCreateAdmin)
+	case exOrgUserDelCmd.FullCommand():
+		exchange.OrgUserRemove(*exOrg, *exUserPw, *exOrgUserDelOrg, *exOrgUserDelUser, *exOrgUserDelForce)
+	case exOrgListKeyCmd.FullCommand():
+		exchange.OrgListKey(*exOrg, *exUserPw, *exOrgListKeyOrg)
+	case exOrgAddKeyCmd.FullCommand():
+		exchange.OrgAddKey(*exOrg, *exUserPw, *exOrgAddKeyOrg, *exOrgAddKeyFile)
+	case exOrgRemKeyCmd.FullCommand():
+		exchange.OrgRemoveKey(*exOrg, *exUserPw, *exOrgRemKeyOrg, *exOrgRemKeyKey, *exOrgRemKeyForce)
 	case exNodeListCmd.FullCommand():
 		exchange.NodeList(*exOrg, *exUserPw, *exNode, !*exNodeLong)
 	case exNodeCreateCmd.FullCommand():
 		exchange.NodeCreate(*exOrg, *exNodeIdTok, *exUserPw, *exNodeEmail)
 	case exNodeDelCmd.FullCommand():
 		exchange.NodeRemove(*exOrg, *exUserPw, *exDelNode, *exNodeDelForce)
+	case exNodeCopyConfigCmd.FullCommand():
+		if *exNodeCCApply != "" {
+			exchange.NodeApplyConfig(*exOrg, *exUserPw, *exNodeCCNodes, *exNodeCCApply)
+		} else if len(*exNodeCCNodes) != 1 {
+			cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "the configuration can only be copied from 1 node, use --apply to apply it to several nodes")
+		} else {
+			exchange.NodeCopyConfig(*exOrg, *exUserPw, (*exNodeCCNodes)[0])
+		}
+	case exNodeListPolicyCmd.FullCommand():
+		exchange.NodeListPolicy(*exOrg, *exUserPw, *exNodeLPNode)
+	case exNodeAddPolicyCmd.FullCommand():
+		exchange.NodeAddPolicy(*exOrg, *exUserPw, *exNodeAPNode, *exNodeAPJsonFile)
+	case exNodeRemovePolicyCmd.FullCommand():
+		exchange.NodeRemovePolicy(*exOrg, *exUserPw, *exNodeRPNode, *exNodeRPForce)
 	case exAgbotListCmd.FullCommand():
 		exchange.AgbotList(*exOrg, *exUserPw, *exAgbot, !*exAgbotLong)
 	case exAgbotListPatsCmd.FullCommand():
@@ -435,15 +713,30 @@ Environment Variables:
 		exchange.AgbotAddPattern(*exOrg, *exUserPw, *exAgbotAP, *exAgbotAPPatOrg, *exAgbotAPPat)
 	case exAgbotDelPatCmd.FullCommand():
 		exchange.AgbotRemovePattern(*exOrg, *exUserPw, *exAgbotDP, *exAgbotDPPatOrg, *exAgbotDPPat)
+	case exAgbotListBPCmd.FullCommand():
+		exchange.AgbotListBusinessPolicy(*exOrg, *exUserPw, *exAgbotLBP)
+	case exAgbotAddBPCmd.FullCommand():
+		exchange.AgbotAddBusinessPolicy(*exOrg, *exUserPw, *exAgbotABP, *exAgbotABPPolOrg, *exAgbotABPPol, *exAgbotABPNodeOrg)
+	case exAgbotDelBPCmd.FullCommand():
+		exchange.AgbotRemoveBusinessPolicy(*exOrg, *exUserPw, *exAgbotDBP, *exAgbotDBPPolOrg, *exAgbotDBPPol, *exAgbotDBPNodeOrg)
+	case exBusinessListPolicyCmd.FullCommand():
+		exchange.BusinessListPolicy(*exOrg, *exUserPw, *exBusinessLP, !*exBusinessLPLong)
+	case exBusinessAddPolicyCmd.FullCommand():
+		exchange.BusinessAddPolicy(*exOrg, *exUserPw, *exBusinessAP, *exBusinessAPJsonFile)
+	case exBusinessRemovePolicyCmd.FullCommand():
+		exchange.BusinessRemovePolicy(*exOrg, *exUserPw, *exBusinessRP, *exBusinessRPForce)
 	case exPatternListCmd.FullCommand():
 		exchange.PatternList(*exOrg, *exUserPw, *exPattern, !*exPatternLong)
 	case exPatternPublishCmd.FullCommand():
-		exchange.PatternPublish(*exOrg, *exUserPw, *exPatJsonFile, *exPatKeyFile, *exPatPubPubKeyFile, *exPatName)
+		*exPatKeyFile, *exPatPubPubKeyFile = key.SigningKeyFiles(*exPatKeyFile, *exPatPubPubKeyFile)
+		exchange.PatternPublish(*exOrg, *exUserPw, *exPatJsonFile, *exPatKeyFile, *exPatPubPubKeyFile, *exPatName, *exPatVerifyRefs)
 	case exPatternVerifyCmd.FullCommand():
+		*exPatPubKeyFile = key.VerificationKeyFile(*exPatPubKeyFile)
 		exchange.PatternVerify(*exOrg, *exUserPw, *exVerPattern, *exPatPubKeyFile)
 	case exPatDelCmd.FullCommand():
 		exchange.PatternRemove(*exOrg, *exUserPw, *exDelPat, *exPatDelForce)
 	case exPatternAddWorkCmd.FullCommand():
+		*exPatAddWorkKeyFile, *exPatAddWorkPubKeyFile = key.SigningKeyFiles(*exPatAddWorkKeyFile, *exPatAddWorkPubKeyFile)
 		exchange.PatternAddWorkload(*exOrg, *exUserPw, *exPatAddWork, *exPatAddWorkJsonFile, *exPatAddWorkKeyFile, *exPatAddWorkPubKeyFile)
 	case exPatternDelWorkCmd.FullCommand():
 		exchange.PatternDelWorkload(*exOrg, *exUserPw, *exPatDelWorkPat, *exPatDelWorkOrg, *exPatDelWorkUrl, *exPatDelWorkArch)
@@ -454,8 +747,10 @@ Environment Variables:
 	case exWorkloadListCmd.FullCommand():
 		exchange.WorkloadList(*exOrg, *exUserPw, *exWorkload, !*exWorkloadLong)
 	case exWorkloadPublishCmd.FullCommand():
-		exchange.WorkloadPublish(*exOrg, *exUserPw, *exWorkJsonFile, *exWorkPrivKeyFile, *exWorkPubPubKeyFile, *exWorkPubDontTouchImage)
+		*exWorkPrivKeyFile, *exWorkPubPubKeyFile = key.SigningKeyFiles(*exWorkPrivKeyFile, *exWorkPubPubKeyFile)
+		exchange.WorkloadPublish(*exOrg, *exUserPw, *exWorkJsonFile, *exWorkPrivKeyFile, *exWorkPubPubKeyFile, *exWorkPubDontTouchImage, *exWorkPubStrict)
 	case exWorkloadVerifyCmd.FullCommand():
+		*exWorkPubKeyFile = key.VerificationKeyFile(*exWorkPubKeyFile)
 		exchange.WorkloadVerify(*exOrg, *exUserPw, *exVerWorkload, *exWorkPubKeyFile)
 	case exWorkDelCmd.FullCommand():
 		exchange.WorkloadRemove(*exOrg, *exUserPw, *exDelWork, *exWorkDelForce)
@@ -463,23 +758,31 @@ Environment Variables:
 		exchange.WorkloadListKey(*exOrg, *exUserPw, *exWorkListKeyWork, *exWorkListKeyKey)
 	case exWorkloadRemKeyCmd.FullCommand():
 		exchange.WorkloadRemoveKey(*exOrg, *exUserPw, *exWorkRemKeyWork, *exWorkRemKeyKey)
+	case exWorkloadMigrateCmd.FullCommand():
+		exchange.WorkloadMigrate(*exOrg, *exUserPw, *exWorkMigrateWork, *exWorkMigrateDryRun)
 	case exMicroserviceListCmd.FullCommand():
-		exchange.MicroserviceList(*exOrg, *exUserPw, *exMicroservice, !*exMicroserviceLong)
+		exchange.MicroserviceList(*exOrg, *exUserPw, *exMicroservice, !*exMicroserviceLong, *exMicroserviceFilters, *exMicroserviceOutput)
 	case exMicroservicePublishCmd.FullCommand():
-		exchange.MicroservicePublish(*exOrg, *exUserPw, *exMicroJsonFile, *exMicroKeyFile, *exMicroPubPubKeyFile, *exMicroPubDontTouchImage)
+		*exMicroKeyFile, *exMicroPubPubKeyFile = key.SigningKeyFiles(*exMicroKeyFile, *exMicroPubPubKeyFile)
+		exchange.MicroservicePublish(*exOrg, *exUserPw, *exMicroJsonFile, *exMicroKeyFile, *exMicroPubPubKeyFile, *exMicroPubDontTouchImage, *exMicroPubRegistryAuthFile, *exMicroPubPushTo, *exMicroPubStrict)
 	case exMicroVerifyCmd.FullCommand():
-		exchange.MicroserviceVerify(*exOrg, *exUserPw, *exVerMicro, *exMicroPubKeyFile)
+		*exMicroPubKeyFile = key.VerificationKeyFile(*exMicroPubKeyFile)
+		exchange.MicroserviceVerify(*exOrg, *exUserPw, *exVerMicro, *exMicroPubKeyFile, *exMicroVerifyExchKeys)
 	case exMicroDelCmd.FullCommand():
 		exchange.MicroserviceRemove(*exOrg, *exUserPw, *exDelMicro, *exMicroDelForce)
 	case exMicroListKeyCmd.FullCommand():
 		exchange.MicroserviceListKey(*exOrg, *exUserPw, *exMicroListKeyMicro, *exMicroListKeyKey)
 	case exMicroRemKeyCmd.FullCommand():
 		exchange.MicroserviceRemoveKey(*exOrg, *exUserPw, *exMicroRemKeyMicro, *exMicroRemKeyKey)
+	case exMicroMigrateCmd.FullCommand():
+		exchange.MicroserviceMigrate(*exOrg, *exUserPw, *exMicroMigrateMicro, *exMicroMigrateDryRun)
 	case exServiceListCmd.FullCommand():
 		exchange.ServiceList(*exOrg, *exUserPw, *exService, !*exServiceLong)
 	case exServicePublishCmd.FullCommand():
-		exchange.ServicePublish(*exOrg, *exUserPw, *exSvcJsonFile, *exSvcPrivKeyFile, *exSvcPubPubKeyFile, *exSvcPubDontTouchImage, *exSvcRegistryTokens)
+		*exSvcPrivKeyFile, *exSvcPubPubKeyFile = key.SigningKeyFiles(*exSvcPrivKeyFile, *exSvcPubPubKeyFile)
+		exchange.ServicePublish(*exOrg, *exUserPw, *exSvcJsonFile, *exSvcPrivKeyFile, *exSvcPubPubKeyFile, *exSvcPubDontTouchImage, *exSvcRegistryTokens, *exSvcPubStrict)
 	case exServiceVerifyCmd.FullCommand():
+		*exSvcPubKeyFile = key.VerificationKeyFile(*exSvcPubKeyFile)
 		exchange.ServiceVerify(*exOrg, *exUserPw, *exVerService, *exSvcPubKeyFile)
 	case exSvcDelCmd.FullCommand():
 		exchange.ServiceRemove(*exOrg, *exUserPw, *exDelSvc, *exSvcDelForce)
@@ -491,6 +794,8 @@ Environment Variables:
 		exchange.ServiceListAuth(*exOrg, *exUserPw, *exSvcListAuthSvc, *exSvcListAuthId)
 	case exServiceRemAuthCmd.FullCommand():
 		exchange.ServiceRemoveAuth(*exOrg, *exUserPw, *exSvcRemAuthSvc, *exSvcRemAuthId)
+	case exDeploymentCheckCmd.FullCommand():
+		exchange.DeploymentCheck(*exOrg, *exUserPw, *exDepCheckNode, *exDepCheckPattern, *exDepCheckInputFile)
 	case wiotpOrgCmd.FullCommand():
 		wiotp.OrgList(*wiotpOrg, *wiotpApiKeyToken)
 	case wiotpStatusCmd.FullCommand():
@@ -514,59 +819,97 @@ Environment Variables:
 	case registerCmd.FullCommand():
 		register.DoIt(*org, *pattern, *nodeIdTok, *userPw, *email, *inputFile)
 	case keyListCmd.FullCommand():
-		key.List(*keyName, *keyListAll)
+		if *keyListKeystore {
+			key.ListKeystore(*keyName)
+		} else {
+			key.List(*keyName, *keyListAll)
+		}
 	//case keyCmd.FullCommand():   // <- I'd like to just default to list in this case, but don't know how to do that yet
 	//	keyCmd.List()
 	case keyCreateCmd.FullCommand():
-		key.Create(*keyX509Org, *keyX509CN, *keyOutputDir, *keyLength, *keyDaysValid, *keyImportFlag)
+		key.Create(*keyX509Org, *keyX509CN, *keyOutputDir, *keyLength, *keyDaysValid, *keyImportFlag, *keyCreateName, *keyCreateUploads, *keyOrg, *keyUserPw)
 	case keyImportCmd.FullCommand():
-		key.Import(*keyImportPubKeyFile)
+		if *keyImportName != "" {
+			key.ImportToKeystore(*keyImportName, *keyImportPubKeyFile, *keyImportPrivKeyFile, *keyImportUploads, *keyOrg, *keyUserPw)
+		} else {
+			key.Import(*keyImportPubKeyFile)
+			for _, upload := range *keyImportUploads {
+				key.UploadPublicKey(*keyImportPubKeyFile, upload, *keyOrg, *keyUserPw)
+			}
+		}
 	case keyDelCmd.FullCommand():
-		key.Remove(*keyDelName)
+		if *keyDelKeystore {
+			key.RemoveFromKeystore(*keyDelName)
+		} else {
+			key.Remove(*keyDelName)
+		}
 	case nodeListCmd.FullCommand():
 		node.List()
+	case nodeStatusCmd.FullCommand():
+		node.Status(*nodeStatusOutput)
+	case nodeScanCmd.FullCommand():
+		node.Scan(node.ScanExchangeUrl(*nodeScanExchangeUrl), *nodeScanCssUrl, *nodeScanOrg, *nodeScanUserPw, *nodeScanPattern, *nodeScanProblems)
 	case agreementListCmd.FullCommand():
-		agreement.List(*listArchivedAgreements, *listAgreementId)
+		filter := agreement.NewListFilter(*listAgreementsWorkload, *listAgreementsState, *listAgreementsNewer, *listAgreementsOlder)
+		if *listWatchAgreements {
+			agreement.Watch(*listArchivedAgreements, *listAgreementId, *listWatchAgreementsInterval, filter, *listWatchAgreementsDiff)
+		} else if *listWatchAgreementsDiff {
+			cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "--diff can only be used with --watch")
+		} else {
+			agreement.List(*listArchivedAgreements, *listAgreementId, filter)
+		}
 	case agreementCancelCmd.FullCommand():
 		agreement.Cancel(*cancelAgreementId, *cancelAllAgreements)
+	case agreementReasonsCmd.FullCommand():
+		agreement.Reasons(*reasonsProtocol)
 	case meteringListCmd.FullCommand():
 		metering.List(*listArchivedMetering)
+	case meteringSummaryCmd.FullCommand():
+		metering.Summary()
 	case attributeListCmd.FullCommand():
 		attribute.List()
 	case serviceListCmd.FullCommand():
-		service.List()
+		if *listWatchServices {
+			service.Watch(*listWatchServicesInterval)
+		} else {
+			service.List()
+		}
 	case serviceRegisteredCmd.FullCommand():
 		service.Registered()
 	case workloadListCmd.FullCommand():
 		workload.List()
 	case unregisterCmd.FullCommand():
-		unregister.DoIt(*forceUnregister, *removeNodeUnregister)
+		unregister.DoIt(*forceUnregister, *removeNodeUnregister, *drainUnregister, *drainTimeoutUnregister)
 	case statusCmd.FullCommand():
 		status.DisplayStatus(*statusLong, false)
+	case supportBundleCmd.FullCommand():
+		support.Bundle(false, support.BundleOptions{OutputFile: *supportBundleFile, ConfigFile: *supportBundleConfig, LogFile: *supportBundleLogFile, LogUnit: *supportBundleUnit, LogLines: *supportBundleLines})
 	case devWorkloadNewCmd.FullCommand():
 		dev.WorkloadNew(*devHomeDirectory, *devWorkloadNewCmdOrg)
 	case devWorkloadStartTestCmd.FullCommand():
-		dev.WorkloadStartTest(*devHomeDirectory, *devWorkloadUserInputFile)
+		dev.WorkloadStartTest(*devHomeDirectory, *devWorkloadUserInputFile, *devWorkloadOverridesFile)
 	case devWorkloadStopTestCmd.FullCommand():
 		dev.WorkloadStopTest(*devHomeDirectory)
 	case devWorkloadValidateCmd.FullCommand():
 		dev.WorkloadValidate(*devHomeDirectory, *devWorkloadVerifyUserInputFile)
 	case devWorkloadDeployCmd.FullCommand():
-		dev.WorkloadDeploy(*devHomeDirectory, *devWorkloadKeyfile, *devWorkPubKeyFile, *devWorkloadDeployCmdUserPw, *devWorkPubDontTouchImage)
+		*devWorkloadKeyfile, *devWorkPubKeyFile = key.SigningKeyFiles(*devWorkloadKeyfile, *devWorkPubKeyFile)
+		dev.WorkloadDeploy(*devHomeDirectory, *devWorkloadKeyfile, *devWorkPubKeyFile, *devWorkloadDeployCmdUserPw, *devWorkPubDontTouchImage, *devWorkPubStrict)
 	case devMicroserviceNewCmd.FullCommand():
 		dev.MicroserviceNew(*devHomeDirectory, *devMicroserviceNewCmdOrg)
 	case devMicroserviceStartTestCmd.FullCommand():
-		dev.MicroserviceStartTest(*devHomeDirectory, *devMicroserviceUserInputFile)
+		dev.MicroserviceStartTest(*devHomeDirectory, *devMicroserviceUserInputFile, *devMicroserviceOverridesFile)
 	case devMicroserviceStopTestCmd.FullCommand():
 		dev.MicroserviceStopTest(*devHomeDirectory)
 	case devMicroserviceValidateCmd.FullCommand():
 		dev.MicroserviceValidate(*devHomeDirectory, *devMicroserviceVerifyUserInputFile)
 	case devMicroserviceDeployCmd.FullCommand():
-		dev.MicroserviceDeploy(*devHomeDirectory, *devMicroserviceKeyfile, *devMicroservicePubKeyFile, *devMicroserviceDeployCmdUserPw, *devMicroservicePubDontTouchImage)
+		*devMicroserviceKeyfile, *devMicroservicePubKeyFile = key.SigningKeyFiles(*devMicroserviceKeyfile, *devMicroservicePubKeyFile)
+		dev.MicroserviceDeploy(*devHomeDirectory, *devMicroserviceKeyfile, *devMicroservicePubKeyFile, *devMicroserviceDeployCmdUserPw, *devMicroservicePubDontTouchImage, *devMicroservicePubStrict)
 	case devServiceNewCmd.FullCommand():
-		dev.ServiceNew(*devHomeDirectory, *devServiceNewCmdOrg)
+		dev.ServiceNew(*devHomeDirectory, *devServiceNewCmdOrg, *devServiceNewCmdTemplate)
 	case devServiceStartTestCmd.FullCommand():
-		dev.ServiceStartTest(*devHomeDirectory, *devServiceUserInputFile)
+		dev.ServiceStartTest(*devHomeDirectory, *devServiceUserInputFile, *devServiceOverridesFile)
 	case devServiceStopTestCmd.FullCommand():
 		dev.ServiceStopTest(*devHomeDirectory)
 	case devServiceValidateCmd.FullCommand():
@@ -586,10 +929,48 @@ Environment Variables:
 	case agbotPolicyListCmd.FullCommand():
 		agreementbot.PolicyList(*agbotPolicyOrg, *agbotPolicyName)
 	case utilSignCmd.FullCommand():
-		utilcmds.Sign(*utilSignPrivKeyFile)
+		if *utilSignDeployment {
+			utilcmds.SignDeployment(*utilSignPrivKeyFile, *utilSignStrict)
+		} else {
+			utilcmds.Sign(*utilSignPrivKeyFile)
+		}
 	case utilVerifyCmd.FullCommand():
-		utilcmds.Verify(*utilVerifyPubKeyFile, *utilVerifySig)
+		if *utilVerifyDeployment {
+			utilcmds.VerifyDeployment(*utilVerifyPubKeyFile, *utilVerifySig)
+		} else {
+			utilcmds.Verify(*utilVerifyPubKeyFile, *utilVerifySig)
+		}
+	case utilDeploymentCanonicalizeCmd.FullCommand():
+		utilcmds.DeploymentCanonicalize(*utilDeploymentCanonicalizeStrict)
+	case utilCertFetchCmd.FullCommand():
+		utilcmds.CertFetch(*utilCertFetchAgbot, *utilCertFetchFile)
+	case utilOutputSchemaCmd.FullCommand():
+		utilcmds.OutputSchema(*utilOutputSchemaCommand)
 	case agbotStatusCmd.FullCommand():
 		status.DisplayStatus(*agbotStatusLong, true)
+	case agbotDiagnoseCmd.FullCommand():
+		agreementbot.Diagnose(*agbotDiagnoseProblems)
+	case agbotSimulateCmd.FullCommand():
+		agreementbot.Simulate(*agbotSimulateNode, *agbotSimulatePattern)
+	case agbotSupportBundleCmd.FullCommand():
+		support.Bundle(true, support.BundleOptions{OutputFile: *agbotSupportBundleFile, ConfigFile: *agbotSupportBundleConfig, LogFile: *agbotSupportBundleLogFile, LogUnit: *agbotSupportBundleUnit, LogLines: *agbotSupportBundleLines})
+	case agbotBulkCancelPlanCmd.FullCommand():
+		agreementbot.BulkCancelPlan(*agbotBulkCancelPlanName, *agbotBulkCancelOrg, *agbotBulkCancelPattern, *agbotBulkCancelWorkloadURL, *agbotBulkCancelWorkloadVersion, *agbotBulkCancelNodes, *agbotBulkCancelBatchSize, *agbotBulkCancelBatchInterval)
+	case agbotBulkCancelListCmd.FullCommand():
+		agreementbot.BulkCancelList(*agbotBulkCancelListName, *agbotBulkCancelListLong)
+	case agbotBulkCancelStartCmd.FullCommand():
+		agreementbot.BulkCancelAction(*agbotBulkCancelStartName, "start")
+	case agbotBulkCancelAbortCmd.FullCommand():
+		agreementbot.BulkCancelAction(*agbotBulkCancelAbortName, "abort")
+	case agbotBulkCancelRemoveCmd.FullCommand():
+		agreementbot.BulkCancelRemove(*agbotBulkCancelRemoveName)
+	case agbotQuotaListCmd.FullCommand():
+		agreementbot.QuotaList(*agbotQuotaListOrg)
+	case agbotQuotaSetCmd.FullCommand():
+		agreementbot.QuotaSet(*agbotQuotaSetOrg, *agbotQuotaSetAgreements, *agbotQuotaSetProposals, *agbotQuotaSetPatterns)
+	case agbotQuotaRemoveCmd.FullCommand():
+		agreementbot.QuotaRemove(*agbotQuotaRemoveOrg)
+	case agbotMeterExportCmd.FullCommand():
+		agreementbot.MeterExport(*agbotMeterExportSince, *agbotMeterExportUntil, *agbotMeterExportOrg, *agbotMeterExportPattern, *agbotMeterExportFormat, *agbotMeterExportAggregate, *agbotMeterExportWindow, *agbotMeterExportFile)
 	}
 }
@@ -7,6 +7,7 @@ import (
 	"github.com/open-horizon/anax/cli/cliutils"
 	"github.com/open-horizon/rsapss-tool/generatekeys"
 	"net/http"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -72,20 +73,52 @@ func List(keyName string, listAll bool) {
 	}
 }
 
-// Create generates a private/public key pair
-func Create(x509Org, x509CN, outputDir string, keyLength, daysValid int, importKey bool) {
+// Create generates a private/public key pair. When a name is given, the key pair is created in the local keystore
+// under that name instead of in outputDir.
+func Create(x509Org, x509CN, outputDir string, keyLength, daysValid int, importKey bool, name string, uploads []string, org, userPw string) {
 	// Note: the cli parse already verifies outputDir exists and keyLength and daysValid are ints
+	if name != "" {
+		outputDir = newKeystoreEntryDir(name)
+	}
+
 	fmt.Println("Creating RSA PSS private and public keys, and an x509 certificate for distribution. This is a CPU-intensive operation and, depending on key length and platform, may take a while. Key generation on an amd64 or ppc64 system using the default key length will complete in less than 1 minute.")
-	newKeys, err := generatekeys.Write(outputDir, keyLength, x509CN, x509Org, time.Now().AddDate(0, 0, daysValid))
+	notAfter := time.Now().AddDate(0, 0, daysValid)
+	newKeys, err := generatekeys.Write(outputDir, keyLength, x509CN, x509Org, notAfter)
 	if err != nil {
+		if name != "" {
+			os.RemoveAll(outputDir)
+		}
 		cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, "failed to create a new key pair: %v", err)
 	}
-	var pubKeyName string // capture this in case they want us to import it
+	var pubKeyName, privKeyName string // capture these in case they want us to import or store them
 	fmt.Println("Created keys:")
 	for _, key := range newKeys {
 		fmt.Printf("\t%v\n", key)
 		if strings.Contains(key, "public") { // this seems like a better check than blindly getting the 2nd key in the list
 			pubKeyName = key
+		} else if strings.Contains(key, "private") {
+			privKeyName = key
+		}
+	}
+
+	// Remember the key pair in the keystore
+	if name != "" {
+		entry := &KeystoreEntry{
+			Name:          name,
+			PrivateKey:    privKeyName,
+			PublicKey:     pubKeyName,
+			KeyLength:     keyLength,
+			Created:       time.Now().Format(time.RFC3339),
+			NotValidAfter: notAfter.Format(time.RFC3339),
+		}
+		if err := saveKeystoreEntry(entry); err != nil {
+			cliutils.Fatal(cliutils.FILE_IO_ERROR, "%v", err)
+		}
+		fmt.Printf("Keys stored in the keystore as '%v'\n", name)
+		uploadKeystoreKey(entry, uploads, org, userPw)
+	} else {
+		for _, upload := range uploads {
+			UploadPublicKey(pubKeyName, upload, org, userPw)
 		}
 	}
 
@@ -0,0 +1,314 @@
+package key
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The local keystore is a directory with one sub-directory per key pair. Each sub-directory holds the private key, the
+// public key (x509 certificate) and a metadata file. Key pairs in the keystore can be referred to by name in the publish
+// and verify commands instead of by the path of their pem files.
+const KEYSTORE_ENVVAR = "HZN_KEYSTORE"
+const DEFAULT_KEYSTORE_DIR = ".hzn/keys" // relative to the user's home directory
+const KEYSTORE_ENTRY_FILE = "key.json"
+
+type KeystoreEntry struct {
+	Name          string   `json:"name"`
+	PrivateKey    string   `json:"private_key,omitempty"` // path of the private key file, empty when only the public key was imported
+	PublicKey     string   `json:"public_key"`            // path of the public key file
+	KeyLength     int      `json:"key_length,omitempty"`
+	Created       string   `json:"created"`
+	NotValidAfter string   `json:"not_valid_after,omitempty"` // RFC3339, empty when not known
+	Uploaded      []string `json:"uploaded,omitempty"`        // exchange resources the public key was stored with
+}
+
+type KeystoreEntryOutput struct {
+	KeystoreEntry
+	Expired bool `json:"expired"`
+}
+
+func (e *KeystoreEntry) Expired() bool {
+	if e.NotValidAfter == "" {
+		return false
+	} else if notAfter, err := time.Parse(time.RFC3339, e.NotValidAfter); err != nil {
+		return false
+	} else {
+		return time.Now().After(notAfter)
+	}
+}
+
+type KeystoreEntriesByName []KeystoreEntry
+
+func (s KeystoreEntriesByName) Len() int {
+	return len(s)
+}
+
+func (s KeystoreEntriesByName) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s KeystoreEntriesByName) Less(i, j int) bool {
+	return s[i].Name < s[j].Name
+}
+
+// Returns the keystore directory, HZN_KEYSTORE if set or ~/.hzn/keys otherwise.
+func GetKeystoreDir() string {
+	if dir := os.Getenv(KEYSTORE_ENVVAR); dir != "" {
+		return dir
+	}
+	home := os.Getenv("HOME")
+	if home == "" {
+		cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, "unable to find the home directory for the keystore, set HOME or %v", KEYSTORE_ENVVAR)
+	}
+	return filepath.Join(home, DEFAULT_KEYSTORE_DIR)
+}
+
+func keystoreEntryDir(name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return "", errors.New(fmt.Sprintf("invalid keystore key name '%v'", name))
+	}
+	return filepath.Join(GetKeystoreDir(), name), nil
+}
+
+// Returns the named keystore entry, or nil if there is no key pair with that name.
+func GetKeystoreEntry(name string) (*KeystoreEntry, error) {
+	dir, err := keystoreEntryDir(name)
+	if err != nil {
+		return nil, err
+	}
+
+	fileBytes, err := ioutil.ReadFile(filepath.Join(dir, KEYSTORE_ENTRY_FILE))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to read keystore key %v: %v", name, err))
+	}
+
+	entry := new(KeystoreEntry)
+	if err := json.Unmarshal(fileBytes, entry); err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to demarshal keystore key %v: %v", name, err))
+	}
+	return entry, nil
+}
+
+// Returns all the key pairs in the keystore, sorted by name.
+func GetKeystoreEntries() ([]KeystoreEntry, error) {
+	entries := make([]KeystoreEntry, 0)
+
+	files, err := ioutil.ReadDir(GetKeystoreDir())
+	if os.IsNotExist(err) {
+		return entries, nil
+	} else if err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to read keystore %v: %v", GetKeystoreDir(), err))
+	}
+
+	for _, f := range files {
+		if !f.IsDir() {
+			continue
+		} else if entry, err := GetKeystoreEntry(f.Name()); err != nil {
+			return nil, err
+		} else if entry != nil {
+			entries = append(entries, *entry)
+		}
+	}
+
+	sort.Sort(KeystoreEntriesByName(entries))
+	return entries, nil
+}
+
+func saveKeystoreEntry(entry *KeystoreEntry) error {
+	dir, err := keystoreEntryDir(entry.Name)
+	if err != nil {
+		return err
+	}
+
+	if jsonBytes, err := json.MarshalIndent(entry, "", cliutils.JSON_INDENT); err != nil {
+		return errors.New(fmt.Sprintf("failed to marshal keystore key %v: %v", entry.Name, err))
+	} else if err := ioutil.WriteFile(filepath.Join(dir, KEYSTORE_ENTRY_FILE), jsonBytes, 0600); err != nil {
+		return errors.New(fmt.Sprintf("unable to write keystore key %v: %v", entry.Name, err))
+	}
+	return nil
+}
+
+// Create the directory for a new keystore key pair. It is an error if the name is already used.
+func newKeystoreEntryDir(name string) string {
+	dir, err := keystoreEntryDir(name)
+	if err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "%v", err)
+	} else if entry, err := GetKeystoreEntry(name); err != nil {
+		cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, "%v", err)
+	} else if entry != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "keystore already has a key named '%v', remove it first with 'hzn key remove --keystore %v'", name, name)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		cliutils.Fatal(cliutils.FILE_IO_ERROR, "unable to create keystore directory %v: %v", dir, err)
+	}
+	return dir
+}
+
+// Copy a key file into the keystore directory of a key pair and return the path of the copy.
+func copyToKeystore(dir string, keyFile string, perm os.FileMode) string {
+	target := filepath.Join(dir, filepath.Base(keyFile))
+	if err := ioutil.WriteFile(target, cliutils.ReadFile(keyFile), perm); err != nil {
+		cliutils.Fatal(cliutils.FILE_IO_ERROR, "unable to copy %v into the keystore: %v", keyFile, err)
+	}
+	return target
+}
+
+// Import an existing key pair, or just a public key, into the keystore under the given name.
+func ImportToKeystore(name, pubKeyFile, privKeyFile string, uploads []string, org, userPw string) {
+	dir := newKeystoreEntryDir(name)
+
+	entry := &KeystoreEntry{
+		Name:      name,
+		PublicKey: copyToKeystore(dir, pubKeyFile, 0644),
+		Created:   time.Now().Format(time.RFC3339),
+	}
+	if privKeyFile != "" {
+		entry.PrivateKey = copyToKeystore(dir, privKeyFile, 0600)
+	}
+
+	if err := saveKeystoreEntry(entry); err != nil {
+		os.RemoveAll(dir)
+		cliutils.Fatal(cliutils.FILE_IO_ERROR, "%v", err)
+	}
+	fmt.Printf("Key '%v' imported into the keystore %v\n", name, GetKeystoreDir())
+
+	uploadKeystoreKey(entry, uploads, org, userPw)
+}
+
+// Remove a key pair from the keystore. Public keys that were stored with exchange resources are left there.
+func RemoveFromKeystore(name string) {
+	if entry, err := GetKeystoreEntry(name); err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "%v", err)
+	} else if entry == nil {
+		cliutils.Fatal(cliutils.NOT_FOUND, "keystore key '%v' not found", name)
+	} else if dir, err := keystoreEntryDir(name); err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "%v", err)
+	} else if err := os.RemoveAll(dir); err != nil {
+		cliutils.Fatal(cliutils.FILE_IO_ERROR, "unable to remove keystore key '%v': %v", name, err)
+	} else if len(entry.Uploaded) != 0 {
+		fmt.Printf("Key '%s' removed from the keystore. Its public key is still stored with: %v\n", name, strings.Join(entry.Uploaded, ", "))
+	} else {
+		fmt.Printf("Key '%s' removed from the keystore.\n", name)
+	}
+}
+
+func ListKeystore(keyName string) {
+	var output interface{}
+	if keyName != "" {
+		entry, err := GetKeystoreEntry(keyName)
+		if err != nil {
+			cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "%v", err)
+		} else if entry == nil {
+			cliutils.Fatal(cliutils.NOT_FOUND, "keystore key '%v' not found", keyName)
+		}
+		output = KeystoreEntryOutput{KeystoreEntry: *entry, Expired: entry.Expired()}
+	} else {
+		entries, err := GetKeystoreEntries()
+		if err != nil {
+			cliutils.Fatal(cliutils.FILE_IO_ERROR, "%v", err)
+		}
+		outputs := make([]KeystoreEntryOutput, 0, len(entries))
+		for _, entry := range entries {
+			outputs = append(outputs, KeystoreEntryOutput{KeystoreEntry: entry, Expired: entry.Expired()})
+		}
+		output = outputs
+	}
+
+	jsonBytes, err := json.MarshalIndent(output, "", cliutils.JSON_INDENT)
+	if err != nil {
+		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to marshal 'key list' output: %v", err)
+	}
+	fmt.Printf("%s\n", jsonBytes)
+}
+
+// Store the public key with each of the exchange resources, given as service:<service-id> or pattern:<pattern-name>,
+// and remember where it was stored.
+func uploadKeystoreKey(entry *KeystoreEntry, uploads []string, org, userPw string) {
+	for _, upload := range uploads {
+		resource := UploadPublicKey(entry.PublicKey, upload, org, userPw)
+		found := false
+		for _, u := range entry.Uploaded {
+			if u == resource {
+				found = true
+			}
+		}
+		if !found {
+			entry.Uploaded = append(entry.Uploaded, resource)
+		}
+	}
+
+	if len(uploads) != 0 {
+		if err := saveKeystoreEntry(entry); err != nil {
+			cliutils.Fatal(cliutils.FILE_IO_ERROR, "%v", err)
+		}
+	}
+}
+
+// Store a public key with an exchange resource, given as service:<service-id> or pattern:<pattern-name>. Returns
+// the exchange resource.
+func UploadPublicKey(pubKeyFile string, upload string, org, userPw string) string {
+	parts := strings.SplitN(upload, ":", 2)
+	if len(parts) != 2 || parts[1] == "" || (parts[0] != "service" && parts[0] != "pattern") {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "invalid upload target '%v', it must be service:<service-id> or pattern:<pattern-name>", upload)
+	}
+
+	resOrg, resId := cliutils.TrimOrg(org, parts[1])
+	resource := fmt.Sprintf("orgs/%v/%vs/%v", resOrg, parts[0], resId)
+	baseName := filepath.Base(pubKeyFile)
+
+	cliutils.SetWhetherUsingApiKey(userPw)
+	cliutils.ExchangePutPost(http.MethodPut, cliutils.GetExchangeUrl(), resource+"/keys/"+baseName, cliutils.OrgAndCreds(org, userPw), []int{201}, cliutils.ReadFile(pubKeyFile))
+	fmt.Printf("%v stored with exchange %v %v/%v\n", baseName, parts[0], resOrg, resId)
+	return resource
+}
+
+// The publish commands accept either key file paths or the name of a key pair in the keystore. When the private key
+// is a keystore name and no public key is given, the public key of the same key pair is used.
+func SigningKeyFiles(privKey string, pubKey string) (string, string) {
+	if privKey != "" && !isFile(privKey) {
+		entry := mustGetKeystoreEntry(privKey)
+		if entry.PrivateKey == "" {
+			cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "keystore key '%v' has no private key to sign with", privKey)
+		} else if entry.Expired() {
+			cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "keystore key '%v' expired on %v", privKey, entry.NotValidAfter)
+		}
+		privKey = entry.PrivateKey
+		if pubKey == "" {
+			pubKey = entry.PublicKey
+		}
+	}
+	return privKey, VerificationKeyFile(pubKey)
+}
+
+// The verify commands accept either a public key file path or the name of a key pair in the keystore.
+func VerificationKeyFile(pubKey string) string {
+	if pubKey != "" && !isFile(pubKey) {
+		return mustGetKeystoreEntry(pubKey).PublicKey
+	}
+	return pubKey
+}
+
+func mustGetKeystoreEntry(name string) *KeystoreEntry {
+	entry, err := GetKeystoreEntry(name)
+	if err != nil || entry == nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "'%v' is neither a key file nor the name of a key in the keystore %v", name, GetKeystoreDir())
+	}
+	return entry
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
@@ -0,0 +1,94 @@
+// +build unit
+
+package key
+
+import (
+	"github.com/open-horizon/anax/cli/cliutils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func setupKeystore(t *testing.T) string {
+	verbose := false
+	cliutils.Opts.Verbose = &verbose
+
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	os.Setenv(KEYSTORE_ENVVAR, filepath.Join(dir, "keys"))
+	return dir
+}
+
+func Test_ImportToKeystore(t *testing.T) {
+
+	dir := setupKeystore(t)
+	defer os.RemoveAll(dir)
+	defer os.Unsetenv(KEYSTORE_ENVVAR)
+
+	pubKeyFile := filepath.Join(dir, "mykey-public.pem")
+	privKeyFile := filepath.Join(dir, "mykey-private.key")
+	if err := ioutil.WriteFile(pubKeyFile, []byte("public"), 0644); err != nil {
+		t.Fatalf("unable to write key file: %v", err)
+	} else if err := ioutil.WriteFile(privKeyFile, []byte("private"), 0600); err != nil {
+		t.Fatalf("unable to write key file: %v", err)
+	}
+
+	// An empty keystore is not an error.
+	if entries, err := GetKeystoreEntries(); err != nil || len(entries) != 0 {
+		t.Errorf("keystore should be empty, has %v, error %v", entries, err)
+	}
+
+	ImportToKeystore("signer", pubKeyFile, privKeyFile, nil, "", "")
+	ImportToKeystore("verifier", pubKeyFile, "", nil, "", "")
+
+	entries, err := GetKeystoreEntries()
+	if err != nil {
+		t.Fatalf("unable to read keystore: %v", err)
+	} else if len(entries) != 2 || entries[0].Name != "signer" || entries[1].Name != "verifier" {
+		t.Fatalf("wrong keystore entries %v", entries)
+	} else if entries[1].PrivateKey != "" {
+		t.Errorf("public key only entry should have no private key, has %v", entries[1].PrivateKey)
+	}
+
+	// Key pair names are resolved to the files in the keystore, file paths are used as they are.
+	priv, pub := SigningKeyFiles("signer", "")
+	if priv != filepath.Join(GetKeystoreDir(), "signer", "mykey-private.key") || pub != filepath.Join(GetKeystoreDir(), "signer", "mykey-public.pem") {
+		t.Errorf("wrong signing key files %v %v", priv, pub)
+	}
+	priv, pub = SigningKeyFiles("signer", "verifier")
+	if pub != filepath.Join(GetKeystoreDir(), "verifier", "mykey-public.pem") {
+		t.Errorf("explicit public key should be used, was %v", pub)
+	}
+	priv, pub = SigningKeyFiles(privKeyFile, pubKeyFile)
+	if priv != privKeyFile || pub != pubKeyFile {
+		t.Errorf("key files should not change, were %v %v", priv, pub)
+	}
+	if pub := VerificationKeyFile("verifier"); pub != filepath.Join(GetKeystoreDir(), "verifier", "mykey-public.pem") {
+		t.Errorf("wrong verification key file %v", pub)
+	}
+	if _, err := GetKeystoreEntry("../signer"); err == nil {
+		t.Errorf("key names with a path separator should be rejected")
+	}
+}
+
+func Test_KeystoreEntry_Expired(t *testing.T) {
+
+	entry := KeystoreEntry{Name: "test"}
+	if entry.Expired() {
+		t.Errorf("key without an expiration should not expire")
+	}
+
+	entry.NotValidAfter = time.Now().Add(-time.Hour).Format(time.RFC3339)
+	if !entry.Expired() {
+		t.Errorf("key should be expired at %v", entry.NotValidAfter)
+	}
+
+	entry.NotValidAfter = time.Now().AddDate(1, 0, 0).Format(time.RFC3339)
+	if entry.Expired() {
+		t.Errorf("key should not be expired until %v", entry.NotValidAfter)
+	}
+}
@@ -1,8 +1,6 @@
 package metering
 
 import (
-	"encoding/json"
-	"fmt"
 	"github.com/open-horizon/anax/cli/cliutils"
 	"github.com/open-horizon/anax/persistence"
 )
@@ -110,20 +108,52 @@ func List(archivedMetering bool) {
 		for i := range apiAgreements {
 			metering[i].CopyAgreementInto(apiAgreements[i])
 		}
-		jsonBytes, err := json.MarshalIndent(metering, "", cliutils.JSON_INDENT)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to marshal 'hzn metering list' output: %v", err)
-		}
-		fmt.Printf("%s\n", jsonBytes)
+		cliutils.PrintOutput("hzn metering list", metering)
 	} else {
 		metering := make([]ArchivedMetering, len(apiAgreements))
 		for i := range apiAgreements {
 			metering[i].CopyAgreementInto(apiAgreements[i])
 		}
-		jsonBytes, err := json.MarshalIndent(metering, "", cliutils.JSON_INDENT)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to marshal 'hzn metering list' output: %v", err)
-		}
-		fmt.Printf("%s\n", jsonBytes)
+		cliutils.PrintOutput("hzn metering list --archived", metering)
+	}
+}
+
+type MeteringSummary struct {
+	WorkloadURL           string `json:"workload_url"`
+	Org                   string `json:"organization"`
+	ConsumerId            string `json:"consumer_id"`
+	TotalAmount           uint64 `json:"total_amount"`
+	TotalMissedTime       uint64 `json:"total_missed_time"`
+	NotificationCount     uint64 `json:"notification_count"`
+	FirstNotificationTime string `json:"first_notification_time"`
+	LastNotificationTime  string `json:"last_notification_time"`
+	Agreements            int    `json:"agreements"` // the number of agreements that sent metering notifications
+}
+
+// CopySummaryInto copies the metering summary info into our output struct
+func (m *MeteringSummary) CopySummaryInto(summary persistence.MeteringSummary) {
+	m.WorkloadURL = summary.WorkloadURL
+	m.Org = summary.Org
+	m.ConsumerId = summary.ConsumerId
+	m.TotalAmount = summary.TotalAmount
+	m.TotalMissedTime = summary.TotalMissedTime
+	m.NotificationCount = summary.NotificationCount
+	m.FirstNotificationTime = cliutils.ConvertTime(summary.FirstNotificationTime)
+	m.LastNotificationTime = cliutils.ConvertTime(summary.LastNotificationTime)
+	m.Agreements = len(summary.Agreements)
+}
+
+func Summary() {
+	apiOutput := make(map[string][]persistence.MeteringSummary, 0)
+	cliutils.HorizonGet("metering/summary", []int{200}, &apiOutput)
+	apiSummaries, ok := apiOutput["summaries"]
+	if !ok {
+		cliutils.Fatal(cliutils.HTTP_ERROR, "horizon api metering summary output did not include 'summaries' key")
+	}
+
+	summaries := make([]MeteringSummary, len(apiSummaries))
+	for i := range apiSummaries {
+		summaries[i].CopySummaryInto(apiSummaries[i])
 	}
+	cliutils.PrintOutput("hzn metering summary", summaries)
 }
@@ -1,11 +1,14 @@
 package node
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/open-horizon/anax/api"
 	"github.com/open-horizon/anax/apicommon"
 	"github.com/open-horizon/anax/cli/cliutils"
+	"github.com/open-horizon/anax/worker"
+	"os"
+	"sort"
+	"strconv"
 )
 
 type Configstate struct {
@@ -71,11 +74,7 @@ func List() {
 	nodeInfo.CopyStatusInto(&status)
 
 	// Output the combined info
-	jsonBytes, err := json.MarshalIndent(nodeInfo, "", cliutils.JSON_INDENT)
-	if err != nil {
-		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to marshal 'hzn node list' output: %v", err)
-	}
-	fmt.Printf("%s\n", jsonBytes) //todo: is there a way to output with json syntax highlighting like jq does?
+	cliutils.PrintOutput("hzn node list", nodeInfo)
 }
 
 func Version() {
@@ -83,3 +82,50 @@ func Version() {
 	cliutils.HorizonGet("status", []int{200}, &status)
 	fmt.Println(status.Configuration.HorizonVersion)
 }
+
+// One row per worker, followed by a row for each of its subworkers.
+func workerStatusTable(workers map[string]*worker.WorkerStatus) ([]string, [][]string) {
+	header := []string{"WORKER", "SUBWORKER", "STATUS", "LAST HEARTBEAT", "ERRORS", "LAST ERROR"}
+	rows := make([][]string, 0, len(workers))
+
+	names := make([]string, 0, len(workers))
+	for name := range workers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		ws := workers[name]
+		rows = append(rows, []string{name, "-", cliutils.WatchCell(ws.Status), cliutils.WatchTime(uint64(ws.LastHeartbeat)), strconv.Itoa(ws.ErrorCount), cliutils.WatchCell(ws.LastError)})
+
+		subnames := make([]string, 0, len(ws.SubworkerStatus))
+		for subname := range ws.SubworkerStatus {
+			subnames = append(subnames, subname)
+		}
+		sort.Strings(subnames)
+		for _, subname := range subnames {
+			rows = append(rows, []string{name, subname, cliutils.WatchCell(ws.SubworkerStatus[subname]), cliutils.WatchTime(uint64(ws.SubworkerHeartbeat[subname])), strconv.Itoa(ws.SubworkerErrorCount[subname]), "-"})
+		}
+	}
+	return header, rows
+}
+
+// Display the status of the workers of the node: whether each one is running, when it last did some work and how
+// often it failed.
+func Status(output string) {
+	outputFormat, err := cliutils.ParseOutputFormat(output)
+	if err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "%v", err)
+	}
+
+	status := apicommon.Info{}
+	cliutils.HorizonGet("status", []int{200}, &status)
+	if status.Workers == nil {
+		status.Workers = map[string]*worker.WorkerStatus{}
+	}
+
+	header, rows := workerStatusTable(status.Workers)
+	if err := outputFormat.Write(os.Stdout, status.Workers, header, rows); err != nil {
+		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to display 'hzn node status' output: %v", err)
+	}
+}
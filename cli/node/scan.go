@@ -0,0 +1,421 @@
+package node
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	dockerclient "github.com/fsouza/go-dockerclient"
+	"github.com/open-horizon/anax/apicommon"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/policy"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The node scan checks the machine before it is registered as a node. Most registration failures in the field are
+// caused by the environment, not by the registration input: docker is missing or too old, the exchange or the CSS
+// can't be reached, the clock is off, or there is not enough memory or disk for the workloads. The scan does not
+// need the agent to be running and does not change anything.
+
+const (
+	SCAN_OK      = "ok"
+	SCAN_WARNING = "warning"
+	SCAN_ERROR   = "error"
+	SCAN_SKIPPED = "skipped"
+)
+
+const (
+	SCAN_CHECK_DOCKER   = "docker"
+	SCAN_CHECK_EXCHANGE = "exchange"
+	SCAN_CHECK_CSS      = "css"
+	SCAN_CHECK_CLOCK    = "clock"
+	SCAN_CHECK_MEMORY   = "memory"
+	SCAN_CHECK_DISK     = "disk"
+	SCAN_CHECK_ARCH     = "arch"
+)
+
+// The oldest docker API version the agent is used with.
+const SCAN_MIN_DOCKER_API_VERSION = "1.24"
+
+// The clock skew with the exchange above which agreement and token times are off enough to matter.
+const SCAN_CLOCK_WARN_S = 30
+const SCAN_CLOCK_ERROR_S = 300
+
+// The memory of the smallest gateways that run the agent, and below which the low memory profile is recommended.
+const SCAN_MIN_MEMORY_MB = 256
+const SCAN_LOW_MEMORY_MB = 512
+
+// The free disk space needed for the agent's database and a few workload images.
+const SCAN_MIN_DISK_MB = 512
+const SCAN_WARN_DISK_MB = 2048
+
+// The directory of the agent's database, the docker images are where docker info says.
+const SCAN_AGENT_DATA_DIR = "/var/horizon"
+const SCAN_DOCKER_ROOT_DIR = "/var/lib/docker"
+
+const SCAN_TIMEOUT_S = 10
+
+type ScanFinding struct {
+	Check   string `json:"check"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Action  string `json:"action,omitempty"` // what to do before registering the node
+}
+
+func (f ScanFinding) String() string {
+	return fmt.Sprintf("Check: %v, Status: %v, Message: %v, Action: %v", f.Check, f.Status, f.Message, f.Action)
+}
+
+func NewScanFinding(check string, status string, message string, action string) ScanFinding {
+	return ScanFinding{
+		Check:   check,
+		Status:  status,
+		Message: message,
+		Action:  action,
+	}
+}
+
+// Scan the machine and display the findings as JSON. Exits with an error when a check found an error.
+func Scan(exchangeUrl string, cssUrl string, org string, userPw string, pattern string, problemsOnly bool) {
+
+	httpClient := &http.Client{Timeout: SCAN_TIMEOUT_S * time.Second}
+	findings := []ScanFinding{}
+
+	dockerFindings, dockerRootDir := scanDocker()
+	findings = append(findings, dockerFindings...)
+
+	exchangeFindings, exchangeTime, reachable := scanExchange(httpClient, exchangeUrl)
+	findings = append(findings, exchangeFindings...)
+	findings = append(findings, scanCSS(httpClient, cssUrl))
+	if exchangeTime.IsZero() {
+		findings = append(findings, NewScanFinding(SCAN_CHECK_CLOCK, SCAN_SKIPPED, "the clock is compared with the exchange, which could not be reached", ""))
+	} else {
+		findings = append(findings, clockSkewFinding(time.Now(), exchangeTime))
+	}
+
+	findings = append(findings, scanMemory())
+	findings = append(findings, scanDisk(dockerRootDir)...)
+
+	if !reachable {
+		findings = append(findings, NewScanFinding(SCAN_CHECK_ARCH, SCAN_SKIPPED, "the pattern is read from the exchange, which could not be reached", ""))
+	} else {
+		findings = append(findings, scanPattern(httpClient, strings.TrimSuffix(exchangeUrl, "/"), org, userPw, pattern))
+	}
+
+	output := []ScanFinding{}
+	failed := 0
+	for _, f := range findings {
+		if f.Status == SCAN_ERROR {
+			failed += 1
+		}
+		if !problemsOnly || (f.Status != SCAN_OK && f.Status != SCAN_SKIPPED) {
+			output = append(output, f)
+		}
+	}
+
+	fmt.Println(cliutils.MarshalIndent(output, "node scan"))
+
+	if failed != 0 {
+		cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, "%v of the node scan checks failed", failed)
+	}
+}
+
+// The exchange URL from the flag, HZN_EXCHANGE_URL, or the agent when it is running.
+func ScanExchangeUrl(exchangeUrl string) string {
+	if exchangeUrl != "" {
+		return exchangeUrl
+	} else if body, err := cliutils.HorizonGetBody("status"); err != nil {
+		cliutils.Verbose("unable to get the exchange URL from the agent: %v", err)
+		return ""
+	} else {
+		status := apicommon.Info{}
+		if err := json.Unmarshal(body, &status); err != nil || status.Configuration == nil {
+			return ""
+		}
+		return status.Configuration.ExchangeAPI
+	}
+}
+
+// Check that docker is running and recent enough. Returns the directory docker keeps its images in.
+func scanDocker() ([]ScanFinding, string) {
+	client, err := dockerclient.NewClient("unix:///var/run/docker.sock")
+	if err == nil {
+		err = client.Ping()
+	}
+	if err != nil {
+		return []ScanFinding{NewScanFinding(SCAN_CHECK_DOCKER, SCAN_ERROR, fmt.Sprintf("docker is not available: %v", err),
+			"Install docker and start the docker daemon, and run the scan as a user that can use /var/run/docker.sock.")}, SCAN_DOCKER_ROOT_DIR
+	}
+
+	rootDir := SCAN_DOCKER_ROOT_DIR
+	if info, err := client.Info(); err == nil && info.DockerRootDir != "" {
+		rootDir = info.DockerRootDir
+	}
+
+	env, err := client.Version()
+	if err != nil {
+		return []ScanFinding{NewScanFinding(SCAN_CHECK_DOCKER, SCAN_WARNING, fmt.Sprintf("unable to get the docker version: %v", err), "")}, rootDir
+	}
+	version, apiVersion := env.Get("Version"), env.Get("ApiVersion")
+	if older, err := versionOlder(apiVersion, SCAN_MIN_DOCKER_API_VERSION); err != nil {
+		return []ScanFinding{NewScanFinding(SCAN_CHECK_DOCKER, SCAN_WARNING, fmt.Sprintf("docker %v has an API version %v that can't be compared: %v", version, apiVersion, err), "")}, rootDir
+	} else if older {
+		return []ScanFinding{NewScanFinding(SCAN_CHECK_DOCKER, SCAN_ERROR, fmt.Sprintf("docker %v has API version %v, older than %v", version, apiVersion, SCAN_MIN_DOCKER_API_VERSION),
+			"Upgrade docker.")}, rootDir
+	}
+	return []ScanFinding{NewScanFinding(SCAN_CHECK_DOCKER, SCAN_OK, fmt.Sprintf("docker %v is running, API version %v", version, apiVersion), "")}, rootDir
+}
+
+// Returns true when the dotted version is older than the minimum.
+func versionOlder(version string, minimum string) (bool, error) {
+	parse := func(v string) ([]int, error) {
+		parts := strings.Split(v, ".")
+		nums := make([]int, len(parts))
+		for ix, p := range parts {
+			if n, err := strconv.Atoi(p); err != nil {
+				return nil, errors.New(fmt.Sprintf("%v is not a version", v))
+			} else {
+				nums[ix] = n
+			}
+		}
+		return nums, nil
+	}
+
+	v, err := parse(version)
+	if err != nil {
+		return false, err
+	}
+	m, err := parse(minimum)
+	if err != nil {
+		return false, err
+	}
+	for ix := 0; ix < len(v) || ix < len(m); ix++ {
+		a, b := 0, 0
+		if ix < len(v) {
+			a = v[ix]
+		}
+		if ix < len(m) {
+			b = m[ix]
+		}
+		if a != b {
+			return a < b, nil
+		}
+	}
+	return false, nil
+}
+
+// Check that the exchange can be reached. Returns the time the exchange sent with its reply, and whether the exchange
+// can be used by the rest of the scan.
+func scanExchange(httpClient *http.Client, exchangeUrl string) ([]ScanFinding, time.Time, bool) {
+	if exchangeUrl == "" {
+		return []ScanFinding{NewScanFinding(SCAN_CHECK_EXCHANGE, SCAN_ERROR, "the exchange URL is not known",
+			"Set HZN_EXCHANGE_URL or use the --exchange flag.")}, time.Time{}, false
+	}
+
+	url := strings.TrimSuffix(exchangeUrl, "/") + "/admin/version"
+	cliutils.Verbose(http.MethodGet + " " + url)
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return []ScanFinding{NewScanFinding(SCAN_CHECK_EXCHANGE, SCAN_ERROR, fmt.Sprintf("the exchange at %v can't be reached: %v", exchangeUrl, err),
+			"Check the exchange URL, the DNS, proxy and firewall settings of this machine, and that the CA of the exchange's certificate is trusted.")}, time.Time{}, false
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	exchangeTime, _ := http.ParseTime(resp.Header.Get("Date"))
+	if resp.StatusCode != http.StatusOK {
+		return []ScanFinding{NewScanFinding(SCAN_CHECK_EXCHANGE, SCAN_ERROR, fmt.Sprintf("the exchange at %v replied with HTTP code %v", exchangeUrl, resp.StatusCode),
+			"Check that the URL is the exchange API URL, ending with /v1 or similar.")}, exchangeTime, false
+	}
+	return []ScanFinding{NewScanFinding(SCAN_CHECK_EXCHANGE, SCAN_OK, fmt.Sprintf("the exchange at %v is reachable, version %v", exchangeUrl, strings.TrimSpace(string(body))), "")}, exchangeTime, true
+}
+
+// Check that the CSS (cloud sync service) can be reached, when the node will use it.
+func scanCSS(httpClient *http.Client, cssUrl string) ScanFinding {
+	if cssUrl == "" {
+		return NewScanFinding(SCAN_CHECK_CSS, SCAN_SKIPPED, "no CSS URL was given", "")
+	}
+
+	cliutils.Verbose(http.MethodGet + " " + cssUrl)
+	resp, err := httpClient.Get(cssUrl)
+	if err != nil {
+		return NewScanFinding(SCAN_CHECK_CSS, SCAN_ERROR, fmt.Sprintf("the CSS at %v can't be reached: %v", cssUrl, err),
+			"Check the CSS URL, the DNS, proxy and firewall settings of this machine, and that the CA of the CSS certificate is trusted.")
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return NewScanFinding(SCAN_CHECK_CSS, SCAN_WARNING, fmt.Sprintf("the CSS at %v replied with HTTP code %v", cssUrl, resp.StatusCode), "Check the health of the CSS.")
+	}
+	return NewScanFinding(SCAN_CHECK_CSS, SCAN_OK, fmt.Sprintf("the CSS at %v is reachable", cssUrl), "")
+}
+
+// Compare the local clock with the time of the exchange.
+func clockSkewFinding(local time.Time, exchangeTime time.Time) ScanFinding {
+	skew := local.Sub(exchangeTime)
+	if skew < 0 {
+		skew = -skew
+	}
+	seconds := int(skew / time.Second)
+	message := fmt.Sprintf("the clock is %v seconds off the exchange clock", seconds)
+	action := "Synchronize the clock, for example with NTP."
+	if seconds > SCAN_CLOCK_ERROR_S {
+		return NewScanFinding(SCAN_CHECK_CLOCK, SCAN_ERROR, message, action)
+	} else if seconds > SCAN_CLOCK_WARN_S {
+		return NewScanFinding(SCAN_CHECK_CLOCK, SCAN_WARNING, message, action)
+	}
+	return NewScanFinding(SCAN_CHECK_CLOCK, SCAN_OK, message, "")
+}
+
+func scanMemory() ScanFinding {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return NewScanFinding(SCAN_CHECK_MEMORY, SCAN_WARNING, fmt.Sprintf("unable to read the memory of this machine: %v", err), "")
+	}
+	defer f.Close()
+
+	if totalMB, err := memTotalMB(f); err != nil {
+		return NewScanFinding(SCAN_CHECK_MEMORY, SCAN_WARNING, fmt.Sprintf("unable to read the memory of this machine: %v", err), "")
+	} else {
+		return memoryFinding(totalMB)
+	}
+}
+
+// Returns the MB of memory in the output of /proc/meminfo.
+func memTotalMB(meminfo io.Reader) (uint64, error) {
+	scanner := bufio.NewScanner(meminfo)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			if kb, err := strconv.ParseUint(fields[1], 10, 64); err != nil {
+				return 0, errors.New(fmt.Sprintf("unable to parse MemTotal %v: %v", fields[1], err))
+			} else {
+				return kb / 1024, nil
+			}
+		}
+	}
+	return 0, errors.New(fmt.Sprintf("MemTotal not found"))
+}
+
+func memoryFinding(totalMB uint64) ScanFinding {
+	message := fmt.Sprintf("this machine has %vMB of memory", totalMB)
+	if totalMB < SCAN_MIN_MEMORY_MB {
+		return NewScanFinding(SCAN_CHECK_MEMORY, SCAN_ERROR, message, fmt.Sprintf("The agent, docker and the workloads need at least %vMB.", SCAN_MIN_MEMORY_MB))
+	} else if totalMB < SCAN_LOW_MEMORY_MB {
+		return NewScanFinding(SCAN_CHECK_MEMORY, SCAN_WARNING, message, "Set MemoryProfile to low in the Edge section of the anax config file.")
+	}
+	return NewScanFinding(SCAN_CHECK_MEMORY, SCAN_OK, message, "")
+}
+
+// Check the free space of the file systems of the agent's database and of the docker images.
+func scanDisk(dockerRootDir string) []ScanFinding {
+	findings := []ScanFinding{}
+	checked := make(map[string]bool)
+	for _, dir := range []string{SCAN_AGENT_DATA_DIR, dockerRootDir} {
+		// The directories might not exist before the agent is installed, their file system is the one of the closest
+		// parent that exists.
+		path := dir
+		for _, err := os.Stat(path); err != nil && path != "/"; _, err = os.Stat(path) {
+			path = filepath.Dir(path)
+		}
+		if checked[path] {
+			continue
+		}
+		checked[path] = true
+
+		if freeMB, err := cutil.FreeDiskMB(path); err != nil {
+			findings = append(findings, NewScanFinding(SCAN_CHECK_DISK, SCAN_WARNING, fmt.Sprintf("unable to get the free space of %v: %v", path, err), ""))
+		} else {
+			findings = append(findings, diskFinding(dir, freeMB))
+		}
+	}
+	return findings
+}
+
+func diskFinding(dir string, freeMB uint64) ScanFinding {
+	message := fmt.Sprintf("the file system of %v has %vMB free", dir, freeMB)
+	action := "Free up disk space, the workload images are pulled into the docker directory."
+	if freeMB < SCAN_MIN_DISK_MB {
+		return NewScanFinding(SCAN_CHECK_DISK, SCAN_ERROR, message, action)
+	} else if freeMB < SCAN_WARN_DISK_MB {
+		return NewScanFinding(SCAN_CHECK_DISK, SCAN_WARNING, message, action)
+	}
+	return NewScanFinding(SCAN_CHECK_DISK, SCAN_OK, message, "")
+}
+
+// Check that the pattern the node will be registered with has services for the arch of this machine.
+func scanPattern(httpClient *http.Client, exchangeUrl string, org string, userPw string, pattern string) ScanFinding {
+	if pattern == "" {
+		return NewScanFinding(SCAN_CHECK_ARCH, SCAN_SKIPPED, fmt.Sprintf("no pattern was given, this machine is %v", cutil.ArchString()), "")
+	}
+	patOrg, patName := cliutils.TrimOrg(org, pattern)
+	if patOrg == "" || userPw == "" {
+		return NewScanFinding(SCAN_CHECK_ARCH, SCAN_SKIPPED, fmt.Sprintf("pattern %v can't be read without an org and exchange credentials", pattern),
+			"Use the -o and -u flags, or set HZN_ORG_ID and HZN_EXCHANGE_USER_AUTH.")
+	}
+
+	url := exchangeUrl + "/orgs/" + patOrg + "/patterns/" + patName
+	cliutils.Verbose(http.MethodGet + " " + url)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return NewScanFinding(SCAN_CHECK_ARCH, SCAN_ERROR, fmt.Sprintf("unable to read pattern %v/%v: %v", patOrg, patName, err), "")
+	}
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Authorization", fmt.Sprintf("Basic %v", base64.StdEncoding.EncodeToString([]byte(cliutils.OrgAndCreds(patOrg, userPw)))))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return NewScanFinding(SCAN_CHECK_ARCH, SCAN_ERROR, fmt.Sprintf("unable to read pattern %v/%v: %v", patOrg, patName, err), "")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return NewScanFinding(SCAN_CHECK_ARCH, SCAN_ERROR, fmt.Sprintf("the exchange did not accept the credentials to read pattern %v/%v, HTTP code %v", patOrg, patName, resp.StatusCode),
+			"Check the -u credentials and the -o org.")
+	} else if resp.StatusCode == http.StatusNotFound {
+		return NewScanFinding(SCAN_CHECK_ARCH, SCAN_ERROR, fmt.Sprintf("pattern %v/%v does not exist", patOrg, patName), "Check the name of the pattern.")
+	} else if resp.StatusCode != http.StatusOK {
+		return NewScanFinding(SCAN_CHECK_ARCH, SCAN_ERROR, fmt.Sprintf("unable to read pattern %v/%v, HTTP code %v", patOrg, patName, resp.StatusCode), "")
+	}
+
+	var patterns exchange.GetPatternResponse
+	if err := json.NewDecoder(resp.Body).Decode(&patterns); err != nil {
+		return NewScanFinding(SCAN_CHECK_ARCH, SCAN_ERROR, fmt.Sprintf("unable to parse pattern %v/%v: %v", patOrg, patName, err), "")
+	}
+	for _, pat := range patterns.Patterns {
+		return archFinding(patOrg+"/"+patName, &pat, cutil.ArchString())
+	}
+	return NewScanFinding(SCAN_CHECK_ARCH, SCAN_ERROR, fmt.Sprintf("pattern %v/%v does not exist", patOrg, patName), "Check the name of the pattern.")
+}
+
+// Check that at least one of the services or workloads of the pattern runs on the arch.
+func archFinding(name string, pat *exchange.Pattern, arch string) ScanFinding {
+	archs := make(map[string]bool)
+	for _, s := range pat.Services {
+		archs[s.ServiceArch] = true
+	}
+	for _, w := range pat.Workloads {
+		archs[w.WorkloadArch] = true
+	}
+
+	if archs[arch] || archs[policy.ANY_ARCH] {
+		return NewScanFinding(SCAN_CHECK_ARCH, SCAN_OK, fmt.Sprintf("pattern %v has services for %v", name, arch), "")
+	}
+
+	supported := []string{}
+	for a := range archs {
+		supported = append(supported, a)
+	}
+	sort.Strings(supported)
+	return NewScanFinding(SCAN_CHECK_ARCH, SCAN_ERROR, fmt.Sprintf("pattern %v has no services for %v, only for %v", name, arch, strings.Join(supported, ", ")),
+		"Register the node with a pattern that has services for the arch of this machine.")
+}
@@ -0,0 +1,102 @@
+// +build unit
+
+package node
+
+import (
+	"github.com/open-horizon/anax/exchange"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_versionOlder(t *testing.T) {
+	tests := []struct {
+		version string
+		older   bool
+	}{
+		{"1.24", false},
+		{"1.40", false},
+		{"1.9", true},
+		{"1.23", true},
+		{"1.24.1", false},
+		{"2", false},
+		{"1", true},
+	}
+	for _, test := range tests {
+		if older, err := versionOlder(test.version, SCAN_MIN_DOCKER_API_VERSION); err != nil {
+			t.Errorf("unexpected error for %v: %v", test.version, err)
+		} else if older != test.older {
+			t.Errorf("version %v older than %v should be %v", test.version, SCAN_MIN_DOCKER_API_VERSION, test.older)
+		}
+	}
+
+	if _, err := versionOlder("1.x", SCAN_MIN_DOCKER_API_VERSION); err == nil {
+		t.Errorf("expected an error for version 1.x")
+	}
+}
+
+func Test_clockSkewFinding(t *testing.T) {
+	now := time.Now()
+	if f := clockSkewFinding(now, now.Add(-5*time.Second)); f.Status != SCAN_OK {
+		t.Errorf("5s of skew should be ok, got %v", f)
+	}
+	if f := clockSkewFinding(now, now.Add(time.Minute)); f.Status != SCAN_WARNING {
+		t.Errorf("a minute of skew should be a warning, got %v", f)
+	}
+	if f := clockSkewFinding(now, now.Add(-time.Hour)); f.Status != SCAN_ERROR {
+		t.Errorf("an hour of skew should be an error, got %v", f)
+	}
+}
+
+func Test_memTotalMB(t *testing.T) {
+	meminfo := "MemTotal:        1024000 kB\nMemFree:          512000 kB\n"
+	if mb, err := memTotalMB(strings.NewReader(meminfo)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if mb != 1000 {
+		t.Errorf("expected 1000MB, got %v", mb)
+	}
+
+	if _, err := memTotalMB(strings.NewReader("MemFree: 512000 kB\n")); err == nil {
+		t.Errorf("expected an error without MemTotal")
+	}
+
+	if f := memoryFinding(128); f.Status != SCAN_ERROR {
+		t.Errorf("128MB should be an error, got %v", f)
+	} else if f := memoryFinding(384); f.Status != SCAN_WARNING {
+		t.Errorf("384MB should be a warning, got %v", f)
+	} else if f := memoryFinding(4096); f.Status != SCAN_OK {
+		t.Errorf("4096MB should be ok, got %v", f)
+	}
+}
+
+func Test_diskFinding(t *testing.T) {
+	if f := diskFinding("/var/lib/docker", 100); f.Status != SCAN_ERROR {
+		t.Errorf("100MB should be an error, got %v", f)
+	} else if f := diskFinding("/var/lib/docker", 1024); f.Status != SCAN_WARNING {
+		t.Errorf("1024MB should be a warning, got %v", f)
+	} else if f := diskFinding("/var/lib/docker", 10240); f.Status != SCAN_OK {
+		t.Errorf("10240MB should be ok, got %v", f)
+	}
+}
+
+func Test_archFinding(t *testing.T) {
+	pat := &exchange.Pattern{
+		Services: []exchange.ServiceReference{
+			{ServiceArch: "amd64"},
+			{ServiceArch: "arm"},
+		},
+	}
+	if f := archFinding("myorg/mypattern", pat, "amd64"); f.Status != SCAN_OK {
+		t.Errorf("amd64 should be supported, got %v", f)
+	}
+	if f := archFinding("myorg/mypattern", pat, "arm64"); f.Status != SCAN_ERROR {
+		t.Errorf("arm64 should not be supported, got %v", f)
+	} else if !strings.Contains(f.Message, "amd64, arm") {
+		t.Errorf("the message should list the supported archs, got %v", f.Message)
+	}
+
+	pat.Services = append(pat.Services, exchange.ServiceReference{ServiceArch: "*"})
+	if f := archFinding("myorg/mypattern", pat, "arm64"); f.Status != SCAN_OK {
+		t.Errorf("any arch should be supported, got %v", f)
+	}
+}
@@ -36,25 +36,80 @@ func (m MicroWork) String() string {
 	return fmt.Sprintf("Org: %v, URL: %v, VersionRange: %v, Variables: %v", m.Org, m.Url, m.VersionRange, m.Variables)
 }
 
+// The node section of a declarative registration file
+type NodeDecl struct {
+	Id    string `json:"id,omitempty"`
+	Token string `json:"token,omitempty"`
+	Name  string `json:"name,omitempty"`
+}
+
+// The input file can also declare everything else needed to register the node, so that 'hzn register -f' alone
+// registers it. The org, pattern and node fields are used when they are not specified on the command line.
 type InputFile struct {
-	Global        []GlobalSet `json:"global,omitempty"`
-	Services      []MicroWork `json:"services,omitempty"`
-	Microservices []MicroWork `json:"microservices,omitempty"`
-	Workloads     []MicroWork `json:"workloads,omitempty"`
+	Org           string                 `json:"org,omitempty"`
+	Pattern       string                 `json:"pattern,omitempty"`
+	Node          *NodeDecl              `json:"node,omitempty"`
+	Properties    map[string]interface{} `json:"properties,omitempty"` // set as PropertyAttributes on the node
+	Global        []GlobalSet            `json:"global,omitempty"`
+	Services      []MicroWork            `json:"services,omitempty"`
+	Microservices []MicroWork            `json:"microservices,omitempty"`
+	Workloads     []MicroWork            `json:"workloads,omitempty"`
 }
 
+// ReadInputFile reads a json or yaml input file
 func ReadInputFile(filePath string, inputFileStruct *InputFile) {
 	newBytes := cliutils.ReadJsonFile(filePath)
-	err := json.Unmarshal(newBytes, inputFileStruct)
-	if err != nil {
-		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to unmarshal json input file %s: %v", filePath, err)
+	if cliutils.IsJSONDocument(newBytes) {
+		if err := json.Unmarshal(newBytes, inputFileStruct); err != nil {
+			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to unmarshal json input file %s: %v", filePath, err)
+		}
+	} else if err := cliutils.UnmarshalYAML(newBytes, inputFileStruct); err != nil {
+		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to unmarshal yaml input file %s: %v", filePath, err)
+	}
+}
+
+// Returns the value specified on the command line, or else the value declared in the input file. It is an error for
+// them to be different.
+func declaredValue(name, cmdLineValue, fileValue string) string {
+	if cmdLineValue == "" {
+		return fileValue
+	} else if fileValue != "" && fileValue != cmdLineValue {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "the %s '%s' specified on the command line is different from the %s '%s' in the input file", name, cmdLineValue, name, fileValue)
+	}
+	return cmdLineValue
+}
+
+// Returns true if the node is already registered the way it is declared. It is an error for the node to be
+// registered differently, or to be part way thru registration, because the anax api doesn't support changing
+// settings once in configuring state.
+func alreadyRegistered(org, pattern, nodeId string) bool {
+	horDevice := api.HorizonDevice{}
+	cliutils.HorizonGet("node", []int{200}, &horDevice)
+	if horDevice.Org == nil || *horDevice.Org == "" {
+		return false
+	}
+
+	state := ""
+	if horDevice.Config != nil && horDevice.Config.State != nil {
+		state = *horDevice.Config.State
+	}
+	regPattern, regId := "", ""
+	if horDevice.Pattern != nil {
+		regPattern = *horDevice.Pattern
+	}
+	if horDevice.Id != nil {
+		regId = *horDevice.Id
+	}
+	if *horDevice.Org != org || regPattern != pattern || regId != nodeId {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "this Horizon node is already registered as node '%s' in org '%s' with pattern '%s'. If you want to register it differently, run 'hzn unregister' first.", regId, *horDevice.Org, regPattern)
+	} else if state != "configured" {
+		cliutils.Fatal(cliutils.HTTP_ERROR, "this Horizon node is in the process of being registered (state '%s'). Run 'hzn unregister' to register it again.", state)
 	}
+	return true
 }
 
 // DoIt registers this node to Horizon with a pattern
 func DoIt(org, pattern, nodeIdTok, userPw, email, inputFile string) {
-	cliutils.SetWhetherUsingApiKey(nodeIdTok) // if we have to use userPw later in NodeCreate(), it will set this appropriately for userPw
-	org, pattern = cliutils.TrimOrg(org, pattern)
 	// Read input file 1st, so we don't get half way thru registration before finding the problem
 	inputFileStruct := InputFile{}
 	if inputFile != "" {
@@ -62,6 +117,25 @@ func DoIt(org, pattern, nodeIdTok, userPw, email, inputFile string) {
 		ReadInputFile(inputFile, &inputFileStruct)
 	}
 
+	// The org, pattern and node can be declared in the input file
+	org = declaredValue("organization", org, inputFileStruct.Org)
+	pattern = declaredValue("pattern", pattern, inputFileStruct.Pattern)
+	nodeName := ""
+	if inputFileStruct.Node != nil {
+		fileNodeIdTok := ""
+		if inputFileStruct.Node.Id != "" || inputFileStruct.Node.Token != "" {
+			fileNodeIdTok = inputFileStruct.Node.Id + ":" + inputFileStruct.Node.Token
+		}
+		nodeIdTok = declaredValue("node ID and token", nodeIdTok, fileNodeIdTok)
+		nodeName = inputFileStruct.Node.Name
+	}
+	if org == "" || pattern == "" {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "the organization and pattern must be specified either as arguments or in the input file")
+	}
+
+	cliutils.SetWhetherUsingApiKey(nodeIdTok) // if we have to use userPw later in NodeCreate(), it will set this appropriately for userPw
+	org, pattern = cliutils.TrimOrg(org, pattern)
+
 	// Get the exchange url from the anax api
 	exchUrlBase := cliutils.GetExchangeUrl()
 	fmt.Printf("Horizon Exchange base URL: %s\n", exchUrlBase)
@@ -85,6 +159,15 @@ func DoIt(org, pattern, nodeIdTok, userPw, email, inputFile string) {
 		fmt.Println("Generated random node token")
 	}
 	nodeIdTok = nodeId + ":" + nodeToken
+	if nodeName == "" {
+		nodeName = nodeId
+	}
+
+	// Running the registration again once it is done has no effect
+	if alreadyRegistered(org, pattern, nodeId) {
+		fmt.Printf("Horizon node is already registered as node %s/%s with pattern %s.\n", org, nodeId, pattern)
+		return
+	}
 
 	// See if the node exists in the exchange, and create if it doesn't
 	httpCode := cliutils.ExchangeGet(exchUrlBase, "orgs/"+org+"/nodes/"+nodeId, cliutils.OrgAndCreds(org, nodeIdTok), nil, nil)
@@ -102,7 +185,7 @@ func DoIt(org, pattern, nodeIdTok, userPw, email, inputFile string) {
 	fmt.Println("Initializing the Horizon node...")
 	//nd := Node{Id: nodeId, Token: nodeToken, Org: org, Pattern: pattern, Name: nodeId, HA: false}
 	falseVal := false
-	nd := api.HorizonDevice{Id: &nodeId, Token: &nodeToken, Org: &org, Pattern: &pattern, Name: &nodeId, HA: &falseVal} //todo: support HA config
+	nd := api.HorizonDevice{Id: &nodeId, Token: &nodeToken, Org: &org, Pattern: &pattern, Name: &nodeName, HA: &falseVal} //todo: support HA config
 	httpCode = cliutils.HorizonPutPost(http.MethodPost, "node", []int{201, 200, cliutils.ANAX_ALREADY_CONFIGURED}, nd)
 	if httpCode == cliutils.ANAX_ALREADY_CONFIGURED {
 		// Note: I wanted to make `hzn register` idempotent, but the anax api doesn't support changing existing settings once in configuring state (to maintain internal consistency).
@@ -132,6 +215,13 @@ func DoIt(org, pattern, nodeIdTok, userPw, email, inputFile string) {
 			cliutils.HorizonPutPost(http.MethodPost, "attribute", []int{201, 200}, attr)
 		}
 
+		// Set the node properties
+		if len(inputFileStruct.Properties) > 0 {
+			fmt.Println("Setting node properties...")
+			attr = api.NewAttribute("PropertyAttributes", []string{}, "Property", true, false, inputFileStruct.Properties)
+			cliutils.HorizonPutPost(http.MethodPost, "attribute", []int{201, 200}, attr)
+		}
+
 		// Set the service variables
 		attr = api.NewAttribute("UserInputAttributes", []string{}, "service", false, false, map[string]interface{}{}) // we reuse this for each service
 		emptyStr := ""
@@ -249,14 +339,14 @@ func CreateInputFile(org, pattern, arch, nodeIdTok, inputFile string) {
 	}
 	completeAPISpecList := new(policy.APISpecList) // list of all MSs the workloads require (will filter out MS refs with exact same version range, but not overlapping ranges (that comes later)
 	for _, work := range patOutput.Patterns[patKey].Workloads {
-		if work.WorkloadArch != arch { // filter out workloads that are not our arch
+		if work.WorkloadArch != arch && work.WorkloadArch != policy.ANY_ARCH { // filter out workloads that are not our arch
 			fmt.Printf("Ignoring workload that is a different architecture: %s, %s, %s\n", work.WorkloadOrg, work.WorkloadURL, work.WorkloadArch)
 			continue
 		}
 
 		for _, workVersion := range work.WorkloadVersions {
 			// Get the workload
-			exchId := cliutils.FormExchangeId(work.WorkloadURL, workVersion.Version, work.WorkloadArch)
+			exchId := cliutils.FormExchangeId(work.WorkloadURL, workVersion.Version, arch)
 			var workOutput exchange.GetWorkloadsResponse
 			cliutils.ExchangeGet(exchangeUrl, "orgs/"+work.WorkloadOrg+"/workloads/"+exchId, cliutils.OrgAndCreds(org, nodeIdTok), []int{200}, &workOutput)
 			workKey := cliutils.OrgAndCreds(work.WorkloadOrg, exchId)
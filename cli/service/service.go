@@ -2,12 +2,12 @@ package service
 
 import (
 	"encoding/json"
-	"fmt"
 	"github.com/open-horizon/anax/api"
 	"github.com/open-horizon/anax/cli/cliutils"
 	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/persistence"
 	"github.com/open-horizon/anax/policy"
+	"strings"
 )
 
 type APIServices struct {
@@ -63,11 +63,68 @@ func List() {
 	}
 
 	// Convert to json and output
-	jsonBytes, err := json.MarshalIndent(services, "", cliutils.JSON_INDENT)
-	if err != nil {
-		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to marshal 'hzn service list' output: %v", err)
+	cliutils.PrintOutput("hzn service list", services)
+}
+
+// The instances section of GET /service, both the agreement services and the services they depend on.
+type APIServiceInstances struct {
+	Instances map[string][]api.MicroserviceInstanceOutput `json:"instances"`
+}
+
+// The state of a running service instance.
+func instanceState(inst api.MicroserviceInstanceOutput) (string, uint64) {
+	switch {
+	case inst.CleanupStartTime != 0:
+		return "stopping", inst.CleanupStartTime
+	case inst.ExecutionFailureCode != 0:
+		return "failed: " + inst.ExecutionFailureDesc, inst.InstanceCreationTime
+	case inst.ExecutionStartTime != 0:
+		return "executing", inst.ExecutionStartTime
+	default:
+		return "starting", inst.InstanceCreationTime
+	}
+}
+
+// The containers of the instance with their docker state, like "gps running". Docker's status is not used because
+// it has the uptime in it, which would change the table at every poll.
+func instanceContainers(inst api.MicroserviceInstanceOutput) string {
+	if inst.Containers == nil || len(*inst.Containers) == 0 {
+		return "-"
+	}
+	containers := make([]string, 0, len(*inst.Containers))
+	for _, c := range *inst.Containers {
+		name := c.ID
+		if len(c.Names) != 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		containers = append(containers, name+" "+c.State)
+	}
+	return strings.Join(containers, ", ")
+}
+
+func serviceTable(instances []api.MicroserviceInstanceOutput) ([]string, [][]string) {
+	header := []string{"SERVICE", "VERSION", "INSTANCE", "STATE", "SINCE", "CONTAINERS"}
+	rows := make([][]string, 0, len(instances))
+	for _, inst := range instances {
+		state, since := instanceState(inst)
+		rows = append(rows, []string{inst.SpecRef, cliutils.WatchCell(inst.Version), inst.InstanceId, state, cliutils.WatchTime(since), instanceContainers(inst)})
 	}
-	fmt.Printf("%s\n", jsonBytes)
+	return header, rows
+}
+
+// Display a table of the running service instances and the state of their containers, that is updated as they
+// change.
+func Watch(intervalS int) {
+	header, _ := serviceTable(nil)
+	cliutils.Watch("hzn service list", intervalS, header, func() [][]string {
+		var apiOutput APIServiceInstances
+		httpCode := cliutils.HorizonGet("service", []int{200, cliutils.ANAX_NOT_CONFIGURED_YET}, &apiOutput)
+		if httpCode == cliutils.ANAX_NOT_CONFIGURED_YET {
+			cliutils.Fatal(cliutils.HTTP_ERROR, cliutils.MUST_REGISTER_FIRST)
+		}
+		_, rows := serviceTable(apiOutput.Instances["active"])
+		return rows
+	})
 }
 
 func Registered() {
@@ -79,9 +136,5 @@ func Registered() {
 	}
 
 	// Convert to json and output
-	jsonBytes, err := json.MarshalIndent(apiOutput, "", cliutils.JSON_INDENT)
-	if err != nil {
-		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to marshal 'hzn service registered' output: %v", err)
-	}
-	fmt.Printf("%s\n", jsonBytes)
+	cliutils.PrintOutput("hzn service registered", apiOutput)
 }
@@ -13,7 +13,7 @@ func getStatus(agbot bool) (apiOutput *worker.WorkerStatusManager) {
 
 	if agbot {
 		// set env to call agbot url
-		os.Setenv("HORIZON_URL", cliutils.AGBOT_HZN_API)
+		os.Setenv("HORIZON_URL", cliutils.GetAgbotUrlBase())
 	}
 
 	// Get horizon api worker status
@@ -0,0 +1,267 @@
+package support
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DEFAULT_CONFIG_FILE = "/etc/horizon/anax.json"
+const DEFAULT_LOG_UNIT = "horizon"
+const DEFAULT_LOG_LINES = "2000"
+
+const REDACTED = "********"
+
+// The options of a support bundle, they are the flags of 'hzn support bundle' and 'hzn agbot support bundle'.
+type BundleOptions struct {
+	OutputFile string // the archive to write, a name with the current time in the current directory when empty
+	ConfigFile string // the anax config file
+	LogFile    string // a file with the anax log, the log is read from the journal of LogUnit when empty
+	LogUnit    string // the systemd unit that anax runs as
+	LogLines   int    // the number of the most recent log lines to include
+}
+
+// One file of the bundle.
+type bundleFile struct {
+	name    string
+	content []byte
+}
+
+// Collects the files of a bundle. A file that can not be collected does not stop the bundle, the reason is recorded
+// in errors.txt instead, so that a bundle can be made while anax is down.
+type bundle struct {
+	files  []bundleFile
+	errors []string
+}
+
+func (b *bundle) add(name string, content []byte) {
+	b.files = append(b.files, bundleFile{name: name, content: content})
+}
+
+func (b *bundle) failed(name string, err error) {
+	cliutils.Verbose("unable to collect %v: %v", name, err)
+	b.errors = append(b.errors, fmt.Sprintf("%v: %v", name, err))
+}
+
+// Add the redacted output of an anax API to the bundle.
+func (b *bundle) addAPI(name string, urlSuffix string) {
+	if body, err := cliutils.HorizonGetBody(urlSuffix); err != nil {
+		b.failed(name, err)
+	} else if redacted, err := redactJSON(body); err != nil {
+		b.failed(name, err)
+	} else {
+		b.add(name, redacted)
+	}
+}
+
+// Add a summary of the agreement database, the agreements themselves are not included.
+func (b *bundle) addAgreementSummary(name string) {
+	var agreements map[string]map[string][]interface{}
+	if body, err := cliutils.HorizonGetBody("agreement"); err != nil {
+		b.failed(name, err)
+	} else if err := json.Unmarshal(body, &agreements); err != nil {
+		b.failed(name, errors.New(fmt.Sprintf("failed to unmarshal the agreements: %v", err)))
+	} else {
+		summary := map[string]int{
+			"active_agreements":   len(agreements["agreements"]["active"]),
+			"archived_agreements": len(agreements["agreements"]["archived"]),
+		}
+		b.add(name, []byte(cliutils.MarshalIndent(summary, "support bundle agreement summary")))
+	}
+}
+
+func (b *bundle) addConfig(name string, configFile string) {
+	if body, err := ioutil.ReadFile(configFile); err != nil {
+		b.failed(name, err)
+	} else if redacted, err := redactJSON(body); err != nil {
+		b.failed(name, errors.New(fmt.Sprintf("%v is not valid json, it is not included because it could not be redacted: %v", configFile, err)))
+	} else {
+		b.add(name, redacted)
+	}
+}
+
+func (b *bundle) addLog(name string, opts BundleOptions) {
+	var logBytes []byte
+	var err error
+	if opts.LogFile != "" {
+		logBytes, err = ioutil.ReadFile(opts.LogFile)
+	} else {
+		logBytes, err = exec.Command("journalctl", "-u", opts.LogUnit, "-n", strconv.Itoa(opts.LogLines), "--no-pager").Output()
+	}
+	if err != nil {
+		b.failed(name, err)
+		return
+	}
+
+	lines := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(logBytes))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, sanitizeLogLine(scanner.Text()))
+	}
+	if len(lines) > opts.LogLines {
+		lines = lines[len(lines)-opts.LogLines:]
+	}
+	b.add(name, []byte(strings.Join(lines, "\n")+"\n"))
+}
+
+// Write the bundle to a gzipped tar file. The files are in a directory named after the archive.
+func (b *bundle) write(archive string) error {
+	if len(b.errors) != 0 {
+		b.add("errors.txt", []byte(strings.Join(b.errors, "\n")+"\n"))
+	}
+
+	f, err := os.OpenFile(archive, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	dir := strings.TrimSuffix(filepath.Base(archive), ".tar.gz")
+	now := time.Now()
+	for _, file := range b.files {
+		hdr := &tar.Header{Name: dir + "/" + file.name, Mode: 0600, Size: int64(len(file.content)), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		} else if _, err := tw.Write(file.content); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Bundle collects what is needed to look into a problem with the Horizon agent or agbot into one archive: the status,
+// the worker status and status log, the recent exchange calls, an agreement summary, the config and the recent log.
+// The secrets in the config, the API output and the log are redacted.
+func Bundle(agbot bool, opts BundleOptions) {
+	if opts.LogLines <= 0 {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "the number of log lines must be greater than 0")
+	}
+
+	kind := "node"
+	if agbot {
+		kind = "agbot"
+		// set env to call agbot url
+		os.Setenv("HORIZON_URL", cliutils.GetAgbotUrlBase())
+	}
+
+	archive := opts.OutputFile
+	if archive == "" {
+		archive = fmt.Sprintf("horizon-%v-support-%v.tar.gz", kind, time.Now().Format("20060102-150405"))
+	} else if !strings.HasSuffix(archive, ".tar.gz") {
+		archive += ".tar.gz"
+	}
+
+	b := new(bundle)
+	b.addAPI("status.json", "status")
+	b.addAPI("workers.json", "status/workers")
+	b.addAPI("exchange.json", "status/exchange")
+	b.addAgreementSummary("agreements.json")
+	if agbot {
+		b.addAPI("diagnostic.json", "diagnostic")
+	} else {
+		b.addAPI("node.json", "node")
+	}
+	b.addConfig("config.json", opts.ConfigFile)
+	b.addLog("anax.log", opts)
+
+	if err := b.write(archive); err != nil {
+		cliutils.Fatal(cliutils.FILE_IO_ERROR, "failed to write support bundle %v: %v", archive, err)
+	}
+
+	msg := fmt.Sprintf("Support bundle written to %v.", archive)
+	if len(b.errors) != 0 {
+		msg += fmt.Sprintf(" %v of the items could not be collected, see errors.txt in the bundle.", len(b.errors))
+	}
+	fmt.Println(msg)
+}
+
+// The names of the attributes whose values are secrets. The anax config uses the go field names and the APIs use
+// json names, so the names are compared in lower case without the underscores.
+var secretAttributes = []string{"token", "password", "passwd", "secret", "apikey", "privatekey", "credentials"}
+
+func isSecretAttribute(name string) bool {
+	n := strings.Replace(strings.ToLower(name), "_", "", -1)
+	for _, s := range secretAttributes {
+		if strings.HasSuffix(n, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Replace the values of the secret attributes in a json document, at any depth.
+func redactJSON(body []byte) ([]byte, error) {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+	out, err := json.MarshalIndent(redactValue(doc), "", cliutils.JSON_INDENT)
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+func redactValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k := range t {
+			if isSecretAttribute(k) {
+				if s, ok := t[k].(string); !ok || s != "" {
+					t[k] = REDACTED
+				}
+			} else {
+				t[k] = redactValue(t[k])
+			}
+		}
+		return t
+	case []interface{}:
+		for i := range t {
+			t[i] = redactValue(t[i])
+		}
+		return t
+	default:
+		return v
+	}
+}
+
+type logSecret struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+var logSecrets = []logSecret{
+	// user:password@ in URLs, exchange user names have the org in them
+	{regexp.MustCompile(`://[^\s:@]+:[^/\s@]+@`), "://" + REDACTED + "@"},
+	// basic and bearer authorization headers
+	{regexp.MustCompile(`(?i)(\b(?:basic|bearer)\s+)[A-Za-z0-9+/=._-]{8,}`), "${1}" + REDACTED},
+	// token=..., "password": "...", Secret: ... and the like
+	{regexp.MustCompile(`(?i)(\b\w*(?:token|password|passwd|secret|apikey)"?\s*[:=]\s*"?)[^\s",&}]+`), "${1}" + REDACTED},
+}
+
+// Remove the credentials that can show up in an anax log line.
+func sanitizeLogLine(line string) string {
+	for _, s := range logSecrets {
+		line = s.re.ReplaceAllString(line, s.repl)
+	}
+	return line
+}
@@ -3,6 +3,7 @@ package unregister
 import (
 	"fmt"
 	"github.com/open-horizon/anax/cli/cliutils"
+	"net/http"
 )
 
 type ApiAttribute struct {
@@ -13,21 +14,36 @@ type ApiAttributes struct {
 	Attributes []ApiAttribute `json:"attributes"`
 }
 
-// DoIt unregisters this Horizon edge node and resets it so it can be registered again
-func DoIt(forceUnregister, removeNodeUnregister bool) {
+// DoIt unregisters this Horizon edge node and resets it so it can be registered again. When drain is set, the node
+// cancels its agreements because it is decommissioned and waits up to drainTimeout seconds for its workloads to stop
+// and its agreements to be removed from the exchange before it removes its local state.
+func DoIt(forceUnregister, removeNodeUnregister, drain bool, drainTimeout int) {
 	if !forceUnregister {
 		cliutils.ConfirmRemove("Are you sure you want to unregister this Horizon node?")
 	}
 
-	fmt.Println("Unregistering this node, cancelling all agreements, stopping all workloads, and restarting Horizon...")
-
 	removeNodeOption := ""
 	if removeNodeUnregister {
 		removeNodeOption = "&removeNode=true"
 	}
 
-	cliutils.HorizonDelete("node?block=true"+removeNodeOption, []int{200, 204})
-	fmt.Println("Horizon node unregistered. You may now run 'hzn register ...' again, if desired.")
+	if !drain {
+		fmt.Println("Unregistering this node, cancelling all agreements, stopping all workloads, and restarting Horizon...")
+		cliutils.HorizonDelete("node?block=true"+removeNodeOption, []int{200, 204})
+		fmt.Println("Horizon node unregistered. You may now run 'hzn register ...' again, if desired.")
+		return
+	}
+
+	if drainTimeout <= 0 {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "the drain timeout must be a positive number of seconds")
+	}
+
+	fmt.Printf("Draining this node, cancelling all agreements, waiting up to %v seconds for all workloads to stop, and restarting Horizon...\n", drainTimeout)
+	httpCode := cliutils.HorizonDelete(fmt.Sprintf("node?block=true&drain=true&drainTimeout=%v", drainTimeout)+removeNodeOption, []int{200, 204, http.StatusGatewayTimeout})
+	if httpCode == http.StatusGatewayTimeout {
+		cliutils.Fatal(cliutils.DRAIN_TIMEOUT, "the node did not drain within %v seconds, its local state was not removed. Run 'hzn unregister' again once Horizon has restarted.", drainTimeout)
+	}
+	fmt.Println("Horizon node drained and unregistered. You may now run 'hzn register ...' again, if desired.")
 
 	/* This does the same thing more manually. Want to keep for reference...
 	fmt.Println("Stopping horizon...")
@@ -0,0 +1,54 @@
+package utilcmds
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/open-horizon/anax/apicommon"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+)
+
+// CertFetch retrieves the CA cert of the self-signed cert that the Horizon agent (or agbot) API is served with. The
+// cert is fetched without verifying it, so the fingerprint is shown, to be compared with the one in the agent log.
+func CertFetch(agbot bool, outFile string) {
+	urlBase := cliutils.GetHorizonUrlBase()
+	if agbot {
+		urlBase = cliutils.GetAgbotUrlBase()
+	}
+
+	apiUrl, err := url.Parse(urlBase)
+	if err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "unable to parse API URL %s: %v", urlBase, err)
+	} else if apiUrl.Scheme != "https" {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "the API URL %s does not use https, set HORIZON_URL (or HZN_AGBOT_URL with --agbot) to the https URL of the API", urlBase)
+	}
+
+	host := apiUrl.Host
+	if apiUrl.Port() == "" {
+		host = net.JoinHostPort(apiUrl.Hostname(), "443")
+	}
+
+	cliutils.Verbose("fetching the API CA cert from %s", host)
+	conn, err := tls.Dial("tcp", host, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		cliutils.Fatal(cliutils.HTTP_ERROR, "unable to connect to %s: %v", host, err)
+	}
+	defer conn.Close()
+
+	for _, cert := range conn.ConnectionState().PeerCertificates {
+		if !cert.IsCA {
+			continue
+		}
+		if outFile == "" {
+			fmt.Print(string(apicommon.CertPEM(cert)))
+		} else if err := ioutil.WriteFile(outFile, apicommon.CertPEM(cert), 0644); err != nil {
+			cliutils.Fatal(cliutils.FILE_IO_ERROR, "unable to write %s: %v", outFile, err)
+		}
+		fmt.Fprintf(os.Stderr, "SHA-256 fingerprint: %s\nCompare it with the fingerprint in the log of the API before trusting the cert, for example with HZN_API_CA_CERT.\n", apicommon.CertFingerprint(cert))
+		return
+	}
+	cliutils.Fatal(cliutils.NOT_FOUND, "the API at %s did not present a CA cert", host)
+}
@@ -0,0 +1,104 @@
+package utilcmds
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"github.com/open-horizon/anax/cli/exchange"
+	"github.com/open-horizon/rsapss-tool/sign"
+	"github.com/open-horizon/rsapss-tool/verify"
+	"os"
+	"strings"
+)
+
+// The deployment string and its signature, with the names of the fields of the workload, microservice and service
+// input files of 'hzn exchange ... publish', which publishes them as they are when both are set.
+type SignedDeployment struct {
+	Deployment          string `json:"deployment"`
+	DeploymentSignature string `json:"deploymentSignature"`
+}
+
+// Returns the deployment string of a deployment config, the way 'hzn exchange service publish' creates it before
+// signing it: compact json with the keys sorted. The input is the deployment config as a json object, or the escaped
+// json string of the deployment field of a resource in the exchange.
+func CanonicalDeployment(input []byte) (string, error) {
+	var deployment interface{}
+	if err := json.Unmarshal(input, &deployment); err != nil {
+		return "", errors.New(fmt.Sprintf("the deployment config is not valid json: %v", err))
+	}
+
+	if depString, ok := deployment.(string); ok {
+		if err := json.Unmarshal([]byte(depString), &deployment); err != nil {
+			return "", errors.New(fmt.Sprintf("the deployment string is not valid json: %v", err))
+		}
+	}
+
+	if _, ok := deployment.(map[string]interface{}); !ok {
+		return "", errors.New(fmt.Sprintf("the deployment config must be a json object"))
+	}
+
+	canonical, err := json.Marshal(deployment)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("unable to marshal the deployment config: %v", err))
+	}
+	return string(canonical), nil
+}
+
+// Read the deployment config from stdin and return its deployment string. When strict, the deployment config must
+// also pass the checks of 'hzn exchange ... publish'.
+func readDeployment(strict bool) string {
+	deployment, err := CanonicalDeployment(cliutils.ReadStdin())
+	if err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "%v", err)
+	}
+	if strict {
+		if problems := exchange.ValidateDeployment("deployment", deployment); len(problems) != 0 {
+			cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "the deployment config is not valid:\n  %v\nSee https://github.com/open-horizon/anax/blob/master/doc/deployment_string.md, or use --no-strict to skip the validation.", strings.Join(problems, "\n  "))
+		}
+	}
+	return deployment
+}
+
+func DeploymentCanonicalize(strict bool) {
+	fmt.Println(readDeployment(strict))
+}
+
+// Sign the deployment config in stdin. The deployment string and its signature are sent to stdout as json.
+func SignDeployment(privKeyFilePath string, strict bool) {
+	deployment := readDeployment(strict)
+	signature, err := sign.Input(privKeyFilePath, []byte(deployment))
+	if err != nil {
+		cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, "problem signing the deployment string with %s: %v", privKeyFilePath, err)
+	}
+
+	jsonBytes, err := json.MarshalIndent(SignedDeployment{Deployment: deployment, DeploymentSignature: signature}, "", cliutils.JSON_INDENT)
+	if err != nil {
+		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to marshal the signed deployment: %v", err)
+	}
+	fmt.Println(string(jsonBytes))
+}
+
+// Verify the signature of the deployment config in stdin. A deployment config that is a json object is verified as
+// the deployment string 'hzn util sign --deployment' creates from it, a json string is verified as it is, because
+// the deployment strings of workloads and microservices are not always in the canonical form.
+func VerifyDeployment(pubKeyFilePath, signature string) {
+	input := cliutils.ReadStdin()
+
+	var depString string
+	if err := json.Unmarshal(input, &depString); err != nil {
+		if depString, err = CanonicalDeployment(input); err != nil {
+			cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "%v", err)
+		}
+	}
+
+	verified, err := verify.Input(pubKeyFilePath, signature, []byte(depString))
+	if err != nil {
+		cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, "problem verifying deployment string with %s: %v", pubKeyFilePath, err)
+	} else if !verified {
+		fmt.Println("This is not a valid signature for the deployment string.")
+		os.Exit(cliutils.SIGNATURE_INVALID)
+	} else {
+		fmt.Println("Signature is valid.")
+	}
+}
@@ -0,0 +1,33 @@
+// +build unit
+
+package utilcmds
+
+import (
+	"testing"
+)
+
+func Test_CanonicalDeployment(t *testing.T) {
+
+	expected := `{"services":{"gps":{"environment":["FOO=bar"],"image":"summit.hovitos.engineering/x86/gps:2.0.3","privileged":true}}}`
+
+	inputs := []string{
+		`{"services": {"gps": {"privileged": true, "image": "summit.hovitos.engineering/x86/gps:2.0.3", "environment": ["FOO=bar"]}}}`,
+		"{\n  \"services\": {\n    \"gps\": {\n      \"image\": \"summit.hovitos.engineering/x86/gps:2.0.3\",\n      \"environment\": [\"FOO=bar\"],\n      \"privileged\": true\n    }\n  }\n}\n",
+		`"{\"services\":{\"gps\":{\"privileged\":true,\"environment\":[\"FOO=bar\"],\"image\":\"summit.hovitos.engineering/x86/gps:2.0.3\"}}}"`,
+		expected,
+	}
+
+	for _, input := range inputs {
+		if canonical, err := CanonicalDeployment([]byte(input)); err != nil {
+			t.Errorf("unable to canonicalize %v: %v", input, err)
+		} else if canonical != expected {
+			t.Errorf("expected deployment string %v, got %v", expected, canonical)
+		}
+	}
+
+	for _, input := range []string{``, `{"services":`, `["services"]`, `"not json"`, `42`} {
+		if canonical, err := CanonicalDeployment([]byte(input)); err == nil {
+			t.Errorf("%v should not be a valid deployment config, got %v", input, canonical)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package utilcmds
+
+import (
+	"fmt"
+	"github.com/open-horizon/anax/cli/agreement"
+	"github.com/open-horizon/anax/cli/agreementbot"
+	"github.com/open-horizon/anax/cli/attribute"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"github.com/open-horizon/anax/cli/metering"
+	"github.com/open-horizon/anax/cli/node"
+	"github.com/open-horizon/anax/cli/service"
+	"github.com/open-horizon/anax/cli/workload"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/policy"
+	"os"
+)
+
+// The list commands whose json output is versioned, see cliutils.OUTPUT_VERSION. The command names are the ones the
+// commands pass to cliutils.PrintOutput.
+type versionedCommand struct {
+	command     string
+	description string
+	output      interface{} // a value of the type that the output is marshaled from
+}
+
+var versionedCommands = []versionedCommand{
+	{"hzn node list", "The configuration and status of the node.", node.NodeAndStatus{}},
+	{"hzn agreement list", "The active agreements of the node.", []agreement.ActiveAgreement{}},
+	{"hzn agreement list --archived", "The archived agreements of the node.", []agreement.ArchivedAgreement{}},
+	{"hzn agreement list AGREEMENT", "All the details of a single active or archived agreement of the node.", persistence.EstablishedAgreement{}},
+	{"hzn agreement reasons", "The reason codes an agreement can be terminated with, for each agreement protocol.", []agreement.ProtocolReasons{}},
+	{"hzn metering list", "The metering notifications of the active agreements of the node.", []metering.ActiveMetering{}},
+	{"hzn metering list --archived", "The metering notifications of the archived agreements of the node.", []metering.ArchivedMetering{}},
+	{"hzn metering summary", "The metering notifications of the node, summarized by workload and agbot.", []metering.MeteringSummary{}},
+	{"hzn attribute list", "The global attributes of the node.", []attribute.OurAttributes{}},
+	{"hzn service list", "The services configured on the node.", []service.OurService{}},
+	{"hzn service registered", "The policies of the services registered by the node.", map[string]policy.Policy{}},
+	{"hzn workload list", "The workloads configured on the node.", []workload.OurWorkload{}},
+	{"hzn agbot agreement list", "The active agreements of the agbot.", []agreementbot.ActiveAgreement{}},
+	{"hzn agbot agreement list --archived", "The archived agreements of the agbot.", []agreementbot.ArchivedAgreement{}},
+}
+
+// The json schema dialect of the generated schemas.
+const JSON_SCHEMA_DRAFT = "http://json-schema.org/draft-07/schema#"
+
+type outputSchema struct {
+	Description string                 `json:"description"`
+	Schema      map[string]interface{} `json:"schema"`
+}
+
+type outputSchemas struct {
+	OutputVersion int                     `json:"outputVersion"`
+	Commands      map[string]outputSchema `json:"commands"`
+	Changes       []cliutils.OutputChange `json:"changes"`
+}
+
+// OutputSchema displays the json schema of the current output version of the list commands, and the changes made
+// to the output in each version. When a command is given, only the schema of its output is displayed.
+func OutputSchema(command string) {
+	schemas := outputSchemas{
+		OutputVersion: cliutils.OUTPUT_VERSION,
+		Commands:      make(map[string]outputSchema),
+		Changes:       cliutils.OutputChanges,
+	}
+	for _, vc := range versionedCommands {
+		if command == "" || command == vc.command {
+			schema := cliutils.JSONSchema(vc.output)
+			schema["$schema"] = JSON_SCHEMA_DRAFT
+			schemas.Commands[vc.command] = outputSchema{
+				Description: vc.description,
+				Schema:      schema,
+			}
+		}
+	}
+
+	if len(schemas.Commands) == 0 {
+		fmt.Fprintf(os.Stderr, "The json output of these commands is versioned:\n")
+		for _, vc := range versionedCommands {
+			fmt.Fprintf(os.Stderr, "  %v\n", vc.command)
+		}
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "the output of '%v' is not versioned", command)
+	}
+	fmt.Println(cliutils.MarshalIndent(schemas, "util output-schema"))
+}
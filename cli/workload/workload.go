@@ -1,8 +1,6 @@
 package workload
 
 import (
-	"encoding/json"
-	"fmt"
 	dockerclient "github.com/fsouza/go-dockerclient"
 	"github.com/open-horizon/anax/cli/cliutils"
 )
@@ -57,9 +55,5 @@ func List() {
 	//todo: should we mix in any other info from /workload?
 
 	// Convert to json and output
-	jsonBytes, err := json.MarshalIndent(workloads, "", cliutils.JSON_INDENT)
-	if err != nil {
-		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, "failed to marshal 'hzn workload list' output: %v", err)
-	}
-	fmt.Printf("%s\n", jsonBytes)
+	cliutils.PrintOutput("hzn workload list", workloads)
 }
@@ -102,6 +102,12 @@ func newHTTPClientFactory(hConfig HorizonConfig) (*HTTPClientFactory, error) {
 
 	tlsConf.BuildNameToCertificate()
 
+	// Every idle connection holds on to its read and write buffers.
+	maxIdleConns := MaxHTTPIdleConnections
+	if hConfig.Edge.LowMemory() {
+		maxIdleConns = LOW_MEMORY_MAX_HTTP_IDLE_CONNECTIONS
+	}
+
 	clientFunc := func(overrideTimeoutS *uint) *http.Client {
 		var timeoutS uint
 
@@ -124,7 +130,7 @@ func newHTTPClientFactory(hConfig HorizonConfig) (*HTTPClientFactory, error) {
 				TLSHandshakeTimeout:   20 * time.Second,
 				ResponseHeaderTimeout: 20 * time.Second,
 				ExpectContinueTimeout: 8 * time.Second,
-				MaxIdleConns:          MaxHTTPIdleConnections,
+				MaxIdleConns:          maxIdleConns,
 				IdleConnTimeout:       HTTPIdleConnectionTimeoutS * time.Second,
 				TLSClientConfig:       &tlsConf,
 			},
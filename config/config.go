@@ -3,9 +3,12 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"strings"
 )
 
 const ExchangeURLEnvvarName = "HZN_EXCHANGE_URL"
@@ -22,6 +25,7 @@ type Config struct {
 	ServiceStorage                string // The base storage directory where the service can write or get the data.
 	TorrentDir                    string
 	APIListen                     string
+	APITLS                        APITLSConfig // Serve the API over https, see APITLSConfig.
 	DBPath                        string
 	DockerEndpoint                string
 	DockerCredFilePath            string
@@ -33,13 +37,20 @@ type Config struct {
 	CACertsPath                   string // Path to a file containing PEM-encoded x509 certs HTTP clients in Anax will trust (additive to the configuration option "TrustSystemCACerts")
 	ExchangeURL                   string
 	DefaultHTTPClientTimeoutS     uint
+	ExchangeMaxRetries            int // The number of times a transient exchange error is retried, by the node and the agbot. The default is 3, a negative value turns retries off.
+	ExchangeRetryIntervalMS       int // The number of milliseconds to wait before the first retry of an exchange call, doubled for every further retry. The default is 1000.
+	ExchangeOutageThresholdS      int // The number of seconds the exchange can be unreachable before the node enters degraded mode. The default is 300.
 	PolicyPath                    string
 	ExchangeHeartbeat             int    // Seconds between heartbeats
+	ExchangeHeartbeatMaxS         int    // When greater than ExchangeHeartbeat, the seconds between heartbeats double after every successful heartbeat up to this value, and go back to ExchangeHeartbeat after a failed one so that the exchange sees the node again quickly. Keep it well below the missing heartbeat interval of the node's patterns. Zero (the default) heartbeats every ExchangeHeartbeat seconds.
 	ExchangeVersionCheckIntervalM int64  // Exchange version check interval in minutes. The default is 720.
 	AgreementTimeoutS             uint64 // Number of seconds to wait before declaring agreement not finalized in blockchain
 	DVPrefix                      string // When passing agreement ids into a workload container, add this prefix to the agreement id
 	RegistrationDelayS            uint64 // The number of seconds to wait after blockchain init before registering with the exchange. This is for testing initialization ONLY.
 	ExchangeMessageTTL            int    // The number of seconds the exchange will keep this message before automatically deleting it
+	ExchangeMessageWaitS          int    // The number of seconds the exchange is asked to hold a fetch of the node's messages open until a message arrives. Zero (the default) turns long polling off.
+	ExchangeMessagePush           bool   // Open the push channel of the node's mailbox, so that messages are fetched as soon as the exchange notifies the node. Falls back to (long) polling when the exchange does not support it.
+	MessageRelayURL               string // The URL of a relay that carries the node's protocol messages instead of the exchange mailbox, for deployments where the exchange mailbox is disabled. The agbots must use the same relay. Empty (the default) uses the exchange mailbox.
 	TorrentListenAddr             string // Override the torrent listen address just in case there are conflicts, syntax is "host:port"
 	UserPublicKeyPath             string // The location to store user keys uploaded through the REST API
 	ReportDeviceStatus            bool   // whether to report the device status to the exchange or not.
@@ -47,6 +58,28 @@ type Config struct {
 	TrustDockerAuthFromOrg        bool   // whether to turst the docker auths provided by the organization on the exchange or not.
 	ServiceUpgradeCheckIntervalS  int64  // service upgrade check interval in seconds. The default is 300 seconds.
 	MultipleAnaxInstances         bool   // multiple anax instances running on the same machine
+	LogFormat                     string // The format of the log lines of the node and the agbot: text (the default), keyvalue or json.
+	ResourceUsageReportIntervalS  int    // Seconds between reports of the resources used by the workload containers of an agreement. The default is 300, a negative value turns reporting off.
+	MemoryProfile                 string // The memory profile of the node: standard (the default) or low, see applyMemoryProfile.
+	NodeAttestation               bool   // Sign the node's arch, memory and GPU presence with the node's messaging key and publish them, for business policies that require attestation.
+
+	// Local scripts or HTTP endpoints that are told when workloads start, stop and fail.
+	WorkloadCallbacks []WorkloadCallbackConfig
+
+	// Minimum free disk space for the data that anax manages, see DiskGuardConfig.
+	DiskGuard DiskGuardConfig
+
+	// Limits for the docker image pulls of the agreements that start at the same time, see ImagePullConfig.
+	ImagePulls ImagePullConfig
+
+	// Opt in to sending anonymous statistics about the node to a collector, see StatisticsConfig.
+	Statistics StatisticsConfig
+
+	// Roll back a workload upgrade whose containers keep failing, see WorkloadRollbackConfig.
+	WorkloadRollback WorkloadRollbackConfig
+
+	// Where the values of the secrets that services reference are read from, see SecretsConfig.
+	Secrets SecretsConfig
 
 	// these Ids could be provided in config or discovered after startup by the system
 	BlockchainAccountId        string
@@ -58,29 +91,378 @@ type AGConfig struct {
 	TxLostDelayTolerationSeconds  int
 	AgreementWorkers              int
 	DBPath                        string
-	ProtocolTimeoutS              uint64 // Number of seconds to wait before declaring proposal response is lost
-	AgreementTimeoutS             uint64 // Number of seconds to wait before declaring agreement not finalized in blockchain
-	NoDataIntervalS               uint64 // default should be 15 mins == 15*60 == 900. Ignored if the policy has data verification disabled.
-	ActiveAgreementsURL           string // This field is used when policy files indicate they want data verification but they dont specify a URL
-	ActiveAgreementsUser          string // This is the userid the agbot uses to authenticate to the data verifivcation API
-	ActiveAgreementsPW            string // This is the password for the ActiveAgreementsUser
-	PolicyPath                    string // The directory where policy files are kept, default /etc/provider-tremor/policy/
-	NewContractIntervalS          uint64 // default should be 1
-	ProcessGovernanceIntervalS    uint64 // How long the gov sleeps before general gov checks (new payloads, interval payments, etc).
-	IgnoreContractWithAttribs     string // A comma seperated list of contract attributes. If set, the contracts that contain one or more of the attributes will be ignored. The default is "ethereum_account".
-	ExchangeURL                   string // The URL of the Horizon exchange. If not configured, the exchange will not be used.
-	ExchangeHeartbeat             int    // Seconds between heartbeats to the exchange
-	ExchangeVersionCheckIntervalM int64  // Exchange version check interval in minutes. The default is 5. 0 means no periodic checking.
-	ExchangeId                    string // The id of the agbot, not the userid of the exchange user. Must be org qualified.
-	ExchangeToken                 string // The agbot's authentication token
-	DVPrefix                      string // When looking for agreement ids in the data verification API response, look for agreement ids with this prefix.
-	ActiveDeviceTimeoutS          int    // The amount of time a device can go without heartbeating and still be considered active for the purposes of search
-	ExchangeMessageTTL            int    // The number of seconds the exchange will keep this message before automatically deleting it
-	MessageKeyPath                string // The path to the location of messaging keys
-	DefaultWorkloadPW             string // The default workload password if none is specified in the policy file
-	APIListen                     string // Host and port for the API to listen on
-	PurgeArchivedAgreementHours   int    // Number of hours to leave an archived agreement in the database before automatically deleting it
-	CheckUpdatedPolicyS           int    // The number of seconds to wait between checks for an updated policy file. Zero means auto checking is turned off.
+	ProtocolTimeoutS              uint64                    // Number of seconds to wait before declaring proposal response is lost
+	AgreementTimeoutS             uint64                    // Number of seconds to wait before declaring agreement not finalized in blockchain
+	NoDataIntervalS               uint64                    // default should be 15 mins == 15*60 == 900. Ignored if the policy has data verification disabled.
+	ActiveAgreementsURL           string                    // This field is used when policy files indicate they want data verification but they dont specify a URL
+	ActiveAgreementsUser          string                    // This is the userid the agbot uses to authenticate to the data verifivcation API
+	ActiveAgreementsPW            string                    // This is the password for the ActiveAgreementsUser
+	PolicyPath                    string                    // The directory where policy files are kept, default /etc/provider-tremor/policy/
+	NewContractIntervalS          uint64                    // default should be 1
+	ProcessGovernanceIntervalS    uint64                    // How long the gov sleeps before general gov checks (new payloads, interval payments, etc).
+	GovernanceShards              int                       // The number of goroutines that govern the active agreements in parallel, each one governs the agreements whose id hashes to it. The default is 1.
+	GovernanceShardBatch          int                       // The max number of agreements each governance goroutine governs per pass, it continues after the last one in the next pass. Zero (the default) means all of them every pass.
+	IgnoreContractWithAttribs     string                    // A comma seperated list of contract attributes. If set, the contracts that contain one or more of the attributes will be ignored. The default is "ethereum_account".
+	ExchangeURL                   string                    // The URL of the Horizon exchange. If not configured, the exchange will not be used.
+	ExchangeHeartbeat             int                       // Seconds between heartbeats to the exchange
+	ExchangeVersionCheckIntervalM int64                     // Exchange version check interval in minutes. The default is 5. 0 means no periodic checking.
+	ExchangeId                    string                    // The id of the agbot, not the userid of the exchange user. Must be org qualified.
+	ExchangeToken                 string                    // The agbot's authentication token
+	DVPrefix                      string                    // When looking for agreement ids in the data verification API response, look for agreement ids with this prefix.
+	ActiveDeviceTimeoutS          int                       // The amount of time a device can go without heartbeating and still be considered active for the purposes of search
+	NodeHeartbeatGraceChecks      int                       // The number of further node health checks a node's heartbeat can be missing on before its agreements are cancelled, for the patterns and policies that do not set missing_heartbeat_grace_checks. Zero (the default) cancels them on the first check.
+	ExchangeMessageTTL            int                       // The number of seconds the exchange will keep this message before automatically deleting it
+	ExchangeMessageWaitS          int                       // The number of seconds the exchange is asked to hold a fetch of the agbot's messages open until a message arrives. Zero (the default) turns long polling off.
+	ExchangeMessagePush           bool                      // Open the push channel of the agbot's mailbox, so that messages are fetched as soon as the exchange notifies the agbot. Falls back to (long) polling when the exchange does not support it.
+	MessageRelayURL               string                    // The URL of a relay that carries the agbot's protocol messages instead of the exchange mailbox, for deployments where the exchange mailbox is disabled. The nodes must use the same relay. Proposals are not batched through a relay. Empty (the default) uses the exchange mailbox.
+	MessageKeyPath                string                    // The path to the location of messaging keys
+	MessageKeyRotationDays        int                       // The number of days after which the messaging keys are replaced and the new public key is published. Messages sent to the previous key are still read. Zero (the default) means the keys are never rotated.
+	DefaultWorkloadPW             string                    // The default workload password if none is specified in the policy file
+	APIListen                     string                    // Host and port for the API to listen on
+	APITLS                        APITLSConfig              // Serve the API over https, see APITLSConfig.
+	PurgeArchivedAgreementHours   int                       // Number of hours to leave an archived agreement in the database before automatically deleting it
+	CheckUpdatedPolicyS           int                       // The number of seconds to wait between checks for an updated policy file. Zero means auto checking is turned off.
+	ProposalBatchSize             int                       // The max number of proposals coalesced into a single exchange message post. Zero or 1 means proposals are sent one at a time.
+	ProposalBatchWaitMS           int                       // The number of milliseconds to wait for a proposal batch to fill before sending it anyway. The default is 500.
+	NewAgreementsPerOrgPerMin     int                       // The max number of new agreements per minute started with the nodes of an org, for each agreement protocol. Zero (the default) means no limit.
+	NewAgreementsPerPatternPerMin int                       // The max number of new agreements per minute started with the nodes of a pattern, for each agreement protocol. Zero (the default) means no limit.
+	ShutdownDrainTimeoutS         int                       // The max number of seconds to wait for in-flight agreement work to finish when the agbot is shutting down. The default is 60.
+	PatternFullResyncS            int                       // The number of seconds between full reads of the served patterns when the exchange provides a change feed. The default is 3600.
+	MeteringRecordRetentionDays   int                       // The number of days the records of the metering notifications sent by the agbot are kept for export. The default is 90.
+	NodeSearchCacheS              int                       // The number of seconds the nodes found by the search of a policy are reused before the exchange is searched again. Policy changes and node changes in the exchange change feed drop them sooner. Zero (the default) searches every time.
+	BCHealthCheckIntervalS        int                       // The number of seconds between health checks of the blockchain clients' RPC endpoints. The default is 60.
+	BCHealthFailureLimit          int                       // The number of consecutive failed health checks after which a blockchain client container is relaunched. The default is 3.
+	BCBlacklistFailureLimit       int                       // The number of consecutive failures to initialize or write to a blockchain after which no more client containers are started for it. The default is 5.
+	HAPeers                       []string                  // The exchange ids (org/id) of the other agbots that serve the same patterns. The nodes are partitioned between the live agbots so that only one of them makes agreements with a node.
+	HAPartitions                  int                       // The number of partitions the nodes are split into between the HA peers. The default is 64.
+	HALeaseS                      int                       // The number of seconds after its last exchange heartbeat that an HA peer's partitions are taken over by the other peers. The default is 180.
+	InMemoryPatternPolicies       bool                      // Keep the policies generated from patterns in memory instead of writing them as policy files into PolicyPath.
+	NegotiationHistoryLength      int                       // The number of most recent proposals kept in the negotiation history of each node. The default is 10.
+	Webhooks                      []WebhookConfig           // External systems that are told when agreements are reached, finalized and terminated.
+	PlacementHooks                []PlacementHookConfig     // External schedulers that approve, reject or reorder the nodes found by a search before proposals are made to them.
+	MeteringMQTT                  MQTTConfig                // An MQTT broker that metering notifications are also published to, so that billing systems can consume them.
+	FederatedExchanges            []FederatedExchangeConfig // Other exchanges that this agbot also makes agreements on, each with its own agbot identity, for bridging two Horizon instances.
+	OrgQuotas                     map[string]OrgQuotaConfig // The quotas of the orgs served by the agbot, keyed by org. The "*" entry is used for the orgs that dont have their own. The quotas can be changed at runtime with the agbot API.
+}
+
+// TLS for the HTTP API of the node or the agbot, so that the tokens sent to the API are not in plaintext even on
+// localhost or a LAN. The API server cert is signed by a CA that is generated for this purpose. Both are generated
+// when they are missing, and the server cert is replaced before it expires. Clients trust the CA cert, which can be
+// fetched with 'hzn util cert fetch'.
+//
+// When the API listens on an interface other than loopback, so that management tools on the LAN can use it, the
+// clients can be required to authenticate with a client cert signed by one of the CAs in ClientCAFile, or with one of
+// the tokens in TokenFile. Clients that connect over loopback do not have to authenticate.
+type APITLSConfig struct {
+	Enabled          bool     // Serve the API over https instead of http.
+	CertDir          string   // The directory the CA and server certs and keys are kept in. The default is the api-tls directory in DBPath.
+	Hosts            []string // The host names and IP addresses the server cert is valid for, in addition to localhost, 127.0.0.1 and ::1.
+	CertValidityDays int      // The number of days a generated server cert is valid for. The default is 90.
+	RenewBeforeDays  int      // The number of days before the server cert expires that it is replaced. The default is 30.
+	ClientCAFile     string   // A file with the PEM-encoded CA certs that sign the client certs that are accepted.
+	TokenFile        string   // A file with the tokens, one per line, that are accepted in an "Authorization: Bearer <token>" header.
+}
+
+// Returns true when the clients of the API have to authenticate.
+func (t *APITLSConfig) ClientAuthEnabled() bool {
+	return t.ClientCAFile != "" || t.TokenFile != ""
+}
+
+// Client authentication sends the tokens, and relies on the client certs, of the TLS connection.
+func (t *APITLSConfig) validate(name string) error {
+	if t.ClientAuthEnabled() && !t.Enabled {
+		return fmt.Errorf("%v API client authentication requires TLS to be enabled", name)
+	}
+	return nil
+}
+
+func (t *APITLSConfig) setDefaults(dbPath string) {
+	if t.CertDir == "" && dbPath != "" {
+		t.CertDir = path.Join(dbPath, "api-tls")
+	}
+	if t.CertValidityDays == 0 {
+		t.CertValidityDays = 90
+	}
+	if t.RenewBeforeDays == 0 {
+		t.RenewBeforeDays = 30
+	}
+}
+
+// An MQTT broker that the agbot publishes to. Publishing is turned off when the broker is not set.
+type MQTTConfig struct {
+	Broker         string // The address of the broker, tcp://host:port, or ssl://host:port for TLS.
+	Topic          string // The topic to publish to. The default is horizon/metering.
+	ClientId       string // The MQTT client id. The default is the agbot's exchange id.
+	Username       string // The user to authenticate to the broker with, if the broker requires it.
+	Password       string // The password of the user.
+	QoS            int    // The MQTT quality of service, 0 (the default) or 1 for at least once delivery.
+	CACertsPath    string // Path to a file containing PEM-encoded x509 certs that are trusted for the TLS connection, in addition to the system's.
+	ClientCertPath string // Path to a PEM-encoded client cert, for brokers that authenticate clients with TLS.
+	ClientKeyPath  string // Path to the PEM-encoded key of the client cert.
+}
+
+// An external endpoint that the agbot POSTs agreement events to.
+type WebhookConfig struct {
+	URL            string   // The URL that the JSON event is POSTed to.
+	Secret         string   // When set, the payload is signed with HMAC-SHA256 using this secret, and the signature is sent in the X-Horizon-Signature header.
+	Events         []string // The events to send: "reached", "finalized", "terminated" and "quota". All events are sent when this is empty.
+	MaxRetries     int      // The number of times a failed POST is retried. The default is 5, a negative value turns retries off.
+	RetryIntervalS int      // The number of seconds to wait before the first retry, doubled for every further retry. The default is 5.
+}
+
+// The limits on the work an agbot that serves many orgs does for the nodes of one org, so that one org can not use up
+// the capacity that the other orgs share. Zero means no limit.
+type OrgQuotaConfig struct {
+	MaxAgreements      int // The max number of agreements with the nodes of the org that are not archived.
+	MaxProposalsPerMin int // The max number of proposals per minute made to the nodes of the org.
+	MaxServedPatterns  int // The max number of the org's patterns that are served. The patterns beyond the limit, by name, are not served.
+}
+
+func (q *OrgQuotaConfig) validate(org string) error {
+	if q.MaxAgreements < 0 || q.MaxProposalsPerMin < 0 || q.MaxServedPatterns < 0 {
+		return fmt.Errorf("the quotas of org %v can not be negative", org)
+	}
+	return nil
+}
+
+// Another exchange that the agbot makes agreements on, in addition to the one in ExchangeURL. The agbot runs a
+// separate agreement bot for each federated exchange, which serves the patterns and business policies that the
+// exchange assigns to the federated agbot identity. Each one has its own database file and policy directory, named
+// after the exchange, next to the ones of the primary exchange, so that agreements made on one exchange are never confused with those made on the other, even
+// when both exchanges have nodes with the same ids.
+type FederatedExchangeConfig struct {
+	Name          string // A short name for the exchange, letters, digits, '-' and '_' only. It must be unique among the federated exchanges.
+	ExchangeURL   string // The URL of the exchange.
+	ExchangeId    string // The id of the agbot in this exchange. Must be org qualified.
+	ExchangeToken string // The agbot's authentication token in this exchange.
+}
+
+// An external scheduler that the agbot consults before it makes proposals for a policy. The candidate nodes and a
+// summary of the policy are POSTed to it, and it answers with the nodes to make proposals to, in the order to make them.
+type PlacementHookConfig struct {
+	URL      string // The URL that the candidate nodes are POSTed to.
+	Secret   string // When set, the request is signed with HMAC-SHA256 using this secret, and the signature is sent in the X-Horizon-Signature header.
+	TimeoutS uint   // The number of seconds to wait for the scheduler's answer. The default is 10.
+	FailOpen bool   // Make proposals to all the candidates when the scheduler can't be reached or fails. By default no proposals are made for the policy until the next search.
+}
+
+var federatedNameRE = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func (f FederatedExchangeConfig) validate() error {
+	if !federatedNameRE.MatchString(f.Name) {
+		return fmt.Errorf("federated exchange name %v must only contain letters, digits, '-' and '_'", f.Name)
+	} else if f.ExchangeURL == "" || f.ExchangeToken == "" {
+		return fmt.Errorf("federated exchange %v must have an ExchangeURL and an ExchangeToken", f.Name)
+	} else if !strings.Contains(f.ExchangeId, "/") {
+		return fmt.Errorf("federated exchange %v must have an org qualified ExchangeId, has %v", f.Name, f.ExchangeId)
+	}
+	return nil
+}
+
+// Returns the config of the agreement bot that makes agreements on the federated exchange. It is the config of this
+// agbot with the identity of the federated exchange, and a policy directory of its own, which is a sibling of the
+// primary exchange's, because the policy directory of an agbot has a sub-directory for each org. The API and the HA peers
+// belong to the primary exchange, so they are not part of it.
+func (c *HorizonConfig) FederatedConfig(fed FederatedExchangeConfig) *HorizonConfig {
+	fedConfig := *c
+	fedConfig.AgreementBot.ExchangeURL = fed.ExchangeURL
+	fedConfig.AgreementBot.ExchangeId = fed.ExchangeId
+	fedConfig.AgreementBot.ExchangeToken = fed.ExchangeToken
+	fedConfig.AgreementBot.PolicyPath = path.Clean(c.AgreementBot.PolicyPath) + "." + fed.Name
+	fedConfig.AgreementBot.APIListen = ""
+	fedConfig.AgreementBot.HAPeers = nil
+	fedConfig.AgreementBot.FederatedExchanges = nil
+	return &fedConfig
+}
+
+// A local script or HTTP endpoint that the node calls when a workload starts, stops or fails. Exactly one of Command
+// and URL is set.
+type WorkloadCallbackConfig struct {
+	Command  string   // The script to run. The event is passed as JSON on stdin and in HZN_ environment variables.
+	URL      string   // The URL that the JSON event is POSTed to.
+	Events   []string // The events to call back for: "started", "stopped" and "failed". All events are sent when this is empty.
+	TimeoutS int      // The number of seconds the script or the POST is allowed to take. The default is 30.
+}
+
+// The node checks the free space of the file systems that hold its database, the service storage and the docker
+// images. When image pulls fill one of them, the node stops accepting new agreements until space is freed, rather than
+// risk a corrupted database. Both thresholds are off by default.
+type DiskGuardConfig struct {
+	Paths              []string // The directories whose file systems are checked. The default is DBPath, ServiceStorage and /var/lib/docker.
+	WarnFreeMB         uint64   // Log a warning at every check while the free space of a file system is below this many MB.
+	MinFreeMB          uint64   // Reject new agreements while the free space of a file system is below this many MB.
+	CheckIntervalS     int      // The number of seconds between checks. The default is 60.
+	RemoveUnusedImages bool     // When the free space falls below MinFreeMB, remove the docker images of ended agreements that no container uses, and dangling images.
+}
+
+// Returns true when either threshold is set.
+func (d *DiskGuardConfig) Enabled() bool {
+	return d.WarnFreeMB != 0 || d.MinFreeMB != 0
+}
+
+func (d *DiskGuardConfig) setDefaults(dbPath string, serviceStorage string) {
+	if len(d.Paths) == 0 {
+		for _, p := range []string{dbPath, serviceStorage, "/var/lib/docker"} {
+			if p != "" {
+				d.Paths = append(d.Paths, p)
+			}
+		}
+	}
+	if d.CheckIntervalS == 0 {
+		d.CheckIntervalS = 60
+	}
+}
+
+const DEFAULT_MAX_CONCURRENT_PULLS = 2
+
+// The image pulls of the agreements that start at the same time are queued, so that they do not saturate the uplink of
+// the node and all time out. The images of the services that other services depend on, and the CriticalImages, are
+// pulled first, then the smaller images.
+type ImagePullConfig struct {
+	MaxConcurrent  int      // The maximum number of images pulled at the same time. The default is 2, a negative number is no limit.
+	MaxPerRegistry int      // The maximum number of images pulled from one registry at the same time. The default, 0, is no limit other than MaxConcurrent.
+	CriticalImages []string // The image repositories, without tags, that are pulled before the others.
+}
+
+func (p *ImagePullConfig) setDefaults() {
+	if p.MaxConcurrent == 0 {
+		p.MaxConcurrent = DEFAULT_MAX_CONCURRENT_PULLS
+	}
+}
+
+// The node periodically sends anonymous operational statistics to the collector, so that the statistics of a large
+// fleet can be aggregated. Nothing is sent unless a CollectorURL is configured.
+type StatisticsConfig struct {
+	CollectorURL string // The http or https URL that the statistics are POSTed to. Empty (the default) turns the reporter off.
+	IntervalS    int    // The number of seconds between reports. The default is 3600.
+}
+
+// Returns true when the node opted in.
+func (s *StatisticsConfig) Enabled() bool {
+	return s.CollectorURL != ""
+}
+
+func (s *StatisticsConfig) setDefaults() {
+	if s.Enabled() && s.IntervalS == 0 {
+		s.IntervalS = 3600
+	}
+}
+
+func (s *StatisticsConfig) validate() error {
+	if !s.Enabled() {
+		return nil
+	} else if u, err := url.Parse(s.CollectorURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("Statistics CollectorURL %v must be an http or https URL", s.CollectorURL)
+	} else if s.IntervalS < 60 {
+		return fmt.Errorf("Statistics IntervalS must be at least 60 seconds, is %v", s.IntervalS)
+	}
+	return nil
+}
+
+// The node rolls back a workload upgrade when the containers of the new version fail MaxFailures times within WindowS
+// seconds. The version it rolls back to is the last one that ran for WindowS seconds, proposals for the failed
+// version are rejected until the workload is upgraded to another version.
+type WorkloadRollbackConfig struct {
+	MaxFailures int // The number of failures of the new version that roll it back. Zero (the default) turns rollback off.
+	WindowS     int // The number of seconds in which the failures are counted, and that a version must run to be rolled back to. The default is 600.
+}
+
+// Returns true when upgrades are rolled back.
+func (r *WorkloadRollbackConfig) Enabled() bool {
+	return r.MaxFailures > 0
+}
+
+func (r *WorkloadRollbackConfig) setDefaults() {
+	if r.Enabled() && r.WindowS == 0 {
+		r.WindowS = 600
+	}
+}
+
+const SECRETS_PROVIDER_FILE = "file"
+const SECRETS_PROVIDER_EXCHANGE = "exchange"
+const SECRETS_PROVIDER_VAULT = "vault"
+
+const DEFAULT_SECRETS_PATH = "/etc/horizon/secrets"
+const DEFAULT_VAULT_MOUNT = "secret"
+
+// The node reads the values of the secrets that the services of its agreements reference from a secrets provider, when
+// the containers are started. The values are set in the environment of the containers and are kept in memory only,
+// they are not written to the node's database or policy files. Services that reference secrets fail to start when no
+// provider is configured.
+type SecretsConfig struct {
+	Provider       string // file, exchange or vault. Empty (the default) is no provider.
+	Path           string // The directory with one file per secret for the file provider, e.g. a mounted volume. The default is /etc/horizon/secrets.
+	VaultAddr      string // The address of the HashiCorp Vault server, e.g. https://vault:8200, for the vault provider.
+	VaultMount     string // The mount path of the KV version 2 secrets engine that holds the secrets. The default is secret.
+	VaultTokenFile string // The file that holds the Vault token of the node. It is read for every lookup so that the token can be rotated.
+}
+
+// Returns true when a secrets provider is configured.
+func (s *SecretsConfig) Enabled() bool {
+	return s.Provider != ""
+}
+
+func (s *SecretsConfig) setDefaults() {
+	if s.Provider == SECRETS_PROVIDER_FILE && s.Path == "" {
+		s.Path = DEFAULT_SECRETS_PATH
+	} else if s.Provider == SECRETS_PROVIDER_VAULT && s.VaultMount == "" {
+		s.VaultMount = DEFAULT_VAULT_MOUNT
+	}
+}
+
+func (s *SecretsConfig) validate() error {
+	switch s.Provider {
+	case "", SECRETS_PROVIDER_FILE, SECRETS_PROVIDER_EXCHANGE:
+		return nil
+	case SECRETS_PROVIDER_VAULT:
+		if u, err := url.Parse(s.VaultAddr); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("Secrets VaultAddr %v must be an http or https URL", s.VaultAddr)
+		} else if s.VaultTokenFile == "" {
+			return fmt.Errorf("Secrets VaultTokenFile must be set for the %v provider", SECRETS_PROVIDER_VAULT)
+		}
+		return nil
+	default:
+		return fmt.Errorf("Secrets Provider %v is not supported, it must be %v, %v or %v", s.Provider, SECRETS_PROVIDER_FILE, SECRETS_PROVIDER_EXCHANGE, SECRETS_PROVIDER_VAULT)
+	}
+}
+
+const MEMORY_PROFILE_STANDARD = "standard"
+const MEMORY_PROFILE_LOW = "low"
+
+// The low memory profile trades features for footprint, so that anax can run next to the workloads on gateways with
+// 256 to 512MB of memory. The blockchain worker and the package (torrent) image fetch are not started, only 1 image is
+// pulled at a time, the resource usage reports are off, the exchange is checked for service upgrades less often, and
+// fewer idle HTTP connections and recorded exchange calls are kept. See doc/low_memory.md for the memory targets.
+const LOW_MEMORY_SERVICE_UPGRADE_CHECK_INTERVAL_S = 3600
+const LOW_MEMORY_MAX_HTTP_IDLE_CONNECTIONS = 2
+const LOW_MEMORY_RECORDED_EXCHANGE_CALLS = 50
+
+// Returns true when the node runs with the low memory profile.
+func (c *Config) LowMemory() bool {
+	return c.MemoryProfile == MEMORY_PROFILE_LOW
+}
+
+// Set the defaults of the memory profile. The settings in the config file are kept, so that a feature the profile
+// turns off can be turned on again.
+func (c *Config) applyMemoryProfile() error {
+	switch c.MemoryProfile {
+	case "":
+		c.MemoryProfile = MEMORY_PROFILE_STANDARD
+	case MEMORY_PROFILE_STANDARD:
+	case MEMORY_PROFILE_LOW:
+		if c.ImagePulls.MaxConcurrent == 0 {
+			c.ImagePulls.MaxConcurrent = 1
+		}
+		if c.ResourceUsageReportIntervalS == 0 {
+			c.ResourceUsageReportIntervalS = -1
+		}
+		if c.ServiceUpgradeCheckIntervalS == 0 {
+			c.ServiceUpgradeCheckIntervalS = LOW_MEMORY_SERVICE_UPGRADE_CHECK_INTERVAL_S
+		}
+	default:
+		return fmt.Errorf("MemoryProfile %v is not %v or %v", c.MemoryProfile, MEMORY_PROFILE_STANDARD, MEMORY_PROFILE_LOW)
+	}
+	return nil
 }
 
 func (c *HorizonConfig) UserPublicKeyPath() string {
@@ -138,6 +520,11 @@ func Read(file string) (*HorizonConfig, error) {
 			return nil, fmt.Errorf("Unable to enrich content of config file with envvars: %v", err)
 		}
 
+		// The memory profile sets its defaults first, the defaults below fill in the rest.
+		if err := config.Edge.applyMemoryProfile(); err != nil {
+			return nil, err
+		}
+
 		// set the defaults here in case the attributes are not setup by the user.
 		if config.Edge.ExchangeVersionCheckIntervalM == 0 {
 			config.Edge.ExchangeVersionCheckIntervalM = 720
@@ -148,6 +535,108 @@ func Read(file string) (*HorizonConfig, error) {
 		if config.Edge.ServiceUpgradeCheckIntervalS == 0 {
 			config.Edge.ServiceUpgradeCheckIntervalS = 300
 		}
+		if config.Edge.ExchangeMaxRetries == 0 {
+			config.Edge.ExchangeMaxRetries = 3
+		}
+		if config.Edge.ExchangeRetryIntervalMS == 0 {
+			config.Edge.ExchangeRetryIntervalMS = 1000
+		}
+		if config.Edge.ExchangeOutageThresholdS == 0 {
+			config.Edge.ExchangeOutageThresholdS = 300
+		}
+		if config.Edge.ResourceUsageReportIntervalS == 0 {
+			config.Edge.ResourceUsageReportIntervalS = 300
+		}
+		if config.AgreementBot.ProposalBatchWaitMS == 0 {
+			config.AgreementBot.ProposalBatchWaitMS = 500
+		}
+		if config.AgreementBot.ShutdownDrainTimeoutS == 0 {
+			config.AgreementBot.ShutdownDrainTimeoutS = 60
+		}
+		if config.AgreementBot.PatternFullResyncS == 0 {
+			config.AgreementBot.PatternFullResyncS = 3600
+		}
+		if config.AgreementBot.MeteringRecordRetentionDays == 0 {
+			config.AgreementBot.MeteringRecordRetentionDays = 90
+		}
+		if config.AgreementBot.BCHealthCheckIntervalS == 0 {
+			config.AgreementBot.BCHealthCheckIntervalS = 60
+		}
+		if config.AgreementBot.BCHealthFailureLimit == 0 {
+			config.AgreementBot.BCHealthFailureLimit = 3
+		}
+		if config.AgreementBot.BCBlacklistFailureLimit == 0 {
+			config.AgreementBot.BCBlacklistFailureLimit = 5
+		}
+		if config.AgreementBot.HAPartitions == 0 {
+			config.AgreementBot.HAPartitions = 64
+		}
+		if config.AgreementBot.HALeaseS == 0 {
+			config.AgreementBot.HALeaseS = 180
+		}
+		if config.AgreementBot.NegotiationHistoryLength == 0 {
+			config.AgreementBot.NegotiationHistoryLength = 10
+		}
+		if config.AgreementBot.GovernanceShards == 0 {
+			config.AgreementBot.GovernanceShards = 1
+		}
+		config.Edge.APITLS.setDefaults(config.Edge.DBPath)
+		config.Edge.DiskGuard.setDefaults(config.Edge.DBPath, config.Edge.ServiceStorage)
+		config.Edge.ImagePulls.setDefaults()
+		config.Edge.Statistics.setDefaults()
+		config.Edge.WorkloadRollback.setDefaults()
+		config.Edge.Secrets.setDefaults()
+		config.AgreementBot.APITLS.setDefaults(config.AgreementBot.DBPath)
+		if config.AgreementBot.MeteringMQTT.Broker != "" && config.AgreementBot.MeteringMQTT.Topic == "" {
+			config.AgreementBot.MeteringMQTT.Topic = "horizon/metering"
+		}
+		for i := range config.Edge.WorkloadCallbacks {
+			if config.Edge.WorkloadCallbacks[i].TimeoutS == 0 {
+				config.Edge.WorkloadCallbacks[i].TimeoutS = 30
+			}
+		}
+		if err := config.Edge.APITLS.validate("node"); err != nil {
+			return nil, err
+		} else if err := config.Edge.Statistics.validate(); err != nil {
+			return nil, err
+		} else if err := config.Edge.Secrets.validate(); err != nil {
+			return nil, err
+		} else if err := config.AgreementBot.APITLS.validate("agbot"); err != nil {
+			return nil, err
+		}
+		if config.AgreementBot.GovernanceShards < 0 || config.AgreementBot.GovernanceShardBatch < 0 {
+			return nil, fmt.Errorf("GovernanceShards and GovernanceShardBatch must not be negative")
+		}
+		names := make(map[string]bool)
+		for _, fed := range config.AgreementBot.FederatedExchanges {
+			if err := fed.validate(); err != nil {
+				return nil, err
+			} else if names[fed.Name] {
+				return nil, fmt.Errorf("federated exchange name %v is used more than once", fed.Name)
+			}
+			names[fed.Name] = true
+		}
+		for i := range config.AgreementBot.Webhooks {
+			if config.AgreementBot.Webhooks[i].MaxRetries == 0 {
+				config.AgreementBot.Webhooks[i].MaxRetries = 5
+			}
+			if config.AgreementBot.Webhooks[i].RetryIntervalS == 0 {
+				config.AgreementBot.Webhooks[i].RetryIntervalS = 5
+			}
+		}
+		for org, quota := range config.AgreementBot.OrgQuotas {
+			if err := quota.validate(org); err != nil {
+				return nil, err
+			}
+		}
+		for i := range config.AgreementBot.PlacementHooks {
+			if config.AgreementBot.PlacementHooks[i].URL == "" {
+				return nil, fmt.Errorf("placement hook %v has no URL", i)
+			}
+			if config.AgreementBot.PlacementHooks[i].TimeoutS == 0 {
+				config.AgreementBot.PlacementHooks[i].TimeoutS = 10
+			}
+		}
 
 		// now make collaborators instance and assign it to member in this config
 		collaborators, err := NewCollaborators(config)
@@ -51,3 +51,96 @@ func Test_enrichFromEnvvars_success(t *testing.T) {
 	}
 
 }
+
+func Test_FederatedExchanges(t *testing.T) {
+
+	valid := FederatedExchangeConfig{Name: "old-exchange_1", ExchangeURL: "https://old.example.com/v1", ExchangeId: "myorg/agbot1", ExchangeToken: "token"}
+	if err := valid.validate(); err != nil {
+		t.Errorf("federated exchange %v should be valid, error %v", valid, err)
+	}
+
+	invalid := []FederatedExchangeConfig{
+		{Name: "", ExchangeURL: "https://old.example.com/v1", ExchangeId: "myorg/agbot1", ExchangeToken: "token"},
+		{Name: "../old", ExchangeURL: "https://old.example.com/v1", ExchangeId: "myorg/agbot1", ExchangeToken: "token"},
+		{Name: "old", ExchangeId: "myorg/agbot1", ExchangeToken: "token"},
+		{Name: "old", ExchangeURL: "https://old.example.com/v1", ExchangeId: "agbot1", ExchangeToken: "token"},
+		{Name: "old", ExchangeURL: "https://old.example.com/v1", ExchangeId: "myorg/agbot1"},
+	}
+	for _, fed := range invalid {
+		if err := fed.validate(); err == nil {
+			t.Errorf("federated exchange %v should not be valid", fed)
+		}
+	}
+
+	config := HorizonConfig{
+		AgreementBot: AGConfig{
+			ExchangeURL:        "https://new.example.com/v1",
+			ExchangeId:         "neworg/agbot",
+			ExchangeToken:      "newtoken",
+			PolicyPath:         "/etc/horizon/policy.d/",
+			APIListen:          "localhost:8046",
+			HAPeers:            []string{"neworg/agbot2"},
+			AgreementWorkers:   5,
+			FederatedExchanges: []FederatedExchangeConfig{valid},
+		},
+	}
+
+	fedConfig := config.FederatedConfig(valid)
+	if ag := fedConfig.AgreementBot; ag.ExchangeURL != valid.ExchangeURL || ag.ExchangeId != valid.ExchangeId || ag.ExchangeToken != valid.ExchangeToken {
+		t.Errorf("federated config should have the identity of the federated exchange, has %v %v %v", ag.ExchangeURL, ag.ExchangeId, ag.ExchangeToken)
+	} else if ag.PolicyPath != "/etc/horizon/policy.d.old-exchange_1" {
+		t.Errorf("federated config should have a policy directory of its own, has %v", ag.PolicyPath)
+	} else if ag.APIListen != "" || len(ag.HAPeers) != 0 || len(ag.FederatedExchanges) != 0 {
+		t.Errorf("federated config should not have the API, HA peers or federated exchanges of the primary exchange, has %v %v %v", ag.APIListen, ag.HAPeers, ag.FederatedExchanges)
+	} else if ag.AgreementWorkers != 5 {
+		t.Errorf("federated config should keep the rest of the agbot config, has %v agreement workers", ag.AgreementWorkers)
+	}
+	if config.AgreementBot.ExchangeURL != "https://new.example.com/v1" || config.AgreementBot.APIListen != "localhost:8046" {
+		t.Errorf("the primary exchange config should not change, is %v", config.AgreementBot)
+	}
+}
+
+func Test_applyMemoryProfile(t *testing.T) {
+
+	standard := Config{}
+	if err := standard.applyMemoryProfile(); err != nil {
+		t.Errorf("the default profile should be valid, error %v", err)
+	} else if standard.MemoryProfile != MEMORY_PROFILE_STANDARD || standard.LowMemory() || standard.ImagePulls.MaxConcurrent != 0 {
+		t.Errorf("the standard profile should not change the defaults, is %v", standard)
+	}
+
+	low := Config{MemoryProfile: MEMORY_PROFILE_LOW, ResourceUsageReportIntervalS: 600}
+	if err := low.applyMemoryProfile(); err != nil {
+		t.Errorf("the low profile should be valid, error %v", err)
+	} else if !low.LowMemory() || low.ImagePulls.MaxConcurrent != 1 || low.ServiceUpgradeCheckIntervalS != LOW_MEMORY_SERVICE_UPGRADE_CHECK_INTERVAL_S {
+		t.Errorf("the low profile should set its defaults, is %v", low)
+	} else if low.ResourceUsageReportIntervalS != 600 {
+		t.Errorf("the low profile should keep the settings of the config file, is %v", low.ResourceUsageReportIntervalS)
+	}
+
+	unknown := Config{MemoryProfile: "tiny"}
+	if err := unknown.applyMemoryProfile(); err == nil {
+		t.Errorf("profile %v should not be valid", unknown.MemoryProfile)
+	}
+}
+
+func Test_StatisticsConfig(t *testing.T) {
+
+	off := StatisticsConfig{}
+	off.setDefaults()
+	if off.Enabled() || off.IntervalS != 0 || off.validate() != nil {
+		t.Errorf("the reporter should be off by default, is %v", off)
+	}
+
+	on := StatisticsConfig{CollectorURL: "https://stats.example.com/v1/nodes"}
+	on.setDefaults()
+	if !on.Enabled() || on.IntervalS != 3600 || on.validate() != nil {
+		t.Errorf("the reporter should be on every hour, is %v", on)
+	}
+
+	for _, invalid := range []StatisticsConfig{{CollectorURL: "stats.example.com", IntervalS: 3600}, {CollectorURL: "ftp://stats.example.com", IntervalS: 3600}, {CollectorURL: "https://stats.example.com", IntervalS: 10}} {
+		if err := invalid.validate(); err == nil {
+			t.Errorf("statistics config %v should not be valid", invalid)
+		}
+	}
+}
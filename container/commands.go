@@ -146,3 +146,42 @@ func (b *ContainerWorker) NewShutdownMicroserviceCommand(key string) *ShutdownMi
 		MsInstKey: key,
 	}
 }
+
+// ==============================================================================================================
+type SampleContainersCommand struct {
+	AgreementProtocol string
+	AgreementId       string
+}
+
+func (c SampleContainersCommand) String() string {
+	return fmt.Sprintf("AgreementProtocol: %v, AgreementId: %v", c.AgreementProtocol, c.AgreementId)
+}
+
+func (c SampleContainersCommand) ShortString() string {
+	return c.String()
+}
+
+func (b *ContainerWorker) NewSampleContainersCommand(protocol string, agreementId string) *SampleContainersCommand {
+	return &SampleContainersCommand{
+		AgreementProtocol: protocol,
+		AgreementId:       agreementId,
+	}
+}
+
+type RemoveUnusedImagesCommand struct {
+	Reason string
+}
+
+func (c RemoveUnusedImagesCommand) String() string {
+	return fmt.Sprintf("Reason: %v", c.Reason)
+}
+
+func (c RemoveUnusedImagesCommand) ShortString() string {
+	return c.String()
+}
+
+func (b *ContainerWorker) NewRemoveUnusedImagesCommand(reason string) *RemoveUnusedImagesCommand {
+	return &RemoveUnusedImagesCommand{
+		Reason: reason,
+	}
+}
@@ -13,7 +13,9 @@ import (
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/config"
 	"github.com/open-horizon/anax/containermessage"
+	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/faults"
 	"github.com/open-horizon/anax/persistence"
 	"github.com/open-horizon/anax/policy"
 	"github.com/open-horizon/anax/worker"
@@ -21,6 +23,7 @@ import (
 	"io"
 	"io/ioutil"
 	"math/big"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
@@ -105,6 +108,7 @@ func NewConfigure(configureNonce string, torrentURL url.URL, deployment string,
 type servicePair struct {
 	service       *containermessage.Service  // the external type
 	serviceConfig *persistence.ServiceConfig // the internal type
+	secretEnv     []string                   // the secrets of the service, NAME=value, kept out of serviceConfig because it is persisted
 }
 
 func hashService(service *containermessage.Service) (string, error) {
@@ -186,28 +190,22 @@ func finalizeDeployment(agreementId string, deployment *containermessage.Deploym
 		labels[LABEL_PREFIX+".variation"] = service.VariationLabel
 		labels[LABEL_PREFIX+".deployment_description_hash"] = deploymentHash
 
-		var logConfig docker.LogConfig
+		var logTag string
 
 		if !deployment.ServicePattern.IsShared("singleton", serviceName) {
 			labels[LABEL_PREFIX+".agreement_id"] = agreementId
-			logConfig = docker.LogConfig{
-				Type: "syslog",
-				Config: map[string]string{
-					"tag": fmt.Sprintf("workload-%v_%v", strings.ToLower(agreementId), serviceName),
-				},
-			}
+			logTag = fmt.Sprintf("workload-%v_%v", strings.ToLower(agreementId), serviceName)
 		} else {
 			logName := serviceName
 			if service.VariationLabel != "" {
 				logName = fmt.Sprintf("%v-%v", serviceName, service.VariationLabel)
 			}
+			logTag = fmt.Sprintf("workload-%v_%v", "singleton", logName)
+		}
 
-			logConfig = docker.LogConfig{
-				Type: "syslog",
-				Config: map[string]string{
-					"tag": fmt.Sprintf("workload-%v_%v", "singleton", logName),
-				},
-			}
+		logConfig, err := service.DockerLogConfig(logTag)
+		if err != nil {
+			return nil, fmt.Errorf("Illegal logging specified in deployment description for service %v: %v", serviceName, err)
 		}
 
 		serviceConfig := &persistence.ServiceConfig{
@@ -323,23 +321,11 @@ func finalizeDeployment(agreementId string, deployment *containermessage.Deploym
 			serviceConfig.HostConfig.PortBindings[dPort] = append(serviceConfig.HostConfig.PortBindings[dPort], hMapping)
 		}
 
-		// The format of device mapping is: <host device name>:<contianer device name>:<cgroup permission>
-		// the cgoup permission can be omitted. It defaults to "rwm" when omitted.
-		for _, givenDevice := range service.Devices {
-			cgp := "rwm"
-			sp := strings.Split(givenDevice, ":")
-			if len(sp) == 3 {
-				// the cgroup permission
-				cgp = sp[2]
-			} else if len(sp) < 2 || len(sp) > 3 {
-				return nil, fmt.Errorf("Illegal device specified in deployment description: %v", givenDevice)
-			}
-
-			serviceConfig.HostConfig.Devices = append(serviceConfig.HostConfig.Devices, docker.Device{
-				PathOnHost:        sp[0],
-				PathInContainer:   sp[1],
-				CgroupPermissions: cgp,
-			})
+		// The devices of the service, the devices it needs must be on the host.
+		if devices, err := service.DockerDevices(containermessage.HostDeviceExists); err != nil {
+			return nil, fmt.Errorf("Illegal device specified in deployment description for service %v: %v", serviceName, err)
+		} else {
+			serviceConfig.HostConfig.Devices = devices
 		}
 
 		services[serviceName] = servicePair{
@@ -357,6 +343,7 @@ type ContainerWorker struct {
 	client            *docker.Client
 	iptables          *iptables.IPTables
 	inAgbot           bool
+	secrets           SecretsProvider
 }
 
 func (cw *ContainerWorker) GetClient() *docker.Client {
@@ -369,12 +356,18 @@ func CreateCLIContainerWorker(config *config.HorizonConfig) (*ContainerWorker, e
 		return nil, derr
 	}
 
+	secrets, serr := NewSecretsProvider(config, nil)
+	if serr != nil {
+		return nil, serr
+	}
+
 	return &ContainerWorker{
 		BaseWorker: worker.NewBaseWorker("mock", config, nil),
 		db:         nil,
 		client:     client,
 		iptables:   nil,
 		inAgbot:    true,
+		secrets:    secrets,
 	}, nil
 }
 
@@ -403,6 +396,9 @@ func NewContainerWorker(name string, config *config.HorizonConfig, db *bolt.DB)
 	} else if client, err := docker.NewClient(config.Edge.DockerEndpoint); err != nil {
 		glog.Errorf("Failed to instantiate docker Client: %v", err)
 		panic("Unable to instantiate docker Client")
+	} else if secrets, err := NewSecretsProvider(config, db); err != nil {
+		glog.Errorf("Failed to instantiate the secrets provider: %v", err)
+		panic("Unable to instantiate the secrets provider")
 	} else {
 		worker := &ContainerWorker{
 			BaseWorker: worker.NewBaseWorker(name, config, nil),
@@ -410,6 +406,7 @@ func NewContainerWorker(name string, config *config.HorizonConfig, db *bolt.DB)
 			client:     client,
 			iptables:   ipt,
 			inAgbot:    inAgbot,
+			secrets:    secrets,
 		}
 		worker.SetDeferredDelay(15)
 
@@ -450,6 +447,9 @@ func (w *ContainerWorker) NewEvent(incoming events.Message) {
 		case events.CONTAINER_MAINTAIN:
 			containerCmd := w.NewContainerMaintenanceCommand(msg.AgreementProtocol, msg.AgreementId, msg.Deployment)
 			w.Commands <- containerCmd
+		case events.SAMPLE_CONTAINERS:
+			containerCmd := w.NewSampleContainersCommand(msg.AgreementProtocol, msg.AgreementId)
+			w.Commands <- containerCmd
 		}
 
 	case *events.GovernanceWorkloadCancelationMessage:
@@ -488,6 +488,16 @@ func (w *ContainerWorker) NewEvent(incoming events.Message) {
 			w.Commands <- containerCmd
 		}
 
+	case *events.DiskSpaceMessage:
+		msg, _ := incoming.(*events.DiskSpaceMessage)
+
+		switch msg.Event().Id {
+		case events.DISK_SPACE_LOW:
+			if w.Config.Edge.DiskGuard.RemoveUnusedImages {
+				w.Commands <- w.NewRemoveUnusedImagesCommand("disk space is low")
+			}
+		}
+
 	case *events.NodeShutdownCompleteMessage:
 		msg, _ := incoming.(*events.NodeShutdownCompleteMessage)
 		switch msg.Event().Id {
@@ -528,6 +538,7 @@ func serviceStart(client *docker.Client,
 	serviceName string,
 	shareLabel string,
 	serviceConfig *persistence.ServiceConfig,
+	secretEnv []string,
 	endpointsConfig map[string]*docker.EndpointConfig,
 	sharedEndpoints map[string]*docker.EndpointConfig,
 	postCreateContainers *[]interface{},
@@ -541,9 +552,15 @@ func serviceStart(client *docker.Client,
 		namePrefix = agreementId
 	}
 
+	// The secrets go into a copy of the config, so that they are neither logged nor persisted with the service config.
+	containerConfig := serviceConfig.Config
+	if len(secretEnv) != 0 {
+		containerConfig.Env = append(append([]string{}, serviceConfig.Config.Env...), secretEnv...)
+	}
+
 	containerOpts := docker.CreateContainerOptions{
 		Name:       fmt.Sprintf("%v-%v", namePrefix, serviceName),
-		Config:     &serviceConfig.Config,
+		Config:     &containerConfig,
 		HostConfig: &serviceConfig.HostConfig,
 		NetworkingConfig: &docker.NetworkingConfig{
 			EndpointsConfig: endpointsConfig,
@@ -558,6 +575,9 @@ func serviceStart(client *docker.Client,
 	glog.V(5).Infof("CreateContainer options: Config: %v, HostConfig: %v, EndpointsConfig: %v", serviceConfig.Config, serviceConfig.HostConfig, endpointsConfig)
 
 	container, cErr := client.CreateContainer(containerOpts)
+	if cErr == nil && faults.Inject(faults.DOCKER_ERROR) {
+		cErr = &docker.Error{Status: http.StatusInternalServerError, Message: faults.Message(faults.DOCKER_ERROR)}
+	}
 	if cErr != nil {
 		if cErr == docker.ErrContainerAlreadyExists {
 			return cErr
@@ -581,7 +601,7 @@ func serviceStart(client *docker.Client,
 			if err_r := client.RemoveContainer(docker.RemoveContainerOptions{ID: container.ID, RemoveVolumes: false, Force: true}); err_r != nil {
 				return fail(container, serviceName, err_r)
 			} else {
-				return serviceStart(client, agreementId, serviceName, shareLabel, serviceConfig, endpointsConfig,
+				return serviceStart(client, agreementId, serviceName, shareLabel, serviceConfig, secretEnv, endpointsConfig,
 					sharedEndpoints, postCreateContainers, fail, false)
 			}
 		} else {
@@ -902,6 +922,17 @@ func (b *ContainerWorker) workloadStorageDir(agreementId string) string {
 	return path.Join(storage_base_dir, agreementId)
 }
 
+// Returns the environment variables that hold the secrets of the service, read from the node's secrets provider.
+func (b *ContainerWorker) secretEnv(service *containermessage.Service) ([]string, error) {
+	if len(service.Secrets) == 0 {
+		return nil, nil
+	} else if b.secrets == nil {
+		return nil, errors.New("the service references secrets but the node has no secrets provider configured")
+	}
+	glog.V(3).Infof("Getting %v secrets from the %v secrets provider", len(service.Secrets), b.secrets.Name())
+	return service.SecretEnv(b.secrets.Secret)
+}
+
 func (b *ContainerWorker) ResourcesCreate(agreementId string, configure *events.ContainerConfig, deployment *containermessage.DeploymentDescription, configureRaw []byte, environmentAdditions map[string]string, ms_networks map[string]docker.ContainerNetwork) (*map[string]persistence.ServiceConfig, error) {
 
 	// local helpers
@@ -983,10 +1014,16 @@ func (b *ContainerWorker) ResourcesCreate(agreementId string, configure *events.
 	ret := make(map[string]persistence.ServiceConfig, 0)
 
 	for serviceName, servicePair := range servicePairs {
-		if image, err := b.client.InspectImage(servicePair.serviceConfig.Config.Image); err != nil {
+		if err := cutil.ValidateImageReference(servicePair.serviceConfig.Config.Image); err != nil {
+			return nil, fail(nil, serviceName, fmt.Errorf("Failed to validate image: %v", err))
+		} else if image, err := b.client.InspectImage(servicePair.serviceConfig.Config.Image); err != nil {
 			return nil, fail(nil, serviceName, fmt.Errorf("Failed to inspect image: %v. Original error: %v", servicePair.serviceConfig.Config.Image, err))
 		} else if image == nil {
 			return nil, fail(nil, serviceName, fmt.Errorf("Unable to find Docker image: %v", servicePair.serviceConfig.Config.Image))
+		} else if secretEnv, err := b.secretEnv(servicePair.service); err != nil {
+			return nil, fail(nil, serviceName, fmt.Errorf("Failed to get the secrets of service %v: %v", serviceName, err))
+		} else {
+			servicePair.secretEnv = secretEnv
 		}
 
 		// need to examine original deploymentDescription to determine which containers are "shared" or in other special patterns
@@ -1058,7 +1095,7 @@ func (b *ContainerWorker) ResourcesCreate(agreementId string, configure *events.
 		if existingContainer == nil {
 			// only create container if there wasn't one
 			servicePair.serviceConfig.HostConfig.NetworkMode = bridgeName
-			if err := serviceStart(b.client, agreementId, containerName, shareLabel, servicePair.serviceConfig, eps, ms_sharedendpoints, &postCreateContainers, fail, true); err != nil {
+			if err := serviceStart(b.client, agreementId, containerName, shareLabel, servicePair.serviceConfig, servicePair.secretEnv, eps, ms_sharedendpoints, &postCreateContainers, fail, true); err != nil {
 				return nil, err
 			}
 		} else {
@@ -1099,7 +1136,7 @@ func (b *ContainerWorker) ResourcesCreate(agreementId string, configure *events.
 	// every one of these gets wired to both the agBridge and every shared bridge from this agreement
 	for serviceName, servicePair := range private {
 		servicePair.serviceConfig.HostConfig.NetworkMode = agreementId // custom bridge has agreementId as name, same as endpoint key
-		if err := serviceStart(b.client, agreementId, serviceName, "", servicePair.serviceConfig, mkEndpoints(agBridge, serviceName), sharedEndpoints, &postCreateContainers, fail, true); err != nil {
+		if err := serviceStart(b.client, agreementId, serviceName, "", servicePair.serviceConfig, servicePair.secretEnv, mkEndpoints(agBridge, serviceName), sharedEndpoints, &postCreateContainers, fail, true); err != nil {
 			if err != docker.ErrContainerAlreadyExists {
 				return nil, err
 			}
@@ -1337,6 +1374,25 @@ func (b *ContainerWorker) CommandHandler(command worker.Command) bool {
 			b.Messages() <- events.NewWorkloadMessage(events.EXECUTION_FAILED, cmd.AgreementProtocol, cmd.AgreementId, cmd.Deployment)
 		}
 
+	case *SampleContainersCommand:
+		cmd := command.(*SampleContainersCommand)
+		glog.V(5).Infof("ContainerWorker received sample command: %v", cmd)
+
+		// Docker takes a while to collect the stats of a container, so the sample is taken without holding up the
+		// other container commands.
+		go func() {
+			if usage, err := b.sampleAgreementUsage(cmd.AgreementId); err != nil {
+				glog.Errorf("Unable to sample the containers of agreement %v, error: %v", cmd.AgreementId, err)
+			} else if usage.Containers != 0 {
+				b.Messages() <- events.NewContainerUsageMessage(events.CONTAINER_USAGE, cmd.AgreementProtocol, cmd.AgreementId, usage)
+			}
+		}()
+
+	case *RemoveUnusedImagesCommand:
+		cmd := command.(*RemoveUnusedImagesCommand)
+		glog.V(3).Infof("ContainerWorker received remove unused images command: %v", cmd)
+		b.removeUnusedImages()
+
 	case *WorkloadShutdownCommand:
 		cmd := command.(*WorkloadShutdownCommand)
 
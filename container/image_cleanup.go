@@ -0,0 +1,96 @@
+package container
+
+import (
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/policy"
+)
+
+// Two references to the same image, e.g. with and without the latest tag, have the same canonical name.
+func canonicalImageName(reference string) string {
+	if r, err := cutil.ParseImageReference(reference); err == nil {
+		return r.Normalize().String()
+	}
+	return reference
+}
+
+// An image that has no tags is dangling, it was replaced by a newer image with the same name.
+func danglingImage(image docker.APIImages) bool {
+	for _, tag := range image.RepoTags {
+		if tag != "<none>:<none>" {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the images to remove: the dangling images and the images of the ended agreements, unless a container, running
+// or not, uses them. Images that anax did not deploy are never removed, and neither are the images of running
+// workloads, because their containers use them.
+func unusedImages(images []docker.APIImages, containers []docker.APIContainers, endedImages map[string]bool) []docker.APIImages {
+	inUse := make(map[string]bool)
+	for _, c := range containers {
+		inUse[c.Image] = true
+		inUse[canonicalImageName(c.Image)] = true
+	}
+
+	unused := make([]docker.APIImages, 0, 10)
+	for _, image := range images {
+		if inUse[image.ID] {
+			continue
+		}
+		used, ended := false, false
+		for _, tag := range image.RepoTags {
+			used = used || inUse[canonicalImageName(tag)]
+			ended = ended || endedImages[canonicalImageName(tag)]
+		}
+		if !used && (ended || danglingImage(image)) {
+			unused = append(unused, image)
+		}
+	}
+	return unused
+}
+
+// Free disk space by removing the images that no container uses anymore. This is done when the node is short of disk
+// space, instead of waiting for an administrator to prune the images.
+func (b *ContainerWorker) removeUnusedImages() {
+
+	endedImages := make(map[string]bool)
+	if ags, err := persistence.FindEstablishedAgreementsAllProtocols(b.db, policy.AllAgreementProtocols(), []persistence.EAFilter{}); err != nil {
+		glog.Errorf("ContainerWorker unable to read agreements to find unused images, error: %v", err)
+		return
+	} else {
+		for _, ag := range ags {
+			if !ag.Archived && ag.AgreementTerminatedTime == 0 {
+				continue
+			}
+			for _, sc := range ag.CurrentDeployment {
+				endedImages[canonicalImageName(sc.Config.Image)] = true
+			}
+		}
+	}
+
+	containers, err := b.client.ListContainers(docker.ListContainersOptions{All: true})
+	if err != nil {
+		glog.Errorf("ContainerWorker unable to list containers to find unused images, error: %v", err)
+		return
+	}
+	images, err := b.client.ListImages(docker.ListImagesOptions{All: false})
+	if err != nil {
+		glog.Errorf("ContainerWorker unable to list images to find unused images, error: %v", err)
+		return
+	}
+
+	freed := int64(0)
+	for _, image := range unusedImages(images, containers, endedImages) {
+		if err := b.client.RemoveImage(image.ID); err != nil {
+			glog.Warningf("ContainerWorker unable to remove unused image %v %v, error: %v", image.ID, image.RepoTags, err)
+		} else {
+			glog.V(3).Infof("ContainerWorker removed unused image %v %v", image.ID, image.RepoTags)
+			freed += image.Size
+		}
+	}
+	glog.Infof("ContainerWorker removed unused images of %v MB", freed/(1024*1024))
+}
@@ -0,0 +1,36 @@
+// +build unit
+
+package container
+
+import (
+	docker "github.com/fsouza/go-dockerclient"
+	"testing"
+)
+
+func Test_unusedImages(t *testing.T) {
+
+	images := []docker.APIImages{
+		{ID: "sha256:1", RepoTags: []string{"myorg/running:1.0"}},
+		{ID: "sha256:2", RepoTags: []string{"myorg/ended:1.0"}},
+		{ID: "sha256:3", RepoTags: []string{"<none>:<none>"}},
+		{ID: "sha256:4", RepoTags: []string{"someone/else:latest"}},
+		{ID: "sha256:5"},
+		{ID: "sha256:6", RepoTags: []string{"myorg/stopped"}},
+	}
+	containers := []docker.APIContainers{
+		{Image: "docker.io/myorg/running:1.0"},
+		{Image: "sha256:5"},
+		{Image: "myorg/stopped:latest"},
+	}
+	ended := map[string]bool{
+		canonicalImageName("myorg/ended:1.0"):   true,
+		canonicalImageName("myorg/running:1.0"): true,
+		canonicalImageName("myorg/stopped"):     true,
+	}
+
+	// Only the image of the ended agreement and the dangling image that no container uses are removed.
+	unused := unusedImages(images, containers, ended)
+	if len(unused) != 2 || unused[0].ID != "sha256:2" || unused[1].ID != "sha256:3" {
+		t.Errorf("unused images should be sha256:2 and sha256:3, are %v", unused)
+	}
+}
@@ -0,0 +1,182 @@
+package container
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/persistence"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// The secrets providers of the node. A provider returns the value of a secret by name, the container worker sets the
+// values in the environment of the containers when they are created, see SecretEnv. The values are never logged, the
+// errors of the providers do not contain the responses of the servers for that reason.
+type SecretsProvider interface {
+	Name() string
+	Secret(name string) (string, error)
+}
+
+// Returns the secrets provider of the node, or nil when none is configured.
+func NewSecretsProvider(cfg *config.HorizonConfig, db *bolt.DB) (SecretsProvider, error) {
+	sc := cfg.Edge.Secrets
+	switch sc.Provider {
+	case "":
+		return nil, nil
+	case config.SECRETS_PROVIDER_FILE:
+		return &fileSecretsProvider{dir: sc.Path}, nil
+	case config.SECRETS_PROVIDER_EXCHANGE:
+		return &exchangeSecretsProvider{
+			httpClient:  cfg.Collaborators.HTTPClientFactory.NewHTTPClient,
+			exchangeURL: cfg.Edge.ExchangeURL,
+			credentials: func() (string, string, error) {
+				if db == nil {
+					return "", "", errors.New("the node is not registered")
+				} else if dev, err := persistence.FindExchangeDevice(db); err != nil {
+					return "", "", err
+				} else if dev == nil {
+					return "", "", errors.New("the node is not registered")
+				} else {
+					return fmt.Sprintf("%v/%v", dev.Org, dev.Id), dev.Token, nil
+				}
+			},
+		}, nil
+	case config.SECRETS_PROVIDER_VAULT:
+		return &vaultSecretsProvider{
+			httpClient: cfg.Collaborators.HTTPClientFactory.NewHTTPClient,
+			addr:       strings.TrimSuffix(sc.VaultAddr, "/"),
+			mount:      strings.Trim(sc.VaultMount, "/"),
+			tokenFile:  sc.VaultTokenFile,
+		}, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("unsupported secrets provider %v", sc.Provider))
+	}
+}
+
+// Secrets in files, one file per secret named like the secret, e.g. in a mounted volume. A single trailing newline is
+// removed from the value.
+type fileSecretsProvider struct {
+	dir string
+}
+
+func (p *fileSecretsProvider) Name() string {
+	return config.SECRETS_PROVIDER_FILE
+}
+
+func (p *fileSecretsProvider) Secret(name string) (string, error) {
+	if bytes, err := ioutil.ReadFile(path.Join(p.dir, name)); os.IsNotExist(err) {
+		return "", errors.New(fmt.Sprintf("secret not found in %v", p.dir))
+	} else if err != nil {
+		return "", err
+	} else {
+		return strings.TrimSuffix(string(bytes), "\n"), nil
+	}
+}
+
+// Secrets that the exchange keeps for the node, at orgs/{org}/nodes/{id}/secrets/{name}. The node authenticates with its
+// exchange credentials, so the exchange only returns the secrets of the node.
+type exchangeSecretsProvider struct {
+	httpClient  func(overrideTimeoutS *uint) *http.Client
+	exchangeURL string
+	credentials func() (string, string, error)
+}
+
+type exchangeSecret struct {
+	Value *string `json:"value"`
+}
+
+func (p *exchangeSecretsProvider) Name() string {
+	return config.SECRETS_PROVIDER_EXCHANGE
+}
+
+func (p *exchangeSecretsProvider) Secret(name string) (string, error) {
+	id, token, err := p.credentials()
+	if err != nil {
+		return "", err
+	}
+	org, node := id, ""
+	if ix := strings.Index(id, "/"); ix != -1 {
+		org, node = id[:ix], id[ix+1:]
+	}
+	req, err := http.NewRequest("GET", fmt.Sprintf("%vorgs/%v/nodes/%v/secrets/%v", p.exchangeURL, org, node, name), nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(id, token)
+
+	secret := new(exchangeSecret)
+	if err := getSecret(p.httpClient(nil), req, secret); err != nil {
+		return "", err
+	} else if secret.Value == nil {
+		return "", errors.New("the exchange response has no value")
+	}
+	return *secret.Value, nil
+}
+
+// Secrets in the KV version 2 secrets engine of a HashiCorp Vault server, at {mount}/data/{name}. The value of the
+// secret is the value key of the secret's data.
+type vaultSecretsProvider struct {
+	httpClient func(overrideTimeoutS *uint) *http.Client
+	addr       string
+	mount      string
+	tokenFile  string
+}
+
+type vaultSecret struct {
+	Data struct {
+		Data map[string]interface{} `json:"data"`
+	} `json:"data"`
+}
+
+func (p *vaultSecretsProvider) Name() string {
+	return config.SECRETS_PROVIDER_VAULT
+}
+
+func (p *vaultSecretsProvider) Secret(name string) (string, error) {
+	token, err := ioutil.ReadFile(p.tokenFile)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("unable to read the vault token, error: %v", err))
+	}
+	req, err := http.NewRequest("GET", fmt.Sprintf("%v/v1/%v/data/%v", p.addr, p.mount, name), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", strings.TrimSpace(string(token)))
+
+	secret := new(vaultSecret)
+	if err := getSecret(p.httpClient(nil), req, secret); err != nil {
+		return "", err
+	} else if value, ok := secret.Data.Data["value"]; !ok {
+		return "", errors.New("the vault secret has no value key")
+	} else if s, ok := value.(string); !ok {
+		return "", errors.New("the value of the vault secret is not a string")
+	} else {
+		return s, nil
+	}
+}
+
+// Sends the request and unmarshals the response into secret. The response body is left out of the errors, it could
+// hold the secret.
+func getSecret(httpClient *http.Client, req *http.Request, secret interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return errors.New(fmt.Sprintf("unable to get %v, error: %v", req.URL, err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errors.New(fmt.Sprintf("secret not found at %v", req.URL))
+	} else if resp.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprintf("unable to get %v, HTTP status %v", req.URL, resp.StatusCode))
+	} else if bytes, err := ioutil.ReadAll(resp.Body); err != nil {
+		return errors.New(fmt.Sprintf("unable to read the response from %v, error: %v", req.URL, err))
+	} else if err := json.Unmarshal(bytes, secret); err != nil {
+		return errors.New(fmt.Sprintf("unable to parse the response from %v", req.URL))
+	}
+	return nil
+}
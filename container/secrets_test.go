@@ -0,0 +1,123 @@
+// +build unit
+
+package container
+
+import (
+	"github.com/open-horizon/anax/config"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func Test_fileSecretsProvider(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(path.Join(dir, "db-password"), []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.HorizonConfig{Edge: config.Config{Secrets: config.SecretsConfig{Provider: config.SECRETS_PROVIDER_FILE, Path: dir}}}
+	if p, err := NewSecretsProvider(cfg, nil); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if v, err := p.Secret("db-password"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if v != "s3cret" {
+		t.Errorf("wrong secret value %v", v)
+	} else if _, err := p.Secret("missing"); err == nil {
+		t.Errorf("expected an error for a missing secret")
+	}
+
+	cfg.Edge.Secrets.Provider = ""
+	if p, err := NewSecretsProvider(cfg, nil); err != nil || p != nil {
+		t.Errorf("expected no provider, got %v, error: %v", p, err)
+	}
+	cfg.Edge.Secrets.Provider = "keychain"
+	if _, err := NewSecretsProvider(cfg, nil); err == nil {
+		t.Errorf("expected an error for an unsupported provider")
+	}
+}
+
+func Test_exchangeSecretsProvider(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pw, _ := r.BasicAuth(); user != "myorg/node1" || pw != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+		} else if r.URL.Path == "/orgs/myorg/nodes/node1/secrets/db-password" {
+			w.Write([]byte(`{"value":"s3cret"}`))
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":"not found"}`))
+		}
+	}))
+	defer server.Close()
+
+	p := &exchangeSecretsProvider{
+		httpClient:  func(overrideTimeoutS *uint) *http.Client { return server.Client() },
+		exchangeURL: server.URL + "/",
+		credentials: func() (string, string, error) { return "myorg/node1", "token", nil },
+	}
+	if v, err := p.Secret("db-password"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if v != "s3cret" {
+		t.Errorf("wrong secret value %v", v)
+	} else if _, err := p.Secret("missing"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+}
+
+func Test_vaultSecretsProvider(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := path.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("vault-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-token" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+		} else if r.URL.Path == "/v1/secret/data/db-password" {
+			w.Write([]byte(`{"data":{"data":{"value":"s3cret"},"metadata":{"version":2}}}`))
+		} else if r.URL.Path == "/v1/secret/data/no-value" {
+			w.Write([]byte(`{"data":{"data":{"password":"s3cret"}}}`))
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+	defer server.Close()
+
+	p := &vaultSecretsProvider{
+		httpClient: func(overrideTimeoutS *uint) *http.Client { return server.Client() },
+		addr:       server.URL,
+		mount:      "secret",
+		tokenFile:  tokenFile,
+	}
+	if v, err := p.Secret("db-password"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if v != "s3cret" {
+		t.Errorf("wrong secret value %v", v)
+	} else if _, err := p.Secret("missing"); err == nil {
+		t.Errorf("expected an error for a missing secret")
+	} else if _, err := p.Secret("no-value"); err == nil || strings.Contains(err.Error(), "s3cret") {
+		t.Errorf("expected an error without the secret, got %v", err)
+	}
+
+	p.tokenFile = path.Join(dir, "missing")
+	if _, err := p.Secret("db-password"); err == nil {
+		t.Errorf("expected an error without a token")
+	}
+}
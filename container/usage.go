@@ -0,0 +1,87 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/events"
+	"time"
+)
+
+// The resources used by the workload containers of an agreement are sampled periodically, so that the node can tell
+// the agbot how heavily its workloads use the node.
+
+const STATS_TIMEOUT_S = 30
+
+// Take a sample of the resources used by the running containers of an agreement. Shared service containers are not
+// included because they are not used by a single agreement.
+func (b *ContainerWorker) sampleAgreementUsage(agreementId string) (events.ResourceUsage, error) {
+
+	usage := events.ResourceUsage{SampleTime: time.Now().Unix()}
+
+	containers, err := b.client.ListContainers(docker.ListContainersOptions{
+		Size: true,
+		Filters: map[string][]string{
+			"label":  []string{fmt.Sprintf("%v.agreement_id=%v", LABEL_PREFIX, agreementId)},
+			"status": []string{"running"},
+		},
+	})
+	if err != nil {
+		return usage, errors.New(fmt.Sprintf("unable to list containers, error %v", err))
+	}
+
+	for _, container := range containers {
+		if stats, err := b.containerStats(container.ID); err != nil {
+			glog.Warningf("Unable to get stats for container %v of agreement %v, error: %v", container.ID, agreementId, err)
+		} else if stats != nil {
+			addContainerUsage(&usage, stats, container.SizeRw)
+		}
+	}
+	return usage, nil
+}
+
+// Get a single set of stats for a container. Docker includes the CPU usage from a moment before, so the CPU
+// utilization can be calculated from a single sample.
+func (b *ContainerWorker) containerStats(id string) (*docker.Stats, error) {
+
+	statsC := make(chan *docker.Stats, 1)
+	errC := make(chan error, 1)
+	go func() {
+		errC <- b.client.Stats(docker.StatsOptions{
+			ID:      id,
+			Stats:   statsC,
+			Stream:  false,
+			Timeout: STATS_TIMEOUT_S * time.Second,
+		})
+	}()
+
+	// The stats channel is closed by the docker client when it is done.
+	var stats *docker.Stats
+	for s := range statsC {
+		stats = s
+	}
+	return stats, <-errC
+}
+
+// Add the resources used by a container to the usage of its agreement.
+func addContainerUsage(usage *events.ResourceUsage, stats *docker.Stats, sizeRw int64) {
+
+	const MB = 1024 * 1024
+
+	usage.Containers += 1
+
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemCPUUsage) - float64(stats.PreCPUStats.SystemCPUUsage)
+	if cpuDelta > 0 && systemDelta > 0 {
+		cpus := len(stats.CPUStats.CPUUsage.PercpuUsage)
+		if cpus == 0 {
+			cpus = 1
+		}
+		usage.CPUPercent += cpuDelta / systemDelta * float64(cpus) * 100
+	}
+
+	usage.MemoryMB += float64(stats.MemoryStats.Usage) / MB
+	usage.MemoryLimitMB += float64(stats.MemoryStats.Limit) / MB
+	usage.DiskMB += float64(sizeRw) / MB
+}
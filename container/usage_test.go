@@ -0,0 +1,39 @@
+// +build unit
+
+package container
+
+import (
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/open-horizon/anax/events"
+	"testing"
+)
+
+func Test_addContainerUsage(t *testing.T) {
+
+	stats := &docker.Stats{}
+	stats.CPUStats.CPUUsage.TotalUsage = 300
+	stats.CPUStats.CPUUsage.PercpuUsage = []uint64{150, 150}
+	stats.CPUStats.SystemCPUUsage = 2000
+	stats.PreCPUStats.CPUUsage.TotalUsage = 200
+	stats.PreCPUStats.SystemCPUUsage = 1000
+	stats.MemoryStats.Usage = 64 * 1024 * 1024
+	stats.MemoryStats.Limit = 512 * 1024 * 1024
+
+	usage := events.ResourceUsage{}
+	addContainerUsage(&usage, stats, 2*1024*1024)
+	if usage.Containers != 1 || usage.CPUPercent != 20 || usage.MemoryMB != 64 || usage.MemoryLimitMB != 512 || usage.DiskMB != 2 {
+		t.Errorf("wrong usage after the first container %v", usage)
+	}
+
+	// No system CPU time passed between the samples, so the CPU usage can't be calculated.
+	first := &docker.Stats{}
+	first.CPUStats.CPUUsage.TotalUsage = 100
+	first.CPUStats.SystemCPUUsage = 1000
+	first.PreCPUStats.SystemCPUUsage = 1000
+	first.MemoryStats.Usage = 16 * 1024 * 1024
+	first.MemoryStats.Limit = 512 * 1024 * 1024
+	addContainerUsage(&usage, first, 0)
+	if usage.Containers != 2 || usage.CPUPercent != 20 || usage.MemoryMB != 80 || usage.MemoryLimitMB != 1024 || usage.DiskMB != 2 {
+		t.Errorf("wrong usage after the second container %v", usage)
+	}
+}
@@ -0,0 +1,182 @@
+package containermessage
+
+import (
+	"errors"
+	"fmt"
+	docker "github.com/fsouza/go-dockerclient"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// A host device that is passed through to the container. The container path defaults to the host path and the
+// cgroup permissions to "rwm". The node does not accept an agreement when a device that is not optional is missing
+// from the host, an optional device is left out of the container when it is missing.
+type DeviceMapping struct {
+	HostPath      string `json:"host_path"`
+	ContainerPath string `json:"container_path,omitempty"`
+	Permissions   string `json:"permissions,omitempty"`
+	Optional      bool   `json:"optional,omitempty"`
+}
+
+func (d DeviceMapping) String() string {
+	return fmt.Sprintf("HostPath: %v, ContainerPath: %v, Permissions: %v, Optional: %v", d.HostPath, d.ContainerPath, d.Permissions, d.Optional)
+}
+
+// The GPUs a container needs. The GPUs are passed through as the device files of the vendor's driver, the first Count
+// GPUs of the host are used.
+type GPURequest struct {
+	Vendor string `json:"vendor"`
+	Count  int    `json:"count"`
+}
+
+func (g GPURequest) String() string {
+	return fmt.Sprintf("Vendor: %v, Count: %v", g.Vendor, g.Count)
+}
+
+const GPU_VENDOR_NVIDIA = "nvidia"
+
+// The device files of each GPU vendor, the control devices that every container with a GPU needs and the device of
+// the GPU with a given index.
+var gpuControlDevices = map[string][]string{
+	GPU_VENDOR_NVIDIA: []string{"/dev/nvidiactl", "/dev/nvidia-uvm"},
+}
+
+var gpuDevice = map[string]func(index int) string{
+	GPU_VENDOR_NVIDIA: func(index int) string { return fmt.Sprintf("/dev/nvidia%v", index) },
+}
+
+// Returns an error when the device declarations of the service are not valid.
+func (s *Service) ValidateDevices() error {
+	for _, d := range s.Devices {
+		if sp := strings.Split(d, ":"); len(sp) < 2 || len(sp) > 3 || sp[0] == "" || sp[1] == "" {
+			return errors.New(fmt.Sprintf("device %v must be in the form host_path:container_path[:permissions]", d))
+		}
+	}
+	for _, d := range s.DeviceMappings {
+		if !path.IsAbs(d.HostPath) {
+			return errors.New(fmt.Sprintf("device host_path %v must be an absolute path", d.HostPath))
+		} else if d.ContainerPath != "" && !path.IsAbs(d.ContainerPath) {
+			return errors.New(fmt.Sprintf("device container_path %v must be an absolute path", d.ContainerPath))
+		} else if strings.Trim(d.Permissions, "rwm") != "" {
+			return errors.New(fmt.Sprintf("device %v permissions %v must be made of r, w and m", d.HostPath, d.Permissions))
+		}
+	}
+	if s.GPU != nil {
+		if _, ok := gpuDevice[s.GPU.Vendor]; !ok {
+			return errors.New(fmt.Sprintf("gpu vendor %v is not supported, it must be %v", s.GPU.Vendor, GPU_VENDOR_NVIDIA))
+		} else if s.GPU.Count < 1 {
+			return errors.New(fmt.Sprintf("gpu count must be at least 1"))
+		}
+	}
+	return nil
+}
+
+// Returns the host paths of the devices the service needs, the devices that are not optional.
+func (s *Service) RequiredDevices() []string {
+	devices := make([]string, 0)
+	for _, d := range s.Devices {
+		devices = append(devices, strings.Split(d, ":")[0])
+	}
+	for _, d := range s.DeviceMappings {
+		if !d.Optional {
+			devices = append(devices, d.HostPath)
+		}
+	}
+	if s.GPU != nil {
+		devices = append(devices, gpuControlDevices[s.GPU.Vendor]...)
+		if f, ok := gpuDevice[s.GPU.Vendor]; ok {
+			for i := 0; i < s.GPU.Count; i++ {
+				devices = append(devices, f(i))
+			}
+		}
+	}
+	return devices
+}
+
+// Returns the docker devices of the service. Optional devices that are missing from the host are left out. It is an
+// error when a device the service needs is missing.
+func (s *Service) DockerDevices(exists func(hostPath string) bool) ([]docker.Device, error) {
+
+	if err := s.ValidateDevices(); err != nil {
+		return nil, err
+	} else if missing := missingDevices(s.RequiredDevices(), exists); len(missing) != 0 {
+		return nil, errors.New(fmt.Sprintf("devices %v are missing from the host", strings.Join(missing, ", ")))
+	}
+
+	devices := make([]docker.Device, 0)
+
+	// The format of device mapping is: <host device name>:<contianer device name>:<cgroup permission>
+	// the cgoup permission can be omitted. It defaults to "rwm" when omitted.
+	for _, d := range s.Devices {
+		cgp := "rwm"
+		sp := strings.Split(d, ":")
+		if len(sp) == 3 {
+			cgp = sp[2]
+		}
+		devices = append(devices, docker.Device{PathOnHost: sp[0], PathInContainer: sp[1], CgroupPermissions: cgp})
+	}
+
+	for _, d := range s.DeviceMappings {
+		if d.Optional && !exists(d.HostPath) {
+			continue
+		}
+		dev := docker.Device{PathOnHost: d.HostPath, PathInContainer: d.ContainerPath, CgroupPermissions: d.Permissions}
+		if dev.PathInContainer == "" {
+			dev.PathInContainer = d.HostPath
+		}
+		if dev.CgroupPermissions == "" {
+			dev.CgroupPermissions = "rwm"
+		}
+		devices = append(devices, dev)
+	}
+
+	if s.GPU != nil {
+		gpus := append([]string{}, gpuControlDevices[s.GPU.Vendor]...)
+		for i := 0; i < s.GPU.Count; i++ {
+			gpus = append(gpus, gpuDevice[s.GPU.Vendor](i))
+		}
+		for _, g := range gpus {
+			devices = append(devices, docker.Device{PathOnHost: g, PathInContainer: g, CgroupPermissions: "rwm"})
+		}
+	}
+
+	return devices, nil
+}
+
+// Returns the host paths of the devices that the services of the deployment need and that are missing from the host,
+// sorted and without duplicates.
+func (d DeploymentDescription) MissingDevices(exists func(hostPath string) bool) []string {
+	required := make([]string, 0)
+	for _, service := range d.Services {
+		if service != nil {
+			required = append(required, service.RequiredDevices()...)
+		}
+	}
+	return missingDevices(required, exists)
+}
+
+func missingDevices(required []string, exists func(hostPath string) bool) []string {
+	found := make(map[string]bool)
+	for _, dev := range required {
+		if _, ok := found[dev]; !ok {
+			found[dev] = exists(dev)
+		}
+	}
+
+	missing := make([]string, 0)
+	for dev, ok := range found {
+		if !ok {
+			missing = append(missing, dev)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// Returns true when the device exists on the host.
+func HostDeviceExists(hostPath string) bool {
+	_, err := os.Stat(hostPath)
+	return err == nil
+}
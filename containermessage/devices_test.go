@@ -0,0 +1,64 @@
+// +build unit
+
+package containermessage
+
+import (
+	docker "github.com/fsouza/go-dockerclient"
+	"reflect"
+	"testing"
+)
+
+func Test_ValidateDevices(t *testing.T) {
+
+	valid := Service{
+		Devices:        []string{"/dev/bus/usb/001/001:/dev/bus/usb/001/001", "/dev/video0:/dev/video0:rw"},
+		DeviceMappings: []DeviceMapping{{HostPath: "/dev/video1", ContainerPath: "/dev/cam", Permissions: "r"}},
+		GPU:            &GPURequest{Vendor: GPU_VENDOR_NVIDIA, Count: 1},
+	}
+	if err := valid.ValidateDevices(); err != nil {
+		t.Errorf("service %v should be valid: %v", valid, err)
+	}
+
+	for _, s := range []Service{
+		{Devices: []string{"/dev/video0"}},
+		{DeviceMappings: []DeviceMapping{{HostPath: "video0"}}},
+		{DeviceMappings: []DeviceMapping{{HostPath: "/dev/video0", ContainerPath: "cam"}}},
+		{DeviceMappings: []DeviceMapping{{HostPath: "/dev/video0", Permissions: "rx"}}},
+		{GPU: &GPURequest{Vendor: "other", Count: 1}},
+		{GPU: &GPURequest{Vendor: GPU_VENDOR_NVIDIA}},
+	} {
+		if err := s.ValidateDevices(); err == nil {
+			t.Errorf("service %v should not be valid", s)
+		}
+	}
+}
+
+// Optional devices that are missing are left out of the container, missing devices that are not optional are an error.
+func Test_DockerDevices(t *testing.T) {
+
+	host := map[string]bool{"/dev/video0": true, "/dev/nvidiactl": true, "/dev/nvidia-uvm": true, "/dev/nvidia0": true}
+	exists := func(hostPath string) bool { return host[hostPath] }
+
+	s := Service{
+		Devices:        []string{"/dev/video0:/dev/video0:r"},
+		DeviceMappings: []DeviceMapping{{HostPath: "/dev/video0", ContainerPath: "/dev/cam"}, {HostPath: "/dev/ttyUSB0", Optional: true}},
+		GPU:            &GPURequest{Vendor: GPU_VENDOR_NVIDIA, Count: 1},
+	}
+	expected := []docker.Device{
+		{PathOnHost: "/dev/video0", PathInContainer: "/dev/video0", CgroupPermissions: "r"},
+		{PathOnHost: "/dev/video0", PathInContainer: "/dev/cam", CgroupPermissions: "rwm"},
+		{PathOnHost: "/dev/nvidiactl", PathInContainer: "/dev/nvidiactl", CgroupPermissions: "rwm"},
+		{PathOnHost: "/dev/nvidia-uvm", PathInContainer: "/dev/nvidia-uvm", CgroupPermissions: "rwm"},
+		{PathOnHost: "/dev/nvidia0", PathInContainer: "/dev/nvidia0", CgroupPermissions: "rwm"},
+	}
+	if devices, err := s.DockerDevices(exists); err != nil {
+		t.Errorf("unable to get devices: %v", err)
+	} else if !reflect.DeepEqual(devices, expected) {
+		t.Errorf("devices should be %v, are %v", expected, devices)
+	}
+
+	s.DeviceMappings[1].Optional = false
+	if _, err := s.DockerDevices(exists); err == nil {
+		t.Errorf("missing device should be an error")
+	}
+}
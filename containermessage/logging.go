@@ -0,0 +1,133 @@
+package containermessage
+
+import (
+	"errors"
+	"fmt"
+	docker "github.com/fsouza/go-dockerclient"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Where the logs of a container go. The node starts the containers with the docker syslog log driver, tagged with the
+// agreement and the service name, unless the service chooses another log driver or a remote syslog endpoint. The tag
+// is always set by the node for the drivers that have one, so that the logs of a workload can be told apart.
+type LogConfig struct {
+	Driver        string            `json:"driver,omitempty"`         // The docker log driver, syslog when empty
+	Options       map[string]string `json:"options,omitempty"`        // The options of the log driver, as in docker run --log-opt
+	MaxSize       string            `json:"max_size,omitempty"`       // The size at which the log file is rotated, e.g. 10m, for the json-file and local drivers
+	SyslogAddress string            `json:"syslog_address,omitempty"` // The remote syslog endpoint, e.g. udp://logs.example.com:514, for the syslog driver
+}
+
+func (l LogConfig) String() string {
+	return fmt.Sprintf("Driver: %v, Options: %v, MaxSize: %v, SyslogAddress: %v", l.Driver, l.Options, l.MaxSize, l.SyslogAddress)
+}
+
+const DEFAULT_LOG_DRIVER = "syslog"
+
+// The log drivers that come with docker, and whether they take a tag option. Log driver plugins are named like
+// images (e.g. grafana/loki-docker-driver:latest) and are passed to docker as they are.
+var logDriverTags = map[string]bool{
+	"syslog":    true,
+	"journald":  true,
+	"fluentd":   true,
+	"gelf":      true,
+	"awslogs":   true,
+	"splunk":    true,
+	"json-file": false,
+	"local":     false,
+	"none":      false,
+}
+
+var logMaxSize = regexp.MustCompile(`^[0-9]+[kmg]?$`)
+
+// The options that have a field of their own, or that the node sets.
+var reservedLogOptions = map[string]string{
+	"max-size":       "max_size",
+	"syslog-address": "syslog_address",
+	"tag":            "",
+}
+
+func (l *LogConfig) driver() string {
+	if l.Driver == "" {
+		return DEFAULT_LOG_DRIVER
+	}
+	return l.Driver
+}
+
+func isLogDriverPlugin(driver string) bool {
+	return strings.ContainsAny(driver, "/:")
+}
+
+// Returns an error when the log configuration is not valid.
+func (l *LogConfig) Validate() error {
+
+	driver := l.driver()
+	if _, ok := logDriverTags[driver]; !ok && !isLogDriverPlugin(driver) {
+		return errors.New(fmt.Sprintf("log driver %v is not supported, it must be one of the docker log drivers or a log driver plugin", driver))
+	}
+
+	for name, _ := range l.Options {
+		if name == "" {
+			return errors.New(fmt.Sprintf("log options must have a name"))
+		} else if field, ok := reservedLogOptions[name]; ok && field != "" {
+			return errors.New(fmt.Sprintf("log option %v must be set with %v", name, field))
+		} else if ok {
+			return errors.New(fmt.Sprintf("log option %v is set by the node", name))
+		}
+	}
+
+	if l.MaxSize != "" {
+		if driver != "json-file" && driver != "local" {
+			return errors.New(fmt.Sprintf("max_size can only be used with the json-file and local log drivers, not %v", driver))
+		} else if !logMaxSize.MatchString(l.MaxSize) {
+			return errors.New(fmt.Sprintf("max_size %v must be a number, optionally followed by k, m or g", l.MaxSize))
+		}
+	}
+
+	if l.SyslogAddress != "" {
+		if driver != "syslog" {
+			return errors.New(fmt.Sprintf("syslog_address can only be used with the syslog log driver, not %v", driver))
+		} else if u, err := url.Parse(l.SyslogAddress); err != nil {
+			return errors.New(fmt.Sprintf("syslog_address %v is not valid: %v", l.SyslogAddress, err))
+		} else if u.Scheme == "unix" || u.Scheme == "unixgram" {
+			if u.Path == "" {
+				return errors.New(fmt.Sprintf("syslog_address %v must have a socket path", l.SyslogAddress))
+			}
+		} else if u.Scheme != "udp" && u.Scheme != "tcp" && u.Scheme != "tcp+tls" {
+			return errors.New(fmt.Sprintf("syslog_address %v must start with udp://, tcp://, tcp+tls://, unix:// or unixgram://", l.SyslogAddress))
+		} else if u.Host == "" {
+			return errors.New(fmt.Sprintf("syslog_address %v must have a host", l.SyslogAddress))
+		}
+	}
+	return nil
+}
+
+// Returns the docker log configuration of a container of the service, with the tag the node gives the container.
+func (s *Service) DockerLogConfig(tag string) (docker.LogConfig, error) {
+
+	if s.Logging == nil {
+		return docker.LogConfig{
+			Type:   DEFAULT_LOG_DRIVER,
+			Config: map[string]string{"tag": tag},
+		}, nil
+	} else if err := s.Logging.Validate(); err != nil {
+		return docker.LogConfig{}, err
+	}
+
+	driver := s.Logging.driver()
+	config := make(map[string]string)
+	for name, value := range s.Logging.Options {
+		config[name] = value
+	}
+	if s.Logging.MaxSize != "" {
+		config["max-size"] = s.Logging.MaxSize
+	}
+	if s.Logging.SyslogAddress != "" {
+		config["syslog-address"] = s.Logging.SyslogAddress
+	}
+	if logDriverTags[driver] {
+		config["tag"] = tag
+	}
+	return docker.LogConfig{Type: driver, Config: config}, nil
+}
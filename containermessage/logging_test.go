@@ -0,0 +1,69 @@
+// +build unit
+
+package containermessage
+
+import (
+	docker "github.com/fsouza/go-dockerclient"
+	"reflect"
+	"testing"
+)
+
+func Test_ValidateLogging(t *testing.T) {
+
+	for _, l := range []LogConfig{
+		{},
+		{SyslogAddress: "udp://logs.example.com:514", Options: map[string]string{"syslog-facility": "daemon"}},
+		{Driver: "syslog", SyslogAddress: "unix:///dev/log"},
+		{Driver: "json-file", MaxSize: "10m", Options: map[string]string{"max-file": "3"}},
+		{Driver: "grafana/loki-docker-driver:latest", Options: map[string]string{"loki-url": "http://loki:3100"}},
+	} {
+		if err := l.Validate(); err != nil {
+			t.Errorf("log config %v should be valid: %v", l, err)
+		}
+	}
+
+	for _, l := range []LogConfig{
+		{Driver: "logstash"},
+		{Driver: "journald", MaxSize: "10m"},
+		{Driver: "local", MaxSize: "10 MB"},
+		{Driver: "gelf", SyslogAddress: "udp://logs:514"},
+		{SyslogAddress: "http://logs:514"},
+		{SyslogAddress: "udp://"},
+		{Options: map[string]string{"syslog-address": "udp://logs:514"}},
+		{Options: map[string]string{"tag": "mine"}},
+	} {
+		if err := l.Validate(); err == nil {
+			t.Errorf("log config %v should not be valid", l)
+		}
+	}
+}
+
+func Test_DockerLogConfig(t *testing.T) {
+
+	s := Service{}
+	if lc, err := s.DockerLogConfig("workload-ag1_ns"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if expected := (docker.LogConfig{Type: "syslog", Config: map[string]string{"tag": "workload-ag1_ns"}}); !reflect.DeepEqual(lc, expected) {
+		t.Errorf("expected %v, got %v", expected, lc)
+	}
+
+	s.Logging = &LogConfig{SyslogAddress: "tcp://logs:514", Options: map[string]string{"syslog-format": "rfc5424"}}
+	if lc, err := s.DockerLogConfig("workload-ag1_ns"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if expected := (docker.LogConfig{Type: "syslog", Config: map[string]string{"tag": "workload-ag1_ns", "syslog-address": "tcp://logs:514", "syslog-format": "rfc5424"}}); !reflect.DeepEqual(lc, expected) {
+		t.Errorf("expected %v, got %v", expected, lc)
+	}
+
+	// The json-file driver has no tag.
+	s.Logging = &LogConfig{Driver: "json-file", MaxSize: "5m"}
+	if lc, err := s.DockerLogConfig("workload-ag1_ns"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if expected := (docker.LogConfig{Type: "json-file", Config: map[string]string{"max-size": "5m"}}); !reflect.DeepEqual(lc, expected) {
+		t.Errorf("expected %v, got %v", expected, lc)
+	}
+
+	s.Logging = &LogConfig{Driver: "logstash"}
+	if _, err := s.DockerLogConfig("workload-ag1_ns"); err == nil {
+		t.Errorf("expected an error for log driver logstash")
+	}
+}
@@ -126,8 +126,12 @@ type Service struct {
 	CapAdd           []string             `json:"cap_add,omitempty"`
 	Command          []string             `json:"command,omitempty"`
 	Devices          []string             `json:"devices,omitempty"`
+	DeviceMappings   []DeviceMapping      `json:"device_mappings,omitempty"` // Structured device passthrough, the node checks the devices exist
+	GPU              *GPURequest          `json:"gpu,omitempty"`
 	Ports            []Port               `json:"ports,omitempty"`
 	NetworkIsolation *NetworkIsolation    `json:"network_isolation,omitempty"` // Changed to pointer so that the hzn dev CLI doesnt generate this struct into the deployment config skeleton
+	Logging          *LogConfig           `json:"logging,omitempty"`           // Where the logs of the container go, syslog on the node when omitted
+	Secrets          []SecretReference    `json:"secrets,omitempty"`           // Secrets from the node's secrets provider, injected when the container starts
 	Binds            []string             `json:"binds,omitempty"`             // Only used by infrastructure containers
 	SpecificPorts    []docker.PortBinding `json:"specific_ports,omitempty"`    // Only used by infrastructure containers
 }
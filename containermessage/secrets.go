@@ -0,0 +1,72 @@
+package containermessage
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// A secret that the container needs, by name. The value is never part of the deployment, the node gets it from its
+// secrets provider when the container is started and sets it in the container's environment. The name of the
+// environment variable defaults to the secret name in upper case, with the dashes and dots replaced by underscores.
+type SecretReference struct {
+	Name string `json:"name"`
+	Env  string `json:"env,omitempty"`
+}
+
+func (s SecretReference) String() string {
+	return fmt.Sprintf("Name: %v, Env: %v", s.Name, s.Env)
+}
+
+// Secret names are used as file names and in URL paths by the secrets providers, so they are kept simple.
+var secretName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+var envVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// The name of the environment variable that holds the secret.
+func (s SecretReference) EnvName() string {
+	if s.Env != "" {
+		return s.Env
+	}
+	return strings.NewReplacer("-", "_", ".", "_").Replace(strings.ToUpper(s.Name))
+}
+
+// Returns an error when the secret declarations of the service are not valid.
+func (s *Service) ValidateSecrets() error {
+	envs := make(map[string]string)
+	for _, sec := range s.Secrets {
+		if !secretName.MatchString(sec.Name) {
+			return errors.New(fmt.Sprintf("secret name '%v' must start with a letter or digit and contain only letters, digits, _, - and .", sec.Name))
+		}
+		env := sec.EnvName()
+		if !envVarName.MatchString(env) {
+			return errors.New(fmt.Sprintf("secret %v: env '%v' is not a valid environment variable name", sec.Name, env))
+		} else if other, ok := envs[env]; ok {
+			return errors.New(fmt.Sprintf("secrets %v and %v are both set in environment variable %v", other, sec.Name, env))
+		}
+		envs[env] = sec.Name
+		for _, e := range s.Environment {
+			if strings.SplitN(e, "=", 2)[0] == env {
+				return errors.New(fmt.Sprintf("secret %v: environment variable %v is also set in environment", sec.Name, env))
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the environment variables, NAME=value, that hold the secrets of the service. The values come from the
+// lookup function, which returns an error when the secret can't be read. The values must not be logged or stored.
+func (s *Service) SecretEnv(lookup func(name string) (string, error)) ([]string, error) {
+	if err := s.ValidateSecrets(); err != nil {
+		return nil, err
+	}
+	env := make([]string, 0, len(s.Secrets))
+	for _, sec := range s.Secrets {
+		if value, err := lookup(sec.Name); err != nil {
+			return nil, errors.New(fmt.Sprintf("unable to get secret %v, error: %v", sec.Name, err))
+		} else {
+			env = append(env, fmt.Sprintf("%v=%v", sec.EnvName(), value))
+		}
+	}
+	return env, nil
+}
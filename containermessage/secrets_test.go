@@ -0,0 +1,62 @@
+// +build unit
+
+package containermessage
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func Test_ValidateSecrets(t *testing.T) {
+
+	s := &Service{
+		Environment: []string{"MODE=prod"},
+		Secrets:     []SecretReference{{Name: "db-password"}, {Name: "api.key", Env: "MY_KEY"}, {Name: "token"}},
+	}
+	if err := s.ValidateSecrets(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for _, secrets := range [][]SecretReference{
+		{{Name: ""}},
+		{{Name: "../passwd"}},
+		{{Name: "a/b"}},
+		{{Name: "key", Env: "1KEY"}},
+		{{Name: "key", Env: "MY-KEY"}},
+		{{Name: "db-password"}, {Name: "db.password"}},
+		{{Name: "mode"}},
+	} {
+		s.Secrets = secrets
+		if err := s.ValidateSecrets(); err == nil {
+			t.Errorf("secrets %v should not be valid", secrets)
+		}
+	}
+}
+
+func Test_SecretEnv(t *testing.T) {
+
+	values := map[string]string{"db-password": "s3cret", "api.key": "a=b"}
+	lookup := func(name string) (string, error) {
+		if v, ok := values[name]; ok {
+			return v, nil
+		}
+		return "", errors.New("secret not found")
+	}
+
+	s := &Service{Secrets: []SecretReference{{Name: "db-password"}, {Name: "api.key", Env: "MY_KEY"}}}
+	if env, err := s.SecretEnv(lookup); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !reflect.DeepEqual(env, []string{"DB_PASSWORD=s3cret", "MY_KEY=a=b"}) {
+		t.Errorf("wrong environment %v", env)
+	}
+
+	s.Secrets = append(s.Secrets, SecretReference{Name: "missing"})
+	if _, err := s.SecretEnv(lookup); err == nil {
+		t.Errorf("expected an error for a missing secret")
+	}
+
+	if env, err := (&Service{}).SecretEnv(lookup); err != nil || len(env) != 0 {
+		t.Errorf("expected no environment, got %v, error: %v", env, err)
+	}
+}
@@ -231,7 +231,8 @@ func MakeMSInstanceKey(specRef string, v string, id string) string {
 
 // This function parsed the given image name to disfferent parts. The image name has the following format:
 // [[repo][:port]/][somedir/]image[:tag][@digest]
-// If the image path as an improper form (we could not parse it), path will be empty.
+// If the image path as an improper form (we could not parse it), path will be empty. The parts are not validated,
+// use ParseImageReference to parse and validate an image reference.
 func ParseDockerImagePath(imagePath string) (domain, path, tag, digest string) {
 	// image names can be domain.com/dir/dir:tag  or  domain.com/dir/dir@sha256:ac88f4...  or  domain.com/dir/dir:tag@sha256:ac88f4...
 	reDigest := regexp.MustCompile(`^(\S*)@(\S+)$`)
@@ -265,7 +266,7 @@ func ParseDockerImagePath(imagePath string) (domain, path, tag, digest string) {
 
 	domain = matches[1]
 	// An image in docker hub has no domain, the chars before the 1st / are part of the path
-	if !strings.ContainsAny(domain, ".:") {
+	if !strings.ContainsAny(domain, ".:") && (domain != "localhost" || path == "") {
 		path = domain + path
 		domain = ""
 	} else {
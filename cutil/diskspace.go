@@ -0,0 +1,90 @@
+package cutil
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The node watches the free space of the file systems that hold the data it manages: its database, the service
+// storage and the docker images. When image pulls fill a disk, the database can be corrupted by a write that fails
+// half way. So while the free space of any of them is below the minimum, the disk space is low and the node rejects
+// new agreements, which would pull more images. The governance worker checks the free space periodically, see
+// UpdateDiskSpaceState.
+
+type FileSystemSpace struct {
+	Path   string `json:"path"`
+	FreeMB uint64 `json:"free_mb"`
+}
+
+type DiskSpaceState struct {
+	Low         bool              `json:"low"`                 // new agreements are rejected
+	LowSince    int64             `json:"low_since,omitempty"` // when the disk space became low
+	Warning     bool              `json:"warning"`             // the free space of a file system is below the warning threshold
+	FileSystems []FileSystemSpace `json:"file_systems"`        // the free space found by the last check
+	LastCheck   int64             `json:"last_check,omitempty"`
+}
+
+func (s DiskSpaceState) String() string {
+	return fmt.Sprintf("Low: %v, LowSince: %v, Warning: %v, FileSystems: %v, LastCheck: %v", s.Low, s.LowSince, s.Warning, s.FileSystems, s.LastCheck)
+}
+
+var diskSpaceLock sync.Mutex
+var diskSpaceState = DiskSpaceState{FileSystems: []FileSystemSpace{}}
+
+// Returns true while the disk space is low.
+func DiskSpaceLow() bool {
+	diskSpaceLock.Lock()
+	defer diskSpaceLock.Unlock()
+	return diskSpaceState.Low
+}
+
+func GetDiskSpaceState() DiskSpaceState {
+	diskSpaceLock.Lock()
+	defer diskSpaceLock.Unlock()
+	return diskSpaceState
+}
+
+// The free space in MB of the file system containing the path, as available to anax.
+func FreeDiskMB(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return st.Bavail * uint64(st.Bsize) / (1024 * 1024), nil
+}
+
+// Check the free space of the file systems containing the paths against the thresholds, either of which can be zero to
+// turn it off. Paths that can't be checked are returned with their errors and don't change the state. Returns the new
+// state, and true when the disk space became low or stopped being low with this check.
+func UpdateDiskSpaceState(paths []string, warnFreeMB uint64, minFreeMB uint64) (DiskSpaceState, bool, map[string]error) {
+	fileSystems := make([]FileSystemSpace, 0, len(paths))
+	errs := make(map[string]error)
+	low, warning := false, false
+	for _, path := range paths {
+		if free, err := FreeDiskMB(path); err != nil {
+			errs[path] = err
+		} else {
+			fileSystems = append(fileSystems, FileSystemSpace{Path: path, FreeMB: free})
+			low = low || (minFreeMB != 0 && free < minFreeMB)
+			warning = warning || (warnFreeMB != 0 && free < warnFreeMB)
+		}
+	}
+
+	diskSpaceLock.Lock()
+	defer diskSpaceLock.Unlock()
+
+	now := time.Now().Unix()
+	changed := low != diskSpaceState.Low
+	if changed && low {
+		diskSpaceState.LowSince = now
+	} else if changed {
+		diskSpaceState.LowSince = 0
+	}
+	diskSpaceState.Low = low
+	diskSpaceState.Warning = warning
+	diskSpaceState.FileSystems = fileSystems
+	diskSpaceState.LastCheck = now
+	return diskSpaceState, changed, errs
+}
@@ -0,0 +1,43 @@
+// +build unit
+
+package cutil
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+)
+
+func Test_UpdateDiskSpaceState(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "diskspace")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// With thresholds that the free space can't be below, the disk space is not low.
+	if state, changed, errs := UpdateDiskSpaceState([]string{dir}, 0, 1); len(errs) != 0 {
+		t.Errorf("unexpected errors checking disk space: %v", errs)
+	} else if state.Low || state.Warning || changed || DiskSpaceLow() {
+		t.Errorf("disk space should not be low: %v, changed %v", state, changed)
+	} else if len(state.FileSystems) != 1 || state.FileSystems[0].Path != dir || state.LastCheck == 0 {
+		t.Errorf("state should have the checked file system: %v", state)
+	}
+
+	// Thresholds that the free space is always below make the disk space low once.
+	if state, changed, _ := UpdateDiskSpaceState([]string{dir}, math.MaxUint64, math.MaxUint64); !state.Low || !state.Warning || !changed || state.LowSince == 0 || !DiskSpaceLow() {
+		t.Errorf("disk space should have become low: %v, changed %v", state, changed)
+	} else if state, changed, _ := UpdateDiskSpaceState([]string{dir}, 0, math.MaxUint64); !state.Low || state.Warning || changed {
+		t.Errorf("disk space should still be low: %v, changed %v", state, changed)
+	}
+
+	// A path that can't be checked is returned with its error and does not change the state.
+	missing := dir + "/missing"
+	if state, changed, errs := UpdateDiskSpaceState([]string{missing}, 0, math.MaxUint64); errs[missing] == nil {
+		t.Errorf("missing path should have an error")
+	} else if state.Low || !changed || state.LowSince != 0 || len(state.FileSystems) != 0 {
+		t.Errorf("disk space should not be low without a file system to check: %v, changed %v", state, changed)
+	}
+}
@@ -0,0 +1,108 @@
+package cutil
+
+import (
+	"fmt"
+)
+
+// The image fetch descriptor tells the node how to fetch the docker images of a deployment. It replaces the
+// deprecated torrent field of workload, microservice and service definitions, which could only point to an image
+// server package. The images are always pulled from docker registries, the descriptor adds:
+//   - mirrors, registries that have copies of the images, tried in order before the registry in the image name.
+//   - digests, the digest each image must have, so that a tag that is moved to another image is not followed.
+//   - auth hints, where the node gets the credentials for a registry.
+type ImageFetch struct {
+	Mirrors []string          `json:"mirrors,omitempty"` // host[:port] of the mirror registries
+	Digests map[string]string `json:"digests,omitempty"` // the digest of each image, keyed by the image name without its tag
+	Auth    []ImageAuthHint   `json:"auth,omitempty"`
+}
+
+// Where the node gets the credentials for a registry.
+const IMAGE_AUTH_EXCHANGE = "exchange" // the image auths of the org in the exchange, the node must trust them (TrustDockerAuthFromOrg)
+const IMAGE_AUTH_NODE = "node"         // the credentials configured on the node, in its attributes or docker config file
+const IMAGE_AUTH_NONE = "none"         // the registry is public, no credentials are sent
+
+type ImageAuthHint struct {
+	Registry string `json:"registry"`
+	Source   string `json:"source"`
+}
+
+func (h ImageAuthHint) String() string {
+	return fmt.Sprintf("Registry: %v, Source: %v", h.Registry, h.Source)
+}
+
+func (f ImageFetch) String() string {
+	return fmt.Sprintf("Mirrors: %v, Digests: %v, Auth: %v", f.Mirrors, f.Digests, f.Auth)
+}
+
+// Returns an error when the descriptor is not valid.
+func (f *ImageFetch) Validate() error {
+	for _, mirror := range f.Mirrors {
+		if msg := validateRegistry(mirror); msg != "" {
+			return fmt.Errorf("invalid mirror: %v", msg)
+		}
+	}
+	for image, digest := range f.Digests {
+		if ref, err := ParseImageReference(image); err != nil {
+			return err
+		} else if ref.Tag != "" || ref.Digest != "" {
+			return fmt.Errorf("the digest of image %v must be keyed by the image name without a tag or digest", image)
+		} else if !reImageDigest.MatchString(digest) {
+			return fmt.Errorf("the digest %v of image %v is not in the form algorithm:hex", digest, image)
+		}
+	}
+	registries := make(map[string]bool)
+	for _, hint := range f.Auth {
+		if msg := validateRegistry(hint.Registry); msg != "" {
+			return fmt.Errorf("invalid auth hint: %v", msg)
+		} else if hint.Source != IMAGE_AUTH_EXCHANGE && hint.Source != IMAGE_AUTH_NODE && hint.Source != IMAGE_AUTH_NONE {
+			return fmt.Errorf("the auth source of registry %v must be %v, %v or %v", hint.Registry, IMAGE_AUTH_EXCHANGE, IMAGE_AUTH_NODE, IMAGE_AUTH_NONE)
+		} else if registries[hint.Registry] {
+			return fmt.Errorf("registry %v has more than 1 auth hint", hint.Registry)
+		}
+		registries[hint.Registry] = true
+	}
+	return nil
+}
+
+// Returns the auth source of a registry, or the empty string when the descriptor has no hint for it. A nil
+// descriptor has no hints.
+func (f *ImageFetch) AuthSource(registry string) string {
+	if f == nil {
+		return ""
+	}
+	for _, hint := range f.Auth {
+		if hint.Registry == registry {
+			return hint.Source
+		}
+	}
+	return ""
+}
+
+// Returns the references to pull an image from, in the order they are tried: the mirrors, then the registry in the
+// image name. When the descriptor pins the image to a digest, every reference is pinned to it. It is an error when
+// the image is already pinned to a different digest. A nil descriptor returns only the image itself.
+func (f *ImageFetch) Candidates(ref *ImageReference) ([]ImageReference, error) {
+	if f == nil {
+		return []ImageReference{*ref}, nil
+	}
+
+	pinned := *ref
+	for image, digest := range f.Digests {
+		key, err := ParseImageReference(image)
+		if err != nil || key.Normalize().Name() != ref.Normalize().Name() {
+			continue
+		} else if ref.Digest != "" && ref.Digest != digest {
+			return nil, fmt.Errorf("image %v is pinned to digest %v in the image fetch descriptor", ref, digest)
+		}
+		pinned.Tag = ""
+		pinned.Digest = digest
+	}
+
+	candidates := make([]ImageReference, 0, len(f.Mirrors)+1)
+	for _, mirror := range f.Mirrors {
+		c := pinned.Normalize()
+		c.Registry = mirror
+		candidates = append(candidates, c)
+	}
+	return append(candidates, pinned), nil
+}
@@ -0,0 +1,76 @@
+// +build unit
+
+package cutil
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_ImageFetch_Validate(t *testing.T) {
+
+	valid := ImageFetch{
+		Mirrors: []string{"mirror.example.com:5000"},
+		Digests: map[string]string{"mydomain.com/x86_64/gps": testDigest},
+		Auth:    []ImageAuthHint{{Registry: "mydomain.com", Source: IMAGE_AUTH_EXCHANGE}, {Registry: "mirror.example.com:5000", Source: IMAGE_AUTH_NONE}},
+	}
+	assert.Nil(t, valid.Validate())
+
+	invalid := []ImageFetch{
+		{Mirrors: []string{"mirror.example.com/path"}},
+		{Digests: map[string]string{"mydomain.com/x86_64/gps:1.0": testDigest}},
+		{Digests: map[string]string{"mydomain.com/x86_64/gps": "1.0"}},
+		{Auth: []ImageAuthHint{{Registry: "mydomain.com", Source: "file"}}},
+		{Auth: []ImageAuthHint{{Registry: "mydomain.com", Source: IMAGE_AUTH_NODE}, {Registry: "mydomain.com", Source: IMAGE_AUTH_NONE}}},
+	}
+	for _, f := range invalid {
+		assert.NotNil(t, f.Validate(), "descriptor %v should not be valid", f)
+	}
+}
+
+// The mirrors are tried before the registry of the image, and every reference is pinned to the digest of the image.
+func Test_ImageFetch_Candidates(t *testing.T) {
+
+	ref, err := ParseImageReference("mydomain.com/x86_64/gps:1.0")
+	assert.Nil(t, err)
+
+	var none *ImageFetch
+	candidates, err := none.Candidates(ref)
+	assert.Nil(t, err)
+	assert.Equal(t, []ImageReference{*ref}, candidates)
+	assert.Equal(t, "", none.AuthSource("mydomain.com"))
+
+	f := &ImageFetch{
+		Mirrors: []string{"mirror1.example.com", "mirror2.example.com:5000"},
+		Digests: map[string]string{"mydomain.com/x86_64/gps": testDigest},
+		Auth:    []ImageAuthHint{{Registry: "mydomain.com", Source: IMAGE_AUTH_NODE}},
+	}
+	candidates, err = f.Candidates(ref)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"mirror1.example.com/x86_64/gps@" + testDigest,
+		"mirror2.example.com:5000/x86_64/gps@" + testDigest,
+		"mydomain.com/x86_64/gps@" + testDigest,
+	}, []string{candidates[0].String(), candidates[1].String(), candidates[2].String()})
+	assert.Equal(t, IMAGE_AUTH_NODE, f.AuthSource("mydomain.com"))
+	assert.Equal(t, "", f.AuthSource("mirror1.example.com"))
+
+	// Images of docker hub are matched in any of their forms, and keep their tag when they have no digest.
+	hub, err := ParseImageReference("busybox")
+	assert.Nil(t, err)
+	candidates, err = f.Candidates(hub)
+	assert.Nil(t, err)
+	assert.Equal(t, "mirror1.example.com/library/busybox:latest", candidates[0].String())
+	assert.Equal(t, "busybox", candidates[2].String())
+
+	f.Digests = map[string]string{"docker.io/library/busybox": testDigest}
+	candidates, err = f.Candidates(hub)
+	assert.Nil(t, err)
+	assert.Equal(t, "busybox@"+testDigest, candidates[2].String())
+
+	// An image pinned to another digest in the deployment can not be pulled.
+	pinned, err := ParseImageReference("busybox@sha256:0000000000000000000000000000000000000000000000000000000000000000")
+	assert.Nil(t, err)
+	_, err = f.Candidates(pinned)
+	assert.NotNil(t, err)
+}
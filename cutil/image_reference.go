@@ -0,0 +1,178 @@
+package cutil
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// A container image reference, as in [registry[:port]/]repository[:tag][@digest]. The grammar is the one docker and
+// the OCI distribution spec use. The registry is empty when the reference does not name one, which means docker hub.
+type ImageReference struct {
+	Registry   string // the host name or IP address of the registry, with its port if it has one
+	Repository string // the path of the image in the registry, made of lower case components separated by /
+	Tag        string
+	Digest     string // algorithm:hex, for example sha256:15315df0...
+}
+
+const DEFAULT_IMAGE_REGISTRY = "docker.io"
+const DEFAULT_IMAGE_TAG = "latest"
+const MAX_IMAGE_NAME_LENGTH = 255
+
+var (
+	reRegistryHost      = regexp.MustCompile(`^(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])(?:\.(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9]))*$`)
+	reRegistryIPv6      = regexp.MustCompile(`^\[[a-fA-F0-9:]+\]$`)
+	reRegistryPort      = regexp.MustCompile(`^[0-9]+$`)
+	rePathComponent     = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*$`)
+	reImageTag          = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	reImageDigest       = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,}$`)
+	dockerHubRegistries = []string{"docker.io", "index.docker.io", "registry-1.docker.io"}
+)
+
+// The error returned when an image reference cannot be parsed.
+type ImageReferenceError struct {
+	Reference string
+	Msg       string
+}
+
+func (e *ImageReferenceError) Error() string {
+	return fmt.Sprintf("invalid image reference '%v': %v", e.Reference, e.Msg)
+}
+
+func (r ImageReference) String() string {
+	s := r.Name()
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// The registry and repository of the image, without the tag and digest.
+func (r ImageReference) Name() string {
+	if r.Registry == "" {
+		return r.Repository
+	}
+	return r.Registry + "/" + r.Repository
+}
+
+// Returns true when the image is in docker hub.
+func (r ImageReference) IsDockerHub() bool {
+	if r.Registry == "" {
+		return true
+	}
+	for _, hub := range dockerHubRegistries {
+		if r.Registry == hub {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the canonical form of the reference, which names the registry, uses the library repository for the
+// official docker hub images, and has the latest tag when it has neither a tag nor a digest. Two references to the
+// same image have the same canonical form.
+func (r ImageReference) Normalize() ImageReference {
+	n := r
+	if n.IsDockerHub() {
+		n.Registry = DEFAULT_IMAGE_REGISTRY
+		if !strings.Contains(n.Repository, "/") {
+			n.Repository = "library/" + n.Repository
+		}
+	}
+	if n.Tag == "" && n.Digest == "" {
+		n.Tag = DEFAULT_IMAGE_TAG
+	}
+	return n
+}
+
+// Parse and validate an image reference. The registry is the part before the first / when it looks like a host,
+// i.e. it contains a . or a port, is localhost, or has upper case letters, which repositories can't have.
+func ParseImageReference(reference string) (*ImageReference, error) {
+	fail := func(msg string, args ...interface{}) (*ImageReference, error) {
+		return nil, &ImageReferenceError{Reference: reference, Msg: fmt.Sprintf(msg, args...)}
+	}
+
+	if reference == "" {
+		return fail("the reference is empty")
+	} else if strings.TrimSpace(reference) != reference || strings.ContainsAny(reference, " \t\n") {
+		return fail("the reference contains white space")
+	}
+
+	r := new(ImageReference)
+	name := reference
+	if ix := strings.Index(name, "@"); ix != -1 {
+		name, r.Digest = name[:ix], name[ix+1:]
+		if !reImageDigest.MatchString(r.Digest) {
+			return fail("the digest %v is not in the form algorithm:hex", r.Digest)
+		}
+	}
+	if ix := strings.LastIndex(name, ":"); ix != -1 && ix > strings.LastIndex(name, "/") {
+		name, r.Tag = name[:ix], name[ix+1:]
+		if !reImageTag.MatchString(r.Tag) {
+			return fail("the tag %v must be at most 128 letters, digits, _, . and -, not starting with . or -", r.Tag)
+		}
+	}
+	if name == "" {
+		return fail("the repository is missing")
+	} else if len(name) > MAX_IMAGE_NAME_LENGTH {
+		return fail("the name is longer than %v characters", MAX_IMAGE_NAME_LENGTH)
+	}
+
+	r.Repository = name
+	if ix := strings.Index(name, "/"); ix != -1 {
+		first := name[:ix]
+		if strings.ContainsAny(first, ".:") || first == "localhost" || strings.ToLower(first) != first {
+			r.Registry, r.Repository = first, name[ix+1:]
+			if err := validateRegistry(r.Registry); err != "" {
+				return fail("%v", err)
+			}
+		}
+	}
+
+	if r.Repository == "" {
+		return fail("the repository is missing")
+	}
+	for _, component := range strings.Split(r.Repository, "/") {
+		if !rePathComponent.MatchString(component) {
+			return fail("the repository component '%v' must be lower case letters and digits, separated by ., _, __ or -", component)
+		}
+	}
+	return r, nil
+}
+
+// Returns a description of what is wrong with the registry, or the empty string when it is valid.
+func validateRegistry(registry string) string {
+	host, port := registry, ""
+	if strings.HasPrefix(registry, "[") {
+		if ix := strings.Index(registry, "]"); ix != -1 {
+			host, port = registry[:ix+1], registry[ix+1:]
+			if port != "" && !strings.HasPrefix(port, ":") {
+				return fmt.Sprintf("the registry %v is not a host name or IP address with an optional port", registry)
+			} else if port == ":" {
+				return fmt.Sprintf("the registry %v has an empty port", registry)
+			}
+			port = strings.TrimPrefix(port, ":")
+		}
+	} else if ix := strings.Index(registry, ":"); ix != -1 {
+		host, port = registry[:ix], registry[ix+1:]
+		if port == "" {
+			return fmt.Sprintf("the registry %v has an empty port", registry)
+		}
+	}
+
+	if !reRegistryHost.MatchString(host) && !reRegistryIPv6.MatchString(host) {
+		return fmt.Sprintf("the registry %v is not a host name or IP address with an optional port", registry)
+	} else if port != "" && !reRegistryPort.MatchString(port) {
+		return fmt.Sprintf("the port of the registry %v is not a number", registry)
+	}
+	return ""
+}
+
+// Returns an error when the image reference is not valid.
+func ValidateImageReference(reference string) error {
+	_, err := ParseImageReference(reference)
+	return err
+}
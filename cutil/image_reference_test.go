@@ -0,0 +1,104 @@
+// +build unit
+
+package cutil
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const testDigest = "sha256:15315df0677ab1c7291a822290731032b19462a9d29bdd4d4619df7cb0c0f567"
+
+func Test_ParseImageReference_valid(t *testing.T) {
+
+	tests := []struct {
+		reference string
+		expected  ImageReference
+	}{
+		{"hellomicroservice", ImageReference{Repository: "hellomicroservice"}},
+		{"username/hellomicroservice:v1.0", ImageReference{Repository: "username/hellomicroservice", Tag: "v1.0"}},
+		{"mydomain.com/x86_64/hellomicroservice:v1.0", ImageReference{Registry: "mydomain.com", Repository: "x86_64/hellomicroservice", Tag: "v1.0"}},
+		{"registry:5000/a/b", ImageReference{Registry: "registry:5000", Repository: "a/b"}},
+		{"localhost/foo", ImageReference{Registry: "localhost", Repository: "foo"}},
+		{"localhost:5000/foo:tag", ImageReference{Registry: "localhost:5000", Repository: "foo", Tag: "tag"}},
+		{"[::1]:5000/img", ImageReference{Registry: "[::1]:5000", Repository: "img"}},
+		{"MyRegistry/img", ImageReference{Registry: "MyRegistry", Repository: "img"}},
+		{"mydomain.com:8080/x86_64/hello.microservice@" + testDigest, ImageReference{Registry: "mydomain.com:8080", Repository: "x86_64/hello.microservice", Digest: testDigest}},
+		{"mydomain.com/hello_micro-service:v1.0@" + testDigest, ImageReference{Registry: "mydomain.com", Repository: "hello_micro-service", Tag: "v1.0", Digest: testDigest}},
+	}
+
+	for _, test := range tests {
+		ref, err := ParseImageReference(test.reference)
+		if assert.Nil(t, err, fmt.Sprintf("%v should be valid", test.reference)) {
+			assert.Equal(t, test.expected, *ref, fmt.Sprintf("Wrong parse of %v.", test.reference))
+			assert.Equal(t, test.reference, ref.String(), fmt.Sprintf("Wrong string form of %v.", test.reference))
+		}
+	}
+}
+
+func Test_ParseImageReference_invalid(t *testing.T) {
+
+	tests := []string{
+		"",
+		" hellomicroservice",
+		":v1.0",
+		"hellomicroservice:",
+		"hellomicroservice:-v1",
+		"hellomicroservice@sha256:nothex",
+		"hellomicroservice@" + testDigest[7:],
+		"HelloMicroservice",
+		"mydomain.com/x86_64/HelloMicroservice",
+		"mydomain.com/",
+		"mydomain.com:port/hellomicroservice",
+		"mydomain.com:/hellomicroservice",
+		"my_domain.com/hellomicroservice",
+		"[::1]5000/img",
+		"mydomain:8080/@" + testDigest,
+		"username//hellomicroservice",
+	}
+
+	for _, reference := range tests {
+		ref, err := ParseImageReference(reference)
+		assert.Nil(t, ref, fmt.Sprintf("%v should not be parsed", reference))
+		if assert.NotNil(t, err, fmt.Sprintf("%v should be invalid", reference)) {
+			_, ok := err.(*ImageReferenceError)
+			assert.True(t, ok, fmt.Sprintf("Wrong error type for %v: %T", reference, err))
+		}
+		assert.NotNil(t, ValidateImageReference(reference), fmt.Sprintf("%v should be invalid", reference))
+	}
+}
+
+func Test_ImageReference_Normalize(t *testing.T) {
+
+	tests := map[string]string{
+		"hellomicroservice":                            "docker.io/library/hellomicroservice:latest",
+		"username/hellomicroservice:v1.0":              "docker.io/username/hellomicroservice:v1.0",
+		"index.docker.io/hellomicroservice":            "docker.io/library/hellomicroservice:latest",
+		"localhost:5000/foo":                           "localhost:5000/foo:latest",
+		"mydomain.com/hellomicroservice@" + testDigest: "mydomain.com/hellomicroservice@" + testDigest,
+	}
+
+	for reference, expected := range tests {
+		ref, err := ParseImageReference(reference)
+		if assert.Nil(t, err, fmt.Sprintf("%v should be valid", reference)) {
+			assert.Equal(t, expected, ref.Normalize().String(), fmt.Sprintf("Wrong canonical form of %v.", reference))
+		}
+	}
+
+	ref, _ := ParseImageReference("mydomain.com/hellomicroservice")
+	assert.False(t, ref.IsDockerHub(), "mydomain.com is not docker hub")
+}
+
+func Test_ParseDockerImagePath_localhost(t *testing.T) {
+
+	domain, path, tag, digest := ParseDockerImagePath("localhost/foo")
+	assert.Equal(t, "localhost", domain, "Wrong domain name in localhost/foo.")
+	assert.Equal(t, "foo", path, "Wrong path name in localhost/foo.")
+	assert.Empty(t, tag, "Wrong tag name in localhost/foo.")
+	assert.Empty(t, digest, "Wrong digest in localhost/foo.")
+
+	domain, path, _, _ = ParseDockerImagePath("localhost")
+	assert.Empty(t, domain, "Wrong domain name in localhost.")
+	assert.Equal(t, "localhost", path, "Wrong path name in localhost.")
+}
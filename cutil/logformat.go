@@ -0,0 +1,135 @@
+package cutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The format of the log lines written by anax and the agbot, selected with the LogFormat config option. The text
+// format is the free text that anax has always written. The keyvalue and json formats write the same information as
+// fields, so that the logs can be ingested by a log analysis tool (e.g. ELK or Splunk) and an agreement can be
+// followed across the workers that handle it. The glog header (severity, time, file and line) still precedes every line.
+const (
+	LOG_FORMAT_TEXT     = "text"
+	LOG_FORMAT_KEYVALUE = "keyvalue"
+	LOG_FORMAT_JSON     = "json"
+)
+
+// The names of the fields in a structured log line.
+const (
+	LOG_COMPONENT    = "component"
+	LOG_WORKER_ID    = "worker_id"
+	LOG_AGREEMENT_ID = "agreement_id"
+	LOG_DEVICE_ID    = "device_id"
+	LOG_PROTOCOL     = "protocol"
+	LOG_MESSAGE      = "msg"
+)
+
+// The well known fields come first in a keyvalue log line, in this order. Any other fields follow in alphabetical
+// order, and the message is always last.
+var logFieldOrder = []string{LOG_COMPONENT, LOG_WORKER_ID, LOG_AGREEMENT_ID, LOG_DEVICE_ID, LOG_PROTOCOL}
+
+// The fields of a structured log line, in addition to the component and the message. Fields with an empty value are
+// left out of the log line.
+type LogFields map[string]string
+
+var logFormatLock sync.RWMutex
+var logFormat = LOG_FORMAT_TEXT
+
+// SetLogFormat selects the format of all the log lines written from now on. An empty format selects the text format.
+func SetLogFormat(format string) error {
+	if format == "" {
+		format = LOG_FORMAT_TEXT
+	}
+	if format != LOG_FORMAT_TEXT && format != LOG_FORMAT_KEYVALUE && format != LOG_FORMAT_JSON {
+		return errors.New(fmt.Sprintf("log format %v is not supported, must be one of %v, %v or %v", format, LOG_FORMAT_TEXT, LOG_FORMAT_KEYVALUE, LOG_FORMAT_JSON))
+	}
+	logFormatLock.Lock()
+	defer logFormatLock.Unlock()
+	logFormat = format
+	return nil
+}
+
+func GetLogFormat() string {
+	logFormatLock.RLock()
+	defer logFormatLock.RUnlock()
+	return logFormat
+}
+
+// Returns true when log lines should be written with LogString instead of as free text.
+func StructuredLogging() bool {
+	return GetLogFormat() != LOG_FORMAT_TEXT
+}
+
+// LogString returns a structured log line for the component, with the given fields and message, in the current log
+// format. The log string functions of each worker call this when StructuredLogging is on, and otherwise keep writing
+// their free text.
+func LogString(component string, fields LogFields, v interface{}) string {
+	all := make(map[string]string, len(fields)+2)
+	for k, val := range fields {
+		if val != "" {
+			all[k] = val
+		}
+	}
+	all[LOG_COMPONENT] = component
+	all[LOG_MESSAGE] = fmt.Sprintf("%v", v)
+
+	if GetLogFormat() == LOG_FORMAT_JSON {
+		if jsonBytes, err := json.Marshal(all); err == nil {
+			return string(jsonBytes)
+		}
+	}
+	return keyValueString(all)
+}
+
+func keyValueString(all map[string]string) string {
+	keys := make([]string, 0, len(all))
+	for _, k := range logFieldOrder {
+		if _, ok := all[k]; ok {
+			keys = append(keys, k)
+		}
+	}
+	others := make([]string, 0)
+	for k := range all {
+		if k != LOG_MESSAGE && !logWellKnownField(k) {
+			others = append(others, k)
+		}
+	}
+	sort.Strings(others)
+	keys = append(keys, others...)
+	keys = append(keys, LOG_MESSAGE)
+
+	var sb bytes.Buffer
+	for i, k := range keys {
+		if i != 0 {
+			sb.WriteString(" ")
+		}
+		sb.WriteString(k)
+		sb.WriteString("=")
+		sb.WriteString(logValue(all[k], k == LOG_MESSAGE))
+	}
+	return sb.String()
+}
+
+func logWellKnownField(k string) bool {
+	for _, f := range logFieldOrder {
+		if f == k {
+			return true
+		}
+	}
+	return false
+}
+
+// Values are quoted when they would otherwise be ambiguous. The message is always quoted.
+func logValue(val string, quote bool) string {
+	if quote || val == "" || strings.ContainsAny(val, " \t\n\"=") {
+		return strconv.Quote(val)
+	}
+	return val
+}
@@ -0,0 +1,53 @@
+// +build unit
+
+package cutil
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func Test_SetLogFormat(t *testing.T) {
+
+	defer SetLogFormat(LOG_FORMAT_TEXT)
+
+	if err := SetLogFormat(""); err != nil {
+		t.Errorf("empty log format should be accepted, error: %v", err)
+	} else if StructuredLogging() {
+		t.Errorf("empty log format should be text")
+	} else if err := SetLogFormat("xml"); err == nil {
+		t.Errorf("xml log format should be rejected")
+	} else if err := SetLogFormat(LOG_FORMAT_JSON); err != nil {
+		t.Errorf("json log format should be accepted, error: %v", err)
+	} else if !StructuredLogging() {
+		t.Errorf("json log format should be structured")
+	}
+}
+
+func Test_LogString_keyvalue(t *testing.T) {
+
+	defer SetLogFormat(LOG_FORMAT_TEXT)
+	SetLogFormat(LOG_FORMAT_KEYVALUE)
+
+	fields := LogFields{LOG_PROTOCOL: "Basic", LOG_AGREEMENT_ID: "a1", LOG_WORKER_ID: "w1", LOG_DEVICE_ID: "", "policy": "my policy"}
+	expected := `component="Base Agreement Worker" worker_id=w1 agreement_id=a1 protocol=Basic policy="my policy" msg="terminating agreement a1."`
+	if s := LogString("Base Agreement Worker", fields, "terminating agreement a1."); s != expected {
+		t.Errorf("expected %v, was %v", expected, s)
+	}
+}
+
+func Test_LogString_json(t *testing.T) {
+
+	defer SetLogFormat(LOG_FORMAT_TEXT)
+	SetLogFormat(LOG_FORMAT_JSON)
+
+	out := make(map[string]string)
+	s := LogString("GovernanceWorker", LogFields{LOG_AGREEMENT_ID: "a1", LOG_DEVICE_ID: ""}, `quoted "text"`)
+	if err := json.Unmarshal([]byte(s), &out); err != nil {
+		t.Errorf("log string %v is not json, error: %v", s, err)
+	} else if out[LOG_COMPONENT] != "GovernanceWorker" || out[LOG_AGREEMENT_ID] != "a1" || out[LOG_MESSAGE] != `quoted "text"` {
+		t.Errorf("log string %v is missing fields", s)
+	} else if _, ok := out[LOG_DEVICE_ID]; ok {
+		t.Errorf("log string %v should not include empty fields", s)
+	}
+}
@@ -0,0 +1,123 @@
+package cutil
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A log trace raises the log verbosity for the log lines about one agreement or one device, so that a single
+// problematic negotiation can be debugged without turning on verbose logging for every other agreement. The log
+// lines that honor traces decide whether to log with TraceV instead of glog.V.
+
+// The default verbosity of a trace and the default number of seconds it lasts.
+const DEFAULT_LOG_TRACE_LEVEL = 5
+const DEFAULT_LOG_TRACE_DURATION_S = 3600
+
+type LogTrace struct {
+	AgreementId string `json:"agreement_id,omitempty"` // either the agreement or the device is traced, not both
+	DeviceId    string `json:"device_id,omitempty"`
+	Level       int    `json:"level"`   // log lines up to this verbosity are written for the agreement or device
+	Expires     int64  `json:"expires"` // the time the trace ends, in seconds since 1970
+}
+
+func (t LogTrace) String() string {
+	return fmt.Sprintf("AgreementId: %v, DeviceId: %v, Level: %v, Expires: %v", t.AgreementId, t.DeviceId, t.Level, t.Expires)
+}
+
+func (t LogTrace) key() string {
+	if t.AgreementId != "" {
+		return LOG_AGREEMENT_ID + "/" + t.AgreementId
+	}
+	return LOG_DEVICE_ID + "/" + t.DeviceId
+}
+
+var logTracesLock sync.RWMutex
+var logTraces = make(map[string]LogTrace)
+
+// Start tracing an agreement or a device, replacing any trace that is already in place for it. A zero level or duration
+// selects the default.
+func AddLogTrace(agreementId string, deviceId string, level int, durationS int) (*LogTrace, error) {
+	if (agreementId == "") == (deviceId == "") {
+		return nil, errors.New("a log trace must be for either an agreement id or a device id")
+	} else if level < 0 || durationS < 0 {
+		return nil, errors.New(fmt.Sprintf("log trace level %v and duration %v must not be negative", level, durationS))
+	}
+
+	if level == 0 {
+		level = DEFAULT_LOG_TRACE_LEVEL
+	}
+	if durationS == 0 {
+		durationS = DEFAULT_LOG_TRACE_DURATION_S
+	}
+	trace := LogTrace{AgreementId: agreementId, DeviceId: deviceId, Level: level, Expires: time.Now().Unix() + int64(durationS)}
+
+	logTracesLock.Lock()
+	defer logTracesLock.Unlock()
+	logTraces[trace.key()] = trace
+	return &trace, nil
+}
+
+// Stop tracing an agreement or a device. Returns false if it was not being traced.
+func RemoveLogTrace(agreementId string, deviceId string) bool {
+	key := LogTrace{AgreementId: agreementId, DeviceId: deviceId}.key()
+
+	logTracesLock.Lock()
+	defer logTracesLock.Unlock()
+	_, ok := logTraces[key]
+	delete(logTraces, key)
+	return ok
+}
+
+// Returns the traces that have not expired yet, agreements first.
+func GetLogTraces() []LogTrace {
+	now := time.Now().Unix()
+
+	logTracesLock.Lock()
+	defer logTracesLock.Unlock()
+
+	keys := make([]string, 0, len(logTraces))
+	for key, trace := range logTraces {
+		if trace.Expires <= now {
+			delete(logTraces, key)
+		} else {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	traces := make([]LogTrace, 0, len(keys))
+	for _, key := range keys {
+		traces = append(traces, logTraces[key])
+	}
+	return traces
+}
+
+// TraceV is used in place of glog.V for log lines about an agreement or a device. The line is written when the
+// verbosity of the process allows it, or when the agreement or the device is being traced at the given level.
+func TraceV(level glog.Level, agreementId string, deviceId string) glog.Verbose {
+	if v := glog.V(level); v {
+		return v
+	}
+	return glog.Verbose(traced(int(level), agreementId, deviceId))
+}
+
+func traced(level int, agreementId string, deviceId string) bool {
+	logTracesLock.RLock()
+	defer logTracesLock.RUnlock()
+
+	if len(logTraces) == 0 {
+		return false
+	}
+
+	now := time.Now().Unix()
+	for _, key := range []string{LOG_AGREEMENT_ID + "/" + agreementId, LOG_DEVICE_ID + "/" + deviceId} {
+		if trace, ok := logTraces[key]; ok && trace.Level >= level && trace.Expires > now {
+			return true
+		}
+	}
+	return false
+}
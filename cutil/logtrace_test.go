@@ -0,0 +1,77 @@
+// +build unit
+
+package cutil
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_LogTrace(t *testing.T) {
+
+	defer func() {
+		RemoveLogTrace("ag1", "")
+		RemoveLogTrace("", "myorg/dev1")
+	}()
+
+	// A trace is for either an agreement or a device.
+	if _, err := AddLogTrace("", "", 0, 0); err == nil {
+		t.Errorf("a trace without an agreement or a device should be rejected")
+	} else if _, err := AddLogTrace("ag1", "myorg/dev1", 0, 0); err == nil {
+		t.Errorf("a trace with both an agreement and a device should be rejected")
+	} else if _, err := AddLogTrace("ag1", "", -1, 0); err == nil {
+		t.Errorf("a trace with a negative level should be rejected")
+	}
+
+	// Nothing is traced yet, and the test process logs at verbosity 0.
+	if TraceV(5, "ag1", "myorg/dev1") {
+		t.Errorf("untraced agreement should not be logged")
+	}
+
+	if trace, err := AddLogTrace("ag1", "", 0, 0); err != nil {
+		t.Fatalf("unable to add trace: %v", err)
+	} else if trace.Level != DEFAULT_LOG_TRACE_LEVEL || trace.Expires <= time.Now().Unix() {
+		t.Errorf("trace %v should have the default level and duration", trace)
+	}
+	if _, err := AddLogTrace("", "myorg/dev1", 3, 60); err != nil {
+		t.Fatalf("unable to add trace: %v", err)
+	}
+
+	if !TraceV(5, "ag1", "") {
+		t.Errorf("traced agreement should be logged at level 5")
+	} else if TraceV(6, "ag1", "") {
+		t.Errorf("traced agreement should not be logged above its level")
+	} else if !TraceV(3, "ag2", "myorg/dev1") {
+		t.Errorf("traced device should be logged at level 3")
+	} else if TraceV(5, "ag2", "myorg/dev1") {
+		t.Errorf("traced device should not be logged above its level")
+	} else if TraceV(3, "ag2", "myorg/dev2") {
+		t.Errorf("untraced agreement and device should not be logged")
+	}
+
+	if traces := GetLogTraces(); len(traces) != 2 || traces[0].AgreementId != "ag1" || traces[1].DeviceId != "myorg/dev1" {
+		t.Errorf("wrong traces %v", traces)
+	}
+
+	// Removing a trace stops the logging.
+	if !RemoveLogTrace("ag1", "") {
+		t.Errorf("trace for ag1 should have been removed")
+	} else if RemoveLogTrace("ag1", "") {
+		t.Errorf("trace for ag1 should already be gone")
+	} else if TraceV(5, "ag1", "") {
+		t.Errorf("agreement should not be logged once its trace is removed")
+	}
+
+	// An expired trace is ignored and pruned.
+	logTracesLock.Lock()
+	trace := logTraces[LOG_DEVICE_ID+"/myorg/dev1"]
+	trace.Expires = time.Now().Unix() - 1
+	logTraces[LOG_DEVICE_ID+"/myorg/dev1"] = trace
+	logTracesLock.Unlock()
+
+	if TraceV(3, "", "myorg/dev1") {
+		t.Errorf("device should not be logged once its trace has expired")
+	} else if traces := GetLogTraces(); len(traces) != 0 {
+		t.Errorf("expired trace should have been pruned, have %v", traces)
+	}
+}
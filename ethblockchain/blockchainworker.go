@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/exchange"
 	"github.com/open-horizon/anax/persistence"
@@ -435,6 +436,14 @@ func (w *EthBlockchainWorker) handleNewClient(cmd *NewClientCommand) {
 			w.DeleteBCInstance(cmd.Msg.Instance())
 		}
 
+	} else if cmd.Msg.Event().Id == events.RESTART_BC_CLIENT && !bcState.needsRestart {
+		// The client stopped answering on its RPC endpoint, stop the container. It is started again when the
+		// container shutdown message arrives back at this worker.
+		glog.V(3).Infof(logString(fmt.Sprintf("restarting unhealthy eth container %v/%v", cmd.Msg.Org(), cmd.Msg.Instance())))
+		bcState.needsRestart = true
+		w.Messages() <- events.NewBlockchainClientStoppingMessage(events.BC_CLIENT_STOPPING, policy.Ethereum_bc, cmd.Msg.Instance(), cmd.Msg.Org())
+		w.Messages() <- events.NewContainerStopMessage(events.CONTAINER_STOPPING, cmd.Msg.Instance(), cmd.Msg.Org())
+
 	} else {
 		glog.V(3).Infof(logString(fmt.Sprintf("ignoring duplicate request to start eth container %v/%v", cmd.Msg.Org(), cmd.Msg.Instance())))
 	}
@@ -501,16 +510,20 @@ func (w *EthBlockchainWorker) fireStartEvent(details *exchange.ChainDetails, nam
 		return errors.New(logString(fmt.Sprintf("ill-formed URL: %v, error %v", details.DeploymentDesc.Torrent.Url, err)))
 	} else {
 
-		// Verify the deployment signature
+		// Verify the deployment signature and the chain definition, and compute the container's config from it.
+		var envAdds map[string]string
 		if pemFiles, err := w.Config.Collaborators.KeyFileNamesFetcher.GetKeyFileNames(w.horizonPubKeyFile, w.Config.UserPublicKeyPath()); err != nil {
 			return errors.New(logString(fmt.Sprintf("received error getting pem key files: %v", err)))
 		} else if err := details.DeploymentDesc.HasValidSignature(pemFiles); err != nil {
 			return errors.New(logString(fmt.Sprintf("eth container has invalid deployment signature %v for %v", details.DeploymentDesc.DeploymentSignature, details.DeploymentDesc.Deployment)))
+		} else if instance, err := chainInstance(details, pemFiles); err != nil {
+			return errors.New(logString(fmt.Sprintf("eth container has invalid chain definition, %v", err)))
+		} else {
+			envAdds = w.computeEnvVarsForContainer(instance)
 		}
 
 		// Fire an event to the torrent worker so that it will download the container
 		cc := events.NewContainerConfig(*url, details.DeploymentDesc.Torrent.Signature, details.DeploymentDesc.Deployment, details.DeploymentDesc.DeploymentSignature, details.DeploymentDesc.DeploymentUserInfo, "", nil)
-		envAdds := w.computeEnvVarsForContainer(details)
 		w.SetColonusDir(name, envAdds["COLONUS_DIR"])
 		lc := events.NewContainerLaunchContext(cc, &envAdds, events.BlockchainConfig{Type: CHAIN_TYPE, Name: name}, name, "", []events.MicroserviceSpec{}, persistence.NewServiceInstancePathElement("",""))
 		w.BaseWorker.Manager.Messages <- events.NewLoadContainerMessage(events.LOAD_CONTAINER, lc)
@@ -519,7 +532,7 @@ func (w *EthBlockchainWorker) fireStartEvent(details *exchange.ChainDetails, nam
 	}
 }
 
-func (w *EthBlockchainWorker) computeEnvVarsForContainer(details *exchange.ChainDetails) map[string]string {
+func (w *EthBlockchainWorker) computeEnvVarsForContainer(instance *exchange.ChainInstance) map[string]string {
 	envAdds := make(map[string]string)
 
 	// Make sure the vars that MUST be set are set.
@@ -529,25 +542,28 @@ func (w *EthBlockchainWorker) computeEnvVarsForContainer(details *exchange.Chain
 		envAdds["HZN_RAM"] = ram
 	}
 
-	envAdds["COLONUS_DIR"] = getInstanceValue("COLONUS_DIR", details.Instance.ColonusDir)
+	envAdds["COLONUS_DIR"] = getInstanceValue("COLONUS_DIR", instance.ColonusDir)
 
 	// If there are no instance details, then dont set any of these envvars.
-	if details.Instance == (exchange.ChainInstance{}) {
+	if *instance == (exchange.ChainInstance{}) {
 		return envAdds
 	}
 
 	// Set env vars from the blockchain metadata details
-	envAdds["BLOCKS_URLS"] = details.Instance.BlocksURLs
-	envAdds["CHAINDATA_DIR"] = details.Instance.ChainDataDir
-	envAdds["DISCOVERY_URLS"] = details.Instance.DiscoveryURLs
-	envAdds["PORT"] = getInstanceValue("PORT", details.Instance.Port)
-	envAdds["HOSTNAME"] = getInstanceValue("HOSTNAME", details.Instance.HostName)
-	envAdds["IDENTITY"] = getInstanceValue("IDENTITY", details.Instance.Identity) + "-" + envAdds["HOSTNAME"]
-	envAdds["KDF"] = getInstanceValue("KDF", details.Instance.KDF)
-	envAdds["PING_HOST"] = details.Instance.PingHost
-	envAdds["ETHEREUM_DIR"] = getInstanceValue("ETHEREUM_DIR", details.Instance.EthDir)
-	envAdds["MAXPEERS"] = getInstanceValue("MAXPEERS", details.Instance.MaxPeers)
-	envAdds["GETH_LOG"] = getInstanceValue("GETH_LOG", details.Instance.GethLog)
+	envAdds["BLOCKS_URLS"] = instance.BlocksURLs
+	envAdds["CHAINDATA_DIR"] = instance.ChainDataDir
+	envAdds["DISCOVERY_URLS"] = instance.DiscoveryURLs
+	envAdds["PORT"] = getInstanceValue("PORT", instance.Port)
+	envAdds["HOSTNAME"] = getInstanceValue("HOSTNAME", instance.HostName)
+	envAdds["IDENTITY"] = getInstanceValue("IDENTITY", instance.Identity) + "-" + envAdds["HOSTNAME"]
+	envAdds["KDF"] = getInstanceValue("KDF", instance.KDF)
+	envAdds["PING_HOST"] = instance.PingHost
+	envAdds["ETHEREUM_DIR"] = getInstanceValue("ETHEREUM_DIR", instance.EthDir)
+	envAdds["MAXPEERS"] = getInstanceValue("MAXPEERS", instance.MaxPeers)
+	envAdds["GETH_LOG"] = getInstanceValue("GETH_LOG", instance.GethLog)
+	envAdds["NETWORK_ID"] = instance.NetworkId
+	envAdds["BOOTNODES"] = instance.Bootnodes
+	envAdds["GENESIS"] = instance.Genesis
 
 	return envAdds
 }
@@ -661,7 +677,7 @@ func (w *EthBlockchainWorker) handleEvents(newEvents []Raw_Event, name string, o
 		} else {
 			rawEvent := string(evBytes)
 			glog.V(3).Info(logString(fmt.Sprintf("found event: %v", rawEvent)))
-			w.Messages() <- events.NewEthBlockchainEventMessage(events.BC_EVENT, rawEvent, name, org, policy.CitizenScientist)
+			w.Messages() <- events.NewEthBlockchainEventMessage(events.BC_EVENT, policy.Ethereum_bc, rawEvent, name, org, policy.CitizenScientist)
 		}
 	}
 }
@@ -789,5 +805,8 @@ func NewShutdownWorkerCommand() *ShutdownWorkerCommand {
 // Utility functions
 
 var logString = func(v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("EthBlockchainWorker", nil, v)
+	}
 	return fmt.Sprintf("EthBlockchainWorker %v", v)
 }
@@ -0,0 +1,37 @@
+package ethblockchain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/rsapss-tool/verify"
+)
+
+// Get the instance config of a chain from its exchange metadata. A signed definition is used when the chain has one,
+// and it has to verify with one of the trusted keys, otherwise the unsigned instance config is used.
+func chainInstance(details *exchange.ChainDetails, keyFileNames []string) (*exchange.ChainInstance, error) {
+
+	if details.Definition == "" {
+		glog.Warningf(logString(fmt.Sprintf("the chain metadata for arch %v has no signed definition, using its unsigned instance config", details.Arch)))
+		return &details.Instance, nil
+	}
+
+	if details.DefinitionSignature == "" {
+		return nil, errors.New(fmt.Sprintf("the chain definition for arch %v is not signed", details.Arch))
+	} else if verified, fn_success, failed_map := verify.InputVerifiedByAnyKey(keyFileNames, details.DefinitionSignature, []byte(details.Definition)); !verified {
+		return nil, errors.New(fmt.Sprintf("error verifying chain definition signature: %v for definition: %v, error: %v", details.DefinitionSignature, details.Definition, failed_map))
+	} else {
+		glog.V(3).Infof(logString(fmt.Sprintf("chain definition verification successful with RSA pubkey in file: %v", fn_success)))
+	}
+
+	def := new(exchange.ChainDefinition)
+	if err := json.Unmarshal([]byte(details.Definition), def); err != nil {
+		return nil, errors.New(fmt.Sprintf("could not unmarshal chain definition, error %v, definition %v", err, details.Definition))
+	} else if def.Type != CHAIN_TYPE {
+		return nil, errors.New(fmt.Sprintf("the chain definition is for a %v chain, expecting %v", def.Type, CHAIN_TYPE))
+	}
+	return &def.Instance, nil
+
+}
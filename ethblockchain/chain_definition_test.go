@@ -0,0 +1,50 @@
+// +build unit
+
+package ethblockchain
+
+import (
+	"github.com/open-horizon/anax/exchange"
+	"testing"
+)
+
+func Test_chainInstance(t *testing.T) {
+
+	// Without a definition the unsigned instance config is used.
+	details := &exchange.ChainDetails{Arch: "amd64", Instance: exchange.ChainInstance{Port: "1234"}}
+	if instance, err := chainInstance(details, []string{}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if instance.Port != "1234" {
+		t.Errorf("wrong instance returned %v", instance)
+	}
+
+	// A definition has to be signed, and the signature has to verify.
+	details.Definition = `{"type":"ethereum","instance":{"port":"5678","bootnodes":"enode://abc@1.2.3.4:30303"}}`
+	if _, err := chainInstance(details, []string{}); err == nil {
+		t.Errorf("an unsigned definition should be rejected")
+	}
+
+	details.DefinitionSignature = "notasignature"
+	if _, err := chainInstance(details, []string{"/tmp/nokey.pem"}); err == nil {
+		t.Errorf("a definition that doesnt verify should be rejected")
+	}
+
+}
+
+func Test_computeEnvVarsForContainer(t *testing.T) {
+
+	w := &EthBlockchainWorker{}
+
+	if envAdds := w.computeEnvVarsForContainer(&exchange.ChainInstance{}); envAdds["COLONUS_DIR"] != "/root/eth" {
+		t.Errorf("COLONUS_DIR should default, got %v", envAdds)
+	} else if _, ok := envAdds["BOOTNODES"]; ok {
+		t.Errorf("no chain config should be set without instance config, got %v", envAdds)
+	}
+
+	instance := &exchange.ChainInstance{NetworkId: "42", Bootnodes: "enode://abc@1.2.3.4:30303", Genesis: `{"config":{}}`}
+	if envAdds := w.computeEnvVarsForContainer(instance); envAdds["NETWORK_ID"] != "42" || envAdds["BOOTNODES"] != instance.Bootnodes || envAdds["GENESIS"] != instance.Genesis {
+		t.Errorf("chain config not set, got %v", envAdds)
+	} else if envAdds["PORT"] != "33303" {
+		t.Errorf("PORT should default, got %v", envAdds)
+	}
+
+}
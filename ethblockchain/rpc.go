@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/faults"
 	"io/ioutil"
 	"math/big"
 	"net/http"
@@ -56,7 +57,9 @@ func (self *RPC_Client) Get_block_number() (uint64, error) {
 		return 0, errors.New(err.Msg)
 	} else if rpcResp, err := self.decodeResponse([]byte(out)); err != nil {
 		return 0, err
-	} else if block, err := strconv.ParseUint(rpcResp.Result.(string)[2:], 16, 64); err != nil {
+	} else if hexBlock, ok := rpcResp.Result.(string); !ok || len(hexBlock) < 2 {
+		return 0, errors.New(fmt.Sprintf("unexpected block number %v returned", rpcResp.Result))
+	} else if block, err := strconv.ParseUint(hexBlock[2:], 16, 64); err != nil {
 		return 0, err
 	} else {
 		return block, nil
@@ -130,7 +133,9 @@ func (self *RPC_Client) Invoke(method string, params interface{}) (string, *RPCE
 		err = &RPCError{fmt.Sprintf("RPC invocation of %v failed creating http request, error: %v", method, e.Error())}
 	} else {
 		req.Close = true // work around to ensure that Go doesn't get connections confused. Supposed to be fixed in Go 1.6.
-		if resp, e := self.httpClient.Do(req); e != nil {
+		if faults.Inject(faults.BLOCKCHAIN_TIMEOUT) {
+			err = &RPCError{fmt.Sprintf("RPC http invocation of %v with %v returned error: %v, Client.Timeout exceeded while awaiting headers", method, self.body, faults.Message(faults.BLOCKCHAIN_TIMEOUT))}
+		} else if resp, e := self.httpClient.Do(req); e != nil {
 			err = &RPCError{fmt.Sprintf("RPC http invocation of %v with %v returned error: %v", method, self.body, e.Error())}
 		} else {
 			defer resp.Body.Close()
@@ -3,6 +3,7 @@ package events
 import (
 	"fmt"
 	"github.com/open-horizon/anax/containermessage"
+	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/persistence"
 	"net/url"
 	"time"
@@ -29,12 +30,20 @@ const (
 	ACCOUNT_FUNDED        EventId = "ACCOUNT_FUNDED"
 	BC_CLIENT_INITIALIZED EventId = "BC_CLIENT_INITIALIZED"
 	BC_CLIENT_STOPPING    EventId = "BC_CLIENT_STOPPING"
+	BC_CLIENT_UNHEALTHY   EventId = "BC_CLIENT_UNHEALTHY"
+	BC_CLIENT_HEALTHY     EventId = "BC_CLIENT_HEALTHY"
 	BC_EVENT              EventId = "BC_EVENT"
 	BC_NEEDED             EventId = "BC_NEEDED"
 	ALL_STOP              EventId = "ALL_STOP"
 
 	// exchange related
 	RECEIVED_EXCHANGE_DEV_MSG EventId = "RECEIVED_EXCHANGE_DEV_MSG"
+	EXCHANGE_DEGRADED         EventId = "EXCHANGE_DEGRADED"
+	EXCHANGE_RESTORED         EventId = "EXCHANGE_RESTORED"
+
+	// node disk space related
+	DISK_SPACE_LOW      EventId = "DISK_SPACE_LOW"
+	DISK_SPACE_RESTORED EventId = "DISK_SPACE_RESTORED"
 
 	// image fetching related
 	IMAGE_FETCHED          EventId = "IMAGE_FETCHED"
@@ -54,7 +63,10 @@ const (
 	START_MICROSERVICE  EventId = "START_MICROSERVICE"
 	CANCEL_MICROSERVICE EventId = "CANCEL_MICROSERVICE"
 	NEW_BC_CLIENT       EventId = "NEW_BC_CONTAINER"
+	RESTART_BC_CLIENT   EventId = "RESTART_BC_CONTAINER"
 	IMAGE_LOAD_FAILED   EventId = "IMAGE_LOAD_FAILED"
+	SAMPLE_CONTAINERS   EventId = "SAMPLE_CONTAINERS"
+	CONTAINER_USAGE     EventId = "CONTAINER_USAGE"
 
 	// policy-related
 	NEW_POLICY     EventId = "NEW_POLICY"
@@ -71,11 +83,16 @@ const (
 	DEVICE_AGREEMENTS_SYNCED EventId = "DEVICE_AGREEMENTS_SYNCED"
 	DEVICE_CONTAINERS_SYNCED EventId = "DEVICE_CONTAINERS_SYNCED"
 	WORKLOAD_UPGRADE         EventId = "WORKLOAD_UPGRADE"
+	WORKLOAD_ROLLBACK        EventId = "WORKLOAD_ROLLBACK"
 
 	// Node related
-	START_UNCONFIGURE    EventId = "UNCONFIGURE_NODE"
-	UNCONFIGURE_COMPLETE EventId = "UNCONFIGURE_COMPLETE"
-	WORKER_STOP          EventId = "WORKER_STOP"
+	START_UNCONFIGURE          EventId = "UNCONFIGURE_NODE"
+	UNCONFIGURE_COMPLETE       EventId = "UNCONFIGURE_COMPLETE"
+	WORKER_STOP                EventId = "WORKER_STOP"
+	SERVICE_USER_INPUT_CHANGED EventId = "SERVICE_USER_INPUT_CHANGED"
+
+	// Agbot related
+	AGBOT_SHUTDOWN EventId = "AGBOT_SHUTDOWN"
 )
 
 type EndContractCause string
@@ -145,10 +162,11 @@ type ContainerConfig struct {
 	DeploymentUserInfo  string            `json:"deployment_user_info"`
 	Overrides           string            `json:"overrides"`
 	ImageDockerAuths    []ImageDockerAuth `json:"image_auths"`
+	ImageFetch          *cutil.ImageFetch `json:"image_fetch,omitempty"` // Mirrors, digests and auth hints for the docker image pulls
 }
 
 func (c ContainerConfig) String() string {
-	return fmt.Sprintf("TorrentURL: %v, TorrentSignature: %v, Deployment: %v, DeploymentSignature: %v, DeploymentUserInfo: %v, Overrides: %v, ImageDockerAuths: %v", c.TorrentURL.String(), c.TorrentSignature, c.Deployment, c.DeploymentSignature, c.DeploymentUserInfo, c.Overrides, c.ImageDockerAuths)
+	return fmt.Sprintf("TorrentURL: %v, TorrentSignature: %v, Deployment: %v, DeploymentSignature: %v, DeploymentUserInfo: %v, Overrides: %v, ImageDockerAuths: %v, ImageFetch: %v", c.TorrentURL.String(), c.TorrentSignature, c.Deployment, c.DeploymentSignature, c.DeploymentUserInfo, c.Overrides, c.ImageDockerAuths, c.ImageFetch)
 }
 
 func NewContainerConfig(torrentURL url.URL, torrentSignature string, deployment string, deploymentSignature string, deploymentUserInfo string, overrides string, imageDockerAuths []ImageDockerAuth) *ContainerConfig {
@@ -755,6 +773,7 @@ type ABApiAgreementCancelationMessage struct {
 	event             Event
 	AgreementProtocol string
 	AgreementId       string
+	Reason            string // the agbot termination reason, empty when the user did not choose one
 }
 
 func (m *ABApiAgreementCancelationMessage) Event() Event {
@@ -762,20 +781,21 @@ func (m *ABApiAgreementCancelationMessage) Event() Event {
 }
 
 func (m ABApiAgreementCancelationMessage) String() string {
-	return fmt.Sprintf("Event: %v, AgreementProtocol: %v, AgreementId: %v", m.event, m.AgreementProtocol, m.AgreementId)
+	return fmt.Sprintf("Event: %v, AgreementProtocol: %v, AgreementId: %v, Reason: %v", m.event, m.AgreementProtocol, m.AgreementId, m.Reason)
 }
 
 func (m ABApiAgreementCancelationMessage) ShortString() string {
 	return m.String()
 }
 
-func NewABApiAgreementCancelationMessage(id EventId, protocol string, agreementId string) *ABApiAgreementCancelationMessage {
+func NewABApiAgreementCancelationMessage(id EventId, protocol string, agreementId string, reason string) *ABApiAgreementCancelationMessage {
 	return &ABApiAgreementCancelationMessage{
 		event: Event{
 			Id: id,
 		},
 		AgreementProtocol: protocol,
 		AgreementId:       agreementId,
+		Reason:            reason,
 	}
 }
 
@@ -1017,6 +1037,55 @@ func NewBlockchainClientStoppingMessage(id EventId, bcType string, bcName string
 	}
 }
 
+// Blockchain client health message, sent when the RPC endpoint of a client stops or starts answering again.
+type BlockchainClientHealthMessage struct {
+	event      Event
+	Time       uint64
+	bcType     string
+	bcInstance string
+	bcOrg      string
+	Failures   int    // the number of consecutive failed health checks
+	Error      string // the error from the last failed health check
+}
+
+func (m *BlockchainClientHealthMessage) Event() Event {
+	return m.event
+}
+
+func (m BlockchainClientHealthMessage) String() string {
+	return fmt.Sprintf("Event: %v, Time: %v, Type: %v, Instance: %v, Org: %v, Failures: %v, Error: %v", m.event, m.Time, m.bcType, m.bcInstance, m.bcOrg, m.Failures, m.Error)
+}
+
+func (m BlockchainClientHealthMessage) ShortString() string {
+	return m.String()
+}
+
+func (m BlockchainClientHealthMessage) BlockchainType() string {
+	return m.bcType
+}
+
+func (m BlockchainClientHealthMessage) BlockchainInstance() string {
+	return m.bcInstance
+}
+
+func (m BlockchainClientHealthMessage) BlockchainOrg() string {
+	return m.bcOrg
+}
+
+func NewBlockchainClientHealthMessage(id EventId, bcType string, bcName string, org string, failures int, errMsg string) *BlockchainClientHealthMessage {
+	return &BlockchainClientHealthMessage{
+		event: Event{
+			Id: id,
+		},
+		Time:       uint64(time.Now().Unix()),
+		bcType:     bcType,
+		bcInstance: bcName,
+		bcOrg:      org,
+		Failures:   failures,
+		Error:      errMsg,
+	}
+}
+
 // Report of blockchains that are needed
 type ReportNeededBlockchainsMessage struct {
 	event     Event
@@ -1056,11 +1125,12 @@ func NewReportNeededBlockchainsMessage(id EventId, bcType string, neededBCs map[
 	}
 }
 
-// Blockchain event occurred
+// Blockchain event occurred, the raw event is in the form of the type of blockchain it came from.
 type EthBlockchainEventMessage struct {
 	event    Event
 	rawEvent string
 	protocol string
+	bcType   string
 	name     string
 	org      string
 	Time     uint64
@@ -1074,6 +1144,10 @@ func (m *EthBlockchainEventMessage) RawEvent() string {
 	return m.rawEvent
 }
 
+func (m *EthBlockchainEventMessage) BlockchainType() string {
+	return m.bcType
+}
+
 func (m *EthBlockchainEventMessage) Name() string {
 	return m.name
 }
@@ -1083,20 +1157,21 @@ func (m *EthBlockchainEventMessage) Org() string {
 }
 
 func (m EthBlockchainEventMessage) String() string {
-	return fmt.Sprintf("Event: %v, Name: %v, Org: %v, Protocol: %v, Raw Event: %v, Time: %v", m.event, m.name, m.org, m.protocol, m.rawEvent, m.Time)
+	return fmt.Sprintf("Event: %v, Type: %v, Name: %v, Org: %v, Protocol: %v, Raw Event: %v, Time: %v", m.event, m.bcType, m.name, m.org, m.protocol, m.rawEvent, m.Time)
 }
 
 func (m EthBlockchainEventMessage) ShortString() string {
-	return fmt.Sprintf("Event: %v, Name: %v, Org: %v, Protocol: %v, Time: %v", m.event, m.name, m.org, m.protocol, m.Time)
+	return fmt.Sprintf("Event: %v, Type: %v, Name: %v, Org: %v, Protocol: %v, Time: %v", m.event, m.bcType, m.name, m.org, m.protocol, m.Time)
 }
 
-func NewEthBlockchainEventMessage(id EventId, ev string, name string, org string, protocol string) *EthBlockchainEventMessage {
+func NewEthBlockchainEventMessage(id EventId, bcType string, ev string, name string, org string, protocol string) *EthBlockchainEventMessage {
 	return &EthBlockchainEventMessage{
 		event: Event{
 			Id: id,
 		},
 		rawEvent: ev,
 		protocol: protocol,
+		bcType:   bcType,
 		name:     name,
 		org:      org,
 		Time:     uint64(time.Now().Unix()),
@@ -1246,6 +1321,62 @@ func NewDeviceAgreementsSyncedMessage(id EventId, completed bool) *DeviceAgreeme
 	}
 }
 
+// Tell everyone that the node has entered or left degraded mode because the exchange is unreachable.
+type ExchangeOutageMessage struct {
+	event            Event
+	UnreachableSince int64 // when the exchange stopped responding
+	Time             int64
+}
+
+func (m *ExchangeOutageMessage) Event() Event {
+	return m.event
+}
+
+func (m ExchangeOutageMessage) String() string {
+	return fmt.Sprintf("Event: %v, UnreachableSince: %v, Time: %v", m.event, m.UnreachableSince, m.Time)
+}
+
+func (m ExchangeOutageMessage) ShortString() string {
+	return m.String()
+}
+
+func NewExchangeOutageMessage(id EventId, unreachableSince int64) *ExchangeOutageMessage {
+	return &ExchangeOutageMessage{
+		event: Event{
+			Id: id,
+		},
+		UnreachableSince: unreachableSince,
+		Time:             time.Now().Unix(),
+	}
+}
+
+// Tell everyone that the free disk space of the node fell below the minimum, or is above it again.
+type DiskSpaceMessage struct {
+	event Event
+	Time  int64
+}
+
+func (m *DiskSpaceMessage) Event() Event {
+	return m.event
+}
+
+func (m DiskSpaceMessage) String() string {
+	return fmt.Sprintf("Event: %v, Time: %v", m.event, m.Time)
+}
+
+func (m DiskSpaceMessage) ShortString() string {
+	return m.String()
+}
+
+func NewDiskSpaceMessage(id EventId) *DiskSpaceMessage {
+	return &DiskSpaceMessage{
+		event: Event{
+			Id: id,
+		},
+		Time: time.Now().Unix(),
+	}
+}
+
 // Tell everyone that the device side of anax has synced up it's containers with the local DB
 type DeviceContainersSyncedMessage struct {
 	event     Event
@@ -1359,9 +1490,10 @@ func NewMicroserviceContainersDestroyedMessage(id EventId, key string) *Microser
 
 // Node lifecycle events
 type NodeShutdownMessage struct {
-	event      Event
-	block      bool
-	removeNode bool
+	event         Event
+	block         bool
+	removeNode    bool
+	drainTimeoutS int
 }
 
 func (n *NodeShutdownMessage) Event() Event {
@@ -1373,7 +1505,7 @@ func (n NodeShutdownMessage) String() string {
 }
 
 func (n NodeShutdownMessage) ShortString() string {
-	return fmt.Sprintf("Event: %v, Blocking: %v, RemoveNode: %v", n.event, n.block, n.removeNode)
+	return fmt.Sprintf("Event: %v, Blocking: %v, RemoveNode: %v, DrainTimeoutS: %v", n.event, n.block, n.removeNode, n.drainTimeoutS)
 }
 
 func (n NodeShutdownMessage) Blocking() bool {
@@ -1384,6 +1516,12 @@ func (n NodeShutdownMessage) RemoveNode() bool {
 	return n.removeNode
 }
 
+// The number of seconds the node waits for its workloads to drain before it gives up on the shutdown, zero when the
+// node is not drained.
+func (n NodeShutdownMessage) DrainTimeout() int {
+	return n.drainTimeoutS
+}
+
 func NewNodeShutdownMessage(id EventId, blocking bool, removeNode bool) *NodeShutdownMessage {
 	return &NodeShutdownMessage{
 		event: Event{
@@ -1394,9 +1532,23 @@ func NewNodeShutdownMessage(id EventId, blocking bool, removeNode bool) *NodeShu
 	}
 }
 
+// A shutdown that drains the node first. The agreements are cancelled because the node is decommissioned, and the
+// local state is only removed once the workloads have stopped and the agreements are gone from the exchange.
+func NewNodeDrainMessage(id EventId, blocking bool, removeNode bool, drainTimeoutS int) *NodeShutdownMessage {
+	return &NodeShutdownMessage{
+		event: Event{
+			Id: id,
+		},
+		block:         blocking,
+		removeNode:    removeNode,
+		drainTimeoutS: drainTimeoutS,
+	}
+}
+
 type NodeShutdownCompleteMessage struct {
-	event Event
-	err   string
+	event         Event
+	err           string
+	drainTimedOut bool
 }
 
 func (n *NodeShutdownCompleteMessage) Event() Event {
@@ -1408,13 +1560,17 @@ func (n NodeShutdownCompleteMessage) String() string {
 }
 
 func (n NodeShutdownCompleteMessage) ShortString() string {
-	return fmt.Sprintf("Event: %v, Error: %v", n.event, n.err)
+	return fmt.Sprintf("Event: %v, Error: %v, DrainTimedOut: %v", n.event, n.err, n.drainTimedOut)
 }
 
 func (n NodeShutdownCompleteMessage) Err() string {
 	return n.err
 }
 
+func (n NodeShutdownCompleteMessage) DrainTimedOut() bool {
+	return n.drainTimedOut
+}
+
 func NewNodeShutdownCompleteMessage(id EventId, errorMsg string) *NodeShutdownCompleteMessage {
 	return &NodeShutdownCompleteMessage{
 		event: Event{
@@ -1424,6 +1580,48 @@ func NewNodeShutdownCompleteMessage(id EventId, errorMsg string) *NodeShutdownCo
 	}
 }
 
+// The shutdown ended because the node did not drain in time, the local state of the node was not removed.
+func NewNodeDrainTimeoutMessage(id EventId, errorMsg string) *NodeShutdownCompleteMessage {
+	return &NodeShutdownCompleteMessage{
+		event: Event{
+			Id: id,
+		},
+		err:           errorMsg,
+		drainTimedOut: true,
+	}
+}
+
+// Asks the agbot to stop making new agreements, finish its in-flight agreement work and then exit.
+type AgbotShutdownMessage struct {
+	event  Event
+	reason string
+}
+
+func (a *AgbotShutdownMessage) Event() Event {
+	return a.event
+}
+
+func (a AgbotShutdownMessage) String() string {
+	return a.ShortString()
+}
+
+func (a AgbotShutdownMessage) ShortString() string {
+	return fmt.Sprintf("Event: %v, Reason: %v", a.event, a.reason)
+}
+
+func (a AgbotShutdownMessage) Reason() string {
+	return a.reason
+}
+
+func NewAgbotShutdownMessage(id EventId, reason string) *AgbotShutdownMessage {
+	return &AgbotShutdownMessage{
+		event: Event{
+			Id: id,
+		},
+		reason: reason,
+	}
+}
+
 // This is a special message that the message dispatcher knows about.
 type WorkerStopMessage struct {
 	event Event
@@ -1478,3 +1676,114 @@ func NewAllBlockchainShutdownMessage(id EventId) *AllBlockchainShutdownMessage {
 		},
 	}
 }
+
+// The resources used by the containers of an agreement, as sampled by the container worker.
+type ResourceUsage struct {
+	SampleTime    int64   `json:"sample_time"`     // when the containers were sampled
+	Containers    int     `json:"containers"`      // the number of running containers that were sampled
+	CPUPercent    float64 `json:"cpu_percent"`     // percent of one CPU, so 4 busy CPUs are 400
+	MemoryMB      float64 `json:"memory_mb"`       // memory in use by the containers
+	MemoryLimitMB float64 `json:"memory_limit_mb"` // the sum of the memory limits of the containers
+	DiskMB        float64 `json:"disk_mb"`         // the size of the files written by the containers
+}
+
+func (r ResourceUsage) String() string {
+	return fmt.Sprintf("SampleTime: %v, Containers: %v, CPUPercent: %.1f, MemoryMB: %.1f, MemoryLimitMB: %.1f, DiskMB: %.1f", r.SampleTime, r.Containers, r.CPUPercent, r.MemoryMB, r.MemoryLimitMB, r.DiskMB)
+}
+
+type ContainerUsageMessage struct {
+	event             Event
+	AgreementProtocol string
+	AgreementId       string
+	Usage             ResourceUsage
+}
+
+func (m *ContainerUsageMessage) Event() Event {
+	return m.event
+}
+
+func (m ContainerUsageMessage) String() string {
+	return fmt.Sprintf("Event: %v, AgreementProtocol: %v, AgreementId: %v, Usage: %v", m.event, m.AgreementProtocol, m.AgreementId, m.Usage)
+}
+
+func (m ContainerUsageMessage) ShortString() string {
+	return m.String()
+}
+
+func NewContainerUsageMessage(id EventId, protocol string, agreementId string, usage ResourceUsage) *ContainerUsageMessage {
+	return &ContainerUsageMessage{
+		event: Event{
+			Id: id,
+		},
+		AgreementProtocol: protocol,
+		AgreementId:       agreementId,
+		Usage:             usage,
+	}
+}
+
+// The node rolled back an upgrade of a workload because the containers of the upgraded version kept failing. The
+// agreement of the failed version was cancelled so that the agbot makes an agreement for the previous version.
+type WorkloadRollbackMessage struct {
+	event             Event
+	AgreementProtocol string
+	AgreementId       string
+	WorkloadURL       string
+	Org               string
+	FailedVersion     string
+	RollbackVersion   string
+}
+
+func (m *WorkloadRollbackMessage) Event() Event {
+	return m.event
+}
+
+func (m WorkloadRollbackMessage) String() string {
+	return fmt.Sprintf("Event: %v, AgreementProtocol: %v, AgreementId: %v, WorkloadURL: %v, Org: %v, FailedVersion: %v, RollbackVersion: %v", m.event, m.AgreementProtocol, m.AgreementId, m.WorkloadURL, m.Org, m.FailedVersion, m.RollbackVersion)
+}
+
+func (m WorkloadRollbackMessage) ShortString() string {
+	return m.String()
+}
+
+func NewWorkloadRollbackMessage(id EventId, protocol string, agreementId string, workloadURL string, org string, failedVersion string, rollbackVersion string) *WorkloadRollbackMessage {
+	return &WorkloadRollbackMessage{
+		event: Event{
+			Id: id,
+		},
+		AgreementProtocol: protocol,
+		AgreementId:       agreementId,
+		WorkloadURL:       workloadURL,
+		Org:               org,
+		FailedVersion:     failedVersion,
+		RollbackVersion:   rollbackVersion,
+	}
+}
+
+// The user input variables of a service were changed through the API
+type ServiceUserInputChangedMessage struct {
+	event      Event
+	ServiceURL string
+	Variables  []string // the names of the variables that changed
+}
+
+func (m *ServiceUserInputChangedMessage) Event() Event {
+	return m.event
+}
+
+func (m ServiceUserInputChangedMessage) String() string {
+	return fmt.Sprintf("Event: %v, ServiceURL: %v, Variables: %v", m.event, m.ServiceURL, m.Variables)
+}
+
+func (m ServiceUserInputChangedMessage) ShortString() string {
+	return m.String()
+}
+
+func NewServiceUserInputChangedMessage(id EventId, serviceURL string, variables []string) *ServiceUserInputChangedMessage {
+	return &ServiceUserInputChangedMessage{
+		event: Event{
+			Id: id,
+		},
+		ServiceURL: serviceURL,
+		Variables:  variables,
+	}
+}
@@ -0,0 +1,208 @@
+package exchange
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/policy"
+	"time"
+)
+
+// Function and types related to working with business policies. A business policy is an org level deployment
+// policy. Instead of a node opting in to a pattern, the business policy places a service on every node whose
+// properties satisfy the business policy's constraints.
+
+type BusinessService struct {
+	Name              string             `json:"name"`                        // refers to a service definition in the exchange
+	Org               string             `json:"org"`                         // the org holding the service definition
+	Arch              string             `json:"arch"`                        // the hardware architecture of the service definition
+	ServiceVersions   []WorkloadChoice   `json:"serviceVersions,omitempty"`   // a list of service version for rollback
+	NodeH             NodeHealth         `json:"nodeHealth"`                  // policy for determining when a node's health is violating its agreements
+	AgreementTimeouts *AgreementTimeouts `json:"agreementTimeouts,omitempty"` // how long the agbot waits for the node to negotiate, the agbot's configuration when omitted
+}
+
+func (s BusinessService) String() string {
+	return fmt.Sprintf("Name: %v, Org: %v, Arch: %v, ServiceVersions: %v, NodeH: %v",
+		s.Name,
+		s.Org,
+		s.Arch,
+		s.ServiceVersions,
+		s.NodeH)
+}
+
+func (s BusinessService) ShortString() string {
+	// get the short string for each service version
+	wl_a := make([]string, len(s.ServiceVersions))
+	for i, wl := range s.ServiceVersions {
+		wl_a[i] = wl.ShortString()
+	}
+	return fmt.Sprintf("Name: %v, Org: %v, Arch: %v, ServiceVersions: %v, NodeH: %v",
+		s.Name,
+		s.Org,
+		s.Arch,
+		wl_a,
+		s.NodeH)
+}
+
+type BusinessPolicy struct {
+	Owner              string                  `json:"owner"`
+	Label              string                  `json:"label"`
+	Description        string                  `json:"description"`
+	Service            BusinessService         `json:"service"`
+	Properties         policy.PropertyList     `json:"properties,omitempty"`         // the properties the agbot advertises to the node
+	Constraints        policy.RequiredProperty `json:"constraints,omitempty"`        // the node properties required to run the service
+	RequireAttestation bool                    `json:"requireAttestation,omitempty"` // only match the node properties that the node attests to with their attested values
+	Created            string                  `json:"created"`
+	LastUpdated        string                  `json:"lastUpdated"`
+}
+
+func (b BusinessPolicy) String() string {
+	return fmt.Sprintf("Owner: %v, Label: %v, Description: %v, Service: %v, Properties: %v, Constraints: %v, RequireAttestation: %v",
+		b.Owner,
+		b.Label,
+		b.Description,
+		b.Service,
+		b.Properties,
+		b.Constraints,
+		b.RequireAttestation)
+}
+
+func (b BusinessPolicy) ShortString() string {
+	return fmt.Sprintf("Owner: %v, Label: %v, Description: %v, Service: %v, Properties: %v, Constraints: %v",
+		b.Owner,
+		b.Label,
+		b.Description,
+		b.Service.ShortString(),
+		b.Properties,
+		b.Constraints)
+}
+
+type GetBusinessPolicyResponse struct {
+	BusinessPolicy map[string]BusinessPolicy `json:"businessPolicy,omitempty"` // map of all defined business policies
+	LastIndex      int                       `json:"lastIndex,omitempty"`
+}
+
+// Get all the business policy metadata for a specific organization, and policy if specified.
+func GetBusinessPolicies(httpClientFactory *config.HTTPClientFactory, org string, policyName string, exURL string, id string, token string) (map[string]BusinessPolicy, error) {
+
+	if policyName == "" {
+		glog.V(3).Infof(rpclogString(fmt.Sprintf("getting business policies for %v", org)))
+	} else {
+		glog.V(3).Infof(rpclogString(fmt.Sprintf("getting business policies for %v and %v", org, policyName)))
+	}
+
+	var resp interface{}
+	resp = new(GetBusinessPolicyResponse)
+
+	// Search the exchange for the business policy definitions
+	targetURL := ""
+	if policyName == "" {
+		targetURL = fmt.Sprintf("%vorgs/%v/business/policies", exURL, org)
+	} else {
+		targetURL = fmt.Sprintf("%vorgs/%v/business/policies/%v", exURL, org, policyName)
+	}
+
+	for {
+		if err, tpErr := InvokeExchange(httpClientFactory.NewHTTPClient(nil), "GET", targetURL, id, token, nil, &resp); err != nil {
+			glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
+			return nil, err
+		} else if tpErr != nil {
+			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
+			time.Sleep(10 * time.Second)
+			continue
+		} else {
+			pols := resp.(*GetBusinessPolicyResponse).BusinessPolicy
+
+			// log the policies with signatures truncated
+			pols_sa := make([]string, 0, len(pols))
+			for _, pol := range pols {
+				pols_sa = append(pols_sa, pol.ShortString())
+			}
+			glog.V(3).Infof(rpclogString(fmt.Sprintf("found business policies for %v, %v", org, pols_sa)))
+
+			return pols, nil
+		}
+	}
+}
+
+// The body of a search for the nodes that a business policy can be deployed to. The exchange only returns nodes in the
+// node orgs that are not using a pattern, the agbot checks their node policies.
+type SearchExchBusinessPolRequest struct {
+	NodeOrgIds   []string `json:"nodeOrgids,omitempty"`
+	SecondsStale int      `json:"secondsStale"`
+	StartIndex   int      `json:"startIndex"`
+	NumEntries   int      `json:"numEntries"`
+}
+
+func (a SearchExchBusinessPolRequest) String() string {
+	return fmt.Sprintf("NodeOrgIds: %v, SecondsStale: %v, StartIndex: %v, NumEntries: %v", a.NodeOrgIds, a.SecondsStale, a.StartIndex, a.NumEntries)
+}
+
+type SearchExchBusinessPolResponse struct {
+	Devices   []SearchResultDevice `json:"nodes"`
+	LastIndex int                  `json:"lastIndex"`
+}
+
+func (r SearchExchBusinessPolResponse) String() string {
+	return fmt.Sprintf("Devices: %v, LastIndex: %v", r.Devices, r.LastIndex)
+}
+
+// This function creates the exchange search message body for a business policy.
+func CreateSearchBusinessPolRequest() *SearchExchBusinessPolRequest {
+
+	ser := &SearchExchBusinessPolRequest{
+		StartIndex: 0,
+		NumEntries: 100,
+	}
+
+	return ser
+}
+
+// Convert a business policy to a policy object. A business policy deploys exactly 1 service, so it is always
+// translated to exactly 1 policy.
+func ConvertBusinessPolicyToPolicy(businessPolId string, bp *BusinessPolicy) (*policy.Policy, error) {
+
+	name := GetId(businessPolId)
+	service := bp.Service
+
+	// make sure required fields are not empty
+	if service.Name == "" || service.Org == "" || service.Arch == "" {
+		return nil, fmt.Errorf("name, org or arch is empty string in the service of business policy %v.", name)
+	} else if service.ServiceVersions == nil || len(service.ServiceVersions) == 0 {
+		return nil, fmt.Errorf("The serviceVersions array is empty in business policy %v.", name)
+	}
+
+	// The policy name is prefixed so that it cannot collide with a policy generated from a pattern of the same name.
+	pol := policy.Policy_Factory(fmt.Sprintf("bp_%v", makePolicyName(name, service.Name, service.Org, service.Arch)))
+
+	// Business policies only deploy services.
+	pol.ServiceBased = true
+
+	// Copy service metadata into the policy
+	for _, wl := range service.ServiceVersions {
+		if wl.Version == "" {
+			return nil, fmt.Errorf("The version for service %v arch %v is empty in business policy %v.", service.Name, service.Arch, name)
+		}
+		ConvertChoice(wl, service.Name, service.Org, service.Arch, pol)
+	}
+
+	ConvertNodeHealth(service.NodeH, pol)
+	ConvertAgreementTimeouts(service.AgreementTimeouts, pol)
+
+	// The properties and constraints of the business policy are matched against the node's properties.
+	pol.Properties = append(pol.Properties, bp.Properties...)
+	if len(bp.Constraints) != 0 {
+		if err := bp.Constraints.IsValid(); err != nil {
+			return nil, fmt.Errorf("The constraints in business policy %v are not valid, error %v", name, err)
+		}
+		pol.CounterPartyProperties = bp.Constraints
+	}
+
+	pol.RequireAttestation = bp.RequireAttestation
+
+	// Indicate that this policy was generated from a business policy. Manually created policy files should not use this field.
+	pol.BusinessPolId = businessPolId
+
+	glog.V(3).Infof(rpclogString(fmt.Sprintf("converted %v into %v", bp.ShortString(), pol)))
+	return pol, nil
+}
@@ -0,0 +1,252 @@
+package exchange
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/policy"
+	"sync"
+	"time"
+)
+
+// The metadata cache keeps a copy of the pattern, workload, microservice and service definitions (and their signing keys)
+// that the node has read from the exchange. When the exchange can not be reached, the cached copy is returned instead of
+// waiting for the exchange to come back, so that a node that restarts while it is offline can still restart the
+// workloads it is already running. The cache holds the exchange responses as they were received, deployment signatures
+// included, so cached definitions go through the same signature verification as definitions read from the exchange.
+//
+// The cached handlers below have the same signature as the HTTP handlers in handlers.go, so they can be used anywhere
+// the HTTP handlers are used.
+
+const METADATA_CACHE = "exchange_metadata_cache" // The bolt DB bucket name
+
+// How long the result of checking whether the exchange is reachable is remembered.
+const REACHABLE_CHECK_INTERVAL_S = 30
+
+type cachedWorkloadResolution struct {
+	APISpecs *policy.APISpecList `json:"apiSpecs"`
+	Workload *WorkloadDefinition `json:"workload"`
+}
+
+type cachedMicroservice struct {
+	Id         string                  `json:"id"`
+	Definition *MicroserviceDefinition `json:"definition"`
+}
+
+type cachedService struct {
+	Id         string             `json:"id"`
+	Definition *ServiceDefinition `json:"definition"`
+}
+
+// A handler for querying the exchange for patterns, falling back to the metadata cache when the exchange is unreachable.
+func GetCachedExchangePatternHandler(ec ExchangeContext, db *bolt.DB) PatternHandler {
+	return func(org string, pattern string) (map[string]Pattern, error) {
+		key := cacheKey(PATTERN, org, pattern)
+		pats := make(map[string]Pattern)
+		if pattern != "" && useMetadataCache(ec, db, key, &pats) {
+			return pats, nil
+		}
+
+		pats, err := GetPatterns(ec.GetHTTPFactory(), org, pattern, ec.GetExchangeURL(), ec.GetExchangeId(), ec.GetExchangeToken())
+		if err == nil && pattern != "" {
+			saveMetadataCache(db, key, pats)
+		}
+		return pats, err
+	}
+}
+
+// A handler for getting microservice metadata, falling back to the metadata cache when the exchange is unreachable.
+func GetCachedMicroserviceHandler(ec ExchangeContext, db *bolt.DB) MicroserviceHandler {
+	return func(mUrl string, mOrg string, mVersion string, mArch string) (*MicroserviceDefinition, string, error) {
+		key := cacheKey(MICROSERVICE, mOrg, mUrl, mVersion, mArch)
+		var cached cachedMicroservice
+		if useMetadataCache(ec, db, key, &cached) && cached.Definition != nil {
+			return cached.Definition, cached.Id, nil
+		}
+
+		msDef, msId, err := GetMicroservice(ec.GetHTTPFactory(), mUrl, mOrg, mVersion, mArch, ec.GetExchangeURL(), ec.GetExchangeId(), ec.GetExchangeToken())
+		if err == nil && msDef != nil {
+			saveMetadataCache(db, key, cachedMicroservice{Id: msId, Definition: msDef})
+		}
+		return msDef, msId, err
+	}
+}
+
+// A handler for resolving workload references, falling back to the metadata cache when the exchange is unreachable.
+func GetCachedWorkloadResolverHandler(ec ExchangeContext, db *bolt.DB) WorkloadResolverHandler {
+	return func(wUrl string, wOrg string, wVersion string, wArch string) (*policy.APISpecList, *WorkloadDefinition, error) {
+		key := cacheKey(WORKLOAD, wOrg, wUrl, wVersion, wArch)
+		var cached cachedWorkloadResolution
+		if useMetadataCache(ec, db, key, &cached) && cached.Workload != nil {
+			return cached.APISpecs, cached.Workload, nil
+		}
+
+		asl, wDef, err := WorkloadResolver(ec.GetHTTPFactory(), wUrl, wOrg, wVersion, wArch, ec.GetExchangeURL(), ec.GetExchangeId(), ec.GetExchangeToken())
+		if err == nil && wDef != nil {
+			saveMetadataCache(db, key, cachedWorkloadResolution{APISpecs: asl, Workload: wDef})
+		}
+		return asl, wDef, err
+	}
+}
+
+// A handler for getting service metadata, falling back to the metadata cache when the exchange is unreachable.
+func GetCachedServiceHandler(ec ExchangeContext, db *bolt.DB) ServiceHandler {
+	return func(sUrl string, sOrg string, sVersion string, sArch string) (*ServiceDefinition, string, error) {
+		key := cacheKey(SERVICE, sOrg, sUrl, sVersion, sArch)
+		var cached cachedService
+		if useMetadataCache(ec, db, key, &cached) && cached.Definition != nil {
+			return cached.Definition, cached.Id, nil
+		}
+
+		sDef, sId, err := GetService(ec, sUrl, sOrg, sVersion, sArch)
+		if err == nil && sDef != nil {
+			saveMetadataCache(db, key, cachedService{Id: sId, Definition: sDef})
+		}
+		return sDef, sId, err
+	}
+}
+
+// A handler for resolving service references, using the cached service handler for the service and its dependencies.
+func GetCachedServiceResolverHandler(ec ExchangeContext, db *bolt.DB) ServiceResolverHandler {
+	return func(wUrl string, wOrg string, wVersion string, wArch string) (*policy.APISpecList, *ServiceDefinition, error) {
+		return ServiceResolver(wUrl, wOrg, wVersion, wArch, GetCachedServiceHandler(ec, db))
+	}
+}
+
+// A handler for resolving workload or service references, falling back to the metadata cache when the exchange is unreachable.
+func GetCachedWorkloadOrServiceResolverHandler(ec ExchangeContext, db *bolt.DB) WorkloadOrServiceResolverHandler {
+	return func(wUrl string, wOrg string, wVersion string, wArch string) (*policy.APISpecList, ExchangeDefinition, error) {
+		return GetWorkloadOrService(wUrl, wOrg, wVersion, wArch, GetCachedWorkloadResolverHandler(ec, db), GetCachedServiceHandler(ec, db))
+	}
+}
+
+// A handler for getting object signing keys, falling back to the metadata cache when the exchange is unreachable.
+func GetCachedObjectSigningKeysHandler(ec ExchangeContext, db *bolt.DB) ObjectSigningKeysHandler {
+	return func(oType string, oUrl string, oOrg string, oVersion string, oArch string) (map[string]string, error) {
+		key := cacheKey("keys", oType, oOrg, oUrl, oVersion, oArch)
+		keys := make(map[string]string)
+		if useMetadataCache(ec, db, key, &keys) {
+			return keys, nil
+		}
+
+		keys, err := GetObjectSigningKeys(ec, oType, oUrl, oOrg, oVersion, oArch)
+		if err == nil {
+			saveMetadataCache(db, key, keys)
+		}
+		return keys, err
+	}
+}
+
+// Remove all cached metadata. Called when the node is unconfigured.
+func DeleteMetadataCache(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket([]byte(METADATA_CACHE)); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		return nil
+	})
+}
+
+// Returns true when the exchange is unreachable and the cached copy was read into obj.
+func useMetadataCache(ec ExchangeContext, db *bolt.DB, key string, obj interface{}) bool {
+	if db == nil || exchangeReachable(ec) {
+		return false
+	} else if found, err := readMetadataCache(db, key, obj); err != nil {
+		glog.Errorf(cachelogString(fmt.Sprintf("unable to read %v, error: %v", key, err)))
+		return false
+	} else if !found {
+		glog.Warningf(cachelogString(fmt.Sprintf("exchange is unreachable and there is no cached copy of %v", key)))
+		return false
+	}
+	glog.V(3).Infof(cachelogString(fmt.Sprintf("exchange is unreachable, using cached copy of %v", key)))
+	return true
+}
+
+func readMetadataCache(db *bolt.DB, key string, obj interface{}) (bool, error) {
+	found := false
+	err := db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(METADATA_CACHE)); b != nil {
+			if v := b.Get([]byte(key)); v != nil {
+				found = true
+				return json.Unmarshal(v, obj)
+			}
+		}
+		return nil
+	})
+	return found, err
+}
+
+func saveMetadataCache(db *bolt.DB, key string, obj interface{}) {
+	if db == nil {
+		return
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		if b, err := tx.CreateBucketIfNotExists([]byte(METADATA_CACHE)); err != nil {
+			return err
+		} else if serial, err := json.Marshal(obj); err != nil {
+			return errors.New(fmt.Sprintf("failed to serialize %v, error: %v", key, err))
+		} else {
+			return b.Put([]byte(key), serial)
+		}
+	})
+
+	if err != nil {
+		glog.Errorf(cachelogString(fmt.Sprintf("unable to save %v, error: %v", key, err)))
+	} else {
+		glog.V(5).Infof(cachelogString(fmt.Sprintf("saved %v", key)))
+	}
+}
+
+func cacheKey(parts ...string) string {
+	key := ""
+	for _, p := range parts {
+		key += "/" + p
+	}
+	return key[1:]
+}
+
+// Remember whether the exchange was reachable the last time it was checked, so that a node that is offline does not
+// check again for every definition it reads.
+var reachableLock sync.Mutex
+var reachableChecked = make(map[string]int64)
+var reachable = make(map[string]bool)
+
+// Returns false when the exchange can not be reached at all. Any response from the exchange, even an error, means
+// that the exchange is reachable.
+func exchangeReachable(ec ExchangeContext) bool {
+	reachableLock.Lock()
+	defer reachableLock.Unlock()
+
+	// In degraded mode the node already knows that the exchange can not be reached.
+	if ExchangeDegraded() {
+		return false
+	}
+
+	exURL := ec.GetExchangeURL()
+	now := time.Now().Unix()
+	if checked, ok := reachableChecked[exURL]; ok && checked+REACHABLE_CHECK_INTERVAL_S > now {
+		return reachable[exURL]
+	}
+
+	var resp interface{}
+	resp = ""
+	_, tpErr := invokeExchange(ShutdownContext(), ec.GetHTTPFactory().NewHTTPClient(nil), "GET", exURL+"admin/version", ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp)
+
+	reachableChecked[exURL] = now
+	reachable[exURL] = tpErr == nil
+	if tpErr != nil {
+		glog.Warningf(cachelogString(fmt.Sprintf("exchange %v is unreachable, error: %v", exURL, tpErr)))
+	}
+	return reachable[exURL]
+}
+
+var cachelogString = func(v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("Exchange Metadata Cache", nil, v)
+	}
+	return fmt.Sprintf("Exchange Metadata Cache %v", v)
+}
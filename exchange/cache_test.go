@@ -0,0 +1,101 @@
+// +build unit
+
+package exchange
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/open-horizon/anax/config"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+type cacheTestContext struct {
+	url string
+}
+
+func (c *cacheTestContext) GetExchangeId() string    { return "myorg/node1" }
+func (c *cacheTestContext) GetExchangeToken() string { return "token" }
+func (c *cacheTestContext) GetExchangeURL() string   { return c.url }
+func (c *cacheTestContext) GetServiceBased() bool    { return true }
+func (c *cacheTestContext) GetHTTPFactory() *config.HTTPClientFactory {
+	return &config.HTTPClientFactory{
+		NewHTTPClient: func(overrideTimeoutS *uint) *http.Client { return &http.Client{Timeout: time.Second} },
+	}
+}
+
+func forgetReachable() {
+	reachableLock.Lock()
+	defer reachableLock.Unlock()
+	reachableChecked = make(map[string]int64)
+	reachable = make(map[string]bool)
+}
+
+func Test_MetadataCache(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "metadata-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := bolt.Open(path.Join(dir, "test.db"), 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("unable to open db: %v", err)
+	}
+	defer db.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		if strings.HasSuffix(r.URL.Path, "admin/version") {
+			w.Write([]byte(`"1.0.0"`))
+		} else if strings.HasSuffix(r.URL.Path, "orgs/myorg/patterns/p1") {
+			w.Write([]byte(`{"patterns":{"myorg/p1":{"label":"pattern 1"}}}`))
+		} else if strings.HasSuffix(r.URL.Path, "orgs/myorg/services") {
+			w.Write([]byte(`{"services":{"myorg/svc1_1.0.0_amd64":{"url":"http://svc1","version":"1.0.0","arch":"amd64","deployment":"{}","deploymentSignature":"sig"}}}`))
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	forgetReachable()
+	defer forgetReachable()
+
+	ec := &cacheTestContext{url: server.URL + "/"}
+	getPattern := GetCachedExchangePatternHandler(ec, db)
+	getService := GetCachedServiceHandler(ec, db)
+
+	// While the exchange is reachable, the definitions are read from the exchange and cached.
+	if pats, err := getPattern("myorg", "p1"); err != nil || pats["myorg/p1"].Label != "pattern 1" {
+		t.Fatalf("wrong pattern %v, error %v", pats, err)
+	} else if sDef, sId, err := getService("http://svc1", "myorg", "1.0.0", "amd64"); err != nil || sDef == nil || sId != "myorg/svc1_1.0.0_amd64" {
+		t.Fatalf("wrong service %v %v, error %v", sId, sDef, err)
+	}
+
+	// Once the exchange is gone, the cached copies are used.
+	server.Close()
+	forgetReachable()
+
+	if pats, err := getPattern("myorg", "p1"); err != nil || pats["myorg/p1"].Label != "pattern 1" {
+		t.Errorf("wrong cached pattern %v, error %v", pats, err)
+	} else if sDef, sId, err := getService("http://svc1", "myorg", "1.0.0", "amd64"); err != nil || sDef == nil || sId != "myorg/svc1_1.0.0_amd64" {
+		t.Errorf("wrong cached service %v %v, error %v", sId, sDef, err)
+	} else if sDef.GetDeploymentSignature() != "sig" {
+		t.Errorf("cached service should keep its deployment signature, was %v", sDef.GetDeploymentSignature())
+	}
+
+	// Unconfiguring the node removes the cache.
+	pats := make(map[string]Pattern)
+	if err := DeleteMetadataCache(db); err != nil {
+		t.Errorf("unable to delete cache: %v", err)
+	} else if found, err := readMetadataCache(db, cacheKey(PATTERN, "myorg", "p1"), &pats); err != nil || found {
+		t.Errorf("pattern should not be cached anymore, error %v", err)
+	} else if err := DeleteMetadataCache(db); err != nil {
+		t.Errorf("deleting an empty cache should not be an error: %v", err)
+	}
+}
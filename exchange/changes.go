@@ -0,0 +1,93 @@
+package exchange
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"time"
+)
+
+// The exchange keeps a feed of the resources that have changed in an org. Each change has a monotonically increasing
+// change id, so a client that remembers the most recent change id it has seen can ask for just the resources that
+// changed after that point, instead of reading all of them again.
+
+const CHANGE_RESOURCE_PATTERN = "pattern"
+const CHANGE_RESOURCE_NODE = "node"
+
+const CHANGE_OPERATION_CREATED = "created"
+const CHANGE_OPERATION_MODIFIED = "modified"
+const CHANGE_OPERATION_DELETED = "deleted"
+
+type ResourceChange struct {
+	ChangeId  uint64 `json:"changeId"`
+	Resource  string `json:"resource"`  // the type of resource that changed, e.g. pattern
+	Id        string `json:"id"`        // the org qualified id of the resource that changed
+	Operation string `json:"operation"` // created, modified or deleted
+}
+
+func (r ResourceChange) String() string {
+	return fmt.Sprintf("ChangeId: %v, Resource: %v, Id: %v, Operation: %v", r.ChangeId, r.Resource, r.Id, r.Operation)
+}
+
+type GetChangesResponse struct {
+	Changes            []ResourceChange `json:"changes"`
+	MostRecentChangeId uint64           `json:"mostRecentChangeId"`
+	Exhausted          bool             `json:"exhausted"` // the exchange no longer has all the changes since the requested id
+}
+
+func (r GetChangesResponse) String() string {
+	return fmt.Sprintf("Changes: %v, MostRecentChangeId: %v, Exhausted: %v", r.Changes, r.MostRecentChangeId, r.Exhausted)
+}
+
+// Returns the change id to resume from after these changes have been processed. When the exchange limits the
+// number of changes it returns, that is the last change returned rather than the most recent change.
+func (r GetChangesResponse) LastChangeId() uint64 {
+	last := uint64(0)
+	for _, c := range r.Changes {
+		if c.ChangeId > last {
+			last = c.ChangeId
+		}
+	}
+	if last == 0 {
+		return r.MostRecentChangeId
+	}
+	return last
+}
+
+// Get the changes to a type of resource in an org that occurred after the given change id, returning at most maxRecords
+// changes if maxRecords is not zero. A nil response and no error is returned when the exchange does not support the
+// changes feed, in which case the caller has to read all the resources.
+func GetOrgChanges(httpClientFactory *config.HTTPClientFactory, org string, resource string, since uint64, maxRecords int, exURL string, id string, token string) (*GetChangesResponse, error) {
+
+	glog.V(3).Infof(rpclogString(fmt.Sprintf("getting %v changes for %v since %v", resource, org, since)))
+
+	var resp interface{}
+	resp = new(GetChangesResponse)
+	targetURL := fmt.Sprintf("%vorgs/%v/changes?resource=%v&since=%v", exURL, org, resource, since)
+	if maxRecords != 0 {
+		targetURL += fmt.Sprintf("&maxRecords=%v", maxRecords)
+	}
+
+	for {
+		if err, tpErr := InvokeExchange(httpClientFactory.NewHTTPClient(nil), "GET", targetURL, id, token, nil, &resp); err != nil {
+			glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
+			return nil, err
+		} else if tpErr != nil {
+			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
+			time.Sleep(10 * time.Second)
+			continue
+		} else {
+			changes := resp.(*GetChangesResponse)
+
+			// Change ids start at 1, so an exchange that knows about changes always returns a most recent change id.
+			// The response is empty when the exchange returns 404 because it doesnt have the changes API.
+			if changes.MostRecentChangeId == 0 {
+				glog.V(3).Infof(rpclogString(fmt.Sprintf("exchange does not support the changes API for %v", org)))
+				return nil, nil
+			}
+
+			glog.V(3).Infof(rpclogString(fmt.Sprintf("found %v changes for %v: %v", resource, org, changes)))
+			return changes, nil
+		}
+	}
+}
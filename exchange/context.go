@@ -0,0 +1,47 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Exchange calls are made with a context.Context, so that a caller can put a deadline on a call, including its
+// retries, and so that calls can be cancelled. A wedged exchange then can't hang the caller forever. Every call is
+// also cancelled when anax shuts down, see CancelExchangeCalls. Calls made through InvokeExchange, without a
+// context of their own, are only cancelled by the shutdown.
+
+var shutdownCtx, cancelShutdown = context.WithCancel(context.Background())
+
+// The context that is cancelled when anax shuts down.
+func ShutdownContext() context.Context {
+	return shutdownCtx
+}
+
+// Cancel the exchange calls that are in flight, and fail the calls that are made after this one. This is called
+// when anax shuts down, so that workers blocked on the exchange can finish.
+func CancelExchangeCalls() {
+	cancelShutdown()
+}
+
+// Returns a context that is done when either the caller's context or the shutdown context is done. The returned
+// cancel function must be called to release the context.
+func callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	callCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-shutdownCtx.Done():
+			cancel()
+		case <-callCtx.Done():
+		}
+	}()
+	return callCtx, cancel
+}
+
+// The error returned by an exchange call that was cancelled, or ran past its deadline, before it could complete.
+func contextError(ctx context.Context, method string, url string) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return errors.New(fmt.Sprintf("Invocation of %v at %v did not complete before its deadline, error: %v", method, url, ctx.Err()))
+	}
+	return errors.New(fmt.Sprintf("Invocation of %v at %v was cancelled, error: %v", method, url, ctx.Err()))
+}
@@ -0,0 +1,49 @@
+// +build unit
+
+package exchange
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_InvokeExchangeWithContext(t *testing.T) {
+
+	defer SetRetryPolicy(GetRetryPolicy())
+	SetRetryPolicy(NewRetryPolicy(10, time.Second))
+
+	// The exchange never responds, until the test is done.
+	done := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	var resp interface{}
+	resp = ""
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err, tpErr := InvokeExchangeWithContext(ctx, &http.Client{}, "GET", server.URL, "", "", nil, &resp); err == nil || tpErr != nil {
+		t.Errorf("a call past its deadline should return an error, was %v, %v", err, tpErr)
+	} else if !strings.Contains(err.Error(), "deadline") {
+		t.Errorf("wrong error %v", err)
+	} else if time.Since(start) > 5*time.Second {
+		t.Errorf("the call should stop at its deadline, took %v", time.Since(start))
+	}
+
+	// A cancelled context fails the call before it is made.
+	cancelled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	if err, tpErr := InvokeExchangeWithContext(cancelled, &http.Client{}, "GET", server.URL, "", "", nil, &resp); err == nil || tpErr != nil {
+		t.Errorf("a cancelled call should return an error, was %v, %v", err, tpErr)
+	} else if !strings.Contains(err.Error(), "cancelled") {
+		t.Errorf("wrong error %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package exchange
+
+import (
+	"github.com/open-horizon/anax/anaxerrors"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// The interaction recorder keeps the metadata of the most recent exchange calls so that it can be included in a
+// support bundle. Only the method, the path, the outcome and the timing of a call are kept. The query string, the
+// credentials, the request and response bodies and the error text are never recorded because they can hold tokens
+// and passwords.
+
+const MAX_RECORDED_INTERACTIONS = 200
+
+const (
+	INTERACTION_OK          = "ok"
+	INTERACTION_HTTP_ERROR  = "http_error"
+	INTERACTION_UNREACHABLE = "unreachable"
+	INTERACTION_TIMEOUT     = "timeout"
+	INTERACTION_CANCELLED   = "cancelled"
+	INTERACTION_ERROR       = "error"
+)
+
+type ExchangeInteraction struct {
+	Time       int64  `json:"time"`
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	Attempt    int    `json:"attempt"`
+	Outcome    string `json:"outcome"`
+	Status     int    `json:"status,omitempty"` // the HTTP status of a failed call, when there is one
+	DurationMS int64  `json:"duration_ms"`
+}
+
+var interactionLock sync.Mutex
+var interactions []ExchangeInteraction
+var nextInteraction int
+var maxInteractions = MAX_RECORDED_INTERACTIONS
+
+// Set the number of exchange calls that are recorded, the calls already recorded are forgotten.
+func SetMaxRecordedInteractions(max int) {
+	interactionLock.Lock()
+	defer interactionLock.Unlock()
+
+	if max <= 0 {
+		max = MAX_RECORDED_INTERACTIONS
+	}
+	maxInteractions = max
+	interactions = nil
+	nextInteraction = 0
+}
+
+// Returns the recorded exchange calls, oldest first.
+func GetRecentInteractions() []ExchangeInteraction {
+	interactionLock.Lock()
+	defer interactionLock.Unlock()
+
+	res := make([]ExchangeInteraction, 0, len(interactions))
+	if len(interactions) < maxInteractions {
+		return append(res, interactions...)
+	}
+	res = append(res, interactions[nextInteraction:]...)
+	return append(res, interactions[:nextInteraction]...)
+}
+
+func recordInteraction(method string, rawURL string, attempt int, start time.Time, outcome string, err error) {
+	i := ExchangeInteraction{
+		Time:       start.Unix(),
+		Method:     method,
+		Path:       interactionPath(rawURL),
+		Attempt:    attempt,
+		Outcome:    outcome,
+		DurationMS: int64(time.Since(start) / time.Millisecond),
+	}
+	if err != nil {
+		i.Status = anaxerrors.HTTPStatus(err)
+	}
+
+	interactionLock.Lock()
+	defer interactionLock.Unlock()
+	if len(interactions) < maxInteractions {
+		interactions = append(interactions, i)
+	} else {
+		interactions[nextInteraction] = i
+	}
+	nextInteraction = (nextInteraction + 1) % maxInteractions
+}
+
+// The outcome of one attempt of an exchange call, as it is recorded.
+func interactionOutcome(err error, tpErr error) string {
+	if tpErr != nil {
+		return INTERACTION_UNREACHABLE
+	} else if err == nil {
+		return INTERACTION_OK
+	} else if anaxerrors.HTTPStatus(err) != 0 {
+		return INTERACTION_HTTP_ERROR
+	}
+	return INTERACTION_ERROR
+}
+
+// Strip the user info, the query and the fragment from a URL, they can hold credentials.
+func interactionPath(rawURL string) string {
+	if u, err := url.Parse(rawURL); err != nil {
+		return ""
+	} else {
+		u.User = nil
+		u.RawQuery = ""
+		u.Fragment = ""
+		return u.String()
+	}
+}
@@ -0,0 +1,130 @@
+package exchange
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/anaxerrors"
+	"github.com/open-horizon/anax/cutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The node and the agbot fetch their messages from their mailbox in the exchange. When the exchange supports it, the
+// fetch can ask the exchange to hold the request open (with the wait query parameter) until a message arrives or the
+// wait expires. Long polling like this delivers a message as soon as it is posted, so that a proposal and reply round
+// trip is not dominated by the polling interval. An exchange that does not support the wait parameter either rejects
+// it or answers right away. In both cases the mailbox falls back to polling at the normal interval, and long polling
+// is tried again later in case the exchange has been upgraded.
+
+const MAILBOX_LONG_POLL_RETRY_S = 3600 // seconds to poll before trying to long poll again
+
+type MailboxPoller struct {
+	lock             sync.Mutex
+	waitS            int       // seconds the exchange is asked to hold a fetch, zero when long polling is turned off
+	intervalS        int       // seconds between fetches when not long polling
+	unsupportedUntil time.Time // long polling is not tried until this time
+	lastFetch        time.Time
+	push             *mailboxPush // the push channel of the mailbox, nil when it is not used
+}
+
+func (p *MailboxPoller) String() string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return fmt.Sprintf("WaitS: %v, IntervalS: %v, UnsupportedUntil: %v, LastFetch: %v", p.waitS, p.intervalS, p.unsupportedUntil, p.lastFetch)
+}
+
+// Create a mailbox poller that long polls for waitS seconds, or polls every intervalS seconds when waitS is zero or
+// the exchange does not support long polling.
+func NewMailboxPoller(waitS int, intervalS int) *MailboxPoller {
+	if waitS < 0 {
+		waitS = 0
+	}
+	return &MailboxPoller{
+		waitS:     waitS,
+		intervalS: intervalS,
+	}
+}
+
+// Returns an HTTP client with a timeout long enough for a fetch that the exchange holds open.
+func (p *MailboxPoller) NewHTTPClient(newHTTPClient func(overrideTimeoutS *uint) *http.Client, defaultTimeoutS uint) *http.Client {
+	if p.waitS == 0 {
+		return newHTTPClient(nil)
+	}
+	timeoutS := defaultTimeoutS + uint(p.waitS)
+	return newHTTPClient(&timeoutS)
+}
+
+// Returns true when long polling is turned on and the exchange is believed to support it.
+func (p *MailboxPoller) LongPolling() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.longPolling(time.Now())
+}
+
+// While the push channel is connected, the mailbox is not long polled.
+func (p *MailboxPoller) longPolling(now time.Time) bool {
+	return p.waitS != 0 && !now.Before(p.unsupportedUntil) && !p.pushing()
+}
+
+// Returns true when the mailbox should be fetched now. Long polls are done back to back, otherwise the mailbox is
+// fetched once per interval. While the push channel is connected, the mailbox is fetched when the exchange notified
+// a message, and once per interval in case a notification was lost.
+func (p *MailboxPoller) Due() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	now := time.Now()
+	if p.pushing() {
+		return p.push.notified() || now.Sub(p.lastFetch) >= time.Duration(p.intervalS)*time.Second
+	}
+	return p.waitS == 0 || p.longPolling(now) || now.Sub(p.lastFetch) >= time.Duration(p.intervalS)*time.Second
+}
+
+// Returns the URL to fetch the mailbox at url with, and the number of seconds the exchange is asked to wait.
+func (p *MailboxPoller) FetchURL(url string) (string, int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !p.longPolling(time.Now()) {
+		return url, 0
+	}
+	sep := "?"
+	if strings.Contains(url, "?") {
+		sep = "&"
+	}
+	return fmt.Sprintf("%v%vwait=%v", url, sep, p.waitS), p.waitS
+}
+
+// Record the result of a fetch that started at the given time and asked the exchange to wait waitS seconds. Returns
+// true when the exchange was found not to support long polling, so the caller should fall back to polling.
+func (p *MailboxPoller) Fetched(waitS int, started time.Time, msgCount int, err error) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	now := time.Now()
+	p.lastFetch = now
+	if waitS == 0 {
+		return false
+	}
+
+	unsupported := false
+	if err != nil && anaxerrors.HTTPStatus(err) == http.StatusBadRequest {
+		glog.Warningf(mailboxLogString(fmt.Sprintf("exchange rejected the wait parameter, falling back to polling every %v seconds, error: %v", p.intervalS, err)))
+		unsupported = true
+	} else if err == nil && msgCount == 0 && now.Sub(started) < time.Duration(waitS)*time.Second/2 {
+		glog.Warningf(mailboxLogString(fmt.Sprintf("exchange answered without waiting for a message, falling back to polling every %v seconds", p.intervalS)))
+		unsupported = true
+	}
+
+	if unsupported {
+		p.unsupportedUntil = now.Add(MAILBOX_LONG_POLL_RETRY_S * time.Second)
+	}
+	return unsupported
+}
+
+var mailboxLogString = func(v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("Exchange Mailbox", nil, v)
+	}
+	return fmt.Sprintf("Exchange Mailbox %v", v)
+}
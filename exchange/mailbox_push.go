@@ -0,0 +1,190 @@
+package exchange
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/anaxerrors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// An exchange that supports it pushes a notification to the node or agbot when a message is posted to its mailbox,
+// over a stream of server-sent events that is held open at <mailbox url>/stream. Each event means that the mailbox
+// should be fetched, the events don't carry the messages themselves. The exchange sends a comment line now and then
+// to keep the stream open while there are no messages.
+//
+// While the stream is connected, the mailbox is fetched when a notification arrives instead of being polled or long
+// polled, and once per polling interval in case a notification was lost. The node's message worker checks for a
+// notification with Due, the agbot's message subworker waits for one with WaitForPush. When the exchange does not have the stream,
+// the mailbox falls back to (long) polling and the stream is tried again later, like long polling is.
+
+// The stream is closed and opened again when nothing, not even a keep alive, was received for this long.
+const MAILBOX_PUSH_IDLE_S = 120
+
+// The number of seconds to wait before opening the stream again after it was closed.
+const MAILBOX_PUSH_RECONNECT_S = 10
+
+type mailboxPush struct {
+	connected        bool
+	unsupportedUntil time.Time // the stream is not tried until this time
+	notify           chan bool // a notification is waiting when there is a value in the channel
+}
+
+// Use the push channel of the mailbox when the exchange has one.
+func (p *MailboxPoller) EnablePush() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.push = &mailboxPush{notify: make(chan bool, 1)}
+}
+
+func (p *MailboxPoller) pushing() bool {
+	return p.push != nil && p.push.connected
+}
+
+// Returns true while the push channel is connected.
+func (p *MailboxPoller) Pushing() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.pushing()
+}
+
+// Returns true when the push channel should be opened: it is enabled, not connected and not known to be missing.
+func (p *MailboxPoller) PushDue() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.push != nil && !p.push.connected && !time.Now().Before(p.push.unsupportedUntil)
+}
+
+// Wait for a notification from the push channel, for at most the polling interval. Returns true when there was one.
+func (p *MailboxPoller) WaitForPush() bool {
+	p.lock.Lock()
+	push := p.push
+	interval := time.Duration(p.intervalS) * time.Second
+	p.lock.Unlock()
+
+	if push == nil {
+		return false
+	}
+	select {
+	case <-push.notify:
+		return true
+	case <-time.After(interval):
+		return false
+	}
+}
+
+// Returns true when there was a notification, and takes it.
+func (m *mailboxPush) notified() bool {
+	select {
+	case <-m.notify:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *MailboxPoller) pushNotify() {
+	select {
+	case p.push.notify <- true:
+	default:
+	}
+}
+
+func (p *MailboxPoller) setPushConnected(connected bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.push.connected = connected
+}
+
+// Record that opening the push channel failed. An exchange that doesn't know the stream answers 404, or 400 or 405
+// when the stream URL looks like a message id to it.
+func (p *MailboxPoller) pushFailed(err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	switch anaxerrors.HTTPStatus(err) {
+	case http.StatusNotFound, http.StatusBadRequest, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		glog.Warningf(mailboxLogString(fmt.Sprintf("exchange does not support the mailbox push channel, falling back to polling, error: %v", err)))
+		p.push.unsupportedUntil = time.Now().Add(MAILBOX_LONG_POLL_RETRY_S * time.Second)
+	}
+}
+
+// Open the push channel of the mailbox at url and read the notifications until the stream closes, the exchange stops
+// sending on it, or the context is done. The httpClient must not have a timeout, the stream is held open. The caller
+// fetches the mailbox when WaitForPush returns.
+func (p *MailboxPoller) Subscribe(ctx context.Context, httpClient *http.Client, url string, user string, pw string) error {
+
+	if p.push == nil {
+		return errors.New(fmt.Sprintf("the mailbox push channel is not enabled"))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	streamURL := strings.TrimSuffix(url, "/") + "/stream"
+	req, err := http.NewRequest(http.MethodGet, streamURL, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Add("Accept", "text/event-stream")
+	req.Header.Add("Authorization", fmt.Sprintf("Basic %v", base64.StdEncoding.EncodeToString([]byte(user+":"+pw))))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return anaxerrors.New(anaxerrors.NETWORK, fmt.Sprintf("unable to open the mailbox push channel %v, error: %v", streamURL, err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err := anaxerrors.NewHTTPError(resp.StatusCode, fmt.Sprintf("unable to open the mailbox push channel %v, HTTP status %v", streamURL, resp.StatusCode))
+		p.pushFailed(err)
+		return err
+	}
+
+	glog.V(3).Infof(mailboxLogString(fmt.Sprintf("connected to the push channel %v", streamURL)))
+	p.setPushConnected(true)
+	defer p.setPushConnected(false)
+
+	// The messages posted before the stream was opened were not notified.
+	p.pushNotify()
+
+	// A stream that went quiet, for example because a proxy dropped the connection, is closed so that it is opened
+	// again.
+	idle := time.AfterFunc(MAILBOX_PUSH_IDLE_S*time.Second, cancel)
+	defer idle.Stop()
+
+	err = readPushEvents(resp.Body, func() { idle.Reset(MAILBOX_PUSH_IDLE_S * time.Second) }, p.pushNotify)
+	if ctx.Err() != nil {
+		return errors.New(fmt.Sprintf("mailbox push channel %v closed: %v", streamURL, ctx.Err()))
+	}
+	return err
+}
+
+// Read server-sent events from the stream. received is called for every line, event for every event. An event is a
+// group of field lines that ends with an empty line, lines that start with a colon are comments.
+func readPushEvents(stream io.Reader, received func(), event func()) error {
+	scanner := bufio.NewScanner(stream)
+	fields := 0
+	for scanner.Scan() {
+		received()
+		line := scanner.Text()
+		if line == "" {
+			if fields != 0 {
+				event()
+			}
+			fields = 0
+		} else if !strings.HasPrefix(line, ":") {
+			fields++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
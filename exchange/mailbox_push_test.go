@@ -0,0 +1,119 @@
+// +build unit
+
+package exchange
+
+import (
+	"context"
+	"github.com/open-horizon/anax/anaxerrors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_readPushEvents(t *testing.T) {
+
+	stream := ": keep alive\n\ndata: {\"msgId\":1}\n\nevent: message\ndata: {\"msgId\":2}\n\n\n: keep alive\n\ndata: 3\n"
+
+	lines, events := 0, 0
+	readPushEvents(strings.NewReader(stream), func() { lines++ }, func() { events++ })
+	if lines != 11 {
+		t.Errorf("expected 11 lines, got %v", lines)
+	} else if events != 2 {
+		t.Errorf("expected 2 events, the comments and the unterminated event are not events, got %v", events)
+	}
+}
+
+// While the push channel is connected, the mailbox is not long polled and is fetched when the exchange notifies a
+// message. Once the stream closes, the mailbox is long polled again.
+func Test_MailboxPoller_push(t *testing.T) {
+
+	events := make(chan string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/msgs/stream" || r.Header.Get("Accept") != "text/event-stream" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		} else if user, pw, ok := r.BasicAuth(); !ok || user != "org/node" || pw != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for ev := range events {
+			w.Write([]byte(ev))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer server.Close()
+
+	p := NewMailboxPoller(30, 10)
+	p.EnablePush()
+	if !p.PushDue() {
+		t.Fatalf("the push channel should be due")
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- p.Subscribe(context.Background(), http.DefaultClient, server.URL+"/msgs", "org/node", "token")
+	}()
+
+	for i := 0; i < 50 && !p.Pushing(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !p.Pushing() {
+		t.Fatalf("the push channel should be connected")
+	} else if p.LongPolling() {
+		t.Errorf("the mailbox should not be long polled while the push channel is connected")
+	} else if url, _ := p.FetchURL(server.URL + "/msgs"); url != server.URL+"/msgs" {
+		t.Errorf("url should not have a wait parameter while pushing, was %v", url)
+	} else if !p.Due() {
+		t.Errorf("fetch should be due right after the push channel connects")
+	}
+
+	p.Fetched(0, time.Now(), 0, nil)
+	if p.Due() {
+		t.Errorf("fetch should not be due until the exchange notifies a message")
+	}
+
+	events <- ": keep alive\n\n"
+	events <- "data: {}\n\n"
+	if !p.WaitForPush() {
+		t.Errorf("a notification should have been received")
+	} else if p.Due() {
+		t.Errorf("the notification should have been taken")
+	}
+
+	close(events)
+	if err := <-done; err == nil {
+		t.Errorf("subscribe should return an error when the stream closes")
+	} else if p.Pushing() {
+		t.Errorf("the push channel should be disconnected")
+	} else if !p.LongPolling() {
+		t.Errorf("the mailbox should be long polled again")
+	} else if !p.PushDue() {
+		t.Errorf("the push channel should be due again")
+	}
+}
+
+// An exchange that does not have the push channel is polled, and the push channel is not tried again for a while.
+func Test_MailboxPoller_push_unsupported(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	p := NewMailboxPoller(0, 10)
+	p.EnablePush()
+	if err := p.Subscribe(context.Background(), http.DefaultClient, server.URL+"/msgs", "org/node", "token"); anaxerrors.HTTPStatus(err) != http.StatusNotFound {
+		t.Errorf("expected a not found error, got %v", err)
+	} else if p.Pushing() {
+		t.Errorf("the push channel should not be connected")
+	} else if p.PushDue() {
+		t.Errorf("the push channel should not be tried again right away")
+	} else if !p.Due() {
+		t.Errorf("the mailbox should be polled")
+	}
+}
@@ -0,0 +1,84 @@
+// +build unit
+
+package exchange
+
+import (
+	"github.com/open-horizon/anax/anaxerrors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_MailboxPoller_off(t *testing.T) {
+
+	p := NewMailboxPoller(0, 10)
+	if p.LongPolling() {
+		t.Errorf("long polling should be off")
+	} else if url, waitS := p.FetchURL("http://exchange/msgs"); url != "http://exchange/msgs" || waitS != 0 {
+		t.Errorf("url should not have a wait parameter, was %v %v", url, waitS)
+	} else if !p.Due() {
+		t.Errorf("fetch should always be due when the worker decides the interval")
+	}
+}
+
+func Test_MailboxPoller_rejected(t *testing.T) {
+
+	p := NewMailboxPoller(30, 10)
+	url, waitS := p.FetchURL("http://exchange/msgs")
+	if url != "http://exchange/msgs?wait=30" || waitS != 30 {
+		t.Errorf("url should have a wait parameter, was %v %v", url, waitS)
+	}
+
+	if !p.Fetched(waitS, time.Now(), 0, anaxerrors.NewHTTPError(http.StatusBadRequest, "bad request")) {
+		t.Errorf("a rejected wait parameter should fall back to polling")
+	} else if p.LongPolling() {
+		t.Errorf("long polling should be off after the exchange rejected it")
+	} else if url, _ := p.FetchURL("http://exchange/msgs"); url != "http://exchange/msgs" {
+		t.Errorf("url should not have a wait parameter after the fallback, was %v", url)
+	} else if p.Due() {
+		t.Errorf("fetch should not be due until the interval has passed")
+	}
+}
+
+// An exchange that ignores the wait parameter answers right away, and the poller falls back to polling. An exchange
+// that holds the request open keeps being long polled.
+func Test_MailboxPoller_exchange(t *testing.T) {
+
+	holdS := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("wait") != "" {
+			time.Sleep(time.Duration(holdS) * time.Second)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"messages":[],"lastIndex":0}`))
+	}))
+	defer server.Close()
+
+	fetch := func(p *MailboxPoller) {
+		var resp interface{}
+		resp = new(GetDeviceMessageResponse)
+		url, waitS := p.FetchURL(server.URL + "/orgs/myorg/nodes/mynode/msgs")
+		started := time.Now()
+		err, tpErr := InvokeExchange(http.DefaultClient, "GET", url, "myorg/mynode", "token", nil, &resp)
+		if tpErr != nil {
+			t.Fatalf("unexpected transport error: %v", tpErr)
+		}
+		p.Fetched(waitS, started, len(resp.(*GetDeviceMessageResponse).Messages), err)
+	}
+
+	ignored := NewMailboxPoller(2, 10)
+	fetch(ignored)
+	if ignored.LongPolling() {
+		t.Errorf("long polling should be off when the exchange answers right away")
+	}
+
+	holdS = 1
+	supported := NewMailboxPoller(2, 10)
+	fetch(supported)
+	if !supported.LongPolling() {
+		t.Errorf("long polling should stay on when the exchange holds the request")
+	} else if !supported.Due() {
+		t.Errorf("long polls should be done back to back")
+	}
+}
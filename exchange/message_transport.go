@@ -0,0 +1,86 @@
+package exchange
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Functions and types related to the transport of protocol messages. The messages between nodes and agbots go
+// through the exchange mailbox of the receiver by default. Deployments where the exchange mailbox is disabled, or
+// where the nodes are behind NAT and the exchange is not the place to hold their messages, can configure a relay
+// instead. A relay is a message broker that serves the same mailbox API as the exchange, orgs/{org}/nodes/{id}/msgs
+// and orgs/{org}/agbots/{id}/msgs, and accepts the exchange credentials of the nodes and agbots. The messages are
+// encrypted and signed end to end, so the relay can neither read nor change them. The relay is called the same way
+// as the exchange, so a relay that can't be reached counts toward the node's degraded mode like the exchange does.
+//
+// Both parties have to use the same transport, so a relay is configured for a whole deployment, on the nodes and
+// on the agbots.
+
+const MSG_TRANSPORT_EXCHANGE = "exchange"
+const MSG_TRANSPORT_RELAY = "relay"
+
+type MessageTransport struct {
+	Name string // one of the MSG_TRANSPORT_ constants
+	URL  string // the URL that the mailbox paths are appended to, ending with a /
+}
+
+func (t MessageTransport) String() string {
+	return fmt.Sprintf("Name: %v, URL: %v", t.Name, t.URL)
+}
+
+// Returns the relay if one is configured, otherwise the exchange mailbox.
+func NewMessageTransport(exchangeURL string, relayURL string) *MessageTransport {
+	if relayURL != "" {
+		if !strings.HasSuffix(relayURL, "/") {
+			relayURL += "/"
+		}
+		return &MessageTransport{Name: MSG_TRANSPORT_RELAY, URL: relayURL}
+	}
+	return &MessageTransport{Name: MSG_TRANSPORT_EXCHANGE, URL: exchangeURL}
+}
+
+// The transport of the node's messages.
+func EdgeMessageTransport(cfg *config.HorizonConfig) *MessageTransport {
+	return NewMessageTransport(cfg.Edge.ExchangeURL, cfg.Edge.MessageRelayURL)
+}
+
+// The transport of the agbot's messages.
+func AgbotMessageTransport(cfg *config.HorizonConfig) *MessageTransport {
+	return NewMessageTransport(cfg.AgreementBot.ExchangeURL, cfg.AgreementBot.MessageRelayURL)
+}
+
+func (t *MessageTransport) IsRelay() bool {
+	return t.Name == MSG_TRANSPORT_RELAY
+}
+
+// The URL of the mailbox of a node.
+func (t *MessageTransport) NodeMailboxURL(nodeId string) string {
+	return t.URL + "orgs/" + GetOrg(nodeId) + "/nodes/" + GetId(nodeId) + "/msgs"
+}
+
+// The URL of the mailbox of an agbot.
+func (t *MessageTransport) AgbotMailboxURL(agbotId string) string {
+	return t.URL + "orgs/" + GetOrg(agbotId) + "/agbots/" + GetId(agbotId) + "/msgs"
+}
+
+// Post a message into a mailbox. Transient errors are retried until the message is accepted.
+func (t *MessageTransport) Post(httpClient *http.Client, mailboxURL string, senderId string, senderToken string, pm *PostMessage) error {
+	var resp interface{}
+	resp = new(PostDeviceResponse)
+	for {
+		if err, tpErr := InvokeExchange(httpClient, "POST", mailboxURL, senderId, senderToken, pm, &resp); err != nil {
+			return err
+		} else if tpErr != nil {
+			glog.Warningf(tpErr.Error())
+			time.Sleep(10 * time.Second)
+			continue
+		} else {
+			glog.V(5).Infof(rpclogString(fmt.Sprintf("posted message to %v through the %v", mailboxURL, t.Name)))
+			return nil
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// +build unit
+
+package exchange
+
+import (
+	"encoding/json"
+	"github.com/open-horizon/anax/config"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_MessageTransport(t *testing.T) {
+
+	cfg := &config.HorizonConfig{
+		Edge:         config.Config{ExchangeURL: "https://exchange/v1/"},
+		AgreementBot: config.AGConfig{ExchangeURL: "https://exchange/v1/"},
+	}
+
+	if tr := EdgeMessageTransport(cfg); tr.IsRelay() || tr.NodeMailboxURL("myorg/node1") != "https://exchange/v1/orgs/myorg/nodes/node1/msgs" {
+		t.Errorf("expected the exchange mailbox, got %v", tr)
+	}
+
+	cfg.Edge.MessageRelayURL = "https://relay:8443"
+	cfg.AgreementBot.MessageRelayURL = "https://relay:8443/"
+	if tr := EdgeMessageTransport(cfg); !tr.IsRelay() || tr.AgbotMailboxURL("myorg/ag1") != "https://relay:8443/orgs/myorg/agbots/ag1/msgs" {
+		t.Errorf("expected the relay, got %v", tr)
+	}
+	if tr := AgbotMessageTransport(cfg); !tr.IsRelay() || tr.NodeMailboxURL("myorg/node1") != "https://relay:8443/orgs/myorg/nodes/node1/msgs" {
+		t.Errorf("expected the relay, got %v", tr)
+	}
+}
+
+func Test_MessageTransport_Post(t *testing.T) {
+
+	var posted PostMessage
+	var path, user string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		user, _, _ = r.BasicAuth()
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &posted)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"code":"ok","msg":"message added"}`))
+	}))
+	defer server.Close()
+
+	tr := NewMessageTransport("https://exchange/v1/", server.URL)
+	pm := CreatePostMessage([]byte("an encrypted message, at least 32 bytes long"), 300)
+	if err := tr.Post(&http.Client{Timeout: time.Second}, tr.NodeMailboxURL("myorg/node1"), "myorg/ag1", "token", pm); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if path != "/orgs/myorg/nodes/node1/msgs" {
+		t.Errorf("message posted to the wrong mailbox %v", path)
+	} else if user != "myorg/ag1" {
+		t.Errorf("message posted with the wrong credentials %v", user)
+	} else if string(posted.Message) != "an encrypted message, at least 32 bytes long" {
+		t.Errorf("wrong message posted %v", posted)
+	}
+}
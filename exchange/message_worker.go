@@ -7,10 +7,13 @@ import (
 	"github.com/boltdb/bolt"
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/faults"
 	"github.com/open-horizon/anax/persistence"
 	"github.com/open-horizon/anax/worker"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -18,9 +21,16 @@ type ExchangeMessageWorker struct {
 	worker.BaseWorker // embedded field
 	db                *bolt.DB
 	httpClient        *http.Client
-	pattern           string // device pattern
+	pattern           string         // device pattern
+	mailbox           *MailboxPoller // decides when and how the node's mailbox is fetched
+	pushClient        *http.Client   // holds the push channel of the mailbox open, without a timeout
 }
 
+// The number of seconds between fetches of the node's mailbox when the exchange is not long polled.
+const MESSAGE_POLL_INTERVAL_S = 10
+
+const MESSAGE_PUSH = "MessagePush"
+
 func NewExchangeMessageWorker(name string, cfg *config.HorizonConfig, db *bolt.DB) *ExchangeMessageWorker {
 
 	var ec *worker.BaseExchangeContext
@@ -30,14 +40,31 @@ func NewExchangeMessageWorker(name string, cfg *config.HorizonConfig, db *bolt.D
 		pattern = dev.Pattern
 	}
 
+	mailbox := NewMailboxPoller(cfg.Edge.ExchangeMessageWaitS, MESSAGE_POLL_INTERVAL_S)
+	var pushClient *http.Client
+	if cfg.Edge.ExchangeMessagePush {
+		mailbox.EnablePush()
+		noTimeout := uint(0)
+		pushClient = cfg.Collaborators.HTTPClientFactory.NewHTTPClient(&noTimeout)
+	}
+
 	worker := &ExchangeMessageWorker{
 		BaseWorker: worker.NewBaseWorker(name, cfg, ec),
 		db:         db,
-		httpClient: cfg.Collaborators.HTTPClientFactory.NewHTTPClient(nil),
+		httpClient: mailbox.NewHTTPClient(cfg.Collaborators.HTTPClientFactory.NewHTTPClient, cfg.Edge.DefaultHTTPClientTimeoutS),
 		pattern:    pattern,
+		mailbox:    mailbox,
+		pushClient: pushClient,
 	}
 
-	worker.Start(worker, 10)
+	// When long polling, the next fetch starts as soon as the previous one returns, and a pushed notification is
+	// checked for every second. The mailbox poller keeps the fetches to the normal interval if the exchange turns
+	// out not to support them.
+	if cfg.Edge.ExchangeMessageWaitS > 0 || cfg.Edge.ExchangeMessagePush {
+		worker.Start(worker, 1)
+	} else {
+		worker.Start(worker, MESSAGE_POLL_INTERVAL_S)
+	}
 	return worker
 }
 
@@ -75,11 +102,22 @@ func (w *ExchangeMessageWorker) Initialize() bool {
 			time.Sleep(5 * time.Second)
 		}
 	}
+
+	if w.Config.Edge.ExchangeMessagePush {
+		w.DispatchSubworker(MESSAGE_PUSH, w.pushMessages, 1)
+	}
 	return true
 }
 
 func (w *ExchangeMessageWorker) NoWorkHandler() {
-	// Pull messages from the exchange and send them out as individual events.
+	// Pull messages from the exchange and send them out as individual events. While the exchange is unreachable
+	// there is nothing to pull, the messages stay in the mailbox until the node leaves degraded mode.
+	if ExchangeDegraded() {
+		glog.V(5).Infof(logString(fmt.Sprintf("exchange is unreachable, not retrieving messages")))
+		return
+	} else if !w.mailbox.Due() {
+		return
+	}
 	glog.V(5).Infof(logString(fmt.Sprintf("retrieving messages from the exchange")))
 
 	if msgs, err := w.getMessages(); err != nil {
@@ -90,6 +128,12 @@ func (w *ExchangeMessageWorker) NoWorkHandler() {
 
 			glog.V(3).Infof(logString(fmt.Sprintf("reading message %v from the exchange", msg.MsgId)))
 
+			// Test builds can simulate a message that is lost in the mailbox.
+			if faults.Inject(faults.MAILBOX_DROP) {
+				w.deleteMessage(msg.MsgId)
+				continue
+			}
+
 			// First get my own keys
 			_, myPrivKey, _ := GetKeys("")
 
@@ -103,6 +147,10 @@ func (w *ExchangeMessageWorker) NoWorkHandler() {
 			} else if mBytes, err := json.Marshal(msg); err != nil {
 				glog.Errorf(logString(fmt.Sprintf("error marshalling message %v, error: %v", msg, err)))
 			} else {
+				// An agbot that sends sealed messages can read them.
+				if IsSealedExchangeMessage(msg.Message) {
+					SetMessageSealing(msg.AgbotId, true)
+				}
 				em := events.NewExchangeDeviceMessage(events.RECEIVED_EXCHANGE_DEV_MSG, mBytes, string(protocolMessage))
 				w.Messages() <- em
 			}
@@ -111,26 +159,72 @@ func (w *ExchangeMessageWorker) NoWorkHandler() {
 
 }
 
+// Hold the push channel of the node's mailbox open, and open it again when it closes. The NoWorkHandler fetches the
+// mailbox when the exchange notifies a message.
+func (w *ExchangeMessageWorker) pushMessages() int {
+	if ExchangeDegraded() || !w.mailbox.PushDue() {
+		return MAILBOX_PUSH_RECONNECT_S
+	}
+
+	mailboxURL := EdgeMessageTransport(w.Manager.Config).NodeMailboxURL(w.GetExchangeId())
+	if err := w.mailbox.Subscribe(w.Context(), w.pushClient, mailboxURL, w.GetExchangeId(), w.GetExchangeToken()); err != nil {
+		glog.V(3).Infof(logString(fmt.Sprintf("mailbox push channel closed, error: %v", err)))
+	}
+	return MAILBOX_PUSH_RECONNECT_S
+}
+
 func (w *ExchangeMessageWorker) getMessages() ([]DeviceMessage, error) {
 	var resp interface{}
 	resp = new(GetDeviceMessageResponse)
-	targetURL := w.Manager.Config.Edge.ExchangeURL + "orgs/" + GetOrg(w.GetExchangeId()) + "/nodes/" + GetId(w.GetExchangeId()) + "/msgs"
+	mailboxURL := EdgeMessageTransport(w.Manager.Config).NodeMailboxURL(w.GetExchangeId())
 	for {
+		targetURL, waitS := w.mailbox.FetchURL(mailboxURL)
+		started := time.Now()
 		if err, tpErr := InvokeExchange(w.httpClient, "GET", targetURL, w.GetExchangeId(), w.GetExchangeToken(), nil, &resp); err != nil {
+			if w.mailbox.Fetched(waitS, started, 0, err) {
+				continue
+			}
 			glog.Errorf(err.Error())
 			return nil, err
+		} else if tpErr != nil && ExchangeDegraded() {
+			return nil, tpErr
 		} else if tpErr != nil {
 			glog.Warningf(tpErr.Error())
 			time.Sleep(10 * time.Second)
 			continue
 		} else {
-			glog.V(3).Infof(logString(fmt.Sprintf("retrieved %v messages", len(resp.(*GetDeviceMessageResponse).Messages))))
 			msgs := resp.(*GetDeviceMessageResponse).Messages
+			w.mailbox.Fetched(waitS, started, len(msgs), nil)
+			glog.V(3).Infof(logString(fmt.Sprintf("retrieved %v messages", len(msgs))))
 			return msgs, nil
 		}
 	}
 }
 
+func (w *ExchangeMessageWorker) deleteMessage(msgId int) error {
+	var resp interface{}
+	resp = new(PostDeviceResponse)
+	targetURL := EdgeMessageTransport(w.Manager.Config).NodeMailboxURL(w.GetExchangeId()) + "/" + strconv.Itoa(msgId)
+	for {
+		if err, tpErr := InvokeExchange(w.httpClient, "DELETE", targetURL, w.GetExchangeId(), w.GetExchangeToken(), nil, &resp); err != nil {
+			glog.Errorf(logString(err.Error()))
+			return err
+		} else if tpErr != nil && ExchangeDegraded() {
+			return tpErr
+		} else if tpErr != nil {
+			glog.Warningf(logString(tpErr.Error()))
+			time.Sleep(10 * time.Second)
+			continue
+		} else {
+			glog.V(3).Infof(logString(fmt.Sprintf("deleted message %v", msgId)))
+			return nil
+		}
+	}
+}
+
 var logString = func(v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("ExchangeMessageWorker", nil, v)
+	}
 	return fmt.Sprintf("ExchangeMessageWorker %v", v)
 }
@@ -7,6 +7,7 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
@@ -16,6 +17,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
+	"sync"
+	"time"
 )
 
 // This module is used to construct a message that can be sent over an insecure transport
@@ -48,10 +52,37 @@ func (self ExchangeMessage) String() string {
 	return res
 }
 
+// The sealed form of an ExchangeMessage, used by the agreement protocol versions that seal their messages. The
+// wrapped message is padded before it is encrypted, so that the size of the message in the exchange mailbox does
+// not tell which protocol message it is, and the envelope names the receiver key it was encrypted to, so that a
+// receiver that has rotated its keys knows which of its private keys to decrypt it with. The envelope version and
+// the key id are authenticated with the encrypted parts of the message, so that they cannot be altered.
+const SEALED_ENVELOPE_VERSION = 2
+
+// The size of the sealed wrapped messages is a multiple of this.
+const SEALED_PAD_SIZE = 2048
+
+type SealedExchangeMessage struct {
+	Envelope        int                      `json:"envelope"`
+	KeyId           string                   `json:"keyId"`
+	WrappedMessage  EncryptedWrappedMessage  `json:"wrappedMessage"`
+	SymmetricValues EncryptedSymmetricValues `json:"symmetricValues"`
+}
+
+func (self SealedExchangeMessage) String() string {
+	return fmt.Sprintf("Envelope: %v, KeyId: %v, Wrapped Message: %v\n SymmetricValues: %v\n", self.Envelope, self.KeyId, self.WrappedMessage, self.SymmetricValues)
+}
+
+// The data that the encrypted parts of a sealed message are bound to.
+func (self SealedExchangeMessage) additionalData() []byte {
+	return []byte(fmt.Sprintf("%v/%v", self.Envelope, self.KeyId))
+}
+
 type WrappedMessage struct {
 	Msg          []byte `json:"msg"`
 	Signature    []byte `json:"signature"`
 	SignerPubKey []byte `json:"signerPubkey"`
+	Padding      string `json:"padding,omitempty"` // only in sealed messages
 }
 
 type SymmetricValues struct {
@@ -70,6 +101,61 @@ type SymmetricValues struct {
 
 func ConstructExchangeMessage(message []byte, senderPublicKey *rsa.PublicKey, senderPrivateKey *rsa.PrivateKey, receiverPublicKey *rsa.PublicKey) (*ExchangeMessage, error) {
 
+	glog.V(6).Infof("Creating ExchangeMessage for %s", message)
+
+	// Steps 1 to 3, sign and wrap the message.
+	wrappedMessage, err := wrapMessage(message, senderPublicKey, senderPrivateKey, receiverPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Steps 4 to 6, encrypt the wrapped message and the symmetric values.
+	encryptedMessage, encryptedSymmetricValues, err := encryptWrappedMessage(wrappedMessage, receiverPublicKey, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// 7. construct an ExchangeMessage from the encrypted WrappedMessage and the encrypted SymmetricValues.
+
+	return newExchangeMessage(encryptedMessage, encryptedSymmetricValues), nil
+
+}
+
+// A sealed ExchangeMessage is constructed the same way, except that the WrappedMessage is padded before it is
+// encrypted, and both encryptions are bound to the envelope version and the id of the receiver key.
+func ConstructSealedExchangeMessage(message []byte, senderPublicKey *rsa.PublicKey, senderPrivateKey *rsa.PrivateKey, receiverPublicKey *rsa.PublicKey) (*SealedExchangeMessage, error) {
+
+	glog.V(6).Infof("Creating sealed ExchangeMessage for %s", message)
+
+	wrappedMessage, err := wrapMessage(message, senderPublicKey, senderPrivateKey, receiverPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keyId, err := KeyId(receiverPublicKey)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error getting the id of the receiver public key, error %v", err))
+	}
+
+	sealed := &SealedExchangeMessage{
+		Envelope: SEALED_ENVELOPE_VERSION,
+		KeyId:    keyId,
+	}
+
+	if err := padWrappedMessage(wrappedMessage, SEALED_PAD_SIZE); err != nil {
+		return nil, err
+	}
+
+	if sealed.WrappedMessage, sealed.SymmetricValues, err = encryptWrappedMessage(wrappedMessage, receiverPublicKey, sealed.additionalData()); err != nil {
+		return nil, err
+	}
+
+	return sealed, nil
+}
+
+// Steps 1 to 3 of constructing an ExchangeMessage, which are the same for both forms of the message.
+func wrapMessage(message []byte, senderPublicKey *rsa.PublicKey, senderPrivateKey *rsa.PrivateKey, receiverPublicKey *rsa.PublicKey) (*WrappedMessage, error) {
+
 	// Up front sanity checks
 	if len(message) == 0 {
 		return nil, errors.New(fmt.Sprintf("Error message has length zero"))
@@ -79,9 +165,7 @@ func ConstructExchangeMessage(message []byte, senderPublicKey *rsa.PublicKey, se
 		return nil, errors.New(fmt.Sprintf("Private key is not valid"))
 	}
 
-	// Start constructing the message
 	err := error(nil)
-	glog.V(6).Infof("Creating ExchangeMessage for %s", message)
 
 	// 1. create a sha3 hash of the original message, called the message digest.
 	// Digital signing can be an expensive operation, so we will be signing the hash because
@@ -110,12 +194,35 @@ func ConstructExchangeMessage(message []byte, senderPublicKey *rsa.PublicKey, se
 		return nil, errors.New(fmt.Sprintf("Error marshalling sender public key, returned empty byte array"))
 	}
 
-	wrappedMessage := &WrappedMessage{
+	return &WrappedMessage{
 		Msg:          message,
 		Signature:    signature,
 		SignerPubKey: pubKey,
+	}, nil
+}
+
+// Pad the wrapped message so that its serialized form is a multiple of the pad size.
+func padWrappedMessage(wrappedMessage *WrappedMessage, padSize int) error {
+
+	wrappedMessage.Padding = ""
+	wmBytes, err := json.Marshal(wrappedMessage)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error marshalling wrapped message, error %v", err))
 	}
 
+	// The padding field adds its name and quotes to the serialized message, as well as the padding itself.
+	overhead := len(`,"padding":""`)
+	size := ((len(wmBytes) + overhead + padSize - 1) / padSize) * padSize
+	wrappedMessage.Padding = strings.Repeat("0", size-len(wmBytes)-overhead)
+	return nil
+}
+
+// Steps 4 to 6 of constructing an ExchangeMessage. The additional data is authenticated by both encryptions, it
+// is nil for the messages that are not sealed.
+func encryptWrappedMessage(wrappedMessage *WrappedMessage, receiverPublicKey *rsa.PublicKey, additionalData []byte) (EncryptedWrappedMessage, EncryptedSymmetricValues, error) {
+
+	err := error(nil)
+
 	// 4. symmetrically encrypt the WrappedMessage with a random symmetric key and nonce.
 	// We need to encrypt the original message, digital signature and the public key to make them unreadable to
 	// 3rd parties. Symmetric encryption is faster than public/private key encryption, so we will
@@ -125,19 +232,19 @@ func ConstructExchangeMessage(message []byte, senderPublicKey *rsa.PublicKey, se
 
 	var wmBytes []byte
 	if wmBytes, err = json.Marshal(wrappedMessage); err != nil {
-		return nil, errors.New(fmt.Sprintf("Error marshalling wrapped message, error %v", err))
+		return nil, nil, errors.New(fmt.Sprintf("Error marshalling wrapped message, error %v", err))
 	} else if len(wmBytes) == 0 {
-		return nil, errors.New(fmt.Sprintf("Error marshalling wrapped message, returned empty byte array"))
+		return nil, nil, errors.New(fmt.Sprintf("Error marshalling wrapped message, returned empty byte array"))
 	} else {
 		glog.V(6).Infof("Created Wrapped Message %s", wmBytes)
 	}
 
 	var encryptedMessage EncryptedWrappedMessage
 	var symmetricKey, nonce []byte
-	if encryptedMessage, symmetricKey, nonce, err = symmetricallyEncrypt(wmBytes); err != nil {
-		return nil, errors.New(fmt.Sprintf("Error symmetrically encrypting %v", err))
+	if encryptedMessage, symmetricKey, nonce, err = symmetricallyEncryptWithData(wmBytes, additionalData); err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("Error symmetrically encrypting %v", err))
 	} else if len(encryptedMessage) == 0 || len(symmetricKey) == 0 || len(nonce) == 0 {
-		return nil, errors.New(fmt.Sprintf("Error symmetrically encrypting, one of encrypted message %v, symmetric key %v, or nonce %v is an empty byte array", encryptedMessage, symmetricKey, nonce))
+		return nil, nil, errors.New(fmt.Sprintf("Error symmetrically encrypting, one of encrypted message %v, symmetric key %v, or nonce %v is an empty byte array", encryptedMessage, symmetricKey, nonce))
 	} else {
 		glog.V(6).Infof("Encrypted wrapped message  %x", encryptedMessage)
 	}
@@ -155,30 +262,66 @@ func ConstructExchangeMessage(message []byte, senderPublicKey *rsa.PublicKey, se
 
 	var svBytes []byte
 	if svBytes, err = json.Marshal(symmetricValues); err != nil {
-		return nil, errors.New(fmt.Sprintf("Error marshalling symmetric values, error %v", err))
+		return nil, nil, errors.New(fmt.Sprintf("Error marshalling symmetric values, error %v", err))
 	} else if len(svBytes) == 0 {
-		return nil, errors.New(fmt.Sprintf("Error marshalling symmetric values, returned empty byte array"))
+		return nil, nil, errors.New(fmt.Sprintf("Error marshalling symmetric values, returned empty byte array"))
 	}
 
 	// 6. encrypt the SymmetricValues using the public key of the intended receiver.
 	// Since this data is small, we can use public/private key encryption on it. We will encrypt
-	// using the receiver's public key so that only the receiver can decrypt.
+	// using the receiver's public key so that only the receiver can decrypt. The label binds the
+	// symmetric values to the envelope of a sealed message.
 
-	// What's the purpose of the label?
-	label := []byte("")
+	label := additionalData
+	if label == nil {
+		label = []byte("")
+	}
 
 	if encryptedSymmetricValues, err = rsa.EncryptOAEP(sha3.New256(), rand.Reader, receiverPublicKey, svBytes, label); err != nil {
-		return nil, errors.New(fmt.Sprintf("Error encrypting symmetric values, error %v", err))
+		return nil, nil, errors.New(fmt.Sprintf("Error encrypting symmetric values, error %v", err))
 	} else if len(encryptedSymmetricValues) == 0 {
-		return nil, errors.New(fmt.Sprintf("Error encrypting symmetric values, returned empty byte array"))
+		return nil, nil, errors.New(fmt.Sprintf("Error encrypting symmetric values, returned empty byte array"))
 	} else {
 		glog.V(6).Infof("Encrypted SymmetricValues %x", encryptedSymmetricValues)
 	}
 
-	// 7. construct an ExchangeMessage from the encrypted WrappedMessage and the encrypted SymmetricValues.
+	return encryptedMessage, encryptedSymmetricValues, nil
+}
 
-	return newExchangeMessage(encryptedMessage, encryptedSymmetricValues), nil
+// Construct the message in the sealed form when seal is true, otherwise in the original form.
+func ConstructMessage(message []byte, seal bool, senderPublicKey *rsa.PublicKey, senderPrivateKey *rsa.PrivateKey, receiverPublicKey *rsa.PublicKey) (interface{}, error) {
+	if seal {
+		return ConstructSealedExchangeMessage(message, senderPublicKey, senderPrivateKey, receiverPublicKey)
+	}
+	return ConstructExchangeMessage(message, senderPublicKey, senderPrivateKey, receiverPublicKey)
+}
+
+// Returns true when the message in the exchange is a sealed ExchangeMessage.
+func IsSealedExchangeMessage(encryptedMessage []byte) bool {
+	em := new(SealedExchangeMessage)
+	return json.Unmarshal(encryptedMessage, em) == nil && em.Envelope >= SEALED_ENVELOPE_VERSION
+}
+
+// The parties that messages are sealed for, keyed by exchange id. A party is added when it negotiates an agreement
+// protocol version that seals messages or when it sends a sealed message, and is removed when it negotiates a version
+// that does not. The other parties might not be able to read sealed messages, they get the original form.
+var sealedReceivers = make(map[string]bool)
+var sealedReceiversLock sync.Mutex
+
+func SetMessageSealing(receiverId string, sealed bool) {
+	sealedReceiversLock.Lock()
+	defer sealedReceiversLock.Unlock()
+	if sealed {
+		sealedReceivers[receiverId] = true
+	} else {
+		delete(sealedReceivers, receiverId)
+	}
+}
 
+func MessageSealing(receiverId string) bool {
+	sealedReceiversLock.Lock()
+	defer sealedReceiversLock.Unlock()
+	return sealedReceivers[receiverId]
 }
 
 // Here is an overview of what happens in order to deconstruct a secure ExchangeMessage. To more deeply
@@ -189,6 +332,10 @@ func ConstructExchangeMessage(message []byte, senderPublicKey *rsa.PublicKey, se
 // 3. use the symmetric key and nonce to decrypt the WrappedMessage
 // 4. verify the signature of the hash of the message
 // 5. extract the plain text message
+//
+// Both forms of the ExchangeMessage are deconstructed by this function. When the receiver's private key is the one
+// being used by this runtime, the messages that were encrypted to its previous key before the keys were rotated are
+// also deconstructed.
 
 func DeconstructExchangeMessage(encryptedMessage []byte, receiverPrivateKey *rsa.PrivateKey) ([]byte, *rsa.PublicKey, error) {
 
@@ -205,13 +352,16 @@ func DeconstructExchangeMessage(encryptedMessage []byte, receiverPrivateKey *rsa
 
 	// 1. receive the encrypted WrappedMessage and SymmetricValues.
 	// The encrypted values of these two fields in the message are assumed to have been base64 encoded
-	// when placed into the message that is put "on the wire".
+	// when placed into the message that is put "on the wire". A message without an envelope version
+	// is not sealed.
 
-	em := new(ExchangeMessage)
+	em := new(SealedExchangeMessage)
 	if err = json.Unmarshal(encryptedMessage, &em); err != nil {
 		return nil, nil, errors.New(fmt.Sprintf("Error unmarshalling exchange message %s, error %v", encryptedMessage, err))
 	} else if len(em.WrappedMessage) == 0 || len(em.SymmetricValues) == 0 {
 		return nil, nil, errors.New(fmt.Sprintf("Error unmarshalling exchange message, one of wrapped message %v or symmetric values %v has length zero.", em.WrappedMessage, em.SymmetricValues))
+	} else if em.Envelope != 0 && em.Envelope != SEALED_ENVELOPE_VERSION {
+		return nil, nil, errors.New(fmt.Sprintf("Error unsupported exchange message envelope version %v", em.Envelope))
 	}
 
 	glog.V(6).Infof("Encrypted Wrapped Message  %x", em.WrappedMessage)
@@ -219,13 +369,28 @@ func DeconstructExchangeMessage(encryptedMessage []byte, receiverPrivateKey *rsa
 
 	// 2. decrypt the symmetric values using the receiver's private key.
 	// The SymmetricValues section includes the key and nonce needed to decrypt the wrapped message
-	// section where the business logic message resides.
+	// section where the business logic message resides. A sealed message names the key it was
+	// encrypted to, otherwise each of the receiver's keys is tried.
 
-	// Decrypt symmetric values
-	// What's the purpose of the label?
+	var additionalData []byte
 	label := []byte("")
+	privateKeys := receiverKeys(receiverPrivateKey)
+	if em.Envelope != 0 {
+		additionalData = em.additionalData()
+		label = additionalData
+		if privateKeys, err = keysWithId(privateKeys, em.KeyId); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Decrypt symmetric values
 	var receivedSymValues []byte
-	if receivedSymValues, err = rsa.DecryptOAEP(sha3.New256(), rand.Reader, receiverPrivateKey, em.SymmetricValues, label); err != nil {
+	for _, privateKey := range privateKeys {
+		if receivedSymValues, err = rsa.DecryptOAEP(sha3.New256(), rand.Reader, privateKey, em.SymmetricValues, label); err == nil {
+			break
+		}
+	}
+	if err != nil {
 		return nil, nil, errors.New(fmt.Sprintf("Error decrypting Symmetric values from message, error %v", err))
 	}
 
@@ -240,7 +405,7 @@ func DeconstructExchangeMessage(encryptedMessage []byte, receiverPrivateKey *rsa
 	// The WrappedMessage section is very long, so it was symmetrically encrypted because it's faster.
 
 	var receivedDecryptedMessage []byte
-	if receivedDecryptedMessage, err = symmetricallyDecrypt(em.WrappedMessage, sv.Key, sv.Nonce); err != nil {
+	if receivedDecryptedMessage, err = symmetricallyDecryptWithData(em.WrappedMessage, sv.Key, sv.Nonce, additionalData); err != nil {
 		return nil, nil, errors.New(fmt.Sprintf("Error decrypting message: %v", err))
 	} else {
 		glog.V(6).Infof("Decrypted Wrapped Message %s", receivedDecryptedMessage)
@@ -279,6 +444,60 @@ func DeconstructExchangeMessage(encryptedMessage []byte, receiverPrivateKey *rsa
 	return wm.Msg, receivedPubKey, nil
 }
 
+// The private keys that a message to the receiver might have been encrypted to. The previous key of this runtime is
+// kept after the keys are rotated, for the messages that were sent before the new public key was published.
+func receiverKeys(receiverPrivateKey *rsa.PrivateKey) []*rsa.PrivateKey {
+	keys := []*rsa.PrivateKey{receiverPrivateKey}
+	keyLock.Lock()
+	defer keyLock.Unlock()
+	if receiverPrivateKey == gPrivateKey && gPreviousPrivateKey != nil {
+		keys = append(keys, gPreviousPrivateKey)
+	}
+	return keys
+}
+
+// Returns the keys that have the key id.
+func keysWithId(privateKeys []*rsa.PrivateKey, keyId string) ([]*rsa.PrivateKey, error) {
+	for _, privateKey := range privateKeys {
+		if id, err := KeyId(&privateKey.PublicKey); err == nil && id == keyId {
+			return []*rsa.PrivateKey{privateKey}, nil
+		}
+	}
+	return nil, errors.New(fmt.Sprintf("Error message was sealed for key %v, which is not one of the receiver's keys", keyId))
+}
+
+// The id of a public key, which is the start of the sha3 hash of its serialized form.
+func KeyId(key *rsa.PublicKey) (string, error) {
+	if pubKey, err := MarshalPublicKey(key); err != nil {
+		return "", err
+	} else {
+		digest := sha3.Sum256(pubKey)
+		return hex.EncodeToString(digest[:16]), nil
+	}
+}
+
+// Sign a batch of already encrypted messages so that the exchange can verify that the batch was
+// produced by the holder of the private key, without having to verify each message in it.
+func SignMessageBatch(content []byte, signerPrivateKey *rsa.PrivateKey) ([]byte, error) {
+
+	if len(content) == 0 {
+		return nil, errors.New(fmt.Sprintf("Error message batch has length zero"))
+	} else if signerPrivateKey == nil {
+		return nil, errors.New(fmt.Sprintf("Error signer private key is nil"))
+	}
+
+	digest := sha3.Sum256(content)
+
+	if signature, err := rsa.SignPSS(rand.Reader, signerPrivateKey, crypto.SHA3_256, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto}); err != nil {
+		return nil, errors.New(fmt.Sprintf("Error signing the message batch, error: %v", err))
+	} else if len(signature) == 0 {
+		return nil, errors.New(fmt.Sprintf("Error signing the message batch, signature is empty byte array"))
+	} else {
+		glog.V(6).Infof("Signed message batch digest %x", digest)
+		return signature, nil
+	}
+}
+
 // Helper function that uses the PKI X.509 library to serialize an RSA key.
 func MarshalPublicKey(key *rsa.PublicKey) ([]byte, error) {
 
@@ -311,6 +530,11 @@ func DemarshalPublicKey(serializedKey []byte) (*rsa.PublicKey, error) {
 // Helper function to symmetrically encrypt a hunk of data using a given key and nonce with the
 // GCM block mode cipher.
 func symmetricallyEncrypt(data []byte) ([]byte, []byte, []byte, error) {
+	return symmetricallyEncryptWithData(data, nil)
+}
+
+// The additional data is authenticated along with the encrypted data, but not encrypted.
+func symmetricallyEncryptWithData(data []byte, additionalData []byte) ([]byte, []byte, []byte, error) {
 
 	// Generate 1 time use symmetric key for encryption of the message
 	symmetricKey := make([]byte, 32) // 256 bit symmetric key
@@ -332,11 +556,11 @@ func symmetricallyEncrypt(data []byte) ([]byte, []byte, []byte, error) {
 	} else if gcmCipher, err := cipher.NewGCM(blockCipher); err != nil {
 		return nil, nil, nil, errors.New(fmt.Sprintf("Error getting GCM block cipher object, error %v", err))
 	} else {
-		// Encrypt the message. We are not using the additional data feature of the GCM
-		// algorithm becuse we dont think we need it. This is because we are wrapping this whole
-		// symmetrically encrypted message inside a public key encryption that also includes a digital
-		// signature.
-		encryptedMessage = gcmCipher.Seal(nil, nonce, data, nil)
+		// Encrypt the message. The additional data feature of the GCM algorithm is only used by
+		// sealed messages, to bind the envelope to the message. Otherwise we dont think we need it,
+		// because we are wrapping this whole symmetrically encrypted message inside a public key
+		// encryption that also includes a digital signature.
+		encryptedMessage = gcmCipher.Seal(nil, nonce, data, additionalData)
 	}
 	return encryptedMessage, symmetricKey, nonce, nil
 }
@@ -344,6 +568,10 @@ func symmetricallyEncrypt(data []byte) ([]byte, []byte, []byte, error) {
 // Helper function to symmetrically decrypt a hunk of data using a given key and nonce with the
 // GCM block mode cipher.
 func symmetricallyDecrypt(data []byte, key []byte, nonce []byte) ([]byte, error) {
+	return symmetricallyDecryptWithData(data, key, nonce, nil)
+}
+
+func symmetricallyDecryptWithData(data []byte, key []byte, nonce []byte, additionalData []byte) ([]byte, error) {
 
 	if len(nonce) != 12 {
 		return nil, errors.New(fmt.Sprintf("Error nonce must be 12 bytes long"))
@@ -359,7 +587,7 @@ func symmetricallyDecrypt(data []byte, key []byte, nonce []byte) ([]byte, error)
 	} else {
 
 		// Decrypt the message
-		if receivedDecryptedMessage, err = gcmCipher.Open(nil, nonce, data, nil); err != nil {
+		if receivedDecryptedMessage, err = gcmCipher.Open(nil, nonce, data, additionalData); err != nil {
 			return nil, errors.New(fmt.Sprintf("Error decrypting message, error %v", err))
 		}
 	}
@@ -373,6 +601,8 @@ func symmetricallyDecrypt(data []byte, key []byte, nonce []byte) ([]byte, error)
 
 var gPublicKey *rsa.PublicKey
 var gPrivateKey *rsa.PrivateKey
+var gPreviousPrivateKey *rsa.PrivateKey // the private key before the keys were last rotated, if any
+var keyLock sync.Mutex
 
 func HasKeys() bool {
 	if gPublicKey != nil {
@@ -383,9 +613,13 @@ func HasKeys() bool {
 
 var privFileName = "privateMessagingKey.pem"
 var pubFileName = "publicMessagingKey.pem"
+var prevPrivFileName = "previousPrivateMessagingKey.pem"
 
 func GetKeys(keyPath string) (*rsa.PublicKey, *rsa.PrivateKey, error) {
 
+	keyLock.Lock()
+	defer keyLock.Unlock()
+
 	if gPublicKey != nil {
 		return gPublicKey, gPrivateKey, nil
 	}
@@ -459,9 +693,71 @@ func GetKeys(keyPath string) (*rsa.PublicKey, *rsa.PrivateKey, error) {
 		}
 	}
 
+	// After the keys are rotated, the previous private key is still needed for the messages in flight.
+	prevFilepath := path.Join(os.Getenv("SNAP_COMMON"), keyPath, prevPrivFileName)
+	if _, ferr := os.Stat(prevFilepath); ferr == nil {
+		if previousKey, err := readPrivateKey(prevFilepath); err != nil {
+			glog.Errorf(fmt.Sprintf("Unable to read the previous private key, messages sent to it cannot be decrypted, error: %v", err))
+		} else {
+			gPreviousPrivateKey = previousKey
+		}
+	}
+
 	return gPublicKey, gPrivateKey, nil
 }
 
+func readPrivateKey(privFilepath string) (*rsa.PrivateKey, error) {
+	if privBytes, err := ioutil.ReadFile(privFilepath); err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to read private key file %v, error: %v", privFilepath, err))
+	} else if privBlock, _ := pem.Decode(privBytes); privBlock == nil {
+		return nil, errors.New(fmt.Sprintf("Unable to extract pem block from private key file %v", privFilepath))
+	} else if privateKey, err := x509.ParsePKCS1PrivateKey(privBlock.Bytes); err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to parse private key in %v, error: %v", privFilepath, err))
+	} else {
+		return privateKey, nil
+	}
+}
+
+// Replace the RSA keys being used by this runtime with new ones. The previous private key is kept, so that the
+// messages that were encrypted to the previous public key can still be deconstructed until they are all read. The
+// caller has to publish the new public key in the exchange.
+func RotateKeys(keyPath string) (*rsa.PublicKey, *rsa.PrivateKey, error) {
+
+	if _, _, err := GetKeys(keyPath); err != nil {
+		return nil, nil, err
+	}
+
+	privFilepath := path.Join(os.Getenv("SNAP_COMMON"), keyPath, privFileName)
+	pubFilepath := path.Join(os.Getenv("SNAP_COMMON"), keyPath, pubFileName)
+	prevFilepath := path.Join(os.Getenv("SNAP_COMMON"), keyPath, prevPrivFileName)
+
+	keyLock.Lock()
+	if err := os.Rename(privFilepath, prevFilepath); err != nil {
+		keyLock.Unlock()
+		return nil, nil, errors.New(fmt.Sprintf("Could not move private key file %v to %v, error %v", privFilepath, prevFilepath, err))
+	} else if err := os.Remove(pubFilepath); err != nil && !os.IsNotExist(err) {
+		keyLock.Unlock()
+		return nil, nil, errors.New(fmt.Sprintf("Could not remove public key file %v, error %v", pubFilepath, err))
+	}
+	gPublicKey = nil
+	gPrivateKey = nil
+	gPreviousPrivateKey = nil
+	keyLock.Unlock()
+
+	glog.V(3).Infof("Rotating the messaging keys in %v", path.Join(os.Getenv("SNAP_COMMON"), keyPath))
+	return GetKeys(keyPath)
+}
+
+// Returns the time that the RSA keys being used by this runtime were created.
+func KeysCreated(keyPath string) (time.Time, error) {
+	privFilepath := path.Join(os.Getenv("SNAP_COMMON"), keyPath, privFileName)
+	if info, err := os.Stat(privFilepath); err != nil {
+		return time.Time{}, err
+	} else {
+		return info.ModTime(), nil
+	}
+}
+
 func DeleteKeys(keyPath string) error {
 	// Construct the full file path name
 	privFilepath := path.Join(os.Getenv("SNAP_COMMON"), keyPath, privFileName)
@@ -482,5 +778,12 @@ func DeleteKeys(keyPath string) error {
 		}
 	}
 
+	prevFilepath := path.Join(os.Getenv("SNAP_COMMON"), keyPath, prevPrivFileName)
+	if _, ferr := os.Stat(prevFilepath); !os.IsNotExist(ferr) {
+		if err := os.Remove(prevFilepath); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
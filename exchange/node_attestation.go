@@ -0,0 +1,165 @@
+package exchange
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/policy"
+	"golang.org/x/crypto/sha3"
+	"net/http"
+	"time"
+)
+
+// Functions and types related to node attestations. A node can attest to the properties that it measures itself, its
+// hardware architecture, memory and GPUs, by signing them with its messaging key. The public messaging key is in the
+// node's exchange resource, so an agbot can verify that the properties came from the node and were not changed by
+// whoever can update the node's policy. A business policy that requires attestation is only matched against the
+// attested values of those properties.
+
+type NodeAttestation struct {
+	Properties policy.PropertyList `json:"properties"` // the properties the node measured
+	Time       uint64              `json:"time"`       // when the properties were signed, in seconds since 1970
+	Signature  string              `json:"signature"`  // the base64 encoded signature of the properties and time
+}
+
+func (a NodeAttestation) String() string {
+	return fmt.Sprintf("Properties: %v, Time: %v, Signature: %v", a.Properties, a.Time, a.Signature != "")
+}
+
+// The digest that is signed. The properties are serialized the same way on both sides, numbers that went through the
+// exchange as json serialize the same as the numbers the node signed.
+func (a *NodeAttestation) digest() ([]byte, error) {
+	signed := struct {
+		Properties policy.PropertyList `json:"properties"`
+		Time       uint64              `json:"time"`
+	}{Properties: a.Properties, Time: a.Time}
+
+	if content, err := json.Marshal(signed); err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to serialize attested properties %v, error: %v", a.Properties, err))
+	} else {
+		digest := sha3.Sum256(content)
+		return digest[:], nil
+	}
+}
+
+// Sign the properties with the node's private messaging key.
+func NewNodeAttestation(properties policy.PropertyList, t uint64, privateKey *rsa.PrivateKey) (*NodeAttestation, error) {
+
+	if privateKey == nil {
+		return nil, errors.New(fmt.Sprintf("Error signer private key is nil"))
+	}
+
+	a := &NodeAttestation{Properties: properties, Time: t}
+	digest, err := a.digest()
+	if err != nil {
+		return nil, err
+	}
+
+	if signature, err := rsa.SignPSS(rand.Reader, privateKey, crypto.SHA3_256, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto}); err != nil {
+		return nil, errors.New(fmt.Sprintf("Error signing the node attestation, error: %v", err))
+	} else {
+		a.Signature = base64.StdEncoding.EncodeToString(signature)
+		return a, nil
+	}
+}
+
+// Verify the attestation with the node's serialized public messaging key, as it is in the node's exchange resource.
+func (a *NodeAttestation) Verify(serializedKey []byte) error {
+
+	if a.Signature == "" {
+		return errors.New(fmt.Sprintf("the node has not attested to its properties"))
+	} else if len(serializedKey) == 0 {
+		return errors.New(fmt.Sprintf("the node has no public key"))
+	}
+
+	publicKey, err := DemarshalPublicKey(serializedKey)
+	if err != nil {
+		return errors.New(fmt.Sprintf("unable to demarshal the public key of the node, error: %v", err))
+	}
+	signature, err := base64.StdEncoding.DecodeString(a.Signature)
+	if err != nil {
+		return errors.New(fmt.Sprintf("unable to decode the signature of the node attestation, error: %v", err))
+	}
+	digest, err := a.digest()
+	if err != nil {
+		return err
+	}
+
+	if err := rsa.VerifyPSS(publicKey, crypto.SHA3_256, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto}); err != nil {
+		return errors.New(fmt.Sprintf("the signature of the node attestation is not valid, error: %v", err))
+	}
+	return nil
+}
+
+// Replace the node's claims about the attested properties with the attested values. The properties the node did not
+// attest to are kept.
+func (a *NodeAttestation) Apply(properties policy.PropertyList) policy.PropertyList {
+	attested := make(map[string]bool)
+	for _, prop := range a.Properties {
+		attested[prop.Name] = true
+	}
+	result := make(policy.PropertyList, 0, len(properties)+len(a.Properties))
+	for _, prop := range properties {
+		if !attested[prop.Name] {
+			result = append(result, prop)
+		}
+	}
+	return append(result, a.Properties...)
+}
+
+// Publish the attestation of a node.
+func PutNodeAttestation(httpClient *http.Client, deviceId string, exURL string, id string, token string, attestation *NodeAttestation) error {
+
+	glog.V(3).Infof(rpclogString(fmt.Sprintf("putting attestation of node %v", deviceId)))
+
+	var resp interface{}
+	resp = new(PostDeviceResponse)
+	targetURL := exURL + "orgs/" + GetOrg(deviceId) + "/nodes/" + GetId(deviceId) + "/attestation"
+	for {
+		if err, tpErr := InvokeExchange(httpClient, "PUT", targetURL, id, token, attestation, &resp); err != nil {
+			glog.Errorf(rpclogString(err.Error()))
+			return err
+		} else if tpErr != nil {
+			if ExchangeDegraded() {
+				return tpErr
+			}
+			glog.Warningf(rpclogString(tpErr.Error()))
+			time.Sleep(10 * time.Second)
+			continue
+		} else {
+			glog.V(5).Infof(rpclogString(fmt.Sprintf("put attestation of node %v: %v", deviceId, attestation)))
+			return nil
+		}
+	}
+}
+
+// Get the attestation of a node. A node that has not attested to its properties has an empty attestation, without
+// a signature.
+func GetNodeAttestation(ctx context.Context, httpClient *http.Client, deviceId string, exURL string, id string, token string) (*NodeAttestation, error) {
+
+	glog.V(3).Infof(rpclogString(fmt.Sprintf("getting attestation of node %v", deviceId)))
+
+	var resp interface{}
+	resp = new(NodeAttestation)
+	targetURL := exURL + "orgs/" + GetOrg(deviceId) + "/nodes/" + GetId(deviceId) + "/attestation"
+	for {
+		if err, tpErr := InvokeExchangeWithContext(ctx, httpClient, "GET", targetURL, id, token, nil, &resp); err != nil {
+			glog.Errorf(rpclogString(err.Error()))
+			return nil, err
+		} else if tpErr != nil {
+			glog.Warningf(rpclogString(tpErr.Error()))
+			time.Sleep(10 * time.Second)
+			continue
+		} else {
+			attestation := resp.(*NodeAttestation)
+			glog.V(5).Infof(rpclogString(fmt.Sprintf("found attestation of node %v, %v", deviceId, attestation)))
+			return attestation, nil
+		}
+	}
+}
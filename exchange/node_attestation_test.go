@@ -0,0 +1,82 @@
+// +build unit
+
+package exchange
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"github.com/open-horizon/anax/policy"
+	"testing"
+)
+
+func Test_NodeAttestation(t *testing.T) {
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+	serializedKey, err := MarshalPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("unable to marshal public key: %v", err)
+	}
+
+	props := policy.PropertyList{
+		*policy.Property_Factory("arch", "amd64"),
+		*policy.Property_Factory("gpu", true),
+		*policy.Property_Factory("memoryMB", 4096),
+	}
+	att, err := NewNodeAttestation(props, 1560000000, privateKey)
+	if err != nil {
+		t.Fatalf("unable to sign attestation: %v", err)
+	}
+
+	// The attestation is verified after a trip through the exchange, where the numbers become float64.
+	var fromExchange NodeAttestation
+	if serial, err := json.Marshal(att); err != nil {
+		t.Fatalf("unable to marshal attestation: %v", err)
+	} else if err := json.Unmarshal(serial, &fromExchange); err != nil {
+		t.Fatalf("unable to unmarshal attestation: %v", err)
+	} else if err := fromExchange.Verify(serializedKey); err != nil {
+		t.Errorf("attestation should verify: %v", err)
+	}
+
+	// A changed property does not verify.
+	fromExchange.Properties[1] = *policy.Property_Factory("gpu", false)
+	if err := fromExchange.Verify(serializedKey); err == nil {
+		t.Errorf("a changed attestation should not verify")
+	}
+
+	// Neither does an attestation signed with another key, or without a signature.
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherSerialized, _ := MarshalPublicKey(&otherKey.PublicKey)
+	if err := att.Verify(otherSerialized); err == nil {
+		t.Errorf("attestation should not verify with another key")
+	} else if err := (&NodeAttestation{}).Verify(serializedKey); err == nil {
+		t.Errorf("an empty attestation should not verify")
+	} else if err := att.Verify(nil); err == nil {
+		t.Errorf("attestation should not verify without a key")
+	}
+}
+
+func Test_NodeAttestation_Apply(t *testing.T) {
+
+	att := &NodeAttestation{Properties: policy.PropertyList{*policy.Property_Factory("gpu", false)}}
+	claimed := policy.PropertyList{
+		*policy.Property_Factory("purpose", "test"),
+		*policy.Property_Factory("gpu", true),
+	}
+
+	applied := att.Apply(claimed)
+	if len(applied) != 2 {
+		t.Fatalf("expected 2 properties, got %v", applied)
+	}
+	for _, prop := range applied {
+		if prop.Name == "gpu" && prop.Value != false {
+			t.Errorf("the attested gpu value should win, got %v", applied)
+		}
+	}
+	if len(claimed) != 2 || claimed[1].Value != true {
+		t.Errorf("the claimed properties should not change, got %v", claimed)
+	}
+}
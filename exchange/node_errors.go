@@ -0,0 +1,58 @@
+package exchange
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"net/http"
+	"time"
+)
+
+// Functions and types related to the errors that a node reports to the exchange. The errors are shown to the owner of
+// the node, for problems that the node handled on its own but the owner should know about. The node always PUTs the
+// complete list of its errors, an error that is no longer in the list is removed from the exchange.
+
+type NodeError struct {
+	RecordId  string `json:"record_id"`  // identifies the error, the same problem has the same record id
+	Message   string `json:"message"`    // a description of the error for the owner of the node
+	EventCode string `json:"event_code"` // the kind of error
+	Hidden    bool   `json:"hidden"`     // the owner dismissed the error
+}
+
+func (n NodeError) String() string {
+	return fmt.Sprintf("RecordId: %v, Message: %v, EventCode: %v, Hidden: %v", n.RecordId, n.Message, n.EventCode, n.Hidden)
+}
+
+type PutNodeErrorsRequest struct {
+	Errors []NodeError `json:"errors"`
+}
+
+func (p PutNodeErrorsRequest) String() string {
+	return fmt.Sprintf("Errors: %v", p.Errors)
+}
+
+// Replace the errors of a node in the exchange. An empty list clears the errors of the node.
+func PutNodeErrors(httpClient *http.Client, deviceId string, exURL string, id string, token string, nodeErrors []NodeError) error {
+
+	glog.V(3).Infof(rpclogString(fmt.Sprintf("putting %v errors of node %v", len(nodeErrors), deviceId)))
+
+	var resp interface{}
+	resp = new(PostDeviceResponse)
+	req := PutNodeErrorsRequest{Errors: nodeErrors}
+	targetURL := exURL + "orgs/" + GetOrg(deviceId) + "/nodes/" + GetId(deviceId) + "/errors"
+	for {
+		if err, tpErr := InvokeExchange(httpClient, "PUT", targetURL, id, token, &req, &resp); err != nil {
+			glog.Errorf(rpclogString(err.Error()))
+			return err
+		} else if tpErr != nil {
+			if ExchangeDegraded() {
+				return tpErr
+			}
+			glog.Warningf(rpclogString(tpErr.Error()))
+			time.Sleep(10 * time.Second)
+			continue
+		} else {
+			glog.V(5).Infof(rpclogString(fmt.Sprintf("put errors of node %v: %v", deviceId, req)))
+			return nil
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/policy"
+	"net/http"
+	"time"
+)
+
+// Functions and types related to working with node policies. The owner of a node that is not using a pattern
+// publishes a node policy to the exchange. The properties of the node policy are matched against the constraints of
+// the business policies, and the constraints of the node policy against the properties of the business policies. The
+// agbot only deploys the service of a business policy to the node when both sides match.
+
+type NodePolicy struct {
+	Properties  policy.PropertyList     `json:"properties,omitempty"`  // the properties of the node
+	Constraints policy.RequiredProperty `json:"constraints,omitempty"` // the business policy properties required to run a service on the node
+	LastUpdated string                  `json:"lastUpdated,omitempty"`
+}
+
+func (n NodePolicy) String() string {
+	return fmt.Sprintf("Properties: %v, Constraints: %v, LastUpdated: %v", n.Properties, n.Constraints, n.LastUpdated)
+}
+
+// Make sure that the properties have names and that the constraints are a valid expression.
+func (n *NodePolicy) IsValid() error {
+	for _, prop := range n.Properties {
+		if prop.Name == "" {
+			return errors.New(fmt.Sprintf("a property of the node policy has no name, its value is %v", prop.Value))
+		}
+	}
+	if len(n.Constraints) != 0 {
+		if err := n.Constraints.IsValid(); err != nil {
+			return errors.New(fmt.Sprintf("the constraints of the node policy are not valid, error %v", err))
+		}
+	}
+	return nil
+}
+
+// Get the node policy of a node. A node that has not published a node policy has no properties and no constraints,
+// so an empty node policy is returned for it.
+func GetNodePolicy(ctx context.Context, httpClient *http.Client, deviceId string, exURL string, id string, token string) (*NodePolicy, error) {
+
+	glog.V(3).Infof(rpclogString(fmt.Sprintf("getting node policy for %v", deviceId)))
+
+	var resp interface{}
+	resp = new(NodePolicy)
+	targetURL := exURL + "orgs/" + GetOrg(deviceId) + "/nodes/" + GetId(deviceId) + "/policy"
+	for {
+		if err, tpErr := InvokeExchangeWithContext(ctx, httpClient, "GET", targetURL, id, token, nil, &resp); err != nil {
+			glog.Errorf(rpclogString(err.Error()))
+			return nil, err
+		} else if tpErr != nil {
+			glog.Warningf(rpclogString(tpErr.Error()))
+			time.Sleep(10 * time.Second)
+			continue
+		} else {
+			nodePolicy := resp.(*NodePolicy)
+			glog.V(5).Infof(rpclogString(fmt.Sprintf("found node policy for %v, %v", deviceId, nodePolicy)))
+			return nodePolicy, nil
+		}
+	}
+}
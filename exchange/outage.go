@@ -0,0 +1,109 @@
+package exchange
+
+import (
+	"fmt"
+	"github.com/open-horizon/anax/anaxerrors"
+	"sync"
+	"time"
+)
+
+// The node runs in degraded mode while the exchange has been unreachable for longer than the outage threshold. In
+// degraded mode the workloads that are already running keep running, but the node stops doing the things that need the
+// exchange (polling for messages, agreement verification, status reports, service upgrades) instead of retrying
+// them over and over. Every exchange call made through InvokeExchange tells the tracker whether the exchange could be
+// reached. The node heartbeat decides when degraded mode starts and ends, see UpdateOutageState.
+
+const DEFAULT_OUTAGE_THRESHOLD_S = 300
+
+type OutageState struct {
+	Degraded         bool   `json:"degraded"`
+	DegradedSince    int64  `json:"degraded_since,omitempty"`    // when degraded mode was entered
+	UnreachableSince int64  `json:"unreachable_since,omitempty"` // when the exchange stopped responding, 0 while it responds
+	LastContact      int64  `json:"last_contact,omitempty"`      // when the exchange last responded
+	LastError        string `json:"last_error,omitempty"`        // the error of the last call that could not reach the exchange
+}
+
+func (s OutageState) String() string {
+	return fmt.Sprintf("Degraded: %v, DegradedSince: %v, UnreachableSince: %v, LastContact: %v, LastError: %v", s.Degraded, s.DegradedSince, s.UnreachableSince, s.LastContact, s.LastError)
+}
+
+var outageLock sync.Mutex
+var outageThresholdS = int64(DEFAULT_OUTAGE_THRESHOLD_S)
+var outageState OutageState
+
+// Set the number of seconds the exchange can be unreachable before the node enters degraded mode.
+func SetOutageThreshold(seconds int) {
+	outageLock.Lock()
+	defer outageLock.Unlock()
+	if seconds > 0 {
+		outageThresholdS = int64(seconds)
+	} else {
+		outageThresholdS = DEFAULT_OUTAGE_THRESHOLD_S
+	}
+}
+
+// Returns true while the node is in degraded mode.
+func ExchangeDegraded() bool {
+	outageLock.Lock()
+	defer outageLock.Unlock()
+	return outageState.Degraded
+}
+
+func GetOutageState() OutageState {
+	outageLock.Lock()
+	defer outageLock.Unlock()
+	return outageState
+}
+
+// Decide whether the node should be in degraded mode, based on the exchange calls made since the last time this
+// function was called. Returns the new state, and true when degraded mode was entered or left by this call.
+func UpdateOutageState() (OutageState, bool) {
+	outageLock.Lock()
+	defer outageLock.Unlock()
+
+	now := time.Now().Unix()
+	degraded := outageState.UnreachableSince != 0 && now-outageState.UnreachableSince >= outageThresholdS
+	if degraded == outageState.Degraded {
+		return outageState, false
+	}
+
+	outageState.Degraded = degraded
+	if degraded {
+		outageState.DegradedSince = now
+	} else {
+		outageState.DegradedSince = 0
+	}
+	return outageState, true
+}
+
+// Called when a call has reached the exchange, even if the exchange returned an error.
+func recordExchangeContact() {
+	outageLock.Lock()
+	defer outageLock.Unlock()
+	outageState.UnreachableSince = 0
+	outageState.LastContact = time.Now().Unix()
+}
+
+// Called when a call could not reach the exchange.
+func recordExchangeUnreachable(err error) {
+	outageLock.Lock()
+	defer outageLock.Unlock()
+	if outageState.UnreachableSince == 0 {
+		outageState.UnreachableSince = time.Now().Unix()
+	}
+	outageState.LastError = err.Error()
+}
+
+// Remember whether the exchange could be reached by one attempt of an exchange call. Errors with an HTTP status were
+// returned by the exchange, except for the statuses a proxy in front of the exchange returns when the exchange is down.
+func recordExchangeAttempt(err error, tpErr error) {
+	if tpErr != nil {
+		recordExchangeUnreachable(tpErr)
+	} else if err == nil {
+		recordExchangeContact()
+	} else if exchangeErrorStatus(err) != 0 {
+		recordExchangeUnreachable(err)
+	} else if anaxerrors.HTTPStatus(err) != 0 {
+		recordExchangeContact()
+	}
+}
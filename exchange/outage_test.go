@@ -0,0 +1,76 @@
+// +build unit
+
+package exchange
+
+import (
+	"errors"
+	"github.com/open-horizon/anax/anaxerrors"
+	"testing"
+	"time"
+)
+
+func resetOutageState() {
+	outageState = OutageState{}
+	SetOutageThreshold(0)
+}
+
+func Test_recordExchangeAttempt(t *testing.T) {
+
+	resetOutageState()
+	defer resetOutageState()
+
+	refused := errors.New("dial tcp 127.0.0.1:8080: connect: connection refused")
+
+	recordExchangeAttempt(nil, refused)
+	if state := GetOutageState(); state.UnreachableSince == 0 || state.LastError != refused.Error() {
+		t.Errorf("transport error should mark the exchange unreachable, state is %v", state)
+	}
+
+	// A proxy in front of the exchange answering for it does not count as contact.
+	recordExchangeAttempt(anaxerrors.NewHTTPError(503, "Invocation of GET at url failed, status: 503, response: "), nil)
+	if state := GetOutageState(); state.UnreachableSince == 0 || state.LastContact != 0 {
+		t.Errorf("503 should not count as contact with the exchange, state is %v", state)
+	}
+
+	// An error response from the exchange itself means it can be reached.
+	recordExchangeAttempt(anaxerrors.NewHTTPError(404, "Invocation of GET at url failed, status: 404, response: "), nil)
+	if state := GetOutageState(); state.UnreachableSince != 0 || state.LastContact == 0 {
+		t.Errorf("404 should count as contact with the exchange, state is %v", state)
+	}
+
+	// Errors that are not from the exchange change nothing.
+	outageState.LastContact = 0
+	recordExchangeAttempt(errors.New("unable to demarshal response"), nil)
+	if state := GetOutageState(); state.UnreachableSince != 0 || state.LastContact != 0 {
+		t.Errorf("local error should not change the state, state is %v", state)
+	}
+}
+
+func Test_UpdateOutageState(t *testing.T) {
+
+	resetOutageState()
+	defer resetOutageState()
+
+	SetOutageThreshold(60)
+	refused := errors.New("dial tcp 127.0.0.1:8080: connect: connection refused")
+
+	// Unreachable for less than the threshold.
+	recordExchangeAttempt(nil, refused)
+	if state, changed := UpdateOutageState(); changed || state.Degraded || ExchangeDegraded() {
+		t.Errorf("node should not be degraded before the threshold, state is %v", state)
+	}
+
+	// Unreachable for longer than the threshold.
+	outageState.UnreachableSince = time.Now().Unix() - 61
+	if state, changed := UpdateOutageState(); !changed || !state.Degraded || state.DegradedSince == 0 || !ExchangeDegraded() {
+		t.Errorf("node should enter degraded mode, state is %v", state)
+	} else if _, changed := UpdateOutageState(); changed {
+		t.Errorf("node should stay in degraded mode without a change")
+	}
+
+	// The exchange responds again.
+	recordExchangeAttempt(nil, nil)
+	if state, changed := UpdateOutageState(); !changed || state.Degraded || state.DegradedSince != 0 || ExchangeDegraded() {
+		t.Errorf("node should leave degraded mode, state is %v", state)
+	}
+}
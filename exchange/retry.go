@@ -0,0 +1,105 @@
+package exchange
+
+import (
+	"fmt"
+	"github.com/open-horizon/anax/anaxerrors"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The longest time to wait between two attempts of the same exchange call, no matter how many attempts came before.
+const MAX_RETRY_INTERVAL = 60 * time.Second
+
+// A RetryPolicy describes how transient exchange errors are retried. The interval between attempts doubles after
+// every attempt, up to MAX_RETRY_INTERVAL, and a random jitter of up to half the interval is added so that many
+// clients that failed at the same time do not all retry at the same time.
+type RetryPolicy struct {
+	MaxRetries    int           // The number of retries after the first attempt. Zero means calls are not retried.
+	RetryInterval time.Duration // The time to wait before the first retry.
+}
+
+func NewRetryPolicy(maxRetries int, retryInterval time.Duration) *RetryPolicy {
+	if maxRetries < 0 {
+		maxRetries = 0
+	}
+	if retryInterval < 0 {
+		retryInterval = 0
+	}
+	return &RetryPolicy{
+		MaxRetries:    maxRetries,
+		RetryInterval: retryInterval,
+	}
+}
+
+// Returns the time to wait before the given retry, where 1 is the first retry.
+func (r *RetryPolicy) Backoff(retry int) time.Duration {
+	interval := r.RetryInterval
+	for i := 1; i < retry && interval < MAX_RETRY_INTERVAL; i++ {
+		interval *= 2
+	}
+	if interval > MAX_RETRY_INTERVAL {
+		interval = MAX_RETRY_INTERVAL
+	}
+	if interval <= 0 {
+		return 0
+	}
+	return interval + time.Duration(rand.Int63n(int64(interval)/2+1))
+}
+
+// Returns true if the given attempt of a call should be retried. A call that fails before it reaches the exchange
+// is always safe to retry. GET, PUT and DELETE are idempotent, so they are also retried when the request might have
+// reached the exchange, i.e. when the connection timed out or was reset, or when the exchange (or a proxy in front
+// of it) says it is temporarily unavailable. POST and PATCH are not retried in those cases because the exchange
+// might have already processed them.
+func (r *RetryPolicy) Retryable(retry int, method string, httpStatus int, err error) bool {
+	if retry > r.MaxRetries {
+		return false
+	} else if err != nil && isConnectionRefused(err) {
+		return true
+	} else if !isIdempotent(method) {
+		return false
+	} else if httpStatus == http.StatusBadGateway || httpStatus == http.StatusServiceUnavailable || httpStatus == http.StatusGatewayTimeout {
+		return true
+	}
+	return err != nil && isTransportError(err)
+}
+
+func (r *RetryPolicy) String() string {
+	return fmt.Sprintf("MaxRetries: %v, RetryInterval: %v", r.MaxRetries, r.RetryInterval)
+}
+
+// Returns the status of an error response that a proxy in front of the exchange returns when the exchange is down.
+func exchangeErrorStatus(err error) int {
+	switch status := anaxerrors.HTTPStatus(err); status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return status
+	}
+	return 0
+}
+
+func isIdempotent(method string) bool {
+	return method == http.MethodGet || method == http.MethodPut || method == http.MethodDelete || method == http.MethodHead
+}
+
+func isConnectionRefused(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "connection refused")
+}
+
+// The retry policy used by InvokeExchange. Exchange calls are not retried until the policy is set from the config.
+var retryPolicyLock sync.Mutex
+var retryPolicy = NewRetryPolicy(0, 0)
+
+func SetRetryPolicy(policy *RetryPolicy) {
+	retryPolicyLock.Lock()
+	defer retryPolicyLock.Unlock()
+	retryPolicy = policy
+}
+
+func GetRetryPolicy() *RetryPolicy {
+	retryPolicyLock.Lock()
+	defer retryPolicyLock.Unlock()
+	return retryPolicy
+}
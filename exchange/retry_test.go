@@ -0,0 +1,86 @@
+// +build unit
+
+package exchange
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_RetryPolicy_Retryable(t *testing.T) {
+
+	policy := NewRetryPolicy(2, time.Millisecond)
+
+	refused := errors.New("dial tcp 127.0.0.1:8080: connect: connection refused")
+	reset := errors.New("read tcp 127.0.0.1:8080: connection reset by peer")
+
+	if !policy.Retryable(1, "POST", 0, refused) {
+		t.Errorf("a refused connection should be retried for any method")
+	} else if policy.Retryable(1, "POST", 0, reset) {
+		t.Errorf("a reset POST should not be retried")
+	} else if !policy.Retryable(1, "GET", 0, reset) {
+		t.Errorf("a reset GET should be retried")
+	} else if !policy.Retryable(2, "PUT", http.StatusServiceUnavailable, errors.New("status: 503")) {
+		t.Errorf("a PUT rejected with 503 should be retried")
+	} else if policy.Retryable(1, "PATCH", http.StatusServiceUnavailable, errors.New("status: 503")) {
+		t.Errorf("a PATCH rejected with 503 should not be retried")
+	} else if policy.Retryable(1, "GET", http.StatusBadRequest, errors.New("status: 400")) {
+		t.Errorf("a GET rejected with 400 should not be retried")
+	} else if policy.Retryable(3, "GET", 0, refused) {
+		t.Errorf("retries beyond the max should not be allowed")
+	} else if NewRetryPolicy(0, time.Second).Retryable(1, "GET", 0, refused) {
+		t.Errorf("a policy without retries should not retry")
+	}
+}
+
+func Test_RetryPolicy_Backoff(t *testing.T) {
+
+	policy := NewRetryPolicy(10, time.Second)
+
+	for retry, min := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 10: MAX_RETRY_INTERVAL} {
+		if wait := policy.Backoff(retry); wait < min || wait > min+min/2 {
+			t.Errorf("retry %v backoff %v should be between %v and %v", retry, wait, min, min+min/2)
+		}
+	}
+
+	if wait := NewRetryPolicy(1, 0).Backoff(1); wait != 0 {
+		t.Errorf("backoff should be 0 without a retry interval, was %v", wait)
+	}
+}
+
+func Test_InvokeExchange_retry(t *testing.T) {
+
+	defer SetRetryPolicy(GetRetryPolicy())
+	SetRetryPolicy(NewRetryPolicy(2, time.Millisecond))
+
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls += 1
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`"1.0.0"`))
+	}))
+	defer server.Close()
+
+	var resp interface{}
+	resp = ""
+	if err, tpErr := InvokeExchange(&http.Client{}, "GET", server.URL, "", "", nil, &resp); err != nil || tpErr != nil {
+		t.Errorf("unexpected errors %v, %v", err, tpErr)
+	} else if calls != 3 {
+		t.Errorf("expected 3 calls, was %v", calls)
+	}
+
+	// A POST is not idempotent so it is not retried.
+	calls = 0
+	if err, _ := InvokeExchange(&http.Client{}, "POST", server.URL, "", "", nil, &resp); err == nil {
+		t.Errorf("expected error from POST")
+	} else if calls != 1 {
+		t.Errorf("expected 1 call, was %v", calls)
+	}
+}
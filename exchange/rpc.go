@@ -2,14 +2,17 @@ package exchange
 
 import (
 	"bytes"
+	"context"
 	"crypto/rsa"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/golang/glog"
+	"github.com/open-horizon/anax/anaxerrors"
 	"github.com/open-horizon/anax/config"
 	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/faults"
 	"github.com/open-horizon/anax/policy"
 	"io/ioutil"
 	"net/http"
@@ -205,6 +208,13 @@ type ServedPattern struct {
 	LastUpdated string `json:"lastUpdated"`
 }
 
+type ServedBusinessPolicy struct {
+	BusinessPolOrg string `json:"businessPolOrgid"` // the org of the business policy
+	BusinessPol    string `json:"businessPol"`      // the name of the business policy
+	NodeOrg        string `json:"nodeOrgid"`        // the org of the nodes that the business policy applies to
+	LastUpdated    string `json:"lastUpdated"`
+}
+
 type Agbot struct {
 	Token         string `json:"token"`
 	Name          string `json:"name"`
@@ -231,15 +241,20 @@ type GetAgbotsPatternsResponse struct {
 	Patterns map[string]ServedPattern `json:"patterns"`
 }
 
+type GetAgbotsBusinessPolsResponse struct {
+	BusinessPols map[string]ServedBusinessPolicy `json:"businessPols"`
+}
+
 type AgbotAgreement struct {
-	Workload    WorkloadAgreement `json:"workload,omitempty"`
-	Service     WorkloadAgreement `json:"service,omitempty"`
-	State       string            `json:"state"`
-	LastUpdated string            `json:"lastUpdated"`
+	Workload         WorkloadAgreement `json:"workload,omitempty"`
+	Service          WorkloadAgreement `json:"service,omitempty"`
+	State            string            `json:"state"`
+	LastUpdated      string            `json:"lastUpdated"`
+	DataLastReceived string            `json:"dataLastReceived,omitempty"` // set by a data ingest system through the agbot's dataheartbeat resource
 }
 
 func (a AgbotAgreement) String() string {
-	return fmt.Sprintf("Service: %v, Workload: %v, State: %v, LastUpdated: %v", a.Service, a.Workload, a.State, a.LastUpdated)
+	return fmt.Sprintf("Service: %v, Workload: %v, State: %v, LastUpdated: %v, DataLastReceived: %v", a.Service, a.Workload, a.State, a.LastUpdated, a.DataLastReceived)
 }
 
 type DeviceAgreement struct {
@@ -387,6 +402,52 @@ func CreatePostMessage(msg []byte, ttl int) *PostMessage {
 	return pm
 }
 
+// A batch of messages bound for different nodes, posted by an agbot in one call. The signature covers the
+// concatenation of the node ids and encrypted messages in the order they appear in the batch.
+type BatchMessageEntry struct {
+	NodeId  string `json:"nodeId"`
+	Message []byte `json:"message"`
+}
+
+type PostBatchMessage struct {
+	Messages  []BatchMessageEntry `json:"messages"`
+	TTL       int                 `json:"ttl"`
+	Signature []byte              `json:"signature"`
+}
+
+func (p PostBatchMessage) String() string {
+	return fmt.Sprintf("TTL: %v, Messages: %v, Signature: %x...", p.TTL, len(p.Messages), p.Signature[:32])
+}
+
+func CreatePostBatchMessage(entries []BatchMessageEntry, ttl int, signerPrivateKey *rsa.PrivateKey) (*PostBatchMessage, error) {
+	theTTL := 180
+	if ttl != 0 {
+		theTTL = ttl
+	}
+
+	content := make([]byte, 0, 1024)
+	for _, entry := range entries {
+		content = append(content, []byte(entry.NodeId)...)
+		content = append(content, entry.Message...)
+	}
+
+	if sig, err := SignMessageBatch(content, signerPrivateKey); err != nil {
+		return nil, err
+	} else {
+		return &PostBatchMessage{
+			Messages:  entries,
+			TTL:       theTTL,
+			Signature: sig,
+		}, nil
+	}
+}
+
+type PostBatchMessageResponse struct {
+	Code  string `json:"code"`
+	Msg   string `json:"msg"`
+	Count int    `json:"count"`
+}
+
 type ExchangeMessageTarget struct {
 	ReceiverExchangeId     string // in the form org/id
 	ReceiverPublicKeyObj   *rsa.PublicKey
@@ -471,12 +532,24 @@ type ChainInstance struct {
 	EthDir        string `json:"ethDir"`
 	MaxPeers      string `json:"maxPeers"`
 	GethLog       string `json:"gethLog"`
+	NetworkId     string `json:"networkId"` // the id of the chain's network, so that the client only peers with nodes on that chain
+	Bootnodes     string `json:"bootnodes"` // comma separated enode URLs of the chain's boot nodes
+	Genesis       string `json:"genesis"`   // the genesis block of the chain, in the JSON form that the client is initialized with
+}
+
+// A chain definition is the signed form of the instance config of a chain. The org that owns the blockchain marshals
+// it into the ChainDetails.Definition field and signs that string with its key, the same way a deployment is signed.
+type ChainDefinition struct {
+	Type     string        `json:"type"`
+	Instance ChainInstance `json:"instance"`
 }
 
 type ChainDetails struct {
-	Arch           string          `json:"arch"`
-	DeploymentDesc policy.Workload `json:"deployment_description"`
-	Instance       ChainInstance   `json:"instance"`
+	Arch                string          `json:"arch"`
+	DeploymentDesc      policy.Workload `json:"deployment_description"`
+	Instance            ChainInstance   `json:"instance"`                       // unsigned instance config, only used when there is no definition
+	Definition          string          `json:"definition,omitempty"`           // a marshalled ChainDefinition
+	DefinitionSignature string          `json:"definition_signature,omitempty"` // the signature of the definition
 }
 
 type BlockchainDetails struct {
@@ -560,25 +633,26 @@ func ConvertToString(a []string) string {
 	return r
 }
 
+// Heartbeats are sent periodically, so a heartbeat that can not reach the exchange is not retried here. The next
+// heartbeat is the retry.
 func Heartbeat(h *http.Client, url string, id string, token string) error {
 
 	glog.V(5).Infof(rpclogString(fmt.Sprintf("Heartbeating to exchange: %v", url)))
 
 	var resp interface{}
 	resp = new(PostDeviceResponse)
-	for {
-		if err, tpErr := InvokeExchange(h, "POST", url, id, token, nil, &resp); err != nil {
-			glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
-			return err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			time.Sleep(10 * time.Second)
-			continue
+	if err, tpErr := InvokeExchange(h, "POST", url, id, token, nil, &resp); err != nil {
+		glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
+		return err
+	} else if tpErr != nil {
+		if ExchangeDegraded() {
+			glog.V(3).Infof(rpclogString(fmt.Sprintf(tpErr.Error())))
 		} else {
-			glog.V(5).Infof(rpclogString(fmt.Sprintf("Sent heartbeat %v: %v", url, resp)))
-			break
+			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
 		}
+		return tpErr
 	}
+	glog.V(5).Infof(rpclogString(fmt.Sprintf("Sent heartbeat %v: %v", url, resp)))
 	return nil
 
 }
@@ -669,16 +743,18 @@ type APISpec struct {
 }
 
 type WorkloadDeployment struct {
-	Deployment          string `json:"deployment"`
-	DeploymentSignature string `json:"deployment_signature"`
-	Torrent             string `json:"torrent"`
+	Deployment          string            `json:"deployment"`
+	DeploymentSignature string            `json:"deployment_signature"`
+	Torrent             string            `json:"torrent"`              // Deprecated, use ImageFetch
+	ImageFetch          *cutil.ImageFetch `json:"imageFetch,omitempty"` // How the node pulls the docker images of the deployment
 }
 
 func (w WorkloadDeployment) String() string {
-	return fmt.Sprintf("Deployment: %v, DeploymentSignature: %v, Torrent: %v",
+	return fmt.Sprintf("Deployment: %v, DeploymentSignature: %v, Torrent: %v, ImageFetch: %v",
 		w.Deployment,
 		w.DeploymentSignature,
-		w.Torrent)
+		w.Torrent,
+		w.ImageFetch)
 }
 
 func (w WorkloadDeployment) ShortString() string {
@@ -794,6 +870,13 @@ func (w *WorkloadDefinition) GetImageStore() policy.ImplementationPackage {
 	return policy.ImplementationPackage{}
 }
 
+func (w *WorkloadDefinition) GetImageFetch() *cutil.ImageFetch {
+	if len(w.Workloads) > 0 {
+		return w.Workloads[0].ImageFetch
+	}
+	return nil
+}
+
 func (w *WorkloadDefinition) IsServiceBased() bool {
 	return false
 }
@@ -913,6 +996,13 @@ func (w *MicroserviceDefinition) GetImageStore() policy.ImplementationPackage {
 	return policy.ImplementationPackage{}
 }
 
+func (w *MicroserviceDefinition) GetImageFetch() *cutil.ImageFetch {
+	if len(w.Workloads) > 0 {
+		return w.Workloads[0].ImageFetch
+	}
+	return nil
+}
+
 func (w *MicroserviceDefinition) IsServiceBased() bool {
 	return false
 }
@@ -1230,7 +1320,7 @@ func GetOrganization(httpClientFactory *config.HTTPClientFactory, org string, ex
 		} else {
 			orgs := resp.(*GetOrganizationResponse).Orgs
 			if theOrg, ok := orgs[org]; !ok {
-				return nil, errors.New(fmt.Sprintf("organization %v not found", org))
+				return nil, anaxerrors.New(anaxerrors.EXCHANGE_NOT_FOUND, fmt.Sprintf("organization %v not found", org))
 			} else {
 				glog.V(3).Infof(rpclogString(fmt.Sprintf("found organization %v definition %v", org, theOrg)))
 				return &theOrg, nil
@@ -1281,12 +1371,15 @@ func (w WorkloadChoice) ShortString() string {
 }
 
 type WorkloadReference struct {
-	WorkloadURL      string           `json:"workloadUrl,omitempty"`      // refers to a workload definition in the exchange
-	WorkloadOrg      string           `json:"workloadOrgid,omitempty"`    // the org holding the workload definition
-	WorkloadArch     string           `json:"workloadArch,omitempty"`     // the hardware architecture of the workload definition
-	WorkloadVersions []WorkloadChoice `json:"workloadVersions,omitempty"` // a list of workload version for rollback
-	DataVerify       DataVerification `json:"dataVerification"`           // policy for verifying that the node is sending data
-	NodeH            NodeHealth       `json:"nodeHealth"`                 // policy for determining when a node's health is violating its agreements
+	WorkloadURL       string             `json:"workloadUrl,omitempty"`       // refers to a workload definition in the exchange
+	WorkloadOrg       string             `json:"workloadOrgid,omitempty"`     // the org holding the workload definition
+	WorkloadArch      string             `json:"workloadArch,omitempty"`      // the hardware architecture of the workload definition
+	WorkloadVersions  []WorkloadChoice   `json:"workloadVersions,omitempty"`  // a list of workload version for rollback
+	DataVerify        DataVerification   `json:"dataVerification"`            // policy for verifying that the node is sending data
+	NodeH             NodeHealth         `json:"nodeHealth"`                  // policy for determining when a node's health is violating its agreements
+	Placement         *Placement         `json:"placement,omitempty"`         // which other workloads must or must not be on the node
+	TimeWindows       []TimeWindow       `json:"timeWindows,omitempty"`       // the times of day during which the workload can run
+	AgreementTimeouts *AgreementTimeouts `json:"agreementTimeouts,omitempty"` // how long the agbot waits for the node to negotiate, the agbot's configuration when omitted
 }
 
 func (w WorkloadReference) String() string {
@@ -1315,13 +1408,16 @@ func (w WorkloadReference) ShortString() string {
 }
 
 type ServiceReference struct {
-	ServiceURL      string           `json:"serviceUrl,omitempty"`      // refers to a service definition in the exchange
-	ServiceOrg      string           `json:"serviceOrgid,omitempty"`    // the org holding the service definition
-	ServiceArch     string           `json:"serviceArch,omitempty"`     // the hardware architecture of the service definition
-	ServiceVersions []WorkloadChoice `json:"serviceVersions,omitempty"` // a list of service version for rollback
-	DataVerify      DataVerification `json:"dataVerification"`          // policy for verifying that the node is sending data
-	NodeH           NodeHealth       `json:"nodeHealth"`                // policy for determining when a node's health is violating its agreements
-	AgreementLess   bool             `json:"agreementLess"`             // This service should get started on the node without an agreement to start it
+	ServiceURL        string             `json:"serviceUrl,omitempty"`        // refers to a service definition in the exchange
+	ServiceOrg        string             `json:"serviceOrgid,omitempty"`      // the org holding the service definition
+	ServiceArch       string             `json:"serviceArch,omitempty"`       // the hardware architecture of the service definition
+	ServiceVersions   []WorkloadChoice   `json:"serviceVersions,omitempty"`   // a list of service version for rollback
+	DataVerify        DataVerification   `json:"dataVerification"`            // policy for verifying that the node is sending data
+	NodeH             NodeHealth         `json:"nodeHealth"`                  // policy for determining when a node's health is violating its agreements
+	AgreementLess     bool               `json:"agreementLess"`               // This service should get started on the node without an agreement to start it
+	Placement         *Placement         `json:"placement,omitempty"`         // which other services must or must not be on the node
+	TimeWindows       []TimeWindow       `json:"timeWindows,omitempty"`       // the times of day during which the service can run
+	AgreementTimeouts *AgreementTimeouts `json:"agreementTimeouts,omitempty"` // how long the agbot waits for the node to negotiate, the agbot's configuration when omitted
 }
 
 func (w ServiceReference) String() string {
@@ -1357,6 +1453,7 @@ type Meter struct {
 
 type DataVerification struct {
 	Enabled     bool   `json:"enabled,omitempty"`    // Whether or not data verification is enabled
+	Type        string `json:"type,omitempty"`       // How the data is verified: http (the default), exchange or webhook
 	URL         string `json:"URL,omitempty"`        // The URL to be used for data receipt verification
 	URLUser     string `json:"user,omitempty"`       // The user id to use when calling the verification URL
 	URLPassword string `json:"password,omitempty"`   // The password to use when calling the verification URL
@@ -1366,8 +1463,30 @@ type DataVerification struct {
 }
 
 type NodeHealth struct {
-	MissingHBInterval    int `json:"missing_heartbeat_interval,omitempty"` // How long a heartbeat can be missing until it is considered missing (in seconds)
-	CheckAgreementStatus int `json:"check_agreement_status,omitempty"`     // How often to check that the node agreement entry still exists in the exchange (in seconds)
+	MissingHBInterval    int `json:"missing_heartbeat_interval,omitempty"`     // How long a heartbeat can be missing until it is considered missing (in seconds)
+	CheckAgreementStatus int `json:"check_agreement_status,omitempty"`         // How often to check that the node agreement entry still exists in the exchange (in seconds)
+	MissingHBGraceChecks int `json:"missing_heartbeat_grace_checks,omitempty"` // How many more checks the heartbeat can be missing on before the agreement is cancelled
+}
+
+type AgreementTimeouts struct {
+	NoReplyS      uint64 `json:"noReply,omitempty"`      // seconds to wait for the reply to a proposal
+	NotFinalizedS uint64 `json:"notFinalized,omitempty"` // seconds to wait for the agreement to be finalized
+}
+
+type PlacementWorkload struct {
+	URL string `json:"url"`   // the workload or service URL
+	Org string `json:"orgid"` // the org holding the workload or service definition
+}
+
+type Placement struct {
+	CoLocate     []PlacementWorkload `json:"colocate,omitempty"`     // these must already be running on the node
+	AntiAffinity []PlacementWorkload `json:"antiAffinity,omitempty"` // these must never be running on the node
+}
+
+type TimeWindow struct {
+	Start    string `json:"start"`              // HH:MM
+	End      string `json:"end"`                // HH:MM, a window that ends before it starts spans midnight
+	Timezone string `json:"timezone,omitempty"` // IANA time zone name, the node's local time when omitted
 }
 
 type Blockchain struct {
@@ -1492,6 +1611,11 @@ func makePolicyName(patternName string, workloadURL string, workloadOrg string,
 		url = strings.Replace(url, "/", "-", -1)
 	}
 
+	// The policy names become file names, so the any arch wildcard is spelled out.
+	if workloadArch == policy.ANY_ARCH {
+		workloadArch = "anyarch"
+	}
+
 	return fmt.Sprintf("%v_%v_%v_%v", patternName, url, workloadOrg, workloadArch)
 
 }
@@ -1537,6 +1661,9 @@ func ConvertToPolicies(patternId string, p *Pattern) ([]*policy.Policy, error) {
 			}
 
 			ConvertCommon(p, patternId, service.DataVerify, service.NodeH, pol)
+			ConvertPlacement(service.Placement, pol)
+			ConvertTimeWindows(service.TimeWindows, pol)
+			ConvertAgreementTimeouts(service.AgreementTimeouts, pol)
 
 			glog.V(3).Infof(rpclogString(fmt.Sprintf("converted %v into %v", service.ShortString(), pol)))
 			policies = append(policies, pol)
@@ -1567,6 +1694,9 @@ func ConvertToPolicies(patternId string, p *Pattern) ([]*policy.Policy, error) {
 			}
 
 			ConvertCommon(p, patternId, workload.DataVerify, workload.NodeH, pol)
+			ConvertPlacement(workload.Placement, pol)
+			ConvertTimeWindows(workload.TimeWindows, pol)
+			ConvertAgreementTimeouts(workload.AgreementTimeouts, pol)
 
 			glog.V(3).Infof(rpclogString(fmt.Sprintf("converted %v into %v", workload.ShortString(), pol)))
 			policies = append(policies, pol)
@@ -1595,6 +1725,7 @@ func ConvertDataVerify(dv DataVerification, pol *policy.Policy) {
 			NotificationIntervalS: dv.Metering.NotificationIntervalS,
 		}
 		d := policy.DataVerification_Factory(dv.URL, dv.URLUser, dv.URLPassword, dv.Interval, dv.CheckRate, mp)
+		d.Type = dv.Type
 		pol.Add_DataVerification(d)
 	}
 }
@@ -1602,9 +1733,38 @@ func ConvertDataVerify(dv DataVerification, pol *policy.Policy) {
 func ConvertNodeHealth(nodeh NodeHealth, pol *policy.Policy) {
 	// Copy over the node health policy
 	nh := policy.NodeHealth_Factory(nodeh.MissingHBInterval, nodeh.CheckAgreementStatus)
+	nh.MissingHBGraceChecks = nodeh.MissingHBGraceChecks
 	pol.Add_NodeHealth(nh)
 }
 
+func ConvertPlacement(placement *Placement, pol *policy.Policy) {
+	// Copy over the placement constraints
+	convert := func(wls []PlacementWorkload) []policy.PlacementWorkload {
+		res := make([]policy.PlacementWorkload, 0, len(wls))
+		for _, wl := range wls {
+			res = append(res, policy.PlacementWorkload{URL: wl.URL, Org: wl.Org})
+		}
+		return res
+	}
+	if placement != nil && (len(placement.CoLocate) != 0 || len(placement.AntiAffinity) != 0) {
+		pol.Add_Placement(policy.Placement_Factory(convert(placement.CoLocate), convert(placement.AntiAffinity)))
+	}
+}
+
+func ConvertAgreementTimeouts(timeouts *AgreementTimeouts, pol *policy.Policy) {
+	// Copy over the agreement timeouts
+	if timeouts != nil && (timeouts.NoReplyS != 0 || timeouts.NotFinalizedS != 0) {
+		pol.Add_AgreementTimeouts(policy.AgreementTimeouts_Factory(timeouts.NoReplyS, timeouts.NotFinalizedS))
+	}
+}
+
+func ConvertTimeWindows(windows []TimeWindow, pol *policy.Policy) {
+	// Copy over the time windows
+	for _, w := range windows {
+		pol.Add_TimeWindow(&policy.TimeWindow{Start: w.Start, End: w.End, Timezone: w.Timezone})
+	}
+}
+
 func ConvertAgreementProtocol(p *Pattern, pol *policy.Policy) {
 	// Copy Agreement protocol metadata into the policy
 	for _, agp := range p.AgreementProtocols {
@@ -1676,7 +1836,7 @@ func GetNodeHealthStatus(httpClientFactory *config.HTTPClientFactory, pattern st
 	}
 
 	for {
-		if err, tpErr := InvokeExchange(httpClientFactory.NewHTTPClient(nil), "POST", targetURL, id, token, &params, &resp); err != nil && !strings.Contains(err.Error(), "status: 404") {
+		if err, tpErr := InvokeExchange(httpClientFactory.NewHTTPClient(nil), "POST", targetURL, id, token, &params, &resp); err != nil && !anaxerrors.Is(err, anaxerrors.EXCHANGE_NOT_FOUND) {
 			glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
 			return nil, err
 		} else if tpErr != nil {
@@ -1694,7 +1854,67 @@ func GetNodeHealthStatus(httpClientFactory *config.HTTPClientFactory, pattern st
 
 // This function is used to invoke an exchange API
 // For GET, the given resp parameter will be untouched when http returns code 404.
+// Transient errors are retried according to the retry policy, see SetRetryPolicy. The error of the last attempt is
+// returned when the retries are used up. Every attempt is reported to the exchange outage tracker.
 func InvokeExchange(httpClient *http.Client, method string, url string, user string, pw string, params interface{}, resp *interface{}) (error, error) {
+	return InvokeExchangeWithContext(context.Background(), httpClient, method, url, user, pw, params, resp)
+}
+
+// Invoke the exchange, retrying as the retry policy allows. The call stops when the context is done, even while it
+// is waiting for the exchange to respond. A call that is stopped by its context returns an error, not a transport
+// error, so that callers dont retry it.
+func InvokeExchangeWithContext(ctx context.Context, httpClient *http.Client, method string, url string, user string, pw string, params interface{}, resp *interface{}) (error, error) {
+
+	ctx, cancel := callContext(ctx)
+	defer cancel()
+
+	policy := GetRetryPolicy()
+	for retry := 1; ; retry++ {
+		if ctx.Err() != nil {
+			return contextError(ctx, method, url), nil
+		}
+
+		start := time.Now()
+		err, tpErr := invokeExchange(ctx, httpClient, method, url, user, pw, params, resp)
+		if ctx.Err() != nil {
+			// A call that ran out of time might mean the exchange is wedged, a cancelled call says nothing about it.
+			if ctx.Err() == context.DeadlineExceeded {
+				recordExchangeUnreachable(contextError(ctx, method, url))
+				recordInteraction(method, url, retry, start, INTERACTION_TIMEOUT, nil)
+			} else {
+				recordInteraction(method, url, retry, start, INTERACTION_CANCELLED, nil)
+			}
+			return contextError(ctx, method, url), nil
+		}
+		recordExchangeAttempt(err, tpErr)
+		recordInteraction(method, url, retry, start, interactionOutcome(err, tpErr), err)
+		if err == nil && tpErr == nil {
+			return nil, nil
+		}
+
+		failure := tpErr
+		if err != nil {
+			failure = err
+		}
+		if !policy.Retryable(retry, method, exchangeErrorStatus(err), failure) {
+			return err, tpErr
+		}
+
+		// The node already knows the exchange is down, so dont fill the log with it.
+		wait := policy.Backoff(retry)
+		if ExchangeDegraded() {
+			glog.V(3).Infof(rpclogString(fmt.Sprintf("retry %v of %v for %v at %v in %v, error: %v", retry, policy.MaxRetries, method, url, wait, failure)))
+		} else {
+			glog.Warningf(rpclogString(fmt.Sprintf("retry %v of %v for %v at %v in %v, error: %v", retry, policy.MaxRetries, method, url, wait, failure)))
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+		}
+	}
+}
+
+func invokeExchange(ctx context.Context, httpClient *http.Client, method string, url string, user string, pw string, params interface{}, resp *interface{}) (error, error) {
 
 	if len(method) == 0 {
 		return errors.New(fmt.Sprintf("Error invoking exchange, method name must be specified")), nil
@@ -1722,6 +1942,7 @@ func InvokeExchange(httpClient *http.Client, method string, url string, user str
 	if req, err := http.NewRequest(method, url, requestBody); err != nil {
 		return errors.New(fmt.Sprintf("Invocation of %v at %v with %v failed creating HTTP request, error: %v", method, url, requestBody, err)), nil
 	} else {
+		req = req.WithContext(ctx)
 		req.Close = true // work around to ensure that Go doesn't get connections confused. Supposed to be fixed in Go 1.6.
 		req.Header.Add("Accept", "application/json")
 		if method != "GET" {
@@ -1731,11 +1952,17 @@ func InvokeExchange(httpClient *http.Client, method string, url string, user str
 			req.Header.Add("Authorization", fmt.Sprintf("Basic %v", base64.StdEncoding.EncodeToString([]byte(user+":"+pw))))
 		}
 		glog.V(5).Infof(rpclogString(fmt.Sprintf("Invoking exchange with headers: %v", req.Header)))
+
+		// Test builds can simulate an exchange server error.
+		if faults.Inject(faults.EXCHANGE_5XX) {
+			return anaxerrors.NewHTTPError(http.StatusServiceUnavailable, fmt.Sprintf("Invocation of %v at %v failed invoking HTTP request, status: %v, response: %v", method, url, http.StatusServiceUnavailable, faults.Message(faults.EXCHANGE_5XX))), nil
+		}
+
 		// If the exchange is down, this call will return an error.
 
 		if httpResp, err := httpClient.Do(req); err != nil {
 			if isTransportError(err) {
-				return nil, errors.New(fmt.Sprintf("Invocation of %v at %v with %v failed invoking HTTP request, error: %v", method, url, requestBody, err))
+				return nil, anaxerrors.New(anaxerrors.NETWORK, fmt.Sprintf("Invocation of %v at %v with %v failed invoking HTTP request, error: %v", method, url, requestBody, err))
 			} else {
 				return errors.New(fmt.Sprintf("Invocation of %v at %v with %v failed invoking HTTP request, error: %v", method, url, requestBody, err)), nil
 			}
@@ -1747,7 +1974,7 @@ func InvokeExchange(httpClient *http.Client, method string, url string, user str
 			if httpResp.Body != nil {
 				if outBytes, readErr = ioutil.ReadAll(httpResp.Body); err != nil {
 					if isTransportError(err) {
-						return nil, errors.New(fmt.Sprintf("Invocation of %v at %v failed reading response message, HTTP Status %v, error: %v", method, url, httpResp.StatusCode, readErr))
+						return nil, anaxerrors.New(anaxerrors.NETWORK, fmt.Sprintf("Invocation of %v at %v failed reading response message, HTTP Status %v, error: %v", method, url, httpResp.StatusCode, readErr))
 					} else {
 						return errors.New(fmt.Sprintf("Invocation of %v at %v failed reading response message, HTTP Status %v, error: %v", method, url, httpResp.StatusCode, readErr)), nil
 					}
@@ -1756,7 +1983,7 @@ func InvokeExchange(httpClient *http.Client, method string, url string, user str
 
 			// Handle special case of server error
 			if httpResp.StatusCode == http.StatusInternalServerError && strings.Contains(string(outBytes), "timed out") {
-				return nil, errors.New(fmt.Sprintf("Invocation of %v at %v with %v failed invoking HTTP request, error: %v", method, url, requestBody, err))
+				return nil, anaxerrors.New(anaxerrors.NETWORK, fmt.Sprintf("Invocation of %v at %v with %v failed invoking HTTP request, error: %v", method, url, requestBody, err))
 			}
 
 			if method == "GET" && httpResp.StatusCode != http.StatusOK {
@@ -1764,12 +1991,12 @@ func InvokeExchange(httpClient *http.Client, method string, url string, user str
 					glog.V(5).Infof(rpclogString(fmt.Sprintf("Got %v. Response to %v at %v is %v", httpResp.StatusCode, method, url, string(outBytes))))
 					return nil, nil
 				} else {
-					return errors.New(fmt.Sprintf("Invocation of %v at %v failed invoking HTTP request, status: %v, response: %v", method, url, httpResp.StatusCode, string(outBytes))), nil
+					return anaxerrors.NewHTTPError(httpResp.StatusCode, fmt.Sprintf("Invocation of %v at %v failed invoking HTTP request, status: %v, response: %v", method, url, httpResp.StatusCode, string(outBytes))), nil
 				}
 			} else if (method == "PUT" || method == "POST" || method == "PATCH") && httpResp.StatusCode != http.StatusCreated {
-				return errors.New(fmt.Sprintf("Invocation of %v at %v failed invoking HTTP request, status: %v, response: %v", method, url, httpResp.StatusCode, string(outBytes))), nil
+				return anaxerrors.NewHTTPError(httpResp.StatusCode, fmt.Sprintf("Invocation of %v at %v failed invoking HTTP request, status: %v, response: %v", method, url, httpResp.StatusCode, string(outBytes))), nil
 			} else if method == "DELETE" && httpResp.StatusCode != http.StatusNoContent {
-				return errors.New(fmt.Sprintf("Invocation of %v at %v failed invoking HTTP request, status: %v, response: %v", method, url, httpResp.StatusCode, string(outBytes))), nil
+				return anaxerrors.NewHTTPError(httpResp.StatusCode, fmt.Sprintf("Invocation of %v at %v failed invoking HTTP request, status: %v, response: %v", method, url, httpResp.StatusCode, string(outBytes))), nil
 			} else if method == "DELETE" {
 				return nil, nil
 			} else {
@@ -1846,6 +2073,21 @@ func InvokeExchange(httpClient *http.Client, method string, url string, user str
 					case *GetAgbotsPatternsResponse:
 						return nil, nil
 
+					case *GetAgbotsBusinessPolsResponse:
+						return nil, nil
+
+					case *GetBusinessPolicyResponse:
+						return nil, nil
+
+					case *SearchExchBusinessPolResponse:
+						return nil, nil
+
+					case *NodePolicy:
+						return nil, nil
+
+					case *NodeAttestation:
+						return nil, nil
+
 					case *NodeHealthStatus:
 						return nil, nil
 
@@ -1869,6 +2111,9 @@ func isTransportError(err error) bool {
 }
 
 var rpclogString = func(v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("Exchange RPC", nil, v)
+	}
 	return fmt.Sprintf("Exchange RPC %v", v)
 }
 
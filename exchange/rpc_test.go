@@ -5,6 +5,7 @@ package exchange
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/open-horizon/anax/policy"
 	"testing"
 )
 
@@ -260,6 +261,15 @@ func Test_makePolicyName2(t *testing.T) {
 
 }
 
+func Test_makePolicyName_anyArch(t *testing.T) {
+
+	expected := "pat1_mydomain.com_myorg_anyarch"
+	if res := makePolicyName("pat1", "http://mydomain.com", "myorg", policy.ANY_ARCH); res != expected {
+		t.Errorf("Error: expecting %v got %v\n", expected, res)
+	}
+
+}
+
 func Test_IsTraportError(t *testing.T) {
 	error1 := fmt.Errorf("Time is out")
 	error2 := fmt.Errorf("connection is refused")
@@ -287,6 +297,68 @@ func Test_IsTraportError(t *testing.T) {
 // Create a Pattern object from a JSON serialization. The JSON serialization
 // does not have to be a valid pattern serialization, just has to be a valid
 // JSON serialization.
+// Placement constraints in a service pattern are converted into the policy.
+func Test_ConvertPattern_placement(t *testing.T) {
+
+	org := "testorg"
+	name := "testpattern"
+
+	pa := `{"label":"Pipeline","description":"a pipeline pattern","public":true,` +
+		`"services":[` +
+		`{"serviceUrl":"https://bluehorizon.network/services/analyze","serviceOrgid":"testorg","serviceArch":"amd64","serviceVersions":` +
+		`[{"version":"1.0.0","priority":{},"upgradePolicy":{}}],` +
+		`"placement":{"colocate":[{"url":"https://bluehorizon.network/services/collect","orgid":"testorg"}],` +
+		`"antiAffinity":[{"url":"https://bluehorizon.network/services/train","orgid":"otherorg"}]}},` +
+		`{"serviceUrl":"https://bluehorizon.network/services/collect","serviceOrgid":"testorg","serviceArch":"amd64","serviceVersions":` +
+		`[{"version":"1.0.0","priority":{},"upgradePolicy":{}}]}` +
+		`],` +
+		`"agreementProtocols":[{"name":"Basic"}]}`
+
+	if p1 := create_Pattern(pa, t); p1 == nil {
+		t.Errorf("Pattern not created from %v\n", pa)
+	} else if pols, err := ConvertToPolicies(fmt.Sprintf("%v/%v", org, name), p1); err != nil {
+		t.Errorf("Error: %v converting %v to a policy\n", err, pa)
+	} else if len(pols) != 2 {
+		t.Errorf("Error: should be 2 policies in the pattern, there are %v\n", len(pols))
+	} else if pols[0].Placement == nil {
+		t.Errorf("Error: placement not converted")
+	} else if len(pols[0].Placement.CoLocate) != 1 || pols[0].Placement.CoLocate[0].URL != "https://bluehorizon.network/services/collect" || pols[0].Placement.CoLocate[0].Org != "testorg" {
+		t.Errorf("Error: co-location not converted correctly, is %v", pols[0].Placement)
+	} else if len(pols[0].Placement.AntiAffinity) != 1 || pols[0].Placement.AntiAffinity[0].Org != "otherorg" {
+		t.Errorf("Error: anti-affinity not converted correctly, is %v", pols[0].Placement)
+	} else if !pols[1].Placement.IsEmpty() {
+		t.Errorf("Error: placement should be empty, is %v", pols[1].Placement)
+	}
+
+}
+
+// Agreement timeouts in a pattern are converted into the policy of the service they are given for.
+func Test_ConvertPattern_agreementTimeouts(t *testing.T) {
+
+	pa := `{"label":"Cellular","description":"a pattern for slow nodes","public":true,` +
+		`"services":[` +
+		`{"serviceUrl":"https://bluehorizon.network/services/gps","serviceOrgid":"testorg","serviceArch":"arm","serviceVersions":` +
+		`[{"version":"1.0.0","priority":{},"upgradePolicy":{}}],` +
+		`"agreementTimeouts":{"noReply":900,"notFinalized":3600}},` +
+		`{"serviceUrl":"https://bluehorizon.network/services/collect","serviceOrgid":"testorg","serviceArch":"arm","serviceVersions":` +
+		`[{"version":"1.0.0","priority":{},"upgradePolicy":{}}]}` +
+		`],` +
+		`"agreementProtocols":[{"name":"Basic"}]}`
+
+	if p1 := create_Pattern(pa, t); p1 == nil {
+		t.Errorf("Pattern not created from %v\n", pa)
+	} else if pols, err := ConvertToPolicies("testorg/cellular", p1); err != nil {
+		t.Errorf("Error: %v converting %v to a policy\n", err, pa)
+	} else if len(pols) != 2 {
+		t.Errorf("Error: should be 2 policies in the pattern, there are %v\n", len(pols))
+	} else if pols[0].AgreementTimeouts.NoReply(60) != 900 || pols[0].AgreementTimeouts.NotFinalized(60) != 3600 {
+		t.Errorf("Error: agreement timeouts not converted correctly, are %v", pols[0].AgreementTimeouts)
+	} else if pols[1].AgreementTimeouts != nil || pols[1].AgreementTimeouts.NoReply(60) != 60 {
+		t.Errorf("Error: agreement timeouts should be the defaults, are %v", pols[1].AgreementTimeouts)
+	}
+
+}
+
 func create_Pattern(jsonString string, t *testing.T) *Pattern {
 	wl := new(Pattern)
 
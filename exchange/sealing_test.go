@@ -0,0 +1,186 @@
+// +build unit
+
+package exchange
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func sealedTestKeys(t *testing.T) (*rsa.PrivateKey, *rsa.PrivateKey) {
+	senderPrivateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Could not generate sender private key, error %v", err)
+	}
+	receiverPrivateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Could not generate receiver private key, error %v", err)
+	}
+	return senderPrivateKey, receiverPrivateKey
+}
+
+func sealMessage(t *testing.T, message []byte, senderPrivateKey *rsa.PrivateKey, receiverPublicKey *rsa.PublicKey) []byte {
+	if msg, err := ConstructSealedExchangeMessage(message, &senderPrivateKey.PublicKey, senderPrivateKey, receiverPublicKey); err != nil {
+		t.Fatalf("Could not construct sealed message, %v", err)
+	} else if msgBody, err := json.Marshal(msg); err != nil {
+		t.Fatalf("Error marshalling sealed message, %v", err)
+	} else {
+		return msgBody
+	}
+	return nil
+}
+
+func TestSealedMessage_success(t *testing.T) {
+
+	senderPrivateKey, receiverPrivateKey := sealedTestKeys(t)
+	message := []byte(`{"type":"proposal","protocol":"Basic","version":4,"agreementId":"ag1"}`)
+
+	msgBody := sealMessage(t, message, senderPrivateKey, &receiverPrivateKey.PublicKey)
+	if !IsSealedExchangeMessage(msgBody) {
+		t.Errorf("message %s should be sealed", msgBody)
+	} else if receivedMessage, senderKey, err := DeconstructExchangeMessage(msgBody, receiverPrivateKey); err != nil {
+		t.Errorf("Could not deconstruct sealed message, %v", err)
+	} else if bytes.Compare(message, receivedMessage) != 0 {
+		t.Errorf("Received message %s is not the same as the original message %s.", receivedMessage, message)
+	} else if senderKey.N.Cmp(senderPrivateKey.PublicKey.N) != 0 {
+		t.Errorf("Received the wrong sender key")
+	}
+
+	// The original form of the message is not sealed.
+	if msg, err := ConstructExchangeMessage(message, &senderPrivateKey.PublicKey, senderPrivateKey, &receiverPrivateKey.PublicKey); err != nil {
+		t.Errorf("Could not construct message, %v", err)
+	} else if msgBody, err := json.Marshal(msg); err != nil {
+		t.Errorf("Error marshalling exchange message, %v", err)
+	} else if IsSealedExchangeMessage(msgBody) {
+		t.Errorf("message %s should not be sealed", msgBody)
+	}
+}
+
+func TestSealedMessage_padding(t *testing.T) {
+
+	senderPrivateKey, receiverPrivateKey := sealedTestKeys(t)
+
+	// Messages of different sizes are the same size once they are sealed, unless they are larger than the pad size.
+	sizes := make(map[int]bool)
+	for _, message := range []string{`{"type":"cancel"}`, `{"type":"proposal","tsandcs":"` + strings.Repeat("x", 900) + `"}`} {
+		em := new(SealedExchangeMessage)
+		if err := json.Unmarshal(sealMessage(t, []byte(message), senderPrivateKey, &receiverPrivateKey.PublicKey), em); err != nil {
+			t.Fatalf("Error unmarshalling sealed message, %v", err)
+		}
+		sizes[len(em.WrappedMessage)] = true
+	}
+	if len(sizes) != 1 {
+		t.Errorf("sealed messages should be the same size, got sizes %v", sizes)
+	}
+
+	wm := &WrappedMessage{Msg: []byte(strings.Repeat("x", SEALED_PAD_SIZE)), Signature: []byte("sig"), SignerPubKey: []byte("key")}
+	if err := padWrappedMessage(wm, SEALED_PAD_SIZE); err != nil {
+		t.Errorf("Could not pad wrapped message, %v", err)
+	} else if wmBytes, err := json.Marshal(wm); err != nil {
+		t.Errorf("Error marshalling wrapped message, %v", err)
+	} else if len(wmBytes)%SEALED_PAD_SIZE != 0 {
+		t.Errorf("padded wrapped message is %v bytes, not a multiple of %v", len(wmBytes), SEALED_PAD_SIZE)
+	}
+}
+
+func TestSealedMessage_envelope(t *testing.T) {
+
+	senderPrivateKey, receiverPrivateKey := sealedTestKeys(t)
+	message := []byte(`{"type":"reply","protocol":"Basic","version":4,"agreementId":"ag1"}`)
+	msgBody := sealMessage(t, message, senderPrivateKey, &receiverPrivateKey.PublicKey)
+
+	// A message sealed for another key is not deconstructed.
+	if _, _, err := DeconstructExchangeMessage(msgBody, senderPrivateKey); err == nil {
+		t.Errorf("should not deconstruct a message sealed for another key")
+	}
+
+	// The envelope cannot be changed.
+	em := new(SealedExchangeMessage)
+	if err := json.Unmarshal(msgBody, em); err != nil {
+		t.Fatalf("Error unmarshalling sealed message, %v", err)
+	}
+
+	downgraded := ExchangeMessage{WrappedMessage: em.WrappedMessage, SymmetricValues: em.SymmetricValues}
+	if body, err := json.Marshal(downgraded); err != nil {
+		t.Errorf("Error marshalling exchange message, %v", err)
+	} else if _, _, err := DeconstructExchangeMessage(body, receiverPrivateKey); err == nil {
+		t.Errorf("should not deconstruct a sealed message without its envelope")
+	}
+
+	em.Envelope = SEALED_ENVELOPE_VERSION + 1
+	if body, err := json.Marshal(em); err != nil {
+		t.Errorf("Error marshalling sealed message, %v", err)
+	} else if _, _, err := DeconstructExchangeMessage(body, receiverPrivateKey); err == nil {
+		t.Errorf("should not deconstruct a message with an unknown envelope version")
+	}
+}
+
+func TestRotateKeys(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "messaging-keys")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	snapCommon := os.Getenv("SNAP_COMMON")
+	os.Setenv("SNAP_COMMON", dir)
+	defer os.Setenv("SNAP_COMMON", snapCommon)
+
+	gPublicKey, gPrivateKey, gPreviousPrivateKey = nil, nil, nil
+	defer func() { gPublicKey, gPrivateKey, gPreviousPrivateKey = nil, nil, nil }()
+
+	senderPrivateKey, _ := sealedTestKeys(t)
+	message := []byte(`{"type":"cancel","protocol":"Basic","version":4,"agreementId":"ag1"}`)
+
+	oldPublicKey, _, err := GetKeys("")
+	if err != nil {
+		t.Fatalf("Could not get keys, error %v", err)
+	} else if _, err := KeysCreated(""); err != nil {
+		t.Errorf("Could not get the creation time of the keys, error %v", err)
+	}
+
+	// Messages in both forms are sent to the key before it is rotated.
+	sealed := sealMessage(t, message, senderPrivateKey, oldPublicKey)
+	unsealed, err := ConstructExchangeMessage(message, &senderPrivateKey.PublicKey, senderPrivateKey, oldPublicKey)
+	if err != nil {
+		t.Fatalf("Could not construct message, %v", err)
+	}
+	unsealedBody, _ := json.Marshal(unsealed)
+
+	newPublicKey, newPrivateKey, err := RotateKeys("")
+	if err != nil {
+		t.Fatalf("Could not rotate keys, error %v", err)
+	} else if newPublicKey.N.Cmp(oldPublicKey.N) == 0 {
+		t.Fatalf("the rotated public key is the same as the old one")
+	}
+
+	// Both are still read after the rotation, and after a restart.
+	for i := 0; i < 2; i++ {
+		for _, body := range [][]byte{sealed, unsealedBody, sealMessage(t, message, senderPrivateKey, newPublicKey)} {
+			if receivedMessage, _, err := DeconstructExchangeMessage(body, newPrivateKey); err != nil {
+				t.Errorf("Could not deconstruct message after rotation, %v", err)
+			} else if bytes.Compare(message, receivedMessage) != 0 {
+				t.Errorf("Received message %s is not the same as the original message %s.", receivedMessage, message)
+			}
+		}
+
+		gPublicKey, gPrivateKey, gPreviousPrivateKey = nil, nil, nil
+		if _, newPrivateKey, err = GetKeys(""); err != nil {
+			t.Fatalf("Could not get keys after restart, error %v", err)
+		}
+	}
+
+	// Only the key before the last rotation is kept.
+	if _, newPrivateKey, err = RotateKeys(""); err != nil {
+		t.Fatalf("Could not rotate keys, error %v", err)
+	} else if _, _, err := DeconstructExchangeMessage(sealed, newPrivateKey); err == nil {
+		t.Errorf("should not deconstruct a message sent before the key was rotated twice")
+	}
+}
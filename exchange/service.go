@@ -22,6 +22,7 @@ type ExchangeDefinition interface {
 	GetDeploymentSignature() string
 	GetTorrent() string
 	GetImageStore() policy.ImplementationPackage
+	GetImageFetch() *cutil.ImageFetch
 	IsServiceBased() bool
 	GetServiceDependencies() *[]ServiceDependency
 	GetVersion() string
@@ -113,6 +114,7 @@ type ServiceDefinition struct {
 	Deployment          string                `json:"deployment"`
 	DeploymentSignature string                `json:"deploymentSignature"`
 	ImageStore          ImplementationPackage `json:"imageStore"`
+	ImageFetch          *cutil.ImageFetch     `json:"imageFetch,omitempty"`
 	LastUpdated         string                `json:"lastUpdated"`
 }
 
@@ -191,6 +193,10 @@ func (s *ServiceDefinition) GetImageStore() policy.ImplementationPackage {
 	return polIP
 }
 
+func (s *ServiceDefinition) GetImageFetch() *cutil.ImageFetch {
+	return s.ImageFetch
+}
+
 func (s *ServiceDefinition) HasDependencies() bool {
 	return len(s.RequiredServices) != 0
 }
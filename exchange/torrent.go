@@ -0,0 +1,46 @@
+package exchange
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/open-horizon/anax/policy"
+)
+
+// The torrent field in workload and microservice definitions is deprecated. Before images could be fetched
+// from a docker registry, it held the URL and signature of an image server package. Most definitions in the exchange
+// now carry a torrent field with an empty URL and signature, which means "fetch from the docker registry",
+// and which is exactly what an empty torrent field means. During the transition window both forms are accepted,
+// the legacy form is normalized (and reported) wherever it is read.
+
+// Returns true when the torrent field is the legacy form that only indicates the images are in a docker registry.
+func IsLegacyTorrentField(torrent string) bool {
+	if torrent == "" {
+		return false
+	}
+	var t policy.Torrent
+	if err := json.Unmarshal([]byte(torrent), &t); err != nil {
+		return false
+	}
+	return t.Url == "" && t.Signature == ""
+}
+
+// Convert a torrent field into its normalized form. The legacy docker registry form becomes an empty string and
+// an image server reference is rewritten in canonical JSON. The returned boolean is true when the normalized
+// form is different from the input.
+func NormalizeTorrentField(torrent string) (string, bool, error) {
+	if torrent == "" {
+		return "", false, nil
+	}
+
+	var t policy.Torrent
+	if err := json.Unmarshal([]byte(torrent), &t); err != nil {
+		return "", false, errors.New(fmt.Sprintf("unable to demarshal torrent field %v, error: %v", torrent, err))
+	} else if t.Url == "" && t.Signature == "" {
+		return "", true, nil
+	} else if tBytes, err := json.Marshal(t); err != nil {
+		return "", false, errors.New(fmt.Sprintf("unable to marshal torrent %v, error: %v", t, err))
+	} else {
+		return string(tBytes), string(tBytes) != torrent, nil
+	}
+}
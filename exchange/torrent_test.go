@@ -0,0 +1,53 @@
+// +build unit
+
+package exchange
+
+import (
+	"testing"
+)
+
+func Test_IsLegacyTorrentField(t *testing.T) {
+
+	if IsLegacyTorrentField("") {
+		t.Errorf("empty torrent field should not be legacy")
+	} else if !IsLegacyTorrentField(`{"url":"","signature":""}`) {
+		t.Errorf("docker registry torrent field should be legacy")
+	} else if IsLegacyTorrentField(`{"url":"https://images.bluehorizon.network/abc.torrent","signature":"123456"}`) {
+		t.Errorf("image server torrent field should not be legacy")
+	} else if IsLegacyTorrentField(`not json`) {
+		t.Errorf("malformed torrent field should not be legacy")
+	}
+
+}
+
+func Test_NormalizeTorrentField(t *testing.T) {
+
+	if torr, changed, err := NormalizeTorrentField(""); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if torr != "" || changed {
+		t.Errorf("empty torrent field should not change, got %v %v", torr, changed)
+	}
+
+	if torr, changed, err := NormalizeTorrentField(`{"url":"","signature":""}`); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if torr != "" || !changed {
+		t.Errorf("legacy torrent field should be dropped, got %v %v", torr, changed)
+	}
+
+	if torr, changed, err := NormalizeTorrentField(`{ "signature": "123456", "url": "https://host/abc.torrent" }`); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if torr != `{"url":"https://host/abc.torrent","signature":"123456"}` || !changed {
+		t.Errorf("image server torrent field should be canonical, got %v %v", torr, changed)
+	}
+
+	if torr, changed, err := NormalizeTorrentField(`{"url":"https://host/abc.torrent","signature":"123456"}`); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if changed {
+		t.Errorf("canonical torrent field should not change, got %v", torr)
+	}
+
+	if _, _, err := NormalizeTorrentField(`{"url":`); err == nil {
+		t.Errorf("expected error for malformed torrent field")
+	}
+
+}
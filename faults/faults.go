@@ -0,0 +1,75 @@
+package faults
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The fault injection facility is only compiled into anax when it is built with the faultinjection build tag,
+// e.g. BUILD_TAGS=faultinjection make. In a normal build, Inject is a no-op that always returns false. When it is
+// compiled in, the rate at which each fault is injected is read from the HZN_FAULT_INJECTION environment variable,
+// which is a comma separated list of point=rate pairs, where rate is between 0 and 1. For example:
+//
+//   HZN_FAULT_INJECTION=exchange_5xx=0.1,mailbox_drop=0.05,blockchain_timeout=0.2,docker_error=0.1
+//
+// HZN_FAULT_INJECTION_SEED can be set to an integer to make the sequence of injected faults repeatable.
+
+const FaultInjectionEnvvarName = "HZN_FAULT_INJECTION"
+const FaultInjectionSeedEnvvarName = "HZN_FAULT_INJECTION_SEED"
+
+type FaultPoint string
+
+const (
+	EXCHANGE_5XX       FaultPoint = "exchange_5xx"       // the exchange returns a server error
+	MAILBOX_DROP       FaultPoint = "mailbox_drop"       // a message read from an exchange mailbox is lost
+	BLOCKCHAIN_TIMEOUT FaultPoint = "blockchain_timeout" // a blockchain RPC call times out
+	DOCKER_ERROR       FaultPoint = "docker_error"       // the docker API returns an error
+)
+
+var allFaultPoints = []FaultPoint{EXCHANGE_5XX, MAILBOX_DROP, BLOCKCHAIN_TIMEOUT, DOCKER_ERROR}
+
+// Parse a fault injection specification into the rate for each fault point. An empty specification
+// means that no faults are injected.
+func ParseRates(spec string) (map[FaultPoint]float64, error) {
+	rates := make(map[FaultPoint]float64)
+	if strings.TrimSpace(spec) == "" {
+		return rates, nil
+	}
+
+	for _, pair := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.New(fmt.Sprintf("fault injection setting %v must be of the form point=rate", pair))
+		}
+
+		point := FaultPoint(strings.TrimSpace(parts[0]))
+		if !knownFaultPoint(point) {
+			return nil, errors.New(fmt.Sprintf("unknown fault injection point %v, must be one of %v", point, allFaultPoints))
+		}
+
+		rate, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("fault injection rate %v for %v is not a number, error: %v", parts[1], point, err))
+		} else if rate < 0 || rate > 1 {
+			return nil, errors.New(fmt.Sprintf("fault injection rate %v for %v must be between 0 and 1", rate, point))
+		}
+		rates[point] = rate
+	}
+	return rates, nil
+}
+
+func knownFaultPoint(point FaultPoint) bool {
+	for _, p := range allFaultPoints {
+		if p == point {
+			return true
+		}
+	}
+	return false
+}
+
+// The error message used for injected faults, so that they are easy to find in the log.
+func Message(point FaultPoint) string {
+	return fmt.Sprintf("injected fault %v", point)
+}
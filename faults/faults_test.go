@@ -0,0 +1,31 @@
+// +build unit
+
+package faults
+
+import (
+	"testing"
+)
+
+func Test_ParseRates(t *testing.T) {
+
+	if rates, err := ParseRates(""); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if len(rates) != 0 {
+		t.Errorf("expected no rates, got %v", rates)
+	}
+
+	if rates, err := ParseRates("exchange_5xx=0.1, mailbox_drop=0.05,blockchain_timeout=1,docker_error=0"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if len(rates) != 4 {
+		t.Errorf("expected 4 rates, got %v", rates)
+	} else if rates[EXCHANGE_5XX] != 0.1 || rates[MAILBOX_DROP] != 0.05 || rates[BLOCKCHAIN_TIMEOUT] != 1 || rates[DOCKER_ERROR] != 0 {
+		t.Errorf("wrong rates %v", rates)
+	}
+
+	for _, bad := range []string{"exchange_5xx", "nosuchpoint=0.1", "docker_error=abc", "docker_error=1.5", "docker_error=-0.1"} {
+		if _, err := ParseRates(bad); err == nil {
+			t.Errorf("expected error parsing %v", bad)
+		}
+	}
+
+}
@@ -0,0 +1,62 @@
+// +build faultinjection
+
+package faults
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/cutil"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var rates map[FaultPoint]float64
+var random *rand.Rand
+var randomLock sync.Mutex
+
+func init() {
+	seed := time.Now().UnixNano()
+	if s := os.Getenv(FaultInjectionSeedEnvvarName); s != "" {
+		if i, err := strconv.ParseInt(s, 10, 64); err != nil {
+			glog.Errorf(logString(fmt.Sprintf("ignoring %v %v, error: %v", FaultInjectionSeedEnvvarName, s, err)))
+		} else {
+			seed = i
+		}
+	}
+	random = rand.New(rand.NewSource(seed))
+
+	var err error
+	if rates, err = ParseRates(os.Getenv(FaultInjectionEnvvarName)); err != nil {
+		glog.Errorf(logString(fmt.Sprintf("fault injection disabled, error: %v", err)))
+		rates = make(map[FaultPoint]float64)
+	} else {
+		glog.Warningf(logString(fmt.Sprintf("fault injection is compiled in, rates: %v, seed: %v", rates, seed)))
+	}
+}
+
+// Returns true if a fault should be injected at the given point.
+func Inject(point FaultPoint) bool {
+	rate, ok := rates[point]
+	if !ok || rate == 0 {
+		return false
+	}
+
+	randomLock.Lock()
+	inject := random.Float64() < rate
+	randomLock.Unlock()
+
+	if inject {
+		glog.Warningf(logString(Message(point)))
+	}
+	return inject
+}
+
+var logString = func(v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("Fault Injection", nil, v)
+	}
+	return fmt.Sprintf("Fault Injection: %v", v)
+}
@@ -0,0 +1,8 @@
+// +build !faultinjection
+
+package faults
+
+// Fault injection is not compiled into this build.
+func Inject(point FaultPoint) bool {
+	return false
+}
@@ -35,6 +35,7 @@ type CleanupExecutionCommand struct {
 	AgreementId       string
 	Reason            uint
 	Deployment        map[string]persistence.ServiceConfig
+	WorkloadFailed    bool // the agreement is ending because its workload could not be started or has failed
 }
 
 func (c CleanupExecutionCommand) ShortString() string {
@@ -43,7 +44,7 @@ func (c CleanupExecutionCommand) ShortString() string {
 		depStr = depStr + key + ","
 	}
 
-	return fmt.Sprintf("CleanupExecutionCommand: AgreementId %v, AgreementProtocol %v, Reason %v, Deployed Services %v", c.AgreementId, c.AgreementProtocol, c.Reason, depStr)
+	return fmt.Sprintf("CleanupExecutionCommand: AgreementId %v, AgreementProtocol %v, Reason %v, Deployed Services %v, WorkloadFailed %v", c.AgreementId, c.AgreementProtocol, c.Reason, depStr, c.WorkloadFailed)
 }
 
 func (w *GovernanceWorker) NewCleanupExecutionCommand(protocol string, agreementId string, reason uint, deployment map[string]persistence.ServiceConfig) *CleanupExecutionCommand {
@@ -172,3 +173,48 @@ func (c StartAgreementLessServicesCommand) ShortString() string {
 func (w *GovernanceWorker) NewStartAgreementLessServicesCommand() *StartAgreementLessServicesCommand {
 	return &StartAgreementLessServicesCommand{}
 }
+
+// ==============================================================================================================
+// Report the resources used by the containers of an agreement to the agbot
+type ReportResourceUsageCommand struct {
+	Msg *events.ContainerUsageMessage
+}
+
+func (c ReportResourceUsageCommand) ShortString() string {
+	return fmt.Sprintf("ReportResourceUsageCommand Msg: %v", c.Msg)
+}
+
+func (w *GovernanceWorker) NewReportResourceUsageCommand(msg *events.ContainerUsageMessage) *ReportResourceUsageCommand {
+	return &ReportResourceUsageCommand{
+		Msg: msg,
+	}
+}
+
+// ==============================================================================================================
+// Report the errors of the node, like rolled back workloads, to the exchange
+type ReportNodeErrorsCommand struct {
+}
+
+func (c ReportNodeErrorsCommand) ShortString() string {
+	return fmt.Sprintf("ReportNodeErrorsCommand")
+}
+
+func (w *GovernanceWorker) NewReportNodeErrorsCommand() *ReportNodeErrorsCommand {
+	return &ReportNodeErrorsCommand{}
+}
+
+// ==============================================================================================================
+// Start the containers that use the changed user input of a service again
+type ServiceUserInputChangedCommand struct {
+	Msg *events.ServiceUserInputChangedMessage
+}
+
+func (c ServiceUserInputChangedCommand) ShortString() string {
+	return fmt.Sprintf("ServiceUserInputChangedCommand Msg: %v", c.Msg)
+}
+
+func (w *GovernanceWorker) NewServiceUserInputChangedCommand(msg *events.ServiceUserInputChangedMessage) *ServiceUserInputChangedCommand {
+	return &ServiceUserInputChangedCommand{
+		Msg: msg,
+	}
+}
@@ -0,0 +1,39 @@
+package governance
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/events"
+)
+
+// Check the free space of the file systems holding the data that anax manages. While it is below the minimum, the
+// producer protocol handlers reject new agreements, and the other workers are told so that they can free some space.
+func (w *GovernanceWorker) checkDiskSpace() int {
+
+	guard := w.Config.Edge.DiskGuard
+	state, changed, errs := cutil.UpdateDiskSpaceState(guard.Paths, guard.WarnFreeMB, guard.MinFreeMB)
+	for path, err := range errs {
+		glog.Errorf(logString(fmt.Sprintf("unable to check the free disk space of %v, error: %v", path, err)))
+		w.RecordSubworkerError(DISK_SPACE_GUARD, errors.New(fmt.Sprintf("unable to check the free disk space of %v, error: %v", path, err)))
+	}
+
+	if state.Warning {
+		for _, fs := range state.FileSystems {
+			if fs.FreeMB < guard.WarnFreeMB {
+				glog.Warningf(logString(fmt.Sprintf("free disk space of %v is %v MB, below the warning threshold of %v MB", fs.Path, fs.FreeMB, guard.WarnFreeMB)))
+			}
+		}
+	}
+
+	if changed && state.Low {
+		glog.Errorf(logString(fmt.Sprintf("free disk space is below %v MB, new agreements are rejected until space is freed: %v", guard.MinFreeMB, state.FileSystems)))
+		w.Messages() <- events.NewDiskSpaceMessage(events.DISK_SPACE_LOW)
+	} else if changed {
+		glog.Infof(logString(fmt.Sprintf("free disk space is above %v MB again, new agreements are accepted: %v", guard.MinFreeMB, state.FileSystems)))
+		w.Messages() <- events.NewDiskSpaceMessage(events.DISK_SPACE_RESTORED)
+	}
+
+	return 0
+}
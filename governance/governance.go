@@ -17,6 +17,7 @@ import (
 	"github.com/open-horizon/anax/persistence"
 	"github.com/open-horizon/anax/policy"
 	"github.com/open-horizon/anax/producer"
+	"github.com/open-horizon/anax/version"
 	"github.com/open-horizon/anax/worker"
 	"net/http"
 	"net/url"
@@ -44,17 +45,22 @@ const STATUS_AG_PROTOCOL_TERMINATED = 501
 const CONTAINER_GOVERNOR = "ContainerGovernor"
 const MICROSERVICE_GOVERNOR = "MicroserviceGovernor"
 const BC_GOVERNOR = "BlockchainGovernor"
+const RESOURCE_USAGE_REPORTER = "ResourceUsageReporter"
+const DISK_SPACE_GUARD = "DiskSpaceGuard"
+const STATISTICS_REPORTER = "StatisticsReporter"
 
 type GovernanceWorker struct {
-	worker.BaseWorker   // embedded field
-	db                  *bolt.DB
-	bc                  *ethblockchain.BaseContracts
-	devicePattern       string
-	pm                  *policy.PolicyManager
-	producerPH          map[string]producer.ProducerProtocolHandler
-	deviceStatus        *DeviceStatus
-	ShuttingDownCmd     *NodeShutdownCommand
-	lastSvcUpgradeCheck int64
+	worker.BaseWorker    // embedded field
+	db                   *bolt.DB
+	bc                   *ethblockchain.BaseContracts
+	devicePattern        string
+	pm                   *policy.PolicyManager
+	producerPH           map[string]producer.ProducerProtocolHandler
+	deviceStatus         *DeviceStatus
+	ShuttingDownCmd      *NodeShutdownCommand
+	lastSvcUpgradeCheck  int64
+	callbacks            *WorkloadCallbacks // nil when no workload callbacks are configured
+	lastStatisticsReport uint64             // the time of the last statistics report that was sent
 }
 
 func NewGovernanceWorker(name string, cfg *config.HorizonConfig, db *bolt.DB, pm *policy.PolicyManager) *GovernanceWorker {
@@ -77,6 +83,12 @@ func NewGovernanceWorker(name string, cfg *config.HorizonConfig, db *bolt.DB, pm
 		lastSvcUpgradeCheck: time.Now().Unix(),
 	}
 
+	if callbacks, err := NewWorkloadCallbacks(cfg.Edge.WorkloadCallbacks, cfg.Collaborators.HTTPClientFactory); err != nil {
+		glog.Errorf(logString(fmt.Sprintf("workload callbacks are turned off, error: %v", err)))
+	} else {
+		worker.callbacks = callbacks
+	}
+
 	worker.Start(worker, 10)
 	return worker
 }
@@ -114,9 +126,11 @@ func (w *GovernanceWorker) NewEvent(incoming events.Message) {
 			w.Commands <- cmd
 		case events.EXECUTION_FAILED:
 			cmd := w.NewCleanupExecutionCommand(msg.AgreementProtocol, msg.AgreementId, w.producerPH[msg.AgreementProtocol].GetTerminationCode(producer.TERM_REASON_CONTAINER_FAILURE), msg.Deployment)
+			cmd.WorkloadFailed = true
 			w.Commands <- cmd
 		case events.IMAGE_LOAD_FAILED:
 			cmd := w.NewCleanupExecutionCommand(msg.AgreementProtocol, msg.AgreementId, w.producerPH[msg.AgreementProtocol].GetTerminationCode(producer.TERM_REASON_WL_IMAGE_LOAD_FAILURE), msg.Deployment)
+			cmd.WorkloadFailed = true
 			w.Commands <- cmd
 		case events.WORKLOAD_DESTROYED:
 			cmd := w.NewCleanupStatusCommand(msg.AgreementProtocol, msg.AgreementId, STATUS_WORKLOAD_DESTROYED)
@@ -150,6 +164,7 @@ func (w *GovernanceWorker) NewEvent(incoming events.Message) {
 					reason = w.producerPH[lc.AgreementProtocol].GetTerminationCode(producer.TERM_REASON_IMAGE_FETCH_FAILURE)
 				}
 				cmd := w.NewCleanupExecutionCommand(lc.AgreementProtocol, lc.AgreementId, reason, nil)
+				cmd.WorkloadFailed = true
 				w.Commands <- cmd
 			case *events.ContainerLaunchContext:
 				lc := msg.LaunchContext.(*events.ContainerLaunchContext)
@@ -251,6 +266,38 @@ func (w *GovernanceWorker) NewEvent(incoming events.Message) {
 			w.Commands <- worker.NewTerminateCommand("shutdown")
 		}
 
+	case *events.ContainerUsageMessage:
+		msg, _ := incoming.(*events.ContainerUsageMessage)
+		switch msg.Event().Id {
+		case events.CONTAINER_USAGE:
+			w.Commands <- w.NewReportResourceUsageCommand(msg)
+		}
+
+	case *events.ExchangeOutageMessage:
+		msg, _ := incoming.(*events.ExchangeOutageMessage)
+		switch msg.Event().Id {
+		case events.EXCHANGE_RESTORED:
+			// The status reports were skipped while the exchange was unreachable.
+			w.Commands <- w.NewReportDeviceStatusCommand()
+			if w.Config.Edge.WorkloadRollback.Enabled() {
+				w.Commands <- w.NewReportNodeErrorsCommand()
+			}
+		}
+
+	case *events.WorkloadRollbackMessage:
+		msg, _ := incoming.(*events.WorkloadRollbackMessage)
+		switch msg.Event().Id {
+		case events.WORKLOAD_ROLLBACK:
+			w.Commands <- w.NewReportNodeErrorsCommand()
+		}
+
+	case *events.ServiceUserInputChangedMessage:
+		msg, _ := incoming.(*events.ServiceUserInputChangedMessage)
+		switch msg.Event().Id {
+		case events.SERVICE_USER_INPUT_CHANGED:
+			w.Commands <- w.NewServiceUserInputChangedCommand(msg)
+		}
+
 	default: //nothing
 	}
 
@@ -280,6 +327,13 @@ func (w *GovernanceWorker) governAgreements() {
 			protocolHandler := w.producerPH[ag.AgreementProtocol].AgreementProtocolHandler(bcType, bcName, bcOrg)
 			if ag.AgreementFinalizedTime == 0 { // TODO: might need to change this to be a protocol specific check
 
+				// The agreement cannot be verified with the agbot while the exchange is unreachable, so it is not
+				// timed out either. The checks resume when the node leaves degraded mode.
+				if exchange.ExchangeDegraded() {
+					glog.V(5).Infof(logString(fmt.Sprintf("exchange is unreachable, skipping finalization check for agreement %v.", ag.CurrentAgreementId)))
+					continue
+				}
+
 				// Cancel the agreement if finalization doesn't occur before the timeout
 				glog.V(5).Infof(logString(fmt.Sprintf("checking agreement %v for finalization.", ag.CurrentAgreementId)))
 
@@ -368,6 +422,29 @@ func (w *GovernanceWorker) governContainers() int {
 
 		}
 	}
+
+	// Verify the workload versions that ran long enough, so that an upgrade of them can be rolled back.
+	w.verifyWorkloadVersions()
+	return 0
+}
+
+// Ask the container worker to sample the containers of the running agreements. The samples come back as events and
+// are reported to the agbots.
+func (w *GovernanceWorker) sampleContainers() int {
+
+	runningFilter := func() persistence.EAFilter {
+		return func(a persistence.EstablishedAgreement) bool {
+			return a.AgreementExecutionStartTime != 0 && a.AgreementTerminatedTime == 0
+		}
+	}
+
+	if establishedAgreements, err := persistence.FindEstablishedAgreementsAllProtocols(w.db, policy.AllAgreementProtocols(), []persistence.EAFilter{persistence.UnarchivedEAFilter(), runningFilter()}); err != nil {
+		glog.Errorf(logString(fmt.Sprintf("Unable to retrieve running agreements from database, error: %v", err)))
+	} else {
+		for _, ag := range establishedAgreements {
+			w.Messages() <- events.NewGovernanceMaintenanceMessage(events.SAMPLE_CONTAINERS, ag.AgreementProtocol, ag.CurrentAgreementId, ag.CurrentDeployment)
+		}
+	}
 	return 0
 }
 
@@ -380,26 +457,32 @@ func (w *GovernanceWorker) reportBlockchains() int {
 	for _, agp := range policy.AllAgreementProtocols() {
 
 		// If the agreement protocol doesnt require a blockchain then we can skip it.
-		if bcType := policy.RequiresBlockchainType(agp); bcType == "" {
+		if defaultType := policy.RequiresBlockchainType(agp); defaultType == "" {
 			continue
 		} else {
 
 			// Make a map of all blockchain orgs and names that we need to have running
-			neededBCs := make(map[string]map[string]bool)
+			neededBCs := make(map[string]map[string]map[string]bool)
 			if agreements, err := persistence.FindEstablishedAgreements(w.db, agp, []persistence.EAFilter{persistence.UnarchivedEAFilter()}); err == nil {
 				for _, ag := range agreements {
-					_, bcName, bcOrg := w.producerPH[agp].GetKnownBlockchain(&ag)
+					bcType, bcName, bcOrg := w.producerPH[agp].GetKnownBlockchain(&ag)
 					if bcName != "" {
-						if _, ok := neededBCs[bcOrg]; !ok {
-							neededBCs[bcOrg] = make(map[string]bool)
+						if bcType == "" {
+							bcType = defaultType
+						}
+						if _, ok := neededBCs[bcType]; !ok {
+							neededBCs[bcType] = make(map[string]map[string]bool)
+						}
+						if _, ok := neededBCs[bcType][bcOrg]; !ok {
+							neededBCs[bcType][bcOrg] = make(map[string]bool)
 						}
-						neededBCs[bcOrg][bcName] = true
+						neededBCs[bcType][bcOrg][bcName] = true
 					}
 				}
 
-				// If we captured any needed blockchains, inform the blockchain worker
-				if len(neededBCs) != 0 {
-					w.Messages() <- events.NewReportNeededBlockchainsMessage(events.BC_NEEDED, bcType, neededBCs)
+				// If we captured any needed blockchains, inform the blockchain worker of each type
+				for bcType, typeBCs := range neededBCs {
+					w.Messages() <- events.NewReportNeededBlockchainsMessage(events.BC_NEEDED, bcType, typeBCs)
 				}
 
 			} else {
@@ -420,7 +503,7 @@ func (w *GovernanceWorker) cancelAgreement(agreementId string, agreementProtocol
 	// Update the database
 	var ag *persistence.EstablishedAgreement
 	if agreement, err := persistence.AgreementStateTerminated(w.db, agreementId, uint64(reason), desc, agreementProtocol); err != nil {
-		glog.Errorf(logString(fmt.Sprintf("error marking agreement %v terminated: %v", agreementId, err)))
+		glog.Errorf(logStringA(agreementId, agreementProtocol, fmt.Sprintf("error marking agreement %v terminated: %v", agreementId, err)))
 	} else {
 		ag = agreement
 	}
@@ -428,7 +511,7 @@ func (w *GovernanceWorker) cancelAgreement(agreementId string, agreementProtocol
 	// Delete from the exchange
 	if ag != nil && ag.AgreementAcceptedTime != 0 {
 		if err := deleteProducerAgreement(w.Config.Collaborators.HTTPClientFactory.NewHTTPClient(nil), w.GetExchangeURL(), w.GetExchangeId(), w.GetExchangeToken(), agreementId); err != nil {
-			glog.Errorf(logString(fmt.Sprintf("error deleting agreement %v in exchange: %v", agreementId, err)))
+			glog.Errorf(logStringA(agreementId, agreementProtocol, fmt.Sprintf("error deleting agreement %v in exchange: %v", agreementId, err)))
 		}
 	}
 
@@ -440,6 +523,68 @@ func (w *GovernanceWorker) cancelAgreement(agreementId string, agreementProtocol
 	}
 }
 
+// Protocol messages that were deferred by an older version of anax are given to the extension message handlers
+// again. Messages that are still not understood stay in the database until the next upgrade, unless the agreement
+// they refer to is gone.
+func (w *GovernanceWorker) processDeferredProtocolMessages() {
+
+	msgs, err := persistence.FindDeferredProtocolMessages(w.db)
+	if err != nil {
+		glog.Errorf(logString(fmt.Sprintf("unable to retrieve deferred protocol messages, error: %v", err)))
+		return
+	}
+
+	for _, dm := range msgs {
+		if dm.StoredByVersion == version.HORIZON_VERSION {
+			continue
+		}
+
+		pph, ok := w.producerPH[dm.Protocol]
+		if !ok {
+			glog.Warningf(logString(fmt.Sprintf("deleting deferred protocol message %v, protocol %v is not supported", dm.MsgId, dm.Protocol)))
+			persistence.DeleteDeferredProtocolMessage(w.db, dm.MsgId)
+			continue
+		}
+
+		ags, err := persistence.FindEstablishedAgreements(w.db, dm.Protocol, []persistence.EAFilter{persistence.UnarchivedEAFilter(), persistence.IdEAFilter(dm.AgreementId)})
+		if err != nil {
+			glog.Errorf(logString(fmt.Sprintf("unable to retrieve agreement %v from database, error %v", dm.AgreementId, err)))
+			continue
+		} else if len(ags) != 1 || ags[0].AgreementTerminatedTime != 0 {
+			glog.V(3).Infof(logString(fmt.Sprintf("deleting deferred protocol message %v, agreement %v is no longer active", dm.MsgId, dm.AgreementId)))
+			persistence.DeleteDeferredProtocolMessage(w.db, dm.MsgId)
+			continue
+		}
+
+		msg := events.NewExchangeDeviceMessage(events.RECEIVED_EXCHANGE_DEV_MSG, nil, dm.ProtocolMessage)
+		exchangeMsg := &exchange.DeviceMessage{
+			MsgId:       dm.MsgId,
+			AgbotId:     dm.AgbotId,
+			AgbotPubKey: dm.AgbotPubKey,
+		}
+
+		handled, cancel, agid, err := pph.HandleExtensionMessages(msg, exchangeMsg)
+		if err != nil {
+			glog.Errorf(logString(fmt.Sprintf("unable to handle deferred protocol message %v, error: %v", dm, err)))
+		} else if !handled {
+			glog.V(3).Infof(logString(fmt.Sprintf("deferred protocol message %v is still not understood", dm)))
+			continue
+		}
+
+		if cancel {
+			reason := pph.GetTerminationCode(producer.TERM_REASON_AGBOT_REQUESTED)
+			w.cancelAgreement(agid, dm.Protocol, reason, pph.GetTerminationReason(reason))
+			w.Messages() <- events.NewGovernanceWorkloadCancelationMessage(events.AGREEMENT_ENDED, events.AG_TERMINATED, dm.Protocol, agid, ags[0].CurrentDeployment)
+			w.handleMicroserviceInstForAgEnded(agid, false)
+		}
+
+		glog.V(3).Infof(logString(fmt.Sprintf("processed deferred protocol message %v", dm)))
+		if err := persistence.DeleteDeferredProtocolMessage(w.db, dm.MsgId); err != nil {
+			glog.Errorf(logString(fmt.Sprintf("unable to delete deferred protocol message %v, error: %v", dm.MsgId, err)))
+		}
+	}
+}
+
 func (w *GovernanceWorker) externalTermination(ag *persistence.EstablishedAgreement, agreementId string, agreementProtocol string, reason uint) {
 
 	// Put the rest of the cancel processing into it's own go routine. In some agreement protocols, this go
@@ -450,7 +595,7 @@ func (w *GovernanceWorker) externalTermination(ag *persistence.EstablishedAgreem
 	go func() {
 
 		// Get the policy we used in the agreement and then cancel, just in case.
-		glog.V(3).Infof(logString(fmt.Sprintf("terminating agreement %v", agreementId)))
+		glog.V(3).Infof(logStringA(agreementId, agreementProtocol, fmt.Sprintf("terminating agreement %v", agreementId)))
 
 		if ag != nil {
 			w.producerPH[agreementProtocol].TerminateAgreement(ag, reason)
@@ -469,14 +614,14 @@ func (w *GovernanceWorker) externalTermination(ag *persistence.EstablishedAgreem
 	go func() {
 		// If there are metering notifications, write them onto the blockchain also
 		if ag.MeteringNotificationMsg != (persistence.MeteringNotification{}) && !ag.Archived {
-			glog.V(3).Infof(logString(fmt.Sprintf("Writing Metering Notification %v to the blockchain for %v.", ag.MeteringNotificationMsg, agreementId)))
+			glog.V(3).Infof(logStringA(agreementId, agreementProtocol, fmt.Sprintf("Writing Metering Notification %v to the blockchain for %v.", ag.MeteringNotificationMsg, agreementId)))
 			bcType, bcName, bcOrg := w.producerPH[agreementProtocol].GetKnownBlockchain(ag)
 			if mn := metering.ConvertFromPersistent(ag.MeteringNotificationMsg, agreementId); mn == nil {
-				glog.Errorf(logString(fmt.Sprintf("error converting from persistent Metering Notification %v for %v, returned nil.", ag.MeteringNotificationMsg, agreementId)))
+				glog.Errorf(logStringA(agreementId, agreementProtocol, fmt.Sprintf("error converting from persistent Metering Notification %v for %v, returned nil.", ag.MeteringNotificationMsg, agreementId)))
 			} else if aph := w.producerPH[agreementProtocol].AgreementProtocolHandler(bcType, bcName, bcOrg); aph == nil {
-				glog.Warningf(logString(fmt.Sprintf("cannot write meter record for %v, agreement protocol handler is not ready.", agreementId)))
+				glog.Warningf(logStringA(agreementId, agreementProtocol, fmt.Sprintf("cannot write meter record for %v, agreement protocol handler is not ready.", agreementId)))
 			} else if err := aph.RecordMeter(agreementId, mn); err != nil {
-				glog.Errorf(logString(fmt.Sprintf("error writing meter %v for agreement %v on the blockchain: %v", ag.MeteringNotificationMsg, agreementId, err)))
+				glog.Errorf(logStringA(agreementId, agreementProtocol, fmt.Sprintf("error writing meter %v for agreement %v on the blockchain: %v", ag.MeteringNotificationMsg, agreementId, err)))
 			}
 		}
 	}()
@@ -502,18 +647,40 @@ func (w *GovernanceWorker) Initialize() bool {
 		w.producerPH[protocolName] = pph
 	}
 
+	// Process protocol messages that an older version of anax did not understand.
+	w.processDeferredProtocolMessages()
+
 	// report the device status to the exchange
 	w.ReportDeviceStatus()
 
 	// Fire up the container governor
 	w.DispatchSubworker(CONTAINER_GOVERNOR, w.governContainers, 60)
 
-	// Fire up the blockchain reporter
-	w.DispatchSubworker(BC_GOVERNOR, w.reportBlockchains, 60)
+	// Fire up the blockchain reporter, there are no blockchain clients with the low memory profile
+	if !w.Config.Edge.LowMemory() {
+		w.DispatchSubworker(BC_GOVERNOR, w.reportBlockchains, 60)
+	}
 
 	// Fire up the microservice governor
 	w.DispatchSubworker(MICROSERVICE_GOVERNOR, w.governMicroservices, 60)
 
+	// Fire up the resource usage reporter
+	if w.Config.Edge.ResourceUsageReportIntervalS > 0 {
+		w.DispatchSubworker(RESOURCE_USAGE_REPORTER, w.sampleContainers, w.Config.Edge.ResourceUsageReportIntervalS)
+	}
+
+	// Fire up the disk space guard, checking right away so that a full disk is known before the first proposal
+	if w.Config.Edge.DiskGuard.Enabled() {
+		w.checkDiskSpace()
+		w.DispatchSubworker(DISK_SPACE_GUARD, w.checkDiskSpace, w.Config.Edge.DiskGuard.CheckIntervalS)
+	}
+
+	// Fire up the statistics reporter, when the node opted in
+	if w.Config.Edge.Statistics.Enabled() {
+		w.lastStatisticsReport = uint64(time.Now().Unix())
+		w.DispatchSubworker(STATISTICS_REPORTER, w.reportStatistics, w.Config.Edge.Statistics.IntervalS)
+	}
+
 	return true
 
 }
@@ -528,8 +695,11 @@ func (w *GovernanceWorker) CommandHandler(command worker.Command) bool {
 		cmd, _ := command.(*StartGovernExecutionCommand)
 		glog.V(3).Infof(logString(fmt.Sprintf("Starting governance on resources in agreement: %v", cmd.AgreementId)))
 
-		if _, err := persistence.AgreementStateExecutionStarted(w.db, cmd.AgreementId, cmd.AgreementProtocol, &cmd.Deployment); err != nil {
+		if ag, err := persistence.AgreementStateExecutionStarted(w.db, cmd.AgreementId, cmd.AgreementProtocol, &cmd.Deployment); err != nil {
 			glog.Errorf(logString(fmt.Sprintf("Failed to update local contract record to start governing Agreement: %v. Error: %v", cmd.AgreementId, err)))
+		} else {
+			w.callbacks.Notify(NewWorkloadEvent(WORKLOAD_STARTED, ag, ""))
+			w.recordWorkloadStarted(ag)
 		}
 
 	case *CleanupExecutionCommand:
@@ -544,7 +714,20 @@ func (w *GovernanceWorker) CommandHandler(command worker.Command) bool {
 			glog.V(3).Infof(logString(fmt.Sprintf("ignoring the event, agreement %v is already terminating", agreementId)))
 		} else {
 			glog.V(3).Infof(logString(fmt.Sprintf("Ending the agreement: %v", agreementId)))
-			w.cancelAgreement(agreementId, cmd.AgreementProtocol, cmd.Reason, w.producerPH[cmd.AgreementProtocol].GetTerminationReason(cmd.Reason))
+			// A workload upgrade that keeps failing is rolled back instead of just ending its agreement.
+			rollback := false
+			var versions *persistence.WorkloadVersions
+			if cmd.WorkloadFailed {
+				rollback, versions = w.recordWorkloadFailed(&ags[0])
+			}
+			if rollback {
+				w.rollbackWorkload(&ags[0], versions)
+			} else {
+				w.cancelAgreement(agreementId, cmd.AgreementProtocol, cmd.Reason, w.producerPH[cmd.AgreementProtocol].GetTerminationReason(cmd.Reason))
+			}
+			if cmd.WorkloadFailed {
+				w.callbacks.Notify(NewWorkloadEvent(WORKLOAD_FAILED, &ags[0], w.producerPH[cmd.AgreementProtocol].GetTerminationReason(cmd.Reason)))
+			}
 
 			// send the event to the container in case it has started the workloads.
 			w.Messages() <- events.NewGovernanceWorkloadCancelationMessage(events.AGREEMENT_ENDED, events.AG_TERMINATED, cmd.AgreementProtocol, agreementId, cmd.Deployment)
@@ -577,6 +760,10 @@ func (w *GovernanceWorker) CommandHandler(command worker.Command) bool {
 			glog.Errorf(logString(fmt.Sprintf("unable to extract agreement protocol name from message %v", protocolMsg)))
 		} else if _, ok := w.producerPH[msgProtocol]; !ok {
 			glog.Infof(logString(fmt.Sprintf("unable to direct exchange message %v to a protocol handler, deleting it.", protocolMsg)))
+		} else if _, err := w.producerPH[msgProtocol].AgreementProtocolHandler("", "", "").ValidateProposal(protocolMsg); err != nil && w.producerPH[msgProtocol].ReliableMessageReceived(protocolMsg, exchangeMsg) {
+			// Proposals are acknowledged by the agreement worker. Message acks and messages that were already
+			// received need no further processing.
+			glog.V(5).Infof(logString(fmt.Sprintf("message %v needs no further processing", exchangeMsg.MsgId)))
 		} else {
 
 			deleteMessage = false
@@ -644,6 +831,9 @@ func (w *GovernanceWorker) CommandHandler(command worker.Command) bool {
 			} else if _, err := persistence.MeteringNotificationReceived(w.db, mnReceived.AgreementId(), *mn, msgProtocol); err != nil {
 				glog.Errorf(logString(fmt.Sprintf("unable to update metering notification for %v, error: %v", mnReceived.AgreementId(), err)))
 				deleteMessage = true
+			} else if _, err := persistence.UpdateMeteringSummary(w.db, ags[0].RunningWorkload.URL, ags[0].RunningWorkload.Org, ags[0].ConsumerId, mnReceived.AgreementId(), *mn); err != nil {
+				glog.Errorf(logString(fmt.Sprintf("unable to update metering summary for %v, error: %v", mnReceived.AgreementId(), err)))
+				deleteMessage = true
 			} else {
 				deleteMessage = true
 			}
@@ -695,6 +885,13 @@ func (w *GovernanceWorker) CommandHandler(command worker.Command) bool {
 			}
 			if handled {
 				deleteMessage = handled
+			} else if !deleteMessage {
+				// Nobody recognized the message. If it came from a newer agbot, keep it for a newer anax to process.
+				if deferred, err := w.producerPH[msgProtocol].DeferExtensionMessage(&cmd.Msg, exchangeMsg); err != nil {
+					glog.Errorf(logString(fmt.Sprintf("unable to defer extension message %v, error: %v", cmd.Msg.ShortProtocolMessage(), err)))
+				} else if deferred {
+					deleteMessage = true
+				}
 			}
 
 		}
@@ -774,8 +971,11 @@ func (w *GovernanceWorker) CommandHandler(command worker.Command) bool {
 			case STATUS_WORKLOAD_DESTROYED:
 				if agreement, err := persistence.AgreementStateWorkloadTerminated(w.db, cmd.AgreementId, cmd.AgreementProtocol); err != nil {
 					glog.Errorf(logString(fmt.Sprintf("error marking agreement %v workload terminated: %v", cmd.AgreementId, err)))
-				} else if agreement.AgreementProtocolTerminatedTime != 0 {
-					archive = true
+				} else {
+					w.callbacks.Notify(NewWorkloadEvent(WORKLOAD_STOPPED, agreement, agreement.TerminatedDescription))
+					if agreement.AgreementProtocolTerminatedTime != 0 {
+						archive = true
+					}
 				}
 			case STATUS_AG_PROTOCOL_TERMINATED:
 				if agreement, err := persistence.AgreementStateAgreementProtocolTerminated(w.db, cmd.AgreementId, cmd.AgreementProtocol); err != nil {
@@ -871,6 +1071,33 @@ func (w *GovernanceWorker) CommandHandler(command worker.Command) bool {
 		glog.V(5).Infof(logString(fmt.Sprintf("Report device status command %v", cmd)))
 		w.ReportDeviceStatus()
 
+	case *ReportResourceUsageCommand:
+		cmd, _ := command.(*ReportResourceUsageCommand)
+		glog.V(5).Infof(logString(fmt.Sprintf("Report resource usage command %v", cmd)))
+
+		// The report would only be lost while the exchange can't be reached, the next sample is reported instead.
+		if exchange.ExchangeDegraded() {
+			glog.V(5).Infof(logString(fmt.Sprintf("skipping resource usage report of agreement %v, the exchange is unreachable", cmd.Msg.AgreementId)))
+		} else if ags, err := persistence.FindEstablishedAgreements(w.db, cmd.Msg.AgreementProtocol, []persistence.EAFilter{persistence.UnarchivedEAFilter(), persistence.IdEAFilter(cmd.Msg.AgreementId)}); err != nil {
+			glog.Errorf(logString(fmt.Sprintf("unable to retrieve agreement %v from database, error %v", cmd.Msg.AgreementId, err)))
+		} else if len(ags) != 1 || ags[0].AgreementTerminatedTime != 0 {
+			glog.V(5).Infof(logString(fmt.Sprintf("ignoring resource usage of agreement %v, it is no longer active", cmd.Msg.AgreementId)))
+		} else if pph, ok := w.producerPH[cmd.Msg.AgreementProtocol]; !ok {
+			glog.Errorf(logString(fmt.Sprintf("no protocol handler for %v, unable to report resource usage of agreement %v", cmd.Msg.AgreementProtocol, cmd.Msg.AgreementId)))
+		} else if err := pph.ReportResourceUsage(&ags[0], cmd.Msg.Usage); err != nil {
+			glog.Errorf(logString(err.Error()))
+		}
+
+	case *ReportNodeErrorsCommand:
+		cmd, _ := command.(*ReportNodeErrorsCommand)
+		glog.V(5).Infof(logString(fmt.Sprintf("Report node errors command %v", cmd)))
+		w.reportNodeErrors()
+
+	case *ServiceUserInputChangedCommand:
+		cmd, _ := command.(*ServiceUserInputChangedCommand)
+		glog.V(5).Infof(logString(fmt.Sprintf("Service user input changed command %v", cmd)))
+		w.restartForUserInput(cmd.Msg.ServiceURL)
+
 	case *NodeShutdownCommand:
 		cmd, _ := command.(*NodeShutdownCommand)
 		glog.V(5).Infof(logString(fmt.Sprintf("Node shutdown command %v", cmd)))
@@ -901,6 +1128,14 @@ func (w *GovernanceWorker) NoWorkHandler() {
 	// Make sure that all known agreements are maintained, if we're not shutting down.
 	if !w.IsWorkerShuttingDown() {
 		w.governAgreements()
+
+		// Send the protocol messages that the agbots have not acknowledged again. The messages go through the
+		// exchange, so they are held while the exchange is unreachable.
+		if !exchange.ExchangeDegraded() {
+			for _, pph := range w.producerPH {
+				pph.ResendMessages()
+			}
+		}
 	}
 
 	// When all subworkers are down, start the shutdown process.
@@ -924,26 +1159,26 @@ func (w *GovernanceWorker) finalizeAgreement(agreement persistence.EstablishedAg
 	// was created by the agbot, we will assume we should have gotten a positive reply ack.
 	if agreement.AgreementAcceptedTime == 0 {
 		if proposal, err := protocolHandler.DemarshalProposal(agreement.Proposal); err != nil {
-			return errors.New(logString(fmt.Sprintf("unable to demarshal proposal for agreement %v from database, error %v", agreement.CurrentAgreementId, err)))
+			return errors.New(logStringA(agreement.CurrentAgreementId, protocolHandler.Name(), fmt.Sprintf("unable to demarshal proposal for agreement %v from database, error %v", agreement.CurrentAgreementId, err)))
 		} else if err := w.RecordReply(proposal, protocolHandler.Name()); err != nil {
-			return errors.New(logString(fmt.Sprintf("unable to accept agreement %v, error: %v", agreement.CurrentAgreementId, err)))
+			return errors.New(logStringA(agreement.CurrentAgreementId, protocolHandler.Name(), fmt.Sprintf("unable to accept agreement %v, error: %v", agreement.CurrentAgreementId, err)))
 		}
 	}
 
 	// Finalize the agreement in the DB
 	if _, err := persistence.AgreementStateFinalized(w.db, agreement.CurrentAgreementId, protocolHandler.Name()); err != nil {
-		return errors.New(logString(fmt.Sprintf("error persisting agreement %v finalized: %v", agreement.CurrentAgreementId, err)))
+		return errors.New(logStringA(agreement.CurrentAgreementId, protocolHandler.Name(), fmt.Sprintf("error persisting agreement %v finalized: %v", agreement.CurrentAgreementId, err)))
 	} else {
-		glog.V(3).Infof(logString(fmt.Sprintf("agreement %v finalized", agreement.CurrentAgreementId)))
+		glog.V(3).Infof(logStringA(agreement.CurrentAgreementId, protocolHandler.Name(), fmt.Sprintf("agreement %v finalized", agreement.CurrentAgreementId)))
 	}
 
 	// Update state in exchange
 	if proposal, err := protocolHandler.DemarshalProposal(agreement.Proposal); err != nil {
-		return errors.New(logString(fmt.Sprintf("could not hydrate proposal, error: %v", err)))
+		return errors.New(logStringA(agreement.CurrentAgreementId, protocolHandler.Name(), fmt.Sprintf("could not hydrate proposal, error: %v", err)))
 	} else if tcPolicy, err := policy.DemarshalPolicy(proposal.TsAndCs()); err != nil {
-		return errors.New(logString(fmt.Sprintf("error demarshalling TsAndCs policy for agreement %v, error %v", agreement.CurrentAgreementId, err)))
+		return errors.New(logStringA(agreement.CurrentAgreementId, protocolHandler.Name(), fmt.Sprintf("error demarshalling TsAndCs policy for agreement %v, error %v", agreement.CurrentAgreementId, err)))
 	} else if err := recordProducerAgreementState(w.Config.Collaborators.HTTPClientFactory.NewHTTPClient(nil), w.GetExchangeURL(), w.GetExchangeId(), w.GetExchangeToken(), w.devicePattern, agreement.CurrentAgreementId, tcPolicy, "Finalized Agreement"); err != nil {
-		return errors.New(logString(fmt.Sprintf("error setting agreement %v finalized state in exchange: %v", agreement.CurrentAgreementId, err)))
+		return errors.New(logStringA(agreement.CurrentAgreementId, protocolHandler.Name(), fmt.Sprintf("error setting agreement %v finalized state in exchange: %v", agreement.CurrentAgreementId, err)))
 	}
 
 	return nil
@@ -982,6 +1217,7 @@ func (w *GovernanceWorker) RecordReply(proposal abstractprotocol.Proposal, proto
 			}
 
 			cc := events.NewContainerConfig(*url, workload.Torrent.Signature, workload.Deployment, workload.DeploymentSignature, workload.DeploymentUserInfo, workload.DeploymentOverrides, img_auths)
+			cc.ImageFetch = workload.ImageFetch
 
 			lc := new(events.AgreementLaunchContext)
 			lc.Configure = *cc
@@ -1000,7 +1236,7 @@ func (w *GovernanceWorker) RecordReply(proposal abstractprotocol.Proposal, proto
 			// get the metadata for the version we are running and then add in any unset default user inputs.
 
 			var serviceDef exchange.ExchangeDefinition
-			if _, exDef, err := exchange.GetHTTPWorkloadOrServiceResolverHandler(w)(workload.WorkloadURL, workload.Org, workload.Version, workload.Arch); err != nil {
+			if _, exDef, err := exchange.GetCachedWorkloadOrServiceResolverHandler(w, w.db)(workload.WorkloadURL, workload.Org, workload.Version, workload.Arch); err != nil {
 				return errors.New(logString(fmt.Sprintf("received error querying exchange for workload or service metadata: %v, error %v", workload, err)))
 			} else if exDef == nil {
 				return errors.New(logString(fmt.Sprintf("cound not find workload or service metadata for %v.", workload)))
@@ -1106,8 +1342,8 @@ func (w *GovernanceWorker) startAgreementLessServices() {
 		return
 	}
 
-	// Get the pattern definition from the exchange.
-	patternDef, err := exchange.GetHTTPExchangePatternHandler(w)(exchange.GetOrg(w.GetExchangeId()), w.devicePattern)
+	// Get the pattern definition from the exchange, or from the metadata cache when the exchange is unreachable.
+	patternDef, err := exchange.GetCachedExchangePatternHandler(w, w.db)(exchange.GetOrg(w.GetExchangeId()), w.devicePattern)
 	if err != nil {
 		glog.Errorf(logString(fmt.Sprintf("Unable to start agreement-less services, error searching for pattern %v in exchange, error: %v", w.devicePattern, err)))
 		return
@@ -1300,6 +1536,9 @@ func deleteProducerAgreement(httpClient *http.Client, url string, deviceId strin
 		if err, tpErr := exchange.InvokeExchange(httpClient, "DELETE", targetURL, deviceId, token, nil, &resp); err != nil {
 			glog.Errorf(logString(fmt.Sprintf(err.Error())))
 			return err
+		} else if tpErr != nil && exchange.ExchangeDegraded() {
+			// The agreement is deleted from the exchange when the node leaves degraded mode.
+			return tpErr
 		} else if tpErr != nil {
 			glog.Warningf(logString(tpErr.Error()))
 			time.Sleep(10 * time.Second)
@@ -1315,7 +1554,7 @@ func deleteProducerAgreement(httpClient *http.Client, url string, deviceId strin
 func (w *GovernanceWorker) deleteMessage(msg *exchange.DeviceMessage) error {
 	var resp interface{}
 	resp = new(exchange.PostDeviceResponse)
-	targetURL := w.GetExchangeURL() + "orgs/" + exchange.GetOrg(w.GetExchangeId()) + "/nodes/" + exchange.GetId(w.GetExchangeId()) + "/msgs/" + strconv.Itoa(msg.MsgId)
+	targetURL := exchange.EdgeMessageTransport(w.Config).NodeMailboxURL(w.GetExchangeId()) + "/" + strconv.Itoa(msg.MsgId)
 	for {
 		if err, tpErr := exchange.InvokeExchange(w.Config.Collaborators.HTTPClientFactory.NewHTTPClient(nil), "DELETE", targetURL, w.GetExchangeId(), w.GetExchangeToken(), nil, &resp); err != nil {
 			glog.Errorf(logString(err.Error()))
@@ -1334,7 +1573,7 @@ func (w *GovernanceWorker) deleteMessage(msg *exchange.DeviceMessage) error {
 func (w *GovernanceWorker) messageInExchange(msgId int) (bool, error) {
 	var resp interface{}
 	resp = new(exchange.GetDeviceMessageResponse)
-	targetURL := w.GetExchangeURL() + "orgs/" + exchange.GetOrg(w.GetExchangeId()) + "/nodes/" + exchange.GetId(w.GetExchangeId()) + "/msgs"
+	targetURL := exchange.EdgeMessageTransport(w.Config).NodeMailboxURL(w.GetExchangeId())
 	for {
 		if err, tpErr := exchange.InvokeExchange(w.Config.Collaborators.HTTPClientFactory.NewHTTPClient(nil), "GET", targetURL, w.GetExchangeId(), w.GetExchangeToken(), nil, &resp); err != nil {
 			glog.Errorf(logString(err.Error()))
@@ -1356,9 +1595,20 @@ func (w *GovernanceWorker) messageInExchange(msgId int) (bool, error) {
 }
 
 var logString = func(v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("GovernanceWorker", nil, v)
+	}
 	return fmt.Sprintf("GovernanceWorker: %v", v)
 }
 
+// Log lines about a specific agreement carry the agreement and protocol as fields when structured logging is on.
+var logStringA = func(agreementId string, protocol string, v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("GovernanceWorker", cutil.LogFields{cutil.LOG_AGREEMENT_ID: agreementId, cutil.LOG_PROTOCOL: protocol}, v)
+	}
+	return logString(v)
+}
+
 // go through all the protocols and find the agreements with given agreement ids from the db
 func (w *GovernanceWorker) FindEstablishedAgreementsWithIds(agreementIds []string) ([]persistence.EstablishedAgreement, error) {
 
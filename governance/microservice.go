@@ -27,7 +27,9 @@ func (w *GovernanceWorker) governMicroservices() int {
 
 		// check for the new microservice version when time is right
 		time_now := time.Now().Unix()
-		if time_now-w.lastSvcUpgradeCheck >= int64(check_interval) {
+		if exchange.ExchangeDegraded() {
+			glog.V(5).Infof(logString(fmt.Sprintf("exchange is unreachable, skipping the service upgrade check")))
+		} else if time_now-w.lastSvcUpgradeCheck >= int64(check_interval) {
 			w.lastSvcUpgradeCheck = time_now
 
 			// handle microservice upgrade. The upgrade includes inactive upgrades if the associated agreements happen to be 0.
@@ -84,6 +86,12 @@ func (w *GovernanceWorker) StartMicroservice(ms_key string, agreementId string,
 
 		deployment, deploymentSig, torr := msdef.GetDeployment()
 
+		// The legacy docker registry form of the torrent field is still accepted, but it means the same thing as no torrent.
+		if exchange.IsLegacyTorrentField(torr) {
+			glog.Warningf(logString(fmt.Sprintf("service %v %v has a deprecated torrent field %v, it should be removed from the definition in the exchange.", msdef.SpecRef, msdef.Version, torr)))
+			torr = ""
+		}
+
 		// convert the torrent string to a structure
 		var torrent policy.Torrent
 
@@ -106,6 +114,7 @@ func (w *GovernanceWorker) StartMicroservice(ms_key string, agreementId string,
 		ms_workload.Deployment = deployment
 		ms_workload.DeploymentSignature = deploymentSig
 		ms_workload.Torrent = torrent
+		ms_workload.ImageFetch = msdef.GetImageFetch()
 		ms_workload.WorkloadPassword = ""
 		ms_workload.DeploymentUserInfo = ""
 
@@ -115,10 +124,10 @@ func (w *GovernanceWorker) StartMicroservice(ms_key string, agreementId string,
 		} else {
 			// get microservice/service keys and save it to the user keys.
 			if w.Config.Edge.TrustCertUpdatesFromOrg {
-				key_map, err := exchange.GetHTTPObjectSigningKeysHandler(w)(exchange.MICROSERVICE, msdef.SpecRef, msdef.Org, msdef.Version, msdef.Arch)
+				key_map, err := exchange.GetCachedObjectSigningKeysHandler(w, w.db)(exchange.MICROSERVICE, msdef.SpecRef, msdef.Org, msdef.Version, msdef.Arch)
 				if err == nil {
 					// No error means that we are working with a microservice.
-				} else if key_map, err = exchange.GetHTTPObjectSigningKeysHandler(w)(exchange.SERVICE, msdef.SpecRef, msdef.Org, msdef.Version, msdef.Arch); err != nil {
+				} else if key_map, err = exchange.GetCachedObjectSigningKeysHandler(w, w.db)(exchange.SERVICE, msdef.SpecRef, msdef.Org, msdef.Version, msdef.Arch); err != nil {
 					return nil, fmt.Errorf(logString(fmt.Sprintf("received error getting signing keys from the exchange: %v %v %v %v. %v", msdef.SpecRef, msdef.Org, msdef.Version, msdef.Arch, err)))
 				}
 
@@ -184,6 +193,7 @@ func (w *GovernanceWorker) StartMicroservice(ms_key string, agreementId string,
 
 				// Fire an event to the torrent worker so that it will download the container
 				cc := events.NewContainerConfig(*url, ms_workload.Torrent.Signature, ms_workload.Deployment, ms_workload.DeploymentSignature, ms_workload.DeploymentUserInfo, "", img_auths)
+				cc.ImageFetch = ms_workload.ImageFetch
 
 				// convert the user input from the service attributes to env variables
 				if attrs, err := persistence.FindApplicableAttributes(w.db, msdef.SpecRef); err != nil {
@@ -245,6 +255,8 @@ func (w *GovernanceWorker) CleanupMicroservice(spec_ref string, version string,
 				ag_reason_code = w.producerPH[ag.AgreementProtocol].GetTerminationCode(producer.TERM_REASON_MS_DOWNGRADE_REQUIRED)
 			case microservice.MS_IMAGE_FETCH_FAILED:
 				ag_reason_code = w.producerPH[ag.AgreementProtocol].GetTerminationCode(producer.TERM_REASON_IMAGE_FETCH_FAILURE)
+			case microservice.MS_DELETED_FOR_USER_INPUT_CHANGED:
+				ag_reason_code = w.producerPH[ag.AgreementProtocol].GetTerminationCode(producer.TERM_REASON_USER_INPUT_CHANGED)
 			default:
 				ag_reason_code = w.producerPH[ag.AgreementProtocol].GetTerminationCode(producer.TERM_REASON_MICROSERVICE_FAILURE)
 			}
@@ -0,0 +1,231 @@
+package governance
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/policy"
+	"github.com/open-horizon/anax/producer"
+	"time"
+)
+
+// The workload rollback watches the versions of the workloads that the node runs. When the containers of an upgraded
+// version fail MaxFailures times within the rollback window, the agreement of the upgraded version is cancelled and
+// the node rejects proposals for that version. The agbot then falls back to the next lower priority workload of its
+// policy, which is the previous version when the policy lists the versions by priority. The rollback is reported as
+// a WORKLOAD_ROLLBACK event and as an error of the node in the exchange.
+
+// The event code of the node errors that report a rollback.
+const NODE_ERROR_WORKLOAD_ROLLBACK = "WORKLOAD_ROLLBACK"
+
+// Record that a version of a workload started. A version other than the verified version is an upgrade, its failures
+// are counted from now on. Starting a new version ends a rollback, the rolled back version might be retried later.
+// Returns true when a rollback was ended.
+func workloadStarted(versions *persistence.WorkloadVersions, version string, now uint64) (changed bool, rollbackEnded bool) {
+
+	if version == "" || version == versions.RolledBackVersion {
+		return false, false
+	}
+
+	if version == versions.VerifiedVersion {
+		// The agbot went back to the verified version on its own, so the upgrade is over.
+		if versions.UpgradeVersion != "" {
+			versions.UpgradeVersion = ""
+			versions.UpgradeStartTime = 0
+			versions.UpgradeFailures = nil
+			return true, false
+		}
+		return false, false
+	}
+
+	if version != versions.UpgradeVersion {
+		versions.UpgradeVersion = version
+		versions.UpgradeStartTime = now
+		versions.UpgradeFailures = nil
+		changed = true
+	}
+
+	if versions.RolledBackVersion != "" {
+		versions.RolledBackVersion = ""
+		versions.RollbackTime = 0
+		changed = true
+		rollbackEnded = true
+	}
+	return changed, rollbackEnded
+}
+
+// Record that the containers of a version of a workload failed. Only the failures of an upgrade are counted, within
+// the rollback window. Returns true when the upgrade failed often enough to be rolled back, the versions are then
+// changed to reject the upgraded version.
+func workloadFailed(versions *persistence.WorkloadVersions, version string, now uint64, cfg config.WorkloadRollbackConfig) (changed bool, rollback bool) {
+
+	if !versions.Upgrading() || version != versions.UpgradeVersion {
+		return false, false
+	}
+
+	failures := make([]uint64, 0, len(versions.UpgradeFailures)+1)
+	for _, failed := range versions.UpgradeFailures {
+		if failed+uint64(cfg.WindowS) > now {
+			failures = append(failures, failed)
+		}
+	}
+	versions.UpgradeFailures = append(failures, now)
+
+	if len(versions.UpgradeFailures) < cfg.MaxFailures {
+		return true, false
+	}
+
+	versions.RolledBackVersion = versions.UpgradeVersion
+	versions.RollbackTime = now
+	versions.UpgradeVersion = ""
+	versions.UpgradeStartTime = 0
+	versions.UpgradeFailures = nil
+	return true, true
+}
+
+// Record that a version of a workload has been running since executionStart. A version that ran for the rollback
+// window without its agreement ending is verified, an upgrade to a later version can be rolled back to it.
+func workloadRunning(versions *persistence.WorkloadVersions, version string, executionStart uint64, now uint64, windowS int) bool {
+
+	if version == "" || executionStart == 0 || executionStart+uint64(windowS) > now {
+		return false
+	} else if version == versions.VerifiedVersion || version == versions.RolledBackVersion {
+		return false
+	} else if version != versions.UpgradeVersion && versions.VerifiedVersion != "" {
+		return false
+	}
+
+	versions.VerifiedVersion = version
+	versions.UpgradeVersion = ""
+	versions.UpgradeStartTime = 0
+	versions.UpgradeFailures = nil
+	return true
+}
+
+// Called when the workload of an agreement has started.
+func (w *GovernanceWorker) recordWorkloadStarted(ag *persistence.EstablishedAgreement) {
+
+	if !w.Config.Edge.WorkloadRollback.Enabled() || ag.RunningWorkload.URL == "" {
+		return
+	}
+
+	rollbackEnded := false
+	update := func(versions *persistence.WorkloadVersions) bool {
+		changed, ended := workloadStarted(versions, ag.RunningWorkload.Version, uint64(time.Now().Unix()))
+		rollbackEnded = ended
+		return changed
+	}
+
+	if versions, err := persistence.UpdateWorkloadVersions(w.db, ag.RunningWorkload.URL, ag.RunningWorkload.Org, update); err != nil {
+		glog.Errorf(logString(fmt.Sprintf("unable to record the start of workload %v version %v, error: %v", ag.RunningWorkload.URL, ag.RunningWorkload.Version, err)))
+	} else if rollbackEnded {
+		glog.Infof(logString(fmt.Sprintf("workload %v was upgraded to version %v, ending the rollback: %v", ag.RunningWorkload.URL, ag.RunningWorkload.Version, versions)))
+		w.reportNodeErrors()
+	}
+}
+
+// Called when the workload of an agreement failed, before the agreement is cancelled. Returns true when the workload
+// is rolled back, the agreement is then cancelled with the rollback reason.
+func (w *GovernanceWorker) recordWorkloadFailed(ag *persistence.EstablishedAgreement) (bool, *persistence.WorkloadVersions) {
+
+	if !w.Config.Edge.WorkloadRollback.Enabled() || ag.RunningWorkload.URL == "" {
+		return false, nil
+	}
+
+	rollback := false
+	update := func(versions *persistence.WorkloadVersions) bool {
+		changed, rb := workloadFailed(versions, ag.RunningWorkload.Version, uint64(time.Now().Unix()), w.Config.Edge.WorkloadRollback)
+		rollback = rb
+		return changed
+	}
+
+	versions, err := persistence.UpdateWorkloadVersions(w.db, ag.RunningWorkload.URL, ag.RunningWorkload.Org, update)
+	if err != nil {
+		glog.Errorf(logString(fmt.Sprintf("unable to record the failure of workload %v version %v, error: %v", ag.RunningWorkload.URL, ag.RunningWorkload.Version, err)))
+		return false, nil
+	}
+	return rollback, versions
+}
+
+// Cancel the agreement of a workload version that is rolled back, and tell the other workers about the rollback.
+func (w *GovernanceWorker) rollbackWorkload(ag *persistence.EstablishedAgreement, versions *persistence.WorkloadVersions) {
+
+	glog.Warningf(logString(fmt.Sprintf("workload %v version %v failed %v times within %v seconds, rolling back to version %v", versions.WorkloadURL, versions.RolledBackVersion, w.Config.Edge.WorkloadRollback.MaxFailures, w.Config.Edge.WorkloadRollback.WindowS, versions.VerifiedVersion)))
+
+	reason := w.producerPH[ag.AgreementProtocol].GetTerminationCode(producer.TERM_REASON_WL_ROLLBACK)
+	w.cancelAgreement(ag.CurrentAgreementId, ag.AgreementProtocol, reason, w.producerPH[ag.AgreementProtocol].GetTerminationReason(reason))
+
+	w.Messages() <- events.NewWorkloadRollbackMessage(events.WORKLOAD_ROLLBACK, ag.AgreementProtocol, ag.CurrentAgreementId, versions.WorkloadURL, versions.Org, versions.RolledBackVersion, versions.VerifiedVersion)
+}
+
+// Verify the workload versions that have been running for the rollback window.
+func (w *GovernanceWorker) verifyWorkloadVersions() {
+
+	if !w.Config.Edge.WorkloadRollback.Enabled() {
+		return
+	}
+
+	runningFilter := func() persistence.EAFilter {
+		return func(a persistence.EstablishedAgreement) bool {
+			return a.AgreementExecutionStartTime != 0 && a.AgreementTerminatedTime == 0 && a.RunningWorkload.URL != ""
+		}
+	}
+
+	establishedAgreements, err := persistence.FindEstablishedAgreementsAllProtocols(w.db, policy.AllAgreementProtocols(), []persistence.EAFilter{persistence.UnarchivedEAFilter(), runningFilter()})
+	if err != nil {
+		glog.Errorf(logString(fmt.Sprintf("Unable to retrieve running agreements from database, error: %v", err)))
+		return
+	}
+
+	now := uint64(time.Now().Unix())
+	for _, ag := range establishedAgreements {
+		update := func(versions *persistence.WorkloadVersions) bool {
+			return workloadRunning(versions, ag.RunningWorkload.Version, ag.AgreementExecutionStartTime, now, w.Config.Edge.WorkloadRollback.WindowS)
+		}
+		if _, err := persistence.UpdateWorkloadVersions(w.db, ag.RunningWorkload.URL, ag.RunningWorkload.Org, update); err != nil {
+			glog.Errorf(logString(fmt.Sprintf("unable to verify workload %v version %v, error: %v", ag.RunningWorkload.URL, ag.RunningWorkload.Version, err)))
+		}
+	}
+}
+
+// Returns the node errors that report the workloads which are rolled back.
+func rollbackNodeErrors(all []persistence.WorkloadVersions) []exchange.NodeError {
+	nodeErrors := make([]exchange.NodeError, 0)
+	for _, versions := range all {
+		if versions.RolledBackVersion == "" {
+			continue
+		}
+		nodeErrors = append(nodeErrors, exchange.NodeError{
+			RecordId:  fmt.Sprintf("%v/%v/rollback", versions.Org, versions.WorkloadURL),
+			Message:   fmt.Sprintf("version %v of workload %v kept failing and was rolled back to version %v at %v", versions.RolledBackVersion, versions.WorkloadURL, versions.VerifiedVersion, time.Unix(int64(versions.RollbackTime), 0).UTC().Format(time.RFC3339)),
+			EventCode: NODE_ERROR_WORKLOAD_ROLLBACK,
+		})
+	}
+	return nodeErrors
+}
+
+// Report the workloads which are rolled back as errors of the node in the exchange. The errors of workloads that are
+// no longer rolled back are removed.
+func (w *GovernanceWorker) reportNodeErrors() {
+
+	if !w.Config.Edge.WorkloadRollback.Enabled() {
+		return
+	} else if exchange.ExchangeDegraded() {
+		glog.V(3).Infof(logString("exchange is unreachable, the node errors are reported when it is back."))
+		return
+	}
+
+	all, err := persistence.FindAllWorkloadVersions(w.db)
+	if err != nil {
+		glog.Errorf(logString(fmt.Sprintf("unable to read the workload versions from the database, error: %v", err)))
+		return
+	}
+
+	httpClient := w.Config.Collaborators.HTTPClientFactory.NewHTTPClient(nil)
+	if err := exchange.PutNodeErrors(httpClient, w.GetExchangeId(), w.Config.Edge.ExchangeURL, w.GetExchangeId(), w.GetExchangeToken(), rollbackNodeErrors(all)); err != nil {
+		glog.Errorf(logString(fmt.Sprintf("unable to report the node errors to the exchange, error: %v", err)))
+	}
+}
@@ -0,0 +1,74 @@
+// +build unit
+
+package governance
+
+import (
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/persistence"
+	"testing"
+)
+
+// An upgrade that fails MaxFailures times within the window is rolled back, and a later version ends the rollback.
+func Test_workload_rollback(t *testing.T) {
+
+	cfg := config.WorkloadRollbackConfig{MaxFailures: 3, WindowS: 600}
+	v := &persistence.WorkloadVersions{WorkloadURL: "http://mydomain.com/workload", Org: "myorg"}
+
+	// The first version runs for the window and is verified.
+	if changed, _ := workloadStarted(v, "1.0.0", 100); !changed || v.UpgradeVersion != "1.0.0" {
+		t.Errorf("The first version should be recorded, was %v", v)
+	} else if _, rollback := workloadFailed(v, "1.0.0", 200, cfg); rollback || len(v.UpgradeFailures) != 0 {
+		t.Errorf("Failures of a version that cannot be rolled back should not be counted, was %v", v)
+	} else if workloadRunning(v, "1.0.0", 100, 500, cfg.WindowS) {
+		t.Errorf("A version should not be verified before the window ends, was %v", v)
+	} else if !workloadRunning(v, "1.0.0", 100, 700, cfg.WindowS) || v.VerifiedVersion != "1.0.0" || v.UpgradeVersion != "" {
+		t.Errorf("The version should be verified, was %v", v)
+	}
+
+	// The upgrade fails, the failures outside the window are forgotten.
+	if changed, _ := workloadStarted(v, "2.0.0", 1000); !changed || !v.Upgrading() {
+		t.Errorf("The upgrade should be recorded, was %v", v)
+	} else if _, rollback := workloadFailed(v, "2.0.0", 1000, cfg); rollback {
+		t.Errorf("One failure should not roll back, was %v", v)
+	} else if _, rollback := workloadFailed(v, "2.0.0", 1700, cfg); rollback || len(v.UpgradeFailures) != 1 {
+		t.Errorf("The failure outside the window should be forgotten, was %v", v)
+	} else if changed, _ := workloadStarted(v, "2.0.0", 1710); changed {
+		t.Errorf("Restarting the upgrade should keep its failures, was %v", v)
+	} else if _, rollback := workloadFailed(v, "1.0.0", 1720, cfg); rollback || len(v.UpgradeFailures) != 1 {
+		t.Errorf("Failures of the verified version should not be counted, was %v", v)
+	} else if _, rollback := workloadFailed(v, "2.0.0", 1800, cfg); rollback {
+		t.Errorf("Two failures should not roll back, was %v", v)
+	} else if _, rollback := workloadFailed(v, "2.0.0", 1900, cfg); !rollback {
+		t.Errorf("Three failures should roll back, was %v", v)
+	} else if v.RolledBackVersion != "2.0.0" || v.RollbackTime != 1900 || v.VerifiedVersion != "1.0.0" || v.Upgrading() {
+		t.Errorf("The upgrade should be rolled back to 1.0.0, was %v", v)
+	}
+
+	// The rolled back version is not an upgrade again, a new version is.
+	if changed, _ := workloadStarted(v, "1.0.0", 2000); changed {
+		t.Errorf("Starting the verified version should not change the versions, was %v", v)
+	} else if changed, _ := workloadStarted(v, "2.0.0", 2000); changed {
+		t.Errorf("Starting the rolled back version should not change the versions, was %v", v)
+	} else if workloadRunning(v, "2.0.0", 2000, 3000, cfg.WindowS) {
+		t.Errorf("The rolled back version should not be verified, was %v", v)
+	} else if _, ended := workloadStarted(v, "2.0.1", 3000); !ended || v.RolledBackVersion != "" || v.UpgradeVersion != "2.0.1" {
+		t.Errorf("A new version should end the rollback, was %v", v)
+	}
+}
+
+// Only the workloads which are rolled back are reported as node errors.
+func Test_rollbackNodeErrors(t *testing.T) {
+
+	all := []persistence.WorkloadVersions{
+		{WorkloadURL: "http://mydomain.com/workload1", Org: "myorg", VerifiedVersion: "1.0.0", RolledBackVersion: "2.0.0", RollbackTime: 1900},
+		{WorkloadURL: "http://mydomain.com/workload2", Org: "myorg", VerifiedVersion: "1.0.0"},
+	}
+
+	if errs := rollbackNodeErrors(all); len(errs) != 1 {
+		t.Errorf("There should be one node error, was %v", errs)
+	} else if errs[0].EventCode != NODE_ERROR_WORKLOAD_ROLLBACK || errs[0].RecordId != "myorg/http://mydomain.com/workload1/rollback" {
+		t.Errorf("The node error should report the rollback of workload1, was %v", errs[0])
+	} else if errs := rollbackNodeErrors(all[1:]); len(errs) != 0 {
+		t.Errorf("There should be no node errors, was %v", errs)
+	}
+}
@@ -4,13 +4,13 @@ import (
 	"errors"
 	"fmt"
 	"github.com/golang/glog"
+	"github.com/open-horizon/anax/anaxerrors"
 	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/exchange"
 	"github.com/open-horizon/anax/persistence"
 	"github.com/open-horizon/anax/policy"
 	"github.com/open-horizon/anax/producer"
 	"runtime"
-	"strings"
 	"time"
 )
 
@@ -21,9 +21,20 @@ import (
 //
 // There are other workers responsible for other functions, which will also so some cleanup when the Node Shutdown Message
 // arrives. For example, the node heartbeat function is stopped by the Agreement worker.
+//
+// When the node is drained, the agreements are cancelled because the node is decommissioned, and the node waits for the
+// workloads to stop and for the agreements to be removed from the exchange before it removes any local state. If that
+// takes longer than the drain timeout, the shutdown ends without removing the local state.
 func (w *GovernanceWorker) nodeShutdown(cmd *NodeShutdownCommand) {
 	glog.V(3).Infof(logString(fmt.Sprintf("begin node shutdown process.")))
 
+	drain := newNodeDrain(cmd.Msg.DrainTimeout())
+	reason := producer.TERM_REASON_NODE_SHUTDOWN
+	if drain != nil {
+		glog.V(3).Infof(logString(fmt.Sprintf("draining the node, timeout %v seconds.", cmd.Msg.DrainTimeout())))
+		reason = producer.TERM_REASON_NODE_DECOMMISSIONED
+	}
+
 	// Get the node's registration info from the local DB.
 	dev, err := persistence.FindExchangeDevice(w.db)
 	if err != nil {
@@ -43,11 +54,23 @@ func (w *GovernanceWorker) nodeShutdown(cmd *NodeShutdownCommand) {
 	}
 
 	// Cancel all agreements, all workload containers and networks will automatically terminate.
-	if err := w.terminateAllAgreements(); err != nil {
-		w.completedWithError(logString(err.Error()))
+	if err := w.terminateAllAgreements(reason, drain); err != nil {
+		w.shutdownFailed(err)
 		return
 	}
 
+	// A drained node also waits for its service containers to stop and for its agreements to be removed from its exchange
+	// resource, before it removes any local state.
+	if drain != nil {
+		if err := w.terminateMicroservices(drain); err != nil {
+			w.shutdownFailed(err)
+			return
+		} else if err := w.waitForExchangeAgreements(drain); err != nil {
+			w.shutdownFailed(err)
+			return
+		}
+	}
+
 	// Remove the node’s messaging public key from the node’s exchange resource and delete the node’s message key pair from the filesystem.
 	if err := w.patchNodeKey(); err != nil {
 		w.completedWithError(logString(err.Error()))
@@ -59,7 +82,7 @@ func (w *GovernanceWorker) nodeShutdown(cmd *NodeShutdownCommand) {
 	w.Messages() <- events.NewAllBlockchainShutdownMessage(events.ALL_STOP)
 
 	// Tell running microservices to terminate.
-	if err := w.terminateMicroservices(); err != nil {
+	if err := w.terminateMicroservices(nil); err != nil {
 		w.completedWithError(logString(err.Error()))
 		return
 	}
@@ -82,6 +105,12 @@ func (w *GovernanceWorker) nodeShutdown(cmd *NodeShutdownCommand) {
 		return
 	}
 
+	// Remove the cached exchange metadata.
+	if err := exchange.DeleteMetadataCache(w.db); err != nil {
+		w.completedWithError(logString(fmt.Sprintf("unable to delete exchange metadata cache, error: %v", err)))
+		return
+	}
+
 	// Remove the node's exchange resource.
 	if cmd.Msg.RemoveNode() {
 		if err := w.deleteNode(); err != nil {
@@ -108,7 +137,7 @@ func (w *GovernanceWorker) clearNodePatternAndMS() error {
 
 	// If the node entry has already been removed form the exchange, skip this step.
 	exDev, err := exchange.GetExchangeDevice(w.Config.Collaborators.HTTPClientFactory, w.GetExchangeId(), w.GetExchangeToken(), w.GetExchangeURL())
-	if err != nil && strings.Contains(err.Error(), "status: 401") {
+	if err != nil && anaxerrors.Is(err, anaxerrors.EXCHANGE_AUTH) {
 		return nil
 	} else if err != nil {
 		return errors.New(fmt.Sprintf("error reading node from exchange: %v", err))
@@ -147,7 +176,7 @@ func (w *GovernanceWorker) clearNodePatternAndMS() error {
 }
 
 // Terminate all active agreements and wait until they are all archived.
-func (w *GovernanceWorker) terminateAllAgreements() error {
+func (w *GovernanceWorker) terminateAllAgreements(reason string, drain *nodeDrain) error {
 	// Create a new filter for active, unterminated agreements
 	notYetFinalFilter := func() persistence.EAFilter {
 		return func(a persistence.EstablishedAgreement) bool {
@@ -165,7 +194,7 @@ func (w *GovernanceWorker) terminateAllAgreements() error {
 
 		glog.V(3).Infof(logString(fmt.Sprintf("ending agreement: %v", ag.CurrentAgreementId)))
 		pph := w.producerPH[ag.AgreementProtocol]
-		reasonCode := pph.GetTerminationCode(producer.TERM_REASON_NODE_SHUTDOWN)
+		reasonCode := pph.GetTerminationCode(reason)
 		w.cancelAgreement(ag.CurrentAgreementId, ag.AgreementProtocol, reasonCode, pph.GetTerminationReason(reasonCode))
 
 		// send the event to the container worker in case it has started workload containers.
@@ -183,7 +212,9 @@ func (w *GovernanceWorker) terminateAllAgreements() error {
 			return errors.New(fmt.Sprintf("unable to retrieve agreements from database, error: %v", err))
 		} else if len(remainingAgreements) != 0 {
 			glog.V(3).Infof(logString(fmt.Sprintf("waiting for agreements to terminate, have %v", len(remainingAgreements))))
-			time.Sleep(15 * time.Second)
+			if err := drain.wait(15*time.Second, fmt.Sprintf("%v agreements did not terminate", len(remainingAgreements))); err != nil {
+				return err
+			}
 		} else {
 			glog.V(3).Infof(logString(fmt.Sprintf("all agreements terminated")))
 			break
@@ -194,7 +225,7 @@ func (w *GovernanceWorker) terminateAllAgreements() error {
 
 // Terminate any remaining service/microservice containers. All ms(es) associated with an agreement should be gone. The
 // remaining containers are the shared singleton containers.
-func (w *GovernanceWorker) terminateMicroservices() error {
+func (w *GovernanceWorker) terminateMicroservices(drain *nodeDrain) error {
 	// Get all unarchived service/microservice instances and ask them to terminate. Services/Microservices that have containers will be
 	// cleaned up asynchronously so we have to wait to make sure they are all gone.
 	ms_instances, err := persistence.FindMicroserviceInstances(w.db, []persistence.MIFilter{persistence.NotCleanedUpMIFilter(), persistence.UnarchivedMIFilter()})
@@ -217,7 +248,9 @@ func (w *GovernanceWorker) terminateMicroservices() error {
 			return errors.New(fmt.Sprintf("unable to retrieve service instances from database, error: %v", err))
 		} else if remainingInstances != nil && len(remainingInstances) != 0 {
 			glog.V(3).Infof(logString(fmt.Sprintf("waiting for services to terminate, have %v, %v", len(remainingInstances), remainingInstances)))
-			time.Sleep(15 * time.Second)
+			if err := drain.wait(15*time.Second, fmt.Sprintf("%v services did not terminate", len(remainingInstances))); err != nil {
+				return err
+			}
 		} else {
 			glog.V(3).Infof(logString(fmt.Sprintf("service instance termination complete")))
 			break
@@ -239,6 +272,35 @@ func (w *GovernanceWorker) terminateMicroservices() error {
 	return nil
 }
 
+// Wait until the node's exchange resource has no agreements. The node removes its agreements from the exchange when it
+// cancels them, this makes sure they are gone before the node forgets about them. If the node is already gone from the
+// exchange, there is nothing to wait for.
+func (w *GovernanceWorker) waitForExchangeAgreements(drain *nodeDrain) error {
+
+	targetURL := w.GetExchangeURL() + "orgs/" + exchange.GetOrg(w.GetExchangeId()) + "/nodes/" + exchange.GetId(w.GetExchangeId()) + "/agreements"
+
+	for {
+		var resp interface{}
+		resp = new(exchange.AllDeviceAgreementsResponse)
+		if err, tpErr := exchange.InvokeExchange(w.Config.Collaborators.HTTPClientFactory.NewHTTPClient(nil), "GET", targetURL, w.GetExchangeId(), w.GetExchangeToken(), nil, &resp); err != nil && (anaxerrors.Is(err, anaxerrors.EXCHANGE_AUTH) || anaxerrors.Is(err, anaxerrors.EXCHANGE_NOT_FOUND)) {
+			return nil
+		} else if err != nil {
+			return errors.New(fmt.Sprintf("error reading node agreements from exchange: %v", err))
+		} else if tpErr != nil {
+			glog.Warningf(tpErr.Error())
+		} else if agreements := resp.(*exchange.AllDeviceAgreementsResponse).Agreements; len(agreements) != 0 {
+			glog.V(3).Infof(logString(fmt.Sprintf("waiting for %v agreements to be removed from the exchange", len(agreements))))
+		} else {
+			glog.V(3).Infof(logString(fmt.Sprintf("all agreements removed from the exchange")))
+			return nil
+		}
+
+		if err := drain.wait(10*time.Second, "the agreements were not removed from the exchange"); err != nil {
+			return err
+		}
+	}
+}
+
 // Remove the messaging key so that no one tries to communicate with the node. If the node is already gone from the exchange, ignore the error.
 func (w *GovernanceWorker) patchNodeKey() error {
 
@@ -253,7 +315,7 @@ func (w *GovernanceWorker) patchNodeKey() error {
 
 	for {
 		if err, tpErr := exchange.InvokeExchange(w.Config.Collaborators.HTTPClientFactory.NewHTTPClient(nil), "PATCH", targetURL, w.GetExchangeId(), w.GetExchangeToken(), pdr, &resp); err != nil {
-			if strings.Contains(err.Error(), "status: 401") {
+			if anaxerrors.Is(err, anaxerrors.EXCHANGE_AUTH) {
 				break
 			} else {
 				return err
@@ -370,3 +432,53 @@ func (w *GovernanceWorker) completedWithError(e string) {
 	}
 	w.Messages() <- events.NewNodeShutdownCompleteMessage(events.UNCONFIGURE_COMPLETE, e)
 }
+
+// End the shutdown because of an error. When the node did not drain in time, the shutdown complete message says so,
+// the local state of the node has not been removed yet.
+func (w *GovernanceWorker) shutdownFailed(err error) {
+	if _, ok := err.(*drainTimeoutError); ok {
+		glog.Errorf(logString(fmt.Sprintf("node shutdown terminating, %v", err)))
+		w.Messages() <- events.NewNodeDrainTimeoutMessage(events.UNCONFIGURE_COMPLETE, logString(err.Error()))
+	} else {
+		w.completedWithError(logString(err.Error()))
+	}
+}
+
+// The node did not drain before the drain timeout, pending is what did not happen in time.
+type drainTimeoutError struct {
+	pending string
+}
+
+func (e *drainTimeoutError) Error() string {
+	return fmt.Sprintf("node drain timed out, %v", e.pending)
+}
+
+// The deadline of a node drain. The waits of a node shutdown that is not drained never time out, so the functions
+// accept a nil drain.
+type nodeDrain struct {
+	deadline time.Time
+}
+
+func newNodeDrain(timeoutS int) *nodeDrain {
+	if timeoutS <= 0 {
+		return nil
+	}
+	return &nodeDrain{deadline: time.Now().Add(time.Duration(timeoutS) * time.Second)}
+}
+
+// Sleep before checking again whether the node has drained, but not past the deadline. Returns an error saying what
+// did not happen in time once the deadline has passed.
+func (d *nodeDrain) wait(interval time.Duration, pending string) error {
+	if d == nil {
+		time.Sleep(interval)
+		return nil
+	}
+	remaining := d.deadline.Sub(time.Now())
+	if remaining <= 0 {
+		return &drainTimeoutError{pending: pending}
+	} else if remaining < interval {
+		interval = remaining
+	}
+	time.Sleep(interval)
+	return nil
+}
@@ -0,0 +1,194 @@
+package governance
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/abstractprotocol"
+	"github.com/open-horizon/anax/basicprotocol"
+	"github.com/open-horizon/anax/citizenscientist"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/policy"
+	"github.com/open-horizon/anax/version"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"runtime"
+	"time"
+)
+
+// The statistics reporter sends anonymous operational statistics about the node to the collector that the node opted
+// in to, see config.StatisticsConfig. Only the fields of NodeStatistics are sent. They are counts, flags and values
+// from fixed lists, never the ids, names, URLs, addresses or error text of the node, its agreements or its workloads.
+// The strings are checked again before they are sent, a value that does not look like an identifier is replaced.
+
+const STATISTICS_REDACTED = "redacted"
+const STATISTICS_UNKNOWN_REASON = "unknown"
+
+type AgreementStatistics struct {
+	Active     int `json:"active"`     // the agreements that are not terminated
+	Running    int `json:"running"`    // the active agreements whose workload is running
+	Terminated int `json:"terminated"` // the agreements terminated since the last report
+}
+
+type NodeStatistics struct {
+	ReporterId       string              `json:"reporter_id"` // an id that can not be traced back to the node, it changes when the node registers again
+	Time             int64               `json:"time"`
+	IntervalS        int                 `json:"interval_s"`
+	Version          string              `json:"version"`
+	Arch             string              `json:"arch"`
+	OS               string              `json:"os"`
+	MemoryProfile    string              `json:"memory_profile"`
+	UsesPattern      bool                `json:"uses_pattern"`
+	ExchangeDegraded bool                `json:"exchange_degraded"`
+	DiskSpaceLow     bool                `json:"disk_space_low"`
+	Agreements       AgreementStatistics `json:"agreements"`
+	Terminations     map[string]int      `json:"terminations"` // the agreements terminated since the last report, by the name of the reason
+}
+
+func (s NodeStatistics) String() string {
+	return fmt.Sprintf("ReporterId: %v, Time: %v, Version: %v, Arch: %v, OS: %v, MemoryProfile: %v, UsesPattern: %v, ExchangeDegraded: %v, DiskSpaceLow: %v, Agreements: %v, Terminations: %v",
+		s.ReporterId, s.Time, s.Version, s.Arch, s.OS, s.MemoryProfile, s.UsesPattern, s.ExchangeDegraded, s.DiskSpaceLow, s.Agreements, s.Terminations)
+}
+
+// The shape of the values that can be sent, anything else is replaced.
+var statisticsValueRE = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+-]{0,63}$`)
+
+func redactStatisticsValue(value string) string {
+	if !statisticsValueRE.MatchString(value) {
+		return STATISTICS_REDACTED
+	}
+	return value
+}
+
+// Apply the redaction rules to the strings of the statistics.
+func redactStatistics(s *NodeStatistics) {
+	s.Version = redactStatisticsValue(s.Version)
+	s.Arch = redactStatisticsValue(s.Arch)
+	s.OS = redactStatisticsValue(s.OS)
+	s.MemoryProfile = redactStatisticsValue(s.MemoryProfile)
+	terminations := make(map[string]int)
+	for reason, count := range s.Terminations {
+		terminations[redactStatisticsValue(reason)] += count
+	}
+	s.Terminations = terminations
+}
+
+// The reporter id is a keyed hash of the node id. The key is the node's token, so the collector, and anyone else
+// without the token, can not tell which node reported.
+func statisticsReporterId(nodeId string, token string) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(nodeId))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// The name of the reason an agreement was terminated with. The names are fixed identifiers, unlike the description
+// that is recorded with the agreement.
+func terminationReasonName(protocol string, code uint64) string {
+	registry := basicprotocol.Reasons
+	if protocol == policy.CitizenScientist {
+		registry = citizenscientist.Reasons
+	}
+	if name := registry.Name(code); name != "" {
+		if code >= abstractprotocol.CONSUMER_REASON_MIN && code <= abstractprotocol.CONSUMER_REASON_MAX {
+			return "Agbot" + name
+		}
+		return name
+	}
+	return STATISTICS_UNKNOWN_REASON
+}
+
+// Count the agreements, and the agreements terminated after the given time by reason.
+func countAgreements(agreements []persistence.EstablishedAgreement, since uint64) (AgreementStatistics, map[string]int) {
+	counts := AgreementStatistics{}
+	terminations := make(map[string]int)
+	for _, ag := range agreements {
+		if ag.AgreementTerminatedTime == 0 && !ag.Archived {
+			counts.Active += 1
+			if ag.AgreementExecutionStartTime != 0 {
+				counts.Running += 1
+			}
+		} else if ag.AgreementTerminatedTime > since {
+			counts.Terminated += 1
+			terminations[terminationReasonName(ag.AgreementProtocol, ag.TerminatedReason)] += 1
+		}
+	}
+	return counts, terminations
+}
+
+// Collect the statistics of the node since the given time.
+func (w *GovernanceWorker) collectStatistics(since uint64) (*NodeStatistics, error) {
+
+	agreements, err := persistence.FindEstablishedAgreementsAllProtocols(w.db, policy.AllAgreementProtocols(), []persistence.EAFilter{})
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to read the agreements, error: %v", err))
+	}
+
+	stats := &NodeStatistics{
+		ReporterId:       statisticsReporterId(w.GetExchangeId(), w.GetExchangeToken()),
+		Time:             time.Now().Unix(),
+		IntervalS:        w.Config.Edge.Statistics.IntervalS,
+		Version:          version.HORIZON_VERSION,
+		Arch:             runtime.GOARCH,
+		OS:               runtime.GOOS,
+		MemoryProfile:    w.Config.Edge.MemoryProfile,
+		UsesPattern:      w.devicePattern != "",
+		ExchangeDegraded: exchange.ExchangeDegraded(),
+		DiskSpaceLow:     cutil.DiskSpaceLow(),
+	}
+	stats.Agreements, stats.Terminations = countAgreements(agreements, since)
+	redactStatistics(stats)
+	return stats, nil
+}
+
+// POST the statistics to the collector.
+func sendStatistics(httpClient *http.Client, collectorURL string, stats *NodeStatistics) error {
+
+	body, err := json.Marshal(stats)
+	if err != nil {
+		return errors.New(fmt.Sprintf("unable to marshal statistics, error: %v", err))
+	}
+
+	req, err := http.NewRequest("POST", collectorURL, bytes.NewReader(body))
+	if err != nil {
+		return errors.New(fmt.Sprintf("unable to create the request to %v, error: %v", collectorURL, err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return errors.New(fmt.Sprintf("unable to send statistics to %v, error: %v", collectorURL, err))
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New(fmt.Sprintf("statistics collector %v returned HTTP status %v", collectorURL, resp.StatusCode))
+	}
+	return nil
+}
+
+// Send the statistics of the node to the collector. A report that fails is not retried, the terminations it
+// counted are counted in the next report.
+func (w *GovernanceWorker) reportStatistics() int {
+
+	now := uint64(time.Now().Unix())
+	if stats, err := w.collectStatistics(w.lastStatisticsReport); err != nil {
+		glog.Errorf(logString(fmt.Sprintf("unable to collect statistics, error: %v", err)))
+		w.RecordSubworkerError(STATISTICS_REPORTER, err)
+	} else if err := sendStatistics(w.Config.Collaborators.HTTPClientFactory.NewHTTPClient(nil), w.Config.Edge.Statistics.CollectorURL, stats); err != nil {
+		glog.Warningf(logString(err.Error()))
+		w.RecordSubworkerError(STATISTICS_REPORTER, err)
+	} else {
+		glog.V(3).Infof(logString(fmt.Sprintf("sent statistics %v", stats)))
+		w.lastStatisticsReport = now
+	}
+	return 0
+}
@@ -0,0 +1,84 @@
+// +build unit
+
+package governance
+
+import (
+	"encoding/json"
+	"github.com/open-horizon/anax/abstractprotocol"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/policy"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_redactStatistics(t *testing.T) {
+
+	stats := &NodeStatistics{
+		Version:       "2.17.1-rc.3",
+		Arch:          "arm64",
+		OS:            "linux",
+		MemoryProfile: "low",
+		Terminations:  map[string]int{"ImageFetchFailure": 2, "https://registry.example.com/x": 1, "user@example.com": 1},
+	}
+	redactStatistics(stats)
+
+	assert.Equal(t, "2.17.1-rc.3", stats.Version)
+	assert.Equal(t, "arm64", stats.Arch)
+	assert.Equal(t, map[string]int{"ImageFetchFailure": 2, STATISTICS_REDACTED: 2}, stats.Terminations)
+
+	stats.Version = "local build"
+	redactStatistics(stats)
+	assert.Equal(t, STATISTICS_REDACTED, stats.Version, "a value with a space is not an identifier")
+}
+
+func Test_statisticsReporterId(t *testing.T) {
+
+	id := statisticsReporterId("myorg/node1", "token1")
+	assert.Equal(t, id, statisticsReporterId("myorg/node1", "token1"), "the id should be stable while the node is registered")
+	assert.NotEqual(t, id, statisticsReporterId("myorg/node1", "token2"), "the id should change when the node registers again")
+	assert.False(t, strings.Contains(id, "node1"))
+	assert.Equal(t, 32, len(id))
+}
+
+func Test_countAgreements(t *testing.T) {
+
+	agreements := []persistence.EstablishedAgreement{
+		{AgreementProtocol: policy.BasicProtocol, AgreementExecutionStartTime: 10},
+		{AgreementProtocol: policy.BasicProtocol},
+		{AgreementProtocol: policy.BasicProtocol, Archived: true, AgreementTerminatedTime: 50, TerminatedReason: abstractprotocol.CANCEL_IMAGE_FETCH_FAILURE},
+		{AgreementProtocol: policy.CitizenScientist, Archived: true, AgreementTerminatedTime: 60, TerminatedReason: abstractprotocol.AB_CANCEL_NO_DATA_RECEIVED},
+		{AgreementProtocol: policy.BasicProtocol, Archived: true, AgreementTerminatedTime: 70, TerminatedReason: 998},
+		{AgreementProtocol: policy.BasicProtocol, Archived: true, AgreementTerminatedTime: 20, TerminatedReason: abstractprotocol.CANCEL_USER_REQUESTED},
+	}
+
+	counts, terminations := countAgreements(agreements, 30)
+	assert.Equal(t, AgreementStatistics{Active: 2, Running: 1, Terminated: 3}, counts)
+	assert.Equal(t, map[string]int{"ImageFetchFailure": 1, "AgbotNoData": 1, STATISTICS_UNKNOWN_REASON: 1}, terminations, "the terminations before the last report are not counted")
+}
+
+func Test_sendStatistics(t *testing.T) {
+
+	var received NodeStatistics
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &received)
+		if received.Arch == "fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	stats := &NodeStatistics{ReporterId: "abc", Arch: "amd64", Terminations: map[string]int{"NodeShutdown": 1}}
+	assert.Nil(t, sendStatistics(http.DefaultClient, server.URL, stats))
+	assert.Equal(t, "abc", received.ReporterId)
+	assert.Equal(t, 1, received.Terminations["NodeShutdown"])
+
+	stats.Arch = "fail"
+	assert.NotNil(t, sendStatistics(http.DefaultClient, server.URL, stats), "a collector error should be returned")
+}
@@ -97,6 +97,9 @@ func (w *GovernanceWorker) ReportDeviceStatus() {
 	if !w.Config.Edge.ReportDeviceStatus {
 		glog.Info("ReportDeviceStatus is false. The status report to the exchange is turned off.")
 		return
+	} else if exchange.ExchangeDegraded() {
+		glog.V(3).Infof(logString("exchange is unreachable, the status report is skipped."))
+		return
 	}
 
 	glog.Info("started the status report to the exchange.")
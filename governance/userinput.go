@@ -0,0 +1,87 @@
+package governance
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/microservice"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/policy"
+	"github.com/open-horizon/anax/producer"
+)
+
+// The user input variables of a service are passed to its containers in their environment when the containers are
+// started, so the containers that use the service's user input are started again when it changes. The agreements that
+// run the service are ended, the agbots make new agreements with the node and the workload containers are started
+// with the new values. The instances of the service that other workloads depend on are cleaned up, which ends the
+// agreements that use them, and the agreement-less instances are started again right away. The containers of the
+// other services keep running and the node stays registered.
+
+// Returns the agreements that run the service as their workload and were accepted, their containers were started, or
+// are being started, with the old user input.
+func userInputAgreements(ags []persistence.EstablishedAgreement, serviceURL string) []persistence.EstablishedAgreement {
+	affected := make([]persistence.EstablishedAgreement, 0)
+	for _, ag := range ags {
+		if ag.RunningWorkload.URL == serviceURL && ag.AgreementAcceptedTime != 0 && ag.AgreementTerminatedTime == 0 {
+			affected = append(affected, ag)
+		}
+	}
+	return affected
+}
+
+// Returns the instances of the service that are not already being cleaned up.
+func userInputInstances(instances []persistence.MicroserviceInstance, serviceURL string) []persistence.MicroserviceInstance {
+	affected := make([]persistence.MicroserviceInstance, 0)
+	for _, msi := range instances {
+		if msi.SpecRef == serviceURL && msi.CleanupStartTime == 0 {
+			affected = append(affected, msi)
+		}
+	}
+	return affected
+}
+
+// Start the containers that use the user input of the service again.
+func (w *GovernanceWorker) restartForUserInput(serviceURL string) {
+
+	glog.V(3).Infof(logString(fmt.Sprintf("user input of service %v changed, restarting the containers that use it", serviceURL)))
+
+	// End the agreements that run the service, the cleanup removes their containers.
+	if ags, err := persistence.FindEstablishedAgreementsAllProtocols(w.db, policy.AllAgreementProtocols(), []persistence.EAFilter{persistence.UnarchivedEAFilter()}); err != nil {
+		glog.Errorf(logString(fmt.Sprintf("unable to retrieve agreements from database, error: %v", err)))
+	} else {
+		for _, ag := range userInputAgreements(ags, serviceURL) {
+			glog.V(3).Infof(logString(fmt.Sprintf("ending agreement %v, the user input of its workload %v changed", ag.CurrentAgreementId, serviceURL)))
+			reason := w.producerPH[ag.AgreementProtocol].GetTerminationCode(producer.TERM_REASON_USER_INPUT_CHANGED)
+			w.Commands <- w.NewCleanupExecutionCommand(ag.AgreementProtocol, ag.CurrentAgreementId, reason, ag.CurrentDeployment)
+		}
+	}
+
+	// Clean up the dependent service instances of the service, and start the agreement-less ones again.
+	instances, err := persistence.FindMicroserviceInstances(w.db, []persistence.MIFilter{persistence.UnarchivedMIFilter()})
+	if err != nil {
+		glog.Errorf(logString(fmt.Sprintf("error retrieving all service instances from database, error: %v", err)))
+		return
+	}
+
+	restart := false
+	for _, msi := range userInputInstances(instances, serviceURL) {
+		glog.V(3).Infof(logString(fmt.Sprintf("cleaning up service instance %v, its user input changed", msi.GetKey())))
+		if err := w.CleanupMicroservice(msi.SpecRef, msi.Version, msi.GetKey(), microservice.MS_DELETED_FOR_USER_INPUT_CHANGED); err != nil {
+			glog.Errorf(logString(fmt.Sprintf("unable to clean up service instance %v, error: %v", msi.GetKey(), err)))
+		} else if msi.AgreementLess {
+			restart = true
+		}
+	}
+
+	if restart {
+		if msdefs, err := persistence.FindUnarchivedMicroserviceDefs(w.db, serviceURL); err != nil {
+			glog.Errorf(logString(fmt.Sprintf("unable to restart agreement-less service %v, error %v", serviceURL, err)))
+		} else if len(msdefs) == 0 {
+			glog.Errorf(logString(fmt.Sprintf("unable to restart agreement-less service %v, local service definition not found", serviceURL)))
+		} else {
+			instancePath := []persistence.ServiceInstancePathElement{*persistence.NewServiceInstancePathElement(msdefs[0].SpecRef, msdefs[0].Version)}
+			if err := w.startDependentService(instancePath, &msdefs[0], "", policy.BasicProtocol); err != nil {
+				glog.Errorf(logString(fmt.Sprintf("unable to restart agreement-less service %v, error %v", serviceURL, err)))
+			}
+		}
+	}
+}
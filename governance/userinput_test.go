@@ -0,0 +1,34 @@
+// +build unit
+
+package governance
+
+import (
+	"github.com/open-horizon/anax/persistence"
+	"testing"
+)
+
+// Only the containers that were started with the user input of the service are restarted.
+func Test_userInputAgreements(t *testing.T) {
+
+	surl := "http://mydomain.com/service"
+	ags := []persistence.EstablishedAgreement{
+		{CurrentAgreementId: "ag1", AgreementAcceptedTime: 10, RunningWorkload: persistence.WorkloadInfo{URL: surl}},
+		{CurrentAgreementId: "ag2", AgreementAcceptedTime: 10, RunningWorkload: persistence.WorkloadInfo{URL: "http://mydomain.com/other"}},
+		{CurrentAgreementId: "ag3", RunningWorkload: persistence.WorkloadInfo{URL: surl}},
+		{CurrentAgreementId: "ag4", AgreementAcceptedTime: 10, AgreementTerminatedTime: 20, RunningWorkload: persistence.WorkloadInfo{URL: surl}},
+	}
+
+	if affected := userInputAgreements(ags, surl); len(affected) != 1 || affected[0].CurrentAgreementId != "ag1" {
+		t.Errorf("only ag1 should be restarted, are %v", affected)
+	}
+
+	instances := []persistence.MicroserviceInstance{
+		{SpecRef: surl, Version: "1.0.0", InstanceId: "i1"},
+		{SpecRef: surl, Version: "1.0.0", InstanceId: "i2", CleanupStartTime: 30},
+		{SpecRef: "http://mydomain.com/other", Version: "1.0.0", InstanceId: "i3"},
+	}
+
+	if affected := userInputInstances(instances, surl); len(affected) != 1 || affected[0].InstanceId != "i1" {
+		t.Errorf("only instance i1 should be cleaned up, are %v", affected)
+	}
+}
@@ -0,0 +1,230 @@
+package governance
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/persistence"
+	"net/http"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// The workload lifecycle events that are sent to the workload callbacks.
+const WORKLOAD_STARTED = "started" // the containers of the workload are running
+const WORKLOAD_STOPPED = "stopped" // the containers of the workload were removed because the agreement ended
+const WORKLOAD_FAILED = "failed"   // the containers of the workload could not be started or have failed
+
+// The number of events that can be waiting for a callback. When a callback is slow enough to fill the queue, new
+// events are dropped rather than holding up the governance worker.
+const WORKLOAD_CALLBACK_QUEUE_SIZE = 50
+
+// The event that a callback receives, as JSON on stdin of a script or as the body of a POST.
+type WorkloadEvent struct {
+	Event       string   `json:"event"`
+	AgreementId string   `json:"agreementId"`
+	Protocol    string   `json:"protocol"`
+	WorkloadURL string   `json:"workloadUrl"`
+	Org         string   `json:"org"`
+	Version     string   `json:"version"`
+	Arch        string   `json:"arch"`
+	Containers  []string `json:"containers,omitempty"`
+	Time        uint64   `json:"time"`
+	Reason      string   `json:"reason,omitempty"` // why the workload stopped or failed
+}
+
+func (e WorkloadEvent) String() string {
+	return fmt.Sprintf("Event: %v, AgreementId: %v, WorkloadURL: %v, Version: %v, Reason: %v", e.Event, e.AgreementId, e.WorkloadURL, e.Version, e.Reason)
+}
+
+func NewWorkloadEvent(event string, ag *persistence.EstablishedAgreement, reason string) *WorkloadEvent {
+	return &WorkloadEvent{
+		Event:       event,
+		AgreementId: ag.CurrentAgreementId,
+		Protocol:    ag.AgreementProtocol,
+		WorkloadURL: ag.RunningWorkload.URL,
+		Org:         ag.RunningWorkload.Org,
+		Version:     ag.RunningWorkload.Version,
+		Arch:        ag.RunningWorkload.Arch,
+		Containers:  persistence.ServiceConfigNames(&ag.CurrentDeployment),
+		Time:        uint64(time.Now().Unix()),
+		Reason:      reason,
+	}
+}
+
+// The environment variables a callback script receives, in addition to the node's environment.
+func (e WorkloadEvent) envVars() []string {
+	return []string{
+		"HZN_EVENT=" + e.Event,
+		"HZN_AGREEMENT_ID=" + e.AgreementId,
+		"HZN_AGREEMENT_PROTOCOL=" + e.Protocol,
+		"HZN_WORKLOAD_URL=" + e.WorkloadURL,
+		"HZN_WORKLOAD_ORG=" + e.Org,
+		"HZN_WORKLOAD_VERSION=" + e.Version,
+		"HZN_WORKLOAD_ARCH=" + e.Arch,
+		"HZN_REASON=" + e.Reason,
+	}
+}
+
+// A callback and the queue of events waiting for it. Each callback is served by its own goroutine so that it sees
+// the events in the order they happened, and a slow callback does not delay the others.
+type workloadCallback struct {
+	config     config.WorkloadCallbackConfig
+	httpClient *http.Client
+	queue      chan *WorkloadEvent
+}
+
+func (c *workloadCallback) target() string {
+	if c.config.Command != "" {
+		return c.config.Command
+	}
+	return c.config.URL
+}
+
+func (c *workloadCallback) wants(event string) bool {
+	if len(c.config.Events) == 0 {
+		return true
+	}
+	for _, e := range c.config.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// The workload callbacks tell local integrations, like status LEDs or watchdogs, about workload lifecycle events.
+// A nil WorkloadCallbacks calls nothing, so callers dont have to check whether callbacks are configured.
+type WorkloadCallbacks struct {
+	callbacks []*workloadCallback
+}
+
+func NewWorkloadCallbacks(cfgs []config.WorkloadCallbackConfig, httpClientFactory *config.HTTPClientFactory) (*WorkloadCallbacks, error) {
+	if len(cfgs) == 0 {
+		return nil, nil
+	}
+
+	wc := &WorkloadCallbacks{
+		callbacks: make([]*workloadCallback, 0, len(cfgs)),
+	}
+	for _, cfg := range cfgs {
+		if (cfg.Command == "") == (cfg.URL == "") {
+			return nil, errors.New(fmt.Sprintf("workload callback %v must have either a Command or a URL", cfg))
+		}
+		for _, e := range cfg.Events {
+			if e != WORKLOAD_STARTED && e != WORKLOAD_STOPPED && e != WORKLOAD_FAILED {
+				return nil, errors.New(fmt.Sprintf("workload callback %v has unknown event %v, expecting %v, %v or %v", cfg, e, WORKLOAD_STARTED, WORKLOAD_STOPPED, WORKLOAD_FAILED))
+			}
+		}
+
+		timeout := uint(cfg.TimeoutS)
+		c := &workloadCallback{
+			config: cfg,
+			queue:  make(chan *WorkloadEvent, WORKLOAD_CALLBACK_QUEUE_SIZE),
+		}
+		if cfg.URL != "" {
+			c.httpClient = httpClientFactory.NewHTTPClient(&timeout)
+		}
+		wc.callbacks = append(wc.callbacks, c)
+		go wc.serve(c)
+	}
+	return wc, nil
+}
+
+// Queue a workload event for every callback that wants it. This never blocks.
+func (wc *WorkloadCallbacks) Notify(event *WorkloadEvent) {
+	if wc == nil {
+		return
+	}
+
+	for _, c := range wc.callbacks {
+		if !c.wants(event.Event) {
+			continue
+		}
+		select {
+		case c.queue <- event:
+			glog.V(5).Infof(wcLogString(fmt.Sprintf("queued %v for %v", event, c.target())))
+		default:
+			glog.Errorf(wcLogString(fmt.Sprintf("queue for %v is full, dropping %v", c.target(), event)))
+		}
+	}
+}
+
+func (wc *WorkloadCallbacks) serve(c *workloadCallback) {
+	for event := range c.queue {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			glog.Errorf(wcLogString(fmt.Sprintf("unable to marshal workload event %v, error: %v", event, err)))
+			continue
+		}
+
+		if c.config.Command != "" {
+			err = runCallbackCommand(c.config.Command, payload, event.envVars(), time.Duration(c.config.TimeoutS)*time.Second)
+		} else {
+			err = postCallback(c.httpClient, c.config.URL, payload)
+		}
+
+		if err != nil {
+			glog.Errorf(wcLogString(fmt.Sprintf("callback %v failed for %v, error: %v", c.target(), event, err)))
+		} else {
+			glog.V(5).Infof(wcLogString(fmt.Sprintf("callback %v completed for %v", c.target(), event)))
+		}
+	}
+}
+
+// Run the script with the payload on stdin, killing it if it runs longer than the timeout. The script runs in its own
+// process group so that anything it started is killed with it.
+func runCallbackCommand(command string, payload []byte, env []string, timeout time.Duration) error {
+	cmd := exec.Command(command)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(os.Environ(), env...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return errors.New(fmt.Sprintf("%v, output: %v", err, output.String()))
+		}
+		return nil
+	case <-time.After(timeout):
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		return errors.New(fmt.Sprintf("killed after %v", timeout))
+	}
+}
+
+func postCallback(httpClient *http.Client, url string, payload []byte) error {
+	resp, err := httpClient.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New(fmt.Sprintf("callback returned HTTP status %v", resp.StatusCode))
+	}
+	return nil
+}
+
+var wcLogString = func(v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("Workload Callbacks", nil, v)
+	}
+	return fmt.Sprintf("Workload Callbacks: %v", v)
+}
@@ -0,0 +1,125 @@
+// +build unit
+
+package governance
+
+import (
+	"encoding/json"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/persistence"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func callbackHTTPFactory() *config.HTTPClientFactory {
+	return &config.HTTPClientFactory{
+		NewHTTPClient: func(overrideTimeoutS *uint) *http.Client {
+			return &http.Client{Timeout: time.Duration(*overrideTimeoutS) * time.Second}
+		},
+	}
+}
+
+func callbackAgreement() *persistence.EstablishedAgreement {
+	return &persistence.EstablishedAgreement{
+		CurrentAgreementId: "ag1",
+		AgreementProtocol:  "Basic",
+		RunningWorkload:    persistence.WorkloadInfo{URL: "http://mydomain.com/wl", Org: "myorg", Version: "1.0.0", Arch: "amd64"},
+	}
+}
+
+func Test_WorkloadCallbacks_config(t *testing.T) {
+
+	if wc, err := NewWorkloadCallbacks(nil, callbackHTTPFactory()); err != nil || wc != nil {
+		t.Errorf("no callbacks should give a nil WorkloadCallbacks, error: %v", err)
+	}
+
+	// A nil WorkloadCallbacks ignores events.
+	var wc *WorkloadCallbacks
+	wc.Notify(NewWorkloadEvent(WORKLOAD_STARTED, callbackAgreement(), ""))
+
+	for _, cfg := range []config.WorkloadCallbackConfig{
+		config.WorkloadCallbackConfig{},
+		config.WorkloadCallbackConfig{Command: "/bin/true", URL: "http://localhost"},
+		config.WorkloadCallbackConfig{Command: "/bin/true", Events: []string{WORKLOAD_STARTED, "crashed"}},
+	} {
+		if _, err := NewWorkloadCallbacks([]config.WorkloadCallbackConfig{cfg}, callbackHTTPFactory()); err == nil {
+			t.Errorf("callback %v should be rejected", cfg)
+		}
+	}
+}
+
+func Test_WorkloadCallbacks_http(t *testing.T) {
+
+	received := make(chan WorkloadEvent, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event WorkloadEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("unable to decode event: %v", err)
+		}
+		received <- event
+	}))
+	defer server.Close()
+
+	cfg := config.WorkloadCallbackConfig{URL: server.URL, Events: []string{WORKLOAD_FAILED}, TimeoutS: 5}
+	wc, err := NewWorkloadCallbacks([]config.WorkloadCallbackConfig{cfg}, callbackHTTPFactory())
+	if err != nil {
+		t.Fatalf("unable to create callbacks: %v", err)
+	}
+
+	// Only the failed event is wanted by the callback.
+	wc.Notify(NewWorkloadEvent(WORKLOAD_STARTED, callbackAgreement(), ""))
+	wc.Notify(NewWorkloadEvent(WORKLOAD_FAILED, callbackAgreement(), "container failure"))
+
+	select {
+	case event := <-received:
+		if event.Event != WORKLOAD_FAILED || event.AgreementId != "ag1" || event.WorkloadURL != "http://mydomain.com/wl" || event.Reason != "container failure" {
+			t.Errorf("wrong event %v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the callback was not called")
+	}
+
+	select {
+	case event := <-received:
+		t.Errorf("unexpected event %v", event)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func Test_runCallbackCommand(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "workload-callback")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The script saves the event it is given on stdin and in its environment.
+	outFile := path.Join(dir, "out")
+	script := path.Join(dir, "callback.sh")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\necho \"$HZN_EVENT $HZN_AGREEMENT_ID $HZN_WORKLOAD_VERSION\" > "+outFile+"\ncat >> "+outFile+"\n"), 0700); err != nil {
+		t.Fatalf("unable to write script: %v", err)
+	}
+
+	event := NewWorkloadEvent(WORKLOAD_STOPPED, callbackAgreement(), "")
+	payload, _ := json.Marshal(event)
+	if err := runCallbackCommand(script, payload, event.envVars(), 5*time.Second); err != nil {
+		t.Fatalf("script failed: %v", err)
+	} else if out, err := ioutil.ReadFile(outFile); err != nil {
+		t.Fatalf("unable to read script output: %v", err)
+	} else if lines := strings.SplitN(string(out), "\n", 2); lines[0] != "stopped ag1 1.0.0" || lines[1] != string(payload) {
+		t.Errorf("wrong script output %v", string(out))
+	}
+
+	// A script that runs too long is killed.
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\nsleep 10\n"), 0700); err != nil {
+		t.Fatalf("unable to write script: %v", err)
+	} else if err := runCallbackCommand(script, payload, nil, 100*time.Millisecond); err == nil || !strings.Contains(err.Error(), "killed") {
+		t.Errorf("script should have been killed, error: %v", err)
+	}
+}
@@ -9,7 +9,9 @@ import (
 	"github.com/open-horizon/anax/api"
 	"github.com/open-horizon/anax/config"
 	"github.com/open-horizon/anax/container"
+	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/ethblockchain"
+	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/exchange"
 	"github.com/open-horizon/anax/governance"
 	"github.com/open-horizon/anax/persistence"
@@ -49,9 +51,22 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	// Log lines written by both the node and the agbot use the same format.
+	if err := cutil.SetLogFormat(cfg.Edge.LogFormat); err != nil {
+		panic(err)
+	}
+
 	glog.V(2).Infof("Using config: %v", cfg)
 	glog.V(2).Infof("GOMAXPROCS: %v", runtime.GOMAXPROCS(-1))
 
+	// Exchange calls made by both the node and the agbot retry transient errors in the same way.
+	exchange.SetRetryPolicy(exchange.NewRetryPolicy(cfg.Edge.ExchangeMaxRetries, time.Duration(cfg.Edge.ExchangeRetryIntervalMS)*time.Millisecond))
+	exchange.SetOutageThreshold(cfg.Edge.ExchangeOutageThresholdS)
+	if cfg.Edge.LowMemory() {
+		glog.Infof("Using the low memory profile.")
+		exchange.SetMaxRecordedInteractions(config.LOW_MEMORY_RECORDED_EXCHANGE_CALLS)
+	}
+
 	// open edge DB if necessary
 	var db *bolt.DB
 	if len(cfg.Edge.DBPath) != 0 {
@@ -81,17 +96,68 @@ func main() {
 		agbotdb = agdb
 	}
 
+	// The agbots of the federated exchanges each have a database of their own, named after the exchange.
+	federatedDBs := make(map[string]*bolt.DB)
+	if len(cfg.AgreementBot.DBPath) != 0 {
+		for _, fed := range cfg.AgreementBot.FederatedExchanges {
+			fedDB, err := bolt.Open(path.Join(cfg.AgreementBot.DBPath, "agreementbot-"+fed.Name+".db"), 0600, &bolt.Options{Timeout: 10 * time.Second})
+			if err != nil {
+				panic(err)
+			}
+			federatedDBs[fed.Name] = fedDB
+		}
+	}
+
 	// start control signal handler
 	control := make(chan os.Signal, 1)
 	signal.Notify(control, os.Interrupt)
 	signal.Notify(control, syscall.SIGTERM)
 
+	// The agbot workers are created up front so that the control signal handler can drain them.
+	agbotWorker := agreementbot.NewAgreementBotWorker("AgBot", cfg, agbotdb)
+	federatedWorkers := make([]*agreementbot.AgreementBotWorker, 0, len(federatedDBs))
+	for _, fed := range cfg.AgreementBot.FederatedExchanges {
+		if fedDB, ok := federatedDBs[fed.Name]; ok {
+			glog.Infof("Starting the agreement bot of federated exchange %v at %v", fed.Name, fed.ExchangeURL)
+			federatedWorkers = append(federatedWorkers, agreementbot.NewAgreementBotWorker("AgBot-"+fed.Name, cfg.FederatedConfig(fed), fedDB))
+		}
+	}
+
+	// The shutdown event goes to all the agbot workers, so the agbots of the federated exchanges drain at the same time.
+	waitForDrained := func(agbots []*agreementbot.AgreementBotWorker) {
+		drainTimeout := time.After(time.Duration(cfg.AgreementBot.ShutdownDrainTimeoutS+10) * time.Second)
+		for _, w := range agbots {
+			select {
+			case <-w.Drained():
+			case <-drainTimeout:
+				glog.Warningf("Timed out draining the agreement bot %v.", w.GetName())
+				return
+			}
+		}
+	}
+
 	// This routine does not need to be a subworker because it has no parent worker and it will terminate on its own
 	// when the main anax process terminates.
 	go func() {
-		<-control
+		select {
+		case <-control:
+			// Give the agbot a chance to finish the agreement work it is already doing before the process goes away.
+			if len(cfg.AgreementBot.DBPath) != 0 {
+				glog.Infof("Draining the agreement bot.")
+				go func() {
+					agbotWorker.Messages() <- events.NewAgbotShutdownMessage(events.AGBOT_SHUTDOWN, "control signal received")
+				}()
+				waitForDrained(append([]*agreementbot.AgreementBotWorker{agbotWorker}, federatedWorkers...))
+			}
+		case <-agbotWorker.Drained():
+			// A shutdown was requested through the agbot API.
+			waitForDrained(federatedWorkers)
+		}
 		glog.Infof("Closing up shop.")
 
+		// Stop the exchange calls that are still in flight.
+		exchange.CancelExchangeCalls()
+
 		pprof.StopCPUProfile()
 		if db != nil {
 			db.Close()
@@ -99,6 +165,9 @@ func main() {
 		if agbotdb != nil {
 			agbotdb.Close()
 		}
+		for _, fedDB := range federatedDBs {
+			fedDB.Close()
+		}
 
 		os.Exit(0)
 	}()
@@ -128,11 +197,17 @@ func main() {
 	// start workers
 	workers := worker.NewMessageHandlerRegistry()
 
-	workers.Add(agreementbot.NewAgreementBotWorker("AgBot", cfg, agbotdb))
+	workers.Add(agbotWorker)
+	for _, w := range federatedWorkers {
+		workers.Add(w)
+	}
 	if cfg.AgreementBot.APIListen != "" {
-		workers.Add(agreementbot.NewAPIListener("AgBot API", cfg, agbotdb))
+		workers.Add(agreementbot.NewAPIListener("AgBot API", cfg, agbotdb, agbotWorker))
+	}
+	// A node with the low memory profile does not run blockchain clients, an agbot always does.
+	if !cfg.Edge.LowMemory() || len(cfg.AgreementBot.DBPath) != 0 {
+		workers.Add(ethblockchain.NewEthBlockchainWorker("Blockchain", cfg))
 	}
-	workers.Add(ethblockchain.NewEthBlockchainWorker("Blockchain", cfg))
 
 	if db != nil {
 		workers.Add(api.NewAPIListener("API", cfg, db, pm))
@@ -155,6 +230,9 @@ func main() {
 	if agbotdb != nil {
 		agbotdb.Close()
 	}
+	for _, fedDB := range federatedDBs {
+		fedDB.Close()
+	}
 
 	glog.Info("Main process terminating")
 
@@ -0,0 +1,319 @@
+package metering
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"time"
+)
+
+// The MQTT publisher emits metering notifications to an MQTT broker, so that billing systems can consume usage data
+// as it is produced instead of reading it from the blockchain or the exchange. Only the small part of MQTT 3.1.1
+// that is needed to publish is implemented. Notifications are queued and published in the background, each batch
+// over its own connection because they are sent minutes apart.
+
+const MQTT_QUEUE_SIZE = 500                  // The number of records that can wait for the broker before new ones are dropped
+const MQTT_RETRY_INTERVAL = 30 * time.Second // The time to wait before trying an unreachable broker again
+const MQTT_TIMEOUT = 20 * time.Second        // The time allowed to connect and publish a batch
+const MQTT_KEEPALIVE_S = 60
+
+// MQTT control packet types, which are the high nibble of the first byte of a packet.
+const (
+	mqttConnect    = 0x10
+	mqttConnack    = 0x20
+	mqttPublish    = 0x30
+	mqttPuback     = 0x40
+	mqttDisconnect = 0xE0
+)
+
+var mqttConnackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "client id rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// The record that is published for each metering notification. It adds what a billing system needs to know about
+// the agreement, which the notification itself does not carry.
+type MeteringRecord struct {
+	Org          string                `json:"org"`
+	DeviceId     string                `json:"device_id"`
+	PolicyName   string                `json:"policy_name"`
+	Protocol     string                `json:"protocol"`
+	Notification *MeteringNotification `json:"notification"`
+}
+
+func (m MeteringRecord) String() string {
+	return fmt.Sprintf("Org: %v, DeviceId: %v, PolicyName: %v, Protocol: %v, Notification: %v", m.Org, m.DeviceId, m.PolicyName, m.Protocol, m.Notification)
+}
+
+type MQTTPublisher struct {
+	address   string      // host:port of the broker
+	tlsConfig *tls.Config // nil when the broker is not using TLS
+	topic     string
+	clientId  string
+	username  string
+	password  string
+	qos       byte
+	packetId  uint16
+	queue     chan []byte
+}
+
+// Create the publisher and start publishing in the background. The client id is used when the config doesnt have one.
+func NewMQTTPublisher(cfg config.MQTTConfig, clientId string) (*MQTTPublisher, error) {
+
+	broker, err := url.Parse(cfg.Broker)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to parse MQTT broker address %v, error %v", cfg.Broker, err))
+	} else if broker.Host == "" {
+		return nil, errors.New(fmt.Sprintf("MQTT broker address %v has no host, expecting tcp://host:port or ssl://host:port", cfg.Broker))
+	} else if cfg.QoS != 0 && cfg.QoS != 1 {
+		return nil, errors.New(fmt.Sprintf("MQTT QoS %v is not supported, use 0 or 1", cfg.QoS))
+	}
+
+	p := &MQTTPublisher{
+		address:  broker.Host,
+		topic:    cfg.Topic,
+		clientId: cfg.ClientId,
+		username: cfg.Username,
+		password: cfg.Password,
+		qos:      byte(cfg.QoS),
+		queue:    make(chan []byte, MQTT_QUEUE_SIZE),
+	}
+	if p.clientId == "" {
+		p.clientId = clientId
+	}
+
+	switch broker.Scheme {
+	case "tcp", "mqtt":
+	case "ssl", "tls", "mqtts":
+		if p.tlsConfig, err = mqttTLSConfig(cfg, broker.Hostname()); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New(fmt.Sprintf("MQTT broker address %v has unsupported scheme %v, expecting tcp or ssl", cfg.Broker, broker.Scheme))
+	}
+
+	go p.run()
+	return p, nil
+}
+
+func mqttTLSConfig(cfg config.MQTTConfig, serverName string) (*tls.Config, error) {
+
+	tlsConf := &tls.Config{
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	certPool, err := x509.SystemCertPool()
+	if err != nil {
+		certPool = x509.NewCertPool()
+	}
+	if cfg.CACertsPath != "" {
+		if caBytes, err := ioutil.ReadFile(cfg.CACertsPath); err != nil {
+			return nil, errors.New(fmt.Sprintf("unable to read MQTT CA certs %v, error %v", cfg.CACertsPath, err))
+		} else if !certPool.AppendCertsFromPEM(caBytes) {
+			return nil, errors.New(fmt.Sprintf("no PEM certs found in MQTT CA certs %v", cfg.CACertsPath))
+		}
+	}
+	tlsConf.RootCAs = certPool
+
+	if cfg.ClientCertPath != "" {
+		if cert, err := tls.LoadX509KeyPair(cfg.ClientCertPath, cfg.ClientKeyPath); err != nil {
+			return nil, errors.New(fmt.Sprintf("unable to load MQTT client cert %v, error %v", cfg.ClientCertPath, err))
+		} else {
+			tlsConf.Certificates = []tls.Certificate{cert}
+		}
+	}
+	return tlsConf, nil
+}
+
+func (p *MQTTPublisher) String() string {
+	return fmt.Sprintf("Address: %v, TLS: %v, Topic: %v, ClientId: %v, User: %v, QoS: %v, Queued: %v", p.address, p.tlsConfig != nil, p.topic, p.clientId, p.username, p.qos, len(p.queue))
+}
+
+// Queue a metering record for publishing. This never blocks, a record is dropped when the queue is full. A nil
+// publisher publishes nothing, so callers dont have to check whether a broker is configured.
+func (p *MQTTPublisher) Publish(record *MeteringRecord) {
+	if p == nil {
+		return
+	} else if payload, err := json.Marshal(record); err != nil {
+		glog.Errorf(mqttLogString(fmt.Sprintf("unable to marshal metering record %v, error %v", record, err)))
+	} else {
+		select {
+		case p.queue <- payload:
+		default:
+			glog.Errorf(mqttLogString(fmt.Sprintf("queue is full, dropping metering record %v", record)))
+		}
+	}
+}
+
+func (p *MQTTPublisher) run() {
+	for payload := range p.queue {
+
+		// Send everything else that is waiting on the same connection.
+		batch := [][]byte{payload}
+	drain:
+		for {
+			select {
+			case more := <-p.queue:
+				batch = append(batch, more)
+			default:
+				break drain
+			}
+		}
+
+		for len(batch) != 0 {
+			sent, err := p.send(batch)
+			batch = batch[sent:]
+			if err != nil {
+				glog.Errorf(mqttLogString(fmt.Sprintf("unable to publish %v metering records to %v, will retry, error %v", len(batch), p.address, err)))
+				time.Sleep(MQTT_RETRY_INTERVAL)
+			}
+		}
+	}
+}
+
+// Connect to the broker and publish the payloads. Returns the number of payloads that were published.
+func (p *MQTTPublisher) send(batch [][]byte) (int, error) {
+
+	dialer := &net.Dialer{Timeout: MQTT_TIMEOUT}
+	var conn net.Conn
+	var err error
+	if p.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", p.address, p.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", p.address)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(MQTT_TIMEOUT))
+	reader := bufio.NewReader(conn)
+
+	if _, err := conn.Write(p.connectPacket()); err != nil {
+		return 0, err
+	} else if header, body, err := readMQTTPacket(reader); err != nil {
+		return 0, err
+	} else if header != mqttConnack || len(body) != 2 {
+		return 0, errors.New(fmt.Sprintf("expected CONNACK from the broker, received packet type %x", header))
+	} else if body[1] != 0 {
+		return 0, errors.New(fmt.Sprintf("the broker refused the connection: %v", mqttConnackErrors[body[1]]))
+	}
+
+	for sent, payload := range batch {
+		p.packetId += 1
+		if p.packetId == 0 {
+			p.packetId = 1
+		}
+		if _, err := conn.Write(p.publishPacket(payload)); err != nil {
+			return sent, err
+		} else if p.qos == 1 {
+			if header, body, err := readMQTTPacket(reader); err != nil {
+				return sent, err
+			} else if header != mqttPuback || len(body) != 2 || uint16(body[0])<<8|uint16(body[1]) != p.packetId {
+				return sent, errors.New(fmt.Sprintf("expected PUBACK for packet %v from the broker, received packet type %x", p.packetId, header))
+			}
+		}
+		glog.V(5).Infof(mqttLogString(fmt.Sprintf("published %s to %v", payload, p.topic)))
+	}
+
+	conn.Write(mqttPacket(mqttDisconnect, nil))
+	return len(batch), nil
+}
+
+func (p *MQTTPublisher) connectPacket() []byte {
+	flags := byte(0x02) // clean session
+	if p.username != "" {
+		flags |= 0x80
+		if p.password != "" {
+			flags |= 0x40
+		}
+	}
+
+	body := mqttString("MQTT")
+	body = append(body, 4, flags, byte(MQTT_KEEPALIVE_S>>8), byte(MQTT_KEEPALIVE_S&0xff))
+	body = append(body, mqttString(p.clientId)...)
+	if flags&0x80 != 0 {
+		body = append(body, mqttString(p.username)...)
+	}
+	if flags&0x40 != 0 {
+		body = append(body, mqttString(p.password)...)
+	}
+	return mqttPacket(mqttConnect, body)
+}
+
+func (p *MQTTPublisher) publishPacket(payload []byte) []byte {
+	body := mqttString(p.topic)
+	if p.qos == 1 {
+		body = append(body, byte(p.packetId>>8), byte(p.packetId&0xff))
+	}
+	body = append(body, payload...)
+	return mqttPacket(mqttPublish|p.qos<<1, body)
+}
+
+func mqttPacket(header byte, body []byte) []byte {
+	packet := []byte{header}
+
+	// The remaining length is encoded 7 bits at a time, with the high bit set when more bytes follow.
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if length == 0 {
+			break
+		}
+	}
+	return append(packet, body...)
+}
+
+func mqttString(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s) & 0xff)}, s...)
+}
+
+// Read a packet, returning its first byte and the rest of the packet after the remaining length.
+func readMQTTPacket(reader *bufio.Reader) (byte, []byte, error) {
+	header, err := reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length := 0
+	for i, multiplier := 0, 1; ; i, multiplier = i+1, multiplier*128 {
+		if i == 4 {
+			return 0, nil, errors.New("malformed MQTT packet length")
+		} else if digit, err := reader.ReadByte(); err != nil {
+			return 0, nil, err
+		} else {
+			length += int(digit&0x7f) * multiplier
+			if digit&0x80 == 0 {
+				break
+			}
+		}
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+var mqttLogString = func(v interface{}) string {
+	return fmt.Sprintf("Metering MQTT Publisher: %v", v)
+}
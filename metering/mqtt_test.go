@@ -0,0 +1,139 @@
+// +build unit
+
+package metering
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/open-horizon/anax/config"
+	"net"
+	"testing"
+)
+
+// A broker that accepts one connection, answers the CONNECT with the given return code and acknowledges each
+// PUBLISH. The packets it receives are returned on the channel.
+func fakeBroker(t *testing.T, connackCode byte) (net.Listener, chan []byte) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+
+	packets := make(chan []byte, 10)
+	go func() {
+		defer close(packets)
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			header, body, err := readMQTTPacket(reader)
+			if err != nil {
+				return
+			}
+			packets <- append([]byte{header}, body...)
+			switch header & 0xf0 {
+			case mqttConnect:
+				conn.Write(mqttPacket(mqttConnack, []byte{0, connackCode}))
+			case mqttPublish:
+				if header&0x06 != 0 {
+					topicLen := int(body[0])<<8 | int(body[1])
+					conn.Write(mqttPacket(mqttPuback, body[2+topicLen:4+topicLen]))
+				}
+			case mqttDisconnect:
+				return
+			}
+		}
+	}()
+	return listener, packets
+}
+
+func Test_MQTTPublisher_send(t *testing.T) {
+
+	listener, packets := fakeBroker(t, 0)
+	defer listener.Close()
+
+	cfg := config.MQTTConfig{Broker: "tcp://" + listener.Addr().String(), Topic: "horizon/metering", Username: "user", Password: "pw", QoS: 1}
+	p, err := NewMQTTPublisher(cfg, "myorg/agbot1")
+	if err != nil {
+		t.Fatalf("unable to create publisher: %v", err)
+	}
+
+	record := &MeteringRecord{Org: "myorg", DeviceId: "myorg/node1", PolicyName: "pol", Protocol: "Basic", Notification: &MeteringNotification{Amount: 10, AgreementId: "ag1"}}
+	payload, _ := json.Marshal(record)
+	if sent, err := p.send([][]byte{payload, payload}); err != nil || sent != 2 {
+		t.Fatalf("should have sent 2 records, sent %v, error: %v", sent, err)
+	}
+
+	// CONNECT carries the client id and credentials.
+	connect := <-packets
+	expected := append([]byte{mqttConnect}, mqttString("MQTT")...)
+	expected = append(expected, 4, 0xc2, 0, MQTT_KEEPALIVE_S)
+	expected = append(expected, mqttString("myorg/agbot1")...)
+	expected = append(expected, mqttString("user")...)
+	expected = append(expected, mqttString("pw")...)
+	if string(connect) != string(expected) {
+		t.Errorf("wrong CONNECT packet %x, expected %x", connect, expected)
+	}
+
+	// Each PUBLISH is QoS 1 with its own packet id.
+	for id := byte(1); id <= 2; id++ {
+		publish := <-packets
+		expected := append([]byte{mqttPublish | 0x02}, mqttString("horizon/metering")...)
+		expected = append(expected, 0, id)
+		expected = append(expected, payload...)
+		if string(publish) != string(expected) {
+			t.Errorf("wrong PUBLISH packet %x, expected %x", publish, expected)
+		}
+	}
+
+	if disconnect := <-packets; len(disconnect) != 1 || disconnect[0] != mqttDisconnect {
+		t.Errorf("expected DISCONNECT, received %x", disconnect)
+	}
+}
+
+func Test_MQTTPublisher_refused(t *testing.T) {
+
+	listener, _ := fakeBroker(t, 5)
+	defer listener.Close()
+
+	p, err := NewMQTTPublisher(config.MQTTConfig{Broker: "tcp://" + listener.Addr().String(), Topic: "horizon/metering"}, "myorg/agbot1")
+	if err != nil {
+		t.Fatalf("unable to create publisher: %v", err)
+	} else if sent, err := p.send([][]byte{[]byte("{}")}); err == nil || sent != 0 {
+		t.Errorf("send should fail when the broker refuses the connection, sent %v", sent)
+	}
+}
+
+func Test_NewMQTTPublisher_invalid(t *testing.T) {
+
+	for _, cfg := range []config.MQTTConfig{
+		config.MQTTConfig{Broker: "localhost:1883", Topic: "t"},
+		config.MQTTConfig{Broker: "http://localhost:1883", Topic: "t"},
+		config.MQTTConfig{Broker: "tcp://localhost:1883", Topic: "t", QoS: 2},
+		config.MQTTConfig{Broker: "ssl://localhost:8883", Topic: "t", CACertsPath: "/does/not/exist"},
+	} {
+		if _, err := NewMQTTPublisher(cfg, "id"); err == nil {
+			t.Errorf("config %v should be rejected", cfg)
+		}
+	}
+
+	// A nil publisher ignores records.
+	var p *MQTTPublisher
+	p.Publish(&MeteringRecord{})
+}
+
+func Test_mqttPacket_length(t *testing.T) {
+
+	// Bodies longer than 127 bytes need more than one length byte.
+	body := make([]byte, 321)
+	packet := mqttPacket(mqttPublish, body)
+	if packet[1] != 0xc1 || packet[2] != 0x02 || len(packet) != 3+len(body) {
+		t.Errorf("wrong remaining length encoding %x", packet[:3])
+	}
+	if header, readBody, err := readMQTTPacket(bufio.NewReader(bytes.NewReader(packet))); err != nil || header != mqttPublish || len(readBody) != len(body) {
+		t.Errorf("unable to read packet back, header %x, length %v, error %v", header, len(readBody), err)
+	}
+}
@@ -30,19 +30,21 @@ const MS_DELETED_BY_UPGRADE_PROCESS = 205
 const MS_DELETED_FOR_AG_ENDED = 206
 const MS_IMAGE_FETCH_FAILED = 207
 const MS_DELETED_BY_DOWNGRADE_PROCESS = 208
+const MS_DELETED_FOR_USER_INPUT_CHANGED = 209
 
 func DecodeReasonCode(code uint64) string {
 	// microservice termiated deccription
 	codeMeanings := map[uint64]string{
-		MS_UNREG_EXCH_FAILED:            "Unregistering microservice on exchange failed",
-		MS_CLEAR_OLD_AGS_FAILED:         "Clearing old agreements failed",
-		MS_EXEC_FAILED:                  "Execution failed",
-		MS_REREG_EXCH_FAILED:            "Reregistering microservice on exchange failed",
-		MS_IMAGE_LOAD_FAILED:            "Image loading failed",
-		MS_DELETED_BY_UPGRADE_PROCESS:   "Deleted by upgrading process",
-		MS_DELETED_BY_DOWNGRADE_PROCESS: "Deleted by downgrading process",
-		MS_DELETED_FOR_AG_ENDED:         "Deleted for agreement ended",
-		MS_IMAGE_FETCH_FAILED:           "Image fetching failed",
+		MS_UNREG_EXCH_FAILED:              "Unregistering microservice on exchange failed",
+		MS_CLEAR_OLD_AGS_FAILED:           "Clearing old agreements failed",
+		MS_EXEC_FAILED:                    "Execution failed",
+		MS_REREG_EXCH_FAILED:              "Reregistering microservice on exchange failed",
+		MS_IMAGE_LOAD_FAILED:              "Image loading failed",
+		MS_DELETED_BY_UPGRADE_PROCESS:     "Deleted by upgrading process",
+		MS_DELETED_BY_DOWNGRADE_PROCESS:   "Deleted by downgrading process",
+		MS_DELETED_FOR_AG_ENDED:           "Deleted for agreement ended",
+		MS_IMAGE_FETCH_FAILED:             "Image fetching failed",
+		MS_DELETED_FOR_USER_INPUT_CHANGED: "Deleted for user input changed",
 	}
 
 	if reasonString, ok := codeMeanings[code]; !ok {
@@ -101,6 +103,7 @@ func ConvertMicroserviceToPersistent(ems *exchange.MicroserviceDefinition, org s
 		workloads = append(workloads, *new_wl)
 	}
 	pms.Workloads = workloads
+	pms.ImageFetch = ems.GetImageFetch()
 	pms.LastUpdated = ems.LastUpdated
 
 	// set defaults
@@ -171,6 +174,7 @@ func ConvertServiceToPersistent(es *exchange.ServiceDefinition, org string) (*pe
 
 	pms.ImageStore = make(persistence.ImplementationPackage)
 	cutil.CopyMap(es.ImageStore, pms.ImageStore)
+	pms.ImageFetch = es.ImageFetch
 
 	pms.LastUpdated = es.LastUpdated
 
@@ -25,18 +25,29 @@ func (c Configstate) String() string {
 	return fmt.Sprintf("State: %v, Time: %v", c.State, c.LastUpdateTime)
 }
 
+// The most agreements a constrained node is willing to run at the same time. A limit of zero means no limit.
+type AgreementLimits struct {
+	MaxAgreementsPerWorkload int `json:"maxAgreementsPerWorkload"`
+	MaxTotalAgreements       int `json:"maxTotalAgreements"`
+}
+
+func (a AgreementLimits) String() string {
+	return fmt.Sprintf("MaxAgreementsPerWorkload: %v, MaxTotalAgreements: %v", a.MaxAgreementsPerWorkload, a.MaxTotalAgreements)
+}
+
 type ExchangeDevice struct {
-	Id                 string      `json:"id"`
-	Org                string      `json:"organization"`
-	Pattern            string      `json:"pattern"`
-	Name               string      `json:"name"`
-	Token              string      `json:"token"`
-	TokenLastValidTime uint64      `json:"token_last_valid_time"`
-	TokenValid         bool        `json:"token_valid"`
-	HA                 bool        `json:"ha"`
-	Config             Configstate `json:"configstate"`
-	ServiceBased       bool        `json:"serviceBased"`  // The device is service based if this flag is on, but the flag being off could mean that service or workload based is not yet known.
-	WorkloadBased      bool        `json:"workloadBased"` // The device is workload based if this flag is on, but the flag being off could mean that service or workload based is not yet known.
+	Id                 string          `json:"id"`
+	Org                string          `json:"organization"`
+	Pattern            string          `json:"pattern"`
+	Name               string          `json:"name"`
+	Token              string          `json:"token"`
+	TokenLastValidTime uint64          `json:"token_last_valid_time"`
+	TokenValid         bool            `json:"token_valid"`
+	HA                 bool            `json:"ha"`
+	Config             Configstate     `json:"configstate"`
+	ServiceBased       bool            `json:"serviceBased"`  // The device is service based if this flag is on, but the flag being off could mean that service or workload based is not yet known.
+	WorkloadBased      bool            `json:"workloadBased"` // The device is workload based if this flag is on, but the flag being off could mean that service or workload based is not yet known.
+	AgreementLimits    AgreementLimits `json:"agreementLimits"`
 }
 
 func (e ExchangeDevice) String() string {
@@ -47,7 +58,7 @@ func (e ExchangeDevice) String() string {
 		tokenShadow = "unset"
 	}
 
-	return fmt.Sprintf("Org: %v, Token: <%s>, Name: %v, TokenLastValidTime: %v, TokenValid: %v, Pattern: %v, ServiceBased: %v, WorkloadBased: %v, %v", e.Org, tokenShadow, e.Name, e.TokenLastValidTime, e.TokenValid, e.Pattern, e.ServiceBased, e.WorkloadBased, e.Config)
+	return fmt.Sprintf("Org: %v, Token: <%s>, Name: %v, TokenLastValidTime: %v, TokenValid: %v, Pattern: %v, ServiceBased: %v, WorkloadBased: %v, %v, %v", e.Org, tokenShadow, e.Name, e.TokenLastValidTime, e.TokenValid, e.Pattern, e.ServiceBased, e.WorkloadBased, e.Config, e.AgreementLimits)
 }
 
 func (e ExchangeDevice) GetId() string {
@@ -131,6 +142,17 @@ func (e *ExchangeDevice) SetWorkloadBased(db *bolt.DB) (*ExchangeDevice, error)
 	})
 }
 
+func (e *ExchangeDevice) SetAgreementLimits(db *bolt.DB, deviceId string, limits AgreementLimits) (*ExchangeDevice, error) {
+	if limits.MaxAgreementsPerWorkload < 0 || limits.MaxTotalAgreements < 0 {
+		return nil, errors.New(fmt.Sprintf("Agreement limits must not be negative: %v", limits))
+	}
+
+	return updateExchangeDevice(db, e, deviceId, false, func(d ExchangeDevice) *ExchangeDevice {
+		d.AgreementLimits = limits
+		return &d
+	})
+}
+
 func (e *ExchangeDevice) IsState(state string) bool {
 	return e.Config.State == state
 }
@@ -188,6 +210,11 @@ func updateExchangeDevice(db *bolt.DB, self *ExchangeDevice, deviceId string, in
 				mod.Config.State = update.Config.State
 				mod.Config.LastUpdateTime = update.Config.LastUpdateTime
 			}
+			// Only take the limits from the update when they were changed, so that updating some other field through
+			// an older copy of the device does not put back the old limits.
+			if update.AgreementLimits != self.AgreementLimits {
+				mod.AgreementLimits = update.AgreementLimits
+			}
 			if mod.ServiceBased == false && mod.WorkloadBased == false && update.ServiceBased == true {
 				mod.ServiceBased = update.ServiceBased
 			}
@@ -0,0 +1,166 @@
+package persistence
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+)
+
+// metering summary table name
+const METERING_SUMMARY = "metering_summary"
+
+// A metering summary accumulates the metering notifications received for a workload from a consumer, across all the
+// agreements made for that workload with that consumer. Each metering notification carries the amount accrued since
+// the start of its agreement, so the summary keeps the most recent amount for each agreement and the total is the sum
+// of those. Summaries outlive the agreements they summarize, so that the earnings of archived agreements are not lost
+// when the archived agreements are purged.
+type MeteringSummary struct {
+	WorkloadURL           string                              `json:"workload_url"`
+	Org                   string                              `json:"organization"`
+	ConsumerId            string                              `json:"consumer_id"`
+	TotalAmount           uint64                              `json:"total_amount"`            // The tokens accrued in all agreements
+	TotalMissedTime       uint64                              `json:"total_missed_time"`       // The time in seconds that the consumer detected missing data in all agreements
+	NotificationCount     uint64                              `json:"notification_count"`      // The number of metering notifications received
+	FirstNotificationTime uint64                              `json:"first_notification_time"` // When the consumer sent the first notification, in seconds since 1970
+	LastNotificationTime  uint64                              `json:"last_notification_time"`  // When the consumer sent the most recent notification, in seconds since 1970
+	Agreements            map[string]MeteringSummaryAgreement `json:"agreements"`              // The most recent meter for each agreement, keyed by agreement id
+}
+
+type MeteringSummaryAgreement struct {
+	Amount      uint64 `json:"amount"`
+	MissedTime  uint64 `json:"missed_time"`
+	CurrentTime uint64 `json:"current_time"`
+}
+
+func (m MeteringSummary) String() string {
+	return fmt.Sprintf("WorkloadURL: %v, "+
+		"Org: %v, "+
+		"ConsumerId: %v, "+
+		"TotalAmount: %v, "+
+		"TotalMissedTime: %v, "+
+		"NotificationCount: %v, "+
+		"FirstNotificationTime: %v, "+
+		"LastNotificationTime: %v, "+
+		"Agreements: %v",
+		m.WorkloadURL, m.Org, m.ConsumerId, m.TotalAmount, m.TotalMissedTime, m.NotificationCount,
+		m.FirstNotificationTime, m.LastNotificationTime, m.Agreements)
+}
+
+func meteringSummaryKey(workloadURL string, org string, consumerId string) string {
+	catNull := func(str string) string {
+		return fmt.Sprintf("%s\x00", str)
+	}
+
+	var sb bytes.Buffer
+	sb.WriteString(catNull(workloadURL))
+	sb.WriteString(catNull(org))
+	sb.WriteString(consumerId)
+
+	return sb.String()
+}
+
+// Add a metering notification to the summary of the workload and consumer, creating the summary if necessary.
+// Notifications that are not newer than the one already recorded for the same agreement are ignored, so that a
+// duplicate is not counted twice and a notification received out of order does not reduce the amount.
+func UpdateMeteringSummary(db *bolt.DB, workloadURL string, org string, consumerId string, agreementId string, mn MeteringNotification) (*MeteringSummary, error) {
+
+	if workloadURL == "" || consumerId == "" || agreementId == "" {
+		return nil, errors.New("MeteringSummary, workload URL, consumer id or agreement id is empty, cannot persist")
+	}
+
+	var summary *MeteringSummary
+	key := meteringSummaryKey(workloadURL, org, consumerId)
+
+	updateErr := db.Update(func(tx *bolt.Tx) error {
+
+		b, err := tx.CreateBucketIfNotExists([]byte(METERING_SUMMARY))
+		if err != nil {
+			return err
+		}
+
+		summary = &MeteringSummary{
+			WorkloadURL: workloadURL,
+			Org:         org,
+			ConsumerId:  consumerId,
+			Agreements:  make(map[string]MeteringSummaryAgreement),
+		}
+		if current := b.Get([]byte(key)); current != nil {
+			if err := json.Unmarshal(current, summary); err != nil {
+				return fmt.Errorf("Unable to demarshal metering summary record %v, error: %v", string(current), err)
+			}
+		}
+
+		if previous, ok := summary.Agreements[agreementId]; ok && previous.CurrentTime >= mn.CurrentTime {
+			glog.V(3).Infof("ignoring metering notification %v for %v, it is older than %v", mn, agreementId, previous)
+			return nil
+		}
+
+		summary.Agreements[agreementId] = MeteringSummaryAgreement{
+			Amount:      mn.Amount,
+			MissedTime:  mn.MissedTime,
+			CurrentTime: mn.CurrentTime,
+		}
+		summary.NotificationCount += 1
+		if summary.FirstNotificationTime == 0 || mn.CurrentTime < summary.FirstNotificationTime {
+			summary.FirstNotificationTime = mn.CurrentTime
+		}
+		if mn.CurrentTime > summary.LastNotificationTime {
+			summary.LastNotificationTime = mn.CurrentTime
+		}
+
+		summary.TotalAmount = 0
+		summary.TotalMissedTime = 0
+		for _, ag := range summary.Agreements {
+			summary.TotalAmount += ag.Amount
+			summary.TotalMissedTime += ag.MissedTime
+		}
+
+		if serial, err := json.Marshal(summary); err != nil {
+			return fmt.Errorf("Unable to marshal metering summary record: %v", err)
+		} else if err := b.Put([]byte(key), serial); err != nil {
+			return fmt.Errorf("Unable to persist metering summary: %v", err)
+		}
+		glog.V(5).Infof("Updated metering summary %v", summary)
+
+		// success, close tx
+		return nil
+	})
+
+	if updateErr != nil {
+		return nil, updateErr
+	}
+	return summary, nil
+}
+
+// Return all the metering summaries in the db.
+func FindMeteringSummaries(db *bolt.DB) ([]MeteringSummary, error) {
+	summaries := make([]MeteringSummary, 0)
+
+	// fetch metering summaries
+	readErr := db.View(func(tx *bolt.Tx) error {
+
+		if b := tx.Bucket([]byte(METERING_SUMMARY)); b != nil {
+			b.ForEach(func(k, v []byte) error {
+
+				var s MeteringSummary
+
+				if err := json.Unmarshal(v, &s); err != nil {
+					glog.Errorf("Unable to deserialize db record: %v", v)
+				} else {
+					summaries = append(summaries, s)
+				}
+				return nil
+			})
+		}
+
+		return nil // end the transaction
+	})
+
+	if readErr != nil {
+		return nil, readErr
+	}
+	return summaries, nil
+}
@@ -0,0 +1,51 @@
+// +build unit
+
+package persistence
+
+import (
+	"testing"
+)
+
+// The summary total is the sum of the most recent amount of each agreement.
+func Test_UpdateMeteringSummary(t *testing.T) {
+
+	// Setup the DB for the UT environment
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Errorf("Error setting up UT DB: %v", err)
+	}
+
+	defer cleanTestDir(dir)
+
+	url := "http://mydomain.com/workload"
+	org := "myorg"
+	consumer := "myorg/agbot1"
+
+	if _, err := UpdateMeteringSummary(db, url, org, consumer, "ag1", MeteringNotification{Amount: 10, CurrentTime: 100}); err != nil {
+		t.Errorf("Error updating summary: %v", err)
+	} else if _, err := UpdateMeteringSummary(db, url, org, consumer, "ag1", MeteringNotification{Amount: 25, MissedTime: 60, CurrentTime: 200}); err != nil {
+		t.Errorf("Error updating summary: %v", err)
+	} else if _, err := UpdateMeteringSummary(db, url, org, consumer, "ag2", MeteringNotification{Amount: 5, CurrentTime: 300}); err != nil {
+		t.Errorf("Error updating summary: %v", err)
+	} else if s, err := UpdateMeteringSummary(db, url, org, consumer, "ag1", MeteringNotification{Amount: 15, CurrentTime: 150}); err != nil {
+		t.Errorf("Error updating summary: %v", err)
+	} else if s.TotalAmount != 30 {
+		t.Errorf("Total amount should be 30, an out of order notification should be ignored, was %v", s)
+	} else if s.TotalMissedTime != 60 {
+		t.Errorf("Total missed time should be 60, was %v", s)
+	} else if s.NotificationCount != 3 {
+		t.Errorf("Notification count should be 3, was %v", s)
+	} else if s.FirstNotificationTime != 100 || s.LastNotificationTime != 300 {
+		t.Errorf("Notification times should be 100 and 300, was %v", s)
+	} else if _, err := UpdateMeteringSummary(db, url, org, "myorg/agbot2", "ag3", MeteringNotification{Amount: 7, CurrentTime: 300}); err != nil {
+		t.Errorf("Error updating summary: %v", err)
+	} else if summaries, err := FindMeteringSummaries(db); err != nil {
+		t.Errorf("Error finding summaries: %v", err)
+	} else if len(summaries) != 2 {
+		t.Errorf("There should be a summary for each consumer, was %v", summaries)
+	}
+
+	if _, err := UpdateMeteringSummary(db, "", org, consumer, "ag4", MeteringNotification{Amount: 7, CurrentTime: 300}); err == nil {
+		t.Errorf("Summary without a workload URL should not be persisted")
+	}
+}
@@ -140,6 +140,7 @@ type MicroserviceDefinition struct {
 	Deployment                   string                `json:"deployment"`           // Used only by services, the deployment configuration of the implementation packages.
 	DeploymentSignature          string                `json:"deployment_signature"` // Used only by services, the signature of the deployment configuration.
 	ImageStore                   ImplementationPackage `json:"imageStore"`           // Used by services, the metadata that describes how to get the service implementation package(s).
+	ImageFetch                   *cutil.ImageFetch     `json:"imageFetch,omitempty"` // The mirrors, digests and auth hints for pulling the docker images of the deployment.
 	LastUpdated                  string                `json:"lastUpdated"`
 	Archived                     bool                  `json:"archived"`
 	Name                         string                `json:"name"`                  //the sensor_name passed in from the POST /service call
@@ -179,6 +180,7 @@ func (w MicroserviceDefinition) String() string {
 		"Deployment: %v, "+
 		"DeploymentSignature: %v, "+
 		"ImageStore: %v, "+
+		"ImageFetch: %v, "+
 		"LastUpdated: %v, "+
 		"Archived: %v, "+
 		"Name: %v, "+
@@ -197,7 +199,7 @@ func (w MicroserviceDefinition) String() string {
 		"UpgradeNewMsId: %v, "+
 		"MetadataHash: %v",
 		w.Id, w.Owner, w.Label, w.Description, w.SpecRef, w.Org, w.Version, w.Arch, w.Sharable, w.DownloadURL,
-		w.MatchHardware, w.UserInputs, w.Workloads, w.Public, w.RequiredServices, w.Deployment, w.DeploymentSignature, w.ImageStore, w.LastUpdated,
+		w.MatchHardware, w.UserInputs, w.Workloads, w.Public, w.RequiredServices, w.Deployment, w.DeploymentSignature, w.ImageStore, w.ImageFetch, w.LastUpdated,
 		w.Archived, w.Name, w.RequestedArch, w.UpgradeVersionRange, w.AutoUpgrade, w.ActiveUpgrade,
 		w.UpgradeStartTime, w.UpgradeMsUnregisteredTime, w.UpgradeAgreementsClearedTime, w.UpgradeExecutionStartTime, w.UpgradeMsReregisteredTime,
 		w.UpgradeFailedTime, w.UngradeFailureReason, w.UngradeFailureDescription, w.UpgradeNewMsId, w.MetadataHash)
@@ -260,6 +262,10 @@ func (m *MicroserviceDefinition) GetImageStore() ImplementationPackage {
 	return ip
 }
 
+func (m *MicroserviceDefinition) GetImageFetch() *cutil.ImageFetch {
+	return m.ImageFetch
+}
+
 func (m *MicroserviceDefinition) NeedsUserInput() string {
 	for _, ui := range m.UserInputs {
 		if ui.DefaultValue == "" {
@@ -0,0 +1,98 @@
+package persistence
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"strconv"
+)
+
+// deferred protocol message table name
+const DEFERRED_PROTOCOL_MESSAGES = "deferred_protocol_messages"
+
+// A protocol message that this version of anax did not understand. The message has a valid protocol header
+// so it was sent by a newer agbot, it is saved here so that a newer anax can process it after an upgrade.
+type DeferredProtocolMessage struct {
+	MsgId           int    `json:"msg_id"`            // The id of the exchange message that carried the protocol message
+	Protocol        string `json:"protocol"`          // The agreement protocol name
+	MsgType         string `json:"msg_type"`          // The protocol message type
+	MsgVersion      int    `json:"msg_version"`       // The protocol version the message was sent with
+	AgreementId     string `json:"agreement_id"`      // The agreement the message refers to
+	AgbotId         string `json:"agbot_id"`          // The exchange id of the sender
+	AgbotPubKey     []byte `json:"agbot_pub_key"`     // The public messaging key of the sender
+	ProtocolMessage string `json:"protocol_message"`  // The decrypted protocol message
+	ReceivedTime    uint64 `json:"received_time"`     // When the message was received from the exchange
+	StoredByVersion string `json:"stored_by_version"` // The version of anax that could not process the message
+}
+
+func (m DeferredProtocolMessage) String() string {
+	return fmt.Sprintf("MsgId: %v, "+
+		"Protocol: %v, "+
+		"MsgType: %v, "+
+		"MsgVersion: %v, "+
+		"AgreementId: %v, "+
+		"AgbotId: %v, "+
+		"ReceivedTime: %v, "+
+		"StoredByVersion: %v",
+		m.MsgId, m.Protocol, m.MsgType, m.MsgVersion, m.AgreementId, m.AgbotId, m.ReceivedTime, m.StoredByVersion)
+}
+
+// save a deferred protocol message to the db, keyed by the exchange message id.
+func SaveDeferredProtocolMessage(db *bolt.DB, msg *DeferredProtocolMessage) error {
+
+	if msg == nil || msg.MsgId == 0 {
+		return errors.New("DeferredProtocolMessage, message or message id is empty, cannot persist")
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		if b, err := tx.CreateBucketIfNotExists([]byte(DEFERRED_PROTOCOL_MESSAGES)); err != nil {
+			return err
+		} else if bytes, err := json.Marshal(msg); err != nil {
+			return fmt.Errorf("Unable to marshal new record: %v", err)
+		} else if err := b.Put([]byte(strconv.Itoa(msg.MsgId)), []byte(bytes)); err != nil {
+			return fmt.Errorf("Unable to persist deferred protocol message: %v", err)
+		} else {
+			glog.V(5).Infof("serialized to db record: %v", msg)
+		}
+		// success, close tx
+		return nil
+	})
+}
+
+// find all the deferred protocol messages in the db.
+func FindDeferredProtocolMessages(db *bolt.DB) ([]DeferredProtocolMessage, error) {
+	msgs := make([]DeferredProtocolMessage, 0)
+
+	readErr := db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(DEFERRED_PROTOCOL_MESSAGES)); b != nil {
+			return b.ForEach(func(k, v []byte) error {
+				var m DeferredProtocolMessage
+
+				if err := json.Unmarshal(v, &m); err != nil {
+					glog.Errorf("Unable to deserialize deferred protocol message db record: %v, error: %v", string(v), err)
+				} else {
+					msgs = append(msgs, m)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+
+	if readErr != nil {
+		return nil, readErr
+	}
+	return msgs, nil
+}
+
+// delete a deferred protocol message from the db.
+func DeleteDeferredProtocolMessage(db *bolt.DB, msgId int) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(DEFERRED_PROTOCOL_MESSAGES)); b != nil {
+			return b.Delete([]byte(strconv.Itoa(msgId)))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,58 @@
+// +build unit
+
+package persistence
+
+import (
+	"testing"
+)
+
+func Test_DeferredProtocolMessage_lifecycle(t *testing.T) {
+
+	// Setup the DB for the UT environment
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Errorf("Error setting up UT DB: %v", err)
+	}
+
+	defer cleanTestDir(dir)
+
+	if msgs, err := FindDeferredProtocolMessages(db); err != nil {
+		t.Errorf("Error finding deferred messages in empty db: %v", err)
+	} else if len(msgs) != 0 {
+		t.Errorf("Expected no deferred messages, got %v", msgs)
+	}
+
+	dm := &DeferredProtocolMessage{
+		MsgId:           42,
+		Protocol:        "Basic",
+		MsgType:         "newmessagetype",
+		MsgVersion:      2,
+		AgreementId:     "deadbeef",
+		AgbotId:         "myorg/ag1",
+		ProtocolMessage: `{"type":"newmessagetype","protocol":"Basic","version":2,"agreementId":"deadbeef"}`,
+		StoredByVersion: "1.0.0",
+	}
+
+	if err := SaveDeferredProtocolMessage(db, dm); err != nil {
+		t.Errorf("Error saving deferred message: %v", err)
+	} else if err := SaveDeferredProtocolMessage(db, &DeferredProtocolMessage{}); err == nil {
+		t.Errorf("Expected error saving a deferred message without a message id")
+	}
+
+	if msgs, err := FindDeferredProtocolMessages(db); err != nil {
+		t.Errorf("Error finding deferred messages: %v", err)
+	} else if len(msgs) != 1 {
+		t.Errorf("Expected 1 deferred message, got %v", msgs)
+	} else if msgs[0].MsgType != dm.MsgType || msgs[0].AgreementId != dm.AgreementId || msgs[0].ProtocolMessage != dm.ProtocolMessage {
+		t.Errorf("Deferred message %v does not match the saved message %v", msgs[0], dm)
+	}
+
+	if err := DeleteDeferredProtocolMessage(db, dm.MsgId); err != nil {
+		t.Errorf("Error deleting deferred message: %v", err)
+	} else if msgs, err := FindDeferredProtocolMessages(db); err != nil {
+		t.Errorf("Error finding deferred messages: %v", err)
+	} else if len(msgs) != 0 {
+		t.Errorf("Expected no deferred messages after delete, got %v", msgs)
+	}
+
+}
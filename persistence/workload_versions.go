@@ -0,0 +1,150 @@
+package persistence
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+)
+
+// workload versions table name
+const WORKLOAD_VERSIONS = "workload_versions"
+
+// The versions of a workload that the node has run, used to roll back an upgrade of the workload whose containers
+// keep failing. A version is verified once it ran for the rollback window without failing. A version that is started
+// while another version is verified is an upgrade, the failures of the upgrade are counted until it is verified or
+// rolled back. Proposals for a version that was rolled back are rejected until the workload is upgraded to another
+// version.
+type WorkloadVersions struct {
+	WorkloadURL       string   `json:"workload_url"`
+	Org               string   `json:"organization"`
+	VerifiedVersion   string   `json:"verified_version"`    // The most recent version that ran for the rollback window without failing
+	UpgradeVersion    string   `json:"upgrade_version"`     // The version being upgraded to, empty when the workload is not being upgraded
+	UpgradeStartTime  uint64   `json:"upgrade_start_time"`  // When the upgrade version was first started, in seconds since 1970
+	UpgradeFailures   []uint64 `json:"upgrade_failures"`    // When the containers of the upgrade version failed, in seconds since 1970
+	RolledBackVersion string   `json:"rolled_back_version"` // The version that was rolled back, empty when there was no rollback
+	RollbackTime      uint64   `json:"rollback_time"`       // When the version was rolled back, in seconds since 1970
+}
+
+func (w WorkloadVersions) String() string {
+	return fmt.Sprintf("WorkloadURL: %v, "+
+		"Org: %v, "+
+		"VerifiedVersion: %v, "+
+		"UpgradeVersion: %v, "+
+		"UpgradeStartTime: %v, "+
+		"UpgradeFailures: %v, "+
+		"RolledBackVersion: %v, "+
+		"RollbackTime: %v",
+		w.WorkloadURL, w.Org, w.VerifiedVersion, w.UpgradeVersion, w.UpgradeStartTime, w.UpgradeFailures,
+		w.RolledBackVersion, w.RollbackTime)
+}
+
+// Returns true when the upgrade version can be rolled back, i.e. there is a verified version to roll back to.
+func (w WorkloadVersions) Upgrading() bool {
+	return w.UpgradeVersion != "" && w.VerifiedVersion != "" && w.UpgradeVersion != w.VerifiedVersion
+}
+
+func workloadVersionsKey(workloadURL string, org string) string {
+	return fmt.Sprintf("%s\x00%s", workloadURL, org)
+}
+
+// Change the versions record of the workload with the update function, creating the record if necessary. The update
+// function returns false when it did not change the record.
+func UpdateWorkloadVersions(db *bolt.DB, workloadURL string, org string, update func(*WorkloadVersions) bool) (*WorkloadVersions, error) {
+
+	if workloadURL == "" || org == "" {
+		return nil, errors.New("WorkloadVersions, workload URL or org is empty, cannot persist")
+	}
+
+	var versions *WorkloadVersions
+	key := workloadVersionsKey(workloadURL, org)
+
+	updateErr := db.Update(func(tx *bolt.Tx) error {
+
+		b, err := tx.CreateBucketIfNotExists([]byte(WORKLOAD_VERSIONS))
+		if err != nil {
+			return err
+		}
+
+		versions = &WorkloadVersions{
+			WorkloadURL: workloadURL,
+			Org:         org,
+		}
+		if current := b.Get([]byte(key)); current != nil {
+			if err := json.Unmarshal(current, versions); err != nil {
+				return fmt.Errorf("Unable to demarshal workload versions record %v, error: %v", string(current), err)
+			}
+		}
+
+		if !update(versions) {
+			return nil
+		}
+
+		if serial, err := json.Marshal(versions); err != nil {
+			return fmt.Errorf("Unable to marshal workload versions record: %v", err)
+		} else if err := b.Put([]byte(key), serial); err != nil {
+			return fmt.Errorf("Unable to persist workload versions: %v", err)
+		}
+		glog.V(5).Infof("Updated workload versions %v", versions)
+
+		// success, close tx
+		return nil
+	})
+
+	if updateErr != nil {
+		return nil, updateErr
+	}
+	return versions, nil
+}
+
+// Return the versions record of the workload, nil when the node has not run the workload.
+func FindWorkloadVersions(db *bolt.DB, workloadURL string, org string) (*WorkloadVersions, error) {
+	var versions *WorkloadVersions
+
+	readErr := db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(WORKLOAD_VERSIONS)); b != nil {
+			if current := b.Get([]byte(workloadVersionsKey(workloadURL, org))); current != nil {
+				versions = new(WorkloadVersions)
+				if err := json.Unmarshal(current, versions); err != nil {
+					return fmt.Errorf("Unable to demarshal workload versions record %v, error: %v", string(current), err)
+				}
+			}
+		}
+		return nil
+	})
+
+	if readErr != nil {
+		return nil, readErr
+	}
+	return versions, nil
+}
+
+// Return the versions records of all the workloads in the db.
+func FindAllWorkloadVersions(db *bolt.DB) ([]WorkloadVersions, error) {
+	all := make([]WorkloadVersions, 0)
+
+	readErr := db.View(func(tx *bolt.Tx) error {
+
+		if b := tx.Bucket([]byte(WORKLOAD_VERSIONS)); b != nil {
+			b.ForEach(func(k, v []byte) error {
+
+				var w WorkloadVersions
+
+				if err := json.Unmarshal(v, &w); err != nil {
+					glog.Errorf("Unable to deserialize db record: %v", v)
+				} else {
+					all = append(all, w)
+				}
+				return nil
+			})
+		}
+
+		return nil // end the transaction
+	})
+
+	if readErr != nil {
+		return nil, readErr
+	}
+	return all, nil
+}
@@ -0,0 +1,58 @@
+// +build unit
+
+package persistence
+
+import (
+	"testing"
+)
+
+// The update function decides whether the record is persisted, the record is created on the first change.
+func Test_UpdateWorkloadVersions(t *testing.T) {
+
+	// Setup the DB for the UT environment
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Errorf("Error setting up UT DB: %v", err)
+	}
+
+	defer cleanTestDir(dir)
+
+	url := "http://mydomain.com/workload"
+	org := "myorg"
+
+	unchanged := func(w *WorkloadVersions) bool { return false }
+	upgrade := func(w *WorkloadVersions) bool {
+		w.UpgradeVersion = "2.0.0"
+		return true
+	}
+
+	if _, err := UpdateWorkloadVersions(db, url, org, unchanged); err != nil {
+		t.Errorf("Error updating versions: %v", err)
+	} else if v, err := FindWorkloadVersions(db, url, org); err != nil {
+		t.Errorf("Error finding versions: %v", err)
+	} else if v != nil {
+		t.Errorf("An unchanged record should not be persisted, was %v", v)
+	} else if _, err := UpdateWorkloadVersions(db, url, org, func(w *WorkloadVersions) bool { w.VerifiedVersion = "1.0.0"; return true }); err != nil {
+		t.Errorf("Error updating versions: %v", err)
+	} else if v, err := UpdateWorkloadVersions(db, url, org, upgrade); err != nil {
+		t.Errorf("Error updating versions: %v", err)
+	} else if !v.Upgrading() {
+		t.Errorf("Versions should be upgrading, was %v", v)
+	} else if v, err := FindWorkloadVersions(db, url, org); err != nil {
+		t.Errorf("Error finding versions: %v", err)
+	} else if v == nil || v.VerifiedVersion != "1.0.0" || v.UpgradeVersion != "2.0.0" || v.Org != org {
+		t.Errorf("Versions should be 1.0.0 and 2.0.0, was %v", v)
+	} else if _, err := UpdateWorkloadVersions(db, url, "otherorg", upgrade); err != nil {
+		t.Errorf("Error updating versions: %v", err)
+	} else if all, err := FindAllWorkloadVersions(db); err != nil {
+		t.Errorf("Error finding versions: %v", err)
+	} else if len(all) != 2 {
+		t.Errorf("There should be a record for each org, was %v", all)
+	} else if all[0].Upgrading() && all[1].Upgrading() {
+		t.Errorf("Versions without a verified version should not be upgrading, was %v", all)
+	}
+
+	if _, err := UpdateWorkloadVersions(db, "", org, upgrade); err == nil {
+		t.Errorf("Versions without a workload URL should not be persisted")
+	}
+}
@@ -12,6 +12,7 @@ const BasicProtocol = "Basic"
 var AllProtocols = []string{CitizenScientist, BasicProtocol}
 
 var RequiresBCType = map[string]string{CitizenScientist: Ethereum_bc}
+var SupportedBCTypes = map[string][]string{CitizenScientist: []string{Ethereum_bc, Fabric_bc}}
 var DefaultBCOrg = map[string]string{CitizenScientist: Default_Blockchain_org}
 
 func SupportedAgreementProtocol(name string) bool {
@@ -34,6 +35,17 @@ func RequiresBlockchainType(protocolName string) string {
 	return ""
 }
 
+// Returns true when the agreement protocol can use the blockchain type. The type returned by RequiresBlockchainType
+// is the default type of the protocol.
+func SupportsBlockchainType(protocolName string, bcType string) bool {
+	for _, t := range SupportedBCTypes[protocolName] {
+		if t == bcType {
+			return true
+		}
+	}
+	return false
+}
+
 func HasDefaultBCOrg(protocolName string) string {
 	if bcorg, ok := DefaultBCOrg[protocolName]; ok {
 		return bcorg
@@ -151,7 +163,7 @@ func (a *AgreementProtocol) IsValid() error {
 		return errors.New(fmt.Sprintf("AgreementProtocol %v is not supported.", a.Name))
 	} else {
 		for _, bc := range a.Blockchains {
-			if bc.Type != "" && bc.Type != RequiresBlockchainType(a.Name) {
+			if bc.Type != "" && !SupportsBlockchainType(a.Name, bc.Type) {
 				return errors.New(fmt.Sprintf("AgreementProtocol %v has blockchain type %v that is incompatible.", a.Name, bc.Type))
 			}
 		}
@@ -179,7 +191,9 @@ func AgreementProtocol_Factory(name string) *AgreementProtocol {
 	a := new(AgreementProtocol)
 	a.Name = name
 	a.Blockchains = (*new(BlockchainList))
-	if name == CitizenScientist {
+	if name == BasicProtocol {
+		a.ProtocolVersion = 4 // V3 compresses proposals, V4 seals messages
+	} else if name == CitizenScientist {
 		a.ProtocolVersion = 2
 	} else {
 		a.ProtocolVersion = 1 // this might have to be zero
@@ -222,6 +236,12 @@ func (self *AgreementProtocolList) Intersects_With(other *AgreementProtocolList)
 				} else {
 					new_ele := AgreementProtocol_Factory(sub_ele.Name)
 					new_ele.Blockchains = *bcIntersect
+					// Neither side can use a protocol version higher than the one it declared.
+					for _, pv := range []int{sub_ele.ProtocolVersion, other_ele.ProtocolVersion} {
+						if pv != 0 && pv < new_ele.ProtocolVersion {
+							new_ele.ProtocolVersion = pv
+						}
+					}
 					(*inter) = append(*inter, *new_ele)
 				}
 			}
@@ -209,6 +209,21 @@ func Test_AgreementProtocolList_intersects(t *testing.T) {
 		}
 	}
 
+	// A node that supports V2 of the basic protocol with an agbot that only supports V1
+	p1 = `[{"name":"Basic","protocolVersion":2}]`
+	p2 = `[{"name":"Basic","protocolVersion":1}]`
+	if pl1 = create_AgreementProtocolList(p1, t); pl1 != nil {
+		if pl2 = create_AgreementProtocolList(p2, t); pl2 != nil {
+			if pl3, err := pl1.Intersects_With(pl2); err != nil {
+				t.Errorf("Error: %v intersects with %v, error was %v\n", p1, p2, err)
+			} else if len(*pl3) != 1 {
+				t.Errorf("Error: Intersection of %v with %v should have produced 1 intersections, produced %v\n", p1, p2, len(*pl3))
+			} else if (*pl3)[0].ProtocolVersion != 1 {
+				t.Errorf("Error: Intersection of %v with %v should have produced protocol version 1, produced %v\n", p1, p2, (*pl3)[0].ProtocolVersion)
+			}
+		}
+	}
+
 	// Now test with the protocol names that we actually support
 	p1 = `[{"name":"Citizen Scientist","protocolVersion":2}]`
 	p2 = `[{"name":"Citizen Scientist"}]`
@@ -364,7 +379,7 @@ func Test_AgreementProtocol_isvalid(t *testing.T) {
 		t.Errorf("Error: agreement protocol object is valid %v\n", agp)
 	}
 
-	p1 := `[{"name":"Basic","blockchains":[]},{"name":"Basic"},{"name":"Citizen Scientist"},{"name":"Citizen Scientist","blockchains":[]},{"name":"Citizen Scientist","blockchains":[{}]},{"name":"Citizen Scientist","blockchains":[{"name":"fred"}]},{"name":"Citizen Scientist","blockchains":[{"name":"fred","type":"ethereum"}]}]`
+	p1 := `[{"name":"Basic","blockchains":[]},{"name":"Basic"},{"name":"Citizen Scientist"},{"name":"Citizen Scientist","blockchains":[]},{"name":"Citizen Scientist","blockchains":[{}]},{"name":"Citizen Scientist","blockchains":[{"name":"fred"}]},{"name":"Citizen Scientist","blockchains":[{"name":"fred","type":"ethereum"}]},{"name":"Citizen Scientist","blockchains":[{"name":"fred","type":"fabric"}]}]`
 	if pl1 := create_AgreementProtocolList(p1, t); pl1 != nil {
 		for _, agp := range *pl1 {
 			if err := agp.IsValid(); err != nil {
@@ -394,6 +409,15 @@ func Test_AgreementProtocol_is_notvalid(t *testing.T) {
 		}
 	}
 
+	p1 = `[{"name":"Basic","blockchains":[{"type":"fabric"}]}]`
+	if pl1 := create_AgreementProtocolList(p1, t); pl1 != nil {
+		for _, agp := range *pl1 {
+			if err := agp.IsValid(); err == nil {
+				t.Errorf("Error: agreement protocol object is not valid %v\n", agp)
+			}
+		}
+	}
+
 	p1 = `[{"name":"fred","blockchains":[{"type":"ethereum"}]}]`
 	if pl1 := create_AgreementProtocolList(p1, t); pl1 != nil {
 		for _, agp := range *pl1 {
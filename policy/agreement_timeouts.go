@@ -0,0 +1,60 @@
+package policy
+
+import (
+	"fmt"
+)
+
+// The purpose of this file is to abstract the operations on the AgreementTimeouts type. Agreement timeouts are
+// specified on the consumer side. They override the agbot's configured timeouts for the agreements made with the
+// policy, so that workloads whose nodes are slow to negotiate, e.g. over a cellular link, are not cancelled before
+// the node had a chance to reply. A zero timeout means the agbot's configured timeout is used.
+
+type AgreementTimeouts struct {
+	NoReplyS      uint64 `json:"noReply,omitempty"`      // Number of seconds to wait for the reply to a proposal, overrides ProtocolTimeoutS
+	NotFinalizedS uint64 `json:"notFinalized,omitempty"` // Number of seconds to wait for the agreement to be finalized, overrides AgreementTimeoutS
+}
+
+// This function creates AgreementTimeouts objects
+func AgreementTimeouts_Factory(noReplyS uint64, notFinalizedS uint64) *AgreementTimeouts {
+	t := new(AgreementTimeouts)
+	t.NoReplyS = noReplyS
+	t.NotFinalizedS = notFinalizedS
+
+	return t
+}
+
+func (t *AgreementTimeouts) String() string {
+	if t == nil {
+		return "default"
+	}
+	return fmt.Sprintf("NoReplyS: %v, NotFinalizedS: %v", t.NoReplyS, t.NotFinalizedS)
+}
+
+// A policy without an agreement timeouts section uses the agbot's configured timeouts.
+func (t *AgreementTimeouts) IsEmpty() bool {
+	return t == nil || t.NoReplyS == 0 && t.NotFinalizedS == 0
+}
+
+func (t *AgreementTimeouts) IsSame(compare *AgreementTimeouts) bool {
+	if t.IsEmpty() || compare.IsEmpty() {
+		return t.IsEmpty() && compare.IsEmpty()
+	}
+	return t.NoReplyS == compare.NoReplyS && t.NotFinalizedS == compare.NotFinalizedS
+}
+
+// Returns the number of seconds to wait for a proposal reply, the default when the policy doesn't override it.
+func (t *AgreementTimeouts) NoReply(defaultS uint64) uint64 {
+	if t == nil || t.NoReplyS == 0 {
+		return defaultS
+	}
+	return t.NoReplyS
+}
+
+// Returns the number of seconds to wait for an agreement to be finalized, the default when the policy doesn't
+// override it.
+func (t *AgreementTimeouts) NotFinalized(defaultS uint64) uint64 {
+	if t == nil || t.NotFinalizedS == 0 {
+		return defaultS
+	}
+	return t.NotFinalizedS
+}
@@ -117,7 +117,18 @@ func (self APISpecList) Supports(required APISpecList) error {
 			}
 		}
 		if !found {
-			return errors.New(fmt.Sprintf("APISpec %v does not support required API Spec %v", sub_ele, required))
+			// Say which required version range was not met, when the API spec is required at all.
+			versionRange := ""
+			for _, req_ele := range required {
+				if sub_ele.SpecRef == req_ele.SpecRef && sub_ele.Org == req_ele.Org {
+					versionRange = req_ele.Version
+				}
+			}
+			return &CompatibilityError{
+				SpecRef:      sub_ele.SpecRef,
+				VersionRange: versionRange,
+				Msg:          fmt.Sprintf("APISpec %v does not support required API Spec %v", sub_ele, required),
+			}
 		}
 	}
 
@@ -10,6 +10,7 @@ import (
 //
 
 const Ethereum_bc = "ethereum"
+const Fabric_bc = "fabric"
 const Default_Blockchain_name = "bluehorizon"
 const Default_Blockchain_org = "IBM"
 
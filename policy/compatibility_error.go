@@ -0,0 +1,43 @@
+package policy
+
+// The purpose of this file is to describe why 2 policies are not compatible in a way that can be processed by code,
+// so that a node that declines a proposal can tell the agbot which part of the policies did not match.
+
+// The parts of the policies that are checked for compatibility.
+const COMPAT_SCHEMA_VERSION = "schemaVersion"
+const COMPAT_API_SPECS = "apiSpecs"
+const COMPAT_CONSUMER_REQUIREMENTS = "consumerCounterPartyProperties" // the producer properties dont satisfy the consumer's requirements
+const COMPAT_PRODUCER_REQUIREMENTS = "producerCounterPartyProperties" // the consumer properties dont satisfy the producer's requirements
+const COMPAT_AGREEMENT_PROTOCOLS = "agreementProtocols"
+const COMPAT_PROPERTIES = "properties"
+const COMPAT_RESOURCE_LIMITS = "resourceLimits"
+const COMPAT_DATA_VERIFICATION = "dataVerification"
+const COMPAT_HA_GROUP = "haGroup"
+
+// The error returned when policies are not compatible. The message is the same as the message of the plain error
+// that was returned before, the other fields say what did not match when it is known.
+type CompatibilityError struct {
+	Constraint   string // the part of the policies that is not compatible, one of the COMPAT_ constants
+	Property     string // the name of the property that is missing or has the wrong value
+	SpecRef      string // the API spec that is not supported
+	VersionRange string // the version range of the API spec that is not supported
+	Msg          string
+}
+
+func (e *CompatibilityError) Error() string {
+	return e.Msg
+}
+
+// Create the error for a failed compatibility check, taking the details that the underlying error knows about.
+func NewCompatibilityError(constraint string, msg string, cause error) *CompatibilityError {
+	ce := &CompatibilityError{
+		Constraint: constraint,
+		Msg:        msg,
+	}
+	if inner, ok := cause.(*CompatibilityError); ok {
+		ce.Property = inner.Property
+		ce.SpecRef = inner.SpecRef
+		ce.VersionRange = inner.VersionRange
+	}
+	return ce
+}
@@ -0,0 +1,63 @@
+// +build unit
+
+package policy
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func compatTestPolicies(t *testing.T) (*Policy, *Policy) {
+
+	producer := Policy_Factory("producer")
+	producer.Add_API_Spec(APISpecification_Factory("http://mycompany.com/gps", "myorg", "1.0.0", "amd64"))
+	producer.Add_Agreement_Protocol(AgreementProtocol_Factory(BasicProtocol))
+	producer.Add_Property(Property_Factory("gpu", "none"))
+
+	consumer := Policy_Factory("consumer")
+	consumer.Add_API_Spec(APISpecification_Factory("http://mycompany.com/gps", "myorg", "1.0.0", "amd64"))
+	consumer.Add_Agreement_Protocol(AgreementProtocol_Factory(BasicProtocol))
+
+	return producer, consumer
+}
+
+func Test_CompatibilityError_property(t *testing.T) {
+
+	producer, consumer := compatTestPolicies(t)
+
+	rp := RequiredProperty_Factory()
+	if err := json.Unmarshal([]byte(`{"and":[{"name":"gpu","value":"nvidia","op":"="}]}`), rp); err != nil {
+		t.Fatalf("unable to create required properties: %v", err)
+	}
+	consumer.Add_CounterPartyProperties(rp)
+
+	if err := Are_Compatible(producer, consumer); err == nil {
+		t.Errorf("policies should not be compatible")
+	} else if ce, ok := err.(*CompatibilityError); !ok {
+		t.Errorf("expected a compatibility error, got %T", err)
+	} else if ce.Constraint != COMPAT_CONSUMER_REQUIREMENTS || ce.Property != "gpu" {
+		t.Errorf("expected the gpu consumer requirement to fail, got %v %v", ce.Constraint, ce.Property)
+	}
+}
+
+func Test_CompatibilityError_apiSpec(t *testing.T) {
+
+	producer, consumer := compatTestPolicies(t)
+	consumer.APISpecs = APISpecList{*APISpecification_Factory("http://mycompany.com/gps", "myorg", "[2.0.0,3.0.0)", "amd64")}
+
+	if err := Are_Compatible(producer, consumer); err == nil {
+		t.Errorf("policies should not be compatible")
+	} else if ce, ok := err.(*CompatibilityError); !ok {
+		t.Errorf("expected a compatibility error, got %T", err)
+	} else if ce.Constraint != COMPAT_API_SPECS || ce.SpecRef != "http://mycompany.com/gps" || ce.VersionRange != "[2.0.0,3.0.0)" {
+		t.Errorf("expected the gps version range to fail, got %v %v %v", ce.Constraint, ce.SpecRef, ce.VersionRange)
+	}
+}
+
+func Test_CompatibilityError_compatible(t *testing.T) {
+
+	producer, consumer := compatTestPolicies(t)
+	if err := Are_Compatible(producer, consumer); err != nil {
+		t.Errorf("policies should be compatible, error: %v", err)
+	}
+}
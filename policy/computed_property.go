@@ -0,0 +1,144 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// The purpose of this file is to support computed properties. A computed property is a property whose value is not
+// fixed when the node is registered, but is derived each time the property is evaluated against the counterparty's
+// requirements, for example the free disk space of the node. The node advertises a computed property with a value
+// that refers to a property provider:
+//
+// {"name": "freeDiskGB", "value": "${freeDiskGB}"}
+//
+// The provider is called when the node decides on a proposal, so the counterparty's requirements are checked
+// against the current value. The agbot can't evaluate the property, so it assumes that a requirement on a computed
+// property is satisfied and leaves the decision to the node.
+//
+// Integrators add device specific properties by implementing the PropertyProvider interface and registering the
+// provider when the node starts, typically from an init function in a file that is compiled into anax.
+
+type PropertyProvider interface {
+	Name() string                // the name that computed property values refer to
+	Value() (interface{}, error) // the current value, must be a number, a boolean or a string
+}
+
+var computedRef = regexp.MustCompile(`^\$\{([A-Za-z0-9_.-]+)\}$`)
+
+var providersLock sync.Mutex
+var providers = make(map[string]PropertyProvider)
+
+// Register a property provider. It is an error to register 2 providers with the same name.
+func RegisterPropertyProvider(p PropertyProvider) error {
+	providersLock.Lock()
+	defer providersLock.Unlock()
+
+	if !computedRef.MatchString("${" + p.Name() + "}") {
+		return errors.New(fmt.Sprintf("property provider name %v is not valid, use letters, digits, '_', '.' and '-'", p.Name()))
+	} else if _, ok := providers[p.Name()]; ok {
+		return errors.New(fmt.Sprintf("property provider %v is already registered", p.Name()))
+	}
+	providers[p.Name()] = p
+	return nil
+}
+
+func UnregisterPropertyProvider(name string) {
+	providersLock.Lock()
+	defer providersLock.Unlock()
+	delete(providers, name)
+}
+
+func getPropertyProvider(name string) PropertyProvider {
+	providersLock.Lock()
+	defer providersLock.Unlock()
+	return providers[name]
+}
+
+// Returns the names of the registered property providers, sorted.
+func PropertyProviderNames() []string {
+	providersLock.Lock()
+	defer providersLock.Unlock()
+
+	names := make([]string, 0, len(providers))
+	for name, _ := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the name of the provider that a property value refers to, or the empty string if the value is not a
+// computed property reference.
+func ComputedPropertyProvider(value interface{}) string {
+	if s, ok := value.(string); !ok {
+		return ""
+	} else if m := computedRef.FindStringSubmatch(s); m == nil {
+		return ""
+	} else {
+		return m[1]
+	}
+}
+
+// Verify that every computed property in the list refers to a registered provider.
+func (self PropertyList) ValidateComputed() error {
+	for _, p := range self {
+		if name := ComputedPropertyProvider(p.Value); name != "" && getPropertyProvider(name) == nil {
+			return errors.New(fmt.Sprintf("property %v refers to property provider %v, which is not registered. Registered providers are %v", p.Name, name, PropertyProviderNames()))
+		}
+	}
+	return nil
+}
+
+// Return a copy of the property list with the computed properties replaced by their current values. Integer values
+// are converted to float64 so that they compare like the numbers in a RequiredProperty expression.
+func (self PropertyList) EvaluateComputed() (PropertyList, error) {
+	evaluated := make(PropertyList, 0, len(self))
+	for _, p := range self {
+		name := ComputedPropertyProvider(p.Value)
+		if name == "" {
+			evaluated = append(evaluated, p)
+			continue
+		}
+
+		provider := getPropertyProvider(name)
+		if provider == nil {
+			return nil, errors.New(fmt.Sprintf("property %v refers to property provider %v, which is not registered", p.Name, name))
+		}
+
+		value, err := provider.Value()
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("property provider %v unable to compute property %v, error %v", name, p.Name, err))
+		}
+
+		switch v := value.(type) {
+		case int:
+			value = float64(v)
+		case int64:
+			value = float64(v)
+		case uint64:
+			value = float64(v)
+		case float32:
+			value = float64(v)
+		case float64, bool, string:
+		default:
+			return nil, errors.New(fmt.Sprintf("property provider %v returned unsupported type %T for property %v", name, value, p.Name))
+		}
+		evaluated = append(evaluated, Property{Name: p.Name, Value: value})
+	}
+	return evaluated, nil
+}
+
+// Return a copy of the policy with its computed properties replaced by their current values.
+func (self *Policy) EvaluateComputedProperties() (*Policy, error) {
+	if evaluated, err := self.Properties.EvaluateComputed(); err != nil {
+		return nil, err
+	} else {
+		pol := *self
+		pol.Properties = evaluated
+		return &pol, nil
+	}
+}
@@ -0,0 +1,104 @@
+// +build unit
+
+package policy
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+type testProvider struct {
+	name  string
+	value interface{}
+	err   error
+}
+
+func (p *testProvider) Name() string {
+	return p.name
+}
+
+func (p *testProvider) Value() (interface{}, error) {
+	return p.value, p.err
+}
+
+func Test_computed_property_evaluation(t *testing.T) {
+
+	disk := &testProvider{name: "testDiskGB", value: 5}
+	if err := RegisterPropertyProvider(disk); err != nil {
+		t.Fatalf("unable to register provider: %v", err)
+	}
+	defer UnregisterPropertyProvider(disk.name)
+
+	if err := RegisterPropertyProvider(&testProvider{name: "testDiskGB"}); err == nil {
+		t.Errorf("registering the same provider twice should fail")
+	} else if err := RegisterPropertyProvider(&testProvider{name: "bad name"}); err == nil {
+		t.Errorf("registering a provider with an invalid name should fail")
+	}
+
+	props := PropertyList{{Name: "freeDisk", Value: "${testDiskGB}"}, {Name: "color", Value: "red"}}
+	if err := props.ValidateComputed(); err != nil {
+		t.Errorf("properties %v should be valid, error %v", props, err)
+	} else if err := (PropertyList{{Name: "x", Value: "${notregistered}"}}).ValidateComputed(); err == nil {
+		t.Errorf("a property that refers to an unregistered provider should not be valid")
+	}
+
+	// The expression is satisfied by the computed value, and the agbot assumes it is satisfied before evaluation.
+	rp := create_RP(`{"and":[{"name":"freeDisk", "value":10, "op":">="}, {"name":"color", "value":"red"}]}`, t)
+	if err := rp.IsSatisfiedBy(props); err != nil {
+		t.Errorf("unevaluated computed property should satisfy the expression, error %v", err)
+	} else if evaluated, err := props.EvaluateComputed(); err != nil {
+		t.Errorf("unable to evaluate properties: %v", err)
+	} else if evaluated[0].Value != float64(5) || evaluated[1].Value != "red" || props[0].Value != "${testDiskGB}" {
+		t.Errorf("wrong evaluated properties %v", evaluated)
+	} else if err := rp.IsSatisfiedBy(evaluated); err == nil {
+		t.Errorf("evaluated properties %v should not satisfy the expression", evaluated)
+	}
+
+	disk.value = 20
+	if evaluated, err := props.EvaluateComputed(); err != nil {
+		t.Errorf("unable to evaluate properties: %v", err)
+	} else if err := rp.IsSatisfiedBy(evaluated); err != nil {
+		t.Errorf("evaluated properties %v should satisfy the expression, error %v", evaluated, err)
+	}
+
+	disk.err = errors.New("disk is gone")
+	if _, err := props.EvaluateComputed(); err == nil {
+		t.Errorf("a provider error should fail the evaluation")
+	}
+
+	disk.err = nil
+	disk.value = []string{"unsupported"}
+	if _, err := props.EvaluateComputed(); err == nil {
+		t.Errorf("an unsupported value type should fail the evaluation")
+	}
+}
+
+func Test_BatteryProvider(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "power_supply")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := &BatteryProvider{PowerSupplyDir: path.Join(dir, "missing")}
+	if v, err := p.Value(); err != nil || v != false {
+		t.Errorf("node without power supplies should not be battery powered, %v %v", v, err)
+	}
+
+	os.MkdirAll(path.Join(dir, "AC"), 0755)
+	ioutil.WriteFile(path.Join(dir, "AC", "type"), []byte("Mains\n"), 0644)
+	p.PowerSupplyDir = dir
+	if v, err := p.Value(); err != nil || v != false {
+		t.Errorf("node with mains power should not be battery powered, %v %v", v, err)
+	}
+
+	os.MkdirAll(path.Join(dir, "BAT0"), 0755)
+	ioutil.WriteFile(path.Join(dir, "BAT0", "type"), []byte("Battery\n"), 0644)
+	if v, err := p.Value(); err != nil || v != true {
+		t.Errorf("node with a battery should be battery powered, %v %v", v, err)
+	}
+}
@@ -0,0 +1,561 @@
+package policy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The purpose of this file is to support constraint expressions, a textual form of the RequiredProperty expression
+// that is easier to write and to read than the nested and/or json form. For example:
+//
+// "constraints": "arch == amd64 AND (memoryMB >= 1024 OR gpu) AND NOT location in [factory1, factory2]"
+//
+// An expression is made of comparisons of a property with a value, combined with AND, OR, NOT and parentheses. NOT
+// binds tighter than AND, and AND binds tighter than OR. The comparisons are:
+//
+// name == value or name != value, for numbers, booleans and strings. A single = is the same as ==.
+// name < value, name <= value, name > value and name >= value, for numbers and for versions like 1.2.3.
+// name in [value1, value2, ...], when the property is equal to one of the values.
+// name within [1.0.0,2.0.0), when the property is a version in the range. The range has the same syntax as the
+// version ranges of API specs, and a single version means that version or higher.
+// name alone, when the boolean property is true.
+//
+// Values are numbers, true or false, words such as amd64 or 1.2.3, or strings in double quotes. A value that is not
+// quoted is compared as a string with a property that is a string, so 1.4 is a version or a number depending on the
+// property. The keywords are not case sensitive, and &&, || and ! can be used instead of AND, OR and NOT. A comparison with a property that the
+// counterparty does not have is false. A comparison with a computed property can only be evaluated on the node, the
+// agbot leaves the decision to the node the same way it does for the json form.
+//
+// The expression is held in a RequiredProperty as {"expression": "..."}, so that it can be merged with and nested in
+// the json form, and the agbot and the node evaluate it the same way.
+
+const CONSTRAINT_EXPRESSION = "expression"
+
+// The error returned for an expression that can't be parsed. The position is the character in the expression where
+// the error was found, starting at 1.
+type ConstraintSyntaxError struct {
+	Expression string
+	Position   int
+	Msg        string
+}
+
+func (e *ConstraintSyntaxError) Error() string {
+	return fmt.Sprintf("constraint expression %q has an error at position %v: %v", e.Expression, e.Position, e.Msg)
+}
+
+type ConstraintExpression struct {
+	text string
+	root constraintNode
+}
+
+func (c *ConstraintExpression) String() string {
+	return c.text
+}
+
+// Parse a constraint expression.
+func ParseConstraintExpression(text string) (*ConstraintExpression, error) {
+	tokens, err := tokenizeConstraint(text)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &constraintParser{text: text, tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	} else if tok := p.peek(); tok.kind != tokEnd {
+		return nil, p.errorAt(tok, "expected AND, OR or the end of the expression, found %v", tok.describe())
+	}
+	return &ConstraintExpression{text: text, root: root}, nil
+}
+
+// Returns nil if the properties satisfy the expression, or a CompatibilityError naming a property that does not.
+func (c *ConstraintExpression) IsSatisfiedBy(props []Property) error {
+	if result, name := c.root.evaluate(props); result == constraintFalse {
+		return &CompatibilityError{
+			Property: name,
+			Msg:      fmt.Sprintf("Properties %v do not satisfy constraint expression %v\n", props, c.text),
+		}
+	}
+	return nil
+}
+
+// ========================================================================================================
+// The evaluation of an expression. A comparison with a computed property is unknown until the node evaluates it, so
+// the result of an expression is false, unknown or true. Unknown is treated as satisfied.
+
+type constraintResult int
+
+const (
+	constraintFalse constraintResult = iota
+	constraintUnknown
+	constraintTrue
+)
+
+type constraintNode interface {
+	evaluate(props []Property) (constraintResult, string) // the result, and the name of the property that decided it
+}
+
+type constraintOr struct {
+	left  constraintNode
+	right constraintNode
+}
+
+func (n *constraintOr) evaluate(props []Property) (constraintResult, string) {
+	left, name := n.left.evaluate(props)
+	if left == constraintTrue {
+		return left, name
+	}
+	right, rightName := n.right.evaluate(props)
+	if right > left {
+		return right, rightName
+	}
+	return left, name
+}
+
+type constraintAnd struct {
+	left  constraintNode
+	right constraintNode
+}
+
+func (n *constraintAnd) evaluate(props []Property) (constraintResult, string) {
+	left, name := n.left.evaluate(props)
+	if left == constraintFalse {
+		return left, name
+	}
+	right, rightName := n.right.evaluate(props)
+	if right < left {
+		return right, rightName
+	}
+	return left, name
+}
+
+type constraintNot struct {
+	operand constraintNode
+}
+
+func (n *constraintNot) evaluate(props []Property) (constraintResult, string) {
+	result, name := n.operand.evaluate(props)
+	return constraintTrue - result, name
+}
+
+type constraintComparison struct {
+	name     string
+	op       string              // one of the comparison operators, or the empty string for in and within
+	values   []interface{}       // the value to compare with, or the values of an in list
+	words    []string            // the text of the values that were not quoted, or the empty string
+	versions *Version_Expression // the version range of within
+}
+
+func (n *constraintComparison) evaluate(props []Property) (constraintResult, string) {
+	for _, p := range props {
+		if p.Name != n.name {
+			continue
+		} else if ComputedPropertyProvider(p.Value) != "" {
+			return constraintUnknown, n.name
+		} else if n.versions != nil {
+			if s, ok := p.Value.(string); ok {
+				if within, err := n.versions.Is_within_range(s); err == nil && within {
+					return constraintTrue, n.name
+				}
+			}
+			return constraintFalse, n.name
+		}
+
+		op := n.op
+		if op == "" {
+			op = equalto
+		}
+		for ix, v := range n.values {
+			// A value that is not quoted compares with a string property as a string, so that versions like 1.4
+			// and numbers that a node advertises as strings can be written without quotes.
+			if _, ok := p.Value.(string); ok && n.words[ix] != "" {
+				v = n.words[ix]
+			}
+			if compareValues(p.Value, op, v) {
+				return constraintTrue, n.name
+			}
+		}
+		return constraintFalse, n.name
+	}
+	return constraintFalse, n.name
+}
+
+// Compare a property value with the value of an expression. This is the comparison of both the json form and the
+// constraint expressions. Numbers are compared as numbers and versions as versions, the other strings and the
+// booleans can only be compared for equality. Values of different types are never equal.
+func compareValues(value interface{}, op string, expected interface{}) bool {
+	switch v := value.(type) {
+	case float64:
+		if e, ok := expected.(float64); ok {
+			switch op {
+			case lessthan:
+				return v < e
+			case greaterthan:
+				return v > e
+			case lessthaneq:
+				return v <= e
+			case greaterthaneq:
+				return v >= e
+			case notequalto:
+				return v != e
+			default:
+				return v == e
+			}
+		}
+	case bool:
+		if e, ok := expected.(bool); ok {
+			if op == notequalto {
+				return v != e
+			} else if op == equalto {
+				return v == e
+			}
+		}
+	case string:
+		if e, ok := expected.(string); ok {
+			if op == notequalto {
+				return v != e
+			} else if op == equalto {
+				return v == e
+			} else if !IsVersionString(v) || !IsVersionString(e) {
+				return false
+			} else if c, err := CompareVersions(v, e); err != nil {
+				return false
+			} else {
+				switch op {
+				case lessthan:
+					return c < 0
+				case greaterthan:
+					return c > 0
+				case lessthaneq:
+					return c <= 0
+				case greaterthaneq:
+					return c >= 0
+				}
+			}
+		}
+	}
+	return false
+}
+
+// ========================================================================================================
+// The tokenizer.
+
+const (
+	tokEnd = iota
+	tokWord
+	tokString
+	tokOp
+	tokAnd
+	tokOr
+	tokNot
+	tokIn
+	tokWithin
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+	tokComma
+)
+
+type constraintToken struct {
+	kind int
+	text string // the text of the token, the content of a string without the quotes
+	pos  int    // the byte offset of the token in the expression
+}
+
+func (t constraintToken) describe() string {
+	switch t.kind {
+	case tokEnd:
+		return "the end of the expression"
+	case tokString:
+		return fmt.Sprintf("string %q", t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+var constraintKeywords = map[string]int{
+	"and":    tokAnd,
+	"or":     tokOr,
+	"not":    tokNot,
+	"in":     tokIn,
+	"within": tokWithin,
+}
+
+// The characters of property names and of values that are not quoted.
+func isConstraintWordChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || strings.IndexByte("_.-+/:", c) >= 0
+}
+
+func constraintSyntaxError(text string, offset int, format string, args ...interface{}) error {
+	return &ConstraintSyntaxError{
+		Expression: text,
+		Position:   utf8.RuneCountInString(text[:offset]) + 1,
+		Msg:        fmt.Sprintf(format, args...),
+	}
+}
+
+func tokenizeConstraint(text string) ([]constraintToken, error) {
+	tokens := make([]constraintToken, 0, 10)
+	for i := 0; i < len(text); {
+		c := text[i]
+		two := ""
+		if i+1 < len(text) {
+			two = text[i : i+2]
+		}
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case two == "&&":
+			tokens = append(tokens, constraintToken{kind: tokAnd, text: two, pos: i})
+			i += 2
+		case two == "||":
+			tokens = append(tokens, constraintToken{kind: tokOr, text: two, pos: i})
+			i += 2
+		case two == "==" || two == "!=" || two == "<=" || two == ">=":
+			tokens = append(tokens, constraintToken{kind: tokOp, text: two, pos: i})
+			i += 2
+		case c == '=' || c == '<' || c == '>':
+			tokens = append(tokens, constraintToken{kind: tokOp, text: string(c), pos: i})
+			i++
+		case c == '!':
+			tokens = append(tokens, constraintToken{kind: tokNot, text: "!", pos: i})
+			i++
+		case c == '(':
+			tokens = append(tokens, constraintToken{kind: tokLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, constraintToken{kind: tokRParen, text: ")", pos: i})
+			i++
+		case c == '[':
+			tokens = append(tokens, constraintToken{kind: tokLBracket, text: "[", pos: i})
+			i++
+		case c == ']':
+			tokens = append(tokens, constraintToken{kind: tokRBracket, text: "]", pos: i})
+			i++
+		case c == ',':
+			tokens = append(tokens, constraintToken{kind: tokComma, text: ",", pos: i})
+			i++
+		case c == '"':
+			value := make([]byte, 0, 10)
+			j := i + 1
+			for ; j < len(text) && text[j] != '"'; j++ {
+				if text[j] == '\\' && j+1 < len(text) {
+					j++
+				}
+				value = append(value, text[j])
+			}
+			if j == len(text) {
+				return nil, constraintSyntaxError(text, i, "the string is not terminated with a double quote")
+			}
+			tokens = append(tokens, constraintToken{kind: tokString, text: string(value), pos: i})
+			i = j + 1
+		case isConstraintWordChar(c):
+			j := i
+			for j < len(text) && isConstraintWordChar(text[j]) {
+				j++
+			}
+			word := text[i:j]
+			if kind, ok := constraintKeywords[strings.ToLower(word)]; ok {
+				tokens = append(tokens, constraintToken{kind: kind, text: word, pos: i})
+			} else {
+				tokens = append(tokens, constraintToken{kind: tokWord, text: word, pos: i})
+			}
+			i = j
+		case c == '&' || c == '|':
+			return nil, constraintSyntaxError(text, i, "%q is not an operator, use %q", string(c), strings.Repeat(string(c), 2))
+		default:
+			r, _ := utf8.DecodeRuneInString(text[i:])
+			return nil, constraintSyntaxError(text, i, "unexpected character %q", r)
+		}
+	}
+	return append(tokens, constraintToken{kind: tokEnd, pos: len(text)}), nil
+}
+
+// ========================================================================================================
+// The parser, a recursive descent parser with one function per precedence level.
+
+type constraintParser struct {
+	text   string
+	tokens []constraintToken
+	next   int
+}
+
+func (p *constraintParser) peek() constraintToken {
+	return p.tokens[p.next]
+}
+
+func (p *constraintParser) take() constraintToken {
+	tok := p.tokens[p.next]
+	if tok.kind != tokEnd {
+		p.next++
+	}
+	return tok
+}
+
+func (p *constraintParser) errorAt(tok constraintToken, format string, args ...interface{}) error {
+	return constraintSyntaxError(p.text, tok.pos, format, args...)
+}
+
+func (p *constraintParser) parseOr() (constraintNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOr {
+		p.take()
+		if right, err := p.parseAnd(); err != nil {
+			return nil, err
+		} else {
+			left = &constraintOr{left: left, right: right}
+		}
+	}
+	return left, nil
+}
+
+func (p *constraintParser) parseAnd() (constraintNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokAnd {
+		p.take()
+		if right, err := p.parseNot(); err != nil {
+			return nil, err
+		} else {
+			left = &constraintAnd{left: left, right: right}
+		}
+	}
+	return left, nil
+}
+
+func (p *constraintParser) parseNot() (constraintNode, error) {
+	if p.peek().kind == tokNot {
+		p.take()
+		if operand, err := p.parseNot(); err != nil {
+			return nil, err
+		} else {
+			return &constraintNot{operand: operand}, nil
+		}
+	}
+	return p.parsePrimary()
+}
+
+func (p *constraintParser) parsePrimary() (constraintNode, error) {
+	tok := p.take()
+	switch tok.kind {
+	case tokLParen:
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		} else if closing := p.take(); closing.kind != tokRParen {
+			return nil, p.errorAt(closing, "expected ) to close the ( at position %v, found %v", utf8.RuneCountInString(p.text[:tok.pos])+1, closing.describe())
+		}
+		return node, nil
+	case tokWord:
+		return p.parseComparison(tok)
+	default:
+		return nil, p.errorAt(tok, "expected a property name, NOT or (, found %v", tok.describe())
+	}
+}
+
+// Parse the rest of a comparison, after the property name.
+func (p *constraintParser) parseComparison(name constraintToken) (constraintNode, error) {
+	switch tok := p.peek(); tok.kind {
+	case tokOp:
+		p.take()
+		op := tok.text
+		if op == "==" {
+			op = equalto
+		}
+		if value, word, err := p.parseValue(); err != nil {
+			return nil, err
+		} else {
+			return &constraintComparison{name: name.text, op: op, values: []interface{}{value}, words: []string{word}}, nil
+		}
+
+	case tokIn:
+		p.take()
+		if open := p.take(); open.kind != tokLBracket {
+			return nil, p.errorAt(open, "expected [ to start the list of values after in, found %v", open.describe())
+		}
+		values := make([]interface{}, 0, 5)
+		words := make([]string, 0, 5)
+		for {
+			value, word, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			words = append(words, word)
+			if sep := p.take(); sep.kind == tokRBracket {
+				break
+			} else if sep.kind != tokComma {
+				return nil, p.errorAt(sep, "expected , or ] in the list of values, found %v", sep.describe())
+			}
+		}
+		return &constraintComparison{name: name.text, values: values, words: words}, nil
+
+	case tokWithin:
+		p.take()
+		return p.parseRange(name)
+
+	default:
+		// A property name alone is true when the boolean property is true.
+		return &constraintComparison{name: name.text, op: equalto, values: []interface{}{true}, words: []string{""}}, nil
+	}
+}
+
+// Parse a value, and return the text of the value when it is not quoted. Words that are numbers or booleans are
+// numbers or booleans, strings in quotes are always strings.
+func (p *constraintParser) parseValue() (interface{}, string, error) {
+	tok := p.take()
+	switch tok.kind {
+	case tokString:
+		return tok.text, "", nil
+	case tokWord:
+		if f, err := strconv.ParseFloat(tok.text, 64); err == nil {
+			return f, tok.text, nil
+		} else if strings.EqualFold(tok.text, "true") {
+			return true, tok.text, nil
+		} else if strings.EqualFold(tok.text, "false") {
+			return false, tok.text, nil
+		}
+		return tok.text, tok.text, nil
+	default:
+		return nil, "", p.errorAt(tok, "expected a value, found %v", tok.describe())
+	}
+}
+
+// Parse the version range after within, a range like [1.0.0,2.0.0), a single version, or either in quotes.
+func (p *constraintParser) parseRange(name constraintToken) (constraintNode, error) {
+	start := p.peek()
+	text := ""
+	switch start.kind {
+	case tokString, tokWord:
+		p.take()
+		text = start.text
+	case tokLBracket, tokLParen:
+		for _, kinds := range [][]int{{tokLBracket, tokLParen}, {tokWord}, {tokComma}, {tokWord}, {tokRBracket, tokRParen}} {
+			tok := p.take()
+			if tok.kind != kinds[0] && (len(kinds) == 1 || tok.kind != kinds[1]) {
+				return nil, p.errorAt(tok, "expected a version range like [1.0.0,2.0.0) after within, found %v", tok.describe())
+			}
+			text += tok.text
+		}
+	default:
+		return nil, p.errorAt(start, "expected a version range like [1.0.0,2.0.0) after within, found %v", start.describe())
+	}
+
+	if versions, err := Version_Expression_Factory(text); err != nil {
+		return nil, p.errorAt(start, "%v is not a valid version range, error: %v", text, err)
+	} else if c, err := CompareVersions(versions.Get_start_version(), versions.Get_end_version()); err != nil || c > 0 {
+		return nil, p.errorAt(start, "%v is not a valid version range, the start version is higher than the end version", text)
+	} else {
+		return &constraintComparison{name: name.text, versions: versions}, nil
+	}
+}
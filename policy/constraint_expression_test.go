@@ -0,0 +1,165 @@
+// +build unit
+
+package policy
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func Test_ConstraintExpression_satisfied(t *testing.T) {
+
+	props := []Property{
+		{Name: "arch", Value: "amd64"},
+		{Name: "memoryMB", Value: float64(2048)},
+		{Name: "gpu", Value: false},
+		{Name: "location", Value: "office 2"},
+		{Name: "firmware", Value: "1.4.2"},
+		{Name: "freeDiskGB", Value: "${freeDiskGB}"},
+	}
+
+	tests := []struct {
+		expression string
+		satisfied  bool
+	}{
+		{`arch == amd64`, true},
+		{`arch = amd64`, true},
+		{`arch != amd64`, false},
+		{`arch == "amd64"`, true},
+		{`memoryMB >= 1024`, true},
+		{`memoryMB < 1024`, false},
+		{`memoryMB == 2048 AND arch == amd64`, true},
+		{`memoryMB == 2048 AND arch == arm`, false},
+		{`arch == arm OR memoryMB > 1000`, true},
+		{`arch == arm or memoryMB < 1000`, false},
+		{`gpu`, false},
+		{`NOT gpu`, true},
+		{`!gpu && arch == amd64`, true},
+		{`gpu == false`, true},
+		{`arch == arm OR arch == amd64 AND gpu`, false},
+		{`(arch == arm OR arch == amd64) AND NOT gpu`, true},
+		{`arch in [arm, arm64, amd64]`, true},
+		{`arch in [arm, arm64]`, false},
+		{`NOT location in ["office 1", "office 2"]`, false},
+		{`memoryMB in [1024, 2048]`, true},
+		{`firmware within [1.0.0,2.0.0)`, true},
+		{`firmware within (1.4.2,2.0.0)`, false},
+		{`firmware within "[1.4,INFINITY)"`, true},
+		{`firmware within 1.5`, false},
+		{`firmware >= 1.4`, true},
+		{`firmware < 1.10`, true},
+		{`location > 1.0`, false},
+		{`missing == 1`, false},
+		{`NOT missing == 1`, true},
+		{`freeDiskGB > 10`, true},
+		{`NOT freeDiskGB > 10`, true},
+		{`freeDiskGB > 10 AND arch == arm`, false},
+	}
+
+	for _, test := range tests {
+		if exp, err := ParseConstraintExpression(test.expression); err != nil {
+			t.Errorf("unexpected error parsing %v: %v", test.expression, err)
+		} else if err := exp.IsSatisfiedBy(props); test.satisfied && err != nil {
+			t.Errorf("%v should be satisfied, error: %v", test.expression, err)
+		} else if !test.satisfied && err == nil {
+			t.Errorf("%v should not be satisfied", test.expression)
+		}
+	}
+
+	exp, _ := ParseConstraintExpression(`memoryMB > 1024 AND arch == arm`)
+	if err := exp.IsSatisfiedBy(props); err == nil {
+		t.Errorf("expected an error")
+	} else if ce, ok := err.(*CompatibilityError); !ok || ce.Property != "arch" {
+		t.Errorf("expected a compatibility error for property arch, got %v", err)
+	}
+}
+
+func Test_ConstraintExpression_syntax_errors(t *testing.T) {
+
+	tests := []struct {
+		expression string
+		position   int
+	}{
+		{``, 1},
+		{`arch ==`, 8},
+		{`arch == amd64 AND`, 18},
+		{`arch == amd64 amd64`, 15},
+		{`(arch == amd64`, 15},
+		{`arch == amd64)`, 14},
+		{`arch & gpu`, 6},
+		{`arch == "amd64`, 9},
+		{`arch in arm`, 9},
+		{`arch in [arm arm64]`, 14},
+		{`firmware within [1.0.0,2.0.0`, 29},
+		{`firmware within [2.0.0,1.0.0)`, 17},
+		{`arch == amd64 AND ? == 1`, 19},
+		{`== 1`, 1},
+	}
+
+	for _, test := range tests {
+		if _, err := ParseConstraintExpression(test.expression); err == nil {
+			t.Errorf("expected an error parsing %v", test.expression)
+		} else if se, ok := err.(*ConstraintSyntaxError); !ok {
+			t.Errorf("expected a syntax error parsing %v, got %v", test.expression, err)
+		} else if se.Position != test.position {
+			t.Errorf("expected the error parsing %v at position %v, got %v", test.expression, test.position, err)
+		}
+	}
+}
+
+func Test_ConstraintExpression_RequiredProperty(t *testing.T) {
+
+	props := []Property{
+		{Name: "arch", Value: "amd64"},
+		{Name: "memoryMB", Value: float64(2048)},
+	}
+
+	// A json string is a constraint expression.
+	rp := new(RequiredProperty)
+	if err := json.Unmarshal([]byte(`"arch == amd64 AND memoryMB >= 1024"`), rp); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if (*rp)[CONSTRAINT_EXPRESSION] != "arch == amd64 AND memoryMB >= 1024" {
+		t.Errorf("expected the expression in the RequiredProperty, got %v", *rp)
+	} else if err := rp.IsValid(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if err := rp.IsSatisfiedBy(props); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// The json form still works, and can hold expressions.
+	if rp = create_RP(`{"and":[{"name":"arch", "value":"amd64"}, {"expression": "memoryMB > 4096"}]}`, t); rp != nil {
+		if err := rp.IsValid(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		} else if err := rp.IsSatisfiedBy(props); err == nil {
+			t.Errorf("expected memoryMB > 4096 to fail")
+		}
+	}
+
+	// An expression merges with the json form.
+	rp1 := create_RP(`{"or":[{"name":"arch", "value":"arm"}, {"name":"arch", "value":"amd64"}]}`, t)
+	rp2 := create_RP(`"memoryMB >= 1024"`, t)
+	if rp1 != nil && rp2 != nil {
+		if err := rp1.Merge(rp2).IsSatisfiedBy(props); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	// A syntax error is reported by IsValid.
+	if rp = create_RP(`{"expression": "arch == "}`, t); rp != nil {
+		if err := rp.IsValid(); err == nil {
+			t.Errorf("expected a syntax error")
+		} else if _, ok := err.(*ConstraintSyntaxError); !ok {
+			t.Errorf("expected a syntax error, got %v", err)
+		}
+	}
+	if rp = create_RP(`{"expression": 5}`, t); rp != nil {
+		if err := rp.IsValid(); err == nil {
+			t.Errorf("expected an error for an expression that is not a string")
+		}
+	}
+
+	// An empty string is no constraint.
+	if rp = create_RP(`""`, t); rp != nil && len(*rp) != 0 {
+		t.Errorf("expected an empty RequiredProperty, got %v", *rp)
+	}
+}
@@ -1,6 +1,7 @@
 package policy
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 )
@@ -24,6 +25,9 @@ import (
 // The "=" and "!=" comparison operators can be applied to strings and integers.
 // If the "op" key is missing, then equal is assumed.
 //
+// A constraint expression can be used instead of, or inside, the and/or form, as "expression": "..." (see
+// constraint_expression.go). A json string is also accepted as a RequiredProperty and holds a constraint expression.
+//
 // See the unit tests for examples of valid and invalid syntax
 //
 
@@ -41,6 +45,26 @@ func RequiredProperty_Factory() *RequiredProperty {
 	return rp
 }
 
+// Unmarshal either the json form of the expression, or a json string that holds a constraint expression.
+func (self *RequiredProperty) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		if text == "" {
+			(*self) = nil
+		} else {
+			(*self) = RequiredProperty{CONSTRAINT_EXPRESSION: text}
+		}
+		return nil
+	}
+
+	var exp map[string]interface{}
+	if err := json.Unmarshal(data, &exp); err != nil {
+		return err
+	}
+	(*self) = exp
+	return nil
+}
+
 // These are the comparison operators that are supported
 const lessthan = "<"
 const greaterthan = ">"
@@ -105,13 +129,24 @@ func (self *RequiredProperty) IsSatisfiedBy(props []Property) error {
 // recursively because control operators can be nested n levels deep.
 func (self *RequiredProperty) satisfied(cop *map[string]interface{}, props *[]Property) error {
 	controlOp := self.getControlOperator(cop)
-	if controlOp == and {
+	if controlOp == CONSTRAINT_EXPRESSION {
+
+		if exp, err := constraintExpressionValue((*cop)[controlOp]); err != nil {
+			return err
+		} else {
+			return exp.IsSatisfiedBy(*props)
+		}
+
+	} else if controlOp == and {
 
 		propArray := (*cop)[controlOp].([]interface{})
 		for _, p := range propArray {
 			if prop := isPropertyExpression(p); prop != nil {
 				if !propertyInArray(prop, props) {
-					return errors.New(fmt.Sprintf("Property %v with value %v not in %v\n", prop.Name, prop.Value, props))
+					return &CompatibilityError{
+						Property: prop.Name,
+						Msg:      fmt.Sprintf("Property %v with value %v not in %v\n", prop.Name, prop.Value, props),
+					}
 				}
 			} else if cop := isControlOp(p); cop != nil {
 				if err := self.satisfied(cop, props); err != nil {
@@ -186,6 +221,12 @@ func (self *RequiredProperty) verify(cop *map[string]interface{}) error {
 	// Iterate through the expression
 	controlOp := self.getControlOperator(cop)
 
+	// A constraint expression has to parse
+	if controlOp == CONSTRAINT_EXPRESSION {
+		_, err := constraintExpressionValue((*cop)[controlOp])
+		return err
+	}
+
 	// Ensure the control operator value is an array
 	if !isArray((*cop)[controlOp]) {
 		return errors.New(fmt.Sprintf("RequiredProperty Object not valid, control operator value is not an array, is %v", (*cop)[controlOp]))
@@ -251,7 +292,7 @@ func (self *RequiredProperty) getControlOperator(m *map[string]interface{}) stri
 // of the supported control operators.
 func controlOperators() map[string]int {
 	// return map[string]int {and:0, or:0, not:0}
-	return map[string]int{and: 0, or: 0}
+	return map[string]int{and: 0, or: 0, CONSTRAINT_EXPRESSION: 0}
 }
 
 // Return a map of comparison operators so that it's easy to check if a string is equivalent to one
@@ -322,6 +363,15 @@ func isControlOp(x interface{}) *map[string]interface{} {
 	}
 }
 
+// This function parses the value of an expression key, which must be a string holding a constraint expression.
+func constraintExpressionValue(x interface{}) (*ConstraintExpression, error) {
+	if text, ok := x.(string); !ok {
+		return nil, errors.New(fmt.Sprintf("RequiredProperty Object not valid, expression value is not a string, is %v", x))
+	} else {
+		return ParseConstraintExpression(text)
+	}
+}
+
 // This function checks the type of the input interface object to see if it's an array. The value of a
 // Control operator is always an array.
 func isArray(x interface{}) bool {
@@ -379,38 +429,12 @@ func propertyInArray(propexp *PropertyExpression, props *[]Property) bool {
 		if p.Name != propexp.Name {
 			// These are not the droids we're looking for
 			continue
+		} else if ComputedPropertyProvider(p.Value) != "" {
+			// The value of a computed property is only known on the node, so it is assumed to satisfy the
+			// expression here. The node checks it again with the current value before accepting an agreement.
+			return true
 		} else {
-			if isFloat64(p.Value) && isFloat64(propexp.Value) {
-				if propexp.Op == lessthan {
-					return p.Value.(float64) < propexp.Value.(float64)
-				} else if propexp.Op == greaterthan {
-					return p.Value.(float64) > propexp.Value.(float64)
-				} else if propexp.Op == lessthaneq {
-					return p.Value.(float64) <= propexp.Value.(float64)
-				} else if propexp.Op == greaterthaneq {
-					return p.Value.(float64) >= propexp.Value.(float64)
-				} else if propexp.Op == notequalto {
-					return p.Value.(float64) != propexp.Value.(float64)
-				} else {
-					return p.Value.(float64) == propexp.Value.(float64)
-				}
-			} else if isBoolean(p.Value) && isBoolean(propexp.Value) {
-				if _, ok := stringOperators()[propexp.Op]; !ok {
-					return false
-				} else if propexp.Op == notequalto {
-					return p.Value.(bool) != propexp.Value.(bool)
-				} else if propexp.Op == equalto {
-					return p.Value.(bool) == propexp.Value.(bool)
-				}
-			} else if isString(p.Value) && isString(propexp.Value) {
-				if _, ok := stringOperators()[propexp.Op]; !ok {
-					return false
-				} else if propexp.Op == notequalto {
-					return p.Value.(string) != propexp.Value.(string)
-				} else {
-					return p.Value.(string) == propexp.Value.(string)
-				}
-			}
+			return compareValues(p.Value, propexp.Op, propexp.Value)
 		}
 	}
 	return false
@@ -220,8 +220,16 @@ func maxOfUint64(x uint64, y uint64) uint64 {
 	}
 }
 
+// The ways that an agbot can verify that data is being received from the nodes in its agreements.
+const (
+	DATA_VERIFICATION_HTTP     = "http"     // poll the URL for the agreements that are sending data, the default
+	DATA_VERIFICATION_EXCHANGE = "exchange" // the data ingest system records when it last received data on the agbot's agreement in the exchange
+	DATA_VERIFICATION_WEBHOOK  = "webhook"  // the data ingest system tells the agbot API when it receives data for an agreement
+)
+
 type DataVerification struct {
 	Enabled     bool   `json:"enabled,omitempty"`     // Whether or not data verification is enabled
+	Type        string `json:"type,omitempty"`        // How the data is verified, one of the DATA_VERIFICATION_ types, http when empty
 	URL         string `json:"URL,omitempty"`         // The URL to be used for data receipt verification
 	URLUser     string `json:"URLUser,omitempty"`     // The user id to use when calling the verification URL
 	URLPassword string `json:"URLPassword,omitempty"` // The password to use when calling the verification URL
@@ -243,9 +251,19 @@ func DataVerification_Factory(url string, urluser string, urlpw string, interval
 	return d
 }
 
+// The way the data is verified, with the default filled in.
+func (d DataVerification) VerificationType() string {
+	if d.Type == "" {
+		return DATA_VERIFICATION_HTTP
+	}
+	return d.Type
+}
+
 func (d DataVerification) IsValid() (bool, error) {
 	if !d.Metering.IsValid() {
 		return false, errors.New(fmt.Sprintf("Metering is not valid"))
+	} else if t := d.VerificationType(); t != DATA_VERIFICATION_HTTP && t != DATA_VERIFICATION_EXCHANGE && t != DATA_VERIFICATION_WEBHOOK {
+		return false, errors.New(fmt.Sprintf("Type %v is not supported, use %v, %v or %v", d.Type, DATA_VERIFICATION_HTTP, DATA_VERIFICATION_EXCHANGE, DATA_VERIFICATION_WEBHOOK))
 	} else if d.Interval != 0 && d.CheckRate != 0 && d.Interval < d.CheckRate {
 		return false, errors.New(fmt.Sprintf("Interval is shorter than check rate"))
 	}
@@ -254,6 +272,7 @@ func (d DataVerification) IsValid() (bool, error) {
 
 func (d DataVerification) IsSame(compare DataVerification) bool {
 	return d.Enabled == compare.Enabled &&
+		d.VerificationType() == compare.VerificationType() &&
 		d.URL == compare.URL &&
 		d.URLUser == compare.URLUser &&
 		d.Interval == compare.Interval &&
@@ -262,7 +281,7 @@ func (d DataVerification) IsSame(compare DataVerification) bool {
 }
 
 func (d DataVerification) String() string {
-	return fmt.Sprintf("Enabled: %v, URL: %v, URL User: %v, Interval: %v, CheckRate: %v, Metering: %v", d.Enabled, d.URL, d.URLUser, d.Interval, d.CheckRate, d.Metering)
+	return fmt.Sprintf("Enabled: %v, Type: %v, URL: %v, URL User: %v, Interval: %v, CheckRate: %v, Metering: %v", d.Enabled, d.VerificationType(), d.URL, d.URLUser, d.Interval, d.CheckRate, d.Metering)
 }
 
 func (d *DataVerification) Obscure() {
@@ -283,9 +302,10 @@ func (d DataVerification) IsCompatibleWith(compare DataVerification) bool {
 
 func (d *DataVerification) internalCompatibleWith(compare *DataVerification) bool {
 	// single out the case where 2 DV sections are not compatible; both sections are
-	// enabled they want to use different URLs and/or Users to verify. That difference
+	// enabled they want to use different ways, URLs and/or Users to verify. That difference
 	// cannot be reconciled and therefore the sections are incompatible.
-	if (d.Enabled && compare.Enabled && d.URL != "" && compare.URL != "" && d.URL != compare.URL) ||
+	if (d.Enabled && compare.Enabled && d.Type != "" && compare.Type != "" && d.Type != compare.Type) ||
+		(d.Enabled && compare.Enabled && d.URL != "" && compare.URL != "" && d.URL != compare.URL) ||
 		(d.Enabled && compare.Enabled && d.URLUser != "" && compare.URLUser != "" && d.URLUser != compare.URLUser) {
 		return false
 	}
@@ -367,8 +387,14 @@ func (d DataVerification) ProducerMergeWith(other DataVerification, configInterv
 		ret.Enabled = true
 	}
 
-	// If there is a URL and User in one of the policies, use it. If there is a URL or User
-	// in both, they will be the same because a previous compat check is assumed.
+	// If there is a Type, URL and User in one of the policies, use it. If there is a Type, URL
+	// or User in both, they will be the same because a previous compat check is assumed.
+	if d.Enabled && d.Type != "" {
+		ret.Type = d.Type
+	} else if other.Enabled && other.Type != "" {
+		ret.Type = other.Type
+	}
+
 	if d.Enabled && d.URL != "" {
 		ret.URL = d.URL
 	} else if other.Enabled && other.URL != "" {
@@ -408,8 +434,14 @@ func (d DataVerification) MergeWith(other DataVerification, configInterval uint6
 		ret.Enabled = true
 	}
 
-	// If there is a URL and User in one of the policies, use it. If there is a URL or User
-	// in both, they will be the same because a previous compat check is assumed.
+	// If there is a Type, URL and User in one of the policies, use it. If there is a Type, URL
+	// or User in both, they will be the same because a previous compat check is assumed.
+	if d.Enabled && d.Type != "" {
+		ret.Type = d.Type
+	} else if other.Enabled && other.Type != "" {
+		ret.Type = other.Type
+	}
+
 	if d.Enabled && d.URL != "" {
 		ret.URL = d.URL
 	} else if other.Enabled && other.URL != "" {
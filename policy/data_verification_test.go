@@ -167,6 +167,40 @@ func Test_dv_compatwith(t *testing.T) {
 
 }
 
+func Test_dv_type(t *testing.T) {
+
+	dv1 := `{"enabled":true,"type":"webhook","URLUser":"me","URLPassword":"mysecret","interval":300}`
+	dv2 := `{"enabled":true,"interval":0}`
+	dv3 := `{"enabled":true,"type":"exchange","interval":0}`
+	if dva := create_DataVerification(dv1, t); dva != nil {
+		if dvb := create_DataVerification(dv2, t); dvb != nil {
+			if !dva.IsCompatibleWith(*dvb) {
+				t.Errorf("DV section %v is compatible with %v\n", dva, dvb)
+			} else if merged := dvb.MergeWith(*dva, 600); merged.VerificationType() != DATA_VERIFICATION_WEBHOOK {
+				t.Errorf("merged DV section %v should use webhook data verification\n", merged)
+			} else if merged := dvb.ProducerMergeWith(*dva, 600); merged.VerificationType() != DATA_VERIFICATION_WEBHOOK {
+				t.Errorf("producer merged DV section %v should use webhook data verification\n", merged)
+			} else if dvb.VerificationType() != DATA_VERIFICATION_HTTP {
+				t.Errorf("DV section %v without a type should use http data verification\n", dvb)
+			}
+		}
+		if dvc := create_DataVerification(dv3, t); dvc != nil {
+			if dva.IsCompatibleWith(*dvc) || dva.IsProducerCompatible(*dvc) {
+				t.Errorf("DV section %v is not compatible with %v\n", dva, dvc)
+			} else if dva.IsSame(*dvc) {
+				t.Errorf("DV section %v is not the same as %v\n", dva, dvc)
+			}
+		}
+	}
+
+	if dv := create_DataVerification(`{"enabled":true,"type":"email"}`, t); dv != nil {
+		if ok, err := dv.IsValid(); ok || err == nil {
+			t.Errorf("DV section %v has an unsupported type\n", dv)
+		}
+	}
+
+}
+
 func Test_dv_mergewith(t *testing.T) {
 
 	dv1 := `{"enabled":true,"URL":"http://company.com/verify","URLUser":"me","URLPassword":"mysecret","interval":30,"metering":{"tokens":3,"per_time_unit":"min","notification_interval":25}}`
@@ -3,12 +3,13 @@ package policy
 import ()
 
 type NodeHealth struct {
-	MissingHBInterval    int `json:"missing_heartbeat_interval,omitempty"` // How long a heartbeat can be missing until it is considered missing (in seconds)
-	CheckAgreementStatus int `json:"check_agreement_status,omitempty"`     // How often to check that the node agreement entry still exists in the exchange (in seconds)
+	MissingHBInterval    int `json:"missing_heartbeat_interval,omitempty"`     // How long a heartbeat can be missing until it is considered missing (in seconds)
+	CheckAgreementStatus int `json:"check_agreement_status,omitempty"`         // How often to check that the node agreement entry still exists in the exchange (in seconds)
+	MissingHBGraceChecks int `json:"missing_heartbeat_grace_checks,omitempty"` // How many more checks the heartbeat can be missing on before the agreement is cancelled
 }
 
 func (h NodeHealth) IsSame(compare NodeHealth) bool {
-	return h.MissingHBInterval == compare.MissingHBInterval && h.CheckAgreementStatus == compare.CheckAgreementStatus
+	return h.MissingHBInterval == compare.MissingHBInterval && h.CheckAgreementStatus == compare.CheckAgreementStatus && h.MissingHBGraceChecks == compare.MissingHBGraceChecks
 }
 
 func NodeHealth_Factory(hbInterval int, checkRate int) *NodeHealth {
@@ -0,0 +1,125 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The purpose of this file is to abstract the operations on the Placement type. Placement constraints are specified
+// on the consumer side. They describe which other workloads must (co-location) or must not (anti-affinity) already be
+// running on a node before an agreement for the policy's workload can be made with that node. This allows a pipeline
+// of edge services to be spread across, or kept together on, a set of nodes.
+
+// A workload is identified by its URL and org, independent of version and arch.
+type PlacementWorkload struct {
+	URL string `json:"url"`
+	Org string `json:"org"`
+}
+
+func (w PlacementWorkload) String() string {
+	return fmt.Sprintf("%v/%v", w.Org, w.URL)
+}
+
+type Placement struct {
+	CoLocate     []PlacementWorkload `json:"colocate,omitempty"`     // All of these workloads must already be running on the node
+	AntiAffinity []PlacementWorkload `json:"antiAffinity,omitempty"` // None of these workloads can be running on the node
+}
+
+// This function creates Placement objects
+func Placement_Factory(coLocate []PlacementWorkload, antiAffinity []PlacementWorkload) *Placement {
+	p := new(Placement)
+	p.CoLocate = coLocate
+	p.AntiAffinity = antiAffinity
+
+	return p
+}
+
+func (p *Placement) String() string {
+	if p == nil {
+		return "none"
+	}
+	return fmt.Sprintf("CoLocate: %v, AntiAffinity: %v", p.CoLocate, p.AntiAffinity)
+}
+
+// A policy without a placement section has no placement constraints.
+func (p *Placement) IsEmpty() bool {
+	return p == nil || len(p.CoLocate) == 0 && len(p.AntiAffinity) == 0
+}
+
+// Return true if 2 Placements are the same. The workloads dont have to be in the same order in both lists.
+func (p *Placement) IsSame(compare *Placement) bool {
+	if p.IsEmpty() || compare.IsEmpty() {
+		return p.IsEmpty() && compare.IsEmpty()
+	}
+	return sameWorkloads(p.CoLocate, compare.CoLocate) && sameWorkloads(p.AntiAffinity, compare.AntiAffinity)
+}
+
+// A workload can't be required to be both on and off the same node.
+func (p *Placement) IsValid() error {
+	if p.IsEmpty() {
+		return nil
+	}
+	for _, wl := range p.CoLocate {
+		if wl.URL == "" || wl.Org == "" {
+			return errors.New(fmt.Sprintf("co-located workload %v must have a url and an org", wl))
+		} else if containsWorkload(p.AntiAffinity, wl) {
+			return errors.New(fmt.Sprintf("workload %v is both co-located and anti-affine", wl))
+		}
+	}
+	for _, wl := range p.AntiAffinity {
+		if wl.URL == "" || wl.Org == "" {
+			return errors.New(fmt.Sprintf("anti-affine workload %v must have a url and an org", wl))
+		}
+	}
+	return nil
+}
+
+// Check the placement constraints against the workloads that are running on a node. An error describing the first
+// unsatisfied constraint is returned. A nil error means the node satisfies all the constraints. A running workload
+// without an org matches a constraint on URL alone.
+func (p *Placement) Is_Satisfied_By(running []PlacementWorkload) error {
+	if p.IsEmpty() {
+		return nil
+	}
+	for _, wl := range p.CoLocate {
+		if !runningWorkload(running, wl) {
+			return errors.New(fmt.Sprintf("co-located workload %v is not running on the node", wl))
+		}
+	}
+	for _, wl := range p.AntiAffinity {
+		if runningWorkload(running, wl) {
+			return errors.New(fmt.Sprintf("anti-affine workload %v is running on the node", wl))
+		}
+	}
+	return nil
+}
+
+func containsWorkload(list []PlacementWorkload, wl PlacementWorkload) bool {
+	for _, item := range list {
+		if item.URL == wl.URL && item.Org == wl.Org {
+			return true
+		}
+	}
+	return false
+}
+
+func runningWorkload(running []PlacementWorkload, wl PlacementWorkload) bool {
+	for _, item := range running {
+		if item.URL == wl.URL && (item.Org == "" || item.Org == wl.Org) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameWorkloads(list1 []PlacementWorkload, list2 []PlacementWorkload) bool {
+	if len(list1) != len(list2) {
+		return false
+	}
+	for _, wl := range list1 {
+		if !containsWorkload(list2, wl) {
+			return false
+		}
+	}
+	return true
+}
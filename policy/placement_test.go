@@ -0,0 +1,81 @@
+// +build unit
+
+package policy
+
+import (
+	"testing"
+)
+
+func Test_placement_factory(t *testing.T) {
+
+	if p := Placement_Factory(nil, nil); p == nil {
+		t.Errorf("Factory returned nil, should not.")
+	} else if !p.IsEmpty() {
+		t.Errorf("Placement %v should be empty", p)
+	} else if str := p.String(); len(str) == 0 {
+		t.Errorf("no formatted output")
+	}
+
+}
+
+func Test_placement_issame(t *testing.T) {
+
+	a := PlacementWorkload{URL: "http://mydomain.com/a", Org: "myorg"}
+	b := PlacementWorkload{URL: "http://mydomain.com/b", Org: "myorg"}
+
+	p1 := Placement_Factory([]PlacementWorkload{a, b}, nil)
+	p2 := Placement_Factory([]PlacementWorkload{b, a}, []PlacementWorkload{})
+	p3 := Placement_Factory([]PlacementWorkload{a}, []PlacementWorkload{b})
+
+	if !p1.IsSame(p2) {
+		t.Errorf("Placements are not the same, should be %v and %v", p1, p2)
+	} else if p1.IsSame(p3) {
+		t.Errorf("Placements are the same, should not be %v and %v", p1, p3)
+	} else if p1.IsSame(nil) {
+		t.Errorf("Placements are the same, should not be %v and nil", p1)
+	} else if !Placement_Factory(nil, nil).IsSame(nil) {
+		t.Errorf("Empty placement should be the same as no placement")
+	}
+
+}
+
+func Test_placement_isvalid(t *testing.T) {
+
+	a := PlacementWorkload{URL: "http://mydomain.com/a", Org: "myorg"}
+	b := PlacementWorkload{URL: "http://mydomain.com/b", Org: "myorg"}
+
+	if err := Placement_Factory([]PlacementWorkload{a}, []PlacementWorkload{b}).IsValid(); err != nil {
+		t.Errorf("Placement should be valid, error: %v", err)
+	} else if err := Placement_Factory([]PlacementWorkload{a}, []PlacementWorkload{a}).IsValid(); err == nil {
+		t.Errorf("Placement with the same workload co-located and anti-affine should not be valid")
+	} else if err := Placement_Factory(nil, []PlacementWorkload{PlacementWorkload{URL: "http://mydomain.com/b"}}).IsValid(); err == nil {
+		t.Errorf("Placement with a workload without an org should not be valid")
+	}
+
+}
+
+func Test_placement_satisfied(t *testing.T) {
+
+	a := PlacementWorkload{URL: "http://mydomain.com/a", Org: "myorg"}
+	b := PlacementWorkload{URL: "http://mydomain.com/b", Org: "myorg"}
+	c := PlacementWorkload{URL: "http://mydomain.com/c", Org: "myorg"}
+
+	p := Placement_Factory([]PlacementWorkload{a}, []PlacementWorkload{b})
+
+	if err := p.Is_Satisfied_By([]PlacementWorkload{a, c}); err != nil {
+		t.Errorf("Placement %v should be satisfied, error: %v", p, err)
+	} else if err := p.Is_Satisfied_By([]PlacementWorkload{PlacementWorkload{URL: a.URL}}); err != nil {
+		t.Errorf("Placement %v should be satisfied by a running workload without an org, error: %v", p, err)
+	} else if err := p.Is_Satisfied_By([]PlacementWorkload{c}); err == nil {
+		t.Errorf("Placement %v should not be satisfied without the co-located workload", p)
+	} else if err := p.Is_Satisfied_By([]PlacementWorkload{a, b}); err == nil {
+		t.Errorf("Placement %v should not be satisfied with the anti-affine workload", p)
+	} else if err := p.Is_Satisfied_By([]PlacementWorkload{PlacementWorkload{URL: a.URL, Org: "otherorg"}}); err == nil {
+		t.Errorf("Placement %v should not be satisfied by a workload in a different org", p)
+	} else if err := Placement_Factory(nil, nil).Is_Satisfied_By(nil); err != nil {
+		t.Errorf("Empty placement should be satisfied, error: %v", err)
+	} else if err := (*Placement)(nil).Is_Satisfied_By([]PlacementWorkload{a}); err != nil {
+		t.Errorf("No placement should be satisfied, error: %v", err)
+	}
+
+}
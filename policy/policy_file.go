@@ -51,8 +51,9 @@ type ProposalRejection struct {
 // This is the main struct that defines the Policy object
 type Policy struct {
 	Header                 PolicyHeader          `json:"header"`
-	PatternId              string                `json:"patternId,omitempty"` // Manually created policy files should NOT use this field.
-	ServiceBased           bool                  `json:"useServices"`         // Manually created policy files set this field when using the service model.
+	PatternId              string                `json:"patternId,omitempty"`     // Manually created policy files should NOT use this field.
+	BusinessPolId          string                `json:"businessPolId,omitempty"` // Manually created policy files should NOT use this field.
+	ServiceBased           bool                  `json:"useServices"`             // Manually created policy files set this field when using the service model.
 	APISpecs               APISpecList           `json:"apiSpec,omitempty"`
 	AgreementProtocols     AgreementProtocolList `json:"agreementProtocols,omitempty"`
 	Workloads              WorkloadList          `json:"workloads,omitempty"`
@@ -67,6 +68,10 @@ type Policy struct {
 	RequiredWorkload       string                `json:"requiredWorkload,omitempty"`       // Version 2.0
 	HAGroup                HighAvailabilityGroup `json:"ha_group,omitempty"`               // Version 2.0
 	NodeH                  NodeHealth            `json:"nodeHealth,omitempty"`             // Version 2.0
+	Placement              *Placement            `json:"placement,omitempty"`              // Version 2.0
+	TimeWindows            TimeWindowList        `json:"timeWindows,omitempty"`            // Version 2.0
+	AgreementTimeouts      *AgreementTimeouts    `json:"agreementTimeouts,omitempty"`      // Version 2.0
+	RequireAttestation     bool                  `json:"requireAttestation,omitempty"`     // Version 2.0
 }
 
 // These functions are used to create Policy objects. You can create the base object
@@ -147,6 +152,33 @@ func (self *Policy) Add_NodeHealth(nh *NodeHealth) error {
 	}
 }
 
+func (self *Policy) Add_AgreementTimeouts(t *AgreementTimeouts) error {
+	if t != nil {
+		self.AgreementTimeouts = t
+		return nil
+	} else {
+		return errors.New(fmt.Sprintf("Add_AgreementTimeouts Error: input is nil."))
+	}
+}
+
+func (self *Policy) Add_Placement(p *Placement) error {
+	if p != nil {
+		self.Placement = p
+		return nil
+	} else {
+		return errors.New(fmt.Sprintf("Add_Placement Error: input is nil."))
+	}
+}
+
+func (self *Policy) Add_TimeWindow(w *TimeWindow) error {
+	if w != nil {
+		self.TimeWindows = append(self.TimeWindows, *w)
+		return nil
+	} else {
+		return errors.New(fmt.Sprintf("Add_TimeWindow Error: input is nil."))
+	}
+}
+
 // This is a function that compares two in-memory Policy objects to determine if they are compatible
 // or not. If no error is returned, then the policies are compatible. The order of parameters is
 // important. The first policy is the policy of the device that is offering itself for usage (aka
@@ -164,19 +196,19 @@ func (self *Policy) Add_NodeHealth(nh *NodeHealth) error {
 func Are_Compatible(producer_policy *Policy, consumer_policy *Policy) error {
 
 	if !consumer_policy.Is_Version(producer_policy.Header.Version) {
-		return errors.New(fmt.Sprintf("Compatibility Error: Schema versions are not the same, Consumer policy: %v, Producer policy %v", consumer_policy.Header.Version, producer_policy.Header.Version))
+		return NewCompatibilityError(COMPAT_SCHEMA_VERSION, fmt.Sprintf("Compatibility Error: Schema versions are not the same, Consumer policy: %v, Producer policy %v", consumer_policy.Header.Version, producer_policy.Header.Version), nil)
 	} else if err := producer_policy.APISpecs.Supports(consumer_policy.APISpecs); err != nil {
-		return errors.New(fmt.Sprintf("Compatibility Error: Producer policy APISpecs %v do not support Consumer APISpec requirements %v. Underlying error: %v", producer_policy.APISpecs, consumer_policy.APISpecs, err))
+		return NewCompatibilityError(COMPAT_API_SPECS, fmt.Sprintf("Compatibility Error: Producer policy APISpecs %v do not support Consumer APISpec requirements %v. Underlying error: %v", producer_policy.APISpecs, consumer_policy.APISpecs, err), err)
 	} else if err := (&consumer_policy.CounterPartyProperties).IsSatisfiedBy(producer_policy.Properties); err != nil {
-		return errors.New(fmt.Sprintf("Compatibility Error: Producer properties %v do not satisfy Consumer property requirements %v. Underlying error: %v", producer_policy.Properties, consumer_policy.CounterPartyProperties, err))
+		return NewCompatibilityError(COMPAT_CONSUMER_REQUIREMENTS, fmt.Sprintf("Compatibility Error: Producer properties %v do not satisfy Consumer property requirements %v. Underlying error: %v", producer_policy.Properties, consumer_policy.CounterPartyProperties, err), err)
 	} else if err := (&producer_policy.CounterPartyProperties).IsSatisfiedBy(consumer_policy.Properties); err != nil {
-		return errors.New(fmt.Sprintf("Compatibility Error: Consumer properties %v do not satisfy Producer property requirements %v. Underlying error: %v", consumer_policy.Properties, producer_policy.CounterPartyProperties, err))
+		return NewCompatibilityError(COMPAT_PRODUCER_REQUIREMENTS, fmt.Sprintf("Compatibility Error: Consumer properties %v do not satisfy Producer property requirements %v. Underlying error: %v", consumer_policy.Properties, producer_policy.CounterPartyProperties, err), err)
 	} else if _, err := (&producer_policy.AgreementProtocols).Intersects_With(&consumer_policy.AgreementProtocols); err != nil {
-		return errors.New(fmt.Sprintf("Compatibility Error: No common Agreement Protocols between %v and %v. Underlying error: %v", producer_policy.AgreementProtocols, consumer_policy.AgreementProtocols, err))
+		return NewCompatibilityError(COMPAT_AGREEMENT_PROTOCOLS, fmt.Sprintf("Compatibility Error: No common Agreement Protocols between %v and %v. Underlying error: %v", producer_policy.AgreementProtocols, consumer_policy.AgreementProtocols, err), err)
 	} else if !(&consumer_policy.ResourceLimits).IsSatisfiedBy(&producer_policy.ResourceLimits) {
-		return errors.New(fmt.Sprintf("Compatibility Error: Producer resource limits %v do not satisfy consumer resource requirements %v", producer_policy.ResourceLimits, consumer_policy.ResourceLimits))
+		return NewCompatibilityError(COMPAT_RESOURCE_LIMITS, fmt.Sprintf("Compatibility Error: Producer resource limits %v do not satisfy consumer resource requirements %v", producer_policy.ResourceLimits, consumer_policy.ResourceLimits), nil)
 	} else if !producer_policy.DataVerify.IsCompatibleWith(consumer_policy.DataVerify) {
-		return errors.New(fmt.Sprintf("Compatibility Error: Data verification must be compatible, producer has %v and consumer has %v.", producer_policy.DataVerify, consumer_policy.DataVerify))
+		return NewCompatibilityError(COMPAT_DATA_VERIFICATION, fmt.Sprintf("Compatibility Error: Data verification must be compatible, producer has %v and consumer has %v.", producer_policy.DataVerify, consumer_policy.DataVerify), nil)
 	}
 
 	return nil
@@ -202,15 +234,15 @@ func Are_Compatible_Producers(producer_policy1 *Policy, producer_policy2 *Policy
 	if producer_policy1 == nil {
 		return producer_policy2, nil
 	} else if !producer_policy1.Is_Version(producer_policy2.Header.Version) {
-		return nil, errors.New(fmt.Sprintf("Compatibility Error: Schema versions are not the same, Policy1: %v, Policy2 %v", producer_policy1.Header.Version, producer_policy2.Header.Version))
+		return nil, NewCompatibilityError(COMPAT_SCHEMA_VERSION, fmt.Sprintf("Compatibility Error: Schema versions are not the same, Policy1: %v, Policy2 %v", producer_policy1.Header.Version, producer_policy2.Header.Version), nil)
 	} else if _, err := (&producer_policy1.AgreementProtocols).Intersects_With(&producer_policy2.AgreementProtocols); err != nil {
-		return nil, errors.New(fmt.Sprintf("Compatibility Error: No common Agreement Protocols between %v and %v. Underlying error: %v", producer_policy1.AgreementProtocols, producer_policy2.AgreementProtocols, err))
+		return nil, NewCompatibilityError(COMPAT_AGREEMENT_PROTOCOLS, fmt.Sprintf("Compatibility Error: No common Agreement Protocols between %v and %v. Underlying error: %v", producer_policy1.AgreementProtocols, producer_policy2.AgreementProtocols, err), err)
 	} else if err := (&producer_policy1.Properties).Compatible_With(&producer_policy2.Properties); err != nil {
-		return nil, errors.New(fmt.Sprintf("Compatibility Error: Common Properties between %v and %v. Underlying error: %v", producer_policy1.Properties, producer_policy2.Properties, err))
+		return nil, NewCompatibilityError(COMPAT_PROPERTIES, fmt.Sprintf("Compatibility Error: Common Properties between %v and %v. Underlying error: %v", producer_policy1.Properties, producer_policy2.Properties, err), err)
 	} else if !producer_policy1.DataVerify.IsProducerCompatible(producer_policy2.DataVerify) {
-		return nil, errors.New(fmt.Sprintf("Compatibility Error: Data verification must be compatible between %v and %v.", producer_policy1.DataVerify, producer_policy2.DataVerify))
+		return nil, NewCompatibilityError(COMPAT_DATA_VERIFICATION, fmt.Sprintf("Compatibility Error: Data verification must be compatible between %v and %v.", producer_policy1.DataVerify, producer_policy2.DataVerify), nil)
 	} else if !producer_policy1.HAGroup.Compatible_With(&producer_policy2.HAGroup) {
-		return nil, errors.New(fmt.Sprintf("Compatibility Error: HAGroups must be compatible between %v and %v.", producer_policy1.HAGroup, producer_policy2.HAGroup))
+		return nil, NewCompatibilityError(COMPAT_HA_GROUP, fmt.Sprintf("Compatibility Error: HAGroups must be compatible between %v and %v.", producer_policy1.HAGroup, producer_policy2.HAGroup), nil)
 	}
 
 	merged_pol := new(Policy)
@@ -252,8 +284,9 @@ func Create_Terms_And_Conditions(producer_policy *Policy, consumer_policy *Polic
 		// Propagate the service model indicator
 		merged_pol.ServiceBased = consumer_policy.ServiceBased
 
-		// Propagate the pattern id
+		// Propagate the pattern id and business policy id
 		merged_pol.PatternId = consumer_policy.PatternId
+		merged_pol.BusinessPolId = consumer_policy.BusinessPolId
 
 		// The consumer policy object has already been augmented with the microservices from the producer
 		merged_pol.APISpecs = append(merged_pol.APISpecs, consumer_policy.APISpecs...)
@@ -274,6 +307,8 @@ func Create_Terms_And_Conditions(producer_policy *Policy, consumer_policy *Polic
 		merged_pol.RequiredWorkload = producer_policy.RequiredWorkload
 		merged_pol.HAGroup = producer_policy.HAGroup
 		merged_pol.NodeH = consumer_policy.NodeH
+		merged_pol.TimeWindows = consumer_policy.TimeWindows.ForNode(producer_policy.Properties)
+		merged_pol.AgreementTimeouts = consumer_policy.AgreementTimeouts
 
 		return merged_pol, nil
 	}
@@ -287,6 +322,21 @@ func (self *Policy) Is_Self_Consistent(keyFileNames []string,
 		return errors.New(fmt.Sprintf("Data Verification section is not valid, error: %v", err))
 	}
 
+	// Check validity of the counterparty property expression
+	if err := self.CounterPartyProperties.IsValid(); err != nil {
+		return errors.New(fmt.Sprintf("CounterPartyProperties section of %v is not valid, error: %v", self.Header.Name, err))
+	}
+
+	// Check validity of the placement constraints
+	if err := self.Placement.IsValid(); err != nil {
+		return errors.New(fmt.Sprintf("Placement section of %v is not valid, error: %v", self.Header.Name, err))
+	}
+
+	// Check validity of the time windows
+	if err := self.TimeWindows.IsValid(); err != nil {
+		return errors.New(fmt.Sprintf("TimeWindows section of %v is not valid, error: %v", self.Header.Name, err))
+	}
+
 	// Check validity of the agreement protocol list
 	for _, agp := range self.AgreementProtocols {
 		if err := agp.IsValid(); err != nil {
@@ -327,10 +377,16 @@ func (self *Policy) Is_Self_Consistent(keyFileNames []string,
 			return errors.New(fmt.Sprintf("Workload section has mix of organizations, element 0 has org %v, element %v has %v", self.Workloads[0].Org, ix, workload.Org))
 		}
 
+		// The workloads are either all for any hardware architecture or all for a specific one.
+		if self.Workloads[0].HasAnyArch() != workload.HasAnyArch() {
+			return errors.New(fmt.Sprintf("Workload section has mix of architectures, element 0 has arch %v, element %v has %v", self.Workloads[0].Arch, ix, workload.Arch))
+		}
+
 		// If the workloads use different API specs, return the error. API specs can differ by version from one workload to
 		// another but they cant differ by architecture, nor can one workload require an API spec that is not required
-		// by another workload in this policy file.
-		if workloadOrServiceResolver != nil && workload.WorkloadURL != "" && workload.Deployment == "" {
+		// by another workload in this policy file. Workloads for any hardware architecture are only resolved for the arch
+		// of a node, when an agreement is made.
+		if workloadOrServiceResolver != nil && workload.WorkloadURL != "" && workload.Deployment == "" && !workload.HasAnyArch() {
 			if ix == 0 {
 				if firstASRL, err := workloadOrServiceResolver(workload.WorkloadURL, workload.Org, workload.Version, workload.Arch); err == nil {
 					referencedApiSpecRefs = firstASRL
@@ -339,8 +395,9 @@ func (self *Policy) Is_Self_Consistent(keyFileNames []string,
 				}
 				// If the policy is not pattern based then it is a policy file that places workloads on nodes solely based on services that the node owner
 				// has opted in to. In this case, the agreement services must have dependencies in order for them to be placed on a node. This is the not
-				// the case for patterns (i.e. patterns can place agreement services on nodes where the agreement service has no dependencies).
-				if self.PatternId == "" && (referencedApiSpecRefs == nil || len(*referencedApiSpecRefs) == 0) {
+				// the case for patterns (i.e. patterns can place agreement services on nodes where the agreement service has no dependencies),
+				// nor for business policies, which place agreement services based on node properties.
+				if self.PatternId == "" && self.BusinessPolId == "" && (referencedApiSpecRefs == nil || len(*referencedApiSpecRefs) == 0) {
 					return errors.New(fmt.Sprintf("Agreement services in non-pattern policies must have service dependencies, policy: %v", self.Header.Name))
 				}
 
@@ -389,6 +446,10 @@ func (self *Policy) String() string {
 	res += fmt.Sprintf("CounterPartyProperties: %v\n", self.CounterPartyProperties)
 	res += fmt.Sprintf("Data Verification: %v\n", self.DataVerify)
 	res += fmt.Sprintf("Node Health: %v\n", self.NodeH)
+	res += fmt.Sprintf("Placement: %v\n", self.Placement)
+	res += fmt.Sprintf("Time Windows: %v\n", self.TimeWindows)
+	res += fmt.Sprintf("Agreement Timeouts: %v\n", self.AgreementTimeouts)
+	res += fmt.Sprintf("Require Attestation: %v\n", self.RequireAttestation)
 
 	return res
 }
@@ -407,6 +468,10 @@ func (self *Policy) ShortString() string {
 	}
 	res += fmt.Sprintf(", Data Verification: %v", self.DataVerify)
 	res += fmt.Sprintf(", Node Health: %v", self.NodeH)
+	res += fmt.Sprintf(", Placement: %v", self.Placement)
+	res += fmt.Sprintf(", Time Windows: %v", self.TimeWindows)
+	res += fmt.Sprintf(", Agreement Timeouts: %v", self.AgreementTimeouts)
+	res += fmt.Sprintf(", Require Attestation: %v", self.RequireAttestation)
 
 	return res
 }
@@ -586,13 +651,19 @@ func ReadPolicyFile(name string, arch_synonymns config.ArchSynonyms) (*Policy, e
 }
 
 // This function writes a Policy object into a file. Note that the file is written formatted so
-// that it is human readable.
+// that it is human readable. The file is written under a temporary name and then renamed, so that
+// the policy file watcher never reads a partial file, and an existing file is replaced in one step
+// rather than being seen as deleted.
 func WritePolicyFile(newPolicy *Policy, name string) error {
 
 	if bytes, err := json.MarshalIndent(newPolicy, "", "    "); err != nil {
 		return errors.New(fmt.Sprintf("Unable to marshal policy %v to file, error: %v", newPolicy, err))
-	} else if err := ioutil.WriteFile(name, bytes, 0644); err != nil {
+	} else if err := ioutil.WriteFile(name+".tmp", bytes, 0644); err != nil {
+		os.Remove(name + ".tmp")
 		return errors.New(fmt.Sprintf("Unable to write policy file %v, error: %v", name, err))
+	} else if err := os.Rename(name+".tmp", name); err != nil {
+		os.Remove(name + ".tmp")
+		return errors.New(fmt.Sprintf("Unable to replace policy file %v, error: %v", name, err))
 	} else {
 		return nil
 	}
@@ -614,6 +685,11 @@ func DeletePolicyFilesForPattern(policyPath string, org string, pattern string)
 		return fmt.Errorf("Unable to get list of policy files in %v, error: %v", orgPath, err)
 	}
 
+	// Remove the pattern's generation first, so that the watcher stops loading its files before they are deleted.
+	if err := DeletePolicyGeneration(policyPath, org, pattern); err != nil {
+		return err
+	}
+
 	// For each policy, if it is for this pattern, delete it.
 	p_id := fmt.Sprintf("%v/%v", org, pattern)
 	for _, fileInfo := range files {
@@ -629,6 +705,45 @@ func DeletePolicyFilesForPattern(policyPath string, org string, pattern string)
 	return nil
 }
 
+// This function deletes all the policy files for the given business policy of the given org.
+func DeletePolicyFilesForBusinessPolicy(policyPath string, org string, businessPol string) error {
+
+	bp_id := fmt.Sprintf("%v/%v", org, businessPol)
+	return deletePolicyFilesInOrg(policyPath, org, func(pol *Policy) bool { return pol.BusinessPolId == bp_id })
+}
+
+// This function deletes all the policy files for the given org that were generated from a business policy.
+func DeletePolicyFilesForBusinessPolicyOrg(policyPath string, org string) error {
+	return deletePolicyFilesInOrg(policyPath, org, func(pol *Policy) bool { return pol.BusinessPolId != "" })
+}
+
+func deletePolicyFilesInOrg(policyPath string, org string, match func(pol *Policy) bool) error {
+
+	orgPath := policyPath + "/" + org + "/"
+
+	if _, err := os.Stat(orgPath); os.IsNotExist(err) {
+		glog.Infof("The directory %v does not exist, do nothing.", orgPath)
+		return nil
+	}
+
+	files, err := getPolicyFiles(orgPath)
+	if err != nil {
+		return fmt.Errorf("Unable to get list of policy files in %v, error: %v", orgPath, err)
+	}
+
+	for _, fileInfo := range files {
+		if policy, err := ReadPolicyFile(orgPath+fileInfo.Name(), config.NewArchSynonyms()); err != nil {
+			return fmt.Errorf("Failed to read file %v, error: %v", orgPath+fileInfo.Name(), err)
+		} else if match(policy) {
+			if err := DeletePolicyFile(orgPath + fileInfo.Name()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 // This function deletes all the policy files for the given org.
 // If patternBasedOnly is false, it deletes all policy file under the path.
 // If patternBasedOnly is true, it only deletes the policy files that are pattern based.
@@ -647,6 +762,11 @@ func DeletePolicyFilesForOrg(policyPath string, org string, patternBasedOnly boo
 		return fmt.Errorf("pattern manager unable to get list of policy files in %v, error: %v", orgPath, err)
 	}
 
+	// The generations only list files generated from patterns.
+	if err := DeletePolicyGenerationsForOrg(policyPath, org); err != nil {
+		return err
+	}
+
 	// For each policy, delete it according to the patternBasedOnly setting
 	for _, fileInfo := range files {
 
@@ -846,11 +966,29 @@ func PolicyFileChangeWatcher(homePath string,
 				return contents, errors.New(fmt.Sprintf("Policy File Watcher unable to get list of policy files in %v, error: %v", orgPath, err))
 			}
 
+			// Generated policy files that are no longer in the current generation of their pattern are forgotten. When
+			// the current generation has the same policy in another file, that file is reported as changed below, so
+			// the policy is replaced rather than deleted and added again.
+			generations := ReadPolicyGenerations(homePath, org)
+			if contents.HasOrg(org) {
+				for fileName, we := range contents.AllWatches[org] {
+					if !isCurrentGeneration(generations, fileName, we.Pol) {
+						if generations[we.Pol.PatternId].PolicyFile(we.Pol.Header.Name) == "" {
+							fileDeleted(org, orgPath+fileName, we.Pol)
+							glog.V(5).Infof("Policy File Watcher detected deleted policy generation file %v", orgPath+fileName)
+						}
+						contents.RemoveWatchEntry(org, fileName)
+					}
+				}
+			}
+
 			// For each file, if we dont have a record of it, read in the file and create an entry in the map.
 			for _, fileInfo := range files {
 				if !contents.HasFile(org, fileInfo.Name()) {
 					if policy, err := ReadPolicyFile(orgPath+fileInfo.Name(), arch_synonymns); err != nil {
 						fileError(org, orgPath+fileInfo.Name(), err)
+					} else if !isCurrentGeneration(generations, fileInfo.Name(), policy) {
+						glog.V(5).Infof("Policy File Watcher skipping file %v, not in the current generation of pattern %v", orgPath+fileInfo.Name(), policy.PatternId)
 					} else if err := policy.Is_Self_Consistent(nil, workloadOrServiceResolver); err != nil {
 						fileError(org, orgPath+fileInfo.Name(), errors.New(fmt.Sprintf("Policy file not self consistent %v, error: %v", orgPath, err)))
 					} else if fn := contents.ConflictsWithAlreadyTracked(org, policy); fn != "" {
@@ -884,6 +1022,13 @@ func PolicyFileChangeWatcher(homePath string,
 							break
 						}
 					}
+					// A generated file is deleted after its pattern switched to a new generation, which might
+					// have happened after this pass read the generations.
+					if we.Pol.PatternId != "" {
+						if fn := ReadPolicyGenerations(homePath, org)[we.Pol.PatternId].PolicyFile(we.Pol.Header.Name); fn != "" && fn != we.FInfo.Name() {
+							found = true
+						}
+					}
 					// If there is another file with our policy in it, then we can skip the delete event but we still have to
 					// remove the file entry from the contents map.
 					if !found {
@@ -949,9 +1094,11 @@ func DeleteAllPolicyFiles(homePath string, patternBasedOnly bool) error {
 				glog.Errorf("Error removing policy directory %v, error: %v", pDir, err)
 			}
 		} else {
-			// remove policy files that are pattern based
+			// remove policy files that are pattern based, or generated from a business policy
 			if err := DeletePolicyFilesForOrg(homePath, org, true); err != nil {
 				return err
+			} else if err := DeletePolicyFilesForBusinessPolicyOrg(homePath, org); err != nil {
+				return err
 			}
 		}
 	}
@@ -49,6 +49,8 @@ func Test_reads_and_writes_file(t *testing.T) {
 		t.Errorf("Unable to read echo.policy policy file, error: %v", err)
 	} else if bytes.Compare(pf1bytes, pf2bytes) != 0 {
 		t.Errorf("Echoed policy file %v does not match original file %v", string(pf2bytes), string(pf1bytes))
+	} else if _, err := os.Stat("./test/pftest/echo.policy.tmp"); !os.IsNotExist(err) {
+		t.Errorf("Temporary policy file should have been renamed, error: %v", err)
 	}
 
 }
@@ -0,0 +1,188 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// The agbot generates a set of policy files from each pattern it serves. When a pattern changes, the whole set is
+// replaced by a new generation of files. Each generation is written in full under new file names, and then the
+// pattern's generation manifest is switched to it by renaming the new manifest over the old one. The policy file
+// watcher only loads the generated files that are listed in their pattern's manifest, so it never sees a half
+// written or half deleted set. The manifests are kept in a directory of the org's policy directory, which the
+// watcher does not read as policy files.
+const GENERATIONS_DIR = ".generations"
+
+type PolicyGeneration struct {
+	PatternId  string            `json:"patternId"`
+	Generation string            `json:"generation"`
+	Files      map[string]string `json:"files"` // the policy name in each file of the generation, keyed by file name
+	Created    uint64            `json:"created"`
+}
+
+func (g *PolicyGeneration) String() string {
+	return fmt.Sprintf("PatternId: %v, Generation: %v, Files: %v, Created: %v", g.PatternId, g.Generation, g.Files, g.Created)
+}
+
+// Returns true when the file, a name without the directory, belongs to the generation.
+func (g *PolicyGeneration) HasFile(fileName string) bool {
+	if g == nil {
+		return false
+	}
+	_, ok := g.Files[fileName]
+	return ok
+}
+
+// Returns the name of the file containing the policy in the generation, or the empty string.
+func (g *PolicyGeneration) PolicyFile(policyName string) string {
+	if g == nil {
+		return ""
+	}
+	for fileName, name := range g.Files {
+		if name == policyName {
+			return fileName
+		}
+	}
+	return ""
+}
+
+func generationsPath(policyPath string, org string) string {
+	return path.Join(policyPath, org, GENERATIONS_DIR)
+}
+
+func generationFileName(policyPath string, org string, pattern string) string {
+	return path.Join(generationsPath(policyPath, org), pattern+".json")
+}
+
+// Generation ids only have to be different from the previous generation of the same pattern.
+func newGenerationId() string {
+	return fmt.Sprintf("%x", time.Now().UnixNano())
+}
+
+// Write a new generation of policy files for the pattern and make it the current generation. Returns the generation
+// id and the full names of the new files. The files of the previous generation are left for the caller to delete.
+func CreatePolicyGeneration(policyPath string, org string, patternId string, policies []*Policy) (string, []string, error) {
+
+	gen := &PolicyGeneration{
+		PatternId:  patternId,
+		Generation: newGenerationId(),
+		Files:      make(map[string]string),
+		Created:    uint64(time.Now().Unix()),
+	}
+
+	orgPath := path.Join(policyPath, org)
+	if err := os.MkdirAll(generationsPath(policyPath, org), 0764); err != nil {
+		return "", nil, errors.New(fmt.Sprintf("Error creating policy generation directory %v, error: %v", generationsPath(policyPath, org), err))
+	}
+
+	// WritePolicyFile renames each file into place, so that the watcher never reads a partial file.
+	fileNames := make([]string, 0, len(policies))
+	for _, pol := range policies {
+		fileName := fmt.Sprintf("%v.%v.policy", pol.Header.Name, gen.Generation)
+		fullFileName := path.Join(orgPath, fileName)
+		if err := WritePolicyFile(pol, fullFileName); err != nil {
+			deletePolicyFiles(fileNames)
+			return "", nil, err
+		}
+		gen.Files[fileName] = pol.Header.Name
+		fileNames = append(fileNames, fullFileName)
+	}
+
+	if err := switchPolicyGeneration(policyPath, org, gen); err != nil {
+		deletePolicyFiles(fileNames)
+		return "", nil, err
+	}
+
+	glog.V(5).Infof("Switched pattern %v to policy generation %v", patternId, gen.Generation)
+	return gen.Generation, fileNames, nil
+}
+
+// The rename replaces the previous manifest atomically.
+func switchPolicyGeneration(policyPath string, org string, gen *PolicyGeneration) error {
+	fileName := generationFileName(policyPath, org, getPatternName(gen.PatternId))
+	if bytes, err := json.Marshal(gen); err != nil {
+		return errors.New(fmt.Sprintf("Unable to marshal policy generation %v, error: %v", gen, err))
+	} else if err := ioutil.WriteFile(fileName+".tmp", bytes, 0644); err != nil {
+		return errors.New(fmt.Sprintf("Unable to write policy generation %v, error: %v", fileName, err))
+	} else if err := os.Rename(fileName+".tmp", fileName); err != nil {
+		os.Remove(fileName + ".tmp")
+		return errors.New(fmt.Sprintf("Unable to switch policy generation %v, error: %v", fileName, err))
+	}
+	return nil
+}
+
+func deletePolicyFiles(fileNames []string) {
+	for _, fileName := range fileNames {
+		if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+			glog.Errorf("Unable to remove policy file %v, error: %v", fileName, err)
+		}
+	}
+}
+
+// Remove the generation manifest of the pattern. Its generated files are no longer loaded by the watcher, even
+// before they are deleted.
+func DeletePolicyGeneration(policyPath string, org string, pattern string) error {
+	if err := os.Remove(generationFileName(policyPath, org, pattern)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Failed to remove the policy generation of pattern %v/%v, error %v", org, pattern, err)
+	}
+	return nil
+}
+
+// Remove the generation manifests of all the patterns in the org.
+func DeletePolicyGenerationsForOrg(policyPath string, org string) error {
+	if err := os.RemoveAll(generationsPath(policyPath, org)); err != nil {
+		return fmt.Errorf("Failed to remove the policy generations of org %v, error %v", org, err)
+	}
+	return nil
+}
+
+// Returns the current generation of each pattern in the org, keyed by pattern id. A manifest that can't be read is
+// logged and skipped, so the files of its pattern are not loaded until the next generation.
+func ReadPolicyGenerations(policyPath string, org string) map[string]*PolicyGeneration {
+	res := make(map[string]*PolicyGeneration)
+
+	files, err := ioutil.ReadDir(generationsPath(policyPath, org))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			glog.Errorf("Unable to read policy generations in %v, error: %v", generationsPath(policyPath, org), err)
+		}
+		return res
+	}
+
+	for _, fileInfo := range files {
+		if fileInfo.IsDir() || !strings.HasSuffix(fileInfo.Name(), ".json") {
+			continue
+		}
+		fileName := path.Join(generationsPath(policyPath, org), fileInfo.Name())
+		gen := new(PolicyGeneration)
+		if bytes, err := ioutil.ReadFile(fileName); err != nil {
+			glog.Errorf("Unable to read policy generation %v, error: %v", fileName, err)
+		} else if err := json.Unmarshal(bytes, gen); err != nil {
+			glog.Errorf("Unable to demarshal policy generation %v, error: %v", fileName, err)
+		} else {
+			res[gen.PatternId] = gen
+		}
+	}
+	return res
+}
+
+// Generated policies are only loaded when their file is in the current generation of their pattern. Other policies
+// are always loaded.
+func isCurrentGeneration(generations map[string]*PolicyGeneration, fileName string, pol *Policy) bool {
+	return pol.PatternId == "" || generations[pol.PatternId].HasFile(fileName)
+}
+
+// The pattern name of a pattern id, org/name.
+func getPatternName(patternId string) string {
+	if parts := strings.SplitN(patternId, "/", 2); len(parts) == 2 {
+		return parts[1]
+	}
+	return patternId
+}
@@ -0,0 +1,106 @@
+// +build unit
+
+package policy
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func generationTestPolicy(name string, patternId string) *Policy {
+	pol := Policy_Factory(name)
+	pol.PatternId = patternId
+	return pol
+}
+
+func Test_PolicyGeneration(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "policy-generation")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	changed := make(map[string]string)
+	deleted := make(map[string]string)
+	watch := func(contents *Contents) *Contents {
+		for k := range changed {
+			delete(changed, k)
+		}
+		for k := range deleted {
+			delete(deleted, k)
+		}
+		contents, err := PolicyFileChangeWatcher(dir, contents, make(map[string]string),
+			func(org string, fileName string, pol *Policy) { changed[pol.Header.Name] = path.Base(fileName) },
+			func(org string, fileName string, pol *Policy) { deleted[pol.Header.Name] = path.Base(fileName) },
+			func(org string, fileName string, err error) {
+				t.Errorf("unexpected error reading %v: %v", fileName, err)
+			},
+			nil, 0)
+		if err != nil {
+			t.Fatalf("policy file watcher failed: %v", err)
+		}
+		return contents
+	}
+
+	// A hand written policy and the first generation of a pattern are loaded.
+	if _, err := CreatePolicyFile(dir+"/", "myorg", "mypolicy", Policy_Factory("mypolicy")); err != nil {
+		t.Fatalf("unable to create policy file: %v", err)
+	}
+	gen1, files1, err := CreatePolicyGeneration(dir, "myorg", "myorg/pat1", []*Policy{generationTestPolicy("pol1", "myorg/pat1"), generationTestPolicy("pol2", "myorg/pat1")})
+	if err != nil {
+		t.Fatalf("unable to create generation: %v", err)
+	} else if len(files1) != 2 {
+		t.Fatalf("generation should have 2 files, has %v", files1)
+	}
+	contents := watch(NewContents())
+	if len(changed) != 3 || changed["mypolicy"] == "" || changed["pol1"] == "" || changed["pol2"] == "" {
+		t.Errorf("all 3 policies should be loaded, loaded %v", changed)
+	}
+
+	// A generated file that is not in the current generation is not loaded.
+	if _, err := CreatePolicyFile(dir+"/", "myorg", "pol3.partial", generationTestPolicy("pol3", "myorg/pat1")); err != nil {
+		t.Fatalf("unable to create policy file: %v", err)
+	}
+	contents = watch(contents)
+	if len(changed) != 0 || len(deleted) != 0 {
+		t.Errorf("a file outside the current generation should be skipped, changed %v, deleted %v", changed, deleted)
+	}
+	os.Remove(path.Join(dir, "myorg", "pol3.partial.policy"))
+
+	// Switching to a new generation replaces pol1, deletes pol2 and adds pol3, even before the old files are deleted.
+	gen2, _, err := CreatePolicyGeneration(dir, "myorg", "myorg/pat1", []*Policy{generationTestPolicy("pol1", "myorg/pat1"), generationTestPolicy("pol3", "myorg/pat1")})
+	if err != nil {
+		t.Fatalf("unable to create generation: %v", err)
+	} else if gen2 == gen1 {
+		t.Errorf("the new generation should have a new id, both are %v", gen1)
+	}
+	contents = watch(contents)
+	if len(changed) != 2 || changed["pol1"] == "" || changed["pol3"] == "" {
+		t.Errorf("pol1 and pol3 should be changed, changed %v", changed)
+	} else if len(deleted) != 1 || deleted["pol2"] == "" {
+		t.Errorf("only pol2 should be deleted, deleted %v", deleted)
+	}
+
+	// Deleting the old files changes nothing.
+	deletePolicyFiles(files1)
+	contents = watch(contents)
+	if len(changed) != 0 || len(deleted) != 0 {
+		t.Errorf("deleting the old generation should not change the policies, changed %v, deleted %v", changed, deleted)
+	}
+
+	// Deleting the pattern's files deletes its policies and its generation.
+	if err := DeletePolicyFilesForPattern(dir, "myorg", "pat1"); err != nil {
+		t.Fatalf("unable to delete pattern files: %v", err)
+	}
+	contents = watch(contents)
+	if len(deleted) != 2 || deleted["pol1"] == "" || deleted["pol3"] == "" {
+		t.Errorf("pol1 and pol3 should be deleted, deleted %v", deleted)
+	} else if gens := ReadPolicyGenerations(dir, "myorg"); len(gens) != 0 {
+		t.Errorf("the generation of the pattern should be deleted, generations are %v", gens)
+	} else if !contents.HasFile("myorg", "mypolicy.policy") {
+		t.Errorf("the hand written policy should still be loaded, contents are %v", contents)
+	}
+}
@@ -267,6 +267,18 @@ func (self *PolicyManager) hasPolicy(org string, matchPolicy *Policy) (bool, err
 		} else if !reflect.DeepEqual(pol.CounterPartyProperties, matchPolicy.CounterPartyProperties) {
 			errString = fmt.Sprintf("CounterPartyProperties %v mismatch with %v", pol.CounterPartyProperties, matchPolicy.CounterPartyProperties)
 			continue
+		} else if !pol.Placement.IsSame(matchPolicy.Placement) {
+			errString = fmt.Sprintf("Placement %v mismatch with %v", pol.Placement, matchPolicy.Placement)
+			continue
+		} else if !pol.TimeWindows.IsSame(matchPolicy.TimeWindows) {
+			errString = fmt.Sprintf("TimeWindows %v mismatch with %v", pol.TimeWindows, matchPolicy.TimeWindows)
+			continue
+		} else if !pol.AgreementTimeouts.IsSame(matchPolicy.AgreementTimeouts) {
+			errString = fmt.Sprintf("AgreementTimeouts %v mismatch with %v", pol.AgreementTimeouts, matchPolicy.AgreementTimeouts)
+			continue
+		} else if pol.RequireAttestation != matchPolicy.RequireAttestation {
+			errString = fmt.Sprintf("RequireAttestation %v mismatch with %v", pol.RequireAttestation, matchPolicy.RequireAttestation)
+			continue
 		} else if pol.RequiredWorkload != matchPolicy.RequiredWorkload {
 			errString = fmt.Sprintf("RequiredWorkload %v mismatch with %v", pol.RequiredWorkload, matchPolicy.RequiredWorkload)
 			continue
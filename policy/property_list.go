@@ -51,7 +51,10 @@ func (self *PropertyList) Compatible_With(other *PropertyList) error {
 	for _, self_ele := range *self {
 		for _, other_ele := range *other {
 			if self_ele.Name == other_ele.Name && self_ele.Value != other_ele.Value {
-				return errors.New(fmt.Sprintf("Property %v has value %v and %v.", self_ele.Name, self_ele.Value, other_ele.Value))
+				return &CompatibilityError{
+					Property: self_ele.Name,
+					Msg:      fmt.Sprintf("Property %v has value %v and %v.", self_ele.Name, self_ele.Value, other_ele.Value),
+				}
 			}
 		}
 	}
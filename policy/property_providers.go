@@ -0,0 +1,56 @@
+package policy
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+	"syscall"
+)
+
+// The property providers that are built into the node. Nodes refer to them in their properties, e.g.
+// {"name": "freeDiskGB", "value": "${freeDiskGB}"}.
+
+func init() {
+	RegisterPropertyProvider(&FreeDiskProvider{Path: "/"})
+	RegisterPropertyProvider(&BatteryProvider{PowerSupplyDir: "/sys/class/power_supply"})
+}
+
+// The free disk space available to anax in the file system containing Path, in GB.
+type FreeDiskProvider struct {
+	Path string
+}
+
+func (p *FreeDiskProvider) Name() string {
+	return "freeDiskGB"
+}
+
+func (p *FreeDiskProvider) Value() (interface{}, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(p.Path, &st); err != nil {
+		return nil, err
+	}
+	return float64(st.Bavail*uint64(st.Bsize)) / (1024 * 1024 * 1024), nil
+}
+
+// Whether the node has a battery, based on the power supplies the kernel reports. Nodes without the power supply
+// class in sysfs are reported as not battery powered.
+type BatteryProvider struct {
+	PowerSupplyDir string
+}
+
+func (p *BatteryProvider) Name() string {
+	return "batteryPowered"
+}
+
+func (p *BatteryProvider) Value() (interface{}, error) {
+	supplies, err := ioutil.ReadDir(p.PowerSupplyDir)
+	if err != nil {
+		return false, nil
+	}
+	for _, supply := range supplies {
+		if t, err := ioutil.ReadFile(path.Join(p.PowerSupplyDir, supply.Name(), "type")); err == nil && strings.TrimSpace(string(t)) == "Battery" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,165 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The purpose of this file is to abstract the operations on the TimeWindow type. Time windows are specified on the
+// consumer side. They describe the times of day during which the policy's workload is allowed to run on a node, for
+// example only at night. An agreement is only made while the current time is within one of the windows, and it is
+// cancelled when the time moves outside of all of them. A window without a timezone is in the node's local time. The
+// node advertises its timezone with the timezone property, e.g. {"name": "timezone", "value": "Europe/Helsinki"}.
+// A node that doesn't advertise a timezone is assumed to be on UTC.
+
+const NODE_TIMEZONE_PROPERTY = "timezone"
+
+type TimeWindow struct {
+	Start    string `json:"start"`              // HH:MM, the first minute of the window
+	End      string `json:"end"`                // HH:MM, the first minute after the window. A window that ends before it starts spans midnight.
+	Timezone string `json:"timezone,omitempty"` // The IANA name of the time zone of the window, the node's time zone when omitted
+}
+
+func (w TimeWindow) String() string {
+	tz := w.Timezone
+	if tz == "" {
+		tz = "node local time"
+	}
+	return fmt.Sprintf("%v-%v %v", w.Start, w.End, tz)
+}
+
+// Returns the time of day as minutes since midnight.
+func minuteOfDay(hhmm string) (int, error) {
+	if t, err := time.Parse("15:04", hhmm); err != nil {
+		return 0, errors.New(fmt.Sprintf("time %v is not in the form HH:MM", hhmm))
+	} else {
+		return t.Hour()*60 + t.Minute(), nil
+	}
+}
+
+func (w TimeWindow) IsValid() error {
+	if start, err := minuteOfDay(w.Start); err != nil {
+		return err
+	} else if end, err := minuteOfDay(w.End); err != nil {
+		return err
+	} else if start == end {
+		return errors.New(fmt.Sprintf("time window %v must not start and end at the same time", w))
+	} else if w.Timezone != "" {
+		if _, err := time.LoadLocation(w.Timezone); err != nil {
+			return errors.New(fmt.Sprintf("time window %v has an unknown timezone: %v", w, err))
+		}
+	}
+	return nil
+}
+
+// Return true if the time is within the window. The window is in the time zone of the node when it doesn't have a
+// time zone of its own.
+func (w TimeWindow) Contains(t time.Time, nodeLoc *time.Location) bool {
+	loc := nodeLoc
+	if w.Timezone != "" {
+		if l, err := time.LoadLocation(w.Timezone); err != nil {
+			return false
+		} else {
+			loc = l
+		}
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	start, err1 := minuteOfDay(w.Start)
+	end, err2 := minuteOfDay(w.End)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+
+	lt := t.In(loc)
+	now := lt.Hour()*60 + lt.Minute()
+	if start < end {
+		return now >= start && now < end
+	}
+	return now >= start || now < end
+}
+
+type TimeWindowList []TimeWindow
+
+// A policy without time windows allows its workload to run at any time.
+func (l TimeWindowList) IsEmpty() bool {
+	return len(l) == 0
+}
+
+func (l TimeWindowList) IsValid() error {
+	for _, w := range l {
+		if err := w.IsValid(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return true if 2 lists have the same windows. The windows dont have to be in the same order in both lists.
+func (l TimeWindowList) IsSame(compare TimeWindowList) bool {
+	if len(l) != len(compare) {
+		return false
+	}
+	for _, w := range l {
+		found := false
+		for _, c := range compare {
+			if w == c {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Return true if the time is within any of the windows, or if there are no windows.
+func (l TimeWindowList) Allows(t time.Time, nodeLoc *time.Location) bool {
+	if l.IsEmpty() {
+		return true
+	}
+	for _, w := range l {
+		if w.Contains(t, nodeLoc) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a copy of the windows in which the windows without a time zone are given the node's time zone, so that
+// they can be evaluated without the node's properties.
+func (l TimeWindowList) ForNode(nodeProps PropertyList) TimeWindowList {
+	if l.IsEmpty() {
+		return l
+	}
+	tz := NodeTimezone(nodeProps)
+	res := make(TimeWindowList, 0, len(l))
+	for _, w := range l {
+		if w.Timezone == "" {
+			w.Timezone = tz
+		}
+		res = append(res, w)
+	}
+	return res
+}
+
+// Returns the time zone that a node advertises in its properties, or UTC when the node doesn't advertise a valid one.
+func NodeTimezone(nodeProps PropertyList) string {
+	for _, p := range nodeProps {
+		if p.Name != NODE_TIMEZONE_PROPERTY {
+			continue
+		} else if tz, ok := p.Value.(string); !ok || tz == "" {
+			break
+		} else if _, err := time.LoadLocation(tz); err != nil {
+			break
+		} else {
+			return tz
+		}
+	}
+	return "UTC"
+}
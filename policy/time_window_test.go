@@ -0,0 +1,87 @@
+// +build unit
+
+package policy
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_time_window_valid(t *testing.T) {
+
+	valid := TimeWindowList{{Start: "22:00", End: "06:00"}, {Start: "09:30", End: "10:15", Timezone: "Europe/Helsinki"}}
+	if err := valid.IsValid(); err != nil {
+		t.Errorf("time windows %v should be valid, error %v", valid, err)
+	}
+
+	for _, w := range []TimeWindow{{Start: "22", End: "06:00"}, {Start: "22:00", End: "25:00"}, {Start: "08:00", End: "08:00"}, {Start: "08:00", End: "09:00", Timezone: "Mars/Olympus_Mons"}} {
+		if err := w.IsValid(); err == nil {
+			t.Errorf("time window %v should not be valid", w)
+		}
+	}
+}
+
+func Test_time_window_allows(t *testing.T) {
+
+	helsinki, err := time.LoadLocation("Europe/Helsinki")
+	if err != nil {
+		t.Skipf("time zone data not available: %v", err)
+	}
+
+	at := func(hour int, min int) time.Time {
+		return time.Date(2018, time.January, 10, hour, min, 0, 0, time.UTC)
+	}
+
+	night := TimeWindowList{{Start: "22:00", End: "06:00"}}
+	if !night.Allows(at(23, 0), nil) || !night.Allows(at(5, 59), nil) || !night.Allows(at(22, 0), nil) {
+		t.Errorf("time windows %v should allow the night", night)
+	} else if night.Allows(at(6, 0), nil) || night.Allows(at(12, 0), nil) {
+		t.Errorf("time windows %v should not allow the day", night)
+	}
+
+	// Helsinki is UTC+2 in January, so 21:00 UTC is 23:00 node local time.
+	if !night.Allows(at(21, 0), helsinki) {
+		t.Errorf("time windows %v should allow 23:00 node local time", night)
+	} else if night.Allows(at(5, 0), helsinki) {
+		t.Errorf("time windows %v should not allow 05:00 UTC as 07:00 node local time", night)
+	}
+
+	// A window with its own time zone ignores the node's time zone.
+	day := TimeWindowList{{Start: "09:00", End: "17:00", Timezone: "UTC"}, {Start: "20:00", End: "21:00"}}
+	if !day.Allows(at(10, 0), helsinki) {
+		t.Errorf("time windows %v should allow 10:00 UTC", day)
+	} else if !day.Allows(at(18, 30), helsinki) {
+		t.Errorf("time windows %v should allow 20:30 node local time", day)
+	} else if day.Allows(at(8, 0), helsinki) {
+		t.Errorf("time windows %v should not allow 08:00 UTC", day)
+	}
+
+	if !(TimeWindowList{}).Allows(at(12, 0), nil) {
+		t.Errorf("no time windows should allow any time")
+	}
+}
+
+func Test_time_window_for_node(t *testing.T) {
+
+	windows := TimeWindowList{{Start: "22:00", End: "06:00"}, {Start: "09:00", End: "10:00", Timezone: "UTC"}}
+
+	props := PropertyList{*Property_Factory("cpus", 4), *Property_Factory(NODE_TIMEZONE_PROPERTY, "America/New_York")}
+	if nodeWindows := windows.ForNode(props); nodeWindows[0].Timezone != "America/New_York" || nodeWindows[1].Timezone != "UTC" {
+		t.Errorf("time windows %v should have the node's time zone", nodeWindows)
+	} else if windows[0].Timezone != "" {
+		t.Errorf("the original time windows %v should not change", windows)
+	} else if nodeWindows.IsSame(windows) {
+		t.Errorf("time windows %v and %v should not be the same", nodeWindows, windows)
+	}
+
+	if tz := NodeTimezone(PropertyList{*Property_Factory(NODE_TIMEZONE_PROPERTY, "Nowhere/Special")}); tz != "UTC" {
+		t.Errorf("an invalid time zone should be UTC, was %v", tz)
+	} else if tz := NodeTimezone(nil); tz != "UTC" {
+		t.Errorf("a node without a time zone should be UTC, was %v", tz)
+	}
+
+	reordered := TimeWindowList{windows[1], windows[0]}
+	if !windows.IsSame(reordered) {
+		t.Errorf("time windows %v and %v should be the same", windows, reordered)
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"github.com/golang/glog"
+	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/rsapss-tool/verify"
 	"golang.org/x/crypto/bcrypt"
+	"reflect"
 )
 
 type WorkloadList []Workload
@@ -76,6 +78,7 @@ type Workload struct {
 	DeploymentOverrides          string                `json:"deployment_overrides,omitempty"`           // Added with MS split, env var overrides for the workload
 	DeploymentOverridesSignature string                `json:"deployment_overrides_signature,omitempty"` // Added with MS split, signature of env var overrides
 	ImageStore                   ImplementationPackage `json:"imageStore,omitempty"`                     // Metadata describing how to get the implementation package in a ServiceDefinition
+	ImageFetch                   *cutil.ImageFetch     `json:"imageFetch,omitempty"`                     // How the node pulls the docker images of the deployment, replaces the torrent
 }
 
 func (w Workload) String() string {
@@ -91,9 +94,10 @@ func (w Workload) String() string {
 		"Arch: %v, "+
 		"Deployment Overrides: %v, "+
 		"Deployment Overrides Signature: %v, "+
-		"ImageStore: %v",
+		"ImageStore: %v, "+
+		"ImageFetch: %v",
 		w.Priority, w.Deployment, w.DeploymentSignature, w.DeploymentUserInfo, w.Torrent, w.WorkloadPassword,
-		w.WorkloadURL, w.Org, w.Version, w.Arch, w.DeploymentOverrides, w.DeploymentOverridesSignature, w.ImageStore)
+		w.WorkloadURL, w.Org, w.Version, w.Arch, w.DeploymentOverrides, w.DeploymentOverridesSignature, w.ImageStore, w.ImageFetch)
 }
 
 func (w Workload) ShortString() string {
@@ -132,7 +136,8 @@ func (wl Workload) IsSame(compare Workload) bool {
 		return wl.Deployment == compare.Deployment &&
 			wl.DeploymentSignature == compare.DeploymentSignature &&
 			wl.DeploymentUserInfo == compare.DeploymentUserInfo &&
-			wl.Torrent.IsSame(compare.Torrent)
+			wl.Torrent.IsSame(compare.Torrent) &&
+			reflect.DeepEqual(wl.ImageFetch, compare.ImageFetch)
 
 	} else {
 		return wl.WorkloadURL == compare.WorkloadURL &&
@@ -190,6 +195,37 @@ func (w Workload) HasValidSignature(keyFileNames []string) error {
 
 }
 
+// A pattern or business policy can deploy a workload to nodes of any hardware architecture with this arch. When the agbot
+// makes an agreement, it uses the definition of the workload for the arch of the node.
+const ANY_ARCH = "*"
+
+// Returns true when the workload can be deployed to nodes of any hardware architecture.
+func (w Workload) HasAnyArch() bool {
+	return w.Arch == ANY_ARCH
+}
+
+// Returns true when any of the workloads can be deployed to nodes of any hardware architecture.
+func (self WorkloadList) HasAnyArch() bool {
+	for _, w := range self {
+		if w.HasAnyArch() {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a copy of the workloads in which the workloads for any hardware architecture are for the given arch.
+func (self WorkloadList) ForArch(arch string) WorkloadList {
+	workloads := make(WorkloadList, len(self))
+	copy(workloads, self)
+	for ix := range workloads {
+		if workloads[ix].HasAnyArch() {
+			workloads[ix].Arch = arch
+		}
+	}
+	return workloads
+}
+
 func (w Workload) HasEmptyPriority() bool {
 	if w.Priority.PriorityValue == 0 && w.Priority.Retries == 0 && w.Priority.RetryDurationS == 0 {
 		return true
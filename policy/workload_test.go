@@ -456,6 +456,21 @@ func Test_WorkloadFactory(t *testing.T) {
 	}
 }
 
+func Test_WorkloadList_ForArch(t *testing.T) {
+
+	wl := WorkloadList{*Workload_Factory("myurl", "myorg", "1.0.0", ANY_ARCH), *Workload_Factory("myurl", "myorg", "2.0.0", "amd64")}
+	if !wl.HasAnyArch() {
+		t.Errorf("Workloads %v should be for any arch.", wl)
+	}
+
+	arm := wl.ForArch("arm64")
+	if arm[0].Arch != "arm64" || arm[1].Arch != "amd64" {
+		t.Errorf("Workloads %v were not resolved for arm64 correctly.", arm)
+	} else if wl[0].Arch != ANY_ARCH || arm.HasAnyArch() {
+		t.Errorf("Workloads %v should have been copied, the original is %v.", arm, wl)
+	}
+}
+
 func Test_WorkloadPriority_Factory(t *testing.T) {
 
 	wl := Workload_Priority_Factory(50, 2, 120, 240)
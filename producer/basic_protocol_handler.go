@@ -8,6 +8,7 @@ import (
 	"github.com/open-horizon/anax/abstractprotocol"
 	"github.com/open-horizon/anax/basicprotocol"
 	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/exchange"
 	"github.com/open-horizon/anax/persistence"
@@ -18,6 +19,7 @@ import (
 type BasicProtocolHandler struct {
 	*BaseProducerProtocolHandler
 	agreementPH *basicprotocol.ProtocolHandler
+	messenger   *basicprotocol.ReliableMessenger // keeps the V2 protocol messages until the agbot acknowledges them
 }
 
 func NewBasicProtocolHandler(name string, cfg *config.HorizonConfig, db *bolt.DB, pm *policy.PolicyManager, ec exchange.ExchangeContext) *BasicProtocolHandler {
@@ -31,6 +33,7 @@ func NewBasicProtocolHandler(name string, cfg *config.HorizonConfig, db *bolt.DB
 				ec:     ec,
 			},
 			agreementPH: basicprotocol.NewProtocolHandler(cfg.Collaborators.HTTPClientFactory.NewHTTPClient(nil), pm),
+			messenger:   basicprotocol.NewReliableMessenger(db),
 		}
 	} else {
 		return nil
@@ -60,9 +63,89 @@ func (c *BasicProtocolHandler) AcceptCommand(cmd worker.Command) bool {
 	return false
 }
 
+// The messages of V2 agreements are sent reliably, the other messages are sent once.
+func (c *BasicProtocolHandler) GetSendMessage() func(mt interface{}, pay []byte) error {
+	return c.sendMessage
+}
+
+func (c *BasicProtocolHandler) sendMessage(mt interface{}, pay []byte) error {
+
+	send := c.BaseProducerProtocolHandler.GetSendMessage()
+
+	if header := basicprotocol.ReliableMessageHeader(pay); header == nil || !c.isReliable(header) {
+		return send(mt, pay)
+	} else if messageTarget, ok := mt.(*exchange.ExchangeMessageTarget); !ok {
+		return send(mt, pay)
+	} else {
+		return c.messenger.Send(header.AgreementId(), messageTarget.ReceiverExchangeId, pay, func(p []byte) error { return send(mt, p) })
+	}
+}
+
+// Returns true if the message belongs to an agreement that uses the V2 protocol.
+func (c *BasicProtocolHandler) isReliable(msg *abstractprotocol.BaseProtocolMessage) bool {
+	if basicprotocol.CarriesAgreementVersion(msg) {
+		return msg.Version() >= 2
+	} else if ags, err := persistence.FindEstablishedAgreements(c.db, c.Name(), []persistence.EAFilter{persistence.IdEAFilter(msg.AgreementId())}); err != nil {
+		glog.Errorf(BPHlogString(fmt.Sprintf("unable to retrieve agreement %v from database, error %v", msg.AgreementId(), err)))
+		return false
+	} else {
+		return len(ags) != 0 && ags[0].ProtocolVersion >= 2
+	}
+}
+
+// Send the messages that the agbots have not acknowledged again.
+func (c *BasicProtocolHandler) ResendMessages() {
+	c.messenger.Resend(func(receiverId string, pay []byte) error {
+		if whisperTo, pubkeyTo, err := c.GetAgbotMessageEndpoint(receiverId); err != nil {
+			return err
+		} else if mt, err := exchange.CreateMessageTarget(receiverId, nil, pubkeyTo, whisperTo); err != nil {
+			return err
+		} else {
+			return c.BaseProducerProtocolHandler.GetSendMessage()(mt, pay)
+		}
+	})
+}
+
+// Acknowledge a message of a V2 agreement. Returns true if the message needs no further processing, because it is a
+// message ack or because it was already received in an earlier exchange message.
+func (c *BasicProtocolHandler) ReliableMessageReceived(protocolMsg string, exchangeMsg *exchange.DeviceMessage) bool {
+
+	if ack, err := c.agreementPH.ValidateMessageAck(protocolMsg); err == nil {
+		glog.V(5).Infof(BPHlogString(fmt.Sprintf("received message ack %v", ack)))
+		if err := c.messenger.Acknowledged(ack.MsgId); err != nil {
+			glog.Errorf(BPHlogString(fmt.Sprintf("unable to record message ack %v, error %v", ack, err)))
+		}
+		return true
+	}
+
+	header := basicprotocol.ReliableMessageHeader([]byte(protocolMsg))
+	if header == nil {
+		return false
+	}
+	msgId := basicprotocol.GetMessageId(protocolMsg)
+	if msgId == "" {
+		return false
+	}
+
+	if mt, err := exchange.CreateMessageTarget(exchangeMsg.AgbotId, nil, exchangeMsg.AgbotPubKey, ""); err != nil {
+		glog.Errorf(BPHlogString(fmt.Sprintf("error creating message target: %v", err)))
+	} else if err := c.agreementPH.SendMessageAck(header.AgreementId(), msgId, mt, c.BaseProducerProtocolHandler.GetSendMessage()); err != nil {
+		glog.Errorf(BPHlogString(err.Error()))
+	}
+
+	if duplicate, err := c.messenger.Received(msgId, exchangeMsg.MsgId); err != nil {
+		glog.Errorf(BPHlogString(fmt.Sprintf("unable to record received message %v, error %v", msgId, err)))
+		return false
+	} else if duplicate {
+		glog.V(3).Infof(BPHlogString(fmt.Sprintf("ignoring message %v for agreement %v, it was already received", msgId, header.AgreementId())))
+		return true
+	}
+	return false
+}
+
 func (c *BasicProtocolHandler) HandleProposalMessage(proposal abstractprotocol.Proposal, protocolMsg string, exchangeMsg *exchange.DeviceMessage) bool {
 
-	if handled, reply, tcPolicy := c.HandleProposal(c.agreementPH, proposal, protocolMsg, []map[string]string{}, exchangeMsg); handled {
+	if handled, reply, tcPolicy := c.HandleProposal(c.agreementPH, proposal, protocolMsg, []map[string]string{}, exchangeMsg, c.GetSendMessage()); handled {
 		if reply != nil {
 			c.PersistProposal(proposal, reply, tcPolicy, protocolMsg)
 		}
@@ -101,6 +184,18 @@ func (c *BasicProtocolHandler) VerifyAgreement(ag *persistence.EstablishedAgreem
 	}
 }
 
+// Tell the agbot how much of the node the workload of an agreement is using.
+func (c *BasicProtocolHandler) ReportResourceUsage(ag *persistence.EstablishedAgreement, usage events.ResourceUsage) error {
+	if _, pubkey, err := c.BaseProducerProtocolHandler.GetAgbotMessageEndpoint(ag.ConsumerId); err != nil {
+		return errors.New(BPHlogString(fmt.Sprintf("error getting agbot message target: %v", err)))
+	} else if mt, err := exchange.CreateMessageTarget(ag.ConsumerId, nil, pubkey, ""); err != nil {
+		return errors.New(BPHlogString(fmt.Sprintf("error creating message target: %v", err)))
+	} else if err := c.agreementPH.SendResourceUsage(ag.CurrentAgreementId, usage, mt, c.GetSendMessage()); err != nil {
+		return errors.New(BPHlogString(fmt.Sprintf("error reporting resource usage of agreement %v, error %v", ag.CurrentAgreementId, err)))
+	}
+	return nil
+}
+
 // Returns 2 booleans, first is whether or not the message was handled, the second is whether or not to cancel the agreement in the protocol msg.
 func (c *BasicProtocolHandler) HandleExtensionMessages(msg *events.ExchangeDeviceMessage, exchangeMsg *exchange.DeviceMessage) (bool, bool, string, error) {
 
@@ -115,49 +210,12 @@ func (c *BasicProtocolHandler) HandleExtensionMessages(msg *events.ExchangeDevic
 	return false, false, "", nil
 }
 
+func (c *BasicProtocolHandler) DeferExtensionMessage(msg *events.ExchangeDeviceMessage, exchangeMsg *exchange.DeviceMessage) (bool, error) {
+	return c.DeferUnknownExtensionMessage(c.agreementPH, []string{basicprotocol.MsgTypeVerifyAgreement, basicprotocol.MsgTypeVerifyAgreementReply, basicprotocol.MsgTypeMessageAck}, msg, exchangeMsg)
+}
+
 func (c *BasicProtocolHandler) GetTerminationCode(reason string) uint {
-	switch reason {
-	case TERM_REASON_POLICY_CHANGED:
-		return basicprotocol.CANCEL_POLICY_CHANGED
-	case TERM_REASON_AGBOT_REQUESTED:
-		return basicprotocol.CANCEL_AGBOT_REQUESTED
-	case TERM_REASON_CONTAINER_FAILURE:
-		return basicprotocol.CANCEL_CONTAINER_FAILURE
-	//case TERM_REASON_TORRENT_FAILURE:
-	//	return basicprotocol.CANCEL_TORRENT_FAILURE
-	case TERM_REASON_USER_REQUESTED:
-		return basicprotocol.CANCEL_USER_REQUESTED
-	// case TERM_REASON_NOT_FINALIZED_TIMEOUT:
-	//     return citizenscientist.CANCEL_NOT_FINALIZED_TIMEOUT
-	case TERM_REASON_NO_REPLY_ACK:
-		return basicprotocol.CANCEL_NO_REPLY_ACK
-	case TERM_REASON_NOT_EXECUTED_TIMEOUT:
-		return basicprotocol.CANCEL_NOT_EXECUTED_TIMEOUT
-	case TERM_REASON_MICROSERVICE_FAILURE:
-		return basicprotocol.CANCEL_MICROSERVICE_FAILURE
-	case TERM_REASON_WL_IMAGE_LOAD_FAILURE:
-		return basicprotocol.CANCEL_WL_IMAGE_LOAD_FAILURE
-	case TERM_REASON_MS_IMAGE_LOAD_FAILURE:
-		return basicprotocol.CANCEL_MS_IMAGE_LOAD_FAILURE
-	case TERM_REASON_MS_IMAGE_FETCH_FAILURE:
-		return basicprotocol.CANCEL_MS_IMAGE_FETCH_FAILURE
-	case TERM_REASON_MS_UPGRADE_REQUIRED:
-		return basicprotocol.CANCEL_MS_UPGRADE_REQUIRED
-	case TERM_REASON_MS_DOWNGRADE_REQUIRED:
-		return basicprotocol.CANCEL_MS_DOWNGRADE_REQUIRED
-	case TERM_REASON_IMAGE_DATA_ERROR:
-		return basicprotocol.CANCEL_IMAGE_DATA_ERROR
-	case TERM_REASON_IMAGE_FETCH_FAILURE:
-		return basicprotocol.CANCEL_IMAGE_FETCH_FAILURE
-	case TERM_REASON_IMAGE_FETCH_AUTH_FAILURE:
-		return basicprotocol.CANCEL_IMAGE_FETCH_AUTH_FAILURE
-	case TERM_REASON_IMAGE_SIG_VERIF_FAILURE:
-		return basicprotocol.CANCEL_IMAGE_SIG_VERIF_FAILURE
-	case TERM_REASON_NODE_SHUTDOWN:
-		return basicprotocol.CANCEL_NODE_SHUTDOWN
-	default:
-		return 999
-	}
+	return basicprotocol.Reasons.Code(abstractprotocol.REASON_PRODUCER, reason)
 }
 
 func (c *BasicProtocolHandler) GetTerminationReason(code uint) string {
@@ -184,5 +242,8 @@ func (c *BasicProtocolHandler) IsAgreementVerifiable(ag *persistence.Established
 // Utility functions
 
 var BPHlogString = func(v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("Producer Basic Protocol Handler", nil, v)
+	}
 	return fmt.Sprintf("Producer Basic Protocol Handler %v", v)
 }
@@ -8,6 +8,7 @@ import (
 	"github.com/open-horizon/anax/abstractprotocol"
 	"github.com/open-horizon/anax/citizenscientist"
 	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/exchange"
 	"github.com/open-horizon/anax/persistence"
@@ -82,7 +83,7 @@ func (c *CSProtocolHandler) AcceptCommand(cmd worker.Command) bool {
 	switch cmd.(type) {
 	case *BlockchainEventCommand:
 		bcc := cmd.(*BlockchainEventCommand)
-		if c.IsBlockchainClientAvailable(policy.Ethereum_bc, bcc.Msg.Name(), bcc.Msg.Org()) {
+		if c.IsBlockchainClientAvailable(bcc.Msg.BlockchainType(), bcc.Msg.Name(), bcc.Msg.Org()) {
 			return true
 		} else {
 			return false
@@ -98,14 +99,14 @@ func (c *CSProtocolHandler) HandleProposalMessage(proposal abstractprotocol.Prop
 		return true
 	}
 
-	// Grab the list of running BCs that we know about for the ethereum type
+	// Grab the list of running BCs that we know about for the blockchain types of the protocol
 	runningBCs := make([]map[string]string, 0, 5)
 	for org, typeMap := range c.bcState {
 		for bcType, nameMap := range typeMap {
-			if bcType == policy.Ethereum_bc {
+			if policy.SupportsBlockchainType(c.Name(), bcType) {
 				for name, bc := range nameMap {
 					if bc.ready {
-						runningBCs = append(runningBCs, map[string]string{"name": name, "org": org})
+						runningBCs = append(runningBCs, map[string]string{"type": bcType, "name": name, "org": org})
 					}
 				}
 			}
@@ -113,7 +114,7 @@ func (c *CSProtocolHandler) HandleProposalMessage(proposal abstractprotocol.Prop
 	}
 
 	// Go make a decision about the proposal
-	if handled, reply, tcPolicy := c.HandleProposal(c.genericAgreementPH, proposal, protocolMsg, runningBCs, exchangeMsg); handled {
+	if handled, reply, tcPolicy := c.HandleProposal(c.genericAgreementPH, proposal, protocolMsg, runningBCs, exchangeMsg, c.GetSendMessage()); handled {
 		if reply != nil {
 			c.PersistProposal(proposal, reply, tcPolicy, protocolMsg)
 		}
@@ -137,7 +138,7 @@ func (c *CSProtocolHandler) PersistProposal(p abstractprotocol.Proposal, r abstr
 
 func (c *CSProtocolHandler) HandleBlockchainEventMessage(cmd *BlockchainEventCommand) (string, bool, uint64, bool, error) {
 	// Unmarshal the raw event
-	if rawEvent, err := c.genericAgreementPH.DemarshalEvent(cmd.Msg.RawEvent()); err != nil {
+	if rawEvent, err := c.genericAgreementPH.DemarshalEvent(cmd.Msg.BlockchainType(), cmd.Msg.RawEvent()); err != nil {
 		return "", false, 0, false, errors.New(PPHlogString(fmt.Sprintf("unable to demarshal raw event %v, error: %v", cmd.Msg.RawEvent(), err)))
 	} else {
 		agId := c.genericAgreementPH.GetAgreementId(rawEvent)
@@ -172,48 +173,7 @@ func (c *CSProtocolHandler) TerminateAgreement(ag *persistence.EstablishedAgreem
 }
 
 func (c *CSProtocolHandler) GetTerminationCode(reason string) uint {
-	switch reason {
-	case TERM_REASON_POLICY_CHANGED:
-		return citizenscientist.CANCEL_POLICY_CHANGED
-	case TERM_REASON_AGBOT_REQUESTED:
-		return citizenscientist.CANCEL_AGBOT_REQUESTED
-	case TERM_REASON_CONTAINER_FAILURE:
-		return citizenscientist.CANCEL_CONTAINER_FAILURE
-	//case TERM_REASON_TORRENT_FAILURE:
-	//	return citizenscientist.CANCEL_TORRENT_FAILURE
-	case TERM_REASON_USER_REQUESTED:
-		return citizenscientist.CANCEL_USER_REQUESTED
-	case TERM_REASON_NOT_FINALIZED_TIMEOUT:
-		return citizenscientist.CANCEL_NOT_FINALIZED_TIMEOUT
-	case TERM_REASON_NO_REPLY_ACK:
-		return citizenscientist.CANCEL_NO_REPLY_ACK
-	case TERM_REASON_NOT_EXECUTED_TIMEOUT:
-		return citizenscientist.CANCEL_NOT_EXECUTED_TIMEOUT
-	case TERM_REASON_MICROSERVICE_FAILURE:
-		return citizenscientist.CANCEL_MICROSERVICE_FAILURE
-	case TERM_REASON_WL_IMAGE_LOAD_FAILURE:
-		return citizenscientist.CANCEL_WL_IMAGE_LOAD_FAILURE
-	case TERM_REASON_MS_IMAGE_LOAD_FAILURE:
-		return citizenscientist.CANCEL_MS_IMAGE_LOAD_FAILURE
-	case TERM_REASON_MS_IMAGE_FETCH_FAILURE:
-		return citizenscientist.CANCEL_MS_IMAGE_FETCH_FAILURE
-	case TERM_REASON_MS_UPGRADE_REQUIRED:
-		return citizenscientist.CANCEL_MS_UPGRADE_REQUIRED
-	case TERM_REASON_MS_DOWNGRADE_REQUIRED:
-		return citizenscientist.CANCEL_MS_DOWNGRADE_REQUIRED
-	case TERM_REASON_IMAGE_DATA_ERROR:
-		return citizenscientist.CANCEL_IMAGE_DATA_ERROR
-	case TERM_REASON_IMAGE_FETCH_FAILURE:
-		return citizenscientist.CANCEL_IMAGE_FETCH_FAILURE
-	case TERM_REASON_IMAGE_FETCH_AUTH_FAILURE:
-		return citizenscientist.CANCEL_IMAGE_FETCH_AUTH_FAILURE
-	case TERM_REASON_IMAGE_SIG_VERIF_FAILURE:
-		return citizenscientist.CANCEL_IMAGE_SIG_VERIF_FAILURE
-	case TERM_REASON_NODE_SHUTDOWN:
-		return citizenscientist.CANCEL_NODE_SHUTDOWN
-	default:
-		return 999
-	}
+	return citizenscientist.Reasons.Code(abstractprotocol.REASON_PRODUCER, reason)
 }
 
 func (c *CSProtocolHandler) GetTerminationReason(code uint) string {
@@ -234,7 +194,7 @@ func (c *CSProtocolHandler) IsBlockchainClientAvailable(typeName string, name st
 }
 
 func (c *CSProtocolHandler) SetBlockchainClientNotAvailable(cmd *BCStoppingCommand) {
-	nameMap := c.getBCNameMap(cmd.Msg.BlockchainOrg(), policy.Ethereum_bc)
+	nameMap := c.getBCNameMap(cmd.Msg.BlockchainOrg(), cmd.Msg.BlockchainType())
 
 	delete(nameMap, cmd.Msg.BlockchainInstance())
 
@@ -433,6 +393,16 @@ func (c *CSProtocolHandler) HandleExtensionMessages(msg *events.ExchangeDeviceMe
 	return deleteMessage, false, "", nil
 }
 
+func (c *CSProtocolHandler) DeferExtensionMessage(msg *events.ExchangeDeviceMessage, exchangeMsg *exchange.DeviceMessage) (bool, error) {
+	knownTypes := []string{
+		citizenscientist.MsgTypeBlockchainConsumerUpdate,
+		citizenscientist.MsgTypeBlockchainConsumerUpdateAck,
+		citizenscientist.MsgTypeBlockchainProducerUpdate,
+		citizenscientist.MsgTypeBlockchainProducerUpdateAck,
+	}
+	return c.DeferUnknownExtensionMessage(c.genericAgreementPH, knownTypes, msg, exchangeMsg)
+}
+
 func (c *CSProtocolHandler) GetKnownBlockchain(ag *persistence.EstablishedAgreement) (string, string, string) {
 	return ag.BlockchainType, ag.BlockchainName, ag.BlockchainOrg
 }
@@ -441,5 +411,8 @@ func (c *CSProtocolHandler) GetKnownBlockchain(ag *persistence.EstablishedAgreem
 // Utility functions
 
 var PPHlogString = func(v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("Producer CS Protocol Handler", nil, v)
+	}
 	return fmt.Sprintf("Producer CS Protocol Handler %v", v)
 }
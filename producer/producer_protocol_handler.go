@@ -9,10 +9,13 @@ import (
 	"github.com/open-horizon/anax/abstractprotocol"
 	"github.com/open-horizon/anax/api"
 	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/containermessage"
+	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/exchange"
 	"github.com/open-horizon/anax/persistence"
 	"github.com/open-horizon/anax/policy"
+	"github.com/open-horizon/anax/version"
 	"github.com/open-horizon/anax/worker"
 	"strings"
 	"time"
@@ -45,10 +48,14 @@ type ProducerProtocolHandler interface {
 	IsBlockchainWritable(agreement *persistence.EstablishedAgreement) bool
 	IsAgreementVerifiable(agreement *persistence.EstablishedAgreement) bool
 	HandleExtensionMessages(msg *events.ExchangeDeviceMessage, exchangeMsg *exchange.DeviceMessage) (bool, bool, string, error)
+	DeferExtensionMessage(msg *events.ExchangeDeviceMessage, exchangeMsg *exchange.DeviceMessage) (bool, error)
 	UpdateConsumer(ag *persistence.EstablishedAgreement)
 	UpdateConsumers()
 	GetKnownBlockchain(ag *persistence.EstablishedAgreement) (string, string, string)
 	VerifyAgreement(ag *persistence.EstablishedAgreement) (bool, error)
+	ReliableMessageReceived(protocolMsg string, exchangeMsg *exchange.DeviceMessage) bool
+	ResendMessages()
+	ReportResourceUsage(ag *persistence.EstablishedAgreement, usage events.ResourceUsage) error
 }
 
 type BaseProducerProtocolHandler struct {
@@ -95,30 +102,26 @@ func (w *BaseProducerProtocolHandler) sendMessage(mt interface{}, pay []byte) er
 		}
 	}
 
-	// Create an encrypted message
-	if encryptedMsg, err := exchange.ConstructExchangeMessage(pay, myPubKey, myPrivKey, messageTarget.ReceiverPublicKeyObj); err != nil {
+	// The proposal and its reply tell whether the other party reads sealed messages.
+	if negotiated, sealed := abstractprotocol.NegotiatesSealing(pay); negotiated {
+		exchange.SetMessageSealing(messageTarget.ReceiverExchangeId, sealed)
+	}
+
+	// Create an encrypted message, sealed when the receiver reads sealed messages
+	if encryptedMsg, err := exchange.ConstructMessage(pay, exchange.MessageSealing(messageTarget.ReceiverExchangeId), myPubKey, myPrivKey, messageTarget.ReceiverPublicKeyObj); err != nil {
 		return errors.New(fmt.Sprintf("Unable to construct encrypted message from %v, error %v", pay, err))
 		// Marshal it into a byte array
 	} else if msgBody, err := json.Marshal(encryptedMsg); err != nil {
 		return errors.New(fmt.Sprintf("Unable to marshal exchange message %v, error %v", encryptedMsg, err))
 		// Send it to the device's message queue
 	} else {
+		transport := exchange.EdgeMessageTransport(w.config)
 		pm := exchange.CreatePostMessage(msgBody, w.config.Edge.ExchangeMessageTTL)
-		var resp interface{}
-		resp = new(exchange.PostDeviceResponse)
-		targetURL := w.config.Edge.ExchangeURL + "orgs/" + exchange.GetOrg(messageTarget.ReceiverExchangeId) + "/agbots/" + exchange.GetId(messageTarget.ReceiverExchangeId) + "/msgs"
-		for {
-			if err, tpErr := exchange.InvokeExchange(w.config.Collaborators.HTTPClientFactory.NewHTTPClient(nil), "POST", targetURL, w.ec.GetExchangeId(), w.ec.GetExchangeToken(), pm, &resp); err != nil {
-				return err
-			} else if tpErr != nil {
-				glog.Warningf(tpErr.Error())
-				time.Sleep(10 * time.Second)
-				continue
-			} else {
-				glog.V(5).Infof(BPPHlogString(w.Name(), fmt.Sprintf("Sent message for %v to exchange.", messageTarget.ReceiverExchangeId)))
-				return nil
-			}
+		if err := transport.Post(w.config.Collaborators.HTTPClientFactory.NewHTTPClient(nil), transport.AgbotMailboxURL(messageTarget.ReceiverExchangeId), w.ec.GetExchangeId(), w.ec.GetExchangeToken(), pm); err != nil {
+			return err
 		}
+		glog.V(5).Infof(BPPHlogString(w.Name(), fmt.Sprintf("Sent message for %v to %v.", messageTarget.ReceiverExchangeId, transport.Name)))
+		return nil
 	}
 }
 
@@ -135,7 +138,7 @@ func (w *BaseProducerProtocolHandler) workloadOrServiceResolver(wURL string, wOr
 	return asl, err
 }
 
-func (w *BaseProducerProtocolHandler) HandleProposal(ph abstractprotocol.ProtocolHandler, proposal abstractprotocol.Proposal, protocolMsg string, runningBCs []map[string]string, exchangeMsg *exchange.DeviceMessage) (bool, abstractprotocol.ProposalReply, *policy.Policy) {
+func (w *BaseProducerProtocolHandler) HandleProposal(ph abstractprotocol.ProtocolHandler, proposal abstractprotocol.Proposal, protocolMsg string, runningBCs []map[string]string, exchangeMsg *exchange.DeviceMessage, sendMessage func(mt interface{}, pay []byte) error) (bool, abstractprotocol.ProposalReply, *policy.Policy) {
 
 	handled := false
 
@@ -144,6 +147,24 @@ func (w *BaseProducerProtocolHandler) HandleProposal(ph abstractprotocol.Protoco
 	} else if len(agAlreadyExists) != 0 {
 		glog.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("agreement %v already exists, ignoring proposal: %v", proposal.AgreementId(), proposal.ShortString())))
 		handled = true
+	} else if proposal.IsExpired() {
+		// The consumer has already given up on a proposal that sat in the mailbox too long, so dont bother deciding on it.
+		glog.Warningf(BPPHlogString(w.Name(), fmt.Sprintf("rejecting proposal %v, it expired at %v", proposal.ShortString(), proposal.Expiry())))
+		handled = true
+		if messageTarget, err := exchange.CreateMessageTarget(exchangeMsg.AgbotId, nil, exchangeMsg.AgbotPubKey, ""); err != nil {
+			glog.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("error creating message target: %v", err)))
+		} else if err := abstractprotocol.RejectProposal(ph, proposal, w.ec.GetExchangeId(), abstractprotocol.REJECT_PROPOSAL_EXPIRED, messageTarget, sendMessage); err != nil {
+			glog.Errorf(BPPHlogString(w.Name(), err.Error()))
+		}
+	} else if cutil.DiskSpaceLow() {
+		// The images of a new workload could fill the disk, so the node takes no new agreements until space is freed.
+		glog.Warningf(BPPHlogString(w.Name(), fmt.Sprintf("rejecting proposal %v, the node is short of disk space: %v", proposal.ShortString(), cutil.GetDiskSpaceState().FileSystems)))
+		handled = true
+		if messageTarget, err := exchange.CreateMessageTarget(exchangeMsg.AgbotId, nil, exchangeMsg.AgbotPubKey, ""); err != nil {
+			glog.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("error creating message target: %v", err)))
+		} else if err := abstractprotocol.RejectProposal(ph, proposal, w.ec.GetExchangeId(), abstractprotocol.REJECT_NODE_LOW_DISK, messageTarget, sendMessage); err != nil {
+			glog.Errorf(BPPHlogString(w.Name(), err.Error()))
+		}
 	} else if tcPolicy, err := policy.DemarshalPolicy(proposal.TsAndCs()); err != nil {
 		glog.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("received error demarshalling TsAndCs, %v", err)))
 	} else if err := w.saveSigningKeys(tcPolicy); err != nil {
@@ -169,9 +190,36 @@ func (w *BaseProducerProtocolHandler) HandleProposal(ph abstractprotocol.Protoco
 		handled = true
 	} else if messageTarget, err := exchange.CreateMessageTarget(exchangeMsg.AgbotId, nil, exchangeMsg.AgbotPubKey, ""); err != nil {
 		glog.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("error creating message target: %v", err)))
+	} else if exceeded, err := w.CheckAgreementLimits(tcPolicy); err != nil {
+		glog.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("error checking agreement limits, error %v", err)))
+		handled = true
+	} else if exceeded != "" {
+		glog.Warningf(BPPHlogString(w.Name(), fmt.Sprintf("rejecting proposal %v, %v", proposal.ShortString(), exceeded)))
+		handled = true
+		if err := abstractprotocol.RejectProposal(ph, proposal, w.ec.GetExchangeId(), abstractprotocol.REJECT_NODE_AGREEMENT_LIMIT, messageTarget, sendMessage); err != nil {
+			glog.Errorf(BPPHlogString(w.Name(), err.Error()))
+		}
+	} else if missing, err := MissingWorkloadDevices(tcPolicy, containermessage.HostDeviceExists); err != nil {
+		glog.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("error checking the devices of the workload, error %v", err)))
+		handled = true
+	} else if len(missing) != 0 {
+		glog.Warningf(BPPHlogString(w.Name(), fmt.Sprintf("rejecting proposal %v, the workload needs devices %v that are missing from the node", proposal.ShortString(), missing)))
+		handled = true
+		if err := abstractprotocol.RejectProposal(ph, proposal, w.ec.GetExchangeId(), abstractprotocol.REJECT_NODE_MISSING_DEVICES, messageTarget, sendMessage); err != nil {
+			glog.Errorf(BPPHlogString(w.Name(), err.Error()))
+		}
+	} else if rolledBack, err := w.RolledBackWorkload(tcPolicy); err != nil {
+		glog.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("error checking for a rolled back workload, error %v", err)))
+		handled = true
+	} else if rolledBack != nil {
+		glog.Warningf(BPPHlogString(w.Name(), fmt.Sprintf("rejecting proposal %v, version %v of workload %v was rolled back at %v", proposal.ShortString(), rolledBack.RolledBackVersion, rolledBack.WorkloadURL, rolledBack.RollbackTime)))
+		handled = true
+		if err := abstractprotocol.RejectProposal(ph, proposal, w.ec.GetExchangeId(), abstractprotocol.REJECT_NODE_WORKLOAD_ROLLBACK, messageTarget, sendMessage); err != nil {
+			glog.Errorf(BPPHlogString(w.Name(), err.Error()))
+		}
 	} else {
 		handled = true
-		if r, err := ph.DecideOnProposal(proposal, w.ec.GetExchangeId(), exchange.GetOrg(w.ec.GetExchangeId()), runningBCs, messageTarget, w.sendMessage); err != nil {
+		if r, err := ph.DecideOnProposal(proposal, w.ec.GetExchangeId(), exchange.GetOrg(w.ec.GetExchangeId()), runningBCs, messageTarget, sendMessage); err != nil {
 			glog.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("respond to proposal with error: %v", err)))
 		} else {
 			return handled, r, tcPolicy
@@ -306,6 +354,81 @@ func (w *BaseProducerProtocolHandler) FindAgreementWithSameWorkload(ph abstractp
 	return false, nil
 }
 
+// Check the node's agreement limits. Returns a description of the limit that another agreement for the workload in
+// the policy would exceed, or an empty string when there is room for it. Agreements of all protocols count.
+func (w *BaseProducerProtocolHandler) CheckAgreementLimits(tcPolicy *policy.Policy) (string, error) {
+
+	notTerminated := func() persistence.EAFilter {
+		return func(a persistence.EstablishedAgreement) bool {
+			return a.AgreementTerminatedTime == 0
+		}
+	}
+
+	if pDevice, err := persistence.FindExchangeDevice(w.db); err != nil {
+		return "", errors.New(fmt.Sprintf("unable to read node object, error %v", err))
+	} else if pDevice == nil || (pDevice.AgreementLimits.MaxAgreementsPerWorkload == 0 && pDevice.AgreementLimits.MaxTotalAgreements == 0) {
+		return "", nil
+	} else if ags, err := persistence.FindEstablishedAgreementsAllProtocols(w.db, policy.AllAgreementProtocols(), []persistence.EAFilter{notTerminated(), persistence.UnarchivedEAFilter()}); err != nil {
+		return "", errors.New(fmt.Sprintf("error retrieving unarchived agreements from db: %v", err))
+	} else if len(tcPolicy.Workloads) == 0 {
+		return agreementLimitExceeded(pDevice.AgreementLimits, ags, "", ""), nil
+	} else {
+		return agreementLimitExceeded(pDevice.AgreementLimits, ags, tcPolicy.Workloads[0].WorkloadURL, tcPolicy.Workloads[0].Org), nil
+	}
+}
+
+func agreementLimitExceeded(limits persistence.AgreementLimits, ags []persistence.EstablishedAgreement, wURL string, wOrg string) string {
+
+	if limits.MaxTotalAgreements != 0 && len(ags) >= limits.MaxTotalAgreements {
+		return fmt.Sprintf("the node already has %v agreements, the most it allows is %v", len(ags), limits.MaxTotalAgreements)
+	}
+
+	if limits.MaxAgreementsPerWorkload != 0 && wURL != "" {
+		count := 0
+		for _, ag := range ags {
+			if ag.RunningWorkload.URL == wURL && ag.RunningWorkload.Org == wOrg {
+				count += 1
+			}
+		}
+		if count >= limits.MaxAgreementsPerWorkload {
+			return fmt.Sprintf("the node already has %v agreements for workload %v/%v, the most it allows is %v", count, wOrg, wURL, limits.MaxAgreementsPerWorkload)
+		}
+	}
+
+	return ""
+}
+
+// Returns the host devices that the workload in the policy needs and that are missing from the node. The services the
+// workload depends on are checked by the container worker when their containers are created.
+func MissingWorkloadDevices(tcPolicy *policy.Policy, exists func(hostPath string) bool) ([]string, error) {
+	if len(tcPolicy.Workloads) == 0 || tcPolicy.Workloads[0].Deployment == "" {
+		return []string{}, nil
+	}
+
+	deployment := new(containermessage.DeploymentDescription)
+	if err := json.Unmarshal([]byte(tcPolicy.Workloads[0].Deployment), deployment); err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to demarshal deployment %v, error %v", tcPolicy.Workloads[0].Deployment, err))
+	}
+	return deployment.MissingDevices(exists), nil
+}
+
+// Returns the versions record of the workload in the policy when the proposed version is the one that the node rolled
+// back, nil otherwise. Only an enabled rollback rejects proposals.
+func (w *BaseProducerProtocolHandler) RolledBackWorkload(tcPolicy *policy.Policy) (*persistence.WorkloadVersions, error) {
+	if !w.config.Edge.WorkloadRollback.Enabled() || len(tcPolicy.Workloads) == 0 {
+		return nil, nil
+	}
+
+	wl := tcPolicy.Workloads[0]
+	if versions, err := persistence.FindWorkloadVersions(w.db, wl.WorkloadURL, wl.Org); err != nil {
+		return nil, err
+	} else if versions == nil || versions.RolledBackVersion == "" || versions.RolledBackVersion != wl.Version {
+		return nil, nil
+	} else {
+		return versions, nil
+	}
+}
+
 func (w *BaseProducerProtocolHandler) PersistProposal(proposal abstractprotocol.Proposal, reply abstractprotocol.ProposalReply, tcPolicy *policy.Policy, protocolMsg string) {
 	if wi, err := persistence.NewWorkloadInfo(tcPolicy.Workloads[0].WorkloadURL, tcPolicy.Workloads[0].Org, tcPolicy.Workloads[0].Version, tcPolicy.Workloads[0].Arch); err != nil {
 		glog.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("error creating workload info object from %v, error: %v", tcPolicy.Workloads[0], err)))
@@ -376,8 +499,19 @@ func (b *BaseProducerProtocolHandler) HandleExtensionMessages(msg *events.Exchan
 	return false, false, "", nil
 }
 
+// Protocols without reliable messaging dont acknowledge messages, so every message needs to be processed.
+func (b *BaseProducerProtocolHandler) ReliableMessageReceived(protocolMsg string, exchangeMsg *exchange.DeviceMessage) bool {
+	return false
+}
+
+func (b *BaseProducerProtocolHandler) ResendMessages() {}
+
 func (b *BaseProducerProtocolHandler) UpdateConsumer(ag *persistence.EstablishedAgreement) {}
 
+func (b *BaseProducerProtocolHandler) ReportResourceUsage(ag *persistence.EstablishedAgreement, usage events.ResourceUsage) error {
+	return nil
+}
+
 func (b *BaseProducerProtocolHandler) UpdateConsumers() {}
 
 func (c *BaseProducerProtocolHandler) SetBlockchainClientAvailable(cmd *BCInitializedCommand) {
@@ -392,8 +526,68 @@ func (c *BaseProducerProtocolHandler) GetKnownBlockchain(ag *persistence.Establi
 	return "", "", ""
 }
 
+// The protocol message types that every agreement protocol understands. Messages of these types are handled by the
+// governance worker directly, so they never need to be deferred.
+var coreMessageTypes = []string{
+	abstractprotocol.MsgTypeProposal,
+	abstractprotocol.MsgTypeReply,
+	abstractprotocol.MsgTypeReplyAck,
+	abstractprotocol.MsgTypeDataReceived,
+	abstractprotocol.MsgTypeDataReceivedAck,
+	abstractprotocol.MsgTypeNotifyMetering,
+	abstractprotocol.MsgTypeCancel,
+}
+
+func isMessageType(msgTypes []string, msgType string) bool {
+	for _, t := range msgTypes {
+		if t == msgType {
+			return true
+		}
+	}
+	return false
+}
+
+// Extension messages that this version of anax does not understand, but which have a valid protocol header, were sent
+// by a newer agbot. Instead of dropping them, they are acked (removed from the exchange) and saved in the database so
+// that a newer anax can process them after it is upgraded. The knownTypes are the extension message types that the
+// caller's protocol understands. Returns true if the message was deferred.
+func (w *BaseProducerProtocolHandler) DeferUnknownExtensionMessage(ph abstractprotocol.ProtocolHandler, knownTypes []string, msg *events.ExchangeDeviceMessage, exchangeMsg *exchange.DeviceMessage) (bool, error) {
+
+	header := new(abstractprotocol.BaseProtocolMessage)
+	if err := json.Unmarshal([]byte(msg.ProtocolMessage()), header); err != nil || !header.IsValid() {
+		// Not a versioned protocol message, nothing to defer.
+		return false, nil
+	} else if header.Protocol() != w.Name() {
+		return false, nil
+	} else if header.Version() <= ph.Version() && (isMessageType(coreMessageTypes, header.Type()) || isMessageType(knownTypes, header.Type())) {
+		// The message is one we understand, it was rejected for some other reason.
+		return false, nil
+	}
+
+	glog.V(3).Infof(BPPHlogString(w.Name(), fmt.Sprintf("deferring unknown protocol message %v version %v for agreement %v, current protocol version is %v", header.Type(), header.Version(), header.AgreementId(), ph.Version())))
+
+	dm := &persistence.DeferredProtocolMessage{
+		MsgId:           exchangeMsg.MsgId,
+		Protocol:        header.Protocol(),
+		MsgType:         header.Type(),
+		MsgVersion:      header.Version(),
+		AgreementId:     header.AgreementId(),
+		AgbotId:         exchangeMsg.AgbotId,
+		AgbotPubKey:     exchangeMsg.AgbotPubKey,
+		ProtocolMessage: msg.ProtocolMessage(),
+		ReceivedTime:    msg.Time,
+		StoredByVersion: version.HORIZON_VERSION,
+	}
+
+	if err := persistence.SaveDeferredProtocolMessage(w.db, dm); err != nil {
+		return false, errors.New(BPPHlogString(w.Name(), fmt.Sprintf("unable to save deferred protocol message %v, error: %v", dm, err)))
+	}
+	return true, nil
+}
+
 // The list of termination reasons that should be supported by all agreement protocols. The caller can pass these into
 // the GetTerminationCode API to get a protocol specific reason code for that termination reason.
+// They are the names of the producer reasons in the reason registry of each protocol, see abstractprotocol.ReasonRegistry.
 const TERM_REASON_POLICY_CHANGED = "PolicyChanged"
 const TERM_REASON_AGBOT_REQUESTED = "ConsumerCancelled"
 const TERM_REASON_CONTAINER_FAILURE = "ContainerFailure"
@@ -414,6 +608,9 @@ const TERM_REASON_IMAGE_FETCH_FAILURE = "ImageFetchFailure"
 const TERM_REASON_IMAGE_FETCH_AUTH_FAILURE = "ImageFetchAuthorizationFailure"
 const TERM_REASON_IMAGE_SIG_VERIF_FAILURE = "ImageSignatureVerificationFailure"
 const TERM_REASON_NODE_SHUTDOWN = "NodeShutdown"
+const TERM_REASON_NODE_DECOMMISSIONED = "NodeDecommissioned"
+const TERM_REASON_WL_ROLLBACK = "WorkloadRollback"
+const TERM_REASON_USER_INPUT_CHANGED = "UserInputChanged"
 
 // ==============================================================================================================
 type ExchangeMessageCommand struct {
@@ -497,5 +694,8 @@ func NewBCWritableCommand(msg *events.AccountFundedMessage) *BCWritableCommand {
 // Utility functions
 
 var BPPHlogString = func(p string, v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("Base Producer Protocol Handler", cutil.LogFields{cutil.LOG_PROTOCOL: p}, v)
+	}
 	return fmt.Sprintf("Base Producer Protocol Handler (%v): %v", p, v)
 }
@@ -0,0 +1,67 @@
+// +build unit
+
+package producer
+
+import (
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/policy"
+	"reflect"
+	"testing"
+)
+
+func Test_agreementLimitExceeded(t *testing.T) {
+
+	ags := []persistence.EstablishedAgreement{
+		persistence.EstablishedAgreement{CurrentAgreementId: "ag1", RunningWorkload: persistence.WorkloadInfo{URL: "http://mydomain.com/wl1", Org: "myorg"}},
+		persistence.EstablishedAgreement{CurrentAgreementId: "ag2", RunningWorkload: persistence.WorkloadInfo{URL: "http://mydomain.com/wl1", Org: "otherorg"}},
+		persistence.EstablishedAgreement{CurrentAgreementId: "ag3", RunningWorkload: persistence.WorkloadInfo{URL: "http://mydomain.com/wl2", Org: "myorg"}},
+	}
+
+	for _, c := range []struct {
+		limits   persistence.AgreementLimits
+		wURL     string
+		exceeded bool
+	}{
+		{persistence.AgreementLimits{}, "http://mydomain.com/wl1", false},
+		{persistence.AgreementLimits{MaxTotalAgreements: 4}, "http://mydomain.com/wl1", false},
+		{persistence.AgreementLimits{MaxTotalAgreements: 3}, "http://mydomain.com/wl3", true},
+		{persistence.AgreementLimits{MaxAgreementsPerWorkload: 1}, "http://mydomain.com/wl1", true},
+		{persistence.AgreementLimits{MaxAgreementsPerWorkload: 2}, "http://mydomain.com/wl1", false},
+		{persistence.AgreementLimits{MaxAgreementsPerWorkload: 1}, "http://mydomain.com/wl3", false},
+		{persistence.AgreementLimits{MaxAgreementsPerWorkload: 1, MaxTotalAgreements: 4}, "http://mydomain.com/wl3", false},
+	} {
+		if reason := agreementLimitExceeded(c.limits, ags, c.wURL, "myorg"); (reason != "") != c.exceeded {
+			t.Errorf("limits %v for workload %v should be exceeded %v, returned: %v", c.limits, c.wURL, c.exceeded, reason)
+		}
+	}
+
+}
+
+// The devices the workload needs are checked, optional devices are not.
+func Test_MissingWorkloadDevices(t *testing.T) {
+
+	host := map[string]bool{"/dev/video0": true, "/dev/nvidiactl": true, "/dev/nvidia-uvm": true, "/dev/nvidia0": true}
+	exists := func(hostPath string) bool { return host[hostPath] }
+
+	for _, c := range []struct {
+		deployment string
+		missing    []string
+	}{
+		{``, []string{}},
+		{`{"services":{"cam":{"image":"cam","devices":["/dev/video0:/dev/video0"]}}}`, []string{}},
+		{`{"services":{"cam":{"image":"cam","device_mappings":[{"host_path":"/dev/video1"},{"host_path":"/dev/ttyUSB0","optional":true}]}}}`, []string{"/dev/video1"}},
+		{`{"services":{"cam":{"image":"cam","device_mappings":[{"host_path":"/dev/video1"}]},"ml":{"image":"ml","gpu":{"vendor":"nvidia","count":2}}}}`, []string{"/dev/nvidia1", "/dev/video1"}},
+	} {
+		pol := &policy.Policy{Workloads: []policy.Workload{policy.Workload{Deployment: c.deployment}}}
+		if missing, err := MissingWorkloadDevices(pol, exists); err != nil {
+			t.Errorf("unable to check devices of deployment %v: %v", c.deployment, err)
+		} else if !reflect.DeepEqual(missing, c.missing) {
+			t.Errorf("deployment %v should be missing devices %v, is missing %v", c.deployment, c.missing, missing)
+		}
+	}
+
+	pol := &policy.Policy{Workloads: []policy.Workload{policy.Workload{Deployment: "{"}}}
+	if _, err := MissingWorkloadDevices(pol, exists); err == nil {
+		t.Errorf("a deployment that is not json should be an error")
+	}
+}
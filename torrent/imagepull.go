@@ -10,7 +10,12 @@ import (
 	"github.com/open-horizon/anax/config"
 	"github.com/open-horizon/anax/containermessage"
 	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/faults"
+	"net/http"
 	"os"
+	"sort"
+	"sync"
 	"time"
 )
 
@@ -66,81 +71,218 @@ func authDockerFile(config config.Config, authConfigs map[string][]docker.AuthCo
 	return nil
 }
 
-func pullImageFromRepos(config config.Config, authConfigs map[string][]docker.AuthConfiguration, client *docker.Client, skipPartFetchFn *func(repotag string) (bool, error), deploymentDesc *containermessage.DeploymentDescription) error {
+// Pull the images of the services in the deployment. The images are pulled at the same time, within the limits of the
+// pull scheduler. When critical is true, the deployment is a service that other services depend on, and its images are
+// pulled before the images of the other deployments. The image fetch descriptor, when the deployment has one, adds
+// mirrors, digests and auth hints to the pulls.
+func pullImageFromRepos(config config.Config, authConfigs map[string][]docker.AuthConfiguration, trustedAuths []events.ImageDockerAuth, imageFetch *cutil.ImageFetch, client *docker.Client, skipPartFetchFn *func(repotag string) (bool, error), deploymentDesc *containermessage.DeploymentDescription, critical bool) error {
 
 	// append docker auth from docker file
 	authDockerFile(config, authConfigs)
 
-	// TODO: can we fetch in parallel with the docker client? If so, lift pattern from https://github.com/open-horizon/horizon-pkg-fetch/blob/master/fetch.go#L350
+	// The images are checked before any is pulled, so that a bad deployment does not pull half of its images.
+	refs := make(map[string]*cutil.ImageReference)
+	names := make([]string, 0, len(deploymentDesc.Services))
 	for name, service := range deploymentDesc.Services {
+		ref, err := cutil.ParseImageReference(service.Image)
+		if err != nil {
+			glog.Errorf("Invalid image name format specified: %v", err)
+			return fmt.Errorf("Invalid image name format specified: %v", err)
+		}
+		refs[name] = ref
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-		glog.V(3).Infof("Pulling image %v for service %v", service.Image, name)
+	sizes := localImageSizes(client)
 
-		var opts docker.PullImageOptions
+	errs := make(map[string]error)
+	var errLock sync.Mutex
+	var wg sync.WaitGroup
+	for _, name := range names {
+		registry := refs[name].Registry
+		if registry == "" {
+			registry = cutil.DEFAULT_IMAGE_REGISTRY
+		}
+		wg.Add(1)
+		go func(name string, service *containermessage.Service, ref *cutil.ImageReference) {
+			defer wg.Done()
+			release := pullScheduler.Acquire(service.Image, registry, critical || isCriticalImage(config, ref), sizes[ref.Name()])
+			defer release()
 
-		domain, path, tag, digest := cutil.ParseDockerImagePath(service.Image)
-		if path == "" {
-			glog.Errorf("Invalid image name format specified: %v", service.Image)
-			return fmt.Errorf("Invalid image name format specified: %v", service.Image)
+			glog.V(3).Infof("Pulling image %v for service %v", service.Image, name)
+			if err := pullServiceImage(authConfigs, trustedAuths, imageFetch, client, name, service, ref); err != nil {
+				glog.Errorf("Docker image pull(s) failed for docker image %v. Error: %v.", service.Image, err)
+				errLock.Lock()
+				errs[name] = err
+				errLock.Unlock()
+			} else {
+				glog.V(3).Infof("Succeeded fetching image %v for service %v", service.Image, name)
+			}
+		}(name, deploymentDesc.Services[name], refs[name])
+	}
+	wg.Wait()
+
+	for _, name := range names {
+		if err, ok := errs[name]; ok {
+			return err
 		}
+	}
+	return nil
+}
+
+// Pull the image of one service from each of the references of the image fetch descriptor in turn, until one of them
+// is pulled. The image of the service is changed to the reference that was pulled, so that the container is created
+// from it.
+func pullServiceImage(authConfigs map[string][]docker.AuthConfiguration, trustedAuths []events.ImageDockerAuth, imageFetch *cutil.ImageFetch, client *docker.Client, name string, service *containermessage.Service, ref *cutil.ImageReference) error {
 
-		// the image name format is [[repo][:port]/][somedir/]image[:tag][@digest].
-		// tag and digest do not contain '/'
-		if digest != "" {
-			// this is the case where image repo digest is used, just put whole name there
-			opts = docker.PullImageOptions{
-				Repository: service.Image,
+	candidates, err := imageFetch.Candidates(ref)
+	if err != nil {
+		return fetcherrors.PkgSourceError{Msg: err.Error()}
+	}
+
+	for i, candidate := range candidates {
+		if auths, err := registryAuths(imageFetch.AuthSource(candidate.Registry), candidate.Registry, authConfigs, trustedAuths); err != nil {
+			return err
+		} else if err = pullImageReference(auths, client, name, &candidate); err == nil {
+			if image := candidate.String(); image != service.Image {
+				glog.V(3).Infof("Pulled image %v instead of %v for service %v", image, service.Image, name)
+				service.Image = image
 			}
+			return nil
+		} else if i < len(candidates)-1 {
+			glog.Warningf("Docker image pull failed for service %v docker image %v, trying %v. Error: %v", name, candidate.String(), candidates[i+1].String(), err)
 		} else {
-			// this is case where image name:tag is used. The image repo may contain :, image tag itself cannot contain : or /.
-			// These are valid formats:
-			//  repo/a/b:tag
-			//  repo:port/a/b:tag
-			//  repo:port/a/b
-
-			var repo string
-			if domain == "" {
-				repo = path
-			} else {
-				repo = fmt.Sprintf("%v/%v", domain, path)
-			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the auths to try for a registry, given the auth source that the image fetch descriptor asks for. Without a
+// hint, all the auths the node has for the registry are tried.
+func registryAuths(source string, registry string, authConfigs map[string][]docker.AuthConfiguration, trustedAuths []events.ImageDockerAuth) ([]docker.AuthConfiguration, error) {
 
-			if tag == "" {
-				tag = "latest"
+	fromExchange := func(auth docker.AuthConfiguration) bool {
+		for _, t := range trustedAuths {
+			if t.Registry == auth.ServerAddress && t.Password == auth.Password {
+				return true
 			}
+		}
+		return false
+	}
 
-			// TODO: check the on-disk image to make sure it still verifies
-			// N.B. It's possible to specify an outputstream here which means we could fetch a docker image and hash it, check the sig like we used to
-			opts = docker.PullImageOptions{
-				Repository: repo,
-				Tag:        tag,
+	auths := make([]docker.AuthConfiguration, 0)
+	switch source {
+	case cutil.IMAGE_AUTH_NONE:
+		auths = append(auths, docker.AuthConfiguration{})
+
+	case cutil.IMAGE_AUTH_EXCHANGE:
+		for _, t := range trustedAuths {
+			if t.Registry == registry {
+				auths = append(auths, docker.AuthConfiguration{Username: "token", Password: t.Password, ServerAddress: t.Registry})
 			}
 		}
+		if len(auths) == 0 {
+			return nil, fetcherrors.PkgSourceFetchAuthError{Msg: fmt.Sprintf("registry %v needs the image auths of the org in the exchange, the node has none for it. The node only uses them when TrustDockerAuthFromOrg is set.", registry)}
+		}
 
-		var err error
-		if domain == "" {
-			err = pullSingleImageFromRepo(client, opts, docker.AuthConfiguration{})
-		} else if auth_array, ok := authConfigs[domain]; !ok {
-			err = pullSingleImageFromRepo(client, opts, docker.AuthConfiguration{})
-		} else {
-			for i, auth := range auth_array {
-				err = pullSingleImageFromRepo(client, opts, auth)
-				if err == nil {
-					break
-				} else if i < len(auth_array)-1 {
-					glog.V(5).Infof("Docker image pull(s) failed for service %v docker image %v with auth %v. Error: %v. Try next auth.", name, service.Image, auth, err)
-				}
+	case cutil.IMAGE_AUTH_NODE:
+		for _, auth := range authConfigs[registry] {
+			if !fromExchange(auth) {
+				auths = append(auths, auth)
 			}
 		}
-		if err != nil {
-			glog.Errorf("Docker image pull(s) failed for docker image %v. Error: %v.", service.Image, err)
-			return err
-		} else {
-			glog.V(3).Infof("Succeeded fetching image %v for service", service.Image, name)
+		if len(auths) == 0 {
+			return nil, fetcherrors.PkgSourceFetchAuthError{Msg: fmt.Sprintf("registry %v needs credentials configured on the node, the node has none for it", registry)}
+		}
+
+	default:
+		if registry != "" {
+			auths = append(auths, authConfigs[registry]...)
+		}
+		if len(auths) == 0 {
+			auths = append(auths, docker.AuthConfiguration{})
 		}
 	}
+	return auths, nil
+}
 
-	return nil
+// Pull an image, trying each of the auths in turn.
+func pullImageReference(auths []docker.AuthConfiguration, client *docker.Client, name string, ref *cutil.ImageReference) error {
+
+	var opts docker.PullImageOptions
+
+	// the image name format is [[repo][:port]/][somedir/]image[:tag][@digest].
+	// tag and digest do not contain '/'
+	if ref.Digest != "" {
+		// this is the case where image repo digest is used, just put whole name there
+		opts = docker.PullImageOptions{
+			Repository: ref.String(),
+		}
+	} else {
+		// this is case where image name:tag is used. The image repo may contain :, image tag itself cannot contain : or /.
+		// These are valid formats:
+		//  repo/a/b:tag
+		//  repo:port/a/b:tag
+		//  repo:port/a/b
+
+		tag := ref.Tag
+		if tag == "" {
+			tag = cutil.DEFAULT_IMAGE_TAG
+		}
+
+		// TODO: check the on-disk image to make sure it still verifies
+		// N.B. It's possible to specify an outputstream here which means we could fetch a docker image and hash it, check the sig like we used to
+		opts = docker.PullImageOptions{
+			Repository: ref.Name(),
+			Tag:        tag,
+		}
+	}
+
+	var err error
+	for i, auth := range auths {
+		err = pullSingleImageFromRepo(client, opts, auth)
+		if err == nil {
+			break
+		} else if i < len(auths)-1 {
+			glog.V(5).Infof("Docker image pull(s) failed for service %v docker image %v with auth %v. Error: %v. Try next auth.", name, ref.String(), auth, err)
+		}
+	}
+	return err
+}
+
+// Returns true when the image repository is one of the configured critical images.
+func isCriticalImage(config config.Config, ref *cutil.ImageReference) bool {
+	for _, image := range config.ImagePulls.CriticalImages {
+		if c, err := cutil.ParseImageReference(image); err == nil && c.Name() == ref.Name() {
+			return true
+		}
+	}
+	return false
+}
+
+// The size of the images already on the node, by repository. The size of an image that is about to be pulled is not
+// known, so the size of an earlier version of it is used to decide which images are small.
+func localImageSizes(client *docker.Client) map[string]int64 {
+	sizes := make(map[string]int64)
+	if client == nil {
+		return sizes
+	}
+
+	images, err := client.ListImages(docker.ListImagesOptions{})
+	if err != nil {
+		glog.V(3).Infof("Unable to list the local images to estimate the image sizes, error: %v", err)
+		return sizes
+	}
+	for _, image := range images {
+		for _, repoTag := range image.RepoTags {
+			if ref, err := cutil.ParseImageReference(repoTag); err == nil {
+				sizes[ref.Name()] = image.Size
+			}
+		}
+	}
+	return sizes
 }
 
 //  This function try maxPullAttempts times to pull the image from the repo. It exits out imediately if there is auth error.
@@ -150,7 +292,11 @@ func pullSingleImageFromRepo(client *docker.Client, opts docker.PullImageOptions
 	var pullAttempts int
 
 	for pullAttempts <= maxPullAttempts {
-		if err := client.PullImage(opts, auth); err == nil {
+		err := client.PullImage(opts, auth)
+		if err == nil && faults.Inject(faults.DOCKER_ERROR) {
+			err = &docker.Error{Status: http.StatusInternalServerError, Message: faults.Message(faults.DOCKER_ERROR)}
+		}
+		if err == nil {
 			return nil
 		} else {
 			pullAttempts++
@@ -0,0 +1,134 @@
+package torrent
+
+import (
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"sort"
+	"sync"
+)
+
+// The pull scheduler limits the number of docker image pulls that run at the same time, in total and per registry, so
+// that the agreements that start together do not saturate the uplink of the node and all time out. Waiting pulls are
+// started in priority order: critical images first, then the smaller images, then in the order they were asked for.
+
+// A pull that is waiting for its turn.
+type pullTicket struct {
+	image    string
+	registry string
+	critical bool
+	size     int64 // the estimated size of the image in bytes, 0 when it is not known
+	seq      uint64
+	granted  chan bool
+}
+
+// Returns true when ticket t should be started before ticket o.
+func (t *pullTicket) before(o *pullTicket) bool {
+	if t.critical != o.critical {
+		return t.critical
+	} else if t.size != o.size {
+		// An image of unknown size might be big, so it goes after the images that are known to be small.
+		if t.size == 0 || o.size == 0 {
+			return t.size != 0
+		}
+		return t.size < o.size
+	}
+	return t.seq < o.seq
+}
+
+type pullTickets []*pullTicket
+
+func (p pullTickets) Len() int           { return len(p) }
+func (p pullTickets) Less(i, j int) bool { return p[i].before(p[j]) }
+func (p pullTickets) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+type ImagePullScheduler struct {
+	lock           sync.Mutex
+	maxConcurrent  int // 0 or less for no limit
+	maxPerRegistry int // 0 or less for no limit
+	running        int
+	registries     map[string]int
+	waiting        pullTickets
+	seq            uint64
+}
+
+func NewImagePullScheduler(maxConcurrent int, maxPerRegistry int) *ImagePullScheduler {
+	return &ImagePullScheduler{
+		maxConcurrent:  maxConcurrent,
+		maxPerRegistry: maxPerRegistry,
+		registries:     make(map[string]int),
+		waiting:        make(pullTickets, 0, 5),
+	}
+}
+
+// The scheduler shared by all the image pulls of this process.
+var pullScheduler = NewImagePullScheduler(config.DEFAULT_MAX_CONCURRENT_PULLS, 0)
+
+// Set the pull limits of the shared scheduler. Pulls that are already running are not stopped when the limits are
+// lowered.
+func SetImagePullLimits(maxConcurrent int, maxPerRegistry int) {
+	pullScheduler.lock.Lock()
+	defer pullScheduler.lock.Unlock()
+	pullScheduler.maxConcurrent = maxConcurrent
+	pullScheduler.maxPerRegistry = maxPerRegistry
+	pullScheduler.dispatch()
+}
+
+// Wait until the image can be pulled. The returned function must be called when the pull is done, successful or not.
+func (s *ImagePullScheduler) Acquire(image string, registry string, critical bool, size int64) func() {
+	s.lock.Lock()
+	s.seq += 1
+	t := &pullTicket{image: image, registry: registry, critical: critical, size: size, seq: s.seq, granted: make(chan bool)}
+	s.waiting = append(s.waiting, t)
+	s.dispatch()
+	waiting := len(s.waiting)
+	s.lock.Unlock()
+
+	select {
+	case <-t.granted:
+	default:
+		glog.V(3).Infof("Pull of image %v is waiting for its turn, %v pulls are waiting", image, waiting)
+		<-t.granted
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { s.release(t) })
+	}
+}
+
+func (s *ImagePullScheduler) release(t *pullTicket) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.running -= 1
+	if s.registries[t.registry] -= 1; s.registries[t.registry] <= 0 {
+		delete(s.registries, t.registry)
+	}
+	s.dispatch()
+}
+
+// Start the waiting pulls that fit within the limits, in priority order. A pull that is held back by the limit of its
+// registry does not hold back the pulls from other registries. Must be called with the lock held.
+func (s *ImagePullScheduler) dispatch() {
+	sort.Sort(s.waiting)
+
+	remaining := s.waiting[:0]
+	for _, t := range s.waiting {
+		if s.maxConcurrent > 0 && s.running >= s.maxConcurrent {
+			remaining = append(remaining, t)
+		} else if s.maxPerRegistry > 0 && s.registries[t.registry] >= s.maxPerRegistry {
+			remaining = append(remaining, t)
+		} else {
+			s.running += 1
+			s.registries[t.registry] += 1
+			close(t.granted)
+		}
+	}
+	s.waiting = remaining
+}
+
+// Returns the number of pulls that are running and waiting.
+func (s *ImagePullScheduler) Load() (int, int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.running, len(s.waiting)
+}
@@ -0,0 +1,94 @@
+// +build unit
+
+package torrent
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// Start a pull that reports on started when it gets its turn, and ends shortly after.
+func acquireAsync(s *ImagePullScheduler, image string, registry string, critical bool, size int64, started chan string) {
+	go func() {
+		release := s.Acquire(image, registry, critical, size)
+		started <- image
+		<-time.After(10 * time.Millisecond)
+		release()
+	}()
+}
+
+func waitForLoad(s *ImagePullScheduler, running int, waiting int) bool {
+	for i := 0; i < 100; i++ {
+		if r, w := s.Load(); r == running && w == waiting {
+			return true
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return false
+}
+
+func Test_ImagePullScheduler_limits(t *testing.T) {
+
+	s := NewImagePullScheduler(2, 1)
+
+	r1 := s.Acquire("reg1/a", "reg1", false, 0)
+	r2 := s.Acquire("reg2/b", "reg2", false, 0)
+
+	// Both limits are reached, the next pulls wait.
+	started := make(chan string, 2)
+	acquireAsync(s, "reg1/c", "reg1", false, 0, started)
+	acquireAsync(s, "reg3/d", "reg3", false, 0, started)
+	assert.True(t, waitForLoad(s, 2, 2), "2 pulls should run and 2 wait")
+
+	// Ending the pull from reg2 lets the pull from reg3 start, reg1 is still busy.
+	r2()
+	assert.Equal(t, "reg3/d", <-started)
+	assert.True(t, waitForLoad(s, 1, 1), "the pull from reg3 should be done and reg1/c should still wait")
+
+	r1()
+	assert.Equal(t, "reg1/c", <-started)
+	assert.True(t, waitForLoad(s, 0, 0), "all pulls should be done")
+
+	// Releasing twice has no effect.
+	r1()
+	r, w := s.Load()
+	assert.Equal(t, 0, r)
+	assert.Equal(t, 0, w)
+}
+
+func Test_ImagePullScheduler_priority(t *testing.T) {
+
+	s := NewImagePullScheduler(1, 0)
+	release := s.Acquire("first", "reg", false, 0)
+
+	started := make(chan string, 4)
+	acquireAsync(s, "unknown", "reg", false, 0, started)
+	assert.True(t, waitForLoad(s, 1, 1), "unknown should wait")
+	acquireAsync(s, "big", "reg", false, 5000, started)
+	assert.True(t, waitForLoad(s, 1, 2), "big should wait")
+	acquireAsync(s, "small", "reg", false, 100, started)
+	assert.True(t, waitForLoad(s, 1, 3), "small should wait")
+	acquireAsync(s, "critical", "reg", true, 9000, started)
+	assert.True(t, waitForLoad(s, 1, 4), "critical should wait")
+
+	release()
+	for _, image := range []string{"critical", "small", "big", "unknown"} {
+		assert.Equal(t, image, <-started)
+	}
+}
+
+func Test_ImagePullScheduler_noLimit(t *testing.T) {
+
+	s := NewImagePullScheduler(0, 0)
+	releases := []func(){}
+	for i := 0; i < 10; i++ {
+		releases = append(releases, s.Acquire("image", "reg", false, 0))
+	}
+	r, w := s.Load()
+	assert.Equal(t, 10, r)
+	assert.Equal(t, 0, w)
+	for _, release := range releases {
+		release()
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/config"
 	"github.com/open-horizon/anax/containermessage"
+	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/persistence"
 	"github.com/open-horizon/anax/worker"
@@ -33,6 +34,8 @@ func NewTorrentWorker(name string, config *config.HorizonConfig, db *bolt.DB) *T
 		panic("Unable to instantiate docker Client")
 	}
 
+	SetImagePullLimits(config.Edge.ImagePulls.MaxConcurrent, config.Edge.ImagePulls.MaxPerRegistry)
+
 	worker := &TorrentWorker{
 		BaseWorker: worker.NewBaseWorker(name, config, nil),
 		db:         db,
@@ -233,12 +236,14 @@ func processDeployment(cfg *config.HorizonConfig, containerConfig events.Contain
 	return pemFiles, &deploymentDesc, nil
 }
 
-func processFetch(cfg *config.HorizonConfig, client *docker.Client, db *bolt.DB, pemFiles []string, deploymentDesc *containermessage.DeploymentDescription, torrentUrl url.URL, torrentSig string, imageDockerAuths []events.ImageDockerAuth) error {
+func processFetch(cfg *config.HorizonConfig, client *docker.Client, db *bolt.DB, pemFiles []string, deploymentDesc *containermessage.DeploymentDescription, torrentUrl url.URL, torrentSig string, imageFetch *cutil.ImageFetch, imageDockerAuths []events.ImageDockerAuth, critical bool) error {
 	httpAuthAttrs := make(map[string]map[string]string, 0)
 	dockerAuthConfigurations := make(map[string][]docker.AuthConfiguration, 0)
+	trustedAuths := []events.ImageDockerAuth{}
 
 	var err error
 	if cfg.Edge.TrustDockerAuthFromOrg {
+		trustedAuths = imageDockerAuths
 		err = authExchange(imageDockerAuths, dockerAuthConfigurations)
 		if err != nil {
 			glog.Errorf("Failed to add authentication facts from exchange before processing packages and / or Docker pulls: %v. Continuing anyway", err)
@@ -249,10 +254,12 @@ func processFetch(cfg *config.HorizonConfig, client *docker.Client, db *bolt.DB,
 		glog.Errorf("Failed to fetch authentication facts from the attributes before processing packages and / or Docker pulls: %v. Continuing anyway", err)
 	}
 
-	return fetchImage(cfg, client, db, pemFiles, deploymentDesc, torrentUrl, torrentSig, httpAuthAttrs, dockerAuthConfigurations)
+	return fetchImage(cfg, client, db, pemFiles, deploymentDesc, torrentUrl, torrentSig, imageFetch, trustedAuths, httpAuthAttrs, dockerAuthConfigurations, critical)
 }
 
-func fetchImage(cfg *config.HorizonConfig, client *docker.Client, db *bolt.DB, pemFiles []string, deploymentDesc *containermessage.DeploymentDescription, torrentUrl url.URL, torrentSig string, httpAuthAttrs map[string]map[string]string, dockerAuthConfigurations map[string][]docker.AuthConfiguration) error {
+// The image fetch descriptor and the trusted image auths from the exchange are only used for docker pulls. The
+// trusted auths are the ones the image fetch descriptor can ask for with the exchange auth source.
+func fetchImage(cfg *config.HorizonConfig, client *docker.Client, db *bolt.DB, pemFiles []string, deploymentDesc *containermessage.DeploymentDescription, torrentUrl url.URL, torrentSig string, imageFetch *cutil.ImageFetch, trustedAuths []events.ImageDockerAuth, httpAuthAttrs map[string]map[string]string, dockerAuthConfigurations map[string][]docker.AuthConfiguration, critical bool) error {
 	// N.B. Using fetcherrors types even for docker pull errors
 	var fetchErr error
 
@@ -262,9 +269,17 @@ func fetchImage(cfg *config.HorizonConfig, client *docker.Client, db *bolt.DB, p
 		// Note: we don't want to make this a fallback option, it's a potential security vector
 		glog.V(3).Infof("Empty torrent URL '%v' and Signature '%v' provided in LaunchContext, using Docker pull mechanism to retrieve and load Docker images into local registry", torrentUrl.String(), torrentSig)
 
-		fetchErr = pullImageFromRepos(cfg.Edge, dockerAuthConfigurations, client, &skipCheckFn, deploymentDesc)
+		fetchErr = pullImageFromRepos(cfg.Edge, dockerAuthConfigurations, trustedAuths, imageFetch, client, &skipCheckFn, deploymentDesc, critical)
+
+	} else if cfg.Edge.LowMemory() {
+		// The package fetch keeps the image parts and their metadata around while they are loaded.
+		fetchErr = fetcherrors.PkgSourceError{Msg: fmt.Sprintf("the low memory profile does not fetch image packages, the workload must use docker images from a registry instead of %v", torrentUrl.String())}
 
 	} else {
+		if imageFetch != nil {
+			glog.Warningf("Deprecated torrent URL %v is used to fetch the images, the image fetch descriptor %v is ignored", torrentUrl.String(), imageFetch)
+		}
+
 		// using Pkg fetch and image load (traditional option, content of images is packaged completely, all content is checked for signature)
 		// imageFiles is of form {<repotag>: <part abspath> or empty string}
 		var imageFiles map[string]string
@@ -326,10 +341,12 @@ func fetchImage(cfg *config.HorizonConfig, client *docker.Client, db *bolt.DB, p
 func ProcessImageFetch(cfg *config.HorizonConfig, client *docker.Client, containerConfig *events.ContainerConfig, httpAuthAttrs map[string]map[string]string, dockerAuthConfigurations map[string][]docker.AuthConfiguration, pemFiles []string) error {
 
 	dockerAuthNew := make(map[string][]docker.AuthConfiguration, 0)
+	trustedAuths := []events.ImageDockerAuth{}
 
 	//make sure that the docker auth from the image overwrites the user defined docker auth for the same repo
 	var err error
 	if cfg.Edge.TrustDockerAuthFromOrg {
+		trustedAuths = containerConfig.ImageDockerAuths
 		err = authExchange(containerConfig.ImageDockerAuths, dockerAuthNew)
 		if err != nil {
 			glog.Errorf("Failed to add authentication facts from exchange before processing packages and / or Docker pulls: %v. Continuing anyway", err)
@@ -347,7 +364,7 @@ func ProcessImageFetch(cfg *config.HorizonConfig, client *docker.Client, contain
 		return fmt.Errorf("Error Unmarshalling deployment string %v, error: %v", containerConfig.Deployment, err)
 	}
 
-	return fetchImage(cfg, client, nil, pemFiles, &deploymentDesc, containerConfig.TorrentURL, containerConfig.TorrentSignature, httpAuthAttrs, dockerAuthNew)
+	return fetchImage(cfg, client, nil, pemFiles, &deploymentDesc, containerConfig.TorrentURL, containerConfig.TorrentSignature, containerConfig.ImageFetch, trustedAuths, httpAuthAttrs, dockerAuthNew, false)
 }
 
 func (b *TorrentWorker) CommandHandler(command worker.Command) bool {
@@ -359,46 +376,54 @@ func (b *TorrentWorker) CommandHandler(command worker.Command) bool {
 		if lc := b.getLaunchContext(cmd.LaunchContext); lc == nil {
 			glog.Errorf("Incoming event was not a known launch context: %T", cmd.LaunchContext)
 		} else {
-			glog.V(5).Infof("LaunchContext(%T): %v", lc, lc)
+			// The fetches run at the same time so that one agreement does not wait for the images of another to be
+			// pulled, the pull scheduler limits how many images are pulled at once.
+			go b.fetch(lc)
+		}
 
-			pemFiles, deploymentDesc, err := processDeployment(b.Config, lc.ContainerConfig())
-			if err != nil {
-				glog.Errorf("Failed to process deployment description and signature after agreement negotiation: %v", err)
-				b.Messages() <- events.NewTorrentMessage(events.IMAGE_FETCH_ERROR, deploymentDesc, lc)
-				return true
-			}
+	default:
+		return false
+	}
+	return true
 
-			if fetchErr := processFetch(b.Config, b.client, b.db, pemFiles, deploymentDesc, lc.ContainerConfig().TorrentURL, lc.ContainerConfig().TorrentSignature, lc.ContainerConfig().ImageDockerAuths); fetchErr != nil {
-				var id events.EventId
-				switch fetchErr.(type) {
-				case fetcherrors.PkgMetaError, fetcherrors.PkgSourceError, fetcherrors.PkgPrecheckError:
-					id = events.IMAGE_DATA_ERROR
+}
 
-				case fetcherrors.PkgSourceFetchError:
-					id = events.IMAGE_FETCH_ERROR
+// Fetch the images of a launch context and tell the other workers whether they were fetched. The images of the
+// services that other services depend on are pulled first.
+func (b *TorrentWorker) fetch(lc events.LaunchContext) {
+	glog.V(5).Infof("LaunchContext(%T): %v", lc, lc)
 
-				case fetcherrors.PkgSourceFetchAuthError:
-					id = events.IMAGE_FETCH_AUTH_ERROR
+	pemFiles, deploymentDesc, err := processDeployment(b.Config, lc.ContainerConfig())
+	if err != nil {
+		glog.Errorf("Failed to process deployment description and signature after agreement negotiation: %v", err)
+		b.Messages() <- events.NewTorrentMessage(events.IMAGE_FETCH_ERROR, deploymentDesc, lc)
+		return
+	}
 
-				case fetcherrors.PkgSignatureVerificationError:
-					id = events.IMAGE_SIG_VERIF_ERROR
+	_, critical := lc.(*events.ContainerLaunchContext)
+	if fetchErr := processFetch(b.Config, b.client, b.db, pemFiles, deploymentDesc, lc.ContainerConfig().TorrentURL, lc.ContainerConfig().TorrentSignature, lc.ContainerConfig().ImageFetch, lc.ContainerConfig().ImageDockerAuths, critical); fetchErr != nil {
+		var id events.EventId
+		switch fetchErr.(type) {
+		case fetcherrors.PkgMetaError, fetcherrors.PkgSourceError, fetcherrors.PkgPrecheckError:
+			id = events.IMAGE_DATA_ERROR
 
-				default:
-					id = events.IMAGE_FETCH_ERROR
-				}
-				glog.Errorf("Failed to fetch image files: %v", fetchErr)
-				b.Messages() <- events.NewTorrentMessage(id, deploymentDesc, lc)
-			} else {
-				b.Messages() <- events.NewTorrentMessage(events.IMAGE_FETCHED, deploymentDesc, lc)
-			}
+		case fetcherrors.PkgSourceFetchError:
+			id = events.IMAGE_FETCH_ERROR
 
-		}
+		case fetcherrors.PkgSourceFetchAuthError:
+			id = events.IMAGE_FETCH_AUTH_ERROR
 
-	default:
-		return false
-	}
-	return true
+		case fetcherrors.PkgSignatureVerificationError:
+			id = events.IMAGE_SIG_VERIF_ERROR
 
+		default:
+			id = events.IMAGE_FETCH_ERROR
+		}
+		glog.Errorf("Failed to fetch image files: %v", fetchErr)
+		b.Messages() <- events.NewTorrentMessage(id, deploymentDesc, lc)
+	} else {
+		b.Messages() <- events.NewTorrentMessage(events.IMAGE_FETCHED, deploymentDesc, lc)
+	}
 }
 
 type FetchCommand struct {
@@ -1,9 +1,12 @@
 package worker
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/events"
 	"runtime"
 	"time"
@@ -170,6 +173,10 @@ func (w *BaseWorker) GetHTTPFactory() *config.HTTPClientFactory {
 	}
 }
 
+// The number of seconds a worker has to handle one command, or one call of its no work handler, before the context
+// of the command is done.
+const DEFAULT_COMMAND_TIMEOUT_S = 300
+
 type BaseWorker struct {
 	Name string
 	Manager
@@ -179,9 +186,14 @@ type BaseWorker struct {
 	SubWorkers       map[string]*SubWorker // workers can have sub go routines that they own
 	ShuttingDown     bool
 	EC               *BaseExchangeContext // Holds the exchange context state
+	CommandTimeoutS  int                  // the deadline for handling one command, 0 for no deadline
+	ctx              context.Context      // cancelled when the worker begins to shut down
+	cancel           context.CancelFunc
+	cmdCtx           context.Context // the context of the command being handled
 }
 
 func NewBaseWorker(name string, cfg *config.HorizonConfig, ec *BaseExchangeContext) BaseWorker {
+	ctx, cancel := context.WithCancel(context.Background())
 	return BaseWorker{
 		Name: name,
 		Manager: Manager{
@@ -194,6 +206,9 @@ func NewBaseWorker(name string, cfg *config.HorizonConfig, ec *BaseExchangeConte
 		SubWorkers:       make(map[string]*SubWorker),
 		ShuttingDown:     false,
 		EC:               ec,
+		CommandTimeoutS:  DEFAULT_COMMAND_TIMEOUT_S,
+		ctx:              ctx,
+		cancel:           cancel,
 	}
 }
 
@@ -236,11 +251,56 @@ func (w *BaseWorker) SetDeferredDelay(delay int) {
 	w.DeferredDelay = delay
 }
 
+func (w *BaseWorker) SetCommandTimeout(seconds int) {
+	w.CommandTimeoutS = seconds
+}
+
+// Returns the context of the worker, which is cancelled when the worker begins to shut down. Subworkers use it for
+// the long running calls they make, e.g. to the exchange, so that they can be terminated while a call is in flight.
+func (w *BaseWorker) Context() context.Context {
+	if w.ctx == nil {
+		return context.Background()
+	}
+	return w.ctx
+}
+
+// Returns the context of the command, or of the call to the no work handler, that the worker is handling. It is done
+// when the command has run past its deadline or when the worker begins to shut down. Only the worker's own go routine
+// can use it, subworkers use Context().
+func (w *BaseWorker) CommandContext() context.Context {
+	if w.cmdCtx == nil {
+		return w.Context()
+	}
+	return w.cmdCtx
+}
+
+// Call fn with a new command context.
+func (w *BaseWorker) withCommandContext(fn func()) {
+	if w.CommandTimeoutS > 0 {
+		ctx, cancel := context.WithTimeout(w.Context(), time.Duration(w.CommandTimeoutS)*time.Second)
+		defer cancel()
+		w.cmdCtx = ctx
+	} else {
+		w.cmdCtx = w.Context()
+	}
+	defer func() { w.cmdCtx = nil }()
+	fn()
+}
+
+// Cancel the context of the worker, which stops the calls made with it.
+func (w *BaseWorker) cancelContext() {
+	if w.cancel != nil {
+		w.cancel()
+	}
+}
+
 // Return handled (boolean) and terminate(boolean)
 func (w *BaseWorker) HandleFrameworkCommands(command Command) (bool, bool) {
 	switch command.(type) {
 	case *BeginShutdownCommand:
+		// Subworkers that are blocked in a call can only be terminated once the call has been stopped.
 		glog.V(3).Infof(cdLogString(fmt.Sprintf("%v terminating subworkers", w.GetName())))
+		w.cancelContext()
 		w.TerminateSubworkers()
 		return true, false
 
@@ -257,6 +317,7 @@ func (w *BaseWorker) HandleFrameworkCommands(command Command) (bool, bool) {
 		workerStatusManager.SetWorkerStatus(w.GetName(), STATUS_TERMINATING)
 		// If we can terminate, do it. Otherwise requeue the termination.
 		if w.AreAllSubworkersTerminated() {
+			w.cancelContext()
 			w.Messages <- events.NewWorkerStopMessage(events.WORKER_STOP, w.GetName())
 			return true, true
 		} else {
@@ -280,11 +341,20 @@ func (w *BaseWorker) internalCommandhandler(worker Worker, command Command) bool
 	}
 
 	// Handle domain specific commands
-	if handled := worker.CommandHandler(command); !handled {
+	handled, timedOut := false, false
+	w.withCommandContext(func() {
+		handled = worker.CommandHandler(command)
+		timedOut = w.CommandContext().Err() == context.DeadlineExceeded
+	})
+	if !handled {
 		glog.Errorf(cdLogString(fmt.Sprintf("%v received unknown command (%T): %v", w.GetName(), command, command)))
+		workerStatusManager.RecordError(w.GetName(), "", errors.New(fmt.Sprintf("unknown command %T", command)))
 	} else {
 		glog.V(2).Infof(cdLogString(fmt.Sprintf("%v handled command %v", w.GetName(), command)))
 	}
+	if timedOut {
+		workerStatusManager.RecordError(w.GetName(), "", errors.New(fmt.Sprintf("command %v ran past its deadline of %v seconds", command.ShortString(), w.CommandTimeoutS)))
+	}
 	return false
 }
 
@@ -302,6 +372,7 @@ func (w *BaseWorker) Start(worker Worker, noWorkInterval int) {
 			return
 		} else {
 			workerStatusManager.SetWorkerStatus(w.GetName(), STATUS_INITIALIZED)
+			workerStatusManager.Heartbeat(w.GetName(), "")
 		}
 
 		// Process commands in blocking or non-blocking fashion, depending on how we were called.
@@ -337,7 +408,7 @@ func (w *BaseWorker) Start(worker Worker, noWorkInterval int) {
 				case <-time.After(time.Duration(waitTime) * time.Second):
 					// Call the no work to do handler if it was requested.
 					if noWorkInterval != 0 {
-						worker.NoWorkHandler()
+						w.withCommandContext(worker.NoWorkHandler)
 					}
 
 					// Requeue any deferred commands that have been accumulating.
@@ -348,6 +419,8 @@ func (w *BaseWorker) Start(worker Worker, noWorkInterval int) {
 				}
 			}
 
+			workerStatusManager.Heartbeat(w.GetName(), "")
+
 			// Give the go subdispatcher a chance to run something else
 			runtime.Gosched()
 		}
@@ -421,6 +494,12 @@ func (w *BaseWorker) AreAllSubworkersTerminated() bool {
 	return true
 }
 
+// Count a failed run of a subworker in the worker status. Subworkers report their own failures, the framework can't
+// tell them apart from runs that had nothing to do.
+func (w *BaseWorker) RecordSubworkerError(name string, err error) {
+	workerStatusManager.RecordError(w.GetName(), name, err)
+}
+
 func (w *BaseWorker) DispatchSubworker(name string, runSubWorker func() int, interval int) {
 	quit := w.AddSubworker(name)
 	nextWaitTime := interval
@@ -435,6 +514,7 @@ func (w *BaseWorker) DispatchSubworker(name string, runSubWorker func() int, int
 				return
 			case <-time.After(time.Duration(nextWaitTime) * time.Second):
 				returnedWait := runSubWorker()
+				workerStatusManager.Heartbeat(w.GetName(), name)
 				if returnedWait > 0 {
 					nextWaitTime = returnedWait
 				}
@@ -573,9 +653,15 @@ func (workers *MessageHandlerRegistry) ProcessEventMessages() {
 }
 
 var mdLogString = func(v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("MessageDispatcher", nil, v)
+	}
 	return fmt.Sprintf("MessageDispatcher: %v", v)
 }
 
 var cdLogString = func(v interface{}) string {
+	if cutil.StructuredLogging() {
+		return cutil.LogString("CommandDispatcher", nil, v)
+	}
 	return fmt.Sprintf("CommandDispatcher: %v", v)
 }
@@ -29,10 +29,15 @@ func resetWorkerStatusManager() {
 
 // status for a worker
 type WorkerStatus struct {
-	Name            string            `json:"name"`
-	Status          string            `json:"status"`
-	SubworkerStatus map[string]string `json:"subworker_status"`
-	StatusLock      sync.Mutex        `json:"-"` // The lock that protects modification from different threads at the same time
+	Name                string            `json:"name"`
+	Status              string            `json:"status"`
+	SubworkerStatus     map[string]string `json:"subworker_status"`
+	LastHeartbeat       int64             `json:"last_heartbeat,omitempty"`        // the last time the worker handled a command or ran its no work handler
+	ErrorCount          int               `json:"error_count"`                     // the number of commands the worker failed to handle
+	LastError           string            `json:"last_error,omitempty"`            // the error of the last failure, worker or subworker
+	SubworkerHeartbeat  map[string]int64  `json:"subworker_heartbeat,omitempty"`   // the last time each subworker ran
+	SubworkerErrorCount map[string]int    `json:"subworker_error_count,omitempty"` // the number of failed runs of each subworker
+	StatusLock          sync.Mutex        `json:"-"`                               // The lock that protects modification from different threads at the same time
 }
 
 func (w *WorkerStatus) SetWorkerStatus(status string) {
@@ -49,6 +54,67 @@ func (w *WorkerStatus) SetSubworkerStatus(name string, status string) {
 	w.SubworkerStatus[name] = status
 }
 
+func (w *WorkerStatus) heartbeat(subname string) {
+	w.StatusLock.Lock()
+	defer w.StatusLock.Unlock()
+
+	if subname == "" {
+		w.LastHeartbeat = time.Now().Unix()
+	} else {
+		if w.SubworkerHeartbeat == nil {
+			w.SubworkerHeartbeat = make(map[string]int64)
+		}
+		w.SubworkerHeartbeat[subname] = time.Now().Unix()
+	}
+}
+
+func (w *WorkerStatus) recordError(subname string, err error) {
+	w.StatusLock.Lock()
+	defer w.StatusLock.Unlock()
+
+	if subname == "" {
+		w.ErrorCount += 1
+		w.LastError = fmt.Sprintf("%v %v", time.Now().Format("2006-01-02 15:04:05"), err)
+	} else {
+		if w.SubworkerErrorCount == nil {
+			w.SubworkerErrorCount = make(map[string]int)
+		}
+		w.SubworkerErrorCount[subname] += 1
+		w.LastError = fmt.Sprintf("%v subworker %v: %v", time.Now().Format("2006-01-02 15:04:05"), subname, err)
+	}
+}
+
+// Returns a copy of the status that the worker can go on changing.
+func (w *WorkerStatus) copy() *WorkerStatus {
+	w.StatusLock.Lock()
+	defer w.StatusLock.Unlock()
+
+	c := &WorkerStatus{
+		Name:            w.Name,
+		Status:          w.Status,
+		SubworkerStatus: make(map[string]string, len(w.SubworkerStatus)),
+		LastHeartbeat:   w.LastHeartbeat,
+		ErrorCount:      w.ErrorCount,
+		LastError:       w.LastError,
+	}
+	for name, status := range w.SubworkerStatus {
+		c.SubworkerStatus[name] = status
+	}
+	if w.SubworkerHeartbeat != nil {
+		c.SubworkerHeartbeat = make(map[string]int64, len(w.SubworkerHeartbeat))
+		for name, hb := range w.SubworkerHeartbeat {
+			c.SubworkerHeartbeat[name] = hb
+		}
+	}
+	if w.SubworkerErrorCount != nil {
+		c.SubworkerErrorCount = make(map[string]int, len(w.SubworkerErrorCount))
+		for name, count := range w.SubworkerErrorCount {
+			c.SubworkerErrorCount[name] = count
+		}
+	}
+	return c
+}
+
 type WorkerStatusManager struct {
 	Workers     map[string]*WorkerStatus `json:"workers"`
 	StatusLog   []string                 `json:"worker_status_log"`
@@ -84,6 +150,14 @@ func (w *WorkerStatusManager) SetSubworkerStatus(name string, subname string, st
 	w.ManagerLock.Lock()
 	defer w.ManagerLock.Unlock()
 
+	w.getWorker(name).SetSubworkerStatus(subname, status)
+
+	time_s := fmt.Sprintf(time.Now().Format("2006-01-02 15:04:05"))
+	w.StatusLog = append(w.StatusLog, fmt.Sprintf("%v Worker %v: subworker %v %v.", time_s, name, subname, status))
+}
+
+// Returns the status of the worker, adding the worker if it is not known yet. The caller holds the manager lock.
+func (w *WorkerStatusManager) getWorker(name string) *WorkerStatus {
 	if _, ok := w.Workers[name]; !ok {
 		w.Workers[name] = &WorkerStatus{
 			Name:            name,
@@ -91,10 +165,36 @@ func (w *WorkerStatusManager) SetSubworkerStatus(name string, subname string, st
 			SubworkerStatus: make(map[string]string),
 		}
 	}
-	w.Workers[name].SetSubworkerStatus(subname, status)
+	return w.Workers[name]
+}
 
-	time_s := fmt.Sprintf(time.Now().Format("2006-01-02 15:04:05"))
-	w.StatusLog = append(w.StatusLog, fmt.Sprintf("%v Worker %v: subworker %v %v.", time_s, name, subname, status))
+// Record that the worker, or one of its subworkers when subname is not empty, is still running. Heartbeats are not
+// added to the status log, they would crowd out the status changes.
+func (w *WorkerStatusManager) Heartbeat(name string, subname string) {
+	w.ManagerLock.Lock()
+	defer w.ManagerLock.Unlock()
+
+	w.getWorker(name).heartbeat(subname)
+}
+
+// Count a failure of the worker, or of one of its subworkers when subname is not empty.
+func (w *WorkerStatusManager) RecordError(name string, subname string, err error) {
+	w.ManagerLock.Lock()
+	defer w.ManagerLock.Unlock()
+
+	w.getWorker(name).recordError(subname, err)
+}
+
+// Returns a copy of the status of all the workers, which can be serialized while the workers go on running.
+func (w *WorkerStatusManager) GetAllWorkerStatus() map[string]*WorkerStatus {
+	w.ManagerLock.Lock()
+	defer w.ManagerLock.Unlock()
+
+	workers := make(map[string]*WorkerStatus, len(w.Workers))
+	for name, ws := range w.Workers {
+		workers[name] = ws.copy()
+	}
+	return workers
 }
 
 // Get the status string for the given worker. It returns an empty string if the worker does not exist.
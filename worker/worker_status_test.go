@@ -3,6 +3,7 @@
 package worker
 
 import (
+	"errors"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -53,3 +54,32 @@ func Test_SubworkerStatus(t *testing.T) {
 	assert.Equal(t, STATUS_ADDED, workerStatusManager.GetSubworkerStatus("worker2", "sub2"), "The status for worker2 subworker sub2 should be "+STATUS_ADDED)
 	assert.Equal(t, STATUS_ADDED, workerStatusManager.GetSubworkerStatus("worker3", "sub1"), "The status for worker3 subworker sub2 should be "+STATUS_ADDED)
 }
+
+func Test_WorkerHeartbeatAndErrors(t *testing.T) {
+
+	// reset the workerStatusManager for testing
+	workerStatusManager = NewWorkerStatusManager()
+
+	workerStatusManager.SetWorkerStatus("worker1", STATUS_INITIALIZED)
+	workerStatusManager.Heartbeat("worker1", "")
+	workerStatusManager.Heartbeat("worker1", "sub1")
+	workerStatusManager.RecordError("worker1", "", errors.New("command failed"))
+	workerStatusManager.RecordError("worker1", "sub1", errors.New("run failed"))
+	workerStatusManager.RecordError("worker1", "sub1", errors.New("run failed again"))
+	workerStatusManager.Heartbeat("worker2", "")
+
+	assert.Equal(t, 1, len(workerStatusManager.StatusLog), "Heartbeats and errors should not be logged.")
+
+	all := workerStatusManager.GetAllWorkerStatus()
+	assert.Equal(t, 2, len(all), "There should be 2 workers.")
+	assert.NotEqual(t, int64(0), all["worker1"].LastHeartbeat, "worker1 should have a heartbeat")
+	assert.NotEqual(t, int64(0), all["worker1"].SubworkerHeartbeat["sub1"], "worker1 subworker sub1 should have a heartbeat")
+	assert.Equal(t, 1, all["worker1"].ErrorCount, "worker1 should have 1 error")
+	assert.Equal(t, 2, all["worker1"].SubworkerErrorCount["sub1"], "worker1 subworker sub1 should have 2 errors")
+	assert.Contains(t, all["worker1"].LastError, "subworker sub1: run failed again", "the last error should be the last subworker error")
+	assert.Equal(t, STATUS_NONE, all["worker2"].Status, "The status for worker2 should be "+STATUS_NONE)
+
+	// The copies don't change with the workers.
+	workerStatusManager.RecordError("worker1", "", errors.New("command failed again"))
+	assert.Equal(t, 1, all["worker1"].ErrorCount, "the copy of worker1 should still have 1 error")
+}
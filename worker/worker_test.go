@@ -3,6 +3,7 @@
 package worker
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"github.com/golang/glog"
@@ -300,6 +301,36 @@ func Test_One_worker_two_sub(t *testing.T) {
 }
 
 // This function monitors the test to prevent hung tests.
+func Test_CommandContext(t *testing.T) {
+
+	w := NewBaseWorker("contexttest", getBasicConfig(), nil)
+	if w.CommandContext() != w.Context() {
+		t.Errorf("outside of a command, the command context should be the worker context")
+	} else if _, ok := w.Context().Deadline(); ok {
+		t.Errorf("the worker context should not have a deadline")
+	}
+
+	var cmdCtx context.Context
+	w.withCommandContext(func() {
+		cmdCtx = w.CommandContext()
+		if deadline, ok := cmdCtx.Deadline(); !ok || deadline.After(time.Now().Add(DEFAULT_COMMAND_TIMEOUT_S*time.Second)) {
+			t.Errorf("the command context should have a deadline, was %v", deadline)
+		}
+
+		// Shutting down the worker stops the command.
+		w.cancelContext()
+		select {
+		case <-cmdCtx.Done():
+		case <-time.After(time.Second):
+			t.Errorf("the command context should be done when the worker shuts down")
+		}
+	})
+
+	if cmdCtx.Err() == nil || w.Context().Err() == nil {
+		t.Errorf("the contexts should be done")
+	}
+}
+
 func monitorTest(t *testing.T, state *bool, wait int) {
 	wc := 0
 	for {